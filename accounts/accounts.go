@@ -0,0 +1,49 @@
+// Package accounts maps login accounts to the simulated AWS account they
+// own. Every login account is one simulated account, identified by a
+// 12-digit account number that appears in ARNs and namespaced file paths.
+package accounts
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// NumberLength is the number of digits in an AWS account number.
+const NumberLength = 12
+
+// SimAccountNumber returns the simulated account number of login account
+// id. It mirrors the generated accounts.sim_account_number column, so no
+// query is needed to build an ARN.
+func SimAccountNumber(id int) string {
+	return fmt.Sprintf("%0*d", NumberLength, id)
+}
+
+// AccountID returns the login account a simulated account number belongs
+// to. Numbers that are not 12 digits, such as the numbers of organization
+// member accounts, belong to no login account.
+func AccountID(number string) (int, bool) {
+	if len(number) != NumberLength {
+		return 0, false
+	}
+	id, err := strconv.Atoi(number)
+	if err != nil || id <= 0 || SimAccountNumber(id) != number {
+		return 0, false
+	}
+	return id, true
+}
+
+// SameAccount reports whether two account numbers name the same account.
+// Policies written before account numbers were padded name accounts by
+// their bare id, so leading zeros are ignored.
+func SameAccount(a, b string) bool {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	return a != "" && a == b
+}
+
+// FilePath returns the path of a user file in its account's namespace,
+// such as "/000000000007/main.py".
+func FilePath(id int, filename string) string {
+	return path.Join("/", SimAccountNumber(id), filename)
+}
@@ -0,0 +1,49 @@
+package accounts
+
+import "testing"
+
+func TestSimAccountNumber(t *testing.T) {
+	if got := SimAccountNumber(7); got != "000000000007" {
+		t.Errorf("SimAccountNumber(7) = %q", got)
+	}
+	if got := FilePath(7, "main.py"); got != "/000000000007/main.py" {
+		t.Errorf("FilePath() = %q", got)
+	}
+}
+
+func TestAccountID(t *testing.T) {
+	tests := []struct {
+		number string
+		want   int
+		ok     bool
+	}{
+		{"000000000007", 7, true},
+		{"7", 0, false},
+		{"000000000000", 0, false},
+		{"-00000000007", 0, false},
+		{"12345678901a", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := AccountID(tt.number)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("AccountID(%q) = %d, %v", tt.number, got, ok)
+		}
+	}
+}
+
+func TestSameAccount(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"000000000007", "7", true},
+		{"000000000007", "000000000007", true},
+		{"000000000007", "000000000070", false},
+		{"0", "000000000000", false},
+	}
+	for _, tt := range tests {
+		if got := SameAccount(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameAccount(%q, %q) = %v", tt.a, tt.b, got)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"allanswebterminal/i18n"
+)
+
+// Code is a stable, machine-readable identifier for an API error. Front-end
+// code should branch on the code rather than on the message text.
+type Code string
+
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeInvalidJSON      Code = "invalid_json"
+	CodeValidation       Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeAccountSuspended Code = "account_suspended"
+	CodeNotFound         Code = "not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeConflict         Code = "conflict"
+	CodePayloadTooLarge  Code = "payload_too_large"
+	CodeRateLimited      Code = "rate_limited"
+	CodeInternal         Code = "internal_error"
+	CodeUnavailable      Code = "service_unavailable"
+
+	// CodeIdempotencyMismatch means an Idempotency-Key was reused for a
+	// different request.
+	CodeIdempotencyMismatch Code = "idempotency_key_mismatch"
+)
+
+// catalog maps every error code to the HTTP status it is served with.
+var catalog = map[Code]int{
+	CodeBadRequest:       http.StatusBadRequest,
+	CodeInvalidJSON:      http.StatusBadRequest,
+	CodeValidation:       http.StatusBadRequest,
+	CodeUnauthorized:     http.StatusUnauthorized,
+	CodeForbidden:        http.StatusForbidden,
+	CodeAccountSuspended: http.StatusForbidden,
+	CodeNotFound:         http.StatusNotFound,
+	CodeMethodNotAllowed: http.StatusMethodNotAllowed,
+	CodeConflict:         http.StatusConflict,
+	CodePayloadTooLarge:  http.StatusRequestEntityTooLarge,
+	CodeRateLimited:      http.StatusTooManyRequests,
+	CodeInternal:         http.StatusInternalServerError,
+	CodeUnavailable:      http.StatusServiceUnavailable,
+
+	CodeIdempotencyMismatch: http.StatusUnprocessableEntity,
+}
+
+// Error is the typed error returned by API handlers.
+type Error struct {
+	Code    Code        `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Envelope is the JSON body written for every API error.
+type Envelope struct {
+	Error *Error `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Status returns the HTTP status code for the error's code.
+func (e *Error) Status() int {
+	return Status(e.Code)
+}
+
+// WithDetails returns a copy of the error carrying extra structured details,
+// such as field-level validation failures.
+func (e *Error) WithDetails(details interface{}) *Error {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// Status returns the HTTP status code registered for code, defaulting to 500.
+func Status(code Code) int {
+	if status, ok := catalog[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func BadRequest(message string) *Error {
+	return New(CodeBadRequest, message)
+}
+
+func InvalidJSON() *Error {
+	return New(CodeInvalidJSON, "Invalid JSON")
+}
+
+func Validation(message string) *Error {
+	return New(CodeValidation, message)
+}
+
+func Unauthorized() *Error {
+	return New(CodeUnauthorized, "Unauthorized")
+}
+
+func Forbidden(message string) *Error {
+	return New(CodeForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(CodeNotFound, message)
+}
+
+func MethodNotAllowed() *Error {
+	return New(CodeMethodNotAllowed, "Method not allowed")
+}
+
+func Conflict(message string) *Error {
+	return New(CodeConflict, message)
+}
+
+func Internal(message string) *Error {
+	return New(CodeInternal, message)
+}
+
+func Unavailable(message string) *Error {
+	return New(CodeUnavailable, message)
+}
+
+// PayloadTooLarge reports a request body over limit bytes.
+func PayloadTooLarge(limit int64) *Error {
+	return New(CodePayloadTooLarge, "Request body too large").
+		WithDetails(map[string]int64{"limit_bytes": limit})
+}
+
+// DecodeError classifies a request body decoding failure: bodies cut off by
+// http.MaxBytesReader become 413s, anything else is invalid JSON.
+func DecodeError(err error) *Error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return PayloadTooLarge(maxBytesErr.Limit)
+	}
+	return InvalidJSON()
+}
+
+// Localize returns a copy of err with its message translated into the
+// locale announced on w (see i18n.Middleware).
+func Localize(w http.ResponseWriter, err *Error) *Error {
+	locale := i18n.ResponseLocale(w)
+	if locale == i18n.Default {
+		return err
+	}
+	localized := *err
+	localized.Message = i18n.T(locale, err.Message)
+	return &localized
+}
+
+// Write sends err as a JSON error envelope. Errors that are not *Error are
+// logged and reported as a generic internal error so internals never leak to
+// clients.
+func Write(w http.ResponseWriter, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		log.Printf("Unhandled API error: %v", err)
+		apiErr = Internal("Internal server error")
+	}
+
+	apiErr = Localize(w, apiErr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status())
+	json.NewEncoder(w).Encode(Envelope{Error: apiErr})
+}
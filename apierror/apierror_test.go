@@ -0,0 +1,96 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusMapping(t *testing.T) {
+	tests := []struct {
+		code Code
+		want int
+	}{
+		{CodeInvalidJSON, http.StatusBadRequest},
+		{CodeUnauthorized, http.StatusUnauthorized},
+		{CodeNotFound, http.StatusNotFound},
+		{CodePayloadTooLarge, http.StatusRequestEntityTooLarge},
+		{CodeUnavailable, http.StatusServiceUnavailable},
+		{Code("unknown_code"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.code), func(t *testing.T) {
+			if got := Status(tt.code); got != tt.want {
+				t.Errorf("Status(%q) = %d, want %d", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteAPIError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	details := map[string]string{"filename": "required"}
+
+	Write(rr, Validation("Filename required").WithDetails(details))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var body struct {
+		Error struct {
+			Code    string            `json:"code"`
+			Message string            `json:"message"`
+			Details map[string]string `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if body.Error.Code != "validation_failed" || body.Error.Message != "Filename required" {
+		t.Errorf("unexpected error body: %+v", body.Error)
+	}
+	if body.Error.Details["filename"] != "required" {
+		t.Errorf("details not preserved: %+v", body.Error.Details)
+	}
+}
+
+func TestWritePlainErrorIsInternal(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	Write(rr, errors.New("pq: connection refused"))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if body := rr.Body.String(); body == "" || strings.Contains(body, "connection refused") {
+		t.Errorf("internal details should not leak, got %q", body)
+	}
+}
+
+func TestWithDetailsDoesNotMutateOriginal(t *testing.T) {
+	original := NotFound("File not found")
+	_ = original.WithDetails("extra")
+
+	if original.Details != nil {
+		t.Error("WithDetails should not modify the receiver")
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	if got := DecodeError(errors.New("unexpected EOF")); got.Code != CodeInvalidJSON {
+		t.Errorf("DecodeError(syntax) code = %q, want %q", got.Code, CodeInvalidJSON)
+	}
+
+	tooLarge := &http.MaxBytesError{Limit: 1024}
+	if got := DecodeError(tooLarge); got.Code != CodePayloadTooLarge || got.Status() != http.StatusRequestEntityTooLarge {
+		t.Errorf("DecodeError(MaxBytesError) = %+v, want payload_too_large", got)
+	}
+}
@@ -0,0 +1,73 @@
+// Package cache is a small TTL cache for hot read paths, backed by process
+// memory or, when several instances must agree on invalidation, Redis.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Store holds encoded values with an expiry.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+var (
+	defaultStore Store = NewMemoryStore(10000)
+	defaultMu    sync.RWMutex
+)
+
+// SetDefault replaces the store used by the package-level helpers.
+func SetDefault(store Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = store
+}
+
+// Default returns the package-level store.
+func Default() Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
+
+// GetOrLoad returns the cached value for key, calling load and caching its
+// result for ttl on a miss. Cache failures are logged and fall through to
+// load so an unavailable backend never breaks the read path.
+func GetOrLoad[T any](ctx context.Context, key string, ttl time.Duration, load func() (T, error)) (T, error) {
+	store := Default()
+
+	if data, ok, err := store.Get(ctx, key); err != nil {
+		log.Printf("cache: get %s failed: %v", key, err)
+	} else if ok {
+		var value T
+		if err := json.Unmarshal(data, &value); err == nil {
+			return value, nil
+		}
+		log.Printf("cache: discarding undecodable entry %s", key)
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	if data, err := json.Marshal(value); err != nil {
+		log.Printf("cache: encode %s failed: %v", key, err)
+	} else if err := store.Set(ctx, key, data, ttl); err != nil {
+		log.Printf("cache: set %s failed: %v", key, err)
+	}
+	return value, nil
+}
+
+// Invalidate drops keys after the data behind them changes.
+func Invalidate(ctx context.Context, keys ...string) {
+	if err := Default().Delete(ctx, keys...); err != nil {
+		log.Printf("cache: invalidate %v failed: %v", keys, err)
+	}
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func useStore(t *testing.T, store Store) {
+	t.Helper()
+	saved := Default()
+	SetDefault(store)
+	t.Cleanup(func() { SetDefault(saved) })
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	store := NewMemoryStore(0)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	store.Set(ctx, "k", []byte("v"), time.Minute)
+	if value, ok, _ := store.Get(ctx, "k"); !ok || string(value) != "v" {
+		t.Fatalf("expected hit, got %q %v", value, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok, _ := store.Get(ctx, "k"); ok {
+		t.Error("expected entry to expire")
+	}
+	if store.Len() != 0 {
+		t.Error("expired entry should be removed on read")
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	store := NewMemoryStore(2)
+	ctx := context.Background()
+
+	store.Set(ctx, "a", []byte("1"), time.Minute)
+	store.Set(ctx, "b", []byte("2"), time.Minute)
+	store.Set(ctx, "c", []byte("3"), time.Minute)
+	if store.Len() != 2 {
+		t.Errorf("expected store to stay at capacity, has %d", store.Len())
+	}
+	if _, ok, _ := store.Get(ctx, "c"); !ok {
+		t.Error("newest entry should be kept")
+	}
+}
+
+func TestGetOrLoad(t *testing.T) {
+	useStore(t, NewMemoryStore(0))
+	ctx := context.Background()
+
+	loads := 0
+	load := func() ([]string, error) {
+		loads++
+		return []string{"go", "rust"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		value, err := GetOrLoad(ctx, "langs", time.Minute, load)
+		if err != nil || len(value) != 2 {
+			t.Fatalf("GetOrLoad = %v, %v", value, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected one load, got %d", loads)
+	}
+
+	Invalidate(ctx, "langs")
+	GetOrLoad(ctx, "langs", time.Minute, load)
+	if loads != 2 {
+		t.Errorf("expected reload after invalidation, got %d loads", loads)
+	}
+}
+
+func TestGetOrLoadDoesNotCacheErrors(t *testing.T) {
+	useStore(t, NewMemoryStore(0))
+	ctx := context.Background()
+
+	_, err := GetOrLoad(ctx, "k", time.Minute, func() (int, error) { return 0, errors.New("db down") })
+	if err == nil {
+		t.Fatal("expected load error")
+	}
+	value, err := GetOrLoad(ctx, "k", time.Minute, func() (int, error) { return 7, nil })
+	if err != nil || value != 7 {
+		t.Errorf("expected fresh load after error, got %v, %v", value, err)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL not set, skipping Redis integration test")
+	}
+
+	store, err := NewRedisStore(url)
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	key := "test:" + time.Now().Format(time.RFC3339Nano)
+	if err := store.Set(ctx, key, []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, ok, err := store.Get(ctx, key); err != nil || !ok || string(value) != "v" {
+		t.Fatalf("Get = %q, %v, %v", value, ok, err)
+	}
+	store.Delete(ctx, key)
+	if _, ok, _ := store.Get(ctx, key); ok {
+		t.Error("expected key to be deleted")
+	}
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryStore is a per-process Store. When full, expired entries are swept
+// and, failing that, an arbitrary entry is evicted.
+type MemoryStore struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time
+}
+
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]memoryEntry),
+		now:        time.Now,
+	}
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !m.now().Before(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (m *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.entries[key]; !exists && m.maxEntries > 0 && len(m.entries) >= m.maxEntries {
+		m.evict()
+	}
+	m.entries[key] = memoryEntry{value: value, expiresAt: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// Len returns the number of stored entries, including expired ones not yet
+// swept.
+func (m *MemoryStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+func (m *MemoryStore) evict() {
+	now := m.now()
+	for key, entry := range m.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(m.entries, key)
+		}
+	}
+	if len(m.entries) < m.maxEntries {
+		return
+	}
+	for key := range m.entries {
+		delete(m.entries, key)
+		return
+	}
+}
@@ -0,0 +1,204 @@
+// Package cardcontent checks and cleans flashcard questions and answers
+// before they are stored. Card text is plain text with TeX math between
+// KaTeX auto-render delimiters: $...$ and \(...\) inline, $$...$$ and
+// \[...\] on their own line. Markup outside math is stripped, and math is
+// checked so that KaTeX renders it without trusting it.
+package cardcontent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"allanswebterminal/sanitize"
+)
+
+// MaxMathChars caps one formula; KaTeX layout time grows with its size.
+const MaxMathChars = 1000
+
+// Segment is a run of plain text or one formula, without its delimiters.
+type Segment struct {
+	Math    bool
+	Display bool
+	Text    string
+	// open and close are the delimiters the formula was written with.
+	open, close string
+}
+
+// forbiddenCommands would let a formula link out, load resources, set HTML
+// attributes or define macros that expand without bound. KaTeX refuses most
+// of them without its trust option, but stored content must not depend on
+// how a client configures its renderer.
+var forbiddenCommands = map[string]bool{
+	"href": true, "url": true, "includegraphics": true,
+	"htmlClass": true, "htmlId": true, "htmlStyle": true, "htmlData": true,
+	"def": true, "gdef": true, "edef": true, "xdef": true, "let": true, "futurelet": true,
+	"newcommand": true, "renewcommand": true, "providecommand": true,
+}
+
+var commandPattern = regexp.MustCompile(`\\([A-Za-z]+)`)
+
+// markupPattern matches HTML tags and comments in a formula. A '<' not
+// followed by a letter, '/' or '!' is a relation, as in "a < b".
+var markupPattern = regexp.MustCompile(`(?s)<!--.*?-->|</?[A-Za-z][^<>]*>`)
+
+// Parse splits text into plain text and math segments. An inline $ only
+// opens math when followed by a non-space and closes when preceded by one
+// and not followed by a digit, so "$5 or $10" stays text; \$ is a literal
+// dollar. Unclosed $$, \( and \[ are errors.
+func Parse(text string) ([]Segment, error) {
+	var segments []Segment
+	var plain strings.Builder
+	flush := func() {
+		if plain.Len() > 0 {
+			segments = append(segments, Segment{Text: plain.String()})
+			plain.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		var open, close string
+		display := false
+		switch {
+		case strings.HasPrefix(text[i:], `\$`):
+			plain.WriteString(`\$`)
+			i += 2
+			continue
+		case strings.HasPrefix(text[i:], "$$"):
+			open, close, display = "$$", "$$", true
+		case strings.HasPrefix(text[i:], `\[`):
+			open, close, display = `\[`, `\]`, true
+		case strings.HasPrefix(text[i:], `\(`):
+			open, close = `\(`, `\)`
+		case text[i] == '$':
+			end := inlineDollarEnd(text, i)
+			if end < 0 {
+				plain.WriteByte('$')
+				i++
+				continue
+			}
+			flush()
+			segments = append(segments, Segment{Math: true, Text: text[i+1 : end], open: "$", close: "$"})
+			i = end + 1
+			continue
+		default:
+			plain.WriteByte(text[i])
+			i++
+			continue
+		}
+
+		end := closingDelimiter(text, i+len(open), close)
+		if end < 0 {
+			return nil, fmt.Errorf("math opened with %s is not closed with %s", open, close)
+		}
+		flush()
+		segments = append(segments, Segment{Math: true, Display: display, Text: text[i+len(open) : end], open: open, close: close})
+		i = end + len(close)
+	}
+	flush()
+	return segments, nil
+}
+
+// inlineDollarEnd returns the index of the $ closing inline math opened at
+// text[start], or -1 if that $ does not open math.
+func inlineDollarEnd(text string, start int) int {
+	if start+1 >= len(text) || isSpace(text[start+1]) {
+		return -1
+	}
+	for i := start + 1; i < len(text); i++ {
+		switch {
+		case text[i] == '\\':
+			i++ // skip the escaped character, including \$
+		case text[i] == '$':
+			if isSpace(text[i-1]) || i+1 < len(text) && text[i+1] >= '0' && text[i+1] <= '9' {
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// closingDelimiter finds close in text from index from, skipping escaped
+// characters.
+func closingDelimiter(text string, from int, close string) int {
+	for i := from; i < len(text); i++ {
+		if strings.HasPrefix(text[i:], close) {
+			return i
+		}
+		if text[i] == '\\' {
+			i++
+		}
+	}
+	return -1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// CheckMath reports why a formula is unsafe or malformed, or nil.
+func CheckMath(tex string) error {
+	if len(tex) > MaxMathChars {
+		return fmt.Errorf("formulas must be at most %d characters", MaxMathChars)
+	}
+	if strings.TrimSpace(tex) == "" {
+		return fmt.Errorf("empty formula")
+	}
+	if markupPattern.MatchString(tex) {
+		return fmt.Errorf("formulas must not contain HTML tags")
+	}
+	for _, m := range commandPattern.FindAllStringSubmatch(tex, -1) {
+		if forbiddenCommands[m[1]] {
+			return fmt.Errorf(`\%s is not allowed in formulas`, m[1])
+		}
+	}
+	depth := 0
+	for i := 0; i < len(tex); i++ {
+		switch tex[i] {
+		case '\\':
+			i++ // \{ and \} are literal braces
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("unbalanced braces in formula")
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("unbalanced braces in formula")
+	}
+	return nil
+}
+
+// Clean returns text ready to store: control characters other than tab and
+// newline removed, markup stripped outside math by sanitize.Text, surrounding space trimmed.
+// It fails when a formula is unclosed or unsafe.
+func Clean(text string) (string, error) {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, text)
+
+	segments, err := Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, s := range segments {
+		if !s.Math {
+			b.WriteString(sanitize.Text(s.Text))
+			continue
+		}
+		if err := CheckMath(s.Text); err != nil {
+			return "", err
+		}
+		b.WriteString(s.open + s.Text + s.close)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
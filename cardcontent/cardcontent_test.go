@@ -0,0 +1,71 @@
+package cardcontent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	segments, err := Parse(`Solve $x^2 = 4$ for \(x\):$$\frac{a}{b}$$ costs $5 or $10.`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Segment{
+		{Text: "Solve "},
+		{Math: true, Text: "x^2 = 4"},
+		{Text: " for "},
+		{Math: true, Text: "x"},
+		{Text: ":"},
+		{Math: true, Display: true, Text: `\frac{a}{b}`},
+		{Text: " costs $5 or $10."},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("segments = %+v", segments)
+	}
+	for i, s := range segments {
+		if s.Math != want[i].Math || s.Display != want[i].Display || s.Text != want[i].Text {
+			t.Errorf("segment %d = %+v, want %+v", i, s, want[i])
+		}
+	}
+
+	if _, err := Parse(`$$x`); err == nil {
+		t.Error("unclosed $$ was accepted")
+	}
+	if segments, _ := Parse(`price \$5$`); len(segments) != 1 || segments[0].Math {
+		t.Errorf(`\$ opened math: %+v`, segments)
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  string
+	}{
+		{"  What is $\\sqrt{2}$?\n", "What is $\\sqrt{2}$?", ""},
+		{"a < b and c > d", "a < b and c > d", ""},
+		{"<b>bold</b> text", "bold text", ""},
+		{"<script>alert(1)</script>", "", ""},
+		{"<<b>script>alert(1)<</b>/script>", "", ""},
+		{"<img src=x onerror=alert(1)>", "", ""},
+		{"<!-- hidden -->shown", "shown", ""},
+		{"tab\tand\x00nul", "tab\tandnul", ""},
+		{`$\href{javascript:alert(1)}{x}$`, "", `\href is not allowed`},
+		{`\(\def\a{\a\a}\a\)`, "", `\def is not allowed`},
+		{`$x<img src=x onerror=alert(1)>$`, "", "HTML tags"},
+		{`$\frac{1}{2$`, "", "unbalanced braces"},
+		{`\[x`, "", "not closed"},
+		{"$" + strings.Repeat("x", MaxMathChars+1) + "$", "", "at most"},
+	}
+	for _, tt := range tests {
+		got, err := Clean(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Clean(%q) err = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Clean(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
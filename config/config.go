@@ -0,0 +1,78 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String returns the environment variable named by key, or def when unset.
+func String(key, def string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// Int returns the environment variable named by key parsed as an int, or def
+// when unset or invalid.
+func Int(key string, def int) int {
+	value := String(key, "")
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s: %q, using default %d", key, value, def)
+		return def
+	}
+	return n
+}
+
+// Float returns the environment variable named by key parsed as a float64,
+// or def when unset or invalid.
+func Float(key string, def float64) float64 {
+	value := String(key, "")
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid number for %s: %q, using default %g", key, value, def)
+		return def
+	}
+	return f
+}
+
+// Bool returns the environment variable named by key parsed as a bool, or def
+// when unset or invalid.
+func Bool(key string, def bool) bool {
+	value := String(key, "")
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s: %q, using default %t", key, value, def)
+		return def
+	}
+	return b
+}
+
+// Duration returns the environment variable named by key parsed with
+// time.ParseDuration, or def when unset or invalid.
+func Duration(key string, def time.Duration) time.Duration {
+	value := String(key, "")
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s: %q, using default %s", key, value, def)
+		return def
+	}
+	return d
+}
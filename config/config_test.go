@@ -0,0 +1,62 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestString(t *testing.T) {
+	t.Setenv("CONFIG_TEST_STRING", "  value  ")
+	if got := String("CONFIG_TEST_STRING", "default"); got != "value" {
+		t.Errorf("String() = %q, want %q", got, "value")
+	}
+	if got := String("CONFIG_TEST_MISSING", "default"); got != "default" {
+		t.Errorf("String() = %q, want %q", got, "default")
+	}
+}
+
+func TestInt(t *testing.T) {
+	t.Setenv("CONFIG_TEST_INT", "42")
+	t.Setenv("CONFIG_TEST_BAD_INT", "forty-two")
+
+	if got := Int("CONFIG_TEST_INT", 1); got != 42 {
+		t.Errorf("Int() = %d, want 42", got)
+	}
+	if got := Int("CONFIG_TEST_BAD_INT", 1); got != 1 {
+		t.Errorf("Int() with invalid value = %d, want default 1", got)
+	}
+}
+
+func TestFloat(t *testing.T) {
+	t.Setenv("CONFIG_TEST_FLOAT", "0.25")
+	t.Setenv("CONFIG_TEST_BAD_FLOAT", "quarter")
+
+	if got := Float("CONFIG_TEST_FLOAT", 1); got != 0.25 {
+		t.Errorf("Float() = %g, want 0.25", got)
+	}
+	if got := Float("CONFIG_TEST_BAD_FLOAT", 1); got != 1 {
+		t.Errorf("Float() with invalid value = %g, want default 1", got)
+	}
+}
+
+func TestBool(t *testing.T) {
+	t.Setenv("CONFIG_TEST_BOOL", "true")
+	if !Bool("CONFIG_TEST_BOOL", false) {
+		t.Error("Bool() = false, want true")
+	}
+	if !Bool("CONFIG_TEST_MISSING", true) {
+		t.Error("Bool() should fall back to default when unset")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	t.Setenv("CONFIG_TEST_DURATION", "3s")
+	t.Setenv("CONFIG_TEST_BAD_DURATION", "soon")
+
+	if got := Duration("CONFIG_TEST_DURATION", time.Second); got != 3*time.Second {
+		t.Errorf("Duration() = %v, want 3s", got)
+	}
+	if got := Duration("CONFIG_TEST_BAD_DURATION", time.Second); got != time.Second {
+		t.Errorf("Duration() with invalid value = %v, want default 1s", got)
+	}
+}
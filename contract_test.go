@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/operations"
+	"allanswebterminal/handlers/sdk"
+	"allanswebterminal/middleware"
+	"allanswebterminal/scheduler"
+	"allanswebterminal/securecookie"
+	"allanswebterminal/ws"
+)
+
+// TestAPIContract sends every operation in the OpenAPI document to the
+// router, built from fixtures, as a signed-in user. Each response must be
+// the documented success with its documented body, or the JSON error
+// envelope; anything else is drift between the document and the handlers.
+//
+// With TEST_DATABASE_URL the handlers run against a migrated database, so
+// operations work on real rows. Without it they run against an empty
+// database, which still reaches the success path of listings and most
+// writes.
+func TestAPIContract(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux, http.NotFoundHandler(), scheduler.New(nil), ws.NewHub(ws.Options{}), nil)
+	handler := middleware.APIRouteErrors(mux)
+	userID := contractDatabase(t)
+
+	doc := sdk.Document()
+	for _, op := range doc.Operations() {
+		t.Run(op.OperationID, func(t *testing.T) {
+			req, err := doc.Request(op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.AddCookie(securecookie.Cookie("user_id", userID))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if err := doc.CheckResponse(op, rec.Code, rec.Header(), rec.Body.Bytes()); err != nil {
+				t.Errorf("%s %s: %v", req.Method, req.URL, err)
+			}
+			waitForOperation(t, rec.Body.Bytes())
+		})
+	}
+}
+
+// waitForOperation waits for the background operation a 202 response
+// started, such as a deck import, to finish, so it doesn't use db.DB after
+// the test has restored it.
+func waitForOperation(t *testing.T, body []byte) {
+	t.Helper()
+	var started struct {
+		OperationID string `json:"operation_id"`
+	}
+	if json.Unmarshal(body, &started) != nil || started.OperationID == "" {
+		return
+	}
+	op, ok := operations.Get(started.OperationID)
+	if !ok {
+		return
+	}
+	for deadline := time.Now().Add(10 * time.Second); !op.Done(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("operation %s did not finish", op.ID)
+		}
+	}
+}
+
+// contractDatabase points db.DB at the test database and returns the ID of
+// the user the operations sign in as.
+func contractDatabase(t *testing.T) string {
+	t.Helper()
+	original := db.DB
+	t.Cleanup(func() { db.DB = original })
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		conn := sql.OpenDB(emptyConnector{})
+		t.Cleanup(func() { conn.Close() })
+		db.DB = conn
+		return emptyDBUserID
+	}
+
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	db.DB = conn
+	if err := db.RunMigrations(); err != nil {
+		t.Fatalf("migrating the test database: %v", err)
+	}
+	var id int
+	username := fmt.Sprintf("contract_%d", time.Now().UnixNano())
+	err = conn.QueryRow("INSERT INTO accounts (username, password) VALUES ($1, 'x') RETURNING id", username).Scan(&id)
+	if err != nil {
+		t.Fatalf("creating the test user: %v", err)
+	}
+	t.Cleanup(func() { conn.Exec("DELETE FROM accounts WHERE id = $1", id) })
+	return strconv.Itoa(id)
+}
+
+// emptyDBUserID is the one account in the empty database.
+const emptyDBUserID = "7"
+
+// emptyConnector opens a database with one account, emptyDBUserID, and
+// nothing else: queries return no rows and statements succeed, affecting
+// one row. Handlers reach their success paths with empty results.
+type emptyConnector struct{}
+
+func (emptyConnector) Connect(context.Context) (driver.Conn, error) { return emptyConn{}, nil }
+func (emptyConnector) Driver() driver.Driver                        { return nil }
+
+type emptyConn struct{}
+
+func (emptyConn) Prepare(query string) (driver.Stmt, error) { return emptyStmt{query}, nil }
+func (emptyConn) Close() error                              { return nil }
+func (emptyConn) Begin() (driver.Tx, error)                 { return emptyTx{}, nil }
+
+type emptyTx struct{}
+
+func (emptyTx) Commit() error   { return nil }
+func (emptyTx) Rollback() error { return nil }
+
+type emptyStmt struct{ query string }
+
+func (emptyStmt) Close() error  { return nil }
+func (emptyStmt) NumInput() int { return -1 }
+
+func (emptyStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+
+func (s emptyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if strings.HasPrefix(s.query, "SELECT id, username, role FROM accounts WHERE id") {
+		return &emptyRows{columns: []string{"id", "username", "role"}, rows: [][]driver.Value{{int64(7), "contract", "user"}}}, nil
+	}
+	return &emptyRows{}, nil
+}
+
+type emptyRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *emptyRows) Columns() []string { return r.columns }
+func (r *emptyRows) Close() error      { return nil }
+
+func (r *emptyRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
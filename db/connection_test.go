@@ -18,4 +18,18 @@ func TestConnect(t *testing.T) {
 	if err == nil {
 		t.Error("Expected connection to fail with nonexistent host, but it succeeded")
 	}
-}
\ No newline at end of file
+}
+func TestCloseWithoutConnection(t *testing.T) {
+	originalDB := DB
+	defer func() {
+		DB = originalDB
+	}()
+
+	DB = nil
+	if Available() {
+		t.Error("Expected Available to be false without a connection")
+	}
+	if err := Close(); err != nil {
+		t.Errorf("Expected Close to be a no-op without a connection, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"log"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"allanswebterminal/metrics"
+)
+
+// SlowQueryThreshold is how long a query may take before it is logged; zero
+// logs none.
+var SlowQueryThreshold = 200 * time.Millisecond
+
+// queryDuration times every query and statement, labelled with the function
+// that ran it and the kind of statement.
+var queryDuration = metrics.NewHistogram("db_query_duration_seconds",
+	"Time taken by database queries, by calling function and statement.",
+	metrics.DefaultBuckets, "caller", "op")
+
+// timedConnector opens connections that time their queries.
+type timedConnector struct {
+	driver.Connector
+}
+
+func (c timedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if full, ok := conn.(fullConn); ok {
+		return timedConn{full}, nil
+	}
+	return conn, nil
+}
+
+// fullConn is what database/sql uses of a lib/pq connection; a wrapper has
+// to offer all of it or database/sql falls back to slower paths.
+type fullConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.QueryerContext
+	driver.ExecerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+// timedConn times the queries and statements run on a connection. Rows are
+// timed until the first of them arrives, not until they are read.
+type timedConn struct {
+	fullConn
+}
+
+func (c timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	started := time.Now()
+	rows, err := c.fullConn.QueryContext(ctx, query, args)
+	observeQuery(query, len(args), time.Since(started), err)
+	return rows, err
+}
+
+func (c timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	started := time.Now()
+	result, err := c.fullConn.ExecContext(ctx, query, args)
+	observeQuery(query, len(args), time.Since(started), err)
+	return result, err
+}
+
+func observeQuery(query string, args int, took time.Duration, err error) {
+	caller := queryCaller()
+	queryDuration.Observe(took.Seconds(), caller, statementKind(query))
+	if SlowQueryThreshold > 0 && took >= SlowQueryThreshold {
+		status := ""
+		if err != nil {
+			status = " (failed)"
+		}
+		log.Printf("Slow query%s took %s in %s: %s [%d args redacted]",
+			status, took.Round(time.Millisecond), caller, redactQuery(query), args)
+	}
+}
+
+// queryCaller names the first function up the stack outside database/sql
+// and this file, such as "flashcards.ListCourses".
+func queryCaller() string {
+	pcs := make([]uintptr, 24)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		fn := frame.Function
+		if !strings.HasPrefix(fn, "database/sql.") && !strings.HasPrefix(fn, "allanswebterminal/db.timedConn") &&
+			!strings.HasPrefix(fn, "runtime.") && fn != "" {
+			if i := strings.LastIndex(fn, "/"); i >= 0 {
+				fn = fn[i+1:]
+			}
+			return fn
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// statementKind is the statement's leading keyword, lowercased, or
+// "other" for rarer ones, which keeps the op label to a few values.
+func statementKind(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch kind := strings.ToLower(fields[0]); kind {
+	case "select", "insert", "update", "delete", "with":
+		return kind
+	default:
+		return "other"
+	}
+}
+
+var (
+	stringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	whitespace    = regexp.MustCompile(`\s+`)
+)
+
+// maxLoggedQuery bounds how much of a slow query is logged.
+const maxLoggedQuery = 500
+
+// redactQuery prepares a query for the log: string literals are replaced
+// with '?' and whitespace is collapsed. Parameters are never logged, since
+// they carry user content.
+func redactQuery(query string) string {
+	query = stringLiteral.ReplaceAllString(query, "'?'")
+	query = strings.TrimSpace(whitespace.ReplaceAllString(query, " "))
+	if len(query) > maxLoggedQuery {
+		query = query[:maxLoggedQuery] + "..."
+	}
+	return query
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowConn answers every statement after a delay.
+type slowConn struct {
+	fullConn
+	delay time.Duration
+}
+
+func (c slowConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	time.Sleep(c.delay)
+	return driver.RowsAffected(1), nil
+}
+
+func (c slowConn) ResetSession(ctx context.Context) error { return nil }
+func (c slowConn) IsValid() bool                          { return true }
+func (c slowConn) Close() error                           { return nil }
+
+type slowConnector struct{ conn slowConn }
+
+func (c slowConnector) Connect(context.Context) (driver.Conn, error) { return c.conn, nil }
+func (c slowConnector) Driver() driver.Driver                        { return nil }
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	original := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(original) })
+	return &buf
+}
+
+func writeQueryMetrics() string {
+	var b strings.Builder
+	queryDuration.Write(&b)
+	return b.String()
+}
+
+// queryCount returns how many op statements from caller were timed. The
+// histogram is global, so tests compare counts rather than assume zero.
+func queryCount(caller, op string) int {
+	prefix := fmt.Sprintf(`db_query_duration_seconds_count{caller=%q,op=%q} `, caller, op)
+	for _, line := range strings.Split(writeQueryMetrics(), "\n") {
+		if count, ok := strings.CutPrefix(line, prefix); ok {
+			n, _ := strconv.Atoi(count)
+			return n
+		}
+	}
+	return 0
+}
+
+func TestTimedQueries(t *testing.T) {
+	defer func(d time.Duration) { SlowQueryThreshold = d }(SlowQueryThreshold)
+	SlowQueryThreshold = 5 * time.Millisecond
+	logged := captureLog(t)
+	updates := queryCount("db.TestTimedQueries", "update")
+	deletes := queryCount("db.TestTimedQueries", "delete")
+
+	db := sql.OpenDB(timedConnector{slowConnector{slowConn{delay: 10 * time.Millisecond}}})
+	defer db.Close()
+	if _, err := db.Exec("UPDATE accounts SET password = 'hunter2' WHERE email = $1", "me@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	line := logged.String()
+	if !strings.Contains(line, "in db.TestTimedQueries: UPDATE accounts SET password = '?' WHERE email = $1 [1 args redacted]") {
+		t.Errorf("slow query log = %q", line)
+	}
+	if strings.Contains(line, "hunter2") || strings.Contains(line, "me@example.com") {
+		t.Errorf("slow query log leaks a value: %q", line)
+	}
+	if got := queryCount("db.TestTimedQueries", "update"); got != updates+1 {
+		t.Errorf("update count = %d, want %d:\n%s", got, updates+1, writeQueryMetrics())
+	}
+
+	// Fast queries are timed but not logged.
+	logged.Reset()
+	SlowQueryThreshold = time.Second
+	if _, err := db.Exec("DELETE FROM sessions"); err != nil {
+		t.Fatal(err)
+	}
+	if logged.Len() != 0 {
+		t.Errorf("fast query logged: %q", logged.String())
+	}
+	if got := queryCount("db.TestTimedQueries", "delete"); got != deletes+1 {
+		t.Errorf("delete count = %d, want %d:\n%s", got, deletes+1, writeQueryMetrics())
+	}
+}
+
+func TestStatementKind(t *testing.T) {
+	for query, want := range map[string]string{
+		"\n\t\tSELECT 1":            "select",
+		"with recent AS (SELECT 1)": "with",
+		"CREATE TABLE x (id INT)":   "other",
+		"":                          "other",
+	} {
+		if got := statementKind(query); got != want {
+			t.Errorf("statementKind(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
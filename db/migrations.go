@@ -1,6 +1,8 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 )
@@ -246,6 +248,1272 @@ var migrations = []Migration{
 		`,
 		Down: `DROP TABLE IF EXISTS iam_policies;`,
 	},
+	{
+		Version: 14,
+		Name:    "create_game_sessions_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS game_sessions (
+				session_id VARCHAR(128) PRIMARY KEY,
+				data JSONB NOT NULL,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS game_sessions;`,
+	},
+	{
+		Version: 15,
+		Name:    "create_scheduler_jobs_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS scheduler_jobs (
+				name VARCHAR(100) PRIMARY KEY,
+				locked_by VARCHAR(255),
+				locked_until TIMESTAMPTZ,
+				last_run_at TIMESTAMPTZ,
+				last_duration_ms BIGINT,
+				last_error TEXT
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS scheduler_jobs;`,
+	},
+	{
+		Version: 16,
+		Name:    "create_iam_credential_reports_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS iam_credential_reports (
+				account_id INTEGER PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+				content TEXT NOT NULL,
+				generated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS iam_credential_reports;`,
+	},
+	{
+		Version: 17,
+		Name:    "add_locale_to_accounts",
+		Up: `
+			ALTER TABLE accounts
+			ADD COLUMN IF NOT EXISTS locale VARCHAR(10);
+		`,
+		Down: `
+			ALTER TABLE accounts
+			DROP COLUMN IF EXISTS locale;
+		`,
+	},
+	{
+		Version: 18,
+		Name:    "create_games_played_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS games_played (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+				course_id INTEGER REFERENCES courses(id) ON DELETE SET NULL,
+				total_questions INTEGER NOT NULL,
+				correct_answers INTEGER NOT NULL,
+				completed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_games_played_completed_at ON games_played (completed_at);
+		`,
+		Down: `DROP TABLE IF EXISTS games_played;`,
+	},
+	{
+		Version: 19,
+		Name:    "create_account_daily_activity_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS account_daily_activity (
+				account_id INTEGER REFERENCES accounts(id) ON DELETE CASCADE,
+				day DATE NOT NULL,
+				PRIMARY KEY (account_id, day)
+			);
+			CREATE INDEX IF NOT EXISTS idx_account_daily_activity_day ON account_daily_activity (day);
+		`,
+		Down: `DROP TABLE IF EXISTS account_daily_activity;`,
+	},
+	{
+		Version: 20,
+		Name:    "create_notifications_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS notifications (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				kind VARCHAR(50) NOT NULL,
+				title VARCHAR(255) NOT NULL,
+				body TEXT,
+				link VARCHAR(500),
+				read_at TIMESTAMP,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_notifications_account ON notifications (account_id, id DESC);
+		`,
+		Down: `DROP TABLE IF EXISTS notifications;`,
+	},
+	{
+		Version: 21,
+		Name:    "create_ujs_runs_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS ujs_runs (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				source TEXT NOT NULL,
+				runtime VARCHAR(20) NOT NULL,
+				iterations INTEGER NOT NULL,
+				median_ns BIGINT NOT NULL,
+				mean_ns BIGINT NOT NULL,
+				steps INTEGER NOT NULL,
+				result JSONB NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_ujs_runs_account ON ujs_runs (account_id, id DESC);
+		`,
+		Down: `DROP TABLE IF EXISTS ujs_runs;`,
+	},
+	{
+		Version: 22,
+		Name:    "create_ujs_snippets_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS ujs_snippets (
+				token VARCHAR(32) PRIMARY KEY,
+				file_id INTEGER NOT NULL UNIQUE REFERENCES user_files(id) ON DELETE CASCADE,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS ujs_snippets;`,
+	},
+	{
+		Version: 23,
+		Name:    "create_deck_gallery_tables",
+		Up: `
+			CREATE TABLE IF NOT EXISTS deck_listings (
+				course_id INTEGER PRIMARY KEY REFERENCES courses(id) ON DELETE CASCADE,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				category VARCHAR(30) NOT NULL,
+				status VARCHAR(20) NOT NULL DEFAULT 'published',
+				moderation_note TEXT,
+				downloads INTEGER NOT NULL DEFAULT 0,
+				published_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_deck_listings_category ON deck_listings (status, category);
+			CREATE TABLE IF NOT EXISTS deck_stars (
+				course_id INTEGER NOT NULL REFERENCES deck_listings(course_id) ON DELETE CASCADE,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (course_id, account_id)
+			);
+			CREATE TABLE IF NOT EXISTS deck_ratings (
+				course_id INTEGER NOT NULL REFERENCES deck_listings(course_id) ON DELETE CASCADE,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (course_id, account_id)
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS deck_ratings;
+			DROP TABLE IF EXISTS deck_stars;
+			DROP TABLE IF EXISTS deck_listings;
+		`,
+	},
+	{
+		Version: 24,
+		Name:    "create_reminders_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS reminders (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				course_id INTEGER NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+				email VARCHAR(254) NOT NULL,
+				local_time VARCHAR(5) NOT NULL,
+				timezone VARCHAR(64) NOT NULL,
+				next_run_at TIMESTAMPTZ NOT NULL,
+				last_sent_at TIMESTAMPTZ,
+				unsubscribe_token VARCHAR(32) NOT NULL UNIQUE,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (account_id, course_id)
+			);
+			CREATE INDEX IF NOT EXISTS idx_reminders_due ON reminders (next_run_at);
+		`,
+		Down: `DROP TABLE IF EXISTS reminders;`,
+	},
+	{
+		Version: 25,
+		Name:    "create_flashcard_stats_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS flashcard_stats (
+				flashcard_id INTEGER PRIMARY KEY REFERENCES flashcards(id) ON DELETE CASCADE,
+				attempts INTEGER NOT NULL,
+				correct INTEGER NOT NULL,
+				accuracy DOUBLE PRECISION NOT NULL,
+				median_time_seconds DOUBLE PRECISION NOT NULL,
+				difficulty DOUBLE PRECISION NOT NULL,
+				refreshed_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_account_score_flashcard ON account_score (flashcard_id);
+		`,
+		Down: `DROP TABLE IF EXISTS flashcard_stats;`,
+	},
+	{
+		Version: 26,
+		Name:    "create_course_scoring_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS course_scoring (
+				course_id INTEGER PRIMARY KEY REFERENCES courses(id) ON DELETE CASCADE,
+				rules JSONB NOT NULL,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS course_scoring;`,
+	},
+	{
+		Version: 27,
+		Name:    "create_iam_password_policies_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS iam_password_policies (
+				account_id INTEGER PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+				minimum_password_length INTEGER NOT NULL,
+				require_symbols BOOLEAN NOT NULL DEFAULT FALSE,
+				require_numbers BOOLEAN NOT NULL DEFAULT FALSE,
+				require_uppercase BOOLEAN NOT NULL DEFAULT FALSE,
+				require_lowercase BOOLEAN NOT NULL DEFAULT FALSE,
+				max_password_age INTEGER NOT NULL DEFAULT 0,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS iam_password_policies;`,
+	},
+	{
+		Version: 28,
+		Name:    "create_iam_login_profiles_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS iam_login_profiles (
+				user_id INTEGER PRIMARY KEY REFERENCES iam_users(id) ON DELETE CASCADE,
+				password_hash TEXT NOT NULL,
+				password_reset_required BOOLEAN NOT NULL DEFAULT FALSE,
+				created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				password_changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS iam_login_profiles;`,
+	},
+	{
+		Version: 29,
+		Name:    "create_organizations_tables",
+		Up: `
+			CREATE TABLE IF NOT EXISTS organizations (
+				management_account_id INTEGER PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+				org_id VARCHAR(34) UNIQUE NOT NULL,
+				root_id VARCHAR(34) NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE TABLE IF NOT EXISTS organization_accounts (
+				account_number VARCHAR(12) PRIMARY KEY,
+				management_account_id INTEGER NOT NULL REFERENCES organizations(management_account_id) ON DELETE CASCADE,
+				name VARCHAR(50) NOT NULL,
+				email VARCHAR(64) NOT NULL,
+				status VARCHAR(20) NOT NULL DEFAULT 'ACTIVE',
+				joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (management_account_id, name)
+			);
+			CREATE TABLE IF NOT EXISTS organization_policies (
+				policy_id VARCHAR(34) PRIMARY KEY,
+				management_account_id INTEGER NOT NULL REFERENCES organizations(management_account_id) ON DELETE CASCADE,
+				name VARCHAR(128) NOT NULL,
+				description TEXT,
+				content JSONB NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (management_account_id, name)
+			);
+			CREATE TABLE IF NOT EXISTS organization_policy_targets (
+				management_account_id INTEGER NOT NULL REFERENCES organizations(management_account_id) ON DELETE CASCADE,
+				policy_id VARCHAR(34) NOT NULL,
+				target_id VARCHAR(34) NOT NULL,
+				PRIMARY KEY (management_account_id, policy_id, target_id)
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS organization_policy_targets;
+			DROP TABLE IF EXISTS organization_policies;
+			DROP TABLE IF EXISTS organization_accounts;
+			DROP TABLE IF EXISTS organizations;
+		`,
+	},
+	{
+		Version: 30,
+		Name:    "create_tag_compliance_tables",
+		Up: `
+			CREATE TABLE IF NOT EXISTS tag_policies (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				name VARCHAR(128) NOT NULL,
+				resource_types JSONB NOT NULL DEFAULT '[]',
+				rules JSONB NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (account_id, name)
+			);
+			CREATE TABLE IF NOT EXISTS compliance_reports (
+				account_id INTEGER PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+				report JSONB NOT NULL,
+				generated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS compliance_reports;
+			DROP TABLE IF EXISTS tag_policies;
+		`,
+	},
+	{
+		Version: 31,
+		Name:    "create_cloudwatch_tables",
+		Up: `
+			CREATE TABLE IF NOT EXISTS cloudsim_resources (
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				resource_id VARCHAR(64) NOT NULL,
+				resource_type VARCHAR(32) NOT NULL,
+				name VARCHAR(64) NOT NULL,
+				arn VARCHAR(2048) NOT NULL,
+				tags JSONB NOT NULL DEFAULT '{}',
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (account_id, resource_id)
+			);
+			-- One narrow row per series and minute; old points are pruned.
+			CREATE TABLE IF NOT EXISTS metric_points (
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				namespace VARCHAR(32) NOT NULL,
+				metric_name VARCHAR(64) NOT NULL,
+				resource_id VARCHAR(64) NOT NULL,
+				ts TIMESTAMP NOT NULL,
+				value REAL NOT NULL,
+				PRIMARY KEY (account_id, namespace, metric_name, resource_id, ts)
+			);
+			CREATE INDEX IF NOT EXISTS idx_metric_points_ts ON metric_points(ts);
+			CREATE TABLE IF NOT EXISTS metric_alarms (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				name VARCHAR(255) NOT NULL,
+				namespace VARCHAR(32) NOT NULL,
+				metric_name VARCHAR(64) NOT NULL,
+				resource_id VARCHAR(64) NOT NULL,
+				statistic VARCHAR(16) NOT NULL,
+				period_minutes INTEGER NOT NULL,
+				evaluation_periods INTEGER NOT NULL,
+				comparison_operator VARCHAR(40) NOT NULL,
+				threshold DOUBLE PRECISION NOT NULL,
+				state VARCHAR(20) NOT NULL DEFAULT 'INSUFFICIENT_DATA',
+				state_reason TEXT NOT NULL DEFAULT '',
+				state_updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (account_id, name)
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS metric_alarms;
+			DROP TABLE IF EXISTS metric_points;
+			DROP TABLE IF EXISTS cloudsim_resources;
+		`,
+	},
+	{
+		Version: 32,
+		Name:    "add_cloudsim_resource_attributes",
+		Up: `
+			ALTER TABLE cloudsim_resources
+			ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+		`,
+		Down: `
+			ALTER TABLE cloudsim_resources
+			DROP COLUMN IF EXISTS attributes;
+		`,
+	},
+	{
+		Version: 33,
+		Name:    "add_guest_expiry_to_accounts",
+		Up: `
+			ALTER TABLE accounts
+			ADD COLUMN IF NOT EXISTS guest_expires_at TIMESTAMP;
+			CREATE INDEX IF NOT EXISTS idx_accounts_guest_expires_at
+				ON accounts(guest_expires_at) WHERE guest_expires_at IS NOT NULL;
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_accounts_guest_expires_at;
+			ALTER TABLE accounts
+			DROP COLUMN IF EXISTS guest_expires_at;
+		`,
+	},
+	{
+		Version: 34,
+		Name:    "create_course_collaborators_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS course_collaborators (
+				course_id INTEGER NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				role VARCHAR(10) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+				invited_by INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (course_id, account_id)
+			);
+			CREATE INDEX IF NOT EXISTS idx_course_collaborators_account ON course_collaborators(account_id);
+		`,
+		Down: `DROP TABLE IF EXISTS course_collaborators;`,
+	},
+	{
+		Version: 35,
+		Name:    "create_card_reviews_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS card_reviews (
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				flashcard_id INTEGER NOT NULL REFERENCES flashcards(id) ON DELETE CASCADE,
+				ease REAL NOT NULL,
+				interval_days INTEGER NOT NULL,
+				repetitions INTEGER NOT NULL,
+				last_grade VARCHAR(10) NOT NULL,
+				due_at TIMESTAMP NOT NULL,
+				reviewed_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (account_id, flashcard_id)
+			);
+			CREATE INDEX IF NOT EXISTS idx_card_reviews_due ON card_reviews(account_id, due_at);
+		`,
+		Down: `DROP TABLE IF EXISTS card_reviews;`,
+	},
+	{
+		Version: 36,
+		Name:    "create_card_media_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS card_media (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+				content_type VARCHAR(50) NOT NULL,
+				width INTEGER NOT NULL,
+				height INTEGER NOT NULL,
+				data BYTEA NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			ALTER TABLE flashcards
+			ADD COLUMN IF NOT EXISTS media_id INTEGER REFERENCES card_media(id) ON DELETE SET NULL,
+			ADD COLUMN IF NOT EXISTS occlusion JSONB;
+			CREATE INDEX IF NOT EXISTS idx_flashcards_media_id ON flashcards(media_id) WHERE media_id IS NOT NULL;
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_flashcards_media_id;
+			ALTER TABLE flashcards
+			DROP COLUMN IF EXISTS occlusion,
+			DROP COLUMN IF EXISTS media_id;
+			DROP TABLE IF EXISTS card_media;
+		`,
+	},
+	{
+		Version: 37,
+		Name:    "add_accounts_username_lower_index",
+		// Usernames differing only in case are renamed, keeping the oldest
+		// account's, so that they can be made unique regardless of case.
+		Up: `
+			UPDATE accounts a SET username = LEFT(a.username, 38) || '-' || a.id
+			WHERE EXISTS (
+				SELECT 1 FROM accounts b WHERE LOWER(b.username) = LOWER(a.username) AND b.id < a.id
+			);
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_username_lower ON accounts (LOWER(username));
+		`,
+		Down: `DROP INDEX IF EXISTS idx_accounts_username_lower;`,
+	},
+	{
+		Version: 38,
+		Name:    "create_site_mode_table",
+		// A single row; id is always TRUE.
+		Up: `
+			CREATE TABLE IF NOT EXISTS site_mode (
+				id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+				mode VARCHAR(20) NOT NULL CHECK (mode IN ('normal', 'read_only', 'maintenance')),
+				message TEXT NOT NULL DEFAULT '',
+				updated_by INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS site_mode;`,
+	},
+	{
+		Version: 39,
+		Name:    "create_study_digests_table",
+		// Rows are created on the first digest or preference change; an
+		// account without one receives digests.
+		Up: `
+			CREATE TABLE IF NOT EXISTS study_digests (
+				account_id INTEGER PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+				opted_out BOOLEAN NOT NULL DEFAULT FALSE,
+				unsubscribe_token VARCHAR(32) NOT NULL UNIQUE,
+				next_run_at TIMESTAMPTZ,
+				last_sent_at TIMESTAMPTZ
+			);
+			CREATE INDEX IF NOT EXISTS idx_account_score_account_answered ON account_score (account_id, answered_at);
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_account_score_account_answered;
+			DROP TABLE IF EXISTS study_digests;
+		`,
+	},
+	{
+		Version: 40,
+		Name:    "create_account_suspensions_table",
+		// expires_at is NULL for bans. Appeals are messages of kind
+		// 'appeal' linked to the account that sent them.
+		Up: `
+			ALTER TABLE messages ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'contact';
+			ALTER TABLE messages ADD COLUMN IF NOT EXISTS account_id INTEGER REFERENCES accounts(id) ON DELETE SET NULL;
+			CREATE TABLE IF NOT EXISTS account_suspensions (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				reason TEXT NOT NULL,
+				suspended_by INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMPTZ,
+				lifted_at TIMESTAMPTZ,
+				lifted_by INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+				appeal_message_id INTEGER REFERENCES messages(id) ON DELETE SET NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_account_suspensions_account ON account_suspensions (account_id) WHERE lifted_at IS NULL;
+		`,
+		Down: `
+			DROP TABLE IF EXISTS account_suspensions;
+			ALTER TABLE messages DROP COLUMN IF EXISTS account_id;
+			ALTER TABLE messages DROP COLUMN IF EXISTS kind;
+		`,
+	},
+	{
+		Version: 41,
+		Name:    "create_idempotency_keys_table",
+		// status is NULL while the first request is running.
+		Up: `
+			CREATE TABLE IF NOT EXISTS idempotency_keys (
+				id SERIAL PRIMARY KEY,
+				scope VARCHAR(80) NOT NULL,
+				key VARCHAR(255) NOT NULL,
+				fingerprint CHAR(64) NOT NULL,
+				status INTEGER,
+				content_type VARCHAR(255),
+				body BYTEA,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (scope, key)
+			);
+			CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys (created_at);
+		`,
+		Down: `DROP TABLE IF EXISTS idempotency_keys;`,
+	},
+	{
+		Version: 42,
+		Name:    "add_accounts_sim_account_number",
+		// The account number is derived from the id so it never changes and
+		// ARNs can be built without a lookup. ARNs stored before then used
+		// the bare id and are rewritten, including the policy ARNs users and
+		// roles reference. Policy documents are left alone; the simulator
+		// treats a bare id as the same account.
+		Up: `
+			ALTER TABLE accounts ADD COLUMN IF NOT EXISTS sim_account_number CHAR(12)
+				GENERATED ALWAYS AS (LPAD(id::text, 12, '0')) STORED;
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_accounts_sim_account_number ON accounts (sim_account_number);
+			UPDATE iam_users t SET arn = REPLACE(t.arn, 'arn:aws:iam::' || t.account_id || ':', 'arn:aws:iam::' || a.sim_account_number || ':'),
+				permissions_boundary = REPLACE(t.permissions_boundary, 'arn:aws:iam::' || t.account_id || ':', 'arn:aws:iam::' || a.sim_account_number || ':'),
+				attached_policies = REPLACE(t.attached_policies::text, 'arn:aws:iam::' || t.account_id || ':', 'arn:aws:iam::' || a.sim_account_number || ':')::jsonb
+				FROM accounts a WHERE a.id = t.account_id;
+			UPDATE iam_roles t SET arn = REPLACE(t.arn, 'arn:aws:iam::' || t.account_id || ':', 'arn:aws:iam::' || a.sim_account_number || ':'),
+				permissions_boundary = REPLACE(t.permissions_boundary, 'arn:aws:iam::' || t.account_id || ':', 'arn:aws:iam::' || a.sim_account_number || ':'),
+				attached_policies = REPLACE(t.attached_policies::text, 'arn:aws:iam::' || t.account_id || ':', 'arn:aws:iam::' || a.sim_account_number || ':')::jsonb
+				FROM accounts a WHERE a.id = t.account_id;
+			UPDATE iam_policies t SET arn = REPLACE(t.arn, 'arn:aws:iam::' || t.account_id || ':', 'arn:aws:iam::' || a.sim_account_number || ':')
+				FROM accounts a WHERE a.id = t.account_id;
+			UPDATE cloudsim_resources t SET arn = REGEXP_REPLACE(t.arn, '^(arn:aws:[a-z0-9-]+:[a-z0-9-]*:)' || t.account_id || ':', '\1' || a.sim_account_number || ':')
+				FROM accounts a WHERE a.id = t.account_id;
+		`,
+		Down: `
+			UPDATE iam_users t SET arn = REPLACE(t.arn, 'arn:aws:iam::' || a.sim_account_number || ':', 'arn:aws:iam::' || t.account_id || ':'),
+				permissions_boundary = REPLACE(t.permissions_boundary, 'arn:aws:iam::' || a.sim_account_number || ':', 'arn:aws:iam::' || t.account_id || ':'),
+				attached_policies = REPLACE(t.attached_policies::text, 'arn:aws:iam::' || a.sim_account_number || ':', 'arn:aws:iam::' || t.account_id || ':')::jsonb
+				FROM accounts a WHERE a.id = t.account_id;
+			UPDATE iam_roles t SET arn = REPLACE(t.arn, 'arn:aws:iam::' || a.sim_account_number || ':', 'arn:aws:iam::' || t.account_id || ':'),
+				permissions_boundary = REPLACE(t.permissions_boundary, 'arn:aws:iam::' || a.sim_account_number || ':', 'arn:aws:iam::' || t.account_id || ':'),
+				attached_policies = REPLACE(t.attached_policies::text, 'arn:aws:iam::' || a.sim_account_number || ':', 'arn:aws:iam::' || t.account_id || ':')::jsonb
+				FROM accounts a WHERE a.id = t.account_id;
+			UPDATE iam_policies t SET arn = REPLACE(t.arn, 'arn:aws:iam::' || a.sim_account_number || ':', 'arn:aws:iam::' || t.account_id || ':')
+				FROM accounts a WHERE a.id = t.account_id;
+			UPDATE cloudsim_resources t SET arn = REPLACE(t.arn, ':' || a.sim_account_number || ':', ':' || t.account_id || ':')
+				FROM accounts a WHERE a.id = t.account_id;
+			DROP INDEX IF EXISTS idx_accounts_sim_account_number;
+			ALTER TABLE accounts DROP COLUMN IF EXISTS sim_account_number;
+		`,
+	},
+	{
+		Version: 43,
+		Name:    "add_accounts_presence_hidden",
+		Up:      `ALTER TABLE accounts ADD COLUMN IF NOT EXISTS presence_hidden BOOLEAN NOT NULL DEFAULT FALSE;`,
+		Down:    `ALTER TABLE accounts DROP COLUMN IF EXISTS presence_hidden;`,
+	},
+	{
+		Version: 44,
+		Name:    "create_api_keys_table",
+		// Only a hash of each key is stored; prefix identifies it to its
+		// owner.
+		Up: `
+			CREATE TABLE IF NOT EXISTS api_keys (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				name VARCHAR(100) NOT NULL,
+				prefix VARCHAR(16) NOT NULL,
+				key_hash CHAR(64) NOT NULL UNIQUE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				last_used_at TIMESTAMPTZ,
+				revoked_at TIMESTAMPTZ
+			);
+			CREATE INDEX IF NOT EXISTS idx_api_keys_account ON api_keys (account_id) WHERE revoked_at IS NULL;
+		`,
+		Down: `DROP TABLE IF EXISTS api_keys;`,
+	},
+	{
+		Version: 45,
+		Name:    "add_courses_draft",
+		Up:      `ALTER TABLE courses ADD COLUMN IF NOT EXISTS draft BOOLEAN NOT NULL DEFAULT FALSE;`,
+		Down:    `ALTER TABLE courses DROP COLUMN IF EXISTS draft;`,
+	},
+	{
+		Version: 46,
+		Name:    "add_flashcards_distractors",
+		// Wrong options of multiple-choice cards; NULL for free-text ones.
+		Up:   `ALTER TABLE flashcards ADD COLUMN IF NOT EXISTS distractors TEXT[];`,
+		Down: `ALTER TABLE flashcards DROP COLUMN IF EXISTS distractors;`,
+	},
+	{
+		Version: 47,
+		Name:    "add_card_explanations",
+		// explain_usage logs every explanation asked for; generated rows
+		// count against the daily limit on model replies.
+		Up: `
+			ALTER TABLE flashcards ADD COLUMN IF NOT EXISTS explanation TEXT;
+			CREATE TABLE IF NOT EXISTS explain_usage (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				flashcard_id INTEGER REFERENCES flashcards(id) ON DELETE SET NULL,
+				generated BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_explain_usage_account ON explain_usage (account_id, created_at);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS explain_usage;
+			ALTER TABLE flashcards DROP COLUMN IF EXISTS explanation;
+		`,
+	},
+	{
+		Version: 48,
+		Name:    "add_code_challenges",
+		// Submissions keep a snapshot of the code, even when it came from a
+		// file that has changed since.
+		Up: `
+			ALTER TABLE flashcards ADD COLUMN IF NOT EXISTS challenge JSONB;
+			CREATE TABLE IF NOT EXISTS challenge_submissions (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				flashcard_id INTEGER NOT NULL REFERENCES flashcards(id) ON DELETE CASCADE,
+				filename VARCHAR(255),
+				source TEXT NOT NULL,
+				passed BOOLEAN NOT NULL,
+				result JSONB NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_challenge_submissions_card ON challenge_submissions (account_id, flashcard_id, created_at);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS challenge_submissions;
+			ALTER TABLE flashcards DROP COLUMN IF EXISTS challenge;
+		`,
+	},
+	{
+		Version: 49,
+		Name:    "create_terminal_tables",
+		Up: `
+			CREATE TABLE IF NOT EXISTS terminal_history (
+				id BIGSERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				command TEXT NOT NULL,
+				cwd VARCHAR(255) NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_terminal_history_account ON terminal_history (account_id, id);
+			CREATE TABLE IF NOT EXISTS terminal_state (
+				account_id INTEGER PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+				cwd VARCHAR(255) NOT NULL,
+				env JSONB NOT NULL DEFAULT '{}',
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS terminal_state;
+			DROP TABLE IF EXISTS terminal_history;
+		`,
+	},
+	{
+		Version: 50,
+		Name:    "create_terminal_recordings",
+		// share_token is set while the recording has a public replay link.
+		Up: `
+			CREATE TABLE IF NOT EXISTS terminal_recordings (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				title VARCHAR(100) NOT NULL,
+				width INTEGER NOT NULL,
+				height INTEGER NOT NULL,
+				duration DOUBLE PRECISION NOT NULL,
+				events JSONB NOT NULL,
+				share_token VARCHAR(32) UNIQUE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_terminal_recordings_account ON terminal_recordings (account_id, created_at);
+		`,
+		Down: `DROP TABLE IF EXISTS terminal_recordings;`,
+	},
+	{
+		Version: 51,
+		Name:    "create_sandbox_packages",
+		// Empty versions allow any version of the package.
+		Up: `
+			CREATE TABLE IF NOT EXISTS sandbox_packages (
+				name VARCHAR(100) PRIMARY KEY,
+				versions TEXT[] NOT NULL DEFAULT '{}',
+				description VARCHAR(255) NOT NULL DEFAULT '',
+				added_by INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS sandbox_packages;`,
+	},
+	{
+		Version: 52,
+		Name:    "create_cron_jobs",
+		// failures counts failed runs in a row; last_status is "ok" or
+		// "failed".
+		Up: `
+			CREATE TABLE IF NOT EXISTS cron_jobs (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				filename VARCHAR(255) NOT NULL,
+				schedule VARCHAR(100) NOT NULL,
+				timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+				enabled BOOLEAN NOT NULL DEFAULT TRUE,
+				next_run_at TIMESTAMPTZ NOT NULL,
+				last_run_at TIMESTAMPTZ,
+				last_status VARCHAR(10),
+				failures INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_cron_jobs_account ON cron_jobs (account_id);
+			CREATE INDEX IF NOT EXISTS idx_cron_jobs_due ON cron_jobs (next_run_at) WHERE enabled;
+			CREATE TABLE IF NOT EXISTS cron_runs (
+				id SERIAL PRIMARY KEY,
+				job_id INTEGER NOT NULL REFERENCES cron_jobs(id) ON DELETE CASCADE,
+				started_at TIMESTAMPTZ NOT NULL,
+				duration_ms BIGINT NOT NULL,
+				exit_code INTEGER NOT NULL,
+				stdout TEXT NOT NULL,
+				stderr TEXT NOT NULL,
+				truncated BOOLEAN NOT NULL DEFAULT FALSE,
+				error TEXT NOT NULL DEFAULT '',
+				manual BOOLEAN NOT NULL DEFAULT FALSE
+			);
+			CREATE INDEX IF NOT EXISTS idx_cron_runs_job ON cron_runs (job_id, started_at);
+		`,
+		Down: `DROP TABLE IF EXISTS cron_runs; DROP TABLE IF EXISTS cron_jobs;`,
+	},
+	{
+		Version: 53,
+		Name:    "create_user_secrets",
+		// value is sealed by the vault package, bound to account_id and
+		// name.
+		Up: `
+			CREATE TABLE IF NOT EXISTS user_secrets (
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				name VARCHAR(64) NOT NULL,
+				value BYTEA NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (account_id, name)
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS user_secrets;`,
+	},
+	{
+		Version: 54,
+		Name:    "add_user_files_encryption",
+		// key_id and data_key are set for content encrypted by the files
+		// keyring, NULL for plaintext content. Rolling back drops the
+		// wrapped keys, so encrypted content is lost with them.
+		Up: `
+			ALTER TABLE user_files
+			ADD COLUMN IF NOT EXISTS key_id VARCHAR(32),
+			ADD COLUMN IF NOT EXISTS data_key BYTEA;
+		`,
+		Down: `
+			ALTER TABLE user_files
+			DROP COLUMN IF EXISTS key_id,
+			DROP COLUMN IF EXISTS data_key;
+		`,
+	},
+	{
+		Version: 55,
+		Name:    "add_user_files_scan",
+		// scan_status is "pending", "clean" or "infected" (quarantined),
+		// NULL for files saved without a malware scanner.
+		Up: `
+			ALTER TABLE user_files
+			ADD COLUMN IF NOT EXISTS scan_status VARCHAR(10),
+			ADD COLUMN IF NOT EXISTS scan_signature VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMPTZ;
+			CREATE INDEX IF NOT EXISTS idx_user_files_scan_status ON user_files (scan_status, updated_at)
+				WHERE scan_status IN ('pending', 'infected');
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_user_files_scan_status;
+			ALTER TABLE user_files
+			DROP COLUMN IF EXISTS scan_status,
+			DROP COLUMN IF EXISTS scan_signature,
+			DROP COLUMN IF EXISTS scanned_at;
+		`,
+	},
+	{
+		Version: 56,
+		Name:    "create_published_pages",
+		// A file is published at most once, under a slug unique to its
+		// account.
+		Up: `
+			CREATE TABLE IF NOT EXISTS published_pages (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				file_id INTEGER NOT NULL UNIQUE REFERENCES user_files(id) ON DELETE CASCADE,
+				slug VARCHAR(64) NOT NULL,
+				published_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (account_id, slug)
+			);
+		`,
+		Down: `DROP TABLE IF EXISTS published_pages;`,
+	},
+	{
+		Version: 57,
+		Name:    "create_projects",
+		// A project owns the files under the directory named after it;
+		// files.Save sets project_id. Deleting a project deletes its files.
+		Up: `
+			CREATE TABLE IF NOT EXISTS projects (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				name VARCHAR(64) NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				entrypoint VARCHAR(255) NOT NULL DEFAULT 'main.py',
+				args TEXT[] NOT NULL DEFAULT '{}',
+				share_token VARCHAR(32) UNIQUE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (account_id, name)
+			);
+			ALTER TABLE user_files
+			ADD COLUMN IF NOT EXISTS project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE;
+			CREATE INDEX IF NOT EXISTS idx_user_files_project_id ON user_files (project_id) WHERE project_id IS NOT NULL;
+		`,
+		Down: `
+			ALTER TABLE user_files DROP COLUMN IF EXISTS project_id;
+			DROP TABLE IF EXISTS projects;
+		`,
+	},
+	{
+		Version: 58,
+		Name:    "create_github_exports",
+		// Tokens are sealed with the vault master key. Exports remember
+		// where files were pushed; branch is empty for gists.
+		Up: `
+			CREATE TABLE IF NOT EXISTS github_connections (
+				account_id INTEGER PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+				login VARCHAR(39) NOT NULL,
+				token BYTEA NOT NULL,
+				scopes VARCHAR(255) NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE TABLE IF NOT EXISTS github_exports (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				kind VARCHAR(10) NOT NULL,
+				remote VARCHAR(255) NOT NULL,
+				branch VARCHAR(255) NOT NULL DEFAULT '',
+				files TEXT[] NOT NULL DEFAULT '{}',
+				url TEXT NOT NULL DEFAULT '',
+				revision VARCHAR(64) NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				synced_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (account_id, kind, remote, branch)
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS github_exports;
+			DROP TABLE IF EXISTS github_connections;
+		`,
+	},
+	{
+		Version: 59,
+		Name:    "create_file_versions",
+		// Versions list their content as chunks addressed by SHA-256, so
+		// versions sharing content store it once per account.
+		Up: `
+			CREATE TABLE IF NOT EXISTS file_chunks (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				hash CHAR(64) NOT NULL,
+				size INTEGER NOT NULL,
+				data BYTEA NOT NULL,
+				key_id VARCHAR(32),
+				data_key BYTEA,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				used_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (account_id, hash)
+			);
+			CREATE TABLE IF NOT EXISTS file_versions (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				filename VARCHAR(255) NOT NULL,
+				version INTEGER NOT NULL,
+				file_type VARCHAR(20) NOT NULL,
+				size INTEGER NOT NULL,
+				hash CHAR(64) NOT NULL,
+				chunks TEXT[] NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (account_id, filename, version)
+			);
+			CREATE INDEX IF NOT EXISTS idx_file_versions_chunks ON file_versions USING GIN (chunks);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS file_versions;
+			DROP TABLE IF EXISTS file_chunks;
+		`,
+	},
+	{
+		Version: 60,
+		Name:    "add_messages_legal_hold",
+		// Messages under legal hold are kept past the retention period.
+		Up: `
+			ALTER TABLE messages ADD COLUMN IF NOT EXISTS legal_hold BOOLEAN NOT NULL DEFAULT FALSE;
+			CREATE INDEX IF NOT EXISTS idx_messages_created_at ON messages (created_at) WHERE NOT legal_hold;
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_messages_created_at;
+			ALTER TABLE messages DROP COLUMN IF EXISTS legal_hold;
+		`,
+	},
+	{
+		Version: 61,
+		Name:    "create_brute_force_tables",
+		// auth_failures feeds brute-force detection and is trimmed to a
+		// day. A block ends at blocked_until; the row stays so failures
+		// before it are not counted again.
+		Up: `
+			CREATE TABLE IF NOT EXISTS auth_failures (
+				id BIGSERIAL PRIMARY KEY,
+				ip VARCHAR(45) NOT NULL,
+				username VARCHAR(255) NOT NULL DEFAULT '',
+				kind VARCHAR(20) NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_auth_failures_created_at ON auth_failures (created_at);
+			CREATE TABLE IF NOT EXISTS blocked_ips (
+				ip VARCHAR(45) PRIMARY KEY,
+				reason VARCHAR(30) NOT NULL,
+				failures INTEGER NOT NULL,
+				usernames INTEGER NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				blocked_until TIMESTAMPTZ NOT NULL
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS blocked_ips;
+			DROP TABLE IF EXISTS auth_failures;
+		`,
+	},
+	{
+		Version: 62,
+		Name:    "create_honeypot_hits",
+		// Requests to decoy routes, kept for 30 days. The fingerprint
+		// groups hits from one scanning tool across IPs.
+		Up: `
+			CREATE TABLE IF NOT EXISTS honeypot_hits (
+				id BIGSERIAL PRIMARY KEY,
+				ip VARCHAR(45) NOT NULL,
+				method VARCHAR(10) NOT NULL,
+				path VARCHAR(255) NOT NULL,
+				user_agent VARCHAR(255) NOT NULL DEFAULT '',
+				fingerprint CHAR(16) NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_honeypot_hits_created_at ON honeypot_hits (created_at);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS honeypot_hits;
+		`,
+	},
+	{
+		Version: 63,
+		Name:    "create_ip_rules",
+		// Admin allow and deny rules for IP ranges. A rule with an
+		// expires_at lapses then.
+		Up: `
+			CREATE TABLE IF NOT EXISTS ip_rules (
+				id SERIAL PRIMARY KEY,
+				cidr CIDR NOT NULL,
+				action VARCHAR(10) NOT NULL CHECK (action IN ('allow', 'deny')),
+				note VARCHAR(255) NOT NULL DEFAULT '',
+				created_by INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMPTZ
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS ip_rules;
+		`,
+	},
+	{
+		Version: 64,
+		Name:    "add_accounts_password_algorithm",
+		// The algorithm of each account's password hash. Hashes before
+		// this were all bcrypt; sign-in moves them to the configured
+		// algorithm and updates the column with them.
+		Up: `
+			ALTER TABLE accounts ADD COLUMN IF NOT EXISTS password_algorithm VARCHAR(20) NOT NULL DEFAULT 'bcrypt';
+			CREATE INDEX IF NOT EXISTS idx_accounts_password_algorithm ON accounts (password_algorithm);
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_accounts_password_algorithm;
+			ALTER TABLE accounts DROP COLUMN IF EXISTS password_algorithm;
+		`,
+	},
+	{
+		Version: 65,
+		Name:    "create_passkeys_table",
+		// WebAuthn credentials that sign accounts in without a password.
+		// public_key is the COSE key; sign_count is the authenticator's
+		// counter, which must rise with each sign-in. Challenges are
+		// deleted when answered, so each is used once; account_id is NULL
+		// for sign-in, which starts without an account.
+		Up: `
+			CREATE TABLE IF NOT EXISTS passkeys (
+				id SERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				credential_id BYTEA NOT NULL UNIQUE,
+				public_key BYTEA NOT NULL,
+				sign_count BIGINT NOT NULL DEFAULT 0,
+				transports VARCHAR(100) NOT NULL DEFAULT '',
+				name VARCHAR(100) NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				last_used_at TIMESTAMPTZ
+			);
+			CREATE INDEX IF NOT EXISTS idx_passkeys_account ON passkeys (account_id);
+			CREATE TABLE IF NOT EXISTS passkey_challenges (
+				challenge BYTEA PRIMARY KEY,
+				ceremony VARCHAR(10) NOT NULL,
+				account_id INTEGER REFERENCES accounts(id) ON DELETE CASCADE,
+				expires_at TIMESTAMPTZ NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_passkey_challenges_expires ON passkey_challenges (expires_at);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS passkey_challenges;
+			DROP TABLE IF EXISTS passkeys;
+		`,
+	},
+	{
+		Version: 66,
+		Name:    "add_cheating_flags",
+		Up: `
+			ALTER TABLE account_score ADD COLUMN IF NOT EXISTS flags TEXT[] NOT NULL DEFAULT '{}';
+			ALTER TABLE games_played ADD COLUMN IF NOT EXISTS flags TEXT[] NOT NULL DEFAULT '{}';
+			CREATE INDEX IF NOT EXISTS idx_account_score_flagged ON account_score (answered_at) WHERE flags <> '{}';
+			CREATE INDEX IF NOT EXISTS idx_games_played_flagged ON games_played (completed_at) WHERE flags <> '{}';
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_games_played_flagged;
+			DROP INDEX IF EXISTS idx_account_score_flagged;
+			ALTER TABLE games_played DROP COLUMN IF EXISTS flags;
+			ALTER TABLE account_score DROP COLUMN IF EXISTS flags;
+		`,
+	},
+	{
+		Version: 67,
+		Name:    "create_iam_policy_versions_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS iam_policy_versions (
+				policy_id INTEGER NOT NULL REFERENCES iam_policies(id) ON DELETE CASCADE,
+				version_id VARCHAR(16) NOT NULL,
+				document JSONB NOT NULL,
+				created_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (policy_id, version_id)
+			);
+			ALTER TABLE iam_policies ADD COLUMN IF NOT EXISTS latest_version INTEGER NOT NULL DEFAULT 1;
+			INSERT INTO iam_policy_versions (policy_id, version_id, document, created_date)
+			SELECT id, default_version_id, policy_document, created_date FROM iam_policies
+			ON CONFLICT DO NOTHING;
+		`,
+		Down: `
+			ALTER TABLE iam_policies DROP COLUMN IF EXISTS latest_version;
+			DROP TABLE IF EXISTS iam_policy_versions;
+		`,
+	},
+	{
+		Version: 68,
+		Name:    "create_iam_access_events",
+		// One row per simulated service call, allowed or not.
+		Up: `
+			CREATE TABLE IF NOT EXISTS iam_access_events (
+				id BIGSERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				principal_arn VARCHAR(256) NOT NULL,
+				service VARCHAR(64) NOT NULL,
+				action VARCHAR(128) NOT NULL,
+				resource TEXT NOT NULL,
+				decision VARCHAR(16) NOT NULL,
+				occurred_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_iam_access_events_principal
+				ON iam_access_events (account_id, principal_arn, service, occurred_at);
+			CREATE INDEX IF NOT EXISTS idx_iam_access_events_occurred ON iam_access_events (occurred_at);
+		`,
+		Down: `DROP TABLE IF EXISTS iam_access_events;`,
+	},
+	{
+		Version: 69,
+		Name:    "create_kms_tables",
+		// material is the key's AES-256 key sealed by the vault package,
+		// bound to account_id and key_id. A secret with a kms_key_id holds
+		// a KMS ciphertext, itself sealed like any secret.
+		Up: `
+			CREATE TABLE IF NOT EXISTS kms_keys (
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				key_id VARCHAR(36) NOT NULL,
+				arn VARCHAR(256) NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				policy JSONB NOT NULL,
+				material BYTEA NOT NULL,
+				enabled BOOLEAN NOT NULL DEFAULT TRUE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (account_id, key_id)
+			);
+			CREATE TABLE IF NOT EXISTS kms_grants (
+				grant_id VARCHAR(64) PRIMARY KEY,
+				account_id INTEGER NOT NULL,
+				key_id VARCHAR(36) NOT NULL,
+				grantee_principal VARCHAR(256) NOT NULL,
+				operations TEXT[] NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (account_id, key_id) REFERENCES kms_keys (account_id, key_id) ON DELETE CASCADE
+			);
+			CREATE INDEX IF NOT EXISTS idx_kms_grants_key ON kms_grants (account_id, key_id);
+			ALTER TABLE user_secrets ADD COLUMN IF NOT EXISTS kms_key_id VARCHAR(256);
+		`,
+		Down: `
+			ALTER TABLE user_secrets DROP COLUMN IF EXISTS kms_key_id;
+			DROP TABLE IF EXISTS kms_grants;
+			DROP TABLE IF EXISTS kms_keys;
+		`,
+	},
+	{
+		Version: 70,
+		Name:    "create_messaging_tables",
+		// Simulated SQS queues and SNS topics. A message is visible when
+		// visible_at has passed; receiving it hides it for the visibility
+		// timeout and gives it a new receipt_handle. Topics deliver to
+		// queues and to simulated Lambda functions, whose invocations are
+		// kept in lambda_invocations.
+		Up: `
+			CREATE TABLE IF NOT EXISTS sqs_queues (
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				queue_name VARCHAR(80) NOT NULL,
+				arn VARCHAR(256) NOT NULL,
+				visibility_timeout INTEGER NOT NULL DEFAULT 30,
+				dead_letter_queue VARCHAR(80),
+				max_receive_count INTEGER,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (account_id, queue_name)
+			);
+			CREATE TABLE IF NOT EXISTS sqs_messages (
+				message_id VARCHAR(36) PRIMARY KEY,
+				account_id INTEGER NOT NULL,
+				queue_name VARCHAR(80) NOT NULL,
+				body TEXT NOT NULL,
+				attributes JSONB NOT NULL DEFAULT '{}',
+				sent_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				visible_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				receive_count INTEGER NOT NULL DEFAULT 0,
+				receipt_handle VARCHAR(64) UNIQUE,
+				FOREIGN KEY (account_id, queue_name) REFERENCES sqs_queues (account_id, queue_name) ON DELETE CASCADE
+			);
+			CREATE INDEX IF NOT EXISTS idx_sqs_messages_visible ON sqs_messages (account_id, queue_name, visible_at);
+			CREATE INDEX IF NOT EXISTS idx_sqs_messages_sent_at ON sqs_messages (sent_at);
+			CREATE TABLE IF NOT EXISTS sns_topics (
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				topic_name VARCHAR(256) NOT NULL,
+				arn VARCHAR(512) NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (account_id, topic_name)
+			);
+			CREATE TABLE IF NOT EXISTS sns_subscriptions (
+				subscription_id VARCHAR(36) PRIMARY KEY,
+				account_id INTEGER NOT NULL,
+				topic_name VARCHAR(256) NOT NULL,
+				protocol VARCHAR(10) NOT NULL,
+				endpoint VARCHAR(256) NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (account_id, topic_name, protocol, endpoint),
+				FOREIGN KEY (account_id, topic_name) REFERENCES sns_topics (account_id, topic_name) ON DELETE CASCADE
+			);
+			CREATE TABLE IF NOT EXISTS lambda_invocations (
+				id BIGSERIAL PRIMARY KEY,
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				function_name VARCHAR(64) NOT NULL,
+				source_arn VARCHAR(512) NOT NULL,
+				payload JSONB NOT NULL,
+				invoked_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS idx_lambda_invocations_function ON lambda_invocations (account_id, function_name, invoked_at);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS lambda_invocations;
+			DROP TABLE IF EXISTS sns_subscriptions;
+			DROP TABLE IF EXISTS sns_topics;
+			DROP TABLE IF EXISTS sqs_messages;
+			DROP TABLE IF EXISTS sqs_queues;
+		`,
+	},
+	{
+		Version: 71,
+		Name:    "create_dynamodb_tables",
+		// Simulated DynamoDB tables. Items are JSON objects keyed by the
+		// text of their partition and sort key values; sk is '' in tables
+		// without a sort key, and size is the item's JSON length, which
+		// capacity units are counted from.
+		Up: `
+			CREATE TABLE IF NOT EXISTS dynamodb_tables (
+				account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+				table_name VARCHAR(255) NOT NULL,
+				arn VARCHAR(512) NOT NULL,
+				partition_key VARCHAR(255) NOT NULL,
+				partition_key_type CHAR(1) NOT NULL,
+				sort_key VARCHAR(255),
+				sort_key_type CHAR(1),
+				read_capacity INTEGER NOT NULL,
+				write_capacity INTEGER NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (account_id, table_name)
+			);
+			CREATE TABLE IF NOT EXISTS dynamodb_items (
+				account_id INTEGER NOT NULL,
+				table_name VARCHAR(255) NOT NULL,
+				pk TEXT NOT NULL,
+				sk TEXT NOT NULL DEFAULT '',
+				item JSONB NOT NULL,
+				size INTEGER NOT NULL,
+				PRIMARY KEY (account_id, table_name, pk, sk),
+				FOREIGN KEY (account_id, table_name) REFERENCES dynamodb_tables (account_id, table_name) ON DELETE CASCADE
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS dynamodb_items;
+			DROP TABLE IF EXISTS dynamodb_tables;
+		`,
+	},
 }
 
 func CreateMigrationsTable() error {
@@ -283,6 +1551,39 @@ func GetAppliedMigrations() (map[int]bool, error) {
 	return applied, nil
 }
 
+// PendingMigrations returns the versions defined in code but not yet
+// recorded as applied.
+func PendingMigrations() ([]int, error) {
+	applied, err := GetAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []int
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration.Version)
+		}
+	}
+	return pending, nil
+}
+
+// CheckMigrations is the readiness check that fails while migrations are
+// outstanding.
+func CheckMigrations(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("database not connected")
+	}
+	pending, err := PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations: %v", len(pending), pending)
+	}
+	return nil
+}
+
 func RunMigrations() error {
 	if err := CreateMigrationsTable(); err != nil {
 		return err
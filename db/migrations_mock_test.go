@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -136,4 +137,39 @@ func TestCreateMigrationsTable(t *testing.T) {
 			t.Errorf("Mock expectations not met: %v", err)
 		}
 	})
-}
\ No newline at end of file
+}
+func TestCheckMigrations(t *testing.T) {
+	originalDB := DB
+	defer func() {
+		DB = originalDB
+	}()
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer mockDB.Close()
+
+	DB = mockDB
+
+	t.Run("all applied", func(t *testing.T) {
+		rows := sqlmock.NewRows([]string{"version"})
+		for _, migration := range migrations {
+			rows.AddRow(migration.Version)
+		}
+		mock.ExpectQuery("SELECT version FROM migrations").WillReturnRows(rows)
+
+		if err := CheckMigrations(context.Background()); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		rows := sqlmock.NewRows([]string{"version"}).AddRow(1)
+		mock.ExpectQuery("SELECT version FROM migrations").WillReturnRows(rows)
+
+		if err := CheckMigrations(context.Background()); err == nil {
+			t.Error("Expected pending migrations to fail the check")
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// Package etag adds entity tags to GET API responses so polling clients can
+// revalidate with If-None-Match and get 304 Not Modified when nothing
+// changed.
+package etag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// cacheControl makes browsers revalidate every time: the responses are
+// per-account and change whenever the data does.
+const cacheControl = "private, no-cache"
+
+// Of returns the strong entity tag of a response body.
+func Of(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Matches reports whether the If-None-Match header of r lists tag. Tags are
+// compared weakly, as RFC 9110 requires for If-None-Match.
+func Matches(r *http.Request, tag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler buffers the response of next and, when it is a 200, tags it with
+// an ETag computed from the body. A GET or HEAD whose If-None-Match matches
+// gets 304 with no body instead. The database is still queried; what is
+// saved is the transfer. Handlers that set their own ETag keep it. Not for
+// streaming responses, which would be held until complete.
+func Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		buf := &bufferedWriter{header: w.Header()}
+		next(buf, r)
+		if buf.status == 0 {
+			buf.status = http.StatusOK
+		}
+		if buf.status != http.StatusOK {
+			w.WriteHeader(buf.status)
+			w.Write(buf.body.Bytes())
+			return
+		}
+
+		h := w.Header()
+		tag := h.Get("ETag")
+		if tag == "" {
+			tag = Of(buf.body.Bytes())
+			h.Set("ETag", tag)
+		}
+		if h.Get("Cache-Control") == "" {
+			h.Set("Cache-Control", cacheControl)
+		}
+		if Matches(r, tag) {
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.body.Bytes())
+	}
+}
+
+// bufferedWriter holds the response until the handler returns. Headers go
+// straight to the real writer's map, which is not sent until WriteHeader.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
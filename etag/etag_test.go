@@ -0,0 +1,84 @@
+package etag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func jsonHandler(body string, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if status != 0 {
+			w.WriteHeader(status)
+		}
+		w.Write([]byte(body))
+	}
+}
+
+func TestHandler(t *testing.T) {
+	body := `{"items":[]}`
+	tag := Of([]byte(body))
+
+	tests := []struct {
+		name        string
+		method      string
+		ifNoneMatch string
+		status      int
+		wantStatus  int
+		wantBody    string
+		wantTag     bool
+	}{
+		{"First load", http.MethodGet, "", 0, http.StatusOK, body, true},
+		{"Unchanged", http.MethodGet, tag, 0, http.StatusNotModified, "", true},
+		{"Weak and listed", http.MethodGet, `"other", W/` + tag, 0, http.StatusNotModified, "", true},
+		{"Any", http.MethodGet, "*", 0, http.StatusNotModified, "", true},
+		{"Changed", http.MethodGet, `"stale"`, 0, http.StatusOK, body, true},
+		{"Errors are untagged", http.MethodGet, tag, http.StatusNotFound, http.StatusNotFound, body, false},
+		{"Not a read", http.MethodPost, tag, 0, http.StatusOK, body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/iam/users", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rr := httptest.NewRecorder()
+			Handler(jsonHandler(body, tt.status))(rr, req)
+
+			if rr.Code != tt.wantStatus || rr.Body.String() != tt.wantBody {
+				t.Errorf("status = %d, body %q", rr.Code, rr.Body.String())
+			}
+			if got := rr.Header().Get("ETag") == tag; got != tt.wantTag {
+				t.Errorf("ETag = %q", rr.Header().Get("ETag"))
+			}
+			if tt.wantTag && rr.Header().Get("Cache-Control") != cacheControl {
+				t.Errorf("Cache-Control = %q", rr.Header().Get("Cache-Control"))
+			}
+			if rr.Code == http.StatusNotModified && rr.Header().Get("Content-Type") != "" {
+				t.Error("304 carries a Content-Type")
+			}
+		})
+	}
+}
+
+func TestHandlerKeepsHandlerETag(t *testing.T) {
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v7"`)
+		w.Write([]byte("{}"))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/files/load", nil)
+	req.Header.Set("If-None-Match", `"v7"`)
+	rr := httptest.NewRecorder()
+	Handler(next)(rr, req)
+
+	if rr.Code != http.StatusNotModified || rr.Header().Get("ETag") != `"v7"` {
+		t.Errorf("status = %d, ETag = %q", rr.Code, rr.Header().Get("ETag"))
+	}
+}
+
+func TestOfDependsOnBody(t *testing.T) {
+	if Of([]byte("a")) == Of([]byte("b")) || Of([]byte("a")) != Of([]byte("a")) {
+		t.Error("Of is not a function of the body")
+	}
+}
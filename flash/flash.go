@@ -0,0 +1,115 @@
+// Package flash carries one-time notices across a redirect: a handler adds
+// a message before redirecting, and the template renderer shows it on the
+// next page and clears it.
+//
+//	flash.Add(w, r, flash.Success, "You have signed out.")
+//	http.Redirect(w, r, "/projects", http.StatusSeeOther)
+//
+// Messages live in a signed browser-session cookie rather than in the URL,
+// so a reload or a shared link never shows them again and other sites
+// cannot plant them. They are English source
+// text, translated when rendered, and always HTML-escaped.
+package flash
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"allanswebterminal/securecookie"
+)
+
+// CookieName holds the pending messages.
+const CookieName = "flash"
+
+// Kinds of message, which style the notice.
+const (
+	Success = "success"
+	Info    = "info"
+	Error   = "error"
+)
+
+const (
+	// maxMessages is how many messages wait at most; older ones are dropped.
+	maxMessages = 3
+	// maxLength caps the length of a message read back from the cookie.
+	maxLength = 300
+)
+
+// Secure marks the cookie as Secure. It is enabled with login.SecureCookies.
+var Secure bool
+
+// Message is one notice.
+type Message struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+}
+
+// Add queues a message for the next page rendered in this browser. Messages
+// already waiting, and those added earlier in this request, are kept.
+func Add(w http.ResponseWriter, r *http.Request, kind, text string) {
+	messages := append(Get(r), Message{Kind: kind, Text: text})
+	if len(messages) > maxMessages {
+		messages = messages[len(messages)-maxMessages:]
+	}
+	data, _ := json.Marshal(messages)
+	value := securecookie.Encode(CookieName, base64.RawURLEncoding.EncodeToString(data))
+
+	// Later Adds in this request read the messages back from r, and the
+	// response carries only the last flash cookie.
+	r.AddCookie(&http.Cookie{Name: CookieName, Value: value})
+	var kept []string
+	for _, c := range w.Header().Values("Set-Cookie") {
+		if !strings.HasPrefix(c, CookieName+"=") {
+			kept = append(kept, c)
+		}
+	}
+	w.Header()["Set-Cookie"] = kept
+	setCookie(w, value, 0)
+}
+
+// Get returns the messages waiting for r, oldest first, without clearing
+// them. Malformed or unsigned cookies and unknown kinds are ignored.
+func Get(r *http.Request) []Message {
+	cookies := r.CookiesNamed(CookieName)
+	if len(cookies) == 0 {
+		return nil
+	}
+	value, err := securecookie.Decode(CookieName, cookies[len(cookies)-1].Value)
+	if err != nil {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	var stored []Message
+	if json.Unmarshal(data, &stored) != nil {
+		return nil
+	}
+	var messages []Message
+	for _, m := range stored {
+		if (m.Kind == Success || m.Kind == Info || m.Kind == Error) && m.Text != "" && len(m.Text) <= maxLength {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// Clear removes the waiting messages once they have been shown.
+func Clear(w http.ResponseWriter) {
+	setCookie(w, "", -1)
+}
+
+func setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,72 @@
+package flash
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// nextRequest is the request the browser sends after rec's response.
+func nextRequest(rec *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			req.AddCookie(c)
+		}
+	}
+	return req
+}
+
+func TestAddAndGet(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/logout", nil)
+	http.SetCookie(rec, &http.Cookie{Name: "user_id", Value: "", MaxAge: -1})
+	Add(rec, req, Success, "You have signed out.")
+	Add(rec, req, Info, "See you soon.")
+
+	if n := len(rec.Header().Values("Set-Cookie")); n != 2 {
+		t.Errorf("response sets %d cookies, want the session cookie and one flash cookie", n)
+	}
+	got := Get(nextRequest(rec))
+	want := []Message{{Success, "You have signed out."}, {Info, "See you soon."}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Get = %+v, want %+v", got, want)
+	}
+}
+
+func TestAddKeepsWaitingMessages(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Add(rec, httptest.NewRequest(http.MethodGet, "/", nil), Info, "first")
+	req := nextRequest(rec)
+	for _, text := range []string{"second", "third", "fourth"} {
+		rec = httptest.NewRecorder()
+		Add(rec, req, Info, text)
+	}
+	got := Get(nextRequest(rec))
+	if len(got) != maxMessages || got[0].Text != "second" || got[2].Text != "fourth" {
+		t.Errorf("Get = %+v, want the last %d messages", got, maxMessages)
+	}
+}
+
+func TestGetIgnoresMalformedCookies(t *testing.T) {
+	for _, value := range []string{
+		"not base64!",
+		"bm90IGpzb24", // "not json"
+		"W3sia2luZCI6InNjcmlwdCIsInRleHQiOiJoaSJ9XQ", // unknown kind
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: CookieName, Value: value})
+		if got := Get(req); len(got) != 0 {
+			t.Errorf("Get(%q) = %+v, want none", value, got)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Clear(rec)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CookieName || cookies[0].MaxAge >= 0 {
+		t.Errorf("Clear set %+v, want an expired flash cookie", cookies)
+	}
+}
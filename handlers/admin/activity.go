@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+)
+
+var (
+	activityMu   sync.Mutex
+	activitySeen = map[int]string{}
+)
+
+// TrackActivity records each signed-in account once per UTC day so the stats
+// endpoint can report daily active users. Repeat visits on the same day are
+// filtered in memory and never reach the database.
+func TrackActivity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if value, ok := securecookie.Value(r, "user_id"); ok {
+			if id, err := strconv.Atoi(value); err == nil && id > 0 {
+				recordActivity(id, time.Now().UTC())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func recordActivity(accountID int, now time.Time) {
+	if !db.Available() {
+		return
+	}
+	day := now.Format(dateLayout)
+
+	activityMu.Lock()
+	if activitySeen[accountID] == day {
+		activityMu.Unlock()
+		return
+	}
+	activitySeen[accountID] = day
+	activityMu.Unlock()
+
+	_, err := db.DB.Exec(
+		"INSERT INTO account_daily_activity (account_id, day) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		accountID, day,
+	)
+	if err != nil {
+		log.Printf("Failed to record activity for account %d: %v", accountID, err)
+	}
+}
@@ -0,0 +1,46 @@
+// Package admin serves operator-only endpoints under /api/admin/.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/scheduler"
+)
+
+// requireAdmin writes an error and returns false unless the caller is
+// signed in with the admin role.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	_, ok := currentAdmin(w, r)
+	return ok
+}
+
+// currentAdmin is requireAdmin for handlers that need the admin's account.
+func currentAdmin(w http.ResponseWriter, r *http.Request) (*login.User, bool) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return nil, false
+	}
+	if user.Role != "admin" {
+		apierror.Write(w, apierror.Forbidden("Admin access required"))
+		return nil, false
+	}
+	return user, true
+}
+
+// SchedulerHandler reports the status of every background job.
+func SchedulerHandler(s *scheduler.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jobs": s.Status(),
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/scheduler"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockUser(t *testing.T, role string) {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "root", role))
+}
+
+func newRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/scheduler", nil)
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	return req
+}
+
+func TestSchedulerHandler(t *testing.T) {
+	s := scheduler.New(nil)
+	s.Register(scheduler.Job{Name: "session_gc", Schedule: scheduler.Every(time.Minute), Local: true,
+		Run: func(context.Context) error { return nil }})
+
+	tests := []struct {
+		name       string
+		role       string
+		cookie     bool
+		wantStatus int
+	}{
+		{"Admin", "admin", true, http.StatusOK},
+		{"Regular user", "user", true, http.StatusForbidden},
+		{"Anonymous", "", false, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/scheduler", nil)
+			if tt.cookie {
+				setupMockUser(t, tt.role)
+				req = newRequest()
+			}
+			rec := httptest.NewRecorder()
+			SchedulerHandler(s)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Jobs []scheduler.JobStatus `json:"jobs"`
+			}
+			json.NewDecoder(rec.Body).Decode(&body)
+			if len(body.Jobs) != 1 || body.Jobs[0].Name != "session_gc" {
+				t.Errorf("unexpected jobs %+v", body.Jobs)
+			}
+		})
+	}
+}
@@ -0,0 +1,400 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/middleware"
+	"allanswebterminal/securecookie"
+)
+
+// Kinds of recorded authentication failures.
+const (
+	FailureLogin        = "login"
+	FailureUnauthorized = "unauthorized"
+)
+
+// Reasons an IP is blocked for.
+const (
+	ReasonCredentialStuffing = "credential_stuffing"
+	ReasonBruteForce         = "brute_force"
+)
+
+// Brute-force detection settings. An IP is blocked for BlockCooldown once,
+// within BruteForceWindow, it fails to sign in as StuffingUsernames
+// different usernames or fails MaxAuthFailures times in all.
+var (
+	BruteForceWindow  = 15 * time.Minute
+	StuffingUsernames = 10
+	MaxAuthFailures   = 50
+	BlockCooldown     = time.Hour
+)
+
+const (
+	// authFailureRetention is how long failures are kept for detection.
+	authFailureRetention = 24 * time.Hour
+	// blockCacheTTL is how long the blocked IPs and IP rules are served
+	// from memory before they are read again, so blocks made by another
+	// instance reach this one.
+	blockCacheTTL = 30 * time.Second
+	// blockRefreshTimeout bounds reading them.
+	blockRefreshTimeout = 10 * time.Second
+)
+
+var (
+	blockedMu       sync.Mutex
+	blockedIPs      map[string]time.Time
+	ipRules         []ipRule
+	blockedLoadedAt time.Time
+	// blocksRefreshing is set while a request reads them again.
+	blocksRefreshing bool
+)
+
+// BlockedIP is an IP refused for brute-forcing sign-ins.
+type BlockedIP struct {
+	IP           string    `json:"ip"`
+	Reason       string    `json:"reason"`
+	Failures     int       `json:"failures"`
+	Usernames    int       `json:"usernames"`
+	CreatedAt    time.Time `json:"created_at"`
+	BlockedUntil time.Time `json:"blocked_until"`
+}
+
+// RecordAuthFailure stores a failed authentication from ip for
+// DetectBruteForce. username is empty when the request named none.
+// Allowlisted IPs are never recorded, so they are never blocked.
+func RecordAuthFailure(ctx context.Context, ip, username, kind string) {
+	if !db.Available() || ipRuleFor(ctx, ip) == RuleAllow {
+		return
+	}
+	if _, err := db.DB.ExecContext(ctx,
+		"INSERT INTO auth_failures (ip, username, kind) VALUES ($1, $2, $3)",
+		ip, strings.ToLower(username), kind); err != nil {
+		log.Printf("Failed to record authentication failure from %s: %v", ip, err)
+	}
+}
+
+// RecordLoginFailure records a failed sign-in; it is login.OnLoginFailure.
+func RecordLoginFailure(r *http.Request, username string) {
+	RecordAuthFailure(r.Context(), middleware.ClientIP(r), username, FailureLogin)
+}
+
+// BlockAbusiveIPs refuses every request from denylisted IPs and API
+// requests from blocked IPs, except to the admin API so that an admin
+// behind a refused address can lift the rule or block. Allowlisted IPs are
+// never blocked.
+// It also records 401 responses to requests that carried credentials, a
+// signed session cookie or an API key, as failures; requests without any
+// are only anonymous. Failed sign-ins are recorded by the login handler, with their
+// username.
+func BlockAbusiveIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !db.Available() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := middleware.ClientIP(r)
+		api := strings.HasPrefix(r.URL.Path, "/api/")
+		adminAPI := strings.HasPrefix(r.URL.Path, "/api/admin/")
+		rule := ipRuleFor(r.Context(), ip)
+		if rule == RuleDeny && !adminAPI {
+			if !api {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			apierror.Write(w, apierror.Forbidden("Your network is not allowed to use this site").
+				WithDetails(map[string]string{"reason": "ip_denied"}))
+			return
+		}
+		if !api || rule == RuleAllow {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if until, blocked := blockedUntil(r.Context(), ip); blocked && !adminAPI {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			apierror.Write(w, apierror.Forbidden("Too many failed sign-ins from your network, try again later").
+				WithDetails(map[string]string{"reason": "ip_blocked"}))
+			return
+		}
+
+		if !hasCredentials(r) || r.URL.Path == "/api/login" || r.URL.Path == "/api/passkeys/login" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == http.StatusUnauthorized {
+			RecordAuthFailure(r.Context(), ip, "", FailureUnauthorized)
+		}
+	})
+}
+
+// hasCredentials reports whether r presented an API key or a session
+// cookie this server signed. A cookie that doesn't decode, say one signed
+// with the random key of an earlier run, is just stale, and its 401s are
+// not failures: otherwise a network full of old browsers gets blocked.
+func hasCredentials(r *http.Request) bool {
+	if _, ok := securecookie.Value(r, "user_id"); ok {
+		return true
+	}
+	return r.Header.Get("Authorization") != "" || r.Header.Get("X-API-Key") != ""
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush keeps event streams, such as operation progress, working through
+// the wrapper.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over for WebSocket upgrades.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// blockedUntil reports whether ip is blocked, and until when. A database
+// error leaves the last known blocks in force.
+func blockedUntil(ctx context.Context, ip string) (time.Time, bool) {
+	refreshBlocks(ctx)
+	blockedMu.Lock()
+	defer blockedMu.Unlock()
+	until, ok := blockedIPs[ip]
+	return until, ok && time.Now().Before(until)
+}
+
+// refreshBlocks reads the blocks and IP rules again once blockCacheTTL has
+// passed. One request at a time does so, without holding blockedMu, so the
+// others keep using the lists in memory instead of waiting on the
+// database. The queries don't end with the request: a cancelled one would
+// otherwise leave the last known lists stale for another blockCacheTTL.
+func refreshBlocks(ctx context.Context) {
+	blockedMu.Lock()
+	due := !blocksRefreshing && time.Since(blockedLoadedAt) >= blockCacheTTL
+	blocksRefreshing = blocksRefreshing || due
+	blockedMu.Unlock()
+	if !due {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), blockRefreshTimeout)
+	defer cancel()
+	blocks, blocksErr := loadBlocks(ctx)
+	rules, rulesErr := loadIPRules(ctx)
+
+	blockedMu.Lock()
+	defer blockedMu.Unlock()
+	blocksRefreshing = false
+	if blocksErr != nil {
+		log.Printf("Failed to load blocked IPs: %v", blocksErr)
+	} else {
+		blockedIPs = blocks
+	}
+	if rulesErr != nil {
+		log.Printf("Failed to load IP rules: %v", rulesErr)
+	} else {
+		ipRules = rules
+	}
+	blockedLoadedAt = time.Now()
+}
+
+func loadBlocks(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT ip, blocked_until FROM blocked_ips WHERE blocked_until > CURRENT_TIMESTAMP")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	blocks := map[string]time.Time{}
+	for rows.Next() {
+		var ip string
+		var until time.Time
+		if err := rows.Scan(&ip, &until); err != nil {
+			return nil, err
+		}
+		blocks[ip] = until
+	}
+	return blocks, rows.Err()
+}
+
+// forgetBlocks makes the next request read the blocks and IP rules again.
+func forgetBlocks() {
+	blockedMu.Lock()
+	blockedLoadedAt = time.Time{}
+	blockedMu.Unlock()
+}
+
+// DetectBruteForce blocks the IPs whose failures since BruteForceWindow
+// ago, or since their last block ended, cross the thresholds, and alerts
+// the admins. It also trims old failures and honeypot hits. It runs as a
+// job every minute.
+func DetectBruteForce(ctx context.Context) error {
+	rows, err := db.DB.QueryContext(ctx, `
+		WITH offenders AS (
+			SELECT f.ip, COUNT(*) AS failures, COUNT(DISTINCT NULLIF(f.username, '')) AS usernames
+			FROM auth_failures f
+			LEFT JOIN blocked_ips b ON b.ip = f.ip
+			WHERE f.created_at > $1 AND (b.ip IS NULL OR f.created_at > b.blocked_until)
+			GROUP BY f.ip
+			HAVING COUNT(*) >= $2 OR COUNT(DISTINCT NULLIF(f.username, '')) >= $3
+		)
+		INSERT INTO blocked_ips (ip, reason, failures, usernames, blocked_until)
+		SELECT ip, CASE WHEN usernames >= $3 THEN $4 ELSE $5 END, failures, usernames, $6
+		FROM offenders
+		ON CONFLICT (ip) DO UPDATE SET reason = EXCLUDED.reason, failures = EXCLUDED.failures,
+			usernames = EXCLUDED.usernames, created_at = CURRENT_TIMESTAMP, blocked_until = EXCLUDED.blocked_until
+		WHERE blocked_ips.blocked_until <= CURRENT_TIMESTAMP
+		RETURNING ip, reason, failures, usernames, created_at, blocked_until`,
+		time.Now().Add(-BruteForceWindow), MaxAuthFailures, StuffingUsernames,
+		ReasonCredentialStuffing, ReasonBruteForce, time.Now().Add(BlockCooldown))
+	if err != nil {
+		return fmt.Errorf("failed to detect brute-force attempts: %w", err)
+	}
+	var blocked []BlockedIP
+	for rows.Next() {
+		var b BlockedIP
+		if err := rows.Scan(&b.IP, &b.Reason, &b.Failures, &b.Usernames, &b.CreatedAt, &b.BlockedUntil); err != nil {
+			rows.Close()
+			return err
+		}
+		blocked = append(blocked, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(blocked) > 0 {
+		forgetBlocks()
+		alertBlocks(ctx, blocked)
+	}
+
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM auth_failures WHERE created_at < $1",
+		time.Now().Add(-authFailureRetention)); err != nil {
+		return fmt.Errorf("failed to delete old authentication failures: %w", err)
+	}
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM honeypot_hits WHERE created_at < $1",
+		time.Now().Add(-honeypotHitRetention)); err != nil {
+		return fmt.Errorf("failed to delete old honeypot hits: %w", err)
+	}
+	return nil
+}
+
+func alertBlocks(ctx context.Context, blocked []BlockedIP) {
+	rows, err := db.DB.QueryContext(ctx, "SELECT id FROM accounts WHERE role = 'admin'")
+	if err != nil {
+		log.Printf("Failed to list admins to alert of blocked IPs: %v", err)
+		return
+	}
+	var admins []int
+	for rows.Next() {
+		var admin int
+		if rows.Scan(&admin) == nil {
+			admins = append(admins, admin)
+		}
+	}
+	rows.Close()
+
+	for _, b := range blocked {
+		what := "failed sign-ins"
+		if b.Reason == ReasonCredentialStuffing {
+			what = fmt.Sprintf("failed sign-ins as %d usernames, a credential-stuffing pattern", b.Usernames)
+		}
+		body := fmt.Sprintf("%s made %d %s. It is blocked until %s.",
+			b.IP, b.Failures, what, b.BlockedUntil.UTC().Format(time.RFC1123))
+		log.Printf("Blocked %s for %s: %d failures, %d usernames", b.IP, b.Reason, b.Failures, b.Usernames)
+		for _, admin := range admins {
+			if _, err := notifications.Notify(ctx, admin, notifications.KindIPBlocked,
+				"IP blocked for brute-forcing sign-ins", body, ""); err != nil {
+				log.Printf("Failed to alert admin %d of blocked IP %s: %v", admin, b.IP, err)
+			}
+		}
+	}
+}
+
+// BlockedIPsHandler lists the IPs blocked now, newest first.
+func BlockedIPsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	rows, err := db.DB.QueryContext(r.Context(), `
+		SELECT ip, reason, failures, usernames, created_at, blocked_until FROM blocked_ips
+		WHERE blocked_until > CURRENT_TIMESTAMP ORDER BY created_at DESC`)
+	if err != nil {
+		log.Printf("Failed to list blocked IPs: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list blocked IPs"))
+		return
+	}
+	defer rows.Close()
+
+	list := []BlockedIP{}
+	for rows.Next() {
+		var b BlockedIP
+		if err := rows.Scan(&b.IP, &b.Reason, &b.Failures, &b.Usernames, &b.CreatedAt, &b.BlockedUntil); err != nil {
+			log.Printf("Failed to scan blocked IP: %v", err)
+			continue
+		}
+		list = append(list, b)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// UnblockIPHandler lifts the block on {ip} before its cooldown ends.
+// Failures before now no longer count towards a new block.
+func UnblockIPHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+	ip := r.PathValue("ip")
+	result, err := db.DB.ExecContext(r.Context(),
+		"UPDATE blocked_ips SET blocked_until = CURRENT_TIMESTAMP WHERE ip = $1 AND blocked_until > CURRENT_TIMESTAMP", ip)
+	if err != nil {
+		log.Printf("Failed to unblock %s: %v", ip, err)
+		apierror.Write(w, apierror.Internal("Failed to unblock IP"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("IP is not blocked"))
+		return
+	}
+	forgetBlocks()
+	log.Printf("Admin %s unblocked %s", admin.Username, ip)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,190 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/securecookie"
+	"allanswebterminal/sse"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var blockColumns = []string{"ip", "reason", "failures", "usernames", "created_at", "blocked_until"}
+
+func TestBlockAbusiveIPs(t *testing.T) {
+	mock := setupAdminMock(t)
+	forgetBlocks()
+	t.Cleanup(forgetBlocks)
+
+	mock.ExpectQuery("SELECT ip, blocked_until FROM blocked_ips").
+		WillReturnRows(sqlmock.NewRows([]string{"ip", "blocked_until"}).AddRow("203.0.113.9", time.Now().Add(time.Hour)))
+	mock.ExpectQuery("SELECT cidr").
+		WillReturnRows(sqlmock.NewRows([]string{"cidr", "action", "expires_at"}).
+			AddRow("192.0.2.0/24", RuleDeny, nil).
+			AddRow("192.0.2.50/32", RuleAllow, time.Now().Add(time.Hour)))
+	handler := BlockAbusiveIPs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	serve := func(ip, path string, cookie bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":5000"
+		if cookie {
+			req.AddCookie(securecookie.Cookie("user_id", "999"))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("203.0.113.9", "/api/files/list", false)
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("blocked IP: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve("203.0.113.9", "/api/admin/blocked-ips", false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("blocked IP on the admin API: status %d", rec.Code)
+	}
+
+	if rec := serve("192.0.2.1", "/", false); rec.Code != http.StatusForbidden {
+		t.Errorf("denied IP on a page: status %d", rec.Code)
+	}
+	if rec := serve("192.0.2.1", "/api/files/list", false); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "ip_denied") {
+		t.Errorf("denied IP on the API: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve("192.0.2.1", "/api/admin/ip-rules", false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("denied IP on the admin API: status %d", rec.Code)
+	}
+	// The allowed address inside the denied range passes, and its 401s
+	// are not recorded.
+	if rec := serve("192.0.2.50", "/api/files/list", true); rec.Code != http.StatusUnauthorized {
+		t.Errorf("allowed IP: status %d", rec.Code)
+	}
+
+	// A 401 counts as a failure only if the request carried credentials.
+	serve("198.51.100.1", "/api/files/list", false)
+	mock.ExpectExec("INSERT INTO auth_failures").WithArgs("198.51.100.1", "", FailureUnauthorized).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	serve("198.51.100.1", "/api/files/list", true)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHasCredentials(t *testing.T) {
+	signed := httptest.NewRequest(http.MethodGet, "/api/files/list", nil)
+	signed.AddCookie(securecookie.Cookie("user_id", "7"))
+	// A cookie this server can't decode, such as one from before a
+	// restart with a new key, is stale rather than a failed credential.
+	stale := httptest.NewRequest(http.MethodGet, "/api/files/list", nil)
+	stale.AddCookie(&http.Cookie{Name: "user_id", Value: "7"})
+	apiKey := httptest.NewRequest(http.MethodGet, "/api/files/list", nil)
+	apiKey.Header.Set("X-API-Key", "ak_x")
+
+	if !hasCredentials(signed) || hasCredentials(stale) || !hasCredentials(apiKey) {
+		t.Errorf("hasCredentials: signed %v, stale %v, API key %v",
+			hasCredentials(signed), hasCredentials(stale), hasCredentials(apiKey))
+	}
+}
+
+func TestRefreshBlocksOutlivesRequest(t *testing.T) {
+	mock := setupAdminMock(t)
+	forgetBlocks()
+	t.Cleanup(forgetBlocks)
+	mock.ExpectQuery("SELECT ip, blocked_until FROM blocked_ips").
+		WillReturnRows(sqlmock.NewRows([]string{"ip", "blocked_until"}).AddRow("203.0.113.9", time.Now().Add(time.Hour)))
+	mock.ExpectQuery("SELECT cidr").
+		WillReturnRows(sqlmock.NewRows([]string{"cidr", "action", "expires_at"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, blocked := blockedUntil(ctx, "203.0.113.9"); !blocked {
+		t.Error("blocks were not loaded for a cancelled request")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBlockAbusiveIPsStreams(t *testing.T) {
+	mock := setupAdminMock(t)
+	forgetBlocks()
+	t.Cleanup(forgetBlocks)
+	mock.ExpectQuery("SELECT ip, blocked_until FROM blocked_ips").
+		WillReturnRows(sqlmock.NewRows([]string{"ip", "blocked_until"}))
+	mock.ExpectQuery("SELECT cidr").
+		WillReturnRows(sqlmock.NewRows([]string{"cidr", "action", "expires_at"}))
+
+	// Signed-in requests go through the status recorder, which must keep
+	// event streams working.
+	handler := BlockAbusiveIPs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, err := sse.NewStream(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stream.Send(sse.Event{Name: "progress", Data: "50"})
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/operations/events", nil)
+	req.AddCookie(securecookie.Cookie("user_id", "999"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !rec.Flushed || !strings.Contains(rec.Body.String(), "event: progress") {
+		t.Errorf("status %d, flushed %v, body %q", rec.Code, rec.Flushed, rec.Body.String())
+	}
+}
+
+func TestDetectBruteForce(t *testing.T) {
+	mock := setupAdminMock(t)
+	until := time.Now().Add(BlockCooldown)
+	mock.ExpectQuery("INSERT INTO blocked_ips").
+		WithArgs(sqlmock.AnyArg(), MaxAuthFailures, StuffingUsernames, ReasonCredentialStuffing, ReasonBruteForce, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(blockColumns).
+			AddRow("203.0.113.9", ReasonCredentialStuffing, 24, 24, time.Now(), until))
+	mock.ExpectQuery("SELECT id FROM accounts WHERE role = 'admin'").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	for _, admin := range []int{1, 2} {
+		mock.ExpectQuery("INSERT INTO notifications").
+			WithArgs(admin, notifications.KindIPBlocked, "IP blocked for brute-forcing sign-ins", sqlmock.AnyArg(), "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(admin, time.Now()))
+	}
+	mock.ExpectExec("DELETE FROM auth_failures WHERE created_at <").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM honeypot_hits WHERE created_at <").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := DetectBruteForce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnblockIPHandler(t *testing.T) {
+	mock := setupAdminMock(t)
+	unblock := func(ip string, affected int64) int {
+		expectAdmin(mock)
+		mock.ExpectExec("UPDATE blocked_ips SET blocked_until").WithArgs(ip).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+		req := httptest.NewRequest(http.MethodDelete, "/api/admin/blocked-ips/"+ip, nil)
+		req.SetPathValue("ip", ip)
+		req.AddCookie(securecookie.Cookie("user_id", "1"))
+		rec := httptest.NewRecorder()
+		UnblockIPHandler(rec, req)
+		return rec.Code
+	}
+
+	if code := unblock("203.0.113.9", 1); code != http.StatusNoContent {
+		t.Errorf("unblock status = %d, want 204", code)
+	}
+	if code := unblock("198.51.100.1", 0); code != http.StatusNotFound {
+		t.Errorf("unblock of an IP not blocked status = %d, want 404", code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
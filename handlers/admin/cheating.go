@@ -0,0 +1,192 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/pagination"
+
+	"github.com/lib/pq"
+)
+
+// FlaggedGame is a finished flashcard game with answers that tripped the
+// cheating heuristics. AccountID is nil for guests.
+type FlaggedGame struct {
+	ID             int       `json:"id"`
+	AccountID      *int      `json:"account_id"`
+	Username       string    `json:"username,omitempty"`
+	CourseID       *int      `json:"course_id"`
+	Course         string    `json:"course,omitempty"`
+	TotalQuestions int       `json:"total_questions"`
+	CorrectAnswers int       `json:"correct_answers"`
+	Flags          []string  `json:"flags"`
+	CompletedAt    time.Time `json:"completed_at"`
+}
+
+// FlaggedAnswer is a saved answer that tripped the cheating heuristics.
+type FlaggedAnswer struct {
+	ID          int       `json:"id"`
+	AccountID   int       `json:"account_id"`
+	Username    string    `json:"username"`
+	FlashcardID *int      `json:"flashcard_id"`
+	Question    string    `json:"question,omitempty"`
+	TimeScore   int       `json:"time_score"`
+	Correct     bool      `json:"correct"`
+	Flags       []string  `json:"flags"`
+	AnsweredAt  time.Time `json:"answered_at"`
+}
+
+var flaggedListOptions = pagination.Options{
+	DefaultLimit: 50,
+	MaxLimit:     200,
+	Sorts:        []string{"created"},
+	DefaultSort:  "-created",
+}
+
+// flaggedFilter builds the WHERE clause shared by the flagged lists over
+// the table aliased t: flagged rows, of account_id and with flag if given.
+func flaggedFilter(w http.ResponseWriter, r *http.Request) (string, []interface{}, bool) {
+	q := r.URL.Query()
+	where, args := "t.flags <> '{}'", []interface{}{}
+	if v := q.Get("account_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			apierror.Write(w, apierror.Validation("account_id must be a positive integer"))
+			return "", nil, false
+		}
+		args = append(args, id)
+		where += fmt.Sprintf(" AND t.account_id = $%d", len(args))
+	}
+	if v := q.Get("flag"); v != "" {
+		args = append(args, v)
+		where += fmt.Sprintf(" AND $%d = ANY(t.flags)", len(args))
+	}
+	return where, args, true
+}
+
+// FlaggedGamesHandler lists finished games with flagged answers, newest
+// first. It takes account_id, flag, limit and offset.
+func FlaggedGamesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	page, apiErr := pagination.Parse(r.URL.Query(), flaggedListOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	where, args, ok := flaggedFilter(w, r)
+	if !ok {
+		return
+	}
+
+	var total int
+	if err := db.DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM games_played t WHERE "+where, args...).Scan(&total); err != nil {
+		log.Printf("Failed to count flagged games: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list flagged games"))
+		return
+	}
+
+	dir := "ASC"
+	if page.Desc {
+		dir = "DESC"
+	}
+	n := len(args)
+	rows, err := db.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT t.id, t.account_id, COALESCE(a.username, ''), t.course_id, COALESCE(c.name, ''),
+			t.total_questions, t.correct_answers, t.flags, t.completed_at
+		FROM games_played t
+		LEFT JOIN accounts a ON a.id = t.account_id
+		LEFT JOIN courses c ON c.id = t.course_id
+		WHERE %s
+		ORDER BY t.completed_at %s, t.id %s
+		LIMIT $%d OFFSET $%d
+	`, where, dir, dir, n+1, n+2), append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to list flagged games: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list flagged games"))
+		return
+	}
+	defer rows.Close()
+
+	games := []FlaggedGame{}
+	for rows.Next() {
+		var g FlaggedGame
+		if err := rows.Scan(&g.ID, &g.AccountID, &g.Username, &g.CourseID, &g.Course,
+			&g.TotalQuestions, &g.CorrectAnswers, pq.Array(&g.Flags), &g.CompletedAt); err != nil {
+			log.Printf("Failed to scan flagged game: %v", err)
+			continue
+		}
+		games = append(games, g)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(games, total, page))
+}
+
+// FlaggedAnswersHandler lists saved answers that were flagged, newest
+// first. Flagged answers are left out of card statistics. It takes
+// account_id, flag, limit and offset.
+func FlaggedAnswersHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	page, apiErr := pagination.Parse(r.URL.Query(), flaggedListOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	where, args, ok := flaggedFilter(w, r)
+	if !ok {
+		return
+	}
+
+	var total int
+	if err := db.DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM account_score t WHERE "+where, args...).Scan(&total); err != nil {
+		log.Printf("Failed to count flagged answers: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list flagged answers"))
+		return
+	}
+
+	dir := "ASC"
+	if page.Desc {
+		dir = "DESC"
+	}
+	n := len(args)
+	rows, err := db.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT t.id, t.account_id, a.username, t.flashcard_id, COALESCE(f.question, ''),
+			t.time_score, t.correct_answer, t.flags, t.answered_at
+		FROM account_score t
+		JOIN accounts a ON a.id = t.account_id
+		LEFT JOIN flashcards f ON f.id = t.flashcard_id
+		WHERE %s
+		ORDER BY t.answered_at %s, t.id %s
+		LIMIT $%d OFFSET $%d
+	`, where, dir, dir, n+1, n+2), append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to list flagged answers: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list flagged answers"))
+		return
+	}
+	defer rows.Close()
+
+	answers := []FlaggedAnswer{}
+	for rows.Next() {
+		var a FlaggedAnswer
+		if err := rows.Scan(&a.ID, &a.AccountID, &a.Username, &a.FlashcardID, &a.Question,
+			&a.TimeScore, &a.Correct, pq.Array(&a.Flags), &a.AnsweredAt); err != nil {
+			log.Printf("Failed to scan flagged answer: %v", err)
+			continue
+		}
+		answers = append(answers, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(answers, total, page))
+}
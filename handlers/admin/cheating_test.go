@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFlaggedGamesHandler(t *testing.T) {
+	mock := setupSuspensionMock(t)
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM games_played t WHERE t.flags <> '{}' AND t.account_id = \\$1 AND \\$2 = ANY\\(t.flags\\)").
+		WithArgs(2, "too_fast").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("FROM games_played t").WithArgs(2, "too_fast", 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "username", "course_id", "course",
+			"total_questions", "correct_answers", "flags", "completed_at"}).
+			AddRow(4, 2, "ana", 1, "AWS", 10, 10, "{too_fast,uniform_timing}", time.Now()))
+
+	rec := httptest.NewRecorder()
+	FlaggedGamesHandler(rec, suspensionRequest(http.MethodGet, "/api/admin/flagged-games?account_id=2&flag=too_fast", ""))
+
+	var page struct {
+		Items []FlaggedGame `json:"items"`
+		Total int           `json:"total"`
+	}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if rec.Code != http.StatusOK || page.Total != 1 || len(page.Items) != 1 || len(page.Items[0].Flags) != 2 || page.Items[0].Course != "AWS" {
+		t.Fatalf("status %d, page %+v", rec.Code, page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlaggedAnswersHandlerValidates(t *testing.T) {
+	setupSuspensionMock(t)
+	rec := httptest.NewRecorder()
+	FlaggedAnswersHandler(rec, suspensionRequest(http.MethodGet, "/api/admin/flagged-answers?account_id=x", ""))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/middleware"
+)
+
+// DebugLogHandler lists the requests captured by d, newest first. ?path=
+// keeps those whose path starts with it. It answers 404 when capturing is
+// off, which d being nil means.
+func DebugLogHandler(d *middleware.DebugLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		if d == nil {
+			apierror.Write(w, apierror.NotFound("Debug logging is off; set DEBUG_LOG_ROUTES to enable it"))
+			return
+		}
+
+		entries := d.Entries()
+		if path := r.URL.Query().Get("path"); path != "" {
+			kept := entries[:0]
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Path, path) {
+					kept = append(kept, entry)
+				}
+			}
+			entries = kept
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"routes":  d.Prefixes(),
+			"entries": entries,
+		})
+	}
+}
+
+// ClearDebugLogHandler drops every captured request.
+func ClearDebugLogHandler(d *middleware.DebugLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		if d == nil {
+			apierror.Write(w, apierror.NotFound("Debug logging is off; set DEBUG_LOG_ROUTES to enable it"))
+			return
+		}
+		d.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"allanswebterminal/middleware"
+)
+
+func TestDebugLogHandler(t *testing.T) {
+	setupMockUser(t, "admin")
+	rec := httptest.NewRecorder()
+	DebugLogHandler(nil)(rec, newRequest())
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("disabled: status = %d, want 404", rec.Code)
+	}
+
+	d := middleware.NewDebugLog(10, []string{"/api/"}, 64)
+	capture := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, path := range []string{"/api/files/list", "/api/flashcards/courses"} {
+		capture.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	setupMockUser(t, "admin")
+	req := newRequest()
+	req.URL.RawQuery = "path=/api/files/"
+	rec = httptest.NewRecorder()
+	DebugLogHandler(d)(rec, req)
+
+	var body struct {
+		Routes  []string                `json:"routes"`
+		Entries []middleware.DebugEntry `json:"entries"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusOK || len(body.Routes) != 1 || len(body.Entries) != 1 || body.Entries[0].Path != "/api/files/list" {
+		t.Errorf("status %d, body %+v", rec.Code, body)
+	}
+}
@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/handlers/files"
+)
+
+// FileEncryption reports how far stored files are from the current
+// keyring. A retired key can be removed once Keys has no count for it, and
+// the rollout is done once Plaintext is 0.
+type FileEncryption struct {
+	Enabled     bool           `json:"enabled"`
+	PrimaryKey  string         `json:"primary_key,omitempty"`
+	RetiredKeys []string       `json:"retired_keys"`
+	Plaintext   int            `json:"plaintext"`
+	Keys        map[string]int `json:"keys"`
+}
+
+// FileEncryptionHandler counts stored files by encryption key.
+func FileEncryptionHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	counts, err := files.EncryptionStatus(r.Context())
+	if err != nil {
+		log.Printf("Failed to count encrypted files: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load file encryption status"))
+		return
+	}
+	primary, retired := files.EncryptionKeys()
+	status := FileEncryption{
+		Enabled:     primary != "",
+		PrimaryKey:  primary,
+		RetiredKeys: retired,
+		Plaintext:   counts[""],
+		Keys:        map[string]int{},
+	}
+	if status.RetiredKeys == nil {
+		status.RetiredKeys = []string{}
+	}
+	for id, n := range counts {
+		if id != "" {
+			status.Keys[id] = n
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
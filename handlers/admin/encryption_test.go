@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/securecookie"
+	"allanswebterminal/vault"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFileEncryptionHandler(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	k, _ := vault.ParseKeyring("k2:" + vault.NewKey() + ",k1:" + vault.NewKey())
+	files.SetKeyring(k)
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+		files.SetKeyring(nil)
+	})
+
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "root", "admin"))
+	mock.ExpectQuery("SELECT COALESCE\\(key_id, ''\\), COUNT\\(\\*\\) FROM \\(SELECT key_id FROM user_files UNION ALL SELECT key_id FROM file_chunks\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"key_id", "count"}).
+			AddRow("", 4).AddRow("k1", 2).AddRow("k2", 10))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/file-encryption", nil)
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	rec := httptest.NewRecorder()
+	FileEncryptionHandler(rec, req)
+
+	var status FileEncryption
+	json.NewDecoder(rec.Body).Decode(&status)
+	if rec.Code != http.StatusOK || !status.Enabled || status.PrimaryKey != "k2" || status.Plaintext != 4 {
+		t.Fatalf("status %d, %+v", rec.Code, status)
+	}
+	if len(status.RetiredKeys) != 1 || status.RetiredKeys[0] != "k1" || status.Keys["k1"] != 2 || status.Keys["k2"] != 10 {
+		t.Errorf("keys = %v, counts %v", status.RetiredKeys, status.Keys)
+	}
+}
@@ -0,0 +1,157 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/middleware"
+
+	"github.com/lib/pq"
+)
+
+// ReasonHoneypot is the reason an IP that requested a decoy is blocked for.
+const ReasonHoneypot = "honeypot"
+
+// HoneypotPaths are decoy routes no real client requests: admin panels
+// and secrets of software the site does not run. Entries ending in "/"
+// take their subpaths too.
+var HoneypotPaths = []string{
+	"/wp-admin/", "/wp-login.php", "/xmlrpc.php",
+	"/.env", "/.git/", "/phpmyadmin/", "/pma/", "/admin.php",
+}
+
+// honeypotHitRetention is how long decoy hits are kept.
+const honeypotHitRetention = 30 * 24 * time.Hour
+
+// fingerprintHeaders are the request headers that tell scanning tools
+// apart, in the order they are hashed.
+var fingerprintHeaders = []string{"User-Agent", "Accept", "Accept-Language", "Accept-Encoding", "Connection"}
+
+// HoneypotHandler answers a decoy route with a plain 404, records the hit
+// with a fingerprint of the client and blocks its IP for BlockCooldown, so
+// the scanner gets nowhere on the real API either. Allowlisted IPs are
+// recorded but not blocked.
+func HoneypotHandler(w http.ResponseWriter, r *http.Request) {
+	ip := middleware.ClientIP(r)
+	fingerprint := clientFingerprint(r)
+	log.Printf("Honeypot %s %s hit by %s (fingerprint %s, %q)", r.Method, r.URL.Path, ip, fingerprint, r.UserAgent())
+
+	if db.Available() {
+		recordHoneypotHit(r, ip, fingerprint)
+	}
+	http.NotFound(w, r)
+}
+
+func recordHoneypotHit(r *http.Request, ip, fingerprint string) {
+	ctx := r.Context()
+	method, path := r.Method, r.URL.Path
+	if len(method) > 10 {
+		method = method[:10]
+	}
+	if len(path) > 255 {
+		path = path[:255]
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	if _, err := db.DB.ExecContext(ctx,
+		"INSERT INTO honeypot_hits (ip, method, path, user_agent, fingerprint) VALUES ($1, $2, $3, $4, $5)",
+		ip, method, path, userAgent, fingerprint); err != nil {
+		log.Printf("Failed to record honeypot hit from %s: %v", ip, err)
+	}
+
+	if ipRuleFor(ctx, ip) == RuleAllow {
+		return
+	}
+
+	// A block in force is extended and counts the hit; an expired one
+	// starts over.
+	var until time.Time
+	err := db.DB.QueryRowContext(ctx, `
+		INSERT INTO blocked_ips (ip, reason, failures, usernames, blocked_until)
+		VALUES ($1, $2, 1, 0, $3)
+		ON CONFLICT (ip) DO UPDATE SET
+			reason = CASE WHEN blocked_ips.blocked_until > CURRENT_TIMESTAMP THEN blocked_ips.reason ELSE EXCLUDED.reason END,
+			failures = CASE WHEN blocked_ips.blocked_until > CURRENT_TIMESTAMP THEN blocked_ips.failures + 1 ELSE 1 END,
+			usernames = CASE WHEN blocked_ips.blocked_until > CURRENT_TIMESTAMP THEN blocked_ips.usernames ELSE 0 END,
+			created_at = CASE WHEN blocked_ips.blocked_until > CURRENT_TIMESTAMP THEN blocked_ips.created_at ELSE CURRENT_TIMESTAMP END,
+			blocked_until = GREATEST(blocked_ips.blocked_until, EXCLUDED.blocked_until)
+		RETURNING blocked_until`,
+		ip, ReasonHoneypot, time.Now().Add(BlockCooldown)).Scan(&until)
+	if err != nil {
+		log.Printf("Failed to block honeypot visitor %s: %v", ip, err)
+		return
+	}
+	blockedMu.Lock()
+	if blockedIPs == nil {
+		blockedIPs = map[string]time.Time{}
+	}
+	blockedIPs[ip] = until
+	blockedMu.Unlock()
+}
+
+// clientFingerprint hashes the headers that identify a scanning tool, so
+// hits from one tool group together across IPs.
+func clientFingerprint(r *http.Request) string {
+	var b strings.Builder
+	for _, name := range fingerprintHeaders {
+		b.WriteString(r.Header.Get(name))
+		b.WriteByte(0)
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Scanner sums up the decoy hits of one fingerprint.
+type Scanner struct {
+	Fingerprint string    `json:"fingerprint"`
+	UserAgent   string    `json:"user_agent"`
+	Hits        int       `json:"hits"`
+	IPs         int       `json:"ips"`
+	Paths       []string  `json:"paths"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// HoneypotHitsHandler lists the scanners seen on the decoy routes over the
+// last 30 days, most recently seen first.
+func HoneypotHitsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	rows, err := db.DB.QueryContext(r.Context(), `
+		SELECT fingerprint, MAX(user_agent), COUNT(*), COUNT(DISTINCT ip),
+			ARRAY_AGG(DISTINCT path), MIN(created_at), MAX(created_at)
+		FROM honeypot_hits
+		GROUP BY fingerprint
+		ORDER BY MAX(created_at) DESC
+		LIMIT 100`)
+	if err != nil {
+		log.Printf("Failed to list honeypot hits: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list honeypot hits"))
+		return
+	}
+	defer rows.Close()
+
+	scanners := []Scanner{}
+	for rows.Next() {
+		var s Scanner
+		if err := rows.Scan(&s.Fingerprint, &s.UserAgent, &s.Hits, &s.IPs,
+			pq.Array(&s.Paths), &s.FirstSeen, &s.LastSeen); err != nil {
+			log.Printf("Failed to scan honeypot hits: %v", err)
+			continue
+		}
+		scanners = append(scanners, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scanners)
+}
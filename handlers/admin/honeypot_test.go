@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestHoneypotHandler(t *testing.T) {
+	mock := setupAdminMock(t)
+	useBlocks(t, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/.env", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	req.Header.Set("User-Agent", "zgrab/0.x")
+	fingerprint := clientFingerprint(req)
+	until := time.Now().Add(BlockCooldown)
+	mock.ExpectExec("INSERT INTO honeypot_hits").
+		WithArgs("203.0.113.7", http.MethodGet, "/.env", "zgrab/0.x", fingerprint).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("INSERT INTO blocked_ips").
+		WithArgs("203.0.113.7", ReasonHoneypot, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"blocked_until"}).AddRow(until))
+	rec := httptest.NewRecorder()
+	HoneypotHandler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+
+	// The block applies at once, without waiting for the cache to expire.
+	if got, blocked := blockedUntil(context.Background(), "203.0.113.7"); !blocked || !got.Equal(until) {
+		t.Errorf("blockedUntil = %v, %v; want %v, true", got, blocked, until)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHoneypotHandlerSparesAllowedIPs(t *testing.T) {
+	mock := setupAdminMock(t)
+	useBlocks(t, []ipRule{{prefix: netip.MustParsePrefix("203.0.113.0/24"), action: RuleAllow}})
+
+	req := httptest.NewRequest(http.MethodPost, "/xmlrpc.php", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	mock.ExpectExec("INSERT INTO honeypot_hits").WillReturnResult(sqlmock.NewResult(1, 1))
+	HoneypotHandler(httptest.NewRecorder(), req)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientFingerprint(t *testing.T) {
+	request := func(ip, userAgent string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/wp-login.php", nil)
+		req.RemoteAddr = ip + ":5000"
+		req.Header.Set("User-Agent", userAgent)
+		return req
+	}
+	a := clientFingerprint(request("203.0.113.7", "sqlmap/1.7"))
+	if b := clientFingerprint(request("198.51.100.1", "sqlmap/1.7")); a != b {
+		t.Errorf("one tool on two IPs has fingerprints %q and %q", a, b)
+	}
+	if b := clientFingerprint(request("203.0.113.7", "Nikto/2.5")); a == b {
+		t.Error("two tools share a fingerprint")
+	}
+	if len(a) != 16 {
+		t.Errorf("fingerprint %q is not 16 characters", a)
+	}
+}
@@ -0,0 +1,237 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/validate"
+)
+
+// Actions of an IP rule.
+const (
+	RuleAllow = "allow"
+	RuleDeny  = "deny"
+)
+
+// IPRule allows or denies an IP range. Allowed ranges are never blocked
+// for brute-forcing or honeypot hits; denied ranges are refused outright.
+type IPRule struct {
+	ID        int        `json:"id"`
+	CIDR      string     `json:"cidr"`
+	Action    string     `json:"action"`
+	Note      string     `json:"note"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type IPRuleRequest struct {
+	// CIDR is a range such as 203.0.113.0/24, or a single address.
+	CIDR   string `json:"cidr" validate:"required"`
+	Action string `json:"action" validate:"required,oneof=allow deny"`
+	Note   string `json:"note" validate:"max=255"`
+	// TTLSeconds ends the rule after that many seconds; without it the
+	// rule lasts until an admin deletes it.
+	TTLSeconds int `json:"ttl_seconds" validate:"min=1"`
+
+	prefix netip.Prefix
+}
+
+func (req *IPRuleRequest) Validate() error {
+	req.CIDR = strings.TrimSpace(req.CIDR)
+	req.Note = strings.TrimSpace(req.Note)
+	if err := validate.Struct(req); err != nil {
+		return err
+	}
+	prefix, err := parseCIDR(req.CIDR)
+	if err != nil {
+		return errors.New("cidr must be an IP address or a CIDR range such as 203.0.113.0/24")
+	}
+	req.prefix = prefix
+	return nil
+}
+
+// parseCIDR parses a range or a single address, which is a range of one.
+// Host bits are cleared, so 203.0.113.7/24 is 203.0.113.0/24.
+func parseCIDR(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// ipRule is an IPRule as matched in memory.
+type ipRule struct {
+	prefix    netip.Prefix
+	action    string
+	expiresAt *time.Time
+}
+
+func loadIPRules(ctx context.Context) ([]ipRule, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT cidr::text, action, expires_at FROM ip_rules WHERE expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []ipRule
+	for rows.Next() {
+		var cidr string
+		var rule ipRule
+		if err := rows.Scan(&cidr, &rule.action, &rule.expiresAt); err != nil {
+			return nil, err
+		}
+		if rule.prefix, err = netip.ParsePrefix(cidr); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// ipRuleFor returns the action of the rule in force for ip, or "" when no
+// rule covers it. The narrowest range wins; between ranges of one size,
+// allow wins.
+func ipRuleFor(ctx context.Context, ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	refreshBlocks(ctx)
+	blockedMu.Lock()
+	defer blockedMu.Unlock()
+	action, bits, now := "", -1, time.Now()
+	for _, rule := range ipRules {
+		if !rule.prefix.Contains(addr) || (rule.expiresAt != nil && !now.Before(*rule.expiresAt)) {
+			continue
+		}
+		if rule.prefix.Bits() > bits || (rule.prefix.Bits() == bits && rule.action == RuleAllow) {
+			action, bits = rule.action, rule.prefix.Bits()
+		}
+	}
+	return action
+}
+
+// IPRulesHandler lists the IP rules in force, newest first.
+func IPRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	rows, err := db.DB.QueryContext(r.Context(), `
+		SELECT rule.id, rule.cidr::text, rule.action, rule.note, COALESCE(a.username, ''),
+			rule.created_at, rule.expires_at
+		FROM ip_rules rule
+		LEFT JOIN accounts a ON a.id = rule.created_by
+		WHERE rule.expires_at IS NULL OR rule.expires_at > CURRENT_TIMESTAMP
+		ORDER BY rule.created_at DESC, rule.id DESC`)
+	if err != nil {
+		log.Printf("Failed to list IP rules: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list IP rules"))
+		return
+	}
+	defer rows.Close()
+
+	rules := []IPRule{}
+	for rows.Next() {
+		var rule IPRule
+		if err := rows.Scan(&rule.ID, &rule.CIDR, &rule.Action, &rule.Note, &rule.CreatedBy,
+			&rule.CreatedAt, &rule.ExpiresAt); err != nil {
+			log.Printf("Failed to scan IP rule: %v", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// CreateIPRuleHandler adds an allow or deny rule for a range. It applies
+// on this instance at once and on the others within blockCacheTTL.
+func CreateIPRuleHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req IPRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		apierror.Write(w, validate.APIError(err))
+		return
+	}
+
+	rule := IPRule{CIDR: req.prefix.String(), Action: req.Action, Note: req.Note, CreatedBy: admin.Username}
+	if req.TTLSeconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+		rule.ExpiresAt = &expiresAt
+	}
+	err := db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO ip_rules (cidr, action, note, created_by, expires_at)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		rule.CIDR, rule.Action, rule.Note, admin.ID, rule.ExpiresAt,
+	).Scan(&rule.ID, &rule.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to create IP rule: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create IP rule"))
+		return
+	}
+	forgetBlocks()
+	log.Printf("Admin %s added IP rule %d: %s %s", admin.Username, rule.ID, rule.Action, rule.CIDR)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteIPRuleHandler removes the IP rule {id}.
+func DeleteIPRuleHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid IP rule ID"))
+		return
+	}
+	result, err := db.DB.ExecContext(r.Context(), "DELETE FROM ip_rules WHERE id = $1", id)
+	if err != nil {
+		log.Printf("Failed to delete IP rule %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to delete IP rule"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("IP rule not found"))
+		return
+	}
+	forgetBlocks()
+	log.Printf("Admin %s deleted IP rule %d", admin.Username, id)
+	w.WriteHeader(http.StatusNoContent)
+}
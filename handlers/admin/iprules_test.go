@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// useBlocks serves rules and no blocks from memory for the test.
+func useBlocks(t *testing.T, rules []ipRule) {
+	t.Helper()
+	blockedMu.Lock()
+	blockedIPs, ipRules, blockedLoadedAt = nil, rules, time.Now()
+	blockedMu.Unlock()
+	t.Cleanup(func() {
+		blockedMu.Lock()
+		blockedIPs, ipRules = nil, nil
+		blockedMu.Unlock()
+		forgetBlocks()
+	})
+}
+
+func TestParseCIDR(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"203.0.113.7", "203.0.113.7/32"},
+		{"203.0.113.7/24", "203.0.113.0/24"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8::1/32", "2001:db8::/32"},
+		{"::ffff:203.0.113.7", "203.0.113.7/32"},
+		{"::ffff:203.0.113.0/120", "203.0.113.0/24"},
+	}
+	for _, tt := range tests {
+		got, err := parseCIDR(tt.in)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("parseCIDR(%q) = %v, %v; want %s", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "example.com", "203.0.113.0/33", "203.0.113"} {
+		if _, err := parseCIDR(bad); err == nil {
+			t.Errorf("parseCIDR(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestIPRuleFor(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	useBlocks(t, []ipRule{
+		{prefix: netip.MustParsePrefix("10.0.0.0/8"), action: RuleDeny},
+		{prefix: netip.MustParsePrefix("10.1.0.0/16"), action: RuleAllow},
+		{prefix: netip.MustParsePrefix("10.1.2.0/24"), action: RuleDeny},
+		{prefix: netip.MustParsePrefix("10.9.0.0/16"), action: RuleDeny},
+		{prefix: netip.MustParsePrefix("10.9.0.0/16"), action: RuleAllow},
+		{prefix: netip.MustParsePrefix("172.16.0.0/12"), action: RuleDeny, expiresAt: &expired},
+	})
+	tests := map[string]string{
+		"10.200.0.1":      RuleDeny,
+		"10.1.9.9":        RuleAllow,
+		"10.1.2.3":        RuleDeny,
+		"10.9.0.1":        RuleAllow,
+		"::ffff:10.1.9.9": RuleAllow,
+		"172.16.0.1":      "",
+		"192.0.2.1":       "",
+		"not an ip":       "",
+		"2001:db8::1":     "",
+	}
+	for ip, want := range tests {
+		if got := ipRuleFor(context.Background(), ip); got != want {
+			t.Errorf("ipRuleFor(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestCreateIPRuleHandler(t *testing.T) {
+	mock := setupAdminMock(t)
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/ip-rules", bytes.NewBufferString(body))
+		req.AddCookie(securecookie.Cookie("user_id", "1"))
+		rec := httptest.NewRecorder()
+		CreateIPRuleHandler(rec, req)
+		return rec
+	}
+
+	for _, body := range []string{
+		`{"cidr": "203.0.113.0/24", "action": "block"}`,
+		`{"cidr": "203.0.113.0/40", "action": "deny"}`,
+		`{"action": "deny"}`,
+		`{"cidr": "203.0.113.0/24", "action": "deny", "ttl_seconds": -5}`,
+	} {
+		expectAdmin(mock)
+		if rec := create(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+
+	expectAdmin(mock)
+	mock.ExpectQuery("INSERT INTO ip_rules").
+		WithArgs("203.0.113.0/24", RuleDeny, "Scanner", 1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+	rec := create(`{"cidr": "203.0.113.9/24", "action": "deny", "note": " Scanner ", "ttl_seconds": 3600}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte(`"cidr":"203.0.113.0/24"`)) || !bytes.Contains(rec.Body.Bytes(), []byte(`"expires_at":"`)) {
+		t.Errorf("body = %s", rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
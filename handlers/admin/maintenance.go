@@ -0,0 +1,227 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cache"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/templates"
+)
+
+// Site modes, from least to most locked down. In ModeReadOnly requests that
+// change data are refused; in ModeMaintenance every page and API is. Admins,
+// admin APIs, health checks and signing in are never affected, so the site
+// can still be inspected and switched back.
+const (
+	ModeNormal      = "normal"
+	ModeReadOnly    = "read_only"
+	ModeMaintenance = "maintenance"
+)
+
+const (
+	siteModeCacheKey = "site_mode"
+	// siteModeCacheTTL bounds how long an instance without a shared cache
+	// keeps serving the previous mode.
+	siteModeCacheTTL = 5 * time.Second
+	maxSiteModeChars = 500
+	// lockdownRetryAfter is the Retry-After sent with refusals, in seconds.
+	lockdownRetryAfter = "300"
+)
+
+// lockdownExempt lists paths served whatever the mode. Entries ending in
+// "/" are prefixes.
+var lockdownExempt = []string{
+	"/healthz", "/readyz", "/version", "/metrics", "/status", "/status.json", "/status/",
+	"/api/admin/", "/static/",
+	"/login", "/logout", "/api/login", "/api/passkeys/login/options", "/api/passkeys/login", "/api/check-username",
+}
+
+type SiteMode struct {
+	Mode string `json:"mode"`
+	// Message is shown to visitors while the site is locked down.
+	Message   string     `json:"message,omitempty"`
+	UpdatedBy string     `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+type SetSiteModeRequest struct {
+	Mode    string `json:"mode"`
+	Message string `json:"message"`
+}
+
+func (req *SetSiteModeRequest) Validate() error {
+	switch req.Mode {
+	case ModeNormal, ModeReadOnly, ModeMaintenance:
+	default:
+		return errors.New("mode must be normal, read_only or maintenance")
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if len(req.Message) > maxSiteModeChars {
+		return errors.New("message is too long")
+	}
+	return nil
+}
+
+// currentSiteMode returns the mode set by an admin, or ModeNormal when none
+// was set or it cannot be loaded: a database outage must not lock out
+// everyone.
+func currentSiteMode(ctx context.Context) SiteMode {
+	if !db.Available() {
+		return SiteMode{Mode: ModeNormal}
+	}
+	mode, err := cache.GetOrLoad(ctx, siteModeCacheKey, siteModeCacheTTL, func() (SiteMode, error) {
+		return loadSiteMode(ctx)
+	})
+	if err != nil {
+		log.Printf("Failed to load site mode: %v", err)
+		return SiteMode{Mode: ModeNormal}
+	}
+	return mode
+}
+
+func loadSiteMode(ctx context.Context) (SiteMode, error) {
+	var mode SiteMode
+	var updatedAt time.Time
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT s.mode, s.message, COALESCE(a.username, ''), s.updated_at
+		 FROM site_mode s LEFT JOIN accounts a ON a.id = s.updated_by`,
+	).Scan(&mode.Mode, &mode.Message, &mode.UpdatedBy, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SiteMode{Mode: ModeNormal}, nil
+	}
+	if err != nil {
+		return SiteMode{}, err
+	}
+	mode.UpdatedAt = &updatedAt
+	return mode, nil
+}
+
+// Lockdown enforces the site mode. Refused API calls and requests that are
+// not page views get a 503 error; page views in maintenance mode get the
+// maintenance page.
+func Lockdown(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isLockdownExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mode := currentSiteMode(r.Context())
+		switch mode.Mode {
+		case ModeMaintenance:
+		case ModeReadOnly:
+			if isSafeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if user, err := login.GetCurrentUser(r); err == nil && user.Role == "admin" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		refuse(w, r, mode)
+	})
+}
+
+func isLockdownExempt(path string) bool {
+	for _, exempt := range lockdownExempt {
+		if path == exempt || strings.HasSuffix(exempt, "/") && strings.HasPrefix(path, exempt) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func refuse(w http.ResponseWriter, r *http.Request, mode SiteMode) {
+	w.Header().Set("Retry-After", lockdownRetryAfter)
+	message := "The site is down for maintenance"
+	if mode.Mode == ModeReadOnly {
+		message = "The site is read-only during maintenance"
+	}
+
+	if mode.Mode == ModeMaintenance && r.Method == http.MethodGet && !strings.HasPrefix(r.URL.Path, "/api/") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := templates.Render(w, r, "maintenance", mode); err != nil {
+			log.Printf("Failed to render maintenance page: %v", err)
+			w.Write([]byte(message))
+		}
+		return
+	}
+	apierror.Write(w, apierror.Unavailable(message).WithDetails(map[string]string{
+		"mode":    mode.Mode,
+		"message": mode.Message,
+	}))
+}
+
+// SiteModeHandler reports the current site mode.
+func SiteModeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	mode, err := loadSiteMode(r.Context())
+	if err != nil {
+		log.Printf("Failed to load site mode: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load site mode"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mode)
+}
+
+// SetSiteModeHandler switches the site mode. Every instance follows within
+// siteModeCacheTTL, at once when the cache is shared.
+func SetSiteModeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetSiteModeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	mode := SiteMode{Mode: req.Mode, Message: req.Message, UpdatedBy: user.Username}
+	var updatedAt time.Time
+	err := db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO site_mode (id, mode, message, updated_by, updated_at)
+		 VALUES (TRUE, $1, $2, $3, CURRENT_TIMESTAMP)
+		 ON CONFLICT (id) DO UPDATE SET mode = EXCLUDED.mode, message = EXCLUDED.message,
+		 updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at
+		 RETURNING updated_at`,
+		req.Mode, req.Message, user.ID,
+	).Scan(&updatedAt)
+	if err != nil {
+		log.Printf("Failed to set site mode: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to set site mode"))
+		return
+	}
+	mode.UpdatedAt = &updatedAt
+	cache.Invalidate(r.Context(), siteModeCacheKey)
+	log.Printf("Site mode set to %s by %s", req.Mode, user.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mode)
+}
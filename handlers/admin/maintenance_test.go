@@ -0,0 +1,173 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/cache"
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupSiteMode(t *testing.T, mode string) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	cache.Invalidate(context.Background(), siteModeCacheKey)
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+		cache.Invalidate(context.Background(), siteModeCacheKey)
+	})
+
+	rows := sqlmock.NewRows([]string{"mode", "message", "username", "updated_at"})
+	if mode != "" {
+		rows.AddRow(mode, "Back at noon", "root", time.Now())
+	}
+	mock.ExpectQuery("FROM site_mode").WillReturnRows(rows)
+	return mock
+}
+
+func TestLockdown(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		method     string
+		path       string
+		role       string
+		wantStatus int
+	}{
+		{"No mode set", "", http.MethodPost, "/api/files/save", "", http.StatusOK},
+		{"Normal", ModeNormal, http.MethodPost, "/api/files/save", "", http.StatusOK},
+		{"Read-only allows reads", ModeReadOnly, http.MethodGet, "/api/files/list", "", http.StatusOK},
+		{"Read-only refuses writes", ModeReadOnly, http.MethodPost, "/api/files/save", "user", http.StatusServiceUnavailable},
+		{"Read-only lets admins write", ModeReadOnly, http.MethodDelete, "/api/files/x", "admin", http.StatusOK},
+		{"Maintenance refuses reads", ModeMaintenance, http.MethodGet, "/api/files/list", "", http.StatusServiceUnavailable},
+		{"Maintenance refuses pages", ModeMaintenance, http.MethodGet, "/flashcards", "user", http.StatusServiceUnavailable},
+		{"Maintenance lets admins in", ModeMaintenance, http.MethodGet, "/flashcards", "admin", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupSiteMode(t, tt.mode)
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.role != "" {
+				mock.ExpectQuery("SELECT id, username, role FROM accounts").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "ana", tt.role))
+				req.AddCookie(securecookie.Cookie("user_id", "1"))
+			}
+			rec := httptest.NewRecorder()
+			Lockdown(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Error("Retry-After not set")
+			}
+		})
+	}
+}
+
+func TestLockdownAPIError(t *testing.T) {
+	setupSiteMode(t, ModeReadOnly)
+	rec := httptest.NewRecorder()
+	Lockdown(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/flashcards/import", nil))
+
+	var body struct {
+		Error struct {
+			Code    string            `json:"code"`
+			Details map[string]string `json:"details"`
+		} `json:"error"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body.Error.Code != "service_unavailable" || body.Error.Details["mode"] != ModeReadOnly ||
+		body.Error.Details["message"] != "Back at noon" {
+		t.Errorf("unexpected error %+v", body.Error)
+	}
+}
+
+func TestLockdownExemptPaths(t *testing.T) {
+	for _, path := range []string{"/healthz", "/readyz", "/api/admin/site-mode", "/static/style.css", "/login", "/api/login"} {
+		// Exempt paths never look the mode up, so no query is expected.
+		originalDB := db.DB
+		mockDB, _, _ := sqlmock.New()
+		db.DB = mockDB
+
+		rec := httptest.NewRecorder()
+		Lockdown(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200", path, rec.Code)
+		}
+
+		db.DB = originalDB
+		mockDB.Close()
+	}
+}
+
+func TestSetSiteModeHandlerRejectsInvalidMode(t *testing.T) {
+	setupMockUser(t, "admin")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/admin/site-mode", strings.NewReader(`{"mode":"offline"}`))
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	SetSiteModeHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid mode: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestSetSiteModeHandlerStoresMode(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+
+	// A cached mode must not outlive the change.
+	cache.Default().Set(context.Background(), siteModeCacheKey, []byte(`{"mode":"normal"}`), time.Minute)
+
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "root", "admin"))
+	mock.ExpectQuery("INSERT INTO site_mode").
+		WithArgs(ModeMaintenance, "Back at noon", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/admin/site-mode",
+		strings.NewReader(`{"mode":"maintenance","message":"  Back at noon "}`))
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	SetSiteModeHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var mode SiteMode
+	json.NewDecoder(rec.Body).Decode(&mode)
+	if mode.Mode != ModeMaintenance || mode.UpdatedBy != "root" || mode.UpdatedAt == nil {
+		t.Errorf("unexpected mode %+v", mode)
+	}
+	if _, ok, _ := cache.Default().Get(context.Background(), siteModeCacheKey); ok {
+		t.Error("cached site mode was not invalidated")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,220 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+// Message is a contact form message or appeal, as exported.
+type Message struct {
+	ID        int       `json:"id"`
+	Kind      string    `json:"kind"`
+	AccountID *int      `json:"account_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Message   string    `json:"message"`
+	LegalHold bool      `json:"legal_hold"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// messageExportFlushRows is how many messages are written between flushes,
+// so a large export streams instead of piling up in the buffer.
+const messageExportFlushRows = 500
+
+// messageFilter builds the WHERE clause of an export from the from, to,
+// kind and legal_hold query parameters.
+func messageFilter(q url.Values) (string, []any, *apierror.Error) {
+	conds := []string{"TRUE"}
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	for _, bound := range []struct {
+		param, cond string
+		endOfDay    bool
+	}{{"from", "created_at >= $%d", false}, {"to", "created_at < $%d", true}} {
+		v := q.Get(bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := parseMessageTime(v, bound.endOfDay)
+		if err != nil {
+			return "", nil, apierror.Validation(bound.param + " must be an RFC 3339 time or YYYY-MM-DD")
+		}
+		add(bound.cond, t)
+	}
+	if v := q.Get("kind"); v != "" {
+		add("kind = $%d", v)
+	}
+	if v := q.Get("legal_hold"); v != "" {
+		hold, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", nil, apierror.Validation("legal_hold must be true or false")
+		}
+		add("legal_hold = $%d", hold)
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// parseMessageTime parses an RFC 3339 time or a date; with endOfDay a date
+// means the start of the next day, for exclusive upper bounds.
+func parseMessageTime(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err == nil && endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, err
+}
+
+// ExportMessagesHandler streams every message matching the filters, oldest
+// first, as CSV or as a JSON array (format=json).
+func ExportMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		apierror.Write(w, apierror.Validation("format must be csv or json"))
+		return
+	}
+	where, args, apiErr := messageFilter(r.URL.Query())
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(), `
+		SELECT id, kind, account_id, name, email, message, legal_hold, created_at
+		FROM messages WHERE `+where+` ORDER BY created_at, id`, args...)
+	if err != nil {
+		log.Printf("Failed to export messages: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to export messages"))
+		return
+	}
+	defer rows.Close()
+	log.Printf("Admin %s exported messages as %s", admin.Username, format)
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="messages.%s"`, format))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	flusher := http.NewResponseController(w)
+
+	var write func(m *Message) error
+	finish := func() {}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		out := csv.NewWriter(w)
+		out.Write([]string{"id", "kind", "account_id", "name", "email", "message", "legal_hold", "created_at"})
+		write = func(m *Message) error {
+			account := ""
+			if m.AccountID != nil {
+				account = strconv.Itoa(*m.AccountID)
+			}
+			out.Write([]string{
+				strconv.Itoa(m.ID), m.Kind, account, csvSafe(m.Name), csvSafe(m.Email), csvSafe(m.Message),
+				strconv.FormatBool(m.LegalHold), m.CreatedAt.UTC().Format(time.RFC3339),
+			})
+			out.Flush()
+			return out.Error()
+		}
+		finish = out.Flush
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		sep := "["
+		write = func(m *Message) error {
+			if _, err := fmt.Fprint(w, sep); err != nil {
+				return err
+			}
+			sep = ","
+			return enc.Encode(m)
+		}
+		finish = func() {
+			if sep == "[" {
+				fmt.Fprint(w, sep)
+			}
+			fmt.Fprintln(w, "]")
+		}
+	}
+
+	var n int
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.Kind, &m.AccountID, &m.Name, &m.Email, &m.Message, &m.LegalHold, &m.CreatedAt); err != nil {
+			log.Printf("Failed to scan message during export: %v", err)
+			break
+		}
+		if err := write(&m); err != nil {
+			// The client went away.
+			return
+		}
+		if n++; n%messageExportFlushRows == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Message export ended early: %v", err)
+	}
+	finish()
+}
+
+// csvSafe keeps spreadsheets from running message text as a formula.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// PlaceLegalHoldHandler puts the message {id} under legal hold, which keeps
+// it from being purged by the retention policy.
+func PlaceLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	setLegalHold(w, r, true)
+}
+
+// ReleaseLegalHoldHandler lifts the legal hold on the message {id}.
+func ReleaseLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
+	setLegalHold(w, r, false)
+}
+
+func setLegalHold(w http.ResponseWriter, r *http.Request, hold bool) {
+	admin, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid message ID"))
+		return
+	}
+	result, err := db.DB.ExecContext(r.Context(), "UPDATE messages SET legal_hold = $1 WHERE id = $2", hold, id)
+	if err != nil {
+		log.Printf("Failed to set legal hold on message %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to update legal hold"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Message not found"))
+		return
+	}
+	log.Printf("Admin %s set legal hold on message %d to %t", admin.Username, id, hold)
+	w.WriteHeader(http.StatusNoContent)
+}
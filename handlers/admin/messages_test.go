@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupAdminMock(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+func expectAdmin(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "root", "admin"))
+}
+
+var messageColumns = []string{"id", "kind", "account_id", "name", "email", "message", "legal_hold", "created_at"}
+
+func exportMessages(query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/messages/export?"+query, nil)
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	rec := httptest.NewRecorder()
+	ExportMessagesHandler(rec, req)
+	return rec
+}
+
+func TestExportMessagesCSV(t *testing.T) {
+	mock := setupAdminMock(t)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	expectAdmin(mock)
+	mock.ExpectQuery("FROM messages WHERE TRUE AND created_at >= \\$1 AND created_at < \\$2 AND kind = \\$3 ORDER BY created_at, id").
+		WithArgs(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), "appeal").
+		WillReturnRows(sqlmock.NewRows(messageColumns).
+			AddRow(3, "appeal", 9, "Eve", "eve@example.com", "=HYPERLINK(\"x\")", true, created))
+
+	rec := exportMessages("from=2026-03-01&to=2026-03-31&kind=appeal")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	want := "id,kind,account_id,name,email,message,legal_hold,created_at\n" +
+		"3,appeal,9,Eve,eve@example.com,\"'=HYPERLINK(\"\"x\"\")\",true,2026-03-01T12:00:00Z\n"
+	if rec.Body.String() != want {
+		t.Errorf("body =\n%s\nwant\n%s", rec.Body.String(), want)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "messages.csv") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportMessagesJSON(t *testing.T) {
+	mock := setupAdminMock(t)
+	expectAdmin(mock)
+	mock.ExpectQuery("FROM messages WHERE TRUE AND legal_hold = \\$1").WithArgs(false).
+		WillReturnRows(sqlmock.NewRows(messageColumns).
+			AddRow(1, "contact", nil, "Ann", "ann@example.com", "Hi", false, time.Now()).
+			AddRow(2, "contact", nil, "Bob", "bob@example.com", "Hello", false, time.Now()))
+
+	rec := exportMessages("format=json&legal_hold=false")
+	var list []Message
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("body %q: %v", rec.Body.String(), err)
+	}
+	if len(list) != 2 || list[1].Name != "Bob" || list[0].AccountID != nil {
+		t.Errorf("exported %+v", list)
+	}
+
+	// No messages is an empty array.
+	expectAdmin(mock)
+	mock.ExpectQuery("FROM messages").WillReturnRows(sqlmock.NewRows(messageColumns))
+	if body := strings.TrimSpace(exportMessages("format=json").Body.String()); body != "[]" {
+		t.Errorf("empty export = %q", body)
+	}
+}
+
+func TestExportMessagesValidation(t *testing.T) {
+	mock := setupAdminMock(t)
+	for _, query := range []string{"format=xml", "from=yesterday", "legal_hold=maybe"} {
+		expectAdmin(mock)
+		if rec := exportMessages(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestSetLegalHold(t *testing.T) {
+	mock := setupAdminMock(t)
+	set := func(handler http.HandlerFunc, id int, hold bool, affected int64) int {
+		expectAdmin(mock)
+		mock.ExpectExec("UPDATE messages SET legal_hold").WithArgs(hold, id).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/admin/messages/%d/legal-hold", id), nil)
+		req.SetPathValue("id", strconv.Itoa(id))
+		req.AddCookie(securecookie.Cookie("user_id", "1"))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	if code := set(PlaceLegalHoldHandler, 4, true, 1); code != http.StatusNoContent {
+		t.Errorf("place status = %d, want 204", code)
+	}
+	if code := set(ReleaseLegalHoldHandler, 4, false, 1); code != http.StatusNoContent {
+		t.Errorf("release status = %d, want 204", code)
+	}
+	if code := set(PlaceLegalHoldHandler, 99, true, 0); code != http.StatusNotFound {
+		t.Errorf("missing message status = %d, want 404", code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/pagination"
+)
+
+// QuarantinedFile is a file the malware scanner flagged.
+type QuarantinedFile struct {
+	ID        int       `json:"id"`
+	AccountID int       `json:"account_id"`
+	Username  string    `json:"username"`
+	Filename  string    `json:"filename"`
+	FileType  string    `json:"file_type"`
+	Signature string    `json:"signature"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+var quarantineListOptions = pagination.Options{
+	DefaultLimit: 50,
+	MaxLimit:     200,
+	Sorts:        []string{"scanned"},
+	DefaultSort:  "-scanned",
+}
+
+// QuarantineHandler lists quarantined files.
+func QuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	page, apiErr := pagination.Parse(r.URL.Query(), quarantineListOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	var total int
+	if err := db.DB.QueryRowContext(r.Context(),
+		"SELECT COUNT(*) FROM user_files WHERE scan_status = $1", files.ScanStatusInfected,
+	).Scan(&total); err != nil {
+		log.Printf("Failed to count quarantined files: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list quarantined files"))
+		return
+	}
+
+	dir := "ASC"
+	if page.Desc {
+		dir = "DESC"
+	}
+	rows, err := db.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT f.id, f.account_id, a.username, f.filename, f.file_type, COALESCE(f.scan_signature, ''), f.scanned_at
+		FROM user_files f
+		JOIN accounts a ON a.id = f.account_id
+		WHERE f.scan_status = $1
+		ORDER BY f.scanned_at %s, f.id %s
+		LIMIT $2 OFFSET $3
+	`, dir, dir), files.ScanStatusInfected, page.Limit, page.Offset)
+	if err != nil {
+		log.Printf("Failed to list quarantined files: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list quarantined files"))
+		return
+	}
+	defer rows.Close()
+
+	list := []QuarantinedFile{}
+	for rows.Next() {
+		var f QuarantinedFile
+		if err := rows.Scan(&f.ID, &f.AccountID, &f.Username, &f.Filename, &f.FileType, &f.Signature, &f.ScannedAt); err != nil {
+			log.Printf("Failed to scan quarantined file: %v", err)
+			continue
+		}
+		list = append(list, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(list, total, page))
+}
+
+// ReleaseQuarantineHandler releases the quarantined file {id}, a false
+// positive, marking it clean.
+func ReleaseQuarantineHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid file ID"))
+		return
+	}
+	result, err := db.DB.ExecContext(r.Context(),
+		"UPDATE user_files SET scan_status = $1, scan_signature = NULL WHERE id = $2 AND scan_status = $3",
+		files.ScanStatusClean, id, files.ScanStatusInfected)
+	if err != nil {
+		log.Printf("Failed to release quarantined file %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to release file"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Quarantined file not found"))
+		return
+	}
+	log.Printf("Admin %s released quarantined file %d", admin.Username, id)
+	w.WriteHeader(http.StatusNoContent)
+}
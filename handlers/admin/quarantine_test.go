@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestReleaseQuarantineHandler(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+
+	release := func(id int, affected int64) int {
+		mock.ExpectQuery("SELECT id, username, role FROM accounts").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "root", "admin"))
+		mock.ExpectExec("UPDATE user_files SET scan_status").
+			WithArgs(files.ScanStatusClean, id, files.ScanStatusInfected).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/admin/quarantine/%d/release", id), nil)
+		req.SetPathValue("id", strconv.Itoa(id))
+		req.AddCookie(securecookie.Cookie("user_id", "1"))
+		rec := httptest.NewRecorder()
+		ReleaseQuarantineHandler(rec, req)
+		return rec.Code
+	}
+
+	if code := release(5, 1); code != http.StatusNoContent {
+		t.Errorf("release status = %d, want 204", code)
+	}
+	if code := release(6, 0); code != http.StatusNotFound {
+		t.Errorf("release of a file not quarantined status = %d, want 404", code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,168 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cache"
+	"allanswebterminal/db"
+)
+
+const (
+	dateLayout       = "2006-01-02"
+	defaultStatsDays = 30
+	maxStatsDays     = 365
+	statsCacheTTL    = time.Minute
+)
+
+// Point is one day of a chart series. Days without activity are included
+// with a zero count so the series can be plotted directly.
+type Point struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// Stats is the payload served by StatsHandler.
+type Stats struct {
+	Days          int            `json:"days"`
+	Since         string         `json:"since"`
+	Registrations []Point        `json:"registrations"`
+	ActiveUsers   []Point        `json:"daily_active_users"`
+	GamesPlayed   []Point        `json:"games_played"`
+	FilesSaved    []Point        `json:"files_saved"`
+	Messages      []Point        `json:"messages_received"`
+	Totals        map[string]int `json:"totals"`
+	Simulator     map[string]int `json:"simulator"`
+}
+
+var seriesQueries = []struct {
+	name  string
+	query string
+}{
+	{"registrations", "SELECT DATE(created_at), COUNT(*) FROM accounts WHERE created_at >= $1 GROUP BY 1"},
+	{"daily_active_users", "SELECT day, COUNT(*) FROM account_daily_activity WHERE day >= $1 GROUP BY 1"},
+	{"games_played", "SELECT DATE(completed_at), COUNT(*) FROM games_played WHERE completed_at >= $1 GROUP BY 1"},
+	{"files_saved", "SELECT DATE(updated_at), COUNT(*) FROM user_files WHERE updated_at >= $1 GROUP BY 1"},
+	{"messages_received", "SELECT DATE(created_at), COUNT(*) FROM messages WHERE created_at >= $1 GROUP BY 1"},
+}
+
+var totalTables = []string{"accounts", "games_played", "user_files", "messages"}
+
+var simulatorTables = []string{"iam_users", "iam_roles", "iam_policies"}
+
+// StatsHandler serves usage statistics for the admin dashboard. The optional
+// days parameter (default 30, max 365) controls the length of each series.
+func StatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsDays {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("days must be between 1 and %d", maxStatsDays)))
+			return
+		}
+		days = n
+	}
+
+	key := fmt.Sprintf("admin:stats:%d", days)
+	stats, err := cache.GetOrLoad(r.Context(), key, statsCacheTTL, func() (*Stats, error) {
+		return loadStats(r.Context(), days, time.Now().UTC())
+	})
+	if err != nil {
+		log.Printf("Failed to load admin stats: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load statistics"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func loadStats(ctx context.Context, days int, now time.Time) (*Stats, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	since := today.AddDate(0, 0, -(days - 1))
+
+	series := make(map[string][]Point, len(seriesQueries))
+	for _, q := range seriesQueries {
+		points, err := querySeries(ctx, q.query, since, days)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.name, err)
+		}
+		series[q.name] = points
+	}
+
+	totals := make(map[string]int, len(totalTables))
+	for _, table := range totalTables {
+		n, err := countRows(ctx, table)
+		if err != nil {
+			return nil, fmt.Errorf("count %s: %w", table, err)
+		}
+		totals[table] = n
+	}
+
+	simulator := make(map[string]int, len(simulatorTables))
+	for _, table := range simulatorTables {
+		n, err := countRows(ctx, table)
+		if err != nil {
+			return nil, fmt.Errorf("count %s: %w", table, err)
+		}
+		simulator[table] = n
+	}
+
+	return &Stats{
+		Days:          days,
+		Since:         since.Format(dateLayout),
+		Registrations: series["registrations"],
+		ActiveUsers:   series["daily_active_users"],
+		GamesPlayed:   series["games_played"],
+		FilesSaved:    series["files_saved"],
+		Messages:      series["messages_received"],
+		Totals:        totals,
+		Simulator:     simulator,
+	}, nil
+}
+
+// querySeries runs a (day, count) aggregate and fills in missing days.
+func querySeries(ctx context.Context, query string, since time.Time, days int) ([]Point, error) {
+	rows, err := db.DB.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var day time.Time
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, err
+		}
+		counts[day.Format(dateLayout)] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	points := make([]Point, days)
+	for i := range points {
+		date := since.AddDate(0, 0, i).Format(dateLayout)
+		points[i] = Point{Date: date, Count: counts[date]}
+	}
+	return points, nil
+}
+
+// countRows is only called with the fixed table names above.
+func countRows(ctx context.Context, table string) (int, error) {
+	var n int
+	err := db.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n)
+	return n, err
+}
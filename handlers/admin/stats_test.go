@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoadStatsFillsMissingDays(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	defer func() {
+		db.DB = originalDB
+		mockDB.Close()
+	}()
+
+	now := time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	for i := range seriesQueries {
+		rows := sqlmock.NewRows([]string{"day", "count"})
+		if i == 0 {
+			rows.AddRow(day(8), 4).AddRow(day(10), 2)
+		}
+		mock.ExpectQuery("SELECT .* GROUP BY 1").WithArgs(day(8)).WillReturnRows(rows)
+	}
+	for _, table := range append(append([]string{}, totalTables...), simulatorTables...) {
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM " + table).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	}
+
+	stats, err := loadStats(context.Background(), 3, now)
+	if err != nil {
+		t.Fatalf("loadStats: %v", err)
+	}
+
+	want := []Point{{"2024-03-08", 4}, {"2024-03-09", 0}, {"2024-03-10", 2}}
+	if len(stats.Registrations) != len(want) {
+		t.Fatalf("registrations = %+v, want %+v", stats.Registrations, want)
+	}
+	for i, p := range want {
+		if stats.Registrations[i] != p {
+			t.Errorf("registrations[%d] = %+v, want %+v", i, stats.Registrations[i], p)
+		}
+	}
+	if len(stats.GamesPlayed) != 3 || stats.GamesPlayed[1].Count != 0 {
+		t.Errorf("games_played = %+v", stats.GamesPlayed)
+	}
+	if stats.Totals["accounts"] != 7 || stats.Simulator["iam_roles"] != 7 {
+		t.Errorf("totals = %v, simulator = %v", stats.Totals, stats.Simulator)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestStatsHandlerRejectsInvalidDays(t *testing.T) {
+	setupMockUser(t, "admin")
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/stats?days=1000", nil)
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	rec := httptest.NewRecorder()
+
+	StatsHandler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,260 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cache"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/pagination"
+	"allanswebterminal/validate"
+)
+
+type SuspendRequest struct {
+	Reason string `json:"reason" validate:"required,max=1000"`
+	// ExpiresAt ends the suspension; without it the account is banned until
+	// an admin lifts it.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (req *SuspendRequest) Validate(now time.Time) error {
+	req.Reason = strings.TrimSpace(req.Reason)
+	if err := validate.Struct(req); err != nil {
+		return err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+var suspensionListOptions = pagination.Options{
+	DefaultLimit: 50,
+	MaxLimit:     200,
+	Sorts:        []string{"created"},
+	DefaultSort:  "-created",
+}
+
+// SuspendAccountHandler suspends the account {id}: it can no longer sign in,
+// its sessions are refused and its public content is hidden. A suspension
+// already in force is replaced. Admins cannot be suspended, so the site
+// always keeps someone able to lift one.
+func SuspendAccountHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+	accountID, ok := pathAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	var req SuspendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := req.Validate(time.Now()); err != nil {
+		apierror.Write(w, validate.APIError(err))
+		return
+	}
+
+	var username, role string
+	err := db.DB.QueryRowContext(r.Context(),
+		"SELECT username, COALESCE(role, 'user') FROM accounts WHERE id = $1", accountID,
+	).Scan(&username, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Account not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load account %d: %v", accountID, err)
+		apierror.Write(w, apierror.Internal("Failed to suspend account"))
+		return
+	}
+	if role == "admin" {
+		apierror.Write(w, apierror.Forbidden("Admin accounts cannot be suspended"))
+		return
+	}
+
+	s, err := suspend(r.Context(), accountID, admin.ID, req)
+	if err != nil {
+		log.Printf("Failed to suspend account %d: %v", accountID, err)
+		apierror.Write(w, apierror.Internal("Failed to suspend account"))
+		return
+	}
+	s.Username = username
+	s.SuspendedBy = admin.Username
+	cache.Invalidate(r.Context(), login.SuspensionCacheKey(accountID))
+	log.Printf("Account %s suspended by %s", username, admin.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// suspend lifts any suspension in force for accountID and records the new
+// one, in a transaction so an account never has two.
+func suspend(ctx context.Context, accountID, adminID int, req SuspendRequest) (*login.Suspension, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE account_suspensions sus SET lifted_at = CURRENT_TIMESTAMP, lifted_by = $2
+		 WHERE sus.account_id = $1 AND `+login.SuspensionInForce,
+		accountID, adminID)
+	if err != nil {
+		return nil, err
+	}
+	s := login.Suspension{AccountID: accountID, Reason: req.Reason, ExpiresAt: req.ExpiresAt}
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO account_suspensions (account_id, reason, suspended_by, expires_at)
+		 VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		accountID, req.Reason, adminID, req.ExpiresAt,
+	).Scan(&s.ID, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, tx.Commit()
+}
+
+// LiftSuspensionHandler ends the suspension in force for the account {id}
+// and notifies it.
+func LiftSuspensionHandler(w http.ResponseWriter, r *http.Request) {
+	admin, ok := currentAdmin(w, r)
+	if !ok {
+		return
+	}
+	accountID, ok := pathAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := db.DB.ExecContext(r.Context(),
+		`UPDATE account_suspensions sus SET lifted_at = CURRENT_TIMESTAMP, lifted_by = $2
+		 WHERE sus.account_id = $1 AND `+login.SuspensionInForce,
+		accountID, admin.ID)
+	if err != nil {
+		log.Printf("Failed to lift suspension of account %d: %v", accountID, err)
+		apierror.Write(w, apierror.Internal("Failed to lift suspension"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Account is not suspended"))
+		return
+	}
+	cache.Invalidate(r.Context(), login.SuspensionCacheKey(accountID))
+	log.Printf("Suspension of account %d lifted by %s", accountID, admin.Username)
+
+	_, err = notifications.Notify(r.Context(), accountID, notifications.KindAdminReply,
+		"Suspension lifted", "Your account was reinstated by an administrator.", "/projects")
+	if err != nil {
+		log.Printf("Lift suspension: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SuspensionsHandler lists suspensions with their appeals, newest first.
+// Query parameters: limit, offset, sort (created, "-" for descending),
+// account_id and active (true for suspensions in force only).
+func SuspensionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	page, apiErr := pagination.Parse(q, suspensionListOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	where, args := "TRUE", []interface{}{}
+	if v := q.Get("account_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			apierror.Write(w, apierror.Validation("account_id must be a positive integer"))
+			return
+		}
+		args = append(args, id)
+		where += fmt.Sprintf(" AND sus.account_id = $%d", len(args))
+	}
+	if v := q.Get("active"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			apierror.Write(w, apierror.Validation("active must be true or false"))
+			return
+		}
+		if active {
+			where += " AND " + login.SuspensionInForce
+		} else {
+			where += " AND NOT (" + login.SuspensionInForce + ")"
+		}
+	}
+
+	var total int
+	if err := db.DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM account_suspensions sus WHERE "+where, args...).Scan(&total); err != nil {
+		log.Printf("Failed to count suspensions: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list suspensions"))
+		return
+	}
+
+	dir := "ASC"
+	if page.Desc {
+		dir = "DESC"
+	}
+	n := len(args)
+	rows, err := db.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT sus.id, sus.account_id, a.username, sus.reason, COALESCE(b.username, ''),
+			sus.expires_at, sus.created_at, sus.lifted_at, sus.appeal_message_id, COALESCE(m.message, '')
+		FROM account_suspensions sus
+		JOIN accounts a ON a.id = sus.account_id
+		LEFT JOIN accounts b ON b.id = sus.suspended_by
+		LEFT JOIN messages m ON m.id = sus.appeal_message_id
+		WHERE %s
+		ORDER BY sus.created_at %s, sus.id %s
+		LIMIT $%d OFFSET $%d
+	`, where, dir, dir, n+1, n+2), append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to list suspensions: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list suspensions"))
+		return
+	}
+	defer rows.Close()
+
+	suspensions := []login.Suspension{}
+	for rows.Next() {
+		var s login.Suspension
+		if err := rows.Scan(&s.ID, &s.AccountID, &s.Username, &s.Reason, &s.SuspendedBy,
+			&s.ExpiresAt, &s.CreatedAt, &s.LiftedAt, &s.AppealMessageID, &s.Appeal); err != nil {
+			log.Printf("Failed to scan suspension: %v", err)
+			continue
+		}
+		suspensions = append(suspensions, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(suspensions, total, page))
+}
+
+func pathAccountID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid account ID"))
+		return 0, false
+	}
+	return id, true
+}
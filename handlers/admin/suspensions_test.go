@@ -0,0 +1,157 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupSuspensionMock(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "root", "admin"))
+	return mock
+}
+
+func suspensionRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.SetPathValue("id", "2")
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	return req
+}
+
+func TestSuspendRequestValidate(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name    string
+		req     SuspendRequest
+		wantErr string
+	}{
+		{"Ban", SuspendRequest{Reason: " spam "}, ""},
+		{"Suspension", SuspendRequest{Reason: "spam", ExpiresAt: &future}, ""},
+		{"No reason", SuspendRequest{Reason: "  "}, "reason is required"},
+		{"Too long", SuspendRequest{Reason: strings.Repeat("x", 1001)}, "reason must be at most 1000 characters long"},
+		{"Expired", SuspendRequest{Reason: "spam", ExpiresAt: &past}, "expires_at must be in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate(now)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSuspendAccountHandler(t *testing.T) {
+	mock := setupSuspensionMock(t)
+	mock.ExpectQuery("SELECT username, COALESCE").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "role"}).AddRow("ana", "user"))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE account_suspensions").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO account_suspensions").WithArgs(2, "spam", 1, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, time.Now()))
+	mock.ExpectCommit()
+
+	rec := httptest.NewRecorder()
+	SuspendAccountHandler(rec, suspensionRequest(http.MethodPost, "/api/admin/accounts/2/suspend", `{"reason":"spam"}`))
+
+	var s login.Suspension
+	json.NewDecoder(rec.Body).Decode(&s)
+	if rec.Code != http.StatusCreated || s.ID != 5 || s.Username != "ana" || s.SuspendedBy != "root" || s.ExpiresAt != nil {
+		t.Fatalf("status %d, suspension %+v", rec.Code, s)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSuspendAccountHandlerRefusesAdmins(t *testing.T) {
+	mock := setupSuspensionMock(t)
+	mock.ExpectQuery("SELECT username, COALESCE").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "role"}).AddRow("boss", "admin"))
+
+	rec := httptest.NewRecorder()
+	SuspendAccountHandler(rec, suspensionRequest(http.MethodPost, "/api/admin/accounts/2/suspend", `{"reason":"spam"}`))
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLiftSuspensionHandler(t *testing.T) {
+	t.Run("Lifted", func(t *testing.T) {
+		mock := setupSuspensionMock(t)
+		mock.ExpectExec("UPDATE account_suspensions").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("INSERT INTO notifications").WithArgs(2, "admin_reply", "Suspension lifted", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+
+		rec := httptest.NewRecorder()
+		LiftSuspensionHandler(rec, suspensionRequest(http.MethodDelete, "/api/admin/accounts/2/suspension", ""))
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("status = %d, body %s", rec.Code, rec.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Not suspended", func(t *testing.T) {
+		mock := setupSuspensionMock(t)
+		mock.ExpectExec("UPDATE account_suspensions").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		rec := httptest.NewRecorder()
+		LiftSuspensionHandler(rec, suspensionRequest(http.MethodDelete, "/api/admin/accounts/2/suspension", ""))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, body %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestSuspensionsHandler(t *testing.T) {
+	mock := setupSuspensionMock(t)
+	mock.ExpectQuery("SELECT COUNT").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("LEFT JOIN messages m").WithArgs(2, 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "username", "reason", "suspended_by",
+			"expires_at", "created_at", "lifted_at", "appeal_message_id", "message"}).
+			AddRow(5, 2, "ana", "spam", "root", nil, time.Now(), nil, 9, "Sorry"))
+
+	rec := httptest.NewRecorder()
+	SuspensionsHandler(rec, suspensionRequest(http.MethodGet, "/api/admin/suspensions?account_id=2&active=true", ""))
+
+	var page struct {
+		Items []login.Suspension `json:"items"`
+		Total int                `json:"total"`
+	}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if rec.Code != http.StatusOK || page.Total != 1 || len(page.Items) != 1 || page.Items[0].Appeal != "Sorry" {
+		t.Fatalf("status %d, page %+v", rec.Code, page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/avatars"
+	"allanswebterminal/pagination"
+)
+
+type UserSummary struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	// AvatarURL is empty for accounts without an avatar.
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+var userSortColumns = map[string]string{
+	"username": "a.username",
+	"created":  "a.created_at",
+}
+
+var userListOptions = pagination.Options{
+	DefaultLimit: 50,
+	MaxLimit:     200,
+	Sorts:        []string{"username", "created"},
+	DefaultSort:  "-created",
+}
+
+// UsersHandler lists accounts with their avatars. Query parameters: limit,
+// offset, sort (username or created, "-" for descending; default -created)
+// and q (username substring, case-insensitive).
+func UsersHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	page, apiErr := pagination.Parse(q, userListOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	where, args := "TRUE", []interface{}{}
+	if search := q.Get("q"); search != "" {
+		args = append(args, pagination.LikePattern(search))
+		where = "a.username ILIKE $1"
+	}
+	var total int
+	if err := db.DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM accounts a WHERE "+where, args...).Scan(&total); err != nil {
+		log.Printf("Failed to count users: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list users"))
+		return
+	}
+
+	dir := "ASC"
+	if page.Desc {
+		dir = "DESC"
+	}
+	n := len(args)
+	rows, err := db.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT a.id, a.username, COALESCE(a.role, 'user'), a.created_at, f.updated_at
+		FROM accounts a
+		LEFT JOIN user_files f ON f.account_id = a.id AND f.filename = $%d AND f.file_type = $%d
+		WHERE %s
+		ORDER BY %s %s, a.id %s
+		LIMIT $%d OFFSET $%d
+	`, n+1, n+2, where, userSortColumns[page.Sort], dir, dir, n+3, n+4),
+		append(args, avatars.Filename, avatars.FileType, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to list users: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list users"))
+		return
+	}
+	defer rows.Close()
+
+	users := []UserSummary{}
+	for rows.Next() {
+		var user UserSummary
+		var avatarUpdated *time.Time
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt, &avatarUpdated); err != nil {
+			log.Printf("Failed to scan user: %v", err)
+			continue
+		}
+		if avatarUpdated != nil {
+			user.AvatarURL = avatars.URL(user.ID, *avatarUpdated)
+		}
+		users = append(users, user)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(users, total, page))
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/avatars"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestUsersHandler(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "root", "admin"))
+	mock.ExpectQuery("SELECT COUNT").WithArgs("%an%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("LEFT JOIN user_files").
+		WithArgs("%an%", avatars.Filename, avatars.FileType, 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "created_at", "updated_at"}).
+			AddRow(2, "ana", "user", created, time.Unix(100, 0)).
+			AddRow(3, "jan", "user", created, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/users?q=an", nil)
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	rec := httptest.NewRecorder()
+	UsersHandler(rec, req)
+
+	var page struct {
+		Items []UserSummary `json:"items"`
+		Total int           `json:"total"`
+	}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if rec.Code != http.StatusOK || page.Total != 2 || len(page.Items) != 2 {
+		t.Fatalf("status %d, page %+v", rec.Code, page)
+	}
+	if page.Items[0].AvatarURL != "/avatars/2?v=100" || page.Items[1].AvatarURL != "" {
+		t.Errorf("avatar URLs = %q, %q", page.Items[0].AvatarURL, page.Items[1].AvatarURL)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,244 @@
+// Package apikeys issues API keys for the public API and authenticates
+// requests that carry one. Keys are shown once when created; only their
+// SHA-256 hash is stored.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/validate"
+)
+
+// Header carries the key. "Authorization: Bearer <key>" is accepted too.
+const Header = "X-API-Key"
+
+const (
+	keyPrefix = "awt_"
+	// prefixLength is how much of a key is stored in the clear, to tell
+	// keys apart in listings and to key rate limits.
+	prefixLength = len(keyPrefix) + 8
+	// maxKeysPerAccount bounds the active keys an account can hold.
+	maxKeysPerAccount = 10
+)
+
+// APIKey is a key as listed to its owner. Key is only set in the response
+// that created it.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type CreateKeyRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// newKey returns a random key.
+func newKey() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return keyPrefix + hex.EncodeToString(b)
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestKey returns the key sent with r, or "".
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get(Header); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// RateLimitKey names the rate-limit bucket of the key sent with r, or
+// returns "" when there is none. The key is not checked; requests with
+// made-up keys still draw from their client IP's bucket.
+func RateLimitKey(r *http.Request) string {
+	key := requestKey(r)
+	if !strings.HasPrefix(key, keyPrefix) || len(key) < prefixLength {
+		return ""
+	}
+	return "key:" + key[:prefixLength]
+}
+
+type contextKey struct{}
+
+// AccountID returns the account whose key authenticated the request, or 0.
+func AccountID(ctx context.Context) int {
+	id, _ := ctx.Value(contextKey{}).(int)
+	return id
+}
+
+// setCORSHeaders lets pages on any origin call the public API, since keys
+// rather than cookies authenticate it.
+func setCORSHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, "+Header)
+}
+
+// PreflightHandler answers CORS preflight requests for the public API.
+func PreflightHandler(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Require rejects requests without a valid, unrevoked key with 401 and
+// records when each key was last used.
+func Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setCORSHeaders(w)
+		key := requestKey(r)
+		if key == "" {
+			apierror.Write(w, apierror.New(apierror.CodeUnauthorized, "An API key is required in the "+Header+" header"))
+			return
+		}
+		var accountID int
+		err := db.DB.QueryRowContext(r.Context(),
+			`UPDATE api_keys k SET last_used_at = CURRENT_TIMESTAMP
+			 WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND `+login.NotSuspended("k.account_id")+`
+			 RETURNING k.account_id`,
+			hashKey(key),
+		).Scan(&accountID)
+		if errors.Is(err, sql.ErrNoRows) {
+			apierror.Write(w, apierror.New(apierror.CodeUnauthorized, "Invalid or revoked API key"))
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to check API key: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to check API key"))
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, accountID)))
+	}
+}
+
+// ListKeysHandler lists the caller's active keys.
+func ListKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT id, name, prefix, created_at, last_used_at FROM api_keys
+		 WHERE account_id = $1 AND revoked_at IS NULL ORDER BY created_at, id`,
+		user.ID)
+	if err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list API keys"))
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			log.Printf("Failed to read API key: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to list API keys"))
+			return
+		}
+		keys = append(keys, k)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateKeyHandler issues a key to the caller. The key is in the response
+// and cannot be retrieved again.
+func CreateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	key := newKey()
+	k := APIKey{Name: strings.TrimSpace(req.Name), Prefix: key[:prefixLength], Key: key}
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO api_keys (account_id, name, prefix, key_hash)
+		 SELECT $1, $2, $3, $4
+		 WHERE (SELECT COUNT(*) FROM api_keys WHERE account_id = $1 AND revoked_at IS NULL) < $5
+		 RETURNING id, created_at`,
+		user.ID, k.Name, k.Prefix, hashKey(key), maxKeysPerAccount,
+	).Scan(&k.ID, &k.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("You already have "+strconv.Itoa(maxKeysPerAccount)+" API keys; revoke one first"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create API key: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create API key"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(k)
+}
+
+// RevokeKeyHandler revokes one of the caller's keys. Requests using it are
+// refused from then on.
+func RevokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid API key ID"))
+		return
+	}
+
+	res, err := db.DB.ExecContext(r.Context(),
+		"UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND account_id = $2 AND revoked_at IS NULL",
+		id, user.ID)
+	if err != nil {
+		log.Printf("Failed to revoke API key %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to revoke API key"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("API key not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
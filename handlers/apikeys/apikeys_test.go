@@ -0,0 +1,160 @@
+package apikeys
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+func expectUser(mock sqlmock.Sqlmock, id int) {
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(id, "ana", "user"))
+}
+
+func TestRequire(t *testing.T) {
+	key := newKey()
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		found      bool
+		wantStatus int
+	}{
+		{"Missing", "", "", false, http.StatusUnauthorized},
+		{"Valid", Header, key, true, http.StatusOK},
+		{"Bearer", "Authorization", "Bearer " + key, true, http.StatusOK},
+		{"Revoked or unknown", Header, key, false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			if tt.header != "" {
+				rows := sqlmock.NewRows([]string{"account_id"})
+				if tt.found {
+					rows.AddRow(4)
+				}
+				mock.ExpectQuery("UPDATE api_keys k SET last_used_at").WithArgs(hashKey(key)).WillReturnRows(rows)
+			}
+
+			var account int
+			next := func(w http.ResponseWriter, r *http.Request) { account = AccountID(r.Context()) }
+			req := httptest.NewRequest(http.MethodGet, "/api/public/v1/decks", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rr := httptest.NewRecorder()
+			Require(next)(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d: %s", rr.Code, rr.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && account != 4 {
+				t.Errorf("AccountID = %d", account)
+			}
+			if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+				t.Error("missing CORS header")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCreateKeyHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	expectUser(mock, 4)
+	mock.ExpectQuery("INSERT INTO api_keys").
+		WithArgs(4, "study app", sqlmock.AnyArg(), sqlmock.AnyArg(), maxKeysPerAccount).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(9, time.Now()))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/api-keys", strings.NewReader(`{"name": " study app "}`))
+	req.AddCookie(securecookie.Cookie("user_id", "4"))
+	rr := httptest.NewRecorder()
+	CreateKeyHandler(rr, req)
+
+	var k APIKey
+	json.NewDecoder(rr.Body).Decode(&k)
+	if rr.Code != http.StatusCreated || k.ID != 9 || !strings.HasPrefix(k.Key, keyPrefix) || k.Prefix != k.Key[:prefixLength] {
+		t.Errorf("status = %d, key %+v", rr.Code, k)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateKeyHandlerLimit(t *testing.T) {
+	mock := setupMockDB(t)
+	expectUser(mock, 4)
+	mock.ExpectQuery("INSERT INTO api_keys").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/api-keys", strings.NewReader(`{"name": "one too many"}`))
+	req.AddCookie(securecookie.Cookie("user_id", "4"))
+	rr := httptest.NewRecorder()
+	CreateKeyHandler(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("status = %d", rr.Code)
+	}
+}
+
+func TestRevokeKeyHandler(t *testing.T) {
+	for _, tt := range []struct {
+		affected   int64
+		wantStatus int
+	}{{1, http.StatusNoContent}, {0, http.StatusNotFound}} {
+		mock := setupMockDB(t)
+		expectUser(mock, 4)
+		mock.ExpectExec("UPDATE api_keys SET revoked_at").WithArgs(9, 4).
+			WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+		req := httptest.NewRequest(http.MethodDelete, "/api/api-keys/9", nil)
+		req.SetPathValue("id", "9")
+		req.AddCookie(securecookie.Cookie("user_id", "4"))
+		rr := httptest.NewRecorder()
+		RevokeKeyHandler(rr, req)
+
+		if rr.Code != tt.wantStatus {
+			t.Errorf("affected %d: status = %d", tt.affected, rr.Code)
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	key := newKey()
+	req := httptest.NewRequest(http.MethodGet, "/api/public/v1/decks", nil)
+	if RateLimitKey(req) != "" {
+		t.Error("request without a key has a bucket")
+	}
+	req.Header.Set(Header, key)
+	if got := RateLimitKey(req); got != "key:"+key[:prefixLength] {
+		t.Errorf("RateLimitKey() = %q", got)
+	}
+	req.Header.Set(Header, "not-a-key")
+	if RateLimitKey(req) != "" {
+		t.Error("malformed key has a bucket")
+	}
+}
@@ -0,0 +1,150 @@
+// Package avatars stores account profile images in the file store and serves
+// them at /avatars/{id}.
+package avatars
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/login"
+)
+
+// An avatar is the account's file named Filename with type FileType. The
+// file store holds text, so the PNG is kept base64-encoded.
+const (
+	Filename = "avatar.png"
+	FileType = "avatar"
+)
+
+// cacheControl lets browsers and proxies keep an avatar for a day; URL adds
+// the upload time, so a new avatar is fetched at once.
+const cacheControl = "public, max-age=86400"
+
+type UploadRequest struct {
+	// Image is a PNG, JPEG or GIF, base64-encoded in JSON.
+	Image []byte `json:"image"`
+}
+
+type AvatarResponse struct {
+	AvatarURL string `json:"avatar_url"`
+}
+
+// URL is where the avatar of accountID, uploaded at updatedAt, is served.
+func URL(accountID int, updatedAt time.Time) string {
+	return fmt.Sprintf("/avatars/%d?v=%d", accountID, updatedAt.Unix())
+}
+
+// UploadHandler replaces the caller's avatar with the uploaded image,
+// cropped to a square and scaled down to Size pixels a side.
+func UploadHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req UploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	avatar, err := Process(req.Image)
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	file := files.UserFile{
+		AccountID: user.ID,
+		Filename:  Filename,
+		Content:   base64.StdEncoding.EncodeToString(avatar),
+		FileType:  FileType,
+	}
+	if err := files.Save(r.Context(), &file); err != nil {
+		log.Printf("Failed to save avatar for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save avatar"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AvatarResponse{AvatarURL: URL(user.ID, file.UpdatedAt)})
+}
+
+// DeleteHandler removes the caller's avatar.
+func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	deleted, err := files.Delete(r.Context(), user.ID, Filename)
+	if err != nil {
+		log.Printf("Failed to delete avatar for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to delete avatar"))
+		return
+	}
+	if !deleted {
+		apierror.Write(w, apierror.NotFound("Avatar not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeHandler serves the avatar of the account {id}. Avatars are public,
+// except those of suspended accounts.
+// Responses carry an ETag and Last-Modified, so revalidating an unchanged
+// avatar costs a 304.
+func ServeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid account ID"))
+		return
+	}
+
+	var content string
+	var updatedAt time.Time
+	var keyID sql.NullString
+	var dataKey []byte
+	err = db.DB.QueryRowContext(r.Context(),
+		"SELECT f.content, f.updated_at, f.key_id, f.data_key FROM user_files f WHERE f.account_id = $1 AND f.filename = $2 AND f.file_type = $3 AND "+files.NotQuarantined("f")+" AND "+login.NotSuspended("f.account_id"),
+		id, Filename, FileType,
+	).Scan(&content, &updatedAt, &keyID, &dataKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Avatar not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load avatar for account %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to load avatar"))
+		return
+	}
+	if content, err = files.Decrypt(id, Filename, content, keyID, dataKey); err != nil {
+		log.Printf("Failed to decrypt avatar of account %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to load avatar"))
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		log.Printf("Avatar of account %d is not valid base64: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to load avatar"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%d"`, id, updatedAt.UnixNano()))
+	http.ServeContent(w, r, Filename, updatedAt, bytes.NewReader(data))
+}
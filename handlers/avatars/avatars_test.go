@@ -0,0 +1,167 @@
+package avatars
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessCropsAndScales(t *testing.T) {
+	// A 600x300 image: red on the left, blue on the right, green in the
+	// middle 300 columns that survive the crop.
+	src := image.NewRGBA(image.Rect(0, 0, 600, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x++ {
+			c := color.RGBA{0, 255, 0, 255}
+			if x < 150 {
+				c = color.RGBA{255, 0, 0, 255}
+			} else if x >= 450 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, src, nil)
+
+	for name, data := range map[string][]byte{"png": encodePNG(t, src), "jpeg": jpg.Bytes()} {
+		out, err := Process(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		img, format, err := image.Decode(bytes.NewReader(out))
+		if err != nil || format != "png" {
+			t.Fatalf("%s: output is not a PNG: %v", name, err)
+		}
+		if b := img.Bounds(); b.Dx() != Size || b.Dy() != Size {
+			t.Errorf("%s: size = %v, want %dx%d", name, b, Size, Size)
+		}
+		for _, x := range []int{0, Size - 1} {
+			r, g, b, _ := img.At(x, Size/2).RGBA()
+			if g>>8 < 200 || r>>8 > 50 || b>>8 > 50 {
+				t.Errorf("%s: pixel %d = %d,%d,%d, want the green middle", name, x, r>>8, g>>8, b>>8)
+			}
+		}
+	}
+}
+
+func TestProcessKeepsSmallImages(t *testing.T) {
+	out, err := Process(encodePNG(t, image.NewRGBA(image.Rect(0, 0, 40, 64))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, _ := image.DecodeConfig(bytes.NewReader(out))
+	if cfg.Width != 40 || cfg.Height != 40 {
+		t.Errorf("size = %dx%d, want 40x40", cfg.Width, cfg.Height)
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	// A PNG header claiming 5000x5000 pixels.
+	huge := encodePNG(t, image.NewGray(image.Rect(0, 0, 1, 1)))
+	huge = append([]byte(nil), huge...)
+	copy(huge[16:24], []byte{0, 0, 0x13, 0x88, 0, 0, 0x13, 0x88})
+	binary.BigEndian.PutUint32(huge[29:33], crc32.ChecksumIEEE(huge[12:29]))
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "required"},
+		{"not an image", []byte("<svg onload=alert(1)>"), "PNG, JPEG or GIF"},
+		{"too large", make([]byte, MaxUpload+1), "at most 5 MB"},
+		{"too many pixels", huge, "4096x4096"},
+	}
+	for _, tt := range tests {
+		if _, err := Process(tt.data); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestServeHandler(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+
+	avatar := encodePNG(t, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	updated := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT f.content, f.updated_at, f.key_id, f.data_key FROM user_files f").
+			WithArgs(7, Filename, FileType).
+			WillReturnRows(sqlmock.NewRows([]string{"content", "updated_at", "key_id", "data_key"}).
+				AddRow(base64.StdEncoding.EncodeToString(avatar), updated, nil, nil))
+	}
+	mock.ExpectQuery("SELECT f.content, f.updated_at, f.key_id, f.data_key FROM user_files f").
+		WithArgs(8, Filename, FileType).
+		WillReturnRows(sqlmock.NewRows([]string{"content", "updated_at", "key_id", "data_key"}))
+
+	serve := func(id, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/avatars/"+id, nil)
+		req.SetPathValue("id", id)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		ServeHandler(rec, req)
+		return rec
+	}
+
+	rec := serve("7", "")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), avatar) {
+		t.Fatalf("status = %d, body %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec.Header().Get("Content-Type") != "image/png" || rec.Header().Get("Cache-Control") != cacheControl {
+		t.Errorf("headers = %v", rec.Header())
+	}
+	if rec := serve("7", rec.Header().Get("ETag")); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation: status = %d, want 304", rec.Code)
+	}
+	if rec := serve("8", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("no avatar: status = %d, want 404", rec.Code)
+	}
+	if rec := serve("x", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad ID: status = %d, want 400", rec.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestURLChangesWithUpload(t *testing.T) {
+	first := URL(7, time.Unix(100, 0))
+	if first != "/avatars/7?v=100" || URL(7, time.Unix(200, 0)) == first {
+		t.Errorf("URL = %q", first)
+	}
+}
@@ -0,0 +1,86 @@
+package avatars
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+)
+
+const (
+	// Size is the width and height of stored avatars. Smaller images are
+	// kept at their own size rather than scaled up.
+	Size = 256
+	// MaxUpload bounds uploaded images, before resizing.
+	MaxUpload = 5 << 20
+	// maxSourcePixels refuses images that are small files but decode to
+	// huge bitmaps.
+	maxSourcePixels = 4096 * 4096
+)
+
+// Process decodes a PNG, JPEG or GIF upload, crops it to a centred square
+// and scales it down to at most Size pixels a side, returning a PNG.
+func Process(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("image is required")
+	}
+	if len(data) > MaxUpload {
+		return nil, fmt.Errorf("image must be at most %d MB", MaxUpload>>20)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("image must be a PNG, JPEG or GIF")
+	}
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, errors.New("image must be at most 4096x4096 pixels")
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("image must be a PNG, JPEG or GIF")
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, resize(squareCrop(src.Bounds()), src)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// squareCrop returns the largest square centred in b.
+func squareCrop(b image.Rectangle) image.Rectangle {
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// resize scales the square crop of src down to at most Size pixels a side.
+// Each output pixel is the average of the source pixels it covers, which
+// keeps downscaled photos free of aliasing.
+func resize(crop image.Rectangle, src image.Image) *image.RGBA {
+	side := crop.Dx()
+	n := min(side, Size)
+	dst := image.NewRGBA(image.Rect(0, 0, n, n))
+	for y := 0; y < n; y++ {
+		y0, y1 := crop.Min.Y+y*side/n, crop.Min.Y+(y+1)*side/n
+		for x := 0; x < n; x++ {
+			x0, x1 := crop.Min.X+x*side/n, crop.Min.X+(x+1)*side/n
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / count), G: uint16(g / count), B: uint16(b / count), A: uint16(a / count),
+			})
+		}
+	}
+	return dst
+}
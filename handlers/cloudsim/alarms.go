@@ -0,0 +1,287 @@
+package cloudsim
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+	"allanswebterminal/handlers/notifications"
+)
+
+// Alarm states, named as in CloudWatch.
+const (
+	StateOK               = "OK"
+	StateAlarm            = "ALARM"
+	StateInsufficientData = "INSUFFICIENT_DATA"
+)
+
+type comparison struct {
+	phrase string
+	breach func(value, threshold float64) bool
+}
+
+var comparisons = map[string]comparison{
+	"GreaterThanThreshold":          {"greater than", func(v, t float64) bool { return v > t }},
+	"GreaterThanOrEqualToThreshold": {"greater than or equal to", func(v, t float64) bool { return v >= t }},
+	"LessThanThreshold":             {"less than", func(v, t float64) bool { return v < t }},
+	"LessThanOrEqualToThreshold":    {"less than or equal to", func(v, t float64) bool { return v <= t }},
+}
+
+// MetricAlarm watches one series. It goes to ALARM when the statistic
+// breaches the threshold in each of the last EvaluationPeriods complete
+// periods, and to INSUFFICIENT_DATA when some of those periods have no
+// points.
+type MetricAlarm struct {
+	ID                 int       `json:"id"`
+	Name               string    `json:"name"`
+	Namespace          string    `json:"namespace"`
+	MetricName         string    `json:"metric_name"`
+	ResourceID         string    `json:"resource_id"`
+	Statistic          string    `json:"statistic"`
+	PeriodMinutes      int       `json:"period_minutes"`
+	EvaluationPeriods  int       `json:"evaluation_periods"`
+	ComparisonOperator string    `json:"comparison_operator"`
+	Threshold          float64   `json:"threshold"`
+	State              string    `json:"state"`
+	StateReason        string    `json:"state_reason"`
+	StateUpdatedAt     time.Time `json:"state_updated_at"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// Validate fills in defaults and reports the first problem, or nil.
+func (a *MetricAlarm) Validate() error {
+	if a.Name == "" || len(a.Name) > 255 {
+		return errors.New("name must be 1 to 255 characters")
+	}
+	if _, ok := findMetric(a.Namespace, a.MetricName); !ok {
+		return fmt.Errorf("unknown metric %s/%s", a.Namespace, a.MetricName)
+	}
+	if a.ResourceID == "" {
+		return errors.New("resource_id is required")
+	}
+	if a.Statistic == "" {
+		a.Statistic = "Average"
+	}
+	if _, ok := statistics[a.Statistic]; !ok {
+		return fmt.Errorf("unknown statistic %q", a.Statistic)
+	}
+	if a.PeriodMinutes == 0 {
+		a.PeriodMinutes = 5
+	}
+	if a.EvaluationPeriods == 0 {
+		a.EvaluationPeriods = 1
+	}
+	if a.PeriodMinutes < 1 || a.PeriodMinutes > 60 {
+		return errors.New("period_minutes must be between 1 and 60")
+	}
+	if a.EvaluationPeriods < 1 || time.Duration(a.PeriodMinutes*a.EvaluationPeriods)*time.Minute > metricRetention {
+		return errors.New("evaluation_periods must be at least 1 and cover at most a day")
+	}
+	if _, ok := comparisons[a.ComparisonOperator]; !ok {
+		return fmt.Errorf("unknown comparison_operator %q", a.ComparisonOperator)
+	}
+	return nil
+}
+
+func (a *MetricAlarm) period() time.Duration {
+	return time.Duration(a.PeriodMinutes) * time.Minute
+}
+
+// evaluate decides the state from the datapoints of the evaluation window.
+func (a *MetricAlarm) evaluate(points []Datapoint) (state, reason string) {
+	if len(points) < a.EvaluationPeriods {
+		return StateInsufficientData, fmt.Sprintf("Insufficient Data: %d of %d datapoints were available.",
+			len(points), a.EvaluationPeriods)
+	}
+	points = points[len(points)-a.EvaluationPeriods:]
+	cmp := comparisons[a.ComparisonOperator]
+	values := make([]string, len(points))
+	breaching := 0
+	for i, p := range points {
+		values[i] = strconv.FormatFloat(p.Value, 'f', 2, 64)
+		if cmp.breach(p.Value, a.Threshold) {
+			breaching++
+		}
+	}
+	if breaching == len(points) {
+		return StateAlarm, fmt.Sprintf("Threshold Crossed: %d datapoints [%s] were %s the threshold (%.2f).",
+			len(points), strings.Join(values, ", "), cmp.phrase, a.Threshold)
+	}
+	return StateOK, fmt.Sprintf("Threshold Crossed: %d of %d datapoints [%s] were %s the threshold (%.2f).",
+		breaching, len(points), strings.Join(values, ", "), cmp.phrase, a.Threshold)
+}
+
+const alarmColumns = `id, account_id, name, namespace, metric_name, resource_id, statistic, period_minutes,
+	evaluation_periods, comparison_operator, threshold, state, state_reason, state_updated_at, created_at`
+
+type alarmRow struct {
+	MetricAlarm
+	accountID int
+}
+
+func scanAlarms(rows *sql.Rows) ([]alarmRow, error) {
+	defer rows.Close()
+	alarms := []alarmRow{}
+	for rows.Next() {
+		var a alarmRow
+		if err := rows.Scan(&a.ID, &a.accountID, &a.Name, &a.Namespace, &a.MetricName, &a.ResourceID,
+			&a.Statistic, &a.PeriodMinutes, &a.EvaluationPeriods, &a.ComparisonOperator, &a.Threshold,
+			&a.State, &a.StateReason, &a.StateUpdatedAt, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		alarms = append(alarms, a)
+	}
+	return alarms, rows.Err()
+}
+
+// evaluateAlarms moves every alarm to the state its last complete periods
+// call for, and notifies the owner when one goes into or out of ALARM.
+func evaluateAlarms(ctx context.Context, now time.Time) error {
+	rows, err := db.DB.QueryContext(ctx, "SELECT "+alarmColumns+" FROM metric_alarms ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("failed to load alarms: %w", err)
+	}
+	alarms, err := scanAlarms(rows)
+	if err != nil {
+		return fmt.Errorf("failed to load alarms: %w", err)
+	}
+
+	for _, a := range alarms {
+		until := now.Truncate(a.period())
+		since := until.Add(-time.Duration(a.EvaluationPeriods) * a.period())
+		k := seriesKey{a.accountID, a.Namespace, a.MetricName, a.ResourceID}
+		points, err := datapoints(ctx, k, a.Statistic, a.period(), since, until)
+		if err != nil {
+			return fmt.Errorf("alarm %d: %w", a.ID, err)
+		}
+		state, reason := a.evaluate(points)
+		if state == a.State {
+			continue
+		}
+
+		result, err := db.DB.ExecContext(ctx, `
+			UPDATE metric_alarms SET state = $1, state_reason = $2, state_updated_at = $3
+			WHERE id = $4 AND state = $5`, state, reason, now, a.ID, a.State)
+		if err != nil {
+			return fmt.Errorf("failed to update alarm %d: %w", a.ID, err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue // changed concurrently
+		}
+		if state == StateAlarm || a.State == StateAlarm && state == StateOK {
+			title := fmt.Sprintf("%s: %q", state, a.Name)
+			if _, err := notifications.Notify(ctx, a.accountID, notifications.KindAlarm, title, reason, "/cloudsimulator"); err != nil {
+				log.Printf("Failed to notify account %d of alarm %d: %v", a.accountID, a.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ListAlarmsHandler lists the account's alarms with their current state.
+func ListAlarmsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(),
+		"SELECT "+alarmColumns+" FROM metric_alarms WHERE account_id = $1 ORDER BY name", accountID)
+	var alarms []alarmRow
+	if err == nil {
+		alarms, err = scanAlarms(rows)
+	}
+	if err != nil {
+		log.Printf("Failed to load alarms: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load alarms"))
+		return
+	}
+	list := make([]MetricAlarm, len(alarms))
+	for i, a := range alarms {
+		list[i] = a.MetricAlarm
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// CreateAlarmHandler adds an alarm, like cloudwatch put-metric-alarm. It
+// starts in INSUFFICIENT_DATA until the next evaluation.
+func CreateAlarmHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var a MetricAlarm
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := a.Validate(); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	a.State = StateInsufficientData
+	a.StateReason = "Unchecked: Initial alarm creation"
+	err := db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO metric_alarms (account_id, name, namespace, metric_name, resource_id, statistic,
+			period_minutes, evaluation_periods, comparison_operator, threshold, state, state_reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (account_id, name) DO NOTHING
+		RETURNING id, state_updated_at, created_at`,
+		accountID, a.Name, a.Namespace, a.MetricName, a.ResourceID, a.Statistic,
+		a.PeriodMinutes, a.EvaluationPeriods, a.ComparisonOperator, a.Threshold, a.State, a.StateReason,
+	).Scan(&a.ID, &a.StateUpdatedAt, &a.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("An alarm with that name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create alarm: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create alarm"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// DeleteAlarmHandler deletes the alarm {id}.
+func DeleteAlarmHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid alarm id"))
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(), "DELETE FROM metric_alarms WHERE id = $1 AND account_id = $2", id, accountID)
+	if err != nil {
+		log.Printf("Failed to delete alarm: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete alarm"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Alarm not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
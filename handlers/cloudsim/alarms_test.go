@@ -0,0 +1,68 @@
+package cloudsim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMetricAlarmEvaluate(t *testing.T) {
+	alarm := MetricAlarm{EvaluationPeriods: 3, ComparisonOperator: "GreaterThanOrEqualToThreshold", Threshold: 80}
+	points := func(values ...float64) []Datapoint {
+		var ps []Datapoint
+		for i, v := range values {
+			ps = append(ps, Datapoint{Timestamp: time.Unix(int64(i*60), 0), Value: v})
+		}
+		return ps
+	}
+
+	tests := []struct {
+		name   string
+		points []Datapoint
+		state  string
+	}{
+		{"too few", points(90, 95), StateInsufficientData},
+		{"all breach", points(80, 95, 99), StateAlarm},
+		{"one below", points(90, 79.5, 99), StateOK},
+		{"only the last periods count", points(10, 85, 90, 95), StateAlarm},
+	}
+	for _, tt := range tests {
+		state, reason := alarm.evaluate(tt.points)
+		if state != tt.state {
+			t.Errorf("%s: state = %s (%s), want %s", tt.name, state, reason, tt.state)
+		}
+	}
+}
+
+func TestCreateAlarmHandler(t *testing.T) {
+	t.Run("unknown metric", func(t *testing.T) {
+		setupMockDB(t)
+		rr := httptest.NewRecorder()
+		CreateAlarmHandler(rr, httptest.NewRequest("POST", "/api/cloudsim/alarms", strings.NewReader(
+			`{"name": "x", "namespace": "AWS/EC2", "metric_name": "DiskReadOps", "resource_id": "i-1", "comparison_operator": "GreaterThanThreshold"}`)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rr.Code)
+		}
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("INSERT INTO metric_alarms").
+			WithArgs(1, "busy", "AWS/Lambda", "Invocations", "resize", "Average", 5, 1, "GreaterThanThreshold", 50.0,
+				StateInsufficientData, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "state_updated_at", "created_at"}).AddRow(3, time.Now(), time.Now()))
+		rr := httptest.NewRecorder()
+		CreateAlarmHandler(rr, httptest.NewRequest("POST", "/api/cloudsim/alarms", strings.NewReader(
+			`{"name": "busy", "namespace": "AWS/Lambda", "metric_name": "Invocations", "resource_id": "resize", "comparison_operator": "GreaterThanThreshold", "threshold": 50}`)))
+		if rr.Code != http.StatusCreated {
+			t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
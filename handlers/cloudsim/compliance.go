@@ -0,0 +1,440 @@
+// Package cloudsim holds the simulated compute resources and the checks and
+// monitoring that span the simulated AWS services.
+package cloudsim
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+)
+
+// resourceSource lists one type of simulated resource that carries tags.
+// Query takes the account id and returns arn, name and the tags as a JSON
+// object.
+type resourceSource struct {
+	Type  string
+	Query string
+}
+
+// resourceSources are scanned in order. A new simulated service with tagged
+// resources adds itself here.
+var resourceSources = []resourceSource{
+	{Type: "iam:user", Query: "SELECT arn, user_name, tags FROM iam_users WHERE account_id = $1 ORDER BY arn"},
+	{Type: "iam:role", Query: "SELECT arn, role_name, tags FROM iam_roles WHERE account_id = $1 ORDER BY arn"},
+	{Type: "iam:policy", Query: "SELECT arn, policy_name, tags FROM iam_policies WHERE account_id = $1 ORDER BY arn"},
+	{Type: TypeInstance, Query: "SELECT arn, name, tags FROM cloudsim_resources WHERE account_id = $1 AND resource_type = 'ec2:instance' ORDER BY arn"},
+	{Type: TypeFunction, Query: "SELECT arn, name, tags FROM cloudsim_resources WHERE account_id = $1 AND resource_type = 'lambda:function' ORDER BY arn"},
+	{Type: TypeVPC, Query: "SELECT arn, name, tags FROM cloudsim_resources WHERE account_id = $1 AND resource_type = 'ec2:vpc' ORDER BY arn"},
+	{Type: TypeBucket, Query: "SELECT arn, name, tags FROM cloudsim_resources WHERE account_id = $1 AND resource_type = 's3:bucket' ORDER BY arn"},
+}
+
+// ResourceTypes returns the resource types tag policies can target.
+func ResourceTypes() []string {
+	types := make([]string, len(resourceSources))
+	for i, s := range resourceSources {
+		types[i] = s.Type
+	}
+	return types
+}
+
+// TagRule is one requirement on a tag key. Pattern is a regular expression
+// the whole value must match; an absent key only violates a Required rule.
+type TagRule struct {
+	Key      string `json:"key"`
+	Required bool   `json:"required"`
+	Pattern  string `json:"pattern,omitempty"`
+}
+
+// TagPolicy applies rules to the account's resources of ResourceTypes, or
+// to all resources when it is empty.
+type TagPolicy struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	ResourceTypes []string  `json:"resource_types"`
+	Rules         []TagRule `json:"rules"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type Violation struct {
+	ResourceType string `json:"resource_type"`
+	ARN          string `json:"arn"`
+	Name         string `json:"name"`
+	Policy       string `json:"policy"`
+	Key          string `json:"key"`
+	// Problem is "missing" or "pattern".
+	Problem string `json:"problem"`
+	Value   string `json:"value,omitempty"`
+}
+
+type ComplianceReport struct {
+	GeneratedAt      time.Time   `json:"generated_at"`
+	Policies         int         `json:"policies"`
+	ResourcesScanned int         `json:"resources_scanned"`
+	NonCompliant     int         `json:"non_compliant"`
+	Violations       []Violation `json:"violations"`
+}
+
+const maxRulesPerPolicy = 50
+
+// compiledRule is a TagRule with its pattern compiled.
+type compiledRule struct {
+	TagRule
+	re *regexp.Regexp
+}
+
+func compileRules(rules []TagRule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, len(rules))
+	for i, rule := range rules {
+		compiled[i].TagRule = rule
+		if rule.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid pattern: %v", i+1, err)
+		}
+		compiled[i].re = re
+	}
+	return compiled, nil
+}
+
+// Validate reports the first problem with the policy, or nil.
+func (p *TagPolicy) Validate() error {
+	if p.Name == "" || len(p.Name) > 128 {
+		return errors.New("name must be 1 to 128 characters")
+	}
+	if len(p.Rules) == 0 || len(p.Rules) > maxRulesPerPolicy {
+		return fmt.Errorf("a policy needs 1 to %d rules", maxRulesPerPolicy)
+	}
+	for i, rule := range p.Rules {
+		if rule.Key == "" {
+			return fmt.Errorf("rule %d: key is required", i+1)
+		}
+		if !rule.Required && rule.Pattern == "" {
+			return fmt.Errorf("rule %d: set required, a pattern or both", i+1)
+		}
+	}
+	known := make(map[string]bool)
+	for _, t := range ResourceTypes() {
+		known[t] = true
+	}
+	for _, t := range p.ResourceTypes {
+		if !known[t] {
+			return fmt.Errorf("unknown resource type %q", t)
+		}
+	}
+	_, err := compileRules(p.Rules)
+	return err
+}
+
+func (p *TagPolicy) covers(resourceType string) bool {
+	if len(p.ResourceTypes) == 0 {
+		return true
+	}
+	for _, t := range p.ResourceTypes {
+		if t == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+func loadTagPolicies(ctx context.Context, accountID int) ([]TagPolicy, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT id, name, resource_types, rules, created_at
+		FROM tag_policies
+		WHERE account_id = $1
+		ORDER BY name`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []TagPolicy{}
+	for rows.Next() {
+		var p TagPolicy
+		var types, rules []byte
+		if err := rows.Scan(&p.ID, &p.Name, &types, &rules, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(types, &p.ResourceTypes); err != nil {
+			return nil, fmt.Errorf("tag policy %d: %w", p.ID, err)
+		}
+		if err := json.Unmarshal(rules, &p.Rules); err != nil {
+			return nil, fmt.Errorf("tag policy %d: %w", p.ID, err)
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// check appends the violations of one resource's tags.
+func check(violations []Violation, policy *TagPolicy, rules []compiledRule, resourceType, arn, name string, tags map[string]string) []Violation {
+	for _, rule := range rules {
+		value, ok := tags[rule.Key]
+		v := Violation{ResourceType: resourceType, ARN: arn, Name: name, Policy: policy.Name, Key: rule.Key}
+		switch {
+		case !ok && rule.Required:
+			v.Problem = "missing"
+		case ok && rule.re != nil && !rule.re.MatchString(value):
+			v.Problem, v.Value = "pattern", value
+		default:
+			continue
+		}
+		violations = append(violations, v)
+	}
+	return violations
+}
+
+// Scan checks every simulated resource of the account against its tag
+// policies.
+func Scan(ctx context.Context, accountID int) (*ComplianceReport, error) {
+	policies, err := loadTagPolicies(ctx, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tag policies: %w", err)
+	}
+	rules := make([][]compiledRule, len(policies))
+	for i := range policies {
+		if rules[i], err = compileRules(policies[i].Rules); err != nil {
+			return nil, fmt.Errorf("tag policy %s: %w", policies[i].Name, err)
+		}
+	}
+
+	report := &ComplianceReport{GeneratedAt: time.Now().UTC(), Policies: len(policies), Violations: []Violation{}}
+	for _, source := range resourceSources {
+		if err := scanSource(ctx, report, source, accountID, policies, rules); err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", source.Type, err)
+		}
+	}
+	return report, nil
+}
+
+func scanSource(ctx context.Context, report *ComplianceReport, source resourceSource, accountID int, policies []TagPolicy, rules [][]compiledRule) error {
+	rows, err := db.DB.QueryContext(ctx, source.Query, accountID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var arn, name string
+		var rawTags []byte
+		if err := rows.Scan(&arn, &name, &rawTags); err != nil {
+			return err
+		}
+		tags := map[string]string{}
+		if len(rawTags) > 0 {
+			json.Unmarshal(rawTags, &tags)
+		}
+
+		report.ResourcesScanned++
+		before := len(report.Violations)
+		for i := range policies {
+			if policies[i].covers(source.Type) {
+				report.Violations = check(report.Violations, &policies[i], rules[i], source.Type, arn, name, tags)
+			}
+		}
+		if len(report.Violations) > before {
+			report.NonCompliant++
+		}
+	}
+	return rows.Err()
+}
+
+func storeReport(ctx context.Context, accountID int, report *ComplianceReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.ExecContext(ctx, `
+		INSERT INTO compliance_reports (account_id, report, generated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (account_id)
+		DO UPDATE SET report = EXCLUDED.report, generated_at = EXCLUDED.generated_at`,
+		accountID, data, report.GeneratedAt)
+	return err
+}
+
+// ScanAll rebuilds the compliance report of every account with a tag
+// policy. It runs from the scheduler.
+func ScanAll(ctx context.Context) error {
+	rows, err := db.DB.QueryContext(ctx, "SELECT DISTINCT account_id FROM tag_policies ORDER BY account_id")
+	if err != nil {
+		return fmt.Errorf("failed to list accounts with tag policies: %w", err)
+	}
+	var accounts []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		accounts = append(accounts, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, accountID := range accounts {
+		report, err := Scan(ctx, accountID)
+		if err != nil {
+			return fmt.Errorf("account %d: %w", accountID, err)
+		}
+		if err := storeReport(ctx, accountID, report); err != nil {
+			return fmt.Errorf("failed to store compliance report for account %d: %w", accountID, err)
+		}
+	}
+	return nil
+}
+
+// GetComplianceHandler returns the latest compliance report, from the
+// scheduled scan or the last on-demand one.
+func GetComplianceHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var data []byte
+	err := db.DB.QueryRowContext(r.Context(), "SELECT report FROM compliance_reports WHERE account_id = $1",
+		accountID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("No compliance report yet; run a scan"))
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// RunComplianceHandler scans the account now and stores the report.
+func RunComplianceHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	report, err := Scan(r.Context(), accountID)
+	if err != nil {
+		log.Printf("Compliance scan failed: %v", err)
+		apierror.Write(w, apierror.Internal("Compliance scan failed"))
+		return
+	}
+	if err := storeReport(r.Context(), accountID, report); err != nil {
+		log.Printf("Failed to store compliance report: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// ListTagPoliciesHandler lists the account's tag policies.
+func ListTagPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	policies, err := loadTagPolicies(r.Context(), accountID)
+	if err != nil {
+		log.Printf("Failed to load tag policies: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load tag policies"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policies)
+}
+
+// CreateTagPolicyHandler adds a tag policy. Rules are checked when saved,
+// so scans never meet an invalid pattern.
+func CreateTagPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var policy TagPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := policy.Validate(); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if policy.ResourceTypes == nil {
+		policy.ResourceTypes = []string{}
+	}
+	sort.Strings(policy.ResourceTypes)
+	types, _ := json.Marshal(policy.ResourceTypes)
+	rules, _ := json.Marshal(policy.Rules)
+
+	err := db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO tag_policies (account_id, name, resource_types, rules)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (account_id, name) DO NOTHING
+		RETURNING id, created_at`,
+		accountID, policy.Name, types, rules,
+	).Scan(&policy.ID, &policy.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("A tag policy with that name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create tag policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create tag policy"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(policy)
+}
+
+// DeleteTagPolicyHandler deletes the tag policy {id}.
+func DeleteTagPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid tag policy id"))
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(), "DELETE FROM tag_policies WHERE id = $1 AND account_id = $2", id, accountID)
+	if err != nil {
+		log.Printf("Failed to delete tag policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete tag policy"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Tag policy not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
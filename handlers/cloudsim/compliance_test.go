@@ -0,0 +1,117 @@
+package cloudsim
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		mockDB.Close()
+		db.DB = originalDB
+	})
+	return mock
+}
+
+func TestTagPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy TagPolicy
+		ok     bool
+	}{
+		{"valid", TagPolicy{Name: "cost", Rules: []TagRule{{Key: "CostCenter", Required: true, Pattern: `CC-\d{4}`}}}, true},
+		{"no rules", TagPolicy{Name: "empty"}, false},
+		{"rule does nothing", TagPolicy{Name: "noop", Rules: []TagRule{{Key: "Owner"}}}, false},
+		{"bad pattern", TagPolicy{Name: "bad", Rules: []TagRule{{Key: "Env", Pattern: "(prod"}}}, false},
+		{"unknown type", TagPolicy{Name: "s3", ResourceTypes: []string{"dynamodb:table"}, Rules: []TagRule{{Key: "Env", Required: true}}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v", tt.name, err)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM tag_policies").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "resource_types", "rules", "created_at"}).
+			AddRow(1, "env", []byte(`[]`), []byte(`[{"key": "Env", "required": true, "pattern": "prod|dev"}]`), time.Now()).
+			AddRow(2, "owners", []byte(`["iam:role"]`), []byte(`[{"key": "Owner", "required": true}]`), time.Now()))
+	mock.ExpectQuery("FROM iam_users").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"arn", "user_name", "tags"}).
+			AddRow("arn:aws:iam::1:user/alice", "alice", []byte(`{"Env": "prod"}`)).
+			AddRow("arn:aws:iam::1:user/bob", "bob", []byte(`{"Env": "production"}`)))
+	mock.ExpectQuery("FROM iam_roles").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"arn", "role_name", "tags"}).
+			AddRow("arn:aws:iam::1:role/app", "app", []byte(`{}`)))
+	mock.ExpectQuery("FROM iam_policies").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"arn", "policy_name", "tags"}))
+	for range 4 {
+		mock.ExpectQuery("FROM cloudsim_resources").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"arn", "name", "tags"}))
+	}
+
+	report, err := Scan(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ResourcesScanned != 3 || report.NonCompliant != 2 || len(report.Violations) != 3 {
+		t.Fatalf("report = %+v", report)
+	}
+	bob := report.Violations[0]
+	if bob.Name != "bob" || bob.Problem != "pattern" || bob.Value != "production" {
+		t.Errorf("first violation = %+v", bob)
+	}
+	for _, v := range report.Violations[1:] {
+		if v.Name != "app" || v.Problem != "missing" {
+			t.Errorf("role violation = %+v", v)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateTagPolicyHandler(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		setupMockDB(t)
+		rr := httptest.NewRecorder()
+		CreateTagPolicyHandler(rr, httptest.NewRequest("POST", "/api/cloudsim/tag-policies",
+			strings.NewReader(`{"name": "x", "rules": [{"key": "Env", "pattern": "["}]}`)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rr.Code)
+		}
+	})
+
+	t.Run("created", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("INSERT INTO tag_policies").
+			WithArgs(1, "env", []byte(`["iam:role","iam:user"]`), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+		rr := httptest.NewRecorder()
+		CreateTagPolicyHandler(rr, httptest.NewRequest("POST", "/api/cloudsim/tag-policies",
+			strings.NewReader(`{"name": "env", "resource_types": ["iam:user", "iam:role"], "rules": [{"key": "Env", "required": true}]}`)))
+		if rr.Code != http.StatusCreated {
+			t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package cloudsim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+)
+
+// Relations drawn between diagram nodes. VPC membership is not an edge: it
+// is the VPC field of the member, and a cluster in DOT.
+const (
+	relationUsesRole     = "uses_role"
+	relationGrantsAccess = "grants_access"
+)
+
+// DiagramNode is a resource or principal. ID is its ARN, or "*" for the
+// anyone principal of a public bucket policy.
+type DiagramNode struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Label string `json:"label"`
+	// VPC is the ID of the VPC node the resource runs in.
+	VPC string `json:"vpc,omitempty"`
+}
+
+type DiagramEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Relation string `json:"relation"`
+}
+
+type Diagram struct {
+	Nodes []DiagramNode `json:"nodes"`
+	Edges []DiagramEdge `json:"edges"`
+}
+
+func (d *Diagram) addNode(seen map[string]bool, n DiagramNode) {
+	if !seen[n.ID] {
+		seen[n.ID] = true
+		d.Nodes = append(d.Nodes, n)
+	}
+}
+
+var accountNumberPattern = regexp.MustCompile(`^\d+$`)
+
+// principalNode describes a principal named in a bucket policy.
+func principalNode(p string) DiagramNode {
+	switch {
+	case p == "*":
+		return DiagramNode{ID: p, Type: "public", Label: "Everyone"}
+	case strings.Contains(p, ":role/"):
+		return DiagramNode{ID: p, Type: "iam:role", Label: p[strings.LastIndex(p, "/")+1:]}
+	case strings.Contains(p, ":user/"):
+		return DiagramNode{ID: p, Type: "iam:user", Label: p[strings.LastIndex(p, "/")+1:]}
+	case accountNumberPattern.MatchString(p):
+		return DiagramNode{ID: "arn:aws:iam::" + p + ":root", Type: "account", Label: "account " + p}
+	case strings.HasSuffix(p, ":root"):
+		return DiagramNode{ID: p, Type: "account", Label: "account " + strings.Split(p, ":")[4]}
+	}
+	return DiagramNode{ID: p, Type: "principal", Label: p}
+}
+
+// buildDiagram collects the account's resources with the roles they use,
+// the VPCs they run in and the principals bucket policies grant access to.
+func buildDiagram(ctx context.Context, accountID int) (*Diagram, error) {
+	resources, err := loadResources(ctx, accountID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load resources: %w", err)
+	}
+	rows, err := db.DB.QueryContext(ctx, "SELECT role_name, arn FROM iam_roles WHERE account_id = $1", accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load roles: %w", err)
+	}
+	roles := make(map[string]string)
+	for rows.Next() {
+		var name, arn string
+		if err := rows.Scan(&name, &arn); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roles[name] = arn
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	vpcs := make(map[string]string)
+	for _, res := range resources {
+		if res.Type == TypeVPC {
+			vpcs[res.ResourceID] = res.ARN
+		}
+	}
+
+	d := &Diagram{Nodes: []DiagramNode{}, Edges: []DiagramEdge{}}
+	seen := make(map[string]bool)
+	for _, res := range resources {
+		label := res.Name
+		if label == "" {
+			label = res.ResourceID
+		}
+		d.addNode(seen, DiagramNode{ID: res.ARN, Type: res.Type, Label: label, VPC: vpcs[res.Attributes.VPCID]})
+
+		if name := res.Attributes.RoleName; name != "" {
+			arn, ok := roles[name]
+			if !ok {
+				arn = fmt.Sprintf("arn:aws:iam::%s:role/%s", accounts.SimAccountNumber(accountID), name)
+			}
+			d.addNode(seen, DiagramNode{ID: arn, Type: "iam:role", Label: name})
+			d.Edges = append(d.Edges, DiagramEdge{From: res.ARN, To: arn, Relation: relationUsesRole})
+		}
+		if res.Attributes.Policy != "" {
+			principals, err := iam.ResourcePolicyPrincipals(res.Attributes.Policy)
+			if err != nil {
+				log.Printf("Skipping invalid policy of bucket %s: %v", res.ResourceID, err)
+				continue
+			}
+			for _, p := range principals {
+				n := principalNode(p)
+				d.addNode(seen, n)
+				d.Edges = append(d.Edges, DiagramEdge{From: res.ARN, To: n.ID, Relation: relationGrantsAccess})
+			}
+		}
+	}
+	return d, nil
+}
+
+var dotShapes = map[string]string{
+	TypeInstance: "box",
+	TypeFunction: "component",
+	TypeBucket:   "cylinder",
+	"iam:role":   "ellipse",
+	"iam:user":   "ellipse",
+	"account":    "house",
+	"public":     "doubleoctagon",
+	"principal":  "ellipse",
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// DOT renders the diagram for Graphviz. Each VPC is a cluster holding the
+// resources that run in it.
+func (d *Diagram) DOT(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n\trankdir=LR;\n", dotQuote(name))
+
+	members := make(map[string][]DiagramNode)
+	for _, n := range d.Nodes {
+		if n.VPC != "" {
+			members[n.VPC] = append(members[n.VPC], n)
+		}
+	}
+	writeNode := func(indent string, n DiagramNode) {
+		shape := dotShapes[n.Type]
+		if shape == "" {
+			shape = "box"
+		}
+		fmt.Fprintf(&b, "%s%s [label=%s, shape=%s];\n", indent, dotQuote(n.ID), dotQuote(n.Label+"\n"+n.Type), shape)
+	}
+	clusters := 0
+	for _, n := range d.Nodes {
+		switch {
+		case n.Type == TypeVPC:
+			fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n\t\tlabel=%s;\n", clusters, dotQuote(n.Label+" ("+n.ID[strings.LastIndex(n.ID, "/")+1:]+")"))
+			clusters++
+			if len(members[n.ID]) == 0 {
+				// Graphviz drops empty clusters; keep a placeholder.
+				writeNode("\t\t", n)
+			}
+			for _, m := range members[n.ID] {
+				writeNode("\t\t", m)
+			}
+			b.WriteString("\t}\n")
+		case n.VPC == "":
+			writeNode("\t", n)
+		}
+	}
+	for _, e := range d.Edges {
+		fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n", dotQuote(e.From), dotQuote(e.To), dotQuote(e.Relation))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// DiagramHandler returns the account's resources and their relationships
+// as JSON, or with ?format=dot as a Graphviz graph.
+func DiagramHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		apierror.Write(w, apierror.Validation(`format must be "json" or "dot"`))
+		return
+	}
+
+	d, err := buildDiagram(r.Context(), accountID)
+	if err != nil {
+		log.Printf("Failed to build diagram: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to build diagram"))
+		return
+	}
+
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		fmt.Fprint(w, d.DOT(fmt.Sprintf("account %d", accountID)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
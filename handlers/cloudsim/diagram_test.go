@@ -0,0 +1,67 @@
+package cloudsim
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestBuildDiagram(t *testing.T) {
+	mock := setupMockDB(t)
+	now := time.Now()
+	vpcARN := "arn:aws:ec2:us-east-1:1:vpc/vpc-0a1"
+	webARN := "arn:aws:ec2:us-east-1:1:instance/i-0b2"
+	bucketARN := "arn:aws:s3:::assets"
+	roleARN := "arn:aws:iam::1:role/service/web"
+	mock.ExpectQuery("FROM cloudsim_resources").WithArgs(1, "").
+		WillReturnRows(sqlmock.NewRows([]string{"resource_id", "resource_type", "name", "arn", "attributes", "tags", "created_at"}).
+			AddRow("vpc-0a1", TypeVPC, "main", vpcARN, []byte(`{}`), []byte(`{}`), now).
+			AddRow("i-0b2", TypeInstance, "web", webARN, []byte(`{"vpc_id": "vpc-0a1", "role_name": "web"}`), []byte(`{}`), now).
+			AddRow("assets", TypeBucket, "assets", bucketARN, []byte(`{"policy": "{\"Statement\": [{\"Effect\": \"Allow\", \"Principal\": {\"AWS\": [\"arn:aws:iam::1:role/service/web\", \"222233334444\"]}, \"Action\": \"s3:GetObject\", \"Resource\": \"*\"}, {\"Effect\": \"Allow\", \"Principal\": \"*\", \"Action\": \"s3:GetObject\", \"Resource\": \"*\"}]}"}`), []byte(`{}`), now))
+	mock.ExpectQuery("FROM iam_roles").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"role_name", "arn"}).AddRow("web", roleARN))
+
+	d, err := buildDiagram(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []DiagramEdge{
+		{From: webARN, To: roleARN, Relation: relationUsesRole},
+		{From: bucketARN, To: roleARN, Relation: relationGrantsAccess},
+		{From: bucketARN, To: "arn:aws:iam::222233334444:root", Relation: relationGrantsAccess},
+		{From: bucketARN, To: "*", Relation: relationGrantsAccess},
+	}
+	if len(d.Edges) != len(want) {
+		t.Fatalf("edges = %+v", d.Edges)
+	}
+	for i := range want {
+		if d.Edges[i] != want[i] {
+			t.Errorf("edge %d = %+v, want %+v", i, d.Edges[i], want[i])
+		}
+	}
+	// The role is drawn once although two resources point at it.
+	if len(d.Nodes) != 6 {
+		t.Errorf("nodes = %+v", d.Nodes)
+	}
+	if d.Nodes[1].VPC != vpcARN {
+		t.Errorf("instance VPC = %q", d.Nodes[1].VPC)
+	}
+
+	dot := d.DOT("account 1")
+	for _, s := range []string{
+		"subgraph cluster_0 {\n\t\tlabel=\"main (vpc-0a1)\";\n\t\t\"" + webARN + "\"",
+		`"` + bucketARN + `" -> "*" [label="grants_access"];`,
+		`[label="Everyone\npublic", shape=doubleoctagon]`,
+	} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("DOT output lacks %q:\n%s", s, dot)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,786 @@
+package cloudsim
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+	"allanswebterminal/ratelimit"
+)
+
+const (
+	// MaxItemSize bounds an item's JSON, as in DynamoDB.
+	MaxItemSize = 400 * 1024
+	// maxQueryLimit bounds the items of one query page.
+	maxQueryLimit = 100
+	// Capacity units cover 1 KB written or 4 KB read.
+	writeUnitSize = 1024
+	readUnitSize  = 4096
+)
+
+// Key attribute types: strings and numbers.
+const (
+	KeyTypeString = "S"
+	KeyTypeNumber = "N"
+)
+
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,255}$`)
+
+var (
+	errNoSuchTable = errors.New("no such table")
+	// errThrottled is returned when a table's provisioned capacity for
+	// this second is used up.
+	errThrottled = errors.New("provisioned throughput exceeded")
+)
+
+// capacityStore holds each table's read and write capacity buckets.
+var capacityStore = ratelimit.NewMemoryStore()
+
+// KeyAttribute names a key attribute and its type, "S" or "N".
+type KeyAttribute struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Table is a simulated DynamoDB table with provisioned capacity: each
+// second it serves ReadCapacity units of reads and WriteCapacity units of
+// writes. ItemCount and SizeBytes are counted when the table is read.
+type Table struct {
+	Name          string        `json:"name"`
+	ARN           string        `json:"arn"`
+	PartitionKey  KeyAttribute  `json:"partition_key"`
+	SortKey       *KeyAttribute `json:"sort_key,omitempty"`
+	ReadCapacity  int           `json:"read_capacity"`
+	WriteCapacity int           `json:"write_capacity"`
+	ItemCount     int           `json:"item_count"`
+	SizeBytes     int64         `json:"size_bytes"`
+	CreatedAt     time.Time     `json:"created_at"`
+
+	accountID int
+}
+
+// Item is a table item, a JSON object with the key attributes as string
+// or number members.
+type Item map[string]json.RawMessage
+
+// Requests that use a table are made as PrincipalARN, a user or role of
+// the account, or as the account root when it is empty. Capacities
+// default to 5 units.
+type CreateTableRequest struct {
+	Name          string        `json:"name"`
+	PartitionKey  KeyAttribute  `json:"partition_key"`
+	SortKey       *KeyAttribute `json:"sort_key,omitempty"`
+	ReadCapacity  int           `json:"read_capacity,omitempty"`
+	WriteCapacity int           `json:"write_capacity,omitempty"`
+	PrincipalARN  string        `json:"principal_arn,omitempty"`
+}
+
+type UpdateCapacityRequest struct {
+	ReadCapacity  int    `json:"read_capacity"`
+	WriteCapacity int    `json:"write_capacity"`
+	PrincipalARN  string `json:"principal_arn,omitempty"`
+}
+
+type PutItemRequest struct {
+	Item         Item   `json:"item"`
+	PrincipalARN string `json:"principal_arn,omitempty"`
+}
+
+// KeyRequest names an item by its key attributes.
+type KeyRequest struct {
+	Key          Item   `json:"key"`
+	PrincipalARN string `json:"principal_arn,omitempty"`
+}
+
+// ItemResponse carries the item read, if any, and the capacity units the
+// request used.
+type ItemResponse struct {
+	Item             Item `json:"item,omitempty"`
+	ConsumedCapacity int  `json:"consumed_capacity"`
+}
+
+// SortKeyCondition narrows a query by sort key. Operator is one of =, <,
+// <=, >, >=, between (two values) or begins_with (string keys).
+type SortKeyCondition struct {
+	Operator string            `json:"operator"`
+	Values   []json.RawMessage `json:"values"`
+}
+
+// QueryRequest reads the items of one partition in sort key order, up to
+// Limit (1-100, default 100) at a time. ExclusiveStartKey continues from
+// the LastEvaluatedKey of the previous page.
+type QueryRequest struct {
+	PartitionKey      json.RawMessage   `json:"partition_key"`
+	SortKey           *SortKeyCondition `json:"sort_key,omitempty"`
+	ScanIndexForward  *bool             `json:"scan_index_forward,omitempty"`
+	Limit             int               `json:"limit,omitempty"`
+	ExclusiveStartKey Item              `json:"exclusive_start_key,omitempty"`
+	PrincipalARN      string            `json:"principal_arn,omitempty"`
+}
+
+type QueryResponse struct {
+	Items            []Item `json:"items"`
+	Count            int    `json:"count"`
+	LastEvaluatedKey Item   `json:"last_evaluated_key,omitempty"`
+	ConsumedCapacity int    `json:"consumed_capacity"`
+}
+
+func tableARN(accountID int, name string) string {
+	return fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/%s", region, accounts.SimAccountNumber(accountID), name)
+}
+
+func checkKeyAttribute(attr KeyAttribute) error {
+	if attr.Name == "" || len(attr.Name) > 255 {
+		return errors.New("key attribute names are 1 to 255 characters")
+	}
+	if attr.Type != KeyTypeString && attr.Type != KeyTypeNumber {
+		return fmt.Errorf(`the type of %s must be "S" or "N"`, attr.Name)
+	}
+	return nil
+}
+
+func checkCapacity(read, write int) error {
+	if read < 1 || read > 1000 || write < 1 || write > 1000 {
+		return errors.New("read_capacity and write_capacity must be between 1 and 1000")
+	}
+	return nil
+}
+
+// keyValue returns the stored text of a key attribute's value. Numbers are
+// normalized, so 1 and 1.0 are the same key.
+func keyValue(attr KeyAttribute, raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", fmt.Errorf("the key attribute %s is missing", attr.Name)
+	}
+	if attr.Type == KeyTypeString {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil || s == "" || len(s) > 1024 {
+			return "", fmt.Errorf("%s must be a string of 1 to 1024 bytes", attr.Name)
+		}
+		return s, nil
+	}
+	var f float64
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return "", fmt.Errorf("%s must be a number", attr.Name)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+// key returns the stored partition and sort key of an item or key.
+func (t *Table) key(item Item) (pk, sk string, err error) {
+	if pk, err = keyValue(t.PartitionKey, item[t.PartitionKey.Name]); err != nil {
+		return "", "", err
+	}
+	if t.SortKey != nil {
+		if sk, err = keyValue(*t.SortKey, item[t.SortKey.Name]); err != nil {
+			return "", "", err
+		}
+	}
+	return pk, sk, nil
+}
+
+// keyOf returns the key attributes of an item.
+func (t *Table) keyOf(item Item) Item {
+	key := Item{t.PartitionKey.Name: item[t.PartitionKey.Name]}
+	if t.SortKey != nil {
+		key[t.SortKey.Name] = item[t.SortKey.Name]
+	}
+	return key
+}
+
+// units returns the capacity units size bytes take, at least one.
+func units(size, unitSize int) int {
+	return max(1, (size+unitSize-1)/unitSize)
+}
+
+// consume takes units from the table's read or write capacity for this
+// second, all at once: a refused request uses none. A request needing more
+// units than the table has per second is always refused; there is no
+// burst capacity.
+func (t *Table) consume(ctx context.Context, write bool, n int) error {
+	kind, capacity := "read", t.ReadCapacity
+	if write {
+		kind, capacity = "write", t.WriteCapacity
+	}
+	key := fmt.Sprintf("dynamodb:%d:%s:%s", t.accountID, t.Name, kind)
+	budget := ratelimit.Budget{Rate: float64(capacity), Burst: capacity}
+	result, err := capacityStore.TakeN(ctx, key, budget, n)
+	if err != nil {
+		return err
+	}
+	if !result.Allowed {
+		return errThrottled
+	}
+	return nil
+}
+
+// authorize checks that the caller may call dynamodb:action on the table.
+func (t *Table) authorize(ctx context.Context, principalARN, action string) error {
+	return authorizeCaller(ctx, t.accountID, principalARN, "dynamodb:"+action, t.ARN)
+}
+
+// loadTables returns the account's tables, or only the table name if it
+// is not empty, with their item counts and sizes.
+func loadTables(ctx context.Context, accountID int, name string) ([]Table, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT t.table_name, t.arn, t.partition_key, t.partition_key_type, t.sort_key, t.sort_key_type,
+			t.read_capacity, t.write_capacity, t.created_at, COUNT(i.pk), COALESCE(SUM(i.size), 0)
+		FROM dynamodb_tables t
+		LEFT JOIN dynamodb_items i ON i.account_id = t.account_id AND i.table_name = t.table_name
+		WHERE t.account_id = $1 AND ($2 = '' OR t.table_name = $2)
+		GROUP BY t.account_id, t.table_name
+		ORDER BY t.table_name`, accountID, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := []Table{}
+	for rows.Next() {
+		t := Table{accountID: accountID}
+		var sortKey, sortKeyType sql.NullString
+		if err := rows.Scan(&t.Name, &t.ARN, &t.PartitionKey.Name, &t.PartitionKey.Type, &sortKey, &sortKeyType,
+			&t.ReadCapacity, &t.WriteCapacity, &t.CreatedAt, &t.ItemCount, &t.SizeBytes); err != nil {
+			return nil, err
+		}
+		if sortKey.Valid {
+			t.SortKey = &KeyAttribute{Name: sortKey.String, Type: sortKeyType.String}
+		}
+		tables = append(tables, t)
+	}
+	return tables, rows.Err()
+}
+
+// loadTable returns the table name without counting its items.
+func loadTable(ctx context.Context, accountID int, name string) (*Table, error) {
+	t := &Table{accountID: accountID}
+	var sortKey, sortKeyType sql.NullString
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT table_name, arn, partition_key, partition_key_type, sort_key, sort_key_type,
+			read_capacity, write_capacity, created_at
+		FROM dynamodb_tables WHERE account_id = $1 AND table_name = $2`, accountID, name,
+	).Scan(&t.Name, &t.ARN, &t.PartitionKey.Name, &t.PartitionKey.Type, &sortKey, &sortKeyType,
+		&t.ReadCapacity, &t.WriteCapacity, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNoSuchTable
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sortKey.Valid {
+		t.SortKey = &KeyAttribute{Name: sortKey.String, Type: sortKeyType.String}
+	}
+	return t, nil
+}
+
+// query reads a page of the partition pk, past the sort key after if it
+// is not empty.
+func (t *Table) query(ctx context.Context, pk string, cond *SortKeyCondition, forward bool, limit int, after string) ([]Item, []int, error) {
+	numeric := t.SortKey != nil && t.SortKey.Type == KeyTypeNumber
+	sortExpr := "sk"
+	if numeric {
+		sortExpr = "sk::numeric"
+	}
+	args := []interface{}{t.accountID, t.Name, pk}
+	param := func(v string) string {
+		args = append(args, v)
+		if numeric {
+			return fmt.Sprintf("$%d::numeric", len(args))
+		}
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := "account_id = $1 AND table_name = $2 AND pk = $3"
+	if cond != nil {
+		values := make([]string, len(cond.Values))
+		for i, raw := range cond.Values {
+			v, err := keyValue(*t.SortKey, raw)
+			if err != nil {
+				return nil, nil, err
+			}
+			values[i] = v
+		}
+		switch cond.Operator {
+		case "=", "<", "<=", ">", ">=":
+			where += fmt.Sprintf(" AND %s %s %s", sortExpr, cond.Operator, param(values[0]))
+		case "between":
+			where += fmt.Sprintf(" AND %s BETWEEN %s AND %s", sortExpr, param(values[0]), param(values[1]))
+		case "begins_with":
+			p := param(values[0])
+			where += fmt.Sprintf(" AND left(sk, char_length(%s)) = %s", p, p)
+		}
+	}
+	dir, next := "ASC", ">"
+	if !forward {
+		dir, next = "DESC", "<"
+	}
+	if after != "" {
+		where += fmt.Sprintf(" AND %s %s %s", sortExpr, next, param(after))
+	}
+	args = append(args, limit)
+
+	rows, err := db.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT item, size FROM dynamodb_items
+		WHERE %s
+		ORDER BY %s %s
+		LIMIT $%d`, where, sortExpr, dir, len(args)), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	var sizes []int
+	for rows.Next() {
+		var raw []byte
+		var size int
+		if err := rows.Scan(&raw, &size); err != nil {
+			return nil, nil, err
+		}
+		var item Item
+		json.Unmarshal(raw, &item)
+		items = append(items, item)
+		sizes = append(sizes, size)
+	}
+	return items, sizes, rows.Err()
+}
+
+// checkSortKeyCondition checks a query's sort key condition against the
+// table's sort key.
+func (t *Table) checkSortKeyCondition(cond *SortKeyCondition) error {
+	if cond == nil {
+		return nil
+	}
+	if t.SortKey == nil {
+		return errors.New("the table has no sort key")
+	}
+	want := 1
+	switch cond.Operator {
+	case "=", "<", "<=", ">", ">=":
+	case "between":
+		want = 2
+	case "begins_with":
+		if t.SortKey.Type != KeyTypeString {
+			return errors.New("begins_with needs a string sort key")
+		}
+	default:
+		return errors.New("operator must be one of =, <, <=, >, >=, between or begins_with")
+	}
+	if len(cond.Values) != want {
+		return fmt.Errorf("%s takes %d value(s)", cond.Operator, want)
+	}
+	return nil
+}
+
+// writeTableError writes the response for an error from a table
+// operation.
+func writeTableError(w http.ResponseWriter, err error, action string) {
+	var denied *AccessDeniedError
+	switch {
+	case errors.As(err, &denied):
+		apierror.Write(w, apierror.Forbidden(denied.Error()).WithDetails(denied.Evaluation))
+	case errors.Is(err, errNoSuchTable):
+		apierror.Write(w, apierror.NotFound("Table not found"))
+	case errors.Is(err, iam.ErrNoSuchPrincipal):
+		apierror.Write(w, apierror.NotFound("No user or role with that principal ARN"))
+	case errors.Is(err, errThrottled):
+		apierror.Write(w, apierror.New(apierror.CodeRateLimited, "The table's provisioned throughput was exceeded").
+			WithDetails(map[string]string{"reason": "provisioned_throughput_exceeded"}))
+	default:
+		log.Printf("Failed to %s: %v", action, err)
+		apierror.Write(w, apierror.Internal("Failed to "+action))
+	}
+}
+
+// ListTablesHandler lists the account's tables.
+func ListTablesHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	tables, err := loadTables(r.Context(), accountID, "")
+	if err != nil {
+		writeTableError(w, err, "list tables")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tables)
+}
+
+// GetTableHandler describes the table {name}.
+func GetTableHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	tables, err := loadTables(r.Context(), accountID, r.PathValue("name"))
+	if err == nil && len(tables) == 0 {
+		err = errNoSuchTable
+	}
+	if err != nil {
+		writeTableError(w, err, "load table")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tables[0])
+}
+
+// CreateTableHandler creates a table keyed by a partition key and an
+// optional sort key.
+func CreateTableHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreateTableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.ReadCapacity == 0 {
+		req.ReadCapacity = 5
+	}
+	if req.WriteCapacity == 0 {
+		req.WriteCapacity = 5
+	}
+	err := checkKeyAttribute(req.PartitionKey)
+	if err == nil && req.SortKey != nil {
+		err = checkKeyAttribute(*req.SortKey)
+		if err == nil && req.SortKey.Name == req.PartitionKey.Name {
+			err = errors.New("the sort key must differ from the partition key")
+		}
+	}
+	if err == nil {
+		err = checkCapacity(req.ReadCapacity, req.WriteCapacity)
+	}
+	if err == nil && !tableNamePattern.MatchString(req.Name) {
+		err = errors.New("table names are 3 to 255 letters, digits, dots, hyphens or underscores")
+	}
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	t := Table{Name: req.Name, ARN: tableARN(accountID, req.Name), PartitionKey: req.PartitionKey, SortKey: req.SortKey,
+		ReadCapacity: req.ReadCapacity, WriteCapacity: req.WriteCapacity}
+	if err := authorizeCaller(r.Context(), accountID, req.PrincipalARN, "dynamodb:CreateTable", t.ARN); err != nil {
+		writeTableError(w, err, "create table")
+		return
+	}
+	var sortKey, sortKeyType sql.NullString
+	if t.SortKey != nil {
+		sortKey = sql.NullString{String: t.SortKey.Name, Valid: true}
+		sortKeyType = sql.NullString{String: t.SortKey.Type, Valid: true}
+	}
+	err = db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO dynamodb_tables (account_id, table_name, arn, partition_key, partition_key_type, sort_key, sort_key_type,
+			read_capacity, write_capacity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (account_id, table_name) DO NOTHING
+		RETURNING created_at`,
+		accountID, t.Name, t.ARN, t.PartitionKey.Name, t.PartitionKey.Type, sortKey, sortKeyType,
+		t.ReadCapacity, t.WriteCapacity,
+	).Scan(&t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("A table with that name already exists"))
+		return
+	}
+	if err != nil {
+		writeTableError(w, err, "create table")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// UpdateCapacityHandler changes the provisioned capacity of the table
+// {name}.
+func UpdateCapacityHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req UpdateCapacityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := checkCapacity(req.ReadCapacity, req.WriteCapacity); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	t, err := loadTable(r.Context(), accountID, r.PathValue("name"))
+	if err == nil {
+		err = t.authorize(r.Context(), req.PrincipalARN, "UpdateTable")
+	}
+	if err == nil {
+		_, err = db.DB.ExecContext(r.Context(),
+			"UPDATE dynamodb_tables SET read_capacity = $3, write_capacity = $4 WHERE account_id = $1 AND table_name = $2",
+			accountID, t.Name, req.ReadCapacity, req.WriteCapacity)
+	}
+	if err != nil {
+		writeTableError(w, err, "update table")
+		return
+	}
+	GetTableHandler(w, r)
+}
+
+// DeleteTableHandler deletes the table {name} and its items. A user or
+// role needs dynamodb:DeleteTable, given as ?principal_arn=.
+func DeleteTableHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	t, err := loadTable(r.Context(), accountID, r.PathValue("name"))
+	if err == nil {
+		err = t.authorize(r.Context(), r.URL.Query().Get("principal_arn"), "DeleteTable")
+	}
+	if err == nil {
+		_, err = db.DB.ExecContext(r.Context(), "DELETE FROM dynamodb_tables WHERE account_id = $1 AND table_name = $2",
+			accountID, t.Name)
+	}
+	if err != nil {
+		writeTableError(w, err, "delete table")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PutItemHandler creates or replaces an item of the table {name}. It uses
+// one write unit per KB.
+func PutItemHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req PutItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	t, err := loadTable(r.Context(), accountID, r.PathValue("name"))
+	if err == nil {
+		err = t.authorize(r.Context(), req.PrincipalARN, "PutItem")
+	}
+	if err != nil {
+		writeTableError(w, err, "put item")
+		return
+	}
+	pk, sk, err := t.key(req.Item)
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	raw, _ := json.Marshal(req.Item)
+	if len(raw) > MaxItemSize {
+		apierror.Write(w, apierror.Validation("items are at most 400 KB"))
+		return
+	}
+
+	resp := ItemResponse{ConsumedCapacity: units(len(raw), writeUnitSize)}
+	err = t.consume(r.Context(), true, resp.ConsumedCapacity)
+	if err == nil {
+		_, err = db.DB.ExecContext(r.Context(), `
+			INSERT INTO dynamodb_items (account_id, table_name, pk, sk, item, size)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (account_id, table_name, pk, sk) DO UPDATE SET item = EXCLUDED.item, size = EXCLUDED.size`,
+			accountID, t.Name, pk, sk, raw, len(raw))
+	}
+	if err != nil {
+		writeTableError(w, err, "put item")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetItemHandler reads the item with the given key from the table {name},
+// using one read unit per 4 KB. A missing item leaves item out.
+func GetItemHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req KeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	t, err := loadTable(r.Context(), accountID, r.PathValue("name"))
+	if err == nil {
+		err = t.authorize(r.Context(), req.PrincipalARN, "GetItem")
+	}
+	if err != nil {
+		writeTableError(w, err, "get item")
+		return
+	}
+	pk, sk, err := t.key(req.Key)
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	var raw []byte
+	var size int
+	err = db.DB.QueryRowContext(r.Context(),
+		"SELECT item, size FROM dynamodb_items WHERE account_id = $1 AND table_name = $2 AND pk = $3 AND sk = $4",
+		accountID, t.Name, pk, sk).Scan(&raw, &size)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	var resp ItemResponse
+	if err == nil {
+		resp.ConsumedCapacity = units(size, readUnitSize)
+		err = t.consume(r.Context(), false, resp.ConsumedCapacity)
+	}
+	if err != nil {
+		writeTableError(w, err, "get item")
+		return
+	}
+	if raw != nil {
+		json.Unmarshal(raw, &resp.Item)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteItemHandler deletes the item with the given key from the table
+// {name}, if it exists, using one write unit.
+func DeleteItemHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req KeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	t, err := loadTable(r.Context(), accountID, r.PathValue("name"))
+	if err == nil {
+		err = t.authorize(r.Context(), req.PrincipalARN, "DeleteItem")
+	}
+	if err != nil {
+		writeTableError(w, err, "delete item")
+		return
+	}
+	pk, sk, err := t.key(req.Key)
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	resp := ItemResponse{ConsumedCapacity: 1}
+	err = t.consume(r.Context(), true, resp.ConsumedCapacity)
+	if err == nil {
+		_, err = db.DB.ExecContext(r.Context(),
+			"DELETE FROM dynamodb_items WHERE account_id = $1 AND table_name = $2 AND pk = $3 AND sk = $4",
+			accountID, t.Name, pk, sk)
+	}
+	if err != nil {
+		writeTableError(w, err, "delete item")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// QueryHandler reads the items of one partition of the table {name} in
+// sort key order, using one read unit per 4 KB read.
+func QueryHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = maxQueryLimit
+	}
+	if req.Limit < 1 || req.Limit > maxQueryLimit {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("limit must be between 1 and %d", maxQueryLimit)))
+		return
+	}
+	t, err := loadTable(r.Context(), accountID, r.PathValue("name"))
+	if err == nil {
+		err = t.authorize(r.Context(), req.PrincipalARN, "Query")
+	}
+	if err != nil {
+		writeTableError(w, err, "query")
+		return
+	}
+
+	pk, err := keyValue(t.PartitionKey, req.PartitionKey)
+	if err == nil {
+		err = t.checkSortKeyCondition(req.SortKey)
+	}
+	var after string
+	if err == nil && req.ExclusiveStartKey != nil && t.SortKey != nil {
+		after, err = keyValue(*t.SortKey, req.ExclusiveStartKey[t.SortKey.Name])
+	}
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	// One more item than asked tells whether there is another page.
+	forward := req.ScanIndexForward == nil || *req.ScanIndexForward
+	items, sizes, err := t.query(r.Context(), pk, req.SortKey, forward, req.Limit+1, after)
+	if err != nil {
+		writeTableError(w, err, "query")
+		return
+	}
+	resp := QueryResponse{Items: []Item{}}
+	if len(items) > req.Limit {
+		items, sizes = items[:req.Limit], sizes[:req.Limit]
+		resp.LastEvaluatedKey = t.keyOf(items[len(items)-1])
+	}
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	resp.ConsumedCapacity = units(total, readUnitSize)
+	if err := t.consume(r.Context(), false, resp.ConsumedCapacity); err != nil {
+		writeTableError(w, err, "query")
+		return
+	}
+	resp.Items = append(resp.Items, items...)
+	resp.Count = len(resp.Items)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,152 @@
+package cloudsim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/ratelimit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var ordersTable = &Table{Name: "orders", accountID: 1, ReadCapacity: 5, WriteCapacity: 5,
+	PartitionKey: KeyAttribute{Name: "customer", Type: KeyTypeString}, SortKey: &KeyAttribute{Name: "placed", Type: KeyTypeNumber}}
+
+func TestKeyValue(t *testing.T) {
+	str, num := KeyAttribute{Name: "id", Type: KeyTypeString}, KeyAttribute{Name: "n", Type: KeyTypeNumber}
+	tests := []struct {
+		attr KeyAttribute
+		raw  string
+		want string
+		ok   bool
+	}{
+		{str, `"a-1"`, "a-1", true},
+		{str, `""`, "", false},
+		{str, `7`, "", false},
+		{num, `1.0`, "1", true},
+		{num, `-2.50`, "-2.5", true},
+		{num, `"7"`, "", false},
+		{num, ``, "", false},
+	}
+	for _, tt := range tests {
+		got, err := keyValue(tt.attr, json.RawMessage(tt.raw))
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("keyValue(%s, %s) = %q, %v", tt.attr.Type, tt.raw, got, err)
+		}
+	}
+}
+
+func TestCheckSortKeyCondition(t *testing.T) {
+	values := func(vs ...string) []json.RawMessage {
+		var raws []json.RawMessage
+		for _, v := range vs {
+			raws = append(raws, json.RawMessage(v))
+		}
+		return raws
+	}
+	tests := []struct {
+		cond *SortKeyCondition
+		ok   bool
+	}{
+		{nil, true},
+		{&SortKeyCondition{Operator: ">=", Values: values("1")}, true},
+		{&SortKeyCondition{Operator: "between", Values: values("1", "5")}, true},
+		{&SortKeyCondition{Operator: "between", Values: values("1")}, false},
+		{&SortKeyCondition{Operator: "begins_with", Values: values(`"20"`)}, false},
+		{&SortKeyCondition{Operator: "!=", Values: values("1")}, false},
+	}
+	for _, tt := range tests {
+		if err := ordersTable.checkSortKeyCondition(tt.cond); (err == nil) != tt.ok {
+			t.Errorf("checkSortKeyCondition(%+v) = %v, want ok %v", tt.cond, err, tt.ok)
+		}
+	}
+}
+
+// useCapacityStore gives the test empty capacity buckets, so tables
+// consumed by other tests or earlier runs do not throttle it.
+func useCapacityStore(t *testing.T) {
+	original := capacityStore
+	capacityStore = ratelimit.NewMemoryStore()
+	t.Cleanup(func() { capacityStore = original })
+}
+
+func TestTableConsume(t *testing.T) {
+	useCapacityStore(t)
+	table := &Table{Name: "throttled", accountID: 1, ReadCapacity: 3, WriteCapacity: 1}
+	ctx := context.Background()
+	if err := table.consume(ctx, false, 3); err != nil {
+		t.Fatalf("consume(3 of 3 read units) = %v", err)
+	}
+	if err := table.consume(ctx, false, 1); !errors.Is(err, errThrottled) {
+		t.Errorf("consume past the read capacity = %v, want errThrottled", err)
+	}
+	if err := table.consume(ctx, true, 2); !errors.Is(err, errThrottled) {
+		t.Errorf("consume(2 of 1 write units) = %v, want errThrottled", err)
+	}
+	if err := table.consume(ctx, true, 1); err != nil {
+		t.Errorf("write units are counted apart from read units: %v", err)
+	}
+}
+
+func expectTable(mock sqlmock.Sqlmock, table *Table) {
+	mock.ExpectQuery("SELECT table_name, arn, partition_key").WithArgs(1, table.Name).
+		WillReturnRows(sqlmock.NewRows([]string{"table_name", "arn", "partition_key", "partition_key_type", "sort_key", "sort_key_type",
+			"read_capacity", "write_capacity", "created_at"}).
+			AddRow(table.Name, tableARN(1, table.Name), table.PartitionKey.Name, table.PartitionKey.Type,
+				table.SortKey.Name, table.SortKey.Type, table.ReadCapacity, table.WriteCapacity, time.Now()))
+}
+
+func TestQueryHandler(t *testing.T) {
+	useCapacityStore(t)
+	mock := setupMockDB(t)
+	expectTable(mock, ordersTable)
+	// Newest first after 1700000000, two at a time: a third row means
+	// another page.
+	mock.ExpectQuery(`SELECT item, size FROM dynamodb_items\s+WHERE account_id = \$1 AND table_name = \$2 AND pk = \$3 `+
+		`AND sk::numeric > \$4::numeric AND sk::numeric < \$5::numeric\s+ORDER BY sk::numeric DESC\s+LIMIT \$6`).
+		WithArgs(1, "orders", "ana", "1700000000", "1700000300", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"item", "size"}).
+			AddRow([]byte(`{"customer": "ana", "placed": 1700000200, "total": 12}`), 5000).
+			AddRow([]byte(`{"customer": "ana", "placed": 1700000100, "total": 3}`), 100).
+			AddRow([]byte(`{"customer": "ana", "placed": 1700000050, "total": 8}`), 100))
+
+	req := httptest.NewRequest("POST", "/api/cloudsim/dynamodb/tables/orders/query", strings.NewReader(`{
+		"partition_key": "ana", "sort_key": {"operator": ">", "values": [1700000000]},
+		"scan_index_forward": false, "limit": 2, "exclusive_start_key": {"customer": "ana", "placed": 1700000300}}`))
+	req.SetPathValue("name", "orders")
+	rr := httptest.NewRecorder()
+	QueryHandler(rr, req)
+
+	var resp QueryResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rr.Code, rr.Body.String())
+	}
+	if resp.Count != 2 || string(resp.LastEvaluatedKey["placed"]) != "1700000100" {
+		t.Errorf("count = %d, last_evaluated_key = %v", resp.Count, resp.LastEvaluatedKey)
+	}
+	if resp.ConsumedCapacity != 2 {
+		t.Errorf("consumed_capacity = %d, want 2 units for 5100 bytes", resp.ConsumedCapacity)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutItemHandlerNeedsKey(t *testing.T) {
+	mock := setupMockDB(t)
+	expectTable(mock, ordersTable)
+	req := httptest.NewRequest("POST", "/api/cloudsim/dynamodb/tables/orders/items",
+		strings.NewReader(`{"item": {"customer": "ana", "total": 12}}`))
+	req.SetPathValue("name", "orders")
+	rr := httptest.NewRecorder()
+	PutItemHandler(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "placed") {
+		t.Errorf("status = %d: %s", rr.Code, rr.Body.String())
+	}
+}
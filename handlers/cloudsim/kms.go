@@ -0,0 +1,708 @@
+package cloudsim
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+	"allanswebterminal/vault"
+
+	"github.com/lib/pq"
+)
+
+// MaxPlaintext bounds what Encrypt takes, as in KMS: keys protect small
+// values such as secrets and data keys, not files.
+const MaxPlaintext = 4096
+
+// kmsBlobVersion starts every ciphertext blob; the key ID follows it.
+const kmsBlobVersion = 1
+
+// grantOperations are the operations a grant can allow.
+var grantOperations = []string{"Encrypt", "Decrypt", "DescribeKey"}
+
+var (
+	// ErrKeyNotFound is returned for a key ID or ARN the account has no
+	// key for.
+	ErrKeyNotFound = errors.New("no such KMS key")
+	// ErrKeyDisabled is returned for cryptographic operations on a
+	// disabled key.
+	ErrKeyDisabled = errors.New("the KMS key is disabled")
+	// ErrInvalidCiphertext is returned for blobs that are not KMS
+	// ciphertexts, were altered or are given another encryption context.
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+// AccessDeniedError is returned when the key policy, grants and the
+// caller's policies do not allow an operation.
+type AccessDeniedError struct {
+	Action     string
+	Evaluation iam.EvaluationResult
+}
+
+func (e *AccessDeniedError) Error() string {
+	return fmt.Sprintf("%s is not allowed: %s", e.Action, e.Evaluation.Reason)
+}
+
+// authorizeCaller checks that principalARN may call action on resource by
+// its own policies, for calls no resource policy governs. The account
+// root, an empty principalARN, may call anything.
+func authorizeCaller(ctx context.Context, accountID int, principalARN, action, resource string) error {
+	if principalARN == "" {
+		return nil
+	}
+	result, err := iam.Authorize(ctx, accountID, principalARN, action, resource, nil)
+	if err != nil {
+		return err
+	}
+	if result.Decision != "allowed" {
+		return &AccessDeniedError{Action: action, Evaluation: result}
+	}
+	return nil
+}
+
+// KMSKey is a simulated KMS key. Its key material never leaves the server.
+type KMSKey struct {
+	KeyID       string    `json:"key_id"`
+	ARN         string    `json:"arn"`
+	Description string    `json:"description"`
+	Policy      string    `json:"policy"`
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// KMSGrant lets a principal use a key for some operations, besides what
+// the key policy allows.
+type KMSGrant struct {
+	GrantID          string    `json:"grant_id"`
+	KeyID            string    `json:"key_id"`
+	GranteePrincipal string    `json:"grantee_principal"`
+	Operations       []string  `json:"operations"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// Requests that change or use a key are made as PrincipalARN, a user or
+// role of the account, or as the account root when it is empty.
+type CreateKeyRequest struct {
+	Description  string `json:"description"`
+	Policy       string `json:"policy,omitempty"`
+	PrincipalARN string `json:"principal_arn,omitempty"`
+}
+
+type PutKeyPolicyRequest struct {
+	Policy       string `json:"policy"`
+	PrincipalARN string `json:"principal_arn,omitempty"`
+}
+
+type SetKeyEnabledRequest struct {
+	Enabled      bool   `json:"enabled"`
+	PrincipalARN string `json:"principal_arn,omitempty"`
+}
+
+type CreateGrantRequest struct {
+	GranteePrincipal string   `json:"grantee_principal"`
+	Operations       []string `json:"operations"`
+	PrincipalARN     string   `json:"principal_arn,omitempty"`
+}
+
+type EncryptRequest struct {
+	KeyID             string            `json:"key_id"`
+	Plaintext         string            `json:"plaintext"`
+	EncryptionContext map[string]string `json:"encryption_context,omitempty"`
+	PrincipalARN      string            `json:"principal_arn,omitempty"`
+}
+
+type EncryptResponse struct {
+	KeyID          string `json:"key_id"`
+	CiphertextBlob []byte `json:"ciphertext_blob"`
+}
+
+type DecryptRequest struct {
+	CiphertextBlob    []byte            `json:"ciphertext_blob"`
+	EncryptionContext map[string]string `json:"encryption_context,omitempty"`
+	PrincipalARN      string            `json:"principal_arn,omitempty"`
+}
+
+type DecryptResponse struct {
+	KeyID     string `json:"key_id"`
+	Plaintext string `json:"plaintext"`
+}
+
+// kmsKey is a key with its sealed material.
+type kmsKey struct {
+	KMSKey
+	accountID int
+	material  []byte
+}
+
+// newUUID returns a random UUID, the form of KMS key IDs and SQS message
+// IDs.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// defaultKeyPolicy is the policy KMS gives a key created without one: it
+// lets the account's IAM policies decide.
+func defaultKeyPolicy(accountID int) string {
+	return fmt.Sprintf(`{"Version": "2012-10-17", "Id": "key-default-1", "Statement": [{"Sid": "Enable IAM User Permissions",
+		"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::%s:root"}, "Action": "kms:*", "Resource": "*"}]}`,
+		accounts.SimAccountNumber(accountID))
+}
+
+// materialContext binds a key's sealed material to the key.
+func materialContext(accountID int, keyID string) []byte {
+	return []byte(fmt.Sprintf("kms:%d:%s", accountID, keyID))
+}
+
+// keyIDOf accepts a key ID or a key ARN.
+func keyIDOf(id string) string {
+	if i := strings.LastIndex(id, ":key/"); i >= 0 {
+		return id[i+len(":key/"):]
+	}
+	return id
+}
+
+func loadKey(ctx context.Context, accountID int, keyID string) (*kmsKey, error) {
+	k := &kmsKey{accountID: accountID}
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT key_id, arn, description, policy, enabled, created_at, material
+		FROM kms_keys WHERE account_id = $1 AND key_id = $2`,
+		accountID, keyIDOf(keyID),
+	).Scan(&k.KeyID, &k.ARN, &k.Description, &k.Policy, &k.Enabled, &k.CreatedAt, &k.material)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	return k, err
+}
+
+func loadGrants(ctx context.Context, accountID int, keyID string) ([]KMSGrant, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT grant_id, key_id, grantee_principal, operations, created_at
+		FROM kms_grants WHERE account_id = $1 AND key_id = $2
+		ORDER BY created_at`, accountID, keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []KMSGrant{}
+	for rows.Next() {
+		var g KMSGrant
+		if err := rows.Scan(&g.GrantID, &g.KeyID, &g.GranteePrincipal, pq.Array(&g.Operations), &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// authorize checks that the caller may call kms:operation on k. The key
+// policy must allow it, as in KMS, and each grant adds an Allow for its
+// grantee.
+func (k *kmsKey) authorize(ctx context.Context, principalARN, operation string) error {
+	grants, err := loadGrants(ctx, k.accountID, k.KeyID)
+	if err != nil {
+		return err
+	}
+	policy := &iam.ResourcePolicy{Kind: "key policy", Document: k.Policy, Owner: accounts.SimAccountNumber(k.accountID), Required: true}
+	for _, g := range grants {
+		actions := make([]string, len(g.Operations))
+		for i, op := range g.Operations {
+			actions[i] = "kms:" + op
+		}
+		policy.Statements = append(policy.Statements, iam.Statement{
+			Sid: "Grant" + g.GrantID[:8], Effect: "Allow", Principal: iam.PrincipalJSON(g.GranteePrincipal), Action: actions,
+		})
+	}
+	result, err := iam.Authorize(ctx, k.accountID, principalARN, "kms:"+operation, k.ARN, policy)
+	if err != nil {
+		return err
+	}
+	if result.Decision != "allowed" {
+		return &AccessDeniedError{Action: "kms:" + operation, Evaluation: result}
+	}
+	return nil
+}
+
+// aead opens the key's material.
+func (k *kmsKey) aead() (cipher.AEAD, error) {
+	material, err := vault.Open(k.material, materialContext(k.accountID, k.KeyID))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(material)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// contextAAD binds a ciphertext to its encryption context. Map keys are
+// marshaled in order, so equal contexts give equal bytes.
+func contextAAD(encryptionContext map[string]string) []byte {
+	if len(encryptionContext) == 0 {
+		return nil
+	}
+	aad, _ := json.Marshal(encryptionContext)
+	return aad
+}
+
+func encrypt(ctx context.Context, accountID int, principalARN, keyID string, plaintext []byte, encryptionContext map[string]string) (*EncryptResponse, error) {
+	if !vault.Configured() {
+		return nil, vault.ErrNotConfigured
+	}
+	k, err := loadKey(ctx, accountID, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.authorize(ctx, principalARN, "Encrypt"); err != nil {
+		return nil, err
+	}
+	if !k.Enabled {
+		return nil, ErrKeyDisabled
+	}
+	a, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte{kmsBlobVersion, byte(len(k.KeyID))}, k.KeyID...)
+	nonce := make([]byte, a.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	blob = append(blob, nonce...)
+	return &EncryptResponse{KeyID: k.ARN, CiphertextBlob: a.Seal(blob, nonce, plaintext, contextAAD(encryptionContext))}, nil
+}
+
+func decrypt(ctx context.Context, accountID int, principalARN string, blob []byte, encryptionContext map[string]string) (*KMSKey, []byte, error) {
+	if !vault.Configured() {
+		return nil, nil, vault.ErrNotConfigured
+	}
+	if len(blob) < 2 || blob[0] != kmsBlobVersion || len(blob) < 2+int(blob[1]) {
+		return nil, nil, ErrInvalidCiphertext
+	}
+	keyID, rest := string(blob[2:2+int(blob[1])]), blob[2+int(blob[1]):]
+	k, err := loadKey(ctx, accountID, keyID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, nil, ErrInvalidCiphertext
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := k.authorize(ctx, principalARN, "Decrypt"); err != nil {
+		return nil, nil, err
+	}
+	if !k.Enabled {
+		return nil, nil, ErrKeyDisabled
+	}
+	a, err := k.aead()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) < a.NonceSize() {
+		return nil, nil, ErrInvalidCiphertext
+	}
+	plaintext, err := a.Open(nil, rest[:a.NonceSize()], rest[a.NonceSize():], contextAAD(encryptionContext))
+	if err != nil {
+		return nil, nil, ErrInvalidCiphertext
+	}
+	return &k.KMSKey, plaintext, nil
+}
+
+// Encrypt encrypts plaintext under the account's key keyID (an ID or
+// ARN) as the account root, for other services such as secrets.
+func Encrypt(ctx context.Context, accountID int, keyID string, plaintext []byte, encryptionContext map[string]string) ([]byte, error) {
+	resp, err := encrypt(ctx, accountID, "", keyID, plaintext, encryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+// Decrypt decrypts a blob from Encrypt as the account root. It fails once
+// the key is disabled or its policy no longer allows the account.
+func Decrypt(ctx context.Context, accountID int, blob []byte, encryptionContext map[string]string) ([]byte, error) {
+	_, plaintext, err := decrypt(ctx, accountID, "", blob, encryptionContext)
+	return plaintext, err
+}
+
+// writeKMSError writes the response for an error from a KMS operation.
+func writeKMSError(w http.ResponseWriter, err error, action string) {
+	var denied *AccessDeniedError
+	switch {
+	case errors.As(err, &denied):
+		apierror.Write(w, apierror.Forbidden(denied.Error()).WithDetails(denied.Evaluation))
+	case errors.Is(err, vault.ErrNotConfigured):
+		apierror.Write(w, apierror.Unavailable("KMS is not configured on this server").
+			WithDetails(map[string]string{"reason": "not_configured"}))
+	case errors.Is(err, ErrKeyNotFound):
+		apierror.Write(w, apierror.NotFound("Key not found"))
+	case errors.Is(err, iam.ErrNoSuchPrincipal):
+		apierror.Write(w, apierror.NotFound("No user or role with that principal ARN"))
+	case errors.Is(err, ErrKeyDisabled):
+		apierror.Write(w, apierror.Conflict("The key is disabled"))
+	case errors.Is(err, ErrInvalidCiphertext):
+		apierror.Write(w, apierror.Validation("The ciphertext is invalid or the encryption context does not match"))
+	default:
+		log.Printf("Failed to %s: %v", action, err)
+		apierror.Write(w, apierror.Internal("Failed to "+action))
+	}
+}
+
+// CreateKeyHandler creates a symmetric key. Without a policy it gets the
+// default key policy, which leaves access to the account's IAM policies.
+func CreateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.Policy == "" {
+		req.Policy = defaultKeyPolicy(accountID)
+	}
+	if err := iam.ValidateResourcePolicy("key policy", req.Policy); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if !vault.Configured() {
+		writeKMSError(w, vault.ErrNotConfigured, "create key")
+		return
+	}
+	if err := authorizeCaller(r.Context(), accountID, req.PrincipalARN, "kms:CreateKey", "*"); err != nil {
+		writeKMSError(w, err, "create key")
+		return
+	}
+
+	k := KMSKey{KeyID: newUUID(), Description: req.Description, Policy: req.Policy, Enabled: true}
+	k.ARN = fmt.Sprintf("arn:aws:kms:%s:%s:key/%s", region, accounts.SimAccountNumber(accountID), k.KeyID)
+	material := make([]byte, 32)
+	rand.Read(material)
+	sealed, err := vault.Seal(material, materialContext(accountID, k.KeyID))
+	if err != nil {
+		writeKMSError(w, err, "create key")
+		return
+	}
+	err = db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO kms_keys (account_id, key_id, arn, description, policy, material)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`,
+		accountID, k.KeyID, k.ARN, k.Description, k.Policy, sealed,
+	).Scan(&k.CreatedAt)
+	if err != nil {
+		writeKMSError(w, err, "create key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(k)
+}
+
+// ListKeysHandler lists the account's keys, oldest first.
+func ListKeysHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(), `
+		SELECT key_id, arn, description, policy, enabled, created_at
+		FROM kms_keys WHERE account_id = $1 ORDER BY created_at`, accountID)
+	if err != nil {
+		writeKMSError(w, err, "list keys")
+		return
+	}
+	defer rows.Close()
+
+	keys := []KMSKey{}
+	for rows.Next() {
+		var k KMSKey
+		if err := rows.Scan(&k.KeyID, &k.ARN, &k.Description, &k.Policy, &k.Enabled, &k.CreatedAt); err != nil {
+			writeKMSError(w, err, "list keys")
+			return
+		}
+		keys = append(keys, k)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// GetKeyHandler describes the key {id}, a key ID or ARN.
+func GetKeyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	k, err := loadKey(r.Context(), accountID, r.PathValue("id"))
+	if err != nil {
+		writeKMSError(w, err, "load key")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(k.KMSKey)
+}
+
+// PutKeyPolicyHandler replaces the policy of the key {id}. The account
+// root can always replace it, so a key whose policy locks everyone out
+// can be recovered; a user or role needs kms:PutKeyPolicy.
+func PutKeyPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req PutKeyPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := iam.ValidateResourcePolicy("key policy", req.Policy); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	k, err := loadKey(r.Context(), accountID, r.PathValue("id"))
+	if err != nil {
+		writeKMSError(w, err, "update key policy")
+		return
+	}
+	if req.PrincipalARN != "" {
+		if err := k.authorize(r.Context(), req.PrincipalARN, "PutKeyPolicy"); err != nil {
+			writeKMSError(w, err, "update key policy")
+			return
+		}
+	}
+
+	if _, err := db.DB.ExecContext(r.Context(), "UPDATE kms_keys SET policy = $3 WHERE account_id = $1 AND key_id = $2",
+		accountID, k.KeyID, req.Policy); err != nil {
+		writeKMSError(w, err, "update key policy")
+		return
+	}
+	k.Policy = req.Policy
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(k.KMSKey)
+}
+
+// SetKeyEnabledHandler enables or disables the key {id}. A disabled key
+// can't encrypt or decrypt.
+func SetKeyEnabledHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req SetKeyEnabledRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	k, err := loadKey(r.Context(), accountID, r.PathValue("id"))
+	if err != nil {
+		writeKMSError(w, err, "update key")
+		return
+	}
+	operation := "DisableKey"
+	if req.Enabled {
+		operation = "EnableKey"
+	}
+	if err := k.authorize(r.Context(), req.PrincipalARN, operation); err != nil {
+		writeKMSError(w, err, "update key")
+		return
+	}
+
+	if _, err := db.DB.ExecContext(r.Context(), "UPDATE kms_keys SET enabled = $3 WHERE account_id = $1 AND key_id = $2",
+		accountID, k.KeyID, req.Enabled); err != nil {
+		writeKMSError(w, err, "update key")
+		return
+	}
+	k.Enabled = req.Enabled
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(k.KMSKey)
+}
+
+// CreateGrantHandler lets a principal use the key {id} for some of
+// Encrypt, Decrypt and DescribeKey, whatever the key policy says.
+func CreateGrantHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreateGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if !strings.HasPrefix(req.GranteePrincipal, "arn:aws:iam::") {
+		apierror.Write(w, apierror.Validation("grantee_principal must be an IAM ARN"))
+		return
+	}
+	if len(req.Operations) == 0 {
+		apierror.Write(w, apierror.Validation("operations are required"))
+		return
+	}
+	for _, op := range req.Operations {
+		if !slices.Contains(grantOperations, op) {
+			apierror.Write(w, apierror.Validation("operations must be among "+strings.Join(grantOperations, ", ")))
+			return
+		}
+	}
+	k, err := loadKey(r.Context(), accountID, r.PathValue("id"))
+	if err != nil {
+		writeKMSError(w, err, "create grant")
+		return
+	}
+	if err := k.authorize(r.Context(), req.PrincipalARN, "CreateGrant"); err != nil {
+		writeKMSError(w, err, "create grant")
+		return
+	}
+
+	id := make([]byte, 32)
+	rand.Read(id)
+	g := KMSGrant{GrantID: hex.EncodeToString(id), KeyID: k.KeyID, GranteePrincipal: req.GranteePrincipal, Operations: req.Operations}
+	err = db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO kms_grants (grant_id, account_id, key_id, grantee_principal, operations)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at`,
+		g.GrantID, accountID, g.KeyID, g.GranteePrincipal, pq.Array(g.Operations),
+	).Scan(&g.CreatedAt)
+	if err != nil {
+		writeKMSError(w, err, "create grant")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(g)
+}
+
+// ListGrantsHandler lists the grants of the key {id}.
+func ListGrantsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	k, err := loadKey(r.Context(), accountID, r.PathValue("id"))
+	if err != nil {
+		writeKMSError(w, err, "list grants")
+		return
+	}
+	grants, err := loadGrants(r.Context(), accountID, k.KeyID)
+	if err != nil {
+		writeKMSError(w, err, "list grants")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grants)
+}
+
+// RevokeGrantHandler deletes the grant {grant_id} of the key {id}.
+func RevokeGrantHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(),
+		"DELETE FROM kms_grants WHERE account_id = $1 AND key_id = $2 AND grant_id = $3",
+		accountID, keyIDOf(r.PathValue("id")), r.PathValue("grant_id"))
+	if err != nil {
+		writeKMSError(w, err, "revoke grant")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Grant not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EncryptHandler encrypts a small plaintext under a key with AES-256-GCM.
+// The ciphertext blob names the key, so decrypting needs no key ID, and is
+// bound to the encryption context, which decrypting must repeat.
+func EncryptHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req EncryptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.KeyID == "" || req.Plaintext == "" || len(req.Plaintext) > MaxPlaintext {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("key_id and a plaintext of 1-%d bytes are required", MaxPlaintext)))
+		return
+	}
+
+	resp, err := encrypt(r.Context(), accountID, req.PrincipalARN, req.KeyID, []byte(req.Plaintext), req.EncryptionContext)
+	if err != nil {
+		writeKMSError(w, err, "encrypt")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DecryptHandler decrypts a ciphertext blob from EncryptHandler.
+func DecryptHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req DecryptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if len(req.CiphertextBlob) == 0 {
+		apierror.Write(w, apierror.Validation("ciphertext_blob is required"))
+		return
+	}
+
+	k, plaintext, err := decrypt(r.Context(), accountID, req.PrincipalARN, req.CiphertextBlob, req.EncryptionContext)
+	if err != nil {
+		writeKMSError(w, err, "decrypt")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DecryptResponse{KeyID: k.ARN, Plaintext: string(plaintext)})
+}
@@ -0,0 +1,124 @@
+package cloudsim
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/vault"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+const testKeyID = "1234abcd-12ab-34cd-56ef-1234567890ab"
+
+func useVaultKey(t *testing.T) {
+	t.Helper()
+	key, _ := vault.ParseKey(vault.NewKey())
+	if err := vault.SetKey(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { vault.SetKey(nil) })
+}
+
+// expectKey expects a key to be loaded and its grants listed, as every
+// cryptographic operation does.
+func expectKey(mock sqlmock.Sqlmock, policy string, enabled bool, material []byte, grants ...KMSGrant) {
+	mock.ExpectQuery("SELECT key_id, arn, description, policy, enabled, created_at, material FROM kms_keys").
+		WithArgs(1, testKeyID).
+		WillReturnRows(sqlmock.NewRows([]string{"key_id", "arn", "description", "policy", "enabled", "created_at", "material"}).
+			AddRow(testKeyID, "arn:aws:kms:us-east-1:000000000001:key/"+testKeyID, "", policy, enabled, time.Now(), material))
+	rows := sqlmock.NewRows([]string{"grant_id", "key_id", "grantee_principal", "operations", "created_at"})
+	for _, g := range grants {
+		rows.AddRow(g.GrantID, testKeyID, g.GranteePrincipal, pq.Array(g.Operations), time.Now())
+	}
+	mock.ExpectQuery("SELECT grant_id, key_id, grantee_principal, operations, created_at FROM kms_grants").
+		WithArgs(1, testKeyID).WillReturnRows(rows)
+}
+
+func TestKMSEncryptDecrypt(t *testing.T) {
+	useVaultKey(t)
+	mock := setupMockDB(t)
+	material, _ := vault.Seal(make([]byte, 32), materialContext(1, testKeyID))
+	ctx := context.Background()
+	ec := map[string]string{"purpose": "lab"}
+
+	expectKey(mock, defaultKeyPolicy(1), true, material)
+	blob, err := Encrypt(ctx, 1, "arn:aws:kms:us-east-1:000000000001:key/"+testKeyID, []byte("hunter2"), ec)
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	expectKey(mock, defaultKeyPolicy(1), true, material)
+	if plaintext, err := Decrypt(ctx, 1, blob, ec); err != nil || string(plaintext) != "hunter2" {
+		t.Errorf("Decrypt() = %q, %v", plaintext, err)
+	}
+
+	expectKey(mock, defaultKeyPolicy(1), true, material)
+	if _, err := Decrypt(ctx, 1, blob, map[string]string{"purpose": "other"}); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Decrypt() with another context: %v, want ErrInvalidCiphertext", err)
+	}
+
+	expectKey(mock, defaultKeyPolicy(1), false, material)
+	if _, err := Decrypt(ctx, 1, blob, ec); !errors.Is(err, ErrKeyDisabled) {
+		t.Errorf("Decrypt() with a disabled key: %v, want ErrKeyDisabled", err)
+	}
+
+	if _, err := Decrypt(ctx, 1, []byte("not a blob"), ec); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("Decrypt() of garbage: %v, want ErrInvalidCiphertext", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKMSKeyPolicyAndGrants(t *testing.T) {
+	useVaultKey(t)
+	mock := setupMockDB(t)
+	material, _ := vault.Seal(make([]byte, 32), materialContext(1, testKeyID))
+	// Only the app role may use the key; the account itself may not.
+	policy := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow",
+		"Principal": {"AWS": "arn:aws:iam::000000000001:role/app"}, "Action": "kms:*", "Resource": "*"}]}`
+
+	expectKey(mock, policy, true, material)
+	_, err := Encrypt(context.Background(), 1, testKeyID, []byte("x"), nil)
+	var denied *AccessDeniedError
+	if !errors.As(err, &denied) || denied.Evaluation.Decision != "implicitDeny" {
+		t.Fatalf("Encrypt() error = %v, want an implicit deny", err)
+	}
+
+	grant := KMSGrant{GrantID: strings.Repeat("ab", 32), GranteePrincipal: "arn:aws:iam::000000000001:root", Operations: []string{"Encrypt"}}
+	expectKey(mock, policy, true, material, grant)
+	if _, err := Encrypt(context.Background(), 1, testKeyID, []byte("x"), nil); err != nil {
+		t.Errorf("Encrypt() with a grant: %v", err)
+	}
+}
+
+func TestEncryptHandlerChecksRequest(t *testing.T) {
+	useVaultKey(t)
+	for _, body := range []string{
+		`{"plaintext": "x"}`,
+		`{"key_id": "` + testKeyID + `"}`,
+		`{"key_id": "` + testKeyID + `", "plaintext": "` + strings.Repeat("x", MaxPlaintext+1) + `"}`,
+	} {
+		rr := httptest.NewRecorder()
+		EncryptHandler(rr, httptest.NewRequest("POST", "/api/cloudsim/kms/encrypt", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%.40s: status = %d, want 400", body, rr.Code)
+		}
+	}
+}
+
+func TestEncryptHandlerNotConfigured(t *testing.T) {
+	rr := httptest.NewRecorder()
+	EncryptHandler(rr, httptest.NewRequest("POST", "/api/cloudsim/kms/encrypt",
+		strings.NewReader(`{"key_id": "`+testKeyID+`", "plaintext": "x"}`)))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "not_configured") {
+		t.Errorf("status = %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,316 @@
+package cloudsim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+
+	"github.com/lib/pq"
+)
+
+// metricRetention is how long metric points are kept.
+const metricRetention = 24 * time.Hour
+
+// metricDef is a synthetic metric emitted every minute for each resource of
+// a type. Each value is a step away from the previous one, so series wander
+// like real load instead of jumping around.
+type metricDef struct {
+	Namespace string
+	Name      string
+	Unit      string
+	start     float64
+	step      func(prev float64) float64
+}
+
+// noise returns a standard normal sample; tests replace it.
+var noise = rand.NormFloat64
+
+// metricDefs lists the metrics emitted per resource type.
+var metricDefs = map[string][]metricDef{
+	TypeInstance: {{
+		Namespace: "AWS/EC2", Name: "CPUUtilization", Unit: "Percent", start: 20,
+		step: func(prev float64) float64 { return clamp(prev+5*noise(), 0, 100) },
+	}},
+	TypeFunction: {{
+		Namespace: "AWS/Lambda", Name: "Invocations", Unit: "Count", start: 10,
+		step: func(prev float64) float64 { return math.Round(clamp(prev+3*noise(), 0, 1000)) },
+	}},
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// findMetric returns the definition of namespace/name.
+func findMetric(namespace, name string) (metricDef, bool) {
+	for _, defs := range metricDefs {
+		for _, def := range defs {
+			if def.Namespace == namespace && def.Name == name {
+				return def, true
+			}
+		}
+	}
+	return metricDef{}, false
+}
+
+// seriesKey identifies one time series.
+type seriesKey struct {
+	accountID  int
+	namespace  string
+	metric     string
+	resourceID string
+}
+
+// lastValues returns the latest value of every series written since since.
+func lastValues(ctx context.Context, since time.Time) (map[seriesKey]float64, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT DISTINCT ON (account_id, namespace, metric_name, resource_id)
+			account_id, namespace, metric_name, resource_id, value
+		FROM metric_points
+		WHERE ts >= $1
+		ORDER BY account_id, namespace, metric_name, resource_id, ts DESC`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	last := make(map[seriesKey]float64)
+	for rows.Next() {
+		var k seriesKey
+		var v float64
+		if err := rows.Scan(&k.accountID, &k.namespace, &k.metric, &k.resourceID, &v); err != nil {
+			return nil, err
+		}
+		last[k] = v
+	}
+	return last, rows.Err()
+}
+
+// emit writes one point per metric of every simulated resource at now,
+// drops points past the retention and evaluates the alarms.
+func emit(ctx context.Context, now time.Time) error {
+	ts := now.Truncate(time.Minute)
+	last, err := lastValues(ctx, ts.Add(-10*time.Minute))
+	if err != nil {
+		return fmt.Errorf("failed to load latest metric values: %w", err)
+	}
+
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT account_id, resource_type, resource_id FROM cloudsim_resources ORDER BY account_id, resource_id")
+	if err != nil {
+		return fmt.Errorf("failed to list resources: %w", err)
+	}
+	var accounts []int
+	var namespaces, metrics, resources []string
+	var values []float64
+	for rows.Next() {
+		var accountID int
+		var resourceType, resourceID string
+		if err := rows.Scan(&accountID, &resourceType, &resourceID); err != nil {
+			rows.Close()
+			return err
+		}
+		for _, def := range metricDefs[resourceType] {
+			prev, ok := last[seriesKey{accountID, def.Namespace, def.Name, resourceID}]
+			if !ok {
+				prev = def.start
+			}
+			accounts = append(accounts, accountID)
+			namespaces = append(namespaces, def.Namespace)
+			metrics = append(metrics, def.Name)
+			resources = append(resources, resourceID)
+			values = append(values, def.step(prev))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(values) > 0 {
+		_, err = db.DB.ExecContext(ctx, `
+			INSERT INTO metric_points (account_id, namespace, metric_name, resource_id, ts, value)
+			SELECT a, n, m, r, $5, v
+			FROM unnest($1::int[], $2::text[], $3::text[], $4::text[], $6::float8[]) AS p(a, n, m, r, v)
+			ON CONFLICT DO NOTHING`,
+			pq.Array(accounts), pq.Array(namespaces), pq.Array(metrics), pq.Array(resources), ts, pq.Array(values))
+		if err != nil {
+			return fmt.Errorf("failed to store metric points: %w", err)
+		}
+	}
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM metric_points WHERE ts < $1", ts.Add(-metricRetention)); err != nil {
+		return fmt.Errorf("failed to prune metric points: %w", err)
+	}
+	return evaluateAlarms(ctx, now)
+}
+
+// EmitMetrics writes this minute's synthetic metrics and evaluates alarms.
+// It runs from the scheduler.
+func EmitMetrics(ctx context.Context) error {
+	return emit(ctx, time.Now().UTC())
+}
+
+// statistics maps the CloudWatch statistic names to SQL aggregates.
+var statistics = map[string]string{
+	"Average":     "AVG",
+	"Sum":         "SUM",
+	"Minimum":     "MIN",
+	"Maximum":     "MAX",
+	"SampleCount": "COUNT",
+}
+
+type Datapoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// datapoints aggregates a series into periods aligned to the epoch, for
+// points in [since, until), oldest first. Periods without points are left
+// out.
+func datapoints(ctx context.Context, k seriesKey, statistic string, period time.Duration, since, until time.Time) ([]Datapoint, error) {
+	seconds := int64(period / time.Second)
+	query := fmt.Sprintf(`
+		SELECT (FLOOR(EXTRACT(EPOCH FROM ts) / $5) * $5)::bigint AS bucket, %s(value)
+		FROM metric_points
+		WHERE account_id = $1 AND namespace = $2 AND metric_name = $3 AND resource_id = $4
+			AND ts >= $6 AND ts < $7
+		GROUP BY bucket
+		ORDER BY bucket`, statistics[statistic])
+	rows, err := db.DB.QueryContext(ctx, query, k.accountID, k.namespace, k.metric, k.resourceID, seconds, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []Datapoint{}
+	for rows.Next() {
+		var bucket int64
+		var p Datapoint
+		if err := rows.Scan(&bucket, &p.Value); err != nil {
+			return nil, err
+		}
+		p.Timestamp = time.Unix(bucket, 0).UTC()
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+type Metric struct {
+	Namespace  string `json:"namespace"`
+	MetricName string `json:"metric_name"`
+	ResourceID string `json:"resource_id"`
+	Unit       string `json:"unit"`
+}
+
+type MetricData struct {
+	Namespace  string      `json:"namespace"`
+	MetricName string      `json:"metric_name"`
+	ResourceID string      `json:"resource_id"`
+	Statistic  string      `json:"statistic"`
+	Unit       string      `json:"unit"`
+	Datapoints []Datapoint `json:"datapoints"`
+}
+
+// ListMetricsHandler lists the metrics of the account's resources, like
+// cloudwatch list-metrics.
+func ListMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	resources, err := loadResources(r.Context(), accountID, "")
+	if err != nil {
+		log.Printf("Failed to load resources: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load metrics"))
+		return
+	}
+	metrics := []Metric{}
+	for _, res := range resources {
+		for _, def := range metricDefs[res.Type] {
+			metrics = append(metrics, Metric{Namespace: def.Namespace, MetricName: def.Name, ResourceID: res.ResourceID, Unit: def.Unit})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// queryInt reads an optional integer query parameter within [lo, hi].
+func queryInt(r *http.Request, name string, def, lo, hi int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, lo, hi)
+	}
+	return n, nil
+}
+
+// GetMetricDataHandler returns one series aggregated into periods, like
+// cloudwatch get-metric-statistics. Query parameters: namespace,
+// metric_name and resource_id (required), statistic (default Average),
+// period in minutes (default 5) and minutes of history (default 60).
+func GetMetricDataHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	q := r.URL.Query()
+	data := MetricData{
+		Namespace:  q.Get("namespace"),
+		MetricName: q.Get("metric_name"),
+		ResourceID: q.Get("resource_id"),
+		Statistic:  q.Get("statistic"),
+	}
+	def, ok := findMetric(data.Namespace, data.MetricName)
+	if !ok || data.ResourceID == "" {
+		apierror.Write(w, apierror.Validation("namespace, metric_name and resource_id must name a simulated metric"))
+		return
+	}
+	data.Unit = def.Unit
+	if data.Statistic == "" {
+		data.Statistic = "Average"
+	}
+	if _, ok := statistics[data.Statistic]; !ok {
+		apierror.Write(w, apierror.Validation("Unknown statistic "+data.Statistic))
+		return
+	}
+	period, err := queryInt(r, "period", 5, 1, 60)
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	minutes, err := queryInt(r, "minutes", 60, 1, int(metricRetention/time.Minute))
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	now := time.Now().UTC()
+	k := seriesKey{accountID, data.Namespace, data.MetricName, data.ResourceID}
+	data.Datapoints, err = datapoints(r.Context(), k, data.Statistic, time.Duration(period)*time.Minute,
+		now.Add(-time.Duration(minutes)*time.Minute), now)
+	if err != nil {
+		log.Printf("Failed to load metric data: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load metric data"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
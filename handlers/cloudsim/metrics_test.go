@@ -0,0 +1,57 @@
+package cloudsim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEmit(t *testing.T) {
+	mock := setupMockDB(t)
+	originalNoise := noise
+	noise = func() float64 { return 1 }
+	t.Cleanup(func() { noise = originalNoise })
+
+	now := time.Date(2026, 3, 1, 12, 0, 30, 0, time.UTC)
+	minute := now.Truncate(time.Minute)
+
+	mock.ExpectQuery("SELECT DISTINCT ON").WithArgs(minute.Add(-10 * time.Minute)).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "namespace", "metric_name", "resource_id", "value"}).
+			AddRow(1, "AWS/EC2", "CPUUtilization", "i-0abc", 98.0))
+	mock.ExpectQuery("FROM cloudsim_resources").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "resource_type", "resource_id"}).
+			AddRow(1, TypeInstance, "i-0abc").
+			AddRow(1, TypeFunction, "resize").
+			AddRow(2, "s3:bucket", "unknown"))
+	// The instance walks from 98 and is capped at 100; the new function
+	// starts from its metric's initial value.
+	mock.ExpectExec("INSERT INTO metric_points").
+		WithArgs("{1,1}", `{"AWS/EC2","AWS/Lambda"}`, `{"CPUUtilization","Invocations"}`, `{"i-0abc","resize"}`, minute, "{100,13}").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM metric_points").WithArgs(minute.Add(-metricRetention)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectQuery("FROM metric_alarms").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "name", "namespace", "metric_name", "resource_id",
+			"statistic", "period_minutes", "evaluation_periods", "comparison_operator", "threshold",
+			"state", "state_reason", "state_updated_at", "created_at"}).
+			AddRow(7, 1, "high-cpu", "AWS/EC2", "CPUUtilization", "i-0abc",
+				"Average", 1, 2, "GreaterThanThreshold", 90.0, StateOK, "", now, now))
+	mock.ExpectQuery("AVG\\(value\\)").WithArgs(1, "AWS/EC2", "CPUUtilization", "i-0abc", int64(60), minute.Add(-2*time.Minute), minute).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "avg"}).
+			AddRow(minute.Add(-2*time.Minute).Unix(), 95.0).
+			AddRow(minute.Add(-time.Minute).Unix(), 98.0))
+	mock.ExpectExec("UPDATE metric_alarms").WithArgs(StateAlarm, sqlmock.AnyArg(), now, 7, StateOK).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO notifications").WithArgs(1, "alarm", `ALARM: "high-cpu"`, sqlmock.AnyArg(), "/cloudsimulator").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+
+	if err := emit(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,289 @@
+package cloudsim
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+)
+
+// region is the only region the simulator has.
+const region = "us-east-1"
+
+// Types of the resources kept in cloudsim_resources.
+const (
+	TypeInstance = "ec2:instance"
+	TypeFunction = "lambda:function"
+	TypeVPC      = "ec2:vpc"
+	TypeBucket   = "s3:bucket"
+)
+
+var (
+	functionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	bucketNamePattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+)
+
+// ResourceAttributes link a resource to others. Instances and functions
+// may run in a VPC under a role (the instance profile or execution role);
+// buckets may have a bucket policy.
+type ResourceAttributes struct {
+	VPCID    string `json:"vpc_id,omitempty"`
+	RoleName string `json:"role_name,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+}
+
+// Resource is a simulated EC2 instance, Lambda function, VPC or S3 bucket.
+// ResourceID is the instance or VPC id, or the function or bucket name.
+type Resource struct {
+	ResourceID string             `json:"resource_id"`
+	Type       string             `json:"type"`
+	Name       string             `json:"name"`
+	ARN        string             `json:"arn"`
+	Attributes ResourceAttributes `json:"attributes"`
+	Tags       map[string]string  `json:"tags"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+type CreateResourceRequest struct {
+	Type       string             `json:"type"`
+	Name       string             `json:"name"`
+	Attributes ResourceAttributes `json:"attributes"`
+	Tags       map[string]string  `json:"tags,omitempty"`
+}
+
+// ec2ID returns a new id such as "i-0123456789abcdef0".
+func ec2ID(prefix string) string {
+	b := make([]byte, 9)
+	rand.Read(b)
+	return prefix + "-" + hex.EncodeToString(b)[:17]
+}
+
+// newResource fills in the id and ARN of a resource of req.Type.
+func newResource(accountID int, req CreateResourceRequest) (*Resource, error) {
+	res := &Resource{Type: req.Type, Name: req.Name, Attributes: req.Attributes, Tags: req.Tags}
+	if res.Tags == nil {
+		res.Tags = map[string]string{}
+	}
+	attrs := req.Attributes
+	switch req.Type {
+	case TypeInstance, TypeVPC:
+		if len(req.Name) > 64 {
+			return nil, errors.New("name must be at most 64 characters")
+		}
+		if req.Type == TypeInstance {
+			res.ResourceID = ec2ID("i")
+			res.ARN = fmt.Sprintf("arn:aws:ec2:%s:%s:instance/%s", region, accounts.SimAccountNumber(accountID), res.ResourceID)
+		} else {
+			res.ResourceID = ec2ID("vpc")
+			res.ARN = fmt.Sprintf("arn:aws:ec2:%s:%s:vpc/%s", region, accounts.SimAccountNumber(accountID), res.ResourceID)
+		}
+	case TypeFunction:
+		if !functionNamePattern.MatchString(req.Name) {
+			return nil, errors.New("function names are 1 to 64 letters, digits, hyphens or underscores")
+		}
+		res.ResourceID = req.Name
+		res.ARN = functionARN(accountID, res.ResourceID)
+	case TypeBucket:
+		if !bucketNamePattern.MatchString(req.Name) {
+			return nil, errors.New("bucket names are 3 to 63 lowercase letters, digits, dots or hyphens")
+		}
+		res.ResourceID = req.Name
+		res.ARN = "arn:aws:s3:::" + res.ResourceID
+	default:
+		return nil, fmt.Errorf("type must be one of %q, %q, %q or %q", TypeInstance, TypeFunction, TypeVPC, TypeBucket)
+	}
+
+	runsCode := req.Type == TypeInstance || req.Type == TypeFunction
+	if !runsCode && (attrs.VPCID != "" || attrs.RoleName != "") {
+		return nil, errors.New("only instances and functions have a vpc_id or role_name")
+	}
+	if req.Type != TypeBucket && attrs.Policy != "" {
+		return nil, errors.New("only buckets have a policy")
+	}
+	if attrs.Policy != "" {
+		if _, err := iam.ResourcePolicyPrincipals(attrs.Policy); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// checkReferences returns a message when the VPC or role named by attrs
+// does not exist in the account.
+func checkReferences(ctx context.Context, accountID int, attrs ResourceAttributes) (string, error) {
+	if attrs.VPCID != "" {
+		var exists bool
+		err := db.DB.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM cloudsim_resources WHERE account_id = $1 AND resource_id = $2 AND resource_type = $3)",
+			accountID, attrs.VPCID, TypeVPC).Scan(&exists)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "No VPC " + attrs.VPCID, nil
+		}
+	}
+	if attrs.RoleName != "" {
+		var exists bool
+		err := db.DB.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM iam_roles WHERE account_id = $1 AND role_name = $2)",
+			accountID, attrs.RoleName).Scan(&exists)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "No role " + attrs.RoleName, nil
+		}
+	}
+	return "", nil
+}
+
+func loadResources(ctx context.Context, accountID int, resourceType string) ([]Resource, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT resource_id, resource_type, name, arn, attributes, tags, created_at
+		FROM cloudsim_resources
+		WHERE account_id = $1 AND ($2 = '' OR resource_type = $2)
+		ORDER BY created_at, resource_id`, accountID, resourceType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resources := []Resource{}
+	for rows.Next() {
+		var res Resource
+		var attrs, tags []byte
+		if err := rows.Scan(&res.ResourceID, &res.Type, &res.Name, &res.ARN, &attrs, &tags, &res.CreatedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(attrs, &res.Attributes)
+		res.Tags = map[string]string{}
+		json.Unmarshal(tags, &res.Tags)
+		resources = append(resources, res)
+	}
+	return resources, rows.Err()
+}
+
+// ListResourcesHandler lists the account's simulated resources, optionally
+// only those of ?type=.
+func ListResourcesHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	resources, err := loadResources(r.Context(), accountID, r.URL.Query().Get("type"))
+	if err != nil {
+		log.Printf("Failed to load resources: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load resources"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resources)
+}
+
+// CreateResourceHandler creates a simulated resource. Metrics for instances
+// and functions start with the next emitter run.
+func CreateResourceHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreateResourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	res, err := newResource(accountID, req)
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	problem, err := checkReferences(r.Context(), accountID, res.Attributes)
+	if err != nil {
+		log.Printf("Failed to check resource references: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create resource"))
+		return
+	}
+	if problem != "" {
+		apierror.Write(w, apierror.NotFound(problem))
+		return
+	}
+	attrs, _ := json.Marshal(res.Attributes)
+	tags, _ := json.Marshal(res.Tags)
+
+	err = db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO cloudsim_resources (account_id, resource_id, resource_type, name, arn, attributes, tags)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (account_id, resource_id) DO NOTHING
+		RETURNING created_at`,
+		accountID, res.ResourceID, res.Type, res.Name, res.ARN, attrs, tags,
+	).Scan(&res.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("A resource with that name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create resource: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create resource"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// DeleteResourceHandler deletes the resource {id}. A VPC must be empty
+// first. Metrics of a deleted resource age out, and alarms on them fall
+// back to INSUFFICIENT_DATA.
+func DeleteResourceHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id := r.PathValue("id")
+
+	var members int
+	err := db.DB.QueryRowContext(r.Context(),
+		"SELECT COUNT(*) FROM cloudsim_resources WHERE account_id = $1 AND attributes->>'vpc_id' = $2",
+		accountID, id).Scan(&members)
+	if err != nil {
+		log.Printf("Failed to check VPC members: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete resource"))
+		return
+	}
+	if members > 0 {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("The VPC has %d resources in it", members)))
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(), "DELETE FROM cloudsim_resources WHERE account_id = $1 AND resource_id = $2",
+		accountID, id)
+	if err != nil {
+		log.Printf("Failed to delete resource: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete resource"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Resource not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,64 @@
+package cloudsim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+	"allanswebterminal/vault"
+)
+
+// ServiceStatus is a simulated service in the service catalog. Reason
+// says why a disabled service cannot be used.
+type ServiceStatus struct {
+	iam.ServiceDef
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// unavailableReason returns why the service prefix cannot be used on this
+// server, or "" if it can. Every service keeps its state in the database;
+// KMS also needs the vault key its key material is sealed with.
+func unavailableReason(prefix string) string {
+	if !db.Available() {
+		return "the database is not available"
+	}
+	if prefix == "kms" && !vault.Configured() {
+		return "no vault key is configured"
+	}
+	return ""
+}
+
+// serviceCatalog returns the simulated services with the actions whose
+// "service:Action" name starts with prefix, ignoring case. Services with
+// no such action are left out.
+func serviceCatalog(prefix string) []ServiceStatus {
+	prefix = strings.ToLower(prefix)
+	catalog := []ServiceStatus{}
+	for _, s := range iam.Services() {
+		actions := s.Actions[:0]
+		for _, a := range s.Actions {
+			if strings.HasPrefix(strings.ToLower(s.Prefix+":"+a.Name), prefix) {
+				actions = append(actions, a)
+			}
+		}
+		if len(actions) == 0 {
+			continue
+		}
+		s.Actions = actions
+		reason := unavailableReason(s.Prefix)
+		catalog = append(catalog, ServiceStatus{ServiceDef: s, Enabled: reason == "", Reason: reason})
+	}
+	return catalog
+}
+
+// ServicesHandler lists the simulated services, whether each is enabled
+// and the documented actions the policy engine evaluates for it. ?q=
+// keeps only actions whose full name starts with it, for autocompletion:
+// "s3:Get" lists s3:GetObject and s3:GetBucketPolicy.
+func ServicesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(serviceCatalog(r.URL.Query().Get("q")))
+}
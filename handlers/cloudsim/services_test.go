@@ -0,0 +1,40 @@
+package cloudsim
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServicesHandler(t *testing.T) {
+	setupMockDB(t)
+	rr := httptest.NewRecorder()
+	ServicesHandler(rr, httptest.NewRequest("GET", "/api/cloudsim/services?q=s3:get", nil))
+	var catalog []ServiceStatus
+	if err := json.NewDecoder(rr.Body).Decode(&catalog); err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog) != 1 || catalog[0].Prefix != "s3" || !catalog[0].Enabled {
+		t.Fatalf("catalog = %+v", catalog)
+	}
+	for _, a := range catalog[0].Actions {
+		if a.Name != "GetObject" && a.Name != "GetBucketPolicy" {
+			t.Errorf("unexpected action %s", a.Name)
+		}
+	}
+}
+
+func TestServiceCatalogKMSNeedsVault(t *testing.T) {
+	setupMockDB(t)
+	catalog := serviceCatalog("kms")
+	if len(catalog) != 1 || catalog[0].Enabled || catalog[0].Reason == "" {
+		t.Fatalf("catalog without a vault key = %+v", catalog)
+	}
+	useVaultKey(t)
+	if catalog := serviceCatalog("kms:"); !catalog[0].Enabled {
+		t.Errorf("catalog with a vault key = %+v", catalog)
+	}
+	if catalog := serviceCatalog(""); len(catalog) < 10 {
+		t.Errorf("unfiltered catalog has %d services", len(catalog))
+	}
+}
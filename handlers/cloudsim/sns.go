@@ -0,0 +1,495 @@
+package cloudsim
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+)
+
+// Subscription protocols: topics deliver to queues and functions.
+const (
+	ProtocolSQS    = "sqs"
+	ProtocolLambda = "lambda"
+)
+
+// maxInvocations bounds the invocations listed per function.
+const maxInvocations = 50
+
+var topicNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// Topic is a simulated SNS standard topic.
+type Topic struct {
+	Name          string    `json:"name"`
+	ARN           string    `json:"arn"`
+	Subscriptions int       `json:"subscriptions"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type CreateTopicRequest struct {
+	Name string `json:"name"`
+}
+
+// Subscription delivers a topic's messages to a queue or function of the
+// account, named by its ARN in Endpoint.
+type Subscription struct {
+	SubscriptionID string    `json:"subscription_id"`
+	ARN            string    `json:"arn"`
+	Protocol       string    `json:"protocol"`
+	Endpoint       string    `json:"endpoint"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type SubscribeRequest struct {
+	Protocol string `json:"protocol"`
+	Endpoint string `json:"endpoint"`
+}
+
+type PublishRequest struct {
+	Message string `json:"message"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// Delivery is the outcome of delivering a message to one subscription. A
+// delivery fails when its queue or function no longer exists.
+type Delivery struct {
+	SubscriptionID string `json:"subscription_id"`
+	Protocol       string `json:"protocol"`
+	Endpoint       string `json:"endpoint"`
+	Delivered      bool   `json:"delivered"`
+}
+
+type PublishResponse struct {
+	MessageID  string     `json:"message_id"`
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// Invocation is a call of a simulated Lambda function, with the event it
+// received.
+type Invocation struct {
+	ID        int64           `json:"id"`
+	SourceARN string          `json:"source_arn"`
+	Payload   json.RawMessage `json:"payload"`
+	InvokedAt time.Time       `json:"invoked_at"`
+}
+
+// snsNotification is the JSON body SNS delivers to queues, and the Sns
+// record of the event it invokes functions with.
+type snsNotification struct {
+	Type      string `json:"Type"`
+	MessageID string `json:"MessageId"`
+	TopicARN  string `json:"TopicArn"`
+	Subject   string `json:"Subject,omitempty"`
+	Message   string `json:"Message"`
+	Timestamp string `json:"Timestamp"`
+}
+
+func topicARN(accountID int, name string) string {
+	return fmt.Sprintf("arn:aws:sns:%s:%s:%s", region, accounts.SimAccountNumber(accountID), name)
+}
+
+func functionARN(accountID int, name string) string {
+	return fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", region, accounts.SimAccountNumber(accountID), name)
+}
+
+// endpointName returns the queue or function name of an endpoint ARN, if
+// it is one of the account's for protocol.
+func endpointName(accountID int, protocol, endpoint string) (string, bool) {
+	var name string
+	var ok bool
+	switch protocol {
+	case ProtocolSQS:
+		name, ok = strings.CutPrefix(endpoint, queueARN(accountID, ""))
+	case ProtocolLambda:
+		name, ok = strings.CutPrefix(endpoint, functionARN(accountID, ""))
+	}
+	if !ok || name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// invoke records an invocation of the function name with payload,
+// returning false if the account has no such function.
+func invoke(ctx context.Context, ex execer, accountID int, name, sourceARN string, payload []byte) (bool, error) {
+	result, err := ex.ExecContext(ctx, `
+		INSERT INTO lambda_invocations (account_id, function_name, source_arn, payload)
+		SELECT $1, $2, $3, $4
+		WHERE EXISTS (SELECT 1 FROM cloudsim_resources WHERE account_id = $1 AND resource_type = $5 AND resource_id = $2)`,
+		accountID, name, sourceARN, payload, TypeFunction)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// publish delivers a message to every subscription of the topic: queues
+// get the SNS notification as the message body and functions are invoked
+// with an SNS event. Deliveries to missing endpoints fail without failing
+// the others.
+func publish(ctx context.Context, accountID int, topic string, req PublishRequest) (*PublishResponse, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT subscription_id, protocol, endpoint FROM sns_subscriptions
+		WHERE account_id = $1 AND topic_name = $2
+		ORDER BY created_at, subscription_id`, accountID, topic)
+	if err != nil {
+		return nil, err
+	}
+	resp := &PublishResponse{MessageID: newUUID(), Deliveries: []Delivery{}}
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.SubscriptionID, &d.Protocol, &d.Endpoint); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		resp.Deliveries = append(resp.Deliveries, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	arn := topicARN(accountID, topic)
+	notification := snsNotification{Type: "Notification", MessageID: resp.MessageID, TopicARN: arn,
+		Subject: req.Subject, Message: req.Message, Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05.000Z")}
+	body, _ := json.Marshal(notification)
+	for i, d := range resp.Deliveries {
+		name, _ := endpointName(accountID, d.Protocol, d.Endpoint)
+		switch d.Protocol {
+		case ProtocolSQS:
+			_, err = sendMessage(ctx, db.DB, accountID, name, string(body), nil)
+			resp.Deliveries[i].Delivered = err == nil
+			if errors.Is(err, errNoSuchQueue) {
+				err = nil
+			}
+		case ProtocolLambda:
+			event, _ := json.Marshal(map[string]interface{}{"Records": []interface{}{map[string]interface{}{
+				"EventSource":          "aws:sns",
+				"EventSubscriptionArn": arn + ":" + d.SubscriptionID,
+				"Sns":                  notification,
+			}}})
+			resp.Deliveries[i].Delivered, err = invoke(ctx, db.DB, accountID, name, arn, event)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("delivering to %s: %w", d.Endpoint, err)
+		}
+	}
+	return resp, nil
+}
+
+// ListTopicsHandler lists the account's topics with their subscription
+// counts.
+func ListTopicsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(), `
+		SELECT t.topic_name, t.arn, COUNT(s.subscription_id), t.created_at
+		FROM sns_topics t
+		LEFT JOIN sns_subscriptions s ON s.account_id = t.account_id AND s.topic_name = t.topic_name
+		WHERE t.account_id = $1
+		GROUP BY t.account_id, t.topic_name
+		ORDER BY t.topic_name`, accountID)
+	if err != nil {
+		log.Printf("Failed to load topics: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load topics"))
+		return
+	}
+	defer rows.Close()
+
+	topics := []Topic{}
+	for rows.Next() {
+		var t Topic
+		if err := rows.Scan(&t.Name, &t.ARN, &t.Subscriptions, &t.CreatedAt); err != nil {
+			log.Printf("Failed to load topics: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load topics"))
+			return
+		}
+		topics = append(topics, t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(topics)
+}
+
+// CreateTopicHandler creates a topic.
+func CreateTopicHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreateTopicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if !topicNamePattern.MatchString(req.Name) {
+		apierror.Write(w, apierror.Validation("topic names are 1 to 256 letters, digits, hyphens or underscores"))
+		return
+	}
+
+	t := Topic{Name: req.Name, ARN: topicARN(accountID, req.Name)}
+	err := db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO sns_topics (account_id, topic_name, arn) VALUES ($1, $2, $3)
+		ON CONFLICT (account_id, topic_name) DO NOTHING
+		RETURNING created_at`, accountID, t.Name, t.ARN).Scan(&t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("A topic with that name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create topic: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create topic"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// DeleteTopicHandler deletes the topic {name} and its subscriptions.
+func DeleteTopicHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(), "DELETE FROM sns_topics WHERE account_id = $1 AND topic_name = $2",
+		accountID, r.PathValue("name"))
+	if err != nil {
+		log.Printf("Failed to delete topic: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete topic"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Topic not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListSubscriptionsHandler lists the subscriptions of the topic {name}.
+func ListSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	topic := r.PathValue("name")
+
+	rows, err := db.DB.QueryContext(r.Context(), `
+		SELECT subscription_id, protocol, endpoint, created_at FROM sns_subscriptions
+		WHERE account_id = $1 AND topic_name = $2
+		ORDER BY created_at, subscription_id`, accountID, topic)
+	if err != nil {
+		log.Printf("Failed to load subscriptions: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load subscriptions"))
+		return
+	}
+	defer rows.Close()
+
+	subscriptions := []Subscription{}
+	for rows.Next() {
+		var s Subscription
+		if err := rows.Scan(&s.SubscriptionID, &s.Protocol, &s.Endpoint, &s.CreatedAt); err != nil {
+			log.Printf("Failed to load subscriptions: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load subscriptions"))
+			return
+		}
+		s.ARN = topicARN(accountID, topic) + ":" + s.SubscriptionID
+		subscriptions = append(subscriptions, s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+// SubscribeHandler subscribes a queue or function of the account, which
+// must exist, to the topic {name}. Subscriptions are confirmed at once.
+func SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	topic := r.PathValue("name")
+
+	var req SubscribeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.Protocol != ProtocolSQS && req.Protocol != ProtocolLambda {
+		apierror.Write(w, apierror.Validation(`protocol must be "sqs" or "lambda"`))
+		return
+	}
+	name, ok := endpointName(accountID, req.Protocol, req.Endpoint)
+	if !ok {
+		apierror.Write(w, apierror.Validation("endpoint must be the ARN of a queue or function of this account"))
+		return
+	}
+
+	var exists bool
+	var err error
+	if req.Protocol == ProtocolSQS {
+		exists, err = queueExists(r.Context(), accountID, name)
+	} else {
+		err = db.DB.QueryRowContext(r.Context(),
+			"SELECT EXISTS (SELECT 1 FROM cloudsim_resources WHERE account_id = $1 AND resource_type = $2 AND resource_id = $3)",
+			accountID, TypeFunction, name).Scan(&exists)
+	}
+	if err != nil {
+		log.Printf("Failed to check subscription endpoint: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to subscribe"))
+		return
+	}
+	if !exists {
+		apierror.Write(w, apierror.NotFound("No "+req.Protocol+" endpoint "+name))
+		return
+	}
+
+	s := Subscription{SubscriptionID: newUUID(), Protocol: req.Protocol, Endpoint: req.Endpoint}
+	s.ARN = topicARN(accountID, topic) + ":" + s.SubscriptionID
+	err = db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO sns_subscriptions (subscription_id, account_id, topic_name, protocol, endpoint)
+		SELECT $1, $2, $3, $4, $5
+		WHERE EXISTS (SELECT 1 FROM sns_topics WHERE account_id = $2 AND topic_name = $3)
+		ON CONFLICT (account_id, topic_name, protocol, endpoint) DO NOTHING
+		RETURNING created_at`,
+		s.SubscriptionID, accountID, topic, s.Protocol, s.Endpoint,
+	).Scan(&s.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("The topic does not exist or already has this subscription"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to subscribe: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to subscribe"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// UnsubscribeHandler deletes the subscription {id}.
+func UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(), "DELETE FROM sns_subscriptions WHERE account_id = $1 AND subscription_id = $2",
+		accountID, r.PathValue("id"))
+	if err != nil {
+		log.Printf("Failed to unsubscribe: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to unsubscribe"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Subscription not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PublishHandler publishes a message to the topic {name} and reports each
+// delivery.
+func PublishHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	topic := r.PathValue("name")
+
+	var req PublishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.Message == "" || len(req.Message) > MaxMessageSize {
+		apierror.Write(w, apierror.Validation("message must be 1 byte to 256 KB"))
+		return
+	}
+	if len(req.Subject) > 100 {
+		apierror.Write(w, apierror.Validation("subject must be at most 100 characters"))
+		return
+	}
+
+	var exists bool
+	err := db.DB.QueryRowContext(r.Context(),
+		"SELECT EXISTS (SELECT 1 FROM sns_topics WHERE account_id = $1 AND topic_name = $2)",
+		accountID, topic).Scan(&exists)
+	if err != nil {
+		log.Printf("Failed to load topic %s: %v", topic, err)
+		apierror.Write(w, apierror.Internal("Failed to publish"))
+		return
+	}
+	if !exists {
+		apierror.Write(w, apierror.NotFound("Topic not found"))
+		return
+	}
+	resp, err := publish(r.Context(), accountID, topic, req)
+	if err != nil {
+		log.Printf("Failed to publish to %s: %v", topic, err)
+		apierror.Write(w, apierror.Internal("Failed to publish"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListInvocationsHandler lists the latest invocations of the function
+// {name}, newest first.
+func ListInvocationsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(), `
+		SELECT id, source_arn, payload, invoked_at FROM lambda_invocations
+		WHERE account_id = $1 AND function_name = $2
+		ORDER BY invoked_at DESC, id DESC
+		LIMIT $3`, accountID, r.PathValue("name"), maxInvocations)
+	if err != nil {
+		log.Printf("Failed to load invocations: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load invocations"))
+		return
+	}
+	defer rows.Close()
+
+	invocations := []Invocation{}
+	for rows.Next() {
+		var inv Invocation
+		if err := rows.Scan(&inv.ID, &inv.SourceARN, &inv.Payload, &inv.InvokedAt); err != nil {
+			log.Printf("Failed to load invocations: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load invocations"))
+			return
+		}
+		invocations = append(invocations, inv)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invocations)
+}
@@ -0,0 +1,70 @@
+package cloudsim
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEndpointName(t *testing.T) {
+	tests := []struct {
+		protocol, endpoint, name string
+		ok                       bool
+	}{
+		{ProtocolSQS, "arn:aws:sqs:us-east-1:000000000001:orders", "orders", true},
+		{ProtocolLambda, "arn:aws:lambda:us-east-1:000000000001:function:resize", "resize", true},
+		{ProtocolSQS, "arn:aws:sqs:us-east-1:000000000002:orders", "", false},
+		{ProtocolLambda, "arn:aws:sqs:us-east-1:000000000001:orders", "", false},
+		{ProtocolSQS, "arn:aws:sqs:us-east-1:000000000001:", "", false},
+	}
+	for _, tt := range tests {
+		name, ok := endpointName(1, tt.protocol, tt.endpoint)
+		if name != tt.name || ok != tt.ok {
+			t.Errorf("endpointName(%s, %s) = %q, %v, want %q, %v", tt.protocol, tt.endpoint, name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT subscription_id, protocol, endpoint FROM sns_subscriptions").WithArgs(1, "events").
+		WillReturnRows(sqlmock.NewRows([]string{"subscription_id", "protocol", "endpoint"}).
+			AddRow("s1", ProtocolSQS, "arn:aws:sqs:us-east-1:000000000001:orders").
+			AddRow("s2", ProtocolLambda, "arn:aws:lambda:us-east-1:000000000001:function:gone"))
+	var body string
+	mock.ExpectExec("INSERT INTO sqs_messages").
+		WithArgs(sqlmock.AnyArg(), 1, "orders", captureArg{&body}, []byte(`{}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The function was deleted since it subscribed.
+	mock.ExpectExec("INSERT INTO lambda_invocations").
+		WithArgs(1, "gone", "arn:aws:sns:us-east-1:000000000001:events", sqlmock.AnyArg(), TypeFunction).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	resp, err := publish(context.Background(), 1, "events", PublishRequest{Message: "hi", Subject: "greeting"})
+	if err != nil {
+		t.Fatalf("publish() error: %v", err)
+	}
+	if len(resp.Deliveries) != 2 || !resp.Deliveries[0].Delivered || resp.Deliveries[1].Delivered {
+		t.Errorf("deliveries = %+v, want the queue delivered and the function not", resp.Deliveries)
+	}
+	var n snsNotification
+	if err := json.Unmarshal([]byte(body), &n); err != nil || n.Message != "hi" || n.MessageID != resp.MessageID ||
+		n.TopicARN != "arn:aws:sns:us-east-1:000000000001:events" {
+		t.Errorf("queue message = %s", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// captureArg matches any string argument and keeps it.
+type captureArg struct{ value *string }
+
+func (a captureArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*a.value = s
+	return ok
+}
@@ -0,0 +1,584 @@
+package cloudsim
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/iam"
+)
+
+const (
+	// MaxMessageSize bounds a message body, as in SQS.
+	MaxMessageSize = 256 * 1024
+	// messageRetention is how long queue messages and function
+	// invocations are kept, the SQS default.
+	messageRetention = 4 * 24 * time.Hour
+	// maxVisibilityTimeout is 12 hours, in seconds.
+	maxVisibilityTimeout = 43200
+)
+
+var queueNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,80}$`)
+
+var errNoSuchQueue = errors.New("no such queue")
+
+// execer runs a statement on the database or in a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Queue is a simulated SQS standard queue. Messages received
+// MaxReceiveCount times without being deleted move to DeadLetterQueue on
+// the next receive. MessagesAvailable and MessagesInFlight are counted
+// when the queue is read.
+type Queue struct {
+	Name              string    `json:"name"`
+	ARN               string    `json:"arn"`
+	VisibilityTimeout int       `json:"visibility_timeout"`
+	DeadLetterQueue   string    `json:"dead_letter_queue,omitempty"`
+	MaxReceiveCount   int       `json:"max_receive_count,omitempty"`
+	MessagesAvailable int       `json:"messages_available"`
+	MessagesInFlight  int       `json:"messages_in_flight"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// QueueRequest creates a queue, or with PUT replaces its settings, where
+// Name is ignored. VisibilityTimeout defaults to 30 seconds.
+type QueueRequest struct {
+	Name              string `json:"name,omitempty"`
+	VisibilityTimeout *int   `json:"visibility_timeout,omitempty"`
+	DeadLetterQueue   string `json:"dead_letter_queue,omitempty"`
+	MaxReceiveCount   int    `json:"max_receive_count,omitempty"`
+}
+
+type SendMessageRequest struct {
+	Body       string            `json:"body"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type SendMessageResponse struct {
+	MessageID string `json:"message_id"`
+}
+
+// ReceiveMessageRequest receives up to MaxMessages (1-10, default 1)
+// messages, hidden for VisibilityTimeout seconds instead of the queue's.
+type ReceiveMessageRequest struct {
+	MaxMessages       int  `json:"max_messages,omitempty"`
+	VisibilityTimeout *int `json:"visibility_timeout,omitempty"`
+}
+
+// Message is a received message. ReceiptHandle deletes it or changes its
+// visibility, until it is received again.
+type Message struct {
+	MessageID     string            `json:"message_id"`
+	ReceiptHandle string            `json:"receipt_handle"`
+	Body          string            `json:"body"`
+	Attributes    map[string]string `json:"attributes"`
+	SentAt        time.Time         `json:"sent_at"`
+	ReceiveCount  int               `json:"receive_count"`
+}
+
+type ChangeVisibilityRequest struct {
+	VisibilityTimeout int `json:"visibility_timeout"`
+}
+
+func queueARN(accountID int, name string) string {
+	return fmt.Sprintf("arn:aws:sqs:%s:%s:%s", region, accounts.SimAccountNumber(accountID), name)
+}
+
+// validate checks the settings of the queue named name.
+func (req QueueRequest) validate(name string) error {
+	if req.VisibilityTimeout != nil && (*req.VisibilityTimeout < 0 || *req.VisibilityTimeout > maxVisibilityTimeout) {
+		return fmt.Errorf("visibility_timeout must be between 0 and %d seconds", maxVisibilityTimeout)
+	}
+	if req.DeadLetterQueue == "" {
+		if req.MaxReceiveCount != 0 {
+			return errors.New("max_receive_count needs a dead_letter_queue")
+		}
+		return nil
+	}
+	if req.DeadLetterQueue == name {
+		return errors.New("a queue can't be its own dead-letter queue")
+	}
+	if req.MaxReceiveCount < 1 || req.MaxReceiveCount > 1000 {
+		return errors.New("max_receive_count must be between 1 and 1000")
+	}
+	return nil
+}
+
+// queueExists reports whether the account has the queue name.
+func queueExists(ctx context.Context, accountID int, name string) (bool, error) {
+	var exists bool
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM sqs_queues WHERE account_id = $1 AND queue_name = $2)",
+		accountID, name).Scan(&exists)
+	return exists, err
+}
+
+// loadQueues returns the account's queues, or only the queue name if it
+// is not empty, with their message counts.
+func loadQueues(ctx context.Context, accountID int, name string) ([]Queue, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT q.queue_name, q.arn, q.visibility_timeout, COALESCE(q.dead_letter_queue, ''),
+			COALESCE(q.max_receive_count, 0), q.created_at,
+			COUNT(m.message_id) FILTER (WHERE m.visible_at <= CURRENT_TIMESTAMP),
+			COUNT(m.message_id) FILTER (WHERE m.visible_at > CURRENT_TIMESTAMP)
+		FROM sqs_queues q
+		LEFT JOIN sqs_messages m ON m.account_id = q.account_id AND m.queue_name = q.queue_name
+		WHERE q.account_id = $1 AND ($2 = '' OR q.queue_name = $2)
+		GROUP BY q.account_id, q.queue_name
+		ORDER BY q.queue_name`, accountID, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queues := []Queue{}
+	for rows.Next() {
+		var q Queue
+		if err := rows.Scan(&q.Name, &q.ARN, &q.VisibilityTimeout, &q.DeadLetterQueue, &q.MaxReceiveCount, &q.CreatedAt,
+			&q.MessagesAvailable, &q.MessagesInFlight); err != nil {
+			return nil, err
+		}
+		queues = append(queues, q)
+	}
+	return queues, rows.Err()
+}
+
+// sendMessage adds a message to the queue, returning errNoSuchQueue if the
+// account has no such queue.
+func sendMessage(ctx context.Context, ex execer, accountID int, queue, body string, attributes map[string]string) (string, error) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	attrs, _ := json.Marshal(attributes)
+	id := newUUID()
+	result, err := ex.ExecContext(ctx, `
+		INSERT INTO sqs_messages (message_id, account_id, queue_name, body, attributes)
+		SELECT $1, $2, $3, $4, $5
+		WHERE EXISTS (SELECT 1 FROM sqs_queues WHERE account_id = $2 AND queue_name = $3)`,
+		id, accountID, queue, body, attrs)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", errNoSuchQueue
+	}
+	return id, nil
+}
+
+// receiveMessages receives up to max visible messages, oldest first, and
+// hides them for visibility seconds, or the queue's visibility timeout if
+// nil. Messages already received max_receive_count times move to the
+// dead-letter queue instead, if it still exists.
+func receiveMessages(ctx context.Context, accountID int, queue string, max int, visibility *int) ([]Message, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var timeout int
+	var deadLetterQueue sql.NullString
+	var maxReceiveCount sql.NullInt64
+	err = tx.QueryRowContext(ctx, `
+		SELECT visibility_timeout, dead_letter_queue, max_receive_count FROM sqs_queues
+		WHERE account_id = $1 AND queue_name = $2`, accountID, queue,
+	).Scan(&timeout, &deadLetterQueue, &maxReceiveCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNoSuchQueue
+	}
+	if err != nil {
+		return nil, err
+	}
+	if visibility != nil {
+		timeout = *visibility
+	}
+
+	if deadLetterQueue.Valid && maxReceiveCount.Valid {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE sqs_messages SET queue_name = $3, receive_count = 0, receipt_handle = NULL, visible_at = CURRENT_TIMESTAMP
+			WHERE account_id = $1 AND queue_name = $2 AND visible_at <= CURRENT_TIMESTAMP AND receive_count >= $4
+				AND EXISTS (SELECT 1 FROM sqs_queues WHERE account_id = $1 AND queue_name = $3)`,
+			accountID, queue, deadLetterQueue.String, maxReceiveCount.Int64)
+		if err != nil {
+			return nil, fmt.Errorf("moving messages to the dead-letter queue: %w", err)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		WITH picked AS (
+			SELECT message_id FROM sqs_messages
+			WHERE account_id = $1 AND queue_name = $2 AND visible_at <= CURRENT_TIMESTAMP
+			ORDER BY sent_at, message_id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE sqs_messages m
+		SET receive_count = m.receive_count + 1,
+			visible_at = CURRENT_TIMESTAMP + make_interval(secs => $4),
+			receipt_handle = md5(m.message_id || random()::text || clock_timestamp()::text)
+		FROM picked
+		WHERE m.message_id = picked.message_id
+		RETURNING m.message_id, m.receipt_handle, m.body, m.attributes, m.sent_at, m.receive_count`,
+		accountID, queue, max, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var m Message
+		var attrs []byte
+		if err := rows.Scan(&m.MessageID, &m.ReceiptHandle, &m.Body, &attrs, &m.SentAt, &m.ReceiveCount); err != nil {
+			return nil, err
+		}
+		m.Attributes = map[string]string{}
+		json.Unmarshal(attrs, &m.Attributes)
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].SentAt.Before(messages[j].SentAt) })
+	return messages, tx.Commit()
+}
+
+// ListQueuesHandler lists the account's queues with their message counts.
+func ListQueuesHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	queues, err := loadQueues(r.Context(), accountID, "")
+	if err != nil {
+		log.Printf("Failed to load queues: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load queues"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queues)
+}
+
+// GetQueueHandler returns the queue {name} with its message counts.
+func GetQueueHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	queues, err := loadQueues(r.Context(), accountID, r.PathValue("name"))
+	if err != nil {
+		log.Printf("Failed to load queue: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load queue"))
+		return
+	}
+	if len(queues) == 0 {
+		apierror.Write(w, apierror.NotFound("Queue not found"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queues[0])
+}
+
+// CreateQueueHandler creates a queue. Its dead-letter queue must exist.
+func CreateQueueHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req QueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if !queueNamePattern.MatchString(req.Name) {
+		apierror.Write(w, apierror.Validation("queue names are 1 to 80 letters, digits, hyphens or underscores"))
+		return
+	}
+	if err := req.validate(req.Name); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if req.DeadLetterQueue != "" {
+		exists, err := queueExists(r.Context(), accountID, req.DeadLetterQueue)
+		if err != nil {
+			log.Printf("Failed to check dead-letter queue: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to create queue"))
+			return
+		}
+		if !exists {
+			apierror.Write(w, apierror.NotFound("No queue "+req.DeadLetterQueue))
+			return
+		}
+	}
+
+	q := Queue{Name: req.Name, ARN: queueARN(accountID, req.Name), VisibilityTimeout: 30,
+		DeadLetterQueue: req.DeadLetterQueue, MaxReceiveCount: req.MaxReceiveCount}
+	if req.VisibilityTimeout != nil {
+		q.VisibilityTimeout = *req.VisibilityTimeout
+	}
+	err := db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO sqs_queues (account_id, queue_name, arn, visibility_timeout, dead_letter_queue, max_receive_count)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, 0))
+		ON CONFLICT (account_id, queue_name) DO NOTHING
+		RETURNING created_at`,
+		accountID, q.Name, q.ARN, q.VisibilityTimeout, q.DeadLetterQueue, q.MaxReceiveCount,
+	).Scan(&q.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("A queue with that name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create queue: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create queue"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(q)
+}
+
+// UpdateQueueHandler replaces the visibility timeout and redrive settings
+// of the queue {name}. Messages already in flight keep their timeout.
+func UpdateQueueHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	name := r.PathValue("name")
+
+	var req QueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := req.validate(name); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if req.DeadLetterQueue != "" {
+		exists, err := queueExists(r.Context(), accountID, req.DeadLetterQueue)
+		if err != nil {
+			log.Printf("Failed to check dead-letter queue: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to update queue"))
+			return
+		}
+		if !exists {
+			apierror.Write(w, apierror.NotFound("No queue "+req.DeadLetterQueue))
+			return
+		}
+	}
+	timeout := 30
+	if req.VisibilityTimeout != nil {
+		timeout = *req.VisibilityTimeout
+	}
+
+	result, err := db.DB.ExecContext(r.Context(), `
+		UPDATE sqs_queues SET visibility_timeout = $3, dead_letter_queue = NULLIF($4, ''), max_receive_count = NULLIF($5, 0)
+		WHERE account_id = $1 AND queue_name = $2`,
+		accountID, name, timeout, req.DeadLetterQueue, req.MaxReceiveCount)
+	if err != nil {
+		log.Printf("Failed to update queue: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to update queue"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Queue not found"))
+		return
+	}
+	GetQueueHandler(w, r)
+}
+
+// DeleteQueueHandler deletes the queue {name} and its messages. Queues
+// using it as their dead-letter queue keep their messages until it is
+// created again.
+func DeleteQueueHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(), "DELETE FROM sqs_queues WHERE account_id = $1 AND queue_name = $2",
+		accountID, r.PathValue("name"))
+	if err != nil {
+		log.Printf("Failed to delete queue: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete queue"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Queue not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SendMessageHandler adds a message to the queue {name}.
+func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.Body == "" || len(req.Body) > MaxMessageSize {
+		apierror.Write(w, apierror.Validation("body must be 1 byte to 256 KB"))
+		return
+	}
+	if len(req.Attributes) > 10 {
+		apierror.Write(w, apierror.Validation("a message has at most 10 attributes"))
+		return
+	}
+
+	id, err := sendMessage(r.Context(), db.DB, accountID, r.PathValue("name"), req.Body, req.Attributes)
+	if errors.Is(err, errNoSuchQueue) {
+		apierror.Write(w, apierror.NotFound("Queue not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to send message: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to send message"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SendMessageResponse{MessageID: id})
+}
+
+// ReceiveMessagesHandler receives messages from the queue {name}. An empty
+// list means no message is visible; there is no long polling.
+func ReceiveMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req ReceiveMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.MaxMessages == 0 {
+		req.MaxMessages = 1
+	}
+	if req.MaxMessages < 1 || req.MaxMessages > 10 {
+		apierror.Write(w, apierror.Validation("max_messages must be between 1 and 10"))
+		return
+	}
+	if req.VisibilityTimeout != nil && (*req.VisibilityTimeout < 0 || *req.VisibilityTimeout > maxVisibilityTimeout) {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("visibility_timeout must be between 0 and %d seconds", maxVisibilityTimeout)))
+		return
+	}
+
+	messages, err := receiveMessages(r.Context(), accountID, r.PathValue("name"), req.MaxMessages, req.VisibilityTimeout)
+	if errors.Is(err, errNoSuchQueue) {
+		apierror.Write(w, apierror.NotFound("Queue not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to receive messages: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to receive messages"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// DeleteMessageHandler deletes the message received with {receipt_handle}
+// from the queue {name}. A handle stops working once the message is
+// received again.
+func DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(),
+		"DELETE FROM sqs_messages WHERE account_id = $1 AND queue_name = $2 AND receipt_handle = $3",
+		accountID, r.PathValue("name"), r.PathValue("receipt_handle"))
+	if err != nil {
+		log.Printf("Failed to delete message: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete message"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("No message with that receipt handle"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangeVisibilityHandler hides the in-flight message {receipt_handle} for
+// visibility_timeout seconds from now; 0 makes it visible at once.
+func ChangeVisibilityHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := iam.AccountID(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req ChangeVisibilityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.VisibilityTimeout < 0 || req.VisibilityTimeout > maxVisibilityTimeout {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("visibility_timeout must be between 0 and %d seconds", maxVisibilityTimeout)))
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(), `
+		UPDATE sqs_messages SET visible_at = CURRENT_TIMESTAMP + make_interval(secs => $4)
+		WHERE account_id = $1 AND queue_name = $2 AND receipt_handle = $3 AND visible_at > CURRENT_TIMESTAMP`,
+		accountID, r.PathValue("name"), r.PathValue("receipt_handle"), req.VisibilityTimeout)
+	if err != nil {
+		log.Printf("Failed to change message visibility: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to change message visibility"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("No message in flight with that receipt handle"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteExpiredMessages drops queue messages and function invocations
+// older than four days. It runs from the scheduler.
+func DeleteExpiredMessages(ctx context.Context) error {
+	cutoff := time.Now().Add(-messageRetention)
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM sqs_messages WHERE sent_at < $1", cutoff); err != nil {
+		return fmt.Errorf("failed to delete expired queue messages: %w", err)
+	}
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM lambda_invocations WHERE invoked_at < $1", cutoff); err != nil {
+		return fmt.Errorf("failed to delete expired function invocations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package cloudsim
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestQueueRequestValidate(t *testing.T) {
+	timeout := func(s int) *int { return &s }
+	tests := []struct {
+		name string
+		req  QueueRequest
+		ok   bool
+	}{
+		{"defaults", QueueRequest{}, true},
+		{"timeout", QueueRequest{VisibilityTimeout: timeout(maxVisibilityTimeout)}, true},
+		{"timeout too long", QueueRequest{VisibilityTimeout: timeout(maxVisibilityTimeout + 1)}, false},
+		{"redrive", QueueRequest{DeadLetterQueue: "orders-dlq", MaxReceiveCount: 3}, true},
+		{"no max receive count", QueueRequest{DeadLetterQueue: "orders-dlq"}, false},
+		{"max receive count alone", QueueRequest{MaxReceiveCount: 3}, false},
+		{"own dead-letter queue", QueueRequest{DeadLetterQueue: "orders", MaxReceiveCount: 3}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.req.validate("orders"); (err == nil) != tt.ok {
+			t.Errorf("%s: validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestReceiveMessages(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT visibility_timeout, dead_letter_queue, max_receive_count FROM sqs_queues").
+		WithArgs(1, "orders").
+		WillReturnRows(sqlmock.NewRows([]string{"visibility_timeout", "dead_letter_queue", "max_receive_count"}).
+			AddRow(30, "orders-dlq", 3))
+	// Messages received three times go to the dead-letter queue first.
+	mock.ExpectExec("UPDATE sqs_messages SET queue_name = \\$3").
+		WithArgs(1, "orders", "orders-dlq", int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	now := time.Now()
+	mock.ExpectQuery("WITH picked AS").WithArgs(1, "orders", 2, 60).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "receipt_handle", "body", "attributes", "sent_at", "receive_count"}).
+			AddRow("m2", "h2", "second", []byte(`{}`), now, 1).
+			AddRow("m1", "h1", "first", []byte(`{"k":"v"}`), now.Add(-time.Second), 2))
+	mock.ExpectCommit()
+
+	visibility := 60
+	messages, err := receiveMessages(context.Background(), 1, "orders", 2, &visibility)
+	if err != nil {
+		t.Fatalf("receiveMessages() error: %v", err)
+	}
+	if len(messages) != 2 || messages[0].MessageID != "m1" || messages[0].Attributes["k"] != "v" {
+		t.Errorf("messages = %+v, want m1 then m2", messages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSendMessageHandler(t *testing.T) {
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/cloudsim/sqs/queues/orders/messages", strings.NewReader(body))
+		req.SetPathValue("name", "orders")
+		rr := httptest.NewRecorder()
+		SendMessageHandler(rr, req)
+		return rr
+	}
+
+	t.Run("sent", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectExec("INSERT INTO sqs_messages").
+			WithArgs(sqlmock.AnyArg(), 1, "orders", "hello", []byte(`{}`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		if rr := send(`{"body": "hello"}`); rr.Code != http.StatusCreated || !strings.Contains(rr.Body.String(), "message_id") {
+			t.Errorf("status = %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("no queue", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectExec("INSERT INTO sqs_messages").WillReturnResult(sqlmock.NewResult(0, 0))
+		if rr := send(`{"body": "hello"}`); rr.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rr.Code)
+		}
+	})
+
+	t.Run("empty body", func(t *testing.T) {
+		setupMockDB(t)
+		if rr := send(`{"body": ""}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rr.Code)
+		}
+	})
+}
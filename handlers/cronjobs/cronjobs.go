@@ -0,0 +1,642 @@
+// Package cronjobs runs users' saved Python files on cron schedules, each
+// run in a fresh sandbox container with the user's virtualenv and secrets,
+// and keeps
+// the output of recent runs. Due jobs are run by a scheduler job.
+package cronjobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // timezones must resolve even on hosts without zoneinfo
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/handlers/secrets"
+	"allanswebterminal/handlers/terminal"
+	"allanswebterminal/pagination"
+	"allanswebterminal/sandbox"
+	"allanswebterminal/scheduler"
+	"allanswebterminal/validate"
+)
+
+const (
+	// MaxJobs bounds an account's jobs.
+	MaxJobs = 5
+	// MinInterval is the shortest time allowed between two runs of a job.
+	MinInterval = 15 * time.Minute
+	// RunTimeout bounds one run.
+	RunTimeout = 2 * time.Minute
+	// KeepRuns is how many runs of each job are kept.
+	KeepRuns = 50
+	// DisableAfter is how many failed runs in a row disable a job.
+	DisableAfter = 10
+
+	maxRunOutput = 64 << 10
+	runBatch     = 20
+	parallelRuns = 4
+)
+
+type Job struct {
+	ID         int        `json:"id"`
+	Filename   string     `json:"filename"`
+	Schedule   string     `json:"schedule"`
+	Timezone   string     `json:"timezone"`
+	Enabled    bool       `json:"enabled"`
+	NextRunAt  *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+	LastStatus string     `json:"last_status,omitempty"`
+	// Failures counts failed runs in a row.
+	Failures  int       `json:"failures"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Run is one execution of a job. A run failed if Error is set or ExitCode
+// is not 0.
+type Run struct {
+	ID         int       `json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	ExitCode   int       `json:"exit_code"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Manual     bool      `json:"manual"`
+}
+
+func (r *Run) failed() bool {
+	return r.Error != "" || r.ExitCode != 0
+}
+
+func (r *Run) status() string {
+	if r.failed() {
+		return "failed"
+	}
+	return "ok"
+}
+
+type JobRequest struct {
+	Filename string `json:"filename" validate:"required,max=255"`
+	Schedule string `json:"schedule" validate:"required,max=100"`
+	// Timezone is the IANA zone the schedule is read in; UTC by default.
+	Timezone string `json:"timezone" validate:"max=64"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+var runOptions = pagination.Options{DefaultLimit: 20, MaxLimit: KeepRuns, Sorts: []string{"started_at"}, DefaultSort: "-started_at"}
+
+// parseSchedule checks spec as a cron expression in loc that runs no more
+// often than MinInterval.
+func parseSchedule(spec string, loc *time.Location, now time.Time) (scheduler.Schedule, error) {
+	s, err := scheduler.Cron(spec)
+	if err != nil {
+		return nil, errors.New(strings.TrimPrefix(err.Error(), "scheduler: "))
+	}
+	t := s.Next(now.In(loc))
+	if t.IsZero() {
+		return nil, errors.New("schedule never runs")
+	}
+	// Two days of runs show any gap too short, such as "0,5 * * * *".
+	for end := t.AddDate(0, 0, 2); t.Before(end); {
+		next := s.Next(t)
+		if next.IsZero() {
+			break
+		}
+		if next.Sub(t) < MinInterval {
+			return nil, fmt.Errorf("schedule must not run more often than every %d minutes", int(MinInterval.Minutes()))
+		}
+		t = next
+	}
+	return s, nil
+}
+
+// checkRequest validates req and returns the job it describes, with its
+// next run computed from now.
+func checkRequest(ctx context.Context, accountID int, req *JobRequest, now time.Time) (Job, *apierror.Error) {
+	if apiErr := validate.Check(req); apiErr != nil {
+		return Job{}, apiErr
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		return Job{}, apierror.Validation("Unknown timezone")
+	}
+	sched, err := parseSchedule(req.Schedule, loc, now)
+	if err != nil {
+		return Job{}, apierror.Validation(err.Error())
+	}
+	file, err := files.Load(ctx, accountID, req.Filename)
+	if errors.Is(err, files.ErrNotFound) || (err == nil && file.FileType != "python") {
+		return Job{}, apierror.Validation("filename must name one of your saved Python files")
+	}
+	if err != nil {
+		log.Printf("Failed to load file %q of account %d: %v", req.Filename, accountID, err)
+		return Job{}, apierror.Internal("Failed to save job")
+	}
+	job := Job{
+		Filename: req.Filename,
+		Schedule: strings.TrimSpace(req.Schedule),
+		Timezone: loc.String(),
+		Enabled:  req.Enabled == nil || *req.Enabled,
+	}
+	next := sched.Next(now.In(loc))
+	job.NextRunAt = &next
+	return job, nil
+}
+
+const jobColumns = `id, filename, schedule, timezone, enabled, next_run_at, last_run_at, last_status, failures, created_at`
+
+func scanJob(row interface{ Scan(...interface{}) error }) (Job, error) {
+	var job Job
+	var next, last sql.NullTime
+	var status sql.NullString
+	err := row.Scan(&job.ID, &job.Filename, &job.Schedule, &job.Timezone, &job.Enabled, &next, &last, &status, &job.Failures, &job.CreatedAt)
+	if job.Enabled && next.Valid {
+		job.NextRunAt = &next.Time
+	}
+	if last.Valid {
+		job.LastRunAt = &last.Time
+	}
+	job.LastStatus = status.String
+	return job, err
+}
+
+// ListJobsHandler returns the caller's jobs.
+func ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT `+jobColumns+` FROM cron_jobs WHERE account_id = $1 ORDER BY id`, user.ID)
+	if err != nil {
+		log.Printf("Failed to list cron jobs of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load jobs"))
+		return
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			log.Printf("Failed to read cron job: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load jobs"))
+			return
+		}
+		jobs = append(jobs, job)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]Job{"jobs": jobs})
+}
+
+// CreateJobHandler schedules one of the caller's saved Python files.
+func CreateJobHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	job, apiErr := checkRequest(r.Context(), user.ID, &req, time.Now())
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	var count int
+	if err := db.DB.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM cron_jobs WHERE account_id = $1`, user.ID).Scan(&count); err != nil {
+		log.Printf("Failed to count cron jobs of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save job"))
+		return
+	}
+	if count >= MaxJobs {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("An account can have at most %d scheduled jobs", MaxJobs)))
+		return
+	}
+
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO cron_jobs (account_id, filename, schedule, timezone, enabled, next_run_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, created_at`,
+		user.ID, job.Filename, job.Schedule, job.Timezone, job.Enabled, *job.NextRunAt,
+	).Scan(&job.ID, &job.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to save cron job for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save job"))
+		return
+	}
+	if !job.Enabled {
+		job.NextRunAt = nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(job)
+}
+
+// UpdateJobHandler replaces one of the caller's jobs. Enabling a job
+// clears its failure count.
+func UpdateJobHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid job ID"))
+		return
+	}
+	var req JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	job, apiErr := checkRequest(r.Context(), user.ID, &req, time.Now())
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	updated, err := scanJob(db.DB.QueryRowContext(r.Context(),
+		`UPDATE cron_jobs SET filename = $3, schedule = $4, timezone = $5, enabled = $6, next_run_at = $7,
+			failures = CASE WHEN $6 AND NOT enabled THEN 0 ELSE failures END
+		 WHERE id = $1 AND account_id = $2
+		 RETURNING `+jobColumns,
+		id, user.ID, job.Filename, job.Schedule, job.Timezone, job.Enabled, *job.NextRunAt))
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Job not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update cron job %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to save job"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteJobHandler removes one of the caller's jobs and its runs.
+func DeleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid job ID"))
+		return
+	}
+	result, err := db.DB.ExecContext(r.Context(), `DELETE FROM cron_jobs WHERE id = $1 AND account_id = $2`, id, user.ID)
+	if err != nil {
+		log.Printf("Failed to delete cron job %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to delete job"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Job not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunsHandler pages through a job's recent runs, newest first by default.
+func RunsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid job ID"))
+		return
+	}
+	page, apiErr := pagination.Parse(r.URL.Query(), runOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	var total int
+	err = db.DB.QueryRowContext(r.Context(),
+		`SELECT COUNT(r.id) FROM cron_jobs j LEFT JOIN cron_runs r ON r.job_id = j.id
+		 WHERE j.id = $1 AND j.account_id = $2 GROUP BY j.id`, id, user.ID).Scan(&total)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Job not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to count runs of cron job %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to load runs"))
+		return
+	}
+	order := "started_at"
+	if page.Desc {
+		order = "started_at DESC"
+	}
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT id, started_at, duration_ms, exit_code, stdout, stderr, truncated, error, manual
+		 FROM cron_runs WHERE job_id = $1 ORDER BY `+order+` LIMIT $2 OFFSET $3`,
+		id, page.Limit, page.Offset)
+	if err != nil {
+		log.Printf("Failed to load runs of cron job %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to load runs"))
+		return
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var run Run
+		if err := rows.Scan(&run.ID, &run.StartedAt, &run.DurationMS, &run.ExitCode, &run.Stdout, &run.Stderr, &run.Truncated, &run.Error, &run.Manual); err != nil {
+			log.Printf("Failed to read cron run: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load runs"))
+			return
+		}
+		runs = append(runs, run)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(runs, total, page))
+}
+
+// RunNowHandler runs a job at once and returns the run. Manual runs are
+// kept with the others but leave the schedule and failure count alone.
+func RunNowHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid job ID"))
+		return
+	}
+	pool := sandbox.Default()
+	if pool == nil {
+		apierror.Write(w, apierror.Unavailable("The terminal sandbox is not configured").
+			WithDetails(map[string]string{"reason": "not_configured"}))
+		return
+	}
+	var filename string
+	err = db.DB.QueryRowContext(r.Context(),
+		`SELECT filename FROM cron_jobs WHERE id = $1 AND account_id = $2`, id, user.ID).Scan(&filename)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Job not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load cron job %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to run job"))
+		return
+	}
+
+	run := execute(r.Context(), pool, user.ID, filename)
+	run.Manual = true
+	if err := saveRun(r.Context(), id, run); err != nil {
+		log.Printf("Failed to save run of cron job %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to run job"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(run)
+}
+
+// execute runs filename of accountID in a container of its own, which is
+// stopped afterwards. Problems, the script's or the sandbox's, are
+// reported in the run.
+func execute(ctx context.Context, pool *sandbox.Pool, accountID int, filename string) *Run {
+	run := &Run{StartedAt: time.Now()}
+	defer func() { run.DurationMS = time.Since(run.StartedAt).Milliseconds() }()
+
+	file, err := files.Load(ctx, accountID, filename)
+	if errors.Is(err, files.ErrNotFound) {
+		run.Error = filename + " no longer exists"
+		return run
+	}
+	if err != nil {
+		log.Printf("Failed to load %q of account %d for cron: %v", filename, accountID, err)
+		run.Error = "the script could not be loaded"
+		return run
+	}
+
+	env, err := secrets.Env(ctx, accountID)
+	if err != nil {
+		log.Printf("Failed to load secrets of account %d for cron: %v", accountID, err)
+		run.Error = "your secrets could not be decrypted"
+		return run
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, RunTimeout)
+	defer cancel()
+	c, err := pool.Temporary(ctx, pool.Limits())
+	if errors.Is(err, sandbox.ErrPoolFull) {
+		run.Error = "all sandboxes were busy"
+		return run
+	}
+	if err != nil {
+		log.Printf("Failed to start cron sandbox for account %d: %v", accountID, err)
+		run.Error = "the sandbox failed to start"
+		return run
+	}
+	defer c.Stop(context.Background())
+	if err := terminal.RestoreVenv(ctx, accountID, c); err != nil {
+		log.Printf("Failed to restore virtualenv of account %d for cron: %v", accountID, err)
+	}
+
+	res, err := c.Exec(ctx, sandbox.ExecRequest{
+		Command: "cat > /tmp/job.py && exec python3 /tmp/job.py < /dev/null",
+		Env:     env,
+		Stdin:   strings.NewReader(file.Content),
+	})
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		run.ExitCode = 124
+		run.Error = fmt.Sprintf("timed out after %s", RunTimeout)
+		return run
+	case err != nil:
+		log.Printf("Cron run for account %d failed: %v", accountID, err)
+		run.Error = "the sandbox failed"
+		return run
+	}
+	run.ExitCode = res.ExitCode
+	run.Stdout, run.Truncated = clip(secrets.Redact(res.Stdout, env), res.Truncated)
+	run.Stderr, run.Truncated = clip(secrets.Redact(res.Stderr, env), run.Truncated)
+	return run
+}
+
+// clip cuts s to maxRunOutput, reporting whether anything was cut.
+func clip(s string, truncated bool) (string, bool) {
+	if len(s) <= maxRunOutput {
+		return s, truncated
+	}
+	return strings.ToValidUTF8(s[:maxRunOutput], ""), true
+}
+
+// saveRun stores run and drops the job's runs past KeepRuns.
+func saveRun(ctx context.Context, jobID int, run *Run) error {
+	err := db.DB.QueryRowContext(ctx,
+		`INSERT INTO cron_runs (job_id, started_at, duration_ms, exit_code, stdout, stderr, truncated, error, manual)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		jobID, run.StartedAt, run.DurationMS, run.ExitCode, run.Stdout, run.Stderr, run.Truncated, run.Error, run.Manual,
+	).Scan(&run.ID)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.ExecContext(ctx,
+		`DELETE FROM cron_runs WHERE job_id = $1 AND id NOT IN
+			(SELECT id FROM cron_runs WHERE job_id = $1 ORDER BY id DESC LIMIT $2)`,
+		jobID, KeepRuns)
+	return err
+}
+
+// RunDue runs every enabled job whose time has come and schedules its
+// next run. Runs missed while the server was down happen once, not once
+// per missed slot.
+func RunDue(ctx context.Context) error {
+	pool := sandbox.Default()
+	if pool == nil {
+		return nil
+	}
+	return runDue(ctx, pool, time.Now())
+}
+
+type dueJob struct {
+	id        int
+	accountID int
+	filename  string
+	schedule  string
+	timezone  string
+	failures  int
+}
+
+func runDue(ctx context.Context, pool *sandbox.Pool, now time.Time) error {
+	due, err := loadDue(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+		slots  = make(chan struct{}, parallelRuns)
+	)
+	for _, job := range due {
+		// Reschedule first, so a crash mid-run does not repeat the run.
+		loc, err := time.LoadLocation(job.timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		var next time.Time
+		if sched, err := scheduler.Cron(job.schedule); err == nil {
+			next = sched.Next(now.In(loc))
+		}
+		if next.IsZero() {
+			next = now.Add(24 * time.Hour)
+		}
+		if _, err := db.DB.ExecContext(ctx, `UPDATE cron_jobs SET next_run_at = $2 WHERE id = $1`, job.id, next); err != nil {
+			return fmt.Errorf("reschedule cron job %d: %w", job.id, err)
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(job dueJob) {
+			defer func() { <-slots; wg.Done() }()
+			if err := finish(ctx, job, execute(ctx, pool, job.accountID, job.filename)); err != nil {
+				log.Printf("Failed to record run of cron job %d: %v", job.id, err)
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(job)
+	}
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cron runs could not be recorded", failed, len(due))
+	}
+	return nil
+}
+
+// finish records a scheduled run, counts failures in a row and tells the
+// owner when a job starts failing or is disabled.
+func finish(ctx context.Context, job dueJob, run *Run) error {
+	if err := saveRun(ctx, job.id, run); err != nil {
+		return err
+	}
+	failures := 0
+	if run.failed() {
+		failures = job.failures + 1
+	}
+	_, err := db.DB.ExecContext(ctx,
+		`UPDATE cron_jobs SET last_run_at = $2, last_status = $3, failures = $4, enabled = enabled AND $4 < $5
+		 WHERE id = $1`,
+		job.id, run.StartedAt, run.status(), failures, DisableAfter)
+	if err != nil {
+		return err
+	}
+
+	var title string
+	switch failures {
+	case 1:
+		title = "Scheduled script " + job.filename + " failed"
+	case DisableAfter:
+		title = fmt.Sprintf("Scheduled script %s was disabled after %d failed runs", job.filename, DisableAfter)
+	default:
+		return nil
+	}
+	body := run.Error
+	if body == "" {
+		body = fmt.Sprintf("It exited with code %d.", run.ExitCode)
+	}
+	if _, err := notifications.Notify(ctx, job.accountID, notifications.KindCronFailed, title, body, ""); err != nil {
+		log.Printf("Failed to notify account %d of cron job %d: %v", job.accountID, job.id, err)
+	}
+	return nil
+}
+
+func loadDue(ctx context.Context, now time.Time) ([]dueJob, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT id, account_id, filename, schedule, timezone, failures
+		 FROM cron_jobs
+		 WHERE enabled AND next_run_at <= $1 AND `+login.NotSuspended("account_id")+`
+		 ORDER BY next_run_at
+		 LIMIT $2`, now, runBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []dueJob
+	for rows.Next() {
+		var job dueJob
+		if err := rows.Scan(&job.id, &job.accountID, &job.filename, &job.schedule, &job.timezone, &job.failures); err != nil {
+			return nil, err
+		}
+		due = append(due, job)
+	}
+	return due, rows.Err()
+}
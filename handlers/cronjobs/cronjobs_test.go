@@ -0,0 +1,205 @@
+package cronjobs
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/sandbox"
+	"allanswebterminal/securecookie"
+	"allanswebterminal/vault"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	originalDB := db.DB
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+func expectUser(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "user"))
+}
+
+var fileColumns = []string{"id", "account_id", "filename", "content", "file_type", "created_at", "updated_at", "key_id", "data_key", "scan_status"}
+
+func expectFile(mock sqlmock.Sqlmock, name, content, fileType string) {
+	rows := sqlmock.NewRows(fileColumns)
+	if content != "" {
+		rows.AddRow(1, 7, name, content, fileType, time.Now(), time.Now(), nil, nil, nil)
+	}
+	mock.ExpectQuery("FROM user_files").WithArgs(7, name).WillReturnRows(rows)
+}
+
+func request(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	return req
+}
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, spec := range []string{"*/15 * * * *", "@daily", "30 8 * * 1-5", "0 0 29 2 *"} {
+		if _, err := parseSchedule(spec, time.UTC, now); err != nil {
+			t.Errorf("parseSchedule(%q) error = %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"*/5 * * * *", "0,5 * * * *", "0 0 31 2 *", "* * *", "61 * * * *"} {
+		if _, err := parseSchedule(spec, time.UTC, now); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestCreateJob(t *testing.T) {
+	mock := setupMockDB(t)
+	expectUser(mock)
+	expectFile(mock, "report.py", "print('hi')", "python")
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("INSERT INTO cron_jobs").
+		WithArgs(7, "report.py", "0 8 * * *", "Europe/Lisbon", true, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+
+	rec := httptest.NewRecorder()
+	CreateJobHandler(rec, request("POST", "/api/cron/jobs", `{"filename":"report.py","schedule":"0 8 * * *","timezone":"Europe/Lisbon"}`))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"next_run_at"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateJobChecksRequest(t *testing.T) {
+	tests := map[string]struct {
+		body string
+		file func(sqlmock.Sqlmock)
+	}{
+		"too often":  {body: `{"filename":"a.py","schedule":"* * * * *"}`},
+		"bad zone":   {body: `{"filename":"a.py","schedule":"@daily","timezone":"Mars/Olympus"}`},
+		"no file":    {body: `{"filename":"a.py","schedule":"@daily"}`, file: func(m sqlmock.Sqlmock) { expectFile(m, "a.py", "", "") }},
+		"not python": {body: `{"filename":"a.js","schedule":"@daily"}`, file: func(m sqlmock.Sqlmock) { expectFile(m, "a.js", "1", "javascript") }},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			expectUser(mock)
+			if tt.file != nil {
+				tt.file(mock)
+			}
+			rec := httptest.NewRecorder()
+			CreateJobHandler(rec, request("POST", "/api/cron/jobs", tt.body))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateJobLimit(t *testing.T) {
+	mock := setupMockDB(t)
+	expectUser(mock)
+	expectFile(mock, "a.py", "print(1)", "python")
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(MaxJobs))
+
+	rec := httptest.NewRecorder()
+	CreateJobHandler(rec, request("POST", "/api/cron/jobs", `{"filename":"a.py","schedule":"@hourly"}`))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", rec.Code)
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	rt := &sandbox.Mock{Reply: sandbox.ExecResult{Stderr: "Traceback\n", ExitCode: 1}}
+	pool := sandbox.NewPool(rt, sandbox.PoolOptions{Max: 2})
+	defer pool.Close(context.Background())
+	now := time.Date(2026, 3, 1, 8, 0, 30, 0, time.UTC)
+
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM cron_jobs").WithArgs(now, runBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "filename", "schedule", "timezone", "failures"}).
+			AddRow(3, 7, "report.py", "0 8 * * *", "UTC", 0))
+	mock.ExpectExec("UPDATE cron_jobs SET next_run_at").
+		WithArgs(3, time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectFile(mock, "report.py", "raise SystemExit(1)", "python")
+	expectFile(mock, "sandbox/venv.tar.gz", "", "")
+	mock.ExpectQuery("INSERT INTO cron_runs").
+		WithArgs(3, sqlmock.AnyArg(), sqlmock.AnyArg(), 1, "", "Traceback\n", false, "", false).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectExec("DELETE FROM cron_runs").WithArgs(3, KeepRuns).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE cron_jobs SET last_run_at").
+		WithArgs(3, sqlmock.AnyArg(), "failed", 1, DisableAfter).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO notifications").
+		WithArgs(7, "cron_failed", "Scheduled script report.py failed", "It exited with code 1.", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+
+	if err := runDue(context.Background(), pool, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	execs := rt.Execs()
+	if len(execs) != 1 || !strings.Contains(execs[0].Command, "python3 /tmp/job.py") {
+		t.Errorf("Execs() = %+v", execs)
+	}
+	if len(rt.Stopped()) != 1 {
+		t.Error("the run's container was not stopped")
+	}
+}
+
+func TestRunsOfOtherAccount(t *testing.T) {
+	mock := setupMockDB(t)
+	expectUser(mock)
+	mock.ExpectQuery("SELECT COUNT").WithArgs(3, 7).WillReturnError(sql.ErrNoRows)
+
+	req := request("GET", "/api/cron/jobs/3/runs", "")
+	req.SetPathValue("id", "3")
+	rec := httptest.NewRecorder()
+	RunsHandler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestExecuteWithSecrets(t *testing.T) {
+	key, _ := vault.ParseKey(vault.NewKey())
+	vault.SetKey(key)
+	defer vault.SetKey(nil)
+	rt := &sandbox.Mock{Reply: sandbox.ExecResult{Stdout: "using sk-live-456\n"}}
+	pool := sandbox.NewPool(rt, sandbox.PoolOptions{Max: 1})
+	defer pool.Close(context.Background())
+
+	mock := setupMockDB(t)
+	expectFile(mock, "report.py", "print(1)", "python")
+	token, _ := vault.Seal([]byte("sk-live-456"), []byte("secret:7:TOKEN"))
+	mock.ExpectQuery("SELECT name, value, kms_key_id FROM user_secrets").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "value", "kms_key_id"}).AddRow("TOKEN", token, nil))
+	expectFile(mock, "sandbox/venv.tar.gz", "", "")
+
+	run := execute(context.Background(), pool, 7, "report.py")
+	if run.failed() || run.Stdout != "using [TOKEN]\n" {
+		t.Errorf("run = %+v", run)
+	}
+	if execs := rt.Execs(); len(execs) != 1 || execs[0].Env["TOKEN"] != "sk-live-456" {
+		t.Errorf("Execs() = %+v", execs)
+	}
+}
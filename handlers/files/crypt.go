@@ -0,0 +1,285 @@
+package files
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/lib/pq"
+
+	"allanswebterminal/db"
+	"allanswebterminal/vault"
+)
+
+// File content is encrypted at rest when a keyring is set: content then
+// holds the base64 of the ciphertext, key_id the keyring key that wrapped
+// the file's data key, and data_key the wrapped key. Rows with a NULL
+// key_id are plaintext, as written before encryption was turned on.
+
+var (
+	keyringMu sync.RWMutex
+	keyring   *vault.Keyring
+)
+
+// SetKeyring turns on encryption of saved files under k. nil stops
+// encrypting new saves; files already encrypted then cannot be loaded.
+func SetKeyring(k *vault.Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	keyring = k
+}
+
+func currentKeyring() *vault.Keyring {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	return keyring
+}
+
+// contentContext binds file content to its owner and name, so content
+// copied to another row does not decrypt.
+func contentContext(accountID int, filename string) []byte {
+	return []byte(fmt.Sprintf("file:%d:%s", accountID, filename))
+}
+
+// sealContent returns the content, key_id and data_key columns to store
+// content with: as given and NULLs when no keyring is set.
+func sealContent(accountID int, filename, content string) (string, any, any, error) {
+	k := currentKeyring()
+	if k == nil {
+		return content, nil, nil, nil
+	}
+	e, err := k.Seal([]byte(content), contentContext(accountID, filename))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return base64.StdEncoding.EncodeToString(e.Ciphertext), e.KeyID, e.DataKey, nil
+}
+
+// Decrypt returns the plaintext of content as stored in user_files with
+// its key_id and data_key columns. Packages that read user_files directly
+// rather than through Load pass the content through this.
+func Decrypt(accountID int, filename, content string, keyID sql.NullString, dataKey []byte) (string, error) {
+	if !keyID.Valid {
+		return content, nil
+	}
+	k := currentKeyring()
+	if k == nil {
+		return "", vault.ErrNotConfigured
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return "", vault.ErrDecrypt
+	}
+	plain, err := k.Open(vault.Envelope{KeyID: keyID.String, DataKey: dataKey, Ciphertext: ciphertext},
+		contentContext(accountID, filename))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// encryptBatch bounds the rows Reencrypt locks at a time.
+const encryptBatch = 100
+
+// Reencrypt brings stored files and the chunks of their versions up to the
+// current keyring: plaintext rows
+// are encrypted and data keys wrapped under retired keys are rewrapped
+// under the primary one, which leaves their content as it is. Once no row
+// uses a retired key, it can be removed from the keyring. Rows are locked
+// while they are rewritten, so concurrent saves wait rather than be lost,
+// and updated_at is kept, as the content has not changed.
+func Reencrypt(ctx context.Context) error {
+	k := currentKeyring()
+	if k == nil {
+		return nil
+	}
+	done := 0
+	for _, batch := range []func(context.Context, *vault.Keyring, int) (int, int, error){reencryptBatch, reencryptChunkBatch} {
+		after := 0
+		for {
+			n, last, err := batch(ctx, k, after)
+			done += n
+			if err != nil {
+				return err
+			}
+			if last == after {
+				break
+			}
+			after = last
+		}
+	}
+	if done > 0 {
+		log.Printf("Encrypted or rewrapped %d stored files and version chunks under key %s", done, k.Primary())
+	}
+	return nil
+}
+
+// reencryptBatch rewrites the next rows with an ID above after, returning
+// how many it rewrote and the last ID it looked at. Rows that fail to
+// decrypt are logged and skipped.
+func reencryptBatch(ctx context.Context, k *vault.Keyring, after int) (int, int, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, after, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, account_id, filename, content, key_id, data_key FROM user_files
+		 WHERE id > $1 AND (key_id IS NULL OR key_id = ANY($2))
+		 ORDER BY id LIMIT $3
+		 FOR UPDATE SKIP LOCKED`,
+		after, pq.Array(k.Retired()), encryptBatch)
+	if err != nil {
+		return 0, after, err
+	}
+	type row struct {
+		id, accountID     int
+		filename, content string
+		keyID             sql.NullString
+		dataKey           []byte
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.accountID, &r.filename, &r.content, &r.keyID, &r.dataKey); err != nil {
+			rows.Close()
+			return 0, after, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, after, err
+	}
+
+	last, n := after, 0
+	for _, r := range batch {
+		last = r.id
+		binding := contentContext(r.accountID, r.filename)
+		var e vault.Envelope
+		var err error
+		if r.keyID.Valid {
+			var ciphertext []byte
+			if ciphertext, err = base64.StdEncoding.DecodeString(r.content); err == nil {
+				e, err = k.Rewrap(vault.Envelope{KeyID: r.keyID.String, DataKey: r.dataKey, Ciphertext: ciphertext}, binding)
+			}
+			if err != nil {
+				log.Printf("Failed to rewrap the key of file %d: %v", r.id, err)
+				continue
+			}
+		} else if e, err = k.Seal([]byte(r.content), binding); err != nil {
+			return 0, after, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE user_files SET content = $1, key_id = $2, data_key = $3 WHERE id = $4`,
+			base64.StdEncoding.EncodeToString(e.Ciphertext), e.KeyID, e.DataKey, r.id,
+		); err != nil {
+			return 0, after, err
+		}
+		n++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, after, err
+	}
+	return n, last, nil
+}
+
+// reencryptChunkBatch is reencryptBatch for version chunks.
+func reencryptChunkBatch(ctx context.Context, k *vault.Keyring, after int) (int, int, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, after, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, account_id, hash, data, key_id, data_key FROM file_chunks
+		 WHERE id > $1 AND (key_id IS NULL OR key_id = ANY($2))
+		 ORDER BY id LIMIT $3
+		 FOR UPDATE SKIP LOCKED`,
+		after, pq.Array(k.Retired()), encryptBatch)
+	if err != nil {
+		return 0, after, err
+	}
+	type row struct {
+		id, accountID int
+		hash          string
+		data, dataKey []byte
+		keyID         sql.NullString
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.accountID, &r.hash, &r.data, &r.keyID, &r.dataKey); err != nil {
+			rows.Close()
+			return 0, after, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, after, err
+	}
+
+	last, n := after, 0
+	for _, r := range batch {
+		last = r.id
+		binding := chunkContext(r.accountID, r.hash)
+		var e vault.Envelope
+		var err error
+		if r.keyID.Valid {
+			if e, err = k.Rewrap(vault.Envelope{KeyID: r.keyID.String, DataKey: r.dataKey, Ciphertext: r.data}, binding); err != nil {
+				log.Printf("Failed to rewrap the key of file chunk %d: %v", r.id, err)
+				continue
+			}
+		} else if e, err = k.Seal(r.data, binding); err != nil {
+			return 0, after, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE file_chunks SET data = $1, key_id = $2, data_key = $3 WHERE id = $4`,
+			e.Ciphertext, e.KeyID, e.DataKey, r.id,
+		); err != nil {
+			return 0, after, err
+		}
+		n++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, after, err
+	}
+	return n, last, nil
+}
+
+// EncryptionStatus counts stored files and version chunks by the key that
+// wraps their data key, "" counting plaintext ones.
+func EncryptionStatus(ctx context.Context) (map[string]int, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT COALESCE(key_id, ''), COUNT(*) FROM (SELECT key_id FROM user_files UNION ALL SELECT key_id FROM file_chunks) stored GROUP BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var keyID string
+		var n int
+		if err := rows.Scan(&keyID, &n); err != nil {
+			return nil, err
+		}
+		counts[keyID] = n
+	}
+	return counts, rows.Err()
+}
+
+// EncryptionKeys returns the primary and retired key IDs of the keyring,
+// or "" and nil when files are not encrypted.
+func EncryptionKeys() (string, []string) {
+	k := currentKeyring()
+	if k == nil {
+		return "", nil
+	}
+	return k.Primary(), k.Retired()
+}
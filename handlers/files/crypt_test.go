@@ -0,0 +1,180 @@
+package files
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"allanswebterminal/db"
+	"allanswebterminal/vault"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := db.DB
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = original
+		mockDB.Close()
+	})
+	return mock
+}
+
+func useKeyring(t *testing.T, spec string) *vault.Keyring {
+	t.Helper()
+	k, err := vault.ParseKeyring(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyring(k)
+	t.Cleanup(func() { SetKeyring(nil) })
+	return k
+}
+
+// capture records a query argument so the test can look at it.
+type capture struct{ value driver.Value }
+
+func (c *capture) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+var storedColumns = []string{"id", "account_id", "filename", "content", "file_type", "created_at", "updated_at", "key_id", "data_key", "scan_status"}
+
+func TestSaveLoadEncrypted(t *testing.T) {
+	mock := setupMockDB(t)
+	useKeyring(t, "k1:"+vault.NewKey())
+
+	var content, keyID, dataKey capture
+	mock.ExpectQuery("INSERT INTO user_files").
+		WithArgs(7, "main.py", &content, "python", &keyID, &dataKey, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, time.Now(), time.Now()))
+	expectVersion(mock, 7, "main.py")
+	file := &UserFile{AccountID: 7, Filename: "main.py", Content: "print('secret plan')"}
+	if err := Save(context.Background(), file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Content != "print('secret plan')" {
+		t.Errorf("Save() changed the file's content to %q", file.Content)
+	}
+	stored, _ := content.value.(string)
+	if strings.Contains(stored, "secret") || keyID.value != "k1" {
+		t.Fatalf("stored content %q under key %v", stored, keyID.value)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("FROM user_files").WithArgs(7, "main.py").
+		WillReturnRows(sqlmock.NewRows(storedColumns).
+			AddRow(1, 7, "main.py", stored, "python", time.Now(), time.Now(), "k1", dataKey.value, nil))
+	loaded, err := Load(context.Background(), 7, "main.py")
+	if err != nil || loaded.Content != "print('secret plan')" {
+		t.Errorf("Load() = %+v, %v", loaded, err)
+	}
+
+	// Content moved to another account does not decrypt.
+	mock.ExpectQuery("FROM user_files").WithArgs(8, "main.py").
+		WillReturnRows(sqlmock.NewRows(storedColumns).
+			AddRow(2, 8, "main.py", stored, "python", time.Now(), time.Now(), "k1", dataKey.value, nil))
+	if _, err := Load(context.Background(), 8, "main.py"); !errors.Is(err, vault.ErrDecrypt) {
+		t.Errorf("Load() of moved content error = %v, want ErrDecrypt", err)
+	}
+
+	SetKeyring(nil)
+	mock.ExpectQuery("FROM user_files").WithArgs(7, "main.py").
+		WillReturnRows(sqlmock.NewRows(storedColumns).
+			AddRow(1, 7, "main.py", stored, "python", time.Now(), time.Now(), "k1", dataKey.value, nil))
+	if _, err := Load(context.Background(), 7, "main.py"); !errors.Is(err, vault.ErrNotConfigured) {
+		t.Errorf("Load() without the keyring error = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestLoadPlaintext(t *testing.T) {
+	mock := setupMockDB(t)
+	useKeyring(t, "k1:"+vault.NewKey())
+	mock.ExpectQuery("FROM user_files").WithArgs(7, "old.py").
+		WillReturnRows(sqlmock.NewRows(storedColumns).
+			AddRow(1, 7, "old.py", "print(1)", "python", time.Now(), time.Now(), nil, nil, nil))
+	file, err := Load(context.Background(), 7, "old.py")
+	if err != nil || file.Content != "print(1)" {
+		t.Errorf("Load() = %+v, %v", file, err)
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	mock := setupMockDB(t)
+	oldKey := vault.NewKey()
+	old := useKeyring(t, "old:"+oldKey)
+	e, err := old.Seal([]byte("print(2)"), contentContext(7, "b.py"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey := vault.NewKey()
+	useKeyring(t, "new:"+newKey+",old:"+oldKey)
+
+	columns := []string{"id", "account_id", "filename", "content", "key_id", "data_key"}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, account_id, filename, content, key_id, data_key FROM user_files").
+		WithArgs(0, sqlmock.AnyArg(), encryptBatch).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, 7, "a.py", "print(1)", nil, nil).
+			AddRow(2, 7, "b.py", base64.StdEncoding.EncodeToString(e.Ciphertext), "old", e.DataKey).
+			AddRow(3, 7, "c.py", "broken", "old", []byte("nope")))
+	var plainContent, plainKey, rewrapped capture
+	mock.ExpectExec("UPDATE user_files SET content").
+		WithArgs(&plainContent, "new", &plainKey, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE user_files SET content").
+		WithArgs(base64.StdEncoding.EncodeToString(e.Ciphertext), "new", &rewrapped, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM user_files").WithArgs(3, sqlmock.AnyArg(), encryptBatch).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectCommit()
+	chunkColumns := []string{"id", "account_id", "hash", "data", "key_id", "data_key"}
+	var chunkData, chunkKey capture
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, account_id, hash, data, key_id, data_key FROM file_chunks").
+		WithArgs(0, sqlmock.AnyArg(), encryptBatch).
+		WillReturnRows(sqlmock.NewRows(chunkColumns).AddRow(1, 7, "h1", []byte("print(3)"), nil, nil))
+	mock.ExpectExec("UPDATE file_chunks SET data").
+		WithArgs(&chunkData, "new", &chunkKey, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM file_chunks").WithArgs(1, sqlmock.AnyArg(), encryptBatch).
+		WillReturnRows(sqlmock.NewRows(chunkColumns))
+	mock.ExpectCommit()
+
+	if err := Reencrypt(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both rows open under the new key alone.
+	final, _ := vault.ParseKeyring("new:" + newKey)
+	ciphertext, _ := base64.StdEncoding.DecodeString(plainContent.value.(string))
+	plain, err := final.Open(vault.Envelope{KeyID: "new", DataKey: plainKey.value.([]byte), Ciphertext: ciphertext}, contentContext(7, "a.py"))
+	if err != nil || string(plain) != "print(1)" {
+		t.Errorf("encrypted plaintext row opens to %q, %v", plain, err)
+	}
+	plain, err = final.Open(vault.Envelope{KeyID: "new", DataKey: rewrapped.value.([]byte), Ciphertext: e.Ciphertext}, contentContext(7, "b.py"))
+	if err != nil || string(plain) != "print(2)" {
+		t.Errorf("rewrapped row opens to %q, %v", plain, err)
+	}
+	plain, err = final.Open(vault.Envelope{KeyID: "new", DataKey: chunkKey.value.([]byte), Ciphertext: chunkData.value.([]byte)}, chunkContext(7, "h1"))
+	if err != nil || string(plain) != "print(3)" {
+		t.Errorf("encrypted chunk opens to %q, %v", plain, err)
+	}
+}
@@ -1,62 +1,63 @@
 package files
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
 	"allanswebterminal/db"
 	"allanswebterminal/handlers/login"
+	"allanswebterminal/pagination"
 )
 
 type UserFile struct {
-	ID        int       `json:"id"`
-	AccountID int       `json:"account_id"`
-	Filename  string    `json:"filename"`
-	Content   string    `json:"content"`
-	FileType  string    `json:"file_type"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int    `json:"id"`
+	AccountID int    `json:"account_id"`
+	Filename  string `json:"filename"`
+	// Path is the filename in the account's namespace, such as
+	// "/000000000007/main.py".
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	FileType string `json:"file_type"`
+	// ProjectID is the project the file belongs to: the account's project
+	// named as the first directory of Filename, if any.
+	ProjectID int `json:"project_id,omitempty"`
+	// ScanStatus is the malware scan's "pending", "clean" or "infected",
+	// empty for files saved without a scanner.
+	ScanStatus string    `json:"scan_status,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 }
 
 func SaveFileHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Get user session (simplified - you'd want proper session management)
 	accountID := getUserIDFromSession(r)
 	if accountID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, apierror.Unauthorized())
 		return
 	}
 
 	var file UserFile
 	if err := json.NewDecoder(r.Body).Decode(&file); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		apierror.Write(w, apierror.DecodeError(err))
 		return
 	}
 
-	file.AccountID = accountID
-	if file.FileType == "" {
-		file.FileType = "python"
+	if reservedFileTypes[file.FileType] {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("File type %q is reserved", file.FileType)))
+		return
 	}
 
-	query := `
-		INSERT INTO user_files (account_id, filename, content, file_type, updated_at)
-		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
-		ON CONFLICT (account_id, filename)
-		DO UPDATE SET content = EXCLUDED.content, file_type = EXCLUDED.file_type, updated_at = CURRENT_TIMESTAMP
-		RETURNING id, created_at, updated_at
-	`
-
-	err := db.DB.QueryRow(query, file.AccountID, file.Filename, file.Content, file.FileType).Scan(
-		&file.ID, &file.CreatedAt, &file.UpdatedAt,
-	)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to save file: %v", err), http.StatusInternalServerError)
+	file.AccountID = accountID
+	if err := Save(r.Context(), &file); err != nil {
+		log.Printf("Failed to save file: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save file"))
 		return
 	}
 
@@ -65,36 +66,31 @@ func SaveFileHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func LoadFileHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	accountID := getUserIDFromSession(r)
 	if accountID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, apierror.Unauthorized())
 		return
 	}
 
 	filename := r.URL.Query().Get("filename")
 	if filename == "" {
-		http.Error(w, "Filename required", http.StatusBadRequest)
+		apierror.Write(w, apierror.Validation("Filename required"))
 		return
 	}
 
-	var file UserFile
-	query := `
-		SELECT id, account_id, filename, content, file_type, created_at, updated_at
-		FROM user_files 
-		WHERE account_id = $1 AND filename = $2
-	`
-
-	err := db.DB.QueryRow(query, accountID, filename).Scan(
-		&file.ID, &file.AccountID, &file.Filename, &file.Content, 
-		&file.FileType, &file.CreatedAt, &file.UpdatedAt,
-	)
+	file, err := Load(r.Context(), accountID, filename)
+	if errors.Is(err, ErrNotFound) {
+		apierror.Write(w, apierror.NotFound("File not found"))
+		return
+	}
+	if errors.Is(err, ErrQuarantined) {
+		apierror.Write(w, apierror.Forbidden("This file was quarantined by the malware scanner").
+			WithDetails(map[string]string{"reason": "quarantined"}))
+		return
+	}
 	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Failed to load file: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load file"))
 		return
 	}
 
@@ -102,80 +98,146 @@ func LoadFileHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(file)
 }
 
+// File listings are paginated; sort keys map to these columns.
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+var listSortColumns = map[string]string{
+	"name":    "filename",
+	"type":    "file_type",
+	"created": "created_at",
+	"updated": "updated_at",
+}
+
+var listOptions = pagination.Options{
+	DefaultLimit: defaultListLimit,
+	MaxLimit:     maxListLimit,
+	Sorts:        []string{"name", "type", "created", "updated"},
+	DefaultSort:  "-updated",
+}
+
+// ListFilesHandler lists the caller's files without their content. Query
+// parameters: limit (default 50, max 200), offset, sort (name, type,
+// created or updated, "-" for descending; default -updated), type (exact
+// file type), project (project name) and q (filename substring,
+// case-insensitive).
 func ListFilesHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	accountID := getUserIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
 		return
 	}
 
-	accountID := getUserIDFromSession(r)
-	if accountID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	q := r.URL.Query()
+	page, apiErr := pagination.Parse(q, listOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
 		return
 	}
 
-	query := `
-		SELECT id, account_id, filename, file_type, created_at, updated_at
-		FROM user_files 
-		WHERE account_id = $1
-		ORDER BY updated_at DESC
-	`
+	where, args := listFilter(accountID, q.Get("type"), q.Get("project"), q.Get("q"))
+	var total int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM user_files WHERE `+where, args...).Scan(&total); err != nil {
+		log.Printf("Failed to count files: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to get files"))
+		return
+	}
 
-	rows, err := db.DB.Query(query, accountID)
+	rows, err := db.DB.Query(listQuery(where, page, len(args)), append(args, page.Limit, page.Offset)...)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get files: %v", err), http.StatusInternalServerError)
+		log.Printf("Failed to get files: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to get files"))
 		return
 	}
 	defer rows.Close()
 
-	var files []UserFile
+	files := []UserFile{}
 	for rows.Next() {
 		var file UserFile
+		var scanStatus sql.NullString
+		var projectID sql.NullInt64
 		err := rows.Scan(
-			&file.ID, &file.AccountID, &file.Filename, 
-			&file.FileType, &file.CreatedAt, &file.UpdatedAt,
+			&file.ID, &file.AccountID, &file.Filename,
+			&file.FileType, &file.CreatedAt, &file.UpdatedAt, &scanStatus, &projectID,
 		)
 		if err != nil {
 			continue
 		}
+		file.ScanStatus = scanStatus.String
+		file.ProjectID = int(projectID.Int64)
+		file.Path = accounts.FilePath(file.AccountID, file.Filename)
 		files = append(files, file)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(files)
+	json.NewEncoder(w).Encode(pagination.NewPage(files, total, page))
 }
 
-func DeleteFileHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// listFilter builds the WHERE clause shared by the count and page queries.
+func listFilter(accountID int, fileType, project, search string) (string, []interface{}) {
+	where := "account_id = $1"
+	args := []interface{}{accountID}
+	if fileType != "" {
+		args = append(args, fileType)
+		where += fmt.Sprintf(" AND file_type = $%d", len(args))
+	}
+	if project != "" {
+		args = append(args, project)
+		where += fmt.Sprintf(" AND project_id = (SELECT id FROM projects WHERE account_id = $1 AND name = $%d)", len(args))
+	}
+	if search != "" {
+		args = append(args, pagination.LikePattern(search))
+		where += fmt.Sprintf(" AND filename ILIKE $%d", len(args))
 	}
+	return where, args
+}
 
+// listQuery selects one page. Ties on the sort column are broken by id in
+// the same direction so pages never overlap or skip rows.
+func listQuery(where string, page pagination.Params, nargs int) string {
+	dir := "ASC"
+	if page.Desc {
+		dir = "DESC"
+	}
+	return fmt.Sprintf(`
+		SELECT id, account_id, filename, file_type, created_at, updated_at, scan_status, project_id
+		FROM user_files
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT $%d OFFSET $%d
+	`, where, listSortColumns[page.Sort], dir, dir, nargs+1, nargs+2)
+}
+
+func DeleteFileHandler(w http.ResponseWriter, r *http.Request) {
 	accountID := getUserIDFromSession(r)
 	if accountID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, apierror.Unauthorized())
 		return
 	}
 
 	filename := r.URL.Query().Get("filename")
 	if filename == "" {
-		http.Error(w, "Filename required", http.StatusBadRequest)
+		apierror.Write(w, apierror.Validation("Filename required"))
 		return
 	}
 
 	query := `DELETE FROM user_files WHERE account_id = $1 AND filename = $2`
 	result, err := db.DB.Exec(query, accountID, filename)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to delete file: %v", err), http.StatusInternalServerError)
+		log.Printf("Failed to delete file: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete file"))
 		return
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		http.Error(w, "File not found", http.StatusNotFound)
+		apierror.Write(w, apierror.NotFound("File not found"))
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "File deleted successfully"})
 }
@@ -187,4 +249,4 @@ func getUserIDFromSession(r *http.Request) int {
 		return 0
 	}
 	return user.ID
-}
\ No newline at end of file
+}
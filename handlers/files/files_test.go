@@ -1,10 +1,11 @@
 package files
 
 import (
-	"net/http"
-	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"allanswebterminal/pagination"
 )
 
 func saveFile(filename, content string, accountID int) (*UserFile, error) {
@@ -160,68 +161,31 @@ func TestDeleteFile(t *testing.T) {
 	}
 }
 
-func TestSaveFileHandler_MethodValidation(t *testing.T) {
-	tests := []struct {
-		name           string
-		method         string
-		expectedStatus int
-	}{
-		{
-			name:           "invalid method GET",
-			method:         "GET",
-			expectedStatus: http.StatusMethodNotAllowed,
-		},
-		{
-			name:           "invalid method PUT",
-			method:         "PUT",
-			expectedStatus: http.StatusMethodNotAllowed,
-		},
-		{
-			name:           "invalid method DELETE",
-			method:         "DELETE",
-			expectedStatus: http.StatusMethodNotAllowed,
-		},
+func TestListFilter(t *testing.T) {
+	where, args := listFilter(7, "python", "", "main")
+	if where != "account_id = $1 AND file_type = $2 AND filename ILIKE $3" {
+		t.Errorf("where = %q", where)
 	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(tt.method, "/api/files/save", nil)
-			w := httptest.NewRecorder()
-			SaveFileHandler(w, req)
-			
-			if w.Code != tt.expectedStatus {
-				t.Errorf("SaveFileHandler() status = %v, want %v", w.Code, tt.expectedStatus)
-			}
-		})
+	if len(args) != 3 || args[0] != 7 || args[1] != "python" || args[2] != "%main%" {
+		t.Errorf("args = %v", args)
 	}
-}
 
-func TestLoadFileHandler_MethodValidation(t *testing.T) {
-	req := httptest.NewRequest("POST", "/api/files/load", nil)
-	w := httptest.NewRecorder()
-	LoadFileHandler(w, req)
-	
-	if w.Code != http.StatusMethodNotAllowed {
-		t.Errorf("LoadFileHandler() status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	where, args = listFilter(7, "", "blog", "")
+	if where != "account_id = $1 AND project_id = (SELECT id FROM projects WHERE account_id = $1 AND name = $2)" || len(args) != 2 {
+		t.Errorf("project filter = %q %v", where, args)
 	}
-}
 
-func TestListFilesHandler_MethodValidation(t *testing.T) {
-	req := httptest.NewRequest("POST", "/api/files/list", nil)
-	w := httptest.NewRecorder()
-	ListFilesHandler(w, req)
-	
-	if w.Code != http.StatusMethodNotAllowed {
-		t.Errorf("ListFilesHandler() status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	where, args = listFilter(7, "", "", "")
+	if where != "account_id = $1" || len(args) != 1 {
+		t.Errorf("unfiltered = %q %v", where, args)
 	}
 }
 
-func TestDeleteFileHandler_MethodValidation(t *testing.T) {
-	req := httptest.NewRequest("GET", "/api/files/delete", nil)
-	w := httptest.NewRecorder()
-	DeleteFileHandler(w, req)
-	
-	if w.Code != http.StatusMethodNotAllowed {
-		t.Errorf("DeleteFileHandler() status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
+func TestListQueryOrdering(t *testing.T) {
+	query := listQuery("account_id = $1", pagination.Params{Limit: 10, Sort: "name", Desc: true}, 1)
+	for _, want := range []string{"ORDER BY filename DESC, id DESC", "LIMIT $2 OFFSET $3"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q:\n%s", want, query)
+		}
 	}
-}
\ No newline at end of file
+}
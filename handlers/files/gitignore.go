@@ -0,0 +1,128 @@
+package files
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is one pattern line of a .gitignore file.
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+// gitignore matches paths against the .gitignore files of a tree. Rules are
+// kept in the order git applies them: a directory's file after its
+// parent's, and within a file top to bottom, so the last match wins.
+type gitignore struct {
+	rules []ignoreRule
+}
+
+// add appends the rules of the .gitignore file in dir ("" for the root).
+// Lines that do not compile are skipped, as git skips patterns it cannot
+// use.
+func (g *gitignore) add(dir, content string) {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		re, err := regexp.Compile(ignorePattern(dir, line))
+		if err != nil {
+			continue
+		}
+		rule.pattern = re
+		g.rules = append(g.rules, rule)
+	}
+}
+
+// ignored reports whether the file at name, relative to the root of the
+// tree, is ignored.
+func (g *gitignore) ignored(name string) bool {
+	ignored := false
+	for _, rule := range g.rules {
+		if rule.pattern.MatchString(name) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// ignorePattern translates a .gitignore pattern of the file in dir into a
+// regular expression over file paths. A pattern with a slash before its
+// end is anchored to dir, otherwise it matches at any depth below it. A
+// pattern matching a directory matches every file inside it, and one
+// ending in a slash matches only directories.
+func ignorePattern(dir, pattern string) string {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if dir != "" {
+		b.WriteString(regexp.QuoteMeta(dir) + "/")
+	}
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**":
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	if dirOnly {
+		b.WriteString("/.*$")
+	} else {
+		b.WriteString("(?:/.*)?$")
+	}
+	return b.String()
+}
+
+// gitignoreDir is the directory a .gitignore file at name applies to.
+func gitignoreDir(name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
@@ -0,0 +1,374 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/handlers/operations"
+)
+
+// Repository imports take a snapshot of one ref, as a clone with --depth 1
+// would, and keep only text files.
+const (
+	MaxGitArchiveBytes = 50 << 20
+	MaxGitImportBytes  = 10 << 20
+	MaxGitFileBytes    = 512 << 10
+	MaxGitFiles        = 500
+	// MaxGitDepth is the number of directories a kept file may be nested in.
+	MaxGitDepth = 10
+)
+
+// gitArchiveURL serves repository snapshots as gzipped tarballs at
+// /<owner>/<repo>/tar.gz/<ref>. Tests point it at a local server.
+var gitArchiveURL = "https://codeload.github.com"
+
+var gitClient = &http.Client{
+	Timeout: 2 * time.Minute,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" || (req.URL.Host != "codeload.github.com" && req.URL.Host != "github.com") {
+			return fmt.Errorf("redirect to %s refused", req.URL.Host)
+		}
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+var (
+	gitOwnerPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,38})$`)
+	gitRepoPattern  = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+	gitRefPattern   = regexp.MustCompile(`^[A-Za-z0-9._][A-Za-z0-9._/-]{0,254}$`)
+	gitDirPattern   = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+)
+
+type GitImportRequest struct {
+	// URL is the repository's address, https://github.com/<owner>/<repo>.
+	URL string `json:"url"`
+	// Ref is the branch, tag or commit to import, the default branch if
+	// empty.
+	Ref string `json:"ref"`
+	// Dir is the directory the files are saved under, the repository's
+	// name if empty.
+	Dir string `json:"dir"`
+
+	owner, repo string
+}
+
+type GitImportResult struct {
+	Dir   string `json:"dir"`
+	Files int    `json:"files"`
+	Bytes int    `json:"bytes"`
+	// Ignored counts files matched by the repository's .gitignore files,
+	// Skipped those that are binary, too large or too deeply nested.
+	Ignored int `json:"ignored"`
+	Skipped int `json:"skipped"`
+}
+
+// ImportGitHandler validates a repository import and runs it in the
+// background, answering 202 with an operation whose progress is streamed
+// from /api/operations/events.
+func ImportGitHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req GitImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := validateGitImport(&req); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	op := operations.Start("git_import", user.ID)
+	go importGit(op, user.ID, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"operation_id": op.ID,
+		"events_url":   "/api/operations/events?id=" + op.ID,
+	})
+}
+
+func validateGitImport(req *GitImportRequest) error {
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || u.Scheme != "https" || u.Host != "github.com" || u.User != nil || u.RawQuery != "" {
+		return fmt.Errorf("url must be a GitHub repository, such as https://github.com/owner/repo")
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("url must be a GitHub repository, such as https://github.com/owner/repo")
+	}
+	req.owner, req.repo = parts[0], strings.TrimSuffix(parts[1], ".git")
+	if !gitOwnerPattern.MatchString(req.owner) || !gitRepoPattern.MatchString(req.repo) || req.repo == "." || req.repo == ".." {
+		return fmt.Errorf("url must be a GitHub repository, such as https://github.com/owner/repo")
+	}
+
+	req.Ref = strings.TrimSpace(req.Ref)
+	if req.Ref == "" {
+		req.Ref = "HEAD"
+	}
+	if !gitRefPattern.MatchString(req.Ref) || strings.Contains(req.Ref, "..") || strings.HasSuffix(req.Ref, "/") {
+		return fmt.Errorf("ref %q is not a valid branch, tag or commit", req.Ref)
+	}
+
+	req.Dir = strings.Trim(strings.TrimSpace(req.Dir), "/")
+	if req.Dir == "" {
+		req.Dir = req.repo
+	}
+	if !gitDirPattern.MatchString(req.Dir) || req.Dir == "." || req.Dir == ".." {
+		return fmt.Errorf("dir must be 1-64 letters, digits, '.', '-' or '_'")
+	}
+	return nil
+}
+
+// gitFile is a file of the snapshot kept for import, named relative to the
+// repository's root.
+type gitFile struct {
+	name    string
+	content string
+}
+
+// importGit downloads the snapshot, then saves the files the repository's
+// .gitignore files do not exclude. Downloading is the first half of the
+// progress, saving the second.
+func importGit(op *operations.Operation, accountID int, req GitImportRequest) {
+	ctx := context.Background()
+	source := fmt.Sprintf("%s/%s", req.owner, req.repo)
+
+	kept, ignore, result, err := fetchGitSnapshot(ctx, op, req)
+	if err != nil {
+		log.Printf("Git import of %s for account %d failed: %v", source, accountID, err)
+		var userErr gitImportError
+		if errors.As(err, &userErr) {
+			op.Fail(string(userErr))
+		} else {
+			op.Fail("Import failed")
+		}
+		return
+	}
+
+	files := kept[:0]
+	for _, f := range kept {
+		if ignore.ignored(f.name) {
+			result.Ignored++
+			continue
+		}
+		files = append(files, f)
+	}
+
+	total := len(files)
+	for i, f := range files {
+		file := &UserFile{
+			AccountID: accountID,
+			Filename:  req.Dir + "/" + f.name,
+			Content:   f.content,
+			FileType:  gitFileType(f.name),
+		}
+		if err := Save(ctx, file); err != nil {
+			log.Printf("Git import of %s for account %d failed to save %s: %v", source, accountID, file.Filename, err)
+			op.Fail(fmt.Sprintf("Import failed on %s after %d of %d files", f.name, i, total))
+			return
+		}
+		result.Files++
+		result.Bytes += len(f.content)
+		op.Progress(50+(i+1)*50/total, fmt.Sprintf("Saved %d of %d files", i+1, total))
+	}
+
+	op.Complete(result)
+
+	_, err = notifications.Notify(ctx, accountID, notifications.KindJobFinished,
+		"Repository import finished", fmt.Sprintf("%s is in %s/ with %d files.", source, req.Dir, result.Files), "")
+	if err != nil {
+		log.Printf("Git import: %v", err)
+	}
+}
+
+// gitImportError is a failure whose message is shown to the user as is.
+type gitImportError string
+
+func (e gitImportError) Error() string { return string(e) }
+
+// fetchGitSnapshot downloads and unpacks the snapshot of req, returning
+// the text files to keep and the rules of the .gitignore files met along
+// the way. Tarballs list a directory's .gitignore before most of its
+// files, so ignored files are mostly left out as they are read; the caller
+// filters again once every rule is known.
+func fetchGitSnapshot(ctx context.Context, op *operations.Operation, req GitImportRequest) ([]gitFile, *gitignore, GitImportResult, error) {
+	result := GitImportResult{Dir: req.Dir}
+	ignore := &gitignore{}
+
+	archiveURL := fmt.Sprintf("%s/%s/%s/tar.gz/%s", gitArchiveURL, url.PathEscape(req.owner), url.PathEscape(req.repo), req.Ref)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL, nil)
+	if err != nil {
+		return nil, nil, result, err
+	}
+	resp, err := gitClient.Do(httpReq)
+	if err != nil {
+		return nil, nil, result, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil, result, gitImportError("Repository or ref not found; only public GitHub repositories can be imported")
+	case resp.StatusCode != http.StatusOK:
+		return nil, nil, result, fmt.Errorf("archive answered %s", resp.Status)
+	}
+	if resp.ContentLength > MaxGitArchiveBytes {
+		return nil, nil, result, gitImportError(fmt.Sprintf("Repository is larger than %d MB", MaxGitArchiveBytes>>20))
+	}
+
+	body := &progressReader{r: io.LimitReader(resp.Body, MaxGitArchiveBytes+1), size: resp.ContentLength, op: op}
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, nil, result, fmt.Errorf("archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var kept []gitFile
+	held := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if body.read > MaxGitArchiveBytes {
+			return nil, nil, result, gitImportError(fmt.Sprintf("Repository is larger than %d MB", MaxGitArchiveBytes>>20))
+		}
+		if err != nil {
+			return nil, nil, result, fmt.Errorf("archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// Entries sit in a top-level directory named after the commit.
+		_, name, ok := strings.Cut(hdr.Name, "/")
+		if !ok || name == "" {
+			continue
+		}
+		name = path.Clean(name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+
+		if path.Base(name) == ".gitignore" && hdr.Size <= MaxGitFileBytes {
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, nil, result, fmt.Errorf("archive: %w", err)
+			}
+			ignore.add(gitignoreDir(name), string(content))
+			if strings.Count(name, "/") <= MaxGitDepth {
+				kept = append(kept, gitFile{name: name, content: string(content)})
+				held += len(content)
+			}
+			continue
+		}
+		if ignore.ignored(name) {
+			result.Ignored++
+			continue
+		}
+		if reason := gitSkipReason(name, hdr.Size); reason != "" {
+			result.Skipped++
+			op.Log(fmt.Sprintf("Skipped %s: %s", name, reason))
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, result, fmt.Errorf("archive: %w", err)
+		}
+		if bytes.IndexByte(content, 0) >= 0 || !utf8.Valid(content) {
+			result.Skipped++
+			op.Log(fmt.Sprintf("Skipped %s: binary file", name))
+			continue
+		}
+
+		kept = append(kept, gitFile{name: name, content: string(content)})
+		held += len(content)
+		if len(kept) > MaxGitFiles {
+			return nil, nil, result, gitImportError(fmt.Sprintf("Repository has more than %d files to import", MaxGitFiles))
+		}
+		if held > MaxGitImportBytes {
+			return nil, nil, result, gitImportError(fmt.Sprintf("Repository has more than %d MB of files to import", MaxGitImportBytes>>20))
+		}
+	}
+	if body.read > MaxGitArchiveBytes {
+		return nil, nil, result, gitImportError(fmt.Sprintf("Repository is larger than %d MB", MaxGitArchiveBytes>>20))
+	}
+	op.Progress(50, fmt.Sprintf("Downloaded %d files", len(kept)))
+	return kept, ignore, result, nil
+}
+
+// gitSkipReason says why the file at name of size bytes is not imported,
+// or is empty if it is.
+func gitSkipReason(name string, size int64) string {
+	if strings.Count(name, "/") > MaxGitDepth {
+		return fmt.Sprintf("nested more than %d directories deep", MaxGitDepth)
+	}
+	if size > MaxGitFileBytes {
+		return fmt.Sprintf("larger than %d KB", MaxGitFileBytes>>10)
+	}
+	return ""
+}
+
+// gitFileTypes maps file extensions to the file types the editor knows.
+var gitFileTypes = map[string]string{
+	".py":   "python",
+	".js":   "javascript",
+	".json": "json",
+	".md":   "markdown",
+	".html": "html",
+	".css":  "css",
+	".sh":   "shell",
+}
+
+func gitFileType(name string) string {
+	if fileType, ok := gitFileTypes[strings.ToLower(path.Ext(name))]; ok {
+		return fileType
+	}
+	return "text"
+}
+
+// progressReader reports download progress on op while it is read, up to
+// half the operation, when the size is known.
+type progressReader struct {
+	r       io.Reader
+	size    int64
+	read    int64
+	percent int
+	op      *operations.Operation
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.size > 0 {
+		if percent := int(min(p.read, p.size) * 50 / p.size); percent > p.percent {
+			p.percent = percent
+			p.op.Progress(percent, fmt.Sprintf("Downloaded %d of %d KB", p.read>>10, p.size>>10))
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,205 @@
+package files
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/malware"
+)
+
+// Scan statuses of user_files.scan_status. Files saved while no scanner
+// is configured have none and are never scanned.
+const (
+	ScanStatusPending  = "pending"
+	ScanStatusClean    = "clean"
+	ScanStatusInfected = "infected"
+)
+
+// ErrQuarantined is returned by Load for a file the malware scanner
+// flagged. It stays quarantined until it is saved again or an admin
+// releases it.
+var ErrQuarantined = errors.New("file is quarantined")
+
+// NotQuarantined returns a SQL condition that holds unless the user_files
+// row aliased alias is quarantined. Queries reading user_files directly add
+// it.
+func NotQuarantined(alias string) string {
+	return fmt.Sprintf("COALESCE(%s.scan_status, '') <> '%s'", alias, ScanStatusInfected)
+}
+
+// encodedFileTypes are stored as base64, and scanned decoded.
+var encodedFileTypes = map[string]bool{"avatar": true, "venv": true}
+
+const (
+	// scanTimeout bounds one file's scan.
+	scanTimeout = 2 * time.Minute
+	// sweepDelay gives the scan started by a save time to finish before
+	// ScanPending picks the file up.
+	sweepDelay = time.Minute
+	sweepBatch = 100
+)
+
+// scanSlots bounds the scans started by saves; past it, files wait for
+// ScanPending.
+var scanSlots = make(chan struct{}, 4)
+
+// scanStatus is the scan_status a save stores: pending when a scanner is
+// configured.
+func scanStatus() any {
+	if malware.Configured() {
+		return ScanStatusPending
+	}
+	return nil
+}
+
+// scanSoon scans file id in the background, so saves do not wait on the
+// scanner.
+func scanSoon(id int) {
+	select {
+	case scanSlots <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-scanSlots }()
+		ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+		defer cancel()
+		if err := scanFile(ctx, id); err != nil {
+			log.Printf("Failed to scan file %d: %v", id, err)
+		}
+	}()
+}
+
+// ScanPending scans files whose scan has not finished, such as those saved
+// while the scanner was down or the server restarting.
+func ScanPending(ctx context.Context) error {
+	if !malware.Configured() {
+		return nil
+	}
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT id FROM user_files WHERE scan_status = $1 AND updated_at < $2 ORDER BY updated_at LIMIT $3`,
+		ScanStatusPending, time.Now().Add(-sweepDelay), sweepBatch)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var failed int
+	var lastErr error
+	for _, id := range ids {
+		scanCtx, cancel := context.WithTimeout(ctx, scanTimeout)
+		if err := scanFile(scanCtx, id); err != nil {
+			failed++
+			lastErr = fmt.Errorf("file %d: %w", id, err)
+		}
+		cancel()
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files could not be scanned: %w", failed, len(ids), lastErr)
+	}
+	return nil
+}
+
+// scanFile scans file id if it is pending and records the verdict, unless
+// the file was saved again meanwhile. Flagged files are quarantined and
+// their owner and the admins notified.
+func scanFile(ctx context.Context, id int) error {
+	var accountID int
+	var filename, content, fileType string
+	var keyID sql.NullString
+	var dataKey []byte
+	var updatedAt time.Time
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT account_id, filename, content, file_type, key_id, data_key, updated_at
+		 FROM user_files WHERE id = $1 AND scan_status = $2`, id, ScanStatusPending,
+	).Scan(&accountID, &filename, &content, &fileType, &keyID, &dataKey, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted, or already scanned.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if content, err = Decrypt(accountID, filename, content, keyID, dataKey); err != nil {
+		return err
+	}
+	data := []byte(content)
+	if encodedFileTypes[fileType] {
+		if decoded, err := base64.StdEncoding.DecodeString(content); err == nil {
+			data = decoded
+		}
+	}
+
+	verdict, err := malware.Scan(ctx, data)
+	if err != nil {
+		return err
+	}
+	status, signature := ScanStatusClean, sql.NullString{}
+	if verdict.Infected {
+		status, signature = ScanStatusInfected, sql.NullString{String: verdict.Signature, Valid: true}
+	}
+	result, err := db.DB.ExecContext(ctx,
+		`UPDATE user_files SET scan_status = $1, scan_signature = $2, scanned_at = CURRENT_TIMESTAMP
+		 WHERE id = $3 AND updated_at = $4 AND scan_status = $5`,
+		status, signature, id, updatedAt, ScanStatusPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 || !verdict.Infected {
+		return nil
+	}
+
+	log.Printf("Quarantined file %d (%s) of account %d: %s", id, filename, accountID, verdict.Signature)
+	notifyQuarantine(ctx, id, accountID, filename, verdict.Signature)
+	return nil
+}
+
+func notifyQuarantine(ctx context.Context, id, accountID int, filename, signature string) {
+	if _, err := notifications.Notify(ctx, accountID, notifications.KindFileQuarantined,
+		"A file was quarantined",
+		fmt.Sprintf("%s was flagged by the malware scanner (%s) and can no longer be opened. Save it again or delete it.", filename, signature),
+		""); err != nil {
+		log.Printf("Failed to notify account %d of quarantined file %d: %v", accountID, id, err)
+	}
+
+	rows, err := db.DB.QueryContext(ctx, `SELECT id FROM accounts WHERE role = 'admin' AND id <> $1`, accountID)
+	if err != nil {
+		log.Printf("Failed to list admins to notify of quarantined file %d: %v", id, err)
+		return
+	}
+	var admins []int
+	for rows.Next() {
+		var admin int
+		if rows.Scan(&admin) == nil {
+			admins = append(admins, admin)
+		}
+	}
+	rows.Close()
+	for _, admin := range admins {
+		if _, err := notifications.Notify(ctx, admin, notifications.KindFileQuarantined,
+			"File quarantined",
+			fmt.Sprintf("File %d (%s) of account %d was flagged as %s.", id, filename, accountID, signature),
+			""); err != nil {
+			log.Printf("Failed to notify admin %d of quarantined file %d: %v", admin, id, err)
+		}
+	}
+}
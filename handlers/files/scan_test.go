@@ -0,0 +1,103 @@
+package files
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/malware"
+)
+
+func useScanner(t *testing.T, s malware.Scanner) {
+	t.Helper()
+	malware.SetScanner(s)
+	t.Cleanup(func() { malware.SetScanner(nil) })
+}
+
+var pendingColumns = []string{"account_id", "filename", "content", "file_type", "key_id", "data_key", "updated_at"}
+
+func TestScanFileQuarantines(t *testing.T) {
+	mock := setupMockDB(t)
+	useScanner(t, malware.Mock{Pattern: "EICAR", Signature: "Eicar-Test"})
+	saved := time.Now()
+
+	mock.ExpectQuery("FROM user_files WHERE id = \\$1 AND scan_status = \\$2").WithArgs(5, ScanStatusPending).
+		WillReturnRows(sqlmock.NewRows(pendingColumns).
+			AddRow(7, "evil.py", "# EICAR", "python", nil, nil, saved))
+	mock.ExpectExec("UPDATE user_files SET scan_status").
+		WithArgs(ScanStatusInfected, "Eicar-Test", 5, saved, ScanStatusPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO notifications").
+		WithArgs(7, notifications.KindFileQuarantined, "A file was quarantined", sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectQuery("SELECT id FROM accounts WHERE role = 'admin'").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("INSERT INTO notifications").
+		WithArgs(1, notifications.KindFileQuarantined, "File quarantined", sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, time.Now()))
+
+	if err := scanFile(context.Background(), 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScanFileDecodesAndSkipsResaved(t *testing.T) {
+	mock := setupMockDB(t)
+	useScanner(t, malware.Mock{Pattern: "EICAR", Signature: "Eicar-Test"})
+	saved := time.Now()
+
+	// Avatars are scanned decoded; the file was saved again while it was
+	// scanned, so the verdict is not recorded and nobody is notified.
+	mock.ExpectQuery("FROM user_files WHERE id = \\$1").WithArgs(6, ScanStatusPending).
+		WillReturnRows(sqlmock.NewRows(pendingColumns).
+			AddRow(7, "avatar.png", base64.StdEncoding.EncodeToString([]byte("EICAR")), "avatar", nil, nil, saved))
+	mock.ExpectExec("UPDATE user_files SET scan_status").
+		WithArgs(ScanStatusInfected, "Eicar-Test", 6, saved, ScanStatusPending).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := scanFile(context.Background(), 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScanPending(t *testing.T) {
+	mock := setupMockDB(t)
+	useScanner(t, malware.Mock{Err: errors.New("clamd: connection refused")})
+
+	mock.ExpectQuery("SELECT id FROM user_files WHERE scan_status = \\$1").
+		WithArgs(ScanStatusPending, sqlmock.AnyArg(), sweepBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery("FROM user_files WHERE id = \\$1").WithArgs(5, ScanStatusPending).
+		WillReturnRows(sqlmock.NewRows(pendingColumns).
+			AddRow(7, "main.py", "print(1)", "python", nil, nil, time.Now()))
+
+	err := ScanPending(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "1 of 1 files") {
+		t.Errorf("ScanPending() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadQuarantined(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM user_files").WithArgs(7, "evil.py").
+		WillReturnRows(sqlmock.NewRows(storedColumns).
+			AddRow(5, 7, "evil.py", "# EICAR", "python", time.Now(), time.Now(), nil, nil, ScanStatusInfected))
+	if _, err := Load(context.Background(), 7, "evil.py"); !errors.Is(err, ErrQuarantined) {
+		t.Errorf("Load() error = %v, want ErrQuarantined", err)
+	}
+}
@@ -0,0 +1,143 @@
+package files
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/db"
+)
+
+// Save creates or replaces file.Filename for file.AccountID and fills in the
+// stored ID and timestamps. Other packages keep user content in the file store
+// through this rather than writing user_files directly. The content is
+// encrypted on the way in when a keyring is set, and scanned for malware
+// in the background when a scanner is. Text files get a new version; a
+// version that fails to record is logged and does not fail the save.
+func Save(ctx context.Context, file *UserFile) error {
+	if file.FileType == "" {
+		file.FileType = "python"
+	}
+	content, keyID, dataKey, err := sealContent(file.AccountID, file.Filename, file.Content)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO user_files (account_id, filename, content, file_type, key_id, data_key, scan_status, project_id, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, ` + projectOf + `, CURRENT_TIMESTAMP)
+		ON CONFLICT (account_id, filename)
+		DO UPDATE SET content = EXCLUDED.content, file_type = EXCLUDED.file_type,
+			key_id = EXCLUDED.key_id, data_key = EXCLUDED.data_key,
+			scan_status = EXCLUDED.scan_status, scan_signature = NULL, scanned_at = NULL,
+			project_id = EXCLUDED.project_id, updated_at = CURRENT_TIMESTAMP
+		RETURNING id, created_at, updated_at
+	`
+	status := scanStatus()
+	file.Path = accounts.FilePath(file.AccountID, file.Filename)
+	err = db.DB.QueryRowContext(ctx, query, file.AccountID, file.Filename, content, file.FileType, keyID, dataKey, status).Scan(
+		&file.ID, &file.CreatedAt, &file.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if status != nil {
+		file.ScanStatus = ScanStatusPending
+		scanSoon(file.ID)
+	}
+	if !encodedFileTypes[file.FileType] {
+		if err := recordVersion(ctx, file.AccountID, file.Filename, file.FileType, file.Content); err != nil {
+			log.Printf("Failed to record a version of file %d: %v", file.ID, err)
+		}
+	}
+	return nil
+}
+
+// projectOf is the project a file saved as $2 by account $1 belongs to:
+// the account's project named as the file's first directory.
+const projectOf = `(SELECT id FROM projects WHERE account_id = $1 AND name = split_part($2, '/', 1) AND strpos($2, '/') > 0)`
+
+// ErrNotFound is returned by Load for a file that does not exist.
+var ErrNotFound = errors.New("file not found")
+
+// Load returns filename of accountID, decrypted. It fails with
+// ErrQuarantined for a file flagged by the malware scanner.
+func Load(ctx context.Context, accountID int, filename string) (*UserFile, error) {
+	file := &UserFile{}
+	var keyID, scanStatus sql.NullString
+	var dataKey []byte
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT id, account_id, filename, content, file_type, created_at, updated_at, key_id, data_key, scan_status
+		 FROM user_files WHERE account_id = $1 AND filename = $2`,
+		accountID, filename,
+	).Scan(&file.ID, &file.AccountID, &file.Filename, &file.Content, &file.FileType, &file.CreatedAt, &file.UpdatedAt, &keyID, &dataKey, &scanStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if scanStatus.String == ScanStatusInfected {
+		return nil, ErrQuarantined
+	}
+	file.ScanStatus = scanStatus.String
+	if file.Content, err = Decrypt(accountID, filename, file.Content, keyID, dataKey); err != nil {
+		return nil, fmt.Errorf("file %d: %w", file.ID, err)
+	}
+	file.Path = accounts.FilePath(file.AccountID, file.Filename)
+	return file, nil
+}
+
+// reservedFileTypes are stored by other packages through Save and cannot be
+// saved through the files API, so users cannot replace them with arbitrary
+// content.
+var reservedFileTypes = map[string]bool{
+	"avatar": true,
+	// Sandbox virtualenvs, which must only hold allowlisted packages.
+	"venv": true,
+}
+
+// Delete removes filename of accountID, reporting whether it existed.
+func Delete(ctx context.Context, accountID int, filename string) (bool, error) {
+	result, err := db.DB.ExecContext(ctx,
+		"DELETE FROM user_files WHERE account_id = $1 AND filename = $2", accountID, filename)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// LoadProject returns the files of project projectID of accountID,
+// decrypted and sorted by name. Quarantined files are left out.
+func LoadProject(ctx context.Context, accountID, projectID int) ([]UserFile, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT id, filename, content, file_type, created_at, updated_at, key_id, data_key, scan_status
+		 FROM user_files WHERE account_id = $1 AND project_id = $2 AND `+NotQuarantined("user_files")+`
+		 ORDER BY filename`,
+		accountID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []UserFile{}
+	for rows.Next() {
+		file := UserFile{AccountID: accountID, ProjectID: projectID}
+		var keyID, scanStatus sql.NullString
+		var dataKey []byte
+		if err := rows.Scan(&file.ID, &file.Filename, &file.Content, &file.FileType, &file.CreatedAt, &file.UpdatedAt, &keyID, &dataKey, &scanStatus); err != nil {
+			return nil, err
+		}
+		if file.Content, err = Decrypt(accountID, file.Filename, file.Content, keyID, dataKey); err != nil {
+			return nil, fmt.Errorf("file %d: %w", file.ID, err)
+		}
+		file.ScanStatus = scanStatus.String
+		file.Path = accounts.FilePath(accountID, file.Filename)
+		list = append(list, file)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,427 @@
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/vault"
+)
+
+// Every save of a file records a version, up to MaxVersions per file.
+// Version bodies are split into content-defined chunks stored once per
+// account in file_chunks and addressed by their SHA-256, so a version of
+// a large file only adds the chunks its edit touched. A chunk no version
+// refers to any more is deleted by CollectChunks.
+
+const (
+	// MaxVersions is how many versions of a file are kept.
+	MaxVersions = 50
+
+	// Chunks are cut where a rolling hash of the last 64 bytes matches
+	// chunkMask, about every 8 KB, so an edit only changes the chunks
+	// around it; chunks are never shorter than minChunk or longer than
+	// maxChunk.
+	minChunk  = 2 << 10
+	maxChunk  = 64 << 10
+	chunkMask = uint64(1<<13-1) << (64 - 13)
+
+	// chunkGrace is how long a chunk is kept after it was last written or
+	// reused, even if no version refers to it, so that a version being
+	// recorded never loses a chunk it found stored.
+	chunkGrace = time.Hour
+)
+
+// ErrVersionNotFound is returned by LoadVersion for a version that does
+// not exist.
+var ErrVersionNotFound = errors.New("file version not found")
+
+// FileVersion is one recorded version of a file.
+type FileVersion struct {
+	Filename string `json:"filename"`
+	Version  int    `json:"version"`
+	Size     int    `json:"size"`
+	// Hash is the SHA-256 of the content, in hex.
+	Hash      string    `json:"hash"`
+	FileType  string    `json:"file_type"`
+	Content   string    `json:"content,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// gear is the table of the rolling hash: fixed pseudo-random values, so
+// the same content is always cut the same way.
+var gear = func() (t [256]uint64) {
+	x := uint64(0)
+	for i := range t {
+		// splitmix64
+		x += 0x9E3779B97F4A7C15
+		z := x
+		z = (z ^ z>>30) * 0xBF58476D1CE4E5B9
+		z = (z ^ z>>27) * 0x94D049BB133111EB
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// splitChunks cuts data into content-defined chunks.
+func splitChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := chunkEnd(data)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// chunkEnd returns the length of the chunk data starts with.
+func chunkEnd(data []byte) int {
+	if len(data) <= minChunk {
+		return len(data)
+	}
+	end := min(len(data), maxChunk)
+	var h uint64
+	for i := minChunk; i < end; i++ {
+		h = h<<1 + gear[data[i]]
+		if h&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return end
+}
+
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// chunkContext binds a chunk to its owner and address.
+func chunkContext(accountID int, hash string) []byte {
+	return []byte(fmt.Sprintf("chunk:%d:%s", accountID, hash))
+}
+
+// recordVersion records content as the newest version of filename,
+// unless it already is. Chunks already stored for the account are reused.
+func recordVersion(ctx context.Context, accountID int, filename, fileType, content string) error {
+	data := []byte(content)
+	hash := contentHash(data)
+	chunks := splitChunks(data)
+	hashes := make([]string, len(chunks))
+	byHash := make(map[string][]byte, len(chunks))
+	var unique []string
+	for i, c := range chunks {
+		hashes[i] = contentHash(c)
+		if _, ok := byHash[hashes[i]]; !ok {
+			byHash[hashes[i]] = c
+			unique = append(unique, hashes[i])
+		}
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the newest version makes concurrent saves of the file take
+	// turns.
+	var latest int
+	var latestHash string
+	err = tx.QueryRowContext(ctx,
+		`SELECT version, hash FROM file_versions WHERE account_id = $1 AND filename = $2
+		 ORDER BY version DESC LIMIT 1 FOR UPDATE`,
+		accountID, filename).Scan(&latest, &latestHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if latestHash == hash {
+		return tx.Commit()
+	}
+
+	// Reusing a chunk touches it, which keeps CollectChunks off it.
+	stored := map[string]bool{}
+	if len(unique) > 0 {
+		rows, err := tx.QueryContext(ctx,
+			`UPDATE file_chunks SET used_at = CURRENT_TIMESTAMP
+			 WHERE account_id = $1 AND hash = ANY($2) RETURNING hash`,
+			accountID, pq.Array(unique))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var h string
+			if err := rows.Scan(&h); err != nil {
+				rows.Close()
+				return err
+			}
+			stored[h] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	k := currentKeyring()
+	for _, h := range unique {
+		if stored[h] {
+			continue
+		}
+		body, keyID, dataKey := byHash[h], any(nil), any(nil)
+		if k != nil {
+			e, err := k.Seal(body, chunkContext(accountID, h))
+			if err != nil {
+				return err
+			}
+			body, keyID, dataKey = e.Ciphertext, e.KeyID, e.DataKey
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO file_chunks (account_id, hash, size, data, key_id, data_key)
+			 VALUES ($1, $2, $3, $4, $5, $6)
+			 ON CONFLICT (account_id, hash) DO UPDATE SET used_at = CURRENT_TIMESTAMP`,
+			accountID, h, len(byHash[h]), body, keyID, dataKey); err != nil {
+			return err
+		}
+	}
+
+	version := latest + 1
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO file_versions (account_id, filename, version, file_type, size, hash, chunks)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		accountID, filename, version, fileType, len(data), hash, pq.Array(hashes)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM file_versions WHERE account_id = $1 AND filename = $2 AND version <= $3`,
+		accountID, filename, version-MaxVersions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListVersions returns the recorded versions of filename of accountID,
+// newest first, without their content.
+func ListVersions(ctx context.Context, accountID int, filename string) ([]FileVersion, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT version, file_type, size, hash, created_at FROM file_versions
+		 WHERE account_id = $1 AND filename = $2 ORDER BY version DESC`,
+		accountID, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []FileVersion{}
+	for rows.Next() {
+		v := FileVersion{Filename: filename}
+		if err := rows.Scan(&v.Version, &v.FileType, &v.Size, &v.Hash, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// LoadVersion returns version of filename of accountID with its content,
+// put together from its chunks. It fails with ErrQuarantined for the
+// newest version of a file the malware scanner flagged, which is the
+// flagged content.
+func LoadVersion(ctx context.Context, accountID int, filename string, version int) (*FileVersion, error) {
+	v := &FileVersion{Filename: filename, Version: version}
+	var hashes []string
+	var quarantined bool
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT v.file_type, v.size, v.hash, v.chunks, v.created_at,
+			v.version = (SELECT MAX(version) FROM file_versions WHERE account_id = $1 AND filename = $2)
+			AND EXISTS (SELECT 1 FROM user_files f WHERE f.account_id = $1 AND f.filename = $2 AND NOT (`+NotQuarantined("f")+`))
+		 FROM file_versions v WHERE v.account_id = $1 AND v.filename = $2 AND v.version = $3`,
+		accountID, filename, version,
+	).Scan(&v.FileType, &v.Size, &v.Hash, pq.Array(&hashes), &v.CreatedAt, &quarantined)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if quarantined {
+		return nil, ErrQuarantined
+	}
+
+	bodies, err := loadChunks(ctx, accountID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	var content strings.Builder
+	content.Grow(v.Size)
+	for _, h := range hashes {
+		body, ok := bodies[h]
+		if !ok {
+			return nil, fmt.Errorf("version %d of %s: chunk %s is missing", version, filename, h)
+		}
+		content.Write(body)
+	}
+	if contentHash([]byte(content.String())) != v.Hash {
+		return nil, fmt.Errorf("version %d of %s does not match its hash", version, filename)
+	}
+	v.Content = content.String()
+	return v, nil
+}
+
+// loadChunks returns the chunks of accountID with the given addresses,
+// decrypted.
+func loadChunks(ctx context.Context, accountID int, hashes []string) (map[string][]byte, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT hash, data, key_id, data_key FROM file_chunks WHERE account_id = $1 AND hash = ANY($2)`,
+		accountID, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bodies := map[string][]byte{}
+	for rows.Next() {
+		var h string
+		var data, dataKey []byte
+		var keyID sql.NullString
+		if err := rows.Scan(&h, &data, &keyID, &dataKey); err != nil {
+			return nil, err
+		}
+		if keyID.Valid {
+			k := currentKeyring()
+			if k == nil {
+				return nil, vault.ErrNotConfigured
+			}
+			if data, err = k.Open(vault.Envelope{KeyID: keyID.String, DataKey: dataKey, Ciphertext: data}, chunkContext(accountID, h)); err != nil {
+				return nil, fmt.Errorf("chunk %s: %w", h, err)
+			}
+		}
+		bodies[h] = data
+	}
+	return bodies, rows.Err()
+}
+
+// CollectChunks deletes the chunks no version refers to, once they have
+// gone unused for chunkGrace.
+func CollectChunks(ctx context.Context) error {
+	result, err := db.DB.ExecContext(ctx,
+		`DELETE FROM file_chunks c
+		 WHERE c.used_at < $1
+		 AND NOT EXISTS (SELECT 1 FROM file_versions v WHERE v.account_id = c.account_id AND v.chunks @> ARRAY[c.hash])`,
+		time.Now().Add(-chunkGrace))
+	if err != nil {
+		return fmt.Errorf("failed to delete unused file chunks: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Deleted %d unused file chunks", n)
+	}
+	return nil
+}
+
+// versionParams reads the filename and version query parameters.
+func versionParams(r *http.Request) (string, int, *apierror.Error) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		return "", 0, apierror.Validation("Filename required")
+	}
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version < 1 {
+		return "", 0, apierror.Validation("version must be a positive number")
+	}
+	return filename, version, nil
+}
+
+func writeVersionError(w http.ResponseWriter, err error, what string) {
+	switch {
+	case errors.Is(err, ErrVersionNotFound):
+		apierror.Write(w, apierror.NotFound("Version not found"))
+	case errors.Is(err, ErrQuarantined):
+		apierror.Write(w, apierror.Forbidden("This version was quarantined by the malware scanner").
+			WithDetails(map[string]string{"reason": "quarantined"}))
+	default:
+		log.Printf("Failed to %s: %v", what, err)
+		apierror.Write(w, apierror.Internal("Failed to "+what))
+	}
+}
+
+// ListVersionsHandler lists the versions of the file named by the
+// filename query parameter, newest first.
+func ListVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getUserIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		apierror.Write(w, apierror.Validation("Filename required"))
+		return
+	}
+	list, err := ListVersions(r.Context(), accountID, filename)
+	if err != nil {
+		writeVersionError(w, err, "load versions")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// LoadVersionHandler returns one version of a file with its content.
+func LoadVersionHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getUserIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	filename, version, apiErr := versionParams(r)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	v, err := LoadVersion(r.Context(), accountID, filename, version)
+	if err != nil {
+		writeVersionError(w, err, "load version")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// RestoreVersionHandler saves a version of a file as its current content,
+// which records it again as the newest version.
+func RestoreVersionHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getUserIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	filename, version, apiErr := versionParams(r)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	v, err := LoadVersion(r.Context(), accountID, filename, version)
+	if err != nil {
+		writeVersionError(w, err, "restore version")
+		return
+	}
+	file := &UserFile{AccountID: accountID, Filename: filename, Content: v.Content, FileType: v.FileType}
+	if err := Save(r.Context(), file); err != nil {
+		log.Printf("Failed to restore version %d of %s: %v", version, filename, err)
+		apierror.Write(w, apierror.Internal("Failed to restore version"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
@@ -0,0 +1,200 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+
+	"allanswebterminal/vault"
+)
+
+// expectVersion expects Save to record the first version of filename,
+// stored in chunks not stored before.
+func expectVersion(mock sqlmock.Sqlmock, accountID int, filename string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT version, hash FROM file_versions").WithArgs(accountID, filename).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "hash"}))
+	mock.ExpectQuery("UPDATE file_chunks SET used_at").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}))
+	mock.ExpectExec("INSERT INTO file_chunks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO file_versions").
+		WithArgs(accountID, filename, 1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM file_versions").WithArgs(accountID, filename, 1-MaxVersions).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+}
+
+func randomText(n int, seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + r.Intn(26))
+	}
+	return b
+}
+
+func TestSplitChunks(t *testing.T) {
+	data := randomText(300<<10, 1)
+	chunks := splitChunks(data)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks do not put the data back together")
+	}
+	for i, c := range chunks {
+		if len(c) > maxChunk || (len(c) < minChunk && i != len(chunks)-1) {
+			t.Errorf("chunk %d is %d bytes", i, len(c))
+		}
+	}
+	if len(chunks) < 10 {
+		t.Errorf("%d chunks for 300 KB, want about 30", len(chunks))
+	}
+
+	// An insertion near the start only changes the chunks around it.
+	edited := append(append(append([]byte{}, data[:1000]...), "inserted"...), data[1000:]...)
+	before := map[string]bool{}
+	for _, c := range chunks {
+		before[contentHash(c)] = true
+	}
+	changed := 0
+	for _, c := range splitChunks(edited) {
+		if !before[contentHash(c)] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("an insertion changed %d chunks", changed)
+	}
+
+	if got := splitChunks(nil); len(got) != 0 {
+		t.Errorf("splitChunks(nil) = %d chunks", len(got))
+	}
+}
+
+func TestRecordVersionReusesChunks(t *testing.T) {
+	mock := setupMockDB(t)
+	content := string(randomText(40<<10, 2))
+	chunks := splitChunks([]byte(content))
+	if len(chunks) < 2 {
+		t.Fatalf("want several chunks, got %d", len(chunks))
+	}
+	stored := contentHash(chunks[0])
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT version, hash FROM file_versions").WithArgs(7, "big.txt").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "hash"}).AddRow(4, "old"))
+	mock.ExpectQuery("UPDATE file_chunks SET used_at").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow(stored))
+	for _, c := range chunks[1:] {
+		mock.ExpectExec("INSERT INTO file_chunks").
+			WithArgs(7, contentHash(c), len(c), c, nil, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	hashes := make([]string, len(chunks))
+	for i, c := range chunks {
+		hashes[i] = contentHash(c)
+	}
+	mock.ExpectExec("INSERT INTO file_versions").
+		WithArgs(7, "big.txt", 5, "text", len(content), contentHash([]byte(content)), pq.Array(hashes)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM file_versions").WithArgs(7, "big.txt", 5-MaxVersions).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := recordVersion(context.Background(), 7, "big.txt", "text", content); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecordVersionSkipsUnchanged(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT version, hash FROM file_versions").WithArgs(7, "main.py").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "hash"}).AddRow(2, contentHash([]byte("print(1)"))))
+	mock.ExpectCommit()
+
+	if err := recordVersion(context.Background(), 7, "main.py", "python", "print(1)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+var versionColumns = []string{"file_type", "size", "hash", "chunks", "created_at", "quarantined"}
+
+func TestLoadVersion(t *testing.T) {
+	mock := setupMockDB(t)
+	useKeyring(t, "k1:"+vault.NewKey())
+	content := randomText(20<<10, 3)
+	chunks := splitChunks(content)
+	hashes := make([]string, len(chunks))
+	rows := sqlmock.NewRows([]string{"hash", "data", "key_id", "data_key"})
+	for i, c := range chunks {
+		hashes[i] = contentHash(c)
+		e, err := currentKeyring().Seal(c, chunkContext(7, hashes[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.AddRow(hashes[i], e.Ciphertext, e.KeyID, e.DataKey)
+	}
+
+	mock.ExpectQuery("FROM file_versions v").WithArgs(7, "notes.md", 3).
+		WillReturnRows(sqlmock.NewRows(versionColumns).
+			AddRow("markdown", len(content), contentHash(content), pq.Array(hashes), time.Now(), false))
+	mock.ExpectQuery("FROM file_chunks").WithArgs(7, pq.Array(hashes)).WillReturnRows(rows)
+
+	v, err := LoadVersion(context.Background(), 7, "notes.md", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Content != string(content) || v.FileType != "markdown" {
+		t.Errorf("LoadVersion() = %d bytes of %s", len(v.Content), v.FileType)
+	}
+}
+
+func TestLoadVersionErrors(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM file_versions v").WithArgs(7, "gone.py", 9).
+		WillReturnRows(sqlmock.NewRows(versionColumns))
+	if _, err := LoadVersion(context.Background(), 7, "gone.py", 9); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("missing version error = %v", err)
+	}
+
+	mock.ExpectQuery("FROM file_versions v").WithArgs(7, "evil.py", 2).
+		WillReturnRows(sqlmock.NewRows(versionColumns).
+			AddRow("python", 6, "h", pq.Array([]string{"h"}), time.Now(), true))
+	if _, err := LoadVersion(context.Background(), 7, "evil.py", 2); !errors.Is(err, ErrQuarantined) {
+		t.Errorf("quarantined version error = %v", err)
+	}
+
+	// A chunk that does not match the version's hash is not served.
+	mock.ExpectQuery("FROM file_versions v").WithArgs(7, "main.py", 1).
+		WillReturnRows(sqlmock.NewRows(versionColumns).
+			AddRow("python", 8, contentHash([]byte("print(1)")), pq.Array([]string{"h"}), time.Now(), false))
+	mock.ExpectQuery("FROM file_chunks").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "data", "key_id", "data_key"}).AddRow("h", []byte("print(2)"), nil, nil))
+	if _, err := LoadVersion(context.Background(), 7, "main.py", 1); err == nil {
+		t.Error("expected a corrupt version to fail")
+	}
+}
+
+func TestCollectChunks(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectExec("DELETE FROM file_chunks c").WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	if err := CollectChunks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
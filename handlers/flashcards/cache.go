@@ -0,0 +1,41 @@
+package flashcards
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"allanswebterminal/cache"
+)
+
+// Course and card content changes rarely but is read on every page load and
+// game start, so it is served from the cache and invalidated on writes.
+const (
+	coursesCacheKey    = "flashcards:courses"
+	guestCardsCacheKey = "flashcards:guest"
+	contentCacheTTL    = 5 * time.Minute
+)
+
+func courseCardsCacheKey(courseID int) string {
+	return "flashcards:course:" + strconv.Itoa(courseID)
+}
+
+func cachedCourses(ctx context.Context) ([]Course, error) {
+	return cache.GetOrLoad(ctx, coursesCacheKey, contentCacheTTL, getAllCourses)
+}
+
+func cachedGuestFlashcards(ctx context.Context) ([]Flashcard, error) {
+	return cache.GetOrLoad(ctx, guestCardsCacheKey, contentCacheTTL, getGuestFlashcards)
+}
+
+func cachedCourseFlashcards(ctx context.Context, courseID int) ([]Flashcard, error) {
+	return cache.GetOrLoad(ctx, courseCardsCacheKey(courseID), contentCacheTTL, func() ([]Flashcard, error) {
+		return getFlashcardsByCourse(courseID)
+	})
+}
+
+// invalidateCourse drops cached data affected by creating or changing a
+// course.
+func invalidateCourse(ctx context.Context, courseID int) {
+	cache.Invalidate(ctx, coursesCacheKey, courseCardsCacheKey(courseID))
+}
@@ -0,0 +1,389 @@
+package flashcards
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/unleashedjs"
+)
+
+// Code challenges are cards answered with an UnleashedJS program instead
+// of text. Each test appends a call to the submitted source, runs it in the
+// sandbox and compares what it prints with the expected output. The card's
+// answer is a reference solution, which must pass the tests.
+const (
+	maxChallengeTests  = 20
+	maxChallengeCall   = 2000
+	maxChallengeOutput = 10000
+	maxChallengeSource = 32 << 10
+	// challengeTimeout bounds all the tests of one submission.
+	challengeTimeout = 30 * time.Second
+)
+
+// Challenge is stored with a code-challenge card.
+type Challenge struct {
+	// Starter is the code the editor starts with, such as a function
+	// signature.
+	Starter string          `json:"starter"`
+	Tests   []ChallengeTest `json:"tests"`
+}
+
+type ChallengeTest struct {
+	Name string `json:"name"`
+	// Call is appended to the submitted source as top-level code, such as
+	// "print(add(2, 3));".
+	Call     string `json:"call"`
+	Expected string `json:"expected"`
+}
+
+// ChallengeResult is the outcome of running a submission against the tests.
+type ChallengeResult struct {
+	Passed bool `json:"passed"`
+	// Diagnostics are set when the submission does not compile; no test
+	// runs then.
+	Diagnostics []string           `json:"diagnostics,omitempty"`
+	Tests       []ChallengeTestRun `json:"tests"`
+}
+
+type ChallengeTestRun struct {
+	Name     string `json:"name"`
+	Passed   bool   `json:"passed"`
+	Expected string `json:"expected"`
+	Output   string `json:"output"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SubmitChallengeRequest names the code to check: Source, or Filename, one
+// of the caller's saved files. Either way the code is snapshotted with the
+// submission, so later edits to the file do not change it.
+type SubmitChallengeRequest struct {
+	Source   string `json:"source"`
+	Filename string `json:"filename"`
+}
+
+// ChallengeSubmission is a snapshot of submitted code and its result.
+type ChallengeSubmission struct {
+	ID          int             `json:"id"`
+	FlashcardID int             `json:"flashcard_id"`
+	Filename    string          `json:"filename,omitempty"`
+	Source      string          `json:"source"`
+	Result      ChallengeResult `json:"result"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// challengeValue returns the card's challenge as stored, or nil.
+func (card Flashcard) challengeValue() interface{} {
+	if card.Challenge == nil {
+		return nil
+	}
+	data, _ := json.Marshal(card.Challenge)
+	return data
+}
+
+func (c *Challenge) validate() error {
+	if len(c.Starter) > maxChallengeSource {
+		return fmt.Errorf("starter must be at most %d KB", maxChallengeSource>>10)
+	}
+	if len(c.Tests) == 0 {
+		return errors.New("a challenge needs at least one test")
+	}
+	if len(c.Tests) > maxChallengeTests {
+		return fmt.Errorf("a challenge has at most %d tests", maxChallengeTests)
+	}
+	for i := range c.Tests {
+		t := &c.Tests[i]
+		t.Name = strings.TrimSpace(t.Name)
+		if t.Name == "" {
+			t.Name = "Test " + strconv.Itoa(i+1)
+		}
+		if strings.TrimSpace(t.Call) == "" || len(t.Call) > maxChallengeCall {
+			return fmt.Errorf("test %d: call is required, up to %d characters", i+1, maxChallengeCall)
+		}
+		if len(t.Expected) > maxChallengeOutput {
+			return fmt.Errorf("test %d: expected output must be at most %d characters", i+1, maxChallengeOutput)
+		}
+	}
+	return nil
+}
+
+// runChallenge runs source against each test of c, each in a fresh
+// program. Output is compared without surrounding whitespace.
+func runChallenge(ctx context.Context, c *Challenge, source string) (*ChallengeResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, challengeTimeout)
+	defer cancel()
+
+	result := &ChallengeResult{Passed: true, Tests: []ChallengeTestRun{}}
+	for i, t := range c.Tests {
+		res, err := unleashedjs.Execute(ctx, source+"\n"+t.Call+"\n")
+		if err != nil {
+			return nil, err
+		}
+		if !res.Compile.OK {
+			if i > 0 {
+				// Only this test's call is broken.
+				result.Passed = false
+				result.Tests = append(result.Tests, ChallengeTestRun{Name: t.Name, Expected: t.Expected, Error: "the test does not compile"})
+				continue
+			}
+			result.Passed = false
+			for _, d := range res.Compile.Diagnostics {
+				result.Diagnostics = append(result.Diagnostics, d.String())
+			}
+			return result, nil
+		}
+		run := ChallengeTestRun{Name: t.Name, Expected: t.Expected, Output: res.Run.Output}
+		if res.Run.Error != nil {
+			run.Error = res.Run.Error.Error()
+		}
+		run.Passed = run.Error == "" && strings.TrimSpace(run.Output) == strings.TrimSpace(t.Expected)
+		result.Passed = result.Passed && run.Passed
+		result.Tests = append(result.Tests, run)
+	}
+	return result, nil
+}
+
+// SetChallengeHandler makes a card of the course a code challenge, or
+// updates its tests. The card's answer must pass them. Editors and owners
+// may do this; cards shared with other courses are refused, like edits.
+func SetChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+	var challenge Challenge
+	if err := json.NewDecoder(r.Body).Decode(&challenge); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := challenge.validate(); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+	cardID, courses, ok := pathCardID(w, r, courseID)
+	if !ok {
+		return
+	}
+	if courses > 1 {
+		apierror.Write(w, apierror.Conflict("The card is shared with other courses"))
+		return
+	}
+
+	var solution string
+	var occlusion []byte
+	err = db.DB.QueryRowContext(r.Context(), "SELECT answer, occlusion FROM flashcards WHERE id = $1", cardID).
+		Scan(&solution, &occlusion)
+	if err != nil {
+		log.Printf("Failed to load card %d: %v", cardID, err)
+		apierror.Write(w, apierror.Internal("Failed to load card"))
+		return
+	}
+	if occlusion != nil {
+		apierror.Write(w, apierror.Validation("Image occlusion cards cannot be code challenges"))
+		return
+	}
+	result, err := runChallenge(r.Context(), &challenge, solution)
+	if err != nil {
+		log.Printf("Failed to run challenge of card %d: %v", cardID, err)
+		apierror.Write(w, apierror.Unavailable("The code runner is busy; try again shortly"))
+		return
+	}
+	if !result.Passed {
+		apierror.Write(w, apierror.Validation("The card's answer must pass the tests").WithDetails(result))
+		return
+	}
+
+	data, _ := json.Marshal(challenge)
+	if _, err := db.DB.ExecContext(r.Context(),
+		"UPDATE flashcards SET challenge = $1 WHERE id = $2", data, cardID,
+	); err != nil {
+		log.Printf("Failed to save challenge of card %d: %v", cardID, err)
+		apierror.Write(w, apierror.Internal("Failed to save challenge"))
+		return
+	}
+	invalidateCourse(r.Context(), courseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(challenge)
+}
+
+// RemoveChallengeHandler turns a code challenge back into a text card.
+func RemoveChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok || !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+	cardID, _, ok := pathCardID(w, r, courseID)
+	if !ok {
+		return
+	}
+
+	if _, err := db.DB.ExecContext(r.Context(), "UPDATE flashcards SET challenge = NULL WHERE id = $1", cardID); err != nil {
+		log.Printf("Failed to remove challenge of card %d: %v", cardID, err)
+		apierror.Write(w, apierror.Internal("Failed to remove challenge"))
+		return
+	}
+	invalidateCourse(r.Context(), courseID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SubmitChallengeHandler checks code against a code-challenge card outside
+// a game and keeps a snapshot of it with the result.
+func SubmitChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	cardID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || cardID < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid card ID"))
+		return
+	}
+	var req SubmitChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if (strings.TrimSpace(req.Source) == "") == (req.Filename == "") {
+		apierror.Write(w, apierror.Validation("Send either source or the filename of a saved file"))
+		return
+	}
+
+	challenge, err := loadChallenge(r.Context(), cardID)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Code challenge not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load challenge of card %d: %v", cardID, err)
+		apierror.Write(w, apierror.Internal("Failed to load challenge"))
+		return
+	}
+
+	sub := ChallengeSubmission{FlashcardID: cardID, Filename: req.Filename, Source: req.Source}
+	if req.Filename != "" {
+		file, err := files.Load(r.Context(), user.ID, req.Filename)
+		if errors.Is(err, files.ErrNotFound) {
+			apierror.Write(w, apierror.NotFound("File not found"))
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load %s for challenge: %v", req.Filename, err)
+			apierror.Write(w, apierror.Internal("Failed to load file"))
+			return
+		}
+		sub.Source = file.Content
+	}
+	if len(sub.Source) > maxChallengeSource {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("source must be at most %d KB", maxChallengeSource>>10)))
+		return
+	}
+
+	result, err := runChallenge(r.Context(), challenge, sub.Source)
+	if err != nil {
+		log.Printf("Failed to run challenge of card %d: %v", cardID, err)
+		apierror.Write(w, apierror.Unavailable("The code runner is busy; try again shortly"))
+		return
+	}
+	sub.Result = *result
+
+	data, _ := json.Marshal(result)
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO challenge_submissions (account_id, flashcard_id, filename, source, passed, result)
+		 VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6) RETURNING id, created_at`,
+		user.ID, cardID, sub.Filename, sub.Source, result.Passed, data,
+	).Scan(&sub.ID, &sub.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to save challenge submission: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save submission"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+// ListSubmissionsHandler lists the caller's submissions to a code
+// challenge, newest first.
+func ListSubmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	cardID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || cardID < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid card ID"))
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT id, COALESCE(filename, ''), source, result, created_at FROM challenge_submissions
+		 WHERE account_id = $1 AND flashcard_id = $2 ORDER BY created_at DESC, id DESC LIMIT 50`,
+		user.ID, cardID)
+	if err != nil {
+		log.Printf("Failed to list challenge submissions: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list submissions"))
+		return
+	}
+	defer rows.Close()
+
+	subs := []ChallengeSubmission{}
+	for rows.Next() {
+		sub := ChallengeSubmission{FlashcardID: cardID}
+		var result []byte
+		if err := rows.Scan(&sub.ID, &sub.Filename, &sub.Source, &result, &sub.CreatedAt); err != nil {
+			log.Printf("Failed to read challenge submission: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to list submissions"))
+			return
+		}
+		json.Unmarshal(result, &sub.Result)
+		subs = append(subs, sub)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+// loadChallenge returns the challenge of a card, or sql.ErrNoRows when it
+// is not a code challenge.
+func loadChallenge(ctx context.Context, cardID int) (*Challenge, error) {
+	var data []byte
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT challenge FROM flashcards WHERE id = $1 AND challenge IS NOT NULL", cardID,
+	).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+	var c Challenge
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("card %d: invalid challenge: %w", cardID, err)
+	}
+	return &c, nil
+}
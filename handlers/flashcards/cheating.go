@@ -0,0 +1,105 @@
+package flashcards
+
+import (
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Flags mark answers that look automated. They are kept with the score in
+// account_score and with the finished game in games_played. Flagged answers
+// are left out of card statistics, anything ranking players must skip
+// flagged games, and admins review both through the admin API.
+const (
+	// FlagTooFast marks a correct answer given sooner after its card was
+	// shown than anyone could read the card and type the answer.
+	FlagTooFast = "too_fast"
+	// FlagUniformTiming marks an answer ending a run of answers given at
+	// the same pace, to within uniformTimingSpread.
+	FlagUniformTiming = "uniform_timing"
+	// FlagExactWhitespace marks a typed answer that repeats the stored
+	// answer byte for byte, including whitespace a player would not type
+	// and the game page trims.
+	FlagExactWhitespace = "exact_whitespace"
+)
+
+const (
+	// minReadTime and minTypeTime bound how fast a person answers: the
+	// time to read the card, plus the time per character typed.
+	minReadTime = 500 * time.Millisecond
+	minTypeTime = 40 * time.Millisecond
+
+	// uniformTimingAnswers answers in a row whose times differ by less than
+	// uniformTimingSpread are flagged.
+	uniformTimingAnswers = 5
+	uniformTimingSpread  = 100 * time.Millisecond
+)
+
+// minAnswerTime is the least time a person needs to answer card with
+// answer. Choices are clicked, not typed.
+func minAnswerTime(card Flashcard, answer string) time.Duration {
+	if len(card.Choices) > 0 {
+		return minReadTime
+	}
+	return minReadTime + time.Duration(len([]rune(answer)))*minTypeTime
+}
+
+// flagAnswer records how long the current card took to answer at now, by
+// the game's clock, and returns the flags the answer raises. The caller
+// holds session.mu.
+func (s *GameSession) flagAnswer(card Flashcard, answer string, correct bool, now time.Time) []string {
+	played := s.playTime(now)
+	took := played - s.CardShownAt
+	s.CardShownAt = played
+	s.AnswerTimes = append(s.AnswerTimes, took)
+	if len(s.AnswerTimes) > uniformTimingAnswers {
+		s.AnswerTimes = s.AnswerTimes[len(s.AnswerTimes)-uniformTimingAnswers:]
+	}
+
+	var flags []string
+	if correct && took < minAnswerTime(card, answer) {
+		flags = append(flags, FlagTooFast)
+	}
+	if len(s.AnswerTimes) == uniformTimingAnswers &&
+		slices.Max(s.AnswerTimes)-slices.Min(s.AnswerTimes) < uniformTimingSpread {
+		flags = append(flags, FlagUniformTiming)
+	}
+	if len(card.Choices) == 0 && card.Challenge == nil && answer == card.Answer && hasOddWhitespace(answer) {
+		flags = append(flags, FlagExactWhitespace)
+	}
+	return flags
+}
+
+// hasOddWhitespace reports whether s has whitespace other than single
+// spaces between words.
+func hasOddWhitespace(s string) bool {
+	if strings.TrimSpace(s) != s || strings.Contains(s, "  ") {
+		return true
+	}
+	return strings.ContainsFunc(s, func(r rune) bool {
+		return r != ' ' && unicode.IsSpace(r)
+	})
+}
+
+// runFlags are the flags raised by any answer of a game, in order.
+func runFlags(scores []ScoreResult) []string {
+	var flags []string
+	for _, score := range scores {
+		for _, flag := range score.Flags {
+			if !slices.Contains(flags, flag) {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	return flags
+}
+
+// flagsOrEmpty returns flags, or an empty list for nil, since the flags
+// columns are not nullable.
+func flagsOrEmpty(flags []string) []string {
+	if flags == nil {
+		return []string{}
+	}
+	return flags
+}
@@ -0,0 +1,297 @@
+package flashcards
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/notifications"
+)
+
+// Collaborator roles on a course, from most to least privileged. Owners
+// manage collaborators and may delete the course; editors change its cards,
+// details and scoring; viewers may see the collaborator list. The account
+// that created a course is always an owner, and admins act as owners
+// everywhere.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+type Collaborator struct {
+	AccountID int    `json:"account_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	// Creator marks the account that created the course. It cannot be
+	// removed or demoted.
+	Creator bool      `json:"creator,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+type InviteCollaboratorRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+type SetCollaboratorRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// courseRole returns the role of user on the course, or "" for none.
+// It returns sql.ErrNoRows when the course does not exist.
+func courseRole(ctx context.Context, courseID int, user *login.User) (string, error) {
+	var creator sql.NullInt64
+	var role string
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT c.account_id, COALESCE(cc.role, '')
+		 FROM courses c
+		 LEFT JOIN course_collaborators cc ON cc.course_id = c.id AND cc.account_id = $2
+		 WHERE c.id = $1`, courseID, user.ID,
+	).Scan(&creator, &role)
+	if err != nil {
+		return "", err
+	}
+	if user.Role == "admin" || creator.Valid && int(creator.Int64) == user.ID {
+		return RoleOwner, nil
+	}
+	return role, nil
+}
+
+// requireCourseRole writes an error and returns false unless user holds at
+// least role min on the course. Course names are public, so a course the
+// user has no role on answers 403 rather than 404.
+func requireCourseRole(w http.ResponseWriter, r *http.Request, courseID int, user *login.User, min string) bool {
+	role, err := courseRole(r.Context(), courseID, user)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Course not found"))
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to load role on course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to check course permissions"))
+		return false
+	}
+	if roleRank[role] < roleRank[min] {
+		apierror.Write(w, apierror.Forbidden(fmt.Sprintf("This needs the %s role on the course", min)))
+		return false
+	}
+	return true
+}
+
+// ListCollaboratorsHandler lists the creator and collaborators of a course.
+// Any collaborator may see it.
+func ListCollaboratorsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok || !requireCourseRole(w, r, courseID, user, RoleViewer) {
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT a.id, a.username, 'owner', TRUE, c.created_at
+		 FROM courses c JOIN accounts a ON a.id = c.account_id
+		 WHERE c.id = $1
+		 UNION ALL
+		 SELECT a.id, a.username, cc.role, FALSE, cc.created_at
+		 FROM course_collaborators cc JOIN accounts a ON a.id = cc.account_id
+		 JOIN courses c ON c.id = cc.course_id
+		 WHERE cc.course_id = $1 AND cc.account_id IS DISTINCT FROM c.account_id
+		 ORDER BY 4 DESC, 2`, courseID)
+	if err != nil {
+		log.Printf("Failed to list collaborators of course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load collaborators"))
+		return
+	}
+	defer rows.Close()
+
+	list := []Collaborator{}
+	for rows.Next() {
+		var c Collaborator
+		if err := rows.Scan(&c.AccountID, &c.Username, &c.Role, &c.Creator, &c.AddedAt); err != nil {
+			log.Printf("Failed to scan collaborator: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load collaborators"))
+			return
+		}
+		list = append(list, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// InviteCollaboratorHandler adds an account to a course by username and
+// notifies it. Only owners may invite.
+func InviteCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req InviteCollaboratorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		apierror.Write(w, apierror.Validation("username is required"))
+		return
+	}
+	if _, ok := roleRank[req.Role]; !ok {
+		apierror.Write(w, apierror.Validation("role must be owner, editor or viewer"))
+		return
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleOwner) {
+		return
+	}
+
+	var c Collaborator
+	var courseName string
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO course_collaborators (course_id, account_id, role, invited_by)
+		 SELECT c.id, a.id, $3, $4
+		 FROM courses c, accounts a
+		 WHERE c.id = $1 AND LOWER(a.username) = LOWER($2) AND a.id IS DISTINCT FROM c.account_id
+		 ON CONFLICT (course_id, account_id) DO NOTHING
+		 RETURNING account_id, created_at, (SELECT name FROM courses WHERE id = $1),
+			(SELECT username FROM accounts WHERE id = course_collaborators.account_id)`,
+		courseID, req.Username, req.Role, user.ID,
+	).Scan(&c.AccountID, &c.AddedAt, &courseName, &c.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		// No such user, the course creator, or already a collaborator.
+		var exists bool
+		if db.DB.QueryRowContext(r.Context(), "SELECT EXISTS (SELECT 1 FROM accounts WHERE LOWER(username) = LOWER($1))",
+			req.Username).Scan(&exists); !exists {
+			apierror.Write(w, apierror.NotFound("No user named "+req.Username))
+			return
+		}
+		apierror.Write(w, apierror.Conflict(req.Username+" already collaborates on this course"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to add collaborator to course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to add collaborator"))
+		return
+	}
+	c.Role = req.Role
+
+	_, err = notifications.Notify(r.Context(), c.AccountID, notifications.KindCourseInvite,
+		fmt.Sprintf("%s added you to %q", user.Username, courseName),
+		fmt.Sprintf("You are now %s of the course.", roleWithArticle(req.Role)), "/flashcards")
+	if err != nil {
+		log.Printf("Failed to notify collaborator %d: %v", c.AccountID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func roleWithArticle(role string) string {
+	if role == RoleViewer {
+		return "a viewer"
+	}
+	return "an " + role
+}
+
+// SetCollaboratorRoleHandler changes the role of collaborator {username}.
+// Only owners may change roles.
+func SetCollaboratorRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetCollaboratorRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if _, ok := roleRank[req.Role]; !ok {
+		apierror.Write(w, apierror.Validation("role must be owner, editor or viewer"))
+		return
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleOwner) {
+		return
+	}
+
+	var c Collaborator
+	err = db.DB.QueryRowContext(r.Context(),
+		`UPDATE course_collaborators cc SET role = $3
+		 FROM accounts a
+		 WHERE cc.course_id = $1 AND cc.account_id = a.id AND LOWER(a.username) = LOWER($2)
+		 RETURNING a.id, a.username, cc.role, cc.created_at`,
+		courseID, r.PathValue("username"), req.Role,
+	).Scan(&c.AccountID, &c.Username, &c.Role, &c.AddedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("No such collaborator"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to change collaborator role on course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to change role"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// RemoveCollaboratorHandler removes collaborator {username}. Owners may
+// remove anyone but the creator; every collaborator may remove itself.
+func RemoveCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+	username := r.PathValue("username")
+	if !strings.EqualFold(username, user.Username) && !requireCourseRole(w, r, courseID, user, RoleOwner) {
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(),
+		`DELETE FROM course_collaborators cc USING accounts a
+		 WHERE cc.course_id = $1 AND cc.account_id = a.id AND LOWER(a.username) = LOWER($2)`,
+		courseID, username)
+	if err != nil {
+		log.Printf("Failed to remove collaborator from course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to remove collaborator"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("No such collaborator"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,318 @@
+package flashcards
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cardcontent"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/sanitize"
+)
+
+type UpdateCourseRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Validate strips markup from the name and keeps only safe formatting in
+// the description; see package sanitize.
+func (req *UpdateCourseRequest) Validate() error {
+	req.Name = strings.TrimSpace(sanitize.Text(req.Name))
+	req.Description = strings.TrimSpace(sanitize.HTML(req.Description))
+	if req.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(req.Name) > maxCourseNameChars {
+		return fmt.Errorf("name must be at most %d characters", maxCourseNameChars)
+	}
+	return nil
+}
+
+// cleanCardContent strips markup from the question and answer and checks
+// their math; see package cardcontent.
+func cleanCardContent(card *Flashcard) error {
+	question, err := cardcontent.Clean(card.Question)
+	if err != nil {
+		return fmt.Errorf("question: %w", err)
+	}
+	answer, err := cardcontent.Clean(card.Answer)
+	if err != nil {
+		return fmt.Errorf("answer: %w", err)
+	}
+	card.Question, card.Answer = question, answer
+	return nil
+}
+
+func validateCard(card *Flashcard) error {
+	// Occlusion cards come only from CreateOcclusionCardsHandler, choices
+	// only from AcceptDistractorsHandler and challenges only from
+	// SetChallengeHandler.
+	card.Occlusion = nil
+	card.Choices = nil
+	card.Challenge = nil
+	if err := cleanCardContent(card); err != nil {
+		return err
+	}
+	if card.Question == "" || card.Answer == "" {
+		return fmt.Errorf("a card needs a question and an answer")
+	}
+	if card.Time < 0 {
+		return fmt.Errorf("time must not be negative")
+	}
+	if card.Time == 0 {
+		card.Time = defaultCardTime
+	}
+	return nil
+}
+
+// UpdateCourseHandler renames a course or changes its description. Editors
+// and owners may do so.
+func UpdateCourseHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateCourseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+
+	_, err = db.DB.ExecContext(r.Context(),
+		"UPDATE courses SET name = $1, description = $2 WHERE id = $3", req.Name, req.Description, courseID)
+	if err != nil {
+		log.Printf("Failed to update course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to update course"))
+		return
+	}
+	invalidateCourse(r.Context(), courseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Course{ID: courseID, Name: req.Name, Description: req.Description})
+}
+
+// DeleteCourseHandler deletes a course with the cards no other course uses.
+// Only owners may delete.
+func DeleteCourseHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok || !requireCourseRole(w, r, courseID, user, RoleOwner) {
+		return
+	}
+
+	tx, err := db.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete course"))
+		return
+	}
+	defer tx.Rollback()
+
+	// Unlinking happens by cascade, so the cards used only here are
+	// collected before the course goes.
+	_, err = tx.ExecContext(r.Context(),
+		`DELETE FROM flashcards WHERE id IN (
+			SELECT flashcard_id FROM course_flashcards WHERE course_id = $1
+			EXCEPT
+			SELECT flashcard_id FROM course_flashcards WHERE course_id <> $1
+		)`, courseID)
+	if err == nil {
+		_, err = tx.ExecContext(r.Context(), "DELETE FROM courses WHERE id = $1", courseID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Failed to delete course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to delete course"))
+		return
+	}
+	invalidateCourse(r.Context(), courseID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddCardHandler appends a new card to a course.
+func AddCardHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	var card Flashcard
+	if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := validateCard(&card); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+
+	err = db.DB.QueryRowContext(r.Context(),
+		`WITH card AS (
+			INSERT INTO flashcards (question, answer, time) VALUES ($2, $3, $4) RETURNING id
+		 )
+		 INSERT INTO course_flashcards (course_id, flashcard_id, order_index)
+		 SELECT $1, card.id, COALESCE((SELECT MAX(order_index) + 1 FROM course_flashcards WHERE course_id = $1), 0)
+		 FROM card
+		 RETURNING flashcard_id`,
+		courseID, card.Question, card.Answer, card.Time,
+	).Scan(&card.ID)
+	if err != nil {
+		log.Printf("Failed to add card to course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to add card"))
+		return
+	}
+	invalidateCourse(r.Context(), courseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(card)
+}
+
+// pathCardID returns the {card_id} of a card in course courseID and the
+// number of courses using it, writing 404 if it is not in the course.
+func pathCardID(w http.ResponseWriter, r *http.Request, courseID int) (cardID, courses int, ok bool) {
+	cardID, err := strconv.Atoi(r.PathValue("card_id"))
+	if err != nil || cardID < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid card ID"))
+		return 0, 0, false
+	}
+	courses, err = cardCourseCount(r.Context(), courseID, cardID)
+	if err != nil {
+		log.Printf("Failed to load card %d: %v", cardID, err)
+		apierror.Write(w, apierror.Internal("Failed to load card"))
+		return 0, 0, false
+	}
+	if courses == 0 {
+		apierror.Write(w, apierror.NotFound("Card not found in this course"))
+		return 0, 0, false
+	}
+	return cardID, courses, true
+}
+
+// cardCourseCount returns how many courses use the card, or 0 when course
+// courseID is not one of them.
+func cardCourseCount(ctx context.Context, courseID, cardID int) (int, error) {
+	var n int
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM course_flashcards
+		 WHERE flashcard_id = $2 AND EXISTS (
+			SELECT 1 FROM course_flashcards WHERE course_id = $1 AND flashcard_id = $2)`,
+		courseID, cardID).Scan(&n)
+	return n, err
+}
+
+// UpdateCardHandler edits a card of the course. Cards that other courses use
+// too are refused, since the edit would change those courses as well.
+func UpdateCardHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	var card Flashcard
+	if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := validateCard(&card); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+	cardID, courses, ok := pathCardID(w, r, courseID)
+	if !ok {
+		return
+	}
+	card.ID = cardID
+	if courses > 1 {
+		apierror.Write(w, apierror.Conflict("The card is shared with other courses"))
+		return
+	}
+
+	_, err = db.DB.ExecContext(r.Context(),
+		"UPDATE flashcards SET question = $1, answer = $2, time = $3 WHERE id = $4",
+		card.Question, card.Answer, card.Time, card.ID)
+	if err != nil {
+		log.Printf("Failed to update card %d: %v", card.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to update card"))
+		return
+	}
+	invalidateCourse(r.Context(), courseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(card)
+}
+
+// DeleteCardHandler removes a card from the course, deleting it when no
+// other course uses it.
+func DeleteCardHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok || !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+	cardID, _, ok := pathCardID(w, r, courseID)
+	if !ok {
+		return
+	}
+
+	_, err = db.DB.ExecContext(r.Context(),
+		`WITH unlinked AS (
+			DELETE FROM course_flashcards WHERE course_id = $1 AND flashcard_id = $2 RETURNING flashcard_id
+		 )
+		 DELETE FROM flashcards WHERE id IN (SELECT flashcard_id FROM unlinked)
+		 AND NOT EXISTS (SELECT 1 FROM course_flashcards WHERE flashcard_id = $2 AND course_id <> $1)`,
+		courseID, cardID)
+	if err != nil {
+		log.Printf("Failed to delete card %d from course %d: %v", cardID, courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to delete card"))
+		return
+	}
+	invalidateCourse(r.Context(), courseID)
+	w.WriteHeader(http.StatusNoContent)
+}
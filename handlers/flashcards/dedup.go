@@ -0,0 +1,464 @@
+package flashcards
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cache"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultDuplicateThreshold = 0.8
+	minDuplicateThreshold     = 0.5
+	// maxDedupCards bounds a bank-wide scan, which compares every pair.
+	maxDedupCards = 5000
+	maxMergeCards = 100
+)
+
+// DuplicateGroup is a set of cards that are near-duplicates of each other,
+// with a proposed merge: keep the oldest card and fold the rest into it.
+type DuplicateGroup struct {
+	Cards []Flashcard `json:"cards"`
+	// Similarity is the lowest question similarity among the matched pairs.
+	Similarity float64 `json:"similarity"`
+	Keep       int     `json:"keep"`
+	Merge      []int   `json:"merge"`
+}
+
+type DuplicatesResponse struct {
+	Groups    []DuplicateGroup `json:"groups"`
+	Scanned   int              `json:"scanned"`
+	Threshold float64          `json:"threshold"`
+	// Truncated is set when the bank had more than maxDedupCards cards and
+	// only the oldest were compared.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type MergeRequest struct {
+	Keep  int   `json:"keep"`
+	Merge []int `json:"merge"`
+}
+
+type MergeResult struct {
+	Kept           int   `json:"kept"`
+	Merged         []int `json:"merged"`
+	CoursesUpdated int   `json:"courses_updated"`
+	ScoresMoved    int64 `json:"scores_moved"`
+}
+
+// DuplicatesHandler finds near-duplicate cards. With ?course_id= it scans
+// that course, which the caller must own unless they are an admin; without
+// it an admin scans the whole question bank. ?threshold= (0.5-1, default
+// 0.8) is the minimum question similarity.
+func DuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	q := r.URL.Query()
+	threshold := defaultDuplicateThreshold
+	if v := q.Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < minDuplicateThreshold || t > 1 {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("threshold must be between %.1f and 1", minDuplicateThreshold)))
+			return
+		}
+		threshold = t
+	}
+
+	var cards []Flashcard
+	truncated := false
+	if v := q.Get("course_id"); v != "" {
+		courseID, err := strconv.Atoi(v)
+		if err != nil {
+			apierror.Write(w, apierror.BadRequest("Invalid course ID"))
+			return
+		}
+		if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+			return
+		}
+		cards, err = getFlashcardsByCourse(courseID)
+		if err != nil {
+			log.Printf("Failed to load cards of course %d for dedup: %v", courseID, err)
+			apierror.Write(w, apierror.Internal("Failed to load flashcards"))
+			return
+		}
+	} else {
+		if user.Role != "admin" {
+			apierror.Write(w, apierror.Forbidden("Admin access required to scan the whole question bank"))
+			return
+		}
+		cards, err = loadQuestionBank(r.Context(), maxDedupCards+1)
+		if err != nil {
+			log.Printf("Failed to load question bank for dedup: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load flashcards"))
+			return
+		}
+		if len(cards) > maxDedupCards {
+			cards, truncated = cards[:maxDedupCards], true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DuplicatesResponse{
+		Groups:    findDuplicates(cards, threshold),
+		Scanned:   len(cards),
+		Threshold: threshold,
+		Truncated: truncated,
+	})
+}
+
+// MergeCardsHandler folds the merge cards into keep in one transaction:
+// course links and score history move to keep, and the merged cards are
+// deleted. Content owners may merge cards that only appear in their own
+// courses; admins may merge any cards.
+func MergeCardsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := validateMerge(&req); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	ids := append([]int{req.Keep}, req.Merge...)
+	if user.Role != "admin" {
+		ok, err := ownsCards(r.Context(), ids, user.ID)
+		if err != nil {
+			log.Printf("Failed to check card ownership for merge: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to merge flashcards"))
+			return
+		}
+		if !ok {
+			apierror.Write(w, apierror.Forbidden("You can only merge cards that appear only in your own courses"))
+			return
+		}
+	}
+
+	result, courses, err := mergeCards(r.Context(), req.Keep, req.Merge)
+	if errors.Is(err, errCardNotFound) {
+		apierror.Write(w, apierror.NotFound("One or more flashcards do not exist"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to merge flashcards %v into %d: %v", req.Merge, req.Keep, err)
+		apierror.Write(w, apierror.Internal("Failed to merge flashcards"))
+		return
+	}
+
+	keys := []string{coursesCacheKey, guestCardsCacheKey}
+	for _, courseID := range courses {
+		keys = append(keys, courseCardsCacheKey(courseID))
+	}
+	cache.Invalidate(r.Context(), keys...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func validateMerge(req *MergeRequest) error {
+	if req.Keep <= 0 {
+		return fmt.Errorf("keep is required")
+	}
+	if len(req.Merge) == 0 {
+		return fmt.Errorf("merge must list at least one card")
+	}
+	if len(req.Merge) > maxMergeCards {
+		return fmt.Errorf("at most %d cards can be merged at once", maxMergeCards)
+	}
+	seen := map[int]bool{req.Keep: true}
+	for _, id := range req.Merge {
+		if seen[id] {
+			return fmt.Errorf("card %d is listed more than once", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+var errCardNotFound = errors.New("flashcard not found")
+
+// mergeCards rewrites every reference to the merged cards and returns the
+// courses whose card lists changed. A course that ends up with keep twice
+// keeps only its earliest position.
+func mergeCards(ctx context.Context, keep int, merge []int) (*MergeResult, []int, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the cards so a concurrent merge or edit cannot interleave.
+	locked, err := queryInts(ctx, tx,
+		`SELECT id FROM flashcards WHERE id = ANY($1) FOR UPDATE`, pq.Array(append([]int{keep}, merge...)))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(locked) != len(merge)+1 {
+		return nil, nil, errCardNotFound
+	}
+
+	courses, err := queryInts(ctx, tx,
+		`SELECT DISTINCT course_id FROM course_flashcards WHERE flashcard_id = ANY($1) ORDER BY course_id`,
+		pq.Array(merge))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	statements := []string{
+		// Drop links in courses that already contain keep...
+		`DELETE FROM course_flashcards cf
+		 WHERE cf.flashcard_id = ANY($2)
+		   AND EXISTS (SELECT 1 FROM course_flashcards k WHERE k.course_id = cf.course_id AND k.flashcard_id = $1)`,
+		// ...and all but the earliest of several merged cards in one course,
+		`DELETE FROM course_flashcards cf
+		 WHERE cf.flashcard_id = ANY($2)
+		   AND EXISTS (SELECT 1 FROM course_flashcards o
+		               WHERE o.course_id = cf.course_id AND o.flashcard_id = ANY($2)
+		                 AND (o.order_index, o.id) < (cf.order_index, cf.id))`,
+		// so repointing the rest cannot violate UNIQUE(course_id, flashcard_id).
+		`UPDATE course_flashcards SET flashcard_id = $1 WHERE flashcard_id = ANY($2)`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt, keep, pq.Array(merge)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE account_score SET flashcard_id = $1 WHERE flashcard_id = ANY($2)`, keep, pq.Array(merge))
+	if err != nil {
+		return nil, nil, err
+	}
+	moved, _ := res.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM flashcards WHERE id = ANY($1)`, pq.Array(merge)); err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return &MergeResult{Kept: keep, Merged: merge, CoursesUpdated: len(courses), ScoresMoved: moved}, courses, nil
+}
+
+func queryInts(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ownsCards reports whether every card is linked to at least one course and
+// accountID owns or edits all of its courses. Cards outside any course are
+// the shared guest bank and need an admin.
+func ownsCards(ctx context.Context, ids []int, accountID int) (bool, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT cf.flashcard_id,
+		        c.account_id = $2 OR COALESCE(cc.role IN ('owner', 'editor'), FALSE)
+		 FROM course_flashcards cf
+		 JOIN courses c ON c.id = cf.course_id
+		 LEFT JOIN course_collaborators cc ON cc.course_id = c.id AND cc.account_id = $2
+		 WHERE cf.flashcard_id = ANY($1)`, pq.Array(ids), accountID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	linked := make(map[int]bool)
+	for rows.Next() {
+		var cardID int
+		var canEdit sql.NullBool
+		if err := rows.Scan(&cardID, &canEdit); err != nil {
+			return false, err
+		}
+		if !canEdit.Bool {
+			return false, nil
+		}
+		linked[cardID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	return len(linked) == len(ids), nil
+}
+
+func loadQuestionBank(ctx context.Context, limit int) ([]Flashcard, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT id, question, answer, time FROM flashcards ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cards []Flashcard
+	for rows.Next() {
+		var card Flashcard
+		if err := rows.Scan(&card.ID, &card.Question, &card.Answer, &card.Time); err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+// findDuplicates links every pair of cards whose questions are at least
+// threshold similar and whose answers agree, and returns the connected
+// groups, most similar first.
+func findDuplicates(cards []Flashcard, threshold float64) []DuplicateGroup {
+	type fingerprint struct {
+		question map[string]struct{}
+		answer   string
+		answerGr map[string]struct{}
+	}
+	prints := make([]fingerprint, len(cards))
+	for i, c := range cards {
+		answer := normalizeText(c.Answer)
+		prints[i] = fingerprint{trigrams(normalizeText(c.Question)), answer, trigrams(answer)}
+	}
+
+	parent := make([]int, len(cards))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	weakest := make(map[int]float64)
+
+	for i := range cards {
+		for j := i + 1; j < len(cards); j++ {
+			sim := jaccard(prints[i].question, prints[j].question)
+			if sim < threshold {
+				continue
+			}
+			if prints[i].answer != prints[j].answer && jaccard(prints[i].answerGr, prints[j].answerGr) < threshold {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			w := sim
+			for _, r := range []int{ri, rj} {
+				if v, ok := weakest[r]; ok && v < w {
+					w = v
+				}
+			}
+			delete(weakest, ri)
+			delete(weakest, rj)
+			if ri != rj {
+				parent[rj] = ri
+			}
+			weakest[ri] = w
+		}
+	}
+
+	members := make(map[int][]Flashcard)
+	for i, c := range cards {
+		root := find(i)
+		if _, linked := weakest[root]; linked {
+			members[root] = append(members[root], c)
+		}
+	}
+
+	groups := make([]DuplicateGroup, 0, len(members))
+	for root, group := range members {
+		sort.Slice(group, func(a, b int) bool { return group[a].ID < group[b].ID })
+		g := DuplicateGroup{Cards: group, Similarity: weakest[root], Keep: group[0].ID}
+		for _, c := range group[1:] {
+			g.Merge = append(g.Merge, c.ID)
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(a, b int) bool {
+		if groups[a].Similarity != groups[b].Similarity {
+			return groups[a].Similarity > groups[b].Similarity
+		}
+		return groups[a].Keep < groups[b].Keep
+	})
+	return groups
+}
+
+// normalizeText lowercases s, drops punctuation and collapses whitespace so
+// that trivially different phrasings compare equal.
+func normalizeText(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r):
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// trigrams returns the set of character trigrams of s, padded so short
+// strings still produce some.
+func trigrams(s string) map[string]struct{} {
+	runes := []rune("  " + s + " ")
+	set := make(map[string]struct{}, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		set[string(runes[i:i+3])] = struct{}{}
+	}
+	return set
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for g := range a {
+		if _, ok := b[g]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
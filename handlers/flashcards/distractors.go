@@ -0,0 +1,334 @@
+package flashcards
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cardcontent"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultDistractors = 3
+	maxDistractors     = 5
+)
+
+// DistractorSuggestion proposes wrong options that would turn a free-text
+// card into a multiple-choice one.
+type DistractorSuggestion struct {
+	FlashcardID int      `json:"flashcard_id"`
+	Question    string   `json:"question"`
+	Answer      string   `json:"answer"`
+	Distractors []string `json:"distractors"`
+}
+
+type DistractorsResponse struct {
+	Suggestions []DistractorSuggestion `json:"suggestions"`
+}
+
+// AcceptDistractorsRequest sets the wrong options of cards, usually a
+// reviewed preview. An empty list turns a card back into free text.
+type AcceptDistractorsRequest struct {
+	Cards []struct {
+		FlashcardID int      `json:"flashcard_id"`
+		Distractors []string `json:"distractors"`
+	} `json:"cards"`
+}
+
+// choicesFor returns the options of a multiple-choice card: the answer and
+// its distractors in alphabetical order, so the position gives nothing
+// away. It returns nil for free-text cards. Distractors that have come to
+// equal the answer, after an edit, are dropped.
+func choicesFor(answer string, distractors []string) []string {
+	key := normalizeText(answer)
+	choices := []string{answer}
+	for _, d := range distractors {
+		if normalizeText(d) != key {
+			choices = append(choices, d)
+		}
+	}
+	if len(choices) == 1 {
+		return nil
+	}
+	sort.Strings(choices)
+	return choices
+}
+
+// distractors returns the wrong options of a multiple-choice card.
+func (card Flashcard) distractors() []string {
+	var wrong []string
+	for _, c := range card.Choices {
+		if c != card.Answer {
+			wrong = append(wrong, c)
+		}
+	}
+	return wrong
+}
+
+// suggestDistractors picks up to count other answers of the course as
+// wrong options for card. Plausible options look like the answer: they
+// share letter sequences with it, are about as long and are numbers when
+// it is one. Answers close enough to count as duplicates are skipped, as
+// they could be right too.
+func suggestDistractors(card Flashcard, answers []string, count int) []string {
+	key := normalizeText(card.Answer)
+	grams := trigrams(key)
+	type candidate struct {
+		text  string
+		score float64
+	}
+	seen := map[string]bool{key: true}
+	var candidates []candidate
+	for _, a := range answers {
+		k := normalizeText(a)
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		similarity := jaccard(grams, trigrams(k))
+		if similarity >= defaultDuplicateThreshold {
+			continue
+		}
+		score := 0.6*similarity + 0.2*lengthRatio(key, k)
+		if isNumeric(key) == isNumeric(k) {
+			score += 0.2
+		}
+		candidates = append(candidates, candidate{a, score})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].text < candidates[j].text
+	})
+
+	picked := []string{}
+	for i := 0; i < len(candidates) && i < count; i++ {
+		picked = append(picked, candidates[i].text)
+	}
+	return picked
+}
+
+func lengthRatio(a, b string) float64 {
+	la, lb := len([]rune(a)), len([]rune(b))
+	if la > lb {
+		la, lb = lb, la
+	}
+	if lb == 0 {
+		return 1
+	}
+	return float64(la) / float64(lb)
+}
+
+func isNumeric(s string) bool {
+	digits := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			return false
+		}
+		if unicode.IsDigit(r) {
+			digits++
+		}
+	}
+	return digits > 0
+}
+
+// PreviewDistractorsHandler suggests wrong options for the free-text cards
+// of a course, or only ?card_id=, drawn from the course's other answers.
+// Nothing is saved; see AcceptDistractorsHandler. ?count= is 1-5, default
+// 3. Editors and owners may use it.
+func PreviewDistractorsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	count := defaultDistractors
+	if v := q.Get("count"); v != "" {
+		count, err = strconv.Atoi(v)
+		if err != nil || count < 1 || count > maxDistractors {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("count must be between 1 and %d", maxDistractors)))
+			return
+		}
+	}
+	cardID := 0
+	if v := q.Get("card_id"); v != "" {
+		if cardID, err = strconv.Atoi(v); err != nil || cardID < 1 {
+			apierror.Write(w, apierror.BadRequest("Invalid card ID"))
+			return
+		}
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+
+	cards, err := getFlashcardsByCourse(courseID)
+	if err != nil {
+		log.Printf("Failed to load cards of course %d for distractors: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load cards"))
+		return
+	}
+	answers := make([]string, 0, len(cards))
+	for _, c := range cards {
+		if c.Occlusion == nil {
+			answers = append(answers, c.Answer)
+		}
+	}
+
+	found := false
+	resp := DistractorsResponse{Suggestions: []DistractorSuggestion{}}
+	for _, c := range cards {
+		if cardID != 0 && c.ID != cardID {
+			continue
+		}
+		found = true
+		if c.Occlusion != nil || c.Choices != nil {
+			continue
+		}
+		if picked := suggestDistractors(c, answers, count); len(picked) > 0 {
+			resp.Suggestions = append(resp.Suggestions, DistractorSuggestion{
+				FlashcardID: c.ID, Question: c.Question, Answer: c.Answer, Distractors: picked,
+			})
+		}
+	}
+	if cardID != 0 && !found {
+		apierror.Write(w, apierror.NotFound("Card not found in this course"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// AcceptDistractorsHandler saves the wrong options of cards in a course,
+// making them multiple choice. Editors and owners may use it.
+func AcceptDistractorsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+	var req AcceptDistractorsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if len(req.Cards) == 0 {
+		apierror.Write(w, apierror.Validation("cards is required"))
+		return
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+
+	cards, err := getFlashcardsByCourse(courseID)
+	if err != nil {
+		log.Printf("Failed to load cards of course %d for distractors: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load cards"))
+		return
+	}
+	byID := make(map[int]Flashcard, len(cards))
+	for _, c := range cards {
+		byID[c.ID] = c
+	}
+	updates := make(map[int][]string, len(req.Cards))
+	for _, c := range req.Cards {
+		card, ok := byID[c.FlashcardID]
+		if !ok {
+			apierror.Write(w, apierror.NotFound(fmt.Sprintf("Card %d not found in this course", c.FlashcardID)))
+			return
+		}
+		if card.Occlusion != nil {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("Card %d is an image occlusion card", c.FlashcardID)))
+			return
+		}
+		cleaned, err := cleanDistractors(card.Answer, c.Distractors)
+		if err != nil {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("card %d: %v", c.FlashcardID, err)))
+			return
+		}
+		updates[c.FlashcardID] = cleaned
+	}
+
+	tx, err := db.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Failed to begin distractor update: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save distractors"))
+		return
+	}
+	defer tx.Rollback()
+	for _, c := range req.Cards {
+		var value interface{}
+		if d := updates[c.FlashcardID]; len(d) > 0 {
+			value = pq.Array(d)
+		}
+		if _, err := tx.ExecContext(r.Context(),
+			"UPDATE flashcards SET distractors = $1 WHERE id = $2", value, c.FlashcardID,
+		); err != nil {
+			log.Printf("Failed to save distractors of card %d: %v", c.FlashcardID, err)
+			apierror.Write(w, apierror.Internal("Failed to save distractors"))
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit distractors: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save distractors"))
+		return
+	}
+	invalidateCourse(r.Context(), courseID)
+
+	saved := []Flashcard{}
+	for _, c := range req.Cards {
+		card := byID[c.FlashcardID]
+		card.Choices = choicesFor(card.Answer, updates[c.FlashcardID])
+		saved = append(saved, card)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// cleanDistractors cleans wrong options like card content and checks they
+// are distinct from each other and from the answer.
+func cleanDistractors(answer string, distractors []string) ([]string, error) {
+	if len(distractors) > maxDistractors {
+		return nil, fmt.Errorf("at most %d distractors", maxDistractors)
+	}
+	seen := map[string]bool{normalizeText(answer): true}
+	cleaned := make([]string, 0, len(distractors))
+	for _, d := range distractors {
+		d, err := cardcontent.Clean(d)
+		if err != nil {
+			return nil, err
+		}
+		d = strings.TrimSpace(d)
+		key := normalizeText(d)
+		if key == "" {
+			return nil, fmt.Errorf("distractors must not be empty")
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%q repeats the answer or another distractor", d)
+		}
+		seen[key] = true
+		cleaned = append(cleaned, d)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,245 @@
+package flashcards
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cardcontent"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/llm"
+	"allanswebterminal/validate"
+)
+
+const (
+	maxExplanationChars = 2000
+	// maxExplainHistory bounds the follow-up turns sent back to the model.
+	maxExplainHistory = 10
+	explainTimeout    = 30 * time.Second
+)
+
+// DailyExplainLimit is how many model replies an account gets per day.
+// Stored explanations are not counted.
+var DailyExplainLimit = 50
+
+// ExplainRequest asks why Answer was wrong. Question is an optional
+// follow-up, "Why?" by default, and History the conversation so far as
+// returned by the previous response.
+type ExplainRequest struct {
+	FlashcardID int           `json:"flashcard_id" validate:"required"`
+	Answer      string        `json:"answer" validate:"max=1000"`
+	Question    string        `json:"question" validate:"max=500"`
+	History     []llm.Message `json:"history"`
+}
+
+// ExplainResponse explains a card. Explanation is the one stored with the
+// card, if any; Reply is the model's, when a provider is configured.
+type ExplainResponse struct {
+	FlashcardID   int           `json:"flashcard_id"`
+	Question      string        `json:"question"`
+	CorrectAnswer string        `json:"correct_answer"`
+	YourAnswer    string        `json:"your_answer"`
+	Correct       bool          `json:"correct"`
+	Explanation   string        `json:"explanation"`
+	Reply         string        `json:"reply,omitempty"`
+	History       []llm.Message `json:"history,omitempty"`
+	// Remaining is how many model replies the caller has left today.
+	Remaining int `json:"remaining"`
+}
+
+type SetExplanationRequest struct {
+	Explanation string `json:"explanation"`
+}
+
+const explainSystemPrompt = `You are a patient tutor. A student answered a flashcard and wants to understand the right answer.
+Explain briefly and concretely why the correct answer is right and, if the student's answer differs, what is wrong with it.
+Rely on the card and its explanation; say so if they do not settle the question.`
+
+// ExplainHandler explains the answer of a card to a signed-in user,
+// usually after a wrong answer. It combines the card, the user's answer and
+// the card's stored explanation and, when an LLM provider is configured,
+// adds the model's reply, which the user can follow up on. Every call is
+// logged per account; model replies are limited to DailyExplainLimit a day.
+func ExplainHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req ExplainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	if len(req.History) > maxExplainHistory {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("history must have at most %d messages", maxExplainHistory)))
+		return
+	}
+	for _, m := range req.History {
+		if m.Role != "user" && m.Role != "assistant" || len(m.Content) > maxExplanationChars {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("history messages need a user or assistant role and at most %d characters", maxExplanationChars)))
+			return
+		}
+	}
+
+	resp := ExplainResponse{FlashcardID: req.FlashcardID, YourAnswer: strings.TrimSpace(req.Answer)}
+	var explanation sql.NullString
+	err = db.DB.QueryRowContext(r.Context(),
+		"SELECT question, answer, explanation FROM flashcards WHERE id = $1", req.FlashcardID,
+	).Scan(&resp.Question, &resp.CorrectAnswer, &explanation)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Card not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load card %d to explain: %v", req.FlashcardID, err)
+		apierror.Write(w, apierror.Internal("Failed to load card"))
+		return
+	}
+	resp.Explanation = explanation.String
+	resp.Correct = checkAnswer(resp.YourAnswer, resp.CorrectAnswer)
+
+	used, err := explainUsedToday(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to count explanations of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to explain card"))
+		return
+	}
+	resp.Remaining = max(DailyExplainLimit-used, 0)
+
+	generated := false
+	switch {
+	case !llm.Configured():
+	case resp.Remaining == 0:
+		if resp.Explanation == "" {
+			apierror.Write(w, apierror.New(apierror.CodeRateLimited, "You have used today's explanations; try again tomorrow"))
+			return
+		}
+	default:
+		ctx, cancel := context.WithTimeout(r.Context(), explainTimeout)
+		reply, err := llm.Complete(ctx, explainPrompt(resp, req))
+		cancel()
+		if err != nil {
+			log.Printf("Explanation of card %d failed: %v", req.FlashcardID, err)
+			if resp.Explanation == "" {
+				apierror.Write(w, apierror.Unavailable("The explainer failed; try again later"))
+				return
+			}
+			break
+		}
+		generated = true
+		resp.Reply = strings.TrimSpace(reply)
+		resp.Remaining--
+		resp.History = append(req.History,
+			llm.Message{Role: "user", Content: explainQuestion(req)},
+			llm.Message{Role: "assistant", Content: resp.Reply})
+	}
+
+	if _, err := db.DB.ExecContext(r.Context(),
+		"INSERT INTO explain_usage (account_id, flashcard_id, generated) VALUES ($1, $2, $3)",
+		user.ID, req.FlashcardID, generated,
+	); err != nil {
+		log.Printf("Failed to log explanation for account %d: %v", user.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// explainPrompt gives the model the card and the user's answer as
+// context, followed by the conversation so far and the new question.
+func explainPrompt(resp ExplainResponse, req ExplainRequest) llm.Request {
+	system := fmt.Sprintf("%s\n\nCard question: %s\nCorrect answer: %s\nStudent's answer: %s",
+		explainSystemPrompt, resp.Question, resp.CorrectAnswer, resp.YourAnswer)
+	if resp.Explanation != "" {
+		system += "\nCard explanation: " + resp.Explanation
+	}
+	messages := append(append([]llm.Message{}, req.History...), llm.Message{Role: "user", Content: explainQuestion(req)})
+	return llm.Request{System: system, Messages: messages, MaxTokens: 800}
+}
+
+// explainQuestion is the user's question; the first one is "Why?".
+func explainQuestion(req ExplainRequest) string {
+	if q := strings.TrimSpace(req.Question); q != "" {
+		return q
+	}
+	return "Why?"
+}
+
+// explainUsedToday counts the model replies an account got since midnight
+// UTC.
+func explainUsedToday(ctx context.Context, accountID int) (int, error) {
+	var n int
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM explain_usage
+		 WHERE account_id = $1 AND generated AND created_at >= date_trunc('day', now() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'`,
+		accountID).Scan(&n)
+	return n, err
+}
+
+// SetExplanationHandler stores the explanation of a card, shown when a user
+// asks why. An empty explanation removes it. Like other edits, cards shared
+// with other courses are refused.
+func SetExplanationHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+	var req SetExplanationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	explanation, err := cardcontent.Clean(req.Explanation)
+	if err != nil {
+		apierror.Write(w, apierror.Validation("explanation: "+err.Error()))
+		return
+	}
+	if len(explanation) > maxExplanationChars {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("explanation must be at most %d characters", maxExplanationChars)))
+		return
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+	cardID, courses, ok := pathCardID(w, r, courseID)
+	if !ok {
+		return
+	}
+	if courses > 1 {
+		apierror.Write(w, apierror.Conflict("The card is shared with other courses"))
+		return
+	}
+
+	var value interface{}
+	if explanation != "" {
+		value = explanation
+	}
+	if _, err := db.DB.ExecContext(r.Context(),
+		"UPDATE flashcards SET explanation = $1 WHERE id = $2", value, cardID,
+	); err != nil {
+		log.Printf("Failed to save explanation of card %d: %v", cardID, err)
+		apierror.Write(w, apierror.Internal("Failed to save explanation"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"flashcard_id": cardID, "explanation": explanation})
+}
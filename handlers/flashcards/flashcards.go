@@ -1,17 +1,24 @@
 package flashcards
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"allanswebterminal/templates"
+
+	"allanswebterminal/apierror"
 	"allanswebterminal/db"
 	"allanswebterminal/handlers/login"
+
+	"github.com/lib/pq"
 )
 
 type Flashcard struct {
@@ -19,6 +26,12 @@ type Flashcard struct {
 	Question string `json:"question"`
 	Answer   string `json:"answer"`
 	Time     int    `json:"time"` // time limit in seconds
+	// Occlusion is set on cards made from an image; see occlusion.go.
+	Occlusion *Occlusion `json:"occlusion,omitempty"`
+	// Choices is set on multiple-choice cards; see distractors.go.
+	Choices []string `json:"choices,omitempty"`
+	// Challenge is set on code-challenge cards; see challenge.go.
+	Challenge *Challenge `json:"challenge,omitempty"`
 }
 
 type Course struct {
@@ -28,230 +41,350 @@ type Course struct {
 }
 
 type GameSession struct {
-	CourseID      int           `json:"course_id"`
-	CurrentIndex  int           `json:"current_index"`
-	Flashcards    []Flashcard   `json:"flashcards"`
-	StartTime     time.Time     `json:"start_time"`
-	Scores        []ScoreResult `json:"scores"`
+	CourseID     int           `json:"course_id"`
+	CurrentIndex int           `json:"current_index"`
+	Flashcards   []Flashcard   `json:"flashcards"`
+	StartTime    time.Time     `json:"start_time"`
+	Scores       []ScoreResult `json:"scores"`
+	// AccountID owns the session and receives its sync events; 0 for guests.
+	AccountID int `json:"account_id,omitempty"`
+	// Version counts accepted answers so devices can order sync events.
+	Version int `json:"version"`
+	// Rules are the course's scoring rules when the game started.
+	Rules *ScoringRules `json:"rules,omitempty"`
+	// Streak counts the current run of correct answers.
+	Streak int `json:"streak"`
+	// PausedAt is when the player paused the game, nil while it runs.
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+	// PausedFor is the time spent in earlier, resumed pauses.
+	PausedFor time.Duration `json:"paused_for,omitempty"`
+	// Seed shuffled the cards when the game was started in shuffle mode.
+	Seed *int64 `json:"seed,omitempty"`
+	// CardShownAt is the play time when the current card was shown, and
+	// AnswerTimes how long the latest answers took; see flagAnswer.
+	CardShownAt time.Duration   `json:"card_shown_at,omitempty"`
+	AnswerTimes []time.Duration `json:"answer_times,omitempty"`
+
+	mu sync.Mutex
 }
 
 type ScoreResult struct {
-	FlashcardID   int  `json:"flashcard_id"`
-	TimeScore     int  `json:"time_score"`     // time taken in seconds
-	CorrectAnswer bool `json:"correct_answer"`
+	FlashcardID   int             `json:"flashcard_id"`
+	TimeScore     int             `json:"time_score"` // time taken in seconds
+	CorrectAnswer bool            `json:"correct_answer"`
+	HintsUsed     int             `json:"hints_used,omitempty"`
+	Points        PointsBreakdown `json:"points"`
+	// Flags are the cheating heuristics the answer tripped; see cheating.go.
+	Flags []string `json:"flags,omitempty"`
 }
 
 type AnswerRequest struct {
 	Answer      string `json:"answer"`
 	TimeScore   int    `json:"time_score"`
 	FlashcardID int    `json:"flashcard_id"`
+	HintsUsed   int    `json:"hints_used"`
 }
 
 type AnswerResponse struct {
-	Correct       bool        `json:"correct"`
-	CorrectAnswer string      `json:"correct_answer"`
-	NextCard      *Flashcard  `json:"next_card"`
-	GameComplete  bool        `json:"game_complete"`
-	FinalScore    *FinalScore `json:"final_score,omitempty"`
+	Correct       bool            `json:"correct"`
+	CorrectAnswer string          `json:"correct_answer"`
+	NextCard      *Flashcard      `json:"next_card"`
+	GameComplete  bool            `json:"game_complete"`
+	FinalScore    *FinalScore     `json:"final_score,omitempty"`
+	Version       int             `json:"version"`
+	Points        PointsBreakdown `json:"points"`
+	Streak        int             `json:"streak"`
+	// Challenge holds the test results of a code-challenge answer.
+	Challenge *ChallengeResult `json:"challenge,omitempty"`
 }
 
 type FinalScore struct {
-	TotalQuestions    int     `json:"total_questions"`
-	CorrectAnswers    int     `json:"correct_answers"`
-	AverageTime       float64 `json:"average_time"`
-	TotalTime         int     `json:"total_time"`
-	AccuracyPercent   float64 `json:"accuracy_percent"`
-}
+	TotalQuestions  int             `json:"total_questions"`
+	CorrectAnswers  int             `json:"correct_answers"`
+	AverageTime     float64         `json:"average_time"`
+	TotalTime       int             `json:"total_time"`
+	AccuracyPercent float64         `json:"accuracy_percent"`
+	Points          int             `json:"points"`
+	LongestStreak   int             `json:"longest_streak"`
+	Breakdown       PointsBreakdown `json:"breakdown"`
+	// Seed replays a shuffled game's order; see RestartGameHandler.
+	Seed *int64 `json:"seed,omitempty"`
+}
+
+var (
+	gameSessions   = make(map[string]*GameSession)
+	gameSessionsMu sync.Mutex
+)
 
-var gameSessions = make(map[string]*GameSession)
+// Game serves the flashcard game: the course list, starting games and
+// answering cards. Its storage and the signed-in user are injected, so
+// tests can play it without a database.
+type Game struct {
+	store Store
+	// currentUser returns the signed-in user, or an error for guests.
+	currentUser func(*http.Request) (*login.User, error)
+}
 
-func FlashcardsPageHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// NewGame returns the game handlers over store, with users signed in
+// through the login package.
+func NewGame(store Store) *Game {
+	return &Game{store: store, currentUser: login.GetCurrentUser}
+}
 
-	courses, err := getAllCourses()
+func (g *Game) FlashcardsPageHandler(w http.ResponseWriter, r *http.Request) {
+	courses, err := g.store.Courses(r.Context())
 	if err != nil {
 		log.Printf("Error getting courses: %v", err)
 		http.Error(w, "Error loading courses", http.StatusInternalServerError)
 		return
 	}
 
-	tmpl, err := template.ParseFiles("templates/flashcards.html")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	data := struct {
 		Courses []Course
 	}{
 		Courses: courses,
 	}
 
-	if err := tmpl.Execute(w, data); err != nil {
+	if err := templates.Render(w, r, "flashcards", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func CoursesAPIHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+func (g *Game) CoursesAPIHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	courses, err := getAllCourses()
+	courses, err := g.store.Courses(r.Context())
 	if err != nil {
 		log.Printf("Error getting courses: %v", err)
-		http.Error(w, "Error loading courses", http.StatusInternalServerError)
+		apierror.Write(w, apierror.Internal("Error loading courses"))
 		return
 	}
 
 	json.NewEncoder(w).Encode(courses)
 }
 
-func GuestFlashcardsAPIHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+func (g *Game) GuestFlashcardsAPIHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	flashcards, err := getGuestFlashcards()
+	flashcards, err := g.store.GuestFlashcards(r.Context())
 	if err != nil {
 		log.Printf("Error getting guest flashcards: %v", err)
-		http.Error(w, "Error loading flashcards", http.StatusInternalServerError)
+		apierror.Write(w, apierror.Internal("Error loading flashcards"))
 		return
 	}
 
 	json.NewEncoder(w).Encode(flashcards)
 }
 
-func StartGameHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+func (g *Game) StartGameHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	courseID, err := parseCourseID(r)
 	if err != nil {
-		http.Error(w, "Invalid course ID", http.StatusBadRequest)
+		apierror.Write(w, apierror.BadRequest("Invalid course ID"))
+		return
+	}
+	seed, err := parseShuffle(r)
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
 		return
 	}
+	g.startCourseGame(w, r, courseID, seed)
+}
 
-	flashcards, err := validateAndGetFlashcards(courseID)
+// startCourseGame starts a game of a course's cards, shuffled with seed
+// unless it is nil.
+func (g *Game) startCourseGame(w http.ResponseWriter, r *http.Request, courseID int, seed *int64) {
+	flashcards, err := validateAndGetFlashcards(r.Context(), g.store, courseID)
 	if err != nil {
-		if err.Error() == "no flashcards found" {
-			http.Error(w, "No flashcards found for this course", http.StatusNotFound)
+		if errors.Is(err, errNoFlashcards) {
+			apierror.Write(w, apierror.NotFound("No flashcards found for this course"))
 		} else {
 			log.Printf("Error getting flashcards: %v", err)
-			http.Error(w, "Error loading flashcards", http.StatusInternalServerError)
+			apierror.Write(w, apierror.Internal("Error loading flashcards"))
 		}
 		return
 	}
 
+	rules, err := g.store.ScoringRules(r.Context(), courseID)
+	if err != nil {
+		log.Printf("Error loading scoring rules: %v", err)
+		apierror.Write(w, apierror.Internal("Error loading scoring rules"))
+		return
+	}
+
+	if seed != nil {
+		flashcards = shuffleCards(flashcards, *seed)
+	}
 	session := createGameSession(courseID, flashcards)
+	session.Rules = &rules
+	session.Seed = seed
+	if user, err := g.currentUser(r); err == nil {
+		session.AccountID = user.ID
+	}
 	sessionID := generateSessionID(courseID)
 	storeGameSession(sessionID, session)
+	publishState(sessionID, session, nil)
 
 	response := buildStartGameResponse(sessionID, flashcards)
+	if seed != nil {
+		response["seed"] = *seed
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
-func StartGuestGameHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+func (g *Game) StartGuestGameHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Parse selected flashcard IDs from request body
 	var req struct {
 		FlashcardIDs []int `json:"flashcard_ids"`
+		Shuffle      bool  `json:"shuffle"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		apierror.Write(w, apierror.DecodeError(err))
 		return
 	}
 
 	if len(req.FlashcardIDs) == 0 {
-		http.Error(w, "No flashcards selected", http.StatusBadRequest)
+		apierror.Write(w, apierror.BadRequest("No flashcards selected"))
 		return
 	}
 
-	flashcards, err := getSelectedFlashcards(req.FlashcardIDs)
+	var seed *int64
+	if req.Shuffle {
+		s := newSeed()
+		seed = &s
+	}
+	g.startGuestGame(w, r, req.FlashcardIDs, seed)
+}
+
+// startGuestGame starts a guest game of the cards with the given IDs,
+// shuffled with seed unless it is nil.
+func (g *Game) startGuestGame(w http.ResponseWriter, r *http.Request, flashcardIDs []int, seed *int64) {
+	flashcards, err := g.store.SelectedFlashcards(r.Context(), flashcardIDs)
 	if err != nil {
 		log.Printf("Error getting selected flashcards: %v", err)
-		http.Error(w, "Error loading flashcards", http.StatusInternalServerError)
+		apierror.Write(w, apierror.Internal("Error loading flashcards"))
 		return
 	}
 
 	if len(flashcards) == 0 {
-		http.Error(w, "No valid flashcards found", http.StatusNotFound)
+		apierror.Write(w, apierror.NotFound("No valid flashcards found"))
 		return
 	}
 
+	if seed != nil {
+		flashcards = shuffleCards(flashcards, *seed)
+	}
 	session := createGuestGameSession(flashcards)
+	session.Seed = seed
 	sessionID := generateGuestSessionID()
 	storeGameSession(sessionID, session)
 
 	response := buildStartGameResponse(sessionID, flashcards)
+	if seed != nil {
+		response["seed"] = *seed
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
-func SubmitAnswerHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+func (g *Game) SubmitAnswerHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	sessionID, err := getSessionID(r)
 	if err != nil {
-		http.Error(w, "Session ID required", http.StatusBadRequest)
+		apierror.Write(w, apierror.Validation("Session ID required"))
 		return
 	}
 
 	session, err := getGameSession(sessionID)
 	if err != nil {
-		http.Error(w, "Invalid session", http.StatusBadRequest)
+		apierror.Write(w, apierror.BadRequest("Invalid session"))
 		return
 	}
 
 	var req AnswerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		apierror.Write(w, apierror.DecodeError(err))
 		return
 	}
+	if req.HintsUsed < 0 {
+		apierror.Write(w, apierror.Validation("hints_used must not be negative"))
+		return
+	}
+	user, _ := g.currentUser(r)
 
+	// Answers from the owner's devices are applied one at a time; the
+	// first to arrive for a card wins and later ones get a conflict.
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.AccountID != 0 && (user == nil || user.ID != session.AccountID) {
+		apierror.Write(w, apierror.BadRequest("Invalid session"))
+		return
+	}
 	if err := validateGameInProgress(session); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, apierror.BadRequest(err.Error()))
+		return
+	}
+	if session.PausedAt != nil {
+		apierror.Write(w, apierror.Conflict("The game is paused; resume it to answer").
+			WithDetails(newSessionState(sessionID, session, nil)))
 		return
 	}
 
 	currentCard := session.Flashcards[session.CurrentIndex]
+	if session.AccountID != 0 && req.FlashcardID != 0 && req.FlashcardID != currentCard.ID {
+		apierror.Write(w, apierror.Conflict("This card was already answered on another device").
+			WithDetails(newSessionState(sessionID, session, nil)))
+		return
+	}
 	isCorrect := checkAnswer(req.Answer, currentCard.Answer)
+	var challenge *ChallengeResult
+	if currentCard.Challenge != nil {
+		// The answer is code, correct when it passes the tests.
+		challenge, err = runChallenge(r.Context(), currentCard.Challenge, req.Answer)
+		if err != nil {
+			log.Printf("Failed to run challenge of card %d: %v", currentCard.ID, err)
+			apierror.Write(w, apierror.Unavailable("The code runner is busy; try again shortly"))
+			return
+		}
+		isCorrect = challenge.Passed
+	}
 
+	if isCorrect {
+		session.Streak++
+	} else {
+		session.Streak = 0
+	}
 	score := createScoreResult(currentCard.ID, req.TimeScore, isCorrect)
+	score.HintsUsed = req.HintsUsed
+	score.Flags = session.flagAnswer(currentCard, req.Answer, isCorrect, time.Now())
+	score.Points = sessionRules(session).score(isCorrect, session.Streak, req.TimeScore, currentCard.Time, req.HintsUsed)
 	session.Scores = append(session.Scores, score)
 
-	saveScoreIfLoggedIn(r, score)
+	g.saveScore(r.Context(), user, score)
 	session.CurrentIndex++
+	session.Version++
 
 	response := buildAnswerResponse(isCorrect, currentCard.Answer, session, sessionID)
+	response.Challenge = challenge
+	if response.GameComplete {
+		g.recordGamePlayed(r.Context(), user, session, response.FinalScore)
+	}
+	publishState(sessionID, session, &LastAnswer{
+		FlashcardID:   currentCard.ID,
+		Correct:       isCorrect,
+		CorrectAnswer: currentCard.Answer,
+		Points:        score.Points.Total,
+	})
 	json.NewEncoder(w).Encode(response)
 }
 
 func getAllCourses() ([]Course, error) {
-	query := "SELECT id, name, description FROM courses ORDER BY name"
+	// Drafts stay unlisted until they are marked ready.
+	query := "SELECT id, name, description FROM courses WHERE NOT draft ORDER BY name"
 	rows, err := db.DB.Query(query)
 	if err != nil {
 		return nil, err
@@ -278,7 +411,7 @@ func getAllCourses() ([]Course, error) {
 
 func getFlashcardsByCourse(courseID int) ([]Flashcard, error) {
 	query := `
-		SELECT f.id, f.question, f.answer, f.time 
+		SELECT f.id, f.question, f.answer, f.time, f.occlusion, f.distractors, f.challenge
 		FROM flashcards f
 		JOIN course_flashcards cf ON f.id = cf.flashcard_id
 		WHERE cf.course_id = $1
@@ -294,10 +427,24 @@ func getFlashcardsByCourse(courseID int) ([]Flashcard, error) {
 	var flashcards []Flashcard
 	for rows.Next() {
 		var card Flashcard
-		err := rows.Scan(&card.ID, &card.Question, &card.Answer, &card.Time)
+		var occlusion []byte
+		var distractors pq.StringArray
+		var challenge []byte
+		err := rows.Scan(&card.ID, &card.Question, &card.Answer, &card.Time, &occlusion, &distractors, &challenge)
 		if err != nil {
 			return nil, err
 		}
+		if challenge != nil {
+			if err := json.Unmarshal(challenge, &card.Challenge); err != nil {
+				return nil, fmt.Errorf("card %d: invalid challenge: %w", card.ID, err)
+			}
+		}
+		card.Choices = choicesFor(card.Answer, distractors)
+		if occlusion != nil {
+			if err := json.Unmarshal(occlusion, &card.Occlusion); err != nil {
+				return nil, fmt.Errorf("card %d: invalid occlusion: %w", card.ID, err)
+			}
+		}
 		flashcards = append(flashcards, card)
 	}
 
@@ -342,10 +489,10 @@ func checkAnswer(userAnswer, correctAnswer string) bool {
 
 func saveScore(accountID int, score ScoreResult) error {
 	query := `
-		INSERT INTO account_score (account_id, flashcard_id, time_score, correct_answer) 
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO account_score (account_id, flashcard_id, time_score, correct_answer, flags) 
+		VALUES ($1, $2, $3, $4, $5)
 	`
-	_, err := db.DB.Exec(query, accountID, score.FlashcardID, score.TimeScore, score.CorrectAnswer)
+	_, err := db.DB.Exec(query, accountID, score.FlashcardID, score.TimeScore, score.CorrectAnswer, pq.Array(flagsOrEmpty(score.Flags)))
 	return err
 }
 
@@ -355,16 +502,19 @@ func parseCourseID(r *http.Request) (int, error) {
 	return strconv.Atoi(courseIDStr)
 }
 
-func validateAndGetFlashcards(courseID int) ([]Flashcard, error) {
-	flashcards, err := getFlashcardsByCourse(courseID)
+// errNoFlashcards is returned when a course has no cards to play.
+var errNoFlashcards = errors.New("no flashcards found")
+
+func validateAndGetFlashcards(ctx context.Context, store Store, courseID int) ([]Flashcard, error) {
+	flashcards, err := store.CourseFlashcards(ctx, courseID)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	if len(flashcards) == 0 {
-		return nil, fmt.Errorf("no flashcards found")
+		return nil, errNoFlashcards
 	}
-	
+
 	return flashcards, nil
 }
 
@@ -436,9 +586,40 @@ func getSelectedFlashcards(flashcardIDs []int) ([]Flashcard, error) {
 }
 
 func storeGameSession(sessionID string, session *GameSession) {
+	gameSessionsMu.Lock()
+	defer gameSessionsMu.Unlock()
 	gameSessions[sessionID] = session
 }
 
+func deleteGameSession(sessionID string) {
+	gameSessionsMu.Lock()
+	defer gameSessionsMu.Unlock()
+	delete(gameSessions, sessionID)
+}
+
+// PruneSessions drops in-memory game sessions that players abandoned
+// without finishing: those played for more than maxAge, not counting
+// pauses, or paused for more than maxAge. It returns how many were removed.
+func PruneSessions(maxAge time.Duration) int {
+	now := time.Now()
+
+	gameSessionsMu.Lock()
+	defer gameSessionsMu.Unlock()
+
+	pruned := 0
+	for sessionID, session := range gameSessions {
+		session.mu.Lock()
+		abandoned := session.playTime(now) > maxAge ||
+			(session.PausedAt != nil && now.Sub(*session.PausedAt) > maxAge)
+		session.mu.Unlock()
+		if abandoned {
+			delete(gameSessions, sessionID)
+			pruned++
+		}
+	}
+	return pruned
+}
+
 func buildStartGameResponse(sessionID string, flashcards []Flashcard) map[string]interface{} {
 	return map[string]interface{}{
 		"session_id":      sessionID,
@@ -458,6 +639,8 @@ func getSessionID(r *http.Request) (string, error) {
 }
 
 func getGameSession(sessionID string) (*GameSession, error) {
+	gameSessionsMu.Lock()
+	defer gameSessionsMu.Unlock()
 	session, exists := gameSessions[sessionID]
 	if !exists {
 		return nil, fmt.Errorf("invalid session")
@@ -480,10 +663,33 @@ func createScoreResult(flashcardID, timeScore int, isCorrect bool) ScoreResult {
 	}
 }
 
-func saveScoreIfLoggedIn(r *http.Request, score ScoreResult) {
-	user, _ := login.GetCurrentUser(r)
+// recordGamePlayed logs a finished game for the admin statistics. Guest
+// games are recorded without an account.
+func (g *Game) recordGamePlayed(ctx context.Context, user *login.User, session *GameSession, final *FinalScore) {
+	if final == nil {
+		return
+	}
+	game := PlayedGame{
+		CourseID:       session.CourseID,
+		TotalQuestions: final.TotalQuestions,
+		CorrectAnswers: final.CorrectAnswers,
+		Flags:          runFlags(session.Scores),
+	}
 	if user != nil {
-		saveScore(user.ID, score)
+		game.AccountID = user.ID
+	}
+	if err := g.store.RecordGame(ctx, game); err != nil {
+		log.Printf("Failed to record finished game: %v", err)
+	}
+}
+
+// saveScore keeps the score of a signed-in player; guests' are not kept.
+func (g *Game) saveScore(ctx context.Context, user *login.User, score ScoreResult) {
+	if user == nil {
+		return
+	}
+	if err := g.store.SaveScore(ctx, user.ID, score); err != nil {
+		log.Printf("Failed to save score of account %d: %v", user.ID, err)
 	}
 }
 
@@ -491,13 +697,19 @@ func buildAnswerResponse(isCorrect bool, correctAnswer string, session *GameSess
 	response := AnswerResponse{
 		Correct:       isCorrect,
 		CorrectAnswer: correctAnswer,
+		Version:       session.Version,
+		Streak:        session.Streak,
+	}
+	if n := len(session.Scores); n > 0 {
+		response.Points = session.Scores[n-1].Points
 	}
 
 	if session.CurrentIndex >= len(session.Flashcards) {
 		// Game complete
 		response.GameComplete = true
 		response.FinalScore = calculateFinalScore(session.Scores)
-		delete(gameSessions, sessionID)
+		response.FinalScore.Seed = session.Seed
+		deleteGameSession(sessionID)
 	} else {
 		// Next question
 		response.NextCard = &session.Flashcards[session.CurrentIndex]
@@ -539,6 +751,19 @@ func calculateAccuracyPercent(correct int, total int) float64 {
 	return (float64(correct) / float64(total)) * 100
 }
 
+func longestStreak(scores []ScoreResult) int {
+	longest, run := 0, 0
+	for _, score := range scores {
+		if score.CorrectAnswer {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
+
 func calculateFinalScore(scores []ScoreResult) *FinalScore {
 	if len(scores) == 0 {
 		return &FinalScore{}
@@ -549,11 +774,93 @@ func calculateFinalScore(scores []ScoreResult) *FinalScore {
 	avgTime := calculateAverageTime(totalTime, len(scores))
 	accuracy := calculateAccuracyPercent(correct, len(scores))
 
-	return &FinalScore{
+	final := &FinalScore{
 		TotalQuestions:  len(scores),
 		CorrectAnswers:  correct,
 		AverageTime:     avgTime,
 		TotalTime:       totalTime,
 		AccuracyPercent: accuracy,
+		LongestStreak:   longestStreak(scores),
+	}
+	for _, score := range scores {
+		final.Breakdown.add(score.Points)
+	}
+	final.Points = final.Breakdown.Total
+	return final
+}
+
+// FlushSessions persists all in-progress game sessions so they survive a
+// restart. It is called during graceful shutdown.
+func FlushSessions() error {
+	gameSessionsMu.Lock()
+	defer gameSessionsMu.Unlock()
+
+	if len(gameSessions) == 0 {
+		return nil
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin session flush: %w", err)
+	}
+
+	query := `
+		INSERT INTO game_sessions (session_id, data, updated_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (session_id)
+		DO UPDATE SET data = EXCLUDED.data, updated_at = CURRENT_TIMESTAMP
+	`
+	for sessionID, session := range gameSessions {
+		data, err := json.Marshal(session)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to encode session %s: %w", sessionID, err)
+		}
+		if _, err := tx.Exec(query, sessionID, string(data)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to flush session %s: %w", sessionID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit session flush: %w", err)
+	}
+
+	log.Printf("Flushed %d game sessions", len(gameSessions))
+	return nil
+}
+
+// RestoreSessions loads game sessions flushed by a previous shutdown back into
+// memory and removes them from the table.
+func RestoreSessions() error {
+	rows, err := db.DB.Query("SELECT session_id, data FROM game_sessions")
+	if err != nil {
+		return fmt.Errorf("failed to load game sessions: %w", err)
+	}
+	defer rows.Close()
+
+	restored := 0
+	for rows.Next() {
+		var sessionID, data string
+		if err := rows.Scan(&sessionID, &data); err != nil {
+			return err
+		}
+		var session GameSession
+		if err := json.Unmarshal([]byte(data), &session); err != nil {
+			log.Printf("Skipping unreadable game session %s: %v", sessionID, err)
+			continue
+		}
+		storeGameSession(sessionID, &session)
+		restored++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := db.DB.Exec("DELETE FROM game_sessions"); err != nil {
+		return fmt.Errorf("failed to clear game sessions: %w", err)
 	}
-}
\ No newline at end of file
+
+	log.Printf("Restored %d game sessions", restored)
+	return nil
+}
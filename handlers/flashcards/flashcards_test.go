@@ -1,11 +1,30 @@
 package flashcards
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"allanswebterminal/cache"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/operations"
+	"allanswebterminal/llm"
+	"allanswebterminal/ocr"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
 )
 
 func TestParseCourseID(t *testing.T) {
@@ -43,16 +62,26 @@ func TestParseCourseID(t *testing.T) {
 }
 
 func TestValidateAndGetFlashcards(t *testing.T) {
-	t.Skip("Skipping database-dependent test - requires database setup")
-	// Note: This test would require database setup in a real scenario
-	// For now, we'll test the logic with mocked data
-	
+	store := &MemoryStore{Cards: map[int][]Flashcard{1: {{ID: 1, Question: "Q1", Answer: "A1"}}}}
+
 	t.Run("Empty flashcards", func(t *testing.T) {
-		// This would normally call the database
-		// For testing, we can mock this or use a test database
-		_, err := validateAndGetFlashcards(999) // Non-existent course
-		if err == nil {
-			t.Errorf("Expected error for non-existent course")
+		_, err := validateAndGetFlashcards(context.Background(), store, 999) // Non-existent course
+		if !errors.Is(err, errNoFlashcards) {
+			t.Errorf("Expected errNoFlashcards for non-existent course, got %v", err)
+		}
+	})
+
+	t.Run("Course with flashcards", func(t *testing.T) {
+		cards, err := validateAndGetFlashcards(context.Background(), store, 1)
+		if err != nil || len(cards) != 1 || cards[0].ID != 1 {
+			t.Errorf("got %v, %v", cards, err)
+		}
+	})
+
+	t.Run("Store error", func(t *testing.T) {
+		failing := &MemoryStore{Err: errors.New("connection refused")}
+		if _, err := validateAndGetFlashcards(context.Background(), failing, 1); err == nil || errors.Is(err, errNoFlashcards) {
+			t.Errorf("Expected the store error, got %v", err)
 		}
 	})
 }
@@ -155,7 +184,7 @@ func TestBuildStartGameResponse(t *testing.T) {
 	if response["total_questions"] != len(flashcards) {
 		t.Errorf("Expected total_questions %d, got %v", len(flashcards), response["total_questions"])
 	}
-	if response["first_card"] != flashcards[0] {
+	if !reflect.DeepEqual(response["first_card"], flashcards[0]) {
 		t.Errorf("Expected first_card to be first flashcard")
 	}
 }
@@ -386,4 +415,1833 @@ func TestCheckAnswer(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestFlushAndRestoreSessions(t *testing.T) {
+	originalDB := db.DB
+	defer func() {
+		db.DB = originalDB
+	}()
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer mockDB.Close()
+	db.DB = mockDB
+
+	sessionID := "flush_session_1"
+	storeGameSession(sessionID, &GameSession{
+		CourseID:   7,
+		Flashcards: []Flashcard{{ID: 1, Question: "Q1", Answer: "A1", Time: 30}},
+		Scores:     []ScoreResult{},
+	})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO game_sessions").
+		WithArgs(sessionID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := FlushSessions(); err != nil {
+		t.Fatalf("FlushSessions failed: %v", err)
+	}
+
+	deleteGameSession(sessionID)
+
+	rows := sqlmock.NewRows([]string{"session_id", "data"}).
+		AddRow(sessionID, `{"course_id":7,"current_index":0,"flashcards":[{"id":1,"question":"Q1","answer":"A1","time":30}],"scores":[]}`)
+	mock.ExpectQuery("SELECT session_id, data FROM game_sessions").WillReturnRows(rows)
+	mock.ExpectExec("DELETE FROM game_sessions").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := RestoreSessions(); err != nil {
+		t.Fatalf("RestoreSessions failed: %v", err)
+	}
+
+	restored, err := getGameSession(sessionID)
+	if err != nil {
+		t.Fatalf("Expected restored session, got error: %v", err)
+	}
+	if restored.CourseID != 7 || len(restored.Flashcards) != 1 {
+		t.Errorf("Restored session mismatch: %+v", restored)
+	}
+	deleteGameSession(sessionID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Mock expectations not met: %v", err)
+	}
+}
+
+func TestValidateImportDeck(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ImportDeckRequest
+		wantErr bool
+	}{
+		{"Valid deck", ImportDeckRequest{Name: "Go", Cards: []Flashcard{{Question: "q", Answer: "a"}}}, false},
+		{"Missing name", ImportDeckRequest{Name: "  ", Cards: []Flashcard{{Question: "q", Answer: "a"}}}, true},
+		{"No cards", ImportDeckRequest{Name: "Go"}, true},
+		{"Blank answer", ImportDeckRequest{Name: "Go", Cards: []Flashcard{{Question: "q"}}}, true},
+		{"Too many cards", ImportDeckRequest{Name: "Go", Cards: make([]Flashcard, maxImportCards+1)}, true},
+		{"Only markup", ImportDeckRequest{Name: "Go", Cards: []Flashcard{{Question: "<img src=x onerror=alert(1)>", Answer: "a"}}}, true},
+		{"Unsafe math", ImportDeckRequest{Name: "Go", Cards: []Flashcard{{Question: `$\href{javascript:x}{y}$`, Answer: "a"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateImportDeck(&tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateImportDeck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.req.Cards[0].Time != defaultCardTime {
+				t.Errorf("expected default card time, got %d", tt.req.Cards[0].Time)
+			}
+		})
+	}
+}
+
+func TestValidateImportDeckCleansCards(t *testing.T) {
+	req := ImportDeckRequest{Name: "Go", Cards: []Flashcard{{Question: "<b>What is</b> $x^2$?", Answer: " 4 "}}}
+	if err := validateImportDeck(&req); err != nil {
+		t.Fatal(err)
+	}
+	if card := req.Cards[0]; card.Question != "What is $x^2$?" || card.Answer != "4" {
+		t.Errorf("card = %+v", card)
+	}
+}
+
+func TestImportDeck(t *testing.T) {
+	originalDB := db.DB
+	defer func() {
+		db.DB = originalDB
+	}()
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer mockDB.Close()
+	db.DB = mockDB
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO courses").WithArgs("Go", "", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("INSERT INTO flashcards").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10 + i))
+		mock.ExpectExec("INSERT INTO course_flashcards").WithArgs(3, 10+i, i).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+	mock.ExpectQuery("INSERT INTO notifications").
+		WithArgs(7, "job_finished", "Deck import finished", sqlmock.AnyArg(), "/flashcards").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+
+	op := operations.Start("deck_import", 7)
+	importDeck(op, 7, ImportDeckRequest{Name: "Go", Cards: []Flashcard{
+		{Question: "q1", Answer: "a1", Time: 30},
+		{Question: "q2", Answer: "a2", Time: 30},
+	}})
+
+	if !op.Done() {
+		t.Error("expected import operation to be finished")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPruneSessions(t *testing.T) {
+	storeGameSession("stale", &GameSession{StartTime: time.Now().Add(-48 * time.Hour)})
+	storeGameSession("fresh", &GameSession{StartTime: time.Now()})
+	defer deleteGameSession("fresh")
+
+	if pruned := PruneSessions(24 * time.Hour); pruned < 1 {
+		t.Errorf("expected stale session to be pruned, pruned %d", pruned)
+	}
+	if _, err := getGameSession("stale"); err == nil {
+		t.Error("stale session still present")
+	}
+	if _, err := getGameSession("fresh"); err != nil {
+		t.Error("fresh session was pruned")
+	}
+}
+
+func TestCoursesAPIHandlerUsesCache(t *testing.T) {
+	originalDB := db.DB
+	defer func() {
+		db.DB = originalDB
+	}()
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer mockDB.Close()
+	db.DB = mockDB
+
+	cache.Invalidate(context.Background(), coursesCacheKey)
+	defer cache.Invalidate(context.Background(), coursesCacheKey)
+
+	mock.ExpectQuery("SELECT id, name, description FROM courses").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description"}).AddRow(1, "Go", "Basics"))
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		NewGame(DBStore()).CoursesAPIHandler(rec, httptest.NewRequest("GET", "/api/flashcards/courses", nil))
+		if !strings.Contains(rec.Body.String(), `"name":"Go"`) {
+			t.Fatalf("request %d: unexpected body %s", i, rec.Body.String())
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expected a single query, got: %v", err)
+	}
+
+	invalidateCourse(context.Background(), 1)
+	mock.ExpectQuery("SELECT id, name, description FROM courses").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description"}))
+	NewGame(DBStore()).CoursesAPIHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/flashcards/courses", nil))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expected a reload after invalidation, got: %v", err)
+	}
+}
+
+func setupGalleryMock(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+func galleryRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	req.SetPathValue("id", "3")
+	return req
+}
+
+func expectGalleryUser(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "user"))
+}
+
+func TestPublishDeckRunsModerators(t *testing.T) {
+	mock := setupGalleryMock(t)
+	RegisterModerator(func(ctx context.Context, deck DeckSubmission) error {
+		if strings.Contains(deck.Cards[0].Question, "spam") {
+			return errors.New("looks like spam")
+		}
+		return nil
+	})
+	t.Cleanup(func() { moderators = nil })
+
+	expectGalleryUser(mock)
+	mock.ExpectQuery("SELECT account_id, name").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "name", "description", "draft"}).AddRow(7, "Go", "", false))
+	mock.ExpectQuery("SELECT f.id, f.question").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "question", "answer", "time", "occlusion", "distractors", "challenge"}).AddRow(1, "buy spam", "no", 30, nil, nil, nil))
+
+	rec := httptest.NewRecorder()
+	PublishDeckHandler(rec, galleryRequest("PUT", "/api/flashcards/gallery/3", `{"category":"programming"}`))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "looks like spam") {
+		t.Errorf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPublishDeckRequiresOwner(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	mock.ExpectQuery("SELECT account_id, name").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "name", "description", "draft"}).AddRow(8, "Go", "", false))
+
+	rec := httptest.NewRecorder()
+	PublishDeckHandler(rec, galleryRequest("PUT", "/api/flashcards/gallery/3", `{"category":"programming"}`))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestPublishDeckRefusesDrafts(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	mock.ExpectQuery("SELECT account_id, name").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "name", "description", "draft"}).AddRow(7, "Go", "", true))
+
+	rec := httptest.NewRecorder()
+	PublishDeckHandler(rec, galleryRequest("PUT", "/api/flashcards/gallery/3", `{"category":"programming"}`))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", rec.Code)
+	}
+}
+
+func TestGenerateCardsHandler(t *testing.T) {
+	mock := setupGalleryMock(t)
+	provider := &llm.Mock{Reply: "Here you go:\n```json\n" +
+		`{"cards": [{"question": "What does go vet do?", "answer": "Reports suspicious code"}, {"question": "", "answer": "dropped"}]}` +
+		"\n```"}
+	llm.SetProvider(provider)
+	t.Cleanup(func() { llm.SetProvider(nil) })
+
+	expectGalleryUser(mock)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO courses").WithArgs("Vet notes", "", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectQuery("INSERT INTO flashcards").WithArgs("What does go vet do?", "Reports suspicious code", defaultCardTime).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	mock.ExpectExec("INSERT INTO course_flashcards").WithArgs(9, 20, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := httptest.NewRecorder()
+	GenerateCardsHandler(rec, galleryRequest("POST", "/api/flashcards/generate",
+		`{"notes":"go vet reports suspicious code","name":"Vet notes","count":3}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var result GenerateCardsResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result.CourseID != 9 || !result.Draft || len(result.Cards) != 1 || result.Cards[0].ID != 20 {
+		t.Errorf("result = %+v", result)
+	}
+	requests := provider.Requests()
+	if len(requests) != 1 || !strings.Contains(requests[0].Messages[0].Content, "go vet reports suspicious code") {
+		t.Errorf("provider requests = %+v", requests)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGenerateCardsHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider llm.Provider
+		body     string
+		status   int
+	}{
+		{"not configured", nil, `{"notes":"n"}`, http.StatusServiceUnavailable},
+		{"both sources", &llm.Mock{}, `{"notes":"n","filename":"a.txt"}`, http.StatusBadRequest},
+		{"no source", &llm.Mock{}, `{}`, http.StatusBadRequest},
+		{"provider fails", &llm.Mock{Err: errors.New("boom")}, `{"notes":"n"}`, http.StatusServiceUnavailable},
+		{"unparseable reply", &llm.Mock{Reply: "sorry"}, `{"notes":"n"}`, http.StatusServiceUnavailable},
+		{"no usable cards", &llm.Mock{Reply: `{"cards":[]}`}, `{"notes":"n"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupGalleryMock(t)
+			llm.SetProvider(tt.provider)
+			t.Cleanup(func() { llm.SetProvider(nil) })
+			expectGalleryUser(mock)
+
+			rec := httptest.NewRecorder()
+			GenerateCardsHandler(rec, galleryRequest("POST", "/api/flashcards/generate", tt.body))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRateDeckRejectsOwnDeck(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	mock.ExpectQuery("SELECT account_id FROM deck_listings").WithArgs(3, "published").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id"}).AddRow(7))
+
+	rec := httptest.NewRecorder()
+	RateDeckHandler(rec, galleryRequest("PUT", "/api/flashcards/gallery/3/rating", `{"rating":5}`))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestCloneDeck(t *testing.T) {
+	mock := setupGalleryMock(t)
+	mock.ExpectQuery("SELECT f.id, f.question").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "question", "answer", "time", "occlusion", "distractors", "challenge"}).AddRow(1, "q", "a", 30, nil, nil, nil))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO courses").WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectQuery("INSERT INTO flashcards").WithArgs("q", "a", 30, nil, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	mock.ExpectExec("INSERT INTO course_flashcards").WithArgs(9, 20, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE deck_listings SET downloads").WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	cloneID, cards, err := cloneDeck(context.Background(), 3, 7)
+	if err != nil || cloneID != 9 || cards != 1 {
+		t.Errorf("cloneDeck = %d, %d, %v", cloneID, cards, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGalleryFilter(t *testing.T) {
+	where, args := galleryFilter(7, "cloud", "s3")
+	// Decks of suspended authors are hidden.
+	if where != "l.status = $2 AND "+login.NotSuspended("l.account_id")+" AND l.category = $3 AND (c.name ILIKE $4 OR c.description ILIKE $4)" {
+		t.Errorf("where = %q", where)
+	}
+	if len(args) != 4 || args[0] != 7 || args[3] != "%s3%" {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestPublicDeckHandler(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	defer func() {
+		db.DB = originalDB
+		mockDB.Close()
+	}()
+	cache.Invalidate(context.Background(), courseCardsCacheKey(42))
+	defer cache.Invalidate(context.Background(), courseCardsCacheKey(42))
+
+	mock.ExpectQuery("FROM deck_listings l").WithArgs(0, listingPublished, 42, 1, 0).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"course_id", "name", "description", "username", "category", "cards", "stars", "downloads",
+			"rating", "ratings", "starred", "my_rating", "published_at",
+		}).AddRow(42, "AWS basics", "", "ana", "cloud", 2, 5, 1, 4.5, 2, false, 0, time.Now()))
+	mock.ExpectQuery("SELECT f.id, f.question, f.answer, f.time, f.occlusion").WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "question", "answer", "time", "occlusion", "distractors", "challenge"}).
+			AddRow(1, "What is S3?", "Object storage", 30, nil, nil, nil).
+			AddRow(2, "Label the diagram", "", 30, []byte(`{"media_id": 1}`), nil, nil))
+
+	req := httptest.NewRequest("GET", "/api/public/v1/decks/42", nil)
+	req.SetPathValue("id", "42")
+	rr := httptest.NewRecorder()
+	PublicDeckHandler(rr, req)
+
+	var deck PublicDeck
+	json.NewDecoder(rr.Body).Decode(&deck)
+	if rr.Code != http.StatusOK || deck.ID != 42 || deck.Author != "ana" || len(deck.Cards) != 1 || deck.Cards[0].Answer != "Object storage" {
+		t.Errorf("status = %d, deck %+v", rr.Code, deck)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+type recordingPublisher struct {
+	topics []string
+	events []interface{}
+}
+
+func (p *recordingPublisher) Publish(topic string, data interface{}) int {
+	p.topics = append(p.topics, topic)
+	p.events = append(p.events, data)
+	return 1
+}
+
+func answerRequest(sessionID, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/api/flashcards/answer?session_id="+sessionID, strings.NewReader(body))
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	return req
+}
+
+func TestSubmitAnswerSyncsDevices(t *testing.T) {
+	mock := setupGalleryMock(t)
+	pub := &recordingPublisher{}
+	SetPublisher(pub)
+	t.Cleanup(func() { SetPublisher(nil) })
+
+	sessionID := "session_sync_test"
+	storeGameSession(sessionID, &GameSession{
+		CourseID:   1,
+		AccountID:  7,
+		Flashcards: []Flashcard{{ID: 1, Question: "Q1", Answer: "A1"}, {ID: 2, Question: "Q2", Answer: "A2"}},
+		StartTime:  time.Now(),
+	})
+	t.Cleanup(func() { deleteGameSession(sessionID) })
+
+	// Device one answers card 1.
+	game := NewGame(DBStore())
+	expectGalleryUser(mock)
+	mock.ExpectExec("INSERT INTO account_score").WithArgs(7, 1, 5, true, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	rec := httptest.NewRecorder()
+	game.SubmitAnswerHandler(rec, answerRequest(sessionID, `{"flashcard_id":1,"answer":"A1","time_score":5}`))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"version":1`) {
+		t.Fatalf("first answer: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if len(pub.topics) != 1 || pub.topics[0] != "account:7" {
+		t.Fatalf("published to %v, want [account:7]", pub.topics)
+	}
+	state := pub.events[0].(map[string]interface{})["state"].(SessionState)
+	if state.Version != 1 || state.CurrentCard == nil || state.CurrentCard.ID != 2 || state.LastAnswer == nil || !state.LastAnswer.Correct {
+		t.Errorf("published state = %+v", state)
+	}
+
+	// Device two, still showing card 1, loses: the server state wins.
+	expectGalleryUser(mock)
+	rec = httptest.NewRecorder()
+	game.SubmitAnswerHandler(rec, answerRequest(sessionID, `{"flashcard_id":1,"answer":"late","time_score":9}`))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"current_index":1`) {
+		t.Errorf("stale answer: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if len(pub.topics) != 1 {
+		t.Errorf("a rejected answer must not be broadcast")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSubmitAnswerRejectsOtherAccounts(t *testing.T) {
+	store := &MemoryStore{}
+	sessionID := "session_owner_test"
+	storeGameSession(sessionID, &GameSession{
+		AccountID:  8,
+		Flashcards: []Flashcard{{ID: 1, Question: "Q1", Answer: "A1"}},
+		StartTime:  time.Now(),
+	})
+	t.Cleanup(func() { deleteGameSession(sessionID) })
+
+	for name, game := range map[string]*Game{
+		"other account": testGame(store, &login.User{ID: 7, Username: "ana"}),
+		"guest":         testGame(store, nil),
+	} {
+		rec := httptest.NewRecorder()
+		game.SubmitAnswerHandler(rec, answerRequest(sessionID, `{"flashcard_id":1,"answer":"A1"}`))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, rec.Code)
+		}
+	}
+	if len(store.Scores) != 0 || len(store.Games) != 0 {
+		t.Errorf("a rejected answer was stored: %v, %v", store.Scores, store.Games)
+	}
+}
+
+// testGame returns a Game over store played by user, or by a guest when
+// user is nil.
+func testGame(store Store, user *login.User) *Game {
+	game := NewGame(store)
+	game.currentUser = func(*http.Request) (*login.User, error) {
+		if user == nil {
+			return nil, errors.New("not logged in")
+		}
+		return user, nil
+	}
+	return game
+}
+
+type startedGame struct {
+	SessionID      string `json:"session_id"`
+	TotalQuestions int    `json:"total_questions"`
+}
+
+func TestGamePlaysThroughWithoutDatabase(t *testing.T) {
+	store := &MemoryStore{
+		CourseList: []Course{{ID: 2, Name: "Linux"}, {ID: 1, Name: "AWS"}},
+		Cards: map[int][]Flashcard{1: {
+			{ID: 1, Question: "What is S3?", Answer: "Object storage", Time: 30},
+			{ID: 2, Question: "What is EC2?", Answer: "Compute", Time: 30},
+		}},
+		Rules: map[int]ScoringRules{1: {BasePoints: 5, TimeBonus: TimeBonus{Curve: CurveNone}}},
+	}
+	game := testGame(store, &login.User{ID: 7, Username: "ana"})
+
+	rec := httptest.NewRecorder()
+	game.CoursesAPIHandler(rec, httptest.NewRequest("GET", "/api/flashcards/courses", nil))
+	var courses []Course
+	json.NewDecoder(rec.Body).Decode(&courses)
+	if len(courses) != 2 || courses[0].Name != "AWS" {
+		t.Fatalf("courses = %+v", courses)
+	}
+
+	rec = httptest.NewRecorder()
+	game.StartGameHandler(rec, httptest.NewRequest("POST", "/api/flashcards/start?course_id=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("start: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var started startedGame
+	json.NewDecoder(rec.Body).Decode(&started)
+	t.Cleanup(func() { deleteGameSession(started.SessionID) })
+	// Take a human amount of time over the first card.
+	session, _ := getGameSession(started.SessionID)
+	session.StartTime = session.StartTime.Add(-10 * time.Second)
+
+	answer := func(body string) AnswerResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		game.SubmitAnswerHandler(rec, answerRequest(started.SessionID, body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("answer: status %d, body %s", rec.Code, rec.Body.String())
+		}
+		var resp AnswerResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+	answer(`{"flashcard_id":1,"answer":"Object storage","time_score":4}`)
+	last := answer(`{"flashcard_id":2,"answer":"Storage","time_score":6}`)
+	if last.FinalScore == nil || last.FinalScore.CorrectAnswers != 1 || last.FinalScore.Points != 5 {
+		t.Fatalf("final score = %+v", last.FinalScore)
+	}
+
+	if scores := store.Scores[7]; len(scores) != 2 || !scores[0].CorrectAnswer || scores[1].CorrectAnswer {
+		t.Errorf("saved scores = %+v", store.Scores)
+	}
+	want := []PlayedGame{{AccountID: 7, CourseID: 1, TotalQuestions: 2, CorrectAnswers: 1}}
+	if !reflect.DeepEqual(store.Games, want) {
+		t.Errorf("recorded games = %+v, want %+v", store.Games, want)
+	}
+
+	rec = httptest.NewRecorder()
+	game.StartGameHandler(rec, httptest.NewRequest("POST", "/api/flashcards/start?course_id=3", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("course without cards: status %d, want 404", rec.Code)
+	}
+}
+
+func TestStartGuestGameUsesSelectedCards(t *testing.T) {
+	store := &MemoryStore{Guest: []Flashcard{
+		{ID: 4, Question: "Q4", Answer: "A4"},
+		{ID: 5, Question: "Q5", Answer: "A5"},
+		{ID: 6, Question: "Q6", Answer: "A6"},
+	}}
+	game := testGame(store, nil)
+
+	rec := httptest.NewRecorder()
+	game.StartGuestGameHandler(rec, httptest.NewRequest("POST", "/api/flashcards/start-guest", strings.NewReader(`{"flashcard_ids":[6,4,99]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body.String())
+	}
+	var started startedGame
+	json.NewDecoder(rec.Body).Decode(&started)
+	t.Cleanup(func() { deleteGameSession(started.SessionID) })
+	if started.TotalQuestions != 2 {
+		t.Errorf("total questions = %d, want 2", started.TotalQuestions)
+	}
+
+	store.Err = errors.New("connection refused")
+	rec = httptest.NewRecorder()
+	game.StartGuestGameHandler(rec, httptest.NewRequest("POST", "/api/flashcards/start-guest", strings.NewReader(`{"flashcard_ids":[4]}`)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("store error: status %d, want 500", rec.Code)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	cards := []Flashcard{
+		{ID: 1, Question: "What does S3 stand for?", Answer: "Simple Storage Service"},
+		{ID: 2, Question: "what does s3 stand for", Answer: "simple storage service."},
+		{ID: 3, Question: "What does EC2 stand for?", Answer: "Elastic Compute Cloud"},
+		{ID: 4, Question: "What does S3 stand for ?", Answer: "A database"},
+		{ID: 5, Question: "What  does S3 stand for?!", Answer: "Simple Storage Service"},
+	}
+
+	groups := findDuplicates(cards, 0.8)
+	if len(groups) != 1 {
+		t.Fatalf("groups = %+v, want one", groups)
+	}
+	g := groups[0]
+	if g.Keep != 1 || len(g.Merge) != 2 || g.Merge[0] != 2 || g.Merge[1] != 5 {
+		t.Errorf("proposal = keep %d merge %v, want keep 1 merge [2 5]", g.Keep, g.Merge)
+	}
+	if g.Similarity < 0.8 || g.Similarity > 1 {
+		t.Errorf("similarity = %v", g.Similarity)
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	if got := normalizeText("  What's   S3?\n"); got != "what s s3" {
+		t.Errorf("normalizeText = %q", got)
+	}
+}
+
+func TestValidateMerge(t *testing.T) {
+	for _, req := range []MergeRequest{
+		{Keep: 0, Merge: []int{2}},
+		{Keep: 1},
+		{Keep: 1, Merge: []int{2, 2}},
+		{Keep: 1, Merge: []int{1}},
+	} {
+		if err := validateMerge(&req); err == nil {
+			t.Errorf("validateMerge(%+v) = nil, want error", req)
+		}
+	}
+	if err := validateMerge(&MergeRequest{Keep: 1, Merge: []int{2, 3}}); err != nil {
+		t.Errorf("valid merge rejected: %v", err)
+	}
+}
+
+func TestMergeCards(t *testing.T) {
+	mock := setupGalleryMock(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM flashcards WHERE id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(5))
+	mock.ExpectQuery("SELECT DISTINCT course_id FROM course_flashcards").
+		WillReturnRows(sqlmock.NewRows([]string{"course_id"}).AddRow(3).AddRow(4))
+	mock.ExpectExec("DELETE FROM course_flashcards cf").WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM course_flashcards cf").WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE course_flashcards SET flashcard_id").WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE account_score SET flashcard_id").WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 6))
+	mock.ExpectExec("DELETE FROM flashcards WHERE id = ANY").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	result, courses, err := mergeCards(context.Background(), 1, []int{2, 5})
+	if err != nil {
+		t.Fatalf("mergeCards: %v", err)
+	}
+	if result.ScoresMoved != 6 || result.CoursesUpdated != 2 || len(courses) != 2 {
+		t.Errorf("result = %+v, courses = %v", result, courses)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestMergeCardsMissingCard(t *testing.T) {
+	mock := setupGalleryMock(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM flashcards WHERE id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	if _, _, err := mergeCards(context.Background(), 1, []int{2}); !errors.Is(err, errCardNotFound) {
+		t.Errorf("err = %v, want errCardNotFound", err)
+	}
+}
+
+func TestRefreshCardStats(t *testing.T) {
+	mock := setupGalleryMock(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO flashcard_stats").WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectExec("DELETE FROM flashcard_stats WHERE refreshed_at").WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := RefreshCardStats(context.Background()); err != nil {
+		t.Fatalf("RefreshCardStats: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCardStatsHandler(t *testing.T) {
+	statsColumns := []string{"question", "attempts", "correct", "accuracy", "median_time_seconds", "difficulty", "refreshed_at"}
+	request := func(id string) *http.Request {
+		req := httptest.NewRequest("GET", "/api/flashcards/"+id+"/stats", nil)
+		req.SetPathValue("id", id)
+		return req
+	}
+
+	t.Run("With answers", func(t *testing.T) {
+		mock := setupGalleryMock(t)
+		mock.ExpectQuery("SELECT f.question, s.attempts").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows(statsColumns).AddRow("Q", 20, 5, 0.25, 12.5, 0.6, time.Now()))
+		rec := httptest.NewRecorder()
+		CardStatsHandler(rec, request("4"))
+		var stats CardStats
+		json.NewDecoder(rec.Body).Decode(&stats)
+		if rec.Code != http.StatusOK || stats.Attempts != 20 || stats.Accuracy != 0.25 || stats.RefreshedAt == nil {
+			t.Errorf("status %d, stats %+v", rec.Code, stats)
+		}
+	})
+
+	t.Run("Never answered", func(t *testing.T) {
+		mock := setupGalleryMock(t)
+		mock.ExpectQuery("SELECT f.question, s.attempts").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows(statsColumns).AddRow("Q", nil, nil, nil, nil, nil, nil))
+		rec := httptest.NewRecorder()
+		CardStatsHandler(rec, request("4"))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"attempts":0`) {
+			t.Errorf("status %d, body %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Unknown card", func(t *testing.T) {
+		mock := setupGalleryMock(t)
+		mock.ExpectQuery("SELECT f.question, s.attempts").WithArgs(4).WillReturnRows(sqlmock.NewRows(statsColumns))
+		rec := httptest.NewRecorder()
+		CardStatsHandler(rec, request("4"))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestHardestCardsHandler(t *testing.T) {
+	mock := setupGalleryMock(t)
+	mock.ExpectQuery("SELECT s.flashcard_id, f.question").WithArgs(3, 2, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"flashcard_id", "question", "attempts", "correct", "accuracy", "median_time_seconds", "difficulty", "refreshed_at"}).
+			AddRow(9, "Hard", 10, 1, 0.1, 25.0, 0.9, time.Now()).
+			AddRow(8, "Medium", 10, 6, 0.6, 10.0, 0.4, time.Now()))
+
+	req := httptest.NewRequest("GET", "/api/flashcards/courses/3/hardest?limit=5&min_attempts=2", nil)
+	req.SetPathValue("id", "3")
+	rec := httptest.NewRecorder()
+	HardestCardsHandler(rec, req)
+
+	var resp struct {
+		Cards []CardStats `json:"cards"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || len(resp.Cards) != 2 || resp.Cards[0].FlashcardID != 9 {
+		t.Errorf("status %d, cards %+v", rec.Code, resp.Cards)
+	}
+
+	req = httptest.NewRequest("GET", "/api/flashcards/courses/3/hardest?limit=500", nil)
+	req.SetPathValue("id", "3")
+	rec = httptest.NewRecorder()
+	HardestCardsHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("oversized limit: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestScoringRulesScore(t *testing.T) {
+	rules := DefaultScoringRules()
+	exp := DefaultScoringRules()
+	exp.TimeBonus = TimeBonus{Curve: CurveExponential, MaxPoints: 80, HalfLife: 5}
+
+	tests := []struct {
+		name    string
+		rules   ScoringRules
+		correct bool
+		streak  int
+		seconds int
+		limit   int
+		hints   int
+		want    PointsBreakdown
+	}{
+		{"wrong answer", rules, false, 0, 3, 10, 0, PointsBreakdown{}},
+		{"linear time bonus", rules, true, 1, 4, 10, 0, PointsBreakdown{Base: 100, TimeBonus: 30, Total: 130}},
+		{"past time limit", rules, true, 1, 12, 10, 0, PointsBreakdown{Base: 100, Total: 100}},
+		{"first streak tier", rules, true, 3, 10, 10, 0, PointsBreakdown{Base: 100, StreakBonus: 50, Total: 150}},
+		{"top streak tier", rules, true, 7, 5, 10, 0, PointsBreakdown{Base: 100, TimeBonus: 25, StreakBonus: 125, Total: 250}},
+		{"hint penalty", rules, true, 1, 10, 10, 2, PointsBreakdown{Base: 100, HintPenalty: 50, Total: 50}},
+		{"penalty never goes negative", rules, false, 0, 3, 10, 3, PointsBreakdown{}},
+		{"exponential half-life", exp, true, 1, 5, 30, 0, PointsBreakdown{Base: 100, TimeBonus: 40, Total: 140}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.rules.score(tt.correct, tt.streak, tt.seconds, tt.limit, tt.hints)
+			if got != tt.want {
+				t.Errorf("score = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScoringRulesValidate(t *testing.T) {
+	if err := DefaultScoringRules().Validate(); err != nil {
+		t.Fatalf("default rules invalid: %v", err)
+	}
+
+	tests := map[string]func(*ScoringRules){
+		"negative base":          func(r *ScoringRules) { r.BasePoints = -1 },
+		"unknown curve":          func(r *ScoringRules) { r.TimeBonus.Curve = "cubic" },
+		"exponential half-life":  func(r *ScoringRules) { r.TimeBonus = TimeBonus{Curve: CurveExponential, MaxPoints: 10} },
+		"streak of one":          func(r *ScoringRules) { r.Streaks[0].Streak = 1 },
+		"multiplier below one":   func(r *ScoringRules) { r.Streaks[0].Multiplier = 0.5 },
+		"unordered tiers":        func(r *ScoringRules) { r.Streaks[1].Streak = 2 },
+		"decreasing multipliers": func(r *ScoringRules) { r.Streaks[1].Multiplier = 1.2 },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			rules := DefaultScoringRules()
+			mutate(&rules)
+			if rules.Validate() == nil {
+				t.Error("expected a validation error")
+			}
+		})
+	}
+}
+
+func TestCalculateFinalScoreBreakdown(t *testing.T) {
+	scores := []ScoreResult{
+		{CorrectAnswer: true, Points: PointsBreakdown{Base: 100, TimeBonus: 20, Total: 120}},
+		{CorrectAnswer: true, Points: PointsBreakdown{Base: 100, HintPenalty: 25, Total: 75}},
+		{CorrectAnswer: false},
+		{CorrectAnswer: true, Points: PointsBreakdown{Base: 100, Total: 100}},
+	}
+	final := calculateFinalScore(scores)
+	want := PointsBreakdown{Base: 300, TimeBonus: 20, HintPenalty: 25, Total: 295}
+	if final.Breakdown != want || final.Points != 295 || final.LongestStreak != 2 {
+		t.Errorf("final score = %+v", final)
+	}
+}
+
+func TestSubmitAnswerAppliesScoring(t *testing.T) {
+	rules := ScoringRules{
+		BasePoints:  10,
+		Streaks:     []StreakTier{{Streak: 2, Multiplier: 3}},
+		TimeBonus:   TimeBonus{Curve: CurveNone},
+		HintPenalty: 5,
+	}
+	sessionID := "guest_session_scoring_test"
+	storeGameSession(sessionID, &GameSession{
+		CourseID:   -1,
+		Flashcards: []Flashcard{{ID: 1, Answer: "A1", Time: 10}, {ID: 2, Answer: "A2", Time: 10}},
+		StartTime:  time.Now(),
+		Rules:      &rules,
+	})
+	t.Cleanup(func() { deleteGameSession(sessionID) })
+
+	store := &MemoryStore{}
+	game := testGame(store, nil)
+	answer := func(body string) AnswerResponse {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/flashcards/answer?session_id="+sessionID, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		game.SubmitAnswerHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp AnswerResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+
+	first := answer(`{"answer":"A1","time_score":3}`)
+	if first.Points.Total != 10 || first.Streak != 1 {
+		t.Errorf("first answer = %+v", first)
+	}
+	second := answer(`{"answer":"A2","time_score":3,"hints_used":1}`)
+	want := PointsBreakdown{Base: 10, StreakBonus: 20, HintPenalty: 5, Total: 25}
+	if second.Points != want || second.Streak != 2 {
+		t.Errorf("second answer = %+v", second)
+	}
+	if second.FinalScore == nil || second.FinalScore.Points != 35 || second.FinalScore.LongestStreak != 2 {
+		t.Errorf("final score = %+v", second.FinalScore)
+	}
+	if len(store.Scores) != 0 || len(store.Games) != 1 || store.Games[0].AccountID != 0 {
+		t.Errorf("guest game stored scores %v, games %+v", store.Scores, store.Games)
+	}
+}
+
+func TestSetScoringRulesRequiresOwner(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	expectCourseRole(mock, 8, "viewer")
+
+	rec := httptest.NewRecorder()
+	SetScoringRulesHandler(rec, galleryRequest("PUT", "/api/flashcards/courses/3/scoring",
+		`{"base_points":50,"time_bonus":{"curve":"none"}}`))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetScoringRules(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	expectCourseRole(mock, 8, "editor")
+	mock.ExpectExec("INSERT INTO course_scoring").
+		WithArgs(3, []byte(`{"base_points":50,"streak_multipliers":[],"time_bonus":{"curve":"none","max_points":0},"hint_penalty":0}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := httptest.NewRecorder()
+	SetScoringRulesHandler(rec, galleryRequest("PUT", "/api/flashcards/courses/3/scoring",
+		`{"base_points":50,"time_bonus":{"curve":"none"}}`))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func expectCourseRole(mock sqlmock.Sqlmock, creator int, role string) {
+	mock.ExpectQuery("FROM courses c").WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "role"}).AddRow(creator, role))
+}
+
+func TestInviteCollaborator(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	expectCourseRole(mock, 7, "")
+	mock.ExpectQuery("INSERT INTO course_collaborators").WithArgs(3, "Ben", "editor", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "created_at", "name", "username"}).AddRow(9, time.Now(), "Go", "ben"))
+	mock.ExpectQuery("INSERT INTO notifications").
+		WithArgs(9, "course_invite", `ana added you to "Go"`, "You are now an editor of the course.", "/flashcards").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+
+	rec := httptest.NewRecorder()
+	InviteCollaboratorHandler(rec, galleryRequest("POST", "/api/flashcards/courses/3/collaborators",
+		`{"username":" Ben ","role":"editor"}`))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"username":"ben"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInviteCollaboratorRequiresOwner(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	expectCourseRole(mock, 8, "editor")
+
+	rec := httptest.NewRecorder()
+	InviteCollaboratorHandler(rec, galleryRequest("POST", "/api/flashcards/courses/3/collaborators",
+		`{"username":"ben","role":"viewer"}`))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoveSelfAsCollaborator(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	mock.ExpectExec("DELETE FROM course_collaborators").WithArgs(3, "ana").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := galleryRequest("DELETE", "/api/flashcards/courses/3/collaborators/ana", "")
+	req.SetPathValue("username", "ana")
+	rec := httptest.NewRecorder()
+	RemoveCollaboratorHandler(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCardEditsNeedEditor(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	expectCourseRole(mock, 8, "viewer")
+
+	rec := httptest.NewRecorder()
+	AddCardHandler(rec, galleryRequest("POST", "/api/flashcards/courses/3/cards", `{"question":"Q","answer":"A"}`))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAddCard(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	expectCourseRole(mock, 8, "editor")
+	mock.ExpectQuery("INSERT INTO course_flashcards").WithArgs(3, "Q", "A", defaultCardTime).
+		WillReturnRows(sqlmock.NewRows([]string{"flashcard_id"}).AddRow(55))
+
+	rec := httptest.NewRecorder()
+	AddCardHandler(rec, galleryRequest("POST", "/api/flashcards/courses/3/cards", `{"question":"Q","answer":"A"}`))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"id":55`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateSharedCardConflicts(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	expectCourseRole(mock, 7, "")
+	mock.ExpectQuery("SELECT COUNT").WithArgs(3, 12).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	req := galleryRequest("PUT", "/api/flashcards/courses/3/cards/12", `{"question":"Q","answer":"A"}`)
+	req.SetPathValue("card_id", "12")
+	rec := httptest.NewRecorder()
+	UpdateCardHandler(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	s := schedule(ReviewState{}, GradeGood, now)
+	if s.IntervalDays != 1 || s.Repetitions != 1 || s.Ease != initialEase || !s.DueAt.Equal(now.AddDate(0, 0, 1)) {
+		t.Errorf("first review = %+v", s)
+	}
+	s = schedule(s, GradeGood, now)
+	if s.IntervalDays != 6 || s.Repetitions != 2 {
+		t.Errorf("second review = %+v", s)
+	}
+	s = schedule(s, GradeEasy, now)
+	if s.IntervalDays != 16 || s.Ease < 2.59 || s.Ease > 2.61 {
+		t.Errorf("third review = %+v", s)
+	}
+	s = schedule(s, GradeAgain, now)
+	if s.IntervalDays != 0 || s.Repetitions != 0 || !s.DueAt.Equal(now.Add(relearnDelay)) {
+		t.Errorf("lapse = %+v", s)
+	}
+	for range 10 {
+		s = schedule(s, GradeAgain, now)
+	}
+	if s.Ease != minEase {
+		t.Errorf("ease after repeated lapses = %v, want %v", s.Ease, minEase)
+	}
+}
+
+func TestReviewNextHidesAnswer(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	now := time.Now().UTC()
+	mock.ExpectQuery("FROM course_flashcards cf").WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "question", "answer", "time", "occlusion", "due_at"}).
+			AddRow(1, "Q1", "A1", 30, nil, now.Add(-time.Hour)).
+			AddRow(2, "Q2", "A2", 30, nil, now.Add(time.Hour)).
+			AddRow(3, "Q3", "A3", 30, nil, nil))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/flashcards/review/next?course_id=3", nil)
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	ReviewNextHandler(rec, req)
+
+	var resp ReviewNextResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if resp.Card == nil || resp.Card.ID != 1 || resp.Card.Answer != "" || resp.Due != 2 || resp.NextDueAt != nil {
+		t.Errorf("response = %s", rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReviewGrade(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	mock.ExpectQuery("SELECT ease, interval_days, repetitions, due_at FROM card_reviews").WithArgs(7, 12).
+		WillReturnRows(sqlmock.NewRows([]string{"ease", "interval_days", "repetitions", "due_at"}).
+			AddRow(2.5, 6, 2, time.Now()))
+	mock.ExpectQuery("INSERT INTO card_reviews").
+		WithArgs(7, 12, 2.5, 15, 3, "good", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"flashcard_id"}).AddRow(12))
+
+	rec := httptest.NewRecorder()
+	ReviewGradeHandler(rec, galleryRequest("POST", "/api/flashcards/review/grade", `{"flashcard_id":12,"grade":"good"}`))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"interval_days":15`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOcclusionRequestValidate(t *testing.T) {
+	img := testPNG(t, 100, 50)
+	tests := []struct {
+		name    string
+		req     OcclusionRequest
+		wantErr string
+	}{
+		{"valid", OcclusionRequest{CourseID: 3, Image: img, Regions: []OcclusionRegion{{X: 10, Y: 10, Width: 90, Height: 40, Label: "CPU"}}}, ""},
+		{"not an image", OcclusionRequest{CourseID: 3, Image: []byte("<svg onload=alert(1)>"), Regions: []OcclusionRegion{{Width: 1, Height: 1, Label: "x"}}}, "PNG, JPEG or GIF"},
+		{"outside", OcclusionRequest{CourseID: 3, Image: img, Regions: []OcclusionRegion{{X: 50, Width: 51, Height: 10, Label: "x"}}}, "inside the 100x50 image"},
+		{"unlabeled", OcclusionRequest{CourseID: 3, Image: img, Regions: []OcclusionRegion{{Width: 10, Height: 10, Label: " "}}}, "needs a label"},
+		{"mode", OcclusionRequest{CourseID: 3, Image: img, Mode: "hide_some", Regions: []OcclusionRegion{{Width: 10, Height: 10, Label: "x"}}}, "mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType, width, height, err := tt.req.Validate()
+			if tt.wantErr == "" {
+				if err != nil || contentType != "image/png" || width != 100 || height != 50 {
+					t.Errorf("Validate = %q, %d, %d, %v", contentType, width, height, err)
+				}
+				if tt.req.Mode != OcclusionHideOne || tt.req.Prompt != defaultOcclusionAsk || tt.req.Time != defaultCardTime {
+					t.Errorf("defaults not applied: %+v", tt.req)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOcclusionCards(t *testing.T) {
+	req := OcclusionRequest{Mode: OcclusionHideAll, Prompt: "Name the part", Time: 20, Regions: []OcclusionRegion{
+		{X: 0, Y: 0, Width: 10, Height: 10, Label: "CPU"},
+		{X: 20, Y: 0, Width: 10, Height: 10, Label: "RAM"},
+	}}
+	cards := occlusionCards(req, 40, 10)
+	if len(cards) != 2 || cards[1].Answer != "RAM" || cards[1].Question != "Name the part" {
+		t.Fatalf("cards = %+v", cards)
+	}
+	occ := cards[1].Occlusion
+	if occ.Target.X != 20 || len(occ.Masks) != 2 || occ.ImageWidth != 40 {
+		t.Errorf("occlusion = %+v", occ)
+	}
+	raw, _ := json.Marshal(cards)
+	if strings.Contains(string(raw), `"label"`) {
+		t.Errorf("labels leak into the occlusion: %s", raw)
+	}
+
+	req.Mode = OcclusionHideOne
+	if masks := occlusionCards(req, 40, 10)[0].Occlusion.Masks; len(masks) != 1 || masks[0].X != 0 {
+		t.Errorf("hide_one masks = %+v", masks)
+	}
+}
+
+func TestCreateOcclusionCards(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	expectCourseRole(mock, 7, "")
+	img := testPNG(t, 40, 10)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO card_media").WithArgs(7, "image/png", 40, 10, img).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(order_index\\)").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"next"}).AddRow(4))
+	mock.ExpectQuery("INSERT INTO flashcards").
+		WithArgs(defaultOcclusionAsk, "CPU", defaultCardTime, 5, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(60))
+	mock.ExpectExec("INSERT INTO course_flashcards").WithArgs(3, 60, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body, _ := json.Marshal(OcclusionRequest{CourseID: 3, Image: img,
+		Regions: []OcclusionRegion{{X: 0, Y: 0, Width: 10, Height: 10, Label: "CPU"}}})
+	rec := httptest.NewRecorder()
+	CreateOcclusionCardsHandler(rec, galleryRequest("POST", "/api/flashcards/occlusion", string(body)))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"media_id":5`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScoreFilter(t *testing.T) {
+	q := url.Values{"course_id": {"2"}, "correct": {"false"}, "from": {"2026-03-01"}, "to": {"2026-03-07"}}
+	where, args, apiErr := scoreFilter(7, q)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if !strings.Contains(where, "cf.course_id = $2") || !strings.Contains(where, "s.correct_answer = $3") ||
+		!strings.Contains(where, "s.answered_at >= $4") || !strings.Contains(where, "s.answered_at < $5") {
+		t.Errorf("where = %s", where)
+	}
+	// A date as the upper bound includes that whole day.
+	if to := args[4].(time.Time); !to.Equal(time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("to = %v", to)
+	}
+
+	for _, bad := range []url.Values{{"course_id": {"x"}}, {"correct": {"maybe"}}, {"from": {"yesterday"}}} {
+		if _, _, apiErr := scoreFilter(7, bad); apiErr == nil {
+			t.Errorf("scoreFilter(%v) accepted invalid input", bad)
+		}
+	}
+}
+
+// flushRecorder counts flushes, to check that exports stream.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *flushRecorder) Flush() { f.flushes++ }
+
+func TestExportScoresHandlerStreamsCSV(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	answered := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"id", "flashcard_id", "question", "time_score", "correct_answer", "answered_at"})
+	for i := 1; i <= exportFlushRows+1; i++ {
+		question := "What is 2+2?"
+		if i == 1 {
+			question = `=HYPERLINK("http://evil")`
+		}
+		rows.AddRow(i, 3, question, 4, i%2 == 0, answered)
+	}
+	mock.ExpectQuery("FROM account_score s").WithArgs(7, true).WillReturnRows(rows)
+
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	ExportScoresHandler(rec, galleryRequest("GET", "/api/flashcards/scores/export.csv?correct=true&sort=answered&limit=5", ""))
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("status = %d, headers = %v: %s", rec.Code, rec.Header(), rec.Body)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != exportFlushRows+2 {
+		t.Errorf("got %d lines, want a header and %d rows; limit must not apply", len(lines), exportFlushRows+1)
+	}
+	if lines[0] != "id,flashcard_id,question,time_score,correct,answered_at" ||
+		lines[1] != `1,3,"'=HYPERLINK(""http://evil"")",4,false,2026-03-02T09:00:00Z` {
+		t.Errorf("unexpected CSV start:\n%s\n%s", lines[0], lines[1])
+	}
+	if rec.flushes != 1 {
+		t.Errorf("flushed %d times, want once per %d rows", rec.flushes, exportFlushRows)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScoreHistoryHandler(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7, 3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("ORDER BY s.answered_at DESC").WithArgs(7, 3, 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "flashcard_id", "question", "time_score", "correct_answer", "answered_at"}).
+			AddRow(9, 3, "Q", 4, true, time.Now()))
+
+	rec := httptest.NewRecorder()
+	ScoreHistoryHandler(rec, galleryRequest("GET", "/api/flashcards/scores?flashcard_id=3", ""))
+	var page struct {
+		Items []ScoreRecord `json:"items"`
+		Total int           `json:"total"`
+	}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if rec.Code != http.StatusOK || page.Total != 1 || len(page.Items) != 1 || !page.Items[0].Correct {
+		t.Errorf("status = %d, page = %+v", rec.Code, page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChoicesFor(t *testing.T) {
+	if got := choicesFor("Paris", nil); got != nil {
+		t.Errorf("free-text card choices = %v", got)
+	}
+	got := choicesFor("Paris", []string{"Rome", "paris!", "Berlin"})
+	if !reflect.DeepEqual(got, []string{"Berlin", "Paris", "Rome"}) {
+		t.Errorf("choices = %v", got)
+	}
+}
+
+func TestSuggestDistractors(t *testing.T) {
+	card := Flashcard{ID: 1, Answer: "1989"}
+	answers := []string{"1989", "1991", "Berlin Wall", "1961", "1989.", "Cold War"}
+	got := suggestDistractors(card, answers, 2)
+	if !reflect.DeepEqual(got, []string{"1961", "1991"}) {
+		t.Errorf("suggestDistractors = %v", got)
+	}
+	if got := suggestDistractors(card, []string{"1989"}, 3); len(got) != 0 {
+		t.Errorf("suggestDistractors with no other answers = %v", got)
+	}
+}
+
+func TestCleanDistractors(t *testing.T) {
+	got, err := cleanDistractors("Paris", []string{" Rome ", "<b>Berlin</b>"})
+	if err != nil || !reflect.DeepEqual(got, []string{"Rome", "Berlin"}) {
+		t.Errorf("cleanDistractors = %v, %v", got, err)
+	}
+	for _, bad := range [][]string{{"PARIS"}, {"Rome", "rome"}, {" "}, {"a", "b", "c", "d", "e", "f"}} {
+		if _, err := cleanDistractors("Paris", bad); err == nil {
+			t.Errorf("cleanDistractors(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestAcceptDistractorsHandler(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	expectCourseRole(mock, 7, "")
+	mock.ExpectQuery("SELECT f.id, f.question").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "question", "answer", "time", "occlusion", "distractors", "challenge"}).
+			AddRow(1, "Capital of France?", "Paris", 30, nil, nil, nil).
+			AddRow(2, "Capital of Italy?", "Rome", 30, nil, nil, nil))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE flashcards SET distractors").WithArgs(`{"Rome","Berlin"}`, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := httptest.NewRecorder()
+	AcceptDistractorsHandler(rec, galleryRequest("POST", "/api/flashcards/courses/3/distractors",
+		`{"cards":[{"flashcard_id":1,"distractors":["Rome","Berlin"]}]}`))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"choices":["Berlin","Paris","Rome"]`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExplainHandler(t *testing.T) {
+	mock := setupGalleryMock(t)
+	provider := &llm.Mock{Reply: " Paris is the capital; Lyon is a large city. "}
+	llm.SetProvider(provider)
+	t.Cleanup(func() { llm.SetProvider(nil) })
+
+	expectGalleryUser(mock)
+	mock.ExpectQuery("SELECT question, answer, explanation FROM flashcards").WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"question", "answer", "explanation"}).
+			AddRow("Capital of France?", "Paris", "Paris has been the capital since 987."))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectExec("INSERT INTO explain_usage").WithArgs(7, 12, true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := httptest.NewRecorder()
+	ExplainHandler(rec, galleryRequest("POST", "/api/flashcards/explain", `{"flashcard_id":12,"answer":"Lyon"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp ExplainResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Correct || resp.CorrectAnswer != "Paris" || resp.Explanation == "" ||
+		resp.Reply != "Paris is the capital; Lyon is a large city." || resp.Remaining != DailyExplainLimit-4 {
+		t.Errorf("response = %+v", resp)
+	}
+	if len(resp.History) != 2 || resp.History[0].Content != "Why?" {
+		t.Errorf("history = %+v", resp.History)
+	}
+	sent := provider.Requests()[0]
+	if !strings.Contains(sent.System, "Student's answer: Lyon") || !strings.Contains(sent.System, "since 987") {
+		t.Errorf("system prompt = %q", sent.System)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExplainHandlerWithoutModel(t *testing.T) {
+	tests := []struct {
+		name        string
+		provider    llm.Provider
+		used        int
+		explanation interface{}
+		status      int
+	}{
+		{"stored only", nil, 0, "Because.", http.StatusOK},
+		{"nothing to say", nil, 0, nil, http.StatusOK},
+		{"limit reached", &llm.Mock{Reply: "x"}, DailyExplainLimit, nil, http.StatusTooManyRequests},
+		{"limit reached with stored", &llm.Mock{Reply: "x"}, DailyExplainLimit, "Because.", http.StatusOK},
+		{"provider fails", &llm.Mock{Err: errors.New("boom")}, 0, nil, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupGalleryMock(t)
+			llm.SetProvider(tt.provider)
+			t.Cleanup(func() { llm.SetProvider(nil) })
+			expectGalleryUser(mock)
+			mock.ExpectQuery("SELECT question, answer, explanation").WithArgs(12).
+				WillReturnRows(sqlmock.NewRows([]string{"question", "answer", "explanation"}).AddRow("Q", "A", tt.explanation))
+			mock.ExpectQuery("SELECT COUNT").WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.used))
+			mock.ExpectExec("INSERT INTO explain_usage").WithArgs(7, 12, false).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			rec := httptest.NewRecorder()
+			ExplainHandler(rec, galleryRequest("POST", "/api/flashcards/explain", `{"flashcard_id":12,"answer":"B"}`))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if strings.Contains(rec.Body.String(), `"reply"`) {
+				t.Errorf("unexpected reply: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseCardLines(t *testing.T) {
+	text := `Q: What does DNS
+stand for?
+A: Domain Name System, which
+maps names to addresses
+
+1. CPU - central processing unit
+2) RAM: random access memory
+What is 2+2? 4
+Pergunta: Capital do Brasil?
+Resposta: Brasília
+Chapter 3 notes
+Q: A question nobody answered`
+
+	cards, unparsed := parseCardLines(text)
+	want := []Flashcard{
+		{Question: "What does DNS stand for?", Answer: "Domain Name System, which maps names to addresses", Time: defaultCardTime},
+		{Question: "CPU", Answer: "central processing unit", Time: defaultCardTime},
+		{Question: "RAM", Answer: "random access memory", Time: defaultCardTime},
+		{Question: "What is 2+2?", Answer: "4", Time: defaultCardTime},
+		{Question: "Capital do Brasil?", Answer: "Brasília", Time: defaultCardTime},
+	}
+	if !reflect.DeepEqual(cards, want) {
+		t.Errorf("cards = %+v", cards)
+	}
+	if !reflect.DeepEqual(unparsed, []string{"Chapter 3 notes", "A question nobody answered"}) {
+		t.Errorf("unparsed = %q", unparsed)
+	}
+}
+
+func TestOCRImportHandler(t *testing.T) {
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 4)))
+	body, _ := json.Marshal(OCRRequest{Image: img.Bytes()})
+
+	tests := []struct {
+		name     string
+		provider ocr.Provider
+		body     string
+		status   int
+	}{
+		{"reads cards", ocr.Mock{Text: "CPU - processor"}, string(body), http.StatusOK},
+		{"not configured", nil, string(body), http.StatusServiceUnavailable},
+		{"provider fails", ocr.Mock{Err: errors.New("boom")}, string(body), http.StatusServiceUnavailable},
+		{"not an image", ocr.Mock{}, `{"image":"aGVsbG8="}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupGalleryMock(t)
+			ocr.SetProvider(tt.provider)
+			t.Cleanup(func() { ocr.SetProvider(nil) })
+			expectGalleryUser(mock)
+
+			rec := httptest.NewRecorder()
+			OCRImportHandler(rec, galleryRequest("POST", "/api/flashcards/ocr", tt.body))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"answer":"processor"`) {
+				t.Errorf("body = %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRunChallenge(t *testing.T) {
+	challenge := &Challenge{Tests: []ChallengeTest{
+		{Name: "small", Call: "print(add(2, 3));", Expected: "5"},
+		{Name: "negative", Call: "print(add(-2, 1));", Expected: "-1\n"},
+	}}
+	if err := challenge.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		passed []bool
+		diag   bool
+	}{
+		{"correct", "function add(a, b) { return a + b; }", []bool{true, true}, false},
+		{"wrong", "function add(a, b) { return a - b; }", []bool{false, false}, false},
+		{"does not compile", "function add(a, b) { return a + ; }", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := runChallenge(context.Background(), challenge, tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(result.Diagnostics) > 0) != tt.diag || len(result.Tests) != len(tt.passed) {
+				t.Fatalf("result = %+v", result)
+			}
+			allPassed := !tt.diag
+			for i, run := range result.Tests {
+				if run.Passed != tt.passed[i] {
+					t.Errorf("test %s passed = %v: %+v", run.Name, run.Passed, run)
+				}
+				allPassed = allPassed && run.Passed
+			}
+			if result.Passed != allPassed {
+				t.Errorf("passed = %v, want %v", result.Passed, allPassed)
+			}
+		})
+	}
+
+	if err := (&Challenge{}).validate(); err == nil {
+		t.Error("a challenge without tests validated")
+	}
+}
+
+func TestSubmitChallengeHandler(t *testing.T) {
+	mock := setupGalleryMock(t)
+	expectGalleryUser(mock)
+	mock.ExpectQuery("SELECT challenge FROM flashcards").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"challenge"}).
+			AddRow([]byte(`{"starter":"","tests":[{"name":"t","call":"print(double(4));","expected":"8"}]}`)))
+	mock.ExpectQuery("INSERT INTO challenge_submissions").
+		WithArgs(7, 3, "", "function double(x) { return x * 2; }", true, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+
+	rec := httptest.NewRecorder()
+	SubmitChallengeHandler(rec, galleryRequest("POST", "/api/flashcards/3/challenge/submissions",
+		`{"source":"function double(x) { return x * 2; }"}`))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"passed":true`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPauseRefusesAnswersUntilResumed(t *testing.T) {
+	store := &MemoryStore{}
+	game := testGame(store, &login.User{ID: 7, Username: "ana"})
+	sessionID := "session_pause_test"
+	storeGameSession(sessionID, &GameSession{
+		AccountID:  7,
+		Flashcards: []Flashcard{{ID: 1, Question: "Q1", Answer: "A1"}, {ID: 2, Question: "Q2", Answer: "A2"}},
+		StartTime:  time.Now().Add(-time.Minute),
+	})
+	t.Cleanup(func() { deleteGameSession(sessionID) })
+
+	setPaused := func(handler http.HandlerFunc, action string) SessionState {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("POST", "/api/flashcards/"+action+"?session_id="+sessionID, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", action, rec.Code, rec.Body.String())
+		}
+		var state SessionState
+		json.Unmarshal(rec.Body.Bytes(), &state)
+		return state
+	}
+
+	if state := setPaused(game.PauseGameHandler, "pause"); !state.Paused || state.PlayTime < 59 {
+		t.Errorf("paused state = %+v", state)
+	}
+	if state := setPaused(game.PauseGameHandler, "pause"); !state.Paused {
+		t.Errorf("pausing again: %+v", state)
+	}
+	rec := httptest.NewRecorder()
+	game.SubmitAnswerHandler(rec, answerRequest(sessionID, `{"flashcard_id":1,"answer":"A1"}`))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("answer while paused: status %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	// An hour-long pause does not count as play time.
+	session, _ := getGameSession(sessionID)
+	pausedAt := time.Now().Add(-time.Hour)
+	session.PausedAt = &pausedAt
+	if state := setPaused(game.ResumeGameHandler, "resume"); state.Paused || state.PlayTime > 5 {
+		t.Errorf("resumed state = %+v", state)
+	}
+	if session.PausedFor < time.Hour {
+		t.Errorf("paused for %v, want at least an hour", session.PausedFor)
+	}
+
+	rec = httptest.NewRecorder()
+	game.SubmitAnswerHandler(rec, answerRequest(sessionID, `{"flashcard_id":1,"answer":"A1"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("answer after resuming: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if len(store.Scores) != 1 {
+		t.Errorf("scores = %v", store.Scores)
+	}
+
+	rec = httptest.NewRecorder()
+	testGame(store, nil).PauseGameHandler(rec, httptest.NewRequest("POST", "/api/flashcards/pause?session_id="+sessionID, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("guest pausing another's game: status %d", rec.Code)
+	}
+}
+
+func TestPruneSessionsSkipsPauses(t *testing.T) {
+	longAgo := time.Now().Add(-48 * time.Hour)
+	recently := time.Now().Add(-time.Hour)
+	storeGameSession("resumed", &GameSession{StartTime: longAgo, PausedFor: 47 * time.Hour})
+	storeGameSession("paused", &GameSession{StartTime: longAgo, PausedFor: 40 * time.Hour, PausedAt: &recently})
+	storeGameSession("left paused", &GameSession{StartTime: longAgo, PausedAt: &longAgo})
+	defer deleteGameSession("resumed")
+	defer deleteGameSession("paused")
+
+	PruneSessions(24 * time.Hour)
+	for _, sessionID := range []string{"resumed", "paused"} {
+		if _, err := getGameSession(sessionID); err != nil {
+			t.Errorf("%s session was pruned", sessionID)
+		}
+	}
+	if _, err := getGameSession("left paused"); err == nil {
+		t.Error("session paused for two days still present")
+	}
+}
+
+func TestShuffleCardsIsReproducible(t *testing.T) {
+	var cards []Flashcard
+	for id := 1; id <= 20; id++ {
+		cards = append(cards, Flashcard{ID: id})
+	}
+	ids := func(cards []Flashcard) []int {
+		var ids []int
+		for _, card := range cards {
+			ids = append(ids, card.ID)
+		}
+		return ids
+	}
+
+	first := shuffleCards(cards, 42)
+	if !reflect.DeepEqual(ids(first), ids(shuffleCards(cards, 42))) {
+		t.Errorf("the same seed gave different orders")
+	}
+	if reflect.DeepEqual(ids(first), ids(cards)) || reflect.DeepEqual(ids(first), ids(shuffleCards(cards, 43))) {
+		t.Errorf("shuffled order = %v", ids(first))
+	}
+	if cards[0].ID != 1 || cards[19].ID != 20 {
+		t.Errorf("shuffleCards modified its input")
+	}
+}
+
+func TestRestartShuffledGame(t *testing.T) {
+	var cards []Flashcard
+	for id := 1; id <= 8; id++ {
+		cards = append(cards, Flashcard{ID: id, Question: fmt.Sprintf("Q%d", id), Answer: fmt.Sprintf("A%d", id)})
+	}
+	store := &MemoryStore{Cards: map[int][]Flashcard{1: cards}, Guest: cards}
+	game := testGame(store, nil)
+
+	type shuffledGame struct {
+		SessionID  string      `json:"session_id"`
+		Flashcards []Flashcard `json:"flashcards"`
+		Seed       *int64      `json:"seed"`
+	}
+	play := func(handler http.HandlerFunc, target, body string) shuffledGame {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("POST", target, strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", target, rec.Code, rec.Body.String())
+		}
+		var started shuffledGame
+		json.NewDecoder(rec.Body).Decode(&started)
+		deleteGameSession(started.SessionID)
+		return started
+	}
+
+	if plain := play(game.StartGameHandler, "/api/flashcards/start?course_id=1", ""); plain.Seed != nil || plain.Flashcards[0].ID != 1 {
+		t.Errorf("unshuffled game: seed %v, first card %d", plain.Seed, plain.Flashcards[0].ID)
+	}
+	shuffled := play(game.StartGameHandler, "/api/flashcards/start?course_id=1&shuffle=true", "")
+	if shuffled.Seed == nil || !reflect.DeepEqual(shuffled.Flashcards, shuffleCards(cards, *shuffled.Seed)) {
+		t.Fatalf("shuffled game: seed %v, cards %v", shuffled.Seed, shuffled.Flashcards)
+	}
+	seeded := play(game.StartGameHandler, fmt.Sprintf("/api/flashcards/start?course_id=1&seed=%d", *shuffled.Seed), "")
+	restarted := play(game.RestartGameHandler, "/api/flashcards/restart", fmt.Sprintf(`{"course_id":1,"seed":%d}`, *shuffled.Seed))
+	for name, replay := range map[string]shuffledGame{"seed": seeded, "restart": restarted} {
+		if replay.Seed == nil || *replay.Seed != *shuffled.Seed || !reflect.DeepEqual(replay.Flashcards, shuffled.Flashcards) {
+			t.Errorf("%s: seed %v, cards %v, want %v", name, replay.Seed, replay.Flashcards, shuffled.Flashcards)
+		}
+	}
+
+	guest := play(game.StartGuestGameHandler, "/api/flashcards/start-guest", `{"flashcard_ids":[2,4,6,8],"shuffle":true}`)
+	replayed := play(game.RestartGameHandler, "/api/flashcards/restart", fmt.Sprintf(`{"flashcard_ids":[8,6,4,2],"seed":%d}`, *guest.Seed))
+	if !reflect.DeepEqual(replayed.Flashcards, guest.Flashcards) {
+		t.Errorf("guest replay = %v, want %v", replayed.Flashcards, guest.Flashcards)
+	}
+
+	for _, body := range []string{`{"seed":1}`, `{"course_id":1,"flashcard_ids":[2],"seed":1}`, `{"course_id":1,"seed":-1}`} {
+		rec := httptest.NewRecorder()
+		game.RestartGameHandler(rec, httptest.NewRequest("POST", "/api/flashcards/restart", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("restart %s: status %d", body, rec.Code)
+		}
+	}
+}
+
+func TestFinalScoreCarriesSeed(t *testing.T) {
+	seed := int64(7)
+	sessionID := "session_seed_test"
+	storeGameSession(sessionID, &GameSession{
+		Flashcards: []Flashcard{{ID: 1, Question: "Q1", Answer: "A1"}},
+		StartTime:  time.Now(),
+		Seed:       &seed,
+	})
+	t.Cleanup(func() { deleteGameSession(sessionID) })
+
+	rec := httptest.NewRecorder()
+	testGame(&MemoryStore{}, nil).SubmitAnswerHandler(rec, answerRequest(sessionID, `{"flashcard_id":1,"answer":"A1"}`))
+	var resp AnswerResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.FinalScore == nil || resp.FinalScore.Seed == nil || *resp.FinalScore.Seed != seed {
+		t.Errorf("final score = %+v", resp.FinalScore)
+	}
+}
+
+func TestFlagAnswer(t *testing.T) {
+	start := time.Now()
+	typed := Flashcard{ID: 1, Answer: "Object storage"}
+	tests := []struct {
+		name    string
+		card    Flashcard
+		answer  string
+		correct bool
+		took    time.Duration
+		want    []string
+	}{
+		{"human", typed, "Object storage", true, 4 * time.Second, nil},
+		{"too fast", typed, "Object storage", true, 300 * time.Millisecond, []string{FlagTooFast}},
+		{"fast but wrong", typed, "x", false, 100 * time.Millisecond, nil},
+		{"fast click", Flashcard{Answer: "B", Choices: []string{"A", "B"}}, "B", true, 800 * time.Millisecond, nil},
+		{"trailing space", Flashcard{Answer: "ls -la "}, "ls -la ", true, 5 * time.Second, []string{FlagExactWhitespace}},
+		{"tab", Flashcard{Answer: "a\tb"}, "a\tb", true, 5 * time.Second, []string{FlagExactWhitespace}},
+		{"trimmed", Flashcard{Answer: "ls -la "}, "ls -la", true, 5 * time.Second, nil},
+	}
+	for _, tt := range tests {
+		session := &GameSession{StartTime: start}
+		got := session.flagAnswer(tt.card, tt.answer, tt.correct, start.Add(tt.took))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: flags = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Five answers in a row at the same pace, give or take 100ms.
+	session := &GameSession{StartTime: start}
+	now := start
+	var flags []string
+	for i, took := range []time.Duration{3 * time.Second, 3020 * time.Millisecond, 2990 * time.Millisecond, 3050 * time.Millisecond, 3 * time.Second} {
+		now = now.Add(took)
+		flags = session.flagAnswer(typed, "wrong", false, now)
+		if i < 4 && flags != nil {
+			t.Errorf("answer %d flagged %v", i+1, flags)
+		}
+	}
+	if !reflect.DeepEqual(flags, []string{FlagUniformTiming}) {
+		t.Errorf("fifth answer: flags = %v", flags)
+	}
+	if got := runFlags([]ScoreResult{{Flags: []string{FlagTooFast}}, {}, {Flags: []string{FlagUniformTiming, FlagTooFast}}}); !reflect.DeepEqual(got, []string{FlagTooFast, FlagUniformTiming}) {
+		t.Errorf("runFlags = %v", got)
+	}
+}
+
+func TestFlaggedGameIsRecorded(t *testing.T) {
+	store := &MemoryStore{Cards: map[int][]Flashcard{1: {{ID: 1, Question: "Q1", Answer: "A1"}}}}
+	game := testGame(store, &login.User{ID: 7, Username: "ana"})
+	rec := httptest.NewRecorder()
+	game.StartGameHandler(rec, httptest.NewRequest("POST", "/api/flashcards/start?course_id=1", nil))
+	var started startedGame
+	json.NewDecoder(rec.Body).Decode(&started)
+	t.Cleanup(func() { deleteGameSession(started.SessionID) })
+
+	rec = httptest.NewRecorder()
+	game.SubmitAnswerHandler(rec, answerRequest(started.SessionID, `{"flashcard_id":1,"answer":"A1","time_score":4}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("answer: status %d, body %s", rec.Code, rec.Body.String())
+	}
+	if scores := store.Scores[7]; len(scores) != 1 || !reflect.DeepEqual(scores[0].Flags, []string{FlagTooFast}) {
+		t.Errorf("saved scores = %+v", scores)
+	}
+	if len(store.Games) != 1 || !reflect.DeepEqual(store.Games[0].Flags, []string{FlagTooFast}) {
+		t.Errorf("recorded games = %+v", store.Games)
+	}
+}
@@ -0,0 +1,657 @@
+package flashcards
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/pagination"
+
+	"github.com/lib/pq"
+)
+
+// Listing statuses. Hidden listings are kept so republishing cannot undo a
+// moderator's decision; only an admin restore can.
+const (
+	listingPublished = "published"
+	listingHidden    = "hidden"
+)
+
+// GalleryCategories are the categories a deck can be published under.
+var GalleryCategories = []string{
+	"programming", "cloud", "security", "languages", "math", "science", "history", "other",
+}
+
+var galleryOptions = pagination.Options{
+	DefaultLimit: 24,
+	MaxLimit:     100,
+	Sorts:        []string{"stars", "downloads", "rating", "published", "name"},
+	DefaultSort:  "-stars",
+}
+
+var gallerySortColumns = map[string]string{
+	"stars":     "stars",
+	"downloads": "l.downloads",
+	"rating":    "rating",
+	"published": "l.published_at",
+	"name":      "c.name",
+}
+
+// GalleryDeck is a published deck as shown in the gallery. Starred and
+// MyRating describe the caller and are zero for anonymous visitors.
+type GalleryDeck struct {
+	CourseID    int       `json:"course_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Author      string    `json:"author"`
+	Category    string    `json:"category"`
+	Cards       int       `json:"cards"`
+	Stars       int       `json:"stars"`
+	Downloads   int       `json:"downloads"`
+	Rating      float64   `json:"rating"`
+	Ratings     int       `json:"ratings"`
+	Starred     bool      `json:"starred"`
+	MyRating    int       `json:"my_rating,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+type PublishDeckRequest struct {
+	Category string `json:"category"`
+}
+
+type RateDeckRequest struct {
+	Rating int `json:"rating"`
+}
+
+type HideDeckRequest struct {
+	Note string `json:"note"`
+}
+
+type CategoryCount struct {
+	Category string `json:"category"`
+	Decks    int    `json:"decks"`
+}
+
+// DeckSubmission is what moderators see when a deck is published.
+type DeckSubmission struct {
+	CourseID    int
+	AccountID   int
+	Name        string
+	Description string
+	Category    string
+	Cards       []Flashcard
+}
+
+// Moderator inspects a deck before it is published. Returning an error
+// rejects the deck; the error text is shown to the author.
+type Moderator func(ctx context.Context, deck DeckSubmission) error
+
+var (
+	moderatorsMu sync.RWMutex
+	moderators   []Moderator
+)
+
+// RegisterModerator adds a check run, in registration order, every time a
+// deck is published or republished.
+func RegisterModerator(m Moderator) {
+	moderatorsMu.Lock()
+	defer moderatorsMu.Unlock()
+	moderators = append(moderators, m)
+}
+
+func moderate(ctx context.Context, deck DeckSubmission) error {
+	moderatorsMu.RLock()
+	defer moderatorsMu.RUnlock()
+	for _, m := range moderators {
+		if err := m(ctx, deck); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GalleryHandler lists published decks. Query parameters: category, q
+// (name or description substring), sort (stars, downloads, rating,
+// published or name; default -stars), limit and offset. No login is needed.
+func GalleryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, apiErr := pagination.Parse(q, galleryOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	category := q.Get("category")
+	if category != "" && !validCategory(category) {
+		apierror.Write(w, apierror.Validation("Unknown category"))
+		return
+	}
+
+	viewer := 0
+	if user, err := login.GetCurrentUser(r); err == nil {
+		viewer = user.ID
+	}
+
+	where, args := galleryFilter(viewer, category, q.Get("q"))
+	var total int
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT COUNT(*) FROM deck_listings l JOIN courses c ON c.id = l.course_id WHERE `+where,
+		args...,
+	).Scan(&total)
+	if err != nil {
+		log.Printf("Failed to count gallery decks: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load gallery"))
+		return
+	}
+
+	decks, err := listGallery(r.Context(), galleryQuery(where, page, len(args)), append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to list gallery decks: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load gallery"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(decks, total, page))
+}
+
+// GalleryCategoriesHandler returns every category with its number of
+// published decks.
+func GalleryCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT category, COUNT(*) FROM deck_listings WHERE status = $1 GROUP BY category`,
+		listingPublished)
+	if err != nil {
+		log.Printf("Failed to count gallery categories: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load categories"))
+		return
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var category string
+		var n int
+		if err := rows.Scan(&category, &n); err != nil {
+			log.Printf("Failed to scan gallery category: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load categories"))
+			return
+		}
+		counts[category] = n
+	}
+
+	result := make([]CategoryCount, 0, len(GalleryCategories))
+	for _, c := range GalleryCategories {
+		result = append(result, CategoryCount{Category: c, Decks: counts[c]})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// PublishDeckHandler publishes one of the caller's decks to the gallery, or
+// changes the category of an already published one. Registered moderators
+// run on every publish.
+func PublishDeckHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req PublishDeckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if !validCategory(req.Category) {
+		apierror.Write(w, apierror.Validation("category must be one of "+strings.Join(GalleryCategories, ", ")))
+		return
+	}
+
+	deck := DeckSubmission{CourseID: courseID, Category: req.Category}
+	var owner sql.NullInt64
+	var draft bool
+	err = db.DB.QueryRowContext(r.Context(),
+		`SELECT account_id, name, COALESCE(description, ''), draft FROM courses WHERE id = $1`, courseID,
+	).Scan(&owner, &deck.Name, &deck.Description, &draft)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Deck not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load course %d for publishing: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to publish deck"))
+		return
+	}
+	if !owner.Valid || int(owner.Int64) != user.ID {
+		apierror.Write(w, apierror.Forbidden("You can only publish your own decks"))
+		return
+	}
+	if draft {
+		apierror.Write(w, apierror.Conflict("Review the draft and mark it ready before publishing"))
+		return
+	}
+	deck.AccountID = user.ID
+
+	deck.Cards, err = getFlashcardsByCourse(courseID)
+	if err != nil {
+		log.Printf("Failed to load cards of course %d for publishing: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to publish deck"))
+		return
+	}
+	if len(deck.Cards) == 0 {
+		apierror.Write(w, apierror.Validation("Deck has no cards"))
+		return
+	}
+	if err := moderate(r.Context(), deck); err != nil {
+		apierror.Write(w, apierror.Validation("Deck rejected: "+err.Error()))
+		return
+	}
+
+	var status string
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO deck_listings (course_id, account_id, category, status)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (course_id) DO UPDATE SET category = EXCLUDED.category
+		 RETURNING status`,
+		courseID, user.ID, req.Category, listingPublished,
+	).Scan(&status)
+	if err != nil {
+		log.Printf("Failed to publish course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to publish deck"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"course_id": courseID,
+		"category":  req.Category,
+		"status":    status,
+	})
+}
+
+// UnpublishDeckHandler removes the caller's deck from the gallery along with
+// its stars and ratings. The deck itself is kept.
+func UnpublishDeckHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(),
+		`DELETE FROM deck_listings WHERE course_id = $1 AND account_id = $2`, courseID, user.ID)
+	if err != nil {
+		log.Printf("Failed to unpublish course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to unpublish deck"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Deck is not published"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StarDeckHandler stars (PUT) or unstars (DELETE) a published deck and
+// returns its new star count.
+func StarDeckHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := publishedOwner(w, r, courseID); !ok {
+		return
+	}
+
+	starred := r.Method == http.MethodPut
+	if starred {
+		_, err = db.DB.ExecContext(r.Context(),
+			`INSERT INTO deck_stars (course_id, account_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			courseID, user.ID)
+	} else {
+		_, err = db.DB.ExecContext(r.Context(),
+			`DELETE FROM deck_stars WHERE course_id = $1 AND account_id = $2`, courseID, user.ID)
+	}
+	var stars int
+	if err == nil {
+		err = db.DB.QueryRowContext(r.Context(),
+			`SELECT COUNT(*) FROM deck_stars WHERE course_id = $1`, courseID).Scan(&stars)
+	}
+	if err != nil {
+		log.Printf("Failed to update star on course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to update star"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"starred": starred, "stars": stars})
+}
+
+// RateDeckHandler records the caller's 1-5 rating of a published deck,
+// replacing any earlier rating. Authors cannot rate their own decks.
+func RateDeckHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req RateDeckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.Rating < 1 || req.Rating > 5 {
+		apierror.Write(w, apierror.Validation("rating must be between 1 and 5"))
+		return
+	}
+
+	owner, ok := publishedOwner(w, r, courseID)
+	if !ok {
+		return
+	}
+	if owner == user.ID {
+		apierror.Write(w, apierror.Forbidden("You cannot rate your own deck"))
+		return
+	}
+
+	var avg float64
+	var count int
+	_, err = db.DB.ExecContext(r.Context(),
+		`INSERT INTO deck_ratings (course_id, account_id, rating) VALUES ($1, $2, $3)
+		 ON CONFLICT (course_id, account_id) DO UPDATE SET rating = EXCLUDED.rating, updated_at = CURRENT_TIMESTAMP`,
+		courseID, user.ID, req.Rating)
+	if err == nil {
+		err = db.DB.QueryRowContext(r.Context(),
+			`SELECT COALESCE(AVG(rating), 0), COUNT(*) FROM deck_ratings WHERE course_id = $1`, courseID,
+		).Scan(&avg, &count)
+	}
+	if err != nil {
+		log.Printf("Failed to rate course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to save rating"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"rating": avg, "ratings": count, "my_rating": req.Rating})
+}
+
+// CloneDeckHandler copies a published deck and its cards into the caller's
+// account and counts a download.
+func CloneDeckHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := publishedOwner(w, r, courseID); !ok {
+		return
+	}
+
+	cloneID, cards, err := cloneDeck(r.Context(), courseID, user.ID)
+	if err != nil {
+		log.Printf("Failed to clone course %d for account %d: %v", courseID, user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to clone deck"))
+		return
+	}
+	invalidateCourse(r.Context(), cloneID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ImportDeckResult{CourseID: cloneID, Cards: cards})
+}
+
+// HideDeckHandler takes a deck out of the gallery without deleting it and
+// tells the author why. Admin only.
+func HideDeckHandler(w http.ResponseWriter, r *http.Request) {
+	setListingStatus(w, r, listingHidden)
+}
+
+// RestoreDeckHandler puts a hidden deck back in the gallery. Admin only.
+func RestoreDeckHandler(w http.ResponseWriter, r *http.Request) {
+	setListingStatus(w, r, listingPublished)
+}
+
+func setListingStatus(w http.ResponseWriter, r *http.Request, status string) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	if user.Role != "admin" {
+		apierror.Write(w, apierror.Forbidden("Admin access required"))
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req HideDeckRequest
+	if status == listingHidden && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.Write(w, apierror.DecodeError(err))
+			return
+		}
+	}
+
+	var owner int
+	var name string
+	err = db.DB.QueryRowContext(r.Context(),
+		`UPDATE deck_listings l SET status = $2, moderation_note = NULLIF($3, '')
+		 FROM courses c
+		 WHERE l.course_id = $1 AND c.id = l.course_id
+		 RETURNING l.account_id, c.name`,
+		courseID, status, req.Note,
+	).Scan(&owner, &name)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Deck is not published"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to set course %d listing to %s: %v", courseID, status, err)
+		apierror.Write(w, apierror.Internal("Failed to update deck"))
+		return
+	}
+
+	if status == listingHidden {
+		body := fmt.Sprintf("%q was removed from the gallery by a moderator.", name)
+		if req.Note != "" {
+			body += " " + req.Note
+		}
+		_, err := notifications.Notify(r.Context(), owner, notifications.KindDeckModerated,
+			"Deck hidden from gallery", body, "/flashcards")
+		if err != nil {
+			log.Printf("Gallery moderation: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"course_id": courseID, "status": status})
+}
+
+// publishedOwner returns the author of a published deck, writing a 404 when
+// the deck is not in the gallery.
+func publishedOwner(w http.ResponseWriter, r *http.Request, courseID int) (int, bool) {
+	var owner int
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT account_id FROM deck_listings l WHERE l.course_id = $1 AND l.status = $2 AND `+login.NotSuspended("l.account_id"),
+		courseID, listingPublished,
+	).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Deck not found"))
+		return 0, false
+	}
+	if err != nil {
+		log.Printf("Failed to load listing for course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load deck"))
+		return 0, false
+	}
+	return owner, true
+}
+
+func pathCourseID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid course ID"))
+		return 0, false
+	}
+	return id, true
+}
+
+func validCategory(category string) bool {
+	for _, c := range GalleryCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// galleryFilter builds the WHERE clause shared by the count and page
+// queries. $1 is always the viewer, used by the page query for starred and
+// my_rating.
+func galleryFilter(viewer int, category, search string) (string, []interface{}) {
+	args := []interface{}{viewer, listingPublished}
+	where := "l.status = $2 AND " + login.NotSuspended("l.account_id")
+	if category != "" {
+		args = append(args, category)
+		where += fmt.Sprintf(" AND l.category = $%d", len(args))
+	}
+	if search != "" {
+		args = append(args, pagination.LikePattern(search))
+		where += fmt.Sprintf(" AND (c.name ILIKE $%d OR c.description ILIKE $%[1]d)", len(args))
+	}
+	return where, args
+}
+
+func galleryQuery(where string, page pagination.Params, nargs int) string {
+	dir := "ASC"
+	if page.Desc {
+		dir = "DESC"
+	}
+	return fmt.Sprintf(`
+		SELECT l.course_id, c.name, COALESCE(c.description, ''), a.username, l.category,
+			(SELECT COUNT(*) FROM course_flashcards cf WHERE cf.course_id = l.course_id),
+			(SELECT COUNT(*) FROM deck_stars s WHERE s.course_id = l.course_id) AS stars,
+			l.downloads,
+			(SELECT COALESCE(AVG(dr.rating), 0) FROM deck_ratings dr WHERE dr.course_id = l.course_id) AS rating,
+			(SELECT COUNT(*) FROM deck_ratings dr WHERE dr.course_id = l.course_id),
+			EXISTS (SELECT 1 FROM deck_stars s WHERE s.course_id = l.course_id AND s.account_id = $1),
+			COALESCE((SELECT dr.rating FROM deck_ratings dr WHERE dr.course_id = l.course_id AND dr.account_id = $1), 0),
+			l.published_at
+		FROM deck_listings l
+		JOIN courses c ON c.id = l.course_id
+		JOIN accounts a ON a.id = l.account_id
+		WHERE %s
+		ORDER BY %s %s, l.course_id %s
+		LIMIT $%d OFFSET $%d
+	`, where, gallerySortColumns[page.Sort], dir, dir, nargs+1, nargs+2)
+}
+
+func listGallery(ctx context.Context, query string, args ...interface{}) ([]GalleryDeck, error) {
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	decks := []GalleryDeck{}
+	for rows.Next() {
+		var d GalleryDeck
+		err := rows.Scan(&d.CourseID, &d.Name, &d.Description, &d.Author, &d.Category,
+			&d.Cards, &d.Stars, &d.Downloads, &d.Rating, &d.Ratings, &d.Starred, &d.MyRating, &d.PublishedAt)
+		if err != nil {
+			return nil, err
+		}
+		decks = append(decks, d)
+	}
+	return decks, rows.Err()
+}
+
+// cloneDeck copies the course and its cards in one transaction and bumps the
+// listing's download count.
+func cloneDeck(ctx context.Context, courseID, accountID int) (int, int, error) {
+	cards, err := getFlashcardsByCourse(courseID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var cloneID int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO courses (name, description, account_id)
+		 SELECT name, description, $2 FROM courses WHERE id = $1
+		 RETURNING id`,
+		courseID, accountID,
+	).Scan(&cloneID)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i, card := range cards {
+		var cardID int
+		mediaID, occlusion := card.occlusionValues()
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO flashcards (question, answer, time, media_id, occlusion, distractors, challenge)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+			card.Question, card.Answer, card.Time, mediaID, occlusion, pq.StringArray(card.distractors()), card.challengeValue(),
+		).Scan(&cardID)
+		if err != nil {
+			return 0, 0, err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO course_flashcards (course_id, flashcard_id, order_index) VALUES ($1, $2, $3)",
+			cloneID, cardID, i,
+		)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE deck_listings SET downloads = downloads + 1 WHERE course_id = $1`, courseID,
+	); err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return cloneID, len(cards), nil
+}
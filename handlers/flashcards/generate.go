@@ -0,0 +1,233 @@
+package flashcards
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/llm"
+	"allanswebterminal/sanitize"
+	"allanswebterminal/validate"
+)
+
+const (
+	defaultGeneratedCards = 10
+	// generateTimeout bounds the provider call; models can be slow.
+	generateTimeout = 90 * time.Second
+)
+
+// GenerateCardsRequest names the source of the notes: pasted Notes or one
+// of the caller's saved files.
+type GenerateCardsRequest struct {
+	Notes    string `json:"notes" validate:"max=20000"`
+	Filename string `json:"filename" validate:"max=255"`
+	Name     string `json:"name" validate:"max=100"`
+	Count    int    `json:"count" validate:"min=1,max=50"`
+}
+
+// GenerateCardsResult is the draft course the cards were saved to.
+type GenerateCardsResult struct {
+	CourseID int         `json:"course_id"`
+	Name     string      `json:"name"`
+	Draft    bool        `json:"draft"`
+	Cards    []Flashcard `json:"cards"`
+}
+
+const generateSystemPrompt = `You write study flashcards from a student's notes.
+Each card asks one clear question and has a short, self-contained answer taken from the notes.
+Do not invent facts that are not in the notes.
+Reply with JSON only, in the form {"cards": [{"question": "...", "answer": "..."}]}.`
+
+// GenerateCardsHandler turns notes into draft cards with the configured LLM
+// provider and saves them as a draft course, which the caller reviews and
+// marks ready before it can be published.
+func GenerateCardsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req GenerateCardsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	req.Notes = strings.TrimSpace(req.Notes)
+	if (req.Notes == "") == (req.Filename == "") {
+		apierror.Write(w, apierror.Validation("Send either notes or the filename of a saved file"))
+		return
+	}
+	if req.Count == 0 {
+		req.Count = defaultGeneratedCards
+	}
+	if !llm.Configured() {
+		apierror.Write(w, apierror.Unavailable("Card generation is not configured"))
+		return
+	}
+
+	notes := req.Notes
+	if req.Filename != "" {
+		file, err := files.Load(r.Context(), user.ID, req.Filename)
+		if errors.Is(err, files.ErrNotFound) {
+			apierror.Write(w, apierror.NotFound("File not found"))
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load %s for card generation: %v", req.Filename, err)
+			apierror.Write(w, apierror.Internal("Failed to load file"))
+			return
+		}
+		if len(file.Content) > 20000 {
+			apierror.Write(w, apierror.Validation("The file is too long; generate from at most 20000 characters"))
+			return
+		}
+		notes = file.Content
+	}
+
+	name := strings.TrimSpace(sanitize.Text(req.Name))
+	if name == "" {
+		name = "Generated cards " + time.Now().Format("2006-01-02 15:04")
+		if req.Filename != "" {
+			name = "Cards from " + req.Filename
+		}
+	}
+	if len(name) > maxCourseNameChars {
+		name = name[:maxCourseNameChars]
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), generateTimeout)
+	defer cancel()
+	cards, err := generateCards(ctx, notes, req.Count)
+	if err != nil {
+		log.Printf("Card generation failed for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Unavailable("The card generator failed; try again later"))
+		return
+	}
+	if len(cards) == 0 {
+		apierror.Write(w, apierror.Validation("No cards could be made from these notes"))
+		return
+	}
+
+	courseID, err := saveDraftCourse(r.Context(), user.ID, name, cards)
+	if err != nil {
+		log.Printf("Failed to save generated cards: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save generated cards"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(GenerateCardsResult{CourseID: courseID, Name: name, Draft: true, Cards: cards})
+}
+
+// generateCards asks the provider for up to count cards and keeps the
+// usable ones, cleaned like imported cards.
+func generateCards(ctx context.Context, notes string, count int) ([]Flashcard, error) {
+	reply, err := llm.Complete(ctx, llm.Request{
+		System: generateSystemPrompt,
+		Messages: []llm.Message{{
+			Role:    "user",
+			Content: fmt.Sprintf("Write at most %d flashcards from these notes:\n\n%s", count, notes),
+		}},
+		MaxTokens: 4000,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Cards []Flashcard `json:"cards"`
+	}
+	if err := json.Unmarshal([]byte(llm.ExtractJSON(reply)), &parsed); err != nil {
+		return nil, fmt.Errorf("unparseable reply: %w", err)
+	}
+	cards := []Flashcard{}
+	for _, card := range parsed.Cards {
+		card = Flashcard{Question: card.Question, Answer: card.Answer, Time: defaultCardTime}
+		if cleanCardContent(&card) != nil || card.Question == "" || card.Answer == "" {
+			continue
+		}
+		cards = append(cards, card)
+		if len(cards) == count {
+			break
+		}
+	}
+	return cards, nil
+}
+
+// saveDraftCourse writes a draft course with cards in one transaction and
+// fills in the card IDs.
+func saveDraftCourse(ctx context.Context, accountID int, name string, cards []Flashcard) (int, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var courseID int
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO courses (name, description, account_id, draft) VALUES ($1, $2, $3, TRUE) RETURNING id",
+		name, "", accountID,
+	).Scan(&courseID)
+	if err != nil {
+		return 0, fmt.Errorf("create course: %w", err)
+	}
+	for i := range cards {
+		err := tx.QueryRowContext(ctx,
+			"INSERT INTO flashcards (question, answer, time) VALUES ($1, $2, $3) RETURNING id",
+			cards[i].Question, cards[i].Answer, cards[i].Time,
+		).Scan(&cards[i].ID)
+		if err != nil {
+			return 0, fmt.Errorf("card %d: %w", i+1, err)
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO course_flashcards (course_id, flashcard_id, order_index) VALUES ($1, $2, $3)",
+			courseID, cards[i].ID, i)
+		if err != nil {
+			return 0, fmt.Errorf("link card %d: %w", i+1, err)
+		}
+	}
+	return courseID, tx.Commit()
+}
+
+// MarkCourseReadyHandler ends the review of a draft course, listing it
+// with the other courses and allowing it to be published. Editors and
+// owners may do this.
+func MarkCourseReadyHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+	if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+
+	if _, err := db.DB.ExecContext(r.Context(), "UPDATE courses SET draft = FALSE WHERE id = $1 AND draft", courseID); err != nil {
+		log.Printf("Failed to mark course %d ready: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to update course"))
+		return
+	}
+	invalidateCourse(r.Context(), courseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"course_id": courseID, "draft": false})
+}
@@ -0,0 +1,165 @@
+package flashcards
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/handlers/operations"
+	"allanswebterminal/sanitize"
+)
+
+const (
+	maxImportCards     = 1000
+	defaultCardTime    = 30
+	maxCourseNameChars = 100
+)
+
+type ImportDeckRequest struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Cards       []Flashcard `json:"cards"`
+}
+
+type ImportDeckResult struct {
+	CourseID int `json:"course_id"`
+	Cards    int `json:"cards"`
+}
+
+// ImportDeckHandler validates a deck and imports it in the background,
+// answering 202 with an operation whose progress is streamed from
+// /api/operations/events.
+func ImportDeckHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req ImportDeckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := validateImportDeck(&req); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	op := operations.Start("deck_import", user.ID)
+	go importDeck(op, user.ID, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"operation_id": op.ID,
+		"events_url":   "/api/operations/events?id=" + op.ID,
+	})
+}
+
+func validateImportDeck(req *ImportDeckRequest) error {
+	req.Name = strings.TrimSpace(sanitize.Text(req.Name))
+	req.Description = strings.TrimSpace(sanitize.HTML(req.Description))
+	if req.Name == "" {
+		return fmt.Errorf("deck name is required")
+	}
+	if len(req.Name) > maxCourseNameChars {
+		return fmt.Errorf("deck name must be at most %d characters", maxCourseNameChars)
+	}
+	if len(req.Cards) == 0 {
+		return fmt.Errorf("deck has no cards")
+	}
+	if len(req.Cards) > maxImportCards {
+		return fmt.Errorf("deck has more than %d cards", maxImportCards)
+	}
+	for i := range req.Cards {
+		card := &req.Cards[i]
+		if err := cleanCardContent(card); err != nil {
+			return fmt.Errorf("card %d: %w", i+1, err)
+		}
+		if card.Question == "" || card.Answer == "" {
+			return fmt.Errorf("card %d needs a question and an answer", i+1)
+		}
+		if card.Time <= 0 {
+			card.Time = defaultCardTime
+		}
+	}
+	return nil
+}
+
+// importDeck writes the course and its cards in one transaction, reporting
+// progress roughly every percent.
+func importDeck(op *operations.Operation, accountID int, req ImportDeckRequest) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Printf("Deck import failed to begin transaction: %v", err)
+		op.Fail("Import failed")
+		return
+	}
+	defer tx.Rollback()
+
+	var courseID int
+	err = tx.QueryRow(
+		"INSERT INTO courses (name, description, account_id) VALUES ($1, $2, $3) RETURNING id",
+		req.Name, req.Description, accountID,
+	).Scan(&courseID)
+	if err != nil {
+		log.Printf("Deck import failed to create course: %v", err)
+		op.Fail("Import failed")
+		return
+	}
+
+	total := len(req.Cards)
+	step := total / 100
+	if step == 0 {
+		step = 1
+	}
+	for i, card := range req.Cards {
+		var cardID int
+		err := tx.QueryRow(
+			"INSERT INTO flashcards (question, answer, time) VALUES ($1, $2, $3) RETURNING id",
+			card.Question, card.Answer, card.Time,
+		).Scan(&cardID)
+		if err != nil {
+			log.Printf("Deck import failed on card %d: %v", i+1, err)
+			op.Fail(fmt.Sprintf("Import failed on card %d", i+1))
+			return
+		}
+		_, err = tx.Exec(
+			"INSERT INTO course_flashcards (course_id, flashcard_id, order_index) VALUES ($1, $2, $3)",
+			courseID, cardID, i,
+		)
+		if err != nil {
+			log.Printf("Deck import failed to link card %d: %v", i+1, err)
+			op.Fail(fmt.Sprintf("Import failed on card %d", i+1))
+			return
+		}
+
+		if done := i + 1; done%step == 0 || done == total {
+			op.Progress(done*100/total, fmt.Sprintf("Imported %d of %d cards", done, total))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Deck import failed to commit: %v", err)
+		op.Fail("Import failed")
+		return
+	}
+	invalidateCourse(context.Background(), courseID)
+
+	// Notify before completing, so nothing touches the database once the
+	// operation reports it is done.
+	_, err = notifications.Notify(context.Background(), accountID, notifications.KindJobFinished,
+		"Deck import finished", fmt.Sprintf("%q is ready with %d cards.", req.Name, total), "/flashcards")
+	if err != nil {
+		log.Printf("Deck import: %v", err)
+	}
+	op.Complete(ImportDeckResult{CourseID: courseID, Cards: total})
+}
@@ -0,0 +1,291 @@
+package flashcards
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+	"strconv"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cardcontent"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+)
+
+// Image occlusion cards hide regions of a diagram: each card masks one
+// region and asks for its label. In OcclusionHideOne mode only that region
+// is masked; in OcclusionHideAll every region is, so neighbours give no
+// hints.
+const (
+	OcclusionHideOne = "hide_one"
+	OcclusionHideAll = "hide_all"
+
+	maxOcclusionImage   = 2 << 20
+	maxOcclusionRegions = 50
+	maxLabelChars       = 200
+	defaultOcclusionAsk = "What is hidden?"
+)
+
+type OcclusionRegion struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Label  string `json:"label,omitempty"`
+}
+
+// Occlusion is stored with a card made from an image. Coordinates are in
+// pixels of the original image, so clients scale them with the image.
+type Occlusion struct {
+	MediaID     int               `json:"media_id"`
+	ImageWidth  int               `json:"image_width"`
+	ImageHeight int               `json:"image_height"`
+	Target      OcclusionRegion   `json:"target"`
+	Masks       []OcclusionRegion `json:"masks"`
+}
+
+type OcclusionRequest struct {
+	CourseID int `json:"course_id"`
+	// Image is a PNG, JPEG or GIF, base64-encoded in JSON.
+	Image   []byte            `json:"image"`
+	Regions []OcclusionRegion `json:"regions"`
+	Mode    string            `json:"mode"`
+	// Prompt is the question of every card, "What is hidden?" by default.
+	Prompt string `json:"prompt"`
+	Time   int    `json:"time"`
+}
+
+type OcclusionResult struct {
+	MediaID int         `json:"media_id"`
+	Cards   []Flashcard `json:"cards"`
+}
+
+// Validate checks the request and returns the image's content type and
+// size. Regions must lie inside the image and carry the label to recall.
+func (req *OcclusionRequest) Validate() (contentType string, width, height int, err error) {
+	if req.CourseID < 1 {
+		return "", 0, 0, errors.New("course_id is required")
+	}
+	if len(req.Image) == 0 {
+		return "", 0, 0, errors.New("image is required")
+	}
+	if len(req.Image) > maxOcclusionImage {
+		return "", 0, 0, fmt.Errorf("image must be at most %d MB", maxOcclusionImage>>20)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(req.Image))
+	if err != nil {
+		return "", 0, 0, errors.New("image must be a PNG, JPEG or GIF")
+	}
+
+	switch req.Mode {
+	case "":
+		req.Mode = OcclusionHideOne
+	case OcclusionHideOne, OcclusionHideAll:
+	default:
+		return "", 0, 0, errors.New(`mode must be "hide_one" or "hide_all"`)
+	}
+	if req.Prompt, err = cardcontent.Clean(req.Prompt); err != nil {
+		return "", 0, 0, fmt.Errorf("prompt: %w", err)
+	}
+	if req.Prompt == "" {
+		req.Prompt = defaultOcclusionAsk
+	}
+	if req.Time < 0 {
+		return "", 0, 0, errors.New("time must not be negative")
+	}
+	if req.Time == 0 {
+		req.Time = defaultCardTime
+	}
+
+	if len(req.Regions) == 0 {
+		return "", 0, 0, errors.New("at least one region is required")
+	}
+	if len(req.Regions) > maxOcclusionRegions {
+		return "", 0, 0, fmt.Errorf("at most %d regions are allowed", maxOcclusionRegions)
+	}
+	for i := range req.Regions {
+		region := &req.Regions[i]
+		if region.Label, err = cardcontent.Clean(region.Label); err != nil {
+			return "", 0, 0, fmt.Errorf("region %d label: %w", i+1, err)
+		}
+		if region.Label == "" {
+			return "", 0, 0, fmt.Errorf("region %d needs a label", i+1)
+		}
+		if len(region.Label) > maxLabelChars {
+			return "", 0, 0, fmt.Errorf("region %d label must be at most %d characters", i+1, maxLabelChars)
+		}
+		if region.Width < 1 || region.Height < 1 || region.X < 0 || region.Y < 0 ||
+			region.X+region.Width > cfg.Width || region.Y+region.Height > cfg.Height {
+			return "", 0, 0, fmt.Errorf("region %d must lie inside the %dx%d image", i+1, cfg.Width, cfg.Height)
+		}
+	}
+	return "image/" + format, cfg.Width, cfg.Height, nil
+}
+
+// occlusionCards makes one card per region of req, masking regions
+// according to req.Mode. MediaID is filled in once the image is stored.
+func occlusionCards(req OcclusionRequest, width, height int) []Flashcard {
+	// Labels stay out of the stored regions: they are the answers.
+	unlabeled := make([]OcclusionRegion, len(req.Regions))
+	for i, region := range req.Regions {
+		region.Label = ""
+		unlabeled[i] = region
+	}
+
+	cards := make([]Flashcard, len(req.Regions))
+	for i, region := range req.Regions {
+		occ := &Occlusion{ImageWidth: width, ImageHeight: height, Target: unlabeled[i]}
+		if req.Mode == OcclusionHideAll {
+			occ.Masks = unlabeled
+		} else {
+			occ.Masks = unlabeled[i : i+1]
+		}
+		cards[i] = Flashcard{Question: req.Prompt, Answer: region.Label, Time: req.Time, Occlusion: occ}
+	}
+	return cards
+}
+
+// occlusionValues returns the media_id and occlusion column values of card.
+func (card *Flashcard) occlusionValues() (interface{}, interface{}) {
+	if card.Occlusion == nil {
+		return nil, nil
+	}
+	raw, _ := json.Marshal(card.Occlusion)
+	return card.Occlusion.MediaID, raw
+}
+
+// CreateOcclusionCardsHandler stores an image and adds one occlusion card
+// per region to a course the caller edits, answering 201 with the cards.
+func CreateOcclusionCardsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req OcclusionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	contentType, width, height, err := req.Validate()
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if !requireCourseRole(w, r, req.CourseID, user, RoleEditor) {
+		return
+	}
+
+	result, err := createOcclusionCards(r.Context(), user.ID, req, contentType, width, height)
+	if err != nil {
+		log.Printf("Failed to create occlusion cards in course %d: %v", req.CourseID, err)
+		apierror.Write(w, apierror.Internal("Failed to create occlusion cards"))
+		return
+	}
+	invalidateCourse(r.Context(), req.CourseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+func createOcclusionCards(ctx context.Context, accountID int, req OcclusionRequest, contentType string, width, height int) (*OcclusionResult, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &OcclusionResult{Cards: occlusionCards(req, width, height)}
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO card_media (account_id, content_type, width, height, data)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		accountID, contentType, width, height, req.Image,
+	).Scan(&result.MediaID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store image: %w", err)
+	}
+
+	var next int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(order_index) + 1, 0) FROM course_flashcards WHERE course_id = $1", req.CourseID,
+	).Scan(&next)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result.Cards {
+		card := &result.Cards[i]
+		card.Occlusion.MediaID = result.MediaID
+		mediaID, occlusion := card.occlusionValues()
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO flashcards (question, answer, time, media_id, occlusion)
+			 VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			card.Question, card.Answer, card.Time, mediaID, occlusion,
+		).Scan(&card.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create card %d: %w", i+1, err)
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO course_flashcards (course_id, flashcard_id, order_index) VALUES ($1, $2, $3)",
+			req.CourseID, card.ID, next+i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to link card %d: %w", i+1, err)
+		}
+	}
+	return result, tx.Commit()
+}
+
+// MediaHandler serves a card image. Course content is public, and media
+// never changes once stored, so it may be cached indefinitely.
+func MediaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid media ID"))
+		return
+	}
+
+	var contentType string
+	var data []byte
+	err = db.DB.QueryRowContext(r.Context(),
+		"SELECT content_type, data FROM card_media WHERE id = $1", id,
+	).Scan(&contentType, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Media not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load media %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to load media"))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(data)
+}
+
+// DeleteUnusedMedia removes images no card refers to any more, after their
+// cards were deleted. It runs from the scheduler.
+func DeleteUnusedMedia(ctx context.Context) error {
+	result, err := db.DB.ExecContext(ctx,
+		`DELETE FROM card_media m
+		 WHERE NOT EXISTS (SELECT 1 FROM flashcards f WHERE f.media_id = m.id)`)
+	if err != nil {
+		return fmt.Errorf("failed to delete unused card media: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Deleted %d unused card images", n)
+	}
+	return nil
+}
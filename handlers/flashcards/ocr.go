@@ -0,0 +1,176 @@
+package flashcards
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/ocr"
+)
+
+const (
+	maxOCRImage = 5 << 20
+	ocrTimeout  = 60 * time.Second
+)
+
+type OCRRequest struct {
+	// Image is a photo of a question and answer list: a PNG, JPEG or GIF,
+	// base64-encoded in JSON.
+	Image []byte `json:"image"`
+}
+
+// OCRResult holds card candidates read from a photo. Nothing is saved:
+// the client lets the user fix them and sends them to ImportDeckHandler.
+// Unparsed are the lines no card was made from.
+type OCRResult struct {
+	Text     string      `json:"text"`
+	Cards    []Flashcard `json:"cards"`
+	Unparsed []string    `json:"unparsed"`
+}
+
+// OCRImportHandler reads a photo of handwritten or printed questions and
+// answers with the configured OCR provider and returns card candidates.
+func OCRImportHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req OCRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if len(req.Image) == 0 {
+		apierror.Write(w, apierror.Validation("image is required"))
+		return
+	}
+	if len(req.Image) > maxOCRImage {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("image must be at most %d MB", maxOCRImage>>20)))
+		return
+	}
+	if _, _, err := image.DecodeConfig(bytes.NewReader(req.Image)); err != nil {
+		apierror.Write(w, apierror.Validation("image must be a PNG, JPEG or GIF"))
+		return
+	}
+	if !ocr.Configured() {
+		apierror.Write(w, apierror.Unavailable("Photo import is not configured"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), ocrTimeout)
+	defer cancel()
+	text, err := ocr.Recognize(ctx, req.Image)
+	if err != nil {
+		log.Printf("OCR failed for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Unavailable("The photo could not be read; try again later"))
+		return
+	}
+
+	cards, unparsed := parseCardLines(text)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OCRResult{Text: text, Cards: cards, Unparsed: unparsed})
+}
+
+// Labels are recognized in the interface's languages: English, Portuguese
+// and Spanish.
+var (
+	questionLabel = regexp.MustCompile(`(?i)^(?:q|question|p|pergunta|pregunta)\s*[:.)-]\s*(.*)$`)
+	answerLabel   = regexp.MustCompile(`(?i)^(?:a|answer|r|resposta|respuesta)\s*[:.)-]\s*(.*)$`)
+	numbering     = regexp.MustCompile(`^(?:\d+|[a-z])[.)]\s+`)
+	// cardSeparators split "question - answer" lines, tried in order.
+	cardSeparators = []string{"\t", " - ", " – ", " — ", " = ", " -> ", ": "}
+)
+
+// parseCardLines turns recognized text into cards. It understands
+// "Q: ... A: ..." pairs and lists with one card per line, such as
+// "1. CPU - central processing unit" or "What is 2+2? 4". A labelled
+// question runs until its answer; an answer goes on over lines starting in
+// lowercase, as wrapped sentences do. Other lines are returned as unparsed.
+func parseCardLines(text string) (cards []Flashcard, unparsed []string) {
+	cards, unparsed = []Flashcard{}, []string{}
+	var question string // a labelled question waiting for its answer
+	inAnswer := false   // the last line was a labelled answer
+	flushQuestion := func() {
+		if question != "" {
+			unparsed = append(unparsed, question)
+			question = ""
+		}
+	}
+	addCard := func(q, a string) {
+		card := Flashcard{Question: q, Answer: a, Time: defaultCardTime}
+		if cleanCardContent(&card) != nil || card.Question == "" || card.Answer == "" {
+			unparsed = append(unparsed, strings.TrimSpace(q+" "+a))
+			return
+		}
+		cards = append(cards, card)
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			inAnswer = false
+			continue
+		}
+		if m := questionLabel.FindStringSubmatch(line); m != nil {
+			flushQuestion()
+			question, inAnswer = strings.TrimSpace(m[1]), false
+			continue
+		}
+		if m := answerLabel.FindStringSubmatch(line); m != nil && question != "" {
+			addCard(question, strings.TrimSpace(m[1]))
+			question, inAnswer = "", true
+			continue
+		}
+		switch {
+		case question != "":
+			question += " " + line
+			continue
+		case inAnswer && len(cards) > 0 && startsLower(line):
+			cards[len(cards)-1].Answer += " " + line
+			continue
+		}
+		if q, a, ok := splitCardLine(numbering.ReplaceAllString(line, "")); ok {
+			addCard(q, a)
+			continue
+		}
+		unparsed = append(unparsed, line)
+	}
+	flushQuestion()
+	return cards, unparsed
+}
+
+func startsLower(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLower(r)
+}
+
+// splitCardLine splits a one-line card at its first separator, or after
+// the question mark of a question.
+func splitCardLine(line string) (question, answer string, ok bool) {
+	for _, sep := range cardSeparators {
+		if q, a, found := strings.Cut(line, sep); found {
+			q, a = strings.TrimSpace(q), strings.TrimSpace(a)
+			if q != "" && a != "" {
+				return q, a, true
+			}
+		}
+	}
+	if i := strings.Index(line, "? "); i > 0 {
+		if a := strings.TrimSpace(line[i+2:]); a != "" {
+			return line[:i+1], a, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,97 @@
+package flashcards
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"allanswebterminal/apierror"
+)
+
+// playTime is how long the game has been played at now, not counting
+// pauses. The caller holds session.mu.
+func (s *GameSession) playTime(now time.Time) time.Duration {
+	played := now.Sub(s.StartTime) - s.PausedFor
+	if s.PausedAt != nil {
+		played -= now.Sub(*s.PausedAt)
+	}
+	if played < 0 {
+		return 0
+	}
+	return played
+}
+
+// pause stops the game's clock at now. It reports whether the game was
+// running.
+func (s *GameSession) pause(now time.Time) bool {
+	if s.PausedAt != nil {
+		return false
+	}
+	s.PausedAt = &now
+	return true
+}
+
+// resume restarts the game's clock at now. It reports whether the game was
+// paused.
+func (s *GameSession) resume(now time.Time) bool {
+	if s.PausedAt == nil {
+		return false
+	}
+	if now.After(*s.PausedAt) {
+		s.PausedFor += now.Sub(*s.PausedAt)
+	}
+	s.PausedAt = nil
+	return true
+}
+
+// PauseGameHandler stops the clock of a game in progress; answers are
+// refused until it is resumed. Pausing a paused game changes nothing.
+func (g *Game) PauseGameHandler(w http.ResponseWriter, r *http.Request) {
+	g.setPaused(w, r, true)
+}
+
+// ResumeGameHandler restarts the clock of a paused game. Resuming a running
+// game changes nothing.
+func (g *Game) ResumeGameHandler(w http.ResponseWriter, r *http.Request) {
+	g.setPaused(w, r, false)
+}
+
+func (g *Game) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	sessionID, err := getSessionID(r)
+	if err != nil {
+		apierror.Write(w, apierror.Validation("Session ID required"))
+		return
+	}
+	session, err := getGameSession(sessionID)
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid session"))
+		return
+	}
+	user, _ := g.currentUser(r)
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.AccountID != 0 && (user == nil || user.ID != session.AccountID) {
+		apierror.Write(w, apierror.BadRequest("Invalid session"))
+		return
+	}
+	if err := validateGameInProgress(session); err != nil {
+		apierror.Write(w, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	var changed bool
+	if paused {
+		changed = session.pause(time.Now())
+	} else {
+		changed = session.resume(time.Now())
+	}
+	if changed {
+		// Other devices pause and resume with this one.
+		publishState(sessionID, session, nil)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newSessionState(sessionID, session, nil))
+}
@@ -0,0 +1,127 @@
+package flashcards
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/pagination"
+)
+
+// PublicDeck is a published deck as served by the public API. Cards is
+// only set when a single deck is fetched.
+type PublicDeck struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Author      string       `json:"author"`
+	Category    string       `json:"category"`
+	CardCount   int          `json:"card_count"`
+	Stars       int          `json:"stars"`
+	Rating      float64      `json:"rating"`
+	PublishedAt time.Time    `json:"published_at"`
+	Cards       []PublicCard `json:"cards,omitempty"`
+}
+
+// PublicCard is a card of a public deck. Image occlusion cards are left
+// out of the public API.
+type PublicCard struct {
+	ID       int    `json:"id"`
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+	Time     int    `json:"time"`
+	// Choices is set on multiple-choice cards.
+	Choices []string `json:"choices,omitempty"`
+}
+
+func publicDeck(d GalleryDeck) PublicDeck {
+	return PublicDeck{
+		ID: d.CourseID, Name: d.Name, Description: d.Description, Author: d.Author, Category: d.Category,
+		CardCount: d.Cards, Stars: d.Stars, Rating: d.Rating, PublishedAt: d.PublishedAt,
+	}
+}
+
+// PublicDecksHandler lists published decks for the public API. It takes
+// the gallery's query parameters.
+func PublicDecksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, apiErr := pagination.Parse(q, galleryOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	category := q.Get("category")
+	if category != "" && !validCategory(category) {
+		apierror.Write(w, apierror.Validation("Unknown category"))
+		return
+	}
+
+	where, args := galleryFilter(0, category, q.Get("q"))
+	var total int
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT COUNT(*) FROM deck_listings l JOIN courses c ON c.id = l.course_id WHERE `+where,
+		args...,
+	).Scan(&total)
+	if err != nil {
+		log.Printf("Failed to count public decks: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load decks"))
+		return
+	}
+	decks, err := listGallery(r.Context(), galleryQuery(where, page, len(args)), append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to list public decks: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load decks"))
+		return
+	}
+
+	items := make([]PublicDeck, len(decks))
+	for i, d := range decks {
+		items[i] = publicDeck(d)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(items, total, page))
+}
+
+// PublicDeckHandler returns one published deck with its cards.
+func PublicDeckHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	where, args := galleryFilter(0, "", "")
+	args = append(args, courseID)
+	where += fmt.Sprintf(" AND l.course_id = $%d", len(args))
+	page := pagination.Params{Limit: 1, Sort: "name"}
+	decks, err := listGallery(r.Context(), galleryQuery(where, page, len(args)), append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to load public deck %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load deck"))
+		return
+	}
+	if len(decks) == 0 {
+		apierror.Write(w, apierror.NotFound("Deck not found"))
+		return
+	}
+
+	cards, err := cachedCourseFlashcards(r.Context(), courseID)
+	if err != nil {
+		log.Printf("Failed to load cards of public deck %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load deck"))
+		return
+	}
+	deck := publicDeck(decks[0])
+	deck.Cards = []PublicCard{}
+	for _, c := range cards {
+		if c.Occlusion == nil {
+			deck.Cards = append(deck.Cards, PublicCard{ID: c.ID, Question: c.Question, Answer: c.Answer, Time: c.Time, Choices: c.Choices})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deck)
+}
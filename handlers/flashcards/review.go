@@ -0,0 +1,250 @@
+package flashcards
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+)
+
+// Self-assessed grades of a review, mapped to SM-2 answer quality (0-5).
+// "again" is a lapse: the card restarts and comes back within the session.
+const (
+	GradeAgain = "again"
+	GradeHard  = "hard"
+	GradeGood  = "good"
+	GradeEasy  = "easy"
+)
+
+var gradeQuality = map[string]float64{GradeAgain: 1, GradeHard: 3, GradeGood: 4, GradeEasy: 5}
+
+const (
+	initialEase = 2.5
+	minEase     = 1.3
+	// relearnDelay is how soon a card graded "again" is due.
+	relearnDelay = 10 * time.Minute
+)
+
+// ReviewState is where a card stands in the spaced-repetition schedule of
+// one account.
+type ReviewState struct {
+	Ease         float64   `json:"ease"`
+	IntervalDays int       `json:"interval_days"`
+	Repetitions  int       `json:"repetitions"`
+	DueAt        time.Time `json:"due_at"`
+}
+
+// schedule applies SM-2 to the state after a review graded grade at now.
+// A new card starts from the zero ReviewState.
+func schedule(s ReviewState, grade string, now time.Time) ReviewState {
+	if s.Ease == 0 {
+		s.Ease = initialEase
+	}
+	q := gradeQuality[grade]
+	s.Ease = math.Max(minEase, s.Ease+0.1-(5-q)*(0.08+(5-q)*0.02))
+
+	if q < 3 {
+		s.Repetitions, s.IntervalDays = 0, 0
+		s.DueAt = now.Add(relearnDelay)
+		return s
+	}
+	switch s.Repetitions {
+	case 0:
+		s.IntervalDays = 1
+	case 1:
+		s.IntervalDays = 6
+	default:
+		s.IntervalDays = int(math.Round(float64(s.IntervalDays) * s.Ease))
+	}
+	s.Repetitions++
+	s.DueAt = now.AddDate(0, 0, s.IntervalDays)
+	return s
+}
+
+// ReviewCard is a card to review. Answer is only set when the client asked
+// for it to be revealed.
+type ReviewCard struct {
+	ID       int    `json:"id"`
+	Question string `json:"question"`
+	Answer   string `json:"answer,omitempty"`
+	Time     int    `json:"time"`
+	// New marks a card the account has never reviewed.
+	New       bool       `json:"new"`
+	Occlusion *Occlusion `json:"occlusion,omitempty"`
+}
+
+type ReviewNextResponse struct {
+	// Card is nil when nothing in the course is due.
+	Card *ReviewCard `json:"card"`
+	// Due counts the cards due now, including Card.
+	Due int `json:"due"`
+	// NextDueAt is when the next card falls due, if nothing is due now.
+	NextDueAt *time.Time `json:"next_due_at,omitempty"`
+}
+
+type ReviewGradeRequest struct {
+	FlashcardID int    `json:"flashcard_id"`
+	Grade       string `json:"grade"`
+}
+
+type ReviewGradeResponse struct {
+	FlashcardID int `json:"flashcard_id"`
+	ReviewState
+}
+
+// ReviewNextHandler returns the next card of ?course_id= due for the caller:
+// overdue cards first, then cards never reviewed in course order. The answer
+// is withheld unless ?reveal=true, so a client shows the question, then asks
+// again with reveal to flip the card; the same card comes back until it is
+// graded.
+func ReviewNextHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, err := parseCourseID(r)
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid course ID"))
+		return
+	}
+
+	resp, err := nextReview(r.Context(), user.ID, courseID, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to load next review of course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load the next card"))
+		return
+	}
+	if resp.Card != nil && r.URL.Query().Get("reveal") != "true" {
+		resp.Card.Answer = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func nextReview(ctx context.Context, accountID, courseID int, now time.Time) (*ReviewNextResponse, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT f.id, f.question, f.answer, f.time, f.occlusion, cr.due_at
+		 FROM course_flashcards cf
+		 JOIN flashcards f ON f.id = cf.flashcard_id
+		 LEFT JOIN card_reviews cr ON cr.flashcard_id = f.id AND cr.account_id = $2
+		 WHERE cf.course_id = $1
+		 ORDER BY cr.due_at NULLS LAST, cf.order_index`, courseID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resp := &ReviewNextResponse{}
+	var newCard *ReviewCard
+	for rows.Next() {
+		var card ReviewCard
+		var dueAt sql.NullTime
+		var occlusion []byte
+		if err := rows.Scan(&card.ID, &card.Question, &card.Answer, &card.Time, &occlusion, &dueAt); err != nil {
+			return nil, err
+		}
+		if occlusion != nil {
+			if err := json.Unmarshal(occlusion, &card.Occlusion); err != nil {
+				return nil, fmt.Errorf("card %d: invalid occlusion: %w", card.ID, err)
+			}
+		}
+		card.New = !dueAt.Valid
+		switch {
+		case card.New:
+			// Overdue cards take precedence over new ones.
+			if newCard == nil {
+				newCard = &card
+			}
+			resp.Due++
+		case !dueAt.Time.After(now):
+			if resp.Card == nil {
+				resp.Card = &card
+			}
+			resp.Due++
+		case resp.NextDueAt == nil:
+			resp.NextDueAt = &dueAt.Time
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if resp.Card == nil {
+		resp.Card = newCard
+	}
+	if resp.Card != nil {
+		resp.NextDueAt = nil
+	}
+	return resp, nil
+}
+
+// ReviewGradeHandler records the caller's self-assessment of a card and
+// reschedules it. Reviews are never scored: they do not touch account_score,
+// so games played, leaderboards and card statistics are unaffected.
+func ReviewGradeHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req ReviewGradeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.FlashcardID < 1 {
+		apierror.Write(w, apierror.Validation("flashcard_id is required"))
+		return
+	}
+	if _, ok := gradeQuality[req.Grade]; !ok {
+		apierror.Write(w, apierror.Validation("grade must be again, hard, good or easy"))
+		return
+	}
+
+	var state ReviewState
+	err = db.DB.QueryRowContext(r.Context(),
+		`SELECT ease, interval_days, repetitions, due_at FROM card_reviews
+		 WHERE account_id = $1 AND flashcard_id = $2`, user.ID, req.FlashcardID,
+	).Scan(&state.Ease, &state.IntervalDays, &state.Repetitions, &state.DueAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to load review state of card %d: %v", req.FlashcardID, err)
+		apierror.Write(w, apierror.Internal("Failed to save review"))
+		return
+	}
+
+	now := time.Now().UTC()
+	state = schedule(state, req.Grade, now)
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO card_reviews
+		 (account_id, flashcard_id, ease, interval_days, repetitions, last_grade, due_at, reviewed_at)
+		 SELECT $1, id, $3, $4, $5, $6, $7, $8 FROM flashcards WHERE id = $2
+		 ON CONFLICT (account_id, flashcard_id) DO UPDATE SET
+		 ease = EXCLUDED.ease, interval_days = EXCLUDED.interval_days, repetitions = EXCLUDED.repetitions,
+		 last_grade = EXCLUDED.last_grade, due_at = EXCLUDED.due_at, reviewed_at = EXCLUDED.reviewed_at
+		 RETURNING flashcard_id`,
+		user.ID, req.FlashcardID, state.Ease, state.IntervalDays, state.Repetitions, req.Grade, state.DueAt, now,
+	).Scan(&req.FlashcardID)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Flashcard not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save review of card %d: %v", req.FlashcardID, err)
+		apierror.Write(w, apierror.Internal("Failed to save review"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReviewGradeResponse{FlashcardID: req.FlashcardID, ReviewState: state})
+}
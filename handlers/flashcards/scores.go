@@ -0,0 +1,249 @@
+package flashcards
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/pagination"
+)
+
+// exportFlushRows is how many CSV rows are written between flushes, so an
+// export reaches the client in chunks as it is read from the database.
+const exportFlushRows = 500
+
+// ScoreRecord is one saved answer of the caller's.
+type ScoreRecord struct {
+	ID          int       `json:"id"`
+	FlashcardID int       `json:"flashcard_id"`
+	Question    string    `json:"question"`
+	TimeScore   int       `json:"time_score"`
+	Correct     bool      `json:"correct"`
+	AnsweredAt  time.Time `json:"answered_at"`
+}
+
+var scoreSortColumns = map[string]string{
+	"answered": "s.answered_at",
+	"time":     "s.time_score",
+}
+
+var scoreListOptions = pagination.Options{
+	DefaultLimit: 50,
+	MaxLimit:     200,
+	Sorts:        []string{"answered", "time"},
+	DefaultSort:  "-answered",
+}
+
+// scoreFilter builds the WHERE clause shared by the history and the export
+// from the query parameters course_id, flashcard_id, correct, from and to.
+// from and to are RFC 3339 times or YYYY-MM-DD dates; a date in to includes
+// the whole day.
+func scoreFilter(accountID int, q url.Values) (string, []interface{}, *apierror.Error) {
+	where := "s.account_id = $1"
+	args := []interface{}{accountID}
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where += fmt.Sprintf(" AND "+clause, len(args))
+	}
+
+	for _, param := range []struct{ name, clause string }{
+		{"course_id", "EXISTS (SELECT 1 FROM course_flashcards cf WHERE cf.flashcard_id = s.flashcard_id AND cf.course_id = $%d)"},
+		{"flashcard_id", "s.flashcard_id = $%d"},
+	} {
+		if v := q.Get(param.name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id < 1 {
+				return "", nil, apierror.Validation(param.name + " must be a positive integer")
+			}
+			add(param.clause, id)
+		}
+	}
+	if v := q.Get("correct"); v != "" {
+		correct, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", nil, apierror.Validation("correct must be true or false")
+		}
+		add("s.correct_answer = $%d", correct)
+	}
+	for _, param := range []struct {
+		name, clause string
+		endOfDay     bool
+	}{
+		{"from", "s.answered_at >= $%d", false},
+		{"to", "s.answered_at < $%d", true},
+	} {
+		if v := q.Get(param.name); v != "" {
+			t, err := parseScoreTime(v, param.endOfDay)
+			if err != nil {
+				return "", nil, apierror.Validation(param.name + " must be an RFC 3339 time or a YYYY-MM-DD date")
+			}
+			add(param.clause, t)
+		}
+	}
+	return where, args, nil
+}
+
+// parseScoreTime parses an RFC 3339 time or a date. With endOfDay a date
+// means the start of the next day, for exclusive upper bounds.
+func parseScoreTime(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err == nil && endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, err
+}
+
+func scoreQuery(where string, page pagination.Params) string {
+	dir := "ASC"
+	if page.Desc {
+		dir = "DESC"
+	}
+	return fmt.Sprintf(`
+		SELECT s.id, COALESCE(s.flashcard_id, 0), COALESCE(f.question, ''), s.time_score, s.correct_answer, s.answered_at
+		FROM account_score s
+		LEFT JOIN flashcards f ON f.id = s.flashcard_id
+		WHERE %s
+		ORDER BY %s %s, s.id %s`, where, scoreSortColumns[page.Sort], dir, dir)
+}
+
+// ScoreHistoryHandler lists the caller's saved answers, newest first by
+// default. Query parameters: limit, offset, sort (answered or time, "-"
+// for descending) and the filters of scoreFilter.
+func ScoreHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	q := r.URL.Query()
+	page, apiErr := pagination.Parse(q, scoreListOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	where, args, apiErr := scoreFilter(user.ID, q)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	var total int
+	err = db.DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM account_score s WHERE "+where, args...).Scan(&total)
+	if err != nil {
+		log.Printf("Failed to count scores for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load score history"))
+		return
+	}
+	n := len(args)
+	rows, err := db.DB.QueryContext(r.Context(),
+		scoreQuery(where, page)+fmt.Sprintf(" LIMIT $%d OFFSET $%d", n+1, n+2),
+		append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to load scores for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load score history"))
+		return
+	}
+	defer rows.Close()
+
+	scores := []ScoreRecord{}
+	for rows.Next() {
+		var s ScoreRecord
+		if err := rows.Scan(&s.ID, &s.FlashcardID, &s.Question, &s.TimeScore, &s.Correct, &s.AnsweredAt); err != nil {
+			log.Printf("Failed to scan score: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load score history"))
+			return
+		}
+		scores = append(scores, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(scores, total, page))
+}
+
+// ExportScoresHandler streams the caller's answers as CSV, with the filters
+// and sort of ScoreHistoryHandler but no page limit. Rows are written as
+// they are read, flushing every exportFlushRows, so large histories are
+// never held in memory. Once rows are sent a failure can no longer change
+// the status; the download then ends early and the error is logged.
+func ExportScoresHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	q := r.URL.Query()
+	q.Del("limit")
+	q.Del("offset")
+	page, apiErr := pagination.Parse(q, scoreListOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	where, args, apiErr := scoreFilter(user.ID, q)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(), scoreQuery(where, page), args...)
+	if err != nil {
+		log.Printf("Failed to export scores for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to export scores"))
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="scores.csv"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	flusher := http.NewResponseController(w)
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "flashcard_id", "question", "time_score", "correct", "answered_at"})
+
+	var n int
+	for rows.Next() {
+		var s ScoreRecord
+		if err := rows.Scan(&s.ID, &s.FlashcardID, &s.Question, &s.TimeScore, &s.Correct, &s.AnsweredAt); err != nil {
+			log.Printf("Failed to scan score during export for account %d: %v", user.ID, err)
+			break
+		}
+		out.Write([]string{
+			strconv.Itoa(s.ID), strconv.Itoa(s.FlashcardID), csvSafe(s.Question),
+			strconv.Itoa(s.TimeScore), strconv.FormatBool(s.Correct), s.AnsweredAt.UTC().Format(time.RFC3339),
+		})
+		if n++; n%exportFlushRows == 0 {
+			out.Flush()
+			if err := out.Error(); err != nil {
+				// The client went away.
+				return
+			}
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Score export for account %d ended early: %v", user.ID, err)
+	}
+	out.Flush()
+}
+
+// csvSafe keeps spreadsheets from running card text as a formula.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
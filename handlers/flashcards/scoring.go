@@ -0,0 +1,279 @@
+package flashcards
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+)
+
+// Time bonus curves.
+const (
+	CurveNone        = "none"
+	CurveLinear      = "linear"
+	CurveExponential = "exponential"
+)
+
+const (
+	maxScoringPoints = 1000
+	maxStreakTiers   = 10
+	maxMultiplier    = 10.0
+	maxHalfLife      = 3600
+)
+
+// ScoringRules decide how many points an answer earns in a course's games.
+// A correct answer earns BasePoints plus a time bonus, both multiplied by
+// the highest streak tier reached; every hint used costs HintPenalty. An
+// answer never scores below zero.
+type ScoringRules struct {
+	BasePoints  int          `json:"base_points"`
+	Streaks     []StreakTier `json:"streak_multipliers"`
+	TimeBonus   TimeBonus    `json:"time_bonus"`
+	HintPenalty int          `json:"hint_penalty"`
+}
+
+// StreakTier applies Multiplier from the Streak-th correct answer in a row.
+type StreakTier struct {
+	Streak     int     `json:"streak"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// TimeBonus rewards fast answers with up to MaxPoints. Linear falls to zero
+// at the card's time limit; exponential halves every HalfLife seconds.
+// Answers past the time limit earn no bonus on either curve.
+type TimeBonus struct {
+	Curve     string `json:"curve"`
+	MaxPoints int    `json:"max_points"`
+	HalfLife  int    `json:"half_life_seconds,omitempty"`
+}
+
+// PointsBreakdown itemizes the points of one answer or a whole game.
+type PointsBreakdown struct {
+	Base        int `json:"base"`
+	TimeBonus   int `json:"time_bonus"`
+	StreakBonus int `json:"streak_bonus"`
+	HintPenalty int `json:"hint_penalty"`
+	Total       int `json:"total"`
+}
+
+func (p *PointsBreakdown) add(o PointsBreakdown) {
+	p.Base += o.Base
+	p.TimeBonus += o.TimeBonus
+	p.StreakBonus += o.StreakBonus
+	p.HintPenalty += o.HintPenalty
+	p.Total += o.Total
+}
+
+// DefaultScoringRules are used by courses without rules of their own and by
+// guest games.
+func DefaultScoringRules() ScoringRules {
+	return ScoringRules{
+		BasePoints: 100,
+		Streaks: []StreakTier{
+			{Streak: 3, Multiplier: 1.5},
+			{Streak: 5, Multiplier: 2},
+		},
+		TimeBonus:   TimeBonus{Curve: CurveLinear, MaxPoints: 50},
+		HintPenalty: 25,
+	}
+}
+
+// Validate reports the first problem with the rules, or nil.
+func (rules ScoringRules) Validate() error {
+	if rules.BasePoints < 0 || rules.BasePoints > maxScoringPoints {
+		return fmt.Errorf("base_points must be between 0 and %d", maxScoringPoints)
+	}
+	if rules.HintPenalty < 0 || rules.HintPenalty > maxScoringPoints {
+		return fmt.Errorf("hint_penalty must be between 0 and %d", maxScoringPoints)
+	}
+	if len(rules.Streaks) > maxStreakTiers {
+		return fmt.Errorf("at most %d streak_multipliers are allowed", maxStreakTiers)
+	}
+	for i, tier := range rules.Streaks {
+		if tier.Streak < 2 {
+			return errors.New("streak_multipliers must start at a streak of 2 or more")
+		}
+		if tier.Multiplier < 1 || tier.Multiplier > maxMultiplier {
+			return fmt.Errorf("streak multipliers must be between 1 and %g", maxMultiplier)
+		}
+		if i > 0 && (tier.Streak <= rules.Streaks[i-1].Streak || tier.Multiplier < rules.Streaks[i-1].Multiplier) {
+			return errors.New("streak_multipliers must be ordered by increasing streak and must not decrease")
+		}
+	}
+
+	bonus := rules.TimeBonus
+	switch bonus.Curve {
+	case CurveNone, CurveLinear:
+	case CurveExponential:
+		if bonus.HalfLife < 1 || bonus.HalfLife > maxHalfLife {
+			return fmt.Errorf("time_bonus.half_life_seconds must be between 1 and %d", maxHalfLife)
+		}
+	default:
+		return fmt.Errorf("time_bonus.curve must be one of %s, %s, %s", CurveNone, CurveLinear, CurveExponential)
+	}
+	if bonus.MaxPoints < 0 || bonus.MaxPoints > maxScoringPoints {
+		return fmt.Errorf("time_bonus.max_points must be between 0 and %d", maxScoringPoints)
+	}
+	return nil
+}
+
+// multiplier returns the multiplier of the highest tier streak reaches.
+func (rules ScoringRules) multiplier(streak int) float64 {
+	m := 1.0
+	for _, tier := range rules.Streaks {
+		if streak >= tier.Streak {
+			m = tier.Multiplier
+		}
+	}
+	return m
+}
+
+// timeBonus returns the bonus for answering in seconds out of limit.
+func (rules ScoringRules) timeBonus(seconds, limit int) int {
+	bonus := rules.TimeBonus
+	if seconds < 0 {
+		seconds = 0
+	}
+	if limit > 0 && seconds >= limit {
+		return 0
+	}
+	switch bonus.Curve {
+	case CurveLinear:
+		if limit <= 0 {
+			return 0
+		}
+		return int(math.Round(float64(bonus.MaxPoints) * float64(limit-seconds) / float64(limit)))
+	case CurveExponential:
+		return int(math.Round(float64(bonus.MaxPoints) * math.Exp2(-float64(seconds)/float64(bonus.HalfLife))))
+	}
+	return 0
+}
+
+// score returns the points of one answer. streak counts consecutive correct
+// answers including this one.
+func (rules ScoringRules) score(correct bool, streak, seconds, limit, hints int) PointsBreakdown {
+	var p PointsBreakdown
+	if correct {
+		p.Base = rules.BasePoints
+		p.TimeBonus = rules.timeBonus(seconds, limit)
+		raw := p.Base + p.TimeBonus
+		p.StreakBonus = int(math.Round(float64(raw)*rules.multiplier(streak))) - raw
+	}
+	gross := p.Base + p.TimeBonus + p.StreakBonus
+	p.HintPenalty = min(hints*rules.HintPenalty, gross)
+	p.Total = gross - p.HintPenalty
+	return p
+}
+
+// sessionRules returns the rules a session was started with. Sessions
+// restored from before scoring rules existed use the defaults.
+func sessionRules(session *GameSession) ScoringRules {
+	if session.Rules == nil {
+		return DefaultScoringRules()
+	}
+	return *session.Rules
+}
+
+// loadScoringRules returns a course's rules, or the defaults if it has
+// none.
+func loadScoringRules(ctx context.Context, courseID int) (ScoringRules, error) {
+	var raw []byte
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT rules FROM course_scoring WHERE course_id = $1`, courseID,
+	).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultScoringRules(), nil
+	}
+	if err != nil {
+		return ScoringRules{}, err
+	}
+	var rules ScoringRules
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return ScoringRules{}, fmt.Errorf("decode scoring rules of course %d: %w", courseID, err)
+	}
+	return rules, nil
+}
+
+// ScoringRulesHandler returns the scoring rules of the course in the path.
+func ScoringRulesHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+	var exists bool
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM courses WHERE id = $1)`, courseID).Scan(&exists)
+	if err != nil {
+		log.Printf("Failed to load course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load scoring rules"))
+		return
+	}
+	if !exists {
+		apierror.Write(w, apierror.NotFound("Course not found"))
+		return
+	}
+
+	rules, err := loadScoringRules(r.Context(), courseID)
+	if err != nil {
+		log.Printf("Failed to load scoring rules of course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load scoring rules"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// SetScoringRulesHandler replaces the scoring rules of a course. Only the
+// course owner or an admin may change them; games already in progress keep
+// the rules they started with.
+func SetScoringRulesHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	courseID, ok := pathCourseID(w, r)
+	if !ok {
+		return
+	}
+
+	var rules ScoringRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if rules.Streaks == nil {
+		rules.Streaks = []StreakTier{}
+	}
+	if err := rules.Validate(); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	if !requireCourseRole(w, r, courseID, user, RoleEditor) {
+		return
+	}
+
+	raw, _ := json.Marshal(rules)
+	_, err = db.DB.ExecContext(r.Context(),
+		`INSERT INTO course_scoring (course_id, rules, updated_at)
+		 VALUES ($1, $2, CURRENT_TIMESTAMP)
+		 ON CONFLICT (course_id) DO UPDATE SET rules = EXCLUDED.rules, updated_at = CURRENT_TIMESTAMP`,
+		courseID, raw)
+	if err != nil {
+		log.Printf("Failed to save scoring rules of course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to save scoring rules"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
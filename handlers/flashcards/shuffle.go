@@ -0,0 +1,94 @@
+package flashcards
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+
+	"allanswebterminal/apierror"
+)
+
+// maxSeed bounds shuffle seeds so they survive JSON in JavaScript, whose
+// numbers are exact only up to 2^53.
+const maxSeed = 1 << 53
+
+// newSeed returns a random shuffle seed.
+func newSeed() int64 {
+	return rand.Int64N(maxSeed)
+}
+
+func checkSeed(seed int64) error {
+	if seed < 0 || seed >= maxSeed {
+		return fmt.Errorf("seed must be between 0 and %d", int64(maxSeed-1))
+	}
+	return nil
+}
+
+// shuffleCards returns cards in the order seed gives them. The same seed
+// and cards always give the same order, so a shuffled game can be replayed
+// or audited from its seed. cards is not modified.
+func shuffleCards(cards []Flashcard, seed int64) []Flashcard {
+	shuffled := append([]Flashcard(nil), cards...)
+	rng := rand.New(rand.NewPCG(uint64(seed), 0))
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// parseShuffle reads the shuffle mode of a game start: seed=N replays that
+// seed, shuffle=true picks a new one, and neither keeps the deck's order
+// (nil).
+func parseShuffle(r *http.Request) (*int64, error) {
+	query := r.URL.Query()
+	if s := query.Get("seed"); s != "" {
+		seed, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("seed must be a number")
+		}
+		if err := checkSeed(seed); err != nil {
+			return nil, err
+		}
+		return &seed, nil
+	}
+	if shuffle, _ := strconv.ParseBool(query.Get("shuffle")); shuffle {
+		seed := newSeed()
+		return &seed, nil
+	}
+	return nil, nil
+}
+
+// RestartGameRequest names a finished or abandoned game to play again in
+// the same order: its course, or the guest cards it was made of, and the
+// seed from its results.
+type RestartGameRequest struct {
+	CourseID     int   `json:"course_id"`
+	FlashcardIDs []int `json:"flashcard_ids"`
+	Seed         int64 `json:"seed"`
+}
+
+// RestartGameHandler starts a new game shuffled with the seed of an earlier
+// one. While the deck is unchanged the cards come in the same order.
+func (g *Game) RestartGameHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req RestartGameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := checkSeed(req.Seed); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	switch {
+	case req.CourseID != 0 && len(req.FlashcardIDs) == 0:
+		g.startCourseGame(w, r, req.CourseID, &req.Seed)
+	case req.CourseID == 0 && len(req.FlashcardIDs) != 0:
+		g.startGuestGame(w, r, req.FlashcardIDs, &req.Seed)
+	default:
+		apierror.Write(w, apierror.Validation("Give either course_id or flashcard_ids"))
+	}
+}
@@ -0,0 +1,194 @@
+package flashcards
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+const (
+	defaultHardestLimit       = 10
+	maxHardestLimit           = 50
+	defaultHardestMinAttempts = 5
+)
+
+// CardStats are the difficulty metrics of one card, as of the last refresh.
+// Difficulty runs from 0 (everyone answers fast and right) to 1.
+type CardStats struct {
+	FlashcardID       int        `json:"flashcard_id"`
+	Question          string     `json:"question,omitempty"`
+	Attempts          int        `json:"attempts"`
+	Correct           int        `json:"correct"`
+	Accuracy          float64    `json:"accuracy"`
+	MedianTimeSeconds float64    `json:"median_time_seconds"`
+	Difficulty        float64    `json:"difficulty"`
+	RefreshedAt       *time.Time `json:"refreshed_at,omitempty"`
+}
+
+// refreshStatsQuery rebuilds flashcard_stats from account_score, leaving
+// out flagged answers. Difficulty weighs wrong answers at 70% and slowness,
+// the median time as a share of the card's time limit, at 30%.
+const refreshStatsQuery = `
+	INSERT INTO flashcard_stats (flashcard_id, attempts, correct, accuracy, median_time_seconds, difficulty, refreshed_at)
+	SELECT s.flashcard_id,
+		s.attempts,
+		s.correct,
+		s.correct::float8 / s.attempts,
+		s.median_time,
+		0.7 * (1 - s.correct::float8 / s.attempts) + 0.3 * LEAST(s.median_time / GREATEST(f.time, 1), 1),
+		$1
+	FROM (
+		SELECT flashcard_id,
+			COUNT(*) AS attempts,
+			COUNT(*) FILTER (WHERE correct_answer) AS correct,
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY time_score) AS median_time
+		FROM account_score
+		WHERE flashcard_id IS NOT NULL AND flags = '{}'
+		GROUP BY flashcard_id
+	) s
+	JOIN flashcards f ON f.id = s.flashcard_id
+	ON CONFLICT (flashcard_id) DO UPDATE SET
+		attempts = EXCLUDED.attempts,
+		correct = EXCLUDED.correct,
+		accuracy = EXCLUDED.accuracy,
+		median_time_seconds = EXCLUDED.median_time_seconds,
+		difficulty = EXCLUDED.difficulty,
+		refreshed_at = EXCLUDED.refreshed_at`
+
+// RefreshCardStats recomputes every card's difficulty metrics. It runs as a
+// scheduled job; rows for cards that no longer have any answers (for
+// example after a merge moved them) are removed.
+func RefreshCardStats(ctx context.Context) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, refreshStatsQuery, now); err != nil {
+		return fmt.Errorf("refresh flashcard stats: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM flashcard_stats WHERE refreshed_at < $1`, now); err != nil {
+		return fmt.Errorf("prune flashcard stats: %w", err)
+	}
+	return tx.Commit()
+}
+
+// CardStatsHandler returns the difficulty metrics of the card in the path.
+// Cards nobody has answered yet report zero attempts.
+func CardStatsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid flashcard ID"))
+		return
+	}
+
+	stats := CardStats{FlashcardID: id}
+	var attempts, correct sql.NullInt64
+	var accuracy, median, difficulty sql.NullFloat64
+	var refreshed sql.NullTime
+	err = db.DB.QueryRowContext(r.Context(),
+		`SELECT f.question, s.attempts, s.correct, s.accuracy, s.median_time_seconds, s.difficulty, s.refreshed_at
+		 FROM flashcards f
+		 LEFT JOIN flashcard_stats s ON s.flashcard_id = f.id
+		 WHERE f.id = $1`, id,
+	).Scan(&stats.Question, &attempts, &correct, &accuracy, &median, &difficulty, &refreshed)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Flashcard not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load stats for flashcard %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to load flashcard stats"))
+		return
+	}
+	stats.Attempts = int(attempts.Int64)
+	stats.Correct = int(correct.Int64)
+	stats.Accuracy = accuracy.Float64
+	stats.MedianTimeSeconds = median.Float64
+	stats.Difficulty = difficulty.Float64
+	if refreshed.Valid {
+		stats.RefreshedAt = &refreshed.Time
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// HardestCardsHandler reports a course's hardest cards, most difficult
+// first. Query parameters: limit (default 10, max 50) and min_attempts
+// (default 5), which keeps barely-answered cards from dominating.
+func HardestCardsHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid course ID"))
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultHardestLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHardestLimit {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("limit must be between 1 and %d", maxHardestLimit)))
+			return
+		}
+		limit = n
+	}
+	minAttempts := defaultHardestMinAttempts
+	if v := q.Get("min_attempts"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			apierror.Write(w, apierror.Validation("min_attempts must be a positive integer"))
+			return
+		}
+		minAttempts = n
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT s.flashcard_id, f.question, s.attempts, s.correct, s.accuracy,
+			s.median_time_seconds, s.difficulty, s.refreshed_at
+		 FROM course_flashcards cf
+		 JOIN flashcards f ON f.id = cf.flashcard_id
+		 JOIN flashcard_stats s ON s.flashcard_id = cf.flashcard_id
+		 WHERE cf.course_id = $1 AND s.attempts >= $2
+		 ORDER BY s.difficulty DESC, s.flashcard_id
+		 LIMIT $3`, courseID, minAttempts, limit)
+	if err != nil {
+		log.Printf("Failed to load hardest cards for course %d: %v", courseID, err)
+		apierror.Write(w, apierror.Internal("Failed to load course report"))
+		return
+	}
+	defer rows.Close()
+
+	cards := []CardStats{}
+	for rows.Next() {
+		var s CardStats
+		var refreshed time.Time
+		err := rows.Scan(&s.FlashcardID, &s.Question, &s.Attempts, &s.Correct, &s.Accuracy,
+			&s.MedianTimeSeconds, &s.Difficulty, &refreshed)
+		if err != nil {
+			log.Printf("Failed to scan hardest card: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load course report"))
+			return
+		}
+		s.RefreshedAt = &refreshed
+		cards = append(cards, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"course_id": courseID,
+		"cards":     cards,
+	})
+}
@@ -0,0 +1,207 @@
+package flashcards
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+
+	"allanswebterminal/db"
+
+	"github.com/lib/pq"
+)
+
+// Store holds what the game handlers read and write: courses and their
+// cards, scoring rules, scores and finished games. DBStore keeps them in
+// the database; MemoryStore keeps them in memory for tests.
+type Store interface {
+	// Courses lists the courses that are not drafts, by name.
+	Courses(ctx context.Context) ([]Course, error)
+	// CourseFlashcards returns a course's cards in play order.
+	CourseFlashcards(ctx context.Context, courseID int) ([]Flashcard, error)
+	// GuestFlashcards returns the cards in no course, which guests practise.
+	GuestFlashcards(ctx context.Context) ([]Flashcard, error)
+	// SelectedFlashcards returns the cards with the given IDs, by ID.
+	// Unknown IDs are skipped.
+	SelectedFlashcards(ctx context.Context, ids []int) ([]Flashcard, error)
+	// ScoringRules returns a course's rules, or the defaults.
+	ScoringRules(ctx context.Context, courseID int) (ScoringRules, error)
+	SaveScore(ctx context.Context, accountID int, score ScoreResult) error
+	RecordGame(ctx context.Context, game PlayedGame) error
+}
+
+// PlayedGame is a finished game, for the admin statistics. AccountID is 0
+// for guests and CourseID 0 when the game had no course.
+type PlayedGame struct {
+	AccountID      int
+	CourseID       int
+	TotalQuestions int
+	CorrectAnswers int
+	// Flags are the cheating flags raised during the game, if any.
+	Flags []string
+}
+
+// DBStore returns the Store backed by db.DB. Course content is served
+// from the cache.
+func DBStore() Store {
+	return dbStore{}
+}
+
+type dbStore struct{}
+
+func (dbStore) Courses(ctx context.Context) ([]Course, error) {
+	return cachedCourses(ctx)
+}
+
+func (dbStore) CourseFlashcards(ctx context.Context, courseID int) ([]Flashcard, error) {
+	return cachedCourseFlashcards(ctx, courseID)
+}
+
+func (dbStore) GuestFlashcards(ctx context.Context) ([]Flashcard, error) {
+	return cachedGuestFlashcards(ctx)
+}
+
+func (dbStore) SelectedFlashcards(ctx context.Context, ids []int) ([]Flashcard, error) {
+	return getSelectedFlashcards(ids)
+}
+
+func (dbStore) ScoringRules(ctx context.Context, courseID int) (ScoringRules, error) {
+	return loadScoringRules(ctx, courseID)
+}
+
+func (dbStore) SaveScore(ctx context.Context, accountID int, score ScoreResult) error {
+	return saveScore(accountID, score)
+}
+
+func (dbStore) RecordGame(ctx context.Context, game PlayedGame) error {
+	if !db.Available() {
+		return nil
+	}
+	var accountID, courseID sql.NullInt64
+	if game.AccountID != 0 {
+		accountID = sql.NullInt64{Int64: int64(game.AccountID), Valid: true}
+	}
+	if game.CourseID != 0 {
+		courseID = sql.NullInt64{Int64: int64(game.CourseID), Valid: true}
+	}
+	_, err := db.DB.ExecContext(ctx,
+		"INSERT INTO games_played (account_id, course_id, total_questions, correct_answers, flags) VALUES ($1, $2, $3, $4, $5)",
+		accountID, courseID, game.TotalQuestions, game.CorrectAnswers, pq.Array(flagsOrEmpty(game.Flags)),
+	)
+	return err
+}
+
+// MemoryStore is a Store in memory. Fill in the exported fields before
+// use; the zero value is an empty catalogue.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	CourseList []Course
+	// Cards are the cards of each course, by course ID, in play order.
+	Cards map[int][]Flashcard
+	// Guest are the cards in no course.
+	Guest []Flashcard
+	// Rules are the courses' scoring rules; courses without use the
+	// defaults.
+	Rules map[int]ScoringRules
+	// Scores are the saved scores, by account ID.
+	Scores map[int][]ScoreResult
+	// Games are the finished games, in the order they were recorded.
+	Games []PlayedGame
+	// Err, if set, is returned by every method.
+	Err error
+}
+
+func (m *MemoryStore) Courses(ctx context.Context) ([]Course, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	courses := slices.Clone(m.CourseList)
+	slices.SortFunc(courses, func(a, b Course) int { return cmp.Compare(a.Name, b.Name) })
+	if courses == nil {
+		courses = []Course{}
+	}
+	return courses, nil
+}
+
+func (m *MemoryStore) CourseFlashcards(ctx context.Context, courseID int) ([]Flashcard, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return slices.Clone(m.Cards[courseID]), nil
+}
+
+func (m *MemoryStore) GuestFlashcards(ctx context.Context) ([]Flashcard, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return slices.Clone(m.Guest), nil
+}
+
+func (m *MemoryStore) SelectedFlashcards(ctx context.Context, ids []int) ([]Flashcard, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	found := map[int]Flashcard{}
+	collect := func(cards []Flashcard) {
+		for _, card := range cards {
+			if slices.Contains(ids, card.ID) {
+				found[card.ID] = card
+			}
+		}
+	}
+	collect(m.Guest)
+	for _, cards := range m.Cards {
+		collect(cards)
+	}
+	var selected []Flashcard
+	for _, card := range found {
+		selected = append(selected, card)
+	}
+	slices.SortFunc(selected, func(a, b Flashcard) int { return cmp.Compare(a.ID, b.ID) })
+	return selected, nil
+}
+
+func (m *MemoryStore) ScoringRules(ctx context.Context, courseID int) (ScoringRules, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return ScoringRules{}, m.Err
+	}
+	if rules, ok := m.Rules[courseID]; ok {
+		return rules, nil
+	}
+	return DefaultScoringRules(), nil
+}
+
+func (m *MemoryStore) SaveScore(ctx context.Context, accountID int, score ScoreResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	if m.Scores == nil {
+		m.Scores = map[int][]ScoreResult{}
+	}
+	m.Scores[accountID] = append(m.Scores[accountID], score)
+	return nil
+}
+
+func (m *MemoryStore) RecordGame(ctx context.Context, game PlayedGame) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Games = append(m.Games, game)
+	return nil
+}
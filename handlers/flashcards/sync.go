@@ -0,0 +1,131 @@
+package flashcards
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/ws"
+)
+
+// GameStateEventType is the WebSocket event type carrying a game's state
+// to the owner's other devices.
+const GameStateEventType = "game_state"
+
+// Publisher delivers an event to WebSocket subscribers; *ws.Hub satisfies it.
+type Publisher interface {
+	Publish(topic string, data interface{}) int
+}
+
+var (
+	publisherMu sync.RWMutex
+	publisher   Publisher
+)
+
+// SetPublisher enables live sync of signed-in players' games across their
+// devices. Pass nil to disable.
+func SetPublisher(p Publisher) {
+	publisherMu.Lock()
+	defer publisherMu.Unlock()
+	publisher = p
+}
+
+// SessionState is the server's view of a game. Devices replace their local
+// state with it whenever its version is newer than theirs.
+type SessionState struct {
+	SessionID    string      `json:"session_id"`
+	CourseID     int         `json:"course_id"`
+	Version      int         `json:"version"`
+	CurrentIndex int         `json:"current_index"`
+	Total        int         `json:"total_questions"`
+	CurrentCard  *Flashcard  `json:"current_card,omitempty"`
+	LastAnswer   *LastAnswer `json:"last_answer,omitempty"`
+	Complete     bool        `json:"game_complete"`
+	FinalScore   *FinalScore `json:"final_score,omitempty"`
+	// Paused is set while the player has paused the game.
+	Paused bool `json:"paused"`
+	// PlayTime is how long the game has been played, in seconds, not
+	// counting pauses.
+	PlayTime int `json:"play_time"`
+}
+
+// LastAnswer is the answer that produced a state, so other devices can show
+// the same feedback.
+type LastAnswer struct {
+	FlashcardID   int    `json:"flashcard_id"`
+	Correct       bool   `json:"correct"`
+	CorrectAnswer string `json:"correct_answer"`
+	Points        int    `json:"points"`
+}
+
+// newSessionState snapshots session. The caller holds session.mu or owns the
+// session exclusively.
+func newSessionState(sessionID string, session *GameSession, last *LastAnswer) SessionState {
+	state := SessionState{
+		SessionID:    sessionID,
+		CourseID:     session.CourseID,
+		Version:      session.Version,
+		CurrentIndex: session.CurrentIndex,
+		Total:        len(session.Flashcards),
+		LastAnswer:   last,
+		Paused:       session.PausedAt != nil,
+		PlayTime:     int(session.playTime(time.Now()).Seconds()),
+	}
+	if session.CurrentIndex < len(session.Flashcards) {
+		card := session.Flashcards[session.CurrentIndex]
+		state.CurrentCard = &card
+	} else {
+		state.Complete = true
+		state.FinalScore = calculateFinalScore(session.Scores)
+		state.FinalScore.Seed = session.Seed
+	}
+	return state
+}
+
+// publishState pushes the session's state to every device of its owner.
+// Guest sessions have no owner and are not synced.
+func publishState(sessionID string, session *GameSession, last *LastAnswer) {
+	if session.AccountID == 0 {
+		return
+	}
+	publisherMu.RLock()
+	p := publisher
+	publisherMu.RUnlock()
+	if p == nil {
+		return
+	}
+	p.Publish(ws.AccountTopic(session.AccountID), map[string]interface{}{
+		"type":  GameStateEventType,
+		"state": newSessionState(sessionID, session, last),
+	})
+}
+
+func ownsSession(r *http.Request, session *GameSession) bool {
+	user, err := login.GetCurrentUser(r)
+	return err == nil && user.ID == session.AccountID
+}
+
+// SessionStateHandler returns the current state of one of the caller's
+// games so a second device can join it.
+func SessionStateHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := getSessionID(r)
+	if err != nil {
+		apierror.Write(w, apierror.Validation("Session ID required"))
+		return
+	}
+	session, err := getGameSession(sessionID)
+	if err != nil || session.AccountID == 0 || !ownsSession(r, session) {
+		apierror.Write(w, apierror.NotFound("Game not found"))
+		return
+	}
+
+	session.mu.Lock()
+	state := newSessionState(sessionID, session, nil)
+	session.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,576 @@
+package github
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/login"
+)
+
+const (
+	// MaxExports bounds the exports an account keeps.
+	MaxExports = 50
+	// MaxExportFiles bounds the files of one export.
+	MaxExportFiles = 100
+)
+
+// Export kinds.
+const (
+	KindGist = "gist"
+	KindRepo = "repo"
+)
+
+var (
+	repoPattern   = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,38})/[A-Za-z0-9._-]{1,100}$`)
+	branchPattern = regexp.MustCompile(`^[A-Za-z0-9._][A-Za-z0-9._/-]{0,254}$`)
+)
+
+// Export is a gist or repository branch some of the caller's files were
+// pushed to.
+type Export struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+	// Remote is the gist's ID or the repository as owner/name.
+	Remote string   `json:"remote"`
+	Branch string   `json:"branch,omitempty"`
+	Files  []string `json:"files"`
+	URL    string   `json:"url"`
+	// Revision is the gist version or commit last pushed.
+	Revision  string    `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+	SyncedAt  time.Time `json:"synced_at"`
+}
+
+type ExportRequest struct {
+	Kind  string   `json:"kind"`
+	Files []string `json:"files"`
+	// Description and Public apply to gists, which are secret by default.
+	Description string `json:"description"`
+	Public      bool   `json:"public"`
+	// Repo, Branch and Message apply to repositories. The branch defaults
+	// to the repository's default branch and is created if missing.
+	Repo    string `json:"repo"`
+	Branch  string `json:"branch"`
+	Message string `json:"message"`
+}
+
+// SyncRequest optionally sets the commit message of a repository sync.
+type SyncRequest struct {
+	Message string `json:"message"`
+}
+
+func validateExport(req *ExportRequest) error {
+	switch req.Kind {
+	case KindGist, KindRepo:
+	default:
+		return fmt.Errorf("kind must be %q or %q", KindGist, KindRepo)
+	}
+	if len(req.Files) == 0 || len(req.Files) > MaxExportFiles {
+		return fmt.Errorf("files must name 1-%d files", MaxExportFiles)
+	}
+	seen := map[string]bool{}
+	for _, name := range req.Files {
+		key := name
+		if req.Kind == KindGist {
+			// Gists have no directories.
+			key = path.Base(name)
+		}
+		if name == "" || seen[key] {
+			return fmt.Errorf("%q is listed twice or has the name of another file", name)
+		}
+		seen[key] = true
+	}
+	if req.Kind == KindGist {
+		return nil
+	}
+	if !repoPattern.MatchString(req.Repo) {
+		return fmt.Errorf("repo must be owner/name")
+	}
+	if req.Branch != "" && (!branchPattern.MatchString(req.Branch) || strings.Contains(req.Branch, "..") || strings.HasSuffix(req.Branch, "/")) {
+		return fmt.Errorf("branch %q is not a valid branch name", req.Branch)
+	}
+	for _, name := range req.Files {
+		if path.IsAbs(name) || path.Clean(name) != name || strings.HasPrefix(name, "../") || strings.HasPrefix(name, ".git/") {
+			return fmt.Errorf("%q cannot be a path in a repository", name)
+		}
+	}
+	return nil
+}
+
+// exportFile is a file to push; a nil Content deletes it from the remote.
+type exportFile struct {
+	Name    string
+	Content *string
+}
+
+// loadExportFiles loads names of accountID. Files that no longer exist
+// are returned with nil content when missingOK, and fail otherwise.
+func loadExportFiles(ctx context.Context, accountID int, names []string, missingOK bool) ([]exportFile, *apierror.Error) {
+	list := make([]exportFile, 0, len(names))
+	for _, name := range names {
+		file, err := files.Load(ctx, accountID, name)
+		switch {
+		case errors.Is(err, files.ErrNotFound) && missingOK:
+			list = append(list, exportFile{Name: name})
+			continue
+		case errors.Is(err, files.ErrNotFound):
+			return nil, apierror.NotFound(fmt.Sprintf("File %s not found", name))
+		case errors.Is(err, files.ErrQuarantined):
+			return nil, apierror.Forbidden(fmt.Sprintf("%s was quarantined by the malware scanner", name)).
+				WithDetails(map[string]string{"reason": "quarantined"})
+		case err != nil:
+			log.Printf("Failed to load %s of account %d for export: %v", name, accountID, err)
+			return nil, apierror.Internal("Failed to load files")
+		}
+		content := file.Content
+		list = append(list, exportFile{Name: name, Content: &content})
+	}
+	return list, nil
+}
+
+// ExportHandler pushes some of the caller's files to a new gist or to a
+// repository branch and remembers where, answering 201 with the export.
+// Exporting to a branch exported to before updates that export.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req ExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := validateExport(&req); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	token, apiErr := tokenOf(r.Context(), user.ID)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	var count int
+	if err := db.DB.QueryRowContext(r.Context(),
+		`SELECT COUNT(*) FROM github_exports WHERE account_id = $1`, user.ID).Scan(&count); err != nil {
+		log.Printf("Failed to count GitHub exports of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to export"))
+		return
+	}
+	if count >= MaxExports {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("An account can have at most %d exports; delete one first", MaxExports)))
+		return
+	}
+
+	list, apiErr := loadExportFiles(r.Context(), user.ID, req.Files, false)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	export := Export{Kind: req.Kind, Files: req.Files}
+	if req.Kind == KindGist {
+		err = createGist(r.Context(), token, req.Description, req.Public, list, &export)
+	} else {
+		export.Remote = req.Repo
+		export.Branch = req.Branch
+		err = pushRepo(r.Context(), token, req.Message, list, &export)
+	}
+	if err != nil {
+		log.Printf("GitHub export of account %d failed: %v", user.ID, err)
+		apierror.Write(w, toAPIError(err, remoteName(&export)))
+		return
+	}
+
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO github_exports (account_id, kind, remote, branch, files, url, revision)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (account_id, kind, remote, branch)
+		 DO UPDATE SET files = EXCLUDED.files, url = EXCLUDED.url, revision = EXCLUDED.revision, synced_at = CURRENT_TIMESTAMP
+		 RETURNING id, created_at, synced_at`,
+		user.ID, export.Kind, export.Remote, export.Branch, pq.Array(export.Files), export.URL, export.Revision,
+	).Scan(&export.ID, &export.CreatedAt, &export.SyncedAt)
+	if err != nil {
+		log.Printf("Failed to save GitHub export of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Files were pushed, but the export could not be saved"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(export)
+}
+
+// ListExportsHandler lists the caller's exports, last synced first.
+func ListExportsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT `+exportColumns+` FROM github_exports WHERE account_id = $1 ORDER BY synced_at DESC, id DESC`, user.ID)
+	if err != nil {
+		log.Printf("Failed to list GitHub exports of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load exports"))
+		return
+	}
+	defer rows.Close()
+
+	list := []Export{}
+	for rows.Next() {
+		export, err := scanExport(rows)
+		if err != nil {
+			log.Printf("Failed to read GitHub export: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load exports"))
+			return
+		}
+		list = append(list, *export)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// SyncExportHandler pushes the current content of an export's files to
+// its gist or branch. Files deleted since are deleted there too, and
+// dropped from the export.
+func SyncExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	export, apiErr := loadExport(r, user.ID)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	var req SyncRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.Write(w, apierror.DecodeError(err))
+			return
+		}
+	}
+	token, apiErr := tokenOf(r.Context(), user.ID)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	list, apiErr := loadExportFiles(r.Context(), user.ID, export.Files, true)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	if export.Kind == KindGist {
+		err = updateGist(r.Context(), token, list, export)
+	} else {
+		err = pushRepo(r.Context(), token, req.Message, list, export)
+	}
+	if err != nil {
+		log.Printf("GitHub sync of export %d failed: %v", export.ID, err)
+		apierror.Write(w, toAPIError(err, remoteName(export)))
+		return
+	}
+
+	export.Files = export.Files[:0]
+	for _, f := range list {
+		if f.Content != nil {
+			export.Files = append(export.Files, f.Name)
+		}
+	}
+	err = db.DB.QueryRowContext(r.Context(),
+		`UPDATE github_exports SET files = $1, url = $2, revision = $3, synced_at = CURRENT_TIMESTAMP
+		 WHERE id = $4 RETURNING synced_at`,
+		pq.Array(export.Files), export.URL, export.Revision, export.ID,
+	).Scan(&export.SyncedAt)
+	if err != nil {
+		log.Printf("Failed to save GitHub export %d: %v", export.ID, err)
+		apierror.Write(w, apierror.Internal("Files were pushed, but the export could not be saved"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(export)
+}
+
+// DeleteExportHandler forgets an export. The gist or repository is left
+// as it is.
+func DeleteExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.NotFound("Export not found"))
+		return
+	}
+	result, err := db.DB.ExecContext(r.Context(),
+		`DELETE FROM github_exports WHERE id = $1 AND account_id = $2`, id, user.ID)
+	if err != nil {
+		log.Printf("Failed to delete GitHub export %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to delete export"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Export not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const exportColumns = `id, kind, remote, branch, files, url, revision, created_at, synced_at`
+
+func scanExport(row interface{ Scan(...interface{}) error }) (*Export, error) {
+	var e Export
+	err := row.Scan(&e.ID, &e.Kind, &e.Remote, &e.Branch, pq.Array(&e.Files), &e.URL, &e.Revision, &e.CreatedAt, &e.SyncedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// loadExport returns the caller's export named by the {id} path value.
+func loadExport(r *http.Request, accountID int) (*Export, *apierror.Error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, apierror.NotFound("Export not found")
+	}
+	export, err := scanExport(db.DB.QueryRowContext(r.Context(),
+		`SELECT `+exportColumns+` FROM github_exports WHERE id = $1 AND account_id = $2`, id, accountID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apierror.NotFound("Export not found")
+	}
+	if err != nil {
+		log.Printf("Failed to load GitHub export %d: %v", id, err)
+		return nil, apierror.Internal("Failed to load export")
+	}
+	return export, nil
+}
+
+// tokenOf returns accountID's token, or the error to answer with.
+func tokenOf(ctx context.Context, accountID int) (string, *apierror.Error) {
+	if !Configured() {
+		return "", errNotConfigured()
+	}
+	token, err := loadToken(ctx, accountID)
+	if errors.Is(err, errNoConnection) {
+		return "", errNotConnected()
+	}
+	if err != nil {
+		log.Printf("Failed to load GitHub token of account %d: %v", accountID, err)
+		return "", apierror.Internal("Failed to load GitHub connection")
+	}
+	return token, nil
+}
+
+func remoteName(e *Export) string {
+	if e.Kind == KindGist {
+		return "Gist"
+	}
+	return "Repository " + e.Remote
+}
+
+type gistFile struct {
+	Content string `json:"content"`
+}
+
+type gist struct {
+	ID      string `json:"id"`
+	HTMLURL string `json:"html_url"`
+	History []struct {
+		Version string `json:"version"`
+	} `json:"history"`
+}
+
+// gistFiles maps files to the gist API's files object; nil deletes.
+func gistFiles(list []exportFile) (map[string]*gistFile, error) {
+	out := make(map[string]*gistFile, len(list))
+	for _, f := range list {
+		name := path.Base(f.Name)
+		if f.Content == nil {
+			out[name] = nil
+			continue
+		}
+		if strings.TrimSpace(*f.Content) == "" {
+			return nil, &apiError{Status: http.StatusUnprocessableEntity, Message: f.Name + " is empty, and gists cannot hold empty files"}
+		}
+		out[name] = &gistFile{Content: *f.Content}
+	}
+	return out, nil
+}
+
+func createGist(ctx context.Context, token, description string, public bool, list []exportFile, e *Export) error {
+	gf, err := gistFiles(list)
+	if err != nil {
+		return err
+	}
+	var g gist
+	err = call(ctx, token, http.MethodPost, "/gists", map[string]interface{}{
+		"description": description,
+		"public":      public,
+		"files":       gf,
+	}, &g)
+	if err != nil {
+		return err
+	}
+	e.Remote, e.URL = g.ID, g.HTMLURL
+	if len(g.History) > 0 {
+		e.Revision = g.History[0].Version
+	}
+	return nil
+}
+
+func updateGist(ctx context.Context, token string, list []exportFile, e *Export) error {
+	gf, err := gistFiles(list)
+	if err != nil {
+		return err
+	}
+	var g gist
+	if err := call(ctx, token, http.MethodPatch, "/gists/"+e.Remote, map[string]interface{}{"files": gf}, &g); err != nil {
+		return err
+	}
+	e.URL = g.HTMLURL
+	if len(g.History) > 0 {
+		e.Revision = g.History[0].Version
+	}
+	return nil
+}
+
+type treeEntry struct {
+	Path    string  `json:"path"`
+	Mode    string  `json:"mode"`
+	Type    string  `json:"type"`
+	Content *string `json:"content,omitempty"`
+	// SHA is only sent, as null, to delete the path.
+	SHA *string `json:"sha"`
+}
+
+// MarshalJSON leaves out sha for files with content: GitHub takes either.
+func (t treeEntry) MarshalJSON() ([]byte, error) {
+	type entry treeEntry
+	if t.Content != nil {
+		return json.Marshal(struct {
+			Path    string  `json:"path"`
+			Mode    string  `json:"mode"`
+			Type    string  `json:"type"`
+			Content *string `json:"content"`
+		}{t.Path, t.Mode, t.Type, t.Content})
+	}
+	return json.Marshal(entry(t))
+}
+
+// pushRepo commits list on top of e.Branch of repository e.Remote through
+// the Git data API: one tree holding the files, one commit, and the branch
+// moved to it. The branch is created if missing; an empty branch name is
+// the default branch. The repository must have at least one commit.
+func pushRepo(ctx context.Context, token, message string, list []exportFile, e *Export) error {
+	repo := "/repos/" + e.Remote
+	if e.Branch == "" {
+		var info struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := call(ctx, token, http.MethodGet, repo, nil, &info); err != nil {
+			return err
+		}
+		e.Branch = info.DefaultBranch
+	}
+	if message == "" {
+		message = fmt.Sprintf("Update %d files from AllansWebTerminal", len(list))
+	}
+
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	parent := ""
+	err := call(ctx, token, http.MethodGet, repo+"/git/ref/heads/"+e.Branch, nil, &ref)
+	var apiErr *apiError
+	switch {
+	case err == nil:
+		parent = ref.Object.SHA
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusConflict:
+		return &apiError{Status: http.StatusConflict, Message: "the repository is empty; add a first commit on GitHub"}
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound:
+		// A new branch, started from the default branch.
+		var info struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := call(ctx, token, http.MethodGet, repo, nil, &info); err != nil {
+			return err
+		}
+		if err := call(ctx, token, http.MethodGet, repo+"/git/ref/heads/"+info.DefaultBranch, nil, &ref); err != nil {
+			return err
+		}
+		parent = ref.Object.SHA
+	default:
+		return err
+	}
+
+	var base struct {
+		Tree struct {
+			SHA string `json:"sha"`
+		} `json:"tree"`
+	}
+	if err := call(ctx, token, http.MethodGet, repo+"/git/commits/"+parent, nil, &base); err != nil {
+		return err
+	}
+	entries := make([]treeEntry, 0, len(list))
+	for _, f := range list {
+		entries = append(entries, treeEntry{Path: f.Name, Mode: "100644", Type: "blob", Content: f.Content})
+	}
+	var tree struct {
+		SHA string `json:"sha"`
+	}
+	if err := call(ctx, token, http.MethodPost, repo+"/git/trees",
+		map[string]interface{}{"base_tree": base.Tree.SHA, "tree": entries}, &tree); err != nil {
+		return err
+	}
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	if err := call(ctx, token, http.MethodPost, repo+"/git/commits",
+		map[string]interface{}{"message": message, "tree": tree.SHA, "parents": []string{parent}}, &commit); err != nil {
+		return err
+	}
+
+	if err := moveBranch(ctx, token, repo, e.Branch, commit.SHA); err != nil {
+		return err
+	}
+	e.Revision = commit.SHA
+	e.URL = "https://github.com/" + e.Remote + "/tree/" + e.Branch
+	return nil
+}
+
+// moveBranch points branch at sha, creating it if needed. The move is not
+// forced, so commits pushed to the branch meanwhile are never lost.
+func moveBranch(ctx context.Context, token, repo, branch, sha string) error {
+	err := call(ctx, token, http.MethodPatch, repo+"/git/refs/heads/"+branch,
+		map[string]interface{}{"sha": sha, "force": false}, nil)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && (apiErr.Status == http.StatusNotFound || apiErr.Status == http.StatusUnprocessableEntity && strings.Contains(apiErr.Message, "does not exist")) {
+		return call(ctx, token, http.MethodPost, repo+"/git/refs",
+			map[string]string{"ref": "refs/heads/" + branch, "sha": sha}, nil)
+	}
+	return err
+}
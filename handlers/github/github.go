@@ -0,0 +1,385 @@
+// Package github connects accounts to GitHub through OAuth and exports
+// their files to gists and repositories. Access tokens are encrypted with
+// the vault master key; each export remembers its gist or repository so
+// it can be synced again later.
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/flash"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/vault"
+)
+
+// Scopes are the OAuth scopes asked for: gists, and pushing to the user's
+// repositories.
+const Scopes = "gist repo"
+
+// stateCookie holds the OAuth state between the redirect to GitHub and
+// the callback.
+const stateCookie = "github_oauth_state"
+
+// Endpoints of GitHub. Tests point them at a local server.
+var (
+	oauthURL = "https://github.com"
+	apiURL   = "https://api.github.com"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// OAuth is the OAuth app the site connects accounts with.
+type OAuth struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where GitHub sends users back to,
+	// <origin>/api/github/callback; it must match the app's callback URL.
+	RedirectURL string
+}
+
+var (
+	appMu sync.RWMutex
+	app   *OAuth
+)
+
+// SetOAuth configures the OAuth app. Without one, or without a vault
+// master key to encrypt tokens with, the GitHub API answers 503.
+func SetOAuth(o *OAuth) {
+	appMu.Lock()
+	defer appMu.Unlock()
+	app = o
+}
+
+func currentApp() *OAuth {
+	appMu.RLock()
+	defer appMu.RUnlock()
+	return app
+}
+
+// Configured reports whether accounts can be connected.
+func Configured() bool {
+	return currentApp() != nil && vault.Configured()
+}
+
+func errNotConfigured() *apierror.Error {
+	return apierror.Unavailable("GitHub is not configured on this server").
+		WithDetails(map[string]string{"reason": "not_configured"})
+}
+
+func errNotConnected() *apierror.Error {
+	return apierror.Forbidden("Connect your GitHub account first").
+		WithDetails(map[string]string{"reason": "not_connected"})
+}
+
+// Connection describes an account's link to GitHub, never its token.
+type Connection struct {
+	Configured  bool       `json:"configured"`
+	Connected   bool       `json:"connected"`
+	Login       string     `json:"login,omitempty"`
+	Scopes      string     `json:"scopes,omitempty"`
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+}
+
+// sealContext binds a token to its owner.
+func sealContext(accountID int) []byte {
+	return []byte(fmt.Sprintf("github:%d", accountID))
+}
+
+// ConnectionHandler reports whether the caller is connected, and as whom.
+func ConnectionHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	conn := Connection{Configured: Configured()}
+	var connectedAt time.Time
+	err = db.DB.QueryRowContext(r.Context(),
+		`SELECT login, scopes, created_at FROM github_connections WHERE account_id = $1`, user.ID,
+	).Scan(&conn.Login, &conn.Scopes, &connectedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to load GitHub connection of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load GitHub connection"))
+		return
+	}
+	if err == nil {
+		conn.Connected = true
+		conn.ConnectedAt = &connectedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conn)
+}
+
+// ConnectHandler sends the caller to GitHub to authorize the app.
+func ConnectHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := login.GetCurrentUser(r); err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	o := currentApp()
+	if o == nil || !vault.Configured() {
+		apierror.Write(w, errNotConfigured())
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	state := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/api/github/callback",
+		HttpOnly: true,
+		Secure:   login.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   600,
+	})
+
+	q := url.Values{}
+	q.Set("client_id", o.ClientID)
+	q.Set("redirect_uri", o.RedirectURL)
+	q.Set("scope", Scopes)
+	q.Set("state", state)
+	http.Redirect(w, r, oauthURL+"/login/oauth/authorize?"+q.Encode(), http.StatusFound)
+}
+
+// CallbackHandler finishes the OAuth flow: it trades the code for a token,
+// stores it for the caller and sends them back to the terminal with a flash
+// message saying whether it worked.
+func CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	o := currentApp()
+	if o == nil || !vault.Configured() {
+		apierror.Write(w, errNotConfigured())
+		return
+	}
+
+	cookie, err := r.Cookie(stateCookie)
+	state := r.URL.Query().Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		apierror.Write(w, apierror.Forbidden("The GitHub authorization expired or did not come from this browser; connect again").
+			WithDetails(map[string]string{"reason": "state_mismatch"}))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/api/github/callback", MaxAge: -1})
+
+	if err := connect(r.Context(), o, user.ID, r.URL.Query().Get("code")); err != nil {
+		log.Printf("Failed to connect account %d to GitHub: %v", user.ID, err)
+		flash.Add(w, r, flash.Error, "Could not connect to GitHub. Please try again.")
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	flash.Add(w, r, flash.Success, "GitHub is connected.")
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// connect exchanges code for a token and stores it, with the GitHub user
+// it belongs to.
+func connect(ctx context.Context, o *OAuth, accountID int, code string) error {
+	if code == "" {
+		return errors.New("no code in callback")
+	}
+	form := url.Values{}
+	form.Set("client_id", o.ClientID)
+	form.Set("client_secret", o.ClientSecret)
+	form.Set("code", code)
+	form.Set("redirect_uri", o.RedirectURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oauthURL+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var grant struct {
+		AccessToken      string `json:"access_token"`
+		Scope            string `json:"scope"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&grant); err != nil {
+		return fmt.Errorf("token response: %w", err)
+	}
+	if grant.AccessToken == "" {
+		return fmt.Errorf("token exchange: %s %s", grant.Error, grant.ErrorDescription)
+	}
+
+	var ghUser struct {
+		Login string `json:"login"`
+	}
+	if err := call(ctx, grant.AccessToken, http.MethodGet, "/user", nil, &ghUser); err != nil {
+		return err
+	}
+
+	sealed, err := vault.Seal([]byte(grant.AccessToken), sealContext(accountID))
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.ExecContext(ctx,
+		`INSERT INTO github_connections (account_id, login, token, scopes)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (account_id) DO UPDATE SET login = EXCLUDED.login, token = EXCLUDED.token,
+			scopes = EXCLUDED.scopes, created_at = CURRENT_TIMESTAMP`,
+		accountID, ghUser.Login, sealed, grant.Scope)
+	return err
+}
+
+// DisconnectHandler forgets the caller's token and revokes it at GitHub.
+// Exports are kept, to sync again after reconnecting.
+func DisconnectHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	token, err := loadToken(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, errNoConnection) {
+		log.Printf("Failed to load GitHub token of account %d: %v", user.ID, err)
+	}
+	if _, err := db.DB.ExecContext(r.Context(), `DELETE FROM github_connections WHERE account_id = $1`, user.ID); err != nil {
+		log.Printf("Failed to disconnect account %d from GitHub: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to disconnect GitHub"))
+		return
+	}
+	if o := currentApp(); o != nil && token != "" {
+		if err := revoke(r.Context(), o, token); err != nil {
+			log.Printf("Failed to revoke GitHub token of account %d: %v", user.ID, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revoke deletes the app's grant of token at GitHub.
+func revoke(ctx context.Context, o *OAuth, token string) error {
+	body, _ := json.Marshal(map[string]string{"access_token": token})
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete,
+		apiURL+"/applications/"+url.PathEscape(o.ClientID)+"/grant", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(o.ClientID, o.ClientSecret)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("GitHub answered %s", resp.Status)
+	}
+	return nil
+}
+
+var errNoConnection = errors.New("account is not connected to GitHub")
+
+// loadToken returns accountID's GitHub token, or errNoConnection.
+func loadToken(ctx context.Context, accountID int) (string, error) {
+	var sealed []byte
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT token FROM github_connections WHERE account_id = $1`, accountID).Scan(&sealed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errNoConnection
+	}
+	if err != nil {
+		return "", err
+	}
+	token, err := vault.Open(sealed, sealContext(accountID))
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// apiError is a GitHub API answer other than 2xx.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("GitHub answered %d: %s", e.Status, e.Message)
+}
+
+// call sends a request to the GitHub API with token and decodes the
+// answer into out, if not nil.
+func call(ctx context.Context, token, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		return &apiError{Status: resp.StatusCode, Message: e.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// toAPIError turns a failed GitHub call into the error shown to the user.
+func toAPIError(err error, what string) *apierror.Error {
+	var e *apiError
+	if !errors.As(err, &e) {
+		return apierror.Unavailable("GitHub could not be reached").
+			WithDetails(map[string]string{"reason": "upstream"})
+	}
+	switch e.Status {
+	case http.StatusUnauthorized:
+		return apierror.Forbidden("GitHub no longer accepts your authorization; connect again").
+			WithDetails(map[string]string{"reason": "reconnect"})
+	case http.StatusForbidden, http.StatusNotFound:
+		return apierror.NotFound(what + " not found, or your GitHub account cannot write to it")
+	case http.StatusConflict, http.StatusUnprocessableEntity:
+		return apierror.Conflict("GitHub refused the change: " + e.Message)
+	}
+	return apierror.Unavailable("GitHub could not be reached").
+		WithDetails(map[string]string{"reason": "upstream"})
+}
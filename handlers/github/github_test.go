@@ -0,0 +1,336 @@
+package github
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/flash"
+	"allanswebterminal/securecookie"
+	"allanswebterminal/vault"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	originalDB := db.DB
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "user"))
+	return mock
+}
+
+// useGitHub configures an OAuth app and a vault key, and points the
+// GitHub endpoints at handler.
+func useGitHub(t *testing.T, handler http.Handler) {
+	t.Helper()
+	key, _ := vault.ParseKey(vault.NewKey())
+	if err := vault.SetKey(key); err != nil {
+		t.Fatal(err)
+	}
+	SetOAuth(&OAuth{ClientID: "cid", ClientSecret: "csecret", RedirectURL: "http://localhost/api/github/callback"})
+	server := httptest.NewServer(handler)
+	originalOAuth, originalAPI := oauthURL, apiURL
+	oauthURL, apiURL = server.URL, server.URL
+	t.Cleanup(func() {
+		server.Close()
+		oauthURL, apiURL = originalOAuth, originalAPI
+		SetOAuth(nil)
+		vault.SetKey(nil)
+	})
+}
+
+func signedIn(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	return req
+}
+
+// gitHubCalls records the requests a fake GitHub received.
+type gitHubCalls struct {
+	mu    sync.Mutex
+	calls []string
+	tree  []map[string]interface{}
+}
+
+func (c *gitHubCalls) add(r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, r.Method+" "+r.URL.Path)
+}
+
+func TestValidateExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ExportRequest
+		wantErr bool
+	}{
+		{"Gist", ExportRequest{Kind: KindGist, Files: []string{"main.py", "lib/util.py"}}, false},
+		{"Repo", ExportRequest{Kind: KindRepo, Files: []string{"blog/main.py"}, Repo: "ana/blog", Branch: "main"}, false},
+		{"Unknown kind", ExportRequest{Kind: "svn", Files: []string{"main.py"}}, true},
+		{"No files", ExportRequest{Kind: KindGist}, true},
+		{"Gist name clash", ExportRequest{Kind: KindGist, Files: []string{"a/main.py", "b/main.py"}}, true},
+		{"Repo without owner", ExportRequest{Kind: KindRepo, Files: []string{"main.py"}, Repo: "blog"}, true},
+		{"Bad branch", ExportRequest{Kind: KindRepo, Files: []string{"main.py"}, Repo: "ana/blog", Branch: "a..b"}, true},
+		{"Path into .git", ExportRequest{Kind: KindRepo, Files: []string{".git/config"}, Repo: "ana/blog"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateExport(&tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("validateExport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConnectRedirectsWithState(t *testing.T) {
+	useGitHub(t, http.NotFoundHandler())
+	setupMockDB(t)
+
+	rec := httptest.NewRecorder()
+	ConnectHandler(rec, signedIn("GET", "/api/github/connect", ""))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	q := location.Query()
+	if location.Path != "/login/oauth/authorize" || q.Get("client_id") != "cid" || q.Get("scope") != Scopes {
+		t.Errorf("Location = %s", location)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookie || cookies[0].Value != q.Get("state") {
+		t.Errorf("state cookie = %v, state = %q", cookies, q.Get("state"))
+	}
+}
+
+func TestConnectNotConfigured(t *testing.T) {
+	setupMockDB(t)
+	rec := httptest.NewRecorder()
+	ConnectHandler(rec, signedIn("GET", "/api/github/connect", ""))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+}
+
+func TestCallbackRejectsStateMismatch(t *testing.T) {
+	useGitHub(t, http.NotFoundHandler())
+	setupMockDB(t)
+
+	req := signedIn("GET", "/api/github/callback?code=c&state=forged", "")
+	req.AddCookie(&http.Cookie{Name: stateCookie, Value: "expected"})
+	rec := httptest.NewRecorder()
+	CallbackHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestCallbackStoresToken(t *testing.T) {
+	useGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login/oauth/access_token":
+			r.ParseForm()
+			if r.Form.Get("code") != "c0de" || r.Form.Get("client_secret") != "csecret" {
+				http.Error(w, "bad exchange", http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_token", "scope": "gist,repo"})
+		case "/user":
+			if r.Header.Get("Authorization") != "Bearer gho_token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"login": "ana-gh"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	mock := setupMockDB(t)
+	mock.ExpectExec("INSERT INTO github_connections").
+		WithArgs(7, "ana-gh", sqlmock.AnyArg(), "gist,repo").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := signedIn("GET", "/api/github/callback?code=c0de&state=s", "")
+	req.AddCookie(&http.Cookie{Name: stateCookie, Value: "s"})
+	rec := httptest.NewRecorder()
+	CallbackHandler(rec, req)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Errorf("status = %d, Location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	next := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		next.AddCookie(c)
+	}
+	if got := flash.Get(next); len(got) != 1 || got[0] != (flash.Message{Kind: flash.Success, Text: "GitHub is connected."}) {
+		t.Errorf("flash messages = %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+var storedFileColumns = []string{"id", "account_id", "filename", "content", "file_type", "created_at", "updated_at", "key_id", "data_key", "scan_status"}
+
+func expectToken(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	sealed, err := vault.Seal([]byte("gho_token"), sealContext(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT token FROM github_connections").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"token"}).AddRow(sealed))
+}
+
+func expectFile(mock sqlmock.Sqlmock, name, content string) {
+	mock.ExpectQuery("SELECT id, account_id, filename, content").WithArgs(7, name).
+		WillReturnRows(sqlmock.NewRows(storedFileColumns).
+			AddRow(1, 7, name, content, "python", time.Now(), time.Now(), nil, nil, nil))
+}
+
+func TestExportGist(t *testing.T) {
+	var got map[string]interface{}
+	useGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/gists" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"id":"g1","html_url":"https://gist.github.com/g1","history":[{"version":"v1"}]}`)
+	}))
+	mock := setupMockDB(t)
+	expectToken(t, mock)
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	expectFile(mock, "blog/main.py", "print('hi')")
+	mock.ExpectQuery("INSERT INTO github_exports").
+		WithArgs(7, KindGist, "g1", "", sqlmock.AnyArg(), "https://gist.github.com/g1", "v1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "synced_at"}).AddRow(3, time.Now(), time.Now()))
+
+	rec := httptest.NewRecorder()
+	ExportHandler(rec, signedIn("POST", "/api/github/exports", `{"kind":"gist","files":["blog/main.py"],"description":"Blog"}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	files, _ := got["files"].(map[string]interface{})
+	if main, _ := files["main.py"].(map[string]interface{}); main["content"] != "print('hi')" || got["public"] != false {
+		t.Errorf("gist request = %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestExportNotConnected(t *testing.T) {
+	useGitHub(t, http.NotFoundHandler())
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT token FROM github_connections").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"token"}))
+
+	rec := httptest.NewRecorder()
+	ExportHandler(rec, signedIn("POST", "/api/github/exports", `{"kind":"gist","files":["main.py"]}`))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "not_connected") {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPushRepo(t *testing.T) {
+	calls := &gitHubCalls{}
+	useGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.add(r)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /repos/ana/blog/git/ref/heads/main":
+			io.WriteString(w, `{"object":{"sha":"parent"}}`)
+		case "GET /repos/ana/blog/git/commits/parent":
+			io.WriteString(w, `{"tree":{"sha":"basetree"}}`)
+		case "POST /repos/ana/blog/git/trees":
+			var body struct {
+				BaseTree string                   `json:"base_tree"`
+				Tree     []map[string]interface{} `json:"tree"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.BaseTree != "basetree" {
+				http.Error(w, "wrong base", http.StatusUnprocessableEntity)
+				return
+			}
+			calls.tree = body.Tree
+			io.WriteString(w, `{"sha":"newtree"}`)
+		case "POST /repos/ana/blog/git/commits":
+			io.WriteString(w, `{"sha":"newcommit"}`)
+		case "PATCH /repos/ana/blog/git/refs/heads/main":
+			io.WriteString(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	content := "print('hi')"
+	export := &Export{Kind: KindRepo, Remote: "ana/blog", Branch: "main"}
+	err := pushRepo(t.Context(), "gho_token", "", []exportFile{{Name: "main.py", Content: &content}, {Name: "old.py"}}, export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Revision != "newcommit" || export.URL != "https://github.com/ana/blog/tree/main" {
+		t.Errorf("export = %+v", export)
+	}
+	if len(calls.tree) != 2 || calls.tree[0]["content"] != content {
+		t.Fatalf("tree = %v", calls.tree)
+	}
+	if sha, ok := calls.tree[1]["sha"]; !ok || sha != nil {
+		t.Errorf("deleted file entry = %v, want a null sha", calls.tree[1])
+	}
+	if last := calls.calls[len(calls.calls)-1]; last != "PATCH /repos/ana/blog/git/refs/heads/main" {
+		t.Errorf("last call = %s", last)
+	}
+}
+
+func TestPushRepoCreatesBranch(t *testing.T) {
+	calls := &gitHubCalls{}
+	useGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.add(r)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /repos/ana/blog":
+			io.WriteString(w, `{"default_branch":"main"}`)
+		case "GET /repos/ana/blog/git/ref/heads/main":
+			io.WriteString(w, `{"object":{"sha":"parent"}}`)
+		case "GET /repos/ana/blog/git/commits/parent":
+			io.WriteString(w, `{"tree":{"sha":"basetree"}}`)
+		case "POST /repos/ana/blog/git/trees":
+			io.WriteString(w, `{"sha":"newtree"}`)
+		case "POST /repos/ana/blog/git/commits":
+			io.WriteString(w, `{"sha":"newcommit"}`)
+		case "PATCH /repos/ana/blog/git/refs/heads/draft":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, `{"message":"Reference does not exist"}`)
+		case "POST /repos/ana/blog/git/refs":
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	content := "x = 1"
+	export := &Export{Kind: KindRepo, Remote: "ana/blog", Branch: "draft"}
+	if err := pushRepo(t.Context(), "gho_token", "", []exportFile{{Name: "main.py", Content: &content}}, export); err != nil {
+		t.Fatal(err)
+	}
+	if last := calls.calls[len(calls.calls)-1]; last != "POST /repos/ana/blog/git/refs" {
+		t.Errorf("last call = %s, want the branch created", last)
+	}
+}
@@ -0,0 +1,269 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+// accessTrackingPeriod is how long service calls are kept for the access
+// advisor, as in AWS.
+const accessTrackingPeriod = 400 * 24 * time.Hour
+
+// simulatedServices are the service namespaces a wildcard action such as
+// "*" or "s3*:*" is expanded against when listing a principal's services.
+var simulatedServices = servicePrefixes()
+
+// serviceOf returns the service namespace of an action, such as "s3" for
+// "s3:GetObject".
+func serviceOf(action string) string {
+	service, _, _ := strings.Cut(action, ":")
+	return strings.ToLower(service)
+}
+
+// grantedServices returns the services an Allow statement of policies
+// grants some action of, sorted. Denies are not subtracted, because one
+// rarely covers a whole service; AWS lists services the same way.
+func grantedServices(policies []sourcedPolicy) []string {
+	granted := map[string]bool{}
+	for _, p := range policies {
+		for _, s := range p.doc.Statement {
+			if s.Effect != "Allow" {
+				continue
+			}
+			if len(s.NotAction) > 0 {
+				for _, service := range simulatedServices {
+					granted[service] = true
+				}
+				continue
+			}
+			for _, action := range s.Action {
+				pattern := serviceOf(action)
+				if action == "*" {
+					pattern = "*"
+				}
+				if !strings.ContainsAny(pattern, "*?") {
+					granted[pattern] = true
+					continue
+				}
+				for _, service := range simulatedServices {
+					if wildcardMatch(pattern, service) {
+						granted[service] = true
+					}
+				}
+			}
+		}
+	}
+	services := make([]string, 0, len(granted))
+	for service := range granted {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// recordCall adds a call by principalARN to the access event log.
+func recordCall(ctx context.Context, accountID int, principalARN string, result EvaluationResult) error {
+	_, err := db.DB.ExecContext(ctx,
+		`INSERT INTO iam_access_events (account_id, principal_arn, service, action, resource, decision)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		accountID, principalARN, serviceOf(result.ActionName), result.ActionName, result.ResourceName, result.Decision)
+	return err
+}
+
+type CallRequest struct {
+	PrincipalARN string `json:"principal_arn"`
+	Action       string `json:"action"`
+	Resource     string `json:"resource"`
+}
+
+// CallHandler simulates a user or role calling a service: the action is
+// evaluated as by the simulator, resource policy included, and the call
+// is logged, allowed or not, for the access advisor.
+func CallHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CallRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.PrincipalARN == "" || !strings.Contains(req.Action, ":") {
+		apierror.Write(w, apierror.Validation("principal_arn and an action such as s3:GetObject are required"))
+		return
+	}
+	if req.Resource == "" {
+		req.Resource = "*"
+	}
+
+	p, err := loadPrincipalByARN(accountID, req.PrincipalARN)
+	if errors.Is(err, errNoSuchPrincipal) {
+		apierror.Write(w, apierror.NotFound("No user or role with that ARN"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load principal: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load principal"))
+		return
+	}
+	e, err := principalEvaluation(accountID, p)
+	if err != nil {
+		log.Printf("Failed to load policies of %s: %v", p.arn, err)
+		apierror.Write(w, apierror.Internal("Failed to load policies"))
+		return
+	}
+
+	rp, err := loadResourcePolicy(accountID, req.Resource)
+	if err != nil {
+		log.Printf("Failed to load the resource policy of %s: %v", req.Resource, err)
+		apierror.Write(w, apierror.Internal("Failed to load resource policy"))
+		return
+	}
+
+	result := e.evaluateWith(req.Action, req.Resource, rp)
+	if err := recordCall(r.Context(), accountID, p.arn, result); err != nil {
+		log.Printf("Failed to record call by %s: %v", p.arn, err)
+		apierror.Write(w, apierror.Internal("Failed to record call"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ServiceLastAccessed is when a principal last made an allowed call to a
+// service its policies grant. LastAuthenticated is nil for a service not
+// used within the tracking period.
+type ServiceLastAccessed struct {
+	Service           string     `json:"service"`
+	LastAuthenticated *time.Time `json:"last_authenticated"`
+	LastAction        *string    `json:"last_action"`
+	CallCount         int        `json:"call_count"`
+}
+
+// AccessAdvisorReport lists a principal's services. UnusedServices are the
+// granted services it has not used within the tracking period, or within
+// the requested number of days: candidates to remove for least privilege.
+type AccessAdvisorReport struct {
+	PrincipalARN   string                `json:"principal_arn"`
+	TrackingSince  time.Time             `json:"tracking_since"`
+	Services       []ServiceLastAccessed `json:"services"`
+	UnusedServices []string              `json:"unused_services"`
+}
+
+// lastAccessed returns the allowed calls by principalARN since since,
+// keyed by service.
+func lastAccessed(ctx context.Context, accountID int, principalARN string, since time.Time) (map[string]ServiceLastAccessed, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT DISTINCT ON (service) service, occurred_at, action,
+			COUNT(*) OVER (PARTITION BY service)
+		FROM iam_access_events
+		WHERE account_id = $1 AND principal_arn = $2 AND decision = $3 AND occurred_at >= $4
+		ORDER BY service, occurred_at DESC`,
+		accountID, principalARN, decisionAllowed, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	used := map[string]ServiceLastAccessed{}
+	for rows.Next() {
+		var s ServiceLastAccessed
+		var at time.Time
+		var action string
+		if err := rows.Scan(&s.Service, &at, &action, &s.CallCount); err != nil {
+			return nil, err
+		}
+		s.LastAuthenticated, s.LastAction = &at, &action
+		used[s.Service] = s
+	}
+	return used, rows.Err()
+}
+
+// AccessAdvisorHandler reports the services the identity policies of the
+// user or role ?arn= grant, when each was last used and which were never
+// used. ?days= counts only calls within that many days as use.
+func AccessAdvisorHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("arn") == "" {
+		apierror.Write(w, apierror.Validation("arn is required"))
+		return
+	}
+	now := time.Now()
+	since := now.Add(-accessTrackingPeriod)
+	if v := query.Get("days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 || days > 400 {
+			apierror.Write(w, apierror.Validation("days must be between 1 and 400"))
+			return
+		}
+		since = now.AddDate(0, 0, -days)
+	}
+
+	p, err := loadPrincipalByARN(accountID, query.Get("arn"))
+	if errors.Is(err, errNoSuchPrincipal) {
+		apierror.Write(w, apierror.NotFound("No user or role with that ARN"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load principal: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load principal"))
+		return
+	}
+	policies, err := identityPolicies(accountID, p)
+	if err != nil {
+		log.Printf("Failed to load policies of %s: %v", p.arn, err)
+		apierror.Write(w, apierror.Internal("Failed to load policies"))
+		return
+	}
+	used, err := lastAccessed(r.Context(), accountID, p.arn, since)
+	if err != nil {
+		log.Printf("Failed to load last accessed data of %s: %v", p.arn, err)
+		apierror.Write(w, apierror.Internal("Failed to load last accessed data"))
+		return
+	}
+
+	report := AccessAdvisorReport{PrincipalARN: p.arn, TrackingSince: since, Services: []ServiceLastAccessed{}, UnusedServices: []string{}}
+	for _, service := range grantedServices(policies) {
+		s, ok := used[service]
+		if !ok {
+			s = ServiceLastAccessed{Service: service}
+			report.UnusedServices = append(report.UnusedServices, service)
+		}
+		report.Services = append(report.Services, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// DeleteOldAccessEvents drops service calls older than the tracking
+// period. It runs from the scheduler.
+func DeleteOldAccessEvents(ctx context.Context) error {
+	_, err := db.DB.ExecContext(ctx, "DELETE FROM iam_access_events WHERE occurred_at < $1",
+		time.Now().Add(-accessTrackingPeriod))
+	if err != nil {
+		return fmt.Errorf("failed to delete old IAM access events: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package iam
+
+import (
+	"sort"
+	"strings"
+)
+
+// Access levels of an action, as in the AWS service authorization
+// reference.
+const (
+	AccessList        = "List"
+	AccessRead        = "Read"
+	AccessWrite       = "Write"
+	AccessPermissions = "Permissions management"
+	AccessTagging     = "Tagging"
+)
+
+// ActionDef documents an action the simulator evaluates policies for.
+type ActionDef struct {
+	Name        string `json:"name"`
+	AccessLevel string `json:"access_level"`
+	Description string `json:"description"`
+}
+
+// ServiceDef is a simulated service namespace and its actions.
+type ServiceDef struct {
+	Prefix  string      `json:"prefix"`
+	Name    string      `json:"name"`
+	Actions []ActionDef `json:"actions"`
+}
+
+// actionRegistry lists the simulated services and the actions a policy
+// can meaningfully name for each, sorted by prefix and action name. It is
+// the single source for wildcard expansion and the service catalog.
+var actionRegistry = []ServiceDef{
+	{Prefix: "autoscaling", Name: "Amazon EC2 Auto Scaling", Actions: []ActionDef{
+		{"CreateAutoScalingGroup", AccessWrite, "Creates an Auto Scaling group"},
+		{"DeleteAutoScalingGroup", AccessWrite, "Deletes an Auto Scaling group"},
+		{"DescribeAutoScalingGroups", AccessList, "Describes Auto Scaling groups"},
+		{"UpdateAutoScalingGroup", AccessWrite, "Changes the size or launch settings of an Auto Scaling group"},
+	}},
+	{Prefix: "cloudwatch", Name: "Amazon CloudWatch", Actions: []ActionDef{
+		{"DeleteAlarms", AccessWrite, "Deletes metric alarms"},
+		{"DescribeAlarms", AccessRead, "Describes metric alarms and their state"},
+		{"GetMetricData", AccessRead, "Returns datapoints of metrics"},
+		{"ListMetrics", AccessList, "Lists the metrics of simulated resources"},
+		{"PutMetricAlarm", AccessWrite, "Creates or updates a metric alarm"},
+	}},
+	{Prefix: "dynamodb", Name: "Amazon DynamoDB", Actions: []ActionDef{
+		{"CreateTable", AccessWrite, "Creates a table with a partition key and optional sort key"},
+		{"DeleteItem", AccessWrite, "Deletes an item by its primary key"},
+		{"DeleteTable", AccessWrite, "Deletes a table and all its items"},
+		{"DescribeTable", AccessRead, "Returns a table's key schema and capacity"},
+		{"GetItem", AccessRead, "Returns an item by its primary key"},
+		{"ListTables", AccessList, "Lists tables"},
+		{"PutItem", AccessWrite, "Creates or replaces an item"},
+		{"Query", AccessRead, "Returns the items of a partition key, optionally filtered by sort key"},
+		{"UpdateTable", AccessWrite, "Changes a table's provisioned capacity"},
+	}},
+	{Prefix: "ec2", Name: "Amazon EC2", Actions: []ActionDef{
+		{"CreateSecurityGroup", AccessWrite, "Creates a security group"},
+		{"CreateSubnet", AccessWrite, "Creates a subnet in a VPC"},
+		{"CreateVpc", AccessWrite, "Creates a VPC"},
+		{"DescribeInstances", AccessList, "Describes instances"},
+		{"RunInstances", AccessWrite, "Launches instances"},
+		{"TerminateInstances", AccessWrite, "Terminates instances"},
+	}},
+	{Prefix: "elasticloadbalancing", Name: "Elastic Load Balancing", Actions: []ActionDef{
+		{"CreateLoadBalancer", AccessWrite, "Creates a load balancer"},
+		{"DeleteLoadBalancer", AccessWrite, "Deletes a load balancer"},
+		{"DescribeLoadBalancers", AccessRead, "Describes load balancers"},
+	}},
+	{Prefix: "iam", Name: "AWS Identity and Access Management", Actions: []ActionDef{
+		{"AttachRolePolicy", AccessPermissions, "Attaches a managed policy to a role"},
+		{"AttachUserPolicy", AccessPermissions, "Attaches a managed policy to a user"},
+		{"CreatePolicy", AccessPermissions, "Creates a customer managed policy"},
+		{"CreatePolicyVersion", AccessPermissions, "Creates a version of a managed policy"},
+		{"CreateRole", AccessWrite, "Creates a role with a trust policy"},
+		{"CreateServiceLinkedRole", AccessWrite, "Creates a role linked to a service"},
+		{"CreateUser", AccessWrite, "Creates a user"},
+		{"DeleteRole", AccessWrite, "Deletes a role"},
+		{"DeleteServiceLinkedRole", AccessWrite, "Deletes a role linked to a service"},
+		{"DeleteUser", AccessWrite, "Deletes a user"},
+		{"DetachRolePolicy", AccessPermissions, "Detaches a managed policy from a role"},
+		{"DetachUserPolicy", AccessPermissions, "Detaches a managed policy from a user"},
+		{"GetRole", AccessRead, "Returns a role and its trust policy"},
+		{"GetUser", AccessRead, "Returns a user"},
+		{"ListRoles", AccessList, "Lists roles"},
+		{"ListUsers", AccessList, "Lists users"},
+		{"PassRole", AccessWrite, "Passes a role to a service"},
+		{"PutRolePermissionsBoundary", AccessPermissions, "Sets the permissions boundary of a role"},
+		{"PutUserPermissionsBoundary", AccessPermissions, "Sets the permissions boundary of a user"},
+		{"SimulatePrincipalPolicy", AccessRead, "Evaluates a principal's policies against actions"},
+	}},
+	{Prefix: "kms", Name: "AWS Key Management Service", Actions: []ActionDef{
+		{"CreateGrant", AccessPermissions, "Lets another principal use a key"},
+		{"CreateKey", AccessWrite, "Creates a symmetric key"},
+		{"Decrypt", AccessWrite, "Decrypts ciphertext encrypted under a key"},
+		{"DescribeKey", AccessRead, "Returns a key's metadata"},
+		{"DisableKey", AccessWrite, "Disables a key for cryptographic operations"},
+		{"EnableKey", AccessWrite, "Enables a disabled key"},
+		{"Encrypt", AccessWrite, "Encrypts plaintext under a key"},
+		{"ListGrants", AccessList, "Lists the grants of a key"},
+		{"ListKeys", AccessList, "Lists keys"},
+		{"PutKeyPolicy", AccessPermissions, "Replaces a key's policy"},
+		{"RevokeGrant", AccessPermissions, "Deletes a grant"},
+	}},
+	{Prefix: "lambda", Name: "AWS Lambda", Actions: []ActionDef{
+		{"CreateFunction", AccessWrite, "Creates a function"},
+		{"DeleteFunction", AccessWrite, "Deletes a function"},
+		{"GetFunction", AccessRead, "Returns a function's configuration"},
+		{"InvokeFunction", AccessWrite, "Invokes a function"},
+		{"ListFunctions", AccessList, "Lists functions"},
+	}},
+	{Prefix: "organizations", Name: "AWS Organizations", Actions: []ActionDef{
+		{"DescribeAccount", AccessRead, "Returns a member account"},
+		{"DescribeOrganization", AccessRead, "Returns the organization"},
+		{"DescribeOrganizationalUnit", AccessRead, "Returns an organizational unit"},
+		{"DescribePolicy", AccessRead, "Returns a service control policy"},
+		{"ListChildren", AccessList, "Lists the accounts or units under a parent"},
+		{"ListParents", AccessList, "Lists the parent of an account or unit"},
+		{"ListPolicies", AccessList, "Lists service control policies"},
+		{"ListPoliciesForTarget", AccessList, "Lists the policies attached to a target"},
+		{"ListRoots", AccessList, "Lists the roots of the organization"},
+		{"ListTargetsForPolicy", AccessList, "Lists the targets a policy is attached to"},
+	}},
+	{Prefix: "s3", Name: "Amazon S3", Actions: []ActionDef{
+		{"CreateBucket", AccessWrite, "Creates a bucket"},
+		{"DeleteBucket", AccessWrite, "Deletes an empty bucket"},
+		{"DeleteBucketPolicy", AccessPermissions, "Deletes a bucket's policy"},
+		{"DeleteObject", AccessWrite, "Deletes an object"},
+		{"GetBucketPolicy", AccessRead, "Returns a bucket's policy"},
+		{"GetObject", AccessRead, "Returns an object"},
+		{"ListAllMyBuckets", AccessList, "Lists the caller's buckets"},
+		{"ListBucket", AccessList, "Lists the objects of a bucket"},
+		{"PutBucketPolicy", AccessPermissions, "Replaces a bucket's policy"},
+		{"PutObject", AccessWrite, "Uploads an object"},
+	}},
+	{Prefix: "sns", Name: "Amazon SNS", Actions: []ActionDef{
+		{"CreateTopic", AccessWrite, "Creates a topic"},
+		{"DeleteTopic", AccessWrite, "Deletes a topic and its subscriptions"},
+		{"ListSubscriptionsByTopic", AccessList, "Lists the subscriptions of a topic"},
+		{"ListTopics", AccessList, "Lists topics"},
+		{"Publish", AccessWrite, "Delivers a message to a topic's subscribers"},
+		{"Subscribe", AccessWrite, "Subscribes a queue or function to a topic"},
+		{"Unsubscribe", AccessWrite, "Deletes a subscription"},
+	}},
+	{Prefix: "sqs", Name: "Amazon SQS", Actions: []ActionDef{
+		{"ChangeMessageVisibility", AccessWrite, "Changes how long a received message stays hidden"},
+		{"CreateQueue", AccessWrite, "Creates a queue"},
+		{"DeleteMessage", AccessWrite, "Deletes a received message"},
+		{"DeleteQueue", AccessWrite, "Deletes a queue and its messages"},
+		{"GetQueueAttributes", AccessRead, "Returns a queue's settings and message counts"},
+		{"ListQueues", AccessList, "Lists queues"},
+		{"ReceiveMessage", AccessRead, "Receives messages, hiding them for the visibility timeout"},
+		{"SendMessage", AccessWrite, "Sends a message to a queue"},
+		{"SetQueueAttributes", AccessWrite, "Changes a queue's settings"},
+	}},
+	{Prefix: "sts", Name: "AWS Security Token Service", Actions: []ActionDef{
+		{"AssumeRole", AccessWrite, "Returns temporary credentials for a role"},
+		{"GetCallerIdentity", AccessRead, "Returns the caller's account and ARN"},
+		{"SetSourceIdentity", AccessWrite, "Sets a source identity on an assumed-role session"},
+		{"TagSession", AccessTagging, "Passes session tags when assuming a role"},
+	}},
+}
+
+// Services returns the simulated services and their actions, sorted by
+// prefix. The result is a copy the caller may modify.
+func Services() []ServiceDef {
+	services := make([]ServiceDef, len(actionRegistry))
+	for i, s := range actionRegistry {
+		s.Actions = append([]ActionDef(nil), s.Actions...)
+		services[i] = s
+	}
+	return services
+}
+
+// LookupAction returns the registered action named by a "service:Action"
+// string, ignoring case as IAM does.
+func LookupAction(action string) (ActionDef, bool) {
+	prefix, name, ok := strings.Cut(action, ":")
+	if !ok {
+		return ActionDef{}, false
+	}
+	for _, s := range actionRegistry {
+		if !strings.EqualFold(s.Prefix, prefix) {
+			continue
+		}
+		for _, a := range s.Actions {
+			if strings.EqualFold(a.Name, name) {
+				return a, true
+			}
+		}
+	}
+	return ActionDef{}, false
+}
+
+// servicePrefixes returns the prefixes of the registered services, sorted.
+func servicePrefixes() []string {
+	prefixes := make([]string, len(actionRegistry))
+	for i, s := range actionRegistry {
+		prefixes[i] = s.Prefix
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
@@ -0,0 +1,230 @@
+package iam
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+const sourceTrust = "trust_policy"
+
+type AssumeRoleSimulationRequest struct {
+	PrincipalARN string `json:"principal_arn"`
+	RoleARN      string `json:"role_arn"`
+}
+
+type TrustPolicyDecision struct {
+	AllowedByTrustPolicy bool `json:"allowed_by_trust_policy"`
+	// NamesPrincipal is set when the trust policy names the caller itself
+	// rather than its whole account.
+	NamesPrincipal    bool               `json:"names_principal"`
+	MatchedStatements []MatchedStatement `json:"matched_statements"`
+}
+
+type AssumeRoleSimulation struct {
+	PrincipalARN       string              `json:"principal_arn"`
+	RoleARN            string              `json:"role_arn"`
+	CrossAccount       bool                `json:"cross_account"`
+	Decision           string              `json:"decision"`
+	Reason             string              `json:"reason"`
+	IdentityEvaluation EvaluationResult    `json:"identity_evaluation"`
+	TrustPolicy        TrustPolicyDecision `json:"trust_policy"`
+	// Evaluation is the identity and trust policies evaluated together,
+	// with the trace of how they decided.
+	Evaluation EvaluationResult `json:"evaluation"`
+}
+
+// parseTrustPolicy parses a role's trust policy, whose statements name a
+// Principal instead of a Resource.
+func parseTrustPolicy(document string) (*PolicyDocument, error) {
+	return parseResourcePolicy("trust policy", document)
+}
+
+// parseResourcePolicy parses a resource-based policy of the given kind,
+// such as a trust or bucket policy; every statement names a Principal.
+func parseResourcePolicy(kind, document string) (*PolicyDocument, error) {
+	var doc PolicyDocument
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		return nil, fmt.Errorf("%s is not valid JSON: %v", kind, err)
+	}
+	for i, s := range doc.Statement {
+		if s.Effect != "Allow" && s.Effect != "Deny" {
+			return nil, fmt.Errorf("statement %d: Effect must be Allow or Deny", i+1)
+		}
+		if len(s.Principal) == 0 {
+			return nil, fmt.Errorf("statement %d: Principal is required", i+1)
+		}
+	}
+	return &doc, nil
+}
+
+// ResourcePolicyPrincipals validates a bucket policy and returns the AWS
+// principals its Allow statements name, with "*" standing for anyone.
+func ResourcePolicyPrincipals(document string) ([]string, error) {
+	doc, err := parseResourcePolicy("bucket policy", document)
+	if err != nil {
+		return nil, err
+	}
+	var principals []string
+	for _, s := range doc.Statement {
+		if s.Effect != "Allow" {
+			continue
+		}
+		var wildcard string
+		if json.Unmarshal(s.Principal, &wildcard) == nil {
+			principals = append(principals, wildcard)
+			continue
+		}
+		var byType struct {
+			AWS stringList `json:"AWS"`
+		}
+		if json.Unmarshal(s.Principal, &byType) == nil {
+			principals = append(principals, byType.AWS...)
+		}
+	}
+	return principals, nil
+}
+
+// principalMatch reports whether a trust policy Principal covers the
+// caller, and whether it names the caller's ARN rather than its account.
+// Only "*" and the AWS key are considered; service and federated
+// principals never match an IAM user or role.
+func principalMatch(raw json.RawMessage, callerARN, callerAccount string) (matches, named bool) {
+	var wildcard string
+	if json.Unmarshal(raw, &wildcard) == nil {
+		return wildcard == "*", false
+	}
+	var byType struct {
+		AWS stringList `json:"AWS"`
+	}
+	if json.Unmarshal(raw, &byType) != nil {
+		return false, false
+	}
+	for _, p := range byType.AWS {
+		switch {
+		case sameARN(p, callerARN):
+			return true, true
+		case p == "*", accounts.SameAccount(p, callerAccount), sameARN(p, "arn:aws:iam::"+callerAccount+":root"):
+			matches = true
+		}
+	}
+	return matches, false
+}
+
+// sameARN reports whether two ARNs are equal, treating an account field
+// that names the account by its bare id as the padded account number.
+func sameARN(a, b string) bool {
+	if a == b {
+		return true
+	}
+	pa, pb := strings.SplitN(a, ":", 6), strings.SplitN(b, ":", 6)
+	if len(pa) < 6 || len(pb) < 6 || !accounts.SameAccount(pa[4], pb[4]) {
+		return false
+	}
+	pa[4], pb[4] = "", ""
+	return strings.Join(pa, ":") == strings.Join(pb, ":")
+}
+
+// loadTrustPolicy returns the trust policy of the role arn and the account
+// it is in: one of the caller's roles, or a member account's
+// OrganizationAccountAccessRole, which trusts the management account.
+func loadTrustPolicy(accountID int, arn string) (*PolicyDocument, string, error) {
+	var document string
+	err := db.DB.QueryRow("SELECT trust_policy FROM iam_roles WHERE account_id = $1 AND arn = $2",
+		accountID, arn).Scan(&document)
+	if errors.Is(err, sql.ErrNoRows) {
+		role, err := memberRole(accountID, arn)
+		if err != nil {
+			return nil, "", err
+		}
+		document = fmt.Sprintf(`{"Version": "2012-10-17", "Statement": [{"Effect": "Allow",
+			"Principal": {"AWS": "arn:aws:iam::%s:root"}, "Action": "sts:AssumeRole"}]}`, accounts.SimAccountNumber(accountID))
+		doc, err := parseTrustPolicy(document)
+		return doc, role.account, err
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	doc, err := parseTrustPolicy(document)
+	return doc, accounts.SimAccountNumber(accountID), err
+}
+
+// SimulateAssumeRoleHandler decides whether a user or role may assume a
+// role, evaluating the role's trust policy as its resource-based policy.
+// Across accounts both the caller's permissions (identity policies,
+// boundary and SCPs) and the trust policy must allow it. Within an account
+// a trust policy that names the caller is enough on its own; one that only
+// trusts the account defers to the caller's permissions.
+func SimulateAssumeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req AssumeRoleSimulationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.PrincipalARN == "" || req.RoleARN == "" {
+		apierror.Write(w, apierror.Validation("principal_arn and role_arn are required"))
+		return
+	}
+
+	caller, err := loadPrincipalByARN(accountID, req.PrincipalARN)
+	if errors.Is(err, errNoSuchPrincipal) {
+		apierror.Write(w, apierror.NotFound("No user or role with that principal ARN"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load principal: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load principal"))
+		return
+	}
+	trustDoc, roleAccount, err := loadTrustPolicy(accountID, req.RoleARN)
+	if errors.Is(err, errNoSuchPrincipal) {
+		apierror.Write(w, apierror.NotFound("No role with that ARN"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load trust policy of %s: %v", req.RoleARN, err)
+		apierror.Write(w, apierror.Internal("Failed to load trust policy"))
+		return
+	}
+	e, err := principalEvaluation(accountID, caller)
+	if err != nil {
+		log.Printf("Failed to load policies of %s: %v", caller.arn, err)
+		apierror.Write(w, apierror.Internal("Failed to load policies"))
+		return
+	}
+
+	trust := trustPolicy(trustDoc, roleAccount)
+	combined := e.evaluateWith("sts:AssumeRole", req.RoleARN, trust)
+	trustAllow, trustDeny, named, matched := trust.match("sts:AssumeRole", req.RoleARN, caller.arn, caller.account)
+	result := AssumeRoleSimulation{
+		PrincipalARN:       caller.arn,
+		RoleARN:            req.RoleARN,
+		CrossAccount:       combined.ResourcePolicyDecision.CrossAccount,
+		Decision:           combined.Decision,
+		Reason:             combined.Reason,
+		IdentityEvaluation: e.evaluate("sts:AssumeRole", req.RoleARN),
+		TrustPolicy: TrustPolicyDecision{
+			AllowedByTrustPolicy: trustAllow && !trustDeny,
+			NamesPrincipal:       named,
+			MatchedStatements:    append([]MatchedStatement{}, matched...),
+		},
+		Evaluation: combined,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,89 @@
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"allanswebterminal/accounts"
+)
+
+// ErrNoSuchPrincipal is returned by Authorize for a principal ARN that is
+// not a user or role of the account.
+var ErrNoSuchPrincipal = errNoSuchPrincipal
+
+// ResourcePolicy is the resource-based policy of a resource in another
+// simulated service, such as a KMS key policy. Statements are added to the
+// document's own, as KMS adds grants. A required policy must allow the
+// caller even within one account.
+type ResourcePolicy struct {
+	Kind       string
+	Document   string
+	Owner      string
+	Required   bool
+	Statements []Statement
+}
+
+// ValidateResourcePolicy checks a resource-based policy of the given kind,
+// such as "key policy": every statement needs an Effect and a Principal.
+func ValidateResourcePolicy(kind, document string) error {
+	_, err := parseResourcePolicy(kind, document)
+	return err
+}
+
+// Authorize decides whether principalARN, a user or role of the account,
+// may call action on resource, whose resource-based policy is policy (nil
+// if it has none). The call is logged for the access advisor. An empty
+// principalARN stands for the account root, which its identity allows
+// everything; only the resource policy can refuse it, and its calls are
+// not logged.
+func Authorize(ctx context.Context, accountID int, principalARN, action, resource string, policy *ResourcePolicy) (EvaluationResult, error) {
+	var rp *resourcePolicy
+	if policy != nil {
+		doc, err := parseResourcePolicy(policy.Kind, policy.Document)
+		if err != nil {
+			return EvaluationResult{}, err
+		}
+		doc.Statement = append(doc.Statement, policy.Statements...)
+		rp = &resourcePolicy{
+			sourcedPolicy: sourcedPolicy{id: policy.Kind, sourceType: policy.Kind, doc: doc},
+			kind:          policy.Kind,
+			owner:         policy.Owner,
+			required:      policy.Required,
+		}
+	}
+
+	if principalARN == "" {
+		account := accounts.SimAccountNumber(accountID)
+		root, err := parsePolicy(awsManagedPolicies["arn:aws:iam::aws:policy/AdministratorAccess"])
+		if err != nil {
+			return EvaluationResult{}, err
+		}
+		e := evaluation{
+			identity:      []sourcedPolicy{{id: "root", sourceType: sourceManaged, doc: root}},
+			callerARN:     fmt.Sprintf("arn:aws:iam::%s:root", account),
+			callerAccount: account,
+		}
+		return e.evaluateWith(action, resource, rp), nil
+	}
+
+	p, err := loadPrincipalByARN(accountID, principalARN)
+	if err != nil {
+		return EvaluationResult{}, err
+	}
+	e, err := principalEvaluation(accountID, p)
+	if err != nil {
+		return EvaluationResult{}, fmt.Errorf("policies of %s: %w", p.arn, err)
+	}
+	result := e.evaluateWith(action, resource, rp)
+	if err := recordCall(ctx, accountID, p.arn, result); err != nil {
+		return result, fmt.Errorf("recording call by %s: %w", p.arn, err)
+	}
+	return result, nil
+}
+
+// PrincipalJSON returns a policy Principal naming the AWS principal arn.
+func PrincipalJSON(arn string) json.RawMessage {
+	raw, _ := json.Marshal(map[string]string{"AWS": arn})
+	return raw
+}
@@ -0,0 +1,271 @@
+package iam
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+// principalKind is a table of IAM identities that policies and permissions
+// boundaries attach to.
+type principalKind struct {
+	table      string
+	nameColumn string
+	label      string
+}
+
+var (
+	userPrincipals = principalKind{table: "iam_users", nameColumn: "user_name", label: "User"}
+	rolePrincipals = principalKind{table: "iam_roles", nameColumn: "role_name", label: "Role"}
+)
+
+// principal is a user or role with the policies that apply to it.
+type principal struct {
+	kind                principalKind
+	name                string
+	arn                 string
+	account             string
+	permissionsBoundary *string
+	attachedPolicies    []string
+	inlinePolicies      map[string]json.RawMessage
+	// org is set for principals in a member account of the caller's
+	// organization, whose SCPs apply to them.
+	org *Organization
+}
+
+var errNoSuchPrincipal = errors.New("no such principal")
+
+// loadPrincipal finds the account's user or role whose column equals value.
+func loadPrincipal(kind principalKind, accountID int, column, value string) (*principal, error) {
+	p := &principal{kind: kind, account: accounts.SimAccountNumber(accountID)}
+	var attached, inline string
+	err := db.DB.QueryRow(fmt.Sprintf(`
+		SELECT %[1]s, arn, permissions_boundary, attached_policies, inline_policies
+		FROM %[2]s
+		WHERE account_id = $1 AND %[3]s = $2`, kind.nameColumn, kind.table, column),
+		accountID, value,
+	).Scan(&p.name, &p.arn, &p.permissionsBoundary, &attached, &inline)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNoSuchPrincipal
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(attached), &p.attachedPolicies); err != nil {
+		return nil, fmt.Errorf("attached_policies of %s: %w", p.arn, err)
+	}
+	if err := json.Unmarshal([]byte(inline), &p.inlinePolicies); err != nil {
+		return nil, fmt.Errorf("inline_policies of %s: %w", p.arn, err)
+	}
+	return p, nil
+}
+
+// loadPrincipalByARN finds the account's user or role with the given ARN,
+// or the OrganizationAccountAccessRole of one of its member accounts.
+func loadPrincipalByARN(accountID int, arn string) (*principal, error) {
+	for _, kind := range []principalKind{userPrincipals, rolePrincipals} {
+		p, err := loadPrincipal(kind, accountID, "arn", arn)
+		if !errors.Is(err, errNoSuchPrincipal) {
+			return p, err
+		}
+	}
+	return memberRole(accountID, arn)
+}
+
+// memberRole returns the OrganizationAccountAccessRole with the given ARN
+// in a member account of the caller's organization.
+func memberRole(accountID int, arn string) (*principal, error) {
+	number := arnAccount(arn)
+	if arn != orgAccessRoleARN(number) {
+		return nil, errNoSuchPrincipal
+	}
+	o, err := loadOrganization(accountID)
+	if errors.Is(err, errNoOrganization) {
+		return nil, errNoSuchPrincipal
+	}
+	if err != nil {
+		return nil, err
+	}
+	if member, err := isMemberAccount(o, number); err != nil || !member {
+		if err == nil {
+			err = errNoSuchPrincipal
+		}
+		return nil, err
+	}
+	return &principal{
+		kind:             rolePrincipals,
+		name:             orgAccessRole,
+		arn:              arn,
+		account:          number,
+		attachedPolicies: []string{"arn:aws:iam::aws:policy/AdministratorAccess"},
+		org:              o,
+	}, nil
+}
+
+// arnAccount returns the account field of an ARN.
+func arnAccount(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
+
+// updatePrincipal updates the principal named by {name} with the given SET
+// clause, extra WHERE condition and arguments from $3 on, and writes a 404
+// if no row matched.
+func updatePrincipal(w http.ResponseWriter, r *http.Request, accountID int, kind principalKind, set, where string, args ...interface{}) bool {
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE account_id = $1 AND %s = $2%s", kind.table, set, kind.nameColumn, where)
+	result, err := db.DB.Exec(query, append([]interface{}{accountID, r.PathValue("name")}, args...)...)
+	if err != nil {
+		log.Printf("Failed to update %s: %v", kind.table, err)
+		apierror.Write(w, apierror.Internal("Failed to update "+kind.label))
+		return false
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if where != "" {
+			apierror.Write(w, apierror.NotFound(kind.label+" or attached policy not found"))
+		} else {
+			apierror.Write(w, apierror.NotFound(kind.label+" not found"))
+		}
+		return false
+	}
+	return true
+}
+
+type PolicyARNRequest struct {
+	PolicyARN string `json:"policy_arn"`
+}
+
+func decodePolicyARN(w http.ResponseWriter, r *http.Request, accountID int) (string, bool) {
+	var req PolicyARNRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return "", false
+	}
+	if req.PolicyARN == "" {
+		apierror.Write(w, apierror.Validation("PolicyArn is required"))
+		return "", false
+	}
+	return req.PolicyARN, managedPolicyExists(w, accountID, req.PolicyARN)
+}
+
+// putPermissionsBoundary sets the managed policy that caps the principal's
+// permissions: an action is only allowed if both its identity policies and
+// the boundary allow it.
+func putPermissionsBoundary(w http.ResponseWriter, r *http.Request, kind principalKind) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	arn, ok := decodePolicyARN(w, r, accountID)
+	if !ok {
+		return
+	}
+	if updatePrincipal(w, r, accountID, kind, "permissions_boundary = $3", "", arn) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func deletePermissionsBoundary(w http.ResponseWriter, r *http.Request, kind principalKind) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	if updatePrincipal(w, r, accountID, kind, "permissions_boundary = NULL", "") {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// attachPolicy adds a managed policy to the principal's identity policies.
+// Attaching a policy twice is not an error.
+func attachPolicy(w http.ResponseWriter, r *http.Request, kind principalKind) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	arn, ok := decodePolicyARN(w, r, accountID)
+	if !ok {
+		return
+	}
+	set := `attached_policies = CASE WHEN attached_policies ? $3 THEN attached_policies
+		ELSE attached_policies || to_jsonb($3::text) END`
+	if updatePrincipal(w, r, accountID, kind, set, "", arn) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func detachPolicy(w http.ResponseWriter, r *http.Request, kind principalKind) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	arn := r.URL.Query().Get("policy_arn")
+	if arn == "" {
+		apierror.Write(w, apierror.Validation("policy_arn is required"))
+		return
+	}
+	if updatePrincipal(w, r, accountID, kind, "attached_policies = attached_policies - $3::text", " AND attached_policies ? $3", arn) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PutUserPermissionsBoundaryHandler sets the permissions boundary of the
+// user named by {name}.
+func PutUserPermissionsBoundaryHandler(w http.ResponseWriter, r *http.Request) {
+	putPermissionsBoundary(w, r, userPrincipals)
+}
+
+// DeleteUserPermissionsBoundaryHandler removes the user's permissions
+// boundary.
+func DeleteUserPermissionsBoundaryHandler(w http.ResponseWriter, r *http.Request) {
+	deletePermissionsBoundary(w, r, userPrincipals)
+}
+
+// PutRolePermissionsBoundaryHandler sets the permissions boundary of the
+// role named by {name}.
+func PutRolePermissionsBoundaryHandler(w http.ResponseWriter, r *http.Request) {
+	putPermissionsBoundary(w, r, rolePrincipals)
+}
+
+// DeleteRolePermissionsBoundaryHandler removes the role's permissions
+// boundary.
+func DeleteRolePermissionsBoundaryHandler(w http.ResponseWriter, r *http.Request) {
+	deletePermissionsBoundary(w, r, rolePrincipals)
+}
+
+// AttachUserPolicyHandler attaches a managed policy to the user named by
+// {name}.
+func AttachUserPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	attachPolicy(w, r, userPrincipals)
+}
+
+// DetachUserPolicyHandler detaches the managed policy given by the
+// policy_arn query parameter from the user.
+func DetachUserPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	detachPolicy(w, r, userPrincipals)
+}
+
+// AttachRolePolicyHandler attaches a managed policy to the role named by
+// {name}.
+func AttachRolePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	attachPolicy(w, r, rolePrincipals)
+}
+
+// DetachRolePolicyHandler detaches the managed policy given by the
+// policy_arn query parameter from the role.
+func DetachRolePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	detachPolicy(w, r, rolePrincipals)
+}
@@ -0,0 +1,118 @@
+package iam
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+type CredentialReport struct {
+	GeneratedTime time.Time `json:"generated_time"`
+	ReportFormat  string    `json:"report_format"`
+	Content       string    `json:"content"`
+}
+
+var credentialReportHeader = []string{
+	"user", "arn", "user_creation_time", "password_last_used",
+	"mfa_active", "access_keys_count", "status",
+}
+
+// GenerateCredentialReports rebuilds the CSV credential report for every
+// account that has IAM users. It runs from the scheduler.
+func GenerateCredentialReports(ctx context.Context) error {
+	query := `
+		SELECT account_id, user_name, arn, created_date, password_last_used,
+			   mfa_enabled, access_keys_count, status
+		FROM iam_users
+		ORDER BY account_id, user_name
+	`
+	rows, err := db.DB.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to load IAM users: %w", err)
+	}
+	defer rows.Close()
+
+	reports := make(map[int]*bytes.Buffer)
+	writers := make(map[int]*csv.Writer)
+	for rows.Next() {
+		var (
+			accountID, keys     int
+			userName, arn, stat string
+			created             time.Time
+			lastUsed            sql.NullTime
+			mfa                 bool
+		)
+		if err := rows.Scan(&accountID, &userName, &arn, &created, &lastUsed, &mfa, &keys, &stat); err != nil {
+			return err
+		}
+
+		writer, ok := writers[accountID]
+		if !ok {
+			reports[accountID] = &bytes.Buffer{}
+			writer = csv.NewWriter(reports[accountID])
+			writer.Write(credentialReportHeader)
+			writers[accountID] = writer
+		}
+
+		passwordLastUsed := "N/A"
+		if lastUsed.Valid {
+			passwordLastUsed = lastUsed.Time.UTC().Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			userName, arn, created.UTC().Format(time.RFC3339), passwordLastUsed,
+			strconv.FormatBool(mfa), strconv.Itoa(keys), stat,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	upsert := `
+		INSERT INTO iam_credential_reports (account_id, content, generated_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (account_id)
+		DO UPDATE SET content = EXCLUDED.content, generated_at = CURRENT_TIMESTAMP
+	`
+	for accountID, writer := range writers {
+		writer.Flush()
+		if _, err := db.DB.ExecContext(ctx, upsert, accountID, reports[accountID].String()); err != nil {
+			return fmt.Errorf("failed to store credential report for account %d: %w", accountID, err)
+		}
+	}
+	return nil
+}
+
+func GetCredentialReportHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	report := CredentialReport{ReportFormat: "text/csv"}
+	err := db.DB.QueryRow(
+		"SELECT content, generated_at FROM iam_credential_reports WHERE account_id = $1", accountID,
+	).Scan(&report.Content, &report.GeneratedTime)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, apierror.NotFound("Credential report not generated yet"))
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
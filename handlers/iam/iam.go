@@ -2,12 +2,18 @@ package iam
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
 	"allanswebterminal/db"
+	"allanswebterminal/validate"
 )
 
 type IAMUser struct {
@@ -47,18 +53,20 @@ type IAMRole struct {
 }
 
 type CreateUserRequest struct {
-	UserName string            `json:"user_name"`
-	Path     string            `json:"path"`
-	Tags     map[string]string `json:"tags"`
+	UserName string            `json:"user_name" validate:"required,max=64"`
+	Path     string            `json:"path" validate:"max=512"`
+	Tags     map[string]string `json:"tags" validate:"max=50"`
 }
 
+// CreateRoleRequest follows the IAM limits; MaxSessionDuration is in
+// seconds and defaults to an hour.
 type CreateRoleRequest struct {
-	RoleName             string            `json:"role_name"`
-	Path                 string            `json:"path"`
-	Description          string            `json:"description"`
-	AssumeRolePolicyDoc  string            `json:"assume_role_policy_document"`
-	MaxSessionDuration   int               `json:"max_session_duration"`
-	Tags                 map[string]string `json:"tags"`
+	RoleName             string            `json:"role_name" validate:"required,max=64"`
+	Path                 string            `json:"path" validate:"max=512"`
+	Description          string            `json:"description" validate:"max=1000"`
+	AssumeRolePolicyDoc  string            `json:"assume_role_policy_document" validate:"max=6144"`
+	MaxSessionDuration   int               `json:"max_session_duration" validate:"min=3600,max=43200"`
+	Tags                 map[string]string `json:"tags" validate:"max=50"`
 }
 
 func generateUserID() string {
@@ -74,26 +82,21 @@ func generateRoleID() string {
 }
 
 func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Get account ID from session/auth
 	accountID := getAccountIDFromSession(r)
 	if accountID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, apierror.Unauthorized())
 		return
 	}
 
 	var req CreateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		apierror.Write(w, apierror.DecodeError(err))
 		return
 	}
 
-	if req.UserName == "" {
-		http.Error(w, "UserName is required", http.StatusBadRequest)
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
 		return
 	}
 
@@ -103,7 +106,7 @@ func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Generate unique IDs
 	userID := generateUserID()
-	arn := fmt.Sprintf("arn:aws:iam::%d:user%s%s", accountID, req.Path, req.UserName)
+	arn := fmt.Sprintf("arn:aws:iam::%s:user%s%s", accounts.SimAccountNumber(accountID), req.Path, req.UserName)
 
 	// Convert tags to JSON
 	tagsJSON, _ := json.Marshal(req.Tags)
@@ -120,7 +123,8 @@ func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var createdDate time.Time
 	err := db.DB.QueryRow(query, accountID, req.UserName, userID, arn, req.Path, string(tagsJSON)).Scan(&id, &createdDate)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create user: %v", err), http.StatusInternalServerError)
+		log.Printf("Failed to create user: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create user"))
 		return
 	}
 
@@ -146,26 +150,21 @@ func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Get account ID from session/auth
 	accountID := getAccountIDFromSession(r)
 	if accountID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, apierror.Unauthorized())
 		return
 	}
 
 	var req CreateRoleRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		apierror.Write(w, apierror.DecodeError(err))
 		return
 	}
 
-	if req.RoleName == "" {
-		http.Error(w, "RoleName is required", http.StatusBadRequest)
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
 		return
 	}
 
@@ -195,7 +194,7 @@ func CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Generate unique IDs
 	roleID := generateRoleID()
-	arn := fmt.Sprintf("arn:aws:iam::%d:role%s%s", accountID, req.Path, req.RoleName)
+	arn := fmt.Sprintf("arn:aws:iam::%s:role%s%s", accounts.SimAccountNumber(accountID), req.Path, req.RoleName)
 
 	// Convert tags to JSON
 	tagsJSON, _ := json.Marshal(req.Tags)
@@ -216,7 +215,8 @@ func CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
 		req.Description, req.AssumeRolePolicyDoc, req.MaxSessionDuration, string(tagsJSON),
 	).Scan(&id, &createdDate)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create role: %v", err), http.StatusInternalServerError)
+		log.Printf("Failed to create role: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create role"))
 		return
 	}
 
@@ -244,14 +244,9 @@ func CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	accountID := getAccountIDFromSession(r)
 	if accountID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, apierror.Unauthorized())
 		return
 	}
 
@@ -267,7 +262,8 @@ func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 
 	rows, err := db.DB.Query(query, accountID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
 		return
 	}
 	defer rows.Close()
@@ -282,7 +278,8 @@ func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 			&user.AttachedPolicies, &user.InlinePolicies, &user.Groups, &user.Status,
 		)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Scan error: %v", err), http.StatusInternalServerError)
+			log.Printf("Scan error: %v", err)
+			apierror.Write(w, apierror.Internal("Scan error"))
 			return
 		}
 		users = append(users, user)
@@ -292,15 +289,71 @@ func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(users)
 }
 
-func ListRolesHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// GetUserHandler returns the IAM user named by the {name} path parameter.
+func GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
 		return
 	}
 
+	query := `
+		SELECT id, account_id, user_name, user_id, arn, path,
+			   permissions_boundary, tags, created_date, password_last_used,
+			   mfa_enabled, access_keys_count, attached_policies,
+			   inline_policies, groups, status
+		FROM iam_users
+		WHERE account_id = $1 AND user_name = $2
+	`
+
+	var user IAMUser
+	err := db.DB.QueryRow(query, accountID, r.PathValue("name")).Scan(
+		&user.ID, &user.AccountID, &user.UserName, &user.UserID, &user.ARN,
+		&user.Path, &user.PermissionsBoundary, &user.Tags, &user.CreatedDate,
+		&user.PasswordLastUsed, &user.MFAEnabled, &user.AccessKeysCount,
+		&user.AttachedPolicies, &user.InlinePolicies, &user.Groups, &user.Status,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("User not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// DeleteUserHandler deletes the IAM user named by the {name} path parameter.
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	accountID := getAccountIDFromSession(r)
 	if accountID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	result, err := db.DB.Exec("DELETE FROM iam_users WHERE account_id = $1 AND user_name = $2",
+		accountID, r.PathValue("name"))
+	if err != nil {
+		log.Printf("Failed to delete user: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete user"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("User not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ListRolesHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
 		return
 	}
 
@@ -315,7 +368,8 @@ func ListRolesHandler(w http.ResponseWriter, r *http.Request) {
 
 	rows, err := db.DB.Query(query, accountID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
 		return
 	}
 	defer rows.Close()
@@ -330,7 +384,8 @@ func ListRolesHandler(w http.ResponseWriter, r *http.Request) {
 			&role.AttachedPolicies, &role.InlinePolicies,
 		)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Scan error: %v", err), http.StatusInternalServerError)
+			log.Printf("Scan error: %v", err)
+			apierror.Write(w, apierror.Internal("Scan error"))
 			return
 		}
 		roles = append(roles, role)
@@ -340,6 +395,12 @@ func ListRolesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(roles)
 }
 
+// AccountID returns the simulated AWS account the request acts on, for
+// other simulated services that share IAM's accounts.
+func AccountID(r *http.Request) int {
+	return getAccountIDFromSession(r)
+}
+
 // Helper function to get account ID from session
 func getAccountIDFromSession(r *http.Request) int {
 	// This is a placeholder - you'll need to implement actual session handling
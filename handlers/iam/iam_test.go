@@ -2,13 +2,43 @@ package iam
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/db"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/crypto/bcrypt"
 )
 
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		mockDB.Close()
+		db.DB = originalDB
+	})
+	return mock
+}
+
 func TestCreateUserHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("INSERT INTO iam_users").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_date"}).AddRow(1, time.Now()))
+
 	req := CreateUserRequest{
 		UserName: "test-user",
 		Path:     "/",
@@ -30,9 +60,16 @@ func TestCreateUserHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v or %v",
 			status, http.StatusOK, http.StatusInternalServerError)
 	}
+	if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), `"arn:aws:iam::000000000001:user/test-user"`) {
+		t.Errorf("ARN is not in the simulated account's namespace: %s", rr.Body.String())
+	}
 }
 
 func TestCreateRoleHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("INSERT INTO iam_roles").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_date"}).AddRow(1, time.Now()))
+
 	req := CreateRoleRequest{
 		RoleName:    "test-role",
 		Path:        "/",
@@ -55,6 +92,59 @@ func TestCreateRoleHandler(t *testing.T) {
 	}
 }
 
+func TestGetUserHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT id, account_id, user_name").
+		WithArgs(1, "alice").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "account_id", "user_name", "user_id", "arn", "path",
+			"permissions_boundary", "tags", "created_date", "password_last_used",
+			"mfa_enabled", "access_keys_count", "attached_policies",
+			"inline_policies", "groups", "status",
+		}).AddRow(7, 1, "alice", "AIDA1", "arn:aws:iam::1:user/alice", "/",
+			nil, "{}", time.Now(), nil, false, 0, "[]", "{}", "[]", "Active"))
+	mock.ExpectQuery("SELECT id, account_id, user_name").
+		WithArgs(1, "bob").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/iam/users/{name}", GetUserHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/iam/users/alice", nil))
+	var user IAMUser
+	json.NewDecoder(rec.Body).Decode(&user)
+	if rec.Code != http.StatusOK || user.UserName != "alice" {
+		t.Errorf("status = %d, user = %+v", rec.Code, user)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/iam/users/bob", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing user status = %d, want 404", rec.Code)
+	}
+}
+
+func TestDeleteUserHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectExec("DELETE FROM iam_users").WithArgs(1, "alice").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM iam_users").WithArgs(1, "bob").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/iam/users/{name}", DeleteUserHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/iam/users/alice", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/iam/users/bob", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing user status = %d, want 404", rec.Code)
+	}
+}
+
 func TestGenerateUserID(t *testing.T) {
 	id1 := generateUserID()
 	id2 := generateUserID()
@@ -87,4 +177,859 @@ func TestGenerateRoleID(t *testing.T) {
 	if id1[:4] != "AROA" {
 		t.Errorf("generateRoleID should start with AROA, got %s", id1[:4])
 	}
-}
\ No newline at end of file
+}
+func TestGenerateCredentialReports(t *testing.T) {
+	mock := setupMockDB(t)
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery("SELECT account_id, user_name").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"account_id", "user_name", "arn", "created_date", "password_last_used",
+			"mfa_enabled", "access_keys_count", "status",
+		}).AddRow(1, "alice", "arn:aws:iam::1:user/alice", created, nil, true, 2, "Active"))
+	mock.ExpectExec("INSERT INTO iam_credential_reports").
+		WithArgs(1, "user,arn,user_creation_time,password_last_used,mfa_active,access_keys_count,status\n"+
+			"alice,arn:aws:iam::1:user/alice,2024-01-02T03:04:05Z,N/A,true,2,Active\n").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := GenerateCredentialReports(context.Background()); err != nil {
+		t.Fatalf("GenerateCredentialReports failed: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func policyRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"minimum_password_length", "require_symbols", "require_numbers",
+		"require_uppercase", "require_lowercase", "max_password_age",
+	})
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	strict := PasswordPolicy{
+		MinimumPasswordLength:      10,
+		RequireSymbols:             true,
+		RequireNumbers:             true,
+		RequireUppercaseCharacters: true,
+		RequireLowercaseCharacters: true,
+	}
+	tests := []struct {
+		password string
+		broken   int
+	}{
+		{"Correct-Horse-9", 0},
+		{"short", 4},
+		{"alllowercase", 3},
+		{"NoSymbols123", 1},
+		{strings.Repeat("Aa1!", 20), 1},
+	}
+	for _, tt := range tests {
+		if got := strict.Check(tt.password); len(got) != tt.broken {
+			t.Errorf("Check(%q) = %v, want %d violations", tt.password, got, tt.broken)
+		}
+	}
+	if got := DefaultPasswordPolicy().Check("12345678"); len(got) != 0 {
+		t.Errorf("default policy rejected an 8 character password: %v", got)
+	}
+}
+
+func TestUpdatePasswordPolicyHandler(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		setupMockDB(t)
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/api/iam/account-password-policy", strings.NewReader(`{"minimum_password_length":4}`))
+		UpdatePasswordPolicyHandler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rr.Code)
+		}
+	})
+
+	t.Run("saved", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectExec("INSERT INTO iam_password_policies").
+			WithArgs(1, 12, true, false, false, false, 90).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/api/iam/account-password-policy",
+			strings.NewReader(`{"minimum_password_length":12,"require_symbols":true,"max_password_age":90}`))
+		UpdatePasswordPolicyHandler(rr, req)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"expire_passwords":true`) {
+			t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestCreateLoginProfileEnforcesPolicy(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM iam_password_policies").WithArgs(1).
+		WillReturnRows(policyRows().AddRow(12, true, false, false, false, 0))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/iam/users/alice/login-profile", strings.NewReader(`{"password":"tooshort"}`))
+	req.SetPathValue("name", "alice")
+	CreateLoginProfileHandler(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "must contain a symbol") {
+		t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateLoginProfileHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM iam_password_policies").WithArgs(1).
+		WillReturnRows(policyRows().AddRow(8, false, false, false, false, 30))
+	mock.ExpectQuery("SELECT id FROM iam_users").WithArgs(1, "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery("INSERT INTO iam_login_profiles").WithArgs(5, sqlmock.AnyArg(), true).
+		WillReturnRows(sqlmock.NewRows([]string{"created_date"}).AddRow(created))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/iam/users/alice/login-profile",
+		strings.NewReader(`{"password":"long enough","password_reset_required":true}`))
+	req.SetPathValue("name", "alice")
+	CreateLoginProfileHandler(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+	var profile LoginProfile
+	json.NewDecoder(rr.Body).Decode(&profile)
+	if profile.PasswordExpiresAt == nil || !profile.PasswordExpiresAt.Equal(created.AddDate(0, 0, 30)) || !profile.PasswordResetRequired {
+		t.Errorf("profile = %+v", profile)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func profileRows(hash string, reset bool, changed time.Time) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "arn", "status", "password_hash", "password_reset_required", "created_date", "password_changed_at",
+	}).AddRow(5, "arn:aws:iam::123456789012:user/alice", "Active", hash, reset, changed, changed)
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestUpdateLoginProfileHandler(t *testing.T) {
+	mock := setupMockDB(t)
+
+	mock.ExpectQuery("FROM iam_users u").WithArgs(1, "alice").
+		WillReturnRows(profileRows("old", false, time.Now()))
+	mock.ExpectQuery("FROM iam_password_policies").WithArgs(1).
+		WillReturnRows(policyRows().AddRow(8, false, false, false, false, 0))
+	changed := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("UPDATE iam_login_profiles").WithArgs(5, sqlmock.AnyArg(), true).
+		WillReturnRows(sqlmock.NewRows([]string{"password_changed_at"}).AddRow(changed))
+
+	req := httptest.NewRequest("PUT", "/api/iam/users/alice/login-profile",
+		strings.NewReader(`{"password":"brand-new-pass","password_reset_required":true}`))
+	req.SetPathValue("name", "alice")
+	rr := httptest.NewRecorder()
+	UpdateLoginProfileHandler(rr, req)
+
+	var profile LoginProfile
+	json.NewDecoder(rr.Body).Decode(&profile)
+	if rr.Code != http.StatusOK || !profile.PasswordResetRequired || profile.PasswordExpiresAt != nil {
+		t.Errorf("status = %d, profile = %+v", rr.Code, profile)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteLoginProfileHandler(t *testing.T) {
+	mock := setupMockDB(t)
+
+	mock.ExpectExec("DELETE FROM iam_login_profiles").WithArgs(1, "alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM iam_login_profiles").WithArgs(1, "bob").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	for _, tt := range []struct {
+		name string
+		want int
+	}{{"alice", http.StatusNoContent}, {"bob", http.StatusNotFound}} {
+		req := httptest.NewRequest("DELETE", "/api/iam/users/"+tt.name+"/login-profile", nil)
+		req.SetPathValue("name", tt.name)
+		rr := httptest.NewRecorder()
+		DeleteLoginProfileHandler(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestConsoleSignInHandler(t *testing.T) {
+	hash := bcryptHash(t, "correct-horse")
+
+	t.Run("wrong password", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("FROM iam_users u").WithArgs(1, "alice").
+			WillReturnRows(profileRows(hash, false, time.Now()))
+
+		rr := httptest.NewRecorder()
+		ConsoleSignInHandler(rr, httptest.NewRequest("POST", "/api/iam/console-signin",
+			strings.NewReader(`{"user_name":"alice","password":"battery-staple"}`)))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", rr.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("records password last used", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("FROM iam_users u").WithArgs(1, "alice").
+			WillReturnRows(profileRows(hash, false, time.Now()))
+		mock.ExpectQuery("FROM iam_password_policies").WithArgs(1).
+			WillReturnRows(policyRows().AddRow(8, false, false, false, false, 90))
+		used := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("UPDATE iam_users SET password_last_used").WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"password_last_used"}).AddRow(used))
+
+		rr := httptest.NewRecorder()
+		ConsoleSignInHandler(rr, httptest.NewRequest("POST", "/api/iam/console-signin",
+			strings.NewReader(`{"user_name":"alice","password":"correct-horse"}`)))
+		var result ConsoleSignInResult
+		json.NewDecoder(rr.Body).Decode(&result)
+		if rr.Code != http.StatusOK || !result.PasswordLastUsed.Equal(used) || result.PasswordChanged {
+			t.Errorf("status = %d, result = %+v", rr.Code, result)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("expired password needs a new one", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("FROM iam_users u").WithArgs(1, "alice").
+			WillReturnRows(profileRows(hash, false, time.Now().AddDate(0, 0, -91)))
+		mock.ExpectQuery("FROM iam_password_policies").WithArgs(1).
+			WillReturnRows(policyRows().AddRow(8, false, false, false, false, 90))
+
+		rr := httptest.NewRecorder()
+		ConsoleSignInHandler(rr, httptest.NewRequest("POST", "/api/iam/console-signin",
+			strings.NewReader(`{"user_name":"alice","password":"correct-horse"}`)))
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "password_expired") {
+			t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("reset replaces the password", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("FROM iam_users u").WithArgs(1, "alice").
+			WillReturnRows(profileRows(hash, true, time.Now()))
+		mock.ExpectQuery("FROM iam_password_policies").WithArgs(1).
+			WillReturnRows(policyRows().AddRow(8, false, false, false, false, 0))
+		mock.ExpectQuery("FROM iam_password_policies").WithArgs(1).
+			WillReturnRows(policyRows().AddRow(8, false, false, false, false, 0))
+		mock.ExpectQuery("UPDATE iam_login_profiles").WithArgs(5, sqlmock.AnyArg(), false).
+			WillReturnRows(sqlmock.NewRows([]string{"password_changed_at"}).AddRow(time.Now()))
+		mock.ExpectQuery("UPDATE iam_users SET password_last_used").WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"password_last_used"}).AddRow(time.Now()))
+
+		rr := httptest.NewRecorder()
+		ConsoleSignInHandler(rr, httptest.NewRequest("POST", "/api/iam/console-signin",
+			strings.NewReader(`{"user_name":"alice","password":"correct-horse","new_password":"tr0ub4dor-and-3"}`)))
+		var result ConsoleSignInResult
+		json.NewDecoder(rr.Body).Decode(&result)
+		if rr.Code != http.StatusOK || !result.PasswordChanged {
+			t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"s3:Get*", "s3:GetObject", true},
+		{"s3:Get*", "s3:PutObject", false},
+		{"arn:aws:s3:::bucket/*", "arn:aws:s3:::bucket/a/b", true},
+		{"arn:aws:s3:::bucket/*", "arn:aws:s3:::other/a", false},
+		{"ec2:?escribe*", "ec2:DescribeInstances", true},
+		{"*:List*", "iam:ListUsers", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func mustPolicy(t *testing.T, id, sourceType, document string) sourcedPolicy {
+	t.Helper()
+	doc, err := parsePolicy(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sourcedPolicy{id: id, sourceType: sourceType, doc: doc}
+}
+
+func TestEvaluatePermissionsBoundary(t *testing.T) {
+	admin := mustPolicy(t, "admin", sourceManaged, awsManagedPolicies["arn:aws:iam::aws:policy/AdministratorAccess"])
+	s3Only := mustPolicy(t, "s3-only", sourceBoundary, `{"Statement": [
+		{"Effect": "Allow", "Action": "s3:*", "Resource": "*"},
+		{"Effect": "Deny", "Action": "s3:DeleteBucket", "Resource": "*"}]}`)
+
+	tests := []struct {
+		action       string
+		boundary     *sourcedPolicy
+		want         string
+		allowedByPB  bool
+		matchedCount int
+	}{
+		{"iam:CreateUser", nil, decisionAllowed, false, 1},
+		{"s3:GetObject", &s3Only, decisionAllowed, true, 2},
+		{"iam:CreateUser", &s3Only, decisionImplicitDeny, false, 1},
+		{"s3:DeleteBucket", &s3Only, decisionExplicitDeny, false, 3},
+	}
+	for _, tt := range tests {
+		got := evaluation{identity: []sourcedPolicy{admin}, boundary: tt.boundary}.evaluate(tt.action, "*")
+		if got.Decision != tt.want || len(got.MatchedStatements) != tt.matchedCount {
+			t.Errorf("%s: decision %s with %d statements, want %s with %d", tt.action, got.Decision,
+				len(got.MatchedStatements), tt.want, tt.matchedCount)
+		}
+		if (got.PermissionsBoundaryDecision != nil) != (tt.boundary != nil) ||
+			got.PermissionsBoundaryDecision != nil && got.PermissionsBoundaryDecision.AllowedByPermissionsBoundary != tt.allowedByPB {
+			t.Errorf("%s: boundary detail = %+v", tt.action, got.PermissionsBoundaryDecision)
+		}
+	}
+
+	// The boundary grants nothing on its own.
+	if got := (evaluation{boundary: &s3Only}).evaluate("s3:GetObject", "*"); got.Decision != decisionImplicitDeny {
+		t.Errorf("boundary alone: decision %s, want implicitDeny", got.Decision)
+	}
+}
+
+func TestSimulatePolicyHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	userARN := "arn:aws:iam::1:user/alice"
+	boundaryARN := "arn:aws:iam::1:policy/S3ReadOnly"
+	mock.ExpectQuery("FROM iam_users").WithArgs(1, userARN).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"user_name", "arn", "permissions_boundary", "attached_policies", "inline_policies",
+		}).AddRow("alice", userARN, boundaryARN,
+			`["arn:aws:iam::aws:policy/PowerUserAccess"]`,
+			`{"deny-ec2": "{\"Statement\": {\"Effect\": \"Deny\", \"Action\": \"ec2:*\", \"Resource\": \"*\"}}"}`))
+	mock.ExpectQuery("SELECT policy_document FROM iam_policies").WithArgs(1, boundaryARN).
+		WillReturnRows(sqlmock.NewRows([]string{"policy_document"}).
+			AddRow(`{"Statement": [{"Effect": "Allow", "Action": ["s3:Get*", "s3:List*", "ec2:*"], "Resource": "*"}]}`))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/iam/simulate", strings.NewReader(`{
+		"policy_source_arn": "`+userARN+`",
+		"action_names": ["s3:GetObject", "s3:PutObject", "ec2:RunInstances"]}`))
+	SimulatePolicyHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+
+	var result SimulationResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	want := map[string]string{
+		"s3:GetObject":     decisionAllowed,
+		"s3:PutObject":     decisionImplicitDeny,
+		"ec2:RunInstances": decisionExplicitDeny,
+	}
+	if len(result.EvaluationResults) != len(want) {
+		t.Fatalf("results = %+v", result.EvaluationResults)
+	}
+	for _, r := range result.EvaluationResults {
+		if r.Decision != want[r.ActionName] || r.ResourceName != "*" {
+			t.Errorf("%s on %s: %s, want %s", r.ActionName, r.ResourceName, r.Decision, want[r.ActionName])
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPutUserPermissionsBoundaryHandler(t *testing.T) {
+	t.Run("unknown policy", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("FROM iam_policies").WithArgs(1, "arn:aws:iam::1:policy/Nope").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/api/iam/users/alice/permissions-boundary",
+			strings.NewReader(`{"policy_arn":"arn:aws:iam::1:policy/Nope"}`))
+		req.SetPathValue("name", "alice")
+		PutUserPermissionsBoundaryHandler(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rr.Code)
+		}
+	})
+
+	t.Run("aws managed", func(t *testing.T) {
+		mock := setupMockDB(t)
+		arn := "arn:aws:iam::aws:policy/ReadOnlyAccess"
+		mock.ExpectExec("UPDATE iam_users SET permissions_boundary").WithArgs(1, "alice", arn).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/api/iam/users/alice/permissions-boundary",
+			strings.NewReader(`{"policy_arn":"`+arn+`"}`))
+		req.SetPathValue("name", "alice")
+		PutUserPermissionsBoundaryHandler(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestEvaluateServiceControlPolicies(t *testing.T) {
+	admin := mustPolicy(t, "admin", sourceManaged, awsManagedPolicies["arn:aws:iam::aws:policy/AdministratorAccess"])
+	full := mustPolicy(t, fullAWSAccessID, sourceSCP, fullAWSAccessContent)
+	denyS3 := mustPolicy(t, "deny-s3", sourceSCP, `{"Statement": {"Effect": "Deny", "Action": "s3:*", "Resource": "*"}}`)
+	ec2Only := mustPolicy(t, "ec2-only", sourceSCP, `{"Statement": {"Effect": "Allow", "Action": "ec2:*", "Resource": "*"}}`)
+
+	tests := []struct {
+		name   string
+		scps   [][]sourcedPolicy
+		action string
+		want   string
+		orgs   bool
+	}{
+		{"full access", [][]sourcedPolicy{{full}, {full}}, "s3:GetObject", decisionAllowed, true},
+		{"deny on account", [][]sourcedPolicy{{full}, {full, denyS3}}, "s3:GetObject", decisionExplicitDeny, false},
+		{"deny elsewhere", [][]sourcedPolicy{{full}, {full, denyS3}}, "ec2:RunInstances", decisionAllowed, true},
+		{"allow list at root", [][]sourcedPolicy{{ec2Only}, {full}}, "s3:GetObject", decisionImplicitDeny, false},
+		{"nothing on account", [][]sourcedPolicy{{full}, nil}, "ec2:RunInstances", decisionImplicitDeny, false},
+	}
+	for _, tt := range tests {
+		got := evaluation{identity: []sourcedPolicy{admin}, scps: tt.scps}.evaluate(tt.action, "*")
+		if got.Decision != tt.want || got.OrganizationsDecision == nil || got.OrganizationsDecision.AllowedByOrganizations != tt.orgs {
+			t.Errorf("%s: decision %s, orgs %+v; want %s, %v", tt.name, got.Decision, got.OrganizationsDecision, tt.want, tt.orgs)
+		}
+	}
+}
+
+func TestPrincipalMatch(t *testing.T) {
+	caller := "arn:aws:iam::000000000001:user/alice"
+	tests := []struct {
+		principal     string
+		matches, name bool
+	}{
+		{`"*"`, true, false},
+		{`{"AWS": "arn:aws:iam::000000000001:root"}`, true, false},
+		{`{"AWS": "000000000001"}`, true, false},
+		{`{"AWS": "1"}`, true, false},
+		{`{"AWS": ["arn:aws:iam::2:root", "arn:aws:iam::000000000001:user/alice"]}`, true, true},
+		{`{"AWS": "arn:aws:iam::1:user/alice"}`, true, true},
+		{`{"AWS": "arn:aws:iam::1:user/bob"}`, false, false},
+		{`{"AWS": "arn:aws:iam::2:root"}`, false, false},
+		{`{"AWS": "arn:aws:iam::000000000011:root"}`, false, false},
+		{`{"Service": "ec2.amazonaws.com"}`, false, false},
+	}
+	for _, tt := range tests {
+		matches, named := principalMatch(json.RawMessage(tt.principal), caller, "000000000001")
+		if matches != tt.matches || named != tt.name {
+			t.Errorf("principalMatch(%s) = %v, %v; want %v, %v", tt.principal, matches, named, tt.matches, tt.name)
+		}
+	}
+}
+
+func TestSimulateAssumeRoleHandler(t *testing.T) {
+	callerARN := "arn:aws:iam::1:user/alice"
+	roleARN := "arn:aws:iam::123456789012:role/OrganizationAccountAccessRole"
+	tests := []struct {
+		attached string
+		want     string
+	}{
+		{`[]`, decisionImplicitDeny},
+		{`["arn:aws:iam::aws:policy/AdministratorAccess"]`, decisionAllowed},
+	}
+	for _, tt := range tests {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("FROM iam_users").WithArgs(1, callerARN).
+			WillReturnRows(sqlmock.NewRows([]string{
+				"user_name", "arn", "permissions_boundary", "attached_policies", "inline_policies",
+			}).AddRow("alice", callerARN, nil, tt.attached, `{}`))
+		mock.ExpectQuery("SELECT trust_policy FROM iam_roles").WithArgs(1, roleARN).
+			WillReturnRows(sqlmock.NewRows([]string{"trust_policy"}))
+		mock.ExpectQuery("FROM organizations").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"org_id", "root_id", "created_at"}).AddRow("o-abc", "r-ab12", time.Now()))
+		mock.ExpectQuery("FROM organization_accounts").WithArgs(1, "123456789012").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		rr := httptest.NewRecorder()
+		SimulateAssumeRoleHandler(rr, httptest.NewRequest("POST", "/api/iam/simulate-assume-role",
+			strings.NewReader(`{"principal_arn": "`+callerARN+`", "role_arn": "`+roleARN+`"}`)))
+
+		var result AssumeRoleSimulation
+		json.NewDecoder(rr.Body).Decode(&result)
+		if rr.Code != http.StatusOK || result.Decision != tt.want || !result.CrossAccount || !result.TrustPolicy.AllowedByTrustPolicy {
+			t.Errorf("attached %s: status %d, result %+v", tt.attached, rr.Code, result)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestCreateOrgAccountHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM organizations").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"org_id", "root_id", "created_at"}).AddRow("o-abc", "r-ab12", time.Now()))
+	mock.ExpectQuery("INSERT INTO organization_accounts").
+		WithArgs(sqlmock.AnyArg(), 1, "dev", "dev@example.com", fullAWSAccessID).
+		WillReturnRows(sqlmock.NewRows([]string{"joined_at"}).AddRow(time.Now()))
+
+	rr := httptest.NewRecorder()
+	CreateOrgAccountHandler(rr, httptest.NewRequest("POST", "/api/organizations/accounts",
+		strings.NewReader(`{"account_name": "dev", "email": "dev@example.com"}`)))
+	var account OrgAccount
+	json.NewDecoder(rr.Body).Decode(&account)
+	if rr.Code != http.StatusCreated || len(account.ID) != 12 || account.RoleARN != orgAccessRoleARN(account.ID) {
+		t.Errorf("status = %d, account = %+v", rr.Code, account)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func lockedPolicyRow(latest int, defaultVersion string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "latest_version", "default_version_id"}).AddRow(4, latest, defaultVersion)
+}
+
+func TestCreatePolicyVersionHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	document := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "*"}]}`
+	create := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/iam/policies/s3/versions",
+			strings.NewReader(`{"policy_document": `+strconv.Quote(document)+`, "set_as_default": true}`))
+		req.SetPathValue("name", "s3")
+		rr := httptest.NewRecorder()
+		CreatePolicyVersionHandler(rr, req)
+		return rr
+	}
+
+	// Version IDs carry on from the latest, even after deletions.
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM iam_policies").WithArgs(1, "s3").WillReturnRows(lockedPolicyRow(3, "v1"))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("INSERT INTO iam_policy_versions").WithArgs(4, "v4", document).
+		WillReturnRows(sqlmock.NewRows([]string{"created_date"}).AddRow(time.Now()))
+	mock.ExpectExec("UPDATE iam_policies SET latest_version").WithArgs(4, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE iam_policies SET default_version_id").WithArgs(4, "v4", document).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	rr := create()
+	var v PolicyVersion
+	json.NewDecoder(rr.Body).Decode(&v)
+	if rr.Code != http.StatusCreated || v.VersionID != "v4" || !v.IsDefaultVersion {
+		t.Errorf("status %d, version %+v", rr.Code, v)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM iam_policies").WithArgs(1, "s3").WillReturnRows(lockedPolicyRow(7, "v7"))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(maxPolicyVersions))
+	mock.ExpectRollback()
+	if rr := create(); rr.Code != http.StatusConflict {
+		t.Errorf("sixth version: status %d", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeletePolicyVersionHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	del := func(version string) int {
+		req := httptest.NewRequest("DELETE", "/api/iam/policies/s3/versions/"+version, nil)
+		req.SetPathValue("name", "s3")
+		req.SetPathValue("version_id", version)
+		rr := httptest.NewRecorder()
+		DeletePolicyVersionHandler(rr, req)
+		return rr.Code
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM iam_policies").WithArgs(1, "s3").WillReturnRows(lockedPolicyRow(3, "v2"))
+	mock.ExpectRollback()
+	if code := del("v2"); code != http.StatusConflict {
+		t.Errorf("default version: status %d", code)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM iam_policies").WithArgs(1, "s3").WillReturnRows(lockedPolicyRow(3, "v2"))
+	mock.ExpectExec("DELETE FROM iam_policy_versions").WithArgs(4, "v1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if code := del("v1"); code != http.StatusNoContent {
+		t.Errorf("old version: status %d", code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetDefaultPolicyVersionHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	document := `{"Statement": [{"Effect": "Deny", "Action": "*", "Resource": "*"}]}`
+	setDefault := func(version string) int {
+		req := httptest.NewRequest("PUT", "/api/iam/policies/s3/default-version", strings.NewReader(`{"version_id": "`+version+`"}`))
+		req.SetPathValue("name", "s3")
+		rr := httptest.NewRecorder()
+		SetDefaultPolicyVersionHandler(rr, req)
+		return rr.Code
+	}
+
+	// The default's document is copied to the policy, which the simulator reads.
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM iam_policies").WithArgs(1, "s3").WillReturnRows(lockedPolicyRow(3, "v3"))
+	mock.ExpectQuery("FROM iam_policy_versions").WithArgs(4, "v1").
+		WillReturnRows(sqlmock.NewRows([]string{"document", "created_date"}).AddRow(document, time.Now()))
+	mock.ExpectExec("UPDATE iam_policies SET default_version_id").WithArgs(4, "v1", document).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if code := setDefault("v1"); code != http.StatusOK {
+		t.Errorf("status %d", code)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM iam_policies").WithArgs(1, "s3").WillReturnRows(lockedPolicyRow(3, "v3"))
+	mock.ExpectQuery("FROM iam_policy_versions").WithArgs(4, "v9").WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectRollback()
+	if code := setDefault("v9"); code != http.StatusNotFound {
+		t.Errorf("unknown version: status %d", code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	groupARN := "arn:aws:iam::" + accounts.SimAccountNumber(1) + ":group/"
+	mock.ExpectQuery("SELECT COUNT").WithArgs(1, "%dev%", groupARN).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("ORDER BY e.name ASC").WithArgs(1, "%dev%", groupARN, 20, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "name", "arn", "tags", "matched_on"}).
+			AddRow("group", "developers", groupARN+"developers", []byte(`{}`), "name").
+			AddRow("role", "ci", "arn:aws:iam::1:role/ci", []byte(`{"env": "dev"}`), "tag"))
+
+	rr := httptest.NewRecorder()
+	SearchHandler(rr, httptest.NewRequest("GET", "/api/iam/search?q=dev", nil))
+	var page struct {
+		Items []SearchResult `json:"items"`
+		Total int            `json:"total"`
+	}
+	json.NewDecoder(rr.Body).Decode(&page)
+	if rr.Code != http.StatusOK || page.Total != 2 || len(page.Items) != 2 {
+		t.Fatalf("status %d, page %+v", rr.Code, page)
+	}
+	if role := page.Items[1]; role.Type != "role" || role.MatchedOn != "tag" || role.Tags["env"] != "dev" {
+		t.Errorf("role result = %+v", role)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	for _, query := range []string{"", "?q=dev&type=bucket"} {
+		rr := httptest.NewRecorder()
+		SearchHandler(rr, httptest.NewRequest("GET", "/api/iam/search"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("search %q: status %d", query, rr.Code)
+		}
+	}
+}
+
+func TestGrantedServices(t *testing.T) {
+	policies := []sourcedPolicy{
+		mustPolicy(t, "a", sourceManaged, `{"Statement": [
+			{"Effect": "Allow", "Action": ["s3:GetObject", "EC2:Describe*", "cloud*:*"], "Resource": "*"},
+			{"Effect": "Deny", "Action": "lambda:*", "Resource": "*"}]}`),
+		mustPolicy(t, "b", sourceInline, `{"Statement": {"Effect": "Allow", "Action": "dynamodb:Query", "Resource": "*"}}`),
+	}
+	got := strings.Join(grantedServices(policies), ",")
+	if got != "cloudwatch,dynamodb,ec2,s3" {
+		t.Errorf("grantedServices = %s", got)
+	}
+	admin := mustPolicy(t, "admin", sourceManaged, awsManagedPolicies["arn:aws:iam::aws:policy/AdministratorAccess"])
+	if got := grantedServices([]sourcedPolicy{admin}); len(got) != len(simulatedServices) {
+		t.Errorf("admin services = %v", got)
+	}
+}
+
+func TestActionRegistry(t *testing.T) {
+	for i, s := range actionRegistry {
+		if i > 0 && actionRegistry[i-1].Prefix >= s.Prefix {
+			t.Errorf("service %s is out of order", s.Prefix)
+		}
+		for j, a := range s.Actions {
+			if j > 0 && s.Actions[j-1].Name >= a.Name {
+				t.Errorf("action %s:%s is out of order", s.Prefix, a.Name)
+			}
+			if a.AccessLevel == "" || a.Description == "" {
+				t.Errorf("action %s:%s is undocumented", s.Prefix, a.Name)
+			}
+		}
+	}
+	if a, ok := LookupAction("S3:getobject"); !ok || a.Name != "GetObject" {
+		t.Errorf("LookupAction(S3:getobject) = %+v, %v", a, ok)
+	}
+	if _, ok := LookupAction("s3:Teleport"); ok {
+		t.Error("LookupAction found an unregistered action")
+	}
+	services := Services()
+	services[0].Actions[0].Name = "changed"
+	if actionRegistry[0].Actions[0].Name == "changed" {
+		t.Error("Services() shares the registry's actions")
+	}
+}
+
+func TestAccessAdvisorHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	userARN := "arn:aws:iam::1:user/alice"
+	mock.ExpectQuery("FROM iam_users").WithArgs(1, userARN).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"user_name", "arn", "permissions_boundary", "attached_policies", "inline_policies",
+		}).AddRow("alice", userARN, nil,
+			`["arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess"]`,
+			`{"ec2": "{\"Statement\": {\"Effect\": \"Allow\", \"Action\": \"ec2:Describe*\", \"Resource\": \"*\"}}"}`))
+	lastUsed := time.Now().Add(-time.Hour)
+	mock.ExpectQuery("FROM iam_access_events").WithArgs(1, userARN, decisionAllowed, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"service", "occurred_at", "action", "count"}).
+			AddRow("s3", lastUsed, "s3:GetObject", 3).
+			AddRow("iam", lastUsed, "iam:ListUsers", 1))
+
+	rr := httptest.NewRecorder()
+	AccessAdvisorHandler(rr, httptest.NewRequest("GET", "/api/iam/access-advisor?arn="+userARN+"&days=90", nil))
+	var report AccessAdvisorReport
+	json.NewDecoder(rr.Body).Decode(&report)
+	if rr.Code != http.StatusOK || len(report.Services) != 3 {
+		t.Fatalf("status %d, report %+v", rr.Code, report)
+	}
+	// Calls to services no policy grants any more are left out.
+	if s3 := report.Services[1]; s3.Service != "s3" || s3.CallCount != 3 || s3.LastAuthenticated == nil {
+		t.Errorf("s3 = %+v", s3)
+	}
+	if strings.Join(report.UnusedServices, ",") != "ec2,s3-object-lambda" || report.Services[0].LastAuthenticated != nil {
+		t.Errorf("unused = %v, ec2 = %+v", report.UnusedServices, report.Services[0])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCallHandlerRecordsCall(t *testing.T) {
+	mock := setupMockDB(t)
+	userARN := "arn:aws:iam::1:user/alice"
+	mock.ExpectQuery("FROM iam_users").WithArgs(1, userARN).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"user_name", "arn", "permissions_boundary", "attached_policies", "inline_policies",
+		}).AddRow("alice", userARN, nil, `["arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess"]`, `{}`))
+	mock.ExpectExec("INSERT INTO iam_access_events").
+		WithArgs(1, userARN, "s3", "s3:PutObject", "*", decisionImplicitDeny).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr := httptest.NewRecorder()
+	CallHandler(rr, httptest.NewRequest("POST", "/api/iam/calls",
+		strings.NewReader(`{"principal_arn": "`+userARN+`", "action": "s3:PutObject"}`)))
+	var result EvaluationResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if rr.Code != http.StatusOK || result.Decision != decisionImplicitDeny {
+		t.Errorf("status %d, result %+v", rr.Code, result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEvaluateWithResourcePolicy(t *testing.T) {
+	caller := "arn:aws:iam::000000000001:user/alice"
+	admin := mustPolicy(t, "admin", sourceManaged, awsManagedPolicies["arn:aws:iam::aws:policy/AdministratorAccess"])
+	bucketPolicy := func(owner, principal, effect string) *resourcePolicy {
+		doc, err := parseResourcePolicy("bucket policy", `{"Statement": [{"Effect": "`+effect+`",
+			"Principal": {"AWS": "`+principal+`"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::notes/*"}]}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &resourcePolicy{sourcedPolicy: sourcedPolicy{id: "BucketPolicy", sourceType: sourceBucketPolicy, doc: doc},
+			kind: "bucket policy", owner: owner}
+	}
+	trust, _ := parseTrustPolicy(`{"Statement": {"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::000000000001:user/bob"}, "Action": "sts:AssumeRole"}}`)
+	roleARN := "arn:aws:iam::000000000001:role/deploy"
+
+	tests := []struct {
+		name     string
+		identity []sourcedPolicy
+		rp       *resourcePolicy
+		action   string
+		resource string
+		want     string
+		step     string
+	}{
+		{"names the caller", nil, bucketPolicy("1", caller, "Allow"), "s3:GetObject", "arn:aws:s3:::notes/a", decisionAllowed, "resource_policy"},
+		{"trusts the account only", nil, bucketPolicy("1", "arn:aws:iam::1:root", "Allow"), "s3:GetObject", "arn:aws:s3:::notes/a", decisionImplicitDeny, "identity"},
+		{"account and identity", []sourcedPolicy{admin}, bucketPolicy("1", "arn:aws:iam::1:root", "Allow"), "s3:GetObject", "arn:aws:s3:::notes/a", decisionAllowed, "decision"},
+		{"silent, identity allows", []sourcedPolicy{admin}, bucketPolicy("1", caller, "Allow"), "s3:PutObject", "arn:aws:s3:::notes/a", decisionAllowed, "decision"},
+		{"cross account, named only", nil, bucketPolicy("2", caller, "Allow"), "s3:GetObject", "arn:aws:s3:::notes/a", decisionImplicitDeny, "identity"},
+		{"cross account, both", []sourcedPolicy{admin}, bucketPolicy("2", caller, "Allow"), "s3:GetObject", "arn:aws:s3:::notes/a", decisionAllowed, "decision"},
+		{"cross account, silent", []sourcedPolicy{admin}, bucketPolicy("2", caller, "Allow"), "s3:PutObject", "arn:aws:s3:::notes/a", decisionImplicitDeny, "resource_policy"},
+		{"bucket denies", []sourcedPolicy{admin}, bucketPolicy("1", "*", "Deny"), "s3:GetObject", "arn:aws:s3:::notes/a", decisionExplicitDeny, "explicit_deny"},
+		{"untrusted caller", []sourcedPolicy{admin}, trustPolicy(trust, "000000000001"), "sts:AssumeRole", roleARN, decisionImplicitDeny, "resource_policy"},
+		{"trust policy ignored", []sourcedPolicy{admin}, trustPolicy(trust, "000000000001"), "iam:GetRole", roleARN, decisionAllowed, "decision"},
+	}
+	for _, tt := range tests {
+		e := evaluation{identity: tt.identity, callerARN: caller, callerAccount: "000000000001"}
+		got := e.evaluateWith(tt.action, tt.resource, tt.rp)
+		if got.Decision != tt.want || len(got.Trace) == 0 || got.Trace[len(got.Trace)-1].Step != tt.step {
+			t.Errorf("%s: decision %s (%s), trace %+v; want %s at %s", tt.name, got.Decision, got.Reason, got.Trace, tt.want, tt.step)
+		}
+	}
+}
+
+func TestSimulatePolicyHandlerLoadsBucketPolicy(t *testing.T) {
+	mock := setupMockDB(t)
+	userARN := "arn:aws:iam::000000000001:user/alice"
+	mock.ExpectQuery("FROM iam_users").WithArgs(1, userARN).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"user_name", "arn", "permissions_boundary", "attached_policies", "inline_policies",
+		}).AddRow("alice", userARN, nil, `[]`, `{}`))
+	mock.ExpectQuery("FROM cloudsim_resources").WithArgs(1, "notes").
+		WillReturnRows(sqlmock.NewRows([]string{"policy"}).AddRow(`{"Statement": [{"Effect": "Allow",
+			"Principal": {"AWS": "` + userARN + `"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::notes/*"}]}`))
+
+	rr := httptest.NewRecorder()
+	SimulatePolicyHandler(rr, httptest.NewRequest("POST", "/api/iam/simulate", strings.NewReader(`{
+		"policy_source_arn": "`+userARN+`", "action_names": ["s3:GetObject"], "resource_arns": ["arn:aws:s3:::notes/todo.txt"]}`)))
+	var result SimulationResult
+	json.NewDecoder(rr.Body).Decode(&result)
+	if rr.Code != http.StatusOK || len(result.EvaluationResults) != 1 {
+		t.Fatalf("status %d, result %+v", rr.Code, result)
+	}
+	got := result.EvaluationResults[0]
+	if got.Decision != decisionAllowed || got.ResourcePolicyDecision == nil || !got.ResourcePolicyDecision.NamesPrincipal {
+		t.Errorf("evaluation = %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,382 @@
+package iam
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/passhash"
+)
+
+// LoginProfile is an IAM user's simulated console password. The password
+// itself is only stored as a hash and never returned.
+type LoginProfile struct {
+	UserName              string     `json:"user_name"`
+	CreateDate            time.Time  `json:"create_date"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	PasswordExpiresAt     *time.Time `json:"password_expires_at,omitempty"`
+}
+
+type LoginProfileRequest struct {
+	Password              string `json:"password"`
+	PasswordResetRequired bool   `json:"password_reset_required"`
+}
+
+// passwordExpiry returns when a password set at changed expires under
+// policy, or nil if it never does.
+func passwordExpiry(policy PasswordPolicy, changed time.Time) *time.Time {
+	if policy.MaxPasswordAge <= 0 {
+		return nil
+	}
+	expires := changed.AddDate(0, 0, policy.MaxPasswordAge)
+	return &expires
+}
+
+// checkPassword validates password against the account's policy and
+// writes the error response if it fails.
+func checkPassword(w http.ResponseWriter, accountID int, password string) (PasswordPolicy, bool) {
+	policy, err := loadPasswordPolicy(accountID)
+	if err != nil {
+		log.Printf("Failed to load password policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load password policy"))
+		return policy, false
+	}
+	if violations := policy.Check(password); len(violations) > 0 {
+		apierror.Write(w, apierror.Validation("Password does not meet the account password policy: "+
+			strings.Join(violations, "; ")).WithDetails(map[string]interface{}{"violations": violations}))
+		return policy, false
+	}
+	return policy, true
+}
+
+// lookupUserID returns the id of the account's IAM user called name.
+func lookupUserID(accountID int, name string) (int, error) {
+	var id int
+	err := db.DB.QueryRow("SELECT id FROM iam_users WHERE account_id = $1 AND user_name = $2",
+		accountID, name).Scan(&id)
+	return id, err
+}
+
+// CreateLoginProfileHandler gives the IAM user named by {name} a console
+// password. The password must satisfy the account's password policy.
+func CreateLoginProfileHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req LoginProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	policy, ok := checkPassword(w, accountID, req.Password)
+	if !ok {
+		return
+	}
+
+	name := r.PathValue("name")
+	userID, err := lookupUserID(accountID, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("User not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+
+	hash, err := passhash.Hash(req.Password)
+	if err != nil {
+		log.Printf("Failed to hash password: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create login profile"))
+		return
+	}
+
+	profile := LoginProfile{UserName: name, PasswordResetRequired: req.PasswordResetRequired}
+	err = db.DB.QueryRow(`
+		INSERT INTO iam_login_profiles (user_id, password_hash, password_reset_required)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO NOTHING
+		RETURNING created_date`,
+		userID, hash, req.PasswordResetRequired,
+	).Scan(&profile.CreateDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("User already has a login profile"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create login profile: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create login profile"))
+		return
+	}
+	profile.PasswordExpiresAt = passwordExpiry(policy, profile.CreateDate)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(profile)
+}
+
+// storedProfile is a login profile row joined with its user.
+type storedProfile struct {
+	userID    int
+	arn       string
+	status    string
+	hash      string
+	profile   LoginProfile
+	changedAt time.Time
+}
+
+func loadLoginProfile(accountID int, name string) (storedProfile, error) {
+	sp := storedProfile{profile: LoginProfile{UserName: name}}
+	err := db.DB.QueryRow(`
+		SELECT u.id, u.arn, u.status, p.password_hash, p.password_reset_required,
+			   p.created_date, p.password_changed_at
+		FROM iam_users u
+		JOIN iam_login_profiles p ON p.user_id = u.id
+		WHERE u.account_id = $1 AND u.user_name = $2`, accountID, name,
+	).Scan(&sp.userID, &sp.arn, &sp.status, &sp.hash, &sp.profile.PasswordResetRequired,
+		&sp.profile.CreateDate, &sp.changedAt)
+	return sp, err
+}
+
+// loginProfileFor loads the profile named by {name} and writes the error
+// response if that fails.
+func loginProfileFor(w http.ResponseWriter, accountID int, name string) (storedProfile, bool) {
+	sp, err := loadLoginProfile(accountID, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Login profile not found"))
+		return sp, false
+	}
+	if err != nil {
+		log.Printf("Failed to load login profile: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load login profile"))
+		return sp, false
+	}
+	return sp, true
+}
+
+// GetLoginProfileHandler returns the login profile of the user named by
+// {name}.
+func GetLoginProfileHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	sp, ok := loginProfileFor(w, accountID, r.PathValue("name"))
+	if !ok {
+		return
+	}
+	policy, err := loadPasswordPolicy(accountID)
+	if err != nil {
+		log.Printf("Failed to load password policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load password policy"))
+		return
+	}
+	sp.profile.PasswordExpiresAt = passwordExpiry(policy, sp.changedAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sp.profile)
+}
+
+// UpdateLoginProfileHandler changes the console password of the user named
+// by {name} and sets whether they must reset it at next sign-in. An empty
+// password only changes the reset flag.
+func UpdateLoginProfileHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req LoginProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	sp, ok := loginProfileFor(w, accountID, r.PathValue("name"))
+	if !ok {
+		return
+	}
+
+	var policy PasswordPolicy
+	if req.Password == "" {
+		var err error
+		if policy, err = loadPasswordPolicy(accountID); err != nil {
+			log.Printf("Failed to load password policy: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load password policy"))
+			return
+		}
+		_, err = db.DB.Exec("UPDATE iam_login_profiles SET password_reset_required = $2 WHERE user_id = $1",
+			sp.userID, req.PasswordResetRequired)
+		if err != nil {
+			log.Printf("Failed to update login profile: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to update login profile"))
+			return
+		}
+	} else {
+		if policy, ok = checkPassword(w, accountID, req.Password); !ok {
+			return
+		}
+		changed, err := setPassword(sp.userID, req.Password, req.PasswordResetRequired)
+		if err != nil {
+			log.Printf("Failed to update login profile: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to update login profile"))
+			return
+		}
+		sp.changedAt = changed
+	}
+
+	sp.profile.PasswordResetRequired = req.PasswordResetRequired
+	sp.profile.PasswordExpiresAt = passwordExpiry(policy, sp.changedAt)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sp.profile)
+}
+
+// setPassword stores a new password hash for userID and returns when it
+// was changed.
+func setPassword(userID int, password string, resetRequired bool) (time.Time, error) {
+	hash, err := passhash.Hash(password)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var changed time.Time
+	err = db.DB.QueryRow(`
+		UPDATE iam_login_profiles
+		SET password_hash = $2, password_reset_required = $3, password_changed_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1
+		RETURNING password_changed_at`,
+		userID, hash, resetRequired,
+	).Scan(&changed)
+	return changed, err
+}
+
+// DeleteLoginProfileHandler removes the console password of the user named
+// by {name}; the user can no longer sign in to the console.
+func DeleteLoginProfileHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	result, err := db.DB.Exec(`
+		DELETE FROM iam_login_profiles p
+		USING iam_users u
+		WHERE p.user_id = u.id AND u.account_id = $1 AND u.user_name = $2`,
+		accountID, r.PathValue("name"))
+	if err != nil {
+		log.Printf("Failed to delete login profile: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete login profile"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Login profile not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ConsoleSignInRequest struct {
+	UserName string `json:"user_name"`
+	Password string `json:"password"`
+	// NewPassword is required when the password has expired or must be
+	// reset, and replaces it.
+	NewPassword string `json:"new_password,omitempty"`
+}
+
+type ConsoleSignInResult struct {
+	UserName         string    `json:"user_name"`
+	ARN              string    `json:"arn"`
+	PasswordLastUsed time.Time `json:"password_last_used"`
+	PasswordChanged  bool      `json:"password_changed,omitempty"`
+}
+
+// ConsoleSignInHandler simulates an IAM user signing in to the console with
+// their password and records the sign-in as the user's PasswordLastUsed.
+// Expired passwords and passwords marked for reset must be replaced in the
+// same request via new_password.
+func ConsoleSignInHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req ConsoleSignInRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+
+	sp, err := loadLoginProfile(accountID, req.UserName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to load login profile: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to sign in"))
+		return
+	}
+	if err != nil || sp.status != "Active" ||
+		passhash.Verify(req.Password, sp.hash) != nil {
+		apierror.Write(w, apierror.Unauthorized().WithDetails(map[string]string{"reason": "invalid user name or password"}))
+		return
+	}
+
+	policy, err := loadPasswordPolicy(accountID)
+	if err != nil {
+		log.Printf("Failed to load password policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to sign in"))
+		return
+	}
+	now := time.Now()
+	expires := passwordExpiry(policy, sp.changedAt)
+	expired := expires != nil && !now.Before(*expires)
+
+	result := ConsoleSignInResult{UserName: req.UserName, ARN: sp.arn}
+	if expired || sp.profile.PasswordResetRequired {
+		if req.NewPassword == "" {
+			reason := "password_reset_required"
+			if expired {
+				reason = "password_expired"
+			}
+			apierror.Write(w, apierror.Forbidden("A new password is required to sign in").
+				WithDetails(map[string]string{"reason": reason}))
+			return
+		}
+		if req.NewPassword == req.Password {
+			apierror.Write(w, apierror.Validation("The new password must differ from the current one"))
+			return
+		}
+		if _, ok := checkPassword(w, accountID, req.NewPassword); !ok {
+			return
+		}
+		if _, err := setPassword(sp.userID, req.NewPassword, false); err != nil {
+			log.Printf("Failed to change password at sign-in: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to sign in"))
+			return
+		}
+		result.PasswordChanged = true
+	}
+
+	err = db.DB.QueryRow(
+		"UPDATE iam_users SET password_last_used = CURRENT_TIMESTAMP WHERE id = $1 RETURNING password_last_used",
+		sp.userID,
+	).Scan(&result.PasswordLastUsed)
+	if err != nil {
+		log.Printf("Failed to record console sign-in: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to sign in"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,547 @@
+package iam
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+// Every new root and account gets the AWS managed FullAWSAccess SCP, so
+// SCPs restrict nothing until it is detached or a Deny is attached.
+const (
+	fullAWSAccessID      = "p-FullAWSAccess"
+	fullAWSAccessContent = `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "*", "Resource": "*"}]}`
+)
+
+// orgAccessRole is created in every member account and trusts the
+// management account, as AWS Organizations does.
+const orgAccessRole = "OrganizationAccountAccessRole"
+
+// Organization is a simulated AWS Organization. The caller's account is its
+// management account; SCPs never restrict the management account.
+type Organization struct {
+	ID                  string    `json:"id"`
+	ARN                 string    `json:"arn"`
+	ManagementAccountID string    `json:"management_account_id"`
+	RootID              string    `json:"root_id"`
+	FeatureSet          string    `json:"feature_set"`
+	CreatedAt           time.Time `json:"created_at"`
+
+	accountID int
+}
+
+// OrgAccount is a member account. It has no IAM users of its own; the
+// management account reaches it by assuming its OrganizationAccountAccessRole.
+type OrgAccount struct {
+	ID       string    `json:"id"`
+	ARN      string    `json:"arn"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Status   string    `json:"status"`
+	RoleARN  string    `json:"role_arn"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// ServiceControlPolicy caps what principals in the accounts it is attached
+// to, directly or through the root, can do.
+type ServiceControlPolicy struct {
+	ID          string   `json:"id"`
+	ARN         string   `json:"arn"`
+	Name        string   `json:"name"`
+	Description *string  `json:"description"`
+	Content     string   `json:"content"`
+	AWSManaged  bool     `json:"aws_managed"`
+	Targets     []string `json:"targets"`
+}
+
+type CreateOrgAccountRequest struct {
+	AccountName string `json:"account_name"`
+	Email       string `json:"email"`
+}
+
+type CreateSCPRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Content     string `json:"content"`
+}
+
+type PolicyTargetRequest struct {
+	TargetID string `json:"target_id"`
+}
+
+var errNoOrganization = errors.New("no organization")
+
+func randomString(alphabet string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		k, _ := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		b[i] = alphabet[k.Int64()]
+	}
+	return string(b)
+}
+
+const lowerAlnum = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+func (o *Organization) accountARN(number string) string {
+	return fmt.Sprintf("arn:aws:organizations::%s:account/%s/%s", o.ManagementAccountID, o.ID, number)
+}
+
+func (o *Organization) policyARN(policyID string) string {
+	if policyID == fullAWSAccessID {
+		return "arn:aws:organizations::aws:policy/service_control_policy/" + fullAWSAccessID
+	}
+	return fmt.Sprintf("arn:aws:organizations::%s:policy/%s/service_control_policy/%s", o.ManagementAccountID, o.ID, policyID)
+}
+
+func orgAccessRoleARN(number string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", number, orgAccessRole)
+}
+
+// loadOrganization returns the organization managed by accountID.
+func loadOrganization(accountID int) (*Organization, error) {
+	o := &Organization{ManagementAccountID: accounts.SimAccountNumber(accountID), FeatureSet: "ALL", accountID: accountID}
+	err := db.DB.QueryRow("SELECT org_id, root_id, created_at FROM organizations WHERE management_account_id = $1",
+		accountID).Scan(&o.ID, &o.RootID, &o.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNoOrganization
+	}
+	if err != nil {
+		return nil, err
+	}
+	o.ARN = fmt.Sprintf("arn:aws:organizations::%s:organization/%s", o.ManagementAccountID, o.ID)
+	return o, nil
+}
+
+// organizationFor loads the caller's organization and writes the error
+// response if that fails.
+func organizationFor(w http.ResponseWriter, accountID int) (*Organization, bool) {
+	o, err := loadOrganization(accountID)
+	if errors.Is(err, errNoOrganization) {
+		apierror.Write(w, apierror.NotFound("Your account is not the management account of an organization"))
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load organization: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load organization"))
+		return nil, false
+	}
+	return o, true
+}
+
+// isMemberAccount reports whether number is a member account of o.
+func isMemberAccount(o *Organization, number string) (bool, error) {
+	var exists bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM organization_accounts WHERE management_account_id = $1 AND account_number = $2)`,
+		o.accountID, number).Scan(&exists)
+	return exists, err
+}
+
+// scpLevels returns the SCPs attached to the root and to the member account
+// number, one slice per level.
+func scpLevels(o *Organization, number string) ([][]sourcedPolicy, error) {
+	rows, err := db.DB.Query(`
+		SELECT t.target_id, t.policy_id, p.content
+		FROM organization_policy_targets t
+		LEFT JOIN organization_policies p ON p.policy_id = t.policy_id
+		WHERE t.management_account_id = $1 AND t.target_id IN ($2, $3)
+		ORDER BY t.policy_id`, o.accountID, o.RootID, number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levels := make([][]sourcedPolicy, 2)
+	for rows.Next() {
+		var target, policyID string
+		var content sql.NullString
+		if err := rows.Scan(&target, &policyID, &content); err != nil {
+			return nil, err
+		}
+		document := content.String
+		if policyID == fullAWSAccessID {
+			document = fullAWSAccessContent
+		}
+		doc, err := parsePolicy(document)
+		if err != nil {
+			return nil, fmt.Errorf("SCP %s: %w", policyID, err)
+		}
+		level := 1
+		if target == o.RootID {
+			level = 0
+		}
+		levels[level] = append(levels[level], sourcedPolicy{id: o.policyARN(policyID), sourceType: sourceSCP, doc: doc})
+	}
+	return levels, rows.Err()
+}
+
+// CreateOrganizationHandler makes the caller's account the management
+// account of a new organization.
+func CreateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	o := &Organization{
+		ID:                  "o-" + randomString(lowerAlnum, 10),
+		ManagementAccountID: accounts.SimAccountNumber(accountID),
+		RootID:              "r-" + randomString(lowerAlnum, 4),
+		FeatureSet:          "ALL",
+	}
+	err := db.DB.QueryRow(`
+		WITH org AS (
+			INSERT INTO organizations (management_account_id, org_id, root_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+			RETURNING management_account_id, root_id, created_at
+		), root_policy AS (
+			INSERT INTO organization_policy_targets (management_account_id, policy_id, target_id)
+			SELECT management_account_id, $4, root_id FROM org
+		)
+		SELECT created_at FROM org`,
+		accountID, o.ID, o.RootID, fullAWSAccessID,
+	).Scan(&o.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("Your account already manages an organization"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create organization: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create organization"))
+		return
+	}
+	o.ARN = fmt.Sprintf("arn:aws:organizations::%s:organization/%s", o.ManagementAccountID, o.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(o)
+}
+
+// GetOrganizationHandler returns the organization the caller manages.
+func GetOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	o, ok := organizationFor(w, accountID)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o)
+}
+
+// CreateOrgAccountHandler creates a member account with a 12-digit account
+// number, its OrganizationAccountAccessRole and FullAWSAccess attached.
+func CreateOrgAccountHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreateOrgAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	req.AccountName = strings.TrimSpace(req.AccountName)
+	if req.AccountName == "" || len(req.AccountName) > 50 {
+		apierror.Write(w, apierror.Validation("account_name must be 1 to 50 characters"))
+		return
+	}
+	if !strings.Contains(req.Email, "@") || len(req.Email) > 64 {
+		apierror.Write(w, apierror.Validation("email must be an email address"))
+		return
+	}
+	o, ok := organizationFor(w, accountID)
+	if !ok {
+		return
+	}
+
+	number := randomString("123456789", 1) + randomString("0123456789", 11)
+	account := OrgAccount{
+		ID:      number,
+		ARN:     o.accountARN(number),
+		Name:    req.AccountName,
+		Email:   req.Email,
+		Status:  "ACTIVE",
+		RoleARN: orgAccessRoleARN(number),
+	}
+	err := db.DB.QueryRow(`
+		WITH account AS (
+			INSERT INTO organization_accounts (account_number, management_account_id, name, email)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+			RETURNING account_number, management_account_id, joined_at
+		), account_policy AS (
+			INSERT INTO organization_policy_targets (management_account_id, policy_id, target_id)
+			SELECT management_account_id, $5, account_number FROM account
+		)
+		SELECT joined_at FROM account`,
+		number, accountID, account.Name, account.Email, fullAWSAccessID,
+	).Scan(&account.JoinedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("An account with that name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create account: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create account"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+// ListOrgAccountsHandler lists the organization's member accounts.
+func ListOrgAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	o, ok := organizationFor(w, accountID)
+	if !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT account_number, name, email, status, joined_at
+		FROM organization_accounts
+		WHERE management_account_id = $1
+		ORDER BY joined_at`, accountID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	accounts := []OrgAccount{}
+	for rows.Next() {
+		var a OrgAccount
+		if err := rows.Scan(&a.ID, &a.Name, &a.Email, &a.Status, &a.JoinedAt); err != nil {
+			log.Printf("Scan error: %v", err)
+			apierror.Write(w, apierror.Internal("Scan error"))
+			return
+		}
+		a.ARN = o.accountARN(a.ID)
+		a.RoleARN = orgAccessRoleARN(a.ID)
+		accounts = append(accounts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accounts)
+}
+
+// CreateSCPHandler creates a service control policy. Its content uses the
+// IAM policy grammar.
+func CreateSCPHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreateSCPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.Name == "" {
+		apierror.Write(w, apierror.Validation("name is required"))
+		return
+	}
+	if _, err := parsePolicy(req.Content); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	o, ok := organizationFor(w, accountID)
+	if !ok {
+		return
+	}
+
+	policy := ServiceControlPolicy{
+		ID:      "p-" + randomString(lowerAlnum, 8),
+		Name:    req.Name,
+		Content: req.Content,
+		Targets: []string{},
+	}
+	policy.ARN = o.policyARN(policy.ID)
+	if req.Description != "" {
+		policy.Description = &req.Description
+	}
+	err := db.DB.QueryRow(`
+		INSERT INTO organization_policies (policy_id, management_account_id, name, description, content)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING
+		RETURNING policy_id`,
+		policy.ID, accountID, policy.Name, policy.Description, policy.Content,
+	).Scan(&policy.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("A policy with that name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create SCP: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create policy"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(policy)
+}
+
+// ListSCPsHandler lists FullAWSAccess and the organization's own SCPs with
+// the roots and accounts each is attached to.
+func ListSCPsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	o, ok := organizationFor(w, accountID)
+	if !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT p.policy_id, p.name, p.description, p.content::text,
+			   COALESCE(array_to_json(array_agg(t.target_id ORDER BY t.target_id) FILTER (WHERE t.target_id IS NOT NULL)), '[]')
+		FROM (
+			SELECT policy_id, name, description, content FROM organization_policies WHERE management_account_id = $1
+			UNION ALL
+			SELECT $2::varchar, 'FullAWSAccess', 'Allows access to every operation', $3::jsonb
+		) p
+		LEFT JOIN organization_policy_targets t ON t.policy_id = p.policy_id AND t.management_account_id = $1
+		GROUP BY p.policy_id, p.name, p.description, p.content::text
+		ORDER BY p.name`, accountID, fullAWSAccessID, fullAWSAccessContent)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	policies := []ServiceControlPolicy{}
+	for rows.Next() {
+		var p ServiceControlPolicy
+		var targets string
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Content, &targets); err != nil {
+			log.Printf("Scan error: %v", err)
+			apierror.Write(w, apierror.Internal("Scan error"))
+			return
+		}
+		json.Unmarshal([]byte(targets), &p.Targets)
+		p.ARN = o.policyARN(p.ID)
+		p.AWSManaged = p.ID == fullAWSAccessID
+		policies = append(policies, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policies)
+}
+
+// policyTarget checks that policyID is an SCP of o and target is its root
+// or one of its member accounts, writing the error response if not.
+func policyTarget(w http.ResponseWriter, o *Organization, policyID, target string) bool {
+	if target == "" {
+		apierror.Write(w, apierror.Validation("target_id is required"))
+		return false
+	}
+	var policyExists, targetExists bool
+	err := db.DB.QueryRow(`
+		SELECT $2 = $5 OR EXISTS (SELECT 1 FROM organization_policies WHERE management_account_id = $1 AND policy_id = $2),
+			   $3 = $4 OR EXISTS (SELECT 1 FROM organization_accounts WHERE management_account_id = $1 AND account_number = $3)`,
+		o.accountID, policyID, target, o.RootID, fullAWSAccessID,
+	).Scan(&policyExists, &targetExists)
+	if err != nil {
+		log.Printf("Failed to look up policy target: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to look up policy"))
+		return false
+	}
+	if !policyExists {
+		apierror.Write(w, apierror.NotFound("Policy not found"))
+		return false
+	}
+	if !targetExists {
+		apierror.Write(w, apierror.NotFound("Target must be the root or a member account"))
+		return false
+	}
+	return true
+}
+
+// AttachSCPHandler attaches the SCP {id} to the root or a member account.
+// Attaching it again is not an error.
+func AttachSCPHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req PolicyTargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	o, ok := organizationFor(w, accountID)
+	if !ok || !policyTarget(w, o, r.PathValue("id"), req.TargetID) {
+		return
+	}
+
+	_, err := db.DB.Exec(`
+		INSERT INTO organization_policy_targets (management_account_id, policy_id, target_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, accountID, r.PathValue("id"), req.TargetID)
+	if err != nil {
+		log.Printf("Failed to attach SCP: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to attach policy"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DetachSCPHandler detaches the SCP {id} from the target given by the
+// target_id query parameter.
+func DetachSCPHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	result, err := db.DB.Exec(`
+		DELETE FROM organization_policy_targets
+		WHERE management_account_id = $1 AND policy_id = $2 AND target_id = $3`,
+		accountID, r.PathValue("id"), r.URL.Query().Get("target_id"))
+	if err != nil {
+		log.Printf("Failed to detach SCP: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to detach policy"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Policy is not attached to that target"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
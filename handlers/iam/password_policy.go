@@ -0,0 +1,193 @@
+package iam
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"unicode"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+// Password length bounds. Passwords are stored with bcrypt, which only
+// looks at the first 72 bytes, so longer ones are refused outright.
+const (
+	minPasswordLength = 6
+	maxPasswordLength = 72
+	maxPasswordAge    = 1095
+)
+
+// PasswordPolicy is an account's rules for IAM users' console passwords.
+// MaxPasswordAge is the rotation period in days; 0 means passwords never
+// expire.
+type PasswordPolicy struct {
+	MinimumPasswordLength      int  `json:"minimum_password_length"`
+	RequireSymbols             bool `json:"require_symbols"`
+	RequireNumbers             bool `json:"require_numbers"`
+	RequireUppercaseCharacters bool `json:"require_uppercase_characters"`
+	RequireLowercaseCharacters bool `json:"require_lowercase_characters"`
+	MaxPasswordAge             int  `json:"max_password_age"`
+	ExpirePasswords            bool `json:"expire_passwords"`
+	// IsDefault is set when the account has not configured a policy.
+	IsDefault bool `json:"is_default,omitempty"`
+}
+
+// DefaultPasswordPolicy applies to accounts that have not set one.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinimumPasswordLength: 8, IsDefault: true}
+}
+
+// Validate reports the first problem with the policy itself, or nil.
+func (p PasswordPolicy) Validate() error {
+	if p.MinimumPasswordLength < minPasswordLength || p.MinimumPasswordLength > maxPasswordLength {
+		return fmt.Errorf("minimum_password_length must be between %d and %d", minPasswordLength, maxPasswordLength)
+	}
+	if p.MaxPasswordAge < 0 || p.MaxPasswordAge > maxPasswordAge {
+		return fmt.Errorf("max_password_age must be between 0 and %d days", maxPasswordAge)
+	}
+	return nil
+}
+
+// Check returns every rule password breaks; none means it is acceptable.
+func (p PasswordPolicy) Check(password string) []string {
+	var violations []string
+	if len([]rune(password)) < p.MinimumPasswordLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinimumPasswordLength))
+	}
+	if len(password) > maxPasswordLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", maxPasswordLength))
+	}
+
+	var symbol, number, upper, lower bool
+	for _, r := range password {
+		switch {
+		case unicode.IsDigit(r):
+			number = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+	if p.RequireSymbols && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+	if p.RequireNumbers && !number {
+		violations = append(violations, "must contain a number")
+	}
+	if p.RequireUppercaseCharacters && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLowercaseCharacters && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	return violations
+}
+
+// loadPasswordPolicy returns the account's policy, or the default.
+func loadPasswordPolicy(accountID int) (PasswordPolicy, error) {
+	var p PasswordPolicy
+	err := db.DB.QueryRow(`
+		SELECT minimum_password_length, require_symbols, require_numbers,
+			   require_uppercase, require_lowercase, max_password_age
+		FROM iam_password_policies
+		WHERE account_id = $1`, accountID,
+	).Scan(&p.MinimumPasswordLength, &p.RequireSymbols, &p.RequireNumbers,
+		&p.RequireUppercaseCharacters, &p.RequireLowercaseCharacters, &p.MaxPasswordAge)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultPasswordPolicy(), nil
+	}
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+	p.ExpirePasswords = p.MaxPasswordAge > 0
+	return p, nil
+}
+
+// GetPasswordPolicyHandler returns the account's password policy.
+func GetPasswordPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	policy, err := loadPasswordPolicy(accountID)
+	if err != nil {
+		log.Printf("Failed to load password policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load password policy"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdatePasswordPolicyHandler replaces the account's password policy. It
+// applies to passwords set from now on; existing ones are not rechecked,
+// but a shorter MaxPasswordAge expires them sooner.
+func UpdatePasswordPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var policy PasswordPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := policy.Validate(); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	policy.ExpirePasswords = policy.MaxPasswordAge > 0
+	policy.IsDefault = false
+
+	_, err := db.DB.Exec(`
+		INSERT INTO iam_password_policies (
+			account_id, minimum_password_length, require_symbols, require_numbers,
+			require_uppercase, require_lowercase, max_password_age
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (account_id) DO UPDATE SET
+			minimum_password_length = EXCLUDED.minimum_password_length,
+			require_symbols = EXCLUDED.require_symbols,
+			require_numbers = EXCLUDED.require_numbers,
+			require_uppercase = EXCLUDED.require_uppercase,
+			require_lowercase = EXCLUDED.require_lowercase,
+			max_password_age = EXCLUDED.max_password_age,
+			updated_at = CURRENT_TIMESTAMP`,
+		accountID, policy.MinimumPasswordLength, policy.RequireSymbols, policy.RequireNumbers,
+		policy.RequireUppercaseCharacters, policy.RequireLowercaseCharacters, policy.MaxPasswordAge)
+	if err != nil {
+		log.Printf("Failed to save password policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save password policy"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// DeletePasswordPolicyHandler reverts the account to the default policy.
+func DeletePasswordPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	if _, err := db.DB.Exec("DELETE FROM iam_password_policies WHERE account_id = $1", accountID); err != nil {
+		log.Printf("Failed to delete password policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to delete password policy"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
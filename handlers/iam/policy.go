@@ -0,0 +1,370 @@
+package iam
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+// PolicyDocument is a parsed IAM policy. Statement, Action and Resource
+// may be a single value or a list in JSON, as in AWS.
+type PolicyDocument struct {
+	Version   string      `json:"Version,omitempty"`
+	Statement []Statement `json:"Statement"`
+}
+
+type Statement struct {
+	Sid         string          `json:"Sid,omitempty"`
+	Effect      string          `json:"Effect"`
+	Principal   json.RawMessage `json:"Principal,omitempty"`
+	Action      stringList      `json:"Action,omitempty"`
+	NotAction   stringList      `json:"NotAction,omitempty"`
+	Resource    stringList      `json:"Resource,omitempty"`
+	NotResource stringList      `json:"NotResource,omitempty"`
+	Condition   json.RawMessage `json:"Condition,omitempty"`
+}
+
+// stringList accepts a JSON string or array of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = stringList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("must be a string or a list of strings")
+	}
+	*l = many
+	return nil
+}
+
+func (d *PolicyDocument) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Version   string          `json:"Version"`
+		Statement json.RawMessage `json:"Statement"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	d.Version = raw.Version
+	var one Statement
+	if len(raw.Statement) > 0 && raw.Statement[0] == '{' {
+		if err := json.Unmarshal(raw.Statement, &one); err != nil {
+			return err
+		}
+		d.Statement = []Statement{one}
+		return nil
+	}
+	d.Statement = nil
+	if len(raw.Statement) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw.Statement, &d.Statement)
+}
+
+// parsePolicy parses and checks an identity-based policy document.
+func parsePolicy(document string) (*PolicyDocument, error) {
+	var doc PolicyDocument
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		return nil, fmt.Errorf("policy document is not valid JSON: %v", err)
+	}
+	if len(doc.Statement) == 0 {
+		return nil, errors.New("policy document has no Statement")
+	}
+	for i, s := range doc.Statement {
+		if s.Effect != "Allow" && s.Effect != "Deny" {
+			return nil, fmt.Errorf("statement %d: Effect must be Allow or Deny", i+1)
+		}
+		if (len(s.Action) == 0) == (len(s.NotAction) == 0) {
+			return nil, fmt.Errorf("statement %d: exactly one of Action and NotAction is required", i+1)
+		}
+		if len(s.Principal) > 0 {
+			return nil, fmt.Errorf("statement %d: identity policies cannot have a Principal", i+1)
+		}
+		if (len(s.Resource) == 0) == (len(s.NotResource) == 0) {
+			return nil, fmt.Errorf("statement %d: exactly one of Resource and NotResource is required", i+1)
+		}
+	}
+	return &doc, nil
+}
+
+// wildcardMatch reports whether s matches pattern, where * matches any run
+// of characters and ? any single character.
+func wildcardMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star >= 0:
+			p = star + 1
+			mark++
+			i = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func matchAny(patterns []string, s string, foldCase bool) bool {
+	if foldCase {
+		s = strings.ToLower(s)
+	}
+	for _, pattern := range patterns {
+		if foldCase {
+			pattern = strings.ToLower(pattern)
+		}
+		if wildcardMatch(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// applies reports whether the statement covers action on resource. Action
+// names are case-insensitive, resource ARNs are not. Statements with a
+// Condition never apply: the simulator has no request context to test it
+// against.
+func (s Statement) applies(action, resource string) bool {
+	if len(s.Condition) > 0 && string(s.Condition) != "null" {
+		return false
+	}
+	if len(s.Action) > 0 && !matchAny(s.Action, action, true) {
+		return false
+	}
+	if len(s.NotAction) > 0 && matchAny(s.NotAction, action, true) {
+		return false
+	}
+	if len(s.Resource) > 0 && !matchAny(s.Resource, resource, false) {
+		return false
+	}
+	if len(s.NotResource) > 0 && matchAny(s.NotResource, resource, false) {
+		return false
+	}
+	return true
+}
+
+// awsManagedPolicies are the AWS managed policies the simulator knows,
+// trimmed to what matters for evaluation.
+var awsManagedPolicies = map[string]string{
+	"arn:aws:iam::aws:policy/AdministratorAccess": `{"Version": "2012-10-17", "Statement": [
+		{"Effect": "Allow", "Action": "*", "Resource": "*"}]}`,
+	"arn:aws:iam::aws:policy/PowerUserAccess": `{"Version": "2012-10-17", "Statement": [
+		{"Effect": "Allow", "NotAction": ["iam:*", "organizations:*", "account:*"], "Resource": "*"},
+		{"Effect": "Allow", "Action": ["iam:CreateServiceLinkedRole", "iam:DeleteServiceLinkedRole", "iam:ListRoles",
+			"organizations:DescribeOrganization", "account:ListRegions"], "Resource": "*"}]}`,
+	"arn:aws:iam::aws:policy/ReadOnlyAccess": `{"Version": "2012-10-17", "Statement": [
+		{"Effect": "Allow", "Action": ["*:Get*", "*:List*", "*:Describe*"], "Resource": "*"}]}`,
+	"arn:aws:iam::aws:policy/IAMFullAccess": `{"Version": "2012-10-17", "Statement": [
+		{"Effect": "Allow", "Action": ["iam:*", "organizations:DescribeAccount", "organizations:DescribeOrganization",
+			"organizations:DescribeOrganizationalUnit", "organizations:DescribePolicy", "organizations:ListChildren",
+			"organizations:ListParents", "organizations:ListPoliciesForTarget", "organizations:ListRoots",
+			"organizations:ListPolicies", "organizations:ListTargetsForPolicy"], "Resource": "*"}]}`,
+	"arn:aws:iam::aws:policy/AmazonS3FullAccess": `{"Version": "2012-10-17", "Statement": [
+		{"Effect": "Allow", "Action": ["s3:*", "s3-object-lambda:*"], "Resource": "*"}]}`,
+	"arn:aws:iam::aws:policy/AmazonS3ReadOnlyAccess": `{"Version": "2012-10-17", "Statement": [
+		{"Effect": "Allow", "Action": ["s3:Get*", "s3:List*", "s3:Describe*", "s3-object-lambda:Get*",
+			"s3-object-lambda:List*"], "Resource": "*"}]}`,
+	"arn:aws:iam::aws:policy/AmazonEC2FullAccess": `{"Version": "2012-10-17", "Statement": [
+		{"Effect": "Allow", "Action": ["ec2:*", "elasticloadbalancing:*", "cloudwatch:*", "autoscaling:*"], "Resource": "*"}]}`,
+}
+
+var errNoSuchPolicy = errors.New("no such policy")
+
+// loadManagedPolicy returns the document of an AWS managed policy or of
+// one of the account's own policies.
+func loadManagedPolicy(accountID int, arn string) (*PolicyDocument, error) {
+	document, ok := awsManagedPolicies[arn]
+	if !ok {
+		err := db.DB.QueryRow("SELECT policy_document FROM iam_policies WHERE account_id = $1 AND arn = $2",
+			accountID, arn).Scan(&document)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errNoSuchPolicy
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parsePolicy(document)
+}
+
+// managedPolicyExists writes a 404 and returns false unless arn names a
+// managed policy the account can attach.
+func managedPolicyExists(w http.ResponseWriter, accountID int, arn string) bool {
+	if _, ok := awsManagedPolicies[arn]; ok {
+		return true
+	}
+	var exists bool
+	err := db.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM iam_policies WHERE account_id = $1 AND arn = $2)",
+		accountID, arn).Scan(&exists)
+	if err != nil {
+		log.Printf("Failed to look up policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to look up policy"))
+		return false
+	}
+	if !exists {
+		apierror.Write(w, apierror.NotFound("Policy not found"))
+		return false
+	}
+	return true
+}
+
+// IAMPolicy is a customer managed policy. PolicyDocument is the document
+// of its default version, the only one evaluated. The counts are computed
+// from the users and roles that use it.
+type IAMPolicy struct {
+	ID                            int       `json:"id"`
+	PolicyName                    string    `json:"policy_name"`
+	PolicyID                      string    `json:"policy_id"`
+	ARN                           string    `json:"arn"`
+	Path                          string    `json:"path"`
+	Description                   *string   `json:"description"`
+	PolicyDocument                string    `json:"policy_document"`
+	DefaultVersionID              string    `json:"default_version_id"`
+	AttachmentCount               int       `json:"attachment_count"`
+	PermissionsBoundaryUsageCount int       `json:"permissions_boundary_usage_count"`
+	CreatedDate                   time.Time `json:"created_date"`
+}
+
+type CreatePolicyRequest struct {
+	PolicyName     string `json:"policy_name"`
+	Path           string `json:"path"`
+	Description    string `json:"description"`
+	PolicyDocument string `json:"policy_document"`
+}
+
+func generatePolicyID() string {
+	bytes := make([]byte, 10)
+	rand.Read(bytes)
+	return fmt.Sprintf("ANPA%X", bytes)
+}
+
+// CreatePolicyHandler creates a customer managed policy.
+func CreatePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreatePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.PolicyName == "" {
+		apierror.Write(w, apierror.Validation("PolicyName is required"))
+		return
+	}
+	if _, err := parsePolicy(req.PolicyDocument); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	if req.Path == "" {
+		req.Path = "/"
+	}
+
+	policy := IAMPolicy{
+		PolicyName:       req.PolicyName,
+		PolicyID:         generatePolicyID(),
+		ARN:              fmt.Sprintf("arn:aws:iam::%s:policy%s%s", accounts.SimAccountNumber(accountID), req.Path, req.PolicyName),
+		Path:             req.Path,
+		PolicyDocument:   req.PolicyDocument,
+		DefaultVersionID: "v1",
+	}
+	if req.Description != "" {
+		policy.Description = &req.Description
+	}
+
+	// The document is also the policy's first version, v1.
+	err := db.DB.QueryRow(`
+		WITH p AS (
+			INSERT INTO iam_policies (account_id, policy_name, policy_id, arn, path, description, policy_document)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (account_id, policy_name) DO NOTHING
+			RETURNING id, created_date
+		)
+		INSERT INTO iam_policy_versions (policy_id, version_id, document, created_date)
+		SELECT id, 'v1', $7, created_date FROM p
+		RETURNING policy_id, created_date`,
+		accountID, policy.PolicyName, policy.PolicyID, policy.ARN, policy.Path, policy.Description, policy.PolicyDocument,
+	).Scan(&policy.ID, &policy.CreatedDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("A policy with that name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create policy: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create policy"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(policy)
+}
+
+// ListPoliciesHandler lists the account's customer managed policies.
+func ListPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT p.id, p.policy_name, p.policy_id, p.arn, p.path, p.description,
+			   p.policy_document, p.default_version_id, p.created_date,
+			   (SELECT COUNT(*) FROM iam_users u WHERE u.account_id = p.account_id AND u.attached_policies ? p.arn) +
+			   (SELECT COUNT(*) FROM iam_roles r WHERE r.account_id = p.account_id AND r.attached_policies ? p.arn),
+			   (SELECT COUNT(*) FROM iam_users u WHERE u.account_id = p.account_id AND u.permissions_boundary = p.arn) +
+			   (SELECT COUNT(*) FROM iam_roles r WHERE r.account_id = p.account_id AND r.permissions_boundary = p.arn)
+		FROM iam_policies p
+		WHERE p.account_id = $1
+		ORDER BY p.policy_name`, accountID)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	policies := []IAMPolicy{}
+	for rows.Next() {
+		var p IAMPolicy
+		err := rows.Scan(&p.ID, &p.PolicyName, &p.PolicyID, &p.ARN, &p.Path, &p.Description,
+			&p.PolicyDocument, &p.DefaultVersionID, &p.CreatedDate,
+			&p.AttachmentCount, &p.PermissionsBoundaryUsageCount)
+		if err != nil {
+			log.Printf("Scan error: %v", err)
+			apierror.Write(w, apierror.Internal("Scan error"))
+			return
+		}
+		policies = append(policies, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policies)
+}
@@ -0,0 +1,338 @@
+package iam
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+)
+
+// maxPolicyVersions is how many versions a managed policy keeps, as in
+// AWS. One more has to wait until an old one is deleted.
+const maxPolicyVersions = 5
+
+var (
+	errVersionLimit   = errors.New("policy has the maximum number of versions")
+	errNoSuchVersion  = errors.New("no such policy version")
+	errDefaultVersion = errors.New("cannot delete the default version")
+)
+
+// PolicyVersion is one version of a customer managed policy. Only the
+// default version is evaluated by the simulator.
+type PolicyVersion struct {
+	VersionID        string    `json:"version_id"`
+	Document         string    `json:"document"`
+	IsDefaultVersion bool      `json:"is_default_version"`
+	CreateDate       time.Time `json:"create_date"`
+}
+
+type CreatePolicyVersionRequest struct {
+	PolicyDocument string `json:"policy_document"`
+	SetAsDefault   bool   `json:"set_as_default"`
+}
+
+type SetDefaultPolicyVersionRequest struct {
+	VersionID string `json:"version_id"`
+}
+
+// lockedPolicy is the row of a policy locked for a version change.
+type lockedPolicy struct {
+	id             int
+	latestVersion  int
+	defaultVersion string
+}
+
+// lockPolicy locks the account's policy called name until tx ends, so
+// concurrent version changes are applied one at a time.
+func lockPolicy(ctx context.Context, tx *sql.Tx, accountID int, name string) (*lockedPolicy, error) {
+	var p lockedPolicy
+	err := tx.QueryRowContext(ctx,
+		`SELECT id, latest_version, default_version_id FROM iam_policies
+		 WHERE account_id = $1 AND policy_name = $2 FOR UPDATE`,
+		accountID, name).Scan(&p.id, &p.latestVersion, &p.defaultVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNoSuchPolicy
+	}
+	return &p, err
+}
+
+// setDefaultVersion makes versionID the policy's default. The policy row
+// keeps a copy of the default document, which is what gets evaluated.
+func setDefaultVersion(ctx context.Context, tx *sql.Tx, policyID int, versionID, document string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE iam_policies SET default_version_id = $2, policy_document = $3, updated_date = CURRENT_TIMESTAMP
+		 WHERE id = $1`,
+		policyID, versionID, document)
+	return err
+}
+
+// createPolicyVersion adds a version to the account's policy called name.
+// Version IDs are never reused, even after a version is deleted.
+func createPolicyVersion(ctx context.Context, accountID int, name string, req CreatePolicyVersionRequest) (*PolicyVersion, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := lockPolicy(ctx, tx, accountID, name)
+	if err != nil {
+		return nil, err
+	}
+	var count int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM iam_policy_versions WHERE policy_id = $1", p.id).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxPolicyVersions {
+		return nil, errVersionLimit
+	}
+
+	v := PolicyVersion{
+		VersionID:        fmt.Sprintf("v%d", p.latestVersion+1),
+		Document:         req.PolicyDocument,
+		IsDefaultVersion: req.SetAsDefault,
+	}
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO iam_policy_versions (policy_id, version_id, document) VALUES ($1, $2, $3)
+		 RETURNING created_date`,
+		p.id, v.VersionID, v.Document).Scan(&v.CreateDate)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE iam_policies SET latest_version = $2 WHERE id = $1", p.id, p.latestVersion+1); err != nil {
+		return nil, err
+	}
+	if req.SetAsDefault {
+		if err := setDefaultVersion(ctx, tx, p.id, v.VersionID, v.Document); err != nil {
+			return nil, err
+		}
+	}
+	return &v, tx.Commit()
+}
+
+// writeVersionError writes the response for an error from a version change.
+func writeVersionError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, errNoSuchPolicy):
+		apierror.Write(w, apierror.NotFound("Policy not found"))
+	case errors.Is(err, errNoSuchVersion):
+		apierror.Write(w, apierror.NotFound("Policy version not found"))
+	case errors.Is(err, errVersionLimit):
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("A policy can have at most %d versions; delete one first", maxPolicyVersions)))
+	case errors.Is(err, errDefaultVersion):
+		apierror.Write(w, apierror.Conflict("The default version cannot be deleted; set another version as default first"))
+	default:
+		log.Printf("Failed to %s: %v", action, err)
+		apierror.Write(w, apierror.Internal("Failed to "+action))
+	}
+}
+
+// CreatePolicyVersionHandler adds a version to the policy named by the
+// {name} path parameter, optionally making it the default.
+func CreatePolicyVersionHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req CreatePolicyVersionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if _, err := parsePolicy(req.PolicyDocument); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	v, err := createPolicyVersion(r.Context(), accountID, r.PathValue("name"), req)
+	if err != nil {
+		writeVersionError(w, err, "create policy version")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(v)
+}
+
+const policyVersionsQuery = `
+	SELECT v.version_id, v.document, v.version_id = p.default_version_id, v.created_date
+	FROM iam_policy_versions v
+	JOIN iam_policies p ON p.id = v.policy_id
+	WHERE p.account_id = $1 AND p.policy_name = $2`
+
+// ListPolicyVersionsHandler lists the versions of the policy named by the
+// {name} path parameter, newest first.
+func ListPolicyVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	rows, err := db.DB.Query(policyVersionsQuery+`
+		ORDER BY substring(v.version_id from 2)::int DESC`,
+		accountID, r.PathValue("name"))
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+	defer rows.Close()
+
+	versions := []PolicyVersion{}
+	for rows.Next() {
+		var v PolicyVersion
+		if err := rows.Scan(&v.VersionID, &v.Document, &v.IsDefaultVersion, &v.CreateDate); err != nil {
+			log.Printf("Scan error: %v", err)
+			apierror.Write(w, apierror.Internal("Scan error"))
+			return
+		}
+		versions = append(versions, v)
+	}
+	// The default version can't be deleted, so every policy has one.
+	if len(versions) == 0 {
+		apierror.Write(w, apierror.NotFound("Policy not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// GetPolicyVersionHandler returns the version {version_id} of the policy
+// named by the {name} path parameter.
+func GetPolicyVersionHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var v PolicyVersion
+	err := db.DB.QueryRow(policyVersionsQuery+" AND v.version_id = $3",
+		accountID, r.PathValue("name"), r.PathValue("version_id"),
+	).Scan(&v.VersionID, &v.Document, &v.IsDefaultVersion, &v.CreateDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Policy version not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// deletePolicyVersion deletes a version other than the default.
+func deletePolicyVersion(ctx context.Context, accountID int, name, versionID string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, err := lockPolicy(ctx, tx, accountID, name)
+	if err != nil {
+		return err
+	}
+	if versionID == p.defaultVersion {
+		return errDefaultVersion
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM iam_policy_versions WHERE policy_id = $1 AND version_id = $2",
+		p.id, versionID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errNoSuchVersion
+	}
+	return tx.Commit()
+}
+
+// DeletePolicyVersionHandler deletes the version {version_id} of the
+// policy named by the {name} path parameter. The default version can't be
+// deleted.
+func DeletePolicyVersionHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	if err := deletePolicyVersion(r.Context(), accountID, r.PathValue("name"), r.PathValue("version_id")); err != nil {
+		writeVersionError(w, err, "delete policy version")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setDefaultPolicyVersion makes an existing version the policy's default.
+func setDefaultPolicyVersion(ctx context.Context, accountID int, name, versionID string) (*PolicyVersion, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := lockPolicy(ctx, tx, accountID, name)
+	if err != nil {
+		return nil, err
+	}
+	v := PolicyVersion{VersionID: versionID, IsDefaultVersion: true}
+	err = tx.QueryRowContext(ctx,
+		"SELECT document, created_date FROM iam_policy_versions WHERE policy_id = $1 AND version_id = $2",
+		p.id, versionID).Scan(&v.Document, &v.CreateDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNoSuchVersion
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := setDefaultVersion(ctx, tx, p.id, v.VersionID, v.Document); err != nil {
+		return nil, err
+	}
+	return &v, tx.Commit()
+}
+
+// SetDefaultPolicyVersionHandler makes a version of the policy named by
+// the {name} path parameter its default, the one the simulator evaluates.
+func SetDefaultPolicyVersionHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req SetDefaultPolicyVersionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.VersionID == "" {
+		apierror.Write(w, apierror.Validation("VersionId is required"))
+		return
+	}
+
+	v, err := setDefaultPolicyVersion(r.Context(), accountID, r.PathValue("name"), req.VersionID)
+	if err != nil {
+		writeVersionError(w, err, "set default policy version")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
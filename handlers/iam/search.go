@@ -0,0 +1,133 @@
+package iam
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/pagination"
+)
+
+// SearchResult is an IAM entity matching a search. MatchedOn says whether
+// the name, the ARN or a tag key or value matched, checked in that order.
+type SearchResult struct {
+	Type      string            `json:"type"`
+	Name      string            `json:"name"`
+	ARN       string            `json:"arn"`
+	Tags      map[string]string `json:"tags"`
+	MatchedOn string            `json:"matched_on"`
+}
+
+// searchTypes are the entity types a search can be narrowed to.
+var searchTypes = []string{"user", "role", "group", "policy"}
+
+var searchOptions = pagination.Options{DefaultLimit: 20, MaxLimit: 100, Sorts: []string{"name"}, DefaultSort: "name"}
+
+// searchEntities is every IAM entity of account $1. Groups have no table
+// of their own; they exist as the names in users' groups lists, and $3 is
+// the ARN prefix they are given.
+const searchEntities = `
+	WITH entities AS (
+		SELECT 'user' AS type, user_name AS name, arn, COALESCE(tags, '{}') AS tags FROM iam_users WHERE account_id = $1
+		UNION ALL
+		SELECT 'role', role_name, arn, COALESCE(tags, '{}') FROM iam_roles WHERE account_id = $1
+		UNION ALL
+		SELECT DISTINCT 'group', g, $3 || g, '{}'::jsonb
+		FROM iam_users, jsonb_array_elements_text(groups) g WHERE account_id = $1
+		UNION ALL
+		SELECT 'policy', policy_name, arn, COALESCE(tags, '{}') FROM iam_policies WHERE account_id = $1
+	)`
+
+// searchFilter matches q, as pattern $2, against names, ARNs, tag keys and
+// tag values, optionally of one type only.
+func searchFilter(accountID int, q, entityType string) (string, []interface{}) {
+	args := []interface{}{accountID, pagination.LikePattern(q),
+		fmt.Sprintf("arn:aws:iam::%s:group/", accounts.SimAccountNumber(accountID))}
+	where := `(e.name ILIKE $2 OR e.arn ILIKE $2 OR EXISTS (
+		SELECT 1 FROM jsonb_each_text(e.tags) t WHERE t.key ILIKE $2 OR t.value ILIKE $2))`
+	if entityType != "" {
+		args = append(args, entityType)
+		where += fmt.Sprintf(" AND e.type = $%d", len(args))
+	}
+	return where, args
+}
+
+// SearchHandler finds the account's users, roles, groups and policies
+// whose name, ARN or tags contain the q query parameter, ignoring case.
+// The optional type parameter narrows the search to one kind of entity.
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	query := r.URL.Query()
+	q := query.Get("q")
+	if q == "" {
+		apierror.Write(w, apierror.Validation("q is required"))
+		return
+	}
+	entityType := query.Get("type")
+	if entityType != "" && !slices.Contains(searchTypes, entityType) {
+		apierror.Write(w, apierror.Validation("type must be one of user, role, group, policy"))
+		return
+	}
+	page, apiErr := pagination.Parse(query, searchOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	dir := "ASC"
+	if page.Desc {
+		dir = "DESC"
+	}
+
+	where, args := searchFilter(accountID, q, entityType)
+	var total int
+	err := db.DB.QueryRow(searchEntities+" SELECT COUNT(*) FROM entities e WHERE "+where, args...).Scan(&total)
+	if err != nil {
+		log.Printf("Failed to search IAM: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to search"))
+		return
+	}
+
+	rows, err := db.DB.Query(fmt.Sprintf(searchEntities+`
+		SELECT e.type, e.name, e.arn, e.tags,
+			CASE WHEN e.name ILIKE $2 THEN 'name' WHEN e.arn ILIKE $2 THEN 'arn' ELSE 'tag' END
+		FROM entities e
+		WHERE %s
+		ORDER BY e.name %s, e.type
+		LIMIT $%d OFFSET $%d`, where, dir, len(args)+1, len(args)+2),
+		append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to search IAM: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to search"))
+		return
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		var tags []byte
+		if err := rows.Scan(&res.Type, &res.Name, &res.ARN, &tags, &res.MatchedOn); err != nil {
+			log.Printf("Scan error: %v", err)
+			apierror.Write(w, apierror.Internal("Scan error"))
+			return
+		}
+		json.Unmarshal(tags, &res.Tags)
+		if res.Tags == nil {
+			res.Tags = map[string]string{}
+		}
+		results = append(results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(results, total, page))
+}
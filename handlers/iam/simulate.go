@@ -0,0 +1,417 @@
+package iam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"allanswebterminal/accounts"
+	"allanswebterminal/apierror"
+)
+
+// Evaluation decisions, named as in the AWS policy simulator.
+const (
+	decisionAllowed      = "allowed"
+	decisionExplicitDeny = "explicitDeny"
+	decisionImplicitDeny = "implicitDeny"
+)
+
+// Source types of the policies behind a matched statement.
+const (
+	sourceManaged  = "managed"
+	sourceInline   = "inline"
+	sourceInput    = "input"
+	sourceBoundary = "permissions_boundary"
+	sourceSCP      = "service_control_policy"
+)
+
+// sourcedPolicy is a policy document and where it came from.
+type sourcedPolicy struct {
+	id         string
+	sourceType string
+	doc        *PolicyDocument
+}
+
+type SimulatePolicyRequest struct {
+	PolicySourceARN string   `json:"policy_source_arn"`
+	ActionNames     []string `json:"action_names"`
+	// ResourceARNs defaults to "*".
+	ResourceARNs []string `json:"resource_arns,omitempty"`
+	// PolicyInputList adds identity policies to the principal's own.
+	PolicyInputList []string `json:"policy_input_list,omitempty"`
+	// PermissionsBoundaryPolicyInput replaces the principal's boundary.
+	PermissionsBoundaryPolicyInput string `json:"permissions_boundary_policy_input,omitempty"`
+	// ResourcePolicy replaces the resource-based policies of the
+	// resources, which are otherwise the bucket policies of simulated
+	// buckets and the trust policies of roles. ResourceOwner is the
+	// account that owns the resources, by default the caller's own.
+	ResourcePolicy string `json:"resource_policy,omitempty"`
+	ResourceOwner  string `json:"resource_owner,omitempty"`
+}
+
+type MatchedStatement struct {
+	SourcePolicyID   string `json:"source_policy_id"`
+	SourcePolicyType string `json:"source_policy_type"`
+	Sid              string `json:"sid,omitempty"`
+	Effect           string `json:"effect"`
+}
+
+type PermissionsBoundaryDecision struct {
+	AllowedByPermissionsBoundary bool `json:"allowed_by_permissions_boundary"`
+}
+
+type OrganizationsDecision struct {
+	AllowedByOrganizations bool `json:"allowed_by_organizations"`
+}
+
+// TraceStep is one step of an evaluation: which policies were checked
+// and what they contributed. Outcome is "deny", "allow" or "continue".
+type TraceStep struct {
+	Step    string `json:"step"`
+	Outcome string `json:"outcome"`
+	Detail  string `json:"detail"`
+}
+
+type EvaluationResult struct {
+	ActionName        string             `json:"action_name"`
+	ResourceName      string             `json:"resource_name"`
+	Decision          string             `json:"decision"`
+	Reason            string             `json:"reason"`
+	MatchedStatements []MatchedStatement `json:"matched_statements"`
+	// PermissionsBoundaryDecision is set when a boundary applies.
+	PermissionsBoundaryDecision *PermissionsBoundaryDecision `json:"permissions_boundary_decision_detail,omitempty"`
+	// OrganizationsDecision is set for principals in member accounts.
+	OrganizationsDecision *OrganizationsDecision `json:"organizations_decision_detail,omitempty"`
+	// ResourcePolicyDecision is set when the resource has a resource-based
+	// policy.
+	ResourcePolicyDecision *ResourcePolicyDecision `json:"resource_policy_decision_detail,omitempty"`
+	// Trace lists the evaluation steps up to the one that decided.
+	Trace []TraceStep `json:"trace"`
+}
+
+type SimulationResult struct {
+	PolicySourceARN     string             `json:"policy_source_arn"`
+	PermissionsBoundary *string            `json:"permissions_boundary"`
+	EvaluationResults   []EvaluationResult `json:"evaluation_results"`
+}
+
+// match returns whether any statement of policies allows or denies action
+// on resource, and the statements that did.
+func match(policies []sourcedPolicy, action, resource string) (allow, deny bool, matched []MatchedStatement) {
+	for _, p := range policies {
+		for _, s := range p.doc.Statement {
+			if !s.applies(action, resource) {
+				continue
+			}
+			if s.Effect == "Deny" {
+				deny = true
+			} else {
+				allow = true
+			}
+			matched = append(matched, MatchedStatement{
+				SourcePolicyID:   p.id,
+				SourcePolicyType: p.sourceType,
+				Sid:              s.Sid,
+				Effect:           s.Effect,
+			})
+		}
+	}
+	return allow, deny, matched
+}
+
+// evaluation is everything that decides what a principal may do.
+type evaluation struct {
+	identity []sourcedPolicy
+	boundary *sourcedPolicy
+	// scps holds the SCPs attached at each level from the root down to the
+	// principal's account; nil outside member accounts.
+	scps [][]sourcedPolicy
+	// callerARN and callerAccount identify the principal to resource-based
+	// policies.
+	callerARN     string
+	callerAccount string
+}
+
+// evaluate decides action on resource when the resource has no
+// resource-based policy.
+func (e evaluation) evaluate(action, resource string) EvaluationResult {
+	return e.evaluateWith(action, resource, nil)
+}
+
+// evaluateWith decides action on resource, whose resource-based policy is
+// rp (nil if it has none), in the order AWS does:
+//  1. an explicit Deny in any policy wins;
+//  2. in member accounts, an SCP at every level of the organization must
+//     allow the action;
+//  3. within one account, a resource policy that names the caller is
+//     enough; across accounts, or for a required policy such as a trust
+//     policy, the resource policy must allow the caller or its account,
+//     and unless it names the caller in the same account the caller's own
+//     policies must allow it too;
+//  4. the identity policies must allow the action;
+//  5. and so must the permissions boundary, if there is one.
+//
+// Without a resource policy the effective permissions are the intersection
+// of the identity policies, the boundary and the SCPs.
+func (e evaluation) evaluateWith(action, resource string, rp *resourcePolicy) EvaluationResult {
+	result := EvaluationResult{ActionName: action, ResourceName: resource, MatchedStatements: []MatchedStatement{}, Trace: []TraceStep{}}
+	if rp != nil && !rp.governs(action) {
+		rp = nil
+	}
+	trace := func(step, outcome, detail string) {
+		result.Trace = append(result.Trace, TraceStep{Step: step, Outcome: outcome, Detail: detail})
+	}
+	decide := func(decision, reason string) EvaluationResult {
+		result.Decision, result.Reason = decision, reason
+		return result
+	}
+
+	identityAllow, deny, matched := match(e.identity, action, resource)
+	result.MatchedStatements = append(result.MatchedStatements, matched...)
+	boundaryAllow := true
+	if e.boundary != nil {
+		allow, boundaryDeny, matched := match([]sourcedPolicy{*e.boundary}, action, resource)
+		result.MatchedStatements = append(result.MatchedStatements, matched...)
+		boundaryAllow = allow
+		result.PermissionsBoundaryDecision = &PermissionsBoundaryDecision{
+			AllowedByPermissionsBoundary: allow && !boundaryDeny,
+		}
+		deny = deny || boundaryDeny
+	}
+	orgsAllow := true
+	if e.scps != nil {
+		for _, level := range e.scps {
+			levelAllow, levelDeny, matched := match(level, action, resource)
+			result.MatchedStatements = append(result.MatchedStatements, matched...)
+			orgsAllow = orgsAllow && levelAllow && !levelDeny
+			deny = deny || levelDeny
+		}
+		result.OrganizationsDecision = &OrganizationsDecision{AllowedByOrganizations: orgsAllow}
+	}
+	var rpAllow, named, crossAccount bool
+	if rp != nil {
+		var rpDeny bool
+		rpAllow, rpDeny, named, matched = rp.match(action, resource, e.callerARN, e.callerAccount)
+		result.MatchedStatements = append(result.MatchedStatements, matched...)
+		crossAccount = !accounts.SameAccount(rp.owner, e.callerAccount)
+		result.ResourcePolicyDecision = &ResourcePolicyDecision{
+			SourcePolicyType:        rp.sourceType,
+			AllowedByResourcePolicy: rpAllow && !rpDeny,
+			NamesPrincipal:          named,
+			CrossAccount:            crossAccount,
+		}
+		deny = deny || rpDeny
+	}
+
+	if deny {
+		trace("explicit_deny", "deny", "a Deny statement matched")
+		return decide(decisionExplicitDeny, "an explicit Deny in one of the policies")
+	}
+	trace("explicit_deny", "continue", "no Deny statement matched")
+
+	if e.scps != nil {
+		if !orgsAllow {
+			trace("organizations", "deny", "an SCP level does not allow the action")
+			return decide(decisionImplicitDeny, "the organization's SCPs do not allow the action")
+		}
+		trace("organizations", "continue", "SCPs at every level allow the action")
+	}
+
+	if rp != nil {
+		switch {
+		case rpAllow && named && !crossAccount:
+			trace("resource_policy", "allow", "the "+rp.kind+" names the caller")
+			return decide(decisionAllowed, "the "+rp.kind+" names the caller, which is enough within one account")
+		case !rpAllow && crossAccount:
+			trace("resource_policy", "deny", "the "+rp.kind+" does not allow the caller")
+			return decide(decisionImplicitDeny, "across accounts the "+rp.kind+" must allow the caller")
+		case !rpAllow && rp.required:
+			trace("resource_policy", "deny", "the "+rp.kind+" does not allow the caller")
+			return decide(decisionImplicitDeny, "the "+rp.kind+" must allow the caller")
+		case rpAllow:
+			trace("resource_policy", "continue", "the "+rp.kind+" allows the caller's account, so the caller's own policies must allow the action")
+		default:
+			trace("resource_policy", "continue", "the "+rp.kind+" does not allow the caller")
+		}
+	}
+
+	if !identityAllow {
+		trace("identity", "deny", "no identity policy allows the action")
+		if crossAccount {
+			return decide(decisionImplicitDeny, "across accounts the caller's own policies must also allow the action")
+		}
+		return decide(decisionImplicitDeny, "no identity policy allows the action")
+	}
+	trace("identity", "continue", "an identity policy allows the action")
+
+	if e.boundary != nil {
+		if !boundaryAllow {
+			trace("permissions_boundary", "deny", "the permissions boundary does not allow the action")
+			return decide(decisionImplicitDeny, "the permissions boundary does not allow the action")
+		}
+		trace("permissions_boundary", "continue", "the permissions boundary allows the action")
+	}
+	trace("decision", "allow", "every applicable policy allows the action")
+	return decide(decisionAllowed, "allowed by the identity policies")
+}
+
+// identityPolicies loads the managed and inline policies of p.
+func identityPolicies(accountID int, p *principal) ([]sourcedPolicy, error) {
+	var policies []sourcedPolicy
+	for _, arn := range p.attachedPolicies {
+		doc, err := loadManagedPolicy(accountID, arn)
+		if errors.Is(err, errNoSuchPolicy) {
+			log.Printf("Skipping missing policy %s attached to %s", arn, p.arn)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %w", arn, err)
+		}
+		policies = append(policies, sourcedPolicy{id: arn, sourceType: sourceManaged, doc: doc})
+	}
+	names := make([]string, 0, len(p.inlinePolicies))
+	for name := range p.inlinePolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		raw := p.inlinePolicies[name]
+		// Inline policies are stored either as documents or as JSON strings.
+		document := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			document = s
+		}
+		doc, err := parsePolicy(document)
+		if err != nil {
+			return nil, fmt.Errorf("inline policy %s: %w", name, err)
+		}
+		policies = append(policies, sourcedPolicy{id: name, sourceType: sourceInline, doc: doc})
+	}
+	return policies, nil
+}
+
+// principalEvaluation loads the identity policies, permissions boundary and
+// SCPs that apply to p.
+func principalEvaluation(accountID int, p *principal) (evaluation, error) {
+	e := evaluation{callerARN: p.arn, callerAccount: p.account}
+	var err error
+	if e.identity, err = identityPolicies(accountID, p); err != nil {
+		return e, err
+	}
+	if p.permissionsBoundary != nil {
+		doc, err := loadManagedPolicy(accountID, *p.permissionsBoundary)
+		if err != nil {
+			return e, fmt.Errorf("permissions boundary %s: %w", *p.permissionsBoundary, err)
+		}
+		e.boundary = &sourcedPolicy{id: *p.permissionsBoundary, sourceType: sourceBoundary, doc: doc}
+	}
+	if p.org != nil {
+		if e.scps, err = scpLevels(p.org, p.account); err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// SimulatePolicyHandler evaluates actions for a user or role against its
+// identity policies, permissions boundary and, in member accounts, the
+// organization's SCPs, like the AWS policy simulator's
+// SimulatePrincipalPolicy.
+func SimulatePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getAccountIDFromSession(r)
+	if accountID == 0 {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req SimulatePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.PolicySourceARN == "" || len(req.ActionNames) == 0 {
+		apierror.Write(w, apierror.Validation("policy_source_arn and action_names are required"))
+		return
+	}
+	if len(req.ResourceARNs) == 0 {
+		req.ResourceARNs = []string{"*"}
+	}
+
+	p, err := loadPrincipalByARN(accountID, req.PolicySourceARN)
+	if errors.Is(err, errNoSuchPrincipal) {
+		apierror.Write(w, apierror.NotFound("No user or role with that ARN"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load principal: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load principal"))
+		return
+	}
+
+	e, err := principalEvaluation(accountID, p)
+	if err != nil {
+		log.Printf("Failed to load policies of %s: %v", p.arn, err)
+		apierror.Write(w, apierror.Internal("Failed to load policies"))
+		return
+	}
+	for i, document := range req.PolicyInputList {
+		doc, err := parsePolicy(document)
+		if err != nil {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("policy_input_list[%d]: %v", i, err)))
+			return
+		}
+		e.identity = append(e.identity, sourcedPolicy{id: fmt.Sprintf("PolicyInputList.%d", i+1), sourceType: sourceInput, doc: doc})
+	}
+	if req.PermissionsBoundaryPolicyInput != "" {
+		doc, err := parsePolicy(req.PermissionsBoundaryPolicyInput)
+		if err != nil {
+			apierror.Write(w, apierror.Validation("permissions_boundary_policy_input: "+err.Error()))
+			return
+		}
+		e.boundary = &sourcedPolicy{id: "PermissionsBoundaryPolicyInput", sourceType: sourceBoundary, doc: doc}
+	}
+
+	var input *resourcePolicy
+	if req.ResourcePolicy != "" {
+		doc, err := parseResourcePolicy("resource_policy", req.ResourcePolicy)
+		if err != nil {
+			apierror.Write(w, apierror.Validation(err.Error()))
+			return
+		}
+		owner := req.ResourceOwner
+		if owner == "" {
+			owner = p.account
+		}
+		input = &resourcePolicy{
+			sourcedPolicy: sourcedPolicy{id: "ResourcePolicy", sourceType: sourceInput, doc: doc},
+			kind:          "resource policy",
+			owner:         owner,
+		}
+	}
+
+	resourcePolicies := make([]*resourcePolicy, len(req.ResourceARNs))
+	for i, resource := range req.ResourceARNs {
+		resourcePolicies[i] = input
+		if input == nil {
+			if resourcePolicies[i], err = loadResourcePolicy(accountID, resource); err != nil {
+				log.Printf("Failed to load the resource policy of %s: %v", resource, err)
+				apierror.Write(w, apierror.Internal("Failed to load resource policy"))
+				return
+			}
+		}
+	}
+
+	result := SimulationResult{PolicySourceARN: p.arn, PermissionsBoundary: p.permissionsBoundary}
+	for _, action := range req.ActionNames {
+		for i, resource := range req.ResourceARNs {
+			result.EvaluationResults = append(result.EvaluationResults, e.evaluateWith(action, resource, resourcePolicies[i]))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,149 @@
+package login
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/passhash"
+	"allanswebterminal/securecookie"
+)
+
+// GuestTTL is how long a guest account lives before DeleteExpiredGuests
+// removes it with everything it created.
+var GuestTTL = 24 * time.Hour
+
+type GuestResponse struct {
+	User      User      `json:"user"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateGuestHandler signs the visitor in to a new temporary account. The
+// account has its own simulated cloud account and file workspace like any
+// other; registering while signed in keeps them.
+func CreateGuestHandler(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	// The password is never revealed, so nobody can log in to a guest
+	// account; the session cookie is the only way in.
+	hashedPassword, err := hashPassword(hex.EncodeToString(b))
+	if err != nil {
+		log.Printf("Failed to hash guest password: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create guest account"))
+		return
+	}
+
+	resp := GuestResponse{User: User{Username: "guest-" + hex.EncodeToString(b[:4])}}
+	resp.ExpiresAt = time.Now().UTC().Add(GuestTTL).Truncate(time.Second)
+	err = db.DB.QueryRowContext(r.Context(), `
+		INSERT INTO accounts (username, password, password_algorithm, guest_expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, role`,
+		resp.User.Username, hashedPassword, passhash.Algorithm(hashedPassword), resp.ExpiresAt,
+	).Scan(&resp.User.ID, &resp.User.Role)
+	if err != nil {
+		log.Printf("Failed to create guest account: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to create guest account"))
+		return
+	}
+
+	cookie := createSessionCookie(resp.User.ID)
+	cookie.Expires = resp.ExpiresAt
+	http.SetCookie(w, cookie)
+
+	setJSONContentType(w)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GuestStatusHandler tells a guest when the account expires. Registered
+// accounts get 404.
+func GuestStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	expiresAt, err := guestExpiry(r.Context(), user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Not a guest account"))
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		apierror.Write(w, apierror.Internal("Database error"))
+		return
+	}
+
+	setJSONContentType(w)
+	json.NewEncoder(w).Encode(GuestResponse{User: *user, ExpiresAt: expiresAt})
+}
+
+// guestExpiry returns when the guest account id expires, or sql.ErrNoRows
+// for a registered account.
+func guestExpiry(ctx context.Context, id int) (time.Time, error) {
+	var expiresAt time.Time
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT guest_expires_at FROM accounts WHERE id = $1 AND guest_expires_at IS NOT NULL", id,
+	).Scan(&expiresAt)
+	return expiresAt, err
+}
+
+// currentGuestID returns the id of the guest account signed in on r, or 0.
+func currentGuestID(r *http.Request) int {
+	value, ok := securecookie.Value(r, "user_id")
+	if !ok {
+		return 0
+	}
+	var id int
+	if _, err := fmt.Sscan(value, &id); err != nil {
+		return 0
+	}
+	if _, err := guestExpiry(r.Context(), id); err != nil {
+		return 0
+	}
+	return id
+}
+
+// convertGuest turns guest account id into a registered one, keeping its
+// data.
+func convertGuest(id int, username, password string) error {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	result, err := db.DB.Exec(`
+		UPDATE accounts SET username = $1, password = $2, password_algorithm = $3, guest_expires_at = NULL
+		WHERE id = $4 AND guest_expires_at IS NOT NULL`,
+		sanitizeUsername(username), hashedPassword, passhash.Algorithm(hashedPassword), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("guest account expired")
+	}
+	return nil
+}
+
+// DeleteExpiredGuests removes guest accounts past their expiry; their
+// files and simulated resources go with them. It runs from the scheduler.
+func DeleteExpiredGuests(ctx context.Context) error {
+	result, err := db.DB.ExecContext(ctx,
+		"DELETE FROM accounts WHERE guest_expires_at IS NOT NULL AND guest_expires_at < $1", time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to delete expired guest accounts: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Deleted %d expired guest accounts", n)
+	}
+	return nil
+}
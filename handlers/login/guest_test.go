@@ -0,0 +1,80 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/passhash"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		mockDB.Close()
+		db.DB = originalDB
+	})
+	return mock
+}
+
+func TestCreateGuestHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("INSERT INTO accounts").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), passhash.Argon2id, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role"}).AddRow(42, "user"))
+
+	rr := httptest.NewRecorder()
+	CreateGuestHandler(rr, httptest.NewRequest("POST", "/api/guest", nil))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+	if id, err := securecookie.Decode("user_id", cookies[0].Value); err != nil || id != "42" {
+		t.Fatalf("cookies = %+v", cookies)
+	}
+	if until := time.Until(cookies[0].Expires); until < GuestTTL-time.Minute || until > GuestTTL {
+		t.Errorf("session cookie expires in %v, want the guest TTL %v", until, GuestTTL)
+	}
+	if !strings.Contains(rr.Body.String(), `"username":"guest-`) {
+		t.Errorf("body = %s", rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegisterConvertsGuest(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT guest_expires_at FROM accounts").WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"guest_expires_at"}).AddRow(time.Now().Add(time.Hour)))
+	mock.ExpectExec("UPDATE accounts SET username").WithArgs("alice", sqlmock.AnyArg(), passhash.Argon2id, 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest("POST", "/api/register", strings.NewReader(`{"username": " alice ", "password": "secret1"}`))
+	req.AddCookie(securecookie.Cookie("user_id", "42"))
+	rr := httptest.NewRecorder()
+	RegisterAPIHandler(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"success":true`) {
+		t.Fatalf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -4,137 +4,185 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"allanswebterminal/flash"
+	"allanswebterminal/i18n"
+	"allanswebterminal/templates"
+
+	"allanswebterminal/apierror"
 	"allanswebterminal/db"
+	"allanswebterminal/passhash"
+	"allanswebterminal/securecookie"
+	"allanswebterminal/validate"
 )
 
+// SecureCookies marks session cookies as Secure. It is enabled when the
+// server terminates TLS itself.
+var SecureCookies bool
+
+// OnLoginFailure, if set, is called with each sign-in that fails and the
+// username it tried, so repeated failures can be detected.
+var OnLoginFailure func(r *http.Request, username string)
+
 type User struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	Locale   string `json:"locale,omitempty"`
 }
 
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required" message:"please enter your username"`
+	Password string `json:"password" validate:"required" message:"please enter your password"`
+	// RedirectToken is the signed page to return to, from the login page.
+	RedirectToken string `json:"redirect_token,omitempty"`
 }
 
+// LoginResponse keeps the success/message fields the login forms read and
+// carries the standard error object on failure.
 type LoginResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	User    *User  `json:"user,omitempty"`
+	// Redirect is where the form goes after signing in, from a valid
+	// redirect token.
+	Redirect string          `json:"redirect,omitempty"`
+	Error    *apierror.Error `json:"error,omitempty"`
 }
 
 type CheckUsernameRequest struct {
 	Username string `json:"username"`
 }
 
+// CheckUsernameResponse tells the login form whether an account exists and
+// the register form whether the username can be taken: Available is false
+// when it exists or breaks UsernamePolicy, and Reason says why.
 type CheckUsernameResponse struct {
-	Exists bool `json:"exists"`
+	Exists    bool   `json:"exists"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
 }
 
+// LoginPageHandler shows the login form. A redirect_token from LoginURL,
+// or a redirect parameter that passes SafeRedirect, is where the form goes
+// after signing in; anything else is dropped.
 func LoginPageHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	redirect := getRedirectURL(r)
 	data := createLoginPageData(redirect)
 	
-	if err := renderLoginPage(w, data); err != nil {
+	if err := renderLoginPage(w, r, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 func LoginAPIHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	setJSONContentType(w)
 
 	req, err := parseLoginRequest(r)
 	if err != nil {
-		writeErrorResponse(w, "Invalid JSON format")
+		writeLoginError(w, apierror.DecodeError(err))
 		return
 	}
 
 	if err := validateLoginRequest(req); err != nil {
-		writeErrorResponse(w, err.Error())
+		writeLoginError(w, validate.APIError(err))
 		return
 	}
 
 	user, err := authenticateUser(req.Username, req.Password)
 	if err != nil {
 		log.Printf("Authentication error: %v", err)
+		if OnLoginFailure != nil {
+			OnLoginFailure(r, req.Username)
+		}
 		message := getAuthenticationErrorMessage(err)
-		writeErrorResponse(w, message)
+		writeLoginError(w, apierror.New(apierror.CodeUnauthorized, message))
+		return
+	}
+	signIn(w, r, user, req.RedirectToken)
+}
+
+// signIn starts a session for user, who has proved who they are, unless
+// the account is suspended, and answers with where to go next.
+func signIn(w http.ResponseWriter, r *http.Request, user *User, redirectToken string) {
+	suspension, err := ActiveSuspension(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to check suspension of account %d: %v", user.ID, err)
+		writeLoginError(w, apierror.Internal("login failed - please try again"))
+		return
+	}
+	if suspension != nil {
+		writeLoginError(w, suspension.Err())
 		return
 	}
 
 	setSessionCookie(w, user.ID)
+	if user.Locale != "" {
+		// Carry the saved language preference over to this browser.
+		i18n.SetCookie(w, user.Locale, SecureCookies)
+		w.Header().Set("Content-Language", user.Locale)
+	}
+	if target, ok := ParseRedirectToken(redirectToken); ok {
+		writeRedirectResponse(w, "Login successful", user, target)
+		return
+	}
 	writeSuccessResponse(w, "Login successful", user)
 }
 
 func RegisterPageHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	if err := renderRegisterPage(w); err != nil {
+	if err := renderRegisterPage(w, r); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 func RegisterAPIHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	setJSONContentType(w)
 
 	req, err := parseLoginRequest(r)
 	if err != nil {
-		writeErrorResponse(w, "Invalid JSON format")
+		writeLoginError(w, apierror.DecodeError(err))
 		return
 	}
 
 	if err := validateRegistrationRequest(req); err != nil {
-		writeErrorResponse(w, err.Error())
+		writeLoginError(w, validate.APIError(err))
+		return
+	}
+
+	if guestID := currentGuestID(r); guestID != 0 {
+		// Keep the guest's files and simulated resources.
+		if err := convertGuest(guestID, req.Username, req.Password); err != nil {
+			log.Printf("Guest conversion error: %v", err)
+			writeLoginError(w, registrationError(err))
+			return
+		}
+		setSessionCookie(w, guestID)
+		flash.Add(w, r, flash.Success, "Account created. Your guest work has been kept.")
+		writeSuccessResponse(w, "Registration successful", nil)
 		return
 	}
 
 	if err := createUser(req.Username, req.Password); err != nil {
 		log.Printf("Registration error: %v", err)
-		message := getRegistrationErrorMessage(err)
-		writeErrorResponse(w, message)
+		writeLoginError(w, registrationError(err))
 		return
 	}
 
+	flash.Add(w, r, flash.Success, "Account created. Sign in to continue.")
 	writeSuccessResponse(w, "Registration successful", nil)
 }
 
 func CheckUsernameAPIHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	setJSONContentType(w)
 
 	req, err := parseCheckUsernameRequest(r)
 	if err != nil {
-		writeCheckUsernameErrorResponse(w, "Invalid JSON format")
+		apierror.Write(w, apierror.DecodeError(err))
 		return
 	}
 
@@ -144,15 +192,21 @@ func CheckUsernameAPIHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	exists := checkUsernameExists(req.Username)
-	writeCheckUsernameResponse(w, exists)
+	writeCheckUsernameResponse(w, newCheckUsernameResponse(req.Username, exists))
+}
+
+// Authenticate checks a username and password without signing in, for
+// requests that prove who they are without a session.
+func Authenticate(username, password string) (*User, error) {
+	return authenticateUser(username, password)
 }
 
 func authenticateUser(username, password string) (*User, error) {
 	var user User
 	var hashedPassword string
 
-	query := "SELECT id, username, password, role FROM accounts WHERE username = $1"
-	err := db.DB.QueryRow(query, username).Scan(&user.ID, &user.Username, &hashedPassword, &user.Role)
+	query := "SELECT id, username, password, role, COALESCE(locale, '') FROM accounts WHERE LOWER(username) = LOWER($1)"
+	err := db.DB.QueryRow(query, username).Scan(&user.ID, &user.Username, &hashedPassword, &user.Role, &user.Locale)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -163,10 +217,30 @@ func authenticateUser(username, password string) (*User, error) {
 	if err := verifyPassword(password, hashedPassword); err != nil {
 		return nil, fmt.Errorf("invalid password")
 	}
+	if passhash.NeedsRehash(hashedPassword) {
+		rehashPassword(user.ID, password, hashedPassword)
+	}
 
 	return &user, nil
 }
 
+// rehashPassword replaces an account's hash made with another algorithm or
+// other parameters than the configured ones, now that the password is
+// known. It only replaces the hash it was given, so a password changed
+// meanwhile is kept. Failures are logged; the old hash still works.
+func rehashPassword(accountID int, password, oldHash string) {
+	hashedPassword, err := hashPassword(password)
+	if err == nil {
+		_, err = db.DB.Exec(
+			"UPDATE accounts SET password = $1, password_algorithm = $2 WHERE id = $3 AND password = $4",
+			hashedPassword, passhash.Algorithm(hashedPassword), accountID, oldHash,
+		)
+	}
+	if err != nil {
+		log.Printf("Failed to rehash the password of account %d: %v", accountID, err)
+	}
+}
+
 func createUser(username, password string) error {
 	hashedPassword, err := hashPassword(password)
 	if err != nil {
@@ -178,21 +252,20 @@ func createUser(username, password string) error {
 }
 
 func insertUser(username, hashedPassword string) error {
-	query := "INSERT INTO accounts (username, password) VALUES ($1, $2)"
-	_, err := db.DB.Exec(query, username, hashedPassword)
+	query := "INSERT INTO accounts (username, password, password_algorithm) VALUES ($1, $2, $3)"
+	_, err := db.DB.Exec(query, username, hashedPassword, passhash.Algorithm(hashedPassword))
 	return err
 }
 
 func GetCurrentUser(r *http.Request) (*User, error) {
-	cookie, err := r.Cookie("user_id")
-	if err != nil {
-		return nil, err
+	userID, ok := securecookie.Value(r, "user_id")
+	if !ok {
+		return nil, http.ErrNoCookie
 	}
 
-	userID := cookie.Value
 	var user User
 	query := "SELECT id, username, role FROM accounts WHERE id = $1"
-	err = db.DB.QueryRow(query, userID).Scan(&user.ID, &user.Username, &user.Role)
+	err := db.DB.QueryRow(query, userID).Scan(&user.ID, &user.Username, &user.Role)
 	if err != nil {
 		return nil, err
 	}
@@ -202,34 +275,40 @@ func GetCurrentUser(r *http.Request) (*User, error) {
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	clearSessionCookie(w)
+	flash.Add(w, r, flash.Success, "You have signed out.")
 	http.Redirect(w, r, "/projects", http.StatusSeeOther)
 }
 
 // Helper functions for LoginPageHandler
 func getRedirectURL(r *http.Request) string {
-	return r.URL.Query().Get("redirect")
+	q := r.URL.Query()
+	if target, ok := ParseRedirectToken(q.Get("redirect_token")); ok {
+		return target
+	}
+	return SafeRedirect(q.Get("redirect"))
 }
 
-func createLoginPageData(redirect string) struct{ Redirect string } {
-	return struct{ Redirect string }{
-		Redirect: redirect,
-	}
+// loginPageData is the page to return to after signing in, and the token
+// the form sends back for it.
+type loginPageData struct {
+	Redirect      string
+	RedirectToken string
 }
 
-func renderLoginPage(w http.ResponseWriter, data struct{ Redirect string }) error {
-	tmpl, err := template.ParseFiles("templates/login.html")
-	if err != nil {
-		return err
+func createLoginPageData(redirect string) loginPageData {
+	data := loginPageData{Redirect: redirect}
+	if redirect != "" {
+		data.RedirectToken = RedirectToken(redirect)
 	}
-	return tmpl.Execute(w, data)
+	return data
 }
 
-func renderRegisterPage(w http.ResponseWriter) error {
-	tmpl, err := template.ParseFiles("templates/register.html")
-	if err != nil {
-		return err
-	}
-	return tmpl.Execute(w, nil)
+func renderLoginPage(w http.ResponseWriter, r *http.Request, data loginPageData) error {
+	return templates.Render(w, r, "login", data)
+}
+
+func renderRegisterPage(w http.ResponseWriter, r *http.Request) error {
+	return templates.Render(w, r, "register", nil)
 }
 
 // Helper functions for API handlers
@@ -248,19 +327,16 @@ func validateLoginRequest(req *LoginRequest) error {
 }
 
 func validateLoginFields(username, password string) error {
-	if strings.TrimSpace(username) == "" {
-		return fmt.Errorf("please enter your username")
-	}
-	if strings.TrimSpace(password) == "" {
-		return fmt.Errorf("please enter your password")
-	}
-	return nil
+	return validate.Struct(LoginRequest{Username: username, Password: password})
 }
 
 func validateRegistrationRequest(req *LoginRequest) error {
 	if err := validateLoginRequest(req); err != nil {
 		return err
 	}
+	if err := UsernamePolicy.Check(req.Username); err != nil {
+		return err
+	}
 	if len(req.Password) < 6 {
 		return fmt.Errorf("password must be at least 6 characters long")
 	}
@@ -268,18 +344,30 @@ func validateRegistrationRequest(req *LoginRequest) error {
 }
 
 func writeErrorResponse(w http.ResponseWriter, message string) {
+	writeLoginError(w, apierror.Validation(message))
+}
+
+func writeLoginError(w http.ResponseWriter, apiErr *apierror.Error) {
+	apiErr = apierror.Localize(w, apiErr)
 	response := LoginResponse{
 		Success: false,
-		Message: message,
+		Message: apiErr.Message,
+		Error:   apiErr,
 	}
+	w.WriteHeader(apiErr.Status())
 	json.NewEncoder(w).Encode(response)
 }
 
 func writeSuccessResponse(w http.ResponseWriter, message string, user *User) {
+	writeRedirectResponse(w, message, user, "")
+}
+
+func writeRedirectResponse(w http.ResponseWriter, message string, user *User, redirect string) {
 	response := LoginResponse{
-		Success: true,
-		Message: message,
-		User:    user,
+		Success:  true,
+		Message:  i18n.T(i18n.ResponseLocale(w), message),
+		User:     user,
+		Redirect: redirect,
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -292,10 +380,10 @@ func setSessionCookie(w http.ResponseWriter, userID int) {
 func createSessionCookie(userID int) *http.Cookie {
 	return &http.Cookie{
 		Name:     "user_id",
-		Value:    fmt.Sprintf("%d", userID),
+		Value:    securecookie.Encode("user_id", strconv.Itoa(userID)),
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
+		Secure:   SecureCookies,
 		SameSite: http.SameSiteLaxMode,
 		Expires:  time.Now().Add(24 * time.Hour),
 	}
@@ -307,18 +395,18 @@ func clearSessionCookie(w http.ResponseWriter) {
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
+		Secure:   SecureCookies,
 		Expires:  time.Now().Add(-1 * time.Hour),
 	})
 }
 
 // Helper functions for password operations
 func hashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(hashedPassword), err
+	return passhash.Hash(password)
 }
 
 func verifyPassword(password, hashedPassword string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	return passhash.Verify(password, hashedPassword)
 }
 
 func sanitizeUsername(username string) string {
@@ -336,9 +424,21 @@ func getAuthenticationErrorMessage(err error) string {
 	return "invalid username or password"
 }
 
-func getRegistrationErrorMessage(err error) string {
+func registrationError(err error) *apierror.Error {
+	message := getRegistrationErrorMessage(err)
+	if isDuplicateUsernameError(err) {
+		return apierror.Conflict(message)
+	}
+	return apierror.Internal(message)
+}
+
+func isDuplicateUsernameError(err error) bool {
 	errorMsg := err.Error()
-	if strings.Contains(errorMsg, "UNIQUE constraint failed") || strings.Contains(errorMsg, "duplicate key") {
+	return strings.Contains(errorMsg, "UNIQUE constraint failed") || strings.Contains(errorMsg, "duplicate key")
+}
+
+func getRegistrationErrorMessage(err error) string {
+	if isDuplicateUsernameError(err) {
 		return "username already exists - please choose a different username or login to your existing account"
 	}
 	return "registration failed - please try again"
@@ -359,7 +459,7 @@ func validateUsernameOnly(username string) error {
 
 func checkUsernameExists(username string) bool {
 	var count int
-	query := "SELECT COUNT(*) FROM accounts WHERE username = $1"
+	query := "SELECT COUNT(*) FROM accounts WHERE LOWER(username) = LOWER($1)"
 	err := db.DB.QueryRow(query, username).Scan(&count)
 	if err != nil {
 		return false
@@ -367,18 +467,26 @@ func checkUsernameExists(username string) bool {
 	return count > 0
 }
 
-func writeCheckUsernameResponse(w http.ResponseWriter, exists bool) {
-	response := CheckUsernameResponse{
-		Exists: exists,
+// newCheckUsernameResponse never fails on a policy violation: usernames
+// taken before the policy existed must still be able to log in.
+func newCheckUsernameResponse(username string, exists bool) CheckUsernameResponse {
+	response := CheckUsernameResponse{Exists: exists}
+	switch err := UsernamePolicy.Check(username); {
+	case exists:
+		response.Reason = "username already exists - please choose a different username or login to your existing account"
+	case err != nil:
+		response.Reason = err.Error()
+	default:
+		response.Available = true
 	}
+	return response
+}
+
+func writeCheckUsernameResponse(w http.ResponseWriter, response CheckUsernameResponse) {
+	response.Reason = i18n.T(i18n.ResponseLocale(w), response.Reason)
 	json.NewEncoder(w).Encode(response)
 }
 
 func writeCheckUsernameErrorResponse(w http.ResponseWriter, message string) {
-	response := struct {
-		Error string `json:"error"`
-	}{
-		Error: message,
-	}
-	json.NewEncoder(w).Encode(response)
+	apierror.Write(w, apierror.Validation(message))
 }
\ No newline at end of file
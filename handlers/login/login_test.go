@@ -8,6 +8,10 @@ import (
 	"testing"
 	"time"
 
+	"allanswebterminal/passhash"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -17,10 +21,15 @@ func TestGetRedirectURL(t *testing.T) {
 		queryParams string
 		expected    string
 	}{
-		{"With redirect parameter", "redirect=flashcards", "flashcards"},
+		{"With redirect parameter", "redirect=flashcards", "/flashcards"},
 		{"Empty redirect parameter", "redirect=", ""},
 		{"No redirect parameter", "", ""},
-		{"Multiple parameters", "redirect=test&other=value", "test"},
+		{"Multiple parameters", "redirect=test&other=value", "/test"},
+		{"Path with query", "redirect=%2Fpreview%2Fs1%2F%3Fx%3D1", "/preview/s1/?x=1"},
+		{"Other host", "redirect=https%3A%2F%2Fevil.example%2F", ""},
+		{"Protocol-relative", "redirect=%2F%2Fevil.example", ""},
+		{"Signed token", "redirect_token=" + RedirectToken("/cloudsimulator") + "&redirect=%2Fflashcards", "/cloudsimulator"},
+		{"Forged token", "redirect_token=L2V2aWw.9999999999.c2ln&redirect=%2Fflashcards", "/flashcards"},
 	}
 
 	for _, tt := range tests {
@@ -240,8 +249,8 @@ func TestCreateSessionCookie(t *testing.T) {
 	if cookie.Name != "user_id" {
 		t.Errorf("Expected cookie name 'user_id', got %q", cookie.Name)
 	}
-	if cookie.Value != "123" {
-		t.Errorf("Expected cookie value '123', got %q", cookie.Value)
+	if id, err := securecookie.Decode("user_id", cookie.Value); err != nil || id != "123" {
+		t.Errorf("Expected cookie value signed '123', got %q", cookie.Value)
 	}
 	if cookie.Path != "/" {
 		t.Errorf("Expected cookie path '/', got %q", cookie.Path)
@@ -274,10 +283,42 @@ func TestHashPassword(t *testing.T) {
 	}
 	
 	// Test that the hashed password can be verified
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	err = verifyPassword(password, hashedPassword)
 	if err != nil {
 		t.Errorf("Hashed password should be verifiable with original password: %v", err)
 	}
+	if passhash.Algorithm(hashedPassword) != passhash.Argon2id {
+		t.Errorf("New hashes should use Argon2id, got %q", hashedPassword)
+	}
+}
+
+func TestAuthenticateRehashesLegacyHash(t *testing.T) {
+	mock := setupMockDB(t)
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("secret1"), bcrypt.MinCost)
+	mock.ExpectQuery("SELECT id, username, password, role").WithArgs("ana").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "role", "locale"}).
+			AddRow(7, "ana", string(legacy), "user", ""))
+	mock.ExpectExec("UPDATE accounts SET password").
+		WithArgs(sqlmock.AnyArg(), passhash.Argon2id, 7, string(legacy)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	user, err := authenticateUser("ana", "secret1")
+	if err != nil || user.ID != 7 {
+		t.Fatalf("authenticateUser = %+v, %v", user, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("legacy hash was not replaced: %v", err)
+	}
+
+	// A wrong password is refused before any rehash.
+	legacyRow := sqlmock.NewRows([]string{"id", "username", "password", "role", "locale"}).AddRow(7, "ana", string(legacy), "user", "")
+	mock.ExpectQuery("SELECT id, username, password, role").WithArgs("ana").WillReturnRows(legacyRow)
+	if _, err := authenticateUser("ana", "wrong"); err == nil {
+		t.Errorf("wrong password accepted")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unexpected queries: %v", err)
+	}
 }
 
 func TestVerifyPassword(t *testing.T) {
@@ -685,7 +726,7 @@ func TestWriteCheckUsernameResponse(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			w := httptest.NewRecorder()
 			
-			writeCheckUsernameResponse(w, tt.exists)
+			writeCheckUsernameResponse(w, CheckUsernameResponse{Exists: tt.exists})
 			
 			body := w.Body.String()
 			if tt.exists {
@@ -0,0 +1,453 @@
+package login
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/validate"
+	"allanswebterminal/webauthn"
+)
+
+// RelyingParty is the site passkeys are registered with. The passkey API
+// answers 503 while it is nil.
+var RelyingParty *webauthn.RelyingParty
+
+// maxPasskeysPerAccount bounds the passkeys an account can register.
+const maxPasskeysPerAccount = 10
+
+// Ceremonies a challenge is issued for.
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+// Passkey is a passkey as listed to its owner.
+type Passkey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// RegisterPasskeyRequest is the authenticator's answer to the registration
+// options, with a name to tell the passkey apart.
+type RegisterPasskeyRequest struct {
+	Name     string                       `json:"name" validate:"max=100"`
+	Response webauthn.AttestationResponse `json:"response"`
+}
+
+// PasskeyOptionsRequest optionally names the account signing in, to offer
+// only its passkeys. Without a username the authenticator offers any
+// passkey it holds for the site.
+type PasskeyOptionsRequest struct {
+	Username string `json:"username"`
+}
+
+// PasskeyLoginRequest is the authenticator's answer to the sign-in
+// options. ID is the credential's raw ID.
+type PasskeyLoginRequest struct {
+	ID       webauthn.Bytes             `json:"id"`
+	Response webauthn.AssertionResponse `json:"response"`
+	// RedirectToken is the signed page to return to, from the login page.
+	RedirectToken string `json:"redirect_token,omitempty"`
+}
+
+func errPasskeysNotConfigured() *apierror.Error {
+	return apierror.Unavailable("Passkeys are not available on this server")
+}
+
+// userHandle is the WebAuthn user handle of an account: its ID, which
+// carries nothing personal.
+func userHandle(accountID int) []byte {
+	return []byte(strconv.Itoa(accountID))
+}
+
+// issueChallenge returns a new challenge for ceremony, for accountID or,
+// signing in, 0. It is good for webauthn.Timeout.
+func issueChallenge(ctx context.Context, ceremony string, accountID int) ([]byte, error) {
+	challenge := webauthn.NewChallenge()
+	var account interface{}
+	if accountID != 0 {
+		account = accountID
+	}
+	_, err := db.DB.ExecContext(ctx,
+		"INSERT INTO passkey_challenges (challenge, ceremony, account_id, expires_at) VALUES ($1, $2, $3, $4)",
+		challenge, ceremony, account, time.Now().Add(webauthn.Timeout))
+	return challenge, err
+}
+
+// takeChallenge consumes the unexpired challenge that clientDataJSON
+// answers, if it was issued for ceremony, and returns the account it was
+// issued to (0 for sign-in). ok is false if there is no such challenge,
+// including when it was already answered.
+func takeChallenge(ctx context.Context, ceremony string, clientDataJSON []byte) (accountID int, challenge []byte, ok bool, err error) {
+	challenge, err = webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return 0, nil, false, nil
+	}
+	err = db.DB.QueryRowContext(ctx,
+		`DELETE FROM passkey_challenges
+		 WHERE challenge = $1 AND ceremony = $2 AND expires_at > CURRENT_TIMESTAMP
+		 RETURNING COALESCE(account_id, 0)`,
+		challenge, ceremony,
+	).Scan(&accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, false, nil
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	return accountID, challenge, true, nil
+}
+
+// DeleteExpiredChallenges removes challenges that were never answered.
+func DeleteExpiredChallenges(ctx context.Context) error {
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM passkey_challenges WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+		return fmt.Errorf("failed to delete expired passkey challenges: %w", err)
+	}
+	return nil
+}
+
+// knownTransports are the authenticator transports kept with a passkey,
+// to hint browsers how to reach it.
+var knownTransports = map[string]bool{"usb": true, "nfc": true, "ble": true, "smart-card": true, "hybrid": true, "internal": true}
+
+// transports returns the known transports in list, comma-separated.
+func transports(list []string) string {
+	var kept []string
+	for _, t := range list {
+		if knownTransports[t] {
+			kept = append(kept, t)
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+// descriptors returns the passkeys registered to accountID.
+func descriptors(r *http.Request, accountID int) ([]webauthn.Descriptor, error) {
+	rows, err := db.DB.QueryContext(r.Context(),
+		"SELECT credential_id, transports FROM passkeys WHERE account_id = $1 ORDER BY id", accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []webauthn.Descriptor
+	for rows.Next() {
+		var id []byte
+		var transports string
+		if err := rows.Scan(&id, &transports); err != nil {
+			return nil, err
+		}
+		var t []string
+		if transports != "" {
+			t = strings.Split(transports, ",")
+		}
+		list = append(list, webauthn.NewDescriptor(id, t))
+	}
+	return list, rows.Err()
+}
+
+// PasskeyRegistrationOptionsHandler starts registering a passkey for the
+// caller: it answers with the options for navigator.credentials.create.
+// Guests register an account first, as their accounts are deleted.
+func PasskeyRegistrationOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if RelyingParty == nil {
+		apierror.Write(w, errPasskeysNotConfigured())
+		return
+	}
+	user, err := GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	if currentGuestID(r) != 0 {
+		apierror.Write(w, apierror.Forbidden("Register an account before adding a passkey"))
+		return
+	}
+
+	exclude, err := descriptors(r, user.ID)
+	if err != nil {
+		log.Printf("Failed to list passkeys of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to start passkey registration"))
+		return
+	}
+	challenge, err := issueChallenge(r.Context(), ceremonyRegister, user.ID)
+	if err != nil {
+		log.Printf("Failed to issue passkey challenge: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to start passkey registration"))
+		return
+	}
+	options := RelyingParty.CreationOptions(challenge, webauthn.User{
+		ID:          userHandle(user.ID),
+		Name:        user.Username,
+		DisplayName: user.Username,
+	}, exclude)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
+// RegisterPasskeyHandler verifies the authenticator's answer to the
+// registration options and stores the passkey for the caller.
+func RegisterPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	if RelyingParty == nil {
+		apierror.Write(w, errPasskeysNotConfigured())
+		return
+	}
+	user, err := GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req RegisterPasskeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	accountID, challenge, ok, err := takeChallenge(r.Context(), ceremonyRegister, req.Response.ClientDataJSON)
+	if err != nil {
+		log.Printf("Failed to look up passkey challenge: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save passkey"))
+		return
+	}
+	if !ok || accountID != user.ID {
+		apierror.Write(w, apierror.BadRequest("The passkey registration expired; start again"))
+		return
+	}
+	cred, err := RelyingParty.VerifyRegistration(challenge, req.Response)
+	if err != nil {
+		log.Printf("Passkey registration for account %d refused: %v", user.ID, err)
+		apierror.Write(w, apierror.BadRequest("The passkey could not be verified"))
+		return
+	}
+
+	p := Passkey{Name: strings.TrimSpace(req.Name)}
+	if p.Name == "" {
+		p.Name = "Passkey"
+	}
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO passkeys (account_id, credential_id, public_key, sign_count, transports, name)
+		 SELECT $1, $2, $3, $4, $5, $6
+		 WHERE (SELECT COUNT(*) FROM passkeys WHERE account_id = $1) < $7
+		 RETURNING id, created_at`,
+		user.ID, cred.ID, cred.PublicKey, int64(cred.SignCount), transports(req.Response.Transports), p.Name,
+		maxPasskeysPerAccount,
+	).Scan(&p.ID, &p.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict("You already have "+strconv.Itoa(maxPasskeysPerAccount)+" passkeys; remove one first"))
+		return
+	}
+	if err != nil && strings.Contains(err.Error(), "duplicate key") {
+		apierror.Write(w, apierror.Conflict("This passkey is already registered"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save passkey of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save passkey"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// ListPasskeysHandler lists the caller's passkeys.
+func ListPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(),
+		"SELECT id, name, created_at, last_used_at FROM passkeys WHERE account_id = $1 ORDER BY created_at, id",
+		user.ID)
+	if err != nil {
+		log.Printf("Failed to list passkeys: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to list passkeys"))
+		return
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		var p Passkey
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			log.Printf("Failed to read passkey: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to list passkeys"))
+			return
+		}
+		passkeys = append(passkeys, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(passkeys)
+}
+
+// DeletePasskeyHandler removes one of the caller's passkeys. It can no
+// longer sign in; the authenticator keeps it until removed there too.
+func DeletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		apierror.Write(w, apierror.BadRequest("Invalid passkey ID"))
+		return
+	}
+
+	res, err := db.DB.ExecContext(r.Context(), "DELETE FROM passkeys WHERE id = $1 AND account_id = $2", id, user.ID)
+	if err != nil {
+		log.Printf("Failed to delete passkey %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to delete passkey"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Passkey not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PasskeyLoginOptionsHandler starts signing in with a passkey: it answers
+// with the options for navigator.credentials.get. With a username it
+// offers that account's passkeys; whether the account exists is not
+// revealed, as unknown usernames get the options for any passkey.
+func PasskeyLoginOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if RelyingParty == nil {
+		apierror.Write(w, errPasskeysNotConfigured())
+		return
+	}
+	var req PasskeyOptionsRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.Write(w, apierror.DecodeError(err))
+			return
+		}
+	}
+
+	var allow []webauthn.Descriptor
+	if username := strings.TrimSpace(req.Username); username != "" {
+		var accountID int
+		err := db.DB.QueryRowContext(r.Context(),
+			"SELECT id FROM accounts WHERE LOWER(username) = LOWER($1)", username).Scan(&accountID)
+		if err == nil {
+			allow, err = descriptors(r, accountID)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to list passkeys of %q: %v", username, err)
+			apierror.Write(w, apierror.Internal("Failed to start passkey sign-in"))
+			return
+		}
+	}
+	challenge, err := issueChallenge(r.Context(), ceremonyLogin, 0)
+	if err != nil {
+		log.Printf("Failed to issue passkey challenge: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to start passkey sign-in"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RelyingParty.RequestOptions(challenge, allow))
+}
+
+// PasskeyLoginHandler verifies the authenticator's answer to the sign-in
+// options and signs in the passkey's account, with no password. It
+// answers like LoginAPIHandler.
+func PasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	setJSONContentType(w)
+	if RelyingParty == nil {
+		writeLoginError(w, errPasskeysNotConfigured())
+		return
+	}
+	var req PasskeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeLoginError(w, apierror.DecodeError(err))
+		return
+	}
+	_, challenge, ok, err := takeChallenge(r.Context(), ceremonyLogin, req.Response.ClientDataJSON)
+	if err != nil {
+		log.Printf("Failed to look up passkey challenge: %v", err)
+		writeLoginError(w, apierror.Internal("login failed - please try again"))
+		return
+	}
+	if !ok {
+		writeLoginError(w, apierror.BadRequest("the passkey sign-in expired - please try again"))
+		return
+	}
+
+	var passkeyID int
+	var signCount int64
+	var user User
+	cred := webauthn.Credential{ID: req.ID}
+	err = db.DB.QueryRowContext(r.Context(),
+		`SELECT p.id, p.public_key, p.sign_count, a.id, a.username, a.role, COALESCE(a.locale, '')
+		 FROM passkeys p JOIN accounts a ON a.id = p.account_id
+		 WHERE p.credential_id = $1`,
+		[]byte(req.ID),
+	).Scan(&passkeyID, &cred.PublicKey, &signCount, &user.ID, &user.Username, &user.Role, &user.Locale)
+	if errors.Is(err, sql.ErrNoRows) {
+		refusePasskey(w, r, "", "unknown credential")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up passkey: %v", err)
+		writeLoginError(w, apierror.Internal("login failed - please try again"))
+		return
+	}
+	if len(req.Response.UserHandle) > 0 && string(req.Response.UserHandle) != string(userHandle(user.ID)) {
+		refusePasskey(w, r, user.Username, "user handle does not match")
+		return
+	}
+	cred.SignCount = uint32(signCount)
+	newCount, err := RelyingParty.VerifyAssertion(challenge, cred, req.Response)
+	if err != nil {
+		refusePasskey(w, r, user.Username, err.Error())
+		return
+	}
+
+	// Only move the counter on from the value checked, so two sign-ins
+	// with one counter value cannot both succeed.
+	res, err := db.DB.ExecContext(r.Context(),
+		"UPDATE passkeys SET sign_count = $1, last_used_at = CURRENT_TIMESTAMP WHERE id = $2 AND sign_count = $3",
+		int64(newCount), passkeyID, signCount)
+	if err != nil {
+		log.Printf("Failed to record use of passkey %d: %v", passkeyID, err)
+		writeLoginError(w, apierror.Internal("login failed - please try again"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		refusePasskey(w, r, user.Username, "counter changed during sign-in")
+		return
+	}
+	signIn(w, r, &user, req.RedirectToken)
+}
+
+// refusePasskey answers a passkey sign-in that failed verification, and
+// counts it as a failed sign-in of username, if known.
+func refusePasskey(w http.ResponseWriter, r *http.Request, username, reason string) {
+	log.Printf("Passkey sign-in refused: %s", reason)
+	if OnLoginFailure != nil {
+		OnLoginFailure(r, username)
+	}
+	writeLoginError(w, apierror.New(apierror.CodeUnauthorized, "passkey not recognized - please try again or sign in with your password"))
+}
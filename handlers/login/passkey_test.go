@@ -0,0 +1,211 @@
+package login
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"allanswebterminal/securecookie"
+	"allanswebterminal/webauthn"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func usePasskeys(t *testing.T) {
+	t.Helper()
+	original := RelyingParty
+	RelyingParty = &webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+	t.Cleanup(func() { RelyingParty = original })
+}
+
+// testPasskey is a software authenticator holding one P-256 passkey.
+type testPasskey struct {
+	key *ecdsa.PrivateKey
+}
+
+func newTestPasskey(t *testing.T) *testPasskey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testPasskey{key: key}
+}
+
+// publicKey returns the passkey's COSE key: a map of kty 2, alg -7, crv 1
+// and the coordinates.
+func (p *testPasskey) publicKey() []byte {
+	cose := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	cose = append(cose, p.key.X.FillBytes(make([]byte, 32))...)
+	cose = append(cose, 0x22, 0x58, 0x20)
+	return append(cose, p.key.Y.FillBytes(make([]byte, 32))...)
+}
+
+// assert answers the sign-in options in options with the given counter.
+func (p *testPasskey) assert(t *testing.T, options []byte, signCount uint32, userHandle string) webauthn.AssertionResponse {
+	var opts webauthn.RequestOptions
+	if err := json.Unmarshal(options, &opts); err != nil {
+		t.Fatal(err)
+	}
+	clientData, _ := json.Marshal(map[string]string{
+		"type":      "webauthn.get",
+		"challenge": base64.RawURLEncoding.EncodeToString(opts.Challenge),
+		"origin":    "https://example.com",
+	})
+	rpIDHash := sha256.Sum256([]byte(opts.RPID))
+	authData := binary.BigEndian.AppendUint32(append(rpIDHash[:], 0x05), signCount)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	sig, _ := ecdsa.SignASN1(rand.Reader, p.key, digest[:])
+	return webauthn.AssertionResponse{ClientDataJSON: clientData, AuthenticatorData: authData, Signature: sig, UserHandle: []byte(userHandle)}
+}
+
+// startPasskeyLogin asks for sign-in options, which issue a challenge.
+func startPasskeyLogin(t *testing.T, mock sqlmock.Sqlmock) []byte {
+	t.Helper()
+	mock.ExpectExec("INSERT INTO passkey_challenges").
+		WithArgs(sqlmock.AnyArg(), ceremonyLogin, nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	rr := httptest.NewRecorder()
+	PasskeyLoginOptionsHandler(rr, httptest.NewRequest("POST", "/api/passkeys/login/options", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("options status = %d: %s", rr.Code, rr.Body)
+	}
+	return rr.Body.Bytes()
+}
+
+// expectChallenge expects the challenge in options to be taken, once.
+func expectChallenge(mock sqlmock.Sqlmock, options []byte, found bool) {
+	var opts webauthn.RequestOptions
+	json.Unmarshal(options, &opts)
+	rows := sqlmock.NewRows([]string{"account_id"})
+	if found {
+		rows.AddRow(0)
+	}
+	mock.ExpectQuery("DELETE FROM passkey_challenges").WithArgs([]byte(opts.Challenge), ceremonyLogin).WillReturnRows(rows)
+}
+
+func passkeyLogin(id string, resp webauthn.AssertionResponse) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(PasskeyLoginRequest{ID: webauthn.Bytes(id), Response: resp})
+	rr := httptest.NewRecorder()
+	PasskeyLoginHandler(rr, httptest.NewRequest("POST", "/api/passkeys/login", bytes.NewReader(body)))
+	return rr
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	usePasskeys(t)
+	mock := setupMockDB(t)
+	passkey := newTestPasskey(t)
+	passkeyRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "public_key", "sign_count", "account_id", "username", "role", "locale"}).
+			AddRow(3, passkey.publicKey(), 4, 7, "ana", "user", "")
+	}
+
+	options := startPasskeyLogin(t, mock)
+	expectChallenge(mock, options, true)
+	mock.ExpectQuery("SELECT p.id, p.public_key").WithArgs([]byte("cred-1")).WillReturnRows(passkeyRow())
+	mock.ExpectExec("UPDATE passkeys SET sign_count").WithArgs(int64(5), 3, int64(4)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM account_suspensions").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	rr := passkeyLogin("cred-1", passkey.assert(t, options, 5, "7"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rr.Code, rr.Body)
+	}
+	var session *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "user_id" {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatalf("no session cookie in %+v", rr.Result().Cookies())
+	}
+	if id, err := securecookie.Decode("user_id", session.Value); err != nil || id != "7" {
+		t.Errorf("session for %q, %v", id, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// The challenge is used up.
+	expectChallenge(mock, options, false)
+	if rr := passkeyLogin("cred-1", passkey.assert(t, options, 6, "7")); rr.Code != http.StatusBadRequest {
+		t.Errorf("reused challenge: status %d", rr.Code)
+	}
+}
+
+func TestPasskeyLoginRefused(t *testing.T) {
+	usePasskeys(t)
+	mock := setupMockDB(t)
+	passkey := newTestPasskey(t)
+	passkeyRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "public_key", "sign_count", "account_id", "username", "role", "locale"}).
+			AddRow(3, passkey.publicKey(), 4, 7, "ana", "user", "")
+	}
+	var failures []string
+	OnLoginFailure = func(r *http.Request, username string) { failures = append(failures, username) }
+	t.Cleanup(func() { OnLoginFailure = nil })
+
+	options := startPasskeyLogin(t, mock)
+	expectChallenge(mock, options, true)
+	mock.ExpectQuery("SELECT p.id, p.public_key").WillReturnRows(sqlmock.NewRows(nil))
+	if rr := passkeyLogin("unknown", passkey.assert(t, options, 5, "7")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("unknown credential: status %d", rr.Code)
+	}
+
+	options = startPasskeyLogin(t, mock)
+	expectChallenge(mock, options, true)
+	mock.ExpectQuery("SELECT p.id, p.public_key").WillReturnRows(passkeyRow())
+	if rr := passkeyLogin("cred-1", passkey.assert(t, options, 5, "8")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other account's user handle: status %d", rr.Code)
+	}
+
+	options = startPasskeyLogin(t, mock)
+	expectChallenge(mock, options, true)
+	mock.ExpectQuery("SELECT p.id, p.public_key").WillReturnRows(passkeyRow())
+	if rr := passkeyLogin("cred-1", passkey.assert(t, options, 4, "7")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("counter not advanced: status %d", rr.Code)
+	}
+
+	options = startPasskeyLogin(t, mock)
+	expectChallenge(mock, options, true)
+	mock.ExpectQuery("SELECT p.id, p.public_key").WillReturnRows(passkeyRow())
+	other := newTestPasskey(t)
+	if rr := passkeyLogin("cred-1", other.assert(t, options, 5, "7")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other key's signature: status %d", rr.Code)
+	}
+
+	expectChallenge(mock, options, false)
+	if rr := passkeyLogin("cred-1", passkey.assert(t, options, 5, "7")); rr.Code != http.StatusBadRequest {
+		t.Errorf("challenge not issued: status %d", rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(failures) != 4 || failures[0] != "" || failures[1] != "ana" {
+		t.Errorf("failures recorded = %q", failures)
+	}
+}
+
+func TestPasskeyRegistrationNeedsAccount(t *testing.T) {
+	usePasskeys(t)
+	rr := httptest.NewRecorder()
+	PasskeyRegistrationOptionsHandler(rr, httptest.NewRequest("POST", "/api/passkeys/register/options", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("signed out: status %d", rr.Code)
+	}
+
+	RelyingParty = nil
+	rr = httptest.NewRecorder()
+	PasskeyLoginOptionsHandler(rr, httptest.NewRequest("POST", "/api/passkeys/login/options", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("not configured: status %d", rr.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package login
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AllowedRedirectHosts are the hosts, lowercase, that sign-in may send the
+// browser to over https. Every other redirect must be a path on this site.
+var AllowedRedirectHosts = map[string]bool{}
+
+// redirectTokenTTL is how long a signed redirect token is honoured.
+const redirectTokenTTL = time.Hour
+
+var (
+	redirectKeyMu sync.RWMutex
+	redirectKey   = randomRedirectKey()
+)
+
+func randomRedirectKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// SetRedirectKey replaces the key that signs redirect tokens. Without one
+// from configuration each instance signs with a random key, and tokens do
+// not survive a restart or reach another instance.
+func SetRedirectKey(key []byte) {
+	redirectKeyMu.Lock()
+	redirectKey = key
+	redirectKeyMu.Unlock()
+}
+
+// SafeRedirect returns target if sign-in may redirect to it, or "". A
+// target is a path on this site, such as /flashcards?deck=2, or an https
+// URL on one of AllowedRedirectHosts. A bare name such as "flashcards" is
+// taken as the path /flashcards. Protocol-relative URLs ("//evil.example"),
+// backslashes and control characters are refused, since browsers read them
+// as other hosts.
+func SafeRedirect(target string) string {
+	target = strings.TrimSpace(target)
+	if target == "" || strings.Contains(target, "\\") {
+		return ""
+	}
+	for _, c := range target {
+		if c < 0x20 || c == 0x7f {
+			return ""
+		}
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	if u.Scheme == "" && u.Host == "" && u.Opaque == "" {
+		if !strings.HasPrefix(target, "/") {
+			target = "/" + target
+		}
+		if strings.HasPrefix(target, "//") {
+			return ""
+		}
+		return target
+	}
+	if u.Scheme == "https" && u.User == nil && AllowedRedirectHosts[strings.ToLower(u.Hostname())] {
+		return u.String()
+	}
+	return ""
+}
+
+// RedirectToken signs target, which must pass SafeRedirect, so the login
+// form can carry it through the browser and back unaltered.
+func RedirectToken(target string) string {
+	expires := strconv.FormatInt(time.Now().Add(redirectTokenTTL).Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(target))
+	return encoded + "." + expires + "." + signRedirect(encoded+"."+expires)
+}
+
+// ParseRedirectToken returns the target of a token from RedirectToken, if
+// it is intact, has not expired and is still a safe redirect.
+func ParseRedirectToken(token string) (string, bool) {
+	encoded, rest, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+	expires, sig, ok := strings.Cut(rest, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signRedirect(encoded+"."+expires))) {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return "", false
+	}
+	target, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	safe := SafeRedirect(string(target))
+	return safe, safe != ""
+}
+
+// LoginURL is the login page that returns to target after sign-in.
+func LoginURL(target string) string {
+	if target = SafeRedirect(target); target == "" {
+		return "/login"
+	}
+	return "/login?redirect_token=" + url.QueryEscape(RedirectToken(target))
+}
+
+func signRedirect(payload string) string {
+	redirectKeyMu.RLock()
+	mac := hmac.New(sha256.New, redirectKey)
+	redirectKeyMu.RUnlock()
+	mac.Write([]byte("redirect:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
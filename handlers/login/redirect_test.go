@@ -0,0 +1,86 @@
+package login
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSafeRedirect(t *testing.T) {
+	AllowedRedirectHosts["docs.example.com"] = true
+	t.Cleanup(func() { delete(AllowedRedirectHosts, "docs.example.com") })
+
+	tests := map[string]string{
+		"/flashcards":                      "/flashcards",
+		"/preview/s1/?tab=2#top":           "/preview/s1/?tab=2#top",
+		"flashcards":                       "/flashcards",
+		" /projects ":                      "/projects",
+		"https://docs.example.com/guide":   "https://docs.example.com/guide",
+		"https://DOCS.example.com/guide":   "https://DOCS.example.com/guide",
+		"":                                 "",
+		"//evil.example":                   "",
+		"///evil.example":                  "",
+		"/\\evil.example":                  "",
+		"\\\\evil.example":                 "",
+		"https://evil.example/":            "",
+		"http://docs.example.com/guide":    "",
+		"https://user@docs.example.com/":   "",
+		"https://docs.example.com.evil.io": "",
+		"javascript:alert(1)":              "",
+		"/path\r\nSet-Cookie: x=1":         "",
+		"/tab\there":                       "",
+	}
+	for in, want := range tests {
+		if got := SafeRedirect(in); got != want {
+			t.Errorf("SafeRedirect(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRedirectToken(t *testing.T) {
+	token := RedirectToken("/preview/s1/?x=1")
+	if got, ok := ParseRedirectToken(token); !ok || got != "/preview/s1/?x=1" {
+		t.Errorf("ParseRedirectToken = %q, %v", got, ok)
+	}
+
+	encoded, rest, _ := strings.Cut(token, ".")
+	expires, sig, _ := strings.Cut(rest, ".")
+	evil := base64.RawURLEncoding.EncodeToString([]byte("/admin"))
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	unsafe := base64.RawURLEncoding.EncodeToString([]byte("//evil.example"))
+	for name, bad := range map[string]string{
+		"empty":          "",
+		"altered target": evil + "." + expires + "." + sig,
+		"altered expiry": encoded + ".9999999999." + sig,
+		"expired":        encoded + "." + past + "." + signRedirect(encoded+"."+past),
+		"unsafe target":  unsafe + "." + expires + "." + signRedirect(unsafe+"."+expires),
+		"missing parts":  encoded + "." + sig,
+	} {
+		if got, ok := ParseRedirectToken(bad); ok {
+			t.Errorf("%s: ParseRedirectToken = %q, want refused", name, got)
+		}
+	}
+
+	old := redirectKey
+	SetRedirectKey(randomRedirectKey())
+	t.Cleanup(func() { SetRedirectKey(old) })
+	if _, ok := ParseRedirectToken(token); ok {
+		t.Error("a token signed with another key was accepted")
+	}
+}
+
+func TestLoginURL(t *testing.T) {
+	if got := LoginURL("//evil.example"); got != "/login" {
+		t.Errorf("LoginURL of an unsafe target = %q, want /login", got)
+	}
+	u, err := url.Parse(LoginURL("/preview/s1/"))
+	if err != nil || u.Path != "/login" {
+		t.Fatalf("LoginURL = %v, %v", u, err)
+	}
+	if got, ok := ParseRedirectToken(u.Query().Get("redirect_token")); !ok || got != "/preview/s1/" {
+		t.Errorf("LoginURL token = %q, %v", got, ok)
+	}
+}
@@ -0,0 +1,178 @@
+package login
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cache"
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+)
+
+// AppealPath is where a suspended account can ask for the suspension to be
+// reviewed. It authenticates with username and password, since the session
+// is gone.
+const AppealPath = "/api/messages/appeal"
+
+// suspensionCacheTTL bounds how long an instance without a shared cache
+// keeps serving an account after it is suspended or lifted.
+const suspensionCacheTTL = 30 * time.Second
+
+// suspensionExempt lists API paths a suspended browser may still call; it is
+// signed out for them instead of refused.
+var suspensionExempt = []string{"/api/login", "/api/passkeys/login/options", "/api/passkeys/login", "/api/check-username", AppealPath}
+
+// Suspension is a period during which an account cannot sign in and its
+// public content is hidden. It ends when it expires or an admin lifts it.
+type Suspension struct {
+	ID          int    `json:"id"`
+	AccountID   int    `json:"account_id"`
+	Username    string `json:"username,omitempty"`
+	Reason      string `json:"reason"`
+	SuspendedBy string `json:"suspended_by,omitempty"`
+	// ExpiresAt is nil for bans, which last until lifted.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	LiftedAt  *time.Time `json:"lifted_at,omitempty"`
+	// AppealMessageID is the account's appeal in the messages table.
+	AppealMessageID *int   `json:"appeal_message_id,omitempty"`
+	Appeal          string `json:"appeal,omitempty"`
+}
+
+// SuspensionDetails are the details of an account_suspended error.
+type SuspensionDetails struct {
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Appealed  bool       `json:"appealed"`
+	// AppealURL is where to POST an appeal while none was sent.
+	AppealURL string `json:"appeal_url,omitempty"`
+}
+
+// SuspensionInForce is the SQL condition that the account_suspensions row
+// aliased sus is in force.
+const SuspensionInForce = "sus.lifted_at IS NULL AND (sus.expires_at IS NULL OR sus.expires_at > CURRENT_TIMESTAMP)"
+
+// NotSuspended returns a SQL condition that holds when the account in column
+// has no suspension in force. Queries showing an account's content to others
+// add it so suspended accounts' content is hidden.
+func NotSuspended(column string) string {
+	return fmt.Sprintf("NOT EXISTS (SELECT 1 FROM account_suspensions sus WHERE sus.account_id = %s AND %s)", column, SuspensionInForce)
+}
+
+// SuspensionCacheKey is the cache key of accountID's suspension state, to be
+// invalidated whenever it changes.
+func SuspensionCacheKey(accountID int) string {
+	return "suspension:" + strconv.Itoa(accountID)
+}
+
+// ActiveSuspension returns the suspension in force for accountID, or nil.
+func ActiveSuspension(ctx context.Context, accountID int) (*Suspension, error) {
+	s := Suspension{AccountID: accountID}
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT sus.id, sus.reason, sus.expires_at, sus.created_at, sus.appeal_message_id
+		 FROM account_suspensions sus
+		 WHERE sus.account_id = $1 AND `+SuspensionInForce+`
+		 ORDER BY sus.created_at DESC LIMIT 1`,
+		accountID,
+	).Scan(&s.ID, &s.Reason, &s.ExpiresAt, &s.CreatedAt, &s.AppealMessageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Err is the account_suspended error returned to the suspended account.
+func (s *Suspension) Err() *apierror.Error {
+	details := SuspensionDetails{Reason: s.Reason, ExpiresAt: s.ExpiresAt, Appealed: s.AppealMessageID != nil}
+	if !details.Appealed {
+		details.AppealURL = AppealPath
+	}
+	message := "This account is suspended"
+	if s.ExpiresAt == nil {
+		message = "This account is banned"
+	}
+	return apierror.New(apierror.CodeAccountSuspended, message).WithDetails(details)
+}
+
+// cachedSuspension is ActiveSuspension behind the shared cache, so the
+// middleware does not query the database on every request. Failures are
+// logged and treated as not suspended: a database outage must not sign out
+// everyone.
+func cachedSuspension(ctx context.Context, accountID int) *Suspension {
+	if !db.Available() {
+		return nil
+	}
+	s, err := cache.GetOrLoad(ctx, SuspensionCacheKey(accountID), suspensionCacheTTL, func() (*Suspension, error) {
+		return ActiveSuspension(ctx, accountID)
+	})
+	if err != nil {
+		log.Printf("Failed to check suspension of account %d: %v", accountID, err)
+		return nil
+	}
+	return s
+}
+
+// EnforceSuspensions revokes the sessions of suspended accounts. Sessions
+// are only a cookie, so every request carrying one is checked: the cookie is
+// cleared and the request goes on signed out. API calls other than
+// suspensionExempt are refused with account_suspended instead, so clients
+// can tell the user why.
+func EnforceSuspensions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value, ok := securecookie.Value(r, "user_id")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		accountID, err := strconv.Atoi(value)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s := cachedSuspension(r.Context(), accountID)
+		if s == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		clearSessionCookie(w)
+		if strings.HasPrefix(r.URL.Path, "/api/") && !isSuspensionExempt(r.URL.Path) {
+			apierror.Write(w, s.Err())
+			return
+		}
+		next.ServeHTTP(w, withoutSession(r))
+	})
+}
+
+func isSuspensionExempt(path string) bool {
+	for _, exempt := range suspensionExempt {
+		if path == exempt {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutSession returns a copy of r with the session cookie removed and
+// every other cookie kept.
+func withoutSession(r *http.Request) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.Header.Del("Cookie")
+	for _, c := range r.Cookies() {
+		if c.Name != "user_id" {
+			r2.AddCookie(c)
+		}
+	}
+	return r2
+}
@@ -0,0 +1,121 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectSuspension(mock sqlmock.Sqlmock, accountID int, expiresAt *time.Time) {
+	mock.ExpectQuery("FROM account_suspensions sus").WithArgs(accountID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "reason", "expires_at", "created_at", "appeal_message_id"}).
+			AddRow(5, "spam", expiresAt, time.Now(), nil))
+}
+
+func TestEnforceSuspensions(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"API refused", "/api/files/list", http.StatusForbidden},
+		{"Appeal signed out", AppealPath, http.StatusOK},
+		{"Page signed out", "/projects", http.StatusOK},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Distinct accounts keep cached results from leaking between cases.
+			accountID := 9100 + i
+			mock := setupMockDB(t)
+			expectSuspension(mock, accountID, nil)
+
+			var sawSession, sawLocale bool
+			handler := EnforceSuspensions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, err := r.Cookie("user_id")
+				sawSession = err == nil
+				_, err = r.Cookie("lang")
+				sawLocale = err == nil
+			}))
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.AddCookie(securecookie.Cookie("user_id", strconv.Itoa(accountID)))
+			req.AddCookie(&http.Cookie{Name: "lang", Value: "es"})
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if sawSession {
+				t.Error("handler saw the suspended session")
+			}
+			if tt.wantStatus == http.StatusOK && !sawLocale {
+				t.Error("other cookies were dropped")
+			}
+			if set := rr.Header().Get("Set-Cookie"); !strings.HasPrefix(set, "user_id=;") {
+				t.Errorf("Set-Cookie = %q, want the session cleared", set)
+			}
+			if tt.wantStatus == http.StatusForbidden &&
+				(!strings.Contains(rr.Body.String(), `"code":"account_suspended"`) || !strings.Contains(rr.Body.String(), `"reason":"spam"`)) {
+				t.Errorf("body = %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestEnforceSuspensionsPassesActiveAccounts(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM account_suspensions sus").WithArgs(9200).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "reason", "expires_at", "created_at", "appeal_message_id"}))
+
+	var sawSession bool
+	handler := EnforceSuspensions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := r.Cookie("user_id")
+		sawSession = err == nil
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/files/list", nil)
+	req.AddCookie(securecookie.Cookie("user_id", "9200"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !sawSession || rr.Header().Get("Set-Cookie") != "" {
+		t.Errorf("status = %d, session = %v, Set-Cookie = %q", rr.Code, sawSession, rr.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestSuspensionErr(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+	appeal := 3
+	tests := []struct {
+		name        string
+		s           Suspension
+		wantMessage string
+		wantURL     string
+	}{
+		{"Suspended", Suspension{Reason: "spam", ExpiresAt: &expires}, "This account is suspended", AppealPath},
+		{"Banned", Suspension{Reason: "spam"}, "This account is banned", AppealPath},
+		{"Appealed", Suspension{Reason: "spam", AppealMessageID: &appeal}, "This account is banned", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.s.Err()
+			details := err.Details.(SuspensionDetails)
+			if err.Message != tt.wantMessage || err.Status() != http.StatusForbidden || details.AppealURL != tt.wantURL {
+				t.Errorf("Err() = %+v, details %+v", err, details)
+			}
+		})
+	}
+}
+
+func TestNotSuspended(t *testing.T) {
+	got := NotSuspended("l.account_id")
+	if !strings.Contains(got, "sus.account_id = l.account_id AND "+SuspensionInForce) {
+		t.Errorf("NotSuspended = %q", got)
+	}
+}
@@ -0,0 +1,96 @@
+package login
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UsernameRules decide which usernames new accounts may take. They apply
+// when registering and when a guest account registers; existing accounts
+// keep their usernames. Usernames are unique regardless of case.
+type UsernameRules struct {
+	MinLength int
+	MaxLength int
+	// Punctuation lists the characters allowed besides ASCII letters and
+	// digits. Usernames start and end with a letter or digit.
+	Punctuation string
+	// Reserved holds lowercased names nobody may register, in any case.
+	Reserved map[string]bool
+}
+
+// maxUsernameColumn is the width of accounts.username.
+const maxUsernameColumn = 50
+
+// guestPrefix starts the names of guest accounts, so registered accounts
+// cannot pass for one.
+const guestPrefix = "guest-"
+
+// DefaultReservedUsernames are names that would look official or clash
+// with a route.
+var DefaultReservedUsernames = []string{
+	"admin", "administrator", "root", "system", "support", "help", "security",
+	"moderator", "staff", "official", "api", "static", "assets", "login",
+	"logout", "register", "account", "settings", "guest", "anonymous",
+	"null", "undefined", "me", "projects", "flashcards", "playground",
+	"cloudsimulator", "replay", "preview", "www", "mail", "postmaster", "webmaster", "noreply",
+}
+
+// UsernamePolicy is enforced on new usernames. main adjusts it from the
+// environment.
+var UsernamePolicy = UsernameRules{
+	MinLength:   3,
+	MaxLength:   30,
+	Punctuation: "_-.",
+	Reserved:    ReservedSet(DefaultReservedUsernames),
+}
+
+// ReservedSet returns names lowercased as a set for UsernameRules.Reserved.
+func ReservedSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name = NormalizeUsername(name); name != "" {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+// NormalizeUsername returns the form usernames are compared in.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// Check reports why username may not be registered, or nil.
+func (p UsernameRules) Check(username string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("please enter your username")
+	}
+	maxLength := p.MaxLength
+	if maxLength <= 0 || maxLength > maxUsernameColumn {
+		maxLength = maxUsernameColumn
+	}
+	if len(username) < p.MinLength || len(username) > maxLength {
+		return fmt.Errorf("username must be between %d and %d characters long", p.MinLength, maxLength)
+	}
+	for _, r := range username {
+		if !isASCIIAlnum(r) && !strings.ContainsRune(p.Punctuation, r) {
+			if p.Punctuation == "" {
+				return fmt.Errorf("username may only contain letters and digits")
+			}
+			return fmt.Errorf("username may only contain letters, digits and %s", strings.Join(strings.Split(p.Punctuation, ""), " "))
+		}
+	}
+	if !isASCIIAlnum(rune(username[0])) || !isASCIIAlnum(rune(username[len(username)-1])) {
+		return fmt.Errorf("username must start and end with a letter or digit")
+	}
+	normalized := NormalizeUsername(username)
+	if p.Reserved[normalized] || strings.HasPrefix(normalized, guestPrefix) {
+		return fmt.Errorf("this username is reserved - please choose a different username")
+	}
+	return nil
+}
+
+func isASCIIAlnum(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
@@ -0,0 +1,77 @@
+package login
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUsernamePolicyCheck(t *testing.T) {
+	tests := []struct {
+		username string
+		wantErr  string
+	}{
+		{"ana", ""},
+		{"Ana_Silva-2.0", ""},
+		{"  padded  ", ""},
+		{"", "please enter your username"},
+		{"ab", "between 3 and 30"},
+		{strings.Repeat("a", 31), "between 3 and 30"},
+		{"ana silva", "may only contain letters, digits and _ - ."},
+		{"anã", "may only contain"},
+		{"<script>", "may only contain"},
+		{"_ana", "start and end with a letter or digit"},
+		{"ana.", "start and end with a letter or digit"},
+		{"admin", "reserved"},
+		{"ADMIN", "reserved"},
+		{"Static", "reserved"},
+		{"guest-1a2b3c4d", "reserved"},
+	}
+	for _, tt := range tests {
+		err := UsernamePolicy.Check(tt.username)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("Check(%q) = %v, want nil", tt.username, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Check(%q) = %v, want error containing %q", tt.username, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUsernameRulesConfigured(t *testing.T) {
+	rules := UsernameRules{MinLength: 1, MaxLength: 500, Reserved: ReservedSet([]string{" Teacher ", ""})}
+	if err := rules.Check("a_b"); err == nil || !strings.Contains(err.Error(), "only contain letters and digits") {
+		t.Errorf("Check(%q) = %v, want a charset error", "a_b", err)
+	}
+	if err := rules.Check("teacher"); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Errorf("Check(%q) = %v, want reserved", "teacher", err)
+	}
+	// The column holds 50 characters whatever the configured maximum.
+	if err := rules.Check(strings.Repeat("a", 51)); err == nil || !strings.Contains(err.Error(), "between 1 and 50") {
+		t.Errorf("Check(51 characters) = %v, want a length error", err)
+	}
+}
+
+func TestNewCheckUsernameResponse(t *testing.T) {
+	tests := []struct {
+		username   string
+		exists     bool
+		available  bool
+		wantReason string
+	}{
+		{"newuser", false, true, ""},
+		{"newuser", true, false, "already exists"},
+		// Accounts older than the policy still exist, and are not refused.
+		{"admin", true, false, "already exists"},
+		{"admin", false, false, "reserved"},
+		{"a b", false, false, "may only contain"},
+	}
+	for _, tt := range tests {
+		got := newCheckUsernameResponse(tt.username, tt.exists)
+		if got.Exists != tt.exists || got.Available != tt.available || !strings.Contains(got.Reason, tt.wantReason) {
+			t.Errorf("newCheckUsernameResponse(%q, %t) = %+v", tt.username, tt.exists, got)
+		}
+	}
+}
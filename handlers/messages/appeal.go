@@ -0,0 +1,118 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/sanitize"
+	"allanswebterminal/validate"
+)
+
+// KindAppeal marks messages sent by suspended accounts asking for review;
+// the contact form's messages have kind 'contact'.
+const KindAppeal = "appeal"
+
+// errAlreadyAppealed is returned by saveAppeal when another request linked
+// an appeal to the suspension first.
+var errAlreadyAppealed = errors.New("suspension already appealed")
+
+// AppealRequest carries the account's credentials, since a suspended
+// account has no session.
+type AppealRequest struct {
+	Username string `json:"username" validate:"required" message:"username and password are required"`
+	Password string `json:"password" validate:"required" message:"username and password are required"`
+	Email    string `json:"email" validate:"required,max=255,email"`
+	Message  string `json:"message" validate:"required,max=10000"`
+}
+
+func (req *AppealRequest) Validate() error {
+	req.Email = strings.TrimSpace(sanitize.Text(req.Email))
+	req.Message = strings.TrimSpace(sanitize.HTML(req.Message))
+	return validate.Struct(req)
+}
+
+// AppealHandler records a suspended account's appeal as a message for the
+// admins and links it to the suspension. Each suspension takes one appeal.
+func AppealHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req AppealRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		apierror.Write(w, validate.APIError(err))
+		return
+	}
+
+	user, err := login.Authenticate(req.Username, req.Password)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	suspension, err := login.ActiveSuspension(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to check suspension of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save appeal"))
+		return
+	}
+	if suspension == nil {
+		apierror.Write(w, apierror.Conflict("Account is not suspended"))
+		return
+	}
+	if suspension.AppealMessageID != nil {
+		apierror.Write(w, apierror.Conflict("This suspension was already appealed"))
+		return
+	}
+
+	id, err := saveAppeal(r.Context(), suspension.ID, user, &req)
+	if errors.Is(err, errAlreadyAppealed) {
+		apierror.Write(w, apierror.Conflict("This suspension was already appealed"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save appeal of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save appeal"))
+		return
+	}
+	log.Printf("Appeal %d saved from %s", id, user.Username)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "message_id": id})
+}
+
+func saveAppeal(ctx context.Context, suspensionID int, user *login.User, req *AppealRequest) (int, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO messages (name, email, message, kind, account_id)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		user.Username, req.Email, req.Message, KindAppeal, user.ID,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx,
+		"UPDATE account_suspensions SET appeal_message_id = $1 WHERE id = $2 AND appeal_message_id IS NULL",
+		id, suspensionID)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, errAlreadyAppealed
+	}
+	return id, tx.Commit()
+}
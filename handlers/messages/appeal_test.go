@@ -0,0 +1,86 @@
+package messages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/passhash"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupAppealMock(t *testing.T, appealID interface{}) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+
+	hash, _ := passhash.Hash("secret")
+	mock.ExpectQuery("SELECT id, username, password, role").WithArgs("ana").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "password", "role", "locale"}).
+			AddRow(7, "ana", hash, "user", ""))
+	mock.ExpectQuery("FROM account_suspensions sus").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "reason", "expires_at", "created_at", "appeal_message_id"}).
+			AddRow(5, "spam", nil, time.Now(), appealID))
+	return mock
+}
+
+const appealBody = `{"username":"ana","password":"secret","email":"ana@example.com","message":"It was a mistake"}`
+
+func TestAppealHandler(t *testing.T) {
+	mock := setupAppealMock(t, nil)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO messages").
+		WithArgs("ana", "ana@example.com", "It was a mistake", KindAppeal, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec("UPDATE account_suspensions SET appeal_message_id").WithArgs(9, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+	AppealHandler(rr, httptest.NewRequest(http.MethodPost, "/api/messages/appeal", strings.NewReader(appealBody)))
+
+	if rr.Code != http.StatusCreated || !strings.Contains(rr.Body.String(), `"message_id":9`) {
+		t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAppealHandlerOnePerSuspension(t *testing.T) {
+	mock := setupAppealMock(t, 3)
+
+	rr := httptest.NewRecorder()
+	AppealHandler(rr, httptest.NewRequest(http.MethodPost, "/api/messages/appeal", strings.NewReader(appealBody)))
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAppealHandlerWrongPassword(t *testing.T) {
+	setupAppealMock(t, nil)
+
+	body := strings.Replace(appealBody, `"secret"`, `"guess"`, 1)
+	rr := httptest.NewRecorder()
+	AppealHandler(rr, httptest.NewRequest(http.MethodPost, "/api/messages/appeal", strings.NewReader(body)))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+}
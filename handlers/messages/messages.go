@@ -7,19 +7,22 @@ import (
 	"net/http"
 	"strings"
 
+	"allanswebterminal/apierror"
 	"allanswebterminal/db"
+	"allanswebterminal/sanitize"
+	"allanswebterminal/validate"
 )
 
 type MessageRequest struct {
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	Message string `json:"message"`
+	Name    string `json:"name" validate:"required,max=100"`
+	Email   string `json:"email" validate:"required,max=255,email"`
+	Message string `json:"message" validate:"required,max=10000"`
 }
 
 func setCORSHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key")
 	w.Header().Set("Content-Type", "application/json")
 }
 
@@ -31,17 +34,13 @@ func parseMessageRequest(r *http.Request) (*MessageRequest, error) {
 	return &msgReq, nil
 }
 
+// validateMessageRequest strips markup from the name and email and keeps
+// only safe formatting in the message before checking the fields.
 func validateMessageRequest(msgReq *MessageRequest) error {
-	if strings.TrimSpace(msgReq.Name) == "" {
-		return fmt.Errorf("name is required")
-	}
-	if strings.TrimSpace(msgReq.Email) == "" {
-		return fmt.Errorf("email is required")
-	}
-	if strings.TrimSpace(msgReq.Message) == "" {
-		return fmt.Errorf("message is required")
-	}
-	return nil
+	msgReq.Name = strings.TrimSpace(sanitize.Text(msgReq.Name))
+	msgReq.Email = strings.TrimSpace(sanitize.Text(msgReq.Email))
+	msgReq.Message = strings.TrimSpace(sanitize.HTML(msgReq.Message))
+	return validate.Struct(msgReq)
 }
 
 func saveMessageToDB(msgReq *MessageRequest) error {
@@ -60,33 +59,28 @@ func sendSuccessResponse(w http.ResponseWriter, msgReq *MessageRequest) error {
 }
 
 func MessagesHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	setCORSHeaders(w)
 
 	msgReq, err := parseMessageRequest(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, apierror.DecodeError(err))
 		return
 	}
 
 	if err := validateMessageRequest(msgReq); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Write(w, validate.APIError(err))
 		return
 	}
 
 	if err := saveMessageToDB(msgReq); err != nil {
 		log.Printf("Database error: %v", err)
-		http.Error(w, "Failed to save message", http.StatusInternalServerError)
+		apierror.Write(w, apierror.Internal("Failed to save message"))
 		return
 	}
 
 	if err := sendSuccessResponse(w, msgReq); err != nil {
 		log.Printf("Failed to send response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		apierror.Write(w, apierror.Internal("Internal server error"))
 		return
 	}
 }
\ No newline at end of file
@@ -65,6 +65,16 @@ func TestValidateMessageRequest(t *testing.T) {
 			wantErr: true,
 			errMsg:  "message is required",
 		},
+		{
+			name: "name that is only a script",
+			request: &MessageRequest{
+				Name:    "<script>alert(1)</script>",
+				Email:   "john@example.com",
+				Message: "Hello world",
+			},
+			wantErr: true,
+			errMsg:  "name is required",
+		},
 	}
 
 	for _, tt := range tests {
@@ -87,6 +97,24 @@ func TestValidateMessageRequest(t *testing.T) {
 	}
 }
 
+func TestValidateMessageRequestSanitizes(t *testing.T) {
+	req := &MessageRequest{
+		Name:    "<b>John</b>",
+		Email:   "john@example.com",
+		Message: `<p onclick="steal()">Hi <a href="javascript:alert(1)">there</a></p><script>alert(1)</script>`,
+	}
+	if err := validateMessageRequest(req); err != nil {
+		t.Fatalf("validateMessageRequest() unexpected error = %v", err)
+	}
+	if req.Name != "John" {
+		t.Errorf("Name = %q, want %q", req.Name, "John")
+	}
+	want := `<p>Hi <a rel="nofollow noopener noreferrer">there</a></p>`
+	if req.Message != want {
+		t.Errorf("Message = %q, want %q", req.Message, want)
+	}
+}
+
 func TestParseMessageRequest(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -150,7 +178,7 @@ func TestSetCORSHeaders(t *testing.T) {
 	expectedHeaders := map[string]string{
 		"Access-Control-Allow-Origin":  "*",
 		"Access-Control-Allow-Methods": "POST",
-		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Allow-Headers": "Content-Type, Idempotency-Key",
 		"Content-Type":                 "application/json",
 	}
 
@@ -161,17 +189,6 @@ func TestSetCORSHeaders(t *testing.T) {
 	}
 }
 
-func TestMessagesHandlerMethodNotAllowed(t *testing.T) {
-	req := httptest.NewRequest("GET", "/api/messages", nil)
-	w := httptest.NewRecorder()
-
-	MessagesHandler(w, req)
-
-	if w.Code != http.StatusMethodNotAllowed {
-		t.Errorf("MessagesHandler() status = %v, want %v", w.Code, http.StatusMethodNotAllowed)
-	}
-}
-
 func TestMessagesHandlerInvalidJSON(t *testing.T) {
 	req := httptest.NewRequest("POST", "/api/messages", strings.NewReader(`{"invalid": json}`))
 	req.Header.Set("Content-Type", "application/json")
@@ -205,4 +222,7 @@ func TestMessagesHandlerValidationError(t *testing.T) {
 	if !strings.Contains(w.Body.String(), "name is required") {
 		t.Errorf("MessagesHandler() body should contain validation error message")
 	}
+	if !strings.Contains(w.Body.String(), `"fields":[{"field":"name","rule":"required"`) {
+		t.Errorf("MessagesHandler() body should list the invalid field: %s", w.Body.String())
+	}
 }
\ No newline at end of file
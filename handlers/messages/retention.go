@@ -0,0 +1,33 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"allanswebterminal/db"
+)
+
+// RetentionDays is how many days messages are kept before PurgeExpired
+// deletes them; zero keeps them forever. Messages under legal hold are
+// always kept.
+var RetentionDays = 0
+
+// PurgeExpired deletes messages older than RetentionDays that are not
+// under legal hold.
+func PurgeExpired(ctx context.Context) error {
+	if RetentionDays <= 0 {
+		return nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -RetentionDays)
+	result, err := db.DB.ExecContext(ctx,
+		"DELETE FROM messages WHERE created_at < $1 AND NOT legal_hold", cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to purge expired messages: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Purged %d messages older than %d days", n, RetentionDays)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package messages
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// around matches a time within a minute of itself.
+type around time.Time
+
+func (a around) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Sub(time.Time(a)).Abs() < time.Minute
+}
+
+func TestPurgeExpired(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB = mockDB
+	defer func(days int) {
+		db.DB = originalDB
+		mockDB.Close()
+		RetentionDays = days
+	}(RetentionDays)
+
+	// Without a retention period nothing is deleted.
+	RetentionDays = 0
+	if err := PurgeExpired(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	RetentionDays = 30
+	mock.ExpectExec("DELETE FROM messages WHERE created_at < \\$1 AND NOT legal_hold").
+		WithArgs(around(time.Now().AddDate(0, 0, -30))).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	if err := PurgeExpired(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
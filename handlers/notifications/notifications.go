@@ -0,0 +1,251 @@
+// Package notifications stores in-app notifications for signed-in users and
+// pushes new ones over the realtime hub when it is available.
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/ws"
+
+	"github.com/lib/pq"
+)
+
+// Kinds produced by the rest of the application.
+const (
+	KindDeckShared      = "deck_shared"
+	KindDeckModerated   = "deck_moderated"
+	KindLabGraded       = "lab_graded"
+	KindJobFinished     = "job_finished"
+	KindAdminReply      = "admin_reply"
+	KindAlarm           = "alarm"
+	KindCourseInvite    = "course_invite"
+	KindCronFailed      = "cron_failed"
+	KindFileQuarantined = "file_quarantined"
+	KindIPBlocked       = "ip_blocked"
+)
+
+// EventType is the WebSocket event type used for pushed notifications.
+const EventType = "notification"
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type Notification struct {
+	ID        int        `json:"id"`
+	Kind      string     `json:"kind"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body,omitempty"`
+	Link      string     `json:"link,omitempty"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type ListResponse struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int            `json:"unread_count"`
+}
+
+type MarkReadRequest struct {
+	IDs []int `json:"ids"`
+	All bool  `json:"all"`
+}
+
+type MarkReadResponse struct {
+	Updated     int `json:"updated"`
+	UnreadCount int `json:"unread_count"`
+}
+
+// Publisher delivers an event to WebSocket subscribers; *ws.Hub satisfies it.
+type Publisher interface {
+	Publish(topic string, data interface{}) int
+}
+
+var (
+	publisherMu sync.RWMutex
+	publisher   Publisher
+)
+
+// SetPublisher enables live push of new notifications. Pass nil to disable.
+func SetPublisher(p Publisher) {
+	publisherMu.Lock()
+	defer publisherMu.Unlock()
+	publisher = p
+}
+
+// Notify stores a notification for accountID and pushes it to any open
+// sessions of that account. Callers typically log the error and carry on:
+// a missed notification should never fail the action that produced it.
+func Notify(ctx context.Context, accountID int, kind, title, body, link string) (*Notification, error) {
+	n := Notification{Kind: kind, Title: title, Body: body, Link: link}
+	err := db.DB.QueryRowContext(ctx,
+		`INSERT INTO notifications (account_id, kind, title, body, link)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		accountID, kind, title, body, link,
+	).Scan(&n.ID, &n.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save notification: %w", err)
+	}
+
+	publisherMu.RLock()
+	p := publisher
+	publisherMu.RUnlock()
+	if p != nil {
+		p.Publish(ws.AccountTopic(accountID), map[string]interface{}{
+			"type":         EventType,
+			"notification": n,
+		})
+	}
+	return &n, nil
+}
+
+// NotificationsHandler lists the caller's notifications, newest first.
+// Query parameters: unread=true, limit (default 20, max 100) and before (an
+// id, for paging).
+func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("limit must be between 1 and %d", maxLimit)))
+			return
+		}
+		limit = n
+	}
+	before := 0
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			apierror.Write(w, apierror.Validation("before must be a notification id"))
+			return
+		}
+		before = n
+	}
+
+	list, err := listNotifications(r.Context(), user.ID, q.Get("unread") == "true", before, limit)
+	if err != nil {
+		log.Printf("Failed to list notifications for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load notifications"))
+		return
+	}
+	unread, err := unreadCount(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to count notifications for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load notifications"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListResponse{Notifications: list, UnreadCount: unread})
+}
+
+// MarkReadHandler marks the given notifications, or all of them, as read.
+func MarkReadHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if !req.All && len(req.IDs) == 0 {
+		apierror.Write(w, apierror.Validation("ids or all is required"))
+		return
+	}
+	if len(req.IDs) > maxLimit {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("at most %d ids per request", maxLimit)))
+		return
+	}
+
+	updated, err := markRead(r.Context(), user.ID, req)
+	if err != nil {
+		log.Printf("Failed to mark notifications read for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to update notifications"))
+		return
+	}
+	unread, err := unreadCount(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to count notifications for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to update notifications"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MarkReadResponse{Updated: updated, UnreadCount: unread})
+}
+
+func listNotifications(ctx context.Context, accountID int, unreadOnly bool, before, limit int) ([]Notification, error) {
+	query := `SELECT id, kind, title, COALESCE(body, ''), COALESCE(link, ''), read_at, created_at
+		FROM notifications
+		WHERE account_id = $1 AND ($2 = 0 OR id < $2) AND (NOT $3 OR read_at IS NULL)
+		ORDER BY id DESC LIMIT $4`
+	rows, err := db.DB.QueryContext(ctx, query, accountID, before, unreadOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Notification{}
+	for rows.Next() {
+		var n Notification
+		var readAt sql.NullTime
+		if err := rows.Scan(&n.ID, &n.Kind, &n.Title, &n.Body, &n.Link, &readAt, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+func unreadCount(ctx context.Context, accountID int) (int, error) {
+	var n int
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM notifications WHERE account_id = $1 AND read_at IS NULL", accountID,
+	).Scan(&n)
+	return n, err
+}
+
+func markRead(ctx context.Context, accountID int, req MarkReadRequest) (int, error) {
+	var res sql.Result
+	var err error
+	if req.All {
+		res, err = db.DB.ExecContext(ctx,
+			"UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE account_id = $1 AND read_at IS NULL",
+			accountID)
+	} else {
+		res, err = db.DB.ExecContext(ctx,
+			"UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE account_id = $1 AND read_at IS NULL AND id = ANY($2)",
+			accountID, pq.Array(req.IDs))
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,170 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type recordingPublisher struct {
+	topics []string
+}
+
+func (p *recordingPublisher) Publish(topic string, data interface{}) int {
+	p.topics = append(p.topics, topic)
+	return 1
+}
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+func expectUser(mock sqlmock.Sqlmock, id int) {
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(id, "ana", "user"))
+}
+
+func TestNotifyPushesToAccountTopic(t *testing.T) {
+	mock := setupMockDB(t)
+	pub := &recordingPublisher{}
+	SetPublisher(pub)
+	defer SetPublisher(nil)
+
+	mock.ExpectQuery("INSERT INTO notifications").
+		WithArgs(4, KindDeckShared, "Deck shared", "", "/flashcards").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(9, time.Now()))
+
+	n, err := Notify(context.Background(), 4, KindDeckShared, "Deck shared", "", "/flashcards")
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if n.ID != 9 {
+		t.Errorf("id = %d, want 9", n.ID)
+	}
+	if len(pub.topics) != 1 || pub.topics[0] != "account:4" {
+		t.Errorf("published to %v", pub.topics)
+	}
+}
+
+func TestNotificationsHandler(t *testing.T) {
+	t.Run("Anonymous", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		NotificationsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/notifications", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", rec.Code)
+		}
+	})
+
+	t.Run("Lists newest first with unread count", func(t *testing.T) {
+		mock := setupMockDB(t)
+		expectUser(mock, 4)
+		readAt := time.Now()
+		mock.ExpectQuery("SELECT id, kind, title").WithArgs(4, 0, true, defaultLimit).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "title", "body", "link", "read_at", "created_at"}).
+				AddRow(2, KindJobFinished, "Import done", "", "", nil, time.Now()).
+				AddRow(1, KindAdminReply, "Reply", "Thanks", "", readAt, time.Now()))
+		mock.ExpectQuery("SELECT COUNT").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/notifications?unread=true", nil)
+		req.AddCookie(securecookie.Cookie("user_id", "4"))
+		rec := httptest.NewRecorder()
+		NotificationsHandler(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp ListResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if len(resp.Notifications) != 2 || resp.UnreadCount != 1 {
+			t.Fatalf("unexpected response %+v", resp)
+		}
+		if resp.Notifications[0].ReadAt != nil || resp.Notifications[1].ReadAt == nil {
+			t.Errorf("read_at not mapped: %+v", resp.Notifications)
+		}
+	})
+
+	t.Run("Rejects bad limit", func(t *testing.T) {
+		mock := setupMockDB(t)
+		expectUser(mock, 4)
+		req := httptest.NewRequest(http.MethodGet, "/api/notifications?limit=500", nil)
+		req.AddCookie(securecookie.Cookie("user_id", "4"))
+		rec := httptest.NewRecorder()
+		NotificationsHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestMarkReadHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		expect     func(sqlmock.Sqlmock)
+		wantStatus int
+	}{
+		{
+			name: "Selected ids",
+			body: `{"ids":[1,2]}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE notifications SET read_at .* id = ANY").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "All",
+			body: `{"all":true}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE notifications SET read_at").WithArgs(4).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+			},
+			wantStatus: http.StatusOK,
+		},
+		{name: "Nothing selected", body: `{}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			expectUser(mock, 4)
+			if tt.expect != nil {
+				tt.expect(mock)
+				mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/notifications/read", strings.NewReader(tt.body))
+			req.AddCookie(securecookie.Cookie("user_id", "4"))
+			rec := httptest.NewRecorder()
+			MarkReadHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Package operations tracks long-running, per-user operations (deck
+// imports, stack creation, execution logs) and streams their progress to
+// the browser over Server-Sent Events.
+package operations
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/sse"
+)
+
+// Update types. "done" and "error" are terminal.
+const (
+	UpdateProgress = "progress"
+	UpdateLog      = "log"
+	UpdateDone     = "done"
+	UpdateError    = "error"
+)
+
+const (
+	// maxHistory bounds the updates kept for replay on reconnect.
+	maxHistory = 1000
+	// retention is how long finished operations stay queryable.
+	retention = 15 * time.Minute
+	// subscriberBuffer is how far a stream may lag before it is cut off;
+	// the browser reconnects with Last-Event-ID and replays from history.
+	subscriberBuffer = 64
+)
+
+// KeepaliveInterval is how often idle streams send a comment line.
+var KeepaliveInterval = 15 * time.Second
+
+type Update struct {
+	Seq     int         `json:"seq"`
+	Type    string      `json:"type"`
+	Percent int         `json:"percent,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Result  interface{} `json:"result,omitempty"`
+	Time    time.Time   `json:"time"`
+}
+
+func (u Update) terminal() bool {
+	return u.Type == UpdateDone || u.Type == UpdateError
+}
+
+// Operation is a running task owned by one account.
+type Operation struct {
+	ID        string
+	Kind      string
+	AccountID int
+
+	mu         sync.Mutex
+	seq        int
+	updates    []Update
+	finishedAt time.Time
+	subs       map[chan Update]struct{}
+}
+
+var (
+	operations   = make(map[string]*Operation)
+	operationsMu sync.Mutex
+)
+
+// Start registers a new operation for accountID.
+func Start(kind string, accountID int) *Operation {
+	op := &Operation{
+		ID:        newOperationID(),
+		Kind:      kind,
+		AccountID: accountID,
+		subs:      make(map[chan Update]struct{}),
+	}
+
+	operationsMu.Lock()
+	defer operationsMu.Unlock()
+	pruneFinished(time.Now())
+	operations[op.ID] = op
+	return op
+}
+
+// Get returns the operation with id, if it is still retained.
+func Get(id string) (*Operation, bool) {
+	operationsMu.Lock()
+	defer operationsMu.Unlock()
+	op, ok := operations[id]
+	return op, ok
+}
+
+func pruneFinished(now time.Time) {
+	for id, op := range operations {
+		op.mu.Lock()
+		expired := !op.finishedAt.IsZero() && now.Sub(op.finishedAt) > retention
+		op.mu.Unlock()
+		if expired {
+			delete(operations, id)
+		}
+	}
+}
+
+// Progress reports completion percent (0-100) with a status message.
+func (op *Operation) Progress(percent int, message string) {
+	op.publish(Update{Type: UpdateProgress, Percent: percent, Message: message})
+}
+
+// Log appends an output line, e.g. from a running program.
+func (op *Operation) Log(line string) {
+	op.publish(Update{Type: UpdateLog, Message: line})
+}
+
+// Complete finishes the operation successfully.
+func (op *Operation) Complete(result interface{}) {
+	op.publish(Update{Type: UpdateDone, Percent: 100, Result: result})
+}
+
+// Fail finishes the operation with a user-facing error message.
+func (op *Operation) Fail(message string) {
+	op.publish(Update{Type: UpdateError, Message: message})
+}
+
+// Done reports whether the operation has finished.
+func (op *Operation) Done() bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return !op.finishedAt.IsZero()
+}
+
+func (op *Operation) publish(u Update) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if !op.finishedAt.IsZero() {
+		return
+	}
+
+	op.seq++
+	u.Seq = op.seq
+	u.Time = time.Now()
+	op.updates = append(op.updates, u)
+	if len(op.updates) > maxHistory {
+		op.updates = op.updates[len(op.updates)-maxHistory:]
+	}
+
+	for ch := range op.subs {
+		select {
+		case ch <- u:
+		default:
+			// Too far behind; the client resumes from history.
+			delete(op.subs, ch)
+			close(ch)
+		}
+	}
+
+	if u.terminal() {
+		op.finishedAt = u.Time
+		for ch := range op.subs {
+			delete(op.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the updates after seq and, unless the operation has
+// finished, a channel for new ones. The channel is closed on completion or
+// when the subscriber falls behind.
+func (op *Operation) subscribe(after int) ([]Update, chan Update, func()) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	var history []Update
+	for _, u := range op.updates {
+		if u.Seq > after {
+			history = append(history, u)
+		}
+	}
+	if !op.finishedAt.IsZero() {
+		return history, nil, func() {}
+	}
+
+	ch := make(chan Update, subscriberBuffer)
+	op.subs[ch] = struct{}{}
+	cancel := func() {
+		op.mu.Lock()
+		defer op.mu.Unlock()
+		if _, ok := op.subs[ch]; ok {
+			delete(op.subs, ch)
+			close(ch)
+		}
+	}
+	return history, ch, cancel
+}
+
+// EventsHandler streams an operation's updates as SSE. Each event is named
+// after the update type and carries the sequence number as its ID, so a
+// reconnecting EventSource resumes where it left off.
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	op, ok := Get(r.URL.Query().Get("id"))
+	if !ok || op.AccountID != user.ID {
+		apierror.Write(w, apierror.NotFound("Operation not found"))
+		return
+	}
+
+	after, _ := strconv.Atoi(sse.LastEventID(r))
+	history, updates, cancel := op.subscribe(after)
+	defer cancel()
+
+	stream, err := sse.NewStream(w)
+	if err != nil {
+		apierror.Write(w, apierror.Internal("Streaming not supported"))
+		return
+	}
+
+	for _, u := range history {
+		if err := sendUpdate(stream, u); err != nil || u.terminal() {
+			return
+		}
+	}
+	if updates == nil {
+		return
+	}
+
+	keepalive := time.NewTicker(KeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if err := stream.Comment("keepalive"); err != nil {
+				return
+			}
+		case u, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := sendUpdate(stream, u); err != nil || u.terminal() {
+				return
+			}
+		}
+	}
+}
+
+func sendUpdate(stream *sse.Stream, u Update) error {
+	return stream.Send(sse.Event{ID: strconv.Itoa(u.Seq), Name: u.Type, Data: u})
+}
+
+func newOperationID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,117 @@
+package operations
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockUser(t *testing.T, accountID int) {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+
+	mock.MatchExpectationsInOrder(false)
+	for i := 0; i < 5; i++ {
+		mock.ExpectQuery("SELECT id, username, role FROM accounts").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(accountID, "alice", "user"))
+	}
+}
+
+func newEventsRequest(id, lastEventID string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/operations/events?id="+id, nil)
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	return req
+}
+
+func TestEventsHandlerReplaysFinishedOperation(t *testing.T) {
+	setupMockUser(t, 1)
+
+	op := Start("test", 1)
+	op.Progress(50, "halfway")
+	op.Log("line")
+	op.Complete(map[string]int{"count": 2})
+	op.Progress(60, "ignored after completion")
+
+	rec := httptest.NewRecorder()
+	EventsHandler(rec, newEventsRequest(op.ID, ""))
+
+	body := rec.Body.String()
+	for _, want := range []string{"id: 1\nevent: progress\n", "id: 2\nevent: log\n", "id: 3\nevent: done\n", `"count":2`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "ignored") {
+		t.Error("updates after completion should be dropped")
+	}
+
+	rec = httptest.NewRecorder()
+	EventsHandler(rec, newEventsRequest(op.ID, "2"))
+	if body := rec.Body.String(); strings.Contains(body, "id: 1\n") || !strings.Contains(body, "id: 3\n") {
+		t.Errorf("expected resume after Last-Event-ID 2, got:\n%s", body)
+	}
+}
+
+func TestEventsHandlerRejectsOtherAccounts(t *testing.T) {
+	setupMockUser(t, 2)
+	op := Start("test", 1)
+
+	rec := httptest.NewRecorder()
+	EventsHandler(rec, newEventsRequest(op.ID, ""))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another account's operation, got %d", rec.Code)
+	}
+}
+
+func TestEventsHandlerStreamsLiveUpdates(t *testing.T) {
+	setupMockUser(t, 1)
+	op := Start("test", 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(EventsHandler))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?id="+op.ID, nil)
+	req.AddCookie(securecookie.Cookie("user_id", "1"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		op.Progress(10, "started")
+		op.Fail("boom")
+	}()
+
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			events = append(events, name)
+		}
+	}
+	if strings.Join(events, ",") != "progress,error" {
+		t.Errorf("unexpected events %v", events)
+	}
+}
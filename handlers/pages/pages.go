@@ -0,0 +1,423 @@
+// Package pages publishes users' Markdown files as public pages under
+// /u/{username}/{slug}. A page shows the file's current content: editing
+// the file updates the page, and a "draft: true" frontmatter line hides it
+// until it is cleared.
+package pages
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/cache"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/markdown"
+	"allanswebterminal/sanitize"
+	"allanswebterminal/templates"
+)
+
+const (
+	// MaxPages bounds an account's published pages.
+	MaxPages = 100
+
+	// renderCacheTTL can be long: the cache key changes whenever the file
+	// is saved.
+	renderCacheTTL = time.Hour
+	cacheControl   = "public, max-age=60"
+)
+
+// pagePolicy replaces the site's Content-Security-Policy on published
+// pages: they run no scripts, and may show images from other sites.
+const pagePolicy = "default-src 'none'; style-src 'self'; img-src 'self' data: https:; " +
+	"base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// Page is a published page.
+type Page struct {
+	Slug        string    `json:"slug"`
+	Filename    string    `json:"filename"`
+	URL         string    `json:"url"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+type PublishRequest struct {
+	Filename string `json:"filename"`
+}
+
+// rendered is a page's file rendered for display.
+type rendered struct {
+	Title string `json:"title"`
+	HTML  string `json:"html"`
+	Draft bool   `json:"draft"`
+}
+
+// URL is where username's page slug is served.
+func URL(username, slug string) string {
+	return "/u/" + username + "/" + slug
+}
+
+// Slugify derives a page slug from a filename: its base name without the
+// extension, lowercased, with runs of other characters turned into '-'.
+func Slugify(filename string) string {
+	name := strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	slug := b.String()
+	if len(slug) > 64 {
+		slug = strings.TrimRight(slug[:64], "-")
+	}
+	return slug
+}
+
+// PublishHandler publishes one of the caller's Markdown files. The slug is
+// the frontmatter's "slug", or else derived from the filename. Publishing
+// a file again moves it to its current slug.
+func PublishHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req PublishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if !strings.HasSuffix(strings.ToLower(req.Filename), ".md") {
+		apierror.Write(w, apierror.Validation("only Markdown (.md) files can be published"))
+		return
+	}
+
+	file, err := files.Load(r.Context(), user.ID, req.Filename)
+	if errors.Is(err, files.ErrNotFound) {
+		apierror.Write(w, apierror.NotFound("File not found"))
+		return
+	}
+	if errors.Is(err, files.ErrQuarantined) {
+		apierror.Write(w, apierror.Forbidden("File is quarantined").WithDetails(map[string]string{"reason": "quarantined"}))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load %s of account %d: %v", req.Filename, user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to publish page"))
+		return
+	}
+	meta, _ := markdown.Frontmatter(file.Content)
+	slug := meta["slug"]
+	if slug == "" {
+		slug = Slugify(file.Filename)
+	}
+	if len(slug) > 64 || !slugPattern.MatchString(slug) {
+		apierror.Write(w, apierror.Validation("slug must be 1-64 lowercase letters, digits and single dashes"))
+		return
+	}
+
+	var published, taken bool
+	var count int
+	err = db.DB.QueryRowContext(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM published_pages WHERE file_id = $3),
+			EXISTS (SELECT 1 FROM published_pages WHERE account_id = $1 AND slug = $2 AND file_id <> $3),
+			(SELECT COUNT(*) FROM published_pages WHERE account_id = $1)`,
+		user.ID, slug, file.ID,
+	).Scan(&published, &taken, &count)
+	if err != nil {
+		log.Printf("Failed to check pages of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to publish page"))
+		return
+	}
+	if taken {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("Another file is published as %q", slug)))
+		return
+	}
+	if !published && count >= MaxPages {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("An account can publish at most %d pages", MaxPages)))
+		return
+	}
+
+	page := Page{Slug: slug, Filename: file.Filename, URL: URL(user.Username, slug)}
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO published_pages (account_id, file_id, slug) VALUES ($1, $2, $3)
+		 ON CONFLICT (file_id) DO UPDATE SET slug = EXCLUDED.slug
+		 RETURNING published_at`,
+		user.ID, file.ID, slug,
+	).Scan(&page.PublishedAt)
+	if err != nil {
+		log.Printf("Failed to publish %s of account %d: %v", file.Filename, user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to publish page"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !published {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// ListPagesHandler lists the caller's published pages.
+func ListPagesHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT p.slug, f.filename, p.published_at
+		 FROM published_pages p JOIN user_files f ON f.id = p.file_id
+		 WHERE p.account_id = $1
+		 ORDER BY p.published_at DESC, p.id DESC`, user.ID)
+	if err != nil {
+		log.Printf("Failed to list pages of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to list pages"))
+		return
+	}
+	defer rows.Close()
+
+	list := []Page{}
+	for rows.Next() {
+		var p Page
+		if err := rows.Scan(&p.Slug, &p.Filename, &p.PublishedAt); err != nil {
+			log.Printf("Failed to scan page: %v", err)
+			continue
+		}
+		p.URL = URL(user.Username, p.Slug)
+		list = append(list, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// UnpublishHandler takes down the caller's page {slug}. The file is kept.
+func UnpublishHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	result, err := db.DB.ExecContext(r.Context(),
+		`DELETE FROM published_pages WHERE account_id = $1 AND slug = $2`, user.ID, r.PathValue("slug"))
+	if err != nil {
+		log.Printf("Failed to unpublish page of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to unpublish page"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Page not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pageView is the data of the page template.
+type pageView struct {
+	Author      string
+	Title       string
+	Body        template.HTML
+	PublishedAt time.Time
+	// Index is set on the author's index, which lists Pages instead.
+	Index bool
+	Pages []indexEntry
+}
+
+type indexEntry struct {
+	Title       string
+	URL         string
+	PublishedAt time.Time
+}
+
+// PageHandler serves the page {slug} of {username}. Pages of suspended
+// accounts and of quarantined files are not found, and neither are
+// drafts.
+func PageHandler(w http.ResponseWriter, r *http.Request) {
+	var id, fileID int
+	var author string
+	var publishedAt, updatedAt time.Time
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT p.id, p.file_id, a.username, p.published_at, f.updated_at
+		 FROM published_pages p
+		 JOIN accounts a ON a.id = p.account_id
+		 JOIN user_files f ON f.id = p.file_id
+		 WHERE a.username = $1 AND p.slug = $2 AND `+files.NotQuarantined("f")+` AND `+login.NotSuspended("p.account_id"),
+		r.PathValue("username"), r.PathValue("slug"),
+	).Scan(&id, &fileID, &author, &publishedAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load page %s/%s: %v", r.PathValue("username"), r.PathValue("slug"), err)
+		http.Error(w, "Failed to load page", http.StatusInternalServerError)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d-%d"`, id, updatedAt.UnixNano())
+	setHeaders(w, etag, updatedAt)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	page, err := render(r.Context(), fileID, updatedAt)
+	if err != nil {
+		log.Printf("Failed to render page %d: %v", id, err)
+		http.Error(w, "Failed to load page", http.StatusInternalServerError)
+		return
+	}
+	if page.Draft {
+		http.NotFound(w, r)
+		return
+	}
+
+	view := pageView{
+		Author:      author,
+		Title:       page.Title,
+		Body:        template.HTML(page.HTML),
+		PublishedAt: publishedAt,
+	}
+	if err := templates.Render(w, r, "page", view); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// IndexHandler lists the pages of {username}, newest first.
+func IndexHandler(w http.ResponseWriter, r *http.Request) {
+	var author string
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT username FROM accounts a WHERE username = $1 AND `+login.NotSuspended("a.id"),
+		r.PathValue("username"),
+	).Scan(&author)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load pages of %s: %v", r.PathValue("username"), err)
+		http.Error(w, "Failed to load pages", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT p.slug, p.file_id, p.published_at, f.updated_at
+		 FROM published_pages p
+		 JOIN accounts a ON a.id = p.account_id
+		 JOIN user_files f ON f.id = p.file_id
+		 WHERE a.username = $1 AND `+files.NotQuarantined("f")+`
+		 ORDER BY p.published_at DESC, p.id DESC`, author)
+	if err != nil {
+		log.Printf("Failed to list pages of %s: %v", author, err)
+		http.Error(w, "Failed to load pages", http.StatusInternalServerError)
+		return
+	}
+	type entry struct {
+		slug                   string
+		fileID                 int
+		publishedAt, updatedAt time.Time
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.slug, &e.fileID, &e.publishedAt, &e.updatedAt); err != nil {
+			log.Printf("Failed to scan page: %v", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+
+	view := pageView{Author: author, Title: author + "'s pages", Index: true}
+	for _, e := range entries {
+		// Titles and drafts come from the content, so every page is
+		// rendered; the cache makes that cheap after the first visit.
+		page, err := render(r.Context(), e.fileID, e.updatedAt)
+		if err != nil {
+			log.Printf("Failed to render page %s/%s: %v", author, e.slug, err)
+			continue
+		}
+		if page.Draft {
+			continue
+		}
+		view.Pages = append(view.Pages, indexEntry{Title: page.Title, URL: URL(author, e.slug), PublishedAt: e.publishedAt})
+	}
+
+	w.Header().Set("Cache-Control", cacheControl)
+	setPolicy(w)
+	if err := templates.Render(w, r, "page", view); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func setHeaders(w http.ResponseWriter, etag string, updatedAt time.Time) {
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+	h.Set("Cache-Control", cacheControl)
+	setPolicy(w)
+}
+
+func setPolicy(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Security-Policy-Report-Only")
+	h.Set("Content-Security-Policy", pagePolicy)
+}
+
+// render renders file fileID as saved at updatedAt, through the cache.
+func render(ctx context.Context, fileID int, updatedAt time.Time) (rendered, error) {
+	key := "pages:" + strconv.Itoa(fileID) + ":" + strconv.FormatInt(updatedAt.UnixNano(), 10)
+	return cache.GetOrLoad(ctx, key, renderCacheTTL, func() (rendered, error) {
+		var accountID int
+		var filename, content string
+		var keyID sql.NullString
+		var dataKey []byte
+		err := db.DB.QueryRowContext(ctx,
+			`SELECT account_id, filename, content, key_id, data_key FROM user_files WHERE id = $1`, fileID,
+		).Scan(&accountID, &filename, &content, &keyID, &dataKey)
+		if err != nil {
+			return rendered{}, err
+		}
+		if content, err = files.Decrypt(accountID, filename, content, keyID, dataKey); err != nil {
+			return rendered{}, err
+		}
+		return renderFile(filename, content), nil
+	})
+}
+
+// renderFile renders the Markdown file filename for display. The title is the
+// frontmatter's "title", or else the filename.
+func renderFile(filename, content string) rendered {
+	meta, body := markdown.Frontmatter(content)
+	title := meta["title"]
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	}
+	draft, _ := strconv.ParseBool(meta["draft"])
+	return rendered{
+		Title: title,
+		HTML:  sanitize.Page(markdown.ToHTML(body)),
+		Draft: draft,
+	}
+}
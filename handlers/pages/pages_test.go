@@ -0,0 +1,163 @@
+package pages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	originalDB := db.DB
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+func expectUser(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "user"))
+}
+
+var fileColumns = []string{"id", "account_id", "filename", "content", "file_type", "created_at", "updated_at", "key_id", "data_key", "scan_status"}
+
+func publish(filename string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/pages", strings.NewReader(`{"filename":"`+filename+`"}`))
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	rec := httptest.NewRecorder()
+	PublishHandler(rec, req)
+	return rec
+}
+
+func TestSlugify(t *testing.T) {
+	for in, want := range map[string]string{
+		"notes/Hello World.md":   "hello-world",
+		"--Über  cool_post--.md": "ber-cool-post",
+		"2026-10-18.md":          "2026-10-18",
+		strings.Repeat("a-", 40): strings.TrimRight(strings.Repeat("a-", 32), "-"),
+	} {
+		if got := Slugify(in); got != want {
+			t.Errorf("Slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRenderFile(t *testing.T) {
+	page := renderFile("posts/first.md", "---\ndraft: true\n---\n# Hi <script>alert(1)</script>\n\n[x](javascript:alert(1))")
+	if page.Title != "first" || !page.Draft {
+		t.Errorf("title, draft = %q, %v", page.Title, page.Draft)
+	}
+	if strings.Contains(page.HTML, "<script") || strings.Contains(page.HTML, "javascript:") {
+		t.Errorf("HTML not sanitized: %s", page.HTML)
+	}
+	if !strings.Contains(page.HTML, "<h1>Hi") {
+		t.Errorf("HTML = %s", page.HTML)
+	}
+	if page := renderFile("a.md", "---\ntitle: Hello\n---\n"); page.Title != "Hello" || page.Draft {
+		t.Errorf("title, draft = %q, %v", page.Title, page.Draft)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	mock := setupMockDB(t)
+	published := time.Now()
+
+	expectUser(mock)
+	mock.ExpectQuery("FROM user_files").WithArgs(7, "Hello World.md").
+		WillReturnRows(sqlmock.NewRows(fileColumns).
+			AddRow(3, 7, "Hello World.md", "# Hello", "python", time.Now(), time.Now(), nil, nil, nil))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(7, "hello-world", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"published", "taken", "count"}).AddRow(false, false, 0))
+	mock.ExpectQuery("INSERT INTO published_pages").WithArgs(7, 3, "hello-world").
+		WillReturnRows(sqlmock.NewRows([]string{"published_at"}).AddRow(published))
+	rec := publish("Hello World.md")
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"url":"/u/ana/hello-world"`) {
+		t.Errorf("publish = %d %s", rec.Code, rec.Body)
+	}
+
+	// The frontmatter's slug is taken by another file.
+	expectUser(mock)
+	mock.ExpectQuery("FROM user_files").WithArgs(7, "b.md").
+		WillReturnRows(sqlmock.NewRows(fileColumns).
+			AddRow(4, 7, "b.md", "---\nslug: hello-world\n---\n", "python", time.Now(), time.Now(), nil, nil, nil))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(7, "hello-world", 4).
+		WillReturnRows(sqlmock.NewRows([]string{"published", "taken", "count"}).AddRow(false, true, 1))
+	if rec := publish("b.md"); rec.Code != http.StatusConflict {
+		t.Errorf("publish of a taken slug = %d, want 409", rec.Code)
+	}
+
+	expectUser(mock)
+	if rec := publish("main.py"); rec.Code != http.StatusBadRequest {
+		t.Errorf("publish of a Python file = %d, want 400", rec.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPageHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	published, updated := time.Now().Add(-time.Hour), time.Now()
+
+	get := func(content string) *httptest.ResponseRecorder {
+		mock.ExpectQuery("FROM published_pages p").WithArgs("ana", "hello").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "file_id", "username", "published_at", "updated_at"}).
+				AddRow(1, 3, "ana", published, updated))
+		mock.ExpectQuery("SELECT account_id, filename, content, key_id, data_key FROM user_files").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "filename", "content", "key_id", "data_key"}).
+				AddRow(7, "hello.md", content, nil, nil))
+		req := httptest.NewRequest(http.MethodGet, "/u/ana/hello", nil)
+		req.SetPathValue("username", "ana")
+		req.SetPathValue("slug", "hello")
+		rec := httptest.NewRecorder()
+		PageHandler(rec, req)
+		return rec
+	}
+
+	rec := get("---\ntitle: Greetings\n---\nSome **bold** text.\n")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, "<title>Greetings - ana</title>") || !strings.Contains(body, "Some <strong>bold</strong> text.") {
+		t.Fatalf("page = %d %s", rec.Code, body)
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != pagePolicy {
+		t.Errorf("Content-Security-Policy = %q", csp)
+	}
+	etag := rec.Header().Get("ETag")
+
+	// Unchanged: revalidation needs no rendering.
+	mock.ExpectQuery("FROM published_pages p").WithArgs("ana", "hello").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "file_id", "username", "published_at", "updated_at"}).
+			AddRow(1, 3, "ana", published, updated))
+	req := httptest.NewRequest(http.MethodGet, "/u/ana/hello", nil)
+	req.SetPathValue("username", "ana")
+	req.SetPathValue("slug", "hello")
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	PageHandler(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation = %d, want 304", rec.Code)
+	}
+
+	// Saved again as a draft.
+	updated = updated.Add(time.Second)
+	if rec := get("---\ndraft: true\n---\nSecret"); rec.Code != http.StatusNotFound {
+		t.Errorf("draft = %d, want 404", rec.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
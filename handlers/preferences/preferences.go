@@ -0,0 +1,113 @@
+// Package preferences stores per-user display preferences.
+package preferences
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/i18n"
+)
+
+type LocaleRequest struct {
+	Locale string `json:"locale"`
+}
+
+type LocaleResponse struct {
+	Locale    string   `json:"locale"`
+	Supported []string `json:"supported"`
+}
+
+// PresenceSetting is whether the user appears in GET /api/presence.
+type PresenceSetting struct {
+	Visible *bool `json:"visible"`
+}
+
+// GetLocaleHandler reports the active locale and the supported ones.
+func GetLocaleHandler(w http.ResponseWriter, r *http.Request) {
+	writeLocaleResponse(w, i18n.Locale(r))
+}
+
+// SetLocaleHandler changes the locale. The choice is kept in a cookie and,
+// for signed-in users, on the account so it follows them to other browsers.
+func SetLocaleHandler(w http.ResponseWriter, r *http.Request) {
+	var req LocaleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+
+	locale := i18n.Normalize(req.Locale)
+	if locale == "" {
+		apierror.Write(w, apierror.Validation("Unsupported locale").
+			WithDetails(map[string][]string{"supported": i18n.Supported()}))
+		return
+	}
+
+	if user, err := login.GetCurrentUser(r); err == nil {
+		if _, err := db.DB.Exec("UPDATE accounts SET locale = $1 WHERE id = $2", locale, user.ID); err != nil {
+			log.Printf("Failed to save locale for account %d: %v", user.ID, err)
+		}
+	}
+
+	i18n.SetCookie(w, locale, login.SecureCookies)
+	w.Header().Set("Content-Language", locale)
+	writeLocaleResponse(w, locale)
+}
+
+func writeLocaleResponse(w http.ResponseWriter, locale string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LocaleResponse{Locale: locale, Supported: i18n.Supported()})
+}
+
+// GetPresenceHandler reports whether the caller is shown as online.
+func GetPresenceHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var hidden bool
+	if err := db.DB.QueryRow("SELECT presence_hidden FROM accounts WHERE id = $1", user.ID).Scan(&hidden); err != nil {
+		log.Printf("Failed to load presence setting for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load presence setting"))
+		return
+	}
+	writePresenceSetting(w, !hidden)
+}
+
+// SetPresenceHandler opts the caller in to or out of presence. Opted-out
+// users are still tracked but no one else sees them online.
+func SetPresenceHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req PresenceSetting
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.Visible == nil {
+		apierror.Write(w, apierror.Validation("visible is required"))
+		return
+	}
+
+	if _, err := db.DB.Exec("UPDATE accounts SET presence_hidden = $1 WHERE id = $2", !*req.Visible, user.ID); err != nil {
+		log.Printf("Failed to save presence setting for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save presence setting"))
+		return
+	}
+	writePresenceSetting(w, *req.Visible)
+}
+
+func writePresenceSetting(w http.ResponseWriter, visible bool) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PresenceSetting{Visible: &visible})
+}
@@ -0,0 +1,115 @@
+package preferences
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSetLocaleHandler(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	defer func() {
+		db.DB = originalDB
+		mockDB.Close()
+	}()
+
+	t.Run("Anonymous sets cookie only", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/preferences/locale", strings.NewReader(`{"locale":"pt-BR"}`))
+		rec := httptest.NewRecorder()
+		SetLocaleHandler(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if cookie := rec.Header().Get("Set-Cookie"); !strings.Contains(cookie, "lang=pt") {
+			t.Errorf("expected lang cookie, got %q", cookie)
+		}
+	})
+
+	t.Run("Signed in user is persisted", func(t *testing.T) {
+		mock.ExpectQuery("SELECT id, username, role FROM accounts").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(4, "ana", "user"))
+		mock.ExpectExec("UPDATE accounts SET locale").WithArgs("es", 4).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		req := httptest.NewRequest(http.MethodPost, "/api/preferences/locale", strings.NewReader(`{"locale":"es"}`))
+		req.AddCookie(securecookie.Cookie("user_id", "4"))
+		rec := httptest.NewRecorder()
+		SetLocaleHandler(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Unsupported locale is localized", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/preferences/locale", strings.NewReader(`{"locale":"klingon"}`))
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Language", "pt")
+		SetLocaleHandler(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "Idioma não suportado") {
+			t.Errorf("expected Portuguese error, got %s", rec.Body.String())
+		}
+	})
+}
+
+func TestSetPresenceHandler(t *testing.T) {
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	defer func() {
+		db.DB = originalDB
+		mockDB.Close()
+	}()
+
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantHidden interface{}
+	}{
+		{`{"visible": false}`, http.StatusOK, true},
+		{`{"visible": true}`, http.StatusOK, false},
+		{`{}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		mock.ExpectQuery("SELECT id, username, role FROM accounts").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(4, "ana", "user"))
+		if tt.wantHidden != nil {
+			mock.ExpectExec("UPDATE accounts SET presence_hidden").WithArgs(tt.wantHidden, 4).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/preferences/presence", strings.NewReader(tt.body))
+		req.AddCookie(securecookie.Cookie("user_id", "4"))
+		rec := httptest.NewRecorder()
+		SetPresenceHandler(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d: %s", tt.body, rec.Code, rec.Body.String())
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+// Package presence tracks which signed-in users are online, for the
+// multiplayer lobby and the collaborative editor. A user is online while
+// they have a WebSocket open or have sent a heartbeat within TTL. Presence
+// is soft: it is kept in memory, per server instance, and is lost on
+// restart.
+package presence
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/ws"
+
+	"github.com/lib/pq"
+)
+
+// TTL is how long a heartbeat keeps a user online. Clients without a
+// WebSocket send one about every 30 seconds.
+var TTL = 90 * time.Second
+
+// User is an online user as listed by GET /api/presence.
+type User struct {
+	AccountID int       `json:"account_id"`
+	Username  string    `json:"username"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Response is the body of GET /api/presence.
+type Response struct {
+	Users []User `json:"users"`
+	Count int    `json:"count"`
+}
+
+type entry struct {
+	username string
+	conns    int
+	lastSeen time.Time
+}
+
+// tracker holds the users seen by this instance.
+type tracker struct {
+	mu    sync.Mutex
+	users map[int]*entry
+	now   func() time.Time
+}
+
+func newTracker() *tracker {
+	return &tracker{users: make(map[int]*entry), now: time.Now}
+}
+
+var online = newTracker()
+
+func (t *tracker) get(accountID int, username string) *entry {
+	e, ok := t.users[accountID]
+	if !ok {
+		e = &entry{}
+		t.users[accountID] = e
+	}
+	e.username = username
+	e.lastSeen = t.now()
+	return e
+}
+
+func (t *tracker) connect(accountID int, username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.get(accountID, username).conns++
+}
+
+func (t *tracker) disconnect(accountID int, username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e := t.get(accountID, username); e.conns > 0 {
+		e.conns--
+	}
+}
+
+func (t *tracker) touch(accountID int, username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.get(accountID, username)
+}
+
+// snapshot returns the online users by username and forgets the rest.
+func (t *tracker) snapshot() []User {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := t.now().Add(-TTL)
+	users := make([]User, 0, len(t.users))
+	for id, e := range t.users {
+		if e.conns == 0 && e.lastSeen.Before(cutoff) {
+			delete(t.users, id)
+			continue
+		}
+		users = append(users, User{AccountID: id, Username: e.username, LastSeen: e.lastSeen})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// Connected and Disconnected are the WebSocket hub's connection hooks. A
+// user with several connections stays online until the last one closes.
+func Connected(identity ws.Identity) {
+	if identity.AccountID != 0 {
+		online.connect(identity.AccountID, identity.Username)
+	}
+}
+
+func Disconnected(identity ws.Identity) {
+	if identity.AccountID != 0 {
+		online.disconnect(identity.AccountID, identity.Username)
+	}
+}
+
+// HeartbeatHandler marks the caller online for TTL.
+func HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	online.touch(user.ID, user.Username)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PresenceHandler lists the online users. Users who opted out of presence
+// are left out, except to themselves.
+func PresenceHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	users := online.snapshot()
+	hidden, err := hiddenAccounts(users)
+	if err != nil {
+		log.Printf("Failed to load presence preferences: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load presence"))
+		return
+	}
+	visible := make([]User, 0, len(users))
+	for _, u := range users {
+		if u.AccountID == user.ID || !hidden[u.AccountID] {
+			visible = append(visible, u)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Users: visible, Count: len(visible)})
+}
+
+// hiddenAccounts returns which of users have opted out of presence.
+func hiddenAccounts(users []User) (map[int]bool, error) {
+	hidden := make(map[int]bool)
+	if len(users) == 0 {
+		return hidden, nil
+	}
+	ids := make([]int, len(users))
+	for i, u := range users {
+		ids[i] = u.AccountID
+	}
+	rows, err := db.DB.Query("SELECT id FROM accounts WHERE id = ANY($1) AND presence_hidden", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		hidden[id] = true
+	}
+	return hidden, rows.Err()
+}
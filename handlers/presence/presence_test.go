@@ -0,0 +1,122 @@
+package presence
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+	"allanswebterminal/ws"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+// useTracker replaces the tracker with one whose clock the test controls.
+func useTracker(t *testing.T) *time.Time {
+	t.Helper()
+	original := online
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	online = newTracker()
+	online.now = func() time.Time { return now }
+	t.Cleanup(func() { online = original })
+	return &now
+}
+
+func usernames(users []User) []string {
+	names := []string{}
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	return names
+}
+
+func TestTracker(t *testing.T) {
+	now := useTracker(t)
+	Connected(ws.Identity{AccountID: 1, Username: "bob"})
+	Connected(ws.Identity{AccountID: 1, Username: "bob"})
+	online.touch(2, "alice")
+	Connected(ws.Identity{})
+
+	if got := usernames(online.snapshot()); len(got) != 2 || got[0] != "alice" || got[1] != "bob" {
+		t.Fatalf("online = %v", got)
+	}
+
+	*now = now.Add(TTL + time.Second)
+	Disconnected(ws.Identity{AccountID: 1, Username: "bob"})
+	if got := usernames(online.snapshot()); len(got) != 1 || got[0] != "bob" {
+		t.Errorf("after the heartbeat expired, online = %v", got)
+	}
+
+	Disconnected(ws.Identity{AccountID: 1, Username: "bob"})
+	*now = now.Add(TTL + time.Second)
+	if got := online.snapshot(); len(got) != 0 || len(online.users) != 0 {
+		t.Errorf("after the last connection closed, online = %v", usernames(got))
+	}
+}
+
+func TestPresenceHandlerHidesOptedOutUsers(t *testing.T) {
+	mock := setupMockDB(t)
+	useTracker(t)
+	online.touch(1, "bob")
+	online.touch(2, "alice")
+	online.touch(3, "carol")
+
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(3, "carol", "user"))
+	mock.ExpectQuery("SELECT id FROM accounts WHERE id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(3))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/presence", nil)
+	req.AddCookie(securecookie.Cookie("user_id", "3"))
+	rr := httptest.NewRecorder()
+	PresenceHandler(rr, req)
+
+	var resp Response
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if got := usernames(resp.Users); rr.Code != http.StatusOK || resp.Count != 2 || got[0] != "alice" || got[1] != "carol" {
+		t.Errorf("status = %d, users = %v, count = %d", rr.Code, got, resp.Count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHeartbeatHandler(t *testing.T) {
+	mock := setupMockDB(t)
+	useTracker(t)
+
+	rr := httptest.NewRecorder()
+	HeartbeatHandler(rr, httptest.NewRequest(http.MethodPost, "/api/presence/heartbeat", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous heartbeat: status = %d", rr.Code)
+	}
+
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(2, "alice", "user"))
+	req := httptest.NewRequest(http.MethodPost, "/api/presence/heartbeat", nil)
+	req.AddCookie(securecookie.Cookie("user_id", "2"))
+	rr = httptest.NewRecorder()
+	HeartbeatHandler(rr, req)
+
+	if got := usernames(online.snapshot()); rr.Code != http.StatusNoContent || len(got) != 1 || got[0] != "alice" {
+		t.Errorf("status = %d, online = %v", rr.Code, got)
+	}
+}
@@ -0,0 +1,545 @@
+// Package projects groups a user's files into projects, each its own
+// codebase. A project owns the files under the directory named after it,
+// such as "blog/main.py" for the project "blog", carries the command that
+// runs it, and can be shared read-only by link.
+package projects
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/secrets"
+	"allanswebterminal/sandbox"
+	"allanswebterminal/validate"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// MaxProjects bounds an account's projects.
+	MaxProjects = 20
+	// MaxArgs bounds the arguments of a project's run command.
+	MaxArgs = 20
+	// RunTimeout bounds a run, as the terminal bounds a command.
+	RunTimeout = 30 * time.Second
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// reservedNames are directories the site keeps its own files in.
+var reservedNames = map[string]bool{"sandbox": true, "playground": true}
+
+// Project is a group of files. Its files are those whose names start with
+// Name and a slash.
+type Project struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Entrypoint is the Python file run, relative to the project.
+	Entrypoint string   `json:"entrypoint"`
+	Args       []string `json:"args"`
+	Files      int      `json:"files"`
+	// ShareURL is set while the project is shared.
+	ShareURL  string    `json:"share_url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProjectRequest creates a project or replaces its settings. The name of
+// an existing project is fixed: its files are encrypted bound to their
+// names.
+type ProjectRequest struct {
+	Name        string   `json:"name" validate:"max=64"`
+	Description string   `json:"description" validate:"max=1000"`
+	Entrypoint  string   `json:"entrypoint" validate:"max=255"`
+	Args        []string `json:"args"`
+}
+
+// SharedProject is a shared project as anyone with its link sees it.
+type SharedProject struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Entrypoint  string       `json:"entrypoint"`
+	Args        []string     `json:"args"`
+	Author      string       `json:"author"`
+	Files       []SharedFile `json:"files"`
+}
+
+// SharedFile is a file of a shared project.
+type SharedFile struct {
+	Filename  string    `json:"filename"`
+	Content   string    `json:"content"`
+	FileType  string    `json:"file_type"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RunResult is the outcome of running a project.
+type RunResult struct {
+	Command string `json:"command"`
+	*sandbox.ExecResult
+}
+
+const projectColumns = `p.id, p.name, p.description, p.entrypoint, p.args, p.share_token, p.created_at, p.updated_at,
+	(SELECT COUNT(*) FROM user_files f WHERE f.project_id = p.id)`
+
+func scanProject(row interface{ Scan(...any) error }) (*Project, error) {
+	var p Project
+	var token sql.NullString
+	if err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Entrypoint, pq.Array(&p.Args), &token, &p.CreatedAt, &p.UpdatedAt, &p.Files); err != nil {
+		return nil, err
+	}
+	if p.Args == nil {
+		p.Args = []string{}
+	}
+	if token.Valid {
+		p.ShareURL = shareURL(token.String)
+	}
+	return &p, nil
+}
+
+// checkRequest validates req, applying defaults, and reports what is wrong.
+func checkRequest(req *ProjectRequest) *apierror.Error {
+	if apiErr := validate.Check(req); apiErr != nil {
+		return apiErr
+	}
+	if req.Entrypoint == "" {
+		req.Entrypoint = "main.py"
+	}
+	if path.IsAbs(req.Entrypoint) || path.Clean(req.Entrypoint) != req.Entrypoint || strings.HasPrefix(req.Entrypoint, "../") || req.Entrypoint == ".." {
+		return apierror.Validation("entrypoint must be a path inside the project, such as main.py or src/app.py")
+	}
+	if len(req.Args) > MaxArgs {
+		return apierror.Validation(fmt.Sprintf("a project can have at most %d arguments", MaxArgs))
+	}
+	if req.Args == nil {
+		req.Args = []string{}
+	}
+	return nil
+}
+
+// ListProjectsHandler lists the caller's projects.
+func ListProjectsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT `+projectColumns+` FROM projects p WHERE p.account_id = $1 ORDER BY p.name`, user.ID)
+	if err != nil {
+		log.Printf("Failed to list projects of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to list projects"))
+		return
+	}
+	defer rows.Close()
+
+	list := []*Project{}
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			log.Printf("Failed to scan project: %v", err)
+			continue
+		}
+		list = append(list, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// CreateProjectHandler creates a project. Files already saved under its
+// directory join it.
+func CreateProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req ProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if apiErr := checkRequest(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	if !namePattern.MatchString(req.Name) || reservedNames[req.Name] {
+		apierror.Write(w, apierror.Validation("name must be 1-64 letters, digits, '.', '-' or '_', and not a directory the site uses"))
+		return
+	}
+
+	var count int
+	var exists bool
+	err = db.DB.QueryRowContext(r.Context(),
+		`SELECT COUNT(*), COALESCE(BOOL_OR(name = $2), FALSE) FROM projects WHERE account_id = $1`, user.ID, req.Name,
+	).Scan(&count, &exists)
+	if err != nil {
+		log.Printf("Failed to count projects of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to create project"))
+		return
+	}
+	if exists {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("A project named %q already exists", req.Name)))
+		return
+	}
+	if count >= MaxProjects {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("An account can have at most %d projects", MaxProjects)))
+		return
+	}
+
+	tx, err := db.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Failed to create project for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to create project"))
+		return
+	}
+	defer tx.Rollback()
+	p := Project{Name: req.Name, Description: req.Description, Entrypoint: req.Entrypoint, Args: req.Args}
+	err = tx.QueryRowContext(r.Context(),
+		`INSERT INTO projects (account_id, name, description, entrypoint, args)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING id, created_at, updated_at`,
+		user.ID, req.Name, req.Description, req.Entrypoint, pq.Array(req.Args),
+	).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		log.Printf("Failed to create project for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to create project"))
+		return
+	}
+	res, err := tx.ExecContext(r.Context(),
+		`UPDATE user_files SET project_id = $1 WHERE account_id = $2 AND filename LIKE $3`,
+		p.ID, user.ID, dirPattern(req.Name))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Failed to create project for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to create project"))
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		p.Files = int(n)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// dirPattern is a LIKE pattern matching the filenames in directory name.
+func dirPattern(name string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(name) + "/%"
+}
+
+// GetProjectHandler returns one of the caller's projects.
+func GetProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	p, err := scanProject(db.DB.QueryRowContext(r.Context(),
+		`SELECT `+projectColumns+` FROM projects p WHERE p.account_id = $1 AND p.name = $2`, user.ID, r.PathValue("name")))
+	if writeLookupError(w, err, user.ID) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// UpdateProjectHandler replaces the description and run command of one of
+// the caller's projects.
+func UpdateProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req ProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	name := r.PathValue("name")
+	if req.Name != "" && req.Name != name {
+		apierror.Write(w, apierror.Validation("a project cannot be renamed"))
+		return
+	}
+	if apiErr := checkRequest(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	p, err := scanProject(db.DB.QueryRowContext(r.Context(),
+		`UPDATE projects p SET description = $3, entrypoint = $4, args = $5, updated_at = CURRENT_TIMESTAMP
+		 WHERE p.account_id = $1 AND p.name = $2
+		 RETURNING `+projectColumns,
+		user.ID, name, req.Description, req.Entrypoint, pq.Array(req.Args)))
+	if writeLookupError(w, err, user.ID) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// DeleteProjectHandler deletes one of the caller's projects with its
+// files.
+func DeleteProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	res, err := db.DB.ExecContext(r.Context(),
+		`DELETE FROM projects WHERE account_id = $1 AND name = $2`, user.ID, r.PathValue("name"))
+	if err != nil {
+		log.Printf("Failed to delete project of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to delete project"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Project not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunProjectHandler copies one of the caller's projects into their
+// sandbox, under a directory of the same name in the home directory, and
+// runs its entrypoint there with its arguments and the caller's secrets.
+func RunProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	if !sandbox.Configured() {
+		apierror.Write(w, apierror.Unavailable("The terminal sandbox is not configured").
+			WithDetails(map[string]string{"reason": "not_configured"}))
+		return
+	}
+	p, err := scanProject(db.DB.QueryRowContext(r.Context(),
+		`SELECT `+projectColumns+` FROM projects p WHERE p.account_id = $1 AND p.name = $2`, user.ID, r.PathValue("name")))
+	if writeLookupError(w, err, user.ID) {
+		return
+	}
+	projectFiles, err := files.LoadProject(r.Context(), user.ID, p.ID)
+	if err != nil {
+		log.Printf("Failed to load files of project %d: %v", p.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to run project"))
+		return
+	}
+	archive, err := tarFiles(p.Name, projectFiles)
+	if err != nil {
+		log.Printf("Failed to pack project %d: %v", p.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to run project"))
+		return
+	}
+	env, err := secrets.Env(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to load secrets of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to run project"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), RunTimeout)
+	defer cancel()
+	dir := shellQuote(path.Join(sandbox.HomeDir, p.Name))
+	command := runCommand(p)
+	res, err := sandbox.Exec(ctx, user.ID, sandbox.ExecRequest{
+		Command: "rm -rf " + dir + " && mkdir -p " + dir + " && tar xf - -C " + dir,
+		Stdin:   archive,
+	})
+	if err == nil && res.ExitCode != 0 {
+		err = fmt.Errorf("tar exited with %d: %.200s", res.ExitCode, res.Stderr)
+	}
+	if err == nil {
+		res, err = sandbox.Exec(ctx, user.ID, sandbox.ExecRequest{Command: "cd " + dir + " && exec " + command, Env: env})
+	}
+	switch {
+	case errors.Is(err, sandbox.ErrPoolFull):
+		apierror.Write(w, apierror.Unavailable("All terminal sandboxes are busy; try again shortly"))
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		// As in the terminal, only a fresh container surely stops it.
+		if err := sandbox.Release(context.Background(), user.ID); err != nil {
+			log.Printf("Failed to stop sandbox of account %d: %v", user.ID, err)
+		}
+		res = &sandbox.ExecResult{
+			Stderr:   fmt.Sprintf("run timed out after %s; the sandbox was reset\n", RunTimeout),
+			ExitCode: 124,
+			Duration: RunTimeout,
+		}
+	case err != nil:
+		log.Printf("Failed to run project %d: %v", p.ID, err)
+		apierror.Write(w, apierror.Unavailable("The terminal sandbox failed; try again later"))
+		return
+	}
+	res.Stdout = secrets.Redact(res.Stdout, env)
+	res.Stderr = secrets.Redact(res.Stderr, env)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RunResult{Command: command, ExecResult: res})
+}
+
+// runCommand is the shell command running p's entrypoint.
+func runCommand(p *Project) string {
+	words := []string{"python3", shellQuote(p.Entrypoint)}
+	for _, arg := range p.Args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_./=:,+@%-]+$`)
+
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// tarFiles archives the files of project name with paths relative to its
+// directory.
+func tarFiles(name string, list []files.UserFile) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range list {
+		rel := path.Clean(strings.TrimPrefix(f.Filename, name+"/"))
+		if rel == "." || path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		hdr := &tar.Header{Name: rel, Mode: 0o644, Size: int64(len(f.Content)), ModTime: f.UpdatedAt, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(f.Content)); err != nil {
+			return nil, err
+		}
+	}
+	return &buf, tw.Close()
+}
+
+// ShareProjectHandler makes one of the caller's projects readable by
+// anyone with its link. Sharing again keeps the link.
+func ShareProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var token string
+	err = db.DB.QueryRowContext(r.Context(),
+		`UPDATE projects SET share_token = COALESCE(share_token, $1)
+		 WHERE account_id = $2 AND name = $3 RETURNING share_token`,
+		newShareToken(), user.ID, r.PathValue("name"),
+	).Scan(&token)
+	if writeLookupError(w, err, user.ID) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"share_url": shareURL(token)})
+}
+
+// UnshareProjectHandler revokes a project's link. Sharing it again makes a
+// new one.
+func UnshareProjectHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	res, err := db.DB.ExecContext(r.Context(),
+		`UPDATE projects SET share_token = NULL WHERE account_id = $1 AND name = $2`, user.ID, r.PathValue("name"))
+	if err != nil {
+		log.Printf("Failed to unshare project of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to unshare project"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Project not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SharedProjectHandler returns a shared project with its files. No login
+// is needed: the token is the capability.
+func SharedProjectHandler(w http.ResponseWriter, r *http.Request) {
+	var id, accountID int
+	var shared SharedProject
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT p.id, p.account_id, p.name, p.description, p.entrypoint, p.args, a.username
+		 FROM projects p JOIN accounts a ON a.id = p.account_id
+		 WHERE p.share_token = $1 AND `+login.NotSuspended("p.account_id"), r.PathValue("token"),
+	).Scan(&id, &accountID, &shared.Name, &shared.Description, &shared.Entrypoint, pq.Array(&shared.Args), &shared.Author)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Project not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load shared project: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load project"))
+		return
+	}
+	if shared.Args == nil {
+		shared.Args = []string{}
+	}
+	projectFiles, err := files.LoadProject(r.Context(), accountID, id)
+	if err != nil {
+		log.Printf("Failed to load files of shared project %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to load project"))
+		return
+	}
+	shared.Files = make([]SharedFile, len(projectFiles))
+	for i, f := range projectFiles {
+		shared.Files[i] = SharedFile{Filename: f.Filename, Content: f.Content, FileType: f.FileType, UpdatedAt: f.UpdatedAt}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shared)
+}
+
+// writeLookupError writes the error of looking up one of accountID's
+// projects, reporting whether there was one.
+func writeLookupError(w http.ResponseWriter, err error, accountID int) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, sql.ErrNoRows):
+		apierror.Write(w, apierror.NotFound("Project not found"))
+	default:
+		log.Printf("Failed to load project of account %d: %v", accountID, err)
+		apierror.Write(w, apierror.Internal("Failed to load project"))
+	}
+	return true
+}
+
+func newShareToken() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func shareURL(token string) string {
+	return "/api/projects/shared/" + token
+}
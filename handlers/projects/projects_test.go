@@ -0,0 +1,173 @@
+package projects
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/sandbox"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	originalDB := db.DB
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+func expectUser(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "user"))
+}
+
+func request(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	return req
+}
+
+var projectRowColumns = []string{"id", "name", "description", "entrypoint", "args", "share_token", "created_at", "updated_at", "files"}
+
+func TestCheckRequest(t *testing.T) {
+	req := ProjectRequest{Name: "blog"}
+	if apiErr := checkRequest(&req); apiErr != nil || req.Entrypoint != "main.py" || req.Args == nil {
+		t.Errorf("defaults: %v %+v", apiErr, req)
+	}
+	for _, entrypoint := range []string{"/etc/passwd", "../other/main.py", "src/../../x.py", "./main.py", ".."} {
+		req := ProjectRequest{Name: "blog", Entrypoint: entrypoint}
+		if checkRequest(&req) == nil {
+			t.Errorf("entrypoint %q accepted", entrypoint)
+		}
+	}
+	req = ProjectRequest{Name: "blog", Args: make([]string, MaxArgs+1)}
+	if checkRequest(&req) == nil {
+		t.Error("too many args accepted")
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	p := &Project{Entrypoint: "src/app.py", Args: []string{"--port=5000", "it's", "$(rm -rf ~)"}}
+	want := `python3 src/app.py --port=5000 'it'\''s' '$(rm -rf ~)'`
+	if got := runCommand(p); got != want {
+		t.Errorf("runCommand() = %s, want %s", got, want)
+	}
+}
+
+func TestTarFiles(t *testing.T) {
+	buf, err := tarFiles("blog", []files.UserFile{
+		{Filename: "blog/main.py", Content: "print(1)"},
+		{Filename: "blog/lib/util.py", Content: "X = 1"},
+		{Filename: "blog/../escape.py", Content: "no"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != "main.py,lib/util.py" {
+		t.Errorf("archived %v", names)
+	}
+}
+
+func TestCreateProject(t *testing.T) {
+	mock := setupMockDB(t)
+
+	expectUser(mock)
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\), COALESCE\\(BOOL_OR").WithArgs(7, "blog").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "exists"}).AddRow(1, false))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO projects").WithArgs(7, "blog", "", "app.py", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(3, time.Now(), time.Now()))
+	mock.ExpectExec("UPDATE user_files SET project_id").WithArgs(3, 7, "blog/%").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	rec := httptest.NewRecorder()
+	CreateProjectHandler(rec, request("POST", "/api/projects", `{"name":"blog","entrypoint":"app.py"}`))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"files":2`) {
+		t.Errorf("create = %d %s", rec.Code, rec.Body)
+	}
+
+	expectUser(mock)
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7, "blog").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "exists"}).AddRow(2, true))
+	rec = httptest.NewRecorder()
+	CreateProjectHandler(rec, request("POST", "/api/projects", `{"name":"blog"}`))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("create of an existing project = %d, want 409", rec.Code)
+	}
+
+	for _, name := range []string{"sandbox", "../x", ".hidden", ""} {
+		expectUser(mock)
+		rec = httptest.NewRecorder()
+		CreateProjectHandler(rec, request("POST", "/api/projects", `{"name":"`+name+`"}`))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("create of %q = %d, want 400", name, rec.Code)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunProject(t *testing.T) {
+	rt := &sandbox.Mock{Reply: sandbox.ExecResult{Stdout: "ok\n"}}
+	pool := sandbox.NewPool(rt, sandbox.PoolOptions{Max: 2})
+	sandbox.SetPool(pool)
+	t.Cleanup(func() {
+		sandbox.SetPool(nil)
+		pool.Close(context.Background())
+	})
+	mock := setupMockDB(t)
+
+	expectUser(mock)
+	mock.ExpectQuery("FROM projects p WHERE p.account_id = \\$1 AND p.name = \\$2").WithArgs(7, "blog").
+		WillReturnRows(sqlmock.NewRows(projectRowColumns).
+			AddRow(3, "blog", "", "app.py", "{--debug}", nil, time.Now(), time.Now(), 1))
+	mock.ExpectQuery("FROM user_files WHERE account_id = \\$1 AND project_id = \\$2").WithArgs(7, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "filename", "content", "file_type", "created_at", "updated_at", "key_id", "data_key", "scan_status"}).
+			AddRow(9, "blog/app.py", "print('ok')", "python", time.Now(), time.Now(), nil, nil, nil))
+
+	req := request("POST", "/api/projects/blog/run", "")
+	req.SetPathValue("name", "blog")
+	rec := httptest.NewRecorder()
+	RunProjectHandler(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"stdout":"ok\n"`) {
+		t.Fatalf("run = %d %s", rec.Code, rec.Body)
+	}
+	execs := rt.Execs()
+	if len(execs) != 2 || !strings.Contains(execs[0].Command, "tar xf - -C /home/sandbox/blog") ||
+		execs[1].Command != "cd /home/sandbox/blog && exec python3 app.py --debug" {
+		t.Errorf("Execs() = %+v", execs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
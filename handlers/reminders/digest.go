@@ -0,0 +1,348 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/mail"
+	"allanswebterminal/templates"
+)
+
+// The weekly digest summarises the past seven days of answers in
+// account_score. It goes to the address of the account's newest reminder,
+// the only email the site has for its users, unless the user opted out.
+const (
+	digestInterval = 7 * 24 * time.Hour
+	// streakLookback bounds how far back a streak is counted.
+	streakLookback = 365
+)
+
+// WeeklyStats is one account's study week, compared with the week before.
+// Days are UTC dates.
+type WeeklyStats struct {
+	Reviewed     int
+	Correct      int
+	PrevReviewed int
+	PrevCorrect  int
+	// Streak counts consecutive days with at least one answer, ending today
+	// or, when nothing was answered yet today, yesterday.
+	Streak       int
+	StudiedToday bool
+}
+
+type DigestPreference struct {
+	WeeklyDigest bool `json:"weekly_digest"`
+	// Email is where digests go: the address of the newest reminder, empty
+	// when there is none and so no digest is sent.
+	Email string `json:"email"`
+}
+
+type SetDigestRequest struct {
+	WeeklyDigest bool `json:"weekly_digest"`
+}
+
+// DigestPreferenceHandler reports whether the caller receives the weekly
+// digest, and where.
+func DigestPreferenceHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	writeDigestPreference(w, r, user.ID)
+}
+
+// SetDigestPreferenceHandler opts the caller in to or out of the weekly
+// digest.
+func SetDigestPreferenceHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req SetDigestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	_, err = db.DB.ExecContext(r.Context(),
+		`INSERT INTO study_digests (account_id, opted_out, unsubscribe_token) VALUES ($1, $2, $3)
+		 ON CONFLICT (account_id) DO UPDATE SET opted_out = EXCLUDED.opted_out`,
+		user.ID, !req.WeeklyDigest, newToken())
+	if err != nil {
+		log.Printf("Failed to save digest preference for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save digest preference"))
+		return
+	}
+	writeDigestPreference(w, r, user.ID)
+}
+
+func writeDigestPreference(w http.ResponseWriter, r *http.Request, accountID int) {
+	var pref DigestPreference
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT NOT COALESCE((SELECT opted_out FROM study_digests WHERE account_id = $1), FALSE),
+			COALESCE((SELECT email FROM reminders WHERE account_id = $1 ORDER BY id DESC LIMIT 1), '')`,
+		accountID,
+	).Scan(&pref.WeeklyDigest, &pref.Email)
+	if err != nil {
+		log.Printf("Failed to load digest preference for account %d: %v", accountID, err)
+		apierror.Write(w, apierror.Internal("Failed to load digest preference"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pref)
+}
+
+// DigestUnsubscribeHandler opts the account identified by ?token= out of
+// the weekly digest without a login. Like UnsubscribeHandler, GET only
+// asks for confirmation and POST opts out.
+func DigestUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	var found bool
+	var err error
+	if r.Method == http.MethodPost {
+		var result sql.Result
+		result, err = db.DB.ExecContext(r.Context(),
+			`UPDATE study_digests SET opted_out = TRUE WHERE unsubscribe_token = $1`, token)
+		if err == nil {
+			n, _ := result.RowsAffected()
+			found = n > 0
+		}
+	} else {
+		err = db.DB.QueryRowContext(r.Context(),
+			`SELECT EXISTS (SELECT 1 FROM study_digests WHERE unsubscribe_token = $1 AND NOT opted_out)`, token,
+		).Scan(&found)
+	}
+	if err != nil {
+		log.Printf("Failed to unsubscribe from digest: %v", err)
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost && oneClick(r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	data := unsubscribePage{Found: found, Digest: true, Confirm: r.Method != http.MethodPost}
+	if err := templates.Render(w, r, "unsubscribe", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SendDigests emails the weekly digest to every account that is due one
+// and answered a card in the last two weeks; idle accounts are skipped
+// until they study again.
+func SendDigests(ctx context.Context) error {
+	return sendDigests(ctx, time.Now())
+}
+
+type digestRecipient struct {
+	accountID int
+	username  string
+	email     string
+	token     string
+}
+
+func sendDigests(ctx context.Context, now time.Time) error {
+	recipients, err := loadDigestRecipients(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, rcpt := range recipients {
+		stats, err := loadWeeklyStats(ctx, rcpt.accountID, now)
+		if err != nil {
+			return fmt.Errorf("load stats for account %d: %w", rcpt.accountID, err)
+		}
+		if rcpt.token == "" {
+			rcpt.token = newToken()
+		}
+		sendErr := mail.Send(ctx, digestMessage(rcpt, stats, now))
+		if sendErr != nil {
+			log.Printf("Failed to send digest to account %d: %v", rcpt.accountID, sendErr)
+			failed++
+		}
+
+		// Like reminders, a failed digest waits for next week rather than
+		// being retried every run.
+		_, err = db.DB.ExecContext(ctx,
+			`INSERT INTO study_digests (account_id, unsubscribe_token, next_run_at, last_sent_at)
+			 VALUES ($1, $2, $3, CASE WHEN $4 THEN $5::TIMESTAMPTZ END)
+			 ON CONFLICT (account_id) DO UPDATE SET next_run_at = EXCLUDED.next_run_at,
+				last_sent_at = CASE WHEN $4 THEN $5::TIMESTAMPTZ ELSE study_digests.last_sent_at END`,
+			rcpt.accountID, rcpt.token, now.Add(digestInterval), sendErr == nil, now)
+		if err != nil {
+			return fmt.Errorf("reschedule digest for account %d: %w", rcpt.accountID, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d digests failed to send", failed, len(recipients))
+	}
+	return nil
+}
+
+func loadDigestRecipients(ctx context.Context, now time.Time) ([]digestRecipient, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT a.id, a.username, e.email, COALESCE(d.unsubscribe_token, '')
+		 FROM accounts a
+		 JOIN LATERAL (
+			SELECT email FROM reminders r WHERE r.account_id = a.id ORDER BY r.id DESC LIMIT 1
+		 ) e ON TRUE
+		 LEFT JOIN study_digests d ON d.account_id = a.id
+		 WHERE NOT COALESCE(d.opted_out, FALSE)
+		   AND (d.next_run_at IS NULL OR d.next_run_at <= $1)
+		   AND EXISTS (SELECT 1 FROM account_score s WHERE s.account_id = a.id AND s.answered_at >= $2)
+		 ORDER BY a.id
+		 LIMIT $3`, now, now.Add(-2*digestInterval), sendBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []digestRecipient
+	for rows.Next() {
+		var rcpt digestRecipient
+		if err := rows.Scan(&rcpt.accountID, &rcpt.username, &rcpt.email, &rcpt.token); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, rcpt)
+	}
+	return recipients, rows.Err()
+}
+
+func loadWeeklyStats(ctx context.Context, accountID int, now time.Time) (WeeklyStats, error) {
+	var stats WeeklyStats
+	weekStart := now.Add(-digestInterval)
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FILTER (WHERE answered_at >= $2),
+			COUNT(*) FILTER (WHERE answered_at >= $2 AND correct_answer),
+			COUNT(*) FILTER (WHERE answered_at < $2),
+			COUNT(*) FILTER (WHERE answered_at < $2 AND correct_answer)
+		 FROM account_score
+		 WHERE account_id = $1 AND answered_at >= $3 AND answered_at < $4`,
+		accountID, weekStart, weekStart.Add(-digestInterval), now,
+	).Scan(&stats.Reviewed, &stats.Correct, &stats.PrevReviewed, &stats.PrevCorrect)
+	if err != nil {
+		return stats, err
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT DISTINCT DATE(answered_at) AS day FROM account_score
+		 WHERE account_id = $1 AND answered_at >= $2
+		 ORDER BY day DESC`,
+		accountID, today.AddDate(0, 0, -streakLookback))
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	var days []time.Time
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			return stats, err
+		}
+		days = append(days, day)
+	}
+	stats.Streak, stats.StudiedToday = streak(days, today)
+	return stats, rows.Err()
+}
+
+// streak counts the consecutive days, newest first, that end today or
+// yesterday.
+func streak(days []time.Time, today time.Time) (int, bool) {
+	if len(days) == 0 {
+		return 0, false
+	}
+	studiedToday := sameDay(days[0], today)
+	expect := today
+	if !studiedToday {
+		expect = today.AddDate(0, 0, -1)
+	}
+	n := 0
+	for _, day := range days {
+		if !sameDay(day, expect) {
+			break
+		}
+		n++
+		expect = expect.AddDate(0, 0, -1)
+	}
+	return n, studiedToday
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.UTC().Date()
+	return ay == by && am == bm && ad == bd
+}
+
+func percent(correct, total int) int {
+	return (correct*100 + total/2) / total
+}
+
+// accuracyLine describes this week's accuracy and how it moved.
+func (s WeeklyStats) accuracyLine() string {
+	if s.Reviewed == 0 {
+		return "Accuracy: no cards answered this week"
+	}
+	pct := percent(s.Correct, s.Reviewed)
+	line := fmt.Sprintf("Accuracy: %d%%", pct)
+	if s.PrevReviewed == 0 {
+		return line + " (no cards answered the week before)"
+	}
+	switch prev := percent(s.PrevCorrect, s.PrevReviewed); {
+	case pct > prev:
+		return line + fmt.Sprintf(" (up from %d%%)", prev)
+	case pct < prev:
+		return line + fmt.Sprintf(" (down from %d%%)", prev)
+	}
+	return line + " (the same as the week before)"
+}
+
+func (s WeeklyStats) streakLine() string {
+	days := "days"
+	if s.Streak == 1 {
+		days = "day"
+	}
+	switch {
+	case s.Streak == 0:
+		return "Streak: none right now - one round today starts a new one"
+	case s.StudiedToday:
+		return fmt.Sprintf("Streak: %d %s, including today", s.Streak, days)
+	}
+	return fmt.Sprintf("Streak: %d %s - study today to keep it going", s.Streak, days)
+}
+
+func digestMessage(rcpt digestRecipient, stats WeeklyStats, now time.Time) mail.Message {
+	unsubscribe := PublicURL + "/digest/unsubscribe?token=" + url.QueryEscape(rcpt.token)
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nHere is your study week, the 7 days to %s:\n\n",
+		rcpt.username, now.UTC().Format("January 2"))
+	fmt.Fprintf(&body, "Cards reviewed: %d (the week before: %d)\n", stats.Reviewed, stats.PrevReviewed)
+	fmt.Fprintf(&body, "%s\n%s\n\n", stats.accuracyLine(), stats.streakLine())
+	fmt.Fprintf(&body, "Keep practising: %s/flashcards\n\n", PublicURL)
+	fmt.Fprintf(&body, "Stop these weekly emails: %s\n", unsubscribe)
+	return mail.Message{
+		To:      rcpt.email,
+		Subject: fmt.Sprintf("Your study week: %d cards reviewed", stats.Reviewed),
+		Body:    body.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}
+}
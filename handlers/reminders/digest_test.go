@@ -0,0 +1,139 @@
+package reminders
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/mail"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStreak(t *testing.T) {
+	today := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return today.AddDate(0, 0, -n) }
+
+	tests := []struct {
+		name      string
+		days      []time.Time
+		want      int
+		wantToday bool
+	}{
+		{"none", nil, 0, false},
+		{"today only", []time.Time{day(0)}, 1, true},
+		{"through today", []time.Time{day(0), day(1), day(2), day(4)}, 3, true},
+		{"through yesterday", []time.Time{day(1), day(2)}, 2, false},
+		{"broken", []time.Time{day(2), day(3)}, 0, false},
+	}
+	for _, tt := range tests {
+		got, gotToday := streak(tt.days, today)
+		if got != tt.want || gotToday != tt.wantToday {
+			t.Errorf("%s: streak = %d, %t, want %d, %t", tt.name, got, gotToday, tt.want, tt.wantToday)
+		}
+	}
+}
+
+func TestWeeklyStatsLines(t *testing.T) {
+	tests := []struct {
+		stats        WeeklyStats
+		wantAccuracy string
+		wantStreak   string
+	}{
+		{WeeklyStats{Reviewed: 10, Correct: 8, PrevReviewed: 4, PrevCorrect: 2, Streak: 3, StudiedToday: true},
+			"Accuracy: 80% (up from 50%)", "Streak: 3 days, including today"},
+		{WeeklyStats{Reviewed: 3, Correct: 1, PrevReviewed: 3, PrevCorrect: 3, Streak: 1},
+			"Accuracy: 33% (down from 100%)", "Streak: 1 day - study today"},
+		{WeeklyStats{Reviewed: 2, Correct: 1, PrevReviewed: 4, PrevCorrect: 2},
+			"(the same as the week before)", "Streak: none right now"},
+		{WeeklyStats{Reviewed: 5, Correct: 5}, "(no cards answered the week before)", ""},
+		{WeeklyStats{PrevReviewed: 5}, "no cards answered this week", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.stats.accuracyLine(); !strings.Contains(got, tt.wantAccuracy) {
+			t.Errorf("%+v: accuracyLine() = %q, want %q", tt.stats, got, tt.wantAccuracy)
+		}
+		if got := tt.stats.streakLine(); !strings.Contains(got, tt.wantStreak) {
+			t.Errorf("%+v: streakLine() = %q, want %q", tt.stats, got, tt.wantStreak)
+		}
+	}
+}
+
+// anyToken matches a newly generated unsubscribe token.
+type anyToken struct{}
+
+func (anyToken) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && len(s) == 32
+}
+
+func TestSendDigests(t *testing.T) {
+	mock := setupMockDB(t)
+	sender := &recordingSender{}
+	mail.SetSender(sender)
+	t.Cleanup(func() { mail.SetSender(mail.LogSender{}) })
+
+	now := time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+	mock.ExpectQuery("FROM accounts a").WithArgs(now, now.Add(-2*week), sendBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "unsubscribe_token"}).
+			AddRow(7, "ana", "ana@example.com", ""))
+	mock.ExpectQuery("COUNT").WithArgs(7, now.Add(-week), now.Add(-2*week), now).
+		WillReturnRows(sqlmock.NewRows([]string{"a", "b", "c", "d"}).AddRow(20, 15, 10, 5))
+	mock.ExpectQuery("SELECT DISTINCT DATE").
+		WillReturnRows(sqlmock.NewRows([]string{"day"}).
+			AddRow(time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)).
+			AddRow(time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectExec("INSERT INTO study_digests").
+		WithArgs(7, anyToken{}, now.Add(week), true, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := sendDigests(context.Background(), now); err != nil {
+		t.Fatalf("sendDigests: %v", err)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sender.sent))
+	}
+	msg := sender.sent[0]
+	for _, want := range []string{
+		"Hi ana", "Cards reviewed: 20 (the week before: 10)", "Accuracy: 75% (up from 50%)",
+		"Streak: 2 days - study today", "/digest/unsubscribe?token=",
+	} {
+		if !strings.Contains(msg.Body, want) {
+			t.Errorf("body missing %q:\n%s", want, msg.Body)
+		}
+	}
+	if msg.To != "ana@example.com" || !strings.Contains(msg.Headers["List-Unsubscribe"], "/digest/unsubscribe") {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDigestUnsubscribeHandler(t *testing.T) {
+	mock := setupMockDB(t)
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("tok").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	rr := httptest.NewRecorder()
+	DigestUnsubscribeHandler(rr, httptest.NewRequest("GET", "/digest/unsubscribe?token=tok", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Stop receiving the weekly study digest?") {
+		t.Errorf("GET: status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+
+	mock.ExpectExec("UPDATE study_digests SET opted_out = TRUE").WithArgs("tok").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	rr = httptest.NewRecorder()
+	DigestUnsubscribeHandler(rr, httptest.NewRequest("POST", "/digest/unsubscribe?token=tok", nil))
+	if !strings.Contains(rr.Body.String(), "no longer receive the weekly study digest") {
+		t.Errorf("confirmed POST: %s", rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,361 @@
+// Package reminders emails users a daily nudge to study a course at a local
+// time of their choosing. Due reminders are sent by a scheduler job.
+package reminders
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezones must resolve even on hosts without zoneinfo
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/mail"
+	"allanswebterminal/templates"
+)
+
+// sendBatch bounds how many reminders one scheduler run sends.
+const sendBatch = 200
+
+// PublicURL is the externally visible origin used for links in emails.
+var PublicURL = "http://localhost:8080"
+
+var localTimePattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):([0-5][0-9])$`)
+
+type Reminder struct {
+	ID         int        `json:"id"`
+	CourseID   int        `json:"course_id"`
+	Course     string     `json:"course"`
+	Email      string     `json:"email"`
+	Time       string     `json:"time"`
+	Timezone   string     `json:"timezone"`
+	NextRunAt  time.Time  `json:"next_run_at"`
+	LastSentAt *time.Time `json:"last_sent_at,omitempty"`
+}
+
+type SetReminderRequest struct {
+	CourseID int    `json:"course_id"`
+	Email    string `json:"email"`
+	Time     string `json:"time"`
+	Timezone string `json:"timezone"`
+}
+
+// ListRemindersHandler returns the caller's reminders.
+func ListRemindersHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT r.id, r.course_id, c.name, r.email, r.local_time, r.timezone, r.next_run_at, r.last_sent_at
+		 FROM reminders r
+		 JOIN courses c ON c.id = r.course_id
+		 WHERE r.account_id = $1
+		 ORDER BY c.name, r.id`, user.ID)
+	if err != nil {
+		log.Printf("Failed to list reminders for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load reminders"))
+		return
+	}
+	defer rows.Close()
+
+	list := []Reminder{}
+	for rows.Next() {
+		var rem Reminder
+		var lastSent sql.NullTime
+		err := rows.Scan(&rem.ID, &rem.CourseID, &rem.Course, &rem.Email, &rem.Time, &rem.Timezone, &rem.NextRunAt, &lastSent)
+		if err != nil {
+			log.Printf("Failed to scan reminder: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load reminders"))
+			return
+		}
+		if lastSent.Valid {
+			rem.LastSentAt = &lastSent.Time
+		}
+		list = append(list, rem)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// SetReminderHandler creates or replaces the caller's daily reminder for a
+// course. Time is "HH:MM" in the given IANA timezone, so delivery follows
+// daylight saving changes.
+func SetReminderHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req SetReminderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if !mail.ValidAddress(req.Email) {
+		apierror.Write(w, apierror.Validation("A valid email address is required"))
+		return
+	}
+	hour, minute, ok := parseLocalTime(req.Time)
+	if !ok {
+		apierror.Write(w, apierror.Validation("time must be HH:MM (24-hour)"))
+		return
+	}
+	loc, err := loadLocation(req.Timezone)
+	if err != nil {
+		apierror.Write(w, apierror.Validation("Unknown timezone"))
+		return
+	}
+
+	rem := Reminder{CourseID: req.CourseID, Email: req.Email, Time: req.Time, Timezone: loc.String()}
+	err = db.DB.QueryRowContext(r.Context(), `SELECT name FROM courses WHERE id = $1`, req.CourseID).Scan(&rem.Course)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Course not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load course %d for reminder: %v", req.CourseID, err)
+		apierror.Write(w, apierror.Internal("Failed to save reminder"))
+		return
+	}
+
+	rem.NextRunAt = nextRun(time.Now(), hour, minute, loc)
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO reminders (account_id, course_id, email, local_time, timezone, next_run_at, unsubscribe_token)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (account_id, course_id) DO UPDATE SET
+			email = EXCLUDED.email, local_time = EXCLUDED.local_time,
+			timezone = EXCLUDED.timezone, next_run_at = EXCLUDED.next_run_at
+		 RETURNING id`,
+		user.ID, rem.CourseID, rem.Email, rem.Time, rem.Timezone, rem.NextRunAt, newToken(),
+	).Scan(&rem.ID)
+	if err != nil {
+		log.Printf("Failed to save reminder for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save reminder"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rem)
+}
+
+// DeleteReminderHandler removes one of the caller's reminders.
+func DeleteReminderHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.BadRequest("Invalid reminder ID"))
+		return
+	}
+
+	result, err := db.DB.ExecContext(r.Context(),
+		`DELETE FROM reminders WHERE id = $1 AND account_id = $2`, id, user.ID)
+	if err != nil {
+		log.Printf("Failed to delete reminder %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to delete reminder"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Reminder not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unsubscribePage is the data of the unsubscribe page, shown for a course
+// reminder or, with Digest set, for the weekly digest. With Confirm set it
+// asks before unsubscribing, with a form posting back to the same URL.
+type unsubscribePage struct {
+	Found   bool
+	Course  string
+	Digest  bool
+	Confirm bool
+}
+
+// oneClick reports whether r is an RFC 8058 one-click unsubscribe sent by
+// a mail client, rather than the confirmation form.
+func oneClick(r *http.Request) bool {
+	return r.PostFormValue("List-Unsubscribe") == "One-Click"
+}
+
+// UnsubscribeHandler cancels the reminder identified by ?token= without a
+// login. GET only shows a confirmation page, since mail scanners follow
+// links; POST cancels, from that page or as the RFC 8058 one-click
+// unsubscribe, which answers with an empty 200.
+func UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT c.name FROM reminders r JOIN courses c ON c.id = r.course_id
+		 WHERE r.unsubscribe_token = $1`
+	if r.Method == http.MethodPost {
+		query = `DELETE FROM reminders r USING courses c
+		 WHERE r.unsubscribe_token = $1 AND c.id = r.course_id
+		 RETURNING c.name`
+	}
+	var course string
+	err := db.DB.QueryRowContext(r.Context(), query, r.URL.Query().Get("token")).Scan(&course)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to unsubscribe reminder: %v", err)
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost && oneClick(r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	data := unsubscribePage{Found: err == nil, Course: course, Confirm: r.Method != http.MethodPost}
+	if err := templates.Render(w, r, "unsubscribe", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SendDue emails every reminder whose time has come and schedules its next
+// delivery. Reminders missed while the server was down are sent once, not
+// once per missed day.
+func SendDue(ctx context.Context) error {
+	return sendDue(ctx, time.Now())
+}
+
+type dueReminder struct {
+	id       int
+	email    string
+	local    string
+	timezone string
+	token    string
+	courseID int
+	course   string
+}
+
+func sendDue(ctx context.Context, now time.Time) error {
+	due, err := loadDue(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, rem := range due {
+		sendErr := mail.Send(ctx, reminderMessage(rem))
+		if sendErr != nil {
+			log.Printf("Failed to send reminder %d: %v", rem.id, sendErr)
+			failed++
+		}
+
+		hour, minute, _ := parseLocalTime(rem.local)
+		loc, err := loadLocation(rem.timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		_, err = db.DB.ExecContext(ctx,
+			`UPDATE reminders SET next_run_at = $2,
+				last_sent_at = CASE WHEN $3 THEN $4 ELSE last_sent_at END
+			 WHERE id = $1`,
+			rem.id, nextRun(now, hour, minute, loc), sendErr == nil, now)
+		if err != nil {
+			return fmt.Errorf("reschedule reminder %d: %w", rem.id, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d reminders failed to send", failed, len(due))
+	}
+	return nil
+}
+
+func loadDue(ctx context.Context, now time.Time) ([]dueReminder, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT r.id, r.email, r.local_time, r.timezone, r.unsubscribe_token, c.id, c.name
+		 FROM reminders r
+		 JOIN courses c ON c.id = r.course_id
+		 WHERE r.next_run_at <= $1
+		 ORDER BY r.next_run_at
+		 LIMIT $2`, now, sendBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []dueReminder
+	for rows.Next() {
+		var rem dueReminder
+		if err := rows.Scan(&rem.id, &rem.email, &rem.local, &rem.timezone, &rem.token, &rem.courseID, &rem.course); err != nil {
+			return nil, err
+		}
+		due = append(due, rem)
+	}
+	return due, rows.Err()
+}
+
+func reminderMessage(rem dueReminder) mail.Message {
+	unsubscribe := PublicURL + "/reminders/unsubscribe?token=" + url.QueryEscape(rem.token)
+	body := fmt.Sprintf("Time for your daily %s practice!\n\n"+
+		"Start a round: %s/flashcards?course_id=%d\n\n"+
+		"You asked to be reminded every day at %s (%s).\n"+
+		"Stop these reminders: %s\n",
+		rem.course, PublicURL, rem.courseID, rem.local, rem.timezone, unsubscribe)
+	return mail.Message{
+		To:      rem.email,
+		Subject: "Study reminder: " + rem.course,
+		Body:    body,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}
+}
+
+// nextRun returns the first hour:minute in loc strictly after after. Local
+// times skipped by a daylight saving jump are normalized forward by
+// time.Date.
+func nextRun(after time.Time, hour, minute int, loc *time.Location) time.Time {
+	local := after.In(loc)
+	for day := local.Day(); ; day++ {
+		next := time.Date(local.Year(), local.Month(), day, hour, minute, 0, 0, loc)
+		if next.After(after) {
+			return next
+		}
+	}
+}
+
+func parseLocalTime(s string) (int, int, bool) {
+	m := localTimePattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute, _ := strconv.Atoi(m[2])
+	return hour, minute, true
+}
+
+// loadLocation accepts IANA zone names only; the empty name and "Local"
+// would silently mean the server's zone.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, errors.New("timezone is required")
+	}
+	return time.LoadLocation(name)
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
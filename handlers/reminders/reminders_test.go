@@ -0,0 +1,198 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/mail"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+type recordingSender struct {
+	sent []mail.Message
+	err  error
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg mail.Message) error {
+	s.sent = append(s.sent, msg)
+	return s.err
+}
+
+func TestNextRun(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		after time.Time
+		want  time.Time
+	}{
+		{"later today", time.Date(2026, 3, 2, 8, 0, 0, 0, ny), time.Date(2026, 3, 2, 9, 0, 0, 0, ny)},
+		{"exactly due moves to tomorrow", time.Date(2026, 3, 2, 9, 0, 0, 0, ny), time.Date(2026, 3, 3, 9, 0, 0, 0, ny)},
+		{"across DST start", time.Date(2026, 3, 7, 10, 0, 0, 0, ny), time.Date(2026, 3, 8, 9, 0, 0, 0, ny)},
+		{"month end", time.Date(2026, 1, 31, 22, 0, 0, 0, ny), time.Date(2026, 2, 1, 9, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextRun(tt.after, 9, 0, ny)
+			if !got.Equal(tt.want) {
+				t.Errorf("nextRun = %v, want %v", got, tt.want)
+			}
+			if h, m, _ := got.In(ny).Clock(); h != 9 || m != 0 {
+				t.Errorf("local delivery time = %02d:%02d, want 09:00", h, m)
+			}
+		})
+	}
+
+	// The server's own zone must not matter: the same instant gives the
+	// same answer whichever location it is expressed in.
+	after := time.Date(2026, 3, 2, 8, 0, 0, 0, ny)
+	if !nextRun(after.UTC(), 9, 0, ny).Equal(nextRun(after, 9, 0, ny)) {
+		t.Error("nextRun depends on the location of its input")
+	}
+}
+
+func TestParseLocalTime(t *testing.T) {
+	for in, ok := range map[string]bool{"09:00": true, "23:59": true, "24:00": false, "9:00": false, "09:60": false, "": false} {
+		if _, _, got := parseLocalTime(in); got != ok {
+			t.Errorf("parseLocalTime(%q) ok = %v, want %v", in, got, ok)
+		}
+	}
+}
+
+func TestSetReminderHandlerValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"bad email", `{"course_id":1,"email":"nope","time":"09:00","timezone":"UTC"}`},
+		{"bad time", `{"course_id":1,"email":"ana@example.com","time":"9am","timezone":"UTC"}`},
+		{"bad timezone", `{"course_id":1,"email":"ana@example.com","time":"09:00","timezone":"Mars/Olympus"}`},
+		{"server timezone", `{"course_id":1,"email":"ana@example.com","time":"09:00","timezone":"Local"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			mock.ExpectQuery("SELECT id, username, role FROM accounts").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "user"))
+
+			req := httptest.NewRequest(http.MethodPut, "/api/reminders", strings.NewReader(tt.body))
+			req.AddCookie(securecookie.Cookie("user_id", "7"))
+			rec := httptest.NewRecorder()
+			SetReminderHandler(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSendDue(t *testing.T) {
+	mock := setupMockDB(t)
+	sender := &recordingSender{}
+	mail.SetSender(sender)
+	t.Cleanup(func() { mail.SetSender(mail.LogSender{}) })
+
+	now := time.Date(2026, 3, 2, 14, 0, 30, 0, time.UTC) // 09:00:30 in New York
+	mock.ExpectQuery("SELECT r.id, r.email").WithArgs(now, sendBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "local_time", "timezone", "unsubscribe_token", "course_id", "name"}).
+			AddRow(4, "ana@example.com", "09:00", "America/New_York", "tok", 2, "AWS Basics"))
+	ny, _ := time.LoadLocation("America/New_York")
+	mock.ExpectExec("UPDATE reminders SET next_run_at").
+		WithArgs(4, time.Date(2026, 3, 3, 9, 0, 0, 0, ny), true, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := sendDue(context.Background(), now); err != nil {
+		t.Fatalf("sendDue: %v", err)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sender.sent))
+	}
+	msg := sender.sent[0]
+	if msg.To != "ana@example.com" || !strings.Contains(msg.Body, "/reminders/unsubscribe?token=tok") ||
+		!strings.Contains(msg.Headers["List-Unsubscribe"], "token=tok") {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSendDueReschedulesFailures(t *testing.T) {
+	mock := setupMockDB(t)
+	mail.SetSender(&recordingSender{err: errors.New("relay down")})
+	t.Cleanup(func() { mail.SetSender(mail.LogSender{}) })
+
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT r.id, r.email").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "local_time", "timezone", "unsubscribe_token", "course_id", "name"}).
+			AddRow(4, "ana@example.com", "09:00", "UTC", "tok", 2, "AWS Basics"))
+	mock.ExpectExec("UPDATE reminders SET next_run_at").
+		WithArgs(4, time.Date(2026, 3, 3, 9, 0, 0, 0, time.UTC), false, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := sendDue(context.Background(), now); err == nil {
+		t.Error("expected sendDue to report the failed send")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnsubscribeHandler(t *testing.T) {
+	mock := setupMockDB(t)
+
+	// A link scanner fetching the page must not cancel the reminder.
+	mock.ExpectQuery("SELECT c.name FROM reminders").WithArgs("tok").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("AWS Basics"))
+	rr := httptest.NewRecorder()
+	UnsubscribeHandler(rr, httptest.NewRequest("GET", "/reminders/unsubscribe?token=tok", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `<form method="post"`) {
+		t.Errorf("GET: status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+
+	mock.ExpectQuery("DELETE FROM reminders").WithArgs("tok").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("AWS Basics"))
+	req := httptest.NewRequest("POST", "/reminders/unsubscribe?token=tok", strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	UnsubscribeHandler(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+		t.Errorf("one-click POST: status = %d, body = %s", rr.Code, rr.Body.String())
+	}
+
+	mock.ExpectQuery("DELETE FROM reminders").WithArgs("tok").WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	rr = httptest.NewRecorder()
+	UnsubscribeHandler(rr, httptest.NewRequest("POST", "/reminders/unsubscribe?token=tok", nil))
+	if !strings.Contains(rr.Body.String(), "already cancelled") {
+		t.Errorf("confirmed POST of a cancelled reminder: %s", rr.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
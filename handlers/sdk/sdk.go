@@ -0,0 +1,471 @@
+// Package sdk serves the OpenAPI document of the public JSON APIs (files,
+// flashcards and the simulated AWS services) and typed clients generated
+// from it.
+package sdk
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/handlers/cloudsim"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/flashcards"
+	"allanswebterminal/handlers/iam"
+	"allanswebterminal/health"
+	"allanswebterminal/openapi"
+)
+
+// Prefixes are the API paths the document covers. Every route registered
+// under them must be listed in routes; a test in package main checks this.
+var Prefixes = []string{"/api/files/", "/api/flashcards/", "/api/iam/", "/api/organizations/", "/api/cloudsim/"}
+
+// Title names the API in the document and the generated clients.
+const Title = "AllansWebTerminal"
+
+var (
+	intID     = []openapi.Param{{Name: "id", Type: "integer"}}
+	cardPath  = []openapi.Param{{Name: "id", Type: "integer"}, {Name: "card_id", Type: "integer"}}
+	paging    = []openapi.Param{{Name: "limit", Type: "integer"}, {Name: "offset", Type: "integer"}, {Name: "sort", Type: "string", Description: `sort key, "-" prefix for descending`}}
+	session   = []openapi.Param{{Name: "session_id", Type: "string", Required: true}}
+	policyARN = []openapi.Param{{Name: "policy_arn", Type: "string", Required: true}}
+	// scoreFilters are shared by the score history and its CSV export.
+	scoreFilters = []openapi.Param{
+		{Name: "course_id", Type: "integer"}, {Name: "flashcard_id", Type: "integer"}, {Name: "correct", Type: "boolean"},
+		{Name: "from", Type: "string", Description: "RFC 3339 time or YYYY-MM-DD"},
+		{Name: "to", Type: "string", Description: "RFC 3339 time or YYYY-MM-DD, exclusive; a date includes that day"},
+	}
+	// versionParams name one saved version of a file.
+	versionParams = []openapi.Param{
+		{Name: "filename", Type: "string", Required: true},
+		{Name: "version", Type: "integer", Required: true},
+	}
+)
+
+// Responses that handlers build as maps are described by anonymous structs,
+// which the client generators name after the operation.
+var (
+	startGameResponse = struct {
+		SessionID      string                 `json:"session_id"`
+		TotalQuestions int                    `json:"total_questions"`
+		FirstCard      flashcards.Flashcard   `json:"first_card"`
+		Flashcards     []flashcards.Flashcard `json:"flashcards"`
+		Seed           *int64                 `json:"seed,omitempty"`
+	}{}
+	starResponse = struct {
+		Starred bool `json:"starred"`
+		Stars   int  `json:"stars"`
+	}{}
+)
+
+func routes() []openapi.Route {
+	return []openapi.Route{
+		// Files
+		{Pattern: "POST /api/files/save", ID: "saveFile", Tag: "files", Summary: "Create or overwrite a file",
+			Body: files.UserFile{}, Response: files.UserFile{}},
+		{Pattern: "GET /api/files/load", ID: "loadFile", Tag: "files", Summary: "Load a file by name",
+			Query: []openapi.Param{{Name: "filename", Type: "string", Required: true}}, Response: files.UserFile{}},
+		{Pattern: "GET /api/files/list", ID: "listFiles", Tag: "files", Summary: "List files without their content",
+			Query: append(paging[:3:3], openapi.Param{Name: "type", Type: "string"}, openapi.Param{Name: "q", Type: "string"}),
+			Response: struct {
+				Items  []files.UserFile `json:"items"`
+				Total  int              `json:"total"`
+				Limit  int              `json:"limit"`
+				Offset int              `json:"offset"`
+			}{}},
+		{Pattern: "DELETE /api/files/delete", ID: "deleteFile", Tag: "files", Summary: "Delete a file by name",
+			Query: []openapi.Param{{Name: "filename", Type: "string", Required: true}},
+			Response: struct {
+				Message string `json:"message"`
+			}{}},
+		{Pattern: "POST /api/files/import-git", ID: "importGitRepository", Tag: "files", Summary: "Import a public GitHub repository in the background",
+			Body: files.GitImportRequest{}, Status: http.StatusAccepted,
+			Response: struct {
+				OperationID string `json:"operation_id"`
+				EventsURL   string `json:"events_url"`
+			}{}},
+		{Pattern: "GET /api/files/versions", ID: "listFileVersions", Tag: "files", Summary: "List the saved versions of a file, newest first",
+			Query: []openapi.Param{{Name: "filename", Type: "string", Required: true}}, Response: []files.FileVersion{}},
+		{Pattern: "GET /api/files/versions/load", ID: "loadFileVersion", Tag: "files", Summary: "Load a saved version of a file",
+			Query: versionParams, Response: files.FileVersion{}},
+		{Pattern: "POST /api/files/versions/restore", ID: "restoreFileVersion", Tag: "files", Summary: "Save a past version of a file as its content",
+			Query: versionParams, Response: files.UserFile{}},
+
+		// Flashcards
+		{Pattern: "GET /api/flashcards/courses", ID: "listCourses", Tag: "flashcards", Response: []flashcards.Course{}},
+		{Pattern: "GET /api/flashcards/guest", ID: "listGuestFlashcards", Tag: "flashcards", Response: []flashcards.Flashcard{}},
+		{Pattern: "POST /api/flashcards/start", ID: "startGame", Tag: "flashcards", Summary: "Start a game of a course",
+			Query:    []openapi.Param{{Name: "course_id", Type: "integer", Required: true}, {Name: "shuffle", Type: "boolean"}, {Name: "seed", Type: "integer"}},
+			Response: startGameResponse},
+		{Pattern: "POST /api/flashcards/start-guest", ID: "startGuestGame", Tag: "flashcards", Summary: "Start a game of selected guest cards",
+			Body: struct {
+				FlashcardIDs []int `json:"flashcard_ids"`
+				Shuffle      bool  `json:"shuffle"`
+			}{}, Response: startGameResponse},
+		{Pattern: "POST /api/flashcards/restart", ID: "restartGame", Tag: "flashcards", Summary: "Start a game again in the order of an earlier shuffled game",
+			Body: flashcards.RestartGameRequest{}, Response: startGameResponse},
+		{Pattern: "POST /api/flashcards/answer", ID: "submitAnswer", Tag: "flashcards",
+			Query: session, Body: flashcards.AnswerRequest{}, Response: flashcards.AnswerResponse{}},
+		{Pattern: "POST /api/flashcards/pause", ID: "pauseGame", Tag: "flashcards", Summary: "Stop a game's clock; answers are refused until it resumes",
+			Query: session, Response: flashcards.SessionState{}},
+		{Pattern: "POST /api/flashcards/resume", ID: "resumeGame", Tag: "flashcards", Summary: "Restart a paused game's clock",
+			Query: session, Response: flashcards.SessionState{}},
+		{Pattern: "GET /api/flashcards/session", ID: "getSessionState", Tag: "flashcards", Summary: "State of one of your games, to join it from another device",
+			Query: session, Response: flashcards.SessionState{}},
+		{Pattern: "POST /api/flashcards/import", ID: "importDeck", Tag: "flashcards", Summary: "Import a deck in the background",
+			Body: flashcards.ImportDeckRequest{}, Status: http.StatusAccepted,
+			Response: struct {
+				OperationID string `json:"operation_id"`
+				EventsURL   string `json:"events_url"`
+			}{}},
+		{Pattern: "GET /api/flashcards/review/next", ID: "nextReviewCard", Tag: "flashcards",
+			Summary:  "Next card due for review in a course; the answer only with reveal=true",
+			Query:    []openapi.Param{{Name: "course_id", Type: "integer", Required: true}, {Name: "reveal", Type: "boolean"}},
+			Response: flashcards.ReviewNextResponse{}},
+		{Pattern: "POST /api/flashcards/review/grade", ID: "gradeReviewCard", Tag: "flashcards", Summary: "Self-grade a reviewed card",
+			Body: flashcards.ReviewGradeRequest{}, Response: flashcards.ReviewGradeResponse{}},
+		{Pattern: "POST /api/flashcards/occlusion", ID: "createOcclusionCards", Tag: "flashcards",
+			Summary: "Add one card per masked region of an image to a course",
+			Body:    flashcards.OcclusionRequest{}, Status: http.StatusCreated, Response: flashcards.OcclusionResult{}},
+		{Pattern: "POST /api/flashcards/generate", ID: "generateCards", Tag: "flashcards",
+			Summary: "Draft cards from notes or a saved file into a new draft course",
+			Body:    flashcards.GenerateCardsRequest{}, Status: http.StatusCreated, Response: flashcards.GenerateCardsResult{}},
+		{Pattern: "POST /api/flashcards/ocr", ID: "readCardsFromPhoto", Tag: "flashcards",
+			Summary: "Read card candidates from a photo of notes, for import",
+			Body:    flashcards.OCRRequest{}, Response: flashcards.OCRResult{}},
+		{Pattern: "POST /api/flashcards/explain", ID: "explainCard", Tag: "flashcards",
+			Summary: "Explain a card's answer, with follow-up questions",
+			Body:    flashcards.ExplainRequest{}, Response: flashcards.ExplainResponse{}},
+		{Pattern: "POST /api/flashcards/courses/{id}/ready", ID: "markCourseReady", Tag: "flashcards",
+			Summary: "Finish reviewing a draft course", Path: intID,
+			Response: struct {
+				CourseID int  `json:"course_id"`
+				Draft    bool `json:"draft"`
+			}{}},
+		{Pattern: "GET /api/flashcards/courses/{id}/distractors", ID: "previewDistractors", Tag: "flashcards",
+			Summary: "Suggest wrong options to make cards multiple choice", Path: intID,
+			Query:    []openapi.Param{{Name: "card_id", Type: "integer"}, {Name: "count", Type: "integer"}},
+			Response: flashcards.DistractorsResponse{}},
+		{Pattern: "POST /api/flashcards/courses/{id}/distractors", ID: "acceptDistractors", Tag: "flashcards",
+			Summary: "Save the wrong options of multiple-choice cards", Path: intID,
+			Body: flashcards.AcceptDistractorsRequest{}, Response: []flashcards.Flashcard{}},
+		{Pattern: "GET /api/flashcards/duplicates", ID: "findDuplicates", Tag: "flashcards", Summary: "Find near-duplicate cards",
+			Query:    []openapi.Param{{Name: "course_id", Type: "integer"}, {Name: "threshold", Type: "number"}},
+			Response: flashcards.DuplicatesResponse{}},
+		{Pattern: "POST /api/flashcards/merge", ID: "mergeCards", Tag: "flashcards",
+			Body: flashcards.MergeRequest{}, Response: flashcards.MergeResult{}},
+		{Pattern: "GET /api/flashcards/scores", ID: "listScores", Tag: "flashcards", Summary: "Your saved answers",
+			Query: append(paging[:3:3], scoreFilters...),
+			Response: struct {
+				Items  []flashcards.ScoreRecord `json:"items"`
+				Total  int                      `json:"total"`
+				Limit  int                      `json:"limit"`
+				Offset int                      `json:"offset"`
+			}{}},
+		{Pattern: "GET /api/flashcards/scores/export.csv", ID: "exportScores", Tag: "flashcards",
+			Summary: "Download your saved answers as CSV", Query: append(paging[2:3:3], scoreFilters...), Media: "text/csv"},
+		{Pattern: "GET /api/flashcards/{id}/stats", ID: "getCardStats", Tag: "flashcards",
+			Path: intID, Response: flashcards.CardStats{}},
+		{Pattern: "GET /api/flashcards/courses/{id}/hardest", ID: "getHardestCards", Tag: "flashcards",
+			Path: intID, Query: []openapi.Param{{Name: "limit", Type: "integer"}, {Name: "min_attempts", Type: "integer"}},
+			Response: struct {
+				CourseID int                    `json:"course_id"`
+				Cards    []flashcards.CardStats `json:"cards"`
+			}{}},
+		{Pattern: "GET /api/flashcards/courses/{id}/scoring", ID: "getScoringRules", Tag: "flashcards",
+			Path: intID, Response: flashcards.ScoringRules{}},
+		{Pattern: "PUT /api/flashcards/courses/{id}/scoring", ID: "setScoringRules", Tag: "flashcards",
+			Path: intID, Body: flashcards.ScoringRules{}, Response: flashcards.ScoringRules{}},
+		{Pattern: "PUT /api/flashcards/courses/{id}", ID: "updateCourse", Tag: "flashcards",
+			Path: intID, Body: flashcards.UpdateCourseRequest{}, Response: flashcards.Course{}},
+		{Pattern: "DELETE /api/flashcards/courses/{id}", ID: "deleteCourse", Tag: "flashcards", Path: intID},
+		{Pattern: "POST /api/flashcards/courses/{id}/cards", ID: "addCard", Tag: "flashcards",
+			Path: intID, Body: flashcards.Flashcard{}, Status: http.StatusCreated, Response: flashcards.Flashcard{}},
+		{Pattern: "PUT /api/flashcards/courses/{id}/cards/{card_id}", ID: "updateCard", Tag: "flashcards",
+			Path: cardPath, Body: flashcards.Flashcard{}, Response: flashcards.Flashcard{}},
+		{Pattern: "DELETE /api/flashcards/courses/{id}/cards/{card_id}", ID: "deleteCard", Tag: "flashcards", Path: cardPath},
+		{Pattern: "PUT /api/flashcards/courses/{id}/cards/{card_id}/challenge", ID: "setCardChallenge", Tag: "flashcards",
+			Summary: "Make a card a code challenge; its answer must pass the tests", Path: cardPath,
+			Body: flashcards.Challenge{}, Response: flashcards.Challenge{}},
+		{Pattern: "DELETE /api/flashcards/courses/{id}/cards/{card_id}/challenge", ID: "removeCardChallenge", Tag: "flashcards",
+			Path: cardPath},
+		{Pattern: "POST /api/flashcards/{id}/challenge/submissions", ID: "submitChallenge", Tag: "flashcards",
+			Summary: "Run code against a code challenge and keep a snapshot", Path: intID,
+			Body: flashcards.SubmitChallengeRequest{}, Status: http.StatusCreated, Response: flashcards.ChallengeSubmission{}},
+		{Pattern: "GET /api/flashcards/{id}/challenge/submissions", ID: "listChallengeSubmissions", Tag: "flashcards",
+			Path: intID, Response: []flashcards.ChallengeSubmission{}},
+		{Pattern: "PUT /api/flashcards/courses/{id}/cards/{card_id}/explanation", ID: "setCardExplanation", Tag: "flashcards",
+			Summary: "Store the explanation shown when a user asks why", Path: cardPath,
+			Body: flashcards.SetExplanationRequest{},
+			Response: struct {
+				FlashcardID int    `json:"flashcard_id"`
+				Explanation string `json:"explanation"`
+			}{}},
+		{Pattern: "GET /api/flashcards/courses/{id}/collaborators", ID: "listCollaborators", Tag: "flashcards",
+			Path: intID, Response: []flashcards.Collaborator{}},
+		{Pattern: "POST /api/flashcards/courses/{id}/collaborators", ID: "inviteCollaborator", Tag: "flashcards",
+			Summary: "Add a collaborator by username", Path: intID,
+			Body: flashcards.InviteCollaboratorRequest{}, Status: http.StatusCreated, Response: flashcards.Collaborator{}},
+		{Pattern: "PUT /api/flashcards/courses/{id}/collaborators/{username}", ID: "setCollaboratorRole", Tag: "flashcards",
+			Path: intID, Body: flashcards.SetCollaboratorRoleRequest{}, Response: flashcards.Collaborator{}},
+		{Pattern: "DELETE /api/flashcards/courses/{id}/collaborators/{username}", ID: "removeCollaborator", Tag: "flashcards",
+			Path: intID},
+		{Pattern: "GET /api/flashcards/gallery", ID: "listGallery", Tag: "flashcards", Summary: "Browse published decks",
+			Query: append(paging[:3:3], openapi.Param{Name: "category", Type: "string"}, openapi.Param{Name: "q", Type: "string"}),
+			Response: struct {
+				Items  []flashcards.GalleryDeck `json:"items"`
+				Total  int                      `json:"total"`
+				Limit  int                      `json:"limit"`
+				Offset int                      `json:"offset"`
+			}{}},
+		{Pattern: "GET /api/flashcards/gallery/categories", ID: "listGalleryCategories", Tag: "flashcards",
+			Response: []flashcards.CategoryCount{}},
+		{Pattern: "PUT /api/flashcards/gallery/{id}", ID: "publishDeck", Tag: "flashcards",
+			Path: intID, Body: flashcards.PublishDeckRequest{},
+			Response: struct {
+				CourseID int    `json:"course_id"`
+				Category string `json:"category"`
+				Status   string `json:"status"`
+			}{}},
+		{Pattern: "DELETE /api/flashcards/gallery/{id}", ID: "unpublishDeck", Tag: "flashcards", Path: intID},
+		{Pattern: "PUT /api/flashcards/gallery/{id}/star", ID: "starDeck", Tag: "flashcards", Path: intID, Response: starResponse},
+		{Pattern: "DELETE /api/flashcards/gallery/{id}/star", ID: "unstarDeck", Tag: "flashcards", Path: intID, Response: starResponse},
+		{Pattern: "PUT /api/flashcards/gallery/{id}/rating", ID: "rateDeck", Tag: "flashcards",
+			Path: intID, Body: flashcards.RateDeckRequest{},
+			Response: struct {
+				Rating   float64 `json:"rating"`
+				Ratings  int     `json:"ratings"`
+				MyRating int     `json:"my_rating"`
+			}{}},
+		{Pattern: "POST /api/flashcards/gallery/{id}/clone", ID: "cloneDeck", Tag: "flashcards",
+			Path: intID, Status: http.StatusCreated, Response: flashcards.ImportDeckResult{}},
+
+		// IAM
+		{Pattern: "GET /api/iam/users", ID: "listIamUsers", Tag: "iam", Response: []iam.IAMUser{}},
+		{Pattern: "POST /api/iam/users", ID: "createIamUser", Tag: "iam",
+			Body: iam.CreateUserRequest{}, Response: iam.IAMUser{}},
+		{Pattern: "GET /api/iam/users/{name}", ID: "getIamUser", Tag: "iam", Response: iam.IAMUser{}},
+		{Pattern: "DELETE /api/iam/users/{name}", ID: "deleteIamUser", Tag: "iam"},
+		{Pattern: "PUT /api/iam/users/{name}/permissions-boundary", ID: "putUserPermissionsBoundary", Tag: "iam",
+			Summary: "Cap a user's permissions with a managed policy", Body: iam.PolicyARNRequest{}},
+		{Pattern: "DELETE /api/iam/users/{name}/permissions-boundary", ID: "deleteUserPermissionsBoundary", Tag: "iam"},
+		{Pattern: "POST /api/iam/users/{name}/attached-policies", ID: "attachUserPolicy", Tag: "iam", Body: iam.PolicyARNRequest{}},
+		{Pattern: "DELETE /api/iam/users/{name}/attached-policies", ID: "detachUserPolicy", Tag: "iam", Query: policyARN},
+		{Pattern: "POST /api/iam/users/{name}/login-profile", ID: "createLoginProfile", Tag: "iam", Summary: "Set a user's console password",
+			Body: iam.LoginProfileRequest{}, Status: http.StatusCreated, Response: iam.LoginProfile{}},
+		{Pattern: "GET /api/iam/users/{name}/login-profile", ID: "getLoginProfile", Tag: "iam", Response: iam.LoginProfile{}},
+		{Pattern: "PUT /api/iam/users/{name}/login-profile", ID: "updateLoginProfile", Tag: "iam", Summary: "Change a user's console password",
+			Body: iam.LoginProfileRequest{}, Response: iam.LoginProfile{}},
+		{Pattern: "DELETE /api/iam/users/{name}/login-profile", ID: "deleteLoginProfile", Tag: "iam"},
+		{Pattern: "POST /api/iam/console-signin", ID: "consoleSignIn", Tag: "iam", Summary: "Simulate a user signing in to the console",
+			Body: iam.ConsoleSignInRequest{}, Response: iam.ConsoleSignInResult{}},
+		{Pattern: "GET /api/iam/account-password-policy", ID: "getPasswordPolicy", Tag: "iam", Response: iam.PasswordPolicy{}},
+		{Pattern: "PUT /api/iam/account-password-policy", ID: "updatePasswordPolicy", Tag: "iam",
+			Body: iam.PasswordPolicy{}, Response: iam.PasswordPolicy{}},
+		{Pattern: "DELETE /api/iam/account-password-policy", ID: "deletePasswordPolicy", Tag: "iam", Summary: "Revert to the default password policy"},
+		{Pattern: "GET /api/iam/credential-report", ID: "getCredentialReport", Tag: "iam", Response: iam.CredentialReport{}},
+		{Pattern: "GET /api/iam/roles", ID: "listIamRoles", Tag: "iam", Response: []iam.IAMRole{}},
+		{Pattern: "POST /api/iam/roles", ID: "createIamRole", Tag: "iam",
+			Body: iam.CreateRoleRequest{}, Response: iam.IAMRole{}},
+		{Pattern: "PUT /api/iam/roles/{name}/permissions-boundary", ID: "putRolePermissionsBoundary", Tag: "iam",
+			Summary: "Cap a role's permissions with a managed policy", Body: iam.PolicyARNRequest{}},
+		{Pattern: "DELETE /api/iam/roles/{name}/permissions-boundary", ID: "deleteRolePermissionsBoundary", Tag: "iam"},
+		{Pattern: "POST /api/iam/roles/{name}/attached-policies", ID: "attachRolePolicy", Tag: "iam", Body: iam.PolicyARNRequest{}},
+		{Pattern: "DELETE /api/iam/roles/{name}/attached-policies", ID: "detachRolePolicy", Tag: "iam", Query: policyARN},
+		{Pattern: "GET /api/iam/policies", ID: "listIamPolicies", Tag: "iam", Summary: "List customer managed policies", Response: []iam.IAMPolicy{}},
+		{Pattern: "POST /api/iam/policies", ID: "createIamPolicy", Tag: "iam",
+			Body: iam.CreatePolicyRequest{}, Status: http.StatusCreated, Response: iam.IAMPolicy{}},
+		{Pattern: "GET /api/iam/policies/{name}/versions", ID: "listIamPolicyVersions", Tag: "iam", Summary: "List a policy's versions, newest first",
+			Response: []iam.PolicyVersion{}},
+		{Pattern: "POST /api/iam/policies/{name}/versions", ID: "createIamPolicyVersion", Tag: "iam", Summary: "Add a version to a policy; at most five are kept",
+			Body: iam.CreatePolicyVersionRequest{}, Status: http.StatusCreated, Response: iam.PolicyVersion{}},
+		{Pattern: "GET /api/iam/policies/{name}/versions/{version_id}", ID: "getIamPolicyVersion", Tag: "iam", Response: iam.PolicyVersion{}},
+		{Pattern: "DELETE /api/iam/policies/{name}/versions/{version_id}", ID: "deleteIamPolicyVersion", Tag: "iam", Summary: "Delete a version other than the default"},
+		{Pattern: "PUT /api/iam/policies/{name}/default-version", ID: "setIamPolicyDefaultVersion", Tag: "iam", Summary: "Choose the version the simulator evaluates",
+			Body: iam.SetDefaultPolicyVersionRequest{}, Response: iam.PolicyVersion{}},
+		{Pattern: "GET /api/iam/search", ID: "searchIam", Tag: "iam", Summary: "Search users, roles, groups and policies by name, ARN or tag",
+			Query: append(paging[:3:3], openapi.Param{Name: "q", Type: "string", Required: true},
+				openapi.Param{Name: "type", Type: "string", Description: "user, role, group or policy"}),
+			Response: struct {
+				Items  []iam.SearchResult `json:"items"`
+				Total  int                `json:"total"`
+				Limit  int                `json:"limit"`
+				Offset int                `json:"offset"`
+			}{}},
+		{Pattern: "POST /api/iam/simulate", ID: "simulatePrincipalPolicy", Tag: "iam", Summary: "Evaluate actions for a user or role",
+			Body: iam.SimulatePolicyRequest{}, Response: iam.SimulationResult{}},
+		{Pattern: "POST /api/iam/calls", ID: "callService", Tag: "iam", Summary: "Simulate a user or role calling a service and log the call",
+			Body: iam.CallRequest{}, Response: iam.EvaluationResult{}},
+		{Pattern: "GET /api/iam/access-advisor", ID: "getServiceLastAccessed", Tag: "iam", Summary: "Services a user or role is granted and when it last used them",
+			Query: []openapi.Param{{Name: "arn", Type: "string", Required: true}, {Name: "days", Type: "integer"}}, Response: iam.AccessAdvisorReport{}},
+		{Pattern: "POST /api/iam/simulate-assume-role", ID: "simulateAssumeRole", Tag: "iam", Summary: "Decide whether a user or role may assume a role",
+			Body: iam.AssumeRoleSimulationRequest{}, Response: iam.AssumeRoleSimulation{}},
+
+		// Organizations
+		{Pattern: "GET /api/organizations/organization", ID: "getOrganization", Tag: "organizations", Response: iam.Organization{}},
+		{Pattern: "POST /api/organizations/organization", ID: "createOrganization", Tag: "organizations",
+			Summary: "Make your account the management account of a new organization", Status: http.StatusCreated, Response: iam.Organization{}},
+		{Pattern: "GET /api/organizations/accounts", ID: "listOrgAccounts", Tag: "organizations", Response: []iam.OrgAccount{}},
+		{Pattern: "POST /api/organizations/accounts", ID: "createOrgAccount", Tag: "organizations",
+			Body: iam.CreateOrgAccountRequest{}, Status: http.StatusCreated, Response: iam.OrgAccount{}},
+		{Pattern: "GET /api/organizations/policies", ID: "listServiceControlPolicies", Tag: "organizations", Response: []iam.ServiceControlPolicy{}},
+		{Pattern: "POST /api/organizations/policies", ID: "createServiceControlPolicy", Tag: "organizations",
+			Body: iam.CreateSCPRequest{}, Status: http.StatusCreated, Response: iam.ServiceControlPolicy{}},
+		{Pattern: "POST /api/organizations/policies/{id}/targets", ID: "attachServiceControlPolicy", Tag: "organizations",
+			Body: iam.PolicyTargetRequest{}},
+		{Pattern: "DELETE /api/organizations/policies/{id}/targets", ID: "detachServiceControlPolicy", Tag: "organizations",
+			Query: []openapi.Param{{Name: "target_id", Type: "string", Required: true}}},
+
+		// Cross-service checks
+		{Pattern: "GET /api/cloudsim/compliance", ID: "getComplianceReport", Tag: "cloudsim", Summary: "Latest tag compliance report",
+			Response: cloudsim.ComplianceReport{}},
+		{Pattern: "POST /api/cloudsim/compliance", ID: "runComplianceScan", Tag: "cloudsim", Summary: "Scan resources against tag policies now",
+			Response: cloudsim.ComplianceReport{}},
+		{Pattern: "GET /api/cloudsim/tag-policies", ID: "listTagPolicies", Tag: "cloudsim", Response: []cloudsim.TagPolicy{}},
+		{Pattern: "POST /api/cloudsim/tag-policies", ID: "createTagPolicy", Tag: "cloudsim",
+			Body: cloudsim.TagPolicy{}, Status: http.StatusCreated, Response: cloudsim.TagPolicy{}},
+		{Pattern: "DELETE /api/cloudsim/tag-policies/{id}", ID: "deleteTagPolicy", Tag: "cloudsim", Path: intID},
+		{Pattern: "GET /api/cloudsim/resources", ID: "listResources", Tag: "cloudsim",
+			Query: []openapi.Param{{Name: "type", Type: "string"}}, Response: []cloudsim.Resource{}},
+		{Pattern: "POST /api/cloudsim/resources", ID: "createResource", Tag: "cloudsim", Summary: "Launch an instance or create a function",
+			Body: cloudsim.CreateResourceRequest{}, Status: http.StatusCreated, Response: cloudsim.Resource{}},
+		{Pattern: "DELETE /api/cloudsim/resources/{id}", ID: "deleteResource", Tag: "cloudsim"},
+		{Pattern: "GET /api/cloudsim/metrics", ID: "listMetrics", Tag: "cloudsim", Response: []cloudsim.Metric{}},
+		{Pattern: "GET /api/cloudsim/metrics/data", ID: "getMetricData", Tag: "cloudsim", Summary: "Statistics of one metric per period",
+			Query: []openapi.Param{
+				{Name: "namespace", Type: "string", Required: true},
+				{Name: "metric_name", Type: "string", Required: true},
+				{Name: "resource_id", Type: "string", Required: true},
+				{Name: "statistic", Type: "string"},
+				{Name: "period", Type: "integer", Description: "minutes"},
+				{Name: "minutes", Type: "integer"},
+			},
+			Response: cloudsim.MetricData{}},
+		{Pattern: "GET /api/cloudsim/alarms", ID: "listAlarms", Tag: "cloudsim", Response: []cloudsim.MetricAlarm{}},
+		{Pattern: "POST /api/cloudsim/alarms", ID: "createAlarm", Tag: "cloudsim",
+			Body: cloudsim.MetricAlarm{}, Status: http.StatusCreated, Response: cloudsim.MetricAlarm{}},
+		{Pattern: "DELETE /api/cloudsim/alarms/{id}", ID: "deleteAlarm", Tag: "cloudsim", Path: intID},
+		{Pattern: "GET /api/cloudsim/diagram", ID: "getDiagram", Tag: "cloudsim", Summary: "Resources and their relationships as a graph",
+			Query: []openapi.Param{{Name: "format", Type: "string", Description: `"json" (default) or "dot"`}}, Response: cloudsim.Diagram{}},
+		{Pattern: "GET /api/cloudsim/services", ID: "listSimulatedServices", Tag: "cloudsim", Summary: "Simulated services and their documented actions",
+			Query: []openapi.Param{{Name: "q", Type: "string", Description: `keep actions whose "service:Action" name starts with this, ignoring case`}}, Response: []cloudsim.ServiceStatus{}},
+		{Pattern: "GET /api/cloudsim/kms/keys", ID: "listKmsKeys", Tag: "cloudsim", Response: []cloudsim.KMSKey{}},
+		{Pattern: "POST /api/cloudsim/kms/keys", ID: "createKmsKey", Tag: "cloudsim", Summary: "Create a symmetric KMS key",
+			Body: cloudsim.CreateKeyRequest{}, Status: http.StatusCreated, Response: cloudsim.KMSKey{}},
+		{Pattern: "GET /api/cloudsim/kms/keys/{id}", ID: "getKmsKey", Tag: "cloudsim", Response: cloudsim.KMSKey{}},
+		{Pattern: "PUT /api/cloudsim/kms/keys/{id}/policy", ID: "putKmsKeyPolicy", Tag: "cloudsim",
+			Body: cloudsim.PutKeyPolicyRequest{}, Response: cloudsim.KMSKey{}},
+		{Pattern: "PUT /api/cloudsim/kms/keys/{id}/enabled", ID: "setKmsKeyEnabled", Tag: "cloudsim", Summary: "Enable or disable a KMS key",
+			Body: cloudsim.SetKeyEnabledRequest{}, Response: cloudsim.KMSKey{}},
+		{Pattern: "GET /api/cloudsim/kms/keys/{id}/grants", ID: "listKmsGrants", Tag: "cloudsim", Response: []cloudsim.KMSGrant{}},
+		{Pattern: "POST /api/cloudsim/kms/keys/{id}/grants", ID: "createKmsGrant", Tag: "cloudsim",
+			Body: cloudsim.CreateGrantRequest{}, Status: http.StatusCreated, Response: cloudsim.KMSGrant{}},
+		{Pattern: "DELETE /api/cloudsim/kms/keys/{id}/grants/{grant_id}", ID: "revokeKmsGrant", Tag: "cloudsim"},
+		{Pattern: "POST /api/cloudsim/kms/encrypt", ID: "kmsEncrypt", Tag: "cloudsim", Summary: "Encrypt up to 4 KB under a KMS key",
+			Body: cloudsim.EncryptRequest{}, Response: cloudsim.EncryptResponse{}},
+		{Pattern: "POST /api/cloudsim/kms/decrypt", ID: "kmsDecrypt", Tag: "cloudsim",
+			Body: cloudsim.DecryptRequest{}, Response: cloudsim.DecryptResponse{}},
+		{Pattern: "GET /api/cloudsim/sqs/queues", ID: "listQueues", Tag: "cloudsim", Response: []cloudsim.Queue{}},
+		{Pattern: "POST /api/cloudsim/sqs/queues", ID: "createQueue", Tag: "cloudsim",
+			Body: cloudsim.QueueRequest{}, Status: http.StatusCreated, Response: cloudsim.Queue{}},
+		{Pattern: "GET /api/cloudsim/sqs/queues/{name}", ID: "getQueue", Tag: "cloudsim", Response: cloudsim.Queue{}},
+		{Pattern: "PUT /api/cloudsim/sqs/queues/{name}", ID: "updateQueue", Tag: "cloudsim", Summary: "Replace a queue's visibility timeout and redrive settings",
+			Body: cloudsim.QueueRequest{}, Response: cloudsim.Queue{}},
+		{Pattern: "DELETE /api/cloudsim/sqs/queues/{name}", ID: "deleteQueue", Tag: "cloudsim"},
+		{Pattern: "POST /api/cloudsim/sqs/queues/{name}/messages", ID: "sendMessage", Tag: "cloudsim",
+			Body: cloudsim.SendMessageRequest{}, Status: http.StatusCreated, Response: cloudsim.SendMessageResponse{}},
+		{Pattern: "POST /api/cloudsim/sqs/queues/{name}/messages/receive", ID: "receiveMessages", Tag: "cloudsim",
+			Summary: "Receive visible messages and hide them for the visibility timeout",
+			Body:    cloudsim.ReceiveMessageRequest{}, Response: []cloudsim.Message{}},
+		{Pattern: "DELETE /api/cloudsim/sqs/queues/{name}/messages/{receipt_handle}", ID: "deleteMessage", Tag: "cloudsim"},
+		{Pattern: "PUT /api/cloudsim/sqs/queues/{name}/messages/{receipt_handle}/visibility", ID: "changeMessageVisibility", Tag: "cloudsim",
+			Body: cloudsim.ChangeVisibilityRequest{}},
+		{Pattern: "GET /api/cloudsim/sns/topics", ID: "listTopics", Tag: "cloudsim", Response: []cloudsim.Topic{}},
+		{Pattern: "POST /api/cloudsim/sns/topics", ID: "createTopic", Tag: "cloudsim",
+			Body: cloudsim.CreateTopicRequest{}, Status: http.StatusCreated, Response: cloudsim.Topic{}},
+		{Pattern: "DELETE /api/cloudsim/sns/topics/{name}", ID: "deleteTopic", Tag: "cloudsim"},
+		{Pattern: "GET /api/cloudsim/sns/topics/{name}/subscriptions", ID: "listSubscriptions", Tag: "cloudsim", Response: []cloudsim.Subscription{}},
+		{Pattern: "POST /api/cloudsim/sns/topics/{name}/subscriptions", ID: "subscribe", Tag: "cloudsim", Summary: "Subscribe a queue or function to a topic",
+			Body: cloudsim.SubscribeRequest{}, Status: http.StatusCreated, Response: cloudsim.Subscription{}},
+		{Pattern: "POST /api/cloudsim/sns/topics/{name}/publish", ID: "publish", Tag: "cloudsim",
+			Body: cloudsim.PublishRequest{}, Response: cloudsim.PublishResponse{}},
+		{Pattern: "DELETE /api/cloudsim/sns/subscriptions/{id}", ID: "unsubscribe", Tag: "cloudsim"},
+		{Pattern: "GET /api/cloudsim/functions/{name}/invocations", ID: "listInvocations", Tag: "cloudsim", Summary: "Latest invocations of a function",
+			Response: []cloudsim.Invocation{}},
+		{Pattern: "GET /api/cloudsim/dynamodb/tables", ID: "listTables", Tag: "cloudsim", Response: []cloudsim.Table{}},
+		{Pattern: "POST /api/cloudsim/dynamodb/tables", ID: "createTable", Tag: "cloudsim",
+			Body: cloudsim.CreateTableRequest{}, Status: http.StatusCreated, Response: cloudsim.Table{}},
+		{Pattern: "GET /api/cloudsim/dynamodb/tables/{name}", ID: "getTable", Tag: "cloudsim", Response: cloudsim.Table{}},
+		{Pattern: "DELETE /api/cloudsim/dynamodb/tables/{name}", ID: "deleteTable", Tag: "cloudsim",
+			Query: []openapi.Param{{Name: "principal_arn", Type: "string", Description: "Delete as this user or role"}}},
+		{Pattern: "PUT /api/cloudsim/dynamodb/tables/{name}/capacity", ID: "updateTableCapacity", Tag: "cloudsim",
+			Body: cloudsim.UpdateCapacityRequest{}, Response: cloudsim.Table{}},
+		{Pattern: "PUT /api/cloudsim/dynamodb/tables/{name}/items", ID: "putItem", Tag: "cloudsim", Summary: "Create or replace an item",
+			Body: cloudsim.PutItemRequest{}, Response: cloudsim.ItemResponse{}},
+		{Pattern: "POST /api/cloudsim/dynamodb/tables/{name}/items/get", ID: "getItem", Tag: "cloudsim",
+			Body: cloudsim.KeyRequest{}, Response: cloudsim.ItemResponse{}},
+		{Pattern: "POST /api/cloudsim/dynamodb/tables/{name}/items/delete", ID: "deleteItem", Tag: "cloudsim",
+			Body: cloudsim.KeyRequest{}, Response: cloudsim.ItemResponse{}},
+		{Pattern: "POST /api/cloudsim/dynamodb/tables/{name}/query", ID: "queryTable", Tag: "cloudsim", Summary: "Read one partition in sort key order",
+			Body: cloudsim.QueryRequest{}, Response: cloudsim.QueryResponse{}},
+	}
+}
+
+var (
+	buildOnce  sync.Once
+	document   *openapi.Document
+	typescript []byte
+	goClient   []byte
+	goErr      error
+)
+
+// build generates the document and clients once; they only change with
+// the code.
+func build() {
+	buildOnce.Do(func() {
+		b := openapi.NewBuilder(Title, health.Version)
+		for _, route := range routes() {
+			b.Add(route)
+		}
+		document = b.Document()
+		typescript = openapi.TypeScript(document)
+		goClient, goErr = openapi.Go(document, "client")
+		if goErr != nil {
+			log.Printf("Failed to generate Go client: %v", goErr)
+		}
+	})
+}
+
+// Document returns the OpenAPI document.
+func Document() *openapi.Document {
+	build()
+	return document
+}
+
+// OpenAPIHandler serves the OpenAPI document.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Document())
+}
+
+// TypeScriptHandler serves the generated TypeScript client.
+func TypeScriptHandler(w http.ResponseWriter, r *http.Request) {
+	build()
+	w.Header().Set("Content-Type", "application/typescript; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="allanswebterminal.ts"`)
+	w.Write(typescript)
+}
+
+// GoHandler serves the generated Go client package.
+func GoHandler(w http.ResponseWriter, r *http.Request) {
+	build()
+	if goErr != nil {
+		apierror.Write(w, apierror.Internal("Failed to generate Go client"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-go; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="client.go"`)
+	w.Write(goClient)
+}
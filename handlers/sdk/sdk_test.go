@@ -0,0 +1,78 @@
+package sdk
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	OpenAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI == "" || doc.Paths["/api/iam/users/{name}"]["delete"] == nil {
+		t.Errorf("unexpected document %+v", doc)
+	}
+	for _, name := range []string{"UserFile", "AnswerResponse", "ScoringRules", "IAMUser", "ApiErrorEnvelope"} {
+		if doc.Components.Schemas[name] == nil {
+			t.Errorf("component %s missing", name)
+		}
+	}
+}
+
+func TestTypeScriptHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	TypeScriptHandler(rec, httptest.NewRequest(http.MethodGet, "/api/sdk/typescript", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/typescript") {
+		t.Fatalf("status = %d, type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"export class Client",
+		"saveFile(body: UserFile): Promise<UserFile>",
+		"submitAnswer(body: AnswerRequest, query: { session_id: string }): Promise<AnswerResponse>",
+		"getIamUser(name: string): Promise<IAMUser>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("client lacks %q", want)
+		}
+	}
+}
+
+func TestGoHandlerServesCompilableClient(t *testing.T) {
+	rec := httptest.NewRecorder()
+	GoHandler(rec, httptest.NewRequest(http.MethodGet, "/api/sdk/go", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "client.go", rec.Body.Bytes(), 0)
+	if err != nil {
+		t.Fatalf("client does not parse: %v", err)
+	}
+	conf := types.Config{Importer: importer.Default()}
+	if _, err := conf.Check("client", fset, []*ast.File{file}, nil); err != nil {
+		t.Fatalf("client does not type-check: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+// Package secrets stores users' API keys and other secrets encrypted with
+// the vault master key, and hands them to sandbox runs as environment
+// variables. Values are write-only over the API: once stored they are
+// only ever seen by the user's own programs. A secret can also be
+// encrypted under one of the account's simulated KMS keys, so that
+// disabling the key or changing its policy cuts programs off from it.
+package secrets
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/cloudsim"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/vault"
+)
+
+const (
+	// MaxSecrets bounds an account's secrets.
+	MaxSecrets = 50
+	// MaxValue bounds a secret's value, in bytes.
+	MaxValue = 4096
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// reservedNames would change how programs in the sandbox run rather than
+// what they know.
+var reservedNames = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "SHELL": true, "PWD": true,
+	"PYTHONPATH": true, "PYTHONHOME": true, "PYTHONSTARTUP": true, "VIRTUAL_ENV": true,
+}
+
+// Secret describes a stored secret, never its value.
+type Secret struct {
+	Name      string    `json:"name"`
+	KMSKeyID  string    `json:"kms_key_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetSecretRequest sets a value, encrypted under the KMS key KMSKeyID (a
+// key ID or ARN) if given, before it is sealed with the master key.
+type SetSecretRequest struct {
+	Value    string `json:"value"`
+	KMSKeyID string `json:"kms_key_id,omitempty"`
+}
+
+func checkName(name string) error {
+	if !namePattern.MatchString(name) {
+		return errors.New("name must be an environment variable name: letters, digits and _, not starting with a digit")
+	}
+	upper := strings.ToUpper(name)
+	if reservedNames[upper] || strings.HasPrefix(upper, "LD_") {
+		return fmt.Errorf("%s cannot be set as a secret", name)
+	}
+	return nil
+}
+
+// sealContext binds a value to its owner and name.
+func sealContext(accountID int, name string) []byte {
+	return []byte(fmt.Sprintf("secret:%d:%s", accountID, name))
+}
+
+// kmsContext binds a secret's KMS ciphertext to the secret's name.
+func kmsContext(name string) map[string]string {
+	return map[string]string{"SecretName": name}
+}
+
+func errNotConfigured() *apierror.Error {
+	return apierror.Unavailable("Secrets are not configured on this server").
+		WithDetails(map[string]string{"reason": "not_configured"})
+}
+
+// ListSecretsHandler returns the names of the caller's secrets.
+func ListSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT name, COALESCE(kms_key_id, ''), created_at, updated_at FROM user_secrets WHERE account_id = $1 ORDER BY name`, user.ID)
+	if err != nil {
+		log.Printf("Failed to list secrets of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load secrets"))
+		return
+	}
+	defer rows.Close()
+
+	list := []Secret{}
+	for rows.Next() {
+		var s Secret
+		if err := rows.Scan(&s.Name, &s.KMSKeyID, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			log.Printf("Failed to read secret: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load secrets"))
+			return
+		}
+		list = append(list, s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]Secret{"secrets": list})
+}
+
+// SetSecretHandler creates or replaces one of the caller's secrets.
+func SetSecretHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	name := r.PathValue("name")
+	if err := checkName(name); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+	var req SetSecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if req.Value == "" || len(req.Value) > MaxValue || strings.ContainsRune(req.Value, 0) {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("value must be 1-%d bytes without NUL characters", MaxValue)))
+		return
+	}
+	if !vault.Configured() {
+		apierror.Write(w, errNotConfigured())
+		return
+	}
+	value := []byte(req.Value)
+	if req.KMSKeyID != "" {
+		value, err = cloudsim.Encrypt(r.Context(), user.ID, req.KMSKeyID, value, kmsContext(name))
+		var denied *cloudsim.AccessDeniedError
+		switch {
+		case errors.Is(err, cloudsim.ErrKeyNotFound):
+			apierror.Write(w, apierror.Validation("kms_key_id is not a key of this account"))
+			return
+		case errors.Is(err, cloudsim.ErrKeyDisabled):
+			apierror.Write(w, apierror.Conflict("The KMS key is disabled"))
+			return
+		case errors.As(err, &denied):
+			apierror.Write(w, apierror.Forbidden(denied.Error()))
+			return
+		case err != nil:
+			log.Printf("Failed to encrypt secret of account %d with KMS: %v", user.ID, err)
+			apierror.Write(w, apierror.Internal("Failed to save secret"))
+			return
+		}
+	}
+	sealed, err := vault.Seal(value, sealContext(user.ID, name))
+	if err != nil {
+		log.Printf("Failed to encrypt secret of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save secret"))
+		return
+	}
+
+	// The count and the insert happen in one statement, so concurrent
+	// requests cannot pass the limit together.
+	s := Secret{Name: name, KMSKeyID: req.KMSKeyID}
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO user_secrets (account_id, name, value, kms_key_id)
+		 SELECT $1, $2, $3, $5
+		 WHERE EXISTS (SELECT 1 FROM user_secrets WHERE account_id = $1 AND name = $2)
+			OR (SELECT COUNT(*) FROM user_secrets WHERE account_id = $1) < $4
+		 ON CONFLICT (account_id, name) DO UPDATE
+			SET value = EXCLUDED.value, kms_key_id = EXCLUDED.kms_key_id, updated_at = CURRENT_TIMESTAMP
+		 RETURNING created_at, updated_at`,
+		user.ID, name, sealed, MaxSecrets, sql.NullString{String: req.KMSKeyID, Valid: req.KMSKeyID != ""},
+	).Scan(&s.CreatedAt, &s.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("An account can have at most %d secrets", MaxSecrets)))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save secret of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save secret"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// DeleteSecretHandler removes one of the caller's secrets.
+func DeleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	result, err := db.DB.ExecContext(r.Context(),
+		`DELETE FROM user_secrets WHERE account_id = $1 AND name = $2`, user.ID, r.PathValue("name"))
+	if err != nil {
+		log.Printf("Failed to delete secret of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to delete secret"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Secret not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Env returns accountID's secrets by name, for the environment of its
+// sandbox runs. It returns nothing when no master key is configured, and
+// fails if any secret cannot be decrypted, so a program never runs with
+// some of its secrets silently missing. That includes a secret whose KMS
+// key was disabled or no longer lets the account decrypt.
+func Env(ctx context.Context, accountID int) (map[string]string, error) {
+	if !vault.Configured() {
+		return nil, nil
+	}
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT name, value, kms_key_id FROM user_secrets WHERE account_id = $1`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	env := map[string]string{}
+	for rows.Next() {
+		var name string
+		var sealed []byte
+		var kmsKeyID sql.NullString
+		if err := rows.Scan(&name, &sealed, &kmsKeyID); err != nil {
+			return nil, err
+		}
+		value, err := vault.Open(sealed, sealContext(accountID, name))
+		if err == nil && kmsKeyID.Valid {
+			value, err = cloudsim.Decrypt(ctx, accountID, value, kmsContext(name))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		env[name] = string(value)
+	}
+	return env, rows.Err()
+}
+
+// Redact replaces the values of env in s, so output that echoes a secret
+// is not stored with it. Values too short to be told from ordinary text
+// are left alone.
+func Redact(s string, env map[string]string) string {
+	names := make([]string, 0, len(env))
+	for name, value := range env {
+		if len(value) >= 6 {
+			names = append(names, name)
+		}
+	}
+	// Longer values first, in case one contains another.
+	sort.Slice(names, func(i, j int) bool { return len(env[names[i]]) > len(env[names[j]]) })
+	for _, name := range names {
+		s = strings.ReplaceAll(s, env[name], "["+name+"]")
+	}
+	return s
+}
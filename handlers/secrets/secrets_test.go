@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+	"allanswebterminal/vault"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	originalDB := db.DB
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "user"))
+	return mock
+}
+
+func useKey(t *testing.T) {
+	t.Helper()
+	key, _ := vault.ParseKey(vault.NewKey())
+	if err := vault.SetKey(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { vault.SetKey(nil) })
+}
+
+func setRequest(name, body string) *http.Request {
+	req := httptest.NewRequest("PUT", "/api/secrets/"+name, strings.NewReader(body))
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	req.SetPathValue("name", name)
+	return req
+}
+
+func TestSetSecret(t *testing.T) {
+	useKey(t)
+	mock := setupMockDB(t)
+	mock.ExpectQuery("INSERT INTO user_secrets").
+		WithArgs(7, "OPENAI_API_KEY", sqlmock.AnyArg(), MaxSecrets, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+
+	rec := httptest.NewRecorder()
+	SetSecretHandler(rec, setRequest("OPENAI_API_KEY", `{"value":"sk-test-123"}`))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "sk-test") {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetSecretLimit(t *testing.T) {
+	useKey(t)
+	mock := setupMockDB(t)
+	mock.ExpectQuery("INSERT INTO user_secrets").WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}))
+
+	rec := httptest.NewRecorder()
+	SetSecretHandler(rec, setRequest("TOKEN", `{"value":"abc"}`))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", rec.Code)
+	}
+}
+
+func TestSetSecretChecksRequest(t *testing.T) {
+	useKey(t)
+	for name, body := range map[string]string{
+		"PATH":       `{"value":"/tmp"}`,
+		"LD_PRELOAD": `{"value":"x.so"}`,
+		"1TOKEN":     `{"value":"x"}`,
+		"TOKEN":      `{"value":""}`,
+	} {
+		setupMockDB(t)
+		rec := httptest.NewRecorder()
+		SetSecretHandler(rec, setRequest(name, body))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: status = %d, want 400", name, body, rec.Code)
+		}
+	}
+}
+
+func TestSetSecretUnknownKMSKey(t *testing.T) {
+	useKey(t)
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM kms_keys").WithArgs(7, "missing").WillReturnRows(sqlmock.NewRows([]string{"key_id"}))
+
+	rec := httptest.NewRecorder()
+	SetSecretHandler(rec, setRequest("TOKEN", `{"value":"abc","kms_key_id":"missing"}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetSecretNotConfigured(t *testing.T) {
+	setupMockDB(t)
+	rec := httptest.NewRecorder()
+	SetSecretHandler(rec, setRequest("TOKEN", `{"value":"abc"}`))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "not_configured") {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestEnv(t *testing.T) {
+	useKey(t)
+	mockDB, mock, _ := sqlmock.New()
+	originalDB := db.DB
+	db.DB = mockDB
+	defer func() { db.DB = originalDB }()
+
+	token, _ := vault.Seal([]byte("sk-test-123"), sealContext(7, "TOKEN"))
+	mock.ExpectQuery("SELECT name, value, kms_key_id FROM user_secrets").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "value", "kms_key_id"}).AddRow("TOKEN", token, nil))
+	env, err := Env(context.Background(), 7)
+	if err != nil || env["TOKEN"] != "sk-test-123" {
+		t.Errorf("Env() = %v, %v", env, err)
+	}
+
+	// A value copied from another account does not open.
+	mock.ExpectQuery("SELECT name, value, kms_key_id FROM user_secrets").WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "value", "kms_key_id"}).AddRow("TOKEN", token, nil))
+	if _, err := Env(context.Background(), 8); err == nil {
+		t.Error("Env() opened another account's secret")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	env := map[string]string{"TOKEN": "sk-test-123", "LONG": "sk-test-123-extra", "PIN": "42"}
+	got := Redact("token sk-test-123, long sk-test-123-extra, pin 42", env)
+	if want := "token [TOKEN], long [LONG], pin 42"; got != want {
+		t.Errorf("Redact() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,539 @@
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/sandbox"
+	"allanswebterminal/validate"
+
+	"github.com/lib/pq"
+)
+
+// A user's virtualenv is kept in the file store as a base64 tarball of
+// sandbox.VenvDir, next to the requirements it was built from, so it
+// survives the container.
+const (
+	venvArchive  = "sandbox/venv.tar.gz"
+	venvManifest = "sandbox/requirements.txt"
+	venvFileType = "venv"
+
+	// MaxPackages bounds the packages in one virtualenv.
+	MaxPackages    = 30
+	maxVenvArchive = 20 << 20
+	buildTimeout   = 5 * time.Minute
+)
+
+var (
+	requirementPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]{0,99})(?:==([A-Za-z0-9][A-Za-z0-9.+!_-]{0,49}))?$`)
+	nameSeparators     = regexp.MustCompile(`[-_.]+`)
+)
+
+// Package is an allowlist entry. With Versions set only those can be
+// installed, the first being the default; without, any version can.
+type Package struct {
+	Name        string    `json:"name"`
+	Versions    []string  `json:"versions"`
+	Description string    `json:"description"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Requirement is an installed package, pinned unless Version is empty.
+type Requirement struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+func (r Requirement) String() string {
+	if r.Version == "" {
+		return r.Name
+	}
+	return r.Name + "==" + r.Version
+}
+
+// normalizePackageName folds a name the way pip compares them (PEP 503).
+func normalizePackageName(name string) string {
+	return nameSeparators.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+// parseRequirement reads "name" or "name==version"; nothing else pip
+// understands, such as URLs or options, is accepted.
+func parseRequirement(s string) (Requirement, error) {
+	m := requirementPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Requirement{}, fmt.Errorf("%q is not a package name, optionally with ==version", s)
+	}
+	return Requirement{Name: normalizePackageName(m[1]), Version: m[2]}, nil
+}
+
+type InstallPackagesRequest struct {
+	Packages []string `json:"packages"`
+}
+
+type InstallPackagesResponse struct {
+	Packages []Requirement `json:"packages"`
+	// Dropped are installed packages left out of the rebuild because they
+	// are no longer allowed.
+	Dropped []string `json:"dropped"`
+	Log     string   `json:"log"`
+	Size    int      `json:"size"`
+}
+
+// InstallPackagesHandler adds allowlisted packages to the caller's
+// virtualenv. The whole virtualenv is rebuilt in a one-off container with
+// network access, saved to the file store and unpacked into the caller's
+// sandbox, whose own network stays off. Only wheels are installed, so no
+// package code runs during the build.
+func InstallPackagesHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req InstallPackagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if len(req.Packages) == 0 || len(req.Packages) > MaxPackages {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("packages must list 1-%d packages", MaxPackages)))
+		return
+	}
+	wanted := map[string]Requirement{}
+	for _, s := range req.Packages {
+		want, err := parseRequirement(s)
+		if err != nil {
+			apierror.Write(w, apierror.Validation(err.Error()))
+			return
+		}
+		wanted[want.Name] = want
+	}
+	pool := sandbox.Default()
+	if pool == nil {
+		apierror.Write(w, errNoSandbox())
+		return
+	}
+
+	installed, err := installedPackages(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to load packages of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to install packages"))
+		return
+	}
+	allowlist, err := allowedPackages(r.Context(), wanted, installed)
+	if err != nil {
+		log.Printf("Failed to load the package allowlist: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to install packages"))
+		return
+	}
+	var notAllowed []string
+	for name, req := range wanted {
+		versions, ok := allowlist[name]
+		switch {
+		case !ok:
+			notAllowed = append(notAllowed, name)
+		case req.Version == "" && len(versions) > 0:
+			wanted[name] = Requirement{Name: name, Version: versions[0]}
+		case req.Version != "" && len(versions) > 0 && !contains(versions, req.Version):
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("%s may only be installed at version %s", name, strings.Join(versions, ", "))))
+			return
+		}
+	}
+	if len(notAllowed) > 0 {
+		sort.Strings(notAllowed)
+		apierror.Write(w, apierror.Validation("Not on the package allowlist: "+strings.Join(notAllowed, ", ")).
+			WithDetails(map[string][]string{"not_allowed": notAllowed}))
+		return
+	}
+
+	resp := InstallPackagesResponse{Dropped: []string{}}
+	for _, req := range installed {
+		if _, ok := wanted[req.Name]; ok {
+			continue
+		}
+		if versions, ok := allowlist[req.Name]; !ok || (len(versions) > 0 && !contains(versions, req.Version)) {
+			resp.Dropped = append(resp.Dropped, req.Name)
+			continue
+		}
+		wanted[req.Name] = req
+	}
+	if len(wanted) > MaxPackages {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("A virtualenv holds at most %d packages", MaxPackages)))
+		return
+	}
+	for _, req := range wanted {
+		resp.Packages = append(resp.Packages, req)
+	}
+	sort.Slice(resp.Packages, func(i, j int) bool { return resp.Packages[i].Name < resp.Packages[j].Name })
+
+	ctx, cancel := context.WithTimeout(r.Context(), buildTimeout)
+	defer cancel()
+	archive, buildLog, err := buildVenv(ctx, pool, resp.Packages)
+	resp.Log = buildLog
+	var buildErr *venvBuildError
+	switch {
+	case errors.Is(err, sandbox.ErrPoolFull):
+		apierror.Write(w, apierror.Unavailable("All terminal sandboxes are busy; try again shortly"))
+		return
+	case errors.As(err, &buildErr):
+		apierror.Write(w, apierror.Validation(buildErr.Error()).WithDetails(map[string]string{"log": buildLog}))
+		return
+	case err != nil:
+		log.Printf("Failed to build virtualenv for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Unavailable("The packages could not be installed; try again later"))
+		return
+	}
+	resp.Size = len(archive)
+
+	if err := saveVenv(r.Context(), user.ID, archive, resp.Packages); err != nil {
+		log.Printf("Failed to save virtualenv of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to install packages"))
+		return
+	}
+	if c, ok := pool.Assigned(user.ID); ok {
+		if err := unpackVenv(r.Context(), c, bytes.NewReader(archive)); err != nil {
+			log.Printf("Failed to unpack virtualenv into sandbox %s: %v", c.ID(), err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// venvBuildError is a build that failed because of the packages rather
+// than the sandbox.
+type venvBuildError struct{ message string }
+
+func (e *venvBuildError) Error() string { return e.message }
+
+// buildVenv installs reqs into a new virtualenv in a temporary container
+// with network access and returns it as a gzipped tarball, with pip's
+// output.
+func buildVenv(ctx context.Context, pool *sandbox.Pool, reqs []Requirement) ([]byte, string, error) {
+	limits := pool.Limits()
+	limits.Network = true
+	c, err := pool.Temporary(ctx, limits)
+	if err != nil {
+		return nil, "", err
+	}
+	defer c.Stop(context.Background())
+
+	// Requirements are validated against requirementPattern, so quoting
+	// them is enough. pip writes to stderr to keep stdout for the tarball.
+	args := make([]string, len(reqs))
+	for i, req := range reqs {
+		args[i] = "'" + req.String() + "'"
+	}
+	venv := strings.TrimPrefix(sandbox.VenvDir, sandbox.HomeDir+"/")
+	command := fmt.Sprintf("python3 -m venv %[1]s >&2 && "+
+		"%[1]s/bin/pip install --disable-pip-version-check --no-input --no-cache-dir --only-binary=:all: %[2]s >&2 && "+
+		"tar czf - %[1]s", venv, strings.Join(args, " "))
+
+	var archive limitedArchive
+	res, err := c.Exec(ctx, sandbox.ExecRequest{Command: command, Stdout: &archive})
+	if err != nil {
+		return nil, "", err
+	}
+	if res.ExitCode != 0 {
+		if archive.overflow {
+			return nil, res.Stderr, &venvBuildError{fmt.Sprintf("The virtualenv is larger than %d MB", maxVenvArchive>>20)}
+		}
+		return nil, res.Stderr, &venvBuildError{fmt.Sprintf("pip install failed with exit code %d; see the log", res.ExitCode)}
+	}
+	return archive.Bytes(), res.Stderr, nil
+}
+
+// limitedArchive fails writes past maxVenvArchive, which stops the tar.
+type limitedArchive struct {
+	bytes.Buffer
+	overflow bool
+}
+
+func (a *limitedArchive) Write(p []byte) (int, error) {
+	if a.Len()+len(p) > maxVenvArchive {
+		a.overflow = true
+		return 0, errors.New("virtualenv too large")
+	}
+	return a.Buffer.Write(p)
+}
+
+func saveVenv(ctx context.Context, accountID int, archive []byte, reqs []Requirement) error {
+	lines := make([]string, len(reqs))
+	for i, req := range reqs {
+		lines[i] = req.String()
+	}
+	if err := files.Save(ctx, &files.UserFile{
+		AccountID: accountID,
+		Filename:  venvArchive,
+		Content:   base64.StdEncoding.EncodeToString(archive),
+		FileType:  venvFileType,
+	}); err != nil {
+		return err
+	}
+	return files.Save(ctx, &files.UserFile{
+		AccountID: accountID,
+		Filename:  venvManifest,
+		Content:   strings.Join(lines, "\n") + "\n",
+		FileType:  venvFileType,
+	})
+}
+
+// RestoreVenv unpacks the account's saved virtualenv into c. It is the
+// sandbox pool's Prepare hook, so new containers start with it.
+func RestoreVenv(ctx context.Context, accountID int, c sandbox.Container) error {
+	file, err := files.Load(ctx, accountID, venvArchive)
+	if errors.Is(err, files.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return unpackVenv(ctx, c, base64.NewDecoder(base64.StdEncoding, strings.NewReader(file.Content)))
+}
+
+func unpackVenv(ctx context.Context, c sandbox.Container, archive io.Reader) error {
+	res, err := c.Exec(ctx, sandbox.ExecRequest{
+		Command: "rm -rf " + sandbox.VenvDir + " && tar xzf -",
+		Stdin:   archive,
+	})
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("tar exited with %d: %.200s", res.ExitCode, res.Stderr)
+	}
+	return nil
+}
+
+// installedPackages reads the caller's requirements from the file store.
+func installedPackages(ctx context.Context, accountID int) ([]Requirement, error) {
+	file, err := files.Load(ctx, accountID, venvManifest)
+	if errors.Is(err, files.ErrNotFound) {
+		return []Requirement{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	reqs := []Requirement{}
+	for _, line := range strings.Split(file.Content, "\n") {
+		if req, err := parseRequirement(line); err == nil {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs, nil
+}
+
+// allowedPackages returns the allowed versions of the wanted and
+// installed packages that are on the allowlist.
+func allowedPackages(ctx context.Context, wanted map[string]Requirement, installed []Requirement) (map[string][]string, error) {
+	var names []string
+	for name := range wanted {
+		names = append(names, name)
+	}
+	for _, req := range installed {
+		names = append(names, req.Name)
+	}
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT name, versions FROM sandbox_packages WHERE name = ANY($1)", pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	allowed := map[string][]string{}
+	for rows.Next() {
+		var name string
+		var versions pq.StringArray
+		if err := rows.Scan(&name, &versions); err != nil {
+			return nil, err
+		}
+		allowed[name] = versions
+	}
+	return allowed, rows.Err()
+}
+
+// ListPackagesHandler returns the packages in the caller's virtualenv.
+func ListPackagesHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	reqs, err := installedPackages(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to load packages of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load packages"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]Requirement{"packages": reqs})
+}
+
+// RemovePackagesHandler deletes the caller's virtualenv, from the file
+// store and from their sandbox.
+func RemovePackagesHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	for _, name := range []string{venvArchive, venvManifest} {
+		if _, err := files.Delete(r.Context(), user.ID, name); err != nil {
+			log.Printf("Failed to delete %s of account %d: %v", name, user.ID, err)
+			apierror.Write(w, apierror.Internal("Failed to remove packages"))
+			return
+		}
+	}
+	if pool := sandbox.Default(); pool != nil {
+		if c, ok := pool.Assigned(user.ID); ok {
+			if _, err := c.Exec(r.Context(), sandbox.ExecRequest{Command: "rm -rf " + sandbox.VenvDir}); err != nil {
+				log.Printf("Failed to remove virtualenv from sandbox %s: %v", c.ID(), err)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AllowedPackagesHandler lists the package allowlist.
+func AllowedPackagesHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := login.GetCurrentUser(r); err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	rows, err := db.DB.QueryContext(r.Context(),
+		"SELECT name, versions, description, updated_at FROM sandbox_packages ORDER BY name")
+	if err != nil {
+		log.Printf("Failed to load the package allowlist: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load the package allowlist"))
+		return
+	}
+	defer rows.Close()
+
+	packages := []Package{}
+	for rows.Next() {
+		var p Package
+		var versions pq.StringArray
+		if err := rows.Scan(&p.Name, &versions, &p.Description, &p.UpdatedAt); err != nil {
+			log.Printf("Failed to read the package allowlist: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load the package allowlist"))
+			return
+		}
+		p.Versions = append([]string{}, versions...)
+		packages = append(packages, p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]Package{"packages": packages})
+}
+
+type AllowPackageRequest struct {
+	Versions    []string `json:"versions"`
+	Description string   `json:"description" validate:"max=255"`
+}
+
+// AllowPackageHandler adds the package {name} to the allowlist or
+// replaces its entry. Admins only.
+func AllowPackageHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	var req AllowPackageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	name := r.PathValue("name")
+	if _, err := parseRequirement(name); err != nil || strings.Contains(name, "==") {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("%q is not a package name", name)))
+		return
+	}
+	p := Package{Name: normalizePackageName(name), Versions: []string{}, Description: strings.TrimSpace(req.Description)}
+	for _, v := range req.Versions {
+		pinned, err := parseRequirement(p.Name + "==" + strings.TrimSpace(v))
+		if err != nil {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("%q is not a version", v)))
+			return
+		}
+		if !contains(p.Versions, pinned.Version) {
+			p.Versions = append(p.Versions, pinned.Version)
+		}
+	}
+
+	err := db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO sandbox_packages (name, versions, description, added_by) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (name) DO UPDATE SET versions = EXCLUDED.versions, description = EXCLUDED.description,
+		   added_by = EXCLUDED.added_by, updated_at = CURRENT_TIMESTAMP
+		 RETURNING updated_at`,
+		p.Name, pq.Array(p.Versions), p.Description, user.ID,
+	).Scan(&p.UpdatedAt)
+	if err != nil {
+		log.Printf("Failed to allow package %s: %v", p.Name, err)
+		apierror.Write(w, apierror.Internal("Failed to update the package allowlist"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// DisallowPackageHandler removes the package {name} from the allowlist.
+// Virtualenvs that have it keep it until they are next rebuilt. Admins
+// only.
+func DisallowPackageHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	name := normalizePackageName(r.PathValue("name"))
+	res, err := db.DB.ExecContext(r.Context(), "DELETE FROM sandbox_packages WHERE name = $1", name)
+	if err != nil {
+		log.Printf("Failed to disallow package %s: %v", name, err)
+		apierror.Write(w, apierror.Internal("Failed to update the package allowlist"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Package not on the allowlist"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func requireAdmin(w http.ResponseWriter, r *http.Request) (*login.User, bool) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return nil, false
+	}
+	if user.Role != "admin" {
+		apierror.Write(w, apierror.Forbidden("Admin access required"))
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,122 @@
+package terminal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/sandbox"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestParseRequirement(t *testing.T) {
+	tests := map[string]Requirement{
+		"requests":            {Name: "requests"},
+		" Flask_SQLAlchemy ":  {Name: "flask-sqlalchemy"},
+		"numpy==1.26.4":       {Name: "numpy", Version: "1.26.4"},
+		"zope.interface==6.0": {Name: "zope-interface", Version: "6.0"},
+	}
+	for in, want := range tests {
+		if got, err := parseRequirement(in); err != nil || got != want {
+			t.Errorf("parseRequirement(%q) = %+v, %v; want %+v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "numpy>=1.0", "-e .", "git+https://example.com/x.git", "pkg; rm -rf /", "a==1 b"} {
+		if _, err := parseRequirement(bad); err == nil {
+			t.Errorf("parseRequirement(%q) succeeded", bad)
+		}
+	}
+}
+
+var userFileColumns = []string{"id", "account_id", "filename", "content", "file_type", "created_at", "updated_at", "key_id", "data_key", "scan_status"}
+
+func TestInstallPackages(t *testing.T) {
+	rt := &sandbox.Mock{Reply: sandbox.ExecResult{Stdout: "tarball", Stderr: "Successfully installed"}}
+	useSandbox(t, rt)
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM user_files").WithArgs(7, venvManifest).
+		WillReturnRows(sqlmock.NewRows(userFileColumns).
+			AddRow(1, 7, venvManifest, "numpy==1.26.4\nleftpad\n", venvFileType, time.Now(), time.Now(), nil, nil, nil))
+	mock.ExpectQuery("SELECT name, versions FROM sandbox_packages").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "versions"}).
+			AddRow("requests", pq.StringArray{}).
+			AddRow("numpy", pq.StringArray{"1.26.4"}))
+	mock.ExpectQuery("INSERT INTO user_files").WithArgs(7, venvArchive, "dGFyYmFsbA==", venvFileType, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(2, time.Now(), time.Now()))
+	mock.ExpectQuery("INSERT INTO user_files").WithArgs(7, venvManifest, "numpy==1.26.4\nrequests\n", venvFileType, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, time.Now(), time.Now()))
+
+	rec := httptest.NewRecorder()
+	InstallPackagesHandler(rec, request("POST", "/api/terminal/packages", `{"packages":["Requests"]}`))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, `"dropped":["leftpad"]`) || !strings.Contains(body, `"size":7`) {
+		t.Errorf("status = %d: %s", rec.Code, body)
+	}
+	execs := rt.Execs()
+	if len(execs) != 1 || !strings.Contains(execs[0].Command, "--only-binary=:all: 'numpy==1.26.4' 'requests' >&2") {
+		t.Errorf("Execs() = %+v", execs)
+	}
+	if got := rt.Stopped(); len(got) != 1 {
+		t.Errorf("the build container was not stopped: %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInstallPackagesNotAllowed(t *testing.T) {
+	rt := &sandbox.Mock{}
+	useSandbox(t, rt)
+	mock := setupMockDB(t)
+	mock.ExpectQuery("FROM user_files").WillReturnRows(sqlmock.NewRows(userFileColumns))
+	mock.ExpectQuery("SELECT name, versions FROM sandbox_packages").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "versions"}).AddRow("numpy", pq.StringArray{"1.26.4"}))
+
+	rec := httptest.NewRecorder()
+	InstallPackagesHandler(rec, request("POST", "/api/terminal/packages", `{"packages":["numpy","cryptominer"]}`))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"not_allowed":["cryptominer"]`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rt.Started() != 0 {
+		t.Error("a build container was started")
+	}
+}
+
+func TestAllowPackage(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useDB(t, mockDB)
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "admin"))
+	mock.ExpectQuery("INSERT INTO sandbox_packages").
+		WithArgs("scikit-learn", pq.Array([]string{"1.5.0", "1.4.2"}), "Machine learning", 7).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+
+	req := request("PUT", "/api/admin/sandbox/packages/scikit_learn", `{"versions":["1.5.0","1.4.2","1.5.0"],"description":"Machine learning"}`)
+	req.SetPathValue("name", "scikit_learn")
+	rec := httptest.NewRecorder()
+	AllowPackageHandler(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"scikit-learn"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAllowPackageNeedsAdmin(t *testing.T) {
+	setupMockDB(t)
+	req := request("PUT", "/api/admin/sandbox/packages/numpy", `{}`)
+	req.SetPathValue("name", "numpy")
+	rec := httptest.NewRecorder()
+	AllowPackageHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
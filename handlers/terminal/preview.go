@@ -0,0 +1,295 @@
+package terminal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/sandbox"
+	"allanswebterminal/validate"
+)
+
+// MaxPreviews bounds an account's preview sessions.
+const MaxPreviews = 5
+
+// previewTTL is how long a preview session lasts after it is created.
+const previewTTL = 24 * time.Hour
+
+// previewPolicy is the content security policy of proxied responses. It
+// must never allow-same-origin.
+const previewPolicy = "sandbox allow-scripts allow-forms allow-popups allow-modals allow-downloads; frame-ancestors 'self'"
+
+// Preview is a session proxying /preview/{session}/ to a port in its
+// owner's sandbox.
+type Preview struct {
+	Session   string    `json:"session"`
+	Port      int       `json:"port"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	accountID int
+}
+
+type CreatePreviewRequest struct {
+	Port int `json:"port" validate:"required,min=1024,max=65535"`
+}
+
+// previews live in memory, like the sandbox pool whose containers they
+// reach; a restart discards both.
+var previews = previewStore{sessions: map[string]*Preview{}}
+
+type previewStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Preview
+}
+
+var errTooManyPreviews = errors.New("too many previews")
+
+// open returns accountID's session for port, creating it if needed. The
+// bool reports whether it was created.
+func (s *previewStore) open(accountID, port int) (Preview, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	n := 0
+	for _, p := range s.sessions {
+		if p.accountID != accountID {
+			continue
+		}
+		if p.Port == port {
+			return *p, false, nil
+		}
+		n++
+	}
+	if n >= MaxPreviews {
+		return Preview{}, false, errTooManyPreviews
+	}
+	session := newShareToken()
+	p := &Preview{
+		Session:   session,
+		Port:      port,
+		URL:       previewPath(session) + "/",
+		ExpiresAt: time.Now().Add(previewTTL),
+		accountID: accountID,
+	}
+	s.sessions[session] = p
+	return *p, true, nil
+}
+
+// get returns session if it is open.
+func (s *previewStore) get(session string) (Preview, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.sessions[session]
+	if !ok || time.Now().After(p.ExpiresAt) {
+		return Preview{}, false
+	}
+	return *p, true
+}
+
+func (s *previewStore) list(accountID int) []Preview {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	list := []Preview{}
+	for _, p := range s.sessions {
+		if p.accountID == accountID {
+			list = append(list, *p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Port < list[j].Port })
+	return list
+}
+
+func (s *previewStore) close(accountID int, session string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.sessions[session]
+	if !ok || p.accountID != accountID {
+		return false
+	}
+	delete(s.sessions, session)
+	return true
+}
+
+// expire drops sessions past their TTL. s.mu must be held.
+func (s *previewStore) expire() {
+	now := time.Now()
+	for session, p := range s.sessions {
+		if now.After(p.ExpiresAt) {
+			delete(s.sessions, session)
+		}
+	}
+}
+
+func previewPath(session string) string {
+	return "/preview/" + session
+}
+
+// CreatePreviewHandler opens a preview session for a port in the caller's
+// sandbox, or returns the open one (200 instead of 201). The server need
+// not be running yet.
+func CreatePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req CreatePreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	if !sandbox.Configured() {
+		apierror.Write(w, errNoSandbox())
+		return
+	}
+	p, created, err := previews.open(user.ID, req.Port)
+	if err != nil {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("At most %d previews can be open; close one first", MaxPreviews)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(p)
+}
+
+func ListPreviewsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]Preview{"previews": previews.list(user.ID)})
+}
+
+func ClosePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	if !previews.close(user.ID, r.PathValue("session")) {
+		apierror.Write(w, apierror.NotFound("Preview not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PreviewHandler proxies /preview/{session}/... to the session's port in
+// its owner's sandbox, WebSocket upgrades included. The unguessable
+// session in the path is what authorizes a request, not the site's
+// sign-in: preview pages run in an opaque origin, so the app's own fetch
+// and XHR calls carry no site cookies. The app is served under the
+// prefix, which it is told in X-Forwarded-Prefix; root-relative redirects
+// and cookies are moved under it, and the site's own session cookie is
+// neither sent nor settable.
+func PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := previews.get(r.PathValue("session"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	pool := sandbox.Default()
+	if pool == nil {
+		http.Error(w, "The terminal sandbox is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The app's pages may use inline scripts and be framed by the
+	// workspace, which the site's own policy forbids. They are served on
+	// the site's origin, so the sandbox directive gives them an opaque
+	// origin instead: scripts the app serves, from whatever code the user
+	// installed, can't read the site's cookies, storage or API responses.
+	h := w.Header()
+	h.Del("Content-Security-Policy")
+	h.Del("Content-Security-Policy-Report-Only")
+	h.Set("Content-Security-Policy", previewPolicy)
+	h.Set("X-Frame-Options", "SAMEORIGIN")
+
+	prefix := previewPath(p.Session)
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			host := fmt.Sprintf("localhost:%d", p.Port)
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = host
+			pr.Out.Host = host
+			pr.Out.URL.Path = strings.TrimPrefix(pr.In.URL.Path, prefix)
+			pr.Out.URL.RawPath = strings.TrimPrefix(pr.In.URL.RawPath, prefix)
+			pr.SetXForwarded()
+			pr.Out.Header.Set("X-Forwarded-Prefix", prefix)
+			pr.Out.Header.Del("Cookie")
+			for _, c := range pr.In.Cookies() {
+				if c.Name != sessionCookie {
+					pr.Out.AddCookie(c)
+				}
+			}
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return pool.Dial(ctx, p.accountID, p.Port)
+			},
+			// Each connection is a relay process in the container; do
+			// not keep them around between requests.
+			DisableKeepAlives: true,
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			rewritePreviewResponse(resp, prefix)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			switch {
+			case errors.Is(err, sandbox.ErrNotRunning):
+				http.Error(w, "Your sandbox is not running; start the server from the terminal", http.StatusBadGateway)
+			case errors.Is(err, sandbox.ErrNotListening):
+				http.Error(w, fmt.Sprintf("Nothing is listening on port %d in your sandbox", p.Port), http.StatusBadGateway)
+			case errors.Is(err, context.Canceled):
+				// The browser went away.
+			default:
+				log.Printf("Preview of port %d for account %d failed: %v", p.Port, p.accountID, err)
+				http.Error(w, "The preview failed", http.StatusBadGateway)
+			}
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// sessionCookie is the site's sign-in cookie, kept from preview apps.
+const sessionCookie = "user_id"
+
+// rewritePreviewResponse moves root-relative redirects and cookie paths
+// under prefix and drops cookies that would replace the site's session.
+func rewritePreviewResponse(resp *http.Response, prefix string) {
+	if loc := resp.Header.Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		resp.Header.Set("Location", prefix+loc)
+	}
+	cookies := resp.Cookies()
+	resp.Header.Del("Set-Cookie")
+	for _, c := range cookies {
+		if c.Name == sessionCookie {
+			continue
+		}
+		c.Domain = ""
+		c.Path = prefix + "/" + strings.TrimPrefix(c.Path, "/")
+		if s := c.String(); s != "" {
+			resp.Header.Add("Set-Cookie", s)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package terminal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"allanswebterminal/sandbox"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/websocket"
+)
+
+// usePreview starts app as a server on port 5000 of account 7's sandbox
+// and opens a preview of it.
+func usePreview(t *testing.T, app http.Handler) Preview {
+	t.Helper()
+	srv := httptest.NewServer(app)
+	t.Cleanup(srv.Close)
+	useSandbox(t, &sandbox.Mock{Ports: map[int]string{5000: srv.Listener.Addr().String()}})
+	if _, err := sandbox.Default().Acquire(context.Background(), 7); err != nil {
+		t.Fatal(err)
+	}
+	p, _, err := previews.open(7, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { previews.close(7, p.Session) })
+	return p
+}
+
+func previewRequest(p Preview, path string) *http.Request {
+	req := request("GET", p.URL+path, "")
+	req.SetPathValue("session", p.Session)
+	return req
+}
+
+func TestPreview(t *testing.T) {
+	p := usePreview(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/go" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "1", Path: "/"})
+		http.SetCookie(w, securecookie.Cookie("user_id", "1"))
+		fmt.Fprintf(w, "%s?%s prefix=%s cookie=%s", r.URL.Path, r.URL.RawQuery, r.Header.Get("X-Forwarded-Prefix"), r.Header.Get("Cookie"))
+	}))
+	req := previewRequest(p, "hello?x=1")
+	req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	rec := httptest.NewRecorder()
+	PreviewHandler(rec, req)
+	want := fmt.Sprintf("/hello?x=1 prefix=/preview/%s cookie=theme=dark", p.Session)
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("status = %d, body = %q, want %q", rec.Code, rec.Body.String(), want)
+	}
+	if got := rec.Header().Values("Set-Cookie"); len(got) != 1 || got[0] != "sid=1; Path=/preview/"+p.Session+"/" {
+		t.Errorf("Set-Cookie = %q", got)
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); !strings.HasPrefix(got, "sandbox allow-scripts ") ||
+		strings.Contains(got, "allow-same-origin") || !strings.HasSuffix(got, "frame-ancestors 'self'") {
+		t.Errorf("Content-Security-Policy = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	PreviewHandler(rec, previewRequest(p, "go"))
+	if got := rec.Header().Get("Location"); got != "/preview/"+p.Session+"/login" {
+		t.Errorf("Location = %q", got)
+	}
+}
+
+func TestPreviewNotListening(t *testing.T) {
+	p := usePreview(t, http.NotFoundHandler())
+	previews.close(7, p.Session)
+	p, _, _ = previews.open(7, 8000)
+
+	rec := httptest.NewRecorder()
+	PreviewHandler(rec, previewRequest(p, ""))
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "port 8000") {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPreviewWithoutCookies(t *testing.T) {
+	p := usePreview(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items": []}`)
+	}))
+
+	// The page's own fetch calls come from an opaque origin, without the
+	// site's cookies; the session in the path authorizes them.
+	req := httptest.NewRequest("GET", p.URL+"api/items", nil)
+	req.SetPathValue("session", p.Session)
+	rec := httptest.NewRecorder()
+	PreviewHandler(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"items": []}` {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	previews.close(7, p.Session)
+	rec = httptest.NewRecorder()
+	PreviewHandler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("closed session: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestPreviewWebSocket(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	p := usePreview(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		kind, msg, err := conn.ReadMessage()
+		if err == nil {
+			conn.WriteMessage(kind, append([]byte("echo: "), msg...))
+		}
+	}))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/preview/{session}/", PreviewHandler)
+	front := httptest.NewServer(mux)
+	defer front.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(front.URL, "http")+p.URL+"ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, []byte("hi"))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "echo: hi" {
+		t.Errorf("ReadMessage() = %q, %v", msg, err)
+	}
+}
+
+func TestCreatePreview(t *testing.T) {
+	useSandbox(t, &sandbox.Mock{})
+	setupMockDB(t)
+	rec := httptest.NewRecorder()
+	CreatePreviewHandler(rec, request("POST", "/api/terminal/previews", `{"port":5000}`))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"url":"/preview/`) {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, p := range previews.list(7) {
+		defer previews.close(7, p.Session)
+	}
+
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "user"))
+	rec = httptest.NewRecorder()
+	CreatePreviewHandler(rec, request("POST", "/api/terminal/previews", `{"port":5000}`))
+	if rec.Code != http.StatusOK {
+		t.Errorf("reopening status = %d, want 200", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	CreatePreviewHandler(rec, request("POST", "/api/terminal/previews", `{"port":80}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("port 80 status = %d, want 400", rec.Code)
+	}
+}
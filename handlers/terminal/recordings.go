@@ -0,0 +1,448 @@
+package terminal
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/pagination"
+	"allanswebterminal/templates"
+)
+
+const (
+	// MaxRecordings is how many recordings an account may keep.
+	MaxRecordings      = 100
+	maxRecordingEvents = 20000
+	// maxRecordingLength is the longest recording kept, in seconds.
+	maxRecordingLength = 2 * 60 * 60
+	maxTerminalWidth   = 500
+	maxTerminalHeight  = 200
+)
+
+// Event is an asciicast v2 event: the seconds since the recording started,
+// the event type and its data. It is encoded as [time, type, data].
+type Event struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// Event types, as in asciicast v2: output, input, resize ("80x24") and
+// marker.
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+	EventMarker = "m"
+)
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
+}
+
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return errors.New("an event is [time, type, data]")
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return fmt.Errorf("event time: %w", err)
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return fmt.Errorf("event type: %w", err)
+	}
+	if err := json.Unmarshal(fields[2], &e.Data); err != nil {
+		return fmt.Errorf("event data: %w", err)
+	}
+	return nil
+}
+
+// Recording is a recorded terminal session. Listings leave Events out.
+type Recording struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Duration  float64   `json:"duration"`
+	Events    []Event   `json:"events,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	ShareURL  string    `json:"share_url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateRecordingRequest struct {
+	Title  string  `json:"title"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Events []Event `json:"events"`
+}
+
+var recordingOptions = pagination.Options{
+	DefaultLimit: 20,
+	MaxLimit:     MaxRecordings,
+	Sorts:        []string{"created_at", "duration", "title"},
+	DefaultSort:  "-created_at",
+}
+
+// CreateRecordingHandler stores a recorded session for the caller. Width
+// and height default to 80x24.
+func CreateRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req CreateRecordingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	rec, err := newRecording(req)
+	if err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	var count int
+	if err := db.DB.QueryRowContext(r.Context(),
+		"SELECT COUNT(*) FROM terminal_recordings WHERE account_id = $1", user.ID,
+	).Scan(&count); err != nil {
+		log.Printf("Failed to count recordings: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save recording"))
+		return
+	}
+	if count >= MaxRecordings {
+		apierror.Write(w, apierror.Conflict(fmt.Sprintf("At most %d recordings are kept; delete some first", MaxRecordings)))
+		return
+	}
+
+	events, _ := json.Marshal(rec.Events)
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO terminal_recordings (account_id, title, width, height, duration, events)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		user.ID, rec.Title, rec.Width, rec.Height, rec.Duration, events,
+	).Scan(&rec.ID, &rec.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to save recording: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save recording"))
+		return
+	}
+	rec.Events = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rec)
+}
+
+// newRecording validates req. Events must be in time order and the
+// recording must have some output.
+func newRecording(req CreateRecordingRequest) (Recording, error) {
+	rec := Recording{Title: strings.TrimSpace(req.Title), Width: req.Width, Height: req.Height, Events: req.Events}
+	if rec.Title == "" {
+		rec.Title = "Untitled session"
+	}
+	if len([]rune(rec.Title)) > 100 {
+		return rec, errors.New("title must be at most 100 characters")
+	}
+	if rec.Width == 0 {
+		rec.Width = 80
+	}
+	if rec.Height == 0 {
+		rec.Height = 24
+	}
+	if rec.Width < 1 || rec.Width > maxTerminalWidth || rec.Height < 1 || rec.Height > maxTerminalHeight {
+		return rec, fmt.Errorf("width must be 1-%d and height 1-%d", maxTerminalWidth, maxTerminalHeight)
+	}
+	if len(rec.Events) > maxRecordingEvents {
+		return rec, fmt.Errorf("a recording has at most %d events", maxRecordingEvents)
+	}
+
+	output := false
+	for i, e := range rec.Events {
+		switch e.Type {
+		case EventOutput:
+			output = true
+		case EventInput, EventResize, EventMarker:
+		default:
+			return rec, fmt.Errorf("event %d: unknown type %q", i, e.Type)
+		}
+		if e.Time < 0 || (i > 0 && e.Time < rec.Events[i-1].Time) {
+			return rec, fmt.Errorf("event %d: times must not go back", i)
+		}
+		if e.Time > maxRecordingLength {
+			return rec, fmt.Errorf("recordings are at most %d hours long", maxRecordingLength/3600)
+		}
+		rec.Duration = e.Time
+	}
+	if !output {
+		return rec, errors.New("the recording has no output")
+	}
+	return rec, nil
+}
+
+// ListRecordingsHandler lists the caller's recordings without their events.
+func ListRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	page, apiErr := pagination.Parse(r.URL.Query(), recordingOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	var total int
+	if err := db.DB.QueryRowContext(r.Context(),
+		"SELECT COUNT(*) FROM terminal_recordings WHERE account_id = $1", user.ID,
+	).Scan(&total); err != nil {
+		log.Printf("Failed to count recordings: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load recordings"))
+		return
+	}
+	order := page.Sort
+	if page.Desc {
+		order += " DESC"
+	}
+	rows, err := db.DB.QueryContext(r.Context(),
+		`SELECT id, title, width, height, duration, share_token, created_at
+		 FROM terminal_recordings WHERE account_id = $1
+		 ORDER BY `+order+`, id LIMIT $2 OFFSET $3`,
+		user.ID, page.Limit, page.Offset)
+	if err != nil {
+		log.Printf("Failed to load recordings: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load recordings"))
+		return
+	}
+	defer rows.Close()
+
+	recordings := []Recording{}
+	for rows.Next() {
+		var rec Recording
+		var token sql.NullString
+		if err := rows.Scan(&rec.ID, &rec.Title, &rec.Width, &rec.Height, &rec.Duration, &token, &rec.CreatedAt); err != nil {
+			log.Printf("Failed to read recording: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load recordings"))
+			return
+		}
+		if token.Valid {
+			rec.ShareURL = replayURL(token.String)
+		}
+		recordings = append(recordings, rec)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(recordings, total, page))
+}
+
+// GetRecordingHandler returns one of the caller's recordings with its
+// events for playback. ?format=cast returns it as an asciicast v2 file
+// instead, which asciinema and compatible players can open.
+func GetRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.Validation("Invalid recording id"))
+		return
+	}
+	rec, err := loadRecording(r, "r.id = $1 AND r.account_id = $2", id, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Recording not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load recording %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to load recording"))
+		return
+	}
+	writeRecording(w, r, rec)
+}
+
+// ReplayHandler returns a shared recording by its token. No login is
+// needed: the token is the capability.
+func ReplayHandler(w http.ResponseWriter, r *http.Request) {
+	rec, err := loadRecording(r, "r.share_token = $1 AND "+login.NotSuspended("r.account_id"), r.PathValue("token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Recording not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load shared recording: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load recording"))
+		return
+	}
+	writeRecording(w, r, rec)
+}
+
+// ReplayPageHandler serves the player for a shared recording; the page
+// loads it from ReplayHandler.
+func ReplayPageHandler(w http.ResponseWriter, r *http.Request) {
+	if err := templates.Render(w, r, "replay", nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func loadRecording(r *http.Request, where string, args ...interface{}) (Recording, error) {
+	var rec Recording
+	var events []byte
+	var token sql.NullString
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT r.id, r.title, r.width, r.height, r.duration, r.events, r.share_token, a.username, r.created_at
+		 FROM terminal_recordings r
+		 JOIN accounts a ON a.id = r.account_id
+		 WHERE `+where, args...,
+	).Scan(&rec.ID, &rec.Title, &rec.Width, &rec.Height, &rec.Duration, &events, &token, &rec.Author, &rec.CreatedAt)
+	if err != nil {
+		return rec, err
+	}
+	if token.Valid {
+		rec.ShareURL = replayURL(token.String)
+	}
+	if err := json.Unmarshal(events, &rec.Events); err != nil {
+		return rec, fmt.Errorf("recording %d events: %w", rec.ID, err)
+	}
+	return rec, nil
+}
+
+func writeRecording(w http.ResponseWriter, r *http.Request, rec Recording) {
+	if r.URL.Query().Get("format") != "cast" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rec)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="recording-%d.cast"`, rec.ID))
+	writeCast(w, rec)
+}
+
+// writeCast writes rec in the asciicast v2 format: a header object, then
+// one event per line.
+func writeCast(w http.ResponseWriter, rec Recording) {
+	enc := json.NewEncoder(w)
+	enc.Encode(map[string]interface{}{
+		"version":   2,
+		"width":     rec.Width,
+		"height":    rec.Height,
+		"timestamp": rec.CreatedAt.Unix(),
+		"title":     rec.Title,
+	})
+	for _, e := range rec.Events {
+		enc.Encode(e)
+	}
+}
+
+// DeleteRecordingHandler deletes one of the caller's recordings, and with
+// it any share link.
+func DeleteRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.Validation("Invalid recording id"))
+		return
+	}
+	res, err := db.DB.ExecContext(r.Context(),
+		"DELETE FROM terminal_recordings WHERE id = $1 AND account_id = $2", id, user.ID)
+	if err != nil {
+		log.Printf("Failed to delete recording %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to delete recording"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Recording not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ShareRecordingHandler makes one of the caller's recordings public and
+// returns its replay link. Sharing again keeps the link.
+func ShareRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.Validation("Invalid recording id"))
+		return
+	}
+	var token string
+	err = db.DB.QueryRowContext(r.Context(),
+		`UPDATE terminal_recordings SET share_token = COALESCE(share_token, $1)
+		 WHERE id = $2 AND account_id = $3 RETURNING share_token`,
+		newShareToken(), id, user.ID,
+	).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Recording not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to share recording %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to share recording"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"share_url": replayURL(token)})
+}
+
+// UnshareRecordingHandler revokes a recording's replay link. Sharing it
+// again makes a new one.
+func UnshareRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, apierror.Validation("Invalid recording id"))
+		return
+	}
+	res, err := db.DB.ExecContext(r.Context(),
+		"UPDATE terminal_recordings SET share_token = NULL WHERE id = $1 AND account_id = $2", id, user.ID)
+	if err != nil {
+		log.Printf("Failed to unshare recording %d: %v", id, err)
+		apierror.Write(w, apierror.Internal("Failed to unshare recording"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierror.Write(w, apierror.NotFound("Recording not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newShareToken() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func replayURL(token string) string {
+	return "/replay/" + token
+}
@@ -0,0 +1,131 @@
+package terminal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEventJSON(t *testing.T) {
+	var e Event
+	if err := json.Unmarshal([]byte(`[1.5, "o", "hello\r\n"]`), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e != (Event{Time: 1.5, Type: EventOutput, Data: "hello\r\n"}) {
+		t.Errorf("Unmarshal = %+v", e)
+	}
+	b, _ := json.Marshal(e)
+	if string(b) != `[1.5,"o","hello\r\n"]` {
+		t.Errorf("Marshal = %s", b)
+	}
+	for _, bad := range []string{`[1, "o"]`, `["1", "o", "x"]`, `{"time": 1}`} {
+		if err := json.Unmarshal([]byte(bad), &e); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestNewRecording(t *testing.T) {
+	rec, err := newRecording(CreateRecordingRequest{Events: []Event{
+		{0, EventInput, "ls"}, {0.2, EventOutput, "README.md\r\n"}, {2.5, EventMarker, ""},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Title != "Untitled session" || rec.Width != 80 || rec.Height != 24 || rec.Duration != 2.5 {
+		t.Errorf("newRecording = %+v", rec)
+	}
+
+	tests := map[string]CreateRecordingRequest{
+		"no output":    {Events: []Event{{0, EventInput, "ls"}}},
+		"unknown type": {Events: []Event{{0, "x", ""}}},
+		"time goes back": {Events: []Event{
+			{1, EventOutput, "a"}, {0.5, EventOutput, "b"},
+		}},
+		"too long":   {Events: []Event{{maxRecordingLength + 1, EventOutput, "a"}}},
+		"too wide":   {Width: maxTerminalWidth + 1, Events: []Event{{0, EventOutput, "a"}}},
+		"long title": {Title: strings.Repeat("x", 101), Events: []Event{{0, EventOutput, "a"}}},
+	}
+	for name, req := range tests {
+		if _, err := newRecording(req); err == nil {
+			t.Errorf("%s: newRecording succeeded", name)
+		}
+	}
+}
+
+func TestCreateRecording(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("INSERT INTO terminal_recordings").
+		WithArgs(7, "demo", 80, 24, 1.25, []byte(`[[0,"o","$ ls\r\n"],[1.25,"o","README.md\r\n"]]`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+
+	rec := httptest.NewRecorder()
+	CreateRecordingHandler(rec, request("POST", "/api/terminal/recordings",
+		`{"title":"demo","events":[[0,"o","$ ls\r\n"],[1.25,"o","README.md\r\n"]]}`))
+	if rec.Code != http.StatusCreated || strings.Contains(rec.Body.String(), "events") {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateRecordingOverLimit(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT COUNT").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(MaxRecordings))
+
+	rec := httptest.NewRecorder()
+	CreateRecordingHandler(rec, request("POST", "/api/terminal/recordings", `{"events":[[0,"o","x"]]}`))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", rec.Code)
+	}
+}
+
+func TestReplayCast(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useDB(t, mockDB)
+	created := time.Unix(1700000000, 0)
+	mock.ExpectQuery("FROM terminal_recordings r").WithArgs("tok").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "width", "height", "duration", "events", "share_token", "username", "created_at"}).
+			AddRow(4, "demo", 100, 30, 1.25, []byte(`[[0,"o","$ ls\r\n"],[1.25,"o","README.md\r\n"]]`), "tok", "ana", created))
+
+	req := httptest.NewRequest("GET", "/api/terminal/replays/tok?format=cast", nil)
+	req.SetPathValue("token", "tok")
+	rec := httptest.NewRecorder()
+	ReplayHandler(rec, req)
+
+	want := `{"height":30,"timestamp":1700000000,"title":"demo","version":2,"width":100}
+[0,"o","$ ls\r\n"]
+[1.25,"o","README.md\r\n"]
+`
+	if rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Errorf("status = %d:\n%s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-asciicast" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestShareRecording(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("UPDATE terminal_recordings SET share_token = COALESCE").
+		WithArgs(sqlmock.AnyArg(), 4, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"share_token"}).AddRow("tok"))
+
+	req := request("PUT", "/api/terminal/recordings/4/share", "")
+	req.SetPathValue("id", "4")
+	rec := httptest.NewRecorder()
+	ShareRecordingHandler(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"share_url":"/replay/tok"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,144 @@
+package terminal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/handlers/secrets"
+	"allanswebterminal/sandbox"
+	"allanswebterminal/validate"
+)
+
+// execTimeout bounds one command. Long-running programs belong in a
+// project run, not the interactive terminal.
+const execTimeout = 30 * time.Second
+
+type ExecRequest struct {
+	Command string `json:"command" validate:"required,max=1000"`
+}
+
+// ExecHandler runs a shell command in the caller's sandbox container,
+// with their secrets and the environment saved in their shell state. Each
+// command gets a fresh sh in the home directory; files persist while the
+// container does. A command that times out resets the container.
+func ExecHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req ExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	if !sandbox.Configured() {
+		apierror.Write(w, errNoSandbox())
+		return
+	}
+
+	env, err := commandEnv(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Failed to load terminal environment of account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to run command"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), execTimeout)
+	defer cancel()
+	res, err := sandbox.Exec(ctx, user.ID, sandbox.ExecRequest{Command: strings.TrimSpace(req.Command), Env: env})
+	switch {
+	case errors.Is(err, sandbox.ErrPoolFull):
+		apierror.Write(w, apierror.Unavailable("All terminal sandboxes are busy; try again shortly"))
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		// The command may still be running inside; a fresh container is
+		// the only sure way to stop it. Report it as timeout(1) would.
+		if err := sandbox.Release(context.Background(), user.ID); err != nil {
+			log.Printf("Failed to stop sandbox of account %d: %v", user.ID, err)
+		}
+		res = &sandbox.ExecResult{
+			Stderr:   "command timed out after 30s; the sandbox was reset\n",
+			ExitCode: 124,
+			Duration: execTimeout,
+		}
+	case err != nil:
+		log.Printf("Sandbox exec failed for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Unavailable("The terminal sandbox failed; try again later"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// errNoSandbox carries a reason clients can tell apart from a busy or
+// failing sandbox; the terminal treats it as "command not found".
+func errNoSandbox() *apierror.Error {
+	return apierror.Unavailable("The terminal sandbox is not configured").
+		WithDetails(map[string]string{"reason": "not_configured"})
+}
+
+// commandEnv is the account's secrets overlaid with the variables saved
+// in its shell state.
+func commandEnv(ctx context.Context, accountID int) (map[string]string, error) {
+	env, err := secrets.Env(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := savedEnv(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if env == nil {
+		return saved, nil
+	}
+	for name, value := range saved {
+		env[name] = value
+	}
+	return env, nil
+}
+
+func savedEnv(ctx context.Context, accountID int) (map[string]string, error) {
+	var raw []byte
+	err := db.DB.QueryRowContext(ctx, "SELECT env FROM terminal_state WHERE account_id = $1", accountID).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env map[string]string
+	return env, json.Unmarshal(raw, &env)
+}
+
+// ResetSandboxHandler stops the caller's container, discarding its files.
+// The next command starts a fresh one.
+func ResetSandboxHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	if !sandbox.Configured() {
+		apierror.Write(w, errNoSandbox())
+		return
+	}
+	if err := sandbox.Release(r.Context(), user.ID); err != nil {
+		log.Printf("Failed to stop sandbox of account %d: %v", user.ID, err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
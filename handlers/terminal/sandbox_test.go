@@ -0,0 +1,50 @@
+package terminal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"allanswebterminal/sandbox"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func useSandbox(t *testing.T, rt *sandbox.Mock) {
+	pool := sandbox.NewPool(rt, sandbox.PoolOptions{Max: 2})
+	sandbox.SetPool(pool)
+	t.Cleanup(func() {
+		sandbox.SetPool(nil)
+		pool.Close(context.Background())
+	})
+}
+
+func TestExec(t *testing.T) {
+	rt := &sandbox.Mock{Reply: sandbox.ExecResult{Stdout: "hello\n"}}
+	useSandbox(t, rt)
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT env FROM terminal_state").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"env"}).AddRow([]byte(`{"NAME":"ana"}`)))
+
+	rec := httptest.NewRecorder()
+	ExecHandler(rec, request("POST", "/api/terminal/exec", `{"command":"echo hello "}`))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"stdout":"hello\n"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	execs := rt.Execs()
+	if len(execs) != 1 || execs[0].Command != "echo hello" || execs[0].Env["NAME"] != "ana" {
+		t.Errorf("Execs() = %+v", execs)
+	}
+}
+
+func TestExecWithoutSandbox(t *testing.T) {
+	sandbox.SetPool(nil)
+	setupMockDB(t)
+	rec := httptest.NewRecorder()
+	ExecHandler(rec, request("POST", "/api/terminal/exec", `{"command":"ls"}`))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"reason":"not_configured"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,273 @@
+// Package terminal keeps the web terminal's state per account: the command
+// history and the shell's working directory and environment, so a user who
+// reconnects, from any device, picks up where they left off.
+package terminal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/pagination"
+	"allanswebterminal/validate"
+)
+
+const (
+	// MaxHistory is how many commands are kept per account; older ones are
+	// dropped as new ones come in.
+	MaxHistory  = 1000
+	maxEnvVars  = 50
+	maxEnvValue = 1000
+	defaultDir  = "~"
+)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// Command is an entry of the command history.
+type Command struct {
+	ID        int64     `json:"id"`
+	Command   string    `json:"command"`
+	Cwd       string    `json:"cwd"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RecordCommandRequest struct {
+	Command string `json:"command" validate:"required,max=1000"`
+	Cwd     string `json:"cwd" validate:"max=255"`
+}
+
+// State is the shell state restored on reconnect.
+type State struct {
+	Cwd       string            `json:"cwd"`
+	Env       map[string]string `json:"env"`
+	UpdatedAt *time.Time        `json:"updated_at"`
+}
+
+var historyOptions = pagination.Options{
+	DefaultLimit: 50,
+	MaxLimit:     MaxHistory,
+	Sorts:        []string{"created_at"},
+	DefaultSort:  "-created_at",
+}
+
+// RecordCommandHandler appends a command to the caller's history. Commands
+// starting with a space are not recorded, as in bash with ignorespace, so
+// users can keep secrets out of it.
+func RecordCommandHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var req RecordCommandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if apiErr := validate.Check(&req); apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+	if strings.HasPrefix(req.Command, " ") || strings.TrimSpace(req.Command) == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.Cwd == "" {
+		req.Cwd = defaultDir
+	}
+
+	c := Command{Command: strings.TrimRight(req.Command, " \t\r\n"), Cwd: req.Cwd}
+	err = db.DB.QueryRowContext(r.Context(),
+		"INSERT INTO terminal_history (account_id, command, cwd) VALUES ($1, $2, $3) RETURNING id, created_at",
+		user.ID, c.Command, c.Cwd,
+	).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to record command: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to record command"))
+		return
+	}
+	if _, err := db.DB.ExecContext(r.Context(),
+		`DELETE FROM terminal_history WHERE account_id = $1 AND id <= (
+			SELECT id FROM terminal_history WHERE account_id = $1 ORDER BY id DESC OFFSET $2 LIMIT 1)`,
+		user.ID, MaxHistory,
+	); err != nil {
+		log.Printf("Failed to trim terminal history of account %d: %v", user.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// HistoryHandler lists the caller's commands, newest first unless
+// ?sort=created_at. ?q= keeps the commands containing it, ignoring case.
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	q := r.URL.Query()
+	page, apiErr := pagination.Parse(q, historyOptions)
+	if apiErr != nil {
+		apierror.Write(w, apiErr)
+		return
+	}
+
+	where, args := "account_id = $1", []interface{}{user.ID}
+	if search := q.Get("q"); search != "" {
+		args = append(args, pagination.LikePattern(search))
+		where += fmt.Sprintf(" AND command ILIKE $%d", len(args))
+	}
+	var total int
+	if err := db.DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM terminal_history WHERE "+where, args...).Scan(&total); err != nil {
+		log.Printf("Failed to count terminal history: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load history"))
+		return
+	}
+	order := "id"
+	if page.Desc {
+		order = "id DESC"
+	}
+	rows, err := db.DB.QueryContext(r.Context(),
+		fmt.Sprintf("SELECT id, command, cwd, created_at FROM terminal_history WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d",
+			where, order, len(args)+1, len(args)+2),
+		append(args, page.Limit, page.Offset)...)
+	if err != nil {
+		log.Printf("Failed to load terminal history: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load history"))
+		return
+	}
+	defer rows.Close()
+
+	commands := []Command{}
+	for rows.Next() {
+		var c Command
+		if err := rows.Scan(&c.ID, &c.Command, &c.Cwd, &c.CreatedAt); err != nil {
+			log.Printf("Failed to read terminal history: %v", err)
+			apierror.Write(w, apierror.Internal("Failed to load history"))
+			return
+		}
+		commands = append(commands, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.NewPage(commands, total, page))
+}
+
+// ClearHistoryHandler deletes the caller's command history.
+func ClearHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	if _, err := db.DB.ExecContext(r.Context(), "DELETE FROM terminal_history WHERE account_id = $1", user.ID); err != nil {
+		log.Printf("Failed to clear terminal history: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to clear history"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetStateHandler returns the caller's shell state, or the home directory
+// and an empty environment if none was saved.
+func GetStateHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	state := State{Cwd: defaultDir, Env: map[string]string{}}
+	var env []byte
+	var updatedAt time.Time
+	err = db.DB.QueryRowContext(r.Context(),
+		"SELECT cwd, env, updated_at FROM terminal_state WHERE account_id = $1", user.ID,
+	).Scan(&state.Cwd, &env, &updatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		log.Printf("Failed to load terminal state: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load terminal state"))
+		return
+	default:
+		state.UpdatedAt = &updatedAt
+		if err := json.Unmarshal(env, &state.Env); err != nil {
+			log.Printf("Invalid terminal environment of account %d: %v", user.ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// SaveStateHandler replaces the caller's shell state.
+func SaveStateHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+	var state State
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if err := validateState(&state); err != nil {
+		apierror.Write(w, apierror.Validation(err.Error()))
+		return
+	}
+
+	env, _ := json.Marshal(state.Env)
+	var updatedAt time.Time
+	err = db.DB.QueryRowContext(r.Context(),
+		`INSERT INTO terminal_state (account_id, cwd, env) VALUES ($1, $2, $3)
+		 ON CONFLICT (account_id) DO UPDATE SET cwd = EXCLUDED.cwd, env = EXCLUDED.env, updated_at = CURRENT_TIMESTAMP
+		 RETURNING updated_at`,
+		user.ID, state.Cwd, env,
+	).Scan(&updatedAt)
+	if err != nil {
+		log.Printf("Failed to save terminal state: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to save terminal state"))
+		return
+	}
+	state.UpdatedAt = &updatedAt
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+func validateState(state *State) error {
+	state.Cwd = strings.TrimSpace(state.Cwd)
+	if state.Cwd == "" {
+		state.Cwd = defaultDir
+	}
+	if len(state.Cwd) > 255 {
+		return errors.New("cwd must be at most 255 characters")
+	}
+	if state.Env == nil {
+		state.Env = map[string]string{}
+	}
+	if len(state.Env) > maxEnvVars {
+		return fmt.Errorf("at most %d environment variables are kept", maxEnvVars)
+	}
+	for name, value := range state.Env {
+		if !envName.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if len(value) > maxEnvValue {
+			return fmt.Errorf("%s must be at most %d characters", name, maxEnvValue)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package terminal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// setupMockDB swaps in a mock database and expects the current user
+// lookup for account 7.
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	useDB(t, mockDB)
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(7, "ana", "user"))
+	return mock
+}
+
+func useDB(t *testing.T, mockDB *sql.DB) {
+	originalDB := db.DB
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+}
+
+func request(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	return req
+}
+
+func TestRecordCommand(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("INSERT INTO terminal_history").WithArgs(7, "cd projects", "~").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectExec("DELETE FROM terminal_history").WithArgs(7, MaxHistory).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rec := httptest.NewRecorder()
+	RecordCommandHandler(rec, request("POST", "/api/terminal/history", `{"command":"cd projects\n"}`))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"command":"cd projects"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecordCommandIgnoresLeadingSpace(t *testing.T) {
+	mock := setupMockDB(t)
+	rec := httptest.NewRecorder()
+	RecordCommandHandler(rec, request("POST", "/api/terminal/history", `{"command":" export TOKEN=secret"}`))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM terminal_history WHERE account_id = \$1 AND command ILIKE \$2`).
+		WithArgs(7, "%100\\%%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`ORDER BY id DESC LIMIT \$3 OFFSET \$4`).WithArgs(7, "%100\\%%", 50, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "cwd", "created_at"}).
+			AddRow(3, "echo 100%", "~", time.Now()))
+
+	rec := httptest.NewRecorder()
+	HistoryHandler(rec, request("GET", "/api/terminal/history?q=100%25", ""))
+	var page struct {
+		Items []Command `json:"items"`
+		Total int       `json:"total"`
+	}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if rec.Code != http.StatusOK || page.Total != 1 || len(page.Items) != 1 || page.Items[0].Command != "echo 100%" {
+		t.Errorf("status = %d, page = %+v", rec.Code, page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetStateDefaults(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT cwd, env, updated_at FROM terminal_state").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"cwd", "env", "updated_at"}))
+
+	rec := httptest.NewRecorder()
+	GetStateHandler(rec, request("GET", "/api/terminal/state", ""))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"cwd":"~","env":{},"updated_at":null}` {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestValidateState(t *testing.T) {
+	state := State{Cwd: " projects/flashcards "}
+	if err := validateState(&state); err != nil || state.Cwd != "projects/flashcards" || state.Env == nil {
+		t.Errorf("validateState = %+v, %v", state, err)
+	}
+	for _, env := range []map[string]string{
+		{"1BAD": "x"},
+		{"HAS SPACE": "x"},
+		{"LONG": strings.Repeat("x", maxEnvValue+1)},
+	} {
+		if err := validateState(&State{Env: env}); err == nil {
+			t.Errorf("validateState(%v) succeeded", env)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package unleashedjs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/ujs"
+)
+
+// Cached operations. Benchmarks are never cached: timing is their result.
+const (
+	cacheOpCompile = "compile"
+	cacheOpRun     = "run"
+)
+
+// ResultCache keeps compile and run results keyed by a SHA-256 of the
+// source, the operation and the runtime version, so resubmitting unchanged
+// source skips the worker entirely. It is bounded by entry count and total
+// encoded size and evicts the least recently used entries first.
+type ResultCache struct {
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List // front is most recently used
+	entries map[string]*list.Element
+	bytes   int
+	hits    int64
+	misses  int64
+}
+
+type cacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// CacheStats is returned by the admin cache endpoint.
+type CacheStats struct {
+	Entries    int    `json:"entries"`
+	Bytes      int    `json:"bytes"`
+	MaxEntries int    `json:"max_entries"`
+	MaxBytes   int    `json:"max_bytes"`
+	TTLSeconds int    `json:"ttl_seconds"`
+	Hits       int64  `json:"hits"`
+	Misses     int64  `json:"misses"`
+	Version    string `json:"version"`
+}
+
+// NewResultCache returns a cache holding at most maxEntries results and
+// maxBytes of encoded data, each for at most ttl.
+func NewResultCache(maxEntries, maxBytes int, ttl time.Duration) *ResultCache {
+	return &ResultCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		now:        time.Now,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+var (
+	resultCacheMu sync.RWMutex
+	resultCache   *ResultCache
+)
+
+// SetResultCache enables result caching for the compile and execute
+// endpoints. Pass nil to disable it.
+func SetResultCache(c *ResultCache) {
+	resultCacheMu.Lock()
+	defer resultCacheMu.Unlock()
+	resultCache = c
+}
+
+func currentResultCache() *ResultCache {
+	resultCacheMu.RLock()
+	defer resultCacheMu.RUnlock()
+	return resultCache
+}
+
+// CacheKey returns the hex SHA-256 identifying op on source for the current
+// language version and runtime backend.
+func CacheKey(op, source string) string {
+	h := sha256.New()
+	for _, part := range []string{ujs.Version, ujs.Backend, op, source} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get decodes the cached value for key into out. A nil cache always misses.
+func (c *ResultCache) get(key string, out interface{}) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if ok && !c.now().Before(el.Value.(*cacheEntry).expiresAt) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok || json.Unmarshal(el.Value.(*cacheEntry).value, out) != nil {
+		c.misses++
+		return false
+	}
+	c.lru.MoveToFront(el)
+	c.hits++
+	return true
+}
+
+// put stores value under key, evicting old entries to stay within limits.
+// Values larger than the whole cache are not stored.
+func (c *ResultCache) put(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil || len(data) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	for c.lru.Len() > 0 && (c.lru.Len() >= c.maxEntries || c.bytes+len(data) > c.maxBytes) {
+		c.remove(c.lru.Back())
+	}
+	entry := &cacheEntry{key: key, value: data, expiresAt: c.now().Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.bytes += len(data)
+}
+
+func (c *ResultCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= len(entry.value)
+}
+
+// Invalidate drops the given keys, or every entry when none are given, and
+// returns how many entries were removed.
+func (c *ResultCache) Invalidate(keys ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(keys) == 0 {
+		n := c.lru.Len()
+		c.lru.Init()
+		c.entries = make(map[string]*list.Element)
+		c.bytes = 0
+		return n
+	}
+	n := 0
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+			n++
+		}
+	}
+	return n
+}
+
+// Stats reports the cache's size and hit rate.
+func (c *ResultCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:    c.lru.Len(),
+		Bytes:      c.bytes,
+		MaxEntries: c.maxEntries,
+		MaxBytes:   c.maxBytes,
+		TTLSeconds: int(c.ttl.Seconds()),
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Version:    ujs.Version,
+	}
+}
+
+// CacheStatsHandler reports the result cache's size and hit rate. Admin only.
+func CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := adminCache(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Stats())
+}
+
+// InvalidateCacheHandler clears the result cache, or with ?source= only the
+// entries for that source, or with ?key= a single entry. Admin only.
+func InvalidateCacheHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := adminCache(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	var keys []string
+	if source := q.Get("source"); source != "" {
+		keys = append(keys, CacheKey(cacheOpCompile, source), CacheKey(cacheOpRun, source))
+	}
+	if key := q.Get("key"); key != "" {
+		keys = append(keys, key)
+	}
+	removed := c.Invalidate(keys...)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+}
+
+// adminCache returns the result cache for an admin caller, writing the
+// error response otherwise.
+func adminCache(w http.ResponseWriter, r *http.Request) (*ResultCache, bool) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return nil, false
+	}
+	if user.Role != "admin" {
+		apierror.Write(w, apierror.Forbidden("Admin access required"))
+		return nil, false
+	}
+	c := currentResultCache()
+	if c == nil {
+		apierror.Write(w, apierror.Unavailable("Result caching is disabled"))
+		return nil, false
+	}
+	return c, true
+}
@@ -0,0 +1,187 @@
+package unleashedjs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/securecookie"
+	"allanswebterminal/ujs"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// countingEngine runs in-process and counts calls so tests can tell a cache
+// hit from a miss.
+type countingEngine struct {
+	inProcess
+	compiles, runs int
+}
+
+func (e *countingEngine) Compile(ctx context.Context, source string) (*ujs.Result, error) {
+	e.compiles++
+	return e.inProcess.Compile(ctx, source)
+}
+
+func (e *countingEngine) Run(ctx context.Context, source string, stdout io.Writer) (*ujs.ExecResult, error) {
+	e.runs++
+	return e.inProcess.Run(ctx, source, stdout)
+}
+
+func withCache(t *testing.T, c *ResultCache) *countingEngine {
+	t.Helper()
+	engine := &countingEngine{}
+	SetEngine(engine)
+	SetResultCache(c)
+	t.Cleanup(func() {
+		SetEngine(inProcess{})
+		SetResultCache(nil)
+	})
+	return engine
+}
+
+func TestCacheKey(t *testing.T) {
+	if CacheKey(cacheOpCompile, "x;") == CacheKey(cacheOpRun, "x;") {
+		t.Error("compile and run share a key")
+	}
+	if CacheKey(cacheOpCompile, "x;") != CacheKey(cacheOpCompile, "x;") {
+		t.Error("key is not stable")
+	}
+}
+
+func TestResultCacheLimits(t *testing.T) {
+	t.Run("Evicts least recently used", func(t *testing.T) {
+		c := NewResultCache(2, 1<<20, time.Hour)
+		c.put("a", 1)
+		c.put("b", 2)
+		var v int
+		c.get("a", &v)
+		c.put("c", 3)
+		if c.get("b", &v) {
+			t.Error("b should have been evicted")
+		}
+		if !c.get("a", &v) || v != 1 || !c.get("c", &v) {
+			t.Error("a and c should remain")
+		}
+	})
+
+	t.Run("Byte limit", func(t *testing.T) {
+		c := NewResultCache(100, 10, time.Hour)
+		c.put("a", "0123")
+		c.put("b", "4567")
+		if stats := c.Stats(); stats.Entries != 1 || stats.Bytes > 10 {
+			t.Errorf("stats = %+v", stats)
+		}
+		c.put("big", strings.Repeat("x", 20))
+		var v string
+		if c.get("big", &v) {
+			t.Error("value larger than the cache was stored")
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		c := NewResultCache(10, 1<<20, time.Minute)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+		c.put("a", 1)
+		now = now.Add(2 * time.Minute)
+		var v int
+		if c.get("a", &v) || c.Stats().Entries != 0 {
+			t.Error("expired entry returned")
+		}
+	})
+}
+
+func TestCompileHandlerUsesCache(t *testing.T) {
+	engine := withCache(t, NewResultCache(10, 1<<20, time.Hour))
+	for i, want := range []string{"MISS", "HIT"} {
+		rec := httptest.NewRecorder()
+		CompileHandler(rec, httptest.NewRequest(http.MethodPost, "/api/ujs/compile", strings.NewReader(`{"source":"let a = ;"}`)))
+		if rec.Header().Get("X-Cache") != want {
+			t.Errorf("request %d: X-Cache = %q, want %s", i, rec.Header().Get("X-Cache"), want)
+		}
+		var res ujs.Result
+		json.NewDecoder(rec.Body).Decode(&res)
+		if res.OK || len(res.Diagnostics) != 1 {
+			t.Errorf("request %d: result = %+v", i, res)
+		}
+	}
+	if engine.compiles != 1 {
+		t.Errorf("compiled %d times, want 1", engine.compiles)
+	}
+}
+
+func TestExecuteHandlerUsesCache(t *testing.T) {
+	execute := func(source string) string {
+		body, _ := json.Marshal(ExecuteRequest{Source: source})
+		rec := httptest.NewRecorder()
+		ExecuteHandler(rec, httptest.NewRequest(http.MethodPost, "/api/ujs/execute", strings.NewReader(string(body))))
+		return rec.Body.String()
+	}
+
+	t.Run("Deterministic programs are replayed", func(t *testing.T) {
+		engine := withCache(t, NewResultCache(10, 1<<20, time.Hour))
+		first := execute(`print("hi");`)
+		second := execute(`print("hi");`)
+		if engine.runs != 1 || !strings.Contains(second, `"text":"hi\n"`) || !strings.Contains(second, "event: result") {
+			t.Errorf("runs = %d, first:\n%s\nsecond:\n%s", engine.runs, first, second)
+		}
+	})
+
+	t.Run("Programs using clock are not cached", func(t *testing.T) {
+		engine := withCache(t, NewResultCache(10, 1<<20, time.Hour))
+		execute(`print(clock());`)
+		execute(`print(clock());`)
+		if engine.runs != 2 {
+			t.Errorf("runs = %d, want 2", engine.runs)
+		}
+	})
+}
+
+func TestCacheHandler(t *testing.T) {
+	c := NewResultCache(10, 1<<20, time.Hour)
+	withCache(t, c)
+	c.put(CacheKey(cacheOpCompile, "x;"), 1)
+	c.put(CacheKey(cacheOpRun, "x;"), 1)
+	c.put(CacheKey(cacheOpRun, "y;"), 1)
+
+	request := func(method, target, role string) *httptest.ResponseRecorder {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("SELECT id, username, role FROM accounts").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "root", role))
+		req := httptest.NewRequest(method, target, nil)
+		req.AddCookie(securecookie.Cookie("user_id", "1"))
+		rec := httptest.NewRecorder()
+		if method == http.MethodDelete {
+			InvalidateCacheHandler(rec, req)
+		} else {
+			CacheStatsHandler(rec, req)
+		}
+		return rec
+	}
+
+	if rec := request(http.MethodGet, "/api/admin/ujs-cache", "user"); rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin status = %d, want 403", rec.Code)
+	}
+
+	rec := request(http.MethodGet, "/api/admin/ujs-cache", "admin")
+	var stats CacheStats
+	json.NewDecoder(rec.Body).Decode(&stats)
+	if stats.Entries != 3 || stats.Version != ujs.Version {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	rec = request(http.MethodDelete, "/api/admin/ujs-cache?source=x%3B", "admin")
+	if !strings.Contains(rec.Body.String(), `"removed":2`) {
+		t.Errorf("delete by source = %s", rec.Body.String())
+	}
+	rec = request(http.MethodDelete, "/api/admin/ujs-cache", "admin")
+	if !strings.Contains(rec.Body.String(), `"removed":1`) || c.Stats().Entries != 0 {
+		t.Errorf("clear = %s", rec.Body.String())
+	}
+}
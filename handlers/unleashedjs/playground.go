@@ -0,0 +1,242 @@
+package unleashedjs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/files"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/sse"
+	"allanswebterminal/templates"
+	"allanswebterminal/ujs"
+)
+
+// Snippets are saved in the file store as playground/<name>.ujs.
+const (
+	snippetDir      = "playground/"
+	snippetFileType = "ujs"
+)
+
+var snippetNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type SaveSnippetRequest struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+type Snippet struct {
+	Token     string    `json:"token"`
+	Name      string    `json:"name"`
+	Source    string    `json:"source,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	URL       string    `json:"url"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ExecuteRequest struct {
+	Source string `json:"source"`
+}
+
+// PlaygroundPageHandler serves the playground editor. A shared snippet is
+// opened with /playground?s=<token>; the page loads it from the API.
+func PlaygroundPageHandler(w http.ResponseWriter, r *http.Request) {
+	if err := templates.Render(w, r, "playground", nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SaveSnippetHandler saves source as a named snippet in the caller's files
+// and returns its share URL. Saving under an existing name updates the
+// snippet and keeps its token.
+func SaveSnippetHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req SaveSnippetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if !snippetNamePattern.MatchString(req.Name) {
+		apierror.Write(w, apierror.Validation("name must be 1-64 letters, digits, '-' or '_'"))
+		return
+	}
+	if strings.TrimSpace(req.Source) == "" {
+		apierror.Write(w, apierror.Validation("source is required"))
+		return
+	}
+	if len(req.Source) > ujs.MaxSourceSize {
+		apierror.Write(w, apierror.Validation("source is too large"))
+		return
+	}
+
+	file := files.UserFile{
+		AccountID: user.ID,
+		Filename:  snippetDir + req.Name + ".ujs",
+		Content:   req.Source,
+		FileType:  snippetFileType,
+	}
+	if err := files.Save(r.Context(), &file); err != nil {
+		log.Printf("Failed to save snippet for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save snippet"))
+		return
+	}
+	token, err := shareFile(r.Context(), file.ID)
+	if err != nil {
+		log.Printf("Failed to share snippet %d: %v", file.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to save snippet"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Snippet{
+		Token:     token,
+		Name:      req.Name,
+		URL:       snippetURL(token),
+		UpdatedAt: file.UpdatedAt,
+	})
+}
+
+// GetSnippetHandler returns a shared snippet by token. No login is needed:
+// the token is the capability.
+func GetSnippetHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		apierror.Write(w, apierror.Validation("token is required"))
+		return
+	}
+
+	var s Snippet
+	var filename string
+	var accountID int
+	var keyID sql.NullString
+	var dataKey []byte
+	err := db.DB.QueryRowContext(r.Context(),
+		`SELECT s.token, f.account_id, f.filename, f.content, f.key_id, f.data_key, a.username, f.updated_at
+		 FROM ujs_snippets s
+		 JOIN user_files f ON f.id = s.file_id
+		 JOIN accounts a ON a.id = f.account_id
+		 WHERE s.token = $1 AND `+files.NotQuarantined("f")+` AND `+login.NotSuspended("f.account_id"), token,
+	).Scan(&s.Token, &accountID, &filename, &s.Source, &keyID, &dataKey, &s.Author, &s.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, apierror.NotFound("Snippet not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load snippet: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load snippet"))
+		return
+	}
+	if s.Source, err = files.Decrypt(accountID, filename, s.Source, keyID, dataKey); err != nil {
+		log.Printf("Failed to decrypt snippet %s: %v", token, err)
+		apierror.Write(w, apierror.Internal("Failed to load snippet"))
+		return
+	}
+	s.Name = strings.TrimSuffix(strings.TrimPrefix(filename, snippetDir), ".ujs")
+	s.URL = snippetURL(s.Token)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// ExecuteHandler compiles and runs the submitted source in the engine's
+// sandbox and streams the result as Server-Sent Events: "output" events as
+// the program prints, then a single "result" event with the compile and run
+// outcome, or an "error" event if the engine failed.
+func ExecuteHandler(w http.ResponseWriter, r *http.Request) {
+	var req ExecuteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if strings.TrimSpace(req.Source) == "" {
+		apierror.Write(w, apierror.Validation("source is required"))
+		return
+	}
+
+	stream, err := sse.NewStream(w)
+	if err != nil {
+		apierror.Write(w, apierror.Internal("Streaming not supported"))
+		return
+	}
+
+	cache := currentResultCache()
+	key := CacheKey(cacheOpRun, req.Source)
+	var res *ujs.ExecResult
+	if cache.get(key, &res) {
+		// Replay the output in one event; the program is not run again.
+		if res.Run != nil && res.Run.Output != "" {
+			outputStream{stream}.Write([]byte(res.Run.Output))
+		}
+		stream.Send(sse.Event{Name: "result", Data: res})
+		return
+	}
+
+	res, err = currentEngine().Run(r.Context(), req.Source, outputStream{stream})
+	if err != nil {
+		stream.Send(sse.Event{Name: "error", Data: engineError(err)})
+		return
+	}
+	if cacheable(r.Context(), res) {
+		cache.put(key, res)
+	}
+	stream.Send(sse.Event{Name: "result", Data: res})
+}
+
+// cacheable reports whether running the same source again is certain to
+// produce res: the program is deterministic and was not cut short by the
+// request going away.
+func cacheable(ctx context.Context, res *ujs.ExecResult) bool {
+	if !res.Compile.OK {
+		return true
+	}
+	return res.Compile.Deterministic && ctx.Err() == nil
+}
+
+// outputStream forwards program output to the client as "output" events.
+type outputStream struct {
+	stream *sse.Stream
+}
+
+func (o outputStream) Write(p []byte) (int, error) {
+	if err := o.stream.Send(sse.Event{Name: "output", Data: map[string]string{"text": string(p)}}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// shareFile returns the share token for fileID, creating one the first time.
+func shareFile(ctx context.Context, fileID int) (string, error) {
+	var token string
+	err := db.DB.QueryRowContext(ctx,
+		`INSERT INTO ujs_snippets (token, file_id) VALUES ($1, $2)
+		 ON CONFLICT (file_id) DO UPDATE SET file_id = EXCLUDED.file_id
+		 RETURNING token`,
+		newSnippetToken(), fileID,
+	).Scan(&token)
+	return token, err
+}
+
+func newSnippetToken() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func snippetURL(token string) string {
+	return "/playground?s=" + token
+}
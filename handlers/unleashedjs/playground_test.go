@@ -0,0 +1,136 @@
+package unleashedjs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/securecookie"
+	"allanswebterminal/ujs/worker"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSaveSnippetHandler(t *testing.T) {
+	t.Run("Anonymous", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		SaveSnippetHandler(rec, httptest.NewRequest(http.MethodPost, "/api/ujs/snippets", strings.NewReader(`{}`)))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", rec.Code)
+		}
+	})
+
+	t.Run("Saves to the file store and shares", func(t *testing.T) {
+		mock := setupMockDB(t)
+		expectUser(mock, 3)
+		now := time.Now()
+		mock.ExpectQuery("INSERT INTO user_files").
+			WithArgs(3, "playground/hello.ujs", "print(1);", "ujs", nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(40, now, now))
+		mock.ExpectQuery("INSERT INTO ujs_snippets").
+			WithArgs(sqlmock.AnyArg(), 40).
+			WillReturnRows(sqlmock.NewRows([]string{"token"}).AddRow("abc123"))
+
+		req := httptest.NewRequest(http.MethodPost, "/api/ujs/snippets", strings.NewReader(`{"name":"hello","source":"print(1);"}`))
+		req.AddCookie(securecookie.Cookie("user_id", "3"))
+		rec := httptest.NewRecorder()
+		SaveSnippetHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		var s Snippet
+		json.NewDecoder(rec.Body).Decode(&s)
+		if s.Token != "abc123" || s.URL != "/playground?s=abc123" {
+			t.Errorf("snippet = %+v", s)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Invalid name", func(t *testing.T) {
+		mock := setupMockDB(t)
+		expectUser(mock, 3)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/ujs/snippets", strings.NewReader(`{"name":"../etc","source":"print(1);"}`))
+		req.AddCookie(securecookie.Cookie("user_id", "3"))
+		rec := httptest.NewRecorder()
+		SaveSnippetHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestGetSnippetHandler(t *testing.T) {
+	t.Run("Found", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("SELECT s.token, f.account_id, f.filename, f.content, f.key_id, f.data_key, a.username, f.updated_at").
+			WithArgs("abc123").
+			WillReturnRows(sqlmock.NewRows([]string{"token", "account_id", "filename", "content", "key_id", "data_key", "username", "updated_at"}).
+				AddRow("abc123", 3, "playground/hello.ujs", "print(1);", nil, nil, "ana", time.Now()))
+
+		rec := httptest.NewRecorder()
+		GetSnippetHandler(rec, httptest.NewRequest(http.MethodGet, "/api/ujs/snippets?token=abc123", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		var s Snippet
+		json.NewDecoder(rec.Body).Decode(&s)
+		if s.Name != "hello" || s.Source != "print(1);" || s.Author != "ana" {
+			t.Errorf("snippet = %+v", s)
+		}
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		mock := setupMockDB(t)
+		mock.ExpectQuery("FROM ujs_snippets").WillReturnError(sql.ErrNoRows)
+
+		rec := httptest.NewRecorder()
+		GetSnippetHandler(rec, httptest.NewRequest(http.MethodGet, "/api/ujs/snippets?token=nope", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestExecuteHandler(t *testing.T) {
+	t.Run("Streams output then the result", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		ExecuteHandler(rec, httptest.NewRequest(http.MethodPost, "/api/ujs/execute",
+			strings.NewReader(`{"source":"print(\"hi\");\nprint(2);"}`)))
+
+		body := rec.Body.String()
+		if rec.Header().Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("content type = %q", rec.Header().Get("Content-Type"))
+		}
+		first := strings.Index(body, "event: output")
+		result := strings.Index(body, "event: result")
+		if first < 0 || result < first || strings.Count(body, "event: output") != 2 {
+			t.Errorf("unexpected stream:\n%s", body)
+		}
+	})
+
+	t.Run("Engine failure", func(t *testing.T) {
+		SetEngine(failingEngine{worker.ErrTimeout})
+		defer SetEngine(inProcess{})
+
+		rec := httptest.NewRecorder()
+		ExecuteHandler(rec, httptest.NewRequest(http.MethodPost, "/api/ujs/execute", strings.NewReader(`{"source":"print(1);"}`)))
+		if !strings.Contains(rec.Body.String(), "event: error") {
+			t.Errorf("unexpected stream:\n%s", rec.Body.String())
+		}
+	})
+
+	t.Run("Empty source", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		ExecuteHandler(rec, httptest.NewRequest(http.MethodPost, "/api/ujs/execute", strings.NewReader(`{"source":""}`)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
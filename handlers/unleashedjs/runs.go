@@ -0,0 +1,193 @@
+package unleashedjs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/handlers/login"
+	"allanswebterminal/ujs"
+)
+
+const (
+	defaultRunsLimit = 20
+	maxRunsLimit     = 100
+	// keepRuns is how many runs are kept per account; older ones are pruned
+	// whenever a new run is stored.
+	keepRuns = 100
+)
+
+type BenchmarkRequest struct {
+	Source     string `json:"source"`
+	Iterations int    `json:"iterations"`
+}
+
+type BenchmarkResponse struct {
+	// RunID is set when the run was saved to the caller's history.
+	RunID int `json:"run_id,omitempty"`
+	*ujs.BenchReport
+}
+
+// Run is a stored benchmark. The listing leaves the source out to keep
+// pages small.
+type Run struct {
+	ID         int             `json:"id"`
+	Runtime    string          `json:"runtime"`
+	Iterations int             `json:"iterations"`
+	MedianNs   int64           `json:"median_ns"`
+	MeanNs     int64           `json:"mean_ns"`
+	Steps      int             `json:"steps"`
+	Result     json.RawMessage `json:"result"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type RunsResponse struct {
+	Runs []Run `json:"runs"`
+}
+
+// BenchmarkHandler compiles and times the submitted program alongside the
+// FastMath kernels. Runs that compile and finish are stored in the caller's
+// history; compile and runtime errors are returned but not stored.
+func BenchmarkHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	var req BenchmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if strings.TrimSpace(req.Source) == "" {
+		apierror.Write(w, apierror.Validation("source is required"))
+		return
+	}
+	if req.Iterations < 0 || req.Iterations > ujs.MaxBenchIterations {
+		apierror.Write(w, apierror.Validation(fmt.Sprintf("iterations must be between 1 and %d", ujs.MaxBenchIterations)))
+		return
+	}
+
+	report, err := currentEngine().Benchmark(r.Context(), req.Source, req.Iterations)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	resp := BenchmarkResponse{BenchReport: report}
+	if report.Program != nil {
+		id, err := saveRun(r.Context(), user.ID, req.Source, report)
+		if err != nil {
+			log.Printf("Failed to save UnleashedJS run for account %d: %v", user.ID, err)
+			apierror.Write(w, apierror.Internal("Failed to save benchmark"))
+			return
+		}
+		resp.RunID = id
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RunsHandler lists the caller's stored benchmarks, newest first. Query
+// parameters: limit (default 20, max 100) and before (a run id, for paging).
+func RunsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		apierror.Write(w, apierror.Unauthorized())
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultRunsLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRunsLimit {
+			apierror.Write(w, apierror.Validation(fmt.Sprintf("limit must be between 1 and %d", maxRunsLimit)))
+			return
+		}
+		limit = n
+	}
+	before := 0
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			apierror.Write(w, apierror.Validation("before must be a run id"))
+			return
+		}
+		before = n
+	}
+
+	runs, err := listRuns(r.Context(), user.ID, before, limit)
+	if err != nil {
+		log.Printf("Failed to list UnleashedJS runs for account %d: %v", user.ID, err)
+		apierror.Write(w, apierror.Internal("Failed to load runs"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RunsResponse{Runs: runs})
+}
+
+func saveRun(ctx context.Context, accountID int, source string, report *ujs.BenchReport) (int, error) {
+	result, err := json.Marshal(struct {
+		Program *ujs.ProgramStats  `json:"program"`
+		Kernels []ujs.KernelResult `json:"kernels"`
+	}{report.Program, report.Kernels})
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	stats := report.Program
+	err = db.DB.QueryRowContext(ctx,
+		`INSERT INTO ujs_runs (account_id, source, runtime, iterations, median_ns, mean_ns, steps, result)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		accountID, source, report.Runtime, stats.Iterations, stats.MedianNs, stats.MeanNs, stats.Steps, string(result),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := db.DB.ExecContext(ctx,
+		`DELETE FROM ujs_runs WHERE account_id = $1 AND id NOT IN (
+			SELECT id FROM ujs_runs WHERE account_id = $1 ORDER BY id DESC LIMIT $2)`,
+		accountID, keepRuns,
+	); err != nil {
+		log.Printf("Failed to prune UnleashedJS runs for account %d: %v", accountID, err)
+	}
+	return id, nil
+}
+
+func listRuns(ctx context.Context, accountID, before, limit int) ([]Run, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT id, runtime, iterations, median_ns, mean_ns, steps, result, created_at
+		 FROM ujs_runs
+		 WHERE account_id = $1 AND ($2 = 0 OR id < $2)
+		 ORDER BY id DESC LIMIT $3`,
+		accountID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var run Run
+		var result []byte
+		if err := rows.Scan(&run.ID, &run.Runtime, &run.Iterations, &run.MedianNs, &run.MeanNs, &run.Steps, &result, &run.CreatedAt); err != nil {
+			return nil, err
+		}
+		run.Result = json.RawMessage(result)
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
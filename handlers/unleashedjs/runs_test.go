@@ -0,0 +1,149 @@
+package unleashedjs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+func expectUser(mock sqlmock.Sqlmock, id int) {
+	mock.ExpectQuery("SELECT id, username, role FROM accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(id, "ana", "user"))
+}
+
+func benchmarkRequest(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/ujs/benchmark", strings.NewReader(body))
+	req.AddCookie(securecookie.Cookie("user_id", "3"))
+	return req
+}
+
+func TestBenchmarkHandler(t *testing.T) {
+	t.Run("Anonymous", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		BenchmarkHandler(rec, httptest.NewRequest(http.MethodPost, "/api/ujs/benchmark", strings.NewReader(`{}`)))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", rec.Code)
+		}
+	})
+
+	t.Run("Stores a finished run", func(t *testing.T) {
+		mock := setupMockDB(t)
+		expectUser(mock, 3)
+		mock.ExpectQuery("INSERT INTO ujs_runs").
+			WithArgs(3, "let x = 1 + 2;", sqlmock.AnyArg(), 2, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+		mock.ExpectExec("DELETE FROM ujs_runs").
+			WithArgs(3, keepRuns).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		rec := httptest.NewRecorder()
+		BenchmarkHandler(rec, benchmarkRequest(`{"source":"let x = 1 + 2;","iterations":2}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp BenchmarkResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.RunID != 12 || resp.Program == nil || resp.Program.Iterations != 2 || len(resp.Kernels) == 0 {
+			t.Errorf("response = %+v", resp)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Compile errors are not stored", func(t *testing.T) {
+		mock := setupMockDB(t)
+		expectUser(mock, 3)
+
+		rec := httptest.NewRecorder()
+		BenchmarkHandler(rec, benchmarkRequest(`{"source":"let x = ;"}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp BenchmarkResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.RunID != 0 || resp.Compile.OK || resp.Program != nil {
+			t.Errorf("response = %+v", resp)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Too many iterations", func(t *testing.T) {
+		mock := setupMockDB(t)
+		expectUser(mock, 3)
+
+		rec := httptest.NewRecorder()
+		BenchmarkHandler(rec, benchmarkRequest(`{"source":"let x = 1;","iterations":1000}`))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestRunsHandler(t *testing.T) {
+	t.Run("Lists newest first", func(t *testing.T) {
+		mock := setupMockDB(t)
+		expectUser(mock, 3)
+		mock.ExpectQuery("SELECT id, runtime, iterations, median_ns, mean_ns, steps, result, created_at").
+			WithArgs(3, 0, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "runtime", "iterations", "median_ns", "mean_ns", "steps", "result", "created_at"}).
+				AddRow(12, "purego", 5, 1500, 1600, 40, []byte(`{"program":{},"kernels":[]}`), time.Now()))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/ujs/runs?limit=1", nil)
+		req.AddCookie(securecookie.Cookie("user_id", "3"))
+		rec := httptest.NewRecorder()
+		RunsHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp RunsResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if len(resp.Runs) != 1 || resp.Runs[0].ID != 12 || resp.Runs[0].MedianNs != 1500 {
+			t.Errorf("runs = %+v", resp.Runs)
+		}
+	})
+
+	t.Run("Invalid limit", func(t *testing.T) {
+		mock := setupMockDB(t)
+		expectUser(mock, 3)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/ujs/runs?limit=0", nil)
+		req.AddCookie(securecookie.Cookie("user_id", "3"))
+		rec := httptest.NewRecorder()
+		RunsHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+// Package unleashedjs serves the UnleashedJS demo endpoints.
+package unleashedjs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/ujs"
+	"allanswebterminal/ujs/worker"
+)
+
+// Engine compiles, runs and benchmarks UnleashedJS source. *worker.Client runs
+// each request in a child process; the default runs in-process.
+type Engine interface {
+	Compile(ctx context.Context, source string) (*ujs.Result, error)
+	Benchmark(ctx context.Context, source string, iterations int) (*ujs.BenchReport, error)
+	Run(ctx context.Context, source string, stdout io.Writer) (*ujs.ExecResult, error)
+}
+
+type inProcess struct{}
+
+func (inProcess) Compile(ctx context.Context, source string) (*ujs.Result, error) {
+	return ujs.Compile(source), nil
+}
+
+func (inProcess) Benchmark(ctx context.Context, source string, iterations int) (*ujs.BenchReport, error) {
+	return ujs.Benchmark(ctx, source, iterations), nil
+}
+
+func (inProcess) Run(ctx context.Context, source string, stdout io.Writer) (*ujs.ExecResult, error) {
+	return ujs.Exec(ctx, source, ujs.RunOptions{Stdout: stdout}), nil
+}
+
+var (
+	engineMu sync.RWMutex
+	engine   Engine = inProcess{}
+)
+
+// SetEngine replaces the engine used by the handlers.
+func SetEngine(e Engine) {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	engine = e
+}
+
+func currentEngine() Engine {
+	engineMu.RLock()
+	defer engineMu.RUnlock()
+	return engine
+}
+
+// Execute compiles and runs source on the current engine without
+// streaming its output. Features outside the playground, such as
+// code-challenge cards, run code through it.
+func Execute(ctx context.Context, source string) (*ujs.ExecResult, error) {
+	return currentEngine().Run(ctx, source, nil)
+}
+
+type CompileRequest struct {
+	Source string `json:"source"`
+}
+
+type InfoResponse struct {
+	Builtins        []string `json:"builtins"`
+	StackAllocTypes []string `json:"stackalloc_types"`
+	MaxSourceSize   int      `json:"max_source_size"`
+	MaxStackAlloc   int      `json:"max_stackalloc"`
+	Runtime         string   `json:"runtime"`
+	NativeMemory    bool     `json:"native_memory"`
+	Isolated        bool     `json:"isolated"`
+}
+
+// CompileHandler parses and checks the submitted source and returns the
+// diagnostics. A program with errors is still a 200: the diagnostics are
+// the result.
+func CompileHandler(w http.ResponseWriter, r *http.Request) {
+	var req CompileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, apierror.DecodeError(err))
+		return
+	}
+	if strings.TrimSpace(req.Source) == "" {
+		apierror.Write(w, apierror.Validation("source is required"))
+		return
+	}
+
+	cache := currentResultCache()
+	key := CacheKey(cacheOpCompile, req.Source)
+	var res *ujs.Result
+	if cache.get(key, &res) {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		var err error
+		res, err = currentEngine().Compile(r.Context(), req.Source)
+		if err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		cache.put(key, res)
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// InfoHandler describes the language limits, builtins and the runtime
+// backend compiled into this binary.
+func InfoHandler(w http.ResponseWriter, r *http.Request) {
+	info := InfoResponse{
+		MaxSourceSize: ujs.MaxSourceSize,
+		MaxStackAlloc: ujs.MaxStackAlloc,
+		Runtime:       ujs.Backend,
+		NativeMemory:  ujs.NativeMemory,
+	}
+	for name := range ujs.Builtins {
+		info.Builtins = append(info.Builtins, name)
+	}
+	for name := range ujs.StackAllocTypes {
+		info.StackAllocTypes = append(info.StackAllocTypes, name)
+	}
+	sort.Strings(info.Builtins)
+	sort.Strings(info.StackAllocTypes)
+	_, info.Isolated = currentEngine().(*worker.Client)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func writeEngineError(w http.ResponseWriter, err error) {
+	apierror.Write(w, engineError(err))
+}
+
+// engineError maps an engine failure to the error shown to the client.
+func engineError(err error) *apierror.Error {
+	switch {
+	case errors.Is(err, worker.ErrBusy):
+		return apierror.Unavailable("The compiler is busy, try again shortly")
+	case errors.Is(err, worker.ErrTimeout):
+		return apierror.Unavailable("Compilation timed out")
+	default:
+		log.Printf("UnleashedJS worker failed: %v", err)
+		return apierror.Internal("Compilation failed")
+	}
+}
@@ -0,0 +1,105 @@
+package unleashedjs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"allanswebterminal/ujs"
+	"allanswebterminal/ujs/worker"
+)
+
+func TestCompileHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantOK     bool
+		wantDiags  int
+	}{
+		{"Clean program", http.MethodPost, `{"source":"nogc function f(x) { return x; }"}`, http.StatusOK, true, 0},
+		{"Program with errors", http.MethodPost, `{"source":"let a = ;\nprint(b);"}`, http.StatusOK, false, 2},
+		{"Empty source", http.MethodPost, `{"source":"  "}`, http.StatusBadRequest, false, 0},
+		{"Invalid JSON", http.MethodPost, `{`, http.StatusBadRequest, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/ujs/compile", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			CompileHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var res ujs.Result
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if res.OK != tt.wantOK || len(res.Diagnostics) != tt.wantDiags {
+				t.Errorf("result = %+v", res)
+			}
+			for _, d := range res.Diagnostics {
+				if d.Line == 0 || d.Col == 0 {
+					t.Errorf("diagnostic without position: %+v", d)
+				}
+			}
+		})
+	}
+}
+
+type failingEngine struct{ err error }
+
+func (f failingEngine) Compile(context.Context, string) (*ujs.Result, error) {
+	return nil, f.err
+}
+
+func (f failingEngine) Benchmark(context.Context, string, int) (*ujs.BenchReport, error) {
+	return nil, f.err
+}
+
+func (f failingEngine) Run(context.Context, string, io.Writer) (*ujs.ExecResult, error) {
+	return nil, f.err
+}
+
+func TestCompileHandlerWorkerErrors(t *testing.T) {
+	defer SetEngine(inProcess{})
+
+	tests := []struct {
+		err        error
+		wantStatus int
+	}{
+		{worker.ErrTimeout, http.StatusServiceUnavailable},
+		{worker.ErrBusy, http.StatusServiceUnavailable},
+		{worker.ErrCrashed, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			SetEngine(failingEngine{tt.err})
+			req := httptest.NewRequest(http.MethodPost, "/api/ujs/compile", strings.NewReader(`{"source":"x;"}`))
+			rec := httptest.NewRecorder()
+			CompileHandler(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestInfoHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	InfoHandler(rec, httptest.NewRequest(http.MethodGet, "/api/ujs/info", nil))
+
+	var info InfoResponse
+	json.NewDecoder(rec.Body).Decode(&info)
+	if rec.Code != http.StatusOK || len(info.Builtins) == 0 || info.Isolated {
+		t.Errorf("status = %d, info = %+v", rec.Code, info)
+	}
+}
@@ -0,0 +1,186 @@
+// Package health serves liveness, readiness and version endpoints for
+// container orchestrators. Subsystems plug in readiness checks with
+// Register.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Build metadata, set at link time:
+//
+//	go build -ldflags "-X allanswebterminal/health.Version=v1.2.3 \
+//	  -X allanswebterminal/health.Commit=$(git rev-parse HEAD) \
+//	  -X allanswebterminal/health.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When unset, Commit and BuildTime fall back to the VCS stamp Go embeds.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+var errPanic = errors.New("check panicked")
+
+// CheckTimeout bounds each readiness check.
+var CheckTimeout = 2 * time.Second
+
+// Check returns nil when the dependency is usable.
+type Check func(ctx context.Context) error
+
+var (
+	checks   = make(map[string]Check)
+	checksMu sync.RWMutex
+)
+
+// Register adds or replaces the readiness check called name.
+func Register(name string, check Check) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	checks[name] = check
+}
+
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type ReadinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// LivenessHandler answers /healthz. It only shows the process is serving
+// requests, so orchestrators do not restart it over a dependency outage.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// ReadinessHandler answers /readyz by running every registered check
+// concurrently. It returns 503 if any check fails.
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	response := RunChecks(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// RunChecks executes the registered checks and aggregates their results.
+func RunChecks(ctx context.Context) ReadinessResponse {
+	checksMu.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pending := make([]Check, len(names))
+	for i, name := range names {
+		pending[i] = checks[name]
+	}
+	checksMu.RUnlock()
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i, check := range pending {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	response := ReadinessResponse{Status: "ok", Checks: make(map[string]CheckResult, len(names))}
+	for i, name := range names {
+		response.Checks[name] = results[i]
+		if results[i].Status != "ok" {
+			response.Status = "unavailable"
+		}
+	}
+	return response
+}
+
+func runCheck(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+	defer cancel()
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- errPanic
+			}
+		}()
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{Status: "ok", Duration: time.Since(started).Round(time.Microsecond).String()}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Info returns the build metadata served by /version.
+func Info() VersionInfo {
+	info := VersionInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Info())
+}
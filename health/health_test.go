@@ -0,0 +1,115 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func resetChecks(t *testing.T) {
+	t.Helper()
+	checksMu.Lock()
+	saved := checks
+	checks = make(map[string]Check)
+	checksMu.Unlock()
+	t.Cleanup(func() {
+		checksMu.Lock()
+		checks = saved
+		checksMu.Unlock()
+	})
+}
+
+func TestLivenessHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	LivenessHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d", rec.Code)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     map[string]Check
+		wantStatus int
+		wantFailed string
+	}{
+		{
+			name:       "All passing",
+			checks:     map[string]Check{"db": func(context.Context) error { return nil }},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "One failing",
+			checks: map[string]Check{
+				"db":        func(context.Context) error { return nil },
+				"templates": func(context.Context) error { return errors.New("not loaded") },
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: "templates",
+		},
+		{
+			name: "Timeout",
+			checks: map[string]Check{"slow": func(ctx context.Context) error {
+				<-ctx.Done()
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			}},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: "slow",
+		},
+		{
+			name:       "Panic",
+			checks:     map[string]Check{"bad": func(context.Context) error { panic("boom") }},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: "bad",
+		},
+	}
+
+	saved := CheckTimeout
+	CheckTimeout = 50 * time.Millisecond
+	defer func() { CheckTimeout = saved }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetChecks(t)
+			for name, check := range tt.checks {
+				Register(name, check)
+			}
+
+			rec := httptest.NewRecorder()
+			ReadinessHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			var body ReadinessResponse
+			json.NewDecoder(rec.Body).Decode(&body)
+			if len(body.Checks) != len(tt.checks) {
+				t.Errorf("expected %d check results, got %+v", len(tt.checks), body.Checks)
+			}
+			if tt.wantFailed != "" && body.Checks[tt.wantFailed].Status != "fail" {
+				t.Errorf("expected %s to fail, got %+v", tt.wantFailed, body.Checks)
+			}
+		})
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	saved := Version
+	Version = "v1.2.3"
+	defer func() { Version = saved }()
+
+	rec := httptest.NewRecorder()
+	VersionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	var info VersionInfo
+	json.NewDecoder(rec.Body).Decode(&info)
+	if info.Version != "v1.2.3" || info.GoVersion == "" {
+		t.Errorf("unexpected version info %+v", info)
+	}
+}
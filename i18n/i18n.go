@@ -0,0 +1,176 @@
+// Package i18n translates user-facing strings. Catalogs are keyed by the
+// English source text (gettext style), so untranslated strings fall back
+// to English without any extra bookkeeping at call sites.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default is the source language of every message key.
+const Default = "en"
+
+// CookieName holds the user's explicit language choice.
+const CookieName = "lang"
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+var catalogs = mustLoadCatalogs()
+
+func mustLoadCatalogs() map[string]map[string]string {
+	files, err := localeFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	loaded := make(map[string]map[string]string, len(files))
+	for _, file := range files {
+		data, err := localeFS.ReadFile("locales/" + file.Name())
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog %s: %v", file.Name(), err))
+		}
+		loaded[strings.TrimSuffix(file.Name(), path.Ext(file.Name()))] = catalog
+	}
+	return loaded
+}
+
+// Supported returns the available locales, sorted.
+func Supported() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Normalize maps a language tag such as "pt-BR" or "ES" to a supported
+// locale, or returns "" if there is none.
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if _, ok := catalogs[tag]; ok {
+		return tag
+	}
+	if base, _, found := strings.Cut(tag, "-"); found {
+		if _, ok := catalogs[base]; ok {
+			return base
+		}
+	}
+	return ""
+}
+
+// T translates key into locale, formatting args with fmt.Sprintf when
+// given. Missing translations fall back to the key itself.
+func T(locale, key string, args ...interface{}) string {
+	message := key
+	if translated, ok := catalogs[locale][key]; ok && translated != "" {
+		message = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Catalog returns the translations for locale, for handing to client-side
+// code. The result must not be modified.
+func Catalog(locale string) map[string]string {
+	if catalog, ok := catalogs[locale]; ok {
+		return catalog
+	}
+	return map[string]string{}
+}
+
+// MatchAcceptLanguage picks the best supported locale from an
+// Accept-Language header, honouring q-values.
+func MatchAcceptLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if locale := Normalize(tag); locale != "" && q > bestQ {
+			best, bestQ = locale, q
+		}
+	}
+	return best
+}
+
+// Detect resolves the locale for r: the lang cookie set from the user's
+// preference wins over Accept-Language, then Default.
+func Detect(r *http.Request) string {
+	if cookie, err := r.Cookie(CookieName); err == nil {
+		if locale := Normalize(cookie.Value); locale != "" {
+			return locale
+		}
+	}
+	if locale := MatchAcceptLanguage(r.Header.Get("Accept-Language")); locale != "" {
+		return locale
+	}
+	return Default
+}
+
+type localeKey struct{}
+
+// Middleware detects the request locale, stores it in the context and
+// announces it with Content-Language, which apierror reads to translate
+// error messages.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := Detect(r)
+		w.Header().Set("Content-Language", locale)
+		w.Header().Add("Vary", "Accept-Language")
+		ctx := context.WithValue(r.Context(), localeKey{}, locale)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Locale returns the locale chosen by Middleware, detecting it directly
+// when the request did not pass through it.
+func Locale(r *http.Request) string {
+	if locale, ok := r.Context().Value(localeKey{}).(string); ok {
+		return locale
+	}
+	return Detect(r)
+}
+
+// ResponseLocale returns the locale announced on a response, for code that
+// only has the ResponseWriter.
+func ResponseLocale(w http.ResponseWriter) string {
+	if locale := Normalize(w.Header().Get("Content-Language")); locale != "" {
+		return locale
+	}
+	return Default
+}
+
+// SetCookie remembers locale as the user's explicit choice for a year.
+func SetCookie(w http.ResponseWriter, locale string, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    locale,
+		Path:     "/",
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().AddDate(1, 0, 0),
+	})
+}
@@ -0,0 +1,103 @@
+package i18n
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCatalogsHaveSameKeys(t *testing.T) {
+	reference := catalogs["pt"]
+	for locale, catalog := range catalogs {
+		if locale == Default {
+			continue
+		}
+		for key := range reference {
+			if _, ok := catalog[key]; !ok {
+				t.Errorf("%s catalog missing %q", locale, key)
+			}
+		}
+		for key := range catalog {
+			if _, ok := reference[key]; !ok {
+				t.Errorf("%s catalog has extra key %q", locale, key)
+			}
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	tests := []struct {
+		locale, key string
+		args        []interface{}
+		want        string
+	}{
+		{"pt", "Correct!", nil, "Correto!"},
+		{"es", "Correct!", nil, "¡Correcto!"},
+		{"en", "Correct!", nil, "Correct!"},
+		{"pt", "Untranslated string", nil, "Untranslated string"},
+		{"fr", "Correct!", nil, "Correct!"},
+		{"pt", "The correct answer was: %s", []interface{}{"lain"}, "A resposta correta era: lain"},
+	}
+
+	for _, tt := range tests {
+		if got := T(tt.locale, tt.key, tt.args...); got != tt.want {
+			t.Errorf("T(%q, %q) = %q, want %q", tt.locale, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMatchAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"pt-BR,pt;q=0.9,en;q=0.8", "pt"},
+		{"fr-FR, es;q=0.5, en;q=0.7", "en"},
+		{"de, fr", ""},
+		{"ES", "es"},
+		{"en;q=bogus, es;q=0.1", "es"},
+	}
+
+	for _, tt := range tests {
+		if got := MatchAcceptLanguage(tt.header); got != tt.want {
+			t.Errorf("MatchAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestDetectPrefersCookie(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "es")
+	if got := Detect(req); got != "es" {
+		t.Errorf("Detect() = %q, want es", got)
+	}
+
+	req.AddCookie(&http.Cookie{Name: CookieName, Value: "pt-BR"})
+	if got := Detect(req); got != "pt" {
+		t.Errorf("Detect() with cookie = %q, want pt", got)
+	}
+
+	if got := Detect(httptest.NewRequest(http.MethodGet, "/", nil)); got != Default {
+		t.Errorf("Detect() without hints = %q, want %q", got, Default)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = Locale(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "pt-BR")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if seen != "pt" {
+		t.Errorf("Locale() = %q, want pt", seen)
+	}
+	if got := ResponseLocale(rec); got != "pt" {
+		t.Errorf("ResponseLocale() = %q, want pt", got)
+	}
+}
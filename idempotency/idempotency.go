@@ -0,0 +1,236 @@
+// Package idempotency lets clients retry mutating requests safely. A request
+// sent with an Idempotency-Key header is run once; retries with the same key
+// get the stored response instead of repeating the change.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/db"
+	"allanswebterminal/middleware"
+	"allanswebterminal/securecookie"
+)
+
+// Header is the request header carrying the key; ReplayedHeader marks
+// responses served from a stored response.
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+const (
+	maxKeyLength = 255
+	// maxStoredBody bounds stored responses. Larger responses are not kept,
+	// so their keys can be retried.
+	maxStoredBody = 256 << 10
+)
+
+// TTL is how long a key is remembered. A key reused after that runs the
+// request again.
+var TTL = 24 * time.Hour
+
+// Handler runs next at most once per Idempotency-Key. Keys are scoped to the
+// session's account, or to the client IP without one, so clients cannot see
+// each other's responses. A retry gets the first response with
+// Idempotent-Replayed: true; a retry while the first request is running gets
+// 409, and reusing a key for a different request gets 422. Server errors are
+// not stored, so the client can retry them. Requests without the header, or
+// arriving while the database is unavailable, run as usual.
+func Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || !db.Available() {
+			next(w, r)
+			return
+		}
+		if !validKey(key) {
+			apierror.Write(w, apierror.BadRequest(Header+" must be 1 to 255 printable ASCII characters"))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			apierror.Write(w, apierror.DecodeError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := requestScope(r)
+		fingerprint := fingerprintOf(r, body)
+		id, err := claim(r.Context(), scope, key, fingerprint)
+		if errors.Is(err, errKeyTaken) {
+			replay(w, r, scope, key, fingerprint)
+			return
+		}
+		if err != nil {
+			log.Printf("Idempotency: failed to claim key: %v", err)
+			next(w, r)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next(rec, r)
+		// The client may have gone away; the outcome is still recorded.
+		ctx := context.WithoutCancel(r.Context())
+		if err := rec.store(ctx, id); err != nil {
+			log.Printf("Idempotency: failed to store response for key %d: %v", id, err)
+		}
+	}
+}
+
+var errKeyTaken = errors.New("idempotency key in use")
+
+// claim records a new key and returns its id. A key whose TTL has passed is
+// taken over; a live one gives errKeyTaken.
+func claim(ctx context.Context, scope, key, fingerprint string) (int, error) {
+	var id int
+	err := db.DB.QueryRowContext(ctx,
+		`INSERT INTO idempotency_keys (scope, key, fingerprint) VALUES ($1, $2, $3)
+		 ON CONFLICT (scope, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint,
+		 status = NULL, content_type = NULL, body = NULL, created_at = CURRENT_TIMESTAMP
+		 WHERE idempotency_keys.created_at < $4
+		 RETURNING id`,
+		scope, key, fingerprint, time.Now().Add(-TTL),
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errKeyTaken
+	}
+	return id, err
+}
+
+// replay answers a retry with the stored response.
+func replay(w http.ResponseWriter, r *http.Request, scope, key, fingerprint string) {
+	var stored string
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+	err := db.DB.QueryRowContext(r.Context(),
+		"SELECT fingerprint, status, content_type, body FROM idempotency_keys WHERE scope = $1 AND key = $2",
+		scope, key,
+	).Scan(&stored, &status, &contentType, &body)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Expired and deleted between the claim and now; the client retries.
+		apierror.Write(w, apierror.Conflict("The request with this "+Header+" is still in progress"))
+		return
+	}
+	if err != nil {
+		log.Printf("Idempotency: failed to load key: %v", err)
+		apierror.Write(w, apierror.Internal("Failed to load the original response"))
+		return
+	}
+	if stored != fingerprint {
+		apierror.Write(w, apierror.New(apierror.CodeIdempotencyMismatch,
+			"This "+Header+" was already used for a different request"))
+		return
+	}
+	if !status.Valid {
+		apierror.Write(w, apierror.Conflict("The request with this "+Header+" is still in progress"))
+		return
+	}
+
+	if contentType.String != "" {
+		w.Header().Set("Content-Type", contentType.String)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(int(status.Int64))
+	w.Write(body)
+}
+
+// DeleteExpired removes keys older than TTL. It runs as a scheduler job.
+func DeleteExpired(ctx context.Context) error {
+	res, err := db.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < $1", time.Now().Add(-TTL))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Deleted %d expired idempotency keys", n)
+	}
+	return nil
+}
+
+func validKey(key string) bool {
+	if len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestScope is the namespace of the request's keys: the session's
+// account, or the client IP for anonymous requests.
+func requestScope(r *http.Request) string {
+	if id, ok := securecookie.Value(r, "user_id"); ok && id != "" {
+		return "account:" + id
+	}
+	return "ip:" + middleware.ClientIP(r)
+}
+
+// fingerprintOf identifies the request a key was first used for.
+func fingerprintOf(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder passes the response through while keeping a copy to store.
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.body.Len()+len(p) > maxStoredBody {
+		rec.tooLarge = true
+	} else if !rec.tooLarge {
+		rec.body.Write(p)
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// store keeps the response under key id, or drops the key when the response
+// should not be replayed.
+func (rec *recorder) store(ctx context.Context, id int) error {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status >= 500 || rec.tooLarge {
+		_, err := db.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE id = $1", id)
+		return err
+	}
+	_, err := db.DB.ExecContext(ctx,
+		"UPDATE idempotency_keys SET status = $1, content_type = $2, body = $3 WHERE id = $4",
+		status, rec.Header().Get("Content-Type"), rec.body.Bytes(), id)
+	return err
+}
@@ -0,0 +1,172 @@
+package idempotency
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"allanswebterminal/db"
+	"allanswebterminal/securecookie"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	originalDB := db.DB
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = originalDB
+		mockDB.Close()
+	})
+	return mock
+}
+
+func newRequest(key, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/iam/users", strings.NewReader(body))
+	req.AddCookie(securecookie.Cookie("user_id", "7"))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	return req
+}
+
+// createHandler counts its calls and answers 201, or status when set.
+func createHandler(calls *int, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if status == 0 {
+			status = http.StatusCreated
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"user_name":"alice"}`))
+	}
+}
+
+func TestHandlerFirstRequestIsStored(t *testing.T) {
+	mock := setupMockDB(t)
+	body := `{"user_name":"alice"}`
+	fingerprint := fingerprintOf(newRequest("k1", body), []byte(body))
+	mock.ExpectQuery("INSERT INTO idempotency_keys").WithArgs("account:7", "k1", fingerprint, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec("UPDATE idempotency_keys SET status").
+		WithArgs(http.StatusCreated, "application/json", []byte(`{"user_name":"alice"}`), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var calls int
+	rr := httptest.NewRecorder()
+	Handler(createHandler(&calls, 0))(rr, newRequest("k1", body))
+
+	if calls != 1 || rr.Code != http.StatusCreated || rr.Header().Get(ReplayedHeader) != "" {
+		t.Errorf("calls = %d, status = %d, replayed = %q", calls, rr.Code, rr.Header().Get(ReplayedHeader))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlerReplays(t *testing.T) {
+	body := `{"user_name":"alice"}`
+	fingerprint := fingerprintOf(newRequest("k1", body), []byte(body))
+	columns := []string{"fingerprint", "status", "content_type", "body"}
+
+	tests := []struct {
+		name       string
+		row        []driver.Value
+		wantStatus int
+		wantBody   string
+	}{
+		{"Completed", []driver.Value{fingerprint, 201, "application/json", []byte(`{"user_name":"alice"}`)},
+			http.StatusCreated, `{"user_name":"alice"}`},
+		{"In progress", []driver.Value{fingerprint, nil, nil, nil}, http.StatusConflict, `"code":"conflict"`},
+		{"Different request", []driver.Value{strings.Repeat("0", 64), 201, "application/json", []byte(`{}`)},
+			http.StatusUnprocessableEntity, `"code":"idempotency_key_mismatch"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectQuery("SELECT fingerprint, status").WithArgs("account:7", "k1").
+				WillReturnRows(sqlmock.NewRows(columns).AddRow(tt.row...))
+
+			var calls int
+			rr := httptest.NewRecorder()
+			Handler(createHandler(&calls, 0))(rr, newRequest("k1", body))
+
+			if calls != 0 {
+				t.Errorf("handler ran %d times on a retry", calls)
+			}
+			if rr.Code != tt.wantStatus || !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("status = %d, body %s", rr.Code, rr.Body.String())
+			}
+			if tt.wantStatus == http.StatusCreated && rr.Header().Get(ReplayedHeader) != "true" {
+				t.Error("replayed response is not marked")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestHandlerForgetsServerErrors(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec("DELETE FROM idempotency_keys WHERE id").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var calls int
+	rr := httptest.NewRecorder()
+	Handler(createHandler(&calls, http.StatusInternalServerError))(rr, newRequest("k1", "{}"))
+
+	if calls != 1 || rr.Code != http.StatusInternalServerError {
+		t.Errorf("calls = %d, status = %d", calls, rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlerWithoutKey(t *testing.T) {
+	mock := setupMockDB(t)
+
+	var calls int
+	rr := httptest.NewRecorder()
+	Handler(createHandler(&calls, 0))(rr, newRequest("", "{}"))
+
+	if calls != 1 || rr.Code != http.StatusCreated {
+		t.Errorf("calls = %d, status = %d", calls, rr.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlerRejectsBadKeys(t *testing.T) {
+	setupMockDB(t)
+	for _, key := range []string{"has space", strings.Repeat("k", maxKeyLength+1), "ключ"} {
+		var calls int
+		rr := httptest.NewRecorder()
+		Handler(createHandler(&calls, 0))(rr, newRequest(key, "{}"))
+		if calls != 0 || rr.Code != http.StatusBadRequest {
+			t.Errorf("key %q: calls = %d, status = %d", key, calls, rr.Code)
+		}
+	}
+}
+
+func TestRequestScope(t *testing.T) {
+	anonymous := httptest.NewRequest(http.MethodPost, "/api/messages", nil)
+	anonymous.RemoteAddr = "203.0.113.9:4000"
+	if got := requestScope(anonymous); got != "ip:203.0.113.9" {
+		t.Errorf("anonymous scope = %q", got)
+	}
+	if got := requestScope(newRequest("k", "")); got != "account:7" {
+		t.Errorf("account scope = %q", got)
+	}
+}
@@ -0,0 +1,171 @@
+// Package llm sends prompts to a large language model through a pluggable
+// Provider. Until one is configured Complete returns ErrNotConfigured, and
+// features built on it answer 503.
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is one turn of a conversation. Role is "user" or "assistant".
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request is a prompt: instructions in System and the conversation so far.
+type Request struct {
+	System    string
+	Messages  []Message
+	MaxTokens int
+}
+
+// Provider completes prompts.
+type Provider interface {
+	Complete(ctx context.Context, req Request) (string, error)
+}
+
+// ErrNotConfigured is returned by Complete when no provider is set.
+var ErrNotConfigured = errors.New("no LLM provider is configured")
+
+var (
+	providerMu sync.RWMutex
+	provider   Provider
+)
+
+// SetProvider replaces the provider used by Complete. nil disables it.
+func SetProvider(p Provider) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	provider = p
+}
+
+// Configured reports whether a provider is set.
+func Configured() bool {
+	providerMu.RLock()
+	defer providerMu.RUnlock()
+	return provider != nil
+}
+
+// Complete sends req to the configured provider and returns its reply.
+func Complete(ctx context.Context, req Request) (string, error) {
+	providerMu.RLock()
+	p := provider
+	providerMu.RUnlock()
+	if p == nil {
+		return "", ErrNotConfigured
+	}
+	return p.Complete(ctx, req)
+}
+
+// OpenAIProvider calls an OpenAI-compatible chat completions API. Most
+// hosted and self-hosted model servers offer one.
+type OpenAIProvider struct {
+	BaseURL string // such as https://api.openai.com/v1
+	APIKey  string
+	Model   string
+	Client  *http.Client
+}
+
+// NewOpenAIProvider returns a provider for baseURL with a 60 second timeout.
+func NewOpenAIProvider(baseURL, apiKey, model string) OpenAIProvider {
+	return OpenAIProvider{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		APIKey:  apiKey,
+		Model:   model,
+		Client:  &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (p OpenAIProvider) Complete(ctx context.Context, req Request) (string, error) {
+	messages := make([]Message, 0, len(req.Messages)+1)
+	if req.System != "" {
+		messages = append(messages, Message{Role: "system", Content: req.System})
+	}
+	messages = append(messages, req.Messages...)
+	body, err := json.Marshal(map[string]interface{}{
+		"model":      p.Model,
+		"messages":   messages,
+		"max_tokens": req.MaxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("LLM provider answered %d: %.200s", resp.StatusCode, data)
+	}
+	var parsed struct {
+		Choices []struct {
+			Message Message `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return "", fmt.Errorf("LLM provider answered with invalid JSON: %w", err)
+	}
+	if len(parsed.Choices) == 0 {
+		return "", errors.New("LLM provider answered with no choices")
+	}
+	return parsed.Choices[0].Message.Content, nil
+}
+
+// Mock is a deterministic provider for tests. It answers every request
+// with Reply, or fails with Err, and records the requests it got.
+type Mock struct {
+	Reply string
+	Err   error
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+func (m *Mock) Complete(ctx context.Context, req Request) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, req)
+	return m.Reply, m.Err
+}
+
+// Requests returns the requests the mock has received.
+func (m *Mock) Requests() []Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Request(nil), m.requests...)
+}
+
+// ExtractJSON returns the outermost JSON object in a reply, dropping any
+// prose or Markdown code fence a model wrapped it in.
+func ExtractJSON(reply string) string {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return ""
+	}
+	return reply[start : end+1]
+}
@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompleteWithoutProvider(t *testing.T) {
+	SetProvider(nil)
+	if _, err := Complete(context.Background(), Request{}); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Complete() error = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestMock(t *testing.T) {
+	mock := &Mock{Reply: "hello"}
+	SetProvider(mock)
+	defer SetProvider(nil)
+
+	reply, err := Complete(context.Background(), Request{System: "be brief"})
+	if err != nil || reply != "hello" || len(mock.Requests()) != 1 || mock.Requests()[0].System != "be brief" {
+		t.Errorf("Complete() = %q, %v; requests %+v", reply, err, mock.Requests())
+	}
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	var got struct {
+		Model    string    `json:"model"`
+		Messages []Message `json:"messages"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "wrong request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "Paris"}}]}`))
+	}))
+	defer srv.Close()
+
+	p := NewOpenAIProvider(srv.URL+"/v1/", "secret", "small")
+	reply, err := p.Complete(context.Background(), Request{
+		System:   "answer in one word",
+		Messages: []Message{{Role: "user", Content: "Capital of France?"}},
+	})
+	if err != nil || reply != "Paris" {
+		t.Fatalf("Complete() = %q, %v", reply, err)
+	}
+	if got.Model != "small" || len(got.Messages) != 2 || got.Messages[0].Role != "system" {
+		t.Errorf("request = %+v", got)
+	}
+
+	p.APIKey = "wrong"
+	if _, err := p.Complete(context.Background(), Request{}); err == nil {
+		t.Error("error status was not reported")
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := map[string]string{
+		"```json\n{\"cards\": []}\n```":      `{"cards": []}`,
+		`Here you go: {"a": {"b": 1}} enjoy`: `{"a": {"b": 1}}`,
+		"no json here":                       "",
+	}
+	for reply, want := range tests {
+		if got := ExtractJSON(reply); got != want {
+			t.Errorf("ExtractJSON(%q) = %q, want %q", reply, got, want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package mail sends plain-text email. Without SMTP configured messages are
+// only logged, so features that email users work in development unchanged.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a single plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	// Headers are extra headers such as List-Unsubscribe.
+	Headers map[string]string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ValidAddress reports whether addr is a bare email address.
+func ValidAddress(addr string) bool {
+	parsed, err := netmail.ParseAddress(addr)
+	return err == nil && parsed.Address == addr && len(addr) <= 254
+}
+
+// SMTPSender sends through an SMTP relay with STARTTLS when offered.
+type SMTPSender struct {
+	Addr string // host:port
+	From string
+	Auth smtp.Auth
+}
+
+// NewSMTPSender returns a sender for addr, authenticating with PLAIN auth
+// when username is set.
+func NewSMTPSender(addr, from, username, password string) SMTPSender {
+	s := SMTPSender{Addr: addr, From: from}
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		s.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s
+}
+
+func (s SMTPSender) Send(ctx context.Context, msg Message) error {
+	data, err := msg.encode(s.From, time.Now())
+	if err != nil {
+		return err
+	}
+	// net/smtp has no context support; give up waiting once ctx is done and
+	// let the dial time out on its own.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, s.Auth, s.From, []string{msg.To}, data)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogSender writes messages to the log instead of sending them.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail: to=%s subject=%q (SMTP not configured, not sent)", msg.To, msg.Subject)
+	return nil
+}
+
+var (
+	senderMu sync.RWMutex
+	sender   Sender = LogSender{}
+)
+
+// SetSender replaces the sender used by Send.
+func SetSender(s Sender) {
+	senderMu.Lock()
+	defer senderMu.Unlock()
+	sender = s
+}
+
+// Send delivers msg with the configured sender.
+func Send(ctx context.Context, msg Message) error {
+	senderMu.RLock()
+	s := sender
+	senderMu.RUnlock()
+	return s.Send(ctx, msg)
+}
+
+// encode renders msg as an RFC 5322 message with CRLF line endings,
+// rejecting header values that could inject extra headers.
+func (m Message) encode(from string, date time.Time) ([]byte, error) {
+	headers := map[string]string{
+		"From":         from,
+		"To":           m.To,
+		"Subject":      m.Subject,
+		"Date":         date.Format(time.RFC1123Z),
+		"MIME-Version": "1.0",
+		"Content-Type": "text/plain; charset=utf-8",
+	}
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+
+	keys := make([]string, 0, len(headers))
+	for k, v := range headers {
+		if strings.ContainsAny(k+v, "\r\n") {
+			return nil, errors.New("mail: header contains a line break")
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, headers[k])
+	}
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	msg := Message{
+		To:      "ana@example.com",
+		Subject: "Time to study",
+		Body:    "line one\nline two",
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.com/u>"},
+	}
+	data, err := msg.encode("noreply@example.com", time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		"From: noreply@example.com\r\n",
+		"To: ana@example.com\r\n",
+		"List-Unsubscribe: <https://example.com/u>\r\n",
+		"\r\n\r\nline one\r\nline two",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestEncodeRejectsHeaderInjection(t *testing.T) {
+	msg := Message{To: "ana@example.com", Subject: "hi\r\nBcc: victim@example.com"}
+	if _, err := msg.encode("noreply@example.com", time.Now()); err == nil {
+		t.Error("expected an error for a subject containing CRLF")
+	}
+}
+
+func TestValidAddress(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"ana@example.com":         true,
+		"Ana <ana@example.com>":   false,
+		"not-an-address":          false,
+		"ana@example.com\r\nBcc:": false,
+	} {
+		if got := ValidAddress(addr); got != want {
+			t.Errorf("ValidAddress(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -1,64 +1,522 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"allanswebterminal/cache"
+	"allanswebterminal/etag"
+	"allanswebterminal/flash"
+	"allanswebterminal/health"
+	"allanswebterminal/i18n"
+	"allanswebterminal/idempotency"
+	"allanswebterminal/llm"
+	"allanswebterminal/mail"
+	"allanswebterminal/malware"
+	"allanswebterminal/ocr"
+	"allanswebterminal/passhash"
+	"allanswebterminal/ratelimit"
+	"allanswebterminal/sandbox"
+	"allanswebterminal/scheduler"
+	"allanswebterminal/securecookie"
+	"allanswebterminal/static"
+	"allanswebterminal/status"
+	"allanswebterminal/ujs/worker"
+	"allanswebterminal/vault"
+	"allanswebterminal/webauthn"
+	"allanswebterminal/ws"
+
+	"allanswebterminal/templates"
+
+	"allanswebterminal/config"
 	"allanswebterminal/db"
+	"allanswebterminal/handlers/admin"
+	"allanswebterminal/handlers/apikeys"
+	"allanswebterminal/handlers/avatars"
+	"allanswebterminal/handlers/cloudsim"
+	"allanswebterminal/handlers/cronjobs"
 	"allanswebterminal/handlers/files"
 	"allanswebterminal/handlers/flashcards"
+	"allanswebterminal/handlers/github"
 	"allanswebterminal/handlers/iam"
 	"allanswebterminal/handlers/login"
 	"allanswebterminal/handlers/messages"
+	"allanswebterminal/handlers/notifications"
+	"allanswebterminal/handlers/operations"
+	"allanswebterminal/handlers/pages"
+	"allanswebterminal/handlers/preferences"
+	"allanswebterminal/handlers/presence"
+	"allanswebterminal/handlers/projects"
+	"allanswebterminal/handlers/reminders"
+	"allanswebterminal/handlers/sdk"
+	"allanswebterminal/handlers/secrets"
+	"allanswebterminal/handlers/terminal"
+	"allanswebterminal/handlers/unleashedjs"
+	"allanswebterminal/metrics"
+	"allanswebterminal/middleware"
 
 	"github.com/joho/godotenv"
 )
 
+// bodyLimits caps request bodies per route so JSON decoders never read
+// unbounded input.
+var bodyLimits = middleware.BodyLimits{
+	Default: 64 << 10,
+	Routes: map[string]int64{
+		"/api/files/":            1 << 20,
+		"/api/flashcards/import": 2 << 20,
+		// Occlusion images are up to 2 MB, base64-encoded in JSON.
+		"/api/flashcards/occlusion": 3 << 20,
+		// OCR photos are up to 5 MB, base64-encoded in JSON.
+		"/api/flashcards/ocr": 7 << 20,
+		// Avatars are up to 5 MB, base64-encoded in JSON.
+		"/api/avatar":         7 << 20,
+		"/api/login":          10 << 10,
+		"/api/register":       10 << 10,
+		"/api/check-username": 10 << 10,
+		"/api/passkeys/":      16 << 10,
+		"/api/messages":       10 << 10,
+		"/api/ujs/":           128 << 10,
+		// Recordings are event streams of up to 20000 events.
+		"/api/terminal/recordings": 4 << 20,
+	},
+}
+
+// apiRateLimits are the token-bucket budgets applied per client IP and per
+// account to everything under /api/.
+var apiRateLimits = ratelimit.Rules{
+	Prefix:  "/api/",
+	Default: ratelimit.Budget{Rate: 5, Burst: 60},
+	Routes: map[string]ratelimit.Budget{
+		"/api/login":          ratelimit.PerMinute(10),
+		"/api/register":       ratelimit.PerMinute(5),
+		"/api/check-username": ratelimit.PerMinute(30),
+		"/api/guest":          ratelimit.PerMinute(5),
+		"/api/passkeys/login": ratelimit.PerMinute(10),
+		"/api/messages":       ratelimit.PerMinute(3),
+		"/api/files/save":     {Rate: 1, Burst: 20},
+		"/api/ujs/execute":    ratelimit.PerMinute(30),
+		// These make paid requests to the LLM provider.
+		"/api/flashcards/generate": ratelimit.PerMinute(5),
+		"/api/flashcards/explain":  ratelimit.PerMinute(10),
+		"/api/flashcards/ocr":      ratelimit.PerMinute(10),
+		"/api/terminal/exec":       ratelimit.PerMinute(60),
+		// Installing rebuilds the whole virtualenv.
+		"/api/terminal/packages": ratelimit.PerMinute(10),
+		// Per API key as well as per IP; see apikeys.RateLimitKey.
+		"/api/public/": ratelimit.PerMinute(60),
+	},
+}
+
 type PageData struct {
 	Title   string
 	Message string
 }
 
 func homeHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("templates/home.html")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	data := PageData{
 		Title:   "Simple Go Web App",
 		Message: "Welcome to our simple webpage!",
 	}
 
-	err = tmpl.Execute(w, data)
-	if err != nil {
+	if err := templates.Render(w, r, "home", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
 	}
 }
 
+// router is the part of *http.ServeMux that registerRoutes uses; tests
+// wrap it to see every registered pattern.
+type router interface {
+	Handle(pattern string, handler http.Handler)
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// registerRoutes adds every page and API route to mux. Routes are
+// registered with their method ("GET /api/...") so handlers never check
+// r.Method themselves; the mux answers other methods with 405.
+func registerRoutes(mux router, assets http.Handler, jobs *scheduler.Scheduler, hub *ws.Hub, debugLog *middleware.DebugLog) {
+	mux.HandleFunc("GET /healthz", health.LivenessHandler)
+	mux.HandleFunc("GET /readyz", health.ReadinessHandler)
+	mux.HandleFunc("GET /version", health.VersionHandler)
+	mux.HandleFunc("GET /metrics", metrics.Handler)
+	mux.HandleFunc("GET /status", status.PageHandler)
+	mux.HandleFunc("GET /status.json", status.JSONHandler)
+	mux.HandleFunc("GET /status/badge.json", status.BadgeHandler)
+	for _, path := range admin.HoneypotPaths {
+		mux.HandleFunc(path, admin.HoneypotHandler)
+	}
+
+	mux.Handle("GET /static/", http.StripPrefix("/static", assets))
+	mux.HandleFunc("GET /{$}", homeHandler)
+	mux.HandleFunc("GET /projects", projectsHandler)
+
+	// Auth routes
+	mux.HandleFunc("GET /login", login.LoginPageHandler)
+	mux.HandleFunc("GET /register", login.RegisterPageHandler)
+	mux.HandleFunc("GET /logout", login.LogoutHandler)
+	mux.HandleFunc("POST /logout", login.LogoutHandler)
+	mux.HandleFunc("POST /api/login", login.LoginAPIHandler)
+	mux.HandleFunc("POST /api/register", login.RegisterAPIHandler)
+	mux.HandleFunc("POST /api/check-username", login.CheckUsernameAPIHandler)
+	mux.HandleFunc("POST /api/guest", login.CreateGuestHandler)
+	mux.HandleFunc("GET /api/guest", login.GuestStatusHandler)
+	mux.HandleFunc("POST /api/passkeys/register/options", login.PasskeyRegistrationOptionsHandler)
+	mux.HandleFunc("POST /api/passkeys/register", login.RegisterPasskeyHandler)
+	mux.HandleFunc("GET /api/passkeys", login.ListPasskeysHandler)
+	mux.HandleFunc("DELETE /api/passkeys/{id}", login.DeletePasskeyHandler)
+	mux.HandleFunc("POST /api/passkeys/login/options", login.PasskeyLoginOptionsHandler)
+	mux.HandleFunc("POST /api/passkeys/login", login.PasskeyLoginHandler)
+	mux.HandleFunc("GET /api/preferences/locale", preferences.GetLocaleHandler)
+	mux.HandleFunc("POST /api/preferences/locale", preferences.SetLocaleHandler)
+	mux.HandleFunc("GET /api/preferences/presence", preferences.GetPresenceHandler)
+	mux.HandleFunc("POST /api/preferences/presence", preferences.SetPresenceHandler)
+
+	// Flashcards routes
+	game := flashcards.NewGame(flashcards.DBStore())
+	mux.HandleFunc("GET /flashcards", game.FlashcardsPageHandler)
+	mux.HandleFunc("GET /api/flashcards/courses", etag.Handler(game.CoursesAPIHandler))
+	mux.HandleFunc("GET /api/flashcards/guest", game.GuestFlashcardsAPIHandler)
+	mux.HandleFunc("POST /api/flashcards/start", game.StartGameHandler)
+	mux.HandleFunc("POST /api/flashcards/start-guest", game.StartGuestGameHandler)
+	mux.HandleFunc("POST /api/flashcards/restart", game.RestartGameHandler)
+	mux.HandleFunc("POST /api/flashcards/answer", game.SubmitAnswerHandler)
+	mux.HandleFunc("POST /api/flashcards/pause", game.PauseGameHandler)
+	mux.HandleFunc("POST /api/flashcards/resume", game.ResumeGameHandler)
+	mux.HandleFunc("GET /api/flashcards/session", flashcards.SessionStateHandler)
+	mux.HandleFunc("POST /api/flashcards/import", flashcards.ImportDeckHandler)
+	mux.HandleFunc("POST /api/flashcards/occlusion", flashcards.CreateOcclusionCardsHandler)
+	mux.HandleFunc("POST /api/flashcards/generate", flashcards.GenerateCardsHandler)
+	mux.HandleFunc("POST /api/flashcards/explain", flashcards.ExplainHandler)
+	mux.HandleFunc("POST /api/flashcards/ocr", flashcards.OCRImportHandler)
+	mux.HandleFunc("GET /flashcards/media/{id}", flashcards.MediaHandler)
+	mux.HandleFunc("GET /api/flashcards/review/next", flashcards.ReviewNextHandler)
+	mux.HandleFunc("POST /api/flashcards/review/grade", flashcards.ReviewGradeHandler)
+	mux.HandleFunc("GET /api/flashcards/duplicates", flashcards.DuplicatesHandler)
+	mux.HandleFunc("POST /api/flashcards/merge", flashcards.MergeCardsHandler)
+	mux.HandleFunc("GET /api/flashcards/scores", flashcards.ScoreHistoryHandler)
+	mux.HandleFunc("GET /api/flashcards/scores/export.csv", flashcards.ExportScoresHandler)
+	mux.HandleFunc("GET /api/flashcards/{id}/stats", flashcards.CardStatsHandler)
+	mux.HandleFunc("GET /api/flashcards/courses/{id}/hardest", flashcards.HardestCardsHandler)
+	mux.HandleFunc("GET /api/flashcards/courses/{id}/scoring", flashcards.ScoringRulesHandler)
+	mux.HandleFunc("PUT /api/flashcards/courses/{id}/scoring", flashcards.SetScoringRulesHandler)
+	mux.HandleFunc("PUT /api/flashcards/courses/{id}", flashcards.UpdateCourseHandler)
+	mux.HandleFunc("DELETE /api/flashcards/courses/{id}", flashcards.DeleteCourseHandler)
+	mux.HandleFunc("POST /api/flashcards/courses/{id}/ready", flashcards.MarkCourseReadyHandler)
+	mux.HandleFunc("GET /api/flashcards/courses/{id}/distractors", flashcards.PreviewDistractorsHandler)
+	mux.HandleFunc("POST /api/flashcards/courses/{id}/distractors", flashcards.AcceptDistractorsHandler)
+	mux.HandleFunc("POST /api/flashcards/courses/{id}/cards", flashcards.AddCardHandler)
+	mux.HandleFunc("PUT /api/flashcards/courses/{id}/cards/{card_id}", flashcards.UpdateCardHandler)
+	mux.HandleFunc("DELETE /api/flashcards/courses/{id}/cards/{card_id}", flashcards.DeleteCardHandler)
+	mux.HandleFunc("PUT /api/flashcards/courses/{id}/cards/{card_id}/explanation", flashcards.SetExplanationHandler)
+	mux.HandleFunc("PUT /api/flashcards/courses/{id}/cards/{card_id}/challenge", flashcards.SetChallengeHandler)
+	mux.HandleFunc("DELETE /api/flashcards/courses/{id}/cards/{card_id}/challenge", flashcards.RemoveChallengeHandler)
+	mux.HandleFunc("POST /api/flashcards/{id}/challenge/submissions", flashcards.SubmitChallengeHandler)
+	mux.HandleFunc("GET /api/flashcards/{id}/challenge/submissions", flashcards.ListSubmissionsHandler)
+	mux.HandleFunc("GET /api/flashcards/courses/{id}/collaborators", flashcards.ListCollaboratorsHandler)
+	mux.HandleFunc("POST /api/flashcards/courses/{id}/collaborators", flashcards.InviteCollaboratorHandler)
+	mux.HandleFunc("PUT /api/flashcards/courses/{id}/collaborators/{username}", flashcards.SetCollaboratorRoleHandler)
+	mux.HandleFunc("DELETE /api/flashcards/courses/{id}/collaborators/{username}", flashcards.RemoveCollaboratorHandler)
+	mux.HandleFunc("GET /api/flashcards/gallery", flashcards.GalleryHandler)
+	mux.HandleFunc("GET /api/flashcards/gallery/categories", flashcards.GalleryCategoriesHandler)
+	mux.HandleFunc("PUT /api/flashcards/gallery/{id}", flashcards.PublishDeckHandler)
+	mux.HandleFunc("DELETE /api/flashcards/gallery/{id}", flashcards.UnpublishDeckHandler)
+	mux.HandleFunc("PUT /api/flashcards/gallery/{id}/star", flashcards.StarDeckHandler)
+	mux.HandleFunc("DELETE /api/flashcards/gallery/{id}/star", flashcards.StarDeckHandler)
+	mux.HandleFunc("PUT /api/flashcards/gallery/{id}/rating", flashcards.RateDeckHandler)
+	mux.HandleFunc("POST /api/flashcards/gallery/{id}/clone", flashcards.CloneDeckHandler)
+
+	// Long-running operation progress (Server-Sent Events)
+	mux.HandleFunc("GET /api/operations/events", operations.EventsHandler)
+
+	// Messages route
+	mux.HandleFunc("POST /api/messages", idempotency.Handler(messages.MessagesHandler))
+	mux.HandleFunc("POST "+login.AppealPath, messages.AppealHandler)
+
+	// Notifications
+	mux.HandleFunc("GET /api/notifications", notifications.NotificationsHandler)
+	mux.HandleFunc("POST /api/notifications/read", notifications.MarkReadHandler)
+	mux.HandleFunc("GET /api/presence", presence.PresenceHandler)
+	mux.HandleFunc("POST /api/presence/heartbeat", presence.HeartbeatHandler)
+
+	// Terminal history and shell state, restored on reconnect
+	mux.HandleFunc("GET /api/terminal/history", terminal.HistoryHandler)
+	mux.HandleFunc("POST /api/terminal/history", terminal.RecordCommandHandler)
+	mux.HandleFunc("DELETE /api/terminal/history", terminal.ClearHistoryHandler)
+	mux.HandleFunc("GET /api/terminal/state", terminal.GetStateHandler)
+	mux.HandleFunc("PUT /api/terminal/state", terminal.SaveStateHandler)
+	mux.HandleFunc("POST /api/terminal/exec", terminal.ExecHandler)
+	mux.HandleFunc("DELETE /api/terminal/sandbox", terminal.ResetSandboxHandler)
+	mux.HandleFunc("GET /api/terminal/packages", terminal.ListPackagesHandler)
+	mux.HandleFunc("POST /api/terminal/packages", terminal.InstallPackagesHandler)
+	mux.HandleFunc("DELETE /api/terminal/packages", terminal.RemovePackagesHandler)
+	mux.HandleFunc("GET /api/terminal/packages/allowed", terminal.AllowedPackagesHandler)
+	mux.HandleFunc("GET /api/terminal/previews", terminal.ListPreviewsHandler)
+	mux.HandleFunc("POST /api/terminal/previews", terminal.CreatePreviewHandler)
+	mux.HandleFunc("DELETE /api/terminal/previews/{session}", terminal.ClosePreviewHandler)
+	mux.HandleFunc("/preview/{session}/", terminal.PreviewHandler)
+	mux.HandleFunc("GET /api/secrets", secrets.ListSecretsHandler)
+	mux.HandleFunc("PUT /api/secrets/{name}", secrets.SetSecretHandler)
+	mux.HandleFunc("DELETE /api/secrets/{name}", secrets.DeleteSecretHandler)
+	mux.HandleFunc("GET /api/cron/jobs", cronjobs.ListJobsHandler)
+	mux.HandleFunc("POST /api/cron/jobs", cronjobs.CreateJobHandler)
+	mux.HandleFunc("PUT /api/cron/jobs/{id}", cronjobs.UpdateJobHandler)
+	mux.HandleFunc("DELETE /api/cron/jobs/{id}", cronjobs.DeleteJobHandler)
+	mux.HandleFunc("GET /api/cron/jobs/{id}/runs", cronjobs.RunsHandler)
+	mux.HandleFunc("POST /api/cron/jobs/{id}/run", cronjobs.RunNowHandler)
+	mux.HandleFunc("GET /api/terminal/recordings", terminal.ListRecordingsHandler)
+	mux.HandleFunc("POST /api/terminal/recordings", terminal.CreateRecordingHandler)
+	mux.HandleFunc("GET /api/terminal/recordings/{id}", terminal.GetRecordingHandler)
+	mux.HandleFunc("DELETE /api/terminal/recordings/{id}", terminal.DeleteRecordingHandler)
+	mux.HandleFunc("PUT /api/terminal/recordings/{id}/share", terminal.ShareRecordingHandler)
+	mux.HandleFunc("DELETE /api/terminal/recordings/{id}/share", terminal.UnshareRecordingHandler)
+	mux.HandleFunc("GET /api/terminal/replays/{token}", terminal.ReplayHandler)
+	mux.HandleFunc("GET /replay/{token}", terminal.ReplayPageHandler)
+
+	// Public API, authenticated by API key
+	mux.HandleFunc("GET /api/api-keys", apikeys.ListKeysHandler)
+	mux.HandleFunc("POST /api/api-keys", apikeys.CreateKeyHandler)
+	mux.HandleFunc("DELETE /api/api-keys/{id}", apikeys.RevokeKeyHandler)
+	mux.HandleFunc("OPTIONS /api/public/", apikeys.PreflightHandler)
+	mux.HandleFunc("GET /api/public/v1/decks", apikeys.Require(etag.Handler(flashcards.PublicDecksHandler)))
+	mux.HandleFunc("GET /api/public/v1/decks/{id}", apikeys.Require(etag.Handler(flashcards.PublicDeckHandler)))
+	mux.HandleFunc("GET /api/reminders", reminders.ListRemindersHandler)
+	mux.HandleFunc("PUT /api/reminders", reminders.SetReminderHandler)
+	mux.HandleFunc("DELETE /api/reminders/{id}", reminders.DeleteReminderHandler)
+	mux.HandleFunc("GET /reminders/unsubscribe", reminders.UnsubscribeHandler)
+	mux.HandleFunc("POST /reminders/unsubscribe", reminders.UnsubscribeHandler)
+	mux.HandleFunc("GET /api/reminders/digest", reminders.DigestPreferenceHandler)
+	mux.HandleFunc("PUT /api/reminders/digest", reminders.SetDigestPreferenceHandler)
+	mux.HandleFunc("GET /digest/unsubscribe", reminders.DigestUnsubscribeHandler)
+	mux.HandleFunc("POST /digest/unsubscribe", reminders.DigestUnsubscribeHandler)
+
+	// Avatars
+	mux.HandleFunc("PUT /api/avatar", avatars.UploadHandler)
+	mux.HandleFunc("DELETE /api/avatar", avatars.DeleteHandler)
+	mux.HandleFunc("GET /avatars/{id}", avatars.ServeHandler)
+
+	// Published pages
+	mux.HandleFunc("GET /api/pages", pages.ListPagesHandler)
+	mux.HandleFunc("POST /api/pages", pages.PublishHandler)
+	mux.HandleFunc("DELETE /api/pages/{slug}", pages.UnpublishHandler)
+	mux.HandleFunc("GET /u/{username}", pages.IndexHandler)
+	mux.HandleFunc("GET /u/{username}/{slug}", pages.PageHandler)
+
+	// File management routes
+	mux.HandleFunc("POST /api/files/save", idempotency.Handler(files.SaveFileHandler))
+	mux.HandleFunc("GET /api/files/load", etag.Handler(files.LoadFileHandler))
+	mux.HandleFunc("GET /api/files/list", etag.Handler(files.ListFilesHandler))
+	mux.HandleFunc("DELETE /api/files/delete", files.DeleteFileHandler)
+	mux.HandleFunc("POST /api/files/import-git", files.ImportGitHandler)
+	mux.HandleFunc("GET /api/files/versions", files.ListVersionsHandler)
+	mux.HandleFunc("GET /api/files/versions/load", files.LoadVersionHandler)
+	mux.HandleFunc("POST /api/files/versions/restore", files.RestoreVersionHandler)
+
+	// Projects
+	mux.HandleFunc("GET /api/projects", projects.ListProjectsHandler)
+	mux.HandleFunc("POST /api/projects", idempotency.Handler(projects.CreateProjectHandler))
+	mux.HandleFunc("GET /api/projects/{name}", projects.GetProjectHandler)
+	mux.HandleFunc("PUT /api/projects/{name}", projects.UpdateProjectHandler)
+	mux.HandleFunc("DELETE /api/projects/{name}", projects.DeleteProjectHandler)
+	mux.HandleFunc("POST /api/projects/{name}/run", projects.RunProjectHandler)
+	mux.HandleFunc("PUT /api/projects/{name}/share", projects.ShareProjectHandler)
+	mux.HandleFunc("DELETE /api/projects/{name}/share", projects.UnshareProjectHandler)
+	mux.HandleFunc("GET /api/projects/shared/{token}", projects.SharedProjectHandler)
+
+	mux.HandleFunc("GET /api/github", github.ConnectionHandler)
+	mux.HandleFunc("DELETE /api/github", github.DisconnectHandler)
+	mux.HandleFunc("GET /api/github/connect", github.ConnectHandler)
+	mux.HandleFunc("GET /api/github/callback", github.CallbackHandler)
+	mux.HandleFunc("GET /api/github/exports", github.ListExportsHandler)
+	mux.HandleFunc("POST /api/github/exports", idempotency.Handler(github.ExportHandler))
+	mux.HandleFunc("POST /api/github/exports/{id}/sync", github.SyncExportHandler)
+	mux.HandleFunc("DELETE /api/github/exports/{id}", github.DeleteExportHandler)
+
+	// IAM endpoints
+	mux.HandleFunc("GET /api/iam/users", etag.Handler(iam.ListUsersHandler))
+	mux.HandleFunc("POST /api/iam/users", idempotency.Handler(iam.CreateUserHandler))
+	mux.HandleFunc("GET /api/iam/users/{name}", iam.GetUserHandler)
+	mux.HandleFunc("DELETE /api/iam/users/{name}", iam.DeleteUserHandler)
+	mux.HandleFunc("PUT /api/iam/users/{name}/permissions-boundary", iam.PutUserPermissionsBoundaryHandler)
+	mux.HandleFunc("DELETE /api/iam/users/{name}/permissions-boundary", iam.DeleteUserPermissionsBoundaryHandler)
+	mux.HandleFunc("POST /api/iam/users/{name}/attached-policies", iam.AttachUserPolicyHandler)
+	mux.HandleFunc("DELETE /api/iam/users/{name}/attached-policies", iam.DetachUserPolicyHandler)
+	mux.HandleFunc("POST /api/iam/users/{name}/login-profile", iam.CreateLoginProfileHandler)
+	mux.HandleFunc("GET /api/iam/users/{name}/login-profile", iam.GetLoginProfileHandler)
+	mux.HandleFunc("PUT /api/iam/users/{name}/login-profile", iam.UpdateLoginProfileHandler)
+	mux.HandleFunc("DELETE /api/iam/users/{name}/login-profile", iam.DeleteLoginProfileHandler)
+	mux.HandleFunc("POST /api/iam/console-signin", iam.ConsoleSignInHandler)
+	mux.HandleFunc("GET /api/iam/account-password-policy", iam.GetPasswordPolicyHandler)
+	mux.HandleFunc("PUT /api/iam/account-password-policy", iam.UpdatePasswordPolicyHandler)
+	mux.HandleFunc("DELETE /api/iam/account-password-policy", iam.DeletePasswordPolicyHandler)
+	mux.HandleFunc("GET /api/iam/credential-report", iam.GetCredentialReportHandler)
+	mux.HandleFunc("GET /api/iam/roles", etag.Handler(iam.ListRolesHandler))
+	mux.HandleFunc("POST /api/iam/roles", idempotency.Handler(iam.CreateRoleHandler))
+	mux.HandleFunc("PUT /api/iam/roles/{name}/permissions-boundary", iam.PutRolePermissionsBoundaryHandler)
+	mux.HandleFunc("DELETE /api/iam/roles/{name}/permissions-boundary", iam.DeleteRolePermissionsBoundaryHandler)
+	mux.HandleFunc("POST /api/iam/roles/{name}/attached-policies", iam.AttachRolePolicyHandler)
+	mux.HandleFunc("DELETE /api/iam/roles/{name}/attached-policies", iam.DetachRolePolicyHandler)
+	mux.HandleFunc("GET /api/iam/policies", etag.Handler(iam.ListPoliciesHandler))
+	mux.HandleFunc("POST /api/iam/policies", idempotency.Handler(iam.CreatePolicyHandler))
+	mux.HandleFunc("GET /api/iam/policies/{name}/versions", iam.ListPolicyVersionsHandler)
+	mux.HandleFunc("POST /api/iam/policies/{name}/versions", idempotency.Handler(iam.CreatePolicyVersionHandler))
+	mux.HandleFunc("GET /api/iam/policies/{name}/versions/{version_id}", iam.GetPolicyVersionHandler)
+	mux.HandleFunc("DELETE /api/iam/policies/{name}/versions/{version_id}", iam.DeletePolicyVersionHandler)
+	mux.HandleFunc("PUT /api/iam/policies/{name}/default-version", iam.SetDefaultPolicyVersionHandler)
+	mux.HandleFunc("GET /api/iam/search", iam.SearchHandler)
+	mux.HandleFunc("POST /api/iam/simulate", iam.SimulatePolicyHandler)
+	mux.HandleFunc("POST /api/iam/calls", iam.CallHandler)
+	mux.HandleFunc("GET /api/iam/access-advisor", iam.AccessAdvisorHandler)
+	mux.HandleFunc("POST /api/iam/simulate-assume-role", iam.SimulateAssumeRoleHandler)
+	mux.HandleFunc("GET /api/organizations/organization", iam.GetOrganizationHandler)
+	mux.HandleFunc("POST /api/organizations/organization", iam.CreateOrganizationHandler)
+	mux.HandleFunc("GET /api/organizations/accounts", etag.Handler(iam.ListOrgAccountsHandler))
+	mux.HandleFunc("POST /api/organizations/accounts", idempotency.Handler(iam.CreateOrgAccountHandler))
+	mux.HandleFunc("GET /api/organizations/policies", etag.Handler(iam.ListSCPsHandler))
+	mux.HandleFunc("POST /api/organizations/policies", idempotency.Handler(iam.CreateSCPHandler))
+	mux.HandleFunc("POST /api/organizations/policies/{id}/targets", iam.AttachSCPHandler)
+	mux.HandleFunc("DELETE /api/organizations/policies/{id}/targets", iam.DetachSCPHandler)
+	mux.HandleFunc("GET /api/cloudsim/compliance", cloudsim.GetComplianceHandler)
+	mux.HandleFunc("POST /api/cloudsim/compliance", cloudsim.RunComplianceHandler)
+	mux.HandleFunc("GET /api/cloudsim/tag-policies", cloudsim.ListTagPoliciesHandler)
+	mux.HandleFunc("POST /api/cloudsim/tag-policies", idempotency.Handler(cloudsim.CreateTagPolicyHandler))
+	mux.HandleFunc("DELETE /api/cloudsim/tag-policies/{id}", cloudsim.DeleteTagPolicyHandler)
+	mux.HandleFunc("GET /api/cloudsim/resources", cloudsim.ListResourcesHandler)
+	mux.HandleFunc("POST /api/cloudsim/resources", idempotency.Handler(cloudsim.CreateResourceHandler))
+	mux.HandleFunc("DELETE /api/cloudsim/resources/{id}", cloudsim.DeleteResourceHandler)
+	mux.HandleFunc("GET /api/cloudsim/metrics", cloudsim.ListMetricsHandler)
+	mux.HandleFunc("GET /api/cloudsim/metrics/data", cloudsim.GetMetricDataHandler)
+	mux.HandleFunc("GET /api/cloudsim/alarms", cloudsim.ListAlarmsHandler)
+	mux.HandleFunc("POST /api/cloudsim/alarms", idempotency.Handler(cloudsim.CreateAlarmHandler))
+	mux.HandleFunc("DELETE /api/cloudsim/alarms/{id}", cloudsim.DeleteAlarmHandler)
+	mux.HandleFunc("GET /api/cloudsim/diagram", cloudsim.DiagramHandler)
+	mux.HandleFunc("GET /api/cloudsim/services", cloudsim.ServicesHandler)
+	mux.HandleFunc("GET /api/cloudsim/kms/keys", cloudsim.ListKeysHandler)
+	mux.HandleFunc("POST /api/cloudsim/kms/keys", idempotency.Handler(cloudsim.CreateKeyHandler))
+	mux.HandleFunc("GET /api/cloudsim/kms/keys/{id}", cloudsim.GetKeyHandler)
+	mux.HandleFunc("PUT /api/cloudsim/kms/keys/{id}/policy", cloudsim.PutKeyPolicyHandler)
+	mux.HandleFunc("PUT /api/cloudsim/kms/keys/{id}/enabled", cloudsim.SetKeyEnabledHandler)
+	mux.HandleFunc("GET /api/cloudsim/kms/keys/{id}/grants", cloudsim.ListGrantsHandler)
+	mux.HandleFunc("POST /api/cloudsim/kms/keys/{id}/grants", idempotency.Handler(cloudsim.CreateGrantHandler))
+	mux.HandleFunc("DELETE /api/cloudsim/kms/keys/{id}/grants/{grant_id}", cloudsim.RevokeGrantHandler)
+	mux.HandleFunc("POST /api/cloudsim/kms/encrypt", cloudsim.EncryptHandler)
+	mux.HandleFunc("POST /api/cloudsim/kms/decrypt", cloudsim.DecryptHandler)
+	mux.HandleFunc("GET /api/cloudsim/sqs/queues", cloudsim.ListQueuesHandler)
+	mux.HandleFunc("POST /api/cloudsim/sqs/queues", idempotency.Handler(cloudsim.CreateQueueHandler))
+	mux.HandleFunc("GET /api/cloudsim/sqs/queues/{name}", cloudsim.GetQueueHandler)
+	mux.HandleFunc("PUT /api/cloudsim/sqs/queues/{name}", cloudsim.UpdateQueueHandler)
+	mux.HandleFunc("DELETE /api/cloudsim/sqs/queues/{name}", cloudsim.DeleteQueueHandler)
+	mux.HandleFunc("POST /api/cloudsim/sqs/queues/{name}/messages", idempotency.Handler(cloudsim.SendMessageHandler))
+	mux.HandleFunc("POST /api/cloudsim/sqs/queues/{name}/messages/receive", cloudsim.ReceiveMessagesHandler)
+	mux.HandleFunc("DELETE /api/cloudsim/sqs/queues/{name}/messages/{receipt_handle}", cloudsim.DeleteMessageHandler)
+	mux.HandleFunc("PUT /api/cloudsim/sqs/queues/{name}/messages/{receipt_handle}/visibility", cloudsim.ChangeVisibilityHandler)
+	mux.HandleFunc("GET /api/cloudsim/sns/topics", cloudsim.ListTopicsHandler)
+	mux.HandleFunc("POST /api/cloudsim/sns/topics", idempotency.Handler(cloudsim.CreateTopicHandler))
+	mux.HandleFunc("DELETE /api/cloudsim/sns/topics/{name}", cloudsim.DeleteTopicHandler)
+	mux.HandleFunc("GET /api/cloudsim/sns/topics/{name}/subscriptions", cloudsim.ListSubscriptionsHandler)
+	mux.HandleFunc("POST /api/cloudsim/sns/topics/{name}/subscriptions", idempotency.Handler(cloudsim.SubscribeHandler))
+	mux.HandleFunc("POST /api/cloudsim/sns/topics/{name}/publish", idempotency.Handler(cloudsim.PublishHandler))
+	mux.HandleFunc("DELETE /api/cloudsim/sns/subscriptions/{id}", cloudsim.UnsubscribeHandler)
+	mux.HandleFunc("GET /api/cloudsim/functions/{name}/invocations", cloudsim.ListInvocationsHandler)
+	mux.HandleFunc("GET /api/cloudsim/dynamodb/tables", cloudsim.ListTablesHandler)
+	mux.HandleFunc("POST /api/cloudsim/dynamodb/tables", idempotency.Handler(cloudsim.CreateTableHandler))
+	mux.HandleFunc("GET /api/cloudsim/dynamodb/tables/{name}", cloudsim.GetTableHandler)
+	mux.HandleFunc("DELETE /api/cloudsim/dynamodb/tables/{name}", cloudsim.DeleteTableHandler)
+	mux.HandleFunc("PUT /api/cloudsim/dynamodb/tables/{name}/capacity", cloudsim.UpdateCapacityHandler)
+	mux.HandleFunc("PUT /api/cloudsim/dynamodb/tables/{name}/items", cloudsim.PutItemHandler)
+	mux.HandleFunc("POST /api/cloudsim/dynamodb/tables/{name}/items/get", cloudsim.GetItemHandler)
+	mux.HandleFunc("POST /api/cloudsim/dynamodb/tables/{name}/items/delete", cloudsim.DeleteItemHandler)
+	mux.HandleFunc("POST /api/cloudsim/dynamodb/tables/{name}/query", cloudsim.QueryHandler)
+
+	// OpenAPI document and generated clients for the files, flashcards and
+	// IAM APIs
+	mux.HandleFunc("GET /api/openapi.json", sdk.OpenAPIHandler)
+	mux.HandleFunc("GET /api/sdk/typescript", sdk.TypeScriptHandler)
+	mux.HandleFunc("GET /api/sdk/go", sdk.GoHandler)
+
+	// UnleashedJS demo
+	mux.HandleFunc("GET /playground", unleashedjs.PlaygroundPageHandler)
+	mux.HandleFunc("POST /api/ujs/compile", unleashedjs.CompileHandler)
+	mux.HandleFunc("GET /api/ujs/info", unleashedjs.InfoHandler)
+	mux.HandleFunc("POST /api/ujs/benchmark", unleashedjs.BenchmarkHandler)
+	mux.HandleFunc("GET /api/ujs/runs", unleashedjs.RunsHandler)
+	mux.HandleFunc("POST /api/ujs/execute", unleashedjs.ExecuteHandler)
+	mux.HandleFunc("GET /api/ujs/snippets", unleashedjs.GetSnippetHandler)
+	mux.HandleFunc("POST /api/ujs/snippets", unleashedjs.SaveSnippetHandler)
+
+	// CloudSimulator endpoint
+	mux.HandleFunc("GET /cloudsimulator", cloudSimulatorHandler)
+
+	// Admin
+	mux.HandleFunc("GET /api/admin/scheduler", admin.SchedulerHandler(jobs))
+	mux.HandleFunc("GET /api/admin/debug-log", admin.DebugLogHandler(debugLog))
+	mux.HandleFunc("DELETE /api/admin/debug-log", admin.ClearDebugLogHandler(debugLog))
+	mux.HandleFunc("GET /api/admin/stats", admin.StatsHandler)
+	mux.HandleFunc("GET /api/admin/users", admin.UsersHandler)
+	mux.HandleFunc("GET /api/admin/file-encryption", admin.FileEncryptionHandler)
+	mux.HandleFunc("GET /api/admin/quarantine", admin.QuarantineHandler)
+	mux.HandleFunc("POST /api/admin/quarantine/{id}/release", admin.ReleaseQuarantineHandler)
+	mux.HandleFunc("POST /api/admin/accounts/{id}/suspend", admin.SuspendAccountHandler)
+	mux.HandleFunc("DELETE /api/admin/accounts/{id}/suspension", admin.LiftSuspensionHandler)
+	mux.HandleFunc("GET /api/admin/suspensions", admin.SuspensionsHandler)
+	mux.HandleFunc("GET /api/admin/flagged-games", admin.FlaggedGamesHandler)
+	mux.HandleFunc("GET /api/admin/flagged-answers", admin.FlaggedAnswersHandler)
+	mux.HandleFunc("GET /api/admin/blocked-ips", admin.BlockedIPsHandler)
+	mux.HandleFunc("DELETE /api/admin/blocked-ips/{ip}", admin.UnblockIPHandler)
+	mux.HandleFunc("GET /api/admin/honeypot-hits", admin.HoneypotHitsHandler)
+	mux.HandleFunc("GET /api/admin/ip-rules", admin.IPRulesHandler)
+	mux.HandleFunc("POST /api/admin/ip-rules", admin.CreateIPRuleHandler)
+	mux.HandleFunc("DELETE /api/admin/ip-rules/{id}", admin.DeleteIPRuleHandler)
+	mux.HandleFunc("GET /api/admin/messages/export", admin.ExportMessagesHandler)
+	mux.HandleFunc("PUT /api/admin/messages/{id}/legal-hold", admin.PlaceLegalHoldHandler)
+	mux.HandleFunc("DELETE /api/admin/messages/{id}/legal-hold", admin.ReleaseLegalHoldHandler)
+	mux.HandleFunc("GET /api/admin/site-mode", admin.SiteModeHandler)
+	mux.HandleFunc("PUT /api/admin/site-mode", admin.SetSiteModeHandler)
+	mux.HandleFunc("GET /api/admin/ujs-cache", unleashedjs.CacheStatsHandler)
+	mux.HandleFunc("DELETE /api/admin/ujs-cache", unleashedjs.InvalidateCacheHandler)
+	mux.HandleFunc("PUT /api/admin/sandbox/packages/{name}", terminal.AllowPackageHandler)
+	mux.HandleFunc("DELETE /api/admin/sandbox/packages/{name}", terminal.DisallowPackageHandler)
+	mux.HandleFunc("POST /api/admin/gallery/{id}/hide", flashcards.HideDeckHandler)
+	mux.HandleFunc("POST /api/admin/gallery/{id}/restore", flashcards.RestoreDeckHandler)
+
+	// Realtime updates
+	mux.Handle("GET /ws", hub)
+}
+
 func projectsHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("templates/projects.html")
-	if err != nil {
+	if err := templates.Render(w, r, "projects", nil); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
 	}
+}
 
-	err = tmpl.Execute(w, nil)
-	if err != nil {
+func cloudSimulatorHandler(w http.ResponseWriter, r *http.Request) {
+	if err := templates.Render(w, r, "cloudsimulator", nil); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == worker.Arg {
+		os.Exit(worker.Serve(os.Stdin, os.Stdout))
+	}
+
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables or defaults")
 	}
 
+	db.SlowQueryThreshold = config.Duration("SLOW_QUERY_THRESHOLD", db.SlowQueryThreshold)
+	metrics.Token = os.Getenv("METRICS_TOKEN")
+	messages.RetentionDays = config.Int("MESSAGE_RETENTION_DAYS", messages.RetentionDays)
 	if err := db.Connect(); err != nil {
 		log.Printf("Database connection failed: %v", err)
 		log.Println("Continuing without database...")
@@ -66,64 +524,678 @@ func main() {
 		if err := db.RunMigrations(); err != nil {
 			log.Printf("Migration failed: %v", err)
 		}
+		if err := flashcards.RestoreSessions(); err != nil {
+			log.Printf("Failed to restore game sessions: %v", err)
+		}
 	}
 
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static/"))))
-	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/projects", projectsHandler)
+	configureCache()
+	configureUnleashedJS()
+	configureMail()
+	configureLLM()
+	configureOCR()
+	configureSandbox()
+	configureVault()
+	configureFileEncryption()
+	configureMalwareScanner()
+	configureGitHub()
+	configureRedirects()
+	configurePasswords()
+	configureCookies()
+	configurePasskeys()
 
-	// Auth routes
-	http.HandleFunc("/login", login.LoginPageHandler)
-	http.HandleFunc("/register", login.RegisterPageHandler)
-	http.HandleFunc("/logout", login.LogoutHandler)
-	http.HandleFunc("/api/login", login.LoginAPIHandler)
-	http.HandleFunc("/api/register", login.RegisterAPIHandler)
-	http.HandleFunc("/api/check-username", login.CheckUsernameAPIHandler)
+	devMode := config.Bool("DEV_MODE", false)
+	var templateFS, staticFS fs.FS = templates.FS, static.FS
+	if devMode {
+		// Serve from disk so edits show up without rebuilding the binary.
+		templateFS, staticFS = os.DirFS("templates"), os.DirFS("static")
+	}
 
-	// Flashcards routes
-	http.HandleFunc("/flashcards", flashcards.FlashcardsPageHandler)
-	http.HandleFunc("/api/flashcards/courses", flashcards.CoursesAPIHandler)
-	http.HandleFunc("/api/flashcards/guest", flashcards.GuestFlashcardsAPIHandler)
-	http.HandleFunc("/api/flashcards/start", flashcards.StartGameHandler)
-	http.HandleFunc("/api/flashcards/start-guest", flashcards.StartGuestGameHandler)
-	http.HandleFunc("/api/flashcards/answer", flashcards.SubmitAnswerHandler)
+	assets, err := static.NewAssets(staticFS, !devMode)
+	if err != nil {
+		log.Fatalf("Failed to index static assets: %v", err)
+	}
+	if err := templates.Init(templateFS, devMode, template.FuncMap{"asset": assets.URL}); err != nil {
+		log.Fatalf("Failed to load templates: %v", err)
+	}
 
-	// Messages route
-	http.HandleFunc("/api/messages", messages.MessagesHandler)
+	// Orchestration probes
+	health.Register("database", db.Ping)
+	health.Register("migrations", db.CheckMigrations)
+	health.Register("templates", templates.Check)
 
-	// File management routes
-	http.HandleFunc("/api/files/save", files.SaveFileHandler)
-	http.HandleFunc("/api/files/load", files.LoadFileHandler)
-	http.HandleFunc("/api/files/list", files.ListFilesHandler)
-	http.HandleFunc("/api/files/delete", files.DeleteFileHandler)
+	jobs := newScheduler()
+	hub := ws.NewHub(ws.Options{
+		Authenticate: authenticateWebSocket,
+		OnConnect:    presence.Connected,
+		OnDisconnect: presence.Disconnected,
+	})
+	notifications.SetPublisher(hub)
+	flashcards.SetPublisher(hub)
 
-	// IAM endpoints
-	http.HandleFunc("/api/iam/users", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case "GET":
-			iam.ListUsersHandler(w, r)
-		case "POST":
-			iam.CreateUserHandler(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	debugLog := newDebugLog()
+	mux := http.NewServeMux()
+	registerRoutes(mux, assets, jobs, hub, debugLog)
+
+	tlsConfig := loadTLSSettings()
+	defaultAddr := ":8080"
+	if tlsConfig.Enabled() {
+		defaultAddr = ":443"
+	}
+
+	middleware.TrustProxyHeaders = config.Bool("TRUST_PROXY", false)
+	middleware.TrustedProxyHops = config.Int("TRUST_PROXY_HOPS", middleware.TrustedProxyHops)
+	login.OnLoginFailure = admin.RecordLoginFailure
+	login.GuestTTL = config.Duration("GUEST_ACCOUNT_TTL", login.GuestTTL)
+	idempotency.TTL = config.Duration("IDEMPOTENCY_KEY_TTL", idempotency.TTL)
+	login.UsernamePolicy.MinLength = config.Int("USERNAME_MIN_LENGTH", login.UsernamePolicy.MinLength)
+	login.UsernamePolicy.MaxLength = config.Int("USERNAME_MAX_LENGTH", login.UsernamePolicy.MaxLength)
+	login.UsernamePolicy.Punctuation = config.String("USERNAME_PUNCTUATION", login.UsernamePolicy.Punctuation)
+	if reserved := config.String("USERNAME_RESERVED", ""); reserved != "" {
+		for name := range login.ReservedSet(strings.Split(reserved, ",")) {
+			login.UsernamePolicy.Reserved[name] = true
+		}
+	}
+
+	var handler http.Handler = middleware.APIRouteErrors(mux)
+	handler = admin.Lockdown(handler)
+	handler = admin.BlockAbusiveIPs(handler)
+	handler = admin.TrackActivity(handler)
+	handler = login.EnforceSuspensions(handler)
+	if debugLog != nil {
+		handler = debugLog.Middleware(handler)
+	}
+	handler = middleware.LimitBody(bodyLimits, handler)
+	handler = newRateLimiter().Middleware(handler)
+	handler = i18n.Middleware(handler)
+	handler = middleware.SecureHeaders(middleware.SecurityHeaders{
+		HSTS:       tlsConfig.Enabled(),
+		ReportOnly: config.Bool("CSP_REPORT_ONLY", false),
+	}, handler)
+	handler = metrics.Requests(handler)
+
+	srv := &http.Server{
+		Addr:              config.String("ADDR", defaultAddr),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		MaxHeaderBytes:    64 << 10,
+	}
+	srv.RegisterOnShutdown(hub.Close)
+	servers := []*http.Server{srv}
+
+	if tlsConfig.Enabled() {
+		redirectSrv, err := configureTLS(srv, tlsConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		login.SecureCookies = true
+		flash.Secure = true
+		servers = append(servers, redirectSrv)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	jobs.Start(ctx)
+
+	shutdownTimeout := config.Duration("SHUTDOWN_TIMEOUT", 15*time.Second)
+	if err := runServer(ctx, shutdownTimeout, servers...); err != nil {
+		log.Fatal(err)
+	}
+
+	jobs.Wait()
+	releaseResources()
+}
+
+// newDebugLog returns the request capture configured by DEBUG_LOG_ROUTES, a
+// comma-separated list of path prefixes, or nil when it is off.
+func newDebugLog() *middleware.DebugLog {
+	var prefixes []string
+	for _, prefix := range strings.Split(config.String("DEBUG_LOG_ROUTES", ""), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil
+	}
+	log.Printf("Capturing requests to %v for debugging", prefixes)
+	return middleware.NewDebugLog(config.Int("DEBUG_LOG_SIZE", 200), prefixes, config.Int("DEBUG_LOG_MAX_BODY", 4096))
+}
+
+// configureCache switches the read cache to Redis when CACHE_BACKEND=redis
+// so invalidations reach every instance.
+func configureCache() {
+	if config.String("CACHE_BACKEND", "memory") != "redis" {
+		return
+	}
+	store, err := cache.NewRedisStore(config.String("REDIS_URL", "redis://localhost:6379/0"))
+	if err != nil {
+		log.Printf("Redis cache unavailable, falling back to memory: %v", err)
+		return
+	}
+	cache.SetDefault(store)
+}
+
+// configureUnleashedJS runs UnleashedJS compiles and benchmarks in
+// short-lived worker processes (this binary, re-executed in worker mode) so
+// a crash or hang only costs one request. UJS_ISOLATE=false runs in-process.
+// Compile and run results are cached unless UJS_CACHE_ENTRIES is 0.
+func configureUnleashedJS() {
+	if entries := config.Int("UJS_CACHE_ENTRIES", 1000); entries > 0 {
+		unleashedjs.SetResultCache(unleashedjs.NewResultCache(entries,
+			config.Int("UJS_CACHE_BYTES", 16<<20), config.Duration("UJS_CACHE_TTL", time.Hour)))
+	}
+
+	if !config.Bool("UJS_ISOLATE", true) {
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Printf("UnleashedJS worker unavailable, compiling in-process: %v", err)
+		return
+	}
+	client := worker.NewClient(exe, []string{worker.Arg},
+		config.Int("UJS_MAX_WORKERS", 4), config.Duration("UJS_TIMEOUT", 5*time.Second))
+	client.BenchmarkTimeout = config.Duration("UJS_BENCHMARK_TIMEOUT", 30*time.Second)
+	unleashedjs.SetEngine(client)
+}
+
+// configureMail sends email through SMTP_ADDR when it is set; otherwise
+// outgoing mail is only logged. PUBLIC_URL is the origin used in links.
+func configureMail() {
+	reminders.PublicURL = strings.TrimSuffix(config.String("PUBLIC_URL", reminders.PublicURL), "/")
+	addr := config.String("SMTP_ADDR", "")
+	if addr == "" {
+		return
+	}
+	mail.SetSender(mail.NewSMTPSender(addr, config.String("SMTP_FROM", "noreply@localhost"),
+		config.String("SMTP_USERNAME", ""), config.String("SMTP_PASSWORD", "")))
+}
+
+// configureLLM enables the features built on a language model when
+// LLM_API_URL names an OpenAI-compatible API; otherwise they answer 503.
+func configureLLM() {
+	url := config.String("LLM_API_URL", "")
+	if url == "" {
+		return
+	}
+	llm.SetProvider(llm.NewOpenAIProvider(url, config.String("LLM_API_KEY", ""), config.String("LLM_MODEL", "gpt-4o-mini")))
+}
+
+// configureOCR enables photo import when OCR_TESSERACT names the tesseract
+// binary; otherwise it answers 503.
+func configureOCR() {
+	path := config.String("OCR_TESSERACT", "")
+	if path == "" {
+		return
+	}
+	ocr.SetProvider(ocr.Tesseract{Path: path, Languages: config.String("OCR_LANGUAGES", "eng+por+spa")})
+}
+
+// configureRedirects sets the key that signs post-login redirect tokens
+// from REDIRECT_SIGNING_KEY, 32 bytes in base64 or hex, so tokens work
+// across instances and restarts, and the hosts outside this site that
+// sign-in may return to from REDIRECT_ALLOWED_HOSTS.
+func configureRedirects() {
+	if encoded := config.String("REDIRECT_SIGNING_KEY", ""); encoded != "" {
+		key, err := vault.ParseKey(encoded)
+		if err != nil {
+			log.Fatalf("REDIRECT_SIGNING_KEY: %v", err)
+		}
+		login.SetRedirectKey(key)
+	}
+	for _, host := range strings.Split(config.String("REDIRECT_ALLOWED_HOSTS", ""), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			login.AllowedRedirectHosts[host] = true
 		}
+	}
+}
+
+// configurePasswords sets how new password hashes are made:
+// PASSWORD_HASH_ALGORITHM is argon2id (the default) or bcrypt, with
+// ARGON2_TIME, ARGON2_MEMORY_KIB and ARGON2_THREADS or BCRYPT_COST. Hashes
+// made otherwise are replaced when their owners sign in. Invalid settings
+// stop the server rather than weaken new hashes.
+func configurePasswords() {
+	defaults := passhash.DefaultParams()
+	err := passhash.Configure(passhash.Params{
+		Algorithm:     config.String("PASSWORD_HASH_ALGORITHM", defaults.Algorithm),
+		BcryptCost:    config.Int("BCRYPT_COST", defaults.BcryptCost),
+		Argon2Time:    config.Int("ARGON2_TIME", defaults.Argon2Time),
+		Argon2Memory:  config.Int("ARGON2_MEMORY_KIB", defaults.Argon2Memory),
+		Argon2Threads: config.Int("ARGON2_THREADS", defaults.Argon2Threads),
 	})
-	http.HandleFunc("/api/iam/roles", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case "GET":
-			iam.ListRolesHandler(w, r)
-		case "POST":
-			iam.CreateRoleHandler(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if err != nil {
+		log.Fatalf("Password hashing: %v", err)
+	}
+}
+
+// configureCookies signs the session and flash cookies with
+// COOKIE_SIGNING_KEYS and, if set, encrypts them with
+// COOKIE_ENCRYPTION_KEYS: comma-separated 32-byte keys in base64 or hex,
+// newest first. To rotate, put the new key in front and drop the old one
+// once COOKIE_MAX_AGE (24h by default) has passed. Without signing keys a
+// random key is used, so sessions end on restart and do not carry between
+// instances; an invalid key stops the server.
+func configureCookies() {
+	signing, err := parseKeys(config.String("COOKIE_SIGNING_KEYS", ""))
+	if err != nil {
+		log.Fatalf("COOKIE_SIGNING_KEYS: %v", err)
+	}
+	encryption, err := parseKeys(config.String("COOKIE_ENCRYPTION_KEYS", ""))
+	if err != nil {
+		log.Fatalf("COOKIE_ENCRYPTION_KEYS: %v", err)
+	}
+	if len(signing) == 0 {
+		if len(encryption) > 0 {
+			log.Fatalf("COOKIE_ENCRYPTION_KEYS needs COOKIE_SIGNING_KEYS")
+		}
+		log.Printf("COOKIE_SIGNING_KEYS is not set; cookies are signed with a random key and sessions end on restart")
+		return
+	}
+	codec, err := securecookie.New(signing, encryption)
+	if err != nil {
+		log.Fatalf("Cookies: %v", err)
+	}
+	codec.MaxAge = config.Duration("COOKIE_MAX_AGE", 24*time.Hour)
+	securecookie.SetDefault(codec)
+}
+
+// configurePasskeys lets accounts sign in with passkeys from the origins
+// in WEBAUTHN_ORIGINS, comma-separated, or PUBLIC_URL. WEBAUTHN_RP_ID is
+// the domain passkeys belong to, by default the first origin's host;
+// changing it orphans every passkey registered before.
+func configurePasskeys() {
+	var origins []string
+	for _, origin := range strings.Split(config.String("WEBAUTHN_ORIGINS", reminders.PublicURL), ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return
+	}
+	first, err := url.Parse(origins[0])
+	if err != nil || first.Host == "" {
+		log.Fatalf("WEBAUTHN_ORIGINS: %q is not an origin", origins[0])
+	}
+	login.RelyingParty = &webauthn.RelyingParty{
+		ID:      config.String("WEBAUTHN_RP_ID", first.Hostname()),
+		Name:    config.String("WEBAUTHN_RP_NAME", sdk.Title),
+		Origins: origins,
+	}
+}
+
+// parseKeys parses a comma-separated list of keys for vault.ParseKey.
+func parseKeys(list string) ([][]byte, error) {
+	var keys [][]byte
+	for i, encoded := range strings.Split(list, ",") {
+		if encoded = strings.TrimSpace(encoded); encoded == "" {
+			continue
+		}
+		key, err := vault.ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
 		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// configureVault sets the master key that encrypts users' secrets from
+// SECRETS_MASTER_KEY, 32 bytes in base64 or hex. Without it the secrets API
+// answers 503; an invalid key stops the server rather than run without.
+func configureVault() {
+	encoded := config.String("SECRETS_MASTER_KEY", "")
+	if encoded == "" {
+		return
+	}
+	key, err := vault.ParseKey(encoded)
+	if err == nil {
+		err = vault.SetKey(key)
+	}
+	if err != nil {
+		log.Fatalf("SECRETS_MASTER_KEY: %v", err)
+	}
+}
+
+// configureFileEncryption encrypts saved files under the keyring in
+// FILES_ENCRYPTION_KEYS, comma-separated id:key pairs with the primary key
+// first. Without it files are stored as plaintext; an invalid keyring
+// stops the server, as files it encrypted would not load.
+func configureFileEncryption() {
+	spec := config.String("FILES_ENCRYPTION_KEYS", "")
+	if spec == "" {
+		return
+	}
+	k, err := vault.ParseKeyring(spec)
+	if err != nil {
+		log.Fatalf("FILES_ENCRYPTION_KEYS: %v", err)
+	}
+	files.SetKeyring(k)
+}
+
+// configureMalwareScanner scans saved files with the clamd at CLAMAV_ADDR
+// (a socket path or host:port) or, failing that, the scanning API at
+// MALWARE_SCAN_URL; otherwise files are stored unscanned.
+func configureMalwareScanner() {
+	if addr := config.String("CLAMAV_ADDR", ""); addr != "" {
+		malware.SetScanner(malware.NewClamAV(addr))
+		return
+	}
+	if url := config.String("MALWARE_SCAN_URL", ""); url != "" {
+		malware.SetScanner(malware.NewHTTPScanner(url, config.String("MALWARE_SCAN_TOKEN", "")))
+	}
+}
+
+// configureGitHub lets users connect GitHub accounts, to export files to
+// gists and repositories, when GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET
+// name an OAuth app whose callback is <PUBLIC_URL>/api/github/callback.
+// Tokens are encrypted with SECRETS_MASTER_KEY, which must be set too.
+func configureGitHub() {
+	id := config.String("GITHUB_CLIENT_ID", "")
+	if id == "" {
+		return
+	}
+	github.SetOAuth(&github.OAuth{
+		ClientID:     id,
+		ClientSecret: config.String("GITHUB_CLIENT_SECRET", ""),
+		RedirectURL:  reminders.PublicURL + "/api/github/callback",
 	})
+}
 
-	// CloudSimulator endpoint
-	http.HandleFunc("/cloudsimulator", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "templates/cloudsimulator.html")
+// configureSandbox runs terminal commands in containers started by
+// SANDBOX_RUNTIME (docker or podman) from SANDBOX_IMAGE; otherwise the
+// terminal has no shell and exec answers 503. Containers have no network
+// unless SANDBOX_NETWORK is set, and start with the user's virtualenv.
+func configureSandbox() {
+	path := config.String("SANDBOX_RUNTIME", "")
+	if path == "" {
+		return
+	}
+	pool := sandbox.NewPool(
+		sandbox.Docker{Path: path, Image: config.String("SANDBOX_IMAGE", "python:3.12-slim"), DiskMB: config.Int("SANDBOX_DISK_MB", 64)},
+		sandbox.PoolOptions{
+			Limits: sandbox.Limits{
+				CPUs:     config.Float("SANDBOX_CPUS", sandbox.DefaultLimits.CPUs),
+				MemoryMB: config.Int("SANDBOX_MEMORY_MB", sandbox.DefaultLimits.MemoryMB),
+				PIDs:     config.Int("SANDBOX_PIDS", sandbox.DefaultLimits.PIDs),
+				Network:  config.Bool("SANDBOX_NETWORK", false),
+			},
+			Warm:        config.Int("SANDBOX_WARM", 2),
+			Max:         config.Int("SANDBOX_MAX", 20),
+			IdleTimeout: config.Duration("SANDBOX_IDLE_TIMEOUT", 15*time.Minute),
+			Prepare:     terminal.RestoreVenv,
+		})
+	go pool.Fill(context.Background())
+	sandbox.SetPool(pool)
+}
+
+// newRateLimiter builds the API rate limiter, sharing buckets through Redis
+// when RATE_LIMIT_BACKEND=redis so several instances enforce one budget.
+func newRateLimiter() *ratelimit.Limiter {
+	var store ratelimit.Store
+	if config.String("RATE_LIMIT_BACKEND", "memory") == "redis" {
+		redisStore, err := ratelimit.NewRedisStore(config.String("REDIS_URL", "redis://localhost:6379/0"))
+		if err != nil {
+			log.Printf("Redis rate limiter unavailable, falling back to memory: %v", err)
+		} else {
+			store = redisStore
+		}
+	}
+	if store == nil {
+		memoryStore := ratelimit.NewMemoryStore()
+		memoryStore.StartCleanup(context.Background(), 10*time.Minute)
+		store = memoryStore
+	}
+
+	return &ratelimit.Limiter{
+		Store: store,
+		Rules: apiRateLimits,
+		Account: func(r *http.Request) string {
+			if key := apikeys.RateLimitKey(r); key != "" {
+				return key
+			}
+			if id, ok := securecookie.Value(r, "user_id"); ok {
+				return id
+			}
+			return ""
+		},
+	}
+}
+
+// newScheduler registers the recurring background jobs. Jobs that touch the
+// database are coordinated through scheduler_jobs so only one instance runs
+// each slot.
+func newScheduler() *scheduler.Scheduler {
+	var locker scheduler.Locker
+	if db.Available() {
+		locker = scheduler.DBLocker{DB: db.DB}
+	}
+	s := scheduler.New(locker)
+
+	sessionMaxAge := config.Duration("GAME_SESSION_MAX_AGE", 24*time.Hour)
+	mustRegister(s, scheduler.Job{
+		Name:     "game_session_gc",
+		Schedule: scheduler.Every(10 * time.Minute),
+		Local:    true,
+		Run: func(ctx context.Context) error {
+			if pruned := flashcards.PruneSessions(sessionMaxAge); pruned > 0 {
+				log.Printf("Pruned %d abandoned game sessions", pruned)
+			}
+			return nil
+		},
 	})
+	mustRegister(s, scheduler.Job{
+		Name:     "status_sample",
+		Schedule: scheduler.Every(time.Minute),
+		Local:    true,
+		Run:      status.Sample,
+	})
+
+	if pool := sandbox.Default(); pool != nil {
+		mustRegister(s, scheduler.Job{
+			Name:     "sandbox_reaper",
+			Schedule: scheduler.Every(time.Minute),
+			Local:    true,
+			Run: func(ctx context.Context) error {
+				if stopped := pool.Reap(ctx); stopped > 0 {
+					log.Printf("Stopped %d idle terminal sandboxes", stopped)
+				}
+				return nil
+			},
+		})
+	}
+
+	if db.Available() {
+		mustRegister(s, scheduler.Job{
+			Name:     "iam_credential_report",
+			Schedule: scheduler.MustCron("@hourly"),
+			Run:      iam.GenerateCredentialReports,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "iam_access_event_expiry",
+			Schedule: scheduler.MustCron("@daily"),
+			Run:      iam.DeleteOldAccessEvents,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "tag_compliance",
+			Schedule: scheduler.MustCron("@hourly"),
+			Run:      cloudsim.ScanAll,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "cloudwatch_metrics",
+			Schedule: scheduler.Every(time.Minute),
+			Run:      cloudsim.EmitMetrics,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "sqs_message_expiry",
+			Schedule: scheduler.MustCron("@daily"),
+			Run:      cloudsim.DeleteExpiredMessages,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "guest_expiry",
+			Schedule: scheduler.Every(10 * time.Minute),
+			Run:      login.DeleteExpiredGuests,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "passkey_challenge_expiry",
+			Schedule: scheduler.Every(10 * time.Minute),
+			Run:      login.DeleteExpiredChallenges,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "study_reminders",
+			Schedule: scheduler.Every(time.Minute),
+			Run:      reminders.SendDue,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "study_digest",
+			Schedule: scheduler.Every(time.Hour),
+			Run:      reminders.SendDigests,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "flashcard_stats",
+			Schedule: scheduler.Every(15 * time.Minute),
+			Run:      flashcards.RefreshCardStats,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "idempotency_key_expiry",
+			Schedule: scheduler.MustCron("@hourly"),
+			Run:      idempotency.DeleteExpired,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "file_chunk_gc",
+			Schedule: scheduler.MustCron("@hourly"),
+			Run:      files.CollectChunks,
+		})
+		mustRegister(s, scheduler.Job{
+			Name:     "brute_force_detection",
+			Schedule: scheduler.Every(time.Minute),
+			Run:      admin.DetectBruteForce,
+		})
+		if messages.RetentionDays > 0 {
+			mustRegister(s, scheduler.Job{
+				Name:     "message_retention",
+				Schedule: scheduler.MustCron("@daily"),
+				Run:      messages.PurgeExpired,
+			})
+		}
+		if sandbox.Configured() {
+			mustRegister(s, scheduler.Job{
+				Name:     "user_cron",
+				Schedule: scheduler.Every(time.Minute),
+				Run:      cronjobs.RunDue,
+				// A batch of due scripts, a few at a time.
+				Timeout: 15 * time.Minute,
+			})
+		}
+		if malware.Configured() {
+			mustRegister(s, scheduler.Job{
+				Name:     "file_scan",
+				Schedule: scheduler.Every(time.Minute),
+				Run:      files.ScanPending,
+				// A batch of files, one at a time.
+				Timeout: 15 * time.Minute,
+			})
+		}
+		if primary, _ := files.EncryptionKeys(); primary != "" {
+			mustRegister(s, scheduler.Job{
+				Name:     "file_encryption",
+				Schedule: scheduler.Every(10 * time.Minute),
+				Run:      files.Reencrypt,
+				// The first run after turning encryption on goes
+				// through every stored file.
+				Timeout: time.Hour,
+			})
+		}
+		mustRegister(s, scheduler.Job{
+			Name:     "card_media_cleanup",
+			Schedule: scheduler.MustCron("@daily"),
+			Run:      flashcards.DeleteUnusedMedia,
+		})
+	}
+	return s
+}
+
+func mustRegister(s *scheduler.Scheduler, job scheduler.Job) {
+	if err := s.Register(job); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// authenticateWebSocket resolves the session cookie during the WebSocket
+// handshake.
+func authenticateWebSocket(r *http.Request) (ws.Identity, error) {
+	if !db.Available() {
+		return ws.Identity{}, ws.ErrUnauthorized
+	}
+	user, err := login.GetCurrentUser(r)
+	if err != nil {
+		return ws.Identity{}, ws.ErrUnauthorized
+	}
+	return ws.Identity{AccountID: user.ID, Username: user.Username}, nil
+}
+
+// runServer serves until ctx is cancelled or a listener fails, then drains
+// in-flight requests for at most shutdownTimeout. Servers with a TLSConfig
+// serve HTTPS. Hooks registered with RegisterOnShutdown (such as WebSocket
+// hubs) are triggered as part of the drain.
+func runServer(ctx context.Context, shutdownTimeout time.Duration, servers ...*http.Server) error {
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			errCh <- listen(srv)
+		}(srv)
+	}
+
+	var serveErr error
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr = err
+		}
+	case <-ctx.Done():
+	}
 
-	fmt.Println("Server running at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Printf("Shutting down, waiting up to %s for in-flight requests...", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Graceful shutdown of %s incomplete: %v", srv.Addr, err)
+			srv.Close()
+		}
+	}
+	return serveErr
+}
+
+func listen(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		fmt.Printf("Server running at https://localhost%s\n", srv.Addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+	fmt.Printf("Server running at http://localhost%s\n", srv.Addr)
+	return srv.ListenAndServe()
+}
+
+// releaseResources persists in-memory state and closes the database pool once
+// the HTTP server has stopped accepting requests.
+func releaseResources() {
+	if pool := sandbox.Default(); pool != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		pool.Close(ctx)
+		cancel()
+	}
+	if !db.Available() {
+		return
+	}
+	if err := flashcards.FlushSessions(); err != nil {
+		log.Printf("Failed to flush game sessions: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	log.Println("Shutdown complete")
 }
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"allanswebterminal/handlers/sdk"
+	"allanswebterminal/middleware"
+	"allanswebterminal/scheduler"
+	"allanswebterminal/ws"
 )
 
 func TestHomeHandler(t *testing.T) {
@@ -34,4 +41,109 @@ func TestHomeHandler(t *testing.T) {
 	if !strings.Contains(rr.Body.String(), "Send me a message") {
 		t.Errorf("handler returned unexpected body: missing message form")
 	}
-}
\ No newline at end of file
+}
+func TestRunServerShutsDownOnCancel(t *testing.T) {
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(ctx, time.Second, srv)
+	}()
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("runServer returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runServer did not return after context cancellation")
+	}
+}
+
+func TestRoutesAreMethodSpecific(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux, http.NotFoundHandler(), scheduler.New(nil), ws.NewHub(ws.Options{}), nil)
+	handler := middleware.APIRouteErrors(mux)
+
+	tests := []struct {
+		method  string
+		path    string
+		pattern string
+	}{
+		{"GET", "/api/files/save", ""},
+		{"PUT", "/api/files/save", ""},
+		{"POST", "/api/files/load", ""},
+		{"POST", "/api/files/list", ""},
+		{"GET", "/api/files/delete", ""},
+		{"GET", "/api/messages", ""},
+		{"GET", "/api/ujs/compile", ""},
+		{"PATCH", "/api/iam/users", ""},
+		{"POST", "/api/iam/users", "POST /api/iam/users"},
+		{"GET", "/api/iam/users/alice", "GET /api/iam/users/{name}"},
+		{"DELETE", "/api/iam/users/alice", "DELETE /api/iam/users/{name}"},
+		{"GET", "/api/preferences/locale", "GET /api/preferences/locale"},
+		{"POST", "/api/preferences/locale", "POST /api/preferences/locale"},
+		{"POST", "/.env", "/.env"},
+		{"GET", "/wp-admin/setup-config.php", "/wp-admin/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		_, pattern := mux.Handler(req)
+		if pattern != tt.pattern {
+			t.Errorf("%s %s matched %q, want %q", tt.method, tt.path, pattern, tt.pattern)
+		}
+		if tt.pattern != "" {
+			continue
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusMethodNotAllowed || !strings.Contains(rec.Body.String(), `"method_not_allowed"`) {
+			t.Errorf("%s %s = %d %s, want JSON 405", tt.method, tt.path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// recordingRouter remembers every pattern registered on it.
+type recordingRouter struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (r *recordingRouter) Handle(pattern string, handler http.Handler) {
+	r.patterns = append(r.patterns, pattern)
+	r.ServeMux.Handle(pattern, handler)
+}
+
+func (r *recordingRouter) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	r.patterns = append(r.patterns, pattern)
+	r.ServeMux.HandleFunc(pattern, handler)
+}
+
+// The OpenAPI document and the clients generated from it must describe
+// exactly the routes served under the documented prefixes.
+func TestOpenAPIDocumentMatchesRoutes(t *testing.T) {
+	mux := &recordingRouter{ServeMux: http.NewServeMux()}
+	registerRoutes(mux, http.NotFoundHandler(), scheduler.New(nil), ws.NewHub(ws.Options{}), nil)
+
+	documented := make(map[string]bool)
+	for _, pattern := range sdk.Document().Patterns() {
+		documented[pattern] = true
+	}
+	for _, pattern := range mux.patterns {
+		_, path, _ := strings.Cut(pattern, " ")
+		for _, prefix := range sdk.Prefixes {
+			if strings.HasPrefix(path, prefix) {
+				if !documented[pattern] {
+					t.Errorf("route %q is not in the OpenAPI document (handlers/sdk)", pattern)
+				}
+				delete(documented, pattern)
+			}
+		}
+	}
+	for pattern := range documented {
+		t.Errorf("OpenAPI operation %q has no route", pattern)
+	}
+}
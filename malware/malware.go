@@ -0,0 +1,198 @@
+// Package malware scans user content through a pluggable Scanner, such as
+// a ClamAV daemon or an external scanning API. Until one is configured
+// Scan returns ErrNotConfigured and content is stored unscanned.
+package malware
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Verdict is a scanner's finding on some content.
+type Verdict struct {
+	Infected bool
+	// Signature names what was found, such as "Eicar-Signature".
+	Signature string
+}
+
+// Scanner inspects content for malware.
+type Scanner interface {
+	Scan(ctx context.Context, data []byte) (Verdict, error)
+}
+
+// ErrNotConfigured is returned by Scan when no scanner is set.
+var ErrNotConfigured = errors.New("no malware scanner is configured")
+
+var (
+	scannerMu sync.RWMutex
+	scanner   Scanner
+)
+
+// SetScanner replaces the scanner used by Scan. nil disables scanning.
+func SetScanner(s Scanner) {
+	scannerMu.Lock()
+	defer scannerMu.Unlock()
+	scanner = s
+}
+
+// Configured reports whether a scanner is set.
+func Configured() bool {
+	scannerMu.RLock()
+	defer scannerMu.RUnlock()
+	return scanner != nil
+}
+
+// Scan returns the configured scanner's verdict on data.
+func Scan(ctx context.Context, data []byte) (Verdict, error) {
+	scannerMu.RLock()
+	s := scanner
+	scannerMu.RUnlock()
+	if s == nil {
+		return Verdict{}, ErrNotConfigured
+	}
+	return s.Scan(ctx, data)
+}
+
+// clamChunk is the size of the chunks streamed to clamd.
+const clamChunk = 64 << 10
+
+// ClamAV streams content to a clamd daemon with the INSTREAM command.
+// Content larger than clamd's StreamMaxLength fails to scan.
+type ClamAV struct {
+	Network string // "unix" or "tcp"
+	Address string // such as /run/clamav/clamd.ctl or localhost:3310
+	Timeout time.Duration
+}
+
+// NewClamAV returns a scanner for the clamd at addr: a socket path if it
+// starts with "/", a host:port otherwise. Scans time out after a minute.
+func NewClamAV(addr string) ClamAV {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	return ClamAV{Network: network, Address: addr, Timeout: time.Minute}
+}
+
+func (c ClamAV) Scan(ctx context.Context, data []byte) (Verdict, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if c.Timeout > 0 && (!ok || time.Until(deadline) > c.Timeout) {
+		deadline, ok = time.Now().Add(c.Timeout), true
+	}
+	if ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var req bytes.Buffer
+	req.WriteString("zINSTREAM\x00")
+	for len(data) > 0 {
+		n := min(len(data), clamChunk)
+		binary.Write(&req, binary.BigEndian, uint32(n))
+		req.Write(data[:n])
+		data = data[n:]
+	}
+	binary.Write(&req, binary.BigEndian, uint32(0))
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	return parseClamReply(string(reply))
+}
+
+// parseClamReply reads clamd's answer to INSTREAM, such as "stream: OK" or
+// "stream: Eicar-Signature FOUND".
+func parseClamReply(reply string) (Verdict, error) {
+	reply = strings.TrimRight(reply, "\x00\n")
+	result, ok := strings.CutPrefix(reply, "stream: ")
+	switch {
+	case ok && result == "OK":
+		return Verdict{}, nil
+	case ok && strings.HasSuffix(result, " FOUND"):
+		return Verdict{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	default:
+		return Verdict{}, fmt.Errorf("clamd: %.200s", reply)
+	}
+}
+
+// HTTPScanner posts content to an external scanning API, which answers
+// 200 with {"infected": bool, "signature": "..."}.
+type HTTPScanner struct {
+	URL    string
+	Token  string // sent as a bearer token when set
+	Client *http.Client
+}
+
+// NewHTTPScanner returns a scanner for url with a one minute timeout.
+func NewHTTPScanner(url, token string) HTTPScanner {
+	return HTTPScanner{URL: url, Token: token, Client: &http.Client{Timeout: time.Minute}}
+}
+
+func (s HTTPScanner) Scan(ctx context.Context, data []byte) (Verdict, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return Verdict{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Verdict{}, fmt.Errorf("scanner answered %d: %.200s", resp.StatusCode, body)
+	}
+	var parsed struct {
+		Infected  *bool  `json:"infected"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil || parsed.Infected == nil {
+		return Verdict{}, fmt.Errorf("scanner answered without a verdict: %.200s", body)
+	}
+	return Verdict{Infected: *parsed.Infected, Signature: parsed.Signature}, nil
+}
+
+// Mock is a deterministic scanner for tests. Content containing Pattern
+// is infected with Signature; every scan fails with Err when it is set.
+type Mock struct {
+	Pattern   string
+	Signature string
+	Err       error
+}
+
+func (m Mock) Scan(ctx context.Context, data []byte) (Verdict, error) {
+	if m.Err != nil {
+		return Verdict{}, m.Err
+	}
+	if m.Pattern != "" && bytes.Contains(data, []byte(m.Pattern)) {
+		return Verdict{Infected: true, Signature: m.Signature}, nil
+	}
+	return Verdict{}, nil
+}
@@ -0,0 +1,134 @@
+package malware
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanWithoutScanner(t *testing.T) {
+	SetScanner(nil)
+	if _, err := Scan(context.Background(), []byte("x")); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Scan() error = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestMock(t *testing.T) {
+	SetScanner(Mock{Pattern: "EICAR", Signature: "Eicar-Test"})
+	defer SetScanner(nil)
+	if v, err := Scan(context.Background(), []byte("X5O EICAR test")); err != nil || !v.Infected || v.Signature != "Eicar-Test" {
+		t.Errorf("Scan(infected) = %+v, %v", v, err)
+	}
+	if v, err := Scan(context.Background(), []byte("print(1)")); err != nil || v.Infected {
+		t.Errorf("Scan(clean) = %+v, %v", v, err)
+	}
+}
+
+// fakeClamd answers INSTREAM on a unix socket with reply to the
+// reassembled stream.
+func fakeClamd(t *testing.T, reply func(data string) string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clamd.ctl")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			cmd := make([]byte, len("zINSTREAM\x00"))
+			io.ReadFull(conn, cmd)
+			var data strings.Builder
+			for {
+				var n uint32
+				if binary.Read(conn, binary.BigEndian, &n) != nil || n == 0 {
+					break
+				}
+				chunk := make([]byte, n)
+				io.ReadFull(conn, chunk)
+				data.Write(chunk)
+			}
+			if string(cmd) != "zINSTREAM\x00" {
+				conn.Write([]byte("UNKNOWN COMMAND\x00"))
+			} else {
+				conn.Write([]byte(reply(data.String()) + "\x00"))
+			}
+			conn.Close()
+		}
+	}()
+	return path
+}
+
+func TestClamAV(t *testing.T) {
+	path := fakeClamd(t, func(data string) string {
+		if strings.Contains(data, "EICAR") {
+			return "stream: Eicar-Signature FOUND"
+		}
+		return "stream: OK"
+	})
+	c := NewClamAV(path)
+	if c.Network != "unix" {
+		t.Fatalf("NewClamAV(%q).Network = %q", path, c.Network)
+	}
+
+	big := strings.Repeat("a", 3*clamChunk) + "EICAR"
+	if v, err := c.Scan(context.Background(), []byte(big)); err != nil || !v.Infected || v.Signature != "Eicar-Signature" {
+		t.Errorf("Scan(infected) = %+v, %v", v, err)
+	}
+	if v, err := c.Scan(context.Background(), []byte("print(1)")); err != nil || v.Infected {
+		t.Errorf("Scan(clean) = %+v, %v", v, err)
+	}
+	if NewClamAV("localhost:3310").Network != "tcp" {
+		t.Error("a host:port is not dialed over TCP")
+	}
+}
+
+func TestParseClamReply(t *testing.T) {
+	if _, err := parseClamReply("INSTREAM size limit exceeded. ERROR\x00"); err == nil {
+		t.Error("an error reply parsed as a verdict")
+	}
+}
+
+func TestHTTPScanner(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch string(body) {
+		case "bad":
+			w.Write([]byte(`{"infected": true, "signature": "Trojan.Test"}`))
+		case "odd":
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`{"infected": false}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := NewHTTPScanner(srv.URL, "t0ken")
+	if v, err := s.Scan(context.Background(), []byte("bad")); err != nil || !v.Infected || v.Signature != "Trojan.Test" {
+		t.Errorf("Scan(infected) = %+v, %v", v, err)
+	}
+	if v, err := s.Scan(context.Background(), []byte("fine")); err != nil || v.Infected {
+		t.Errorf("Scan(clean) = %+v, %v", v, err)
+	}
+	if _, err := s.Scan(context.Background(), []byte("odd")); err == nil {
+		t.Error("a reply without a verdict succeeded")
+	}
+	if _, err := NewHTTPScanner(srv.URL, "").Scan(context.Background(), []byte("x")); err == nil {
+		t.Error("a 401 succeeded")
+	}
+}
@@ -0,0 +1,452 @@
+// Package markdown renders the common subset of Markdown that published
+// pages are written in: ATX and setext headings, paragraphs, emphasis,
+// code spans and fenced code, links and images, block quotes, nested
+// lists and rules. Raw HTML in the source is escaped rather than passed
+// through; callers still sanitize the output. A leading frontmatter block
+// of "key: value" lines is split off with Frontmatter.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Frontmatter splits src into the key/value pairs of a leading block
+// fenced by "---" lines, keys lowercased and values unquoted, and the
+// document after it. Lines in the block that are not "key: value" are
+// ignored. Without a block the pairs are empty and src is returned whole.
+func Frontmatter(src string) (map[string]string, string) {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	meta := map[string]string{}
+	if !strings.HasPrefix(src, "---\n") {
+		return meta, src
+	}
+	block, body, ok := strings.Cut(src[len("---\n"):], "\n---")
+	if !ok {
+		return meta, src
+	}
+	// The closing fence must be a line of its own.
+	if rest, found := strings.CutPrefix(body, "\n"); found {
+		body = rest
+	} else if body != "" {
+		return meta, src
+	}
+	for _, line := range strings.Split(block, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !ok || key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		meta[key] = value
+	}
+	return meta, body
+}
+
+// ToHTML renders src as HTML.
+func ToHTML(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\t", "    ")
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), false)
+	return b.String()
+}
+
+var (
+	atxHeading  = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ ]+(.*?))?(?:[ ]+#+)?[ ]*$`)
+	rule        = regexp.MustCompile(`^ {0,3}(?:(?:-[ ]*){3,}|(?:\*[ ]*){3,}|(?:_[ ]*){3,})$`)
+	fence       = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})")
+	listMarker  = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])( +|$)`)
+	quoteMarker = regexp.MustCompile(`^ {0,3}> ?`)
+	setextLine  = regexp.MustCompile(`^ {0,3}(=+|-+)[ ]*$`)
+)
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// startsBlock reports whether line interrupts a paragraph.
+func startsBlock(line string) bool {
+	return atxHeading.MatchString(line) || rule.MatchString(line) || fence.MatchString(line) ||
+		quoteMarker.MatchString(line) || listMarker.MatchString(line) && !isBlank(listMarker.ReplaceAllString(line, ""))
+}
+
+// renderBlocks renders lines as block content. In a tight list item,
+// paragraphs are not wrapped in <p>.
+func renderBlocks(b *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case fence.MatchString(line):
+			m := fence.FindStringSubmatch(line)
+			indent, marker := len(m[1]), m[2]
+			i++
+			var code []string
+			for ; i < len(lines); i++ {
+				if t := strings.TrimSpace(lines[i]); strings.HasPrefix(t, marker) && strings.Trim(t, marker[:1]) == "" {
+					i++
+					break
+				}
+				code = append(code, trimIndent(lines[i], indent))
+			}
+			b.WriteString("<pre><code>")
+			if len(code) > 0 {
+				b.WriteString(html.EscapeString(strings.Join(code, "\n")) + "\n")
+			}
+			b.WriteString("</code></pre>\n")
+
+		case atxHeading.MatchString(line):
+			m := atxHeading.FindStringSubmatch(line)
+			level := string(rune('0' + len(m[1])))
+			b.WriteString("<h" + level + ">" + inline(strings.TrimSpace(m[2])) + "</h" + level + ">\n")
+			i++
+
+		case rule.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case quoteMarker.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && quoteMarker.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quoteMarker.ReplaceAllString(lines[i], ""))
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted, false)
+			b.WriteString("</blockquote>\n")
+
+		case listMarker.MatchString(line):
+			i = renderList(b, lines, i)
+
+		default:
+			// A paragraph runs to a blank line or the start of another
+			// block; a line of = or - under it makes it a heading.
+			para := []string{strings.TrimLeft(line, " ")}
+			i++
+			level := ""
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				if m := setextLine.FindStringSubmatch(lines[i]); m != nil {
+					level = "2"
+					if m[1][0] == '=' {
+						level = "1"
+					}
+					i++
+					break
+				}
+				if startsBlock(lines[i]) {
+					break
+				}
+				para = append(para, strings.TrimLeft(lines[i], " "))
+			}
+			text := inline(strings.TrimRight(strings.Join(para, "\n"), " "))
+			switch {
+			case level != "":
+				b.WriteString("<h" + level + ">" + text + "</h" + level + ">\n")
+			case tight:
+				b.WriteString(text + "\n")
+			default:
+				b.WriteString("<p>" + text + "</p>\n")
+			}
+		}
+	}
+}
+
+// renderList renders the list starting at lines[i] and returns the index
+// of the line after it. Items hold the lines indented under their marker,
+// which may contain further blocks and lists.
+func renderList(b *strings.Builder, lines []string, i int) int {
+	m := listMarker.FindStringSubmatch(lines[i])
+	ordered := m[2][0] >= '0' && m[2][0] <= '9'
+	delimiter := m[2][len(m[2])-1]
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+
+	var items [][]string
+	loose := false
+	for i < len(lines) {
+		m := listMarker.FindStringSubmatch(lines[i])
+		if m == nil || (m[2][0] >= '0' && m[2][0] <= '9') != ordered || m[2][len(m[2])-1] != delimiter {
+			break
+		}
+		width := len(m[0])
+		if m[3] == "" || len(m[3]) > 4 {
+			// Content indented by more than four spaces after the
+			// marker is code in CommonMark; keep it as text here.
+			width = len(m[1]) + len(m[2]) + 1
+		}
+		item := []string{strings.TrimLeft(lines[i][min(width, len(lines[i])):], " ")}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if isBlank(line) {
+				// A blank line continues the item only if indented
+				// content follows.
+				j := i
+				for j < len(lines) && isBlank(lines[j]) {
+					j++
+				}
+				if j < len(lines) && indentOf(lines[j]) >= width {
+					loose = true
+					for ; i < j; i++ {
+						item = append(item, "")
+					}
+					continue
+				}
+				break
+			}
+			if indentOf(line) >= width {
+				item = append(item, line[width:])
+			} else if !startsBlock(line) {
+				// A lazy continuation of the item's paragraph.
+				item = append(item, strings.TrimLeft(line, " "))
+			} else {
+				break
+			}
+			i++
+		}
+		items = append(items, item)
+
+		// Blank lines between items make the list loose.
+		j := i
+		for j < len(lines) && isBlank(lines[j]) {
+			j++
+		}
+		if j > i && j < len(lines) && listMarker.MatchString(lines[j]) && indentOf(lines[j]) < width {
+			loose = true
+			i = j
+		}
+	}
+
+	b.WriteString("<" + tag + ">\n")
+	for _, item := range items {
+		b.WriteString("<li>")
+		renderBlocks(b, item, !loose)
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func trimIndent(line string, n int) string {
+	return line[min(n, indentOf(line)):]
+}
+
+// asciiPunct may be escaped with a backslash.
+const asciiPunct = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// inline renders the inline markup of text.
+func inline(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+			continue
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(asciiPunct, text[i+1]) >= 0:
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+		case c == ' ' && strings.HasPrefix(text[i:], "  \n"):
+			b.WriteString("<br>\n")
+			i += 3
+			continue
+		case c == '`':
+			if end, code := codeSpan(text, i); end > 0 {
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i = end
+				continue
+			}
+		case c == '<':
+			if end := strings.IndexByte(text[i:], '>'); end > 0 {
+				target := text[i+1 : i+end]
+				if (strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")) && !strings.ContainsAny(target, " <") {
+					b.WriteString(`<a href="` + html.EscapeString(target) + `">` + html.EscapeString(target) + "</a>")
+					i += end + 1
+					continue
+				}
+			}
+		case c == '!' && strings.HasPrefix(text[i:], "!["):
+			if label, dest, end := link(text, i+1); end > 0 {
+				b.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(plain(label)) + `">`)
+				i = end
+				continue
+			}
+		case c == '[':
+			if label, dest, end := link(text, i); end > 0 {
+				b.WriteString(`<a href="` + html.EscapeString(dest) + `">` + inline(label) + "</a>")
+				i = end
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if tag, inner, end := emphasis(text, i); end > 0 {
+				b.WriteString("<" + tag + ">" + inline(inner) + "</" + tag + ">")
+				i = end
+				continue
+			}
+		}
+		b.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// codeSpan returns the end of the code span opening at text[i] and its
+// content, or 0 without a closing run of as many backticks.
+func codeSpan(text string, i int) (int, string) {
+	n := 0
+	for i+n < len(text) && text[i+n] == '`' {
+		n++
+	}
+	run := strings.Repeat("`", n)
+	for j := i + n; j < len(text); {
+		k := strings.Index(text[j:], run)
+		if k < 0 {
+			return 0, ""
+		}
+		k += j
+		if k+n < len(text) && text[k+n] == '`' {
+			// A longer run does not close this span.
+			for k < len(text) && text[k] == '`' {
+				k++
+			}
+			j = k
+			continue
+		}
+		code := strings.ReplaceAll(text[i+n:k], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+			code = code[1 : len(code)-1]
+		}
+		return k + n, code
+	}
+	return 0, ""
+}
+
+// link parses "[label](destination "title")" at text[i], returning the
+// end of it, or 0.
+func link(text string, i int) (label, dest string, end int) {
+	depth := 0
+	j := i
+	for ; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+			continue
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if j >= len(text) || j+1 >= len(text) || text[j+1] != '(' {
+		return "", "", 0
+	}
+	// The destination may hold balanced parentheses, as Wikipedia links do.
+	close, depth := -1, 0
+	for k := j + 2; k < len(text) && close < 0; k++ {
+		switch text[k] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				close = k - (j + 2)
+			}
+			depth--
+		}
+	}
+	if close < 0 {
+		return "", "", 0
+	}
+	target := strings.TrimSpace(text[j+2 : j+2+close])
+	if k := strings.IndexAny(target, " \n"); k >= 0 {
+		// Drop the title.
+		target = target[:k]
+	}
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	return text[i+1 : j], target, j + 3 + close
+}
+
+// emphasis parses emphasis, strong emphasis or strikethrough opening at
+// text[i], returning the tag, the text inside and the end, or 0.
+func emphasis(text string, i int) (tag, inner string, end int) {
+	c := text[i]
+	n := 1
+	if i+1 < len(text) && text[i+1] == c {
+		n = 2
+	}
+	if c == '~' && n != 2 {
+		return "", "", 0
+	}
+	start := i + n
+	if start >= len(text) || text[start] == ' ' || text[start] == '\n' {
+		return "", "", 0
+	}
+	// Underscores inside words, as in snake_case, are not emphasis.
+	if c == '_' && i > 0 && isWordChar(text[i-1]) {
+		return "", "", 0
+	}
+	for j := start + 1; j < len(text); j++ {
+		if text[j] != c {
+			continue
+		}
+		run := 1
+		for j+run < len(text) && text[j+run] == c {
+			run++
+		}
+		switch {
+		case text[j-1] == ' ' || text[j-1] == '\n':
+			// Opens something nested.
+			j += run - 1
+			continue
+		case run == n:
+		case run == 3:
+			// Closes emphasis inside strong emphasis, or the reverse,
+			// together with this one; ours is the outer, last part.
+			j += run - n
+		default:
+			// Closes something else.
+			j += run - 1
+			continue
+		}
+		if c == '_' && j+n < len(text) && isWordChar(text[j+n]) {
+			continue
+		}
+		switch {
+		case c == '~':
+			tag = "del"
+		case n == 2:
+			tag = "strong"
+		default:
+			tag = "em"
+		}
+		return tag, text[start:j], j + n
+	}
+	return "", "", 0
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+var tags = regexp.MustCompile(`<[^>]*>`)
+
+// plain returns the text of inline markup, for image descriptions.
+func plain(text string) string {
+	return html.UnescapeString(tags.ReplaceAllString(inline(text), ""))
+}
@@ -0,0 +1,58 @@
+package markdown
+
+import "testing"
+
+func TestFrontmatter(t *testing.T) {
+	meta, body := Frontmatter("---\r\nTitle: \"Hello: world\"\r\nslug: hello\r\n# a comment\r\nnot a pair\r\n---\r\n# Body\r\n")
+	if meta["title"] != "Hello: world" || meta["slug"] != "hello" || len(meta) != 2 {
+		t.Errorf("meta = %v", meta)
+	}
+	if body != "# Body\n" {
+		t.Errorf("body = %q", body)
+	}
+
+	for _, src := range []string{"# No frontmatter\n", "---\nunclosed: yes\n", "---\nslug: x\n----\n"} {
+		if meta, body := Frontmatter(src); len(meta) != 0 || body != src {
+			t.Errorf("Frontmatter(%q) = %v, %q", src, meta, body)
+		}
+	}
+}
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"paragraphs", "one\ntwo\n\nthree", "<p>one\ntwo</p>\n<p>three</p>\n"},
+		{"atx headings", "# One #\n###### Six", "<h1>One</h1>\n<h6>Six</h6>\n"},
+		{"setext headings", "Title\n=====\nSub\n---", "<h1>Title</h1>\n<h2>Sub</h2>\n"},
+		{"not a heading", "#hashtag", "<p>#hashtag</p>\n"},
+		{"rule", "a\n\n* * *\n", "<p>a</p>\n<hr>\n"},
+		{"emphasis", "*em* _em_ **strong** __strong__ ~~del~~", "<p><em>em</em> <em>em</em> <strong>strong</strong> <strong>strong</strong> <del>del</del></p>\n"},
+		{"nested emphasis", "**bold *and em***", "<p><strong>bold <em>and em</em></strong></p>\n"},
+		{"intraword underscores", "snake_case_name and 2 * 3 * 4", "<p>snake_case_name and 2 * 3 * 4</p>\n"},
+		{"code span", "use `a < b` or ``x ` y``", "<p>use <code>a &lt; b</code> or <code>x ` y</code></p>\n"},
+		{"escapes", `\*not em\* and a\\b`, `<p>*not em* and a\b</p>` + "\n"},
+		{"link", `[the *docs*](https://example.com/a?b=1&c=2 "Title")`, `<p><a href="https://example.com/a?b=1&amp;c=2">the <em>docs</em></a></p>` + "\n"},
+		{"image", `![a **cat**](/cat.png)`, `<p><img src="/cat.png" alt="a cat"></p>` + "\n"},
+		{"autolink", "<https://example.com>", `<p><a href="https://example.com">https://example.com</a></p>` + "\n"},
+		{"raw html escaped", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"hard breaks", "a  \nb\\\nc", "<p>a<br>\nb<br>\nc</p>\n"},
+		{"fenced code", "```python\nif a < b:\n    pass\n```\nafter", "<pre><code>if a &lt; b:\n    pass\n</code></pre>\n<p>after</p>\n"},
+		{"unclosed fence", "~~~\ncode", "<pre><code>code\n</code></pre>\n"},
+		{"block quote", "> quoted\n> # heading\n\nafter", "<blockquote>\n<p>quoted</p>\n<h1>heading</h1>\n</blockquote>\n<p>after</p>\n"},
+		{"tight list", "- one\n- two\n  continued\n- three", "<ul>\n<li>one\n</li>\n<li>two\ncontinued\n</li>\n<li>three\n</li>\n</ul>\n"},
+		{"loose list", "1. one\n\n2. two", "<ol>\n<li><p>one</p>\n</li>\n<li><p>two</p>\n</li>\n</ol>\n"},
+		{"nested list", "- a\n  - b\n- c", "<ul>\n<li>a\n<ul>\n<li>b\n</li>\n</ul>\n</li>\n<li>c\n</li>\n</ul>\n"},
+		{"list interrupts paragraph", "text\n- item", "<p>text</p>\n<ul>\n<li>item\n</li>\n</ul>\n"},
+		{"different markers split lists", "- a\n+ b", "<ul>\n<li>a\n</li>\n</ul>\n<ul>\n<li>b\n</li>\n</ul>\n"},
+		{"parentheses in link", "[Go](https://en.wikipedia.org/wiki/Go_(game)).", `<p><a href="https://en.wikipedia.org/wiki/Go_(game)">Go</a>.</p>` + "\n"},
+		{"emphasis in strong", "*a **b*** and ***c***", "<p><em>a <strong>b</strong></em> and <strong><em>c</em></strong></p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToHTML(tt.in); got != tt.want {
+				t.Errorf("ToHTML(%q) =\n%q\nwant\n%q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package metrics keeps histograms in memory and serves them at /metrics in
+// the Prometheus text format, for scrapers to collect.
+package metrics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Token is the bearer token scrapers must send. Handler serves nothing
+// while it is empty.
+var Token string
+
+// DefaultBuckets are the upper bounds, in seconds, of histograms timing
+// work that usually takes milliseconds.
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	registered   []*Histogram
+	registeredMu sync.Mutex
+)
+
+// Histogram counts observations in buckets, one series per combination of
+// label values.
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	labels  []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram called name with the given bucket
+// upper bounds, in increasing order, and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, labels: labels, series: map[string]*series{}}
+	registeredMu.Lock()
+	registered = append(registered, h)
+	registeredMu.Unlock()
+	return h
+}
+
+// Observe adds value to the series with the given label values, one per
+// label name.
+func (h *Histogram) Observe(value float64, labels ...string) {
+	key := strings.Join(labels, "\x00")
+	i := sort.SearchFloat64s(h.buckets, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &series{labels: labels, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[i]++
+	s.sum += value
+	s.count++
+}
+
+// Write writes the histogram in the Prometheus text format, series sorted
+// by their label values.
+func (h *Histogram) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, k := range keys {
+		s := h.series[k]
+		labels := h.labelPairs(s.labels)
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, braces(labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, braces(labels), s.count)
+	}
+}
+
+// labelPairs formats label values as `name="value",`, ready to have le
+// appended.
+func (h *Histogram) labelPairs(values []string) string {
+	var b strings.Builder
+	for i, name := range h.labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		fmt.Fprintf(&b, "%s=%s,", name, strconv.Quote(value))
+	}
+	return b.String()
+}
+
+func braces(pairs string) string {
+	if pairs == "" {
+		return ""
+	}
+	return "{" + strings.TrimSuffix(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Handler serves every registered histogram to requests bearing Token. It
+// answers 404 while no token is configured.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if Token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(Token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	registeredMu.Lock()
+	histograms := append([]*Histogram(nil), registered...)
+	registeredMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	for _, h := range histograms {
+		h.Write(w)
+	}
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistogramWrite(t *testing.T) {
+	h := &Histogram{name: "job_seconds", help: "Job time.", buckets: []float64{0.1, 1}, labels: []string{"job"}, series: map[string]*series{}}
+	h.Observe(0.05, "a")
+	h.Observe(0.1, "a")
+	h.Observe(3, "a")
+	h.Observe(0.5, `b"c`)
+
+	var b strings.Builder
+	h.Write(&b)
+	want := `# HELP job_seconds Job time.
+# TYPE job_seconds histogram
+job_seconds_bucket{job="a",le="0.1"} 2
+job_seconds_bucket{job="a",le="1"} 2
+job_seconds_bucket{job="a",le="+Inf"} 3
+job_seconds_sum{job="a"} 3.15
+job_seconds_count{job="a"} 3
+job_seconds_bucket{job="b\"c",le="0.1"} 0
+job_seconds_bucket{job="b\"c",le="1"} 1
+job_seconds_bucket{job="b\"c",le="+Inf"} 1
+job_seconds_sum{job="b\"c"} 0.5
+job_seconds_count{job="b\"c"} 1
+`
+	if b.String() != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	defer func(token string) { Token = token }(Token)
+	NewHistogram("test_handler_seconds", "Test.", DefaultBuckets).Observe(0.2)
+
+	serve := func(auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		Handler(rec, r)
+		return rec
+	}
+
+	Token = ""
+	if rec := serve("Bearer "); rec.Code != http.StatusNotFound {
+		t.Errorf("without a token configured: status %d", rec.Code)
+	}
+
+	Token = "scrape"
+	if rec := serve("Bearer wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d", rec.Code)
+	}
+	rec := serve("Bearer scrape")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "test_handler_seconds_count 1\n") {
+		t.Errorf("status %d, body:\n%s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// requestDuration times every HTTP response, by status class.
+var requestDuration = NewHistogram("http_request_duration_seconds",
+	"Time taken to answer HTTP requests, by status class.",
+	DefaultBuckets, "code")
+
+// recentSlots is how many minutes of request counts ErrorRate can look
+// back over.
+const recentSlots = 60
+
+// minuteCount counts the requests answered during one minute.
+type minuteCount struct {
+	minute   int64
+	requests int
+	errors   int
+}
+
+var (
+	recent   [recentSlots]minuteCount
+	recentMu sync.Mutex
+)
+
+// Requests times every request next answers and counts its server errors
+// for ErrorRate.
+func Requests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		requestDuration.Observe(time.Since(started).Seconds(), strconv.Itoa(sw.status/100)+"xx")
+		countRequest(started, sw.status >= 500)
+	})
+}
+
+func countRequest(at time.Time, failed bool) {
+	minute := at.Unix() / 60
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	slot := &recent[minute%recentSlots]
+	if slot.minute != minute {
+		*slot = minuteCount{minute: minute}
+	}
+	slot.requests++
+	if failed {
+		slot.errors++
+	}
+}
+
+// ErrorRate returns how many requests were answered over the last window,
+// up to an hour, and how many of them failed with a server error.
+func ErrorRate(window time.Duration) (requests, errors int) {
+	now := time.Now().Unix() / 60
+	oldest := now - int64(window/time.Minute)
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	for _, slot := range recent {
+		if slot.minute > oldest && slot.minute <= now {
+			requests += slot.requests
+			errors += slot.errors
+		}
+	}
+	return requests, errors
+}
+
+// statusWriter remembers the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps event streams working through the wrapper.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over for WebSocket upgrades, which look for
+// http.Hijacker on the writer itself. A hijacked request counts as 101.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	w.status, w.wroteHeader = http.StatusSwitchingProtocols, true
+	return h.Hijack()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestsCountsServerErrors(t *testing.T) {
+	before, beforeErrors := ErrorRate(time.Hour)
+	handler := Requests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	for _, path := range []string{"/", "/fail", "/missing", "/fail"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	requests, errors := ErrorRate(time.Hour)
+	if requests-before != 4 || errors-beforeErrors != 2 {
+		t.Errorf("ErrorRate() = %d requests, %d errors more, want 4 and 2", requests-before, errors-beforeErrors)
+	}
+}
+
+func TestErrorRateWindow(t *testing.T) {
+	recentMu.Lock()
+	saved := recent
+	recent = [recentSlots]minuteCount{}
+	recentMu.Unlock()
+	defer func() {
+		recentMu.Lock()
+		recent = saved
+		recentMu.Unlock()
+	}()
+
+	now := time.Now()
+	countRequest(now, true)
+	countRequest(now.Add(-10*time.Minute), false)
+	countRequest(now.Add(-90*time.Minute), true) // overwritten or too old
+
+	if requests, errors := ErrorRate(5 * time.Minute); requests != 1 || errors != 1 {
+		t.Errorf("last 5 minutes: %d requests, %d errors", requests, errors)
+	}
+	if requests, errors := ErrorRate(time.Hour); requests != 2 || errors != 1 {
+		t.Errorf("last hour: %d requests, %d errors", requests, errors)
+	}
+}
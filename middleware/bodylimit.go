@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"allanswebterminal/apierror"
+)
+
+// BodyLimits caps request body sizes. Routes are matched by the longest
+// registered path prefix; unmatched routes use Default.
+type BodyLimits struct {
+	Default int64
+	Routes  map[string]int64
+}
+
+// LimitFor returns the byte limit applied to path.
+func (l BodyLimits) LimitFor(path string) int64 {
+	limit := l.Default
+	longest := -1
+	for prefix, routeLimit := range l.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			limit = routeLimit
+			longest = len(prefix)
+		}
+	}
+	return limit
+}
+
+// LimitBody wraps request bodies in http.MaxBytesReader so JSON decoders
+// never read more than the route's limit. Requests that declare an oversize
+// Content-Length are rejected with 413 before the handler runs.
+func LimitBody(limits BodyLimits, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := limits.LimitFor(r.URL.Path)
+		if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > limit {
+			apierror.Write(w, apierror.PayloadTooLarge(limit))
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
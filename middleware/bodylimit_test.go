@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"allanswebterminal/apierror"
+)
+
+func testLimits() BodyLimits {
+	return BodyLimits{
+		Default: 100,
+		Routes: map[string]int64{
+			"/api/files/":     1000,
+			"/api/files/save": 2000,
+			"/api/login":      10,
+		},
+	}
+}
+
+func TestLimitFor(t *testing.T) {
+	limits := testLimits()
+	tests := []struct {
+		path string
+		want int64
+	}{
+		{"/api/files/save", 2000},
+		{"/api/files/load", 1000},
+		{"/api/login", 10},
+		{"/api/messages", 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := limits.LimitFor(tt.path); got != tt.want {
+				t.Errorf("LimitFor(%q) = %d, want %d", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitBodyRejectsDeclaredOversize(t *testing.T) {
+	called := false
+	handler := LimitBody(testLimits(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username":"someone"}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if called {
+		t.Error("handler should not run for oversize payloads")
+	}
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestLimitBodyStopsStreamingDecoder(t *testing.T) {
+	handler := LimitBody(testLimits(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			apierror.Write(w, apierror.DecodeError(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Unknown length bypasses the Content-Length check and must be caught
+	// by MaxBytesReader while decoding.
+	body := io.NopCloser(strings.NewReader(`{"message":"` + strings.Repeat("a", 500) + `"}`))
+	req := httptest.NewRequest("POST", "/api/messages", body)
+	req.ContentLength = -1
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestLimitBodyAllowsSmallPayloads(t *testing.T) {
+	handler := LimitBody(testLimits(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/files/save", strings.NewReader(`{"filename":"a.py"}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusNoContent)
+	}
+}
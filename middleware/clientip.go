@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustProxyHeaders makes ClientIP honour X-Forwarded-For and X-Real-IP. Only
+// enable it when the server sits behind a proxy that sets these headers.
+var TrustProxyHeaders bool
+
+// TrustedProxyHops is how many proxies in front of the server append to
+// X-Forwarded-For. Entries to the left of theirs were sent by the client
+// and can be forged, so ClientIP takes the entry the outermost proxy added.
+var TrustedProxyHops = 1
+
+// ClientIP returns the address of the client that sent r.
+func ClientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			entries := strings.Split(strings.Join(forwarded, ","), ",")
+			i := max(len(entries)-max(TrustedProxyHops, 1), 0)
+			if ip := net.ParseIP(strings.TrimSpace(entries[i])); ip != nil {
+				return ip.String()
+			}
+		}
+		if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+			return realIP.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
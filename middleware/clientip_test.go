@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func() { TrustProxyHeaders, TrustedProxyHops = false, 1 }()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.5:51234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	TrustProxyHeaders = false
+	if got := ClientIP(req); got != "10.0.0.5" {
+		t.Errorf("ClientIP without trusted proxy = %q, want 10.0.0.5", got)
+	}
+
+	TrustProxyHeaders = true
+	if got := ClientIP(req); got != "10.0.0.1" {
+		t.Errorf("ClientIP with trusted proxy = %q, want 10.0.0.1", got)
+	}
+
+	// The client sent the leftmost entries; the proxy appended its peer.
+	req.Header.Set("X-Forwarded-For", "198.51.100.99, 203.0.113.7")
+	if got := ClientIP(req); got != "203.0.113.7" {
+		t.Errorf("ClientIP with a forged entry = %q, want 203.0.113.7", got)
+	}
+
+	TrustedProxyHops = 2
+	req.Header.Set("X-Forwarded-For", "198.51.100.99, 203.0.113.7, 10.0.0.1")
+	if got := ClientIP(req); got != "203.0.113.7" {
+		t.Errorf("ClientIP behind two proxies = %q, want 203.0.113.7", got)
+	}
+	TrustedProxyHops = 1
+
+	req.Header.Set("X-Forwarded-For", "not-an-ip")
+	req.Header.Set("X-Real-IP", "198.51.100.2")
+	if got := ClientIP(req); got != "198.51.100.2" {
+		t.Errorf("ClientIP with X-Real-IP = %q, want 198.51.100.2", got)
+	}
+}
@@ -0,0 +1,345 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces secrets and personal data in captured requests.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeyParts mark JSON fields, form fields, query parameters and
+// headers whose values are never captured.
+var sensitiveKeyParts = []string{
+	"password", "passwd", "secret", "token", "authorization", "cookie",
+	"session", "api_key", "apikey", "api-key", "credential", "private_key",
+	"access_key", "signature", "otp", "totp", "csrf",
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@([A-Za-z0-9\-]+\.)+[A-Za-z]{2,}`)
+	// secretPairPattern finds "key": "value" and key=value pairs of sensitive
+	// keys in bodies that are neither JSON nor a form.
+	secretPairPattern = regexp.MustCompile(`(?i)("?[a-z_\-]*(?:` + strings.Join(sensitiveAlternatives(), "|") + `)[a-z_\-]*"?\s*[:=]\s*)("[^"]*"|[^\s&,}]+)`)
+	bearerPattern     = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/\-]+=*`)
+)
+
+func sensitiveAlternatives() []string {
+	parts := make([]string, len(sensitiveKeyParts))
+	for i, part := range sensitiveKeyParts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return parts
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// DebugEntry is one captured request and its response.
+type DebugEntry struct {
+	ID              int64               `json:"id"`
+	Time            time.Time           `json:"time"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           string              `json:"query,omitempty"`
+	Status          int                 `json:"status"`
+	DurationMS      float64             `json:"duration_ms"`
+	RequestHeaders  map[string][]string `json:"request_headers"`
+	RequestBody     string              `json:"request_body,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	ResponseBody    string              `json:"response_body,omitempty"`
+	// Truncated marks bodies cut at the capture limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// DebugLog keeps the last requests to selected routes in a ring buffer, with
+// secrets and email addresses redacted, for admins to inspect. It is off
+// unless routes are configured, since bodies can hold personal data that
+// redaction does not recognise.
+type DebugLog struct {
+	prefixes []string
+	maxBody  int
+
+	mu      sync.Mutex
+	entries []DebugEntry
+	next    int
+	full    bool
+	lastID  int64
+}
+
+// NewDebugLog captures up to size requests whose paths start with one of
+// prefixes, keeping at most maxBody bytes of each body.
+func NewDebugLog(size int, prefixes []string, maxBody int) *DebugLog {
+	if size < 1 {
+		size = 1
+	}
+	return &DebugLog{prefixes: prefixes, maxBody: maxBody, entries: make([]DebugEntry, size)}
+}
+
+// Prefixes returns the captured route prefixes.
+func (d *DebugLog) Prefixes() []string {
+	return d.prefixes
+}
+
+// Entries returns the captured requests, newest first.
+func (d *DebugLog) Entries() []DebugEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := d.next
+	if d.full {
+		n = len(d.entries)
+	}
+	out := make([]DebugEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, d.entries[(d.next-i+len(d.entries))%len(d.entries)])
+	}
+	return out
+}
+
+// Clear drops every captured request.
+func (d *DebugLog) Clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.entries {
+		d.entries[i] = DebugEntry{}
+	}
+	d.next, d.full = 0, false
+}
+
+func (d *DebugLog) add(entry DebugEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastID++
+	entry.ID = d.lastID
+	d.entries[d.next] = entry
+	d.next = (d.next + 1) % len(d.entries)
+	if d.next == 0 {
+		d.full = true
+	}
+}
+
+// captures reports whether requests to path are captured. Admin APIs never
+// are, so the log does not capture itself being read.
+func (d *DebugLog) captures(path string) bool {
+	if strings.HasPrefix(path, "/api/admin/") {
+		return false
+	}
+	for _, prefix := range d.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware captures requests to the configured routes. WebSocket upgrades
+// are passed through untouched.
+func (d *DebugLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.captures(r.URL.Path) || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		entry := DebugEntry{
+			Time:           start.UTC(),
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			Query:          redactQuery(r.URL.RawQuery),
+			RequestHeaders: redactHeaders(r.Header),
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			// The handler reads the captured prefix and then the rest.
+			raw, err := io.ReadAll(io.LimitReader(r.Body, int64(d.maxBody)+1))
+			logged := raw
+			if len(raw) > d.maxBody {
+				logged, entry.Truncated = raw[:d.maxBody], true
+			}
+			entry.RequestBody = RedactBody(r.Header.Get("Content-Type"), logged)
+			var rest io.Reader = r.Body
+			if err != nil {
+				rest = errReader{err}
+			}
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(raw), rest), r.Body}
+		}
+
+		rec := &captureWriter{ResponseWriter: w, max: d.maxBody}
+		next.ServeHTTP(rec, r)
+
+		entry.Status = rec.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		entry.ResponseHeaders = redactHeaders(w.Header())
+		entry.ResponseBody = RedactBody(w.Header().Get("Content-Type"), rec.body.Bytes())
+		entry.Truncated = entry.Truncated || rec.truncated
+		d.add(entry)
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// captureWriter keeps the first max bytes of a response. It forwards
+// flushes, so streamed responses still stream.
+type captureWriter struct {
+	http.ResponseWriter
+	max       int
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if room := c.max - c.body.Len(); room > 0 {
+		if len(p) > room {
+			c.body.Write(p[:room])
+			c.truncated = true
+		} else {
+			c.body.Write(p)
+		}
+	} else if len(p) > 0 {
+		c.truncated = true
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *captureWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *captureWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func redactHeaders(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for key, values := range h {
+		if isSensitiveKey(key) {
+			out[key] = []string{Redacted}
+			continue
+		}
+		out[key] = append([]string(nil), values...)
+	}
+	return out
+}
+
+func redactQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return redactText(raw)
+	}
+	return redactValues(values).Encode()
+}
+
+func redactValues(values url.Values) url.Values {
+	for key, vs := range values {
+		for i := range vs {
+			if isSensitiveKey(key) {
+				vs[i] = Redacted
+			} else {
+				vs[i] = redactText(vs[i])
+			}
+		}
+	}
+	return values
+}
+
+// RedactBody returns body as text with secrets and email addresses replaced
+// by Redacted. JSON and form bodies are redacted field by field; anything
+// else by pattern. Binary bodies are summarised by size.
+func RedactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(contentType, "application/json") || contentType == "" && json.Valid(body):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			redacted, _ := json.Marshal(redactJSON(v))
+			return string(redacted)
+		}
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return redactValues(values).Encode()
+		}
+	}
+	if !isText(contentType, body) {
+		return "[" + strconv.Itoa(len(body)) + " bytes of " + contentType + "]"
+	}
+	return redactText(string(body))
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if isSensitiveKey(key) {
+				v[key] = Redacted
+			} else {
+				v[key] = redactJSON(value)
+			}
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = redactJSON(v[i])
+		}
+		return v
+	case string:
+		return redactText(v)
+	}
+	return v
+}
+
+func redactText(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+Redacted)
+	s = secretPairPattern.ReplaceAllString(s, "${1}"+Redacted)
+	return emailPattern.ReplaceAllString(s, Redacted)
+}
+
+func isText(contentType string, body []byte) bool {
+	if strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "json") ||
+		strings.Contains(contentType, "xml") || strings.Contains(contentType, "javascript") {
+		return true
+	}
+	return contentType == "" && !bytes.ContainsRune(body, 0) && strings.ToValidUTF8(string(body), "") == string(body)
+}
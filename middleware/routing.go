@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"allanswebterminal/apierror"
+)
+
+// APIRouteErrors serves mux, answering unmatched /api/ requests with the
+// JSON error envelope instead of the mux's plain-text 404 and 405. Handlers
+// registered with method patterns ("GET /api/...") therefore never see a
+// method they were not registered for.
+func APIRouteErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// The mux's own not-found and method-not-allowed handlers only set
+		// headers and a status, so running them into a recorder is cheap.
+		rec := &statusRecorder{header: make(http.Header)}
+		h.ServeHTTP(rec, r)
+		if rec.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", rec.header.Get("Allow"))
+			apierror.Write(w, apierror.MethodNotAllowed())
+			return
+		}
+		apierror.Write(w, apierror.NotFound("No such API endpoint"))
+	})
+}
+
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (r *statusRecorder) Header() http.Header { return r.header }
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
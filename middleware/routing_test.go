@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"allanswebterminal/apierror"
+)
+
+func TestAPIRouteErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/things", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("GET /page", func(w http.ResponseWriter, r *http.Request) {})
+	handler := APIRouteErrors(mux)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantJSON   bool
+	}{
+		{"Matched route", http.MethodGet, "/api/things", http.StatusOK, false},
+		{"Wrong method", http.MethodPost, "/api/things", http.StatusMethodNotAllowed, true},
+		{"Unknown API path", http.MethodGet, "/api/nope", http.StatusNotFound, true},
+		{"Non-API errors are left alone", http.MethodPost, "/page", http.StatusMethodNotAllowed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var env apierror.Envelope
+			isJSON := json.Unmarshal(rec.Body.Bytes(), &env) == nil && env.Error != nil
+			if isJSON != tt.wantJSON {
+				t.Errorf("JSON envelope = %v, body %q", isJSON, rec.Body.String())
+			}
+			if tt.wantStatus == http.StatusMethodNotAllowed && rec.Header().Get("Allow") == "" {
+				t.Error("missing Allow header")
+			}
+		})
+	}
+}
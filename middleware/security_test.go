@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        SecurityHeaders
+		wantHSTS   string
+		wantHeader string
+	}{
+		{"Plain HTTP", SecurityHeaders{}, "", "Content-Security-Policy"},
+		{"TLS", SecurityHeaders{HSTS: true}, "max-age=31536000; includeSubDomains", "Content-Security-Policy"},
+		{"Report only", SecurityHeaders{ReportOnly: true}, "", "Content-Security-Policy-Report-Only"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seenNonce string
+			handler := SecureHeaders(tt.cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seenNonce = Nonce(r)
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			csp := rec.Header().Get(tt.wantHeader)
+			if seenNonce == "" || !strings.Contains(csp, "'nonce-"+seenNonce+"'") {
+				t.Errorf("policy %q does not carry request nonce %q", csp, seenNonce)
+			}
+			if got := rec.Header().Get("Strict-Transport-Security"); got != tt.wantHSTS {
+				t.Errorf("HSTS = %q, want %q", got, tt.wantHSTS)
+			}
+			for header, want := range map[string]string{
+				"X-Frame-Options":        "DENY",
+				"X-Content-Type-Options": "nosniff",
+				"Referrer-Policy":        "strict-origin-when-cross-origin",
+			} {
+				if got := rec.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNonceIsPerRequest(t *testing.T) {
+	var nonces []string
+	handler := SecureHeaders(SecurityHeaders{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, Nonce(r))
+	}))
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if nonces[0] == nonces[1] {
+		t.Error("expected a fresh nonce per request")
+	}
+	if Nonce(httptest.NewRequest(http.MethodGet, "/", nil)) != "" {
+		t.Error("expected empty nonce outside the middleware")
+	}
+}
@@ -0,0 +1,87 @@
+// Package ocr reads text from images through a pluggable Provider. Until
+// one is configured Recognize returns ErrNotConfigured, and features built
+// on it answer 503.
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Provider recognizes the text in a PNG, JPEG or GIF image.
+type Provider interface {
+	Recognize(ctx context.Context, image []byte) (string, error)
+}
+
+// ErrNotConfigured is returned by Recognize when no provider is set.
+var ErrNotConfigured = errors.New("no OCR provider is configured")
+
+var (
+	providerMu sync.RWMutex
+	provider   Provider
+)
+
+// SetProvider replaces the provider used by Recognize. nil disables it.
+func SetProvider(p Provider) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	provider = p
+}
+
+// Configured reports whether a provider is set.
+func Configured() bool {
+	providerMu.RLock()
+	defer providerMu.RUnlock()
+	return provider != nil
+}
+
+// Recognize returns the text the configured provider reads in image.
+func Recognize(ctx context.Context, image []byte) (string, error) {
+	providerMu.RLock()
+	p := provider
+	providerMu.RUnlock()
+	if p == nil {
+		return "", ErrNotConfigured
+	}
+	return p.Recognize(ctx, image)
+}
+
+// Tesseract runs the tesseract command, which reads printed text well and
+// handwriting passably.
+type Tesseract struct {
+	Path string // the tesseract binary
+	// Languages are tesseract language codes joined by "+", such as
+	// "eng+por". Empty means tesseract's default.
+	Languages string
+}
+
+func (t Tesseract) Recognize(ctx context.Context, image []byte) (string, error) {
+	args := []string{"stdin", "stdout"}
+	if t.Languages != "" {
+		args = append(args, "-l", t.Languages)
+	}
+	cmd := exec.CommandContext(ctx, t.Path, args...)
+	cmd.Stdin = bytes.NewReader(image)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract: %w: %.200s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Mock is a deterministic provider for tests. It reads Text from every
+// image, or fails with Err.
+type Mock struct {
+	Text string
+	Err  error
+}
+
+func (m Mock) Recognize(ctx context.Context, image []byte) (string, error) {
+	return m.Text, m.Err
+}
@@ -0,0 +1,43 @@
+package ocr
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecognizeWithoutProvider(t *testing.T) {
+	SetProvider(nil)
+	if _, err := Recognize(context.Background(), []byte("img")); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Recognize() error = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestMock(t *testing.T) {
+	SetProvider(Mock{Text: "Q: a\nA: b"})
+	defer SetProvider(nil)
+	if text, err := Recognize(context.Background(), nil); err != nil || text != "Q: a\nA: b" {
+		t.Errorf("Recognize() = %q, %v", text, err)
+	}
+}
+
+func TestTesseract(t *testing.T) {
+	// A stand-in binary that echoes its arguments and input.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tesseract")
+	script := "#!/bin/sh\necho \"$@\"\ncat\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := Tesseract{Path: path, Languages: "eng+por"}.Recognize(context.Background(), []byte("pixels"))
+	if err != nil || text != "stdin stdout -l eng+por\npixels" {
+		t.Errorf("Recognize() = %q, %v", text, err)
+	}
+
+	if _, err := (Tesseract{Path: filepath.Join(dir, "missing")}).Recognize(context.Background(), nil); err == nil {
+		t.Error("Recognize() with a missing binary succeeded")
+	}
+}
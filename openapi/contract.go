@@ -0,0 +1,265 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FixtureTime is the date-time fixtures use.
+var FixtureTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// Fixture returns an example JSON value for s: every property filled in,
+// one item per array, 1 for numbers, true for booleans and "example" for
+// strings. A component that contains itself is cut off with null.
+func (d *Document) Fixture(s *Schema) interface{} {
+	return d.fixture(s, map[string]bool{})
+}
+
+func (d *Document) fixture(s *Schema, open map[string]bool) interface{} {
+	switch {
+	case s == nil:
+		return nil
+	case s.Ref != "":
+		name := s.RefName()
+		if open[name] || d.Components.Schemas[name] == nil {
+			return nil
+		}
+		open[name] = true
+		defer delete(open, name)
+		return d.fixture(d.Components.Schemas[name], open)
+	case len(s.AllOf) > 0:
+		return d.fixture(s.AllOf[0], open)
+	}
+
+	switch s.Type {
+	case "boolean":
+		return true
+	case "integer", "number":
+		return 1
+	case "string":
+		switch s.Format {
+		case "date-time":
+			return FixtureTime.Format(time.RFC3339)
+		case "byte":
+			return base64.StdEncoding.EncodeToString([]byte("example"))
+		}
+		return "example"
+	case "array":
+		return []interface{}{d.fixture(s.Items, open)}
+	case "object":
+		obj := make(map[string]interface{})
+		for name, prop := range s.Properties {
+			obj[name] = d.fixture(prop, open)
+		}
+		if s.AdditionalProperties != nil {
+			obj["example"] = d.fixture(s.AdditionalProperties, open)
+		}
+		return obj
+	}
+	return nil
+}
+
+// Request returns a request for op built from fixtures: every path
+// parameter, the required query parameters and, when op takes one, a JSON
+// body.
+func (d *Document) Request(op *Operation) (*http.Request, error) {
+	path := op.Path
+	query := url.Values{}
+	for _, p := range op.Parameters {
+		value := fmt.Sprint(d.Fixture(p.Schema))
+		switch {
+		case p.In == "path":
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", url.PathEscape(value))
+		case p.In == "query" && p.Required:
+			query.Set(p.Name, value)
+		}
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	schema := op.body()
+	if schema != nil {
+		data, err := json.Marshal(d.Fixture(schema))
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(op.Method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if schema != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// CheckResponse reports how a response to op departs from the document.
+// The documented success status must carry its documented body; error
+// statuses (4xx and 5xx) must carry the apierror envelope. Any other
+// status is undocumented.
+func (d *Document) CheckResponse(op *Operation, status int, header http.Header, body []byte) error {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch {
+	case status >= 400:
+		if mediaType != "application/json" {
+			return fmt.Errorf("error %d is %q, not the JSON error envelope: %.100s", status, mediaType, body)
+		}
+		return d.checkJSON(&Schema{Ref: ref("ApiErrorEnvelope")}, body)
+	case status != op.Status:
+		return fmt.Errorf("status %d is not documented; the operation answers %d", status, op.Status)
+	case op.Media != "":
+		if mediaType != op.Media {
+			return fmt.Errorf("content type %q, documented %q", mediaType, op.Media)
+		}
+		return nil
+	}
+
+	schema := op.response()
+	if schema == nil {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return fmt.Errorf("status %d has a body, documented none: %.100s", status, body)
+		}
+		return nil
+	}
+	if mediaType != "application/json" {
+		return fmt.Errorf("content type %q, documented application/json", mediaType)
+	}
+	return d.checkJSON(schema, body)
+}
+
+func (d *Document) checkJSON(s *Schema, body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("body is not JSON: %v", err)
+	}
+	return d.Check(s, value)
+}
+
+// Check reports the first way value, as decoded by encoding/json with
+// UseNumber, does not match s. Properties not in the schema are errors, so
+// a field added to a handler's response without its documented type is
+// caught.
+func (d *Document) Check(s *Schema, value interface{}) error {
+	return d.check(s, value, "$")
+}
+
+func (d *Document) check(s *Schema, value interface{}, at string) error {
+	if s.Ref != "" {
+		component := d.Components.Schemas[s.RefName()]
+		if component == nil {
+			return fmt.Errorf("%s: unknown component %q", at, s.RefName())
+		}
+		return d.check(component, value, at)
+	}
+	if value == nil {
+		// encoding/json writes nil slices and maps as null.
+		if s.Nullable || s.Type == "" || s.Type == "array" || (s.Type == "object" && s.Properties == nil) {
+			return nil
+		}
+		return fmt.Errorf("%s: null, want %s", at, s.Type)
+	}
+	for _, sub := range s.AllOf {
+		if err := d.check(sub, value, at); err != nil {
+			return err
+		}
+	}
+
+	switch s.Type {
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return mismatch(at, s, value)
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return mismatch(at, s, value)
+		}
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("%s: %s is not an integer", at, n)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return mismatch(at, s, value)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return mismatch(at, s, value)
+		}
+		switch s.Format {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, str)
+			}
+		case "byte":
+			if _, err := base64.StdEncoding.DecodeString(str); err != nil {
+				return fmt.Errorf("%s: %q is not base64", at, str)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return mismatch(at, s, value)
+		}
+		for i, item := range items {
+			if err := d.check(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch(at, s, value)
+		}
+		return d.checkObject(s, obj, at)
+	}
+	return nil
+}
+
+func (d *Document) checkObject(s *Schema, obj map[string]interface{}, at string) error {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: required property %q missing", at, name)
+		}
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop := s.Properties[name]
+		if prop == nil {
+			prop = s.AdditionalProperties
+		}
+		if prop == nil {
+			if s.Properties == nil {
+				continue
+			}
+			return fmt.Errorf("%s: property %q is not documented", at, name)
+		}
+		if err := d.check(prop, obj[name], at+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mismatch(at string, s *Schema, value interface{}) error {
+	return fmt.Errorf("%s: %T, want %s", at, value, s.Type)
+}
@@ -0,0 +1,320 @@
+package openapi
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// goInitialisms are written in upper case in generated identifiers.
+var goInitialisms = map[string]bool{
+	"Api": true, "Arn": true, "Csv": true, "Http": true, "Iam": true, "Id": true,
+	"Ids": true, "Json": true, "Mfa": true, "Url": true,
+}
+
+// Go generates a Go client package named pkg for doc: a struct per
+// component and a Client with one method per operation.
+func Go(doc *Document, pkg string) ([]byte, error) {
+	g := &goGen{imports: map[string]bool{
+		"bytes": true, "context": true, "encoding/json": true, "fmt": true,
+		"io": true, "net/http": true, "net/url": true, "strings": true,
+	}}
+
+	var types strings.Builder
+	for _, name := range doc.componentNames() {
+		g.writeStruct(&types, goIdent(name), doc.Components.Schemas[name])
+	}
+	var methods strings.Builder
+	for _, op := range doc.clientOperations() {
+		if s := op.body(); inline(s) {
+			g.writeStruct(&types, pascal(op.OperationID)+"Request", s)
+		}
+		if s := op.response(); inline(s) {
+			g.writeStruct(&types, pascal(op.OperationID)+"Response", s)
+		}
+		g.writeMethod(&types, &methods, op)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated from the %s OpenAPI document, version %s. DO NOT EDIT.\n\n", doc.Info.Title, doc.Info.Version)
+	fmt.Fprintf(&b, "// Package %s is a client for the %s API.\npackage %s\n\nimport (\n", pkg, doc.Info.Title, pkg)
+	for _, imp := range []string{"bytes", "context", "encoding/json", "fmt", "io", "net/http", "net/url", "strconv", "strings", "time"} {
+		if g.imports[imp] {
+			fmt.Fprintf(&b, "\t%q\n", imp)
+		}
+	}
+	b.WriteString(")\n\n")
+	b.WriteString(goRuntime)
+	b.WriteString(types.String())
+	b.WriteString(methods.String())
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated Go client: %w", err)
+	}
+	return src, nil
+}
+
+type goGen struct {
+	imports map[string]bool
+}
+
+func (g *goGen) writeStruct(b *strings.Builder, name string, s *Schema) {
+	fmt.Fprintf(b, "type %s %s\n\n", name, g.structType(s))
+}
+
+func (g *goGen) structType(s *Schema) string {
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	for _, prop := range s.Order {
+		tag := prop
+		if !s.IsRequired(prop) {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&b, "\t%s %s `json:%q`\n", goIdent(prop), g.goType(s.Properties[prop]), tag)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func (g *goGen) goType(s *Schema) string {
+	var t string
+	switch {
+	case s.Ref != "":
+		t = goIdent(s.RefName())
+	case len(s.AllOf) == 1:
+		t = g.goType(s.AllOf[0])
+	case s.Type == "string" && s.Format == "date-time":
+		g.imports["time"] = true
+		t = "time.Time"
+	case s.Type == "string" && s.Format == "byte":
+		return "[]byte"
+	case s.Type == "string":
+		t = "string"
+	case s.Type == "integer":
+		t = "int"
+	case s.Type == "number":
+		t = "float64"
+	case s.Type == "boolean":
+		t = "bool"
+	case s.Type == "array":
+		return "[]" + g.goType(s.Items)
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		return "map[string]" + g.goType(s.AdditionalProperties)
+	case s.Type == "object":
+		t = g.structType(s)
+	default:
+		return "json.RawMessage"
+	}
+	if s.Nullable {
+		return "*" + t
+	}
+	return t
+}
+
+func (g *goGen) writeMethod(types, b *strings.Builder, op *Operation) {
+	name := pascal(op.OperationID)
+	args := []string{"ctx context.Context"}
+
+	var path []string
+	rest := op.Path
+	for _, p := range op.params("path") {
+		before, after, _ := strings.Cut(rest, "{"+p.Name+"}")
+		path = append(path, fmt.Sprintf("%q", before), g.pathValue(p))
+		rest = after
+		args = append(args, fmt.Sprintf("%s %s", goArg(p.Name), g.goType(p.Schema)))
+	}
+	if rest != "" || len(path) == 0 {
+		path = append(path, fmt.Sprintf("%q", rest))
+	}
+
+	body := "nil"
+	if s := op.body(); s != nil {
+		t := g.goType(s)
+		if inline(s) {
+			t = name + "Request"
+		}
+		args = append(args, "body "+t)
+		body = "body"
+	}
+
+	query := "nil"
+	if params := op.params("query"); len(params) > 0 {
+		fmt.Fprintf(types, "// %sParams are the query parameters of %s. Zero values are omitted.\ntype %sParams struct {\n", name, name, name)
+		for _, p := range params {
+			fmt.Fprintf(types, "\t%s %s\n", goIdent(p.Name), g.goType(p.Schema))
+		}
+		types.WriteString("}\n\n")
+		args = append(args, "params "+name+"Params")
+		query = "q"
+	}
+
+	result := ""
+	if s := op.response(); s != nil {
+		result = g.goType(s)
+		if inline(s) {
+			result = name + "Response"
+		}
+	}
+
+	if op.Summary != "" {
+		fmt.Fprintf(b, "// %s: %s\n", name, op.Summary)
+	}
+	if result == "" {
+		fmt.Fprintf(b, "func (c *Client) %s(%s) error {\n", name, strings.Join(args, ", "))
+	} else {
+		fmt.Fprintf(b, "func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(args, ", "), result)
+	}
+	if query != "nil" {
+		b.WriteString("\tq := url.Values{}\n")
+		for _, p := range op.params("query") {
+			g.writeQuerySet(b, p)
+		}
+	}
+	if result == "" {
+		fmt.Fprintf(b, "\treturn c.do(ctx, %q, %s, %s, %s, nil)\n}\n\n", op.Method, strings.Join(path, " + "), query, body)
+		return
+	}
+	fmt.Fprintf(b, "\tvar out %s\n\terr := c.do(ctx, %q, %s, %s, %s, &out)\n\treturn out, err\n}\n\n",
+		result, op.Method, strings.Join(path, " + "), query, body)
+}
+
+func (g *goGen) pathValue(p Parameter) string {
+	switch p.Schema.Type {
+	case "integer":
+		g.imports["strconv"] = true
+		return "strconv.Itoa(" + goArg(p.Name) + ")"
+	case "string":
+		return "url.PathEscape(" + goArg(p.Name) + ")"
+	}
+	return "url.PathEscape(fmt.Sprint(" + goArg(p.Name) + "))"
+}
+
+func (g *goGen) writeQuerySet(b *strings.Builder, p Parameter) {
+	field := "params." + goIdent(p.Name)
+	switch p.Schema.Type {
+	case "integer":
+		g.imports["strconv"] = true
+		fmt.Fprintf(b, "\tif %s != 0 {\n\t\tq.Set(%q, strconv.Itoa(%s))\n\t}\n", field, p.Name, field)
+	case "number":
+		g.imports["strconv"] = true
+		fmt.Fprintf(b, "\tif %s != 0 {\n\t\tq.Set(%q, strconv.FormatFloat(%s, 'g', -1, 64))\n\t}\n", field, p.Name, field)
+	case "boolean":
+		fmt.Fprintf(b, "\tif %s {\n\t\tq.Set(%q, \"true\")\n\t}\n", field, p.Name)
+	default:
+		fmt.Fprintf(b, "\tif %s != \"\" {\n\t\tq.Set(%q, %s)\n\t}\n", field, p.Name, field)
+	}
+}
+
+// goIdent turns a JSON or component name into an exported Go identifier.
+func goIdent(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == ' ' }) {
+		word = pascal(word)
+		if goInitialisms[word] {
+			word = strings.ToUpper(word)
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
+
+// goArg turns a path parameter name into an unexported Go identifier.
+func goArg(name string) string {
+	id := goIdent(name)
+	if id == strings.ToUpper(id) {
+		return strings.ToLower(id)
+	}
+	return strings.ToLower(id[:1]) + id[1:]
+}
+
+const goRuntime = `// Client calls the API. Set Header to authenticate, e.g. with the session
+// cookie of a signed-in account.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Header     http.Header
+}
+
+// NewClient returns a client for the server at baseURL, e.g.
+// "https://example.com".
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		Header:     make(http.Header),
+	}
+}
+
+// ResponseError is returned for every non-2xx response, with the server's
+// error code.
+type ResponseError struct {
+	Status  int
+	Code    string
+	Message string
+	Details json.RawMessage
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respErr := &ResponseError{Status: resp.StatusCode, Code: "http_error", Message: resp.Status}
+		var envelope struct {
+			Error *struct {
+				Code    string          ` + "`json:\"code\"`" + `
+				Message string          ` + "`json:\"message\"`" + `
+				Details json.RawMessage ` + "`json:\"details\"`" + `
+			} ` + "`json:\"error\"`" + `
+		}
+		if json.NewDecoder(resp.Body).Decode(&envelope) == nil && envelope.Error != nil {
+			respErr.Code = envelope.Error.Code
+			respErr.Message = envelope.Error.Message
+			respErr.Details = envelope.Error.Details
+		}
+		return respErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+`
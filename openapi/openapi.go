@@ -0,0 +1,452 @@
+// Package openapi builds OpenAPI 3 documents for JSON endpoints from the Go
+// types the handlers encode and decode, and generates typed clients from
+// them. Because schemas are reflected from the handler types, a changed
+// struct changes the document and every generated client with it. Contract
+// tests build requests from a document's fixtures and check handlers'
+// responses against it.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"allanswebterminal/apierror"
+)
+
+// Version is the OpenAPI version documents are written in.
+const Version = "3.0.3"
+
+// Document is an OpenAPI document. Paths maps a path to its operations by
+// lower-case method.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+
+	// operations keeps registration order for the generators.
+	operations []*Operation
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+
+	Method string `json:"-"`
+	Path   string `json:"-"`
+	// Status is the success status; 204 operations have no response body.
+	Status int `json:"-"`
+	// Media is set for operations answering something other than JSON.
+	Media string `json:"-"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Schema is the subset of JSON Schema that reflected Go types need.
+// Properties are generated in Order, the struct's field order.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+
+	Order []string `json:"-"`
+}
+
+// RefName returns the component a $ref schema points to, or "".
+func (s *Schema) RefName() string {
+	return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+}
+
+// IsRequired reports whether the object schema requires property name.
+func (s *Schema) IsRequired(name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Param is a query or path parameter of a Route. Type is a JSON Schema
+// primitive: string, integer, number or boolean.
+type Param struct {
+	Name        string
+	Type        string
+	Required    bool
+	Description string
+}
+
+// Route describes one endpoint.
+type Route struct {
+	// Pattern is the method and path as registered on the mux, e.g.
+	// "GET /api/iam/users/{name}".
+	Pattern string
+	ID      string
+	Summary string
+	Tag     string
+	// Path gives path parameters a type; unlisted ones are strings.
+	Path  []Param
+	Query []Param
+	// Body and Response are zero values of the types the handler decodes
+	// and encodes; nil means none.
+	Body     interface{}
+	Response interface{}
+	// Status defaults to 200, or 204 when there is no Response.
+	Status int
+	// Media is the success response's media type when it is not JSON, such
+	// as "text/csv" for downloads. Clients are not generated for these
+	// operations; they are meant to be fetched directly.
+	Media string
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Builder assembles a Document from Routes.
+type Builder struct {
+	doc   *Document
+	named map[reflect.Type]string
+}
+
+// NewBuilder starts a document. Every operation gets a default response
+// with the apierror envelope, documented as ApiErrorEnvelope.
+func NewBuilder(title, version string) *Builder {
+	b := &Builder{
+		doc: &Document{
+			OpenAPI:    Version,
+			Info:       Info{Title: title, Version: version},
+			Paths:      make(map[string]map[string]*Operation),
+			Components: Components{Schemas: make(map[string]*Schema)},
+		},
+		named: make(map[reflect.Type]string),
+	}
+	b.register(reflect.TypeOf(apierror.Error{}), "ApiError")
+	b.register(reflect.TypeOf(apierror.Envelope{}), "ApiErrorEnvelope")
+	return b
+}
+
+// register adds t as a component under name.
+func (b *Builder) register(t reflect.Type, name string) {
+	b.named[t] = name
+	b.doc.Components.Schemas[name] = b.objectSchema(t)
+}
+
+// Add documents route. It panics on malformed patterns and duplicate
+// operations, which are programming errors.
+func (b *Builder) Add(route Route) {
+	method, path, ok := strings.Cut(route.Pattern, " ")
+	if !ok || route.ID == "" {
+		panic(fmt.Sprintf("openapi: route %q needs a \"METHOD /path\" pattern and an ID", route.Pattern))
+	}
+	for _, op := range b.doc.operations {
+		if op.OperationID == route.ID || (op.Method == method && op.Path == path) {
+			panic(fmt.Sprintf("openapi: duplicate operation %s (%s)", route.ID, route.Pattern))
+		}
+	}
+
+	op := &Operation{
+		OperationID: route.ID,
+		Summary:     route.Summary,
+		Method:      method,
+		Path:        path,
+		Status:      route.Status,
+		Media:       route.Media,
+		Responses:   make(map[string]Response),
+	}
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+	for _, m := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		p := Param{Name: m[1], Type: "string", Required: true}
+		for _, typed := range route.Path {
+			if typed.Name == p.Name {
+				p = typed
+				p.Required = true
+			}
+		}
+		op.Parameters = append(op.Parameters, parameter("path", p))
+	}
+	for _, p := range route.Query {
+		op.Parameters = append(op.Parameters, parameter("query", p))
+	}
+	if route.Body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: b.schemaFor(reflect.TypeOf(route.Body))}},
+		}
+	}
+
+	if op.Status == 0 {
+		op.Status = http.StatusOK
+		if route.Response == nil && route.Media == "" {
+			op.Status = http.StatusNoContent
+		}
+	}
+	success := Response{Description: http.StatusText(op.Status)}
+	if route.Media != "" {
+		success.Content = map[string]MediaType{route.Media: {Schema: &Schema{Type: "string"}}}
+	} else if route.Response != nil {
+		success.Content = map[string]MediaType{"application/json": {Schema: b.schemaFor(reflect.TypeOf(route.Response))}}
+	}
+	op.Responses[fmt.Sprint(op.Status)] = success
+	op.Responses["default"] = Response{
+		Description: "Error",
+		Content:     map[string]MediaType{"application/json": {Schema: &Schema{Ref: ref("ApiErrorEnvelope")}}},
+	}
+
+	if b.doc.Paths[path] == nil {
+		b.doc.Paths[path] = make(map[string]*Operation)
+	}
+	b.doc.Paths[path][strings.ToLower(method)] = op
+	b.doc.operations = append(b.doc.operations, op)
+}
+
+// Document returns the assembled document.
+func (b *Builder) Document() *Document {
+	return b.doc
+}
+
+// Operations returns the document's operations in the order they were
+// added.
+func (d *Document) Operations() []*Operation {
+	return d.operations
+}
+
+// clientOperations returns the operations clients are generated for: those
+// answering JSON or nothing.
+func (d *Document) clientOperations() []*Operation {
+	var ops []*Operation
+	for _, op := range d.operations {
+		if op.Media == "" {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// Patterns returns each operation as a mux pattern, "METHOD /path".
+func (d *Document) Patterns() []string {
+	patterns := make([]string, len(d.operations))
+	for i, op := range d.operations {
+		patterns[i] = op.Method + " " + op.Path
+	}
+	return patterns
+}
+
+func parameter(in string, p Param) Parameter {
+	return Parameter{
+		Name:        p.Name,
+		In:          in,
+		Description: p.Description,
+		Required:    p.Required,
+		Schema:      &Schema{Type: p.Type},
+	}
+}
+
+func ref(name string) string {
+	return "#/components/schemas/" + name
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the schema of t. Named structs become components and
+// are returned as references.
+func (b *Builder) schemaFor(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Pointer:
+		s := b.schemaFor(t.Elem())
+		if s.Ref != "" {
+			return &Schema{AllOf: []*Schema{s}, Nullable: true}
+		}
+		s.Nullable = true
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.objectSchema(t)
+		}
+		if name, ok := b.named[t]; ok {
+			return &Schema{Ref: ref(name)}
+		}
+		name := b.componentName(t)
+		b.register(t, name)
+		return &Schema{Ref: ref(name)}
+	}
+	// interface{} and anything else: any JSON value.
+	return &Schema{}
+}
+
+// componentName is the type's name, qualified by its package when another
+// package already used the name.
+func (b *Builder) componentName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := b.doc.Components.Schemas[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()
+	pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + name
+}
+
+// objectSchema follows encoding/json: unexported and "-" fields are
+// skipped, untagged embedded structs are flattened and omitempty fields
+// are optional.
+func (b *Builder) objectSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	b.addFields(s, t)
+	return s
+}
+
+func (b *Builder) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.addFields(s, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, dup := s.Properties[name]; !dup {
+			s.Order = append(s.Order, name)
+		}
+		s.Properties[name] = b.schemaFor(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// componentNames returns the document's component names, sorted.
+func (d *Document) componentNames() []string {
+	names := make([]string, 0, len(d.Components.Schemas))
+	for name := range d.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// body and response return an operation's JSON schemas, or nil.
+func (op *Operation) body() *Schema {
+	if op.RequestBody == nil {
+		return nil
+	}
+	return op.RequestBody.Content["application/json"].Schema
+}
+
+func (op *Operation) response() *Schema {
+	return op.Responses[fmt.Sprint(op.Status)].Content["application/json"].Schema
+}
+
+func (op *Operation) params(in string) []Parameter {
+	var params []Parameter
+	for _, p := range op.Parameters {
+		if p.In == in {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// inline reports whether s is an object declared in place rather than a
+// component, which the generators name after the operation.
+func inline(s *Schema) bool {
+	return s != nil && s.Ref == "" && s.Type == "object" && s.Properties != nil
+}
+
+// pascal turns "user_name" or "listFiles" into "UserName" or "ListFiles".
+func pascal(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
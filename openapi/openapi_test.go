@@ -0,0 +1,237 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type base struct {
+	ID int `json:"id"`
+}
+
+type widget struct {
+	base
+	Name     string            `json:"name"`
+	Note     *string           `json:"note,omitempty"`
+	Parent   *widget           `json:"parent"`
+	Tags     map[string]string `json:"tags"`
+	Created  time.Time         `json:"created_at"`
+	Skipped  string            `json:"-"`
+	internal int
+}
+
+func testDocument() *Document {
+	b := NewBuilder("Test", "v1")
+	b.Add(Route{Pattern: "GET /api/widgets", ID: "listWidgets", Summary: "List widgets",
+		Query: []Param{{Name: "limit", Type: "integer"}, {Name: "q", Type: "string"}}, Response: []widget{}})
+	b.Add(Route{Pattern: "PUT /api/widgets/{id}", ID: "putWidget", Path: []Param{{Name: "id", Type: "integer"}},
+		Body: widget{}, Response: struct {
+			Saved bool `json:"saved"`
+		}{}})
+	b.Add(Route{Pattern: "DELETE /api/widgets/{name}", ID: "deleteWidget"})
+	return b.Document()
+}
+
+func TestSchemaReflection(t *testing.T) {
+	doc := testDocument()
+	s := doc.Components.Schemas["widget"]
+	if s == nil {
+		t.Fatalf("widget component missing: %v", doc.componentNames())
+	}
+	if got := strings.Join(s.Order, ","); got != "id,name,note,parent,tags,created_at" {
+		t.Errorf("properties = %s", got)
+	}
+	if s.IsRequired("note") || !s.IsRequired("name") {
+		t.Errorf("required = %v", s.Required)
+	}
+	if p := s.Properties["parent"]; !p.Nullable || len(p.AllOf) != 1 || p.AllOf[0].RefName() != "widget" {
+		t.Errorf("parent = %+v", p)
+	}
+	if n := s.Properties["note"]; n.Type != "string" || !n.Nullable {
+		t.Errorf("note = %+v", n)
+	}
+	if c := s.Properties["created_at"]; c.Format != "date-time" {
+		t.Errorf("created_at = %+v", c)
+	}
+	if tags := s.Properties["tags"]; tags.AdditionalProperties == nil || tags.AdditionalProperties.Type != "string" {
+		t.Errorf("tags = %+v", tags)
+	}
+}
+
+func TestAddOperations(t *testing.T) {
+	doc := testDocument()
+	put := doc.Paths["/api/widgets/{id}"]["put"]
+	if put == nil || put.Parameters[0].Schema.Type != "integer" || !put.Parameters[0].Required {
+		t.Fatalf("put = %+v", put)
+	}
+	if _, ok := put.Responses["default"]; !ok {
+		t.Error("operations must document the error envelope")
+	}
+	del := doc.Paths["/api/widgets/{name}"]["delete"]
+	if del.Status != http.StatusNoContent || del.Parameters[0].Schema.Type != "string" {
+		t.Errorf("delete = %+v", del)
+	}
+	if got := strings.Join(doc.Patterns(), ";"); got != "GET /api/widgets;PUT /api/widgets/{id};DELETE /api/widgets/{name}" {
+		t.Errorf("patterns = %s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("a duplicate operation must panic")
+		}
+	}()
+	b := NewBuilder("Test", "v1")
+	b.Add(Route{Pattern: "GET /a", ID: "a"})
+	b.Add(Route{Pattern: "GET /a", ID: "b"})
+}
+
+func TestMediaOperationsAreDocumentedButNotGenerated(t *testing.T) {
+	b := NewBuilder("Test", "v1")
+	b.Add(Route{Pattern: "GET /api/widgets", ID: "listWidgets", Response: []string{}})
+	b.Add(Route{Pattern: "GET /api/widgets/export.csv", ID: "exportWidgets", Media: "text/csv"})
+	doc := b.Document()
+
+	export := doc.Paths["/api/widgets/export.csv"]["get"]
+	if export.Status != http.StatusOK || export.Responses["200"].Content["text/csv"].Schema.Type != "string" {
+		t.Errorf("export = %+v", export)
+	}
+	if ts := string(TypeScript(doc)); strings.Contains(ts, "exportWidgets") || !strings.Contains(ts, "listWidgets") {
+		t.Errorf("TypeScript client:\n%s", ts)
+	}
+}
+
+func TestTypeScript(t *testing.T) {
+	ts := string(TypeScript(testDocument()))
+	for _, want := range []string{
+		"export interface widget {\n  id: number;\n  name: string;\n  note?: string | null;\n  parent: widget | null;\n  tags: Record<string, string>;\n  created_at: string;\n}",
+		"export interface PutWidgetResponse {\n  saved: boolean;\n}",
+		"/** List widgets */\n  listWidgets(query: { limit?: number; q?: string } = {}): Promise<widget[]>",
+		"putWidget(id: number, body: widget): Promise<PutWidgetResponse>",
+		"`/api/widgets/${encodeURIComponent(String(id))}`",
+		"deleteWidget(name: string): Promise<void>",
+	} {
+		if !strings.Contains(ts, want) {
+			t.Errorf("TypeScript client lacks %q", want)
+		}
+	}
+}
+
+func TestGoClientCompiles(t *testing.T) {
+	src, err := Go(testDocument(), "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "client.go", src, 0)
+	if err != nil {
+		t.Fatalf("generated client does not parse: %v\n%s", err, src)
+	}
+	conf := types.Config{Importer: importer.Default()}
+	if _, err := conf.Check("client", fset, []*ast.File{file}, nil); err != nil {
+		t.Fatalf("generated client does not type-check: %v\n%s", err, src)
+	}
+	for _, want := range []string{
+		"func (c *Client) ListWidgets(ctx context.Context, params ListWidgetsParams) ([]Widget, error)",
+		"func (c *Client) PutWidget(ctx context.Context, id int, body Widget) (PutWidgetResponse, error)",
+		"func (c *Client) DeleteWidget(ctx context.Context, name string) error",
+		"Parent    *Widget",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("Go client lacks %q", want)
+		}
+	}
+}
+
+func TestFixtureRequest(t *testing.T) {
+	doc := testDocument()
+	op := doc.Paths["/api/widgets/{id}"]["put"]
+	req, err := doc.Request(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPut || req.URL.Path != "/api/widgets/1" || req.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("request = %s %s", req.Method, req.URL)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	// The self-reference is cut off rather than recursing.
+	if body["name"] != "example" || body["parent"] != nil || body["created_at"] != "2024-01-02T03:04:05Z" {
+		t.Errorf("body = %v", body)
+	}
+	if err := doc.Check(op.body(), jsonValue(t, body)); err != nil {
+		t.Errorf("fixture does not match its own schema: %v", err)
+	}
+
+	req, _ = doc.Request(doc.Paths["/api/widgets"]["get"])
+	if req.URL.RawQuery != "" || req.Body != nil {
+		t.Errorf("optional parameters sent: %s", req.URL)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	doc := testDocument()
+	put := doc.Paths["/api/widgets/{id}"]["put"]
+	list := doc.Paths["/api/widgets"]["get"]
+	del := doc.Paths["/api/widgets/{name}"]["delete"]
+	jsonHeader := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+
+	tests := []struct {
+		name   string
+		op     *Operation
+		status int
+		header http.Header
+		body   string
+		want   string
+	}{
+		{"success", put, 200, jsonHeader, `{"saved":true}`, ""},
+		{"wrong type", put, 200, jsonHeader, `{"saved":"yes"}`, "$.saved: string, want boolean"},
+		{"missing property", put, 200, jsonHeader, `{}`, `required property "saved" missing`},
+		{"undocumented property", put, 200, jsonHeader, `{"saved":true,"id":1}`, `property "id" is not documented`},
+		{"undocumented status", put, 201, jsonHeader, `{"saved":true}`, "status 201 is not documented"},
+		{"not JSON", put, 200, http.Header{}, `{"saved":true}`, "content type"},
+		{"nil slice", list, 200, jsonHeader, `null`, ""},
+		{"items", list, 200, jsonHeader, `[{"id":1.5,"name":"a","parent":null,"tags":null,"created_at":"2024-01-02T03:04:05Z"}]`, "$[0].id: 1.5 is not an integer"},
+		{"date-time", list, 200, jsonHeader, `[{"id":1,"name":"a","parent":null,"tags":{},"created_at":"yesterday"}]`, `$[0].created_at: "yesterday" is not a date-time`},
+		{"no content", del, 204, http.Header{}, ``, ""},
+		{"body on no content", del, 204, http.Header{}, `{}`, "documented none"},
+		{"error envelope", del, 404, jsonHeader, `{"error":{"code":"not_found","message":"Widget not found"}}`, ""},
+		{"plain text error", del, 404, http.Header{"Content-Type": {"text/plain"}}, "404 page not found", "not the JSON error envelope"},
+		{"malformed envelope", put, 500, jsonHeader, `{"message":"boom"}`, `required property "error" missing`},
+	}
+	for _, tt := range tests {
+		err := doc.CheckResponse(tt.op, tt.status, tt.header, []byte(tt.body))
+		if tt.want == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// jsonValue round-trips v through JSON as CheckResponse decodes bodies.
+func jsonValue(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out interface{}
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
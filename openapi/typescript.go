@@ -0,0 +1,218 @@
+package openapi
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// TypeScript generates a dependency-free TypeScript client for doc: an
+// interface per component and a Client class with one method per
+// operation. Requests are sent with the browser's cookies, so a page
+// served by this server is authenticated as its user.
+func TypeScript(doc *Document) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated from the %s OpenAPI document, version %s. DO NOT EDIT.\n\n", doc.Info.Title, doc.Info.Version)
+
+	for _, name := range doc.componentNames() {
+		writeTSInterface(&b, name, doc.Components.Schemas[name])
+	}
+	for _, op := range doc.clientOperations() {
+		if s := op.body(); inline(s) {
+			writeTSInterface(&b, pascal(op.OperationID)+"Request", s)
+		}
+		if s := op.response(); inline(s) {
+			writeTSInterface(&b, pascal(op.OperationID)+"Response", s)
+		}
+	}
+
+	b.WriteString(tsRuntime)
+	for _, op := range doc.clientOperations() {
+		writeTSMethod(&b, op)
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+func writeTSInterface(b *strings.Builder, name string, s *Schema) {
+	fmt.Fprintf(b, "export interface %s {\n", name)
+	for _, prop := range s.Order {
+		fmt.Fprintf(b, "  %s%s: %s;\n", tsProperty(prop), tsOptional(s, prop), tsType(s.Properties[prop]))
+	}
+	b.WriteString("}\n\n")
+}
+
+func writeTSMethod(b *strings.Builder, op *Operation) {
+	var args []string
+	path := op.Path
+	for _, p := range op.params("path") {
+		args = append(args, fmt.Sprintf("%s: %s", p.Name, tsType(p.Schema)))
+		path = strings.ReplaceAll(path, "{"+p.Name+"}", "${encodeURIComponent(String("+p.Name+"))}")
+	}
+
+	body := "undefined"
+	if s := op.body(); s != nil {
+		t := tsType(s)
+		if inline(s) {
+			t = pascal(op.OperationID) + "Request"
+		}
+		args = append(args, "body: "+t)
+		body = "body"
+	}
+
+	query := "undefined"
+	if params := op.params("query"); len(params) > 0 {
+		var fields []string
+		optional := " = {}"
+		for _, p := range params {
+			mark := "?"
+			if p.Required {
+				mark, optional = "", ""
+			}
+			fields = append(fields, fmt.Sprintf("%s%s: %s", tsProperty(p.Name), mark, tsType(p.Schema)))
+		}
+		args = append(args, "query: { "+strings.Join(fields, "; ")+" }"+optional)
+		query = "query"
+	}
+
+	result := "void"
+	if s := op.response(); s != nil {
+		result = tsType(s)
+		if inline(s) {
+			result = pascal(op.OperationID) + "Response"
+		}
+	}
+
+	if op.Summary != "" {
+		fmt.Fprintf(b, "\n  /** %s */\n", op.Summary)
+	} else {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(b, "  %s(%s): Promise<%s> {\n", op.OperationID, strings.Join(args, ", "), result)
+	fmt.Fprintf(b, "    return this.request<%s>('%s', `%s`, %s, %s);\n  }\n", result, op.Method, path, query, body)
+}
+
+func tsProperty(name string) string {
+	if tsIdentifier.MatchString(name) {
+		return name
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+func tsOptional(s *Schema, prop string) string {
+	if s.IsRequired(prop) {
+		return ""
+	}
+	return "?"
+}
+
+func tsType(s *Schema) string {
+	t := tsBaseType(s)
+	if s.Nullable {
+		t += " | null"
+	}
+	return t
+}
+
+func tsBaseType(s *Schema) string {
+	switch {
+	case s.Ref != "":
+		return s.RefName()
+	case len(s.AllOf) == 1:
+		return tsBaseType(s.AllOf[0])
+	}
+	switch s.Type {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		elem := tsType(s.Items)
+		if strings.ContainsAny(elem, " |") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case "object":
+		if s.AdditionalProperties != nil {
+			return "Record<string, " + tsType(s.AdditionalProperties) + ">"
+		}
+		var fields []string
+		for _, prop := range s.Order {
+			fields = append(fields, fmt.Sprintf("%s%s: %s", tsProperty(prop), tsOptional(s, prop), tsType(s.Properties[prop])))
+		}
+		return "{ " + strings.Join(fields, "; ") + " }"
+	}
+	return "unknown"
+}
+
+const tsRuntime = `/** Thrown for every non-2xx response, with the server's error code. */
+export class ResponseError extends Error {
+  readonly status: number;
+  readonly code: string;
+  readonly details?: unknown;
+
+  constructor(status: number, code: string, message: string, details?: unknown) {
+    super(message);
+    this.name = 'ResponseError';
+    this.status = status;
+    this.code = code;
+    this.details = details;
+  }
+}
+
+export interface ClientOptions {
+  /** Origin of the server; defaults to the page's own origin. */
+  baseUrl?: string;
+  /** Headers added to every request. */
+  headers?: Record<string, string>;
+  fetch?: typeof fetch;
+}
+
+type Query = Record<string, string | number | boolean | undefined | null>;
+
+export class Client {
+  private readonly baseUrl: string;
+  private readonly headers: Record<string, string>;
+  private readonly fetchImpl: typeof fetch;
+
+  constructor(options: ClientOptions = {}) {
+    this.baseUrl = (options.baseUrl ?? '').replace(/\/+$/, '');
+    this.headers = options.headers ?? {};
+    this.fetchImpl = options.fetch ?? globalThis.fetch.bind(globalThis);
+  }
+
+  private async request<T>(method: string, path: string, query?: Query, body?: unknown): Promise<T> {
+    let url = this.baseUrl + path;
+    if (query) {
+      const params = new URLSearchParams();
+      for (const [key, value] of Object.entries(query)) {
+        if (value !== undefined && value !== null) params.append(key, String(value));
+      }
+      const qs = params.toString();
+      if (qs) url += '?' + qs;
+    }
+    const headers: Record<string, string> = { Accept: 'application/json', ...this.headers };
+    if (body !== undefined) headers['Content-Type'] = 'application/json';
+    const res = await this.fetchImpl(url, {
+      method,
+      headers,
+      credentials: 'same-origin',
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (!res.ok) {
+      let error: { code?: string; message?: string; details?: unknown } | undefined;
+      try {
+        error = (await res.json()).error;
+      } catch {
+        // not a JSON error envelope
+      }
+      throw new ResponseError(res.status, error?.code ?? 'http_error', error?.message ?? res.statusText, error?.details);
+    }
+    const text = await res.text();
+    return (text ? JSON.parse(text) : undefined) as T;
+  }
+`
@@ -0,0 +1,88 @@
+// Package pagination parses offset-based list parameters and defines the
+// JSON envelope that paginated list endpoints respond with.
+package pagination
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"allanswebterminal/apierror"
+)
+
+// Params are the parsed limit, offset and sort query parameters.
+type Params struct {
+	Limit  int
+	Offset int
+	// Sort is the requested sort key without its direction prefix.
+	Sort string
+	// Desc is set when the sort key was given as "-key".
+	Desc bool
+}
+
+// Page is the envelope for a paginated list: the items on this page, the
+// number of items matching the filters across all pages, and the window
+// that was applied.
+type Page struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+// Options configure Parse for one endpoint.
+type Options struct {
+	DefaultLimit int
+	MaxLimit     int
+	// Sorts are the accepted sort keys. DefaultSort is used when the request
+	// has none and may be prefixed with "-" for descending order.
+	Sorts       []string
+	DefaultSort string
+}
+
+// Parse reads limit, offset and sort from q, rejecting out-of-range values
+// and unknown sort keys.
+func Parse(q url.Values, opts Options) (Params, *apierror.Error) {
+	p := Params{Limit: opts.DefaultLimit}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > opts.MaxLimit {
+			return p, apierror.Validation(fmt.Sprintf("limit must be between 1 and %d", opts.MaxLimit))
+		}
+		p.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, apierror.Validation("offset must be a non-negative integer")
+		}
+		p.Offset = n
+	}
+
+	sort := q.Get("sort")
+	if sort == "" {
+		sort = opts.DefaultSort
+	}
+	p.Sort = strings.TrimPrefix(sort, "-")
+	p.Desc = p.Sort != sort
+	for _, s := range opts.Sorts {
+		if s == p.Sort {
+			return p, nil
+		}
+	}
+	return p, apierror.Validation(fmt.Sprintf("sort must be one of %s, optionally prefixed with '-'", strings.Join(opts.Sorts, ", ")))
+}
+
+// NewPage wraps items in the envelope. A nil slice should be replaced by an
+// empty one by the caller so clients always see a JSON array.
+func NewPage(items interface{}, total int, p Params) Page {
+	return Page{Items: items, Total: total, Limit: p.Limit, Offset: p.Offset}
+}
+
+// LikePattern escapes s for use as a substring match in a SQL LIKE or ILIKE
+// expression with the default backslash escape.
+func LikePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
@@ -0,0 +1,52 @@
+package pagination
+
+import (
+	"net/url"
+	"testing"
+)
+
+var testOptions = Options{
+	DefaultLimit: 20,
+	MaxLimit:     100,
+	Sorts:        []string{"name", "updated"},
+	DefaultSort:  "-updated",
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    Params
+		wantErr bool
+	}{
+		{"", Params{Limit: 20, Sort: "updated", Desc: true}, false},
+		{"limit=5&offset=10&sort=name", Params{Limit: 5, Offset: 10, Sort: "name"}, false},
+		{"sort=-name", Params{Limit: 20, Sort: "name", Desc: true}, false},
+		{"limit=0", Params{}, true},
+		{"limit=101", Params{}, true},
+		{"limit=abc", Params{}, true},
+		{"offset=-1", Params{}, true},
+		{"sort=size", Params{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.query)
+			got, err := Parse(q, testOptions)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Parse(%q) = %+v, want error", tt.query, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Parse(%q) = %+v, %v; want %+v", tt.query, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	if got := LikePattern(`50%_a\b`); got != `%50\%\_a\\b%` {
+		t.Errorf("LikePattern = %q", got)
+	}
+}
@@ -0,0 +1,212 @@
+// Package passhash hashes and verifies account passwords. New hashes use
+// the configured algorithm, Argon2id by default or bcrypt, and Verify
+// accepts either, so hashes made under an earlier configuration keep
+// working. NeedsRehash tells sign-in when to replace such a hash while the
+// plaintext is at hand.
+//
+// Argon2id hashes are written in the PHC string format,
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>, which records the
+// parameters they were made with.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms, as recorded next to each account's hash.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+var (
+	// ErrMismatch is returned when a password does not match its hash.
+	ErrMismatch = errors.New("password does not match")
+	// ErrUnknownHash is returned for hashes in no supported format.
+	ErrUnknownHash = errors.New("unknown password hash format")
+)
+
+// Params configures new hashes.
+type Params struct {
+	// Algorithm is Argon2id or Bcrypt.
+	Algorithm string
+	// BcryptCost is the bcrypt work factor, 4 to 31.
+	BcryptCost int
+	// Argon2Time is the number of passes over the memory.
+	Argon2Time int
+	// Argon2Memory is the memory used, in KiB.
+	Argon2Memory int
+	// Argon2Threads is the degree of parallelism, 1 to 255.
+	Argon2Threads int
+}
+
+// DefaultParams are the OWASP recommendations for Argon2id: 19 MiB, two
+// passes, one thread. The bcrypt cost is bcrypt's default.
+func DefaultParams() Params {
+	return Params{
+		Algorithm:     Argon2id,
+		BcryptCost:    bcrypt.DefaultCost,
+		Argon2Time:    2,
+		Argon2Memory:  19 * 1024,
+		Argon2Threads: 1,
+	}
+}
+
+const (
+	saltLength = 16
+	keyLength  = 32
+	// maxArgon2Memory caps the memory of one hash at 4 GiB.
+	maxArgon2Memory = 4 << 20
+)
+
+var (
+	mu     sync.RWMutex
+	params = DefaultParams()
+)
+
+// Validate reports parameters that would make weak or unusable hashes.
+func (p Params) Validate() error {
+	switch p.Algorithm {
+	case Argon2id:
+		if p.Argon2Time < 1 || p.Argon2Threads < 1 || p.Argon2Threads > 255 {
+			return fmt.Errorf("passhash: argon2id needs a time of at least 1 and 1 to 255 threads")
+		}
+		if p.Argon2Memory < 8*p.Argon2Threads || p.Argon2Memory > maxArgon2Memory {
+			return fmt.Errorf("passhash: argon2id needs 8 KiB of memory per thread and at most %d KiB", maxArgon2Memory)
+		}
+	case Bcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("passhash: the bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("passhash: unknown algorithm %q, want %s or %s", p.Algorithm, Argon2id, Bcrypt)
+	}
+	return nil
+}
+
+// Configure sets the parameters of new hashes.
+func Configure(p Params) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	mu.Lock()
+	params = p
+	mu.Unlock()
+	return nil
+}
+
+// Current returns the parameters of new hashes.
+func Current() Params {
+	mu.RLock()
+	defer mu.RUnlock()
+	return params
+}
+
+// Hash hashes password with the configured algorithm.
+func Hash(password string) (string, error) {
+	p := Current()
+	if p.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, uint32(p.Argon2Time), uint32(p.Argon2Memory), uint8(p.Argon2Threads), keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches hash, which may be in either
+// algorithm.
+func Verify(password, hash string) error {
+	switch Algorithm(hash) {
+	case Bcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	case Argon2id:
+		h, err := parseArgon2(hash)
+		if err != nil {
+			return err
+		}
+		key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+		if subtle.ConstantTimeCompare(key, h.key) != 1 {
+			return ErrMismatch
+		}
+		return nil
+	}
+	return ErrUnknownHash
+}
+
+// Algorithm names the algorithm hash was made with, or "" when it is in no
+// supported format.
+func Algorithm(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return Argon2id
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return Bcrypt
+	}
+	return ""
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or
+// other parameters than new hashes are, so it should be replaced.
+func NeedsRehash(hash string) bool {
+	p := Current()
+	switch Algorithm(hash) {
+	case Bcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return p.Algorithm != Bcrypt || err != nil || cost != p.BcryptCost
+	case Argon2id:
+		h, err := parseArgon2(hash)
+		return p.Algorithm != Argon2id || err != nil ||
+			int(h.time) != p.Argon2Time || int(h.memory) != p.Argon2Memory || int(h.threads) != p.Argon2Threads ||
+			len(h.salt) != saltLength || len(h.key) != keyLength
+	}
+	return true
+}
+
+type argon2Hash struct {
+	time, memory uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func parseArgon2(hash string) (argon2Hash, error) {
+	var h argon2Hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return h, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil || h.time == 0 || h.threads == 0 {
+		return h, ErrUnknownHash
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return h, ErrUnknownHash
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return h, ErrUnknownHash
+	}
+	return h, nil
+}
@@ -0,0 +1,140 @@
+package passhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useParams configures p for the test, with cheap Argon2id settings.
+func useParams(t *testing.T, p Params) {
+	t.Helper()
+	original := Current()
+	if err := Configure(p); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Configure(original) })
+}
+
+func cheapArgon2() Params {
+	p := DefaultParams()
+	p.Argon2Time, p.Argon2Memory = 1, 64
+	return p
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{Argon2id, Bcrypt} {
+		p := cheapArgon2()
+		p.Algorithm, p.BcryptCost = algorithm, bcrypt.MinCost
+		useParams(t, p)
+
+		hash, err := Hash("correct-horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Algorithm(hash) != algorithm {
+			t.Errorf("%s: hash %q", algorithm, hash)
+		}
+		if err := Verify("correct-horse", hash); err != nil {
+			t.Errorf("%s: Verify = %v", algorithm, err)
+		}
+		if err := Verify("battery-staple", hash); !errors.Is(err, ErrMismatch) {
+			t.Errorf("%s: wrong password: %v", algorithm, err)
+		}
+		if again, _ := Hash("correct-horse"); again == hash {
+			t.Errorf("%s: hashes are not salted", algorithm)
+		}
+	}
+}
+
+func TestArgon2Format(t *testing.T) {
+	useParams(t, cheapArgon2())
+	hash, _ := Hash("secret")
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("hash = %q", hash)
+	}
+	// Hashes keep verifying after the parameters change.
+	p := cheapArgon2()
+	p.Argon2Memory = 128
+	useParams(t, p)
+	if err := Verify("secret", hash); err != nil {
+		t.Errorf("Verify after reconfiguring = %v", err)
+	}
+
+	for _, malformed := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+		"plaintext",
+	} {
+		if err := Verify("secret", malformed); !errors.Is(err, ErrUnknownHash) {
+			t.Errorf("Verify(%q) = %v, want ErrUnknownHash", malformed, err)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	useParams(t, cheapArgon2())
+	current, _ := Hash("secret")
+
+	if !NeedsRehash(string(legacy)) {
+		t.Errorf("bcrypt hash under argon2id should be rehashed")
+	}
+	if NeedsRehash(current) {
+		t.Errorf("current hash should be kept")
+	}
+	if !NeedsRehash("plaintext") {
+		t.Errorf("unknown hash should be rehashed")
+	}
+
+	p := cheapArgon2()
+	p.Argon2Time = 2
+	useParams(t, p)
+	if !NeedsRehash(current) {
+		t.Errorf("hash with old parameters should be rehashed")
+	}
+
+	p = cheapArgon2()
+	p.Algorithm, p.BcryptCost = Bcrypt, bcrypt.MinCost
+	useParams(t, p)
+	if NeedsRehash(string(legacy)) || !NeedsRehash(current) {
+		t.Errorf("under bcrypt, only the argon2id hash should be rehashed")
+	}
+	p.BcryptCost = bcrypt.MinCost + 1
+	useParams(t, p)
+	if !NeedsRehash(string(legacy)) {
+		t.Errorf("bcrypt hash with an old cost should be rehashed")
+	}
+}
+
+func TestParamsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Params)
+		ok     bool
+	}{
+		{"defaults", func(p *Params) {}, true},
+		{"bcrypt", func(p *Params) { p.Algorithm = Bcrypt }, true},
+		{"unknown algorithm", func(p *Params) { p.Algorithm = "md5" }, false},
+		{"bcrypt cost too low", func(p *Params) { p.Algorithm, p.BcryptCost = Bcrypt, 3 }, false},
+		{"bcrypt cost too high", func(p *Params) { p.Algorithm, p.BcryptCost = Bcrypt, 32 }, false},
+		{"no passes", func(p *Params) { p.Argon2Time = 0 }, false},
+		{"too many threads", func(p *Params) { p.Argon2Threads = 256 }, false},
+		{"too little memory", func(p *Params) { p.Argon2Threads, p.Argon2Memory = 4, 16 }, false},
+		{"too much memory", func(p *Params) { p.Argon2Memory = 8 << 20 }, false},
+	}
+	for _, tt := range tests {
+		p := DefaultParams()
+		tt.modify(&p)
+		if err := p.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v", tt.name, err)
+		}
+	}
+	if err := Configure(Params{Algorithm: "md5"}); err == nil || Current().Algorithm != Argon2id {
+		t.Errorf("invalid parameters were applied: %v", err)
+	}
+}
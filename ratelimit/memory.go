@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore keeps buckets in process memory.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, budget Budget) (Result, error) {
+	return s.TakeN(ctx, key, budget, 1)
+}
+
+// TakeN takes n tokens from key's bucket in one step: all of them if there
+// are enough, and none otherwise, so a refused caller uses up nothing.
+func (s *MemoryStore) TakeN(ctx context.Context, key string, budget Budget, n int) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(budget.Burst), last: now}
+		s.buckets[key] = b
+	}
+
+	tokens, result := refillN(b.tokens, b.last, now, budget, n)
+	b.tokens = tokens
+	b.last = now
+	return result, nil
+}
+
+// Cleanup drops buckets idle for longer than maxIdle; an idle bucket is full
+// again so forgetting it changes nothing.
+func (s *MemoryStore) Cleanup(maxIdle time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := s.now().Add(-maxIdle)
+	removed := 0
+	for key, b := range s.buckets {
+		if b.last.Before(cutoff) {
+			delete(s.buckets, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// StartCleanup runs Cleanup every interval until ctx is done.
+func (s *MemoryStore) StartCleanup(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Cleanup(interval)
+			}
+		}
+	}()
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"allanswebterminal/apierror"
+	"allanswebterminal/middleware"
+)
+
+// Rules assigns budgets to API routes. Routes are matched by the longest
+// registered path prefix; other paths under Prefix use Default, and paths
+// outside Prefix are not limited.
+type Rules struct {
+	Prefix  string
+	Default Budget
+	Routes  map[string]Budget
+}
+
+// BudgetFor returns the bucket name and budget for path.
+func (r Rules) BudgetFor(path string) (string, Budget, bool) {
+	if !strings.HasPrefix(path, r.Prefix) {
+		return "", Budget{}, false
+	}
+
+	route, budget := r.Prefix, r.Default
+	for prefix, routeBudget := range r.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(route) {
+			route, budget = prefix, routeBudget
+		}
+	}
+	return route, budget, true
+}
+
+// Limiter applies Rules per client IP and, when Account identifies the
+// caller, per account as well.
+type Limiter struct {
+	Store   Store
+	Rules   Rules
+	Account func(r *http.Request) string
+}
+
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, budget, ok := l.Rules.BudgetFor(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keys := []string{"ip:" + middleware.ClientIP(r) + ":" + route}
+		if l.Account != nil {
+			if account := l.Account(r); account != "" {
+				keys = append(keys, "account:"+account+":"+route)
+			}
+		}
+
+		result, ok := l.take(r, keys, budget)
+		if !ok {
+			// Fail open: a broken backend must not take the API down.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		setHeaders(w, result)
+		if !result.Allowed {
+			retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			apierror.Write(w, apierror.New(apierror.CodeRateLimited, "Too many requests, please slow down").
+				WithDetails(map[string]int{"retry_after_seconds": retryAfter}))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// take draws from every bucket and reports the most restrictive result.
+func (l *Limiter) take(r *http.Request, keys []string, budget Budget) (Result, bool) {
+	var combined Result
+	for i, key := range keys {
+		result, err := l.Store.Take(r.Context(), key, budget)
+		if err != nil {
+			log.Printf("Rate limiter unavailable: %v", err)
+			return Result{}, false
+		}
+		if i == 0 || moreRestrictive(result, combined) {
+			combined = result
+		}
+	}
+	return combined, true
+}
+
+func moreRestrictive(a, b Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	return a.Remaining < b.Remaining
+}
+
+func setHeaders(w http.ResponseWriter, result Result) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+}
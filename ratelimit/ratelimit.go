@@ -0,0 +1,72 @@
+// Package ratelimit implements token-bucket rate limiting for the API with an
+// in-process store for single instances and a Redis store for deployments
+// with several instances.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Budget describes a token bucket: Burst requests may be made at once and
+// the bucket refills at Rate tokens per second.
+type Budget struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute builds a budget allowing n requests per minute with a burst of n.
+func PerMinute(n int) Budget {
+	return Budget{Rate: float64(n) / 60, Burst: n}
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a token is available when not allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps bucket state.
+type Store interface {
+	Take(ctx context.Context, key string, budget Budget) (Result, error)
+}
+
+// refill applies the token-bucket arithmetic shared by the stores. tokens is
+// the level at last, and the returned level already includes the token taken
+// when allowed.
+func refill(tokens float64, last, now time.Time, budget Budget) (float64, Result) {
+	return refillN(tokens, last, now, budget, 1)
+}
+
+// refillN is refill taking n tokens at once, or none if fewer are left.
+func refillN(tokens float64, last, now time.Time, budget Budget, n int) (float64, Result) {
+	elapsed := now.Sub(last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	tokens = math.Min(float64(budget.Burst), tokens+elapsed*budget.Rate)
+
+	result := Result{Limit: budget.Burst}
+	if tokens >= float64(n) {
+		tokens -= float64(n)
+		result.Allowed = true
+	} else if budget.Rate > 0 {
+		result.RetryAfter = secondsToDuration((float64(n) - tokens) / budget.Rate)
+	}
+
+	result.Remaining = int(math.Floor(tokens))
+	if budget.Rate > 0 {
+		result.Reset = secondsToDuration((float64(budget.Burst) - tokens) / budget.Rate)
+	}
+	return tokens, result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	store.now = func() time.Time { return now }
+	budget := Budget{Rate: 1, Burst: 2}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if result, _ := store.Take(ctx, "k", budget); !result.Allowed {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+
+	result, _ := store.Take(ctx, "k", budget)
+	if result.Allowed {
+		t.Fatal("third request should be limited")
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Errorf("RetryAfter = %v, want (0, 1s]", result.RetryAfter)
+	}
+
+	now = now.Add(time.Second)
+	if result, _ := store.Take(ctx, "k", budget); !result.Allowed {
+		t.Error("request after refill should be allowed")
+	}
+
+	if result, _ := store.Take(ctx, "other", budget); !result.Allowed || result.Remaining != 1 {
+		t.Errorf("separate keys should have separate buckets, got %+v", result)
+	}
+}
+
+func TestMemoryStoreTakeN(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	store.now = func() time.Time { return now }
+	budget := Budget{Rate: 1, Burst: 5}
+	ctx := context.Background()
+
+	if result, _ := store.TakeN(ctx, "k", budget, 3); !result.Allowed || result.Remaining != 2 {
+		t.Fatalf("taking 3 of 5 = %+v", result)
+	}
+	result, _ := store.TakeN(ctx, "k", budget, 3)
+	if result.Allowed {
+		t.Fatal("taking 3 of the 2 left should be refused")
+	}
+	if result.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want 1s", result.RetryAfter)
+	}
+	if result, _ := store.TakeN(ctx, "k", budget, 2); !result.Allowed {
+		t.Error("a refused request should leave its tokens in the bucket")
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	store.now = func() time.Time { return now }
+	store.Take(context.Background(), "idle", PerMinute(10))
+
+	now = now.Add(time.Hour)
+	if removed := store.Cleanup(time.Minute); removed != 1 {
+		t.Errorf("Cleanup removed %d buckets, want 1", removed)
+	}
+}
+
+func TestRulesBudgetFor(t *testing.T) {
+	rules := Rules{
+		Prefix:  "/api/",
+		Default: Budget{Rate: 10, Burst: 100},
+		Routes: map[string]Budget{
+			"/api/login": PerMinute(5),
+		},
+	}
+
+	if _, _, ok := rules.BudgetFor("/static/app.js"); ok {
+		t.Error("non-API paths should not be limited")
+	}
+	if route, budget, _ := rules.BudgetFor("/api/login"); route != "/api/login" || budget.Burst != 5 {
+		t.Errorf("unexpected login budget %s %+v", route, budget)
+	}
+	if route, budget, _ := rules.BudgetFor("/api/files/list"); route != "/api/" || budget.Burst != 100 {
+		t.Errorf("unexpected default budget %s %+v", route, budget)
+	}
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	limiter := &Limiter{
+		Store: NewMemoryStore(),
+		Rules: Rules{Prefix: "/api/", Default: Budget{Rate: 0.001, Burst: 1}},
+		Account: func(r *http.Request) string {
+			return r.Header.Get("X-Test-Account")
+		},
+	}
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(account string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/files/list", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if account != "" {
+			req.Header.Set("X-Test-Account", account)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := send("")
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", first.Code)
+	}
+	if first.Header().Get("X-RateLimit-Limit") != "1" || first.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected rate limit headers: %v", first.Header())
+	}
+
+	second := send("")
+	if second.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want 429", second.Code)
+	}
+	if second.Header().Get("Retry-After") == "" {
+		t.Error("429 response should set Retry-After")
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, budget Budget) (Result, error) {
+	return Result{}, errors.New("backend down")
+}
+
+func TestLimiterFailsOpen(t *testing.T) {
+	limiter := &Limiter{Store: failingStore{}, Rules: Rules{Prefix: "/api/", Default: PerMinute(1)}}
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/messages", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want request to pass through", rr.Code)
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL not set, skipping Redis integration test")
+	}
+
+	store, err := NewRedisStore(url)
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+	defer store.Close()
+
+	key := "test:" + time.Now().Format(time.RFC3339Nano)
+	budget := Budget{Rate: 0.001, Burst: 1}
+	if result, err := store.Take(context.Background(), key, budget); err != nil || !result.Allowed {
+		t.Fatalf("first take = %+v, %v", result, err)
+	}
+	if result, _ := store.Take(context.Background(), key, budget); result.Allowed {
+		t.Error("second take should be limited")
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript performs the same refill arithmetic as the memory store
+// atomically inside Redis so every instance shares one bucket per key.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+
+local elapsed = math.max(0, now - ts) / 1000
+tokens = math.min(burst, tokens + elapsed * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+if rate > 0 then
+	redis.call("PEXPIRE", KEYS[1], math.ceil((burst / rate) * 1000) + 1000)
+end
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore keeps buckets in Redis.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects to the Redis server at url (redis://host:port/db).
+func NewRedisStore(url string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	return &RedisStore{client: client, prefix: "ratelimit:"}, nil
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, budget Budget) (Result, error) {
+	now := time.Now().UnixMilli()
+	values, err := takeScript.Run(ctx, s.client, []string{s.prefix + key}, budget.Rate, budget.Burst, now).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(values) != 2 {
+		return Result{}, fmt.Errorf("unexpected rate limit script result: %v", values)
+	}
+
+	allowed, _ := values[0].(int64)
+	var tokens float64
+	if text, ok := values[1].(string); ok {
+		fmt.Sscanf(text, "%g", &tokens)
+	}
+
+	result := Result{Allowed: allowed == 1, Limit: budget.Burst, Remaining: int(math.Floor(tokens))}
+	if budget.Rate > 0 {
+		if !result.Allowed {
+			result.RetryAfter = secondsToDuration((1 - tokens) / budget.Rate)
+		}
+		result.Reset = secondsToDuration((float64(budget.Burst) - tokens) / budget.Rate)
+	}
+	return result, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
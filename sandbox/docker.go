@@ -0,0 +1,350 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Label marks the containers a Docker runtime starts, so leftovers from a
+// crashed server can be found with "docker ps --filter label=...".
+const Label = "allanswebterminal.sandbox"
+
+// Docker starts containers with the docker CLI, or podman, which takes
+// the same arguments. Containers run as an unprivileged user with all
+// capabilities dropped and a read-only root; only HomeDir and /tmp are
+// writable, as size-capped tmpfs mounts.
+type Docker struct {
+	Path  string // the docker or podman binary
+	Image string // needs sh and a uid 1000 user
+	// DiskMB caps each of HomeDir and /tmp.
+	DiskMB int
+}
+
+func (d Docker) Start(ctx context.Context, limits Limits) (Container, error) {
+	disk := d.DiskMB
+	if disk <= 0 {
+		disk = 64
+	}
+	args := []string{"run", "--detach", "--rm",
+		"--label", Label,
+		"--user", "1000:1000",
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--read-only",
+		"--tmpfs", fmt.Sprintf("%s:rw,size=%dm,uid=1000,gid=1000", HomeDir, disk),
+		"--tmpfs", fmt.Sprintf("/tmp:rw,size=%dm", disk),
+		"--workdir", HomeDir,
+		"--env", "HOME=" + HomeDir,
+		"--env", "PATH=" + VenvDir + "/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	}
+	if !limits.Network {
+		args = append(args, "--network", "none")
+	}
+	if limits.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(limits.CPUs, 'f', -1, 64))
+	}
+	if limits.MemoryMB > 0 {
+		// Equal memory and swap limits leave no swap.
+		mem := fmt.Sprintf("%dm", limits.MemoryMB)
+		args = append(args, "--memory", mem, "--memory-swap", mem)
+	}
+	if limits.PIDs > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(limits.PIDs))
+	}
+	args = append(args, d.Image, "sleep", "infinity")
+
+	out, err := d.run(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	id := strings.TrimSpace(out)
+	if id == "" {
+		return nil, errors.New("docker run printed no container id")
+	}
+	return &dockerContainer{docker: d, id: id}, nil
+}
+
+func (d Docker) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, d.Path, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s: %w: %.200s", d.Path, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+type dockerContainer struct {
+	docker Docker
+	id     string
+	stop   sync.Once
+}
+
+func (c *dockerContainer) ID() string { return c.id }
+
+func (c *dockerContainer) Exec(ctx context.Context, req ExecRequest) (*ExecResult, error) {
+	args := []string{"exec", "--workdir", HomeDir}
+	if req.Stdin != nil {
+		args = append(args, "--interactive")
+	}
+	names := make([]string, 0, len(req.Env))
+	for name := range req.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--env", name+"="+req.Env[name])
+	}
+	args = append(args, c.id, "sh", "-c", req.Command)
+
+	cmd := exec.CommandContext(ctx, c.docker.Path, args...)
+	stdout := &limitedBuffer{max: MaxOutput}
+	stderr := &limitedBuffer{max: MaxOutput}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = req.Stdin, stdout, stderr
+	if req.Stdout != nil {
+		cmd.Stdout = req.Stdout
+	}
+	start := time.Now()
+	err := cmd.Run()
+	res := &ExecResult{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.overflow || stderr.overflow,
+		Duration:  time.Since(start),
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return nil, fmt.Errorf("%s exec: %w", c.docker.Path, err)
+	}
+	return res, nil
+}
+
+// relay copies between its stdin and stdout and a port on the container's
+// loopback, so connections reach servers in containers without a network.
+// It writes one byte once connected and exits with 3 when nothing listens.
+const relay = `import socket, sys, threading
+try:
+    s = socket.create_connection(("127.0.0.1", int(sys.argv[1])))
+except OSError:
+    sys.exit(3)
+out = sys.stdout.buffer
+out.write(b"\0")
+out.flush()
+def up():
+    while True:
+        b = sys.stdin.buffer.read1(65536)
+        if not b:
+            break
+        s.sendall(b)
+    s.shutdown(socket.SHUT_WR)
+threading.Thread(target=up, daemon=True).start()
+while True:
+    b = s.recv(65536)
+    if not b:
+        break
+    out.write(b)
+    out.flush()
+`
+
+// Dial runs relay with python3, which the image must have.
+func (c *dockerContainer) Dial(ctx context.Context, port int) (net.Conn, error) {
+	cmd := exec.Command(c.docker.Path, "exec", "--interactive", c.id, "python3", "-c", relay, strconv.Itoa(port))
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &limitedBuffer{max: 1 << 10}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s exec: %w", c.docker.Path, err)
+	}
+	conn := &execConn{cmd: cmd, stdin: stdin, stdout: stdout, addr: execAddr(fmt.Sprintf("%s:%d", c.id, port))}
+
+	ack := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(stdout, make([]byte, 1))
+		ack <- err
+	}()
+	select {
+	case err = <-ack:
+	case <-ctx.Done():
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		// The relay exited; wait for its status rather than kill it.
+		conn.close.Do(func() {
+			stdin.Close()
+			conn.waited = cmd.Wait()
+		})
+		var exitErr *exec.ExitError
+		if errors.As(conn.waited, &exitErr) && exitErr.ExitCode() == 3 {
+			return nil, ErrNotListening
+		}
+		return nil, fmt.Errorf("%s exec relay: %.200s", c.docker.Path, strings.TrimSpace(stderr.String()))
+	}
+	return conn, nil
+}
+
+func (c *dockerContainer) Stop(ctx context.Context) error {
+	var err error
+	c.stop.Do(func() {
+		_, err = c.docker.run(ctx, "rm", "--force", c.id)
+	})
+	return err
+}
+
+// execConn is a connection through a relay process. Deadlines are not
+// supported; callers bound their use with contexts instead.
+type execConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.Reader
+	addr   execAddr
+	close  sync.Once
+	waited error
+}
+
+func (c *execConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *execConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+func (c *execConn) Close() error {
+	c.close.Do(func() {
+		c.stdin.Close()
+		c.cmd.Process.Kill()
+		c.waited = c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *execConn) LocalAddr() net.Addr                { return c.addr }
+func (c *execConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *execConn) SetDeadline(t time.Time) error      { return nil }
+func (c *execConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *execConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// execAddr is a container id and port.
+type execAddr string
+
+func (a execAddr) Network() string { return "sandbox" }
+func (a execAddr) String() string  { return string(a) }
+
+// limitedBuffer keeps at most max bytes and drops the rest, so a chatty
+// command cannot exhaust server memory.
+type limitedBuffer struct {
+	bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.overflow = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// Mock is an in-memory runtime for tests. Its containers answer every
+// command with Reply, or fail with Err. Dial connects to the address in
+// Ports for the port, such as an httptest server's.
+type Mock struct {
+	Reply ExecResult
+	Err   error
+	Ports map[int]string
+
+	mu      sync.Mutex
+	started int
+	stopped []string
+	execs   []ExecRequest
+}
+
+func (m *Mock) Start(ctx context.Context, limits Limits) (Container, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	m.started++
+	return &mockContainer{mock: m, id: fmt.Sprintf("mock-%d", m.started)}, nil
+}
+
+// Started returns how many containers were started.
+func (m *Mock) Started() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.started
+}
+
+// Stopped returns the ids of the stopped containers.
+func (m *Mock) Stopped() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.stopped...)
+}
+
+// Execs returns the commands run so far.
+func (m *Mock) Execs() []ExecRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ExecRequest(nil), m.execs...)
+}
+
+type mockContainer struct {
+	mock *Mock
+	id   string
+}
+
+func (c *mockContainer) ID() string { return c.id }
+
+func (c *mockContainer) Exec(ctx context.Context, req ExecRequest) (*ExecResult, error) {
+	c.mock.mu.Lock()
+	defer c.mock.mu.Unlock()
+	c.mock.execs = append(c.mock.execs, req)
+	res := c.mock.Reply
+	if req.Stdout != nil {
+		io.WriteString(req.Stdout, res.Stdout)
+		res.Stdout = ""
+	}
+	return &res, nil
+}
+
+func (c *mockContainer) Dial(ctx context.Context, port int) (net.Conn, error) {
+	c.mock.mu.Lock()
+	addr, ok := c.mock.Ports[port]
+	c.mock.mu.Unlock()
+	if !ok {
+		return nil, ErrNotListening
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func (c *mockContainer) Stop(ctx context.Context) error {
+	c.mock.mu.Lock()
+	defer c.mock.mu.Unlock()
+	c.mock.stopped = append(c.mock.stopped, c.id)
+	return nil
+}
@@ -0,0 +1,130 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDocker writes a stand-in docker binary that logs its arguments and
+// behaves like run, exec and rm.
+func fakeDocker(t *testing.T) (path, log string) {
+	dir := t.TempDir()
+	path = filepath.Join(dir, "docker")
+	log = filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+echo "$@" >> ` + log + `
+case "$1" in
+run) echo c0ffee ;;
+exec) if [ "$4" = python3 ]; then shift 3; exec "$@"; fi; shift $(($# - 1)); sh -c "$1" ;;
+esac
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path, log
+}
+
+func TestDocker(t *testing.T) {
+	path, log := fakeDocker(t)
+	ctx := context.Background()
+	d := Docker{Path: path, Image: "sandbox:latest"}
+
+	c, err := d.Start(ctx, DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID() != "c0ffee" {
+		t.Errorf("ID() = %q", c.ID())
+	}
+
+	res, err := c.Exec(ctx, ExecRequest{Command: "echo out; echo err >&2; exit 3", Env: map[string]string{"B": "2", "A": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stdout != "out\n" || res.Stderr != "err\n" || res.ExitCode != 3 {
+		t.Errorf("Exec() = %+v", res)
+	}
+
+	if err := c.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c.Stop(ctx) // stopping twice runs rm once
+
+	calls, _ := os.ReadFile(log)
+	lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("calls = %q", lines)
+	}
+	for _, want := range []string{"--network none", "--cpus 0.5", "--memory 256m --memory-swap 256m", "--pids-limit 64", "--cap-drop ALL", "--read-only", "sandbox:latest sleep infinity"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("run call %q lacks %q", lines[0], want)
+		}
+	}
+	if !strings.Contains(lines[1], "--env A=1 --env B=2 c0ffee sh -c") {
+		t.Errorf("exec call = %q", lines[1])
+	}
+	if lines[2] != "rm --force c0ffee" {
+		t.Errorf("rm call = %q", lines[2])
+	}
+}
+
+func TestDockerNetwork(t *testing.T) {
+	path, log := fakeDocker(t)
+	if _, err := (Docker{Path: path, Image: "img"}).Start(context.Background(), Limits{Network: true}); err != nil {
+		t.Fatal(err)
+	}
+	calls, _ := os.ReadFile(log)
+	if strings.Contains(string(calls), "--network") || strings.Contains(string(calls), "--cpus") {
+		t.Errorf("run call = %q", calls)
+	}
+}
+
+func TestDockerDial(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("the relay needs python3")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte(strings.ToUpper(line)))
+	}()
+
+	path, _ := fakeDocker(t)
+	ctx := context.Background()
+	c, err := Docker{Path: path, Image: "img"}.Start(ctx, DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Dial(ctx, ln.Addr().(*net.TCPAddr).Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello\n"))
+	if got, _ := bufio.NewReader(conn).ReadString('\n'); got != "HELLO\n" {
+		t.Errorf("reply = %q", got)
+	}
+
+	free, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+	if _, err := c.Dial(ctx, port); !errors.Is(err, ErrNotListening) {
+		t.Errorf("Dial to a closed port error = %v, want ErrNotListening", err)
+	}
+}
@@ -0,0 +1,331 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// PoolOptions configure a Pool.
+type PoolOptions struct {
+	Limits Limits
+	// Warm is how many unassigned containers are kept started, so new
+	// sessions get one at once.
+	Warm int
+	// Max bounds the containers running at once, assigned and warm.
+	Max int
+	// IdleTimeout is how long an account's container lives unused.
+	IdleTimeout time.Duration
+	// Prepare, if set, runs when a container is assigned to an account,
+	// before its first command, to restore the account's files. A failure
+	// is logged and the container used as it is.
+	Prepare func(ctx context.Context, accountID int, c Container) error
+}
+
+// Pool assigns each account a container of its own, taking a warm one
+// when it can. Assigned containers are stopped by Reap once idle.
+type Pool struct {
+	runtime Runtime
+	opts    PoolOptions
+	now     func() time.Time
+
+	mu       sync.Mutex
+	warm     []Container
+	assigned map[int]*assignment
+	starting int
+	// temporary counts the running containers from Temporary.
+	temporary int
+	closed    bool
+}
+
+type assignment struct {
+	container Container
+	lastUsed  time.Time
+}
+
+// Stats describe a pool's containers.
+type Stats struct {
+	Assigned int `json:"assigned"`
+	Warm     int `json:"warm"`
+	Max      int `json:"max"`
+}
+
+func NewPool(rt Runtime, opts PoolOptions) *Pool {
+	if opts.Max < 1 {
+		opts.Max = 1
+	}
+	if opts.Warm > opts.Max {
+		opts.Warm = opts.Max
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 15 * time.Minute
+	}
+	return &Pool{runtime: rt, opts: opts, now: time.Now, assigned: map[int]*assignment{}}
+}
+
+// running counts containers, including those being started. p.mu must be
+// held.
+func (p *Pool) running() int {
+	return len(p.warm) + len(p.assigned) + p.starting + p.temporary
+}
+
+// Acquire returns accountID's container, assigning one if it has none.
+func (p *Pool) Acquire(ctx context.Context, accountID int) (Container, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrNotConfigured
+	}
+	if a, ok := p.assigned[accountID]; ok {
+		a.lastUsed = p.now()
+		p.mu.Unlock()
+		return a.container, nil
+	}
+	if n := len(p.warm); n > 0 {
+		c := p.warm[n-1]
+		p.warm = p.warm[:n-1]
+		p.assigned[accountID] = &assignment{container: c, lastUsed: p.now()}
+		p.mu.Unlock()
+		go p.Fill(context.Background())
+		p.prepare(ctx, accountID, c)
+		return c, nil
+	}
+	if p.running() >= p.opts.Max {
+		p.mu.Unlock()
+		return nil, ErrPoolFull
+	}
+	p.starting++
+	p.mu.Unlock()
+
+	c, err := p.runtime.Start(ctx, p.opts.Limits)
+
+	p.mu.Lock()
+	p.starting--
+	if err != nil {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("starting sandbox: %w", err)
+	}
+	// A concurrent request may have assigned one meanwhile; keep that and
+	// let this one wait warm.
+	if a, ok := p.assigned[accountID]; ok {
+		p.park(c)
+		a.lastUsed = p.now()
+		p.mu.Unlock()
+		return a.container, nil
+	}
+	p.assigned[accountID] = &assignment{container: c, lastUsed: p.now()}
+	p.mu.Unlock()
+	p.prepare(ctx, accountID, c)
+	return c, nil
+}
+
+func (p *Pool) prepare(ctx context.Context, accountID int, c Container) {
+	if p.opts.Prepare == nil {
+		return
+	}
+	if err := p.opts.Prepare(ctx, accountID, c); err != nil {
+		log.Printf("Failed to prepare sandbox %s for account %d: %v", c.ID(), accountID, err)
+	}
+}
+
+// Temporary starts a container with limits for a one-off job, such as a
+// build that needs the network. It counts against Max until stopped.
+func (p *Pool) Temporary(ctx context.Context, limits Limits) (Container, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrNotConfigured
+	}
+	if p.running() >= p.opts.Max {
+		p.mu.Unlock()
+		return nil, ErrPoolFull
+	}
+	p.temporary++
+	p.mu.Unlock()
+
+	c, err := p.runtime.Start(ctx, limits)
+	if err != nil {
+		p.mu.Lock()
+		p.temporary--
+		p.mu.Unlock()
+		return nil, fmt.Errorf("starting sandbox: %w", err)
+	}
+	return &temporaryContainer{Container: c, pool: p}, nil
+}
+
+// Limits returns the limits of the pool's containers.
+func (p *Pool) Limits() Limits {
+	return p.opts.Limits
+}
+
+type temporaryContainer struct {
+	Container
+	pool    *Pool
+	stopped sync.Once
+}
+
+func (c *temporaryContainer) Stop(ctx context.Context) error {
+	err := c.Container.Stop(ctx)
+	c.stopped.Do(func() {
+		c.pool.mu.Lock()
+		c.pool.temporary--
+		c.pool.mu.Unlock()
+	})
+	return err
+}
+
+// park keeps c warm, or stops it if the pool is closed or has warm ones
+// enough. p.mu must be held.
+func (p *Pool) park(c Container) {
+	if !p.closed && len(p.warm) < p.opts.Warm {
+		p.warm = append(p.warm, c)
+		return
+	}
+	go stop(c)
+}
+
+// Assigned returns accountID's container, if it has one, without
+// starting one.
+func (p *Pool) Assigned(accountID int) (Container, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if a, ok := p.assigned[accountID]; ok {
+		return a.container, true
+	}
+	return nil, false
+}
+
+// Exec runs req in accountID's container.
+func (p *Pool) Exec(ctx context.Context, accountID int, req ExecRequest) (*ExecResult, error) {
+	c, err := p.Acquire(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Exec(ctx, req)
+	p.mu.Lock()
+	if a, ok := p.assigned[accountID]; ok && a.container == c {
+		a.lastUsed = p.now()
+	}
+	p.mu.Unlock()
+	return res, err
+}
+
+// Dial connects to port in accountID's container, which counts as use of
+// it. Unlike Exec it does not start a container: a fresh one has nothing
+// listening.
+func (p *Pool) Dial(ctx context.Context, accountID, port int) (net.Conn, error) {
+	p.mu.Lock()
+	a, ok := p.assigned[accountID]
+	if ok {
+		a.lastUsed = p.now()
+	}
+	p.mu.Unlock()
+	if !ok {
+		return nil, ErrNotRunning
+	}
+	return a.container.Dial(ctx, port)
+}
+
+// Release stops accountID's container. The next Acquire gives it a fresh
+// one.
+func (p *Pool) Release(ctx context.Context, accountID int) error {
+	p.mu.Lock()
+	a, ok := p.assigned[accountID]
+	delete(p.assigned, accountID)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	err := a.container.Stop(ctx)
+	go p.Fill(context.Background())
+	return err
+}
+
+// Fill starts containers until Warm are waiting, within Max.
+func (p *Pool) Fill(ctx context.Context) {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.warm)+p.starting >= p.opts.Warm || p.running() >= p.opts.Max {
+			p.mu.Unlock()
+			return
+		}
+		p.starting++
+		p.mu.Unlock()
+
+		c, err := p.runtime.Start(ctx, p.opts.Limits)
+
+		p.mu.Lock()
+		p.starting--
+		if err != nil {
+			p.mu.Unlock()
+			log.Printf("Failed to start warm sandbox: %v", err)
+			return
+		}
+		if p.closed {
+			p.mu.Unlock()
+			stop(c)
+			return
+		}
+		p.warm = append(p.warm, c)
+		p.mu.Unlock()
+	}
+}
+
+// Reap stops containers idle for longer than IdleTimeout, then tops the
+// warm containers up. It returns how many were stopped.
+func (p *Pool) Reap(ctx context.Context) int {
+	p.mu.Lock()
+	var idle []Container
+	cutoff := p.now().Add(-p.opts.IdleTimeout)
+	for id, a := range p.assigned {
+		if a.lastUsed.Before(cutoff) {
+			idle = append(idle, a.container)
+			delete(p.assigned, id)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		if err := c.Stop(ctx); err != nil {
+			log.Printf("Failed to stop sandbox %s: %v", c.ID(), err)
+		}
+	}
+	p.Fill(ctx)
+	return len(idle)
+}
+
+// Close stops every container. Acquire fails afterwards.
+func (p *Pool) Close(ctx context.Context) {
+	p.mu.Lock()
+	p.closed = true
+	all := p.warm
+	p.warm = nil
+	for id, a := range p.assigned {
+		all = append(all, a.container)
+		delete(p.assigned, id)
+	}
+	p.mu.Unlock()
+
+	for _, c := range all {
+		if err := c.Stop(ctx); err != nil {
+			log.Printf("Failed to stop sandbox %s: %v", c.ID(), err)
+		}
+	}
+}
+
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{Assigned: len(p.assigned), Warm: len(p.warm), Max: p.opts.Max}
+}
+
+func stop(c Container) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		log.Printf("Failed to stop sandbox %s: %v", c.ID(), err)
+	}
+}
@@ -0,0 +1,162 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond, for pool refills that run in the background.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolAssignsOneContainerPerAccount(t *testing.T) {
+	rt := &Mock{}
+	p := NewPool(rt, PoolOptions{Max: 2})
+	ctx := context.Background()
+
+	a, err := p.Acquire(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := p.Acquire(ctx, 1); again != a {
+		t.Error("the same account got a second container")
+	}
+	b, err := p.Acquire(ctx, 2)
+	if err != nil || b == a {
+		t.Fatalf("Acquire(2) = %v, %v", b, err)
+	}
+	if _, err := p.Acquire(ctx, 3); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("Acquire over Max error = %v, want ErrPoolFull", err)
+	}
+
+	if err := p.Release(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.Stopped(); len(got) != 1 || got[0] != a.ID() {
+		t.Errorf("Stopped() = %v", got)
+	}
+	if _, err := p.Acquire(ctx, 3); err != nil {
+		t.Errorf("Acquire after Release error = %v", err)
+	}
+}
+
+func TestPoolWarmStart(t *testing.T) {
+	rt := &Mock{}
+	p := NewPool(rt, PoolOptions{Warm: 2, Max: 5})
+	ctx := context.Background()
+	p.Fill(ctx)
+	if s := p.Stats(); s.Warm != 2 || rt.Started() != 2 {
+		t.Fatalf("after Fill: %+v, started %d", s, rt.Started())
+	}
+
+	if _, err := p.Acquire(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	// The warm container was handed out and a new one is started in its
+	// place.
+	waitFor(t, func() bool { return p.Stats().Warm == 2 })
+	if s := p.Stats(); s.Assigned != 1 || rt.Started() != 3 {
+		t.Errorf("after Acquire: %+v, started %d", s, rt.Started())
+	}
+}
+
+func TestPoolPrepare(t *testing.T) {
+	rt := &Mock{}
+	var prepared []int
+	p := NewPool(rt, PoolOptions{Max: 2, Prepare: func(ctx context.Context, accountID int, c Container) error {
+		prepared = append(prepared, accountID)
+		return errors.New("no files")
+	}})
+	ctx := context.Background()
+
+	if _, ok := p.Assigned(1); ok {
+		t.Error("Assigned before Acquire")
+	}
+	c, err := p.Acquire(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Acquire(ctx, 1)
+	if len(prepared) != 1 || prepared[0] != 1 {
+		t.Errorf("prepared = %v, want once for account 1", prepared)
+	}
+	if got, ok := p.Assigned(1); !ok || got != c {
+		t.Errorf("Assigned(1) = %v, %v", got, ok)
+	}
+}
+
+func TestPoolTemporary(t *testing.T) {
+	rt := &Mock{}
+	p := NewPool(rt, PoolOptions{Max: 1})
+	ctx := context.Background()
+
+	c, err := p.Temporary(ctx, Limits{Network: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Acquire(ctx, 1); !errors.Is(err, ErrPoolFull) {
+		t.Errorf("Acquire beside a temporary container error = %v, want ErrPoolFull", err)
+	}
+	c.Stop(ctx)
+	c.Stop(ctx)
+	if _, err := p.Acquire(ctx, 1); err != nil {
+		t.Errorf("Acquire after Stop error = %v", err)
+	}
+}
+
+func TestPoolReap(t *testing.T) {
+	rt := &Mock{Reply: ExecResult{Stdout: "ok\n"}}
+	p := NewPool(rt, PoolOptions{Max: 3, IdleTimeout: time.Minute})
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	p.Acquire(ctx, 1)
+	now = now.Add(50 * time.Second)
+	res, err := p.Exec(ctx, 2, ExecRequest{Command: "echo ok"})
+	if err != nil || res.Stdout != "ok\n" {
+		t.Fatalf("Exec = %+v, %v", res, err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if n := p.Reap(ctx); n != 1 {
+		t.Errorf("Reap() = %d, want 1", n)
+	}
+	if s := p.Stats(); s.Assigned != 1 {
+		t.Errorf("after Reap: %+v", s)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	rt := &Mock{}
+	p := NewPool(rt, PoolOptions{Warm: 1, Max: 3})
+	ctx := context.Background()
+	p.Fill(ctx)
+	p.Acquire(ctx, 1)
+	waitFor(t, func() bool { return p.Stats().Warm == 1 })
+
+	p.Close(ctx)
+	if got := len(rt.Stopped()); got != 2 {
+		t.Errorf("Close stopped %d containers, want 2", got)
+	}
+	if _, err := p.Acquire(ctx, 1); err == nil {
+		t.Error("Acquire after Close succeeded")
+	}
+}
+
+func TestExecWithoutPool(t *testing.T) {
+	SetPool(nil)
+	if _, err := Exec(context.Background(), 1, ExecRequest{Command: "ls"}); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Exec() error = %v, want ErrNotConfigured", err)
+	}
+}
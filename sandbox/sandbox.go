@@ -0,0 +1,133 @@
+// Package sandbox runs terminal commands in per-user containers with CPU,
+// memory and process limits and no network unless asked for. A Pool hands
+// each account its own container, starting them ahead of demand so a new
+// session does not wait for one to boot. Commands never run on the host:
+// until a pool is configured Exec returns ErrNotConfigured.
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// HomeDir is the sandbox user's home and the working directory of
+// commands.
+const HomeDir = "/home/sandbox"
+
+// VenvDir holds the user's Python virtualenv. Its bin directory comes
+// first in PATH.
+const VenvDir = HomeDir + "/.venv"
+
+// Limits are the resources a container may use.
+type Limits struct {
+	CPUs     float64 // fractions of a CPU, such as 0.5
+	MemoryMB int
+	PIDs     int
+	// Network gives the container network access. It is off by default.
+	Network bool
+}
+
+// DefaultLimits suit interactive use by one person.
+var DefaultLimits = Limits{CPUs: 0.5, MemoryMB: 256, PIDs: 64}
+
+// ExecRequest is a shell command to run in a container.
+type ExecRequest struct {
+	Command string
+	Env     map[string]string
+	// Stdin, if set, is the command's input.
+	Stdin io.Reader
+	// Stdout, if set, receives the command's output unbounded instead of
+	// ExecResult.Stdout; it is for moving files, not for showing users.
+	Stdout io.Writer
+}
+
+// ExecResult is how a command went. Output past MaxOutput is cut and
+// Truncated set.
+type ExecResult struct {
+	Stdout    string        `json:"stdout"`
+	Stderr    string        `json:"stderr"`
+	ExitCode  int           `json:"exit_code"`
+	Truncated bool          `json:"truncated,omitempty"`
+	Duration  time.Duration `json:"duration_ns"`
+}
+
+// MaxOutput bounds how much of each of stdout and stderr is kept.
+const MaxOutput = 256 << 10
+
+// Runtime starts containers.
+type Runtime interface {
+	Start(ctx context.Context, limits Limits) (Container, error)
+}
+
+// Container is a running sandbox. Files written in it last until it is
+// stopped.
+type Container interface {
+	ID() string
+	// Exec runs req.Command with sh in HomeDir. A non-zero exit code is
+	// reported in the result, not as an error.
+	Exec(ctx context.Context, req ExecRequest) (*ExecResult, error)
+	// Dial connects to port on the container's loopback interface, which
+	// works without network access. It fails with ErrNotListening when
+	// nothing accepts the connection.
+	Dial(ctx context.Context, port int) (net.Conn, error)
+	Stop(ctx context.Context) error
+}
+
+var (
+	// ErrNotConfigured is returned when no pool is set.
+	ErrNotConfigured = errors.New("no sandbox runtime is configured")
+	// ErrPoolFull is returned when every container allowed is taken.
+	ErrPoolFull = errors.New("all sandboxes are in use")
+	// ErrNotRunning is returned by Dial when the account has no container.
+	ErrNotRunning = errors.New("no sandbox is running")
+	// ErrNotListening is returned by Dial when nothing listens on the port.
+	ErrNotListening = errors.New("nothing is listening on the port")
+)
+
+var (
+	poolMu      sync.RWMutex
+	defaultPool *Pool
+)
+
+// SetPool replaces the pool used by Exec and Release. nil disables
+// sandboxes.
+func SetPool(p *Pool) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	defaultPool = p
+}
+
+// Default returns the configured pool, or nil.
+func Default() *Pool {
+	poolMu.RLock()
+	defer poolMu.RUnlock()
+	return defaultPool
+}
+
+// Configured reports whether a pool is set.
+func Configured() bool {
+	return Default() != nil
+}
+
+// Exec runs req in accountID's container on the configured pool.
+func Exec(ctx context.Context, accountID int, req ExecRequest) (*ExecResult, error) {
+	p := Default()
+	if p == nil {
+		return nil, ErrNotConfigured
+	}
+	return p.Exec(ctx, accountID, req)
+}
+
+// Release stops accountID's container on the configured pool, discarding
+// its files.
+func Release(ctx context.Context, accountID int) error {
+	p := Default()
+	if p == nil {
+		return ErrNotConfigured
+	}
+	return p.Release(ctx, accountID)
+}
@@ -0,0 +1,206 @@
+// Package sanitize cleans user-written HTML before it is stored or shown.
+// HTML keeps a small whitelist of formatting markup, Page adds what a
+// published page needs; Text keeps none. All drop scripts, styles and
+// embedded documents together with their content.
+package sanitize
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags may appear in the output of HTML, without attributes except
+// href on links.
+var allowedTags = map[string]bool{
+	"a": true, "b": true, "i": true, "em": true, "strong": true, "u": true, "s": true,
+	"sub": true, "sup": true, "code": true, "pre": true, "br": true, "p": true,
+	"ul": true, "ol": true, "li": true, "blockquote": true,
+}
+
+// pageTags may also appear in the output of Page, without attributes
+// except a safe src and alt on images.
+var pageTags = map[string]bool{
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"hr": true, "img": true, "del": true,
+}
+
+// voidTags have no content and no end tag.
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+// droppedTags are removed with everything inside them. svg and math are
+// foreign content where the HTML rules for escaping text do not apply.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "frame": true, "frameset": true,
+	"object": true, "embed": true, "applet": true, "noscript": true, "noembed": true,
+	"noframes": true, "template": true, "textarea": true, "title": true, "xmp": true,
+	"plaintext": true, "select": true, "svg": true, "math": true, "head": true,
+}
+
+var allowedSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// linkRel is added to every link: user content must not pass on page rank,
+// the opener or the referrer.
+const linkRel = "nofollow noopener noreferrer"
+
+// HTML returns s with only whitelisted tags kept, every attribute but a
+// safe href removed, text escaped and unclosed tags closed.
+func HTML(s string) string {
+	return clean(s, func(tag string) bool { return allowedTags[tag] })
+}
+
+// Page is HTML for the pages users publish, keeping headings, rules and
+// images as well.
+func Page(s string) string {
+	return clean(s, func(tag string) bool { return allowedTags[tag] || pageTags[tag] })
+}
+
+func clean(s string, allowed func(tag string) bool) string {
+	var b strings.Builder
+	var open []string
+	walk(s, func(tt html.TokenType, tok html.Token) {
+		switch tt {
+		case html.TextToken:
+			b.WriteString(html.EscapeString(html.UnescapeString(tok.Data)))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if !allowed(tok.Data) {
+				return
+			}
+			b.WriteString("<" + tok.Data)
+			if tok.Data == "img" {
+				// html.Token has already dropped repeated attributes.
+				for _, attr := range tok.Attr {
+					switch {
+					case attr.Namespace != "":
+					case attr.Key == "src":
+						if src, ok := safeURL(attr.Val); ok && !strings.HasPrefix(strings.ToLower(src), "mailto:") {
+							b.WriteString(` src="` + html.EscapeString(src) + `"`)
+						}
+					case attr.Key == "alt":
+						b.WriteString(` alt="` + html.EscapeString(attr.Val) + `"`)
+					}
+				}
+			}
+			if tok.Data == "a" {
+				for _, attr := range tok.Attr {
+					if attr.Namespace == "" && attr.Key == "href" {
+						if href, ok := safeURL(attr.Val); ok {
+							b.WriteString(` href="` + html.EscapeString(href) + `"`)
+						}
+						break
+					}
+				}
+				b.WriteString(` rel="` + linkRel + `"`)
+			}
+			b.WriteString(">")
+			if !voidTags[tok.Data] {
+				if tt == html.SelfClosingTagToken {
+					b.WriteString("</" + tok.Data + ">")
+				} else {
+					open = append(open, tok.Data)
+				}
+			}
+		case html.EndTagToken:
+			// Stray end tags are dropped; closing an outer tag closes the
+			// ones opened inside it.
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == tok.Data {
+					for j := len(open) - 1; j >= i; j-- {
+						b.WriteString("</" + open[j] + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+	})
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String()
+}
+
+// Text returns s without any markup. Text is kept as written, entities
+// included, and stripping repeats until nothing is left to strip, so that
+// removing one tag cannot join the pieces of another, as in "<<b>script>".
+func Text(s string) string {
+	for {
+		var b strings.Builder
+		walk(s, func(tt html.TokenType, tok html.Token) {
+			if tt == html.TextToken {
+				b.WriteString(tok.Data)
+			}
+		})
+		if b.String() == s {
+			return s
+		}
+		s = b.String()
+	}
+}
+
+// walk tokenizes s and calls fn for each token outside dropped elements.
+// Text tokens carry their raw text, so callers choose how to escape it.
+// Comments and doctypes are never passed on.
+func walk(s string, fn func(html.TokenType, html.Token)) {
+	z := html.NewTokenizer(strings.NewReader(s))
+	skip, depth := "", 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// A "<" starting a tag that never ends, as in "a<b", is text
+			// for a reader, so it is kept rather than swallowed.
+			if raw := z.Raw(); z.Err() == io.EOF && skip == "" && len(raw) > 0 && raw[0] == '<' {
+				fn(html.TextToken, html.Token{Type: html.TextToken, Data: string(raw)})
+			}
+			return
+		}
+		if tt == html.TextToken {
+			if skip == "" {
+				fn(tt, html.Token{Type: tt, Data: string(z.Raw())})
+			}
+			continue
+		}
+		tok := z.Token()
+		switch {
+		case skip != "":
+			if tok.Data != skip {
+				continue
+			}
+			switch tt {
+			case html.StartTagToken:
+				depth++
+			case html.EndTagToken:
+				if depth--; depth == 0 {
+					skip = ""
+				}
+			}
+		case droppedTags[tok.Data]:
+			if tt == html.StartTagToken {
+				skip, depth = tok.Data, 1
+			}
+		case tt == html.StartTagToken, tt == html.SelfClosingTagToken, tt == html.EndTagToken:
+			fn(tt, tok)
+		}
+	}
+}
+
+// safeURL returns href with the whitespace and control characters browsers
+// ignore removed, or false unless it is relative or uses an allowed scheme.
+func safeURL(href string) (string, bool) {
+	href = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, href)
+	u, err := url.Parse(href)
+	if err != nil || href == "" {
+		return "", false
+	}
+	if u.Scheme != "" && !allowedSchemes[strings.ToLower(u.Scheme)] {
+		return "", false
+	}
+	return href, true
+}
@@ -0,0 +1,124 @@
+package sanitize
+
+import "testing"
+
+const rel = ` rel="nofollow noopener noreferrer"`
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain text", "1 < 2 & 3 > 2", "1 &lt; 2 &amp; 3 &gt; 2"},
+		{"allowed markup", "<p><b>bold</b> and <em>em</em><br></p>", "<p><b>bold</b> and <em>em</em><br></p>"},
+		{"uppercase tags", "<B>bold</B>", "<b>bold</b>"},
+		{"attributes dropped", `<p class="x" style="color:red" onclick="alert(1)">hi</p>`, "<p>hi</p>"},
+		{"script", "<script>alert(1)</script>after", "after"},
+		{"split script", "<scr<script>ipt>alert(1)</script>", "ipt&gt;alert(1)"},
+		{"nested script tags", "<<b>script>alert(1)<</b>/script>", "&lt;<b>script&gt;alert(1)&lt;</b>/script&gt;"},
+		{"script in attribute quotes", `<p title="</p><script>alert(1)</script>">x</p>`, "<p>x</p>"},
+		{"img onerror", "<img src=x onerror=alert(1)>", ""},
+		{"svg onload", "<svg onload=alert(1)><circle/></svg>ok", "ok"},
+		{"script in svg", "<svg><script>alert(1)</script></svg>", ""},
+		{"nested svg", "<svg><svg></svg><b>hidden</b></svg>shown", "shown"},
+		{"math", "<math><mi xlink:href=javascript:alert(1)>x</mi></math>", ""},
+		{"style", "<style>body{background:url(javascript:alert(1))}</style>", ""},
+		{"iframe srcdoc", `<iframe srcdoc="<script>alert(1)</script>"></iframe>`, ""},
+		{"textarea breakout", "<textarea></textarea><script>alert(1)</script></textarea>", ""},
+		{"comment", "<!--<script>alert(1)</script>-->text", "text"},
+		{"unclosed comment", "text<!--<script>alert(1)</script>", "text"},
+		{"conditional comment", "<!--[if IE]><script>alert(1)</script><![endif]-->", ""},
+		{"unclosed tags closed", "<ul><li><b>one", "<ul><li><b>one</b></li></ul>"},
+		{"stray end tags dropped", "a</b></p>b", "ab"},
+		{"misnested tags", "<b><i>x</b>y</i>", "<b><i>x</i></b>y"},
+		{"partial tag kept as text", "a<b", "a&lt;b"},
+		{"entities kept", "&lt;script&gt; &amp; &#60;", "&lt;script&gt; &amp; &lt;"},
+		{"http link", `<a href="https://example.com/?a=1&amp;b=2">x</a>`, `<a href="https://example.com/?a=1&amp;b=2"` + rel + `>x</a>`},
+		{"mailto link", `<a href="mailto:a@example.com">x</a>`, `<a href="mailto:a@example.com"` + rel + `>x</a>`},
+		{"relative link", `<a href="/flashcards">x</a>`, `<a href="/flashcards"` + rel + `>x</a>`},
+		{"link attributes dropped", `<a href="/x" target="_top" onmouseover="alert(1)">x</a>`, `<a href="/x"` + rel + `>x</a>`},
+		{"javascript link", `<a href="javascript:alert(1)">x</a>`, `<a` + rel + `>x</a>`},
+		{"mixed case scheme", `<a href="JaVaScRiPt:alert(1)">x</a>`, `<a` + rel + `>x</a>`},
+		{"entity encoded scheme", `<a href="&#106;avascript&#x3A;alert(1)">x</a>`, `<a` + rel + `>x</a>`},
+		{"named entity colon", `<a href="javascript&colon;alert(1)">x</a>`, `<a` + rel + `>x</a>`},
+		{"whitespace in scheme", "<a href=\" java\tscr\nipt:alert(1)\">x</a>", `<a` + rel + `>x</a>`},
+		{"control character in scheme", "<a href=\"\x01javascript:alert(1)\">x</a>", `<a` + rel + `>x</a>`},
+		{"data url", `<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`, `<a` + rel + `>x</a>`},
+		{"vbscript url", `<a href="vbscript:msgbox(1)">x</a>`, `<a` + rel + `>x</a>`},
+		{"unquoted attribute breakout", `<a href=/x onclick=alert(1)>x</a>`, `<a href="/x"` + rel + `>x</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTML(tt.in); got != tt.want {
+				t.Errorf("HTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLIsIdempotent(t *testing.T) {
+	for _, in := range []string{
+		"<<b>script>alert(1)<</b>/script>",
+		`<a href="https://example.com/?a=1&amp;b=2">x &amp; y</a>`,
+		"<ul><li>a<li>b",
+		"&amp;lt;script&amp;gt;",
+	} {
+		once := HTML(in)
+		if twice := HTML(once); twice != once {
+			t.Errorf("HTML(HTML(%q)) = %q, want %q", in, twice, once)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"headings and rules", "<h1 id=x>Title</h1><hr><h3>Sub</h3>", "<h1>Title</h1><hr><h3>Sub</h3>"},
+		{"del", "<del>gone</del>", "<del>gone</del>"},
+		{"image", `<img src="https://example.com/a.png" alt="a &quot;cat&quot;" width=9>`, `<img src="https://example.com/a.png" alt="a &#34;cat&#34;">`},
+		{"relative image", `<img src="/avatar/7">`, `<img src="/avatar/7">`},
+		{"image onerror", "<img src=x onerror=alert(1)>", `<img src="x">`},
+		{"javascript image", `<img src="javascript:alert(1)" alt=x>`, `<img alt="x">`},
+		{"mailto image", `<img src="mailto:a@example.com">`, `<img>`},
+		{"self-closing void tags", "<hr/><img src=x />", `<hr><img src="x">`},
+		{"script", "<h2><script>alert(1)</script>x</h2>", "<h2>x</h2>"},
+		{"links keep rel", `<a href="/x">x</a>`, `<a href="/x"` + rel + `>x</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Page(tt.in); got != tt.want {
+				t.Errorf("Page(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+	if got := HTML("<h1>x</h1><hr>"); got != "x" {
+		t.Errorf("HTML kept page markup: %q", got)
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain text", "a < b and c > d", "a < b and c > d"},
+		{"markup stripped", "<p><b>bold</b> text</p>", "bold text"},
+		{"entities kept", "&lt;script&gt;", "&lt;script&gt;"},
+		{"script", "<script>alert(1)</script>", ""},
+		{"nested script tags", "<<b>script>alert(1)<</b>/script>", ""},
+		{"img onerror", "<img src=x onerror=alert(1)>", ""},
+		{"comment", "<!-- hidden -->shown", "shown"},
+		{"partial tag kept", "x<y", "x<y"},
+		{"svg", "<svg><text>x</text></svg>y", "y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Text(tt.in)
+			if got != tt.want {
+				t.Errorf("Text(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if again := Text(got); again != got {
+				t.Errorf("Text(%q) = %q, want %q", got, again, got)
+			}
+		})
+	}
+}
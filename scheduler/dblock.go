@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DBLocker coordinates instances through the scheduler_jobs table.
+type DBLocker struct {
+	DB *sql.DB
+}
+
+// TryLock upserts the job row, taking it over only when the previous
+// claim has expired. A row is returned only if this instance now holds it.
+func (l DBLocker) TryLock(ctx context.Context, name, owner string, until time.Time) (bool, error) {
+	query := `
+		INSERT INTO scheduler_jobs (name, locked_by, locked_until)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE
+		SET locked_by = EXCLUDED.locked_by, locked_until = EXCLUDED.locked_until
+		WHERE scheduler_jobs.locked_until IS NULL OR scheduler_jobs.locked_until <= CURRENT_TIMESTAMP
+		RETURNING name
+	`
+
+	var claimed string
+	err := l.DB.QueryRowContext(ctx, query, name, owner, until).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Record stores the outcome of the latest run.
+func (l DBLocker) Record(ctx context.Context, name string, startedAt time.Time, duration time.Duration, runErr error) error {
+	var lastError sql.NullString
+	if runErr != nil {
+		lastError = sql.NullString{String: runErr.Error(), Valid: true}
+	}
+
+	_, err := l.DB.ExecContext(ctx, `
+		UPDATE scheduler_jobs
+		SET last_run_at = $2, last_duration_ms = $3, last_error = $4
+		WHERE name = $1
+	`, name, startedAt, duration.Milliseconds(), lastError)
+	return err
+}
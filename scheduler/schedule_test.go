@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEveryAlignsToInterval(t *testing.T) {
+	s := Every(15 * time.Minute)
+	at := time.Date(2024, 3, 1, 10, 7, 30, 0, time.UTC)
+
+	if got, want := s.Next(at), time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+	if got, want := s.Next(time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)), time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next() on a boundary = %v, want %v", got, want)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Friday 2024-03-01 10:07
+	at := time.Date(2024, 3, 1, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 3, 2, 3, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2024, 3, 4, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5,10 10 * * *", time.Date(2024, 3, 1, 10, 10, 0, 0, time.UTC)},
+		// Restricted day-of-month and day-of-week match either.
+		{"0 0 15 * 6", time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Cron(tt.spec)
+			if err != nil {
+				t.Fatalf("Cron(%q) error: %v", tt.spec, err)
+			}
+			if got := s.Next(at); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronRejectsInvalidSpecs(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := Cron(spec); err == nil {
+			t.Errorf("Cron(%q) expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package scheduler runs recurring background jobs in-process. Jobs that
+// must run once per slot across several instances coordinate through a
+// Locker, normally the scheduler_jobs table.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job is a recurring task.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+	// Local jobs run on every instance (e.g. pruning in-memory state) and
+	// skip the cluster-wide lock.
+	Local bool
+	// Timeout bounds a single run; defaults to the scheduler's.
+	Timeout time.Duration
+}
+
+// Locker lets exactly one instance claim a job's run slot.
+type Locker interface {
+	// TryLock claims name for owner until the given time and reports
+	// whether this instance won.
+	TryLock(ctx context.Context, name, owner string, until time.Time) (bool, error)
+	// Record stores the outcome of a run for the status endpoint.
+	Record(ctx context.Context, name string, startedAt time.Time, duration time.Duration, runErr error) error
+}
+
+// JobStatus is a snapshot of a job for /api/admin/scheduler.
+type JobStatus struct {
+	Name         string     `json:"name"`
+	Schedule     string     `json:"schedule"`
+	Local        bool       `json:"local"`
+	Running      bool       `json:"running"`
+	NextRun      time.Time  `json:"next_run"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	Runs         int        `json:"runs"`
+	Failures     int        `json:"failures"`
+	// Skipped counts slots another instance claimed.
+	Skipped int `json:"skipped"`
+}
+
+type entry struct {
+	job    Job
+	status JobStatus
+}
+
+// Scheduler owns a set of jobs and their timers.
+type Scheduler struct {
+	Locker         Locker
+	DefaultTimeout time.Duration
+
+	owner   string
+	mu      sync.Mutex
+	entries map[string]*entry
+	started bool
+	wg      sync.WaitGroup
+	now     func() time.Time
+}
+
+func New(locker Locker) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		Locker:         locker,
+		DefaultTimeout: 5 * time.Minute,
+		owner:          fmt.Sprintf("%s-%d", host, os.Getpid()),
+		entries:        make(map[string]*entry),
+		now:            time.Now,
+	}
+}
+
+// Register adds a job. It must be called before Start.
+func (s *Scheduler) Register(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return errors.New("scheduler: job needs a name, schedule and run func")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("scheduler: cannot register after Start")
+	}
+	if _, exists := s.entries[job.Name]; exists {
+		return fmt.Errorf("scheduler: job %q already registered", job.Name)
+	}
+	s.entries[job.Name] = &entry{
+		job:    job,
+		status: JobStatus{Name: job.Name, Schedule: job.Schedule.String(), Local: job.Local},
+	}
+	return nil
+}
+
+// Start launches one timer loop per job. The loops stop when ctx is
+// cancelled; Wait blocks until in-flight runs return.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.started = true
+	entries := make([]*entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	for _, e := range entries {
+		s.wg.Add(1)
+		go s.loop(ctx, e)
+	}
+}
+
+// Wait blocks until every job loop has exited.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, e *entry) {
+	defer s.wg.Done()
+
+	for {
+		next := e.job.Schedule.Next(s.now())
+		if next.IsZero() {
+			log.Printf("scheduler: job %s has no future runs", e.job.Name)
+			return
+		}
+		s.mu.Lock()
+		e.status.NextRun = next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runSlot(ctx, e, next)
+	}
+}
+
+// runSlot claims the slot (unless the job is local) and runs the job.
+func (s *Scheduler) runSlot(ctx context.Context, e *entry, slot time.Time) {
+	if !e.job.Local && s.Locker != nil {
+		// Hold the claim until the following slot so that late instances
+		// skip this one without an explicit unlock.
+		until := e.job.Schedule.Next(slot)
+		won, err := s.Locker.TryLock(ctx, e.job.Name, s.owner, until)
+		if err != nil {
+			log.Printf("scheduler: lock for %s failed, skipping: %v", e.job.Name, err)
+			return
+		}
+		if !won {
+			s.mu.Lock()
+			e.status.Skipped++
+			s.mu.Unlock()
+			return
+		}
+	}
+
+	s.run(ctx, e)
+}
+
+func (s *Scheduler) run(ctx context.Context, e *entry) {
+	timeout := e.job.Timeout
+	if timeout == 0 {
+		timeout = s.DefaultTimeout
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	s.mu.Lock()
+	e.status.Running = true
+	s.mu.Unlock()
+
+	started := s.now()
+	err := safeRun(runCtx, e.job.Run)
+	duration := s.now().Sub(started)
+
+	s.mu.Lock()
+	e.status.Running = false
+	e.status.Runs++
+	e.status.LastRun = &started
+	e.status.LastDuration = duration.Round(time.Millisecond).String()
+	e.status.LastError = ""
+	if err != nil {
+		e.status.Failures++
+		e.status.LastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("scheduler: job %s failed: %v", e.job.Name, err)
+	}
+	if !e.job.Local && s.Locker != nil {
+		if recErr := s.Locker.Record(ctx, e.job.Name, started, duration, err); recErr != nil {
+			log.Printf("scheduler: failed to record run of %s: %v", e.job.Name, recErr)
+		}
+	}
+}
+
+func safeRun(ctx context.Context, fn func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// Status returns a snapshot of every job sorted by name.
+func (s *Scheduler) Status() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.entries))
+	for _, e := range s.entries {
+		status := e.status
+		if status.LastRun != nil {
+			lastRun := *status.LastRun
+			status.LastRun = &lastRun
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeLocker struct {
+	mu      sync.Mutex
+	win     bool
+	records []error
+}
+
+func (f *fakeLocker) TryLock(ctx context.Context, name, owner string, until time.Time) (bool, error) {
+	return f.win, nil
+}
+
+func (f *fakeLocker) Record(ctx context.Context, name string, startedAt time.Time, duration time.Duration, runErr error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = append(f.records, runErr)
+	return nil
+}
+
+func TestRegisterValidation(t *testing.T) {
+	s := New(nil)
+	job := Job{Name: "a", Schedule: Every(time.Minute), Run: func(context.Context) error { return nil }}
+
+	if err := s.Register(job); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := s.Register(job); err == nil {
+		t.Error("expected duplicate name to be rejected")
+	}
+	if err := s.Register(Job{Name: "b"}); err == nil {
+		t.Error("expected incomplete job to be rejected")
+	}
+}
+
+func TestRunSlotHonoursLock(t *testing.T) {
+	locker := &fakeLocker{}
+	s := New(locker)
+	var runs int32
+	s.Register(Job{Name: "report", Schedule: Every(time.Hour), Run: func(context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return errors.New("boom")
+	}})
+	e := s.entries["report"]
+
+	s.runSlot(context.Background(), e, time.Now())
+	if runs != 0 || s.Status()[0].Skipped != 1 {
+		t.Fatalf("expected slot to be skipped when lock is lost, runs=%d", runs)
+	}
+
+	locker.win = true
+	s.runSlot(context.Background(), e, time.Now())
+	status := s.Status()[0]
+	if runs != 1 || status.Runs != 1 || status.Failures != 1 || status.LastError != "boom" {
+		t.Errorf("unexpected status after run: %+v", status)
+	}
+	if len(locker.records) != 1 {
+		t.Errorf("expected run to be recorded, got %d records", len(locker.records))
+	}
+}
+
+func TestRunRecoversPanics(t *testing.T) {
+	s := New(nil)
+	s.Register(Job{Name: "bad", Schedule: Every(time.Hour), Local: true, Run: func(context.Context) error {
+		panic("oops")
+	}})
+
+	s.runSlot(context.Background(), s.entries["bad"], time.Now())
+	if got := s.Status()[0].LastError; got != "panic: oops" {
+		t.Errorf("LastError = %q", got)
+	}
+}
+
+func TestStartRunsJobsUntilCancelled(t *testing.T) {
+	s := New(nil)
+	var runs int32
+	s.Register(Job{Name: "tick", Schedule: Every(10 * time.Millisecond), Local: true, Run: func(context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&runs) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	s.Wait()
+
+	if atomic.LoadInt32(&runs) < 2 {
+		t.Errorf("expected job to run repeatedly, ran %d times", runs)
+	}
+	if err := s.Register(Job{Name: "late", Schedule: Every(time.Hour), Run: func(context.Context) error { return nil }}); err == nil {
+		t.Error("expected Register after Start to fail")
+	}
+}
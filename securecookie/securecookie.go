@@ -0,0 +1,208 @@
+// Package securecookie protects cookie values from tampering. Values are
+// signed with HMAC-SHA256, bound to the cookie's name and stamped with the
+// time they were issued, and can also be encrypted with AES-256-GCM so the
+// browser cannot read them.
+//
+//	http.SetCookie(w, &http.Cookie{Name: "user_id", Value: securecookie.Encode("user_id", "42")})
+//	id, ok := securecookie.Value(r, "user_id")
+//
+// Keys rotate: values are signed and encrypted with the first key of each
+// list and accepted under any of them, so a new key can be put in front
+// while values issued under the old one are still in browsers.
+package securecookie
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySize is the length of signing and encryption keys.
+const KeySize = 32
+
+var (
+	// ErrInvalid is returned for values that are malformed, were altered,
+	// or were issued under keys no longer configured or for another
+	// cookie.
+	ErrInvalid = errors.New("securecookie: invalid value")
+	// ErrExpired is returned for values older than the codec's MaxAge.
+	ErrExpired = errors.New("securecookie: value expired")
+)
+
+// Codec signs, and optionally encrypts, cookie values.
+type Codec struct {
+	signing [][]byte
+	aeads   []cipher.AEAD
+	// MaxAge, if positive, is how long values are accepted after they
+	// were issued, whatever the cookie's own expiry says.
+	MaxAge time.Duration
+	// now is the clock, replaced in tests.
+	now func() time.Time
+}
+
+// New returns a codec signing with signingKeys and, if any are given,
+// encrypting with encryptionKeys. The first key of each list is current;
+// the rest are only accepted. Every key must be KeySize bytes.
+func New(signingKeys, encryptionKeys [][]byte) (*Codec, error) {
+	if len(signingKeys) == 0 {
+		return nil, errors.New("securecookie: at least one signing key is required")
+	}
+	c := &Codec{now: time.Now}
+	for _, key := range signingKeys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("securecookie: signing keys must be %d bytes", KeySize)
+		}
+		c.signing = append(c.signing, key)
+	}
+	for _, key := range encryptionKeys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("securecookie: encryption keys must be %d bytes", KeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Encode returns value, issued now, protected for the cookie called name.
+func (c *Codec) Encode(name, value string) string {
+	payload := []byte(strconv.FormatInt(c.now().Unix(), 10) + "|" + value)
+	if len(c.aeads) > 0 {
+		nonce := make([]byte, c.aeads[0].NonceSize())
+		rand.Read(nonce)
+		payload = c.aeads[0].Seal(nonce, nonce, payload, []byte(name))
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + sign(c.signing[0], name, encoded)
+}
+
+// Decode returns the value Encode protected for the cookie called name.
+func (c *Codec) Decode(name, encoded string) (string, error) {
+	payloadPart, sig, ok := strings.Cut(encoded, ".")
+	if !ok {
+		return "", ErrInvalid
+	}
+	signed := false
+	for _, key := range c.signing {
+		if hmac.Equal([]byte(sig), []byte(sign(key, name, payloadPart))) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return "", ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	if len(c.aeads) > 0 {
+		if payload, err = c.open(name, payload); err != nil {
+			return "", err
+		}
+	}
+
+	issued, value, ok := strings.Cut(string(payload), "|")
+	if !ok {
+		return "", ErrInvalid
+	}
+	unix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	if c.MaxAge > 0 && c.now().Sub(time.Unix(unix, 0)) > c.MaxAge {
+		return "", ErrExpired
+	}
+	return value, nil
+}
+
+func (c *Codec) open(name string, sealed []byte) ([]byte, error) {
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize() {
+			return nil, ErrInvalid
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrInvalid
+}
+
+func sign(key []byte, name, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var (
+	mu           sync.RWMutex
+	defaultCodec = randomCodec()
+)
+
+func randomCodec() *Codec {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	c, _ := New([][]byte{key}, nil)
+	return c
+}
+
+// SetDefault replaces the codec the package functions use. Until it is
+// called they sign with a random key, so cookies do not survive a restart
+// or reach another instance.
+func SetDefault(c *Codec) {
+	mu.Lock()
+	defaultCodec = c
+	mu.Unlock()
+}
+
+// Default returns the codec the package functions use.
+func Default() *Codec {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultCodec
+}
+
+// Encode protects value for the cookie called name with the default codec.
+func Encode(name, value string) string {
+	return Default().Encode(name, value)
+}
+
+// Decode reads a value protected by Encode with the default codec.
+func Decode(name, encoded string) (string, error) {
+	return Default().Decode(name, encoded)
+}
+
+// Value returns the value of r's cookie called name, if it is present and
+// intact.
+func Value(r *http.Request, name string) (string, bool) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	value, err := Decode(name, cookie.Value)
+	return value, err == nil
+}
+
+// Cookie returns a cookie called name carrying value, protected, for
+// clients and tests to send. Servers set the cookie's other attributes.
+func Cookie(name, value string) *http.Cookie {
+	return &http.Cookie{Name: name, Value: Encode(name, value)}
+}
@@ -0,0 +1,134 @@
+package securecookie
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func key(b byte) []byte { return bytes.Repeat([]byte{b}, KeySize) }
+
+func mustNew(t *testing.T, signing, encryption [][]byte) *Codec {
+	t.Helper()
+	c, err := New(signing, encryption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := mustNew(t, [][]byte{key(1)}, nil)
+	encoded := c.Encode("user_id", "42")
+	if got, err := c.Decode("user_id", encoded); err != nil || got != "42" {
+		t.Fatalf("Decode = %q, %v", got, err)
+	}
+	if _, err := c.Decode("flash", encoded); !errors.Is(err, ErrInvalid) {
+		t.Errorf("value accepted for another cookie: %v", err)
+	}
+
+	payload, sig, _ := strings.Cut(encoded, ".")
+	for _, tampered := range []string{
+		"42",
+		payload + "." + sig + "x",
+		strings.ToUpper(payload) + "." + sig,
+		payload,
+		"",
+	} {
+		if _, err := c.Decode("user_id", tampered); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Decode(%q) = %v, want ErrInvalid", tampered, err)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := mustNew(t, [][]byte{key(1)}, [][]byte{key(2)})
+	issued := old.Encode("user_id", "42")
+
+	rotated := mustNew(t, [][]byte{key(3), key(1)}, [][]byte{key(4), key(2)})
+	if got, err := rotated.Decode("user_id", issued); err != nil || got != "42" {
+		t.Fatalf("value under the old keys: %q, %v", got, err)
+	}
+	fresh := rotated.Encode("user_id", "42")
+	if _, err := old.Decode("user_id", fresh); !errors.Is(err, ErrInvalid) {
+		t.Errorf("new value accepted under the old keys only: %v", err)
+	}
+
+	retired := mustNew(t, [][]byte{key(3)}, [][]byte{key(4)})
+	if _, err := retired.Decode("user_id", issued); !errors.Is(err, ErrInvalid) {
+		t.Errorf("value accepted after its keys were removed: %v", err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	signed := mustNew(t, [][]byte{key(1)}, nil)
+	encrypted := mustNew(t, [][]byte{key(1)}, [][]byte{key(2)})
+
+	plain := signed.Encode("user_id", "secret-value")
+	sealed := encrypted.Encode("user_id", "secret-value")
+	if decoded, _ := signed.Decode("user_id", sealed); strings.Contains(decoded, "secret-value") {
+		t.Errorf("encrypted value is readable with the signing key alone")
+	}
+	if got, err := encrypted.Decode("user_id", sealed); err != nil || got != "secret-value" {
+		t.Errorf("Decode = %q, %v", got, err)
+	}
+	if _, err := encrypted.Decode("user_id", plain); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unencrypted value accepted by an encrypting codec: %v", err)
+	}
+	if encrypted.Encode("user_id", "secret-value") == sealed {
+		t.Errorf("encryption is not randomized")
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	c := mustNew(t, [][]byte{key(1)}, nil)
+	c.MaxAge = time.Hour
+	start := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return start }
+	encoded := c.Encode("user_id", "42")
+
+	c.now = func() time.Time { return start.Add(59 * time.Minute) }
+	if _, err := c.Decode("user_id", encoded); err != nil {
+		t.Errorf("Decode within MaxAge = %v", err)
+	}
+	c.now = func() time.Time { return start.Add(61 * time.Minute) }
+	if _, err := c.Decode("user_id", encoded); !errors.Is(err, ErrExpired) {
+		t.Errorf("Decode after MaxAge = %v, want ErrExpired", err)
+	}
+}
+
+func TestNewRejectsBadKeys(t *testing.T) {
+	if _, err := New(nil, nil); err == nil {
+		t.Errorf("no signing keys accepted")
+	}
+	if _, err := New([][]byte{[]byte("short")}, nil); err == nil {
+		t.Errorf("short signing key accepted")
+	}
+	if _, err := New([][]byte{key(1)}, [][]byte{[]byte("short")}); err == nil {
+		t.Errorf("short encryption key accepted")
+	}
+}
+
+func TestValue(t *testing.T) {
+	original := Default()
+	SetDefault(mustNew(t, [][]byte{key(1)}, nil))
+	t.Cleanup(func() { SetDefault(original) })
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(Cookie("user_id", "42"))
+	if got, ok := Value(r, "user_id"); !ok || got != "42" {
+		t.Errorf("Value = %q, %v", got, ok)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", "user_id=42")
+	if _, ok := Value(r, "user_id"); ok {
+		t.Errorf("unsigned cookie accepted")
+	}
+	if _, ok := Value(r, "missing"); ok {
+		t.Errorf("missing cookie accepted")
+	}
+}
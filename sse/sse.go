@@ -0,0 +1,117 @@
+// Package sse writes Server-Sent Events (text/event-stream) responses.
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrStreamingUnsupported is returned when the ResponseWriter cannot flush.
+var ErrStreamingUnsupported = errors.New("sse: streaming unsupported")
+
+// Event is a single SSE message. Data is JSON-encoded unless it is already a
+// string.
+type Event struct {
+	ID    string
+	Name  string
+	Data  interface{}
+	Retry time.Duration
+}
+
+// Stream is an open event stream on an HTTP response.
+type Stream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// NewStream sets the event-stream headers and flushes them so the client
+// sees the connection open immediately.
+func NewStream(w http.ResponseWriter) (*Stream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// Stop nginx and similar proxies from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &Stream{w: w, flusher: flusher}, nil
+}
+
+// Send writes ev and flushes it to the client.
+func (s *Stream) Send(ev Event) error {
+	var b strings.Builder
+	if ev.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", oneLine(ev.ID))
+	}
+	if ev.Name != "" {
+		fmt.Fprintf(&b, "event: %s\n", oneLine(ev.Name))
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", ev.Retry.Milliseconds())
+	}
+
+	data, err := encodeData(ev.Data)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	if _, err := s.w.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Comment writes a comment line, which clients ignore; used as a keepalive
+// so idle proxies do not close the connection.
+func (s *Stream) Comment(text string) error {
+	if _, err := fmt.Fprintf(s.w, ": %s\n\n", oneLine(text)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// LastEventID returns the ID the browser sends when reconnecting, falling
+// back to a last_event_id query parameter for clients that cannot set
+// headers.
+func LastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("last_event_id")
+}
+
+func encodeData(data interface{}) (string, error) {
+	switch v := data.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
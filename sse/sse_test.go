@@ -0,0 +1,51 @@
+package sse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendFormatsEvents(t *testing.T) {
+	rec := httptest.NewRecorder()
+	stream, err := NewStream(rec)
+	if err != nil {
+		t.Fatalf("NewStream failed: %v", err)
+	}
+
+	stream.Send(Event{ID: "3", Name: "progress", Data: map[string]int{"percent": 50}})
+	stream.Send(Event{Data: "line one\nline two", Retry: 2 * time.Second})
+	stream.Comment("keepalive")
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	want := "id: 3\nevent: progress\ndata: {\"percent\":50}\n\n" +
+		"retry: 2000\ndata: line one\ndata: line two\n\n" +
+		": keepalive\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("unexpected stream:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestSendStripsNewlinesFromFields(t *testing.T) {
+	rec := httptest.NewRecorder()
+	stream, _ := NewStream(rec)
+	stream.Send(Event{ID: "1\nevent: spoofed", Data: "x"})
+
+	if got := rec.Body.String(); got != "id: 1event: spoofed\ndata: x\n\n" {
+		t.Errorf("unexpected stream %q", got)
+	}
+}
+
+func TestLastEventID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/events?last_event_id=4", nil)
+	if got := LastEventID(req); got != "4" {
+		t.Errorf("expected query fallback, got %q", got)
+	}
+	req.Header.Set("Last-Event-ID", "9")
+	if got := LastEventID(req); got != "9" {
+		t.Errorf("expected header to win, got %q", got)
+	}
+}
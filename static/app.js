@@ -45,7 +45,8 @@ const terminalState = {
     vimCursorCol: 0,
     vimCurrentMode: 'normal', // 'normal', 'insert', 'command'
     vimCommandBuffer: '',
-    vimLastKey: ''
+    vimLastKey: '',
+    recording: null // { title, startedAt, events } while 'record' is on
 };
 
 // Available commands
@@ -117,6 +118,38 @@ const commands = {
     vim: {
         description: 'Open vim editor',
         execute: (args) => openVimEditor(args[0])
+    },
+    record: {
+        description: 'Record the session (start [title], stop, share <id>)',
+        execute: (args) => handleRecord(args)
+    },
+    preview: {
+        description: 'Open a web server running in your sandbox (preview <port>)',
+        execute: (args) => handlePreview(args)
+    },
+    publish: {
+        description: 'Publish a Markdown file as a public page (publish <file.md>, list, remove <slug>)',
+        execute: (args) => handlePublish(args)
+    },
+    project: {
+        description: 'Manage projects (project list, create <name>, run <name>, share <name>, delete <name>)',
+        execute: (args) => handleProject(args)
+    },
+    clone: {
+        description: 'Import a public GitHub repository into your files (clone <url> [dir])',
+        execute: (args) => handleClone(args)
+    },
+    github: {
+        description: 'Export files to GitHub (github connect, gist <files...>, push <owner/repo> <files...>, sync <id>, list, disconnect)',
+        execute: (args) => handleGitHub(args)
+    },
+    versions: {
+        description: 'Show or restore past versions of a file (versions <file> [show|restore <n>])',
+        execute: (args) => handleVersions(args)
+    },
+    passkey: {
+        description: 'Sign in without a password (passkey add [name], list, remove <id>)',
+        execute: (args) => handlePasskey(args)
     }
 };
 
@@ -236,11 +269,11 @@ function listDirectorySync() {
 
 async function listDirectoryAsync() {
     try {
-        const response = await fetch('/api/files/list', {
+        const response = await fetch('/api/files/list?sort=name&limit=200', {
             credentials: 'include'
         });
         if (response.ok) {
-            const userFiles = await response.json();
+            const userFiles = (await response.json()).items;
             
             if (userFiles.length > 0) {
                 const userFileNames = userFiles.map(file => file.filename).join('  ');
@@ -708,6 +741,7 @@ async function performLogin(username, password) {
             terminalState.userName = username.toLowerCase();
             terminalState.isLoggedIn = true;
             updatePrompt();
+            restoreShellState();
             return `✅ Welcome back, ${username}! You now have full access to the system.
 You can now save and load files using 'vim filename.py'`;
         } else {
@@ -1797,7 +1831,8 @@ async function sendMessage() {
             addOutput(`Thank you, ${terminalState.messageData.name}. I'll get back to you soon!`);
         } else {
             const errorData = await response.json();
-            addOutput('❌ Failed to send message: ' + (errorData.error || 'Unknown error'));
+            const errorMessage = errorData.error && errorData.error.message;
+            addOutput('❌ Failed to send message: ' + (errorMessage || 'Unknown error'));
         }
     } catch (error) {
         addOutput('❌ Failed to send message: Network error');
@@ -1860,6 +1895,7 @@ async function loadFileIntoVimEditor(filename, vimEditor) {
 // Terminal UI functions
 function addOutput(output, isCommand = false) {
     if (output === '') return;
+    captureOutput(output, isCommand);
     
     // Handle test environment where terminalContent might not exist
     if (!terminalContent) {
@@ -1930,6 +1966,494 @@ function scrollToBottom() {
 }
 
 
+// Command history and working directory are kept per account on the
+// server, so logging in on any device restores them. Commands starting
+// with a space are not recorded.
+function recordCommand(input) {
+    if (!terminalState.isLoggedIn) return;
+    fetch('/api/terminal/history', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        credentials: 'include',
+        body: JSON.stringify({ command: input, cwd: terminalState.currentDirectory })
+    }).catch(error => console.error('Error recording command:', error));
+}
+
+function saveShellState() {
+    if (!terminalState.isLoggedIn) return;
+    fetch('/api/terminal/state', {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        credentials: 'include',
+        body: JSON.stringify({ cwd: terminalState.currentDirectory, env: terminalState.env || {} })
+    }).catch(error => console.error('Error saving terminal state:', error));
+}
+
+async function restoreShellState() {
+    try {
+        const [historyResponse, stateResponse] = await Promise.all([
+            fetch('/api/terminal/history?limit=100', { credentials: 'include' }),
+            fetch('/api/terminal/state', { credentials: 'include' })
+        ]);
+        if (historyResponse.ok) {
+            const saved = (await historyResponse.json()).items.map(entry => entry.command);
+            commandHistory = commandHistory.concat(saved);
+            historyIndex = -1;
+        }
+        if (stateResponse.ok) {
+            const state = await stateResponse.json();
+            terminalState.currentDirectory = state.cwd;
+            terminalState.env = state.env;
+            updatePrompt();
+        }
+    } catch (error) {
+        console.error('Error restoring terminal state:', error);
+    }
+}
+
+// Session recording: while on, everything shown in the terminal is kept as
+// asciicast events and uploaded on 'record stop'. Login and register input
+// is left out, since it carries passwords.
+function captureOutput(output, isCommand) {
+    const recording = terminalState.recording;
+    if (!recording) return;
+    let text = output;
+    if (isCommand) {
+        if (terminalState.loginMode || terminalState.registerMode || /^(login|register)\s/i.test(output)) {
+            return;
+        }
+        text = `${getPrompt()} ${output}`;
+    }
+    const plain = text.replace(/<br\s*\/?>/gi, '\n').replace(/<[^>]+>/g, '');
+    const seconds = (Date.now() - recording.startedAt) / 1000;
+    recording.events.push([seconds, 'o', plain.replace(/\n/g, '\r\n') + '\r\n']);
+}
+
+async function handleRecord(args) {
+    const action = args[0];
+    if (!terminalState.isLoggedIn) {
+        return 'Log in to record sessions.';
+    }
+    if (action === 'start') {
+        if (terminalState.recording) {
+            return "Already recording. Use 'record stop' to save it.";
+        }
+        terminalState.recording = { title: args.slice(1).join(' '), startedAt: Date.now(), events: [] };
+        return "⏺ Recording started. Use 'record stop' to save it.";
+    }
+    if (action === 'stop') {
+        const recording = terminalState.recording;
+        if (!recording) {
+            return "Not recording. Use 'record start [title]' to begin.";
+        }
+        terminalState.recording = null;
+        try {
+            const response = await fetch('/api/terminal/recordings', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                credentials: 'include',
+                body: JSON.stringify({ title: recording.title, events: recording.events })
+            });
+            const body = await response.json();
+            if (!response.ok) {
+                return `❌ Recording not saved: ${body.error ? body.error.message : response.statusText}`;
+            }
+            return `⏹ Saved recording #${body.id} (${Math.round(body.duration)}s). Use 'record share ${body.id}' for a public link.`;
+        } catch (error) {
+            return `❌ Recording not saved: ${error.message}`;
+        }
+    }
+    if (action === 'share' && args[1]) {
+        try {
+            const response = await fetch(`/api/terminal/recordings/${encodeURIComponent(args[1])}/share`, {
+                method: 'PUT',
+                credentials: 'include'
+            });
+            const body = await response.json();
+            if (!response.ok) {
+                return `❌ ${body.error ? body.error.message : 'Sharing failed'}`;
+            }
+            return `🔗 ${window.location.origin}${body.share_url}`;
+        } catch (error) {
+            return `❌ Sharing failed: ${error.message}`;
+        }
+    }
+    return 'Usage: record start [title] | record stop | record share <id>';
+}
+
+async function handlePreview(args) {
+    if (!terminalState.isLoggedIn) {
+        return 'Log in to preview apps.';
+    }
+    const port = parseInt(args[0], 10);
+    if (!port) {
+        return 'Usage: preview <port>, after starting a server in the background, e.g. nohup flask run --port 5000 > flask.log 2>&1 &';
+    }
+    try {
+        const response = await fetch('/api/terminal/previews', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'include',
+            body: JSON.stringify({ port })
+        });
+        const body = await response.json();
+        if (!response.ok) {
+            return `❌ ${body.error ? body.error.message : 'Preview failed'}`;
+        }
+        window.open(body.url, '_blank', 'noopener');
+        return `🌐 ${window.location.origin}${body.url}`;
+    } catch (error) {
+        return `❌ Preview failed: ${error.message}`;
+    }
+}
+
+async function handlePublish(args) {
+    if (!terminalState.isLoggedIn) {
+        return 'Log in to publish pages.';
+    }
+    const usage = 'Usage: publish <file.md> | publish list | publish remove <slug>';
+    try {
+        if (args[0] === 'list') {
+            const response = await fetch('/api/pages', { credentials: 'include' });
+            const body = await response.json();
+            if (!response.ok) {
+                return `❌ ${body.error ? escapeHtml(body.error.message) : 'Listing pages failed'}`;
+            }
+            if (body.length === 0) {
+                return 'No published pages. Publish one with: publish <file.md>';
+            }
+            return body.map(page => `${escapeHtml(page.filename)} → ${window.location.origin}${escapeHtml(page.url)}`).join('\n');
+        }
+        if (args[0] === 'remove') {
+            if (!args[1]) {
+                return usage;
+            }
+            const response = await fetch(`/api/pages/${encodeURIComponent(args[1])}`, {
+                method: 'DELETE',
+                credentials: 'include'
+            });
+            if (!response.ok) {
+                const body = await response.json();
+                return `❌ ${body.error ? escapeHtml(body.error.message) : 'Unpublishing failed'}`;
+            }
+            return `Unpublished ${escapeHtml(args[1])}.`;
+        }
+        if (!args[0]) {
+            return usage;
+        }
+        const response = await fetch('/api/pages', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'include',
+            body: JSON.stringify({ filename: args[0] })
+        });
+        const body = await response.json();
+        if (!response.ok) {
+            return `❌ ${body.error ? escapeHtml(body.error.message) : 'Publishing failed'}`;
+        }
+        return `🌐 ${window.location.origin}${escapeHtml(body.url)}`;
+    } catch (error) {
+        return `❌ Publishing failed: ${error.message}`;
+    }
+}
+
+async function handleProject(args) {
+    if (!terminalState.isLoggedIn) {
+        return 'Log in to manage projects.';
+    }
+    const [action, name] = args;
+    const usage = 'Usage: project list | project create <name> | project run <name> | project share <name> | project delete <name>';
+    const requests = {
+        list: () => fetch('/api/projects', { credentials: 'include' }),
+        create: () => fetch('/api/projects', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'include',
+            body: JSON.stringify({ name })
+        }),
+        run: () => fetch(`/api/projects/${encodeURIComponent(name)}/run`, { method: 'POST', credentials: 'include' }),
+        share: () => fetch(`/api/projects/${encodeURIComponent(name)}/share`, { method: 'PUT', credentials: 'include' }),
+        delete: () => fetch(`/api/projects/${encodeURIComponent(name)}`, { method: 'DELETE', credentials: 'include' })
+    };
+    if (!requests[action] || (action !== 'list' && !name)) {
+        return usage;
+    }
+    if (action === 'delete' && !confirm(`Delete project ${name} and all its files?`)) {
+        return 'Cancelled.';
+    }
+    try {
+        const response = await requests[action]();
+        const body = response.status === 204 ? {} : await response.json();
+        if (!response.ok) {
+            return `❌ ${body.error ? escapeHtml(body.error.message) : `Project ${action} failed`}`;
+        }
+        switch (action) {
+            case 'list':
+                if (body.length === 0) {
+                    return 'No projects yet. Create one with: project create <name>';
+                }
+                return body.map(p => `${escapeHtml(p.name)}/  ${p.files} files, runs ${escapeHtml(p.entrypoint)}`).join('\n');
+            case 'create':
+                return `Created ${escapeHtml(body.name)}. Files saved as ${escapeHtml(body.name)}/<file> belong to it, e.g. vim ${escapeHtml(body.name)}/main.py`;
+            case 'run':
+                return `$ ${escapeHtml(body.command)}\n${escapeHtml(body.stdout)}${escapeHtml(body.stderr)}[exit ${body.exit_code}]`;
+            case 'share':
+                return `🔗 ${window.location.origin}${escapeHtml(body.share_url)}`;
+            default:
+                return `Deleted ${escapeHtml(name)}.`;
+        }
+    } catch (error) {
+        return `❌ Project ${action} failed: ${error.message}`;
+    }
+}
+
+async function handleClone(args) {
+    if (!terminalState.isLoggedIn) {
+        return 'Log in to import repositories.';
+    }
+    const [url, dir] = args;
+    if (!url) {
+        return 'Usage: clone <https://github.com/owner/repo> [dir]';
+    }
+    try {
+        const response = await fetch('/api/files/import-git', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'include',
+            body: JSON.stringify({ url, dir })
+        });
+        const body = await response.json();
+        if (!response.ok) {
+            return `❌ ${body.error ? escapeHtml(body.error.message) : 'Import failed'}`;
+        }
+        addOutput(`Importing ${escapeHtml(url)}...`);
+        return await new Promise((resolve) => {
+            const events = new EventSource(body.events_url);
+            let reported = 0;
+            events.addEventListener('progress', (e) => {
+                const update = JSON.parse(e.data);
+                if (update.percent >= reported + 25) {
+                    reported = update.percent - update.percent % 25;
+                    addOutput(`${update.percent}% ${escapeHtml(update.message)}`);
+                }
+            });
+            events.addEventListener('log', (e) => addOutput(escapeHtml(JSON.parse(e.data).message)));
+            events.addEventListener('done', (e) => {
+                events.close();
+                const result = JSON.parse(e.data).result;
+                resolve(`✅ ${result.files} files in ${escapeHtml(result.dir)}/ (${result.ignored} ignored, ${result.skipped} skipped)`);
+            });
+            events.addEventListener('error', (e) => {
+                events.close();
+                resolve(`❌ ${e.data ? escapeHtml(JSON.parse(e.data).message) : 'Import failed'}`);
+            });
+        });
+    } catch (error) {
+        return `❌ Import failed: ${error.message}`;
+    }
+}
+
+async function handleGitHub(args) {
+    if (!terminalState.isLoggedIn) {
+        return 'Log in to export to GitHub.';
+    }
+    const [action, ...rest] = args;
+    const usage = 'Usage: github connect | github gist <files...> | github push <owner/repo> <files...> | github sync <id> | github list | github disconnect';
+    if (action === 'connect') {
+        window.location.href = '/api/github/connect';
+        return 'Opening GitHub...';
+    }
+    const exportRequest = (body) => fetch('/api/github/exports', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        credentials: 'include',
+        body: JSON.stringify(body)
+    });
+    const requests = {
+        list: () => fetch('/api/github/exports', { credentials: 'include' }),
+        gist: () => rest.length > 0 && exportRequest({ kind: 'gist', files: rest }),
+        push: () => rest.length > 1 && exportRequest({ kind: 'repo', repo: rest[0], files: rest.slice(1) }),
+        sync: () => rest[0] && fetch(`/api/github/exports/${encodeURIComponent(rest[0])}/sync`, { method: 'POST', credentials: 'include' }),
+        disconnect: () => fetch('/api/github', { method: 'DELETE', credentials: 'include' })
+    };
+    try {
+        const response = requests[action] && await requests[action]();
+        if (!response) {
+            return usage;
+        }
+        const body = response.status === 204 ? {} : await response.json();
+        if (!response.ok) {
+            return `❌ ${body.error ? escapeHtml(body.error.message) : `GitHub ${action} failed`}`;
+        }
+        switch (action) {
+            case 'list':
+                if (body.length === 0) {
+                    return 'No exports yet. Create one with: github gist <files...>';
+                }
+                return body.map(e => `#${e.id} ${e.kind} ${escapeHtml(e.remote)}${e.branch ? '@' + escapeHtml(e.branch) : ''}  ${e.files.length} files  ${escapeHtml(e.url)}`).join('\n');
+            case 'disconnect':
+                return 'Disconnected from GitHub.';
+            default:
+                return `✅ #${body.id} ${escapeHtml(body.url)}`;
+        }
+    } catch (error) {
+        return `❌ GitHub ${action} failed: ${error.message}`;
+    }
+}
+
+async function handleVersions(args) {
+    if (!terminalState.isLoggedIn) {
+        return 'Log in to see file versions.';
+    }
+    const [filename, action, version] = args;
+    if (!filename || (action && (!['show', 'restore'].includes(action) || !version))) {
+        return 'Usage: versions <file> | versions <file> show <n> | versions <file> restore <n>';
+    }
+    const query = `filename=${encodeURIComponent(filename)}` + (version ? `&version=${encodeURIComponent(version)}` : '');
+    const url = {
+        show: `/api/files/versions/load?${query}`,
+        restore: `/api/files/versions/restore?${query}`
+    }[action] || `/api/files/versions?${query}`;
+    try {
+        const response = await fetch(url, { method: action === 'restore' ? 'POST' : 'GET', credentials: 'include' });
+        const body = await response.json();
+        if (!response.ok) {
+            return `❌ ${body.error ? escapeHtml(body.error.message) : 'Failed to load versions'}`;
+        }
+        switch (action) {
+            case 'show':
+                return escapeHtml(body.content);
+            case 'restore':
+                return `✅ Restored version ${escapeHtml(version)} of ${escapeHtml(filename)}`;
+            default:
+                if (body.length === 0) {
+                    return `No versions of ${escapeHtml(filename)} yet.`;
+                }
+                return body.map(v => `v${v.version}  ${new Date(v.created_at).toLocaleString()}  ${v.size} bytes`).join('\n');
+        }
+    } catch (error) {
+        return `❌ Failed to load versions: ${error.message}`;
+    }
+}
+
+async function handlePasskey(args) {
+    if (!terminalState.isLoggedIn) {
+        return 'Log in to manage passkeys.';
+    }
+    const [action, ...rest] = args;
+    const usage = 'Usage: passkey add [name] | passkey list | passkey remove <id>';
+    try {
+        switch (action) {
+            case 'add': {
+                if (!passkeys.supported()) {
+                    return '❌ This browser does not support passkeys.';
+                }
+                const passkey = await passkeys.register(rest.join(' '));
+                return `✅ Added passkey #${passkey.id} ${escapeHtml(passkey.name)}. Use it on the login page to sign in without your password.`;
+            }
+            case 'list':
+            case 'remove': {
+                if (action === 'remove' && !rest[0]) {
+                    return usage;
+                }
+                const response = action === 'list'
+                    ? await fetch('/api/passkeys', { credentials: 'include' })
+                    : await fetch(`/api/passkeys/${encodeURIComponent(rest[0])}`, { method: 'DELETE', credentials: 'include' });
+                const body = response.status === 204 ? {} : await response.json();
+                if (!response.ok) {
+                    return `❌ ${body.error ? escapeHtml(body.error.message) : `Passkey ${action} failed`}`;
+                }
+                if (action === 'remove') {
+                    return `Removed passkey #${escapeHtml(rest[0])}.`;
+                }
+                if (body.length === 0) {
+                    return 'No passkeys yet. Add one with: passkey add [name]';
+                }
+                return body.map(p => `#${p.id} ${escapeHtml(p.name)}  added ${new Date(p.created_at).toLocaleDateString()}, ${p.last_used_at ? 'last used ' + new Date(p.last_used_at).toLocaleString() : 'never used'}`).join('\n');
+            }
+            default:
+                return usage;
+        }
+    } catch (error) {
+        return `❌ Passkey ${action} failed: ${escapeHtml(error.message)}`;
+    }
+}
+
+// Commands the terminal does not know run in the user's sandbox container
+// when one is available, otherwise they are not found.
+async function runInSandbox(input, command) {
+    try {
+        const response = await fetch('/api/terminal/exec', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'include',
+            body: JSON.stringify({ command: input })
+        });
+        const result = await response.json();
+        const noSandbox = response.status === 401 ||
+            (result.error && result.error.details && result.error.details.reason === 'not_configured');
+        if (noSandbox) {
+            addOutput(`Command not found: ${command}. Type 'help' for available commands.`);
+            return;
+        }
+        if (!response.ok) {
+            addOutput(`❌ ${result.error ? result.error.message : response.statusText}`);
+            return;
+        }
+        const output = (result.stdout + result.stderr).replace(/\n$/, '');
+        addOutput(escapeHtml(output) + (result.truncated ? '\n[output truncated]' : ''));
+    } catch (error) {
+        addOutput(`Error: ${error.message}`);
+    }
+}
+
+// installPackages installs allowlisted packages into the user's
+// virtualenv, which the server builds outside their sandbox.
+async function installPackages(packages) {
+    if (packages.length === 0) {
+        addOutput('Usage: pip install <package>[==version] ...');
+        return;
+    }
+    addOutput(`Installing ${escapeHtml(packages.join(' '))}...`);
+    try {
+        const response = await fetch('/api/terminal/packages', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'include',
+            body: JSON.stringify({ packages })
+        });
+        const result = await response.json();
+        if (!response.ok) {
+            const details = result.error && result.error.details;
+            let message = `❌ ${result.error ? escapeHtml(result.error.message) : response.statusText}`;
+            if (details && details.not_allowed) {
+                message += '\nOnly packages on the allowlist can be installed; ask an admin to add others.';
+            } else if (details && details.log) {
+                message += '\n' + escapeHtml(details.log);
+            }
+            addOutput(message);
+            return;
+        }
+        const installed = result.packages.map(p => p.version ? `${p.name}==${p.version}` : p.name);
+        let message = `✅ Installed: ${escapeHtml(installed.join(' '))}`;
+        if (result.dropped.length > 0) {
+            message += `\nRemoved, no longer allowed: ${escapeHtml(result.dropped.join(' '))}`;
+        }
+        addOutput(message);
+    } catch (error) {
+        addOutput(`Error: ${error.message}`);
+    }
+}
+
+// escapeHtml makes command output safe for addOutput, which sets
+// innerHTML.
+function escapeHtml(text) {
+    return String(text)
+        .replace(/&/g, '&amp;')
+        .replace(/</g, '&lt;')
+        .replace(/>/g, '&gt;');
+}
+
 // Input handling functions
 function processCommand(input) {
     const trimmedInput = input.trim();
@@ -1978,9 +2502,18 @@ function processCommand(input) {
     const command = parts[0].toLowerCase();
     const args = parts.slice(1);
     
+    // Login and register take passwords as arguments; never send those
+    if (command !== 'login' && command !== 'register') {
+        recordCommand(input);
+    }
+    const directory = terminalState.currentDirectory;
+    
     // Execute command
     if (commands[command]) {
         const result = commands[command].execute(args);
+        if (terminalState.currentDirectory !== directory) {
+            saveShellState();
+        }
         if (result && typeof result.then === 'function') {
             // Handle async commands
             result.then(output => {
@@ -1996,6 +2529,11 @@ function processCommand(input) {
     } else if (/^[1-4]$/.test(command)) {
         // Handle numbered project selection
         handleProjectSelection(parseInt(command));
+    } else if (terminalState.isLoggedIn && (command === 'pip' || command === 'pip3') && args[0] === 'install') {
+        // The sandbox has no network; installs go through the allowlist
+        installPackages(args.slice(1).filter(arg => arg));
+    } else if (terminalState.isLoggedIn) {
+        runInSandbox(trimmedInput, command);
     } else {
         addOutput(`Command not found: ${command}. Type 'help' for available commands.`);
     }
@@ -2315,6 +2853,10 @@ if (typeof module !== 'undefined' && module.exports) {
         loginUser,
         handleProjectSelection,
         processCommand,
+        recordCommand,
+        restoreShellState,
+        captureOutput,
+        handleRecord,
         getPrompt,
         updatePrompt,
         refreshCurrentLine,
@@ -263,7 +263,7 @@ const CloudSimulator = {
             const cmdElement = document.createElement('div');
             cmdElement.className = 'command-item';
             cmdElement.textContent = cmd;
-            cmdElement.onclick = () => this.executeCommand(cmd);
+            cmdElement.dataset.command = cmd;
             commandsList.appendChild(cmdElement);
         });
     },
@@ -293,6 +293,49 @@ const CloudSimulator = {
         }
     },
 
+    escapeHtml: function(text) {
+        const div = document.createElement('div');
+        div.textContent = text;
+        return div.innerHTML;
+    },
+
+    // formatDiagram lists the nodes of /api/cloudsim/diagram grouped by
+    // VPC, followed by the relationships between them.
+    formatDiagram: function(diagram) {
+        const labels = {};
+        diagram.nodes.forEach(node => { labels[node.id] = node.label + ' [' + node.type + ']'; });
+
+        const lines = [];
+        diagram.nodes.filter(node => node.type === 'ec2:vpc').forEach(vpc => {
+            lines.push(labels[vpc.id]);
+            diagram.nodes.filter(node => node.vpc === vpc.id).forEach(node => {
+                lines.push('  |- ' + labels[node.id]);
+            });
+        });
+        diagram.nodes.filter(node => node.type !== 'ec2:vpc' && !node.vpc).forEach(node => {
+            lines.push(labels[node.id]);
+        });
+        if (diagram.edges.length > 0) {
+            lines.push('');
+            diagram.edges.forEach(edge => {
+                lines.push(labels[edge.from] + ' --' + edge.relation + '--> ' + labels[edge.to]);
+            });
+        }
+        return lines.length > 0 ? lines.join('\n') : 'No simulated resources yet';
+    },
+
+    showDiagram: function() {
+        fetch('/api/cloudsim/diagram')
+            .then(response => {
+                if (!response.ok) {
+                    throw new Error('HTTP ' + response.status);
+                }
+                return response.json();
+            })
+            .then(diagram => this.showTerminalOutput('Infrastructure diagram', this.escapeHtml(this.formatDiagram(diagram))))
+            .catch(err => this.showTerminalOutput('Infrastructure diagram', 'Failed to load diagram: ' + this.escapeHtml(err.message)));
+    },
+
     handleKeydown: function(event) {
         const overlay = document.getElementById('terminalOverlay');
         
@@ -332,6 +375,11 @@ const CloudSimulator = {
                     }
                 }
                 break;
+            case 'd':
+            case 'D':
+                event.preventDefault();
+                this.showDiagram();
+                break;
             case 'Escape':
                 window.location.href = '/';
                 break;
@@ -364,6 +412,21 @@ const CloudSimulator = {
 
     init: function() {
         document.addEventListener('keydown', this.handleKeydown.bind(this));
+
+        const commandsList = document.getElementById('commandsList');
+        if (commandsList) {
+            commandsList.addEventListener('click', (event) => {
+                const item = event.target.closest('[data-command]');
+                if (item) {
+                    this.executeCommand(item.dataset.command);
+                }
+            });
+        }
+
+        const closeBtn = document.getElementById('terminalCloseBtn');
+        if (closeBtn) {
+            closeBtn.addEventListener('click', () => this.closeTerminal());
+        }
         this.updateSelection();
     }
 };
@@ -9,7 +9,10 @@ let gameState = {
     questionStartTime: null,
     answers: [],
     timer: null,
-    timeLeft: 30
+    timeLeft: 30,
+    pausedAt: null,
+    seed: null,
+    flashcardIds: []
 };
 
 // DOM Elements
@@ -21,6 +24,7 @@ const elements = {
     questionNumber: null,
     totalQuestions: null,
     timer: null,
+    pauseGame: null,
     questionText: null,
     answerInput: null,
     submitAnswer: null,
@@ -31,15 +35,42 @@ const elements = {
     finalScore: null,
     finalAccuracy: null,
     finalAvgTime: null,
+    finalSeed: null,
+    finalSeedItem: null,
     playAgain: null,
+    replaySeed: null,
     loadGuestQuestions: null,
     questionsPreview: null,
     selectAllQuestions: null,
     deselectAllQuestions: null,
     startSelectedQuestions: null,
+    shuffleQuestions: null,
     backToMenu: null
 };
 
+// Translations rendered into the page by the server (see i18n package).
+// Keys are the English strings, so a missing catalog falls back to English.
+let messages = null;
+
+function t(key, ...args) {
+    if (messages === null) {
+        messages = {};
+        const catalog = typeof document !== 'undefined' && document.getElementById('i18nMessages');
+        if (catalog && catalog.textContent) {
+            try {
+                messages = JSON.parse(catalog.textContent);
+            } catch (error) {
+                console.error('Invalid i18n catalog:', error);
+            }
+        }
+    }
+    let message = messages[key] || key;
+    args.forEach(arg => {
+        message = message.replace('%s', arg);
+    });
+    return message;
+}
+
 // Initialize DOM elements
 function initializeElements() {
     elements.coursesSection = document.querySelector('.courses-section');
@@ -49,6 +80,7 @@ function initializeElements() {
     elements.questionNumber = document.getElementById('questionNumber');
     elements.totalQuestions = document.getElementById('totalQuestions');
     elements.timer = document.getElementById('timer');
+    elements.pauseGame = document.getElementById('pauseGame');
     elements.questionText = document.getElementById('questionText');
     elements.answerInput = document.getElementById('answerInput');
     elements.submitAnswer = document.getElementById('submitAnswer');
@@ -59,12 +91,16 @@ function initializeElements() {
     elements.finalScore = document.getElementById('finalScore');
     elements.finalAccuracy = document.getElementById('finalAccuracy');
     elements.finalAvgTime = document.getElementById('finalAvgTime');
+    elements.finalSeed = document.getElementById('finalSeed');
+    elements.finalSeedItem = document.getElementById('finalSeedItem');
     elements.playAgain = document.getElementById('playAgain');
+    elements.replaySeed = document.getElementById('replaySeed');
     elements.loadGuestQuestions = document.getElementById('loadGuestQuestions');
     elements.questionsPreview = document.getElementById('questionsPreview');
     elements.selectAllQuestions = document.getElementById('selectAllQuestions');
     elements.deselectAllQuestions = document.getElementById('deselectAllQuestions');
     elements.startSelectedQuestions = document.getElementById('startSelectedQuestions');
+    elements.shuffleQuestions = document.getElementById('shuffleQuestions');
     elements.backToMenu = document.getElementById('backToMenu');
 }
 
@@ -92,6 +128,11 @@ function attachEventListeners() {
         });
     }
 
+    // Pause and resume button
+    if (elements.pauseGame) {
+        elements.pauseGame.addEventListener('click', togglePause);
+    }
+
     // Next question button
     if (elements.nextQuestion) {
         elements.nextQuestion.addEventListener('click', nextQuestion);
@@ -105,6 +146,11 @@ function attachEventListeners() {
         });
     }
 
+    // Replay a shuffled game in the same order
+    if (elements.replaySeed) {
+        elements.replaySeed.addEventListener('click', replayGame);
+    }
+
     // Guest questions button
     if (elements.loadGuestQuestions) {
         elements.loadGuestQuestions.addEventListener('click', loadGuestQuestions);
@@ -181,7 +227,7 @@ async function startGame(courseId) {
         const questions = data.questions;
         
         if (!validateQuestions(questions)) {
-            showError('No questions available for this course.');
+            showError(t('No questions available for this course.'));
             return;
         }
         
@@ -190,7 +236,7 @@ async function startGame(courseId) {
         
     } catch (error) {
         console.error('Error starting game:', error);
-        showError('Failed to start game. Please try again.');
+        showError(t('Failed to start game. Please try again.'));
     }
 }
 
@@ -205,6 +251,9 @@ function displayCurrentQuestion() {
     
     elements.questionNumber.textContent = gameState.currentQuestionIndex + 1;
     elements.questionText.textContent = question.question;
+    if (question.occlusion) {
+        elements.questionText.appendChild(renderOcclusion(question.occlusion));
+    }
     elements.answerInput.value = '';
     elements.answerInput.disabled = false;
     elements.submitAnswer.disabled = false;
@@ -216,6 +265,38 @@ function displayCurrentQuestion() {
     hideFeedback();
 }
 
+// Build an image occlusion card: the image with its masked regions drawn
+// over it. Regions are in image pixels, so they are placed in percent to
+// scale with the image.
+function renderOcclusion(occlusion) {
+    const figure = document.createElement('div');
+    figure.className = 'occlusion';
+    const img = document.createElement('img');
+    img.src = '/flashcards/media/' + occlusion.media_id;
+    img.alt = '';
+    figure.appendChild(img);
+    (occlusion.masks || []).forEach(region => {
+        const mask = document.createElement('div');
+        const isTarget = region.x === occlusion.target.x && region.y === occlusion.target.y &&
+            region.width === occlusion.target.width && region.height === occlusion.target.height;
+        mask.className = isTarget ? 'occlusion-mask occlusion-target' : 'occlusion-mask';
+        mask.style.left = (100 * region.x / occlusion.image_width) + '%';
+        mask.style.top = (100 * region.y / occlusion.image_height) + '%';
+        mask.style.width = (100 * region.width / occlusion.image_width) + '%';
+        mask.style.height = (100 * region.height / occlusion.image_height) + '%';
+        figure.appendChild(mask);
+    });
+    return figure;
+}
+
+// Lift the mask off the asked region once the answer is shown.
+function revealOcclusion() {
+    const target = elements.questionText.querySelector && elements.questionText.querySelector('.occlusion-target');
+    if (target) {
+        target.classList.add('revealed');
+    }
+}
+
 // Start countdown timer
 function startTimer() {
     clearInterval(gameState.timer);
@@ -231,8 +312,77 @@ function startTimer() {
     }, 1000);
 }
 
+// Tell the server the game is paused or resumed, so it stops the game's
+// clock and refuses answers meanwhile.
+async function setPausedOnServer(paused) {
+    if (!gameState.sessionId) {
+        return;
+    }
+    const action = paused ? 'pause' : 'resume';
+    const response = await fetch(`/api/flashcards/${action}?session_id=${gameState.sessionId}`, {
+        method: 'POST'
+    });
+    if (!response.ok) {
+        throw new Error(`Failed to ${action} game`);
+    }
+}
+
+// Stop the countdown and lock the answer until the game is resumed.
+async function pauseGame() {
+    if (gameState.pausedAt !== null) {
+        return;
+    }
+    clearInterval(gameState.timer);
+    gameState.pausedAt = Date.now();
+    disableAnswerControls();
+    elements.pauseGame.textContent = t('Resume');
+    elements.questionText.style.visibility = 'hidden';
+    try {
+        await setPausedOnServer(true);
+    } catch (error) {
+        console.error('Error pausing game:', error);
+    }
+}
+
+// Restart the countdown where it stopped. The pause counts neither towards
+// the card's time nor the game's.
+async function resumeGame() {
+    if (gameState.pausedAt === null) {
+        return;
+    }
+    try {
+        await setPausedOnServer(false);
+    } catch (error) {
+        // Play on: the server reports a lost game when the answer is sent.
+        console.error('Error resuming game:', error);
+    }
+    const pausedFor = Date.now() - gameState.pausedAt;
+    gameState.pausedAt = null;
+    gameState.startTime += pausedFor;
+    gameState.questionStartTime += pausedFor;
+    elements.pauseGame.textContent = t('Pause');
+    elements.questionText.style.visibility = 'visible';
+    if (elements.feedback.style.display !== 'block') {
+        elements.answerInput.disabled = false;
+        elements.submitAnswer.disabled = false;
+        elements.answerInput.focus();
+        startTimer();
+    }
+}
+
+function togglePause() {
+    if (gameState.pausedAt === null) {
+        pauseGame();
+    } else {
+        resumeGame();
+    }
+}
+
 // Check if answer submission is allowed
 function canSubmitAnswer(isTimeout) {
+    if (gameState.pausedAt !== null) {
+        return false;
+    }
     return !elements.submitAnswer.disabled || isTimeout;
 }
 
@@ -321,20 +471,21 @@ async function submitAnswer(isTimeout = false) {
 // Show feedback for answer
 function showFeedback(isCorrect, correctAnswer, isTimeout = false) {
     if (isTimeout) {
-        elements.feedbackTitle.textContent = 'Time\'s Up!';
-        elements.feedbackText.textContent = `The correct answer was: ${correctAnswer}`;
+        elements.feedbackTitle.textContent = t('Time\'s Up!');
+        elements.feedbackText.textContent = t('The correct answer was: %s', correctAnswer);
         elements.feedback.style.backgroundColor = '#f39c12';
     } else if (isCorrect) {
-        elements.feedbackTitle.textContent = 'Correct!';
-        elements.feedbackText.textContent = 'Well done!';
+        elements.feedbackTitle.textContent = t('Correct!');
+        elements.feedbackText.textContent = t('Well done!');
         elements.feedback.style.backgroundColor = '#27ae60';
     } else {
-        elements.feedbackTitle.textContent = 'Incorrect';
-        elements.feedbackText.textContent = `The correct answer was: ${correctAnswer}`;
+        elements.feedbackTitle.textContent = t('Incorrect');
+        elements.feedbackText.textContent = t('The correct answer was: %s', correctAnswer);
         elements.feedback.style.backgroundColor = '#e74c3c';
     }
     
     elements.feedback.style.display = 'block';
+    revealOcclusion();
 }
 
 // Hide feedback
@@ -344,6 +495,9 @@ function hideFeedback() {
 
 // Move to next question
 function nextQuestion() {
+    if (gameState.pausedAt !== null) {
+        return;
+    }
     gameState.currentQuestionIndex++;
     hideFeedback();
     
@@ -420,6 +574,14 @@ function displayResults() {
     const avgTime = calculateAverageTime(gameState.answers);
     
     updateResultsDisplay(gameState.score, totalQuestions, accuracy, avgTime);
+
+    // A shuffled game can be replayed in the same order from its seed.
+    const shuffled = gameState.seed !== null;
+    if (elements.finalSeedItem) {
+        elements.finalSeed.textContent = shuffled ? gameState.seed : '';
+        elements.finalSeedItem.style.display = shuffled ? 'block' : 'none';
+        elements.replaySeed.style.display = shuffled ? 'inline-block' : 'none';
+    }
 }
 
 // Guest Questions Functions
@@ -435,17 +597,28 @@ async function loadGuestQuestions() {
     try {
         const questions = await fetchGuestQuestions();
         if (!questions || questions.length === 0) {
-            showError('No practice questions available.');
+            showError(t('No practice questions available.'));
             return;
         }
         displayQuestionsForSelection(questions);
         showQuestionSelectionSection();
     } catch (error) {
         console.error('Error loading guest questions:', error);
-        showError('Failed to load practice questions.');
+        showError(t('Failed to load practice questions.'));
     }
 }
 
+// escapeHtml makes card text safe to interpolate into markup. Cards are
+// plain text, and older ones may predate server-side sanitizing.
+function escapeHtml(text) {
+    return String(text)
+        .replace(/&/g, '&amp;')
+        .replace(/</g, '&lt;')
+        .replace(/>/g, '&gt;')
+        .replace(/"/g, '&quot;')
+        .replace(/'/g, '&#39;');
+}
+
 function displayQuestionsForSelection(questions) {
     elements.questionsPreview.innerHTML = '';
     
@@ -459,8 +632,8 @@ function displayQuestionsForSelection(questions) {
                 <span class="question-time">${question.time}s</span>
             </div>
             <div class="question-preview-content">
-                <div class="question-text"><strong>Q:</strong> ${question.question}</div>
-                <div class="answer-text"><strong>A:</strong> ${question.answer}</div>
+                <div class="question-text"><strong>Q:</strong> ${escapeHtml(question.question)}</div>
+                <div class="answer-text"><strong>A:</strong> ${escapeHtml(question.answer)}</div>
             </div>
         `;
         elements.questionsPreview.appendChild(questionCard);
@@ -500,23 +673,54 @@ async function startSelectedQuestions() {
     }
 
     try {
+        const shuffle = Boolean(elements.shuffleQuestions && elements.shuffleQuestions.checked);
         const response = await fetch('/api/flashcards/start-guest', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ flashcard_ids: selectedIds })
+            body: JSON.stringify({ flashcard_ids: selectedIds, shuffle: shuffle })
         });
 
         if (!response.ok) {
             throw new Error('Failed to start guest game');
         }
 
-        const data = await response.json();
-        gameState.sessionId = data.session_id;
-        initializeGameState('guest', data.flashcards);
-        setupGameUI();
+        beginGuestGame(await response.json(), selectedIds);
     } catch (error) {
         console.error('Error starting selected questions:', error);
-        showError('Failed to start practice session.');
+        showError(t('Failed to start practice session.'));
+    }
+}
+
+function beginGuestGame(data, flashcardIds) {
+    gameState.sessionId = data.session_id;
+    gameState.seed = data.seed !== undefined ? data.seed : null;
+    gameState.flashcardIds = flashcardIds;
+    initializeGameState('guest', data.flashcards);
+    setupGameUI();
+}
+
+// Start the finished game again with its seed, so the cards come in the
+// same order.
+async function replayGame() {
+    const seed = gameState.seed;
+    const flashcardIds = gameState.flashcardIds;
+    resetGame();
+    try {
+        const response = await fetch('/api/flashcards/restart', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ flashcard_ids: flashcardIds, seed: seed })
+        });
+
+        if (!response.ok) {
+            throw new Error('Failed to restart game');
+        }
+
+        beginGuestGame(await response.json(), flashcardIds);
+    } catch (error) {
+        console.error('Error replaying game:', error);
+        showGameSection();
+        showError(t('Failed to start practice session.'));
     }
 }
 
@@ -550,7 +754,7 @@ function showResultsSection() {
 }
 
 function showLoadingState() {
-    elements.questionText.textContent = 'Loading questions...';
+    elements.questionText.textContent = t('Loading questions...');
 }
 
 function showError(message) {
@@ -572,8 +776,14 @@ function resetGame() {
         questionStartTime: null,
         answers: [],
         timer: null,
-        timeLeft: 30
+        timeLeft: 30,
+        pausedAt: null,
+        seed: null,
+        flashcardIds: []
     };
+    if (elements.pauseGame) {
+        elements.pauseGame.textContent = t('Pause');
+    }
 }
 
 // Initialize application
@@ -589,6 +799,7 @@ document.addEventListener('DOMContentLoaded', init);
 // Export for testing
 if (typeof module !== 'undefined' && module.exports) {
     module.exports = {
+        t,
         gameState,
         elements,
         initializeElements,
@@ -604,6 +815,8 @@ if (typeof module !== 'undefined' && module.exports) {
         updateScore,
         recordAnswer,
         submitAnswer,
+        pauseGame,
+        resumeGame,
         calculateTotalGameTime,
         createGameCompletionData,
         submitGameResults,
@@ -612,6 +825,9 @@ if (typeof module !== 'undefined' && module.exports) {
         calculateAverageTime,
         updateResultsDisplay,
         displayResults,
+        renderOcclusion,
+        escapeHtml,
+        replayGame,
         resetGame
     };
 }
\ No newline at end of file
@@ -8,6 +8,17 @@ const loginForm = document.getElementById('loginForm');
 const loginMessage = document.getElementById('loginMessage');
 const closeBtn = loginModal?.querySelector('.close');
 
+// safeRedirectPath keeps redirects on this site: "flashcards" becomes
+// "/flashcards", and anything a browser could read as another host, such
+// as "//evil.example" or "/\evil.example", falls back to /projects.
+function safeRedirectPath(path) {
+    const target = path.startsWith('/') ? path : `/${path}`;
+    if (target.startsWith('//') || /[\\\u0000-\u001f]/.test(target)) {
+        return '/projects';
+    }
+    return target;
+}
+
 // Open login modal
 function openLoginModal(redirectTo = '/projects') {
     loginRedirectPath = redirectTo;
@@ -67,12 +78,7 @@ async function handleLoginSubmit(event) {
             }
             setTimeout(() => {
                 closeLoginModal();
-                // Redirect to the specified path
-                if (loginRedirectPath.startsWith('/')) {
-                    window.location.href = loginRedirectPath;
-                } else {
-                    window.location.href = `/${loginRedirectPath}`;
-                }
+                window.location.href = safeRedirectPath(loginRedirectPath);
             }, 1000);
         } else {
             if (loginMessage) {
@@ -88,6 +94,13 @@ async function handleLoginSubmit(event) {
 
 // Event Listeners
 document.addEventListener('DOMContentLoaded', function() {
+    // Buttons that open the modal
+    document.querySelectorAll('[data-login-redirect]').forEach(function(button) {
+        button.addEventListener('click', function() {
+            openLoginModal(button.dataset.loginRedirect);
+        });
+    });
+
     // Close button
     if (closeBtn) {
         closeBtn.addEventListener('click', closeLoginModal);
@@ -1,5 +1,6 @@
 let currentStep = 'username';
 let usernameValue = '';
+const redirectToken = document.currentScript?.dataset.redirectToken || '';
 
 function showMessage(message, type = 'error') {
     const messageDiv = document.getElementById('loginMessage');
@@ -50,7 +51,7 @@ async function performLogin(username, password) {
             headers: {
                 'Content-Type': 'application/json',
             },
-            body: JSON.stringify({ username, password })
+            body: JSON.stringify({ username, password, redirect_token: redirectToken })
         });
         
         const result = await response.json();
@@ -106,9 +107,9 @@ async function handleLogin(e) {
         
         if (result.success) {
             showMessage('Login successful! Redirecting...', 'success');
+            // The server only returns a redirect it signed and checked.
             setTimeout(() => {
-                const redirect = document.querySelector('script[src*="login.js"]')?.getAttribute('data-redirect');
-                window.location.href = redirect || '/projects';
+                window.location.href = result.redirect || '/projects';
             }, 1000);
         } else {
             showMessage(result.message);
@@ -118,6 +119,40 @@ async function handleLogin(e) {
     }
 }
 
+// handlePasskey signs in with a passkey instead of a password. A username
+// typed in first narrows the choice to that account's passkeys.
+async function handlePasskey() {
+    clearMessage();
+    try {
+        const username = document.getElementById('username').value.trim();
+        const result = await passkeys.signIn(username, redirectToken);
+        showMessage('Login successful! Redirecting...', 'success');
+        setTimeout(() => {
+            window.location.href = result.redirect || '/projects';
+        }, 1000);
+    } catch (error) {
+        // Closing the browser's passkey prompt is not an error.
+        if (error.name !== 'NotAllowedError') {
+            showMessage(error.message);
+        }
+    }
+}
+
+async function handleGuest(e) {
+    e.preventDefault();
+    try {
+        const response = await fetch('/api/guest', { method: 'POST' });
+        if (!response.ok) {
+            const result = await response.json();
+            showMessage(result.error ? result.error.message : 'Could not start a guest session');
+            return;
+        }
+        window.location.href = '/projects';
+    } catch (error) {
+        showMessage('Network error. Please try again.');
+    }
+}
+
 function handleUsernameEnter(e) {
     if (e.key === 'Enter') {
         handleUsernameNext();
@@ -129,6 +164,12 @@ function initializeLogin() {
     document.getElementById('backBtn').addEventListener('click', handleBack);
     document.getElementById('username').addEventListener('keypress', handleUsernameEnter);
     document.getElementById('loginForm').addEventListener('submit', handleLogin);
+    document.getElementById('guestLink').addEventListener('click', handleGuest);
+    if (passkeys.supported()) {
+        const passkeyBtn = document.getElementById('passkeyBtn');
+        passkeyBtn.hidden = false;
+        passkeyBtn.addEventListener('click', handlePasskey);
+    }
     document.getElementById('username').focus();
 }
 
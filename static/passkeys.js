@@ -0,0 +1,76 @@
+// Passkey ceremonies for the login page and the terminal. The server sends
+// and expects binary WebAuthn fields as unpadded base64url; these helpers
+// convert them for navigator.credentials.
+const passkeys = (() => {
+    function toBytes(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        return Uint8Array.from(atob(base64), c => c.charCodeAt(0));
+    }
+
+    function toBase64url(buffer) {
+        const bytes = new Uint8Array(buffer);
+        let binary = '';
+        bytes.forEach(b => { binary += String.fromCharCode(b); });
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    async function post(url, body) {
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'include',
+            body: JSON.stringify(body || {})
+        });
+        const result = await response.json();
+        if (!response.ok) {
+            throw new Error(result.error ? result.error.message : 'Passkey request failed');
+        }
+        return result;
+    }
+
+    function supported() {
+        return typeof window.PublicKeyCredential === 'function';
+    }
+
+    // register creates a passkey for the signed-in account and returns it
+    // as the server saved it.
+    async function register(name) {
+        const options = await post('/api/passkeys/register/options');
+        options.challenge = toBytes(options.challenge);
+        options.user.id = toBytes(options.user.id);
+        options.excludeCredentials.forEach(c => { c.id = toBytes(c.id); });
+
+        const credential = await navigator.credentials.create({ publicKey: options });
+        return post('/api/passkeys/register', {
+            name,
+            response: {
+                clientDataJSON: toBase64url(credential.response.clientDataJSON),
+                attestationObject: toBase64url(credential.response.attestationObject),
+                transports: credential.response.getTransports ? credential.response.getTransports() : []
+            }
+        });
+    }
+
+    // signIn signs in with a passkey, offering username's passkeys if one
+    // is given, and returns the login response.
+    async function signIn(username, redirectToken) {
+        const options = await post('/api/passkeys/login/options', { username });
+        options.challenge = toBytes(options.challenge);
+        options.allowCredentials.forEach(c => { c.id = toBytes(c.id); });
+
+        const credential = await navigator.credentials.get({ publicKey: options });
+        const response = credential.response;
+        return post('/api/passkeys/login', {
+            id: toBase64url(credential.rawId),
+            response: {
+                clientDataJSON: toBase64url(response.clientDataJSON),
+                authenticatorData: toBase64url(response.authenticatorData),
+                signature: toBase64url(response.signature),
+                userHandle: response.userHandle ? toBase64url(response.userHandle) : undefined
+            },
+            redirect_token: redirectToken
+        });
+    }
+
+    return { supported, register, signIn };
+})();
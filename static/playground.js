@@ -0,0 +1,150 @@
+// UnleashedJS playground: run programs with streamed output and share snippets
+
+const sourceInput = document.getElementById('playgroundSource');
+const nameInput = document.getElementById('playgroundName');
+const runButton = document.getElementById('playgroundRun');
+const saveButton = document.getElementById('playgroundSave');
+const outputEl = document.getElementById('playgroundOutput');
+const diagnosticsEl = document.getElementById('playgroundDiagnostics');
+const messageEl = document.getElementById('playgroundMessage');
+
+function showMessage(text, type) {
+    messageEl.textContent = text;
+    messageEl.className = 'message' + (type ? ' ' + type : '');
+}
+
+function showDiagnostics(diagnostics) {
+    diagnosticsEl.textContent = '';
+    (diagnostics || []).forEach(function(d) {
+        const li = document.createElement('li');
+        li.textContent = d.line + ':' + d.column + ' ' + d.severity + ' [' + d.code + '] ' + d.message;
+        diagnosticsEl.appendChild(li);
+    });
+}
+
+function handleEvent(name, data) {
+    if (name === 'output') {
+        outputEl.textContent += data.text;
+    } else if (name === 'result') {
+        showDiagnostics(data.compile.diagnostics);
+        if (!data.run) {
+            showMessage('Compilation failed', 'error');
+        } else if (data.run.error) {
+            showMessage('Runtime error at ' + data.run.error.line + ':' + data.run.error.column + ': ' + data.run.error.message, 'error');
+        } else {
+            showMessage('Finished in ' + (data.run.duration_ns / 1e6).toFixed(2) + ' ms (' + data.run.steps + ' steps)', 'success');
+        }
+    } else if (name === 'error') {
+        showMessage(data.message, 'error');
+    }
+}
+
+// parseEvents consumes complete "event:/data:" blocks from buffer and
+// returns whatever is left for the next chunk.
+function parseEvents(buffer) {
+    let end;
+    while ((end = buffer.indexOf('\n\n')) !== -1) {
+        const block = buffer.slice(0, end);
+        buffer = buffer.slice(end + 2);
+        let name = 'message';
+        const data = [];
+        block.split('\n').forEach(function(line) {
+            if (line.startsWith('event: ')) {
+                name = line.slice(7);
+            } else if (line.startsWith('data: ')) {
+                data.push(line.slice(6));
+            }
+        });
+        if (data.length > 0) {
+            handleEvent(name, JSON.parse(data.join('\n')));
+        }
+    }
+    return buffer;
+}
+
+async function runProgram() {
+    outputEl.textContent = '';
+    diagnosticsEl.textContent = '';
+    showMessage('Running...');
+    runButton.disabled = true;
+
+    try {
+        const response = await fetch('/api/ujs/execute', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ source: sourceInput.value })
+        });
+        if (!response.ok) {
+            const body = await response.json();
+            showMessage(body.error ? body.error.message : 'Run failed', 'error');
+            return;
+        }
+
+        const reader = response.body.getReader();
+        const decoder = new TextDecoder();
+        let buffer = '';
+        for (;;) {
+            const { value, done } = await reader.read();
+            if (done) {
+                break;
+            }
+            buffer = parseEvents(buffer + decoder.decode(value, { stream: true }));
+        }
+    } catch (error) {
+        showMessage('Run failed: ' + error.message, 'error');
+    } finally {
+        runButton.disabled = false;
+    }
+}
+
+async function saveSnippet() {
+    const name = nameInput.value.trim() || 'untitled';
+    try {
+        const response = await fetch('/api/ujs/snippets', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            credentials: 'same-origin',
+            body: JSON.stringify({ name: name, source: sourceInput.value })
+        });
+        const body = await response.json();
+        if (response.status === 401) {
+            showMessage('Log in to save and share snippets', 'error');
+            return;
+        }
+        if (!response.ok) {
+            showMessage(body.error ? body.error.message : 'Save failed', 'error');
+            return;
+        }
+        const url = window.location.origin + body.url;
+        history.replaceState(null, '', body.url);
+        showMessage('Saved. Share link: ' + url, 'success');
+    } catch (error) {
+        showMessage('Save failed: ' + error.message, 'error');
+    }
+}
+
+async function loadSharedSnippet() {
+    const token = new URLSearchParams(window.location.search).get('s');
+    if (!token) {
+        return;
+    }
+    try {
+        const response = await fetch('/api/ujs/snippets?token=' + encodeURIComponent(token));
+        if (!response.ok) {
+            showMessage('Snippet not found', 'error');
+            return;
+        }
+        const snippet = await response.json();
+        sourceInput.value = snippet.source;
+        nameInput.value = snippet.name;
+        showMessage('Loaded "' + snippet.name + '" by ' + snippet.author);
+    } catch (error) {
+        showMessage('Failed to load snippet: ' + error.message, 'error');
+    }
+}
+
+document.addEventListener('DOMContentLoaded', function() {
+    runButton.addEventListener('click', runProgram);
+    saveButton.addEventListener('click', saveSnippet);
+    loadSharedSnippet();
+});
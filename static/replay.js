@@ -0,0 +1,93 @@
+// Terminal replay: plays a shared recording's output events in real time
+
+const titleEl = document.getElementById('replayTitle');
+const screenEl = document.getElementById('replayScreen');
+const playButton = document.getElementById('replayPlay');
+const speedSelect = document.getElementById('replaySpeed');
+const timeEl = document.getElementById('replayTime');
+const downloadLink = document.getElementById('replayDownload');
+const messageEl = document.getElementById('replayMessage');
+
+// The recording URL ends with its token: /replay/<token>
+const token = window.location.pathname.split('/').pop();
+let recording = null;
+let position = 0; // index of the next event to play
+let timer = null;
+
+function showMessage(text, type) {
+    messageEl.textContent = text;
+    messageEl.className = 'message' + (type ? ' ' + type : '');
+}
+
+function formatTime(seconds) {
+    const s = Math.floor(seconds);
+    return Math.floor(s / 60) + ':' + String(s % 60).padStart(2, '0');
+}
+
+// ANSI escape sequences are dropped; the player shows plain text.
+function stripEscapes(text) {
+    return text.replace(/\x1b\[[0-9;?]*[A-Za-z]/g, '').replace(/\r\n/g, '\n');
+}
+
+function pause() {
+    clearTimeout(timer);
+    timer = null;
+    playButton.textContent = 'Play';
+}
+
+function step() {
+    const events = recording.events;
+    const event = events[position];
+    if (event[1] === 'o') {
+        screenEl.textContent += stripEscapes(event[2]);
+        screenEl.scrollTop = screenEl.scrollHeight;
+    }
+    timeEl.textContent = formatTime(event[0]) + ' / ' + formatTime(recording.duration);
+    position++;
+    if (position >= events.length) {
+        pause();
+        playButton.textContent = 'Replay';
+        return;
+    }
+    const delay = (events[position][0] - event[0]) * 1000 / Number(speedSelect.value);
+    timer = setTimeout(step, delay);
+}
+
+function togglePlay() {
+    if (timer) {
+        pause();
+        return;
+    }
+    if (position >= recording.events.length) {
+        position = 0;
+        screenEl.textContent = '';
+    }
+    playButton.textContent = 'Pause';
+    step();
+}
+
+async function loadRecording() {
+    const url = '/api/terminal/replays/' + encodeURIComponent(token);
+    downloadLink.href = url + '?format=cast';
+    try {
+        const response = await fetch(url);
+        if (!response.ok) {
+            showMessage('Recording not found', 'error');
+            return;
+        }
+        recording = await response.json();
+        titleEl.textContent = recording.title;
+        showMessage('Recorded by ' + recording.author + ' on ' + new Date(recording.created_at).toLocaleDateString());
+        screenEl.style.width = recording.width + 'ch';
+        screenEl.style.height = recording.height * 1.2 + 'em';
+        timeEl.textContent = '0:00 / ' + formatTime(recording.duration);
+        playButton.disabled = false;
+    } catch (error) {
+        showMessage('Failed to load recording: ' + error.message, 'error');
+    }
+}
+
+document.addEventListener('DOMContentLoaded', function() {
+    playButton.addEventListener('click', togglePlay);
+    loadRecording();
+});
@@ -0,0 +1,95 @@
+// Package static embeds the front-end assets and serves them with
+// content-hash fingerprinted URLs.
+package static
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// FS holds the assets compiled into the binary.
+//
+//go:embed *.js *.css examples
+var FS embed.FS
+
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// Assets serves files from an fs.FS. When fingerprinting is enabled every
+// file is also reachable under a name containing a hash of its content
+// (app.js -> app.1a2b3c4d5e6f.js); those URLs are cached for a year since
+// any change to the file produces a new URL.
+type Assets struct {
+	fsys     fs.FS
+	files    http.Handler
+	hashed   map[string]string // original name -> fingerprinted name
+	original map[string]string // fingerprinted name -> original name
+}
+
+// NewAssets indexes fsys. With fingerprint false, URL returns plain paths and
+// every response is revalidated, which suits editing files on disk.
+func NewAssets(fsys fs.FS, fingerprint bool) (*Assets, error) {
+	a := &Assets{
+		fsys:     fsys,
+		files:    http.FileServerFS(fsys),
+		hashed:   make(map[string]string),
+		original: make(map[string]string),
+	}
+	if !fingerprint {
+		return a, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		hashedName := fingerprintName(name, content)
+		a.hashed[name] = hashedName
+		a.original[hashedName] = name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func fingerprintName(name string, content []byte) string {
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])[:12]
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + digest + ext
+}
+
+// URL returns the public URL for the named asset, fingerprinted when possible.
+func (a *Assets) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if hashedName, ok := a.hashed[name]; ok {
+		return "/static/" + hashedName
+	}
+	return "/static/" + name
+}
+
+// ServeHTTP serves assets relative to the /static/ prefix, which must be
+// stripped by the caller.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if originalName, ok := a.original[name]; ok {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + originalName
+		a.files.ServeHTTP(w, r2)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	a.files.ServeHTTP(w, r)
+}
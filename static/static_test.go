@@ -0,0 +1,91 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testAssets(t *testing.T, fingerprint bool) *Assets {
+	t.Helper()
+	fsys := fstest.MapFS{
+		"app.js":            {Data: []byte("console.log('hi');")},
+		"examples/hello.py": {Data: []byte("print('hello')")},
+	}
+	assets, err := NewAssets(fsys, fingerprint)
+	if err != nil {
+		t.Fatalf("NewAssets failed: %v", err)
+	}
+	return assets
+}
+
+func serve(assets *Assets, path string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/static"+path, nil)
+	http.StripPrefix("/static", assets).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestFingerprintedURL(t *testing.T) {
+	assets := testAssets(t, true)
+
+	url := assets.URL("app.js")
+	if url == "/static/app.js" || !strings.HasPrefix(url, "/static/app.") || !strings.HasSuffix(url, ".js") {
+		t.Errorf("expected fingerprinted URL, got %q", url)
+	}
+	if got := assets.URL("examples/hello.py"); !strings.HasPrefix(got, "/static/examples/hello.") {
+		t.Errorf("expected nested asset to be fingerprinted, got %q", got)
+	}
+	if got := assets.URL("missing.css"); got != "/static/missing.css" {
+		t.Errorf("unknown assets should fall back to plain path, got %q", got)
+	}
+}
+
+func TestServeFingerprintedAssetIsImmutable(t *testing.T) {
+	assets := testAssets(t, true)
+	url := assets.URL("app.js")
+
+	rr := serve(assets, strings.TrimPrefix(url, "/static"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != immutableCacheControl {
+		t.Errorf("Cache-Control = %q, want %q", cc, immutableCacheControl)
+	}
+	if !strings.Contains(rr.Body.String(), "console.log") {
+		t.Errorf("unexpected body %q", rr.Body.String())
+	}
+}
+
+func TestServePlainAssetRevalidates(t *testing.T) {
+	assets := testAssets(t, true)
+
+	rr := serve(assets, "/app.js")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Cache-Control = %q, want no-cache", cc)
+	}
+}
+
+func TestWithoutFingerprinting(t *testing.T) {
+	assets := testAssets(t, false)
+	if got := assets.URL("app.js"); got != "/static/app.js" {
+		t.Errorf("URL = %q, want plain path", got)
+	}
+}
+
+func TestEmbeddedAssets(t *testing.T) {
+	assets, err := NewAssets(FS, true)
+	if err != nil {
+		t.Fatalf("NewAssets(FS) failed: %v", err)
+	}
+	for _, name := range []string{"app.js", "style.css", "examples/hello.py"} {
+		if assets.URL(name) == "/static/"+name {
+			t.Errorf("embedded asset %s was not fingerprinted", name)
+		}
+	}
+}
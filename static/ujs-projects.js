@@ -0,0 +1,66 @@
+// UnleashedJS project card: shows the signed-in user's latest benchmark
+
+function formatNs(ns) {
+    if (ns >= 1e6) {
+        return (ns / 1e6).toFixed(2) + ' ms';
+    }
+    if (ns >= 1e3) {
+        return (ns / 1e3).toFixed(1) + ' µs';
+    }
+    return ns + ' ns';
+}
+
+function renderLatestRun(container, run) {
+    const lines = [
+        'Latest run (' + run.runtime + '): median ' + formatNs(run.median_ns) +
+            ' over ' + run.iterations + ' iterations, ' + run.steps + ' steps'
+    ];
+    const kernels = (run.result && run.result.kernels) || [];
+    kernels.forEach(function(kernel) {
+        let line = kernel.name + ': ' + kernel.ns_per_op.toFixed(2) + ' ns/op';
+        if (kernel.cycles_per_op) {
+            line += ', ' + kernel.cycles_per_op.toFixed(1) + ' cycles/op';
+        }
+        lines.push(line);
+    });
+
+    container.textContent = '';
+    lines.forEach(function(text) {
+        const p = document.createElement('p');
+        p.textContent = text;
+        container.appendChild(p);
+    });
+    container.hidden = false;
+}
+
+async function loadLatestRun() {
+    const container = document.getElementById('ujsLatest');
+    const loginButton = document.getElementById('ujsLogin');
+    if (!container) {
+        return;
+    }
+
+    try {
+        const response = await fetch('/api/ujs/runs?limit=1', { credentials: 'same-origin' });
+        if (response.status === 401) {
+            if (loginButton) {
+                loginButton.hidden = false;
+            }
+            return;
+        }
+        if (!response.ok) {
+            return;
+        }
+        const data = await response.json();
+        if (data.runs && data.runs.length > 0) {
+            renderLatestRun(container, data.runs[0]);
+        } else {
+            container.textContent = 'No benchmark runs yet. POST your program to /api/ujs/benchmark to see real numbers here.';
+            container.hidden = false;
+        }
+    } catch (error) {
+        console.error('Failed to load UnleashedJS runs:', error);
+    }
+}
+
+document.addEventListener('DOMContentLoaded', loadLatestRun);
@@ -0,0 +1,230 @@
+// Package status serves the public status page: recent uptime, the server
+// error rate and the health of each dependency, as HTML at /status and as
+// JSON for badges. Figures are per instance and start over on restart.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"allanswebterminal/health"
+	"allanswebterminal/metrics"
+	"allanswebterminal/templates"
+)
+
+const (
+	Operational = "operational"
+	Degraded    = "degraded"
+	Down        = "down"
+)
+
+// ErrorRateThreshold is the share of requests failing with a server error,
+// over the last ErrorWindow, above which the site shows as degraded.
+var ErrorRateThreshold = 0.05
+
+const (
+	// ErrorWindow is how far back the error rate looks.
+	ErrorWindow = time.Hour
+	// UptimeWindow is how far back uptime looks; Sample keeps one result
+	// per minute of it.
+	UptimeWindow = 24 * time.Hour
+	// freshFor is how long a sample stands in for the current health, so
+	// visitors cannot make the page run the checks on every request.
+	freshFor = 30 * time.Second
+)
+
+// sample is the outcome of the readiness checks at one time.
+type sample struct {
+	at     time.Time
+	ok     bool
+	checks map[string]bool
+}
+
+var (
+	started = time.Now()
+
+	samples   []sample // oldest first, at most one per minute of UptimeWindow
+	samplesMu sync.Mutex
+)
+
+// Sample runs the readiness checks and records the outcome. It runs as a
+// job every minute; the page also samples when the last one is stale.
+func Sample(ctx context.Context) error {
+	record(health.RunChecks(ctx), time.Now())
+	return nil
+}
+
+func record(r health.ReadinessResponse, at time.Time) sample {
+	s := sample{at: at, ok: r.Status == "ok", checks: make(map[string]bool, len(r.Checks))}
+	for name, c := range r.Checks {
+		s.checks[name] = c.Status == "ok"
+	}
+
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+	samples = append(samples, s)
+	limit := int(UptimeWindow / time.Minute)
+	if len(samples) > limit {
+		samples = append(samples[:0:0], samples[len(samples)-limit:]...)
+	}
+	return s
+}
+
+// refreshMu makes visitors who find the last sample stale wait for one
+// new sample rather than each take their own.
+var refreshMu sync.Mutex
+
+// latest returns the newest sample, taking one if it is older than
+// freshFor.
+func latest(ctx context.Context) sample {
+	if s := newest(); time.Since(s.at) < freshFor {
+		return s
+	}
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	if s := newest(); time.Since(s.at) < freshFor {
+		return s
+	}
+	return record(health.RunChecks(ctx), time.Now())
+}
+
+func newest() sample {
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+	if n := len(samples); n > 0 {
+		return samples[n-1]
+	}
+	return sample{}
+}
+
+// uptime is the share of samples since since that passed.
+func uptime(since time.Time) float64 {
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+	var total, ok int
+	for _, s := range samples {
+		if s.at.After(since) {
+			total++
+			if s.ok {
+				ok++
+			}
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(ok) / float64(total)
+}
+
+// Check is the health of one dependency. Errors are left out: the page
+// is public.
+type Check struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+}
+
+// Report is the status page's content.
+type Report struct {
+	Status string `json:"status"`
+	// Uptime is the share of readiness checks that passed over the last
+	// UptimeWindow, from 0 to 1.
+	Uptime float64 `json:"uptime"`
+	// ErrorRate is the share of requests answered with a server error over
+	// the last ErrorWindow, from 0 to 1.
+	ErrorRate float64   `json:"error_rate"`
+	Requests  int       `json:"requests"`
+	Checks    []Check   `json:"checks"`
+	UpSince   time.Time `json:"up_since"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Build puts together the current report.
+func Build(ctx context.Context) Report {
+	s := latest(ctx)
+	now := time.Now()
+	requests, errors := metrics.ErrorRate(ErrorWindow)
+	r := Report{
+		Uptime:    uptime(now.Add(-UptimeWindow)),
+		Requests:  requests,
+		Checks:    []Check{},
+		UpSince:   started.UTC(),
+		CheckedAt: s.at.UTC(),
+	}
+	if requests > 0 {
+		r.ErrorRate = float64(errors) / float64(requests)
+	}
+
+	failing := 0
+	for name, ok := range s.checks {
+		r.Checks = append(r.Checks, Check{Name: name, OK: ok})
+		if !ok {
+			failing++
+		}
+	}
+	sort.Slice(r.Checks, func(i, j int) bool { return r.Checks[i].Name < r.Checks[j].Name })
+
+	switch {
+	case failing > 0 && failing == len(r.Checks):
+		r.Status = Down
+	case failing > 0 || r.ErrorRate > ErrorRateThreshold:
+		r.Status = Degraded
+	default:
+		r.Status = Operational
+	}
+	return r
+}
+
+// PageHandler renders the status page.
+func PageHandler(w http.ResponseWriter, r *http.Request) {
+	report := Build(r.Context())
+	w.Header().Set("Cache-Control", "no-store")
+	data := struct {
+		Report
+		UptimePercent    string
+		ErrorRatePercent string
+	}{report, percent(report.Uptime), percent(report.ErrorRate)}
+	if err := templates.Render(w, r, "status", data); err != nil {
+		log.Printf("Failed to render the status page: %v", err)
+		http.Error(w, "Failed to render the status page", http.StatusInternalServerError)
+	}
+}
+
+// JSONHandler serves the report as JSON.
+func JSONHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, Build(r.Context()))
+}
+
+// badgeColors are the shields.io colours of each status.
+var badgeColors = map[string]string{Operational: "brightgreen", Degraded: "yellow", Down: "red"}
+
+// BadgeHandler serves the status in the shields.io endpoint format, so a
+// README can show it with
+// https://img.shields.io/endpoint?url=<site>/status/badge.json.
+func BadgeHandler(w http.ResponseWriter, r *http.Request) {
+	report := Build(r.Context())
+	writeJSON(w, map[string]any{
+		"schemaVersion": 1,
+		"label":         "status",
+		"message":       fmt.Sprintf("%s · %s uptime", report.Status, percent(report.Uptime)),
+		"color":         badgeColors[report.Status],
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(v)
+}
+
+// percent formats a share as a percentage with two decimals, such as
+// "99.95%".
+func percent(share float64) string {
+	return fmt.Sprintf("%.2f%%", share*100)
+}
@@ -0,0 +1,116 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"allanswebterminal/health"
+	"allanswebterminal/templates"
+)
+
+func resetSamples(t *testing.T) {
+	t.Helper()
+	samplesMu.Lock()
+	samples = nil
+	samplesMu.Unlock()
+	t.Cleanup(func() {
+		samplesMu.Lock()
+		samples = nil
+		samplesMu.Unlock()
+	})
+}
+
+func readiness(checks map[string]bool) health.ReadinessResponse {
+	r := health.ReadinessResponse{Status: "ok", Checks: map[string]health.CheckResult{}}
+	for name, ok := range checks {
+		status := "ok"
+		if !ok {
+			status, r.Status = "error", "unavailable"
+		}
+		r.Checks[name] = health.CheckResult{Status: status}
+	}
+	return r
+}
+
+func TestBuild(t *testing.T) {
+	resetSamples(t)
+	now := time.Now()
+	record(readiness(map[string]bool{"database": true}), now.Add(-25*time.Hour))
+	for i := 3; i >= 1; i-- {
+		record(readiness(map[string]bool{"database": true}), now.Add(-time.Duration(i)*time.Minute))
+	}
+	record(readiness(map[string]bool{"database": false, "templates": true}), now)
+
+	r := Build(context.Background())
+	if r.Status != Degraded {
+		t.Errorf("Status = %q, want degraded", r.Status)
+	}
+	if r.Uptime != 0.75 {
+		t.Errorf("Uptime = %v, want 0.75 (older samples are out of the window)", r.Uptime)
+	}
+	if len(r.Checks) != 2 || r.Checks[0] != (Check{"database", false}) || r.Checks[1] != (Check{"templates", true}) {
+		t.Errorf("Checks = %+v", r.Checks)
+	}
+
+	record(readiness(map[string]bool{"database": false, "templates": false}), time.Now())
+	if r := Build(context.Background()); r.Status != Down {
+		t.Errorf("every check failing: Status = %q, want down", r.Status)
+	}
+}
+
+func TestBuildSamplesWhenStale(t *testing.T) {
+	resetSamples(t)
+	health.Register("status_test", func(context.Context) error { return errors.New("secret detail") })
+
+	r := Build(context.Background())
+	if r.Status != Down || r.Uptime != 0 {
+		t.Errorf("report = %+v", r)
+	}
+	body, _ := json.Marshal(r)
+	if strings.Contains(string(body), "secret detail") {
+		t.Errorf("the report shows a check error: %s", body)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	resetSamples(t)
+	record(readiness(map[string]bool{"database": true}), time.Now())
+
+	rec := httptest.NewRecorder()
+	BadgeHandler(rec, httptest.NewRequest(http.MethodGet, "/status/badge.json", nil))
+	var badge struct {
+		SchemaVersion int    `json:"schemaVersion"`
+		Message       string `json:"message"`
+		Color         string `json:"color"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&badge); err != nil {
+		t.Fatal(err)
+	}
+	if badge.SchemaVersion != 1 || badge.Color != "brightgreen" || badge.Message != "operational · 100.00% uptime" {
+		t.Errorf("badge = %+v", badge)
+	}
+
+	renderer, err := templates.New(templates.FS, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	if err := renderer.Render(rec, httptest.NewRequest(http.MethodGet, "/status", nil), "status", struct {
+		Report
+		UptimePercent    string
+		ErrorRatePercent string
+	}{Build(context.Background()), "100.00%", "0.00%"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"All systems operational", "database: ✅ ok", "100.00%"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("status page lacks %q", want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package templates
+
+import "embed"
+
+// FS holds the page templates compiled into the binary.
+//
+//go:embed *.html layouts partials
+var FS embed.FS
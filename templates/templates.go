@@ -0,0 +1,229 @@
+// Package templates parses the HTML page templates in this directory once,
+// composes them with the shared layout and partials, and renders them.
+package templates
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+
+	"allanswebterminal/flash"
+	"allanswebterminal/i18n"
+	"allanswebterminal/middleware"
+)
+
+// Renderer holds the compiled page templates. In dev mode every render
+// re-parses from the filesystem so edits show up without a restart.
+type Renderer struct {
+	fsys  fs.FS
+	dev   bool
+	funcs template.FuncMap
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+}
+
+// defaultFuncs are available to every template. "asset" resolves a static
+// file name to its URL and is normally replaced with the fingerprinting
+// version from the static package. "nonce", "t", "locale", "catalog" and
+// "flashes" are bound per render to the request; see requestFuncs.
+func defaultFuncs() template.FuncMap {
+	return template.FuncMap{
+		"dict": dict,
+		"asset": func(name string) string {
+			return "/static/" + strings.TrimPrefix(name, "/")
+		},
+	}
+}
+
+// requestFuncs are the functions whose results depend on the request: the
+// CSP nonce for inline scripts, translations into the request locale and
+// the flash messages waiting to be shown.
+func requestFuncs(nonce, locale string, flashes []flash.Message) template.FuncMap {
+	return template.FuncMap{
+		"nonce":   func() string { return nonce },
+		"locale":  func() string { return locale },
+		"flashes": func() []flash.Message { return flashes },
+		"t": func(key string, args ...interface{}) string {
+			return i18n.T(locale, key, args...)
+		},
+		"catalog": func() map[string]string { return i18n.Catalog(locale) },
+	}
+}
+
+// New parses the layouts, partials, and pages found in fsys. Functions in
+// extra override the defaults with the same name.
+func New(fsys fs.FS, dev bool, extra template.FuncMap) (*Renderer, error) {
+	funcs := defaultFuncs()
+	for name, fn := range requestFuncs("", i18n.Default, nil) {
+		funcs[name] = fn
+	}
+	for name, fn := range extra {
+		funcs[name] = fn
+	}
+
+	r := &Renderer{fsys: fsys, dev: dev, funcs: funcs}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Renderer) load() error {
+	shared, err := template.New("").Funcs(r.funcs).ParseFS(r.fsys, "layouts/*.html", "partials/*.html")
+	if err != nil {
+		return fmt.Errorf("failed to parse layouts: %w", err)
+	}
+
+	files, err := fs.Glob(r.fsys, "*.html")
+	if err != nil {
+		return err
+	}
+
+	pages := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		page, err := shared.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := page.ParseFS(r.fsys, file); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		pages[strings.TrimSuffix(file, ".html")] = page
+	}
+
+	r.mu.Lock()
+	r.pages = pages
+	r.mu.Unlock()
+	return nil
+}
+
+// Pages returns the names of the loaded pages.
+func (r *Renderer) Pages() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.pages))
+	for name := range r.pages {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Render executes the named page inside the base layout. Output is buffered
+// so a template error never leaves a half-written page.
+//
+// The parsed pages are never executed directly: each render clones one and
+// binds the request functions (CSP nonce, locale, flash messages) to req,
+// since html/template cannot change functions on a template once it has
+// run. Flash messages are cleared once the page has rendered.
+func (r *Renderer) Render(w http.ResponseWriter, req *http.Request, name string, data interface{}) error {
+	if r.dev {
+		if err := r.load(); err != nil {
+			return err
+		}
+	}
+
+	r.mu.RLock()
+	page, ok := r.pages[name]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("template %q not found", name)
+	}
+
+	view, err := page.Clone()
+	if err != nil {
+		return err
+	}
+	flashes := flash.Get(req)
+	view.Funcs(requestFuncs(middleware.Nonce(req), i18n.Locale(req), flashes))
+
+	var buf bytes.Buffer
+	if err := view.ExecuteTemplate(&buf, "base", data); err != nil {
+		return err
+	}
+	if len(flashes) > 0 {
+		flash.Clear(w)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+var (
+	defaultMu       sync.Mutex
+	defaultRenderer *Renderer
+)
+
+// Init replaces the package-level renderer used by Render.
+func Init(fsys fs.FS, dev bool, extra template.FuncMap) error {
+	renderer, err := New(fsys, dev, extra)
+	if err != nil {
+		return err
+	}
+
+	defaultMu.Lock()
+	defaultRenderer = renderer
+	defaultMu.Unlock()
+	return nil
+}
+
+// Default returns the package-level renderer, falling back to the embedded
+// templates when Init has not been called.
+func Default() (*Renderer, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultRenderer == nil {
+		renderer, err := New(FS, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		defaultRenderer = renderer
+	}
+	return defaultRenderer, nil
+}
+
+// Check is the readiness check confirming page templates are loaded.
+func Check(ctx context.Context) error {
+	renderer, err := Default()
+	if err != nil {
+		return err
+	}
+	if len(renderer.Pages()) == 0 {
+		return errors.New("no page templates loaded")
+	}
+	return nil
+}
+
+// Render renders the named page with the package-level renderer.
+func Render(w http.ResponseWriter, req *http.Request, name string, data interface{}) error {
+	renderer, err := Default()
+	if err != nil {
+		return err
+	}
+	return renderer.Render(w, req, name, data)
+}
+
+// dict builds a map from alternating keys and values so partials can take
+// several named arguments.
+func dict(values ...interface{}) (map[string]interface{}, error) {
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("dict requires an even number of arguments")
+	}
+	m := make(map[string]interface{}, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		key, ok := values[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings")
+		}
+		m[key] = values[i+1]
+	}
+	return m, nil
+}
@@ -0,0 +1,205 @@
+package templates
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"allanswebterminal/flash"
+	"allanswebterminal/middleware"
+)
+
+func testFS(content string) fstest.MapFS {
+	return fstest.MapFS{
+		"layouts/base.html":      {Data: []byte(`{{define "base"}}<title>{{template "title" .}}</title>{{template "content" .}}{{end}}`)},
+		"partials/greeting.html": {Data: []byte(`{{define "greeting"}}Hello {{.Name}}{{end}}`)},
+		"page.html":              {Data: []byte(`{{define "title"}}Page{{end}}{{define "content"}}` + content + `{{end}}`)},
+	}
+}
+
+func TestRendererRendersPageInLayout(t *testing.T) {
+	renderer, err := New(testFS(`{{template "greeting" dict "Name" .}}`), false, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	if err := renderer.Render(rr, httptest.NewRequest("GET", "/", nil), "page", "Allan"); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	if got := rr.Body.String(); got != "<title>Page</title>Hello Allan" {
+		t.Errorf("unexpected output %q", got)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+}
+
+func TestRendererUnknownPage(t *testing.T) {
+	renderer, err := New(testFS("body"), false, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if err := renderer.Render(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), "missing", nil); err == nil {
+		t.Error("expected error for unknown page")
+	}
+}
+
+func TestRendererCachesUnlessDev(t *testing.T) {
+	fsys := testFS("v1")
+
+	cached, _ := New(fsys, false, nil)
+	dev, _ := New(fsys, true, nil)
+	fsys["page.html"] = &fstest.MapFile{Data: []byte(`{{define "title"}}Page{{end}}{{define "content"}}v2{{end}}`)}
+
+	rr := httptest.NewRecorder()
+	cached.Render(rr, httptest.NewRequest("GET", "/", nil), "page", nil)
+	if !strings.Contains(rr.Body.String(), "v1") {
+		t.Errorf("cached renderer should keep v1, got %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	dev.Render(rr, httptest.NewRequest("GET", "/", nil), "page", nil)
+	if !strings.Contains(rr.Body.String(), "v2") {
+		t.Errorf("dev renderer should reload v2, got %q", rr.Body.String())
+	}
+}
+
+func TestSiteTemplatesParse(t *testing.T) {
+	renderer, err := New(FS, false, nil)
+	if err != nil {
+		t.Fatalf("site templates failed to parse: %v", err)
+	}
+
+	want := []string{"home", "projects", "login", "register", "flashcards", "cloudsimulator", "playground", "replay", "unsubscribe", "page"}
+	pages := strings.Join(renderer.Pages(), ",")
+	for _, name := range want {
+		if !strings.Contains(pages, name) {
+			t.Errorf("expected page %q to be loaded, got %s", name, pages)
+		}
+	}
+}
+
+func TestDict(t *testing.T) {
+	if _, err := dict("odd"); err == nil {
+		t.Error("expected error for odd argument count")
+	}
+	if _, err := dict(1, "value"); err == nil {
+		t.Error("expected error for non-string key")
+	}
+	m, err := dict("a", 1, "b", "two")
+	if err != nil || m["a"] != 1 || m["b"] != "two" {
+		t.Errorf("unexpected dict result %v, %v", m, err)
+	}
+}
+
+func TestAssetFuncOverride(t *testing.T) {
+	fsys := testFS(`{{asset "app.js"}}`)
+
+	plain, _ := New(fsys, false, nil)
+	rr := httptest.NewRecorder()
+	plain.Render(rr, httptest.NewRequest("GET", "/", nil), "page", nil)
+	if !strings.Contains(rr.Body.String(), "/static/app.js") {
+		t.Errorf("default asset func should return plain path, got %q", rr.Body.String())
+	}
+
+	hashed, _ := New(fsys, false, template.FuncMap{
+		"asset": func(name string) string { return "/static/app.abc123.js" },
+	})
+	rr = httptest.NewRecorder()
+	hashed.Render(rr, httptest.NewRequest("GET", "/", nil), "page", nil)
+	if !strings.Contains(rr.Body.String(), "/static/app.abc123.js") {
+		t.Errorf("asset override not applied, got %q", rr.Body.String())
+	}
+}
+
+func TestNonceBoundPerRequest(t *testing.T) {
+	renderer, err := New(testFS(`<script nonce="{{nonce}}"></script>`), false, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	var nonces []string
+	handler := middleware.SecureHeaders(middleware.SecurityHeaders{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, middleware.Nonce(r))
+		if err := renderer.Render(w, r, "page", nil); err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+	}))
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		want := `<script nonce="` + nonces[i] + `"></script>`
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("render %d: expected %q in %q", i, want, rr.Body.String())
+		}
+	}
+	if nonces[0] == nonces[1] {
+		t.Error("expected distinct nonces per request")
+	}
+}
+
+func TestRendererShowsFlashOnce(t *testing.T) {
+	renderer, err := New(FS, false, nil)
+	if err != nil {
+		t.Fatalf("site templates failed to parse: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	flash.Add(rr, httptest.NewRequest("GET", "/logout", nil), flash.Success, "You have signed out.")
+	req := httptest.NewRequest("GET", "/login", nil)
+	req.Header.Set("Accept-Language", "es")
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+
+	rr = httptest.NewRecorder()
+	if err := renderer.Render(rr, req, "login", struct{ Redirect, RedirectToken string }{}); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if want := `<p class="flash flash-success" role="status">Has cerrado sesión.</p>`; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("expected %q in page", want)
+	}
+	cleared := false
+	for _, c := range rr.Result().Cookies() {
+		cleared = cleared || (c.Name == flash.CookieName && c.MaxAge < 0)
+	}
+	if !cleared {
+		t.Error("the flash cookie was not cleared after rendering")
+	}
+
+	rr = httptest.NewRecorder()
+	if err := renderer.Render(rr, httptest.NewRequest("GET", "/login", nil), "login", struct{ Redirect, RedirectToken string }{}); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if strings.Contains(rr.Body.String(), "flash") || len(rr.Result().Cookies()) != 0 {
+		t.Error("a page without flash messages shows or clears them")
+	}
+}
+
+func TestSiteTemplatesAreLocalized(t *testing.T) {
+	renderer, err := New(FS, false, nil)
+	if err != nil {
+		t.Fatalf("site templates failed to parse: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/flashcards", nil)
+	req.Header.Set("Accept-Language", "pt-BR")
+	rr := httptest.NewRecorder()
+	if err := renderer.Render(rr, req, "flashcards", struct{ Courses []struct{} }{}); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{`<html lang="pt">`, "Escolha um Curso", `"Correct!":"Correto!"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in localized page", want)
+		}
+	}
+}
@@ -54,6 +54,7 @@ const {
     createGameCompletionData,
     calculateAccuracy,
     calculateAverageTime,
+    escapeHtml,
     updateResultsDisplay
 } = require('../static/flashcards.js');
 
@@ -143,6 +144,14 @@ describe('Answer Submission Logic', function() {
             elements.submitAnswer.disabled = true;
             expect(canSubmitAnswer(true)).to.be.true;
         });
+
+        it('should return false while the game is paused, even on timeout', function() {
+            elements.submitAnswer.disabled = false;
+            gameState.pausedAt = Date.now();
+            expect(canSubmitAnswer(false)).to.be.false;
+            expect(canSubmitAnswer(true)).to.be.false;
+            gameState.pausedAt = null;
+        });
     });
 
     describe('getCurrentAnswerData', function() {
@@ -305,6 +314,13 @@ describe('Results Calculation', function() {
     });
 });
 
+describe('escapeHtml', function() {
+    it('should escape markup in card text', function() {
+        expect(escapeHtml('<img src=x onerror="alert(1)">')).to.equal('&lt;img src=x onerror=&quot;alert(1)&quot;&gt;');
+        expect(escapeHtml("Tom & Jerry's")).to.equal('Tom &amp; Jerry&#39;s');
+    });
+});
+
 describe('UI Display Updates', function() {
     beforeEach(function() {
         initializeElements();
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"allanswebterminal/config"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsSettings describes how the server terminates HTTPS. Either a certificate
+// and key pair or an autocert domain enables TLS; with neither set the server
+// speaks plain HTTP as before.
+type tlsSettings struct {
+	CertFile     string
+	KeyFile      string
+	Domains      []string
+	CacheDir     string
+	RedirectAddr string
+}
+
+func loadTLSSettings() tlsSettings {
+	settings := tlsSettings{
+		CertFile:     config.String("TLS_CERT_FILE", ""),
+		KeyFile:      config.String("TLS_KEY_FILE", ""),
+		CacheDir:     config.String("AUTOCERT_CACHE_DIR", "certs"),
+		RedirectAddr: config.String("HTTP_REDIRECT_ADDR", ":80"),
+	}
+	for _, domain := range strings.Split(config.String("TLS_DOMAIN", ""), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			settings.Domains = append(settings.Domains, domain)
+		}
+	}
+	return settings
+}
+
+func (s tlsSettings) Enabled() bool {
+	return s.usesCertFiles() || s.usesAutocert()
+}
+
+func (s tlsSettings) usesCertFiles() bool {
+	return s.CertFile != "" && s.KeyFile != ""
+}
+
+func (s tlsSettings) usesAutocert() bool {
+	return !s.usesCertFiles() && len(s.Domains) > 0
+}
+
+// configureTLS sets srv.TLSConfig and returns the plain HTTP listener that
+// redirects to HTTPS (and answers ACME challenges when autocert is used).
+func configureTLS(srv *http.Server, settings tlsSettings) (*http.Server, error) {
+	redirect := httpsRedirectHandler(srv.Addr)
+
+	switch {
+	case settings.usesCertFiles():
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}
+	case settings.usesAutocert():
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(settings.Domains...),
+			Cache:      autocert.DirCache(settings.CacheDir),
+		}
+		srv.TLSConfig = manager.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		redirect = manager.HTTPHandler(redirect)
+	default:
+		return nil, fmt.Errorf("TLS is not configured")
+	}
+
+	return &http.Server{
+		Addr:              settings.RedirectAddr,
+		Handler:           redirect,
+		ReadHeaderTimeout: srv.ReadHeaderTimeout,
+	}, nil
+}
+
+// httpsRedirectHandler permanently redirects plain HTTP requests to the same
+// host and path on the HTTPS listener.
+func httpsRedirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTLSSettingsEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings tlsSettings
+		want     bool
+	}{
+		{"disabled", tlsSettings{}, false},
+		{"cert files", tlsSettings{CertFile: "cert.pem", KeyFile: "key.pem"}, true},
+		{"cert without key", tlsSettings{CertFile: "cert.pem"}, false},
+		{"autocert", tlsSettings{Domains: []string{"example.com"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.settings.Enabled(); got != tt.want {
+				t.Errorf("Enabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadTLSSettingsParsesDomains(t *testing.T) {
+	t.Setenv("TLS_DOMAIN", "example.com, www.example.com")
+
+	settings := loadTLSSettings()
+	if len(settings.Domains) != 2 || settings.Domains[1] != "www.example.com" {
+		t.Errorf("unexpected domains: %v", settings.Domains)
+	}
+	if !settings.usesAutocert() {
+		t.Error("expected autocert to be used when only a domain is configured")
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsAddr string
+		host      string
+		want      string
+	}{
+		{"default port", ":443", "example.com", "https://example.com/projects?tab=1"},
+		{"strips http port", ":443", "example.com:80", "https://example.com/projects?tab=1"},
+		{"custom https port", ":8443", "localhost:8080", "https://localhost:8443/projects?tab=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://"+tt.host+"/projects?tab=1", nil)
+			rr := httptest.NewRecorder()
+
+			httpsRedirectHandler(tt.httpsAddr).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusMovedPermanently {
+				t.Errorf("status = %d, want %d", rr.Code, http.StatusMovedPermanently)
+			}
+			if got := rr.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package ujs
+
+// Node is any syntax tree node.
+type Node interface {
+	Position() Pos
+}
+
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Program is a parsed source file: function declarations and top-level
+// statements, in source order.
+type Program struct {
+	Body []Stmt
+}
+
+// Functions returns the top-level function declarations.
+func (p *Program) Functions() []*FuncDecl {
+	var funcs []*FuncDecl
+	for _, s := range p.Body {
+		if fn, ok := s.(*FuncDecl); ok {
+			funcs = append(funcs, fn)
+		}
+	}
+	return funcs
+}
+
+// Statements
+
+type FuncDecl struct {
+	Pos    Pos
+	Name   *Ident
+	Params []*Ident
+	NoGC   bool
+	Body   *Block
+}
+
+type VarDecl struct {
+	Pos   Pos
+	Const bool
+	Name  *Ident
+	Value Expr
+}
+
+type Block struct {
+	Pos   Pos
+	Stmts []Stmt
+}
+
+// NoGCBlock is `nogc { ... }`: its body may not allocate on the GC heap and
+// may use stackalloc.
+type NoGCBlock struct {
+	Pos  Pos
+	Body *Block
+}
+
+type IfStmt struct {
+	Pos  Pos
+	Cond Expr
+	Then *Block
+	Else Stmt // *Block, *IfStmt or nil
+}
+
+type WhileStmt struct {
+	Pos  Pos
+	Cond Expr
+	Body *Block
+}
+
+type ReturnStmt struct {
+	Pos   Pos
+	Value Expr // nil for a bare return
+}
+
+type AssignStmt struct {
+	Pos    Pos
+	Target Expr // *Ident or *IndexExpr
+	Op     TokenKind
+	Value  Expr
+}
+
+type ExprStmt struct {
+	X Expr
+}
+
+func (s *FuncDecl) Position() Pos   { return s.Pos }
+func (s *VarDecl) Position() Pos    { return s.Pos }
+func (s *Block) Position() Pos      { return s.Pos }
+func (s *NoGCBlock) Position() Pos  { return s.Pos }
+func (s *IfStmt) Position() Pos     { return s.Pos }
+func (s *WhileStmt) Position() Pos  { return s.Pos }
+func (s *ReturnStmt) Position() Pos { return s.Pos }
+func (s *AssignStmt) Position() Pos { return s.Pos }
+func (s *ExprStmt) Position() Pos   { return s.X.Position() }
+
+func (*FuncDecl) stmtNode()   {}
+func (*VarDecl) stmtNode()    {}
+func (*Block) stmtNode()      {}
+func (*NoGCBlock) stmtNode()  {}
+func (*IfStmt) stmtNode()     {}
+func (*WhileStmt) stmtNode()  {}
+func (*ReturnStmt) stmtNode() {}
+func (*AssignStmt) stmtNode() {}
+func (*ExprStmt) stmtNode()   {}
+
+// Expressions
+
+type Ident struct {
+	Pos  Pos
+	Name string
+}
+
+type NumberLit struct {
+	Pos   Pos
+	Value float64
+	IsInt bool
+}
+
+type StringLit struct {
+	Pos   Pos
+	Value string
+}
+
+type BoolLit struct {
+	Pos   Pos
+	Value bool
+}
+
+type NullLit struct {
+	Pos Pos
+}
+
+type ArrayLit struct {
+	Pos   Pos
+	Elems []Expr
+}
+
+// StackAllocExpr is `stackalloc int[16]`.
+type StackAllocExpr struct {
+	Pos      Pos
+	ElemType string
+	Size     Expr
+}
+
+type UnaryExpr struct {
+	Pos Pos
+	Op  TokenKind
+	X   Expr
+}
+
+type BinaryExpr struct {
+	Pos Pos
+	Op  TokenKind
+	X   Expr
+	Y   Expr
+}
+
+type CallExpr struct {
+	Pos  Pos
+	Fn   Expr
+	Args []Expr
+}
+
+type IndexExpr struct {
+	Pos   Pos
+	X     Expr
+	Index Expr
+}
+
+func (e *Ident) Position() Pos          { return e.Pos }
+func (e *NumberLit) Position() Pos      { return e.Pos }
+func (e *StringLit) Position() Pos      { return e.Pos }
+func (e *BoolLit) Position() Pos        { return e.Pos }
+func (e *NullLit) Position() Pos        { return e.Pos }
+func (e *ArrayLit) Position() Pos       { return e.Pos }
+func (e *StackAllocExpr) Position() Pos { return e.Pos }
+func (e *UnaryExpr) Position() Pos      { return e.Pos }
+func (e *BinaryExpr) Position() Pos     { return e.Pos }
+func (e *CallExpr) Position() Pos       { return e.Pos }
+func (e *IndexExpr) Position() Pos      { return e.Pos }
+
+func (*Ident) exprNode()          {}
+func (*NumberLit) exprNode()      {}
+func (*StringLit) exprNode()      {}
+func (*BoolLit) exprNode()        {}
+func (*NullLit) exprNode()        {}
+func (*ArrayLit) exprNode()       {}
+func (*StackAllocExpr) exprNode() {}
+func (*UnaryExpr) exprNode()      {}
+func (*BinaryExpr) exprNode()     {}
+func (*CallExpr) exprNode()       {}
+func (*IndexExpr) exprNode()      {}
@@ -0,0 +1,142 @@
+package ujs
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultBenchIterations = 5
+	MaxBenchIterations     = 20
+	// benchMaxSteps bounds each benchmark iteration so a full benchmark stays
+	// well inside the worker timeout.
+	benchMaxSteps = 2_000_000
+)
+
+// Kernel is a FastMath micro-benchmark implemented natively by the backend:
+// in C for the cgo build and in Go for the fallback.
+type Kernel struct {
+	Name string
+	// Ops is how many inner operations one call performs.
+	Ops int
+	run func() float64
+}
+
+// KernelResult is the timing of one kernel.
+type KernelResult struct {
+	Name    string  `json:"name"`
+	Ops     int     `json:"ops"`
+	NsPerOp float64 `json:"ns_per_op"`
+	// CyclesPerOp is only reported where the backend can read the CPU cycle
+	// counter (the cgo build on x86-64).
+	CyclesPerOp float64 `json:"cycles_per_op,omitempty"`
+}
+
+// ProgramStats summarizes timed runs of the submitted program.
+type ProgramStats struct {
+	Iterations  int     `json:"iterations"`
+	MinNs       int64   `json:"min_ns"`
+	MedianNs    int64   `json:"median_ns"`
+	MeanNs      int64   `json:"mean_ns"`
+	Steps       int     `json:"steps"`
+	StepsPerSec float64 `json:"steps_per_sec"`
+	Output      string  `json:"output"`
+}
+
+// BenchReport is the outcome of Benchmark. When the source does not compile
+// only Compile is set.
+type BenchReport struct {
+	Compile *Result        `json:"compile"`
+	Runtime string         `json:"runtime"`
+	Program *ProgramStats  `json:"program,omitempty"`
+	Kernels []KernelResult `json:"kernels,omitempty"`
+	Error   *RuntimeError  `json:"error,omitempty"`
+}
+
+// Benchmark compiles src, runs it iterations times and then times the
+// FastMath kernels for comparison.
+func Benchmark(ctx context.Context, src string, iterations int) *BenchReport {
+	if iterations <= 0 {
+		iterations = DefaultBenchIterations
+	}
+	if iterations > MaxBenchIterations {
+		iterations = MaxBenchIterations
+	}
+
+	report := &BenchReport{Compile: Compile(src), Runtime: Backend}
+	if !report.Compile.OK {
+		return report
+	}
+
+	durations := make([]int64, 0, iterations)
+	var last *RunResult
+	for i := 0; i < iterations; i++ {
+		last = Run(ctx, report.Compile.Program, RunOptions{MaxSteps: benchMaxSteps})
+		if last.Error != nil {
+			report.Error = last.Error
+			return report
+		}
+		durations = append(durations, last.Duration.Nanoseconds())
+	}
+	report.Program = summarize(durations, last)
+
+	for _, k := range Kernels() {
+		if ctx.Err() != nil {
+			break
+		}
+		report.Kernels = append(report.Kernels, timeKernel(k))
+	}
+	return report
+}
+
+func summarize(durations []int64, last *RunResult) *ProgramStats {
+	sorted := append([]int64(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total int64
+	for _, d := range sorted {
+		total += d
+	}
+	stats := &ProgramStats{
+		Iterations: len(sorted),
+		MinNs:      sorted[0],
+		MedianNs:   sorted[len(sorted)/2],
+		MeanNs:     total / int64(len(sorted)),
+		Steps:      last.Steps,
+		Output:     last.Output,
+	}
+	if stats.MedianNs > 0 {
+		stats.StepsPerSec = float64(last.Steps) / (float64(stats.MedianNs) / float64(time.Second))
+	}
+	return stats
+}
+
+// kernelSink keeps kernel results observable so they are not optimized away.
+var kernelSink float64
+
+// timeKernel runs k a few times and keeps the fastest run.
+func timeKernel(k Kernel) KernelResult {
+	const rounds = 3
+	best := time.Duration(1<<63 - 1)
+	var bestCycles uint64
+	for i := 0; i < rounds; i++ {
+		c0, hasCycles := readCycles()
+		start := time.Now()
+		kernelSink += k.run()
+		elapsed := time.Since(start)
+		c1, _ := readCycles()
+		if elapsed < best {
+			best = elapsed
+			if hasCycles {
+				bestCycles = c1 - c0
+			}
+		}
+	}
+
+	res := KernelResult{Name: k.Name, Ops: k.Ops, NsPerOp: float64(best.Nanoseconds()) / float64(k.Ops)}
+	if bestCycles > 0 {
+		res.CyclesPerOp = float64(bestCycles) / float64(k.Ops)
+	}
+	return res
+}
@@ -0,0 +1,55 @@
+package ujs
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBenchmark(t *testing.T) {
+	report := Benchmark(context.Background(), `
+function main() {
+	let i = 0;
+	let acc = 0;
+	while (i < 1000) { acc += sqrt(i); i += 1; }
+	print(floor(acc));
+}`, 3)
+
+	if !report.Compile.OK || report.Error != nil {
+		t.Fatalf("report = %+v", report)
+	}
+	p := report.Program
+	if p.Iterations != 3 || p.MinNs <= 0 || p.MinNs > p.MedianNs || p.Steps == 0 || p.Output != "21065\n" {
+		t.Errorf("program stats = %+v", p)
+	}
+	if len(report.Kernels) != len(Kernels()) {
+		t.Fatalf("kernels = %+v", report.Kernels)
+	}
+	for _, k := range report.Kernels {
+		if k.NsPerOp <= 0 {
+			t.Errorf("kernel %s has no timing", k.Name)
+		}
+		if Backend == "purego" && k.CyclesPerOp != 0 {
+			t.Errorf("fallback reported cycles for %s", k.Name)
+		}
+	}
+}
+
+func TestBenchmarkStopsOnErrors(t *testing.T) {
+	if report := Benchmark(context.Background(), "let a = ;", 1); report.Compile.OK || report.Program != nil {
+		t.Errorf("compile error report = %+v", report)
+	}
+	report := Benchmark(context.Background(), "let a = [1];\nprint(a[5]);", 1)
+	if report.Error == nil || report.Program != nil || report.Kernels != nil {
+		t.Errorf("runtime error report = %+v", report)
+	}
+}
+
+func TestKernelsAgreeAcrossBackends(t *testing.T) {
+	// The C and Go kernels must compute the same values.
+	want := map[string]float64{"fib_iter": 1884755131}
+	for _, k := range Kernels() {
+		if v, ok := want[k.Name]; ok && k.run() != v {
+			t.Errorf("%s = %v, want %v", k.Name, k.run(), v)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ujs
+
+// typedBuffer is the Buffer implementation shared by both backends. Only
+// the slice matching elem is set; the backends differ in where its memory
+// comes from.
+type typedBuffer struct {
+	elem    string
+	n       int
+	bytes   []uint8
+	ints    []int64
+	floats  []float64
+	release func()
+	freed   bool
+}
+
+func (b *typedBuffer) Len() int {
+	return b.n
+}
+
+func (b *typedBuffer) ElemSize() int {
+	return StackAllocTypes[b.elem]
+}
+
+func (b *typedBuffer) Get(i int) float64 {
+	switch {
+	case b.bytes != nil:
+		return float64(b.bytes[i])
+	case b.ints != nil:
+		return float64(b.ints[i])
+	default:
+		return b.floats[i]
+	}
+}
+
+func (b *typedBuffer) Set(i int, v float64) {
+	switch {
+	case b.bytes != nil:
+		b.bytes[i] = uint8(int64(v))
+	case b.ints != nil:
+		b.ints[i] = int64(v)
+	default:
+		b.floats[i] = v
+	}
+}
+
+// Free releases the storage. Later accesses are caught by the interpreter
+// through Freed; the slices are cleared so a missed check panics on a nil
+// slice instead of touching released memory.
+func (b *typedBuffer) Free() {
+	if b.freed {
+		return
+	}
+	b.freed = true
+	b.bytes, b.ints, b.floats = nil, nil, nil
+	if b.release != nil {
+		b.release()
+	}
+}
+
+func (b *typedBuffer) Freed() bool {
+	return b.freed
+}
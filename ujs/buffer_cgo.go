@@ -0,0 +1,36 @@
+//go:build ujs_cgo && cgo
+
+package ujs
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import "unsafe"
+
+// Backend names the runtime compiled into this binary.
+const Backend = "cgo"
+
+// NativeMemory reports whether stackalloc buffers live outside the Go heap.
+const NativeMemory = true
+
+// newBuffer allocates the buffer with calloc so nogc code really runs
+// without GC-managed memory; it is freed when the nogc region ends.
+func newBuffer(elem string, n int) Buffer {
+	p := C.calloc(C.size_t(n), C.size_t(StackAllocTypes[elem]))
+	if p == nil {
+		panic("ujs: stackalloc: out of memory")
+	}
+
+	b := &typedBuffer{elem: elem, n: n, release: func() { C.free(p) }}
+	switch elem {
+	case "byte":
+		b.bytes = unsafe.Slice((*uint8)(p), n)
+	case "int":
+		b.ints = unsafe.Slice((*int64)(p), n)
+	default:
+		b.floats = unsafe.Slice((*float64)(p), n)
+	}
+	return b
+}
@@ -0,0 +1,25 @@
+//go:build !ujs_cgo || !cgo
+
+package ujs
+
+// Backend names the runtime compiled into this binary. The pure-Go fallback
+// is used unless the binary is built with -tags ujs_cgo.
+const Backend = "purego"
+
+// NativeMemory reports whether stackalloc buffers live outside the Go heap.
+// The fallback emulates them with ordinary slices, so they are bounded and
+// released logically but still traced by the garbage collector.
+const NativeMemory = false
+
+func newBuffer(elem string, n int) Buffer {
+	b := &typedBuffer{elem: elem, n: n}
+	switch elem {
+	case "byte":
+		b.bytes = make([]uint8, n)
+	case "int":
+		b.ints = make([]int64, n)
+	default:
+		b.floats = make([]float64, n)
+	}
+	return b
+}
@@ -0,0 +1,378 @@
+package ujs
+
+// MaxStackAlloc is the largest total stackalloc size, in bytes, allowed in
+// a single nogc region.
+const MaxStackAlloc = 64 * 1024
+
+type symbolKind int
+
+const (
+	symVar symbolKind = iota
+	symConst
+	symParam
+	symFunc
+	symBuiltin
+)
+
+type symbol struct {
+	name  string
+	kind  symbolKind
+	pos   Pos
+	used  bool
+	arity int // -1 for variadic builtins
+	nogc  bool
+	// impure marks builtins whose result depends on when they run.
+	impure bool
+	// stack marks variables holding a stackalloc buffer; they must not
+	// outlive the nogc region that declared them.
+	stack bool
+	// region is the nogc region the symbol was declared in, nil outside one.
+	region *nogcRegion
+}
+
+// Builtin describes a function provided by the runtime.
+type Builtin struct {
+	Arity int // -1 for variadic
+	NoGC  bool
+	// Impure builtins make a program's output vary between runs.
+	Impure bool
+}
+
+// Builtins are always in scope. Only NoGC builtins may be called from a
+// nogc context.
+var Builtins = map[string]Builtin{
+	"print": {Arity: -1, NoGC: false},
+	"len":   {Arity: 1, NoGC: true},
+	"sqrt":  {Arity: 1, NoGC: true},
+	"abs":   {Arity: 1, NoGC: true},
+	"floor": {Arity: 1, NoGC: true},
+	"min":   {Arity: 2, NoGC: true},
+	"max":   {Arity: 2, NoGC: true},
+	"clock": {Arity: 0, NoGC: true, Impure: true},
+}
+
+type scope struct {
+	parent  *scope
+	symbols map[string]*symbol
+	order   []*symbol
+}
+
+func (s *scope) lookup(name string) *symbol {
+	for ; s != nil; s = s.parent {
+		if sym, ok := s.symbols[name]; ok {
+			return sym
+		}
+	}
+	return nil
+}
+
+type nogcRegion struct {
+	pos        Pos
+	stackBytes int
+}
+
+type checker struct {
+	diags  *diagnostics
+	scope  *scope
+	fn     *FuncDecl
+	region *nogcRegion
+	impure bool
+}
+
+// check runs the semantic checks over prog and reports whether it is
+// deterministic, i.e. calls no impure builtin.
+func check(prog *Program, diags *diagnostics) bool {
+	c := &checker{diags: diags}
+	c.push()
+	for name, b := range Builtins {
+		c.scope.symbols[name] = &symbol{name: name, kind: symBuiltin, arity: b.Arity, nogc: b.NoGC, impure: b.Impure, used: true}
+	}
+
+	// Functions are hoisted so they can be called before their declaration.
+	c.push()
+	for _, fn := range prog.Functions() {
+		c.declare(&symbol{
+			name:  fn.Name.Name,
+			kind:  symFunc,
+			pos:   fn.Name.Pos,
+			arity: len(fn.Params),
+			nogc:  fn.NoGC,
+			used:  true,
+		})
+	}
+
+	for _, stmt := range prog.Body {
+		if fn, ok := stmt.(*FuncDecl); ok {
+			c.checkFunc(fn)
+			continue
+		}
+		c.stmt(stmt)
+	}
+	c.pop()
+	return !c.impure
+}
+
+func (c *checker) push() {
+	c.scope = &scope{parent: c.scope, symbols: make(map[string]*symbol)}
+}
+
+// pop closes the current scope, warning about locals that were never read.
+func (c *checker) pop() {
+	for _, sym := range c.scope.order {
+		if !sym.used && (sym.kind == symVar || sym.kind == symConst) {
+			c.diags.warnf(sym.pos, CodeUnused, "%s is declared but never used", sym.name)
+		}
+	}
+	c.scope = c.scope.parent
+}
+
+func (c *checker) declare(sym *symbol) {
+	if prev, ok := c.scope.symbols[sym.name]; ok {
+		if prev.kind == symBuiltin {
+			c.diags.errorf(sym.pos, CodeRedeclared, "%s shadows a builtin function", sym.name)
+		} else {
+			c.diags.errorf(sym.pos, CodeRedeclared, "%s is already declared at %s", sym.name, prev.pos)
+		}
+		return
+	}
+	if _, ok := Builtins[sym.name]; ok && sym.kind != symBuiltin {
+		c.diags.errorf(sym.pos, CodeRedeclared, "%s shadows a builtin function", sym.name)
+		return
+	}
+	sym.region = c.region
+	c.scope.symbols[sym.name] = sym
+	c.scope.order = append(c.scope.order, sym)
+}
+
+func (c *checker) checkFunc(fn *FuncDecl) {
+	outerFn, outerRegion := c.fn, c.region
+	c.fn = fn
+	c.region = nil
+	if fn.NoGC {
+		c.region = &nogcRegion{pos: fn.Pos}
+	}
+	defer func() { c.fn, c.region = outerFn, outerRegion }()
+
+	c.push()
+	for _, param := range fn.Params {
+		c.declare(&symbol{name: param.Name, kind: symParam, pos: param.Pos})
+	}
+	// The body shares the parameter scope, so `let x` cannot shadow param x.
+	c.stmts(fn.Body.Stmts)
+	c.pop()
+}
+
+func (c *checker) block(b *Block) {
+	c.push()
+	c.stmts(b.Stmts)
+	c.pop()
+}
+
+func (c *checker) stmts(list []Stmt) {
+	for _, s := range list {
+		c.stmt(s)
+	}
+}
+
+func (c *checker) stmt(s Stmt) {
+	switch s := s.(type) {
+	case *FuncDecl:
+		c.diags.errorf(s.Pos, CodeNestedFunction, "function %s must be declared at the top level", s.Name.Name)
+	case *VarDecl:
+		sym := &symbol{name: s.Name.Name, kind: symVar, pos: s.Name.Pos}
+		if s.Const {
+			sym.kind = symConst
+		}
+		if s.Value != nil {
+			c.expr(s.Value)
+			sym.stack = c.isStackValue(s.Value)
+		}
+		c.declare(sym)
+	case *Block:
+		c.block(s)
+	case *NoGCBlock:
+		if c.region != nil {
+			c.diags.warnf(s.Pos, CodeRedundantNoGC, "nogc block is redundant inside another nogc context")
+			c.block(s.Body)
+			return
+		}
+		c.region = &nogcRegion{pos: s.Pos}
+		c.block(s.Body)
+		c.region = nil
+	case *IfStmt:
+		c.expr(s.Cond)
+		c.block(s.Then)
+		if s.Else != nil {
+			c.stmt(s.Else)
+		}
+	case *WhileStmt:
+		c.expr(s.Cond)
+		c.block(s.Body)
+	case *ReturnStmt:
+		if c.fn == nil {
+			c.diags.errorf(s.Pos, CodeReturnOutside, "return outside of a function")
+		}
+		if s.Value != nil {
+			c.expr(s.Value)
+			if c.isStackValue(s.Value) {
+				c.diags.errorf(s.Value.Position(), CodeStackEscape, "stack-allocated buffer cannot be returned")
+			}
+		}
+	case *AssignStmt:
+		c.assign(s)
+	case *ExprStmt:
+		c.expr(s.X)
+	}
+}
+
+func (c *checker) assign(s *AssignStmt) {
+	c.expr(s.Value)
+	switch target := s.Target.(type) {
+	case *Ident:
+		sym := c.scope.lookup(target.Name)
+		if sym == nil {
+			c.diags.errorf(target.Pos, CodeUndefined, "undefined: %s", target.Name)
+			return
+		}
+		switch sym.kind {
+		case symConst:
+			c.diags.errorf(target.Pos, CodeConstAssign, "cannot assign to const %s", target.Name)
+		case symFunc, symBuiltin:
+			c.diags.errorf(target.Pos, CodeInvalidTarget, "cannot assign to function %s", target.Name)
+		}
+		if s.Op != Assign {
+			sym.used = true
+		}
+		if c.isStackValue(s.Value) && sym.region != c.region {
+			c.diags.errorf(s.Value.Position(), CodeStackEscape,
+				"stack-allocated buffer cannot be stored in %s, which outlives the nogc block", target.Name)
+		}
+	case *IndexExpr:
+		c.expr(target)
+		if c.isStackValue(s.Value) {
+			c.diags.errorf(s.Value.Position(), CodeStackEscape, "stack-allocated buffer cannot be stored in another value")
+		}
+	default:
+		c.expr(s.Target)
+		c.diags.errorf(s.Target.Position(), CodeInvalidTarget, "invalid assignment target")
+	}
+}
+
+// isStackValue reports whether e evaluates to a stackalloc buffer.
+func (c *checker) isStackValue(e Expr) bool {
+	switch e := e.(type) {
+	case *StackAllocExpr:
+		return true
+	case *Ident:
+		if sym := c.scope.lookup(e.Name); sym != nil {
+			return sym.stack
+		}
+	}
+	return false
+}
+
+func (c *checker) expr(e Expr) {
+	switch e := e.(type) {
+	case *Ident:
+		sym := c.scope.lookup(e.Name)
+		if sym == nil {
+			c.diags.errorf(e.Pos, CodeUndefined, "undefined: %s", e.Name)
+			return
+		}
+		sym.used = true
+	case *ArrayLit:
+		if c.region != nil {
+			c.diags.errorf(e.Pos, CodeNoGCAlloc, "array literal allocates on the GC heap; use stackalloc inside nogc")
+		}
+		for _, elem := range e.Elems {
+			c.expr(elem)
+			if c.isStackValue(elem) {
+				c.diags.errorf(elem.Position(), CodeStackEscape, "stack-allocated buffer cannot be stored in another value")
+			}
+		}
+	case *StackAllocExpr:
+		c.stackAlloc(e)
+	case *UnaryExpr:
+		c.expr(e.X)
+	case *BinaryExpr:
+		c.expr(e.X)
+		c.expr(e.Y)
+		if c.region != nil && e.Op == Plus && (isString(e.X) || isString(e.Y)) {
+			c.diags.errorf(e.Pos, CodeNoGCAlloc, "string concatenation allocates on the GC heap")
+		}
+	case *CallExpr:
+		c.call(e)
+	case *IndexExpr:
+		c.expr(e.X)
+		c.expr(e.Index)
+	}
+}
+
+func isString(e Expr) bool {
+	_, ok := e.(*StringLit)
+	return ok
+}
+
+func (c *checker) stackAlloc(e *StackAllocExpr) {
+	if c.region == nil {
+		c.diags.errorf(e.Pos, CodeStackAllocScope, "stackalloc is only allowed inside a nogc block or function")
+	}
+
+	size, ok := e.Size.(*NumberLit)
+	if !ok || !size.IsInt {
+		c.expr(e.Size)
+		c.diags.errorf(e.Size.Position(), CodeStackAllocSize, "stackalloc size must be an integer literal")
+		return
+	}
+	if size.Value < 1 {
+		c.diags.errorf(size.Pos, CodeStackAllocSize, "stackalloc size must be positive")
+		return
+	}
+
+	elemSize := StackAllocTypes[e.ElemType]
+	if size.Value*float64(elemSize) > MaxStackAlloc {
+		c.diags.errorf(size.Pos, CodeStackAllocSize, "stackalloc of %g %s elements exceeds the %d byte limit",
+			size.Value, e.ElemType, MaxStackAlloc)
+		return
+	}
+	bytes := int(size.Value) * elemSize
+	if c.region != nil {
+		c.region.stackBytes += bytes
+		if c.region.stackBytes > MaxStackAlloc {
+			c.diags.errorf(e.Pos, CodeStackAllocSize,
+				"nogc region starting at %s allocates more than %d bytes on the stack", c.region.pos, MaxStackAlloc)
+		}
+	}
+}
+
+func (c *checker) call(e *CallExpr) {
+	for _, arg := range e.Args {
+		c.expr(arg)
+	}
+
+	ident, ok := e.Fn.(*Ident)
+	if !ok {
+		c.expr(e.Fn)
+		c.diags.errorf(e.Pos, CodeNotCallable, "only named functions can be called")
+		return
+	}
+	sym := c.scope.lookup(ident.Name)
+	if sym == nil {
+		c.diags.errorf(ident.Pos, CodeUndefined, "undefined function: %s", ident.Name)
+		return
+	}
+	sym.used = true
+	if sym.impure {
+		c.impure = true
+	}
+	if sym.kind != symFunc && sym.kind != symBuiltin {
+		c.diags.errorf(ident.Pos, CodeNotCallable, "%s is not a function", ident.Name)
+		return
+	}
+	if sym.arity >= 0 && len(e.Args) != sym.arity {
+		c.diags.errorf(e.Pos, CodeArity, "%s expects %d argument(s), got %d", ident.Name, sym.arity, len(e.Args))
+	}
+	if c.region != nil && !sym.nogc {
+		c.diags.errorf(ident.Pos, CodeNoGCCall, "cannot call %s from a nogc context; it may allocate on the GC heap", ident.Name)
+	}
+}
@@ -0,0 +1,105 @@
+package ujs
+
+import "testing"
+
+func TestCompileValidProgram(t *testing.T) {
+	res := Compile(`
+nogc function square(x) { return x * x; }
+
+function main() {
+	let total = 0;
+	nogc {
+		let buf = stackalloc int[8];
+		buf[0] = square(3);
+		total = buf[0] + len(buf);
+	}
+	print(total);
+}
+main();
+`)
+	if !res.OK || len(res.Diagnostics) != 0 {
+		t.Fatalf("expected clean compile, got %v", res.Diagnostics)
+	}
+	if len(res.Functions) != 2 || res.Functions[0].Name != "square" || !res.Functions[0].NoGC {
+		t.Errorf("functions = %+v", res.Functions)
+	}
+}
+
+func TestCompileDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		code string
+		pos  Pos
+	}{
+		{"Undefined variable", "print(y);", CodeUndefined, Pos{1, 7}},
+		{"Undefined function", "nope();", CodeUndefined, Pos{1, 1}},
+		{"Redeclared", "let a = 1;\nlet a = 2;\nprint(a);", CodeRedeclared, Pos{2, 5}},
+		{"Shadows builtin", "let print = 1;", CodeRedeclared, Pos{1, 5}},
+		{"Arity", "function f(a, b) { return a + b; }\nf(1);", CodeArity, Pos{2, 2}},
+		{"Not callable", "let a = 1;\na();", CodeNotCallable, Pos{2, 1}},
+		{"Const assign", "const a = 1;\na = 2;", CodeConstAssign, Pos{2, 1}},
+		{"Return outside", "return 1;", CodeReturnOutside, Pos{1, 1}},
+		{"Nested function", "function f() {\n  function g() {}\n}", CodeNestedFunction, Pos{2, 3}},
+		{"Stackalloc outside nogc", "let b = stackalloc int[4];\nprint(b);", CodeStackAllocScope, Pos{1, 9}},
+		{"Stackalloc dynamic size", "function f(n) { nogc { let b = stackalloc int[n]; b[0] = 1; } }", CodeStackAllocSize, Pos{1, 47}},
+		{"Stackalloc too large", "nogc { let b = stackalloc int[100000]; b[0] = 1; }", CodeStackAllocSize, Pos{1, 31}},
+		{"Array literal in nogc", "nogc { let a = [1, 2]; a[0] = 1; }", CodeNoGCAlloc, Pos{1, 16}},
+		{"String concat in nogc", "nogc function f(a) { return a + \"x\"; }", CodeNoGCAlloc, Pos{1, 31}},
+		{"GC call from nogc", "function g() {}\nnogc { g(); }", CodeNoGCCall, Pos{2, 8}},
+		{"GC builtin from nogc", "nogc { print(1); }", CodeNoGCCall, Pos{1, 8}},
+		{"Stack buffer returned", "nogc function f() { let b = stackalloc byte[4]; return b; }", CodeStackEscape, Pos{1, 56}},
+		{"Stack buffer escapes block", "let keep = 0;\nnogc { let b = stackalloc byte[4]; keep = b; }\nprint(keep);", CodeStackEscape, Pos{2, 43}},
+		{"Invalid target", "function f() { return 1; }\nf() = 2;", CodeInvalidTarget, Pos{2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Compile(tt.src)
+			if res.OK {
+				t.Fatal("expected compile to fail")
+			}
+			for _, d := range res.Diagnostics {
+				if d.Code == tt.code && d.Severity == SeverityError {
+					if d.Pos != tt.pos {
+						t.Errorf("%s reported at %s, want %s", tt.code, d.Pos, tt.pos)
+					}
+					return
+				}
+			}
+			t.Errorf("missing %s diagnostic in %v", tt.code, res.Diagnostics)
+		})
+	}
+}
+
+func TestCompileWarnings(t *testing.T) {
+	res := Compile("function f() {\n  let unused = 1;\n  nogc { nogc { } }\n}")
+	if !res.OK {
+		t.Fatalf("warnings should not fail the compile: %v", res.Diagnostics)
+	}
+	codes := map[string]bool{}
+	for _, d := range res.Diagnostics {
+		if d.Severity != SeverityWarning {
+			t.Errorf("unexpected %v", d)
+		}
+		codes[d.Code] = true
+	}
+	if !codes[CodeUnused] || !codes[CodeRedundantNoGC] {
+		t.Errorf("diagnostics = %v", res.Diagnostics)
+	}
+}
+
+func TestCompileRejectsLargeSource(t *testing.T) {
+	res := Compile(string(make([]byte, MaxSourceSize+1)))
+	if res.OK || len(res.Diagnostics) != 1 || res.Program != nil {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestCompileDeterministic(t *testing.T) {
+	if !Compile("print(sqrt(4));").Deterministic {
+		t.Error("pure program reported as nondeterministic")
+	}
+	if Compile("function t() { return clock(); }\nprint(t());").Deterministic {
+		t.Error("program calling clock reported as deterministic")
+	}
+}
@@ -0,0 +1,87 @@
+package ujs
+
+import (
+	"context"
+	"sort"
+)
+
+// MaxSourceSize is the largest source file Compile accepts, in bytes.
+const MaxSourceSize = 64 * 1024
+
+// Version identifies the compiler and runtime semantics. Bump it whenever
+// the same source can compile or run differently, so cached results keyed
+// by it are not reused.
+const Version = "1"
+
+// FunctionInfo summarizes a declared function for editors and the API.
+type FunctionInfo struct {
+	Name   string   `json:"name"`
+	Params []string `json:"params"`
+	NoGC   bool     `json:"nogc"`
+	Pos
+}
+
+// Result is the outcome of Compile. Program is nil only when the source is
+// rejected before parsing.
+type Result struct {
+	OK          bool           `json:"ok"`
+	Diagnostics []Diagnostic   `json:"diagnostics"`
+	Functions   []FunctionInfo `json:"functions"`
+	// Deterministic is false when the program calls an impure builtin such
+	// as clock, so running it twice can print different output.
+	Deterministic bool     `json:"deterministic"`
+	Program       *Program `json:"-"`
+}
+
+// Compile parses and checks src. Semantic checks run even when there are
+// syntax errors so users see as many problems as possible in one pass.
+func Compile(src string) *Result {
+	diags := &diagnostics{}
+	if len(src) > MaxSourceSize {
+		diags.errorf(Pos{Line: 1, Col: 1}, CodeSyntax, "source is larger than %d bytes", MaxSourceSize)
+		return &Result{Diagnostics: diags.list, Functions: []FunctionInfo{}}
+	}
+
+	prog := parse(src, diags)
+	deterministic := check(prog, diags)
+
+	sort.SliceStable(diags.list, func(i, j int) bool {
+		a, b := diags.list[i].Pos, diags.list[j].Pos
+		return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+	})
+
+	res := &Result{
+		OK:            !diags.hasErrors(),
+		Diagnostics:   diags.list,
+		Functions:     []FunctionInfo{},
+		Deterministic: deterministic,
+		Program:       prog,
+	}
+	if res.Diagnostics == nil {
+		res.Diagnostics = []Diagnostic{}
+	}
+	for _, fn := range prog.Functions() {
+		info := FunctionInfo{Name: fn.Name.Name, Params: []string{}, NoGC: fn.NoGC, Pos: fn.Pos}
+		for _, p := range fn.Params {
+			info.Params = append(info.Params, p.Name)
+		}
+		res.Functions = append(res.Functions, info)
+	}
+	return res
+}
+
+// ExecResult is the outcome of Exec. Run is nil when the source does not
+// compile.
+type ExecResult struct {
+	Compile *Result    `json:"compile"`
+	Run     *RunResult `json:"run,omitempty"`
+}
+
+// Exec compiles src and, if it has no errors, runs it with opts.
+func Exec(ctx context.Context, src string, opts RunOptions) *ExecResult {
+	res := &ExecResult{Compile: Compile(src)}
+	if res.Compile.OK {
+		res.Run = Run(ctx, res.Compile.Program, opts)
+	}
+	return res
+}
@@ -0,0 +1,83 @@
+package ujs
+
+import "fmt"
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic codes, stable so clients can key help text off them.
+const (
+	CodeSyntax          = "syntax"
+	CodeUndefined       = "undefined"
+	CodeRedeclared      = "redeclared"
+	CodeArity           = "arity"
+	CodeNotCallable     = "not-callable"
+	CodeConstAssign     = "const-assign"
+	CodeInvalidTarget   = "invalid-assignment"
+	CodeReturnOutside   = "return-outside-function"
+	CodeNestedFunction  = "nested-function"
+	CodeNoGCAlloc       = "nogc-alloc"
+	CodeNoGCCall        = "nogc-call"
+	CodeStackAllocScope = "stackalloc-outside-nogc"
+	CodeStackAllocSize  = "stackalloc-size"
+	CodeStackEscape     = "stack-escape"
+	CodeUnused          = "unused"
+	CodeRedundantNoGC   = "redundant-nogc"
+	CodeTooManyErrors   = "too-many-diagnostics"
+)
+
+// MaxDiagnostics caps how many diagnostics one compile reports.
+const MaxDiagnostics = 100
+
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Pos
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Pos, d.Severity, d.Message)
+}
+
+type diagnostics struct {
+	list      []Diagnostic
+	truncated bool
+}
+
+func (d *diagnostics) add(diag Diagnostic) {
+	if len(d.list) >= MaxDiagnostics {
+		if !d.truncated {
+			d.truncated = true
+			d.list = append(d.list, Diagnostic{
+				Severity: SeverityError,
+				Code:     CodeTooManyErrors,
+				Message:  "too many diagnostics; stopping",
+				Pos:      diag.Pos,
+			})
+		}
+		return
+	}
+	d.list = append(d.list, diag)
+}
+
+func (d *diagnostics) errorf(pos Pos, code, format string, args ...interface{}) {
+	d.add(Diagnostic{Severity: SeverityError, Code: code, Message: fmt.Sprintf(format, args...), Pos: pos})
+}
+
+func (d *diagnostics) warnf(pos Pos, code, format string, args ...interface{}) {
+	d.add(Diagnostic{Severity: SeverityWarning, Code: code, Message: fmt.Sprintf(format, args...), Pos: pos})
+}
+
+func (d *diagnostics) hasErrors() bool {
+	for _, diag := range d.list {
+		if diag.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
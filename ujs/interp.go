@@ -0,0 +1,631 @@
+package ujs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RunOptions bound a single execution. Zero values select the defaults.
+type RunOptions struct {
+	// MaxSteps limits evaluated statements and expressions.
+	MaxSteps int
+	// MaxOutput limits bytes written by print.
+	MaxOutput int
+	// MaxCallDepth limits recursion.
+	MaxCallDepth int
+	// Stdout, when set, also receives print output as it is produced, within
+	// the same MaxOutput limit.
+	Stdout io.Writer
+}
+
+const (
+	DefaultMaxSteps     = 10_000_000
+	DefaultMaxOutput    = 64 << 10
+	DefaultMaxCallDepth = 200
+
+	// MaxLiveStack bounds the stackalloc bytes alive at once across all open
+	// nogc regions. A stackalloc inside a loop keeps allocating until its
+	// region ends, exactly like a real stack, and overflows here.
+	MaxLiveStack = 1 << 20
+)
+
+func (o *RunOptions) setDefaults() {
+	if o.MaxSteps <= 0 {
+		o.MaxSteps = DefaultMaxSteps
+	}
+	if o.MaxOutput <= 0 {
+		o.MaxOutput = DefaultMaxOutput
+	}
+	if o.MaxCallDepth <= 0 {
+		o.MaxCallDepth = DefaultMaxCallDepth
+	}
+}
+
+// RuntimeError is a failure while running a program, at the position of the
+// expression or statement that caused it.
+type RuntimeError struct {
+	Message string `json:"message"`
+	Pos
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// RunResult is the outcome of Run.
+type RunResult struct {
+	Output          string        `json:"output"`
+	OutputTruncated bool          `json:"output_truncated,omitempty"`
+	Steps           int           `json:"steps"`
+	Duration        time.Duration `json:"duration_ns"`
+	Runtime         string        `json:"runtime"`
+	Error           *RuntimeError `json:"error,omitempty"`
+}
+
+// Run executes a program that compiled without errors. Top-level statements
+// run in order; if there are none, a parameterless main() is called.
+func Run(ctx context.Context, prog *Program, opts RunOptions) *RunResult {
+	opts.setDefaults()
+	in := &interp{
+		ctx:     ctx,
+		opts:    opts,
+		funcs:   make(map[string]*FuncDecl),
+		globals: newEnv(nil),
+		start:   time.Now(),
+	}
+
+	var top []Stmt
+	for _, stmt := range prog.Body {
+		if fn, ok := stmt.(*FuncDecl); ok {
+			in.funcs[fn.Name.Name] = fn
+			continue
+		}
+		top = append(top, stmt)
+	}
+
+	res := &RunResult{Runtime: Backend}
+	err := in.protect(func() {
+		if len(top) == 0 {
+			if fn, ok := in.funcs["main"]; ok && len(fn.Params) == 0 {
+				in.callFunc(fn, nil, fn.Pos)
+			}
+			return
+		}
+		in.execList(top, in.globals)
+	})
+
+	res.Output = in.out.String()
+	res.OutputTruncated = in.truncated
+	res.Steps = in.steps
+	res.Duration = time.Since(in.start)
+	res.Error = err
+	return res
+}
+
+type env struct {
+	parent *env
+	vars   map[string]*Value
+}
+
+func newEnv(parent *env) *env {
+	return &env{parent: parent, vars: make(map[string]*Value)}
+}
+
+func (e *env) lookup(name string) *Value {
+	for ; e != nil; e = e.parent {
+		if v, ok := e.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// Value is a runtime value: float64, string, bool, nil, *Array or Buffer.
+type Value = interface{}
+
+// Array is a GC-managed array created by an array literal.
+type Array struct {
+	Elems []Value
+}
+
+// Buffer is a stackalloc buffer. Its storage is provided by the runtime
+// backend and released when the enclosing nogc region ends.
+type Buffer interface {
+	Len() int
+	ElemSize() int
+	Get(i int) float64
+	Set(i int, v float64)
+	Free()
+	Freed() bool
+}
+
+type control int
+
+const (
+	ctlNone control = iota
+	ctlReturn
+)
+
+type interp struct {
+	ctx       context.Context
+	opts      RunOptions
+	funcs     map[string]*FuncDecl
+	globals   *env
+	start     time.Time
+	steps     int
+	depth     int
+	out       strings.Builder
+	truncated bool
+	// regions holds the buffers allocated by each open nogc region.
+	regions   [][]Buffer
+	liveStack int
+}
+
+// abort carries a RuntimeError up the Go stack.
+type abort struct{ err *RuntimeError }
+
+func (in *interp) fail(pos Pos, format string, args ...interface{}) {
+	panic(abort{&RuntimeError{Message: fmt.Sprintf(format, args...), Pos: pos}})
+}
+
+func (in *interp) protect(fn func()) (err *RuntimeError) {
+	defer func() {
+		for len(in.regions) > 0 {
+			in.closeRegion()
+		}
+		if r := recover(); r != nil {
+			a, ok := r.(abort)
+			if !ok {
+				panic(r)
+			}
+			err = a.err
+		}
+	}()
+	fn()
+	return nil
+}
+
+func (in *interp) step(pos Pos) {
+	in.steps++
+	if in.steps > in.opts.MaxSteps {
+		in.fail(pos, "step limit of %d exceeded", in.opts.MaxSteps)
+	}
+	if in.steps&1023 == 0 && in.ctx.Err() != nil {
+		in.fail(pos, "execution cancelled: %v", in.ctx.Err())
+	}
+}
+
+func (in *interp) openRegion() {
+	in.regions = append(in.regions, nil)
+}
+
+func (in *interp) closeRegion() {
+	last := len(in.regions) - 1
+	for _, buf := range in.regions[last] {
+		in.liveStack -= buf.Len() * buf.ElemSize()
+		buf.Free()
+	}
+	in.regions = in.regions[:last]
+}
+
+func (in *interp) execList(stmts []Stmt, e *env) (control, Value) {
+	for _, s := range stmts {
+		if ctl, v := in.exec(s, e); ctl == ctlReturn {
+			return ctl, v
+		}
+	}
+	return ctlNone, nil
+}
+
+func (in *interp) exec(s Stmt, e *env) (control, Value) {
+	in.step(s.Position())
+	switch s := s.(type) {
+	case *VarDecl:
+		var v Value
+		if s.Value != nil {
+			v = in.eval(s.Value, e)
+		}
+		e.vars[s.Name.Name] = &v
+	case *Block:
+		return in.execList(s.Stmts, newEnv(e))
+	case *NoGCBlock:
+		in.openRegion()
+		defer in.closeRegion()
+		return in.execList(s.Body.Stmts, newEnv(e))
+	case *IfStmt:
+		if truthy(in.eval(s.Cond, e)) {
+			return in.execList(s.Then.Stmts, newEnv(e))
+		}
+		if s.Else != nil {
+			return in.exec(s.Else, e)
+		}
+	case *WhileStmt:
+		for truthy(in.eval(s.Cond, e)) {
+			if ctl, v := in.execList(s.Body.Stmts, newEnv(e)); ctl == ctlReturn {
+				return ctl, v
+			}
+			in.step(s.Pos)
+		}
+	case *ReturnStmt:
+		var v Value
+		if s.Value != nil {
+			v = in.eval(s.Value, e)
+		}
+		return ctlReturn, v
+	case *AssignStmt:
+		in.assign(s, e)
+	case *ExprStmt:
+		in.eval(s.X, e)
+	case *FuncDecl:
+		in.fail(s.Pos, "function %s must be declared at the top level", s.Name.Name)
+	}
+	return ctlNone, nil
+}
+
+func (in *interp) assign(s *AssignStmt, e *env) {
+	value := in.eval(s.Value, e)
+	switch target := s.Target.(type) {
+	case *Ident:
+		slot := e.lookup(target.Name)
+		if slot == nil {
+			in.fail(target.Pos, "undefined: %s", target.Name)
+		}
+		if s.Op != Assign {
+			value = in.binary(s.Pos, compoundOp(s.Op), *slot, value)
+		}
+		*slot = value
+	case *IndexExpr:
+		container := in.eval(target.X, e)
+		index := in.eval(target.Index, e)
+		if s.Op != Assign {
+			value = in.binary(s.Pos, compoundOp(s.Op), in.index(target.Pos, container, index), value)
+		}
+		in.setIndex(target.Pos, container, index, value)
+	default:
+		in.fail(s.Pos, "invalid assignment target")
+	}
+}
+
+func compoundOp(op TokenKind) TokenKind {
+	if op == MinusAssign {
+		return Minus
+	}
+	return Plus
+}
+
+func (in *interp) eval(x Expr, e *env) Value {
+	in.step(x.Position())
+	switch x := x.(type) {
+	case *Ident:
+		slot := e.lookup(x.Name)
+		if slot == nil {
+			in.fail(x.Pos, "undefined: %s", x.Name)
+		}
+		return *slot
+	case *NumberLit:
+		return x.Value
+	case *StringLit:
+		return x.Value
+	case *BoolLit:
+		return x.Value
+	case *NullLit:
+		return nil
+	case *ArrayLit:
+		arr := &Array{Elems: make([]Value, len(x.Elems))}
+		for i, elem := range x.Elems {
+			arr.Elems[i] = in.eval(elem, e)
+		}
+		return arr
+	case *StackAllocExpr:
+		return in.stackAlloc(x, e)
+	case *UnaryExpr:
+		v := in.eval(x.X, e)
+		if x.Op == Not {
+			return !truthy(v)
+		}
+		return -in.number(x.Pos, v)
+	case *BinaryExpr:
+		switch x.Op {
+		case AndAnd:
+			left := in.eval(x.X, e)
+			if !truthy(left) {
+				return left
+			}
+			return in.eval(x.Y, e)
+		case OrOr:
+			left := in.eval(x.X, e)
+			if truthy(left) {
+				return left
+			}
+			return in.eval(x.Y, e)
+		}
+		return in.binary(x.Pos, x.Op, in.eval(x.X, e), in.eval(x.Y, e))
+	case *CallExpr:
+		return in.call(x, e)
+	case *IndexExpr:
+		return in.index(x.Pos, in.eval(x.X, e), in.eval(x.Index, e))
+	}
+	in.fail(x.Position(), "unsupported expression")
+	return nil
+}
+
+func (in *interp) stackAlloc(x *StackAllocExpr, e *env) Value {
+	if len(in.regions) == 0 {
+		in.fail(x.Pos, "stackalloc outside of a nogc region")
+	}
+	n := in.number(x.Size.Position(), in.eval(x.Size, e))
+	if n < 1 || n != math.Trunc(n) || n*float64(StackAllocTypes[x.ElemType]) > MaxStackAlloc {
+		in.fail(x.Pos, "invalid stackalloc size %v", n)
+	}
+	size := int(n) * StackAllocTypes[x.ElemType]
+	if in.liveStack+size > MaxLiveStack {
+		in.fail(x.Pos, "stack overflow: more than %d bytes of stackalloc buffers alive", MaxLiveStack)
+	}
+	in.liveStack += size
+	buf := newBuffer(x.ElemType, int(n))
+	last := len(in.regions) - 1
+	in.regions[last] = append(in.regions[last], buf)
+	return buf
+}
+
+func (in *interp) call(x *CallExpr, e *env) Value {
+	ident, ok := x.Fn.(*Ident)
+	if !ok {
+		in.fail(x.Pos, "only named functions can be called")
+	}
+	args := make([]Value, len(x.Args))
+	for i, arg := range x.Args {
+		args[i] = in.eval(arg, e)
+	}
+
+	if fn, ok := in.funcs[ident.Name]; ok {
+		return in.callFunc(fn, args, x.Pos)
+	}
+	if _, ok := Builtins[ident.Name]; ok {
+		return in.builtin(x.Pos, ident.Name, args)
+	}
+	in.fail(ident.Pos, "undefined function: %s", ident.Name)
+	return nil
+}
+
+func (in *interp) callFunc(fn *FuncDecl, args []Value, pos Pos) Value {
+	if len(args) != len(fn.Params) {
+		in.fail(pos, "%s expects %d argument(s), got %d", fn.Name.Name, len(fn.Params), len(args))
+	}
+	in.depth++
+	if in.depth > in.opts.MaxCallDepth {
+		in.fail(pos, "maximum call depth of %d exceeded", in.opts.MaxCallDepth)
+	}
+	defer func() { in.depth-- }()
+
+	e := newEnv(in.globals)
+	for i, param := range fn.Params {
+		v := args[i]
+		e.vars[param.Name] = &v
+	}
+	if fn.NoGC {
+		in.openRegion()
+		defer in.closeRegion()
+	}
+	_, v := in.execList(fn.Body.Stmts, e)
+	return v
+}
+
+func (in *interp) builtin(pos Pos, name string, args []Value) Value {
+	num := func(i int) float64 { return in.number(pos, args[i]) }
+	switch name {
+	case "print":
+		parts := make([]string, len(args))
+		for i, a := range args {
+			parts[i] = formatValue(a)
+		}
+		in.print(strings.Join(parts, " ") + "\n")
+		return nil
+	case "len":
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v)))
+		case *Array:
+			return float64(len(v.Elems))
+		case Buffer:
+			return float64(v.Len())
+		}
+		in.fail(pos, "len of %s", typeName(args[0]))
+	case "sqrt":
+		return math.Sqrt(num(0))
+	case "abs":
+		return math.Abs(num(0))
+	case "floor":
+		return math.Floor(num(0))
+	case "min":
+		return math.Min(num(0), num(1))
+	case "max":
+		return math.Max(num(0), num(1))
+	case "clock":
+		return time.Since(in.start).Seconds()
+	}
+	in.fail(pos, "unknown builtin %s", name)
+	return nil
+}
+
+func (in *interp) print(s string) {
+	if in.truncated {
+		return
+	}
+	if room := in.opts.MaxOutput - in.out.Len(); len(s) > room {
+		s = s[:room]
+		in.truncated = true
+	}
+	in.out.WriteString(s)
+	if in.opts.Stdout != nil && s != "" {
+		io.WriteString(in.opts.Stdout, s)
+	}
+}
+
+func (in *interp) binary(pos Pos, op TokenKind, x, y Value) Value {
+	switch op {
+	case Eq:
+		return equal(x, y)
+	case NotEq:
+		return !equal(x, y)
+	case Plus:
+		xs, xStr := x.(string)
+		ys, yStr := y.(string)
+		if xStr || yStr {
+			if !xStr {
+				xs = formatValue(x)
+			}
+			if !yStr {
+				ys = formatValue(y)
+			}
+			return xs + ys
+		}
+	}
+
+	a, b := in.number(pos, x), in.number(pos, y)
+	switch op {
+	case Plus:
+		return a + b
+	case Minus:
+		return a - b
+	case Star:
+		return a * b
+	case Slash:
+		return a / b
+	case Percent:
+		return math.Mod(a, b)
+	case Lt:
+		return a < b
+	case LtEq:
+		return a <= b
+	case Gt:
+		return a > b
+	case GtEq:
+		return a >= b
+	}
+	in.fail(pos, "unsupported operator %s", op)
+	return nil
+}
+
+func (in *interp) number(pos Pos, v Value) float64 {
+	n, ok := v.(float64)
+	if !ok {
+		in.fail(pos, "expected a number, got %s", typeName(v))
+	}
+	return n
+}
+
+func (in *interp) checkIndex(pos Pos, index Value, length int) int {
+	f := in.number(pos, index)
+	i := int(f)
+	if float64(i) != f || i < 0 || i >= length {
+		in.fail(pos, "index %v out of range [0, %d)", formatValue(index), length)
+	}
+	return i
+}
+
+func (in *interp) index(pos Pos, container, index Value) Value {
+	switch c := container.(type) {
+	case *Array:
+		return c.Elems[in.checkIndex(pos, index, len(c.Elems))]
+	case Buffer:
+		if c.Freed() {
+			in.fail(pos, "use of a stack buffer after its nogc region ended")
+		}
+		return c.Get(in.checkIndex(pos, index, c.Len()))
+	case string:
+		runes := []rune(c)
+		return string(runes[in.checkIndex(pos, index, len(runes))])
+	}
+	in.fail(pos, "cannot index %s", typeName(container))
+	return nil
+}
+
+func (in *interp) setIndex(pos Pos, container, index, value Value) {
+	switch c := container.(type) {
+	case *Array:
+		c.Elems[in.checkIndex(pos, index, len(c.Elems))] = value
+		return
+	case Buffer:
+		if c.Freed() {
+			in.fail(pos, "use of a stack buffer after its nogc region ended")
+		}
+		c.Set(in.checkIndex(pos, index, c.Len()), in.number(pos, value))
+		return
+	}
+	in.fail(pos, "cannot assign into %s", typeName(container))
+}
+
+func truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func equal(x, y Value) bool {
+	switch x.(type) {
+	case *Array, Buffer:
+		return x == y
+	}
+	switch y.(type) {
+	case *Array, Buffer:
+		return false
+	}
+	return x == y
+}
+
+func typeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case *Array:
+		return "array"
+	case Buffer:
+		return "buffer"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func formatValue(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case *Array:
+		parts := make([]string, len(v.Elems))
+		for i, elem := range v.Elems {
+			parts[i] = formatValue(elem)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case Buffer:
+		return fmt.Sprintf("<buffer %d>", v.Len())
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,148 @@
+package ujs
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, src string, opts RunOptions) *RunResult {
+	t.Helper()
+	res := Compile(src)
+	if !res.OK {
+		t.Fatalf("compile failed: %v", res.Diagnostics)
+	}
+	return Run(context.Background(), res.Program, opts)
+}
+
+func TestRun(t *testing.T) {
+	res := run(t, `
+function fib(n) {
+	if (n < 2) { return n; }
+	return fib(n - 1) + fib(n - 2);
+}
+
+nogc function sum(buf) {
+	let total = 0;
+	let i = 0;
+	while (i < len(buf)) {
+		total += buf[i];
+		i += 1;
+	}
+	return total;
+}
+
+function main() {
+	let words = ["a", "b"];
+	print("fib", fib(10), words, 7 / 2, !0);
+	let first = 0;
+	let second = 0;
+	let total = 0;
+	nogc {
+		let bytes = stackalloc byte[2];
+		bytes[0] = 300;
+		bytes[1] = -1;
+		let ints = stackalloc int[3];
+		ints[0] = 2.7;
+		ints[1] = sum(bytes);
+		first = ints[0];
+		second = ints[1];
+		total = sum(ints);
+	}
+	print(first, second, total);
+	print("done" + "!", len("héllo"), "abc"[1], null == null, 0 || "x");
+}
+`, RunOptions{})
+
+	if res.Error != nil {
+		t.Fatalf("runtime error: %v", res.Error)
+	}
+	want := "fib 55 [a, b] 3.5 true\n2 299 301\ndone! 5 b true x\n"
+	if res.Output != want {
+		t.Errorf("output = %q, want %q", res.Output, want)
+	}
+	if res.Runtime != Backend || res.Steps == 0 {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		opts RunOptions
+		want string
+		pos  Pos
+	}{
+		{"Index out of range", "let a = [1];\nprint(a[3]);", RunOptions{}, "index 3 out of range", Pos{2, 8}},
+		{"Type error", "let a = \"x\" - 1;\nprint(a);", RunOptions{}, "expected a number, got string", Pos{1, 13}},
+		{"Step limit", "while (true) { }", RunOptions{MaxSteps: 1000}, "step limit of 1000 exceeded", Pos{}},
+		{"Call depth", "function f(n) { return f(n + 1); }\nf(0);", RunOptions{MaxCallDepth: 50}, "maximum call depth of 50", Pos{}},
+		{"Stack overflow in loop", "nogc {\n  let i = 0;\n  while (i < 100) {\n    let b = stackalloc byte[65536];\n    b[0] = i;\n    i += 1;\n  }\n}", RunOptions{}, "stack overflow", Pos{4, 13}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := run(t, tt.src, tt.opts)
+			if res.Error == nil || !strings.Contains(res.Error.Message, tt.want) {
+				t.Fatalf("error = %v, want %q", res.Error, tt.want)
+			}
+			if tt.pos != (Pos{}) && res.Error.Pos != tt.pos {
+				t.Errorf("error at %s, want %s", res.Error.Pos, tt.pos)
+			}
+		})
+	}
+}
+
+func TestRunOutputLimit(t *testing.T) {
+	res := run(t, "let i = 0;\nwhile (i < 100) { print(\"0123456789\"); i += 1; }", RunOptions{MaxOutput: 25})
+	if res.Error != nil || len(res.Output) != 25 || !res.OutputTruncated {
+		t.Errorf("output = %q, truncated = %v, err = %v", res.Output, res.OutputTruncated, res.Error)
+	}
+}
+
+func TestRunStreamsOutput(t *testing.T) {
+	var stdout strings.Builder
+	res := run(t, "let i = 0;\nwhile (i < 3) { print(i); i += 1; }", RunOptions{MaxOutput: 4, Stdout: &stdout})
+	if stdout.String() != res.Output || res.Output != "0\n1\n" {
+		t.Errorf("streamed %q, output %q", stdout.String(), res.Output)
+	}
+}
+
+func TestExec(t *testing.T) {
+	if res := Exec(context.Background(), "let x = ;", RunOptions{}); res.Compile.OK || res.Run != nil {
+		t.Errorf("broken source ran: %+v", res)
+	}
+	if res := Exec(context.Background(), "print(1 + 1);", RunOptions{}); res.Run == nil || res.Run.Output != "2\n" {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res := Run(ctx, Compile("while (true) { }").Program, RunOptions{})
+	if res.Error == nil || !strings.Contains(res.Error.Message, "cancelled") {
+		t.Errorf("error = %v", res.Error)
+	}
+}
+
+func TestBuffersAreFreedWithTheirRegion(t *testing.T) {
+	in := &interp{}
+	in.openRegion()
+	buf := newBuffer("int", 4)
+	in.regions[0] = append(in.regions[0], buf)
+	buf.Set(3, 9)
+	if buf.Get(3) != 9 {
+		t.Fatalf("Get(3) = %v", buf.Get(3))
+	}
+	in.closeRegion()
+	if !buf.Freed() {
+		t.Error("buffer still live after its region closed")
+	}
+}
+
+func TestBackend(t *testing.T) {
+	if (Backend == "cgo") != NativeMemory {
+		t.Errorf("Backend = %s but NativeMemory = %v", Backend, NativeMemory)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build ujs_cgo && cgo
+
+package ujs
+
+/*
+#cgo LDFLAGS: -lm
+#include <math.h>
+#include <stdint.h>
+#include <string.h>
+
+#if defined(__x86_64__) || defined(__i386__)
+#include <x86intrin.h>
+static uint64_t ujs_cycles(void) { return __rdtsc(); }
+static int ujs_has_cycles(void) { return 1; }
+#else
+static uint64_t ujs_cycles(void) { return 0; }
+static int ujs_has_cycles(void) { return 0; }
+#endif
+
+static double ujs_sqrt_sum(int n) {
+	double sum = 0;
+	for (int i = 1; i <= n; i++) sum += sqrt((double)i);
+	return sum;
+}
+
+static double ujs_inv_sqrt_sum(int n) {
+	float sum = 0;
+	for (int i = 1; i <= n; i++) {
+		float x = (float)i, y;
+		uint32_t bits;
+		memcpy(&bits, &x, sizeof bits);
+		bits = 0x5f3759df - (bits >> 1);
+		memcpy(&y, &bits, sizeof y);
+		y *= 1.5f - 0.5f * x * y * y;
+		sum += y;
+	}
+	return sum;
+}
+
+static double ujs_dot_product(int length, int reps) {
+	static double a[4096], b[4096];
+	if (length > 4096) length = 4096;
+	for (int i = 0; i < length; i++) {
+		a[i] = i * 0.5;
+		b[i] = (length - i) * 0.25;
+	}
+	double sum = 0;
+	for (int r = 0; r < reps; r++)
+		for (int i = 0; i < length; i++) sum += a[i] * b[i];
+	return sum;
+}
+
+static double ujs_fib_iter(int n) {
+	uint64_t a = 0, b = 1;
+	for (int i = 0; i < n; i++) {
+		uint64_t t = a + b;
+		a = b;
+		b = t;
+	}
+	return (double)(a & 0xffffffff);
+}
+*/
+import "C"
+
+// Kernels returns the FastMath micro-benchmarks, implemented in C.
+func Kernels() []Kernel {
+	return []Kernel{
+		{Name: "sqrt_sum", Ops: 1_000_000, run: func() float64 { return float64(C.ujs_sqrt_sum(1_000_000)) }},
+		{Name: "inv_sqrt", Ops: 1_000_000, run: func() float64 { return float64(C.ujs_inv_sqrt_sum(1_000_000)) }},
+		{Name: "dot_product", Ops: 4096 * 256, run: func() float64 { return float64(C.ujs_dot_product(4096, 256)) }},
+		{Name: "fib_iter", Ops: 1_000_000, run: func() float64 { return float64(C.ujs_fib_iter(1_000_000)) }},
+	}
+}
+
+// readCycles reads the CPU timestamp counter where the platform has one.
+func readCycles() (uint64, bool) {
+	if C.ujs_has_cycles() == 0 {
+		return 0, false
+	}
+	return uint64(C.ujs_cycles()), true
+}
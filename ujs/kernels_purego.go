@@ -0,0 +1,66 @@
+//go:build !ujs_cgo || !cgo
+
+package ujs
+
+import "math"
+
+// Kernels returns the FastMath micro-benchmarks, implemented in Go.
+func Kernels() []Kernel {
+	return []Kernel{
+		{Name: "sqrt_sum", Ops: 1_000_000, run: func() float64 { return sqrtSum(1_000_000) }},
+		{Name: "inv_sqrt", Ops: 1_000_000, run: func() float64 { return invSqrtSum(1_000_000) }},
+		{Name: "dot_product", Ops: 4096 * 256, run: func() float64 { return dotProduct(4096, 256) }},
+		{Name: "fib_iter", Ops: 1_000_000, run: func() float64 { return fibIter(1_000_000) }},
+	}
+}
+
+// readCycles reports that the fallback has no cycle counter; benchmarks
+// fall back to wall-clock time only.
+func readCycles() (uint64, bool) {
+	return 0, false
+}
+
+func sqrtSum(n int) float64 {
+	sum := 0.0
+	for i := 1; i <= n; i++ {
+		sum += math.Sqrt(float64(i))
+	}
+	return sum
+}
+
+// invSqrtSum is the classic bit-level fast inverse square root with one
+// Newton step.
+func invSqrtSum(n int) float64 {
+	var sum float32
+	for i := 1; i <= n; i++ {
+		x := float32(i)
+		y := math.Float32frombits(0x5f3759df - math.Float32bits(x)>>1)
+		y *= 1.5 - 0.5*x*y*y
+		sum += y
+	}
+	return float64(sum)
+}
+
+func dotProduct(length, reps int) float64 {
+	a := make([]float64, length)
+	b := make([]float64, length)
+	for i := range a {
+		a[i] = float64(i) * 0.5
+		b[i] = float64(length-i) * 0.25
+	}
+	sum := 0.0
+	for r := 0; r < reps; r++ {
+		for i := range a {
+			sum += a[i] * b[i]
+		}
+	}
+	return sum
+}
+
+func fibIter(n int) float64 {
+	var a, b uint64 = 0, 1
+	for i := 0; i < n; i++ {
+		a, b = b, a+b
+	}
+	return float64(a & 0xffffffff)
+}
@@ -0,0 +1,259 @@
+package ujs
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type lexer struct {
+	src   string
+	off   int
+	line  int
+	col   int
+	diags *diagnostics
+}
+
+func newLexer(src string, diags *diagnostics) *lexer {
+	return &lexer{src: src, line: 1, col: 1, diags: diags}
+}
+
+// Tokenize splits src into tokens, ending with EOF. Lexical errors are
+// reported as diagnostics and produce Illegal tokens.
+func Tokenize(src string) ([]Token, []Diagnostic) {
+	diags := &diagnostics{}
+	lx := newLexer(src, diags)
+	var toks []Token
+	for {
+		tok := lx.next()
+		toks = append(toks, tok)
+		if tok.Kind == EOF {
+			return toks, diags.list
+		}
+	}
+}
+
+func (lx *lexer) peek() rune {
+	if lx.off >= len(lx.src) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(lx.src[lx.off:])
+	return r
+}
+
+func (lx *lexer) peekAt(n int) rune {
+	off := lx.off
+	for i := 0; i < n && off < len(lx.src); i++ {
+		_, size := utf8.DecodeRuneInString(lx.src[off:])
+		off += size
+	}
+	if off >= len(lx.src) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(lx.src[off:])
+	return r
+}
+
+func (lx *lexer) advance() rune {
+	r, size := utf8.DecodeRuneInString(lx.src[lx.off:])
+	lx.off += size
+	if r == '\n' {
+		lx.line++
+		lx.col = 1
+	} else {
+		lx.col++
+	}
+	return r
+}
+
+func (lx *lexer) pos() Pos {
+	return Pos{Line: lx.line, Col: lx.col}
+}
+
+func (lx *lexer) skipSpaceAndComments() {
+	for lx.off < len(lx.src) {
+		r := lx.peek()
+		switch {
+		case unicode.IsSpace(r):
+			lx.advance()
+		case r == '/' && lx.peekAt(1) == '/':
+			for lx.off < len(lx.src) && lx.peek() != '\n' {
+				lx.advance()
+			}
+		case r == '/' && lx.peekAt(1) == '*':
+			start := lx.pos()
+			lx.advance()
+			lx.advance()
+			closed := false
+			for lx.off < len(lx.src) {
+				if lx.peek() == '*' && lx.peekAt(1) == '/' {
+					lx.advance()
+					lx.advance()
+					closed = true
+					break
+				}
+				lx.advance()
+			}
+			if !closed {
+				lx.diags.errorf(start, CodeSyntax, "unterminated block comment")
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (lx *lexer) next() Token {
+	lx.skipSpaceAndComments()
+	start := lx.pos()
+	if lx.off >= len(lx.src) {
+		return Token{Kind: EOF, Pos: start}
+	}
+
+	r := lx.peek()
+	switch {
+	case isIdentStart(r):
+		return lx.ident(start)
+	case isDigit(r):
+		return lx.number(start)
+	case r == '"' || r == '\'':
+		return lx.string(start)
+	}
+
+	lx.advance()
+	two := func(next rune, ifTwo, ifOne TokenKind) Token {
+		if lx.peek() == next {
+			lx.advance()
+			return Token{Kind: ifTwo, Pos: start}
+		}
+		return Token{Kind: ifOne, Pos: start}
+	}
+
+	switch r {
+	case '(':
+		return Token{Kind: LParen, Pos: start}
+	case ')':
+		return Token{Kind: RParen, Pos: start}
+	case '{':
+		return Token{Kind: LBrace, Pos: start}
+	case '}':
+		return Token{Kind: RBrace, Pos: start}
+	case '[':
+		return Token{Kind: LBracket, Pos: start}
+	case ']':
+		return Token{Kind: RBracket, Pos: start}
+	case ',':
+		return Token{Kind: Comma, Pos: start}
+	case ';':
+		return Token{Kind: Semicolon, Pos: start}
+	case '*':
+		return Token{Kind: Star, Pos: start}
+	case '/':
+		return Token{Kind: Slash, Pos: start}
+	case '%':
+		return Token{Kind: Percent, Pos: start}
+	case '+':
+		return two('=', PlusAssign, Plus)
+	case '-':
+		return two('=', MinusAssign, Minus)
+	case '=':
+		return two('=', Eq, Assign)
+	case '!':
+		return two('=', NotEq, Not)
+	case '<':
+		return two('=', LtEq, Lt)
+	case '>':
+		return two('=', GtEq, Gt)
+	case '&':
+		if lx.peek() == '&' {
+			lx.advance()
+			return Token{Kind: AndAnd, Pos: start}
+		}
+	case '|':
+		if lx.peek() == '|' {
+			lx.advance()
+			return Token{Kind: OrOr, Pos: start}
+		}
+	}
+
+	lx.diags.errorf(start, CodeSyntax, "unexpected character %q", r)
+	return Token{Kind: Illegal, Text: string(r), Pos: start}
+}
+
+func (lx *lexer) ident(start Pos) Token {
+	begin := lx.off
+	for lx.off < len(lx.src) && isIdentPart(lx.peek()) {
+		lx.advance()
+	}
+	text := lx.src[begin:lx.off]
+	if kind, ok := keywords[text]; ok {
+		return Token{Kind: kind, Text: text, Pos: start}
+	}
+	return Token{Kind: Identifier, Text: text, Pos: start}
+}
+
+func (lx *lexer) number(start Pos) Token {
+	begin := lx.off
+	for isDigit(lx.peek()) {
+		lx.advance()
+	}
+	if lx.peek() == '.' && isDigit(lx.peekAt(1)) {
+		lx.advance()
+		for isDigit(lx.peek()) {
+			lx.advance()
+		}
+	}
+	if isIdentStart(lx.peek()) {
+		for isIdentPart(lx.peek()) {
+			lx.advance()
+		}
+		lx.diags.errorf(start, CodeSyntax, "malformed number %q", lx.src[begin:lx.off])
+	}
+	return Token{Kind: Number, Text: lx.src[begin:lx.off], Pos: start}
+}
+
+func (lx *lexer) string(start Pos) Token {
+	quote := lx.advance()
+	var b strings.Builder
+	for {
+		if lx.off >= len(lx.src) || lx.peek() == '\n' {
+			lx.diags.errorf(start, CodeSyntax, "unterminated string literal")
+			return Token{Kind: String, Text: b.String(), Pos: start}
+		}
+		r := lx.advance()
+		if r == quote {
+			return Token{Kind: String, Text: b.String(), Pos: start}
+		}
+		if r != '\\' {
+			b.WriteRune(r)
+			continue
+		}
+
+		escPos := lx.pos()
+		if lx.off >= len(lx.src) {
+			continue
+		}
+		switch esc := lx.advance(); esc {
+		case 'n':
+			b.WriteRune('\n')
+		case 't':
+			b.WriteRune('\t')
+		case '\\', '"', '\'':
+			b.WriteRune(esc)
+		default:
+			lx.diags.errorf(escPos, CodeSyntax, "unknown escape sequence \\%c", esc)
+		}
+	}
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || isDigit(r)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
@@ -0,0 +1,58 @@
+package ujs
+
+import "testing"
+
+func TestTokenize(t *testing.T) {
+	src := "nogc function f(a) {\n  let s = \"a\\tb\"; // comment\n  a += 1.5 <= 2 && !x;\n}"
+	toks, diags := Tokenize(src)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+
+	want := []TokenKind{
+		NoGC, Function, Identifier, LParen, Identifier, RParen, LBrace,
+		Let, Identifier, Assign, String, Semicolon,
+		Identifier, PlusAssign, Number, LtEq, Number, AndAnd, Not, Identifier, Semicolon,
+		RBrace, EOF,
+	}
+	if len(toks) != len(want) {
+		t.Fatalf("got %d tokens, want %d: %v", len(toks), len(want), toks)
+	}
+	for i, kind := range want {
+		if toks[i].Kind != kind {
+			t.Errorf("token %d = %s, want %s", i, toks[i].Kind, kind)
+		}
+	}
+
+	if s := toks[10]; s.Text != "a\tb" || s.Pos != (Pos{Line: 2, Col: 11}) {
+		t.Errorf("string token = %+v", s)
+	}
+	if n := toks[14]; n.Text != "1.5" || n.Pos != (Pos{Line: 3, Col: 8}) {
+		t.Errorf("number token = %+v", n)
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		pos  Pos
+	}{
+		{"Unexpected character", "let a = 1 # 2;", Pos{Line: 1, Col: 11}},
+		{"Unterminated string", "let s = \"abc\nlet t;", Pos{Line: 1, Col: 9}},
+		{"Unterminated comment", "x;\n/* open", Pos{Line: 2, Col: 1}},
+		{"Malformed number", "let n = 12ab;", Pos{Line: 1, Col: 9}},
+		{"Bad escape", `let s = "\q";`, Pos{Line: 1, Col: 11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, diags := Tokenize(tt.src)
+			if len(diags) != 1 {
+				t.Fatalf("got %d diagnostics, want 1: %v", len(diags), diags)
+			}
+			if diags[0].Pos != tt.pos || diags[0].Code != CodeSyntax {
+				t.Errorf("diagnostic = %+v, want syntax error at %s", diags[0], tt.pos)
+			}
+		})
+	}
+}
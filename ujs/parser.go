@@ -0,0 +1,418 @@
+package ujs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxNesting bounds expression and block depth so hostile input cannot
+// exhaust the stack.
+const maxNesting = 200
+
+// StackAllocTypes are the element types stackalloc accepts, with their size
+// in bytes.
+var StackAllocTypes = map[string]int{
+	"byte":  1,
+	"int":   8,
+	"float": 8,
+}
+
+type parser struct {
+	toks  []Token
+	pos   int
+	depth int
+	diags *diagnostics
+}
+
+// bail aborts the current statement after a syntax error; parseStmtList
+// recovers it and resynchronizes.
+type bail struct{}
+
+// Parse parses src into a Program. The program is returned even when there
+// are syntax errors, with the broken statements left out.
+func Parse(src string) (*Program, []Diagnostic) {
+	diags := &diagnostics{}
+	prog := parse(src, diags)
+	return prog, diags.list
+}
+
+func parse(src string, diags *diagnostics) *Program {
+	lx := newLexer(src, diags)
+	var toks []Token
+	for {
+		tok := lx.next()
+		if tok.Kind == Illegal {
+			continue // already reported by the lexer
+		}
+		toks = append(toks, tok)
+		if tok.Kind == EOF {
+			break
+		}
+	}
+
+	p := &parser{toks: toks, diags: diags}
+	return &Program{Body: p.parseStmtList(EOF)}
+}
+
+func (p *parser) peek() Token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) peekKind(offset int) TokenKind {
+	if p.pos+offset >= len(p.toks) {
+		return EOF
+	}
+	return p.toks[p.pos+offset].Kind
+}
+
+func (p *parser) advance() Token {
+	tok := p.toks[p.pos]
+	if tok.Kind != EOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) accept(kind TokenKind) bool {
+	if p.peek().Kind == kind {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind TokenKind, context string) Token {
+	tok := p.peek()
+	if tok.Kind != kind {
+		p.fail(tok.Pos, "expected %q %s, found %s", kind.String(), context, tok.describe())
+	}
+	return p.advance()
+}
+
+func (p *parser) fail(pos Pos, format string, args ...interface{}) {
+	p.diags.errorf(pos, CodeSyntax, format, args...)
+	panic(bail{})
+}
+
+func (p *parser) enter(pos Pos) {
+	p.depth++
+	if p.depth > maxNesting {
+		p.fail(pos, "nesting too deep (limit %d)", maxNesting)
+	}
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+// parseStmtList parses statements until end (RBrace or EOF), recovering
+// from syntax errors one statement at a time.
+func (p *parser) parseStmtList(end TokenKind) []Stmt {
+	var stmts []Stmt
+	for p.peek().Kind != end && p.peek().Kind != EOF {
+		before := p.pos
+		if stmt, ok := p.tryStmt(); ok {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		p.synchronize(before)
+	}
+	return stmts
+}
+
+func (p *parser) tryStmt() (stmt Stmt, ok bool) {
+	depth := p.depth
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isBail := r.(bail); !isBail {
+				panic(r)
+			}
+			p.depth = depth
+			stmt, ok = nil, false
+		}
+	}()
+	return p.parseStmt(), true
+}
+
+// synchronize skips to the start of the next statement, stepping over whole
+// brace-delimited bodies so a broken header does not leave its body behind.
+// It always makes progress so a bad token cannot stall the parser.
+func (p *parser) synchronize(start int) {
+	if p.pos == start && p.peek().Kind != LBrace {
+		p.advance()
+	}
+	for {
+		switch p.peek().Kind {
+		case EOF, RBrace:
+			return
+		case Semicolon:
+			p.advance()
+			return
+		case LBrace:
+			p.skipBraces()
+			return
+		case Function, Let, Const, Return, If, While, NoGC:
+			if p.pos != start {
+				return
+			}
+		}
+		p.advance()
+	}
+}
+
+// skipBraces advances past the balanced {...} group at the current token.
+func (p *parser) skipBraces() {
+	depth := 0
+	for {
+		switch p.advance().Kind {
+		case LBrace:
+			depth++
+		case RBrace:
+			depth--
+			if depth == 0 {
+				return
+			}
+		case EOF:
+			return
+		}
+	}
+}
+
+func (p *parser) parseStmt() Stmt {
+	tok := p.peek()
+	switch tok.Kind {
+	case Function:
+		return p.parseFunc(false)
+	case NoGC:
+		if p.peekKind(1) == Function {
+			p.advance()
+			return p.parseFunc(true)
+		}
+		p.advance()
+		return &NoGCBlock{Pos: tok.Pos, Body: p.parseBlock("after nogc")}
+	case Let, Const:
+		return p.parseVarDecl()
+	case If:
+		return p.parseIf()
+	case While:
+		p.advance()
+		cond := p.parseParenExpr("while")
+		return &WhileStmt{Pos: tok.Pos, Cond: cond, Body: p.parseBlock("for while body")}
+	case Return:
+		p.advance()
+		ret := &ReturnStmt{Pos: tok.Pos}
+		if p.peek().Kind != Semicolon {
+			ret.Value = p.parseExpr()
+		}
+		p.expect(Semicolon, "after return")
+		return ret
+	case LBrace:
+		return p.parseBlock("")
+	case Semicolon:
+		p.fail(tok.Pos, "empty statement")
+	}
+	return p.parseSimpleStmt()
+}
+
+func (p *parser) parseFunc(nogc bool) *FuncDecl {
+	tok := p.expect(Function, "")
+	name := p.expect(Identifier, "after function")
+	fn := &FuncDecl{Pos: tok.Pos, Name: &Ident{Pos: name.Pos, Name: name.Text}, NoGC: nogc}
+
+	p.expect(LParen, "after function name")
+	for p.peek().Kind != RParen {
+		param := p.expect(Identifier, "in parameter list")
+		fn.Params = append(fn.Params, &Ident{Pos: param.Pos, Name: param.Text})
+		if !p.accept(Comma) {
+			break
+		}
+	}
+	p.expect(RParen, "after parameters")
+	fn.Body = p.parseBlock("for function body")
+	return fn
+}
+
+func (p *parser) parseVarDecl() *VarDecl {
+	tok := p.advance()
+	name := p.expect(Identifier, "after "+tok.Kind.String())
+	decl := &VarDecl{Pos: tok.Pos, Const: tok.Kind == Const, Name: &Ident{Pos: name.Pos, Name: name.Text}}
+	if p.accept(Assign) {
+		decl.Value = p.parseExpr()
+	} else if decl.Const {
+		p.fail(p.peek().Pos, "const %s must be initialized", name.Text)
+	}
+	p.expect(Semicolon, "after declaration")
+	return decl
+}
+
+func (p *parser) parseIf() *IfStmt {
+	tok := p.expect(If, "")
+	stmt := &IfStmt{Pos: tok.Pos, Cond: p.parseParenExpr("if")}
+	stmt.Then = p.parseBlock("for if body")
+	if p.accept(Else) {
+		if p.peek().Kind == If {
+			stmt.Else = p.parseIf()
+		} else {
+			stmt.Else = p.parseBlock("after else")
+		}
+	}
+	return stmt
+}
+
+func (p *parser) parseBlock(context string) *Block {
+	open := p.expect(LBrace, context)
+	p.enter(open.Pos)
+	defer p.leave()
+
+	block := &Block{Pos: open.Pos, Stmts: p.parseStmtList(RBrace)}
+	if p.peek().Kind != RBrace {
+		p.fail(p.peek().Pos, "missing \"}\" to close block opened at %s", open.Pos)
+	}
+	p.advance()
+	return block
+}
+
+func (p *parser) parseSimpleStmt() Stmt {
+	x := p.parseExpr()
+	switch op := p.peek(); op.Kind {
+	case Assign, PlusAssign, MinusAssign:
+		p.advance()
+		value := p.parseExpr()
+		p.expect(Semicolon, "after assignment")
+		return &AssignStmt{Pos: op.Pos, Target: x, Op: op.Kind, Value: value}
+	}
+	p.expect(Semicolon, "after expression")
+	return &ExprStmt{X: x}
+}
+
+func (p *parser) parseParenExpr(keyword string) Expr {
+	p.expect(LParen, "after "+keyword)
+	x := p.parseExpr()
+	p.expect(RParen, "after "+keyword+" condition")
+	return x
+}
+
+var binaryPrecedence = map[TokenKind]int{
+	OrOr:    1,
+	AndAnd:  2,
+	Eq:      3,
+	NotEq:   3,
+	Lt:      4,
+	LtEq:    4,
+	Gt:      4,
+	GtEq:    4,
+	Plus:    5,
+	Minus:   5,
+	Star:    6,
+	Slash:   6,
+	Percent: 6,
+}
+
+func (p *parser) parseExpr() Expr {
+	return p.parseBinary(1)
+}
+
+func (p *parser) parseBinary(minPrec int) Expr {
+	x := p.parseUnary()
+	for {
+		op := p.peek()
+		prec, ok := binaryPrecedence[op.Kind]
+		if !ok || prec < minPrec {
+			return x
+		}
+		p.advance()
+		y := p.parseBinary(prec + 1)
+		x = &BinaryExpr{Pos: op.Pos, Op: op.Kind, X: x, Y: y}
+	}
+}
+
+func (p *parser) parseUnary() Expr {
+	tok := p.peek()
+	if tok.Kind == Not || tok.Kind == Minus {
+		p.advance()
+		p.enter(tok.Pos)
+		defer p.leave()
+		return &UnaryExpr{Pos: tok.Pos, Op: tok.Kind, X: p.parseUnary()}
+	}
+	return p.parsePostfix(p.parsePrimary())
+}
+
+func (p *parser) parsePostfix(x Expr) Expr {
+	for {
+		tok := p.peek()
+		switch tok.Kind {
+		case LParen:
+			p.advance()
+			call := &CallExpr{Pos: tok.Pos, Fn: x, Args: p.parseExprList(RParen, "in argument list")}
+			x = call
+		case LBracket:
+			p.advance()
+			index := p.parseExpr()
+			p.expect(RBracket, "after index")
+			x = &IndexExpr{Pos: tok.Pos, X: x, Index: index}
+		default:
+			return x
+		}
+	}
+}
+
+func (p *parser) parseExprList(end TokenKind, context string) []Expr {
+	var list []Expr
+	for p.peek().Kind != end {
+		list = append(list, p.parseExpr())
+		if !p.accept(Comma) {
+			break
+		}
+	}
+	p.expect(end, context)
+	return list
+}
+
+func (p *parser) parsePrimary() Expr {
+	tok := p.peek()
+	switch tok.Kind {
+	case Identifier:
+		p.advance()
+		return &Ident{Pos: tok.Pos, Name: tok.Text}
+	case Number:
+		p.advance()
+		value, err := strconv.ParseFloat(tok.Text, 64)
+		if err != nil {
+			p.fail(tok.Pos, "invalid number %q", tok.Text)
+		}
+		return &NumberLit{Pos: tok.Pos, Value: value, IsInt: !strings.Contains(tok.Text, ".")}
+	case String:
+		p.advance()
+		return &StringLit{Pos: tok.Pos, Value: tok.Text}
+	case True, False:
+		p.advance()
+		return &BoolLit{Pos: tok.Pos, Value: tok.Kind == True}
+	case Null:
+		p.advance()
+		return &NullLit{Pos: tok.Pos}
+	case LParen:
+		p.advance()
+		p.enter(tok.Pos)
+		defer p.leave()
+		x := p.parseExpr()
+		p.expect(RParen, "to close parenthesis")
+		return x
+	case LBracket:
+		p.advance()
+		p.enter(tok.Pos)
+		defer p.leave()
+		return &ArrayLit{Pos: tok.Pos, Elems: p.parseExprList(RBracket, "to close array literal")}
+	case StackAlloc:
+		p.advance()
+		elem := p.expect(Identifier, "element type after stackalloc")
+		if _, ok := StackAllocTypes[elem.Text]; !ok {
+			p.fail(elem.Pos, "unknown stackalloc element type %q (want byte, int or float)", elem.Text)
+		}
+		p.expect(LBracket, "after stackalloc element type")
+		size := p.parseExpr()
+		p.expect(RBracket, "after stackalloc size")
+		return &StackAllocExpr{Pos: tok.Pos, ElemType: elem.Text, Size: size}
+	}
+	p.fail(tok.Pos, "expected expression, found %s", tok.describe())
+	return nil
+}
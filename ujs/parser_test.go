@@ -0,0 +1,106 @@
+package ujs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProgram(t *testing.T) {
+	src := `
+nogc function dot(a, b, n) {
+	let sum = 0;
+	let i = 0;
+	while (i < n) {
+		sum += a[i] * b[i];
+		i = i + 1;
+	}
+	return sum;
+}
+
+function main() {
+	nogc {
+		let v = stackalloc float[4];
+		v[0] = -1;
+		print(dot(v, v, 4));
+	}
+	if (1 + 2 * 3 == 7 || false) { print("ok"); } else if (true) { } else { }
+}
+`
+	prog, diags := Parse(src)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+
+	funcs := prog.Functions()
+	if len(funcs) != 2 {
+		t.Fatalf("got %d functions, want 2", len(funcs))
+	}
+	dot := funcs[0]
+	if dot.Name.Name != "dot" || !dot.NoGC || len(dot.Params) != 3 || dot.Pos != (Pos{Line: 2, Col: 6}) {
+		t.Errorf("dot = %+v", dot)
+	}
+
+	block, ok := funcs[1].Body.Stmts[0].(*NoGCBlock)
+	if !ok {
+		t.Fatalf("main body[0] = %T, want *NoGCBlock", funcs[1].Body.Stmts[0])
+	}
+	decl := block.Body.Stmts[0].(*VarDecl)
+	alloc, ok := decl.Value.(*StackAllocExpr)
+	if !ok || alloc.ElemType != "float" || alloc.Size.(*NumberLit).Value != 4 {
+		t.Errorf("stackalloc = %+v", decl.Value)
+	}
+
+	// 1 + 2 * 3 == 7 || false parses as ((1 + (2 * 3)) == 7) || false.
+	cond := funcs[1].Body.Stmts[1].(*IfStmt).Cond.(*BinaryExpr)
+	if cond.Op != OrOr {
+		t.Fatalf("top operator = %s, want ||", cond.Op)
+	}
+	eq := cond.X.(*BinaryExpr)
+	sum := eq.X.(*BinaryExpr)
+	if eq.Op != Eq || sum.Op != Plus || sum.Y.(*BinaryExpr).Op != Star {
+		t.Errorf("unexpected precedence: %+v", eq)
+	}
+}
+
+func TestParseRecoversFromErrors(t *testing.T) {
+	src := `let a = ;
+let b = 2;
+function f( {
+}
+let c = 3
+print(b);`
+	prog, diags := Parse(src)
+
+	var lines []int
+	for _, d := range diags {
+		if d.Code != CodeSyntax {
+			t.Errorf("unexpected diagnostic %v", d)
+		}
+		lines = append(lines, d.Line)
+	}
+	if len(lines) != 3 || lines[0] != 1 || lines[1] != 3 || lines[2] != 6 {
+		t.Fatalf("diagnostics = %v", diags)
+	}
+	if !strings.Contains(diags[2].Message, `expected ";" after declaration`) {
+		t.Errorf("message = %q", diags[2].Message)
+	}
+
+	// let b survives between the broken statements.
+	found := false
+	for _, s := range prog.Body {
+		if d, ok := s.(*VarDecl); ok && d.Name.Name == "b" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected let b to be parsed")
+	}
+}
+
+func TestParseNestingLimit(t *testing.T) {
+	src := "let x = " + strings.Repeat("(", maxNesting+10) + "1" + strings.Repeat(")", maxNesting+10) + ";"
+	_, diags := Parse(src)
+	if len(diags) == 0 || !strings.Contains(diags[0].Message, "nesting too deep") {
+		t.Fatalf("diagnostics = %v", diags)
+	}
+}
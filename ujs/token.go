@@ -0,0 +1,162 @@
+// Package ujs implements the front end of UnleashedJS, a small JavaScript-like
+// demo language with manual memory control: `nogc` functions and blocks that
+// may not touch the garbage-collected heap, and `stackalloc` buffers that live
+// only as long as the enclosing `nogc` block.
+//
+// Compile runs the lexer, parser and semantic checks and reports every
+// problem as a Diagnostic with a line and column.
+package ujs
+
+import "fmt"
+
+// Pos is a 1-based source position. Columns count runes, not bytes.
+type Pos struct {
+	Line int `json:"line"`
+	Col  int `json:"column"`
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+type TokenKind int
+
+const (
+	EOF TokenKind = iota
+	Illegal
+
+	Identifier
+	Number
+	String
+
+	// Keywords
+	Function
+	Let
+	Const
+	Return
+	If
+	Else
+	While
+	NoGC
+	StackAlloc
+	True
+	False
+	Null
+
+	// Punctuation
+	LParen
+	RParen
+	LBrace
+	RBrace
+	LBracket
+	RBracket
+	Comma
+	Semicolon
+
+	// Operators
+	Assign
+	PlusAssign
+	MinusAssign
+	Plus
+	Minus
+	Star
+	Slash
+	Percent
+	Eq
+	NotEq
+	Lt
+	LtEq
+	Gt
+	GtEq
+	AndAnd
+	OrOr
+	Not
+)
+
+var tokenNames = map[TokenKind]string{
+	EOF:         "end of file",
+	Illegal:     "illegal character",
+	Identifier:  "identifier",
+	Number:      "number",
+	String:      "string",
+	Function:    "function",
+	Let:         "let",
+	Const:       "const",
+	Return:      "return",
+	If:          "if",
+	Else:        "else",
+	While:       "while",
+	NoGC:        "nogc",
+	StackAlloc:  "stackalloc",
+	True:        "true",
+	False:       "false",
+	Null:        "null",
+	LParen:      "(",
+	RParen:      ")",
+	LBrace:      "{",
+	RBrace:      "}",
+	LBracket:    "[",
+	RBracket:    "]",
+	Comma:       ",",
+	Semicolon:   ";",
+	Assign:      "=",
+	PlusAssign:  "+=",
+	MinusAssign: "-=",
+	Plus:        "+",
+	Minus:       "-",
+	Star:        "*",
+	Slash:       "/",
+	Percent:     "%",
+	Eq:          "==",
+	NotEq:       "!=",
+	Lt:          "<",
+	LtEq:        "<=",
+	Gt:          ">",
+	GtEq:        ">=",
+	AndAnd:      "&&",
+	OrOr:        "||",
+	Not:         "!",
+}
+
+func (k TokenKind) String() string {
+	if name, ok := tokenNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("token(%d)", int(k))
+}
+
+var keywords = map[string]TokenKind{
+	"function":   Function,
+	"let":        Let,
+	"const":      Const,
+	"return":     Return,
+	"if":         If,
+	"else":       Else,
+	"while":      While,
+	"nogc":       NoGC,
+	"stackalloc": StackAlloc,
+	"true":       True,
+	"false":      False,
+	"null":       Null,
+}
+
+// Token is a lexeme with its position. Text holds the source spelling for
+// identifiers and numbers and the unquoted value for strings.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  Pos
+}
+
+func (t Token) describe() string {
+	switch t.Kind {
+	case Identifier, Number:
+		return fmt.Sprintf("%s %q", t.Kind, t.Text)
+	case String:
+		return "string literal"
+	case EOF, Illegal:
+		return t.Kind.String()
+	default:
+		return fmt.Sprintf("%q", t.Kind.String())
+	}
+}
@@ -0,0 +1,274 @@
+// Package worker runs UnleashedJS jobs in a child process so a crash, hang or
+// runaway allocation in the compiler cannot take the web server down with it.
+//
+// The server binary doubles as the worker: started with Arg as its first
+// argument it handles a single JSON request on stdin, writes the response to
+// stdout and exits. Runs stream program output ahead of the response as
+// output-only lines of newline-delimited JSON.
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"allanswebterminal/ujs"
+)
+
+// Arg is the command-line argument that switches the binary into worker mode.
+const Arg = "ujs-worker"
+
+// Operations understood by Serve.
+const (
+	OpCompile   = "compile"
+	OpBenchmark = "benchmark"
+	OpRun       = "run"
+)
+
+const (
+	// maxOutput bounds how much a worker may write back.
+	maxOutput = 4 << 20
+	// maxStderr is how much of a crashed worker's stderr is kept for logs.
+	maxStderr = 4 << 10
+	// memoryLimit is passed to the worker as GOMEMLIMIT.
+	memoryLimit = "256MiB"
+)
+
+var (
+	ErrTimeout = errors.New("ujs worker timed out")
+	ErrBusy    = errors.New("all ujs workers are busy")
+	ErrCrashed = errors.New("ujs worker crashed")
+)
+
+type Request struct {
+	Op         string `json:"op"`
+	Source     string `json:"source"`
+	Iterations int    `json:"iterations,omitempty"`
+}
+
+// Response is one line of worker output. Lines carrying only Output are
+// streamed program output; the last line has Result or Error.
+type Response struct {
+	Output string          `json:"output,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Serve handles one request from r and writes the response to w. It returns
+// the process exit code.
+func Serve(r io.Reader, w io.Writer) int {
+	var req Request
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return respond(w, nil, fmt.Errorf("invalid request: %w", err))
+	}
+
+	switch req.Op {
+	case OpCompile:
+		return respond(w, ujs.Compile(req.Source), nil)
+	case OpBenchmark:
+		return respond(w, ujs.Benchmark(context.Background(), req.Source, req.Iterations), nil)
+	case OpRun:
+		opts := ujs.RunOptions{Stdout: outputWriter{json.NewEncoder(w)}}
+		return respond(w, ujs.Exec(context.Background(), req.Source, opts), nil)
+	default:
+		return respond(w, nil, fmt.Errorf("unknown operation %q", req.Op))
+	}
+}
+
+// outputWriter forwards program output as output-only Response lines.
+type outputWriter struct {
+	enc *json.Encoder
+}
+
+func (o outputWriter) Write(p []byte) (int, error) {
+	if err := o.enc.Encode(Response{Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func respond(w io.Writer, result interface{}, err error) int {
+	var resp Response
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		resp.Result = raw
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// Client starts a worker process per request, with at most a fixed number
+// running at once.
+type Client struct {
+	Path string
+	Args []string
+	// Env is the worker's entire environment. The server's own environment,
+	// with its database and API credentials, is never inherited.
+	Env     []string
+	Timeout time.Duration
+	// BenchmarkTimeout replaces Timeout for benchmarks, which run the
+	// program repeatedly. Zero means four times Timeout.
+	BenchmarkTimeout time.Duration
+
+	slots chan struct{}
+}
+
+// NewClient returns a Client that runs path with args, allowing
+// maxConcurrent workers and timeout per request (queueing included).
+func NewClient(path string, args []string, maxConcurrent int, timeout time.Duration) *Client {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &Client{
+		Path:    path,
+		Args:    args,
+		Timeout: timeout,
+		slots:   make(chan struct{}, maxConcurrent),
+	}
+}
+
+// Compile compiles source in a worker process.
+func (c *Client) Compile(ctx context.Context, source string) (*ujs.Result, error) {
+	var res ujs.Result
+	if err := c.Do(ctx, Request{Op: OpCompile, Source: source}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Benchmark compiles and benchmarks source in a worker process.
+func (c *Client) Benchmark(ctx context.Context, source string, iterations int) (*ujs.BenchReport, error) {
+	timeout := c.BenchmarkTimeout
+	if timeout <= 0 {
+		timeout = 4 * c.Timeout
+	}
+	var report ujs.BenchReport
+	req := Request{Op: OpBenchmark, Source: source, Iterations: iterations}
+	if err := c.do(ctx, timeout, req, &report, nil); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Run compiles and runs source in a worker process, copying program output
+// to stdout as the worker produces it.
+func (c *Client) Run(ctx context.Context, source string, stdout io.Writer) (*ujs.ExecResult, error) {
+	var res ujs.ExecResult
+	if err := c.do(ctx, c.Timeout, Request{Op: OpRun, Source: source}, &res, stdout); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Do sends req to a fresh worker and decodes its result into out.
+func (c *Client) Do(ctx context.Context, req Request, out interface{}) error {
+	return c.do(ctx, c.Timeout, req, out, nil)
+}
+
+func (c *Client) do(ctx context.Context, timeout time.Duration, req Request, out interface{}, stream io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case c.slots <- struct{}{}:
+		defer func() { <-c.slots }()
+	case <-ctx.Done():
+		return ErrBusy
+	}
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Env = append([]string{"GOMEMLIMIT=" + memoryLimit}, c.Env...)
+	cmd.Stdin = bytes.NewReader(input)
+	stderr := &limitedBuffer{max: maxStderr}
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%w: %v", ErrCrashed, err)
+	}
+
+	resp, readErr := readResponse(stdout, stream)
+	if readErr != nil {
+		cancel() // stop a worker that is still writing
+	}
+	if err := cmd.Wait(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrTimeout
+		}
+		if readErr != nil {
+			err = readErr
+		}
+		return fmt.Errorf("%w: %v: %s", ErrCrashed, err, strings.TrimSpace(stderr.String()))
+	}
+	if readErr != nil {
+		return fmt.Errorf("%w: %v", ErrCrashed, readErr)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return json.Unmarshal(resp.Result, out)
+}
+
+// readResponse reads worker output lines up to the final response, copying
+// streamed output to stream. At most maxOutput bytes are read in total.
+func readResponse(r io.Reader, stream io.Writer) (Response, error) {
+	sc := bufio.NewScanner(io.LimitReader(r, maxOutput))
+	sc.Buffer(make([]byte, 0, 64<<10), maxOutput)
+	for sc.Scan() {
+		var resp Response
+		if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+			return Response{}, fmt.Errorf("invalid response: %v", err)
+		}
+		if resp.Result != nil || resp.Error != "" {
+			return resp, nil
+		}
+		if stream != nil && resp.Output != "" {
+			io.WriteString(stream, resp.Output)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return Response{}, err
+	}
+	return Response{}, fmt.Errorf("no response within %d bytes", maxOutput)
+}
+
+// limitedBuffer keeps at most max bytes and silently drops the rest, so a
+// chatty worker cannot exhaust server memory.
+type limitedBuffer struct {
+	bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.overflow = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as the worker, mirroring how the server binary
+// re-executes itself.
+func TestMain(m *testing.M) {
+	switch os.Getenv("UJS_WORKER_TEST_MODE") {
+	case "serve":
+		os.Exit(Serve(os.Stdin, os.Stdout))
+	case "env":
+		os.Exit(respond(os.Stdout, os.Getenv("DATABASE_URL"), nil))
+	case "crash":
+		panic("worker exploded")
+	case "hang":
+		time.Sleep(time.Hour)
+	}
+	os.Exit(m.Run())
+}
+
+func testClient(mode string, timeout time.Duration) *Client {
+	c := NewClient(os.Args[0], nil, 2, timeout)
+	c.Env = []string{"UJS_WORKER_TEST_MODE=" + mode}
+	return c
+}
+
+func TestCompileInWorker(t *testing.T) {
+	res, err := testClient("serve", 10*time.Second).Compile(context.Background(), "let a = ;\nprint(b);")
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if res.OK || len(res.Diagnostics) != 2 || res.Diagnostics[1].Line != 2 {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestBenchmarkInWorker(t *testing.T) {
+	report, err := testClient("serve", 30*time.Second).Benchmark(context.Background(), "print(1 + 1);", 2)
+	if err != nil {
+		t.Fatalf("Benchmark: %v", err)
+	}
+	if !report.Compile.OK || report.Program == nil || report.Program.Output != "2\n" || len(report.Kernels) == 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestRunInWorkerStreamsOutput(t *testing.T) {
+	var stdout strings.Builder
+	res, err := testClient("serve", 10*time.Second).Run(context.Background(), "print(\"a\");\nprint(\"b\");", &stdout)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if stdout.String() != "a\nb\n" || res.Run == nil || res.Run.Output != "a\nb\n" {
+		t.Errorf("streamed %q, result %+v", stdout.String(), res)
+	}
+}
+
+func TestWorkerDoesNotInheritEnvironment(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://secret")
+	var got string
+	if err := testClient("env", 10*time.Second).Do(context.Background(), Request{}, &got); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if got != "" {
+		t.Errorf("worker saw DATABASE_URL=%q", got)
+	}
+}
+
+func TestWorkerFailures(t *testing.T) {
+	t.Run("Crash", func(t *testing.T) {
+		_, err := testClient("crash", 10*time.Second).Compile(context.Background(), "x;")
+		if !errors.Is(err, ErrCrashed) || !strings.Contains(err.Error(), "worker exploded") {
+			t.Errorf("err = %v, want ErrCrashed with stderr", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		start := time.Now()
+		_, err := testClient("hang", 200*time.Millisecond).Compile(context.Background(), "x;")
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf("err = %v, want ErrTimeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("timeout took %s", elapsed)
+		}
+	})
+
+	t.Run("Unknown operation", func(t *testing.T) {
+		err := testClient("serve", 10*time.Second).Do(context.Background(), Request{Op: "nope"}, new(string))
+		if err == nil || !strings.Contains(err.Error(), "unknown operation") {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 4}
+	b.Write([]byte("abc"))
+	b.Write([]byte("def"))
+	if b.String() != "abcd" || !b.overflow {
+		t.Errorf("buffer = %q, overflow = %v", b.String(), b.overflow)
+	}
+}
@@ -0,0 +1,244 @@
+// Package validate checks request structs against constraints declared in
+// `validate` struct tags, so every endpoint reports invalid fields in the
+// same shape:
+//
+//	type SignupRequest struct {
+//		Username string `json:"username" validate:"required,max=30"`
+//		Email    string `json:"email" validate:"max=255,email"`
+//	}
+//
+// Rules are separated by commas and checked in order; a field reports only
+// its first failure. Apart from required, rules are skipped for zero values,
+// so optional fields can carry limits.
+//
+//   - required: strings must not be blank, numbers not zero, slices and maps
+//     not empty, pointers not nil
+//   - min=N, max=N: characters for strings, items for slices and maps, the
+//     value for numbers
+//   - email: a single address such as ana@example.com
+//   - oneof=a b c: one of the listed values
+//
+// A `message` tag replaces the generated message for every rule of the
+// field, for fields whose wording is established (and translated) already.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"allanswebterminal/apierror"
+)
+
+// FieldError is one invalid field, named as in JSON.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors lists every invalid field of a request, in declaration order.
+type Errors []FieldError
+
+// Error is the message of the first invalid field, which is what handlers
+// that report a single message show.
+func (e Errors) Error() string {
+	if len(e) == 0 {
+		return "validation failed"
+	}
+	return e[0].Message
+}
+
+// Details are the details of a validation_failed error built by APIError.
+type Details struct {
+	Fields Errors `json:"fields"`
+}
+
+type rule struct {
+	name, arg string
+}
+
+type field struct {
+	index   int
+	name    string
+	message string
+	rules   []rule
+}
+
+// fieldCache holds the parsed tags of each struct type.
+var fieldCache sync.Map // reflect.Type -> []field
+
+// Struct checks the tagged fields of v, a struct or a pointer to one. It
+// returns Errors, or nil when every field is valid. Malformed tags panic,
+// since they are programming errors found by the first test that runs the
+// request through.
+func Struct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: %T is not a struct", v))
+	}
+
+	var errs Errors
+	for _, f := range fieldsOf(rv.Type()) {
+		if fe, ok := f.check(rv.Field(f.index)); !ok {
+			errs = append(errs, fe)
+		}
+	}
+	if errs == nil {
+		return nil
+	}
+	return errs
+}
+
+// Check is Struct for handlers: it returns nil or a validation_failed error
+// ready for apierror.Write.
+func Check(v interface{}) *apierror.Error {
+	if err := Struct(v); err != nil {
+		return APIError(err)
+	}
+	return nil
+}
+
+// APIError converts err into a validation_failed error. Errors from Struct
+// keep their fields in the details; other errors become a plain message, so
+// callers can mix tag checks with checks of their own.
+func APIError(err error) *apierror.Error {
+	var errs Errors
+	if errors.As(err, &errs) {
+		return apierror.Validation(errs.Error()).WithDetails(Details{Fields: errs})
+	}
+	return apierror.Validation(err.Error())
+}
+
+func fieldsOf(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("validate")
+		if !ok || tag == "" || !sf.IsExported() {
+			continue
+		}
+		f := field{index: i, name: jsonName(sf), message: sf.Tag.Get("message")}
+		for _, part := range strings.Split(tag, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch name {
+			case "required", "email":
+			case "min", "max":
+				if _, err := strconv.ParseFloat(arg, 64); err != nil {
+					panic(fmt.Sprintf("validate: %s.%s: %s needs a number", t.Name(), sf.Name, name))
+				}
+			case "oneof":
+				if arg == "" {
+					panic(fmt.Sprintf("validate: %s.%s: oneof needs values", t.Name(), sf.Name))
+				}
+			default:
+				panic(fmt.Sprintf("validate: %s.%s: unknown rule %q", t.Name(), sf.Name, name))
+			}
+			f.rules = append(f.rules, rule{name, arg})
+		}
+		fields = append(fields, f)
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+func (f field) check(v reflect.Value) (FieldError, bool) {
+	zero := isBlank(v)
+	for _, r := range f.rules {
+		if r.name != "required" && zero {
+			continue
+		}
+		if msg, ok := r.apply(f.name, v, zero); !ok {
+			if f.message != "" {
+				msg = f.message
+			}
+			return FieldError{Field: f.name, Rule: r.name, Message: msg}, false
+		}
+	}
+	return FieldError{}, true
+}
+
+func (r rule) apply(name string, v reflect.Value, zero bool) (string, bool) {
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	switch r.name {
+	case "required":
+		return name + " is required", !zero
+	case "email":
+		addr, err := mail.ParseAddress(v.String())
+		return name + " must be a valid email address", err == nil && addr.Address == v.String()
+	case "oneof":
+		values := strings.Fields(r.arg)
+		s := fmt.Sprint(v.Interface())
+		for _, allowed := range values {
+			if s == allowed {
+				return "", true
+			}
+		}
+		return fmt.Sprintf("%s must be one of %s", name, strings.Join(values, ", ")), false
+	}
+
+	limit, _ := strconv.ParseFloat(r.arg, 64)
+	size, unit := measure(v)
+	bound := "at least"
+	ok := size >= limit
+	if r.name == "max" {
+		bound, ok = "at most", size <= limit
+	}
+	return fmt.Sprintf("%s must be %s %s%s", name, bound, r.arg, unit), ok
+}
+
+// measure returns what min and max compare for v, and the unit to name in
+// messages.
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters long"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	}
+	panic(fmt.Sprintf("validate: min and max do not apply to %s", v.Kind()))
+}
+
+// isBlank reports whether v is unset for required: blank strings count, as
+// the handlers have always trimmed before checking.
+func isBlank(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
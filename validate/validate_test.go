@@ -0,0 +1,114 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type sample struct {
+	Name     string            `json:"name" validate:"required,min=2,max=5"`
+	Email    string            `json:"email,omitempty" validate:"email"`
+	Kind     string            `json:"kind" validate:"oneof=deck course"`
+	Count    int               `json:"count" validate:"min=1,max=10"`
+	Tags     map[string]string `json:"tags" validate:"max=1"`
+	Nickname *string           `json:"nickname" validate:"required"`
+	Greeting string            `json:"greeting" validate:"required" message:"please say hello"`
+	Internal string            `validate:"max=1"`
+	Ignored  string
+}
+
+func validSample() sample {
+	nick := "ana"
+	return sample{Name: "Ana", Nickname: &nick, Greeting: "hi"}
+}
+
+func TestStructValid(t *testing.T) {
+	s := validSample()
+	if err := Struct(&s); err != nil {
+		t.Errorf("Struct() = %v", err)
+	}
+}
+
+func TestStructFieldErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(*sample)
+		field string
+		rule  string
+		msg   string
+	}{
+		{"Blank", func(s *sample) { s.Name = "  " }, "name", "required", "name is required"},
+		{"Too short", func(s *sample) { s.Name = "A" }, "name", "min", "name must be at least 2 characters long"},
+		{"Counts characters", func(s *sample) { s.Name = "ñandú" }, "", "", ""},
+		{"Too long", func(s *sample) { s.Name = "Ana Maria" }, "name", "max", "name must be at most 5 characters long"},
+		{"Email", func(s *sample) { s.Email = "ana at example" }, "email", "email", "email must be a valid email address"},
+		{"Email with name", func(s *sample) { s.Email = "Ana <ana@example.com>" }, "email", "email", "email must be a valid email address"},
+		{"Oneof", func(s *sample) { s.Kind = "lab" }, "kind", "oneof", "kind must be one of deck, course"},
+		{"Number", func(s *sample) { s.Count = 11 }, "count", "max", "count must be at most 10"},
+		{"Map", func(s *sample) { s.Tags = map[string]string{"a": "1", "b": "2"} }, "tags", "max", "tags must be at most 1 items"},
+		{"Nil pointer", func(s *sample) { s.Nickname = nil }, "nickname", "required", "nickname is required"},
+		{"Message tag", func(s *sample) { s.Greeting = "" }, "greeting", "required", "please say hello"},
+		{"Go name without JSON tag", func(s *sample) { s.Internal = "xy" }, "Internal", "max", "Internal must be at most 1 characters long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := validSample()
+			tt.edit(&s)
+			err := Struct(s)
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("Struct() = %v, want nil", err)
+				}
+				return
+			}
+			var errs Errors
+			if !errors.As(err, &errs) || len(errs) != 1 {
+				t.Fatalf("Struct() = %#v, want one field error", err)
+			}
+			if got := errs[0]; got.Field != tt.field || got.Rule != tt.rule || got.Message != tt.msg {
+				t.Errorf("field error = %+v", got)
+			}
+		})
+	}
+}
+
+func TestStructReportsEveryField(t *testing.T) {
+	err := Struct(sample{})
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("Struct() = %#v", err)
+	}
+	if err.Error() != "name is required" {
+		t.Errorf("Error() = %q, want the first field's message", err.Error())
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	apiErr := Check(sample{Name: "A"})
+	if apiErr == nil || apiErr.Status() != http.StatusBadRequest || apiErr.Message != "name must be at least 2 characters long" {
+		t.Fatalf("Check() = %+v", apiErr)
+	}
+	body, _ := json.Marshal(apiErr)
+	if !strings.Contains(string(body), `"fields":[{"field":"name","rule":"min"`) {
+		t.Errorf("JSON = %s", body)
+	}
+
+	plain := APIError(errors.New("username is reserved"))
+	if plain.Message != "username is reserved" || plain.Details != nil {
+		t.Errorf("APIError(plain) = %+v", plain)
+	}
+}
+
+func TestMalformedTagPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("unknown rule did not panic")
+		}
+	}()
+	Struct(struct {
+		Name string `validate:"requird"`
+	}{})
+}
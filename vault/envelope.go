@@ -0,0 +1,132 @@
+package vault
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrUnknownKey is returned for an envelope whose data key was wrapped
+// under a key the keyring does not hold.
+var ErrUnknownKey = errors.New("the value was encrypted under an unknown key")
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// A Keyring holds named key-encryption keys for envelope encryption:
+// every value is sealed under its own random data key, and only the data
+// key is sealed ("wrapped") under a keyring key. New values use the
+// primary key; the others still open what they wrapped, so keys can be
+// rotated by rewrapping data keys without touching the values.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// Envelope is a value sealed by a Keyring.
+type Envelope struct {
+	// KeyID names the keyring key that wrapped DataKey.
+	KeyID      string
+	DataKey    []byte
+	Ciphertext []byte
+}
+
+// ParseKeyring reads a keyring written as comma-separated "id:key" pairs,
+// the key in base64 or hex as for ParseKey. The first key is the primary.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for _, entry := range strings.Split(s, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("vault: keyring entries must be id:key with an id of letters, digits, - and _, got %q", id)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("vault: key %s is listed twice", id)
+		}
+		key, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		if k.keys[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key that wraps new data keys.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// Retired returns the IDs of the keys other than the primary.
+func (k *Keyring) Retired() []string {
+	ids := []string{}
+	for id := range k.keys {
+		if id != k.primary {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Seal encrypts plaintext bound to context under a new data key.
+func (k *Keyring) Seal(plaintext, context []byte) (Envelope, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return Envelope{}, err
+	}
+	a, err := newAEAD(dataKey)
+	if err != nil {
+		return Envelope{}, err
+	}
+	ciphertext, err := seal(a, plaintext, context)
+	if err != nil {
+		return Envelope{}, err
+	}
+	wrapped, err := seal(k.keys[k.primary], dataKey, context)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{KeyID: k.primary, DataKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// Open decrypts an envelope from Seal given the same context.
+func (k *Keyring) Open(e Envelope, context []byte) ([]byte, error) {
+	dataKey, err := k.unwrap(e, context)
+	if err != nil {
+		return nil, err
+	}
+	a, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return open(a, e.Ciphertext, context)
+}
+
+// Rewrap returns e with its data key wrapped under the primary key. The
+// ciphertext is unchanged.
+func (k *Keyring) Rewrap(e Envelope, context []byte) (Envelope, error) {
+	dataKey, err := k.unwrap(e, context)
+	if err != nil {
+		return Envelope{}, err
+	}
+	wrapped, err := seal(k.keys[k.primary], dataKey, context)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{KeyID: k.primary, DataKey: wrapped, Ciphertext: e.Ciphertext}, nil
+}
+
+func (k *Keyring) unwrap(e Envelope, context []byte) ([]byte, error) {
+	kek, ok := k.keys[e.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, e.KeyID)
+	}
+	return open(kek, e.DataKey, context)
+}
@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKeyringSealOpen(t *testing.T) {
+	k, err := ParseKeyring("k1:" + NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := k.Seal([]byte("print('hi')"), []byte("file:7:main.py"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.KeyID != "k1" || bytes.Contains(e.Ciphertext, []byte("print")) {
+		t.Errorf("Seal() = %+v", e)
+	}
+	plain, err := k.Open(e, []byte("file:7:main.py"))
+	if err != nil || string(plain) != "print('hi')" {
+		t.Errorf("Open() = %q, %v", plain, err)
+	}
+	if _, err := k.Open(e, []byte("file:8:main.py")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open with another context error = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, newKey := NewKey(), NewKey()
+	before, _ := ParseKeyring("old:" + oldKey)
+	e, _ := before.Seal([]byte("data"), nil)
+
+	after, err := ParseKeyring("new:" + newKey + ", old:" + oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Primary() != "new" || len(after.Retired()) != 1 || after.Retired()[0] != "old" {
+		t.Fatalf("Primary() = %s, Retired() = %v", after.Primary(), after.Retired())
+	}
+	if plain, err := after.Open(e, nil); err != nil || string(plain) != "data" {
+		t.Errorf("Open under a retired key = %q, %v", plain, err)
+	}
+
+	rewrapped, err := after.Rewrap(e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped.KeyID != "new" || !bytes.Equal(rewrapped.Ciphertext, e.Ciphertext) {
+		t.Errorf("Rewrap() = %+v", rewrapped)
+	}
+	final, _ := ParseKeyring("new:" + newKey)
+	if plain, err := final.Open(rewrapped, nil); err != nil || string(plain) != "data" {
+		t.Errorf("Open after rotation = %q, %v", plain, err)
+	}
+	if _, err := final.Open(e, nil); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open under a removed key error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := NewKey()
+	for _, bad := range []string{"", key, "k1:short", "bad id:" + key, "k1:" + key + ",k1:" + key} {
+		if _, err := ParseKeyring(bad); err == nil {
+			t.Errorf("ParseKeyring(%q) succeeded", bad)
+		}
+	}
+}
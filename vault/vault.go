@@ -0,0 +1,138 @@
+// Package vault encrypts small values at rest with AES-256-GCM under a
+// master key from configuration. Sealed values carry a format version and
+// a random nonce, and are bound to a context, such as the owner and name
+// of a secret, so a value copied to another row does not open. A Keyring
+// seals larger values, such as files, in envelopes under rotatable keys.
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// KeySize is the length of a master key: AES-256.
+const KeySize = 32
+
+// version1 is AES-256-GCM with a 12-byte nonce.
+const version1 = 1
+
+var (
+	// ErrNotConfigured is returned when no master key is set.
+	ErrNotConfigured = errors.New("no encryption key is configured")
+	// ErrDecrypt is returned for values that were sealed under another key
+	// or context, or were altered.
+	ErrDecrypt = errors.New("value could not be decrypted")
+)
+
+var (
+	mu   sync.RWMutex
+	aead cipher.AEAD
+)
+
+// ParseKey decodes a master key written as base64 or hex.
+func ParseKey(s string) ([]byte, error) {
+	for _, decode := range []func(string) ([]byte, error){
+		base64.StdEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+		hex.DecodeString,
+	} {
+		if key, err := decode(s); err == nil && len(key) == KeySize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("vault: the key must be %d bytes, base64 or hex encoded", KeySize)
+}
+
+// NewKey returns a random master key, base64 encoded.
+func NewKey() string {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// SetKey replaces the master key. nil disables encryption.
+func SetKey(key []byte) error {
+	var a cipher.AEAD
+	if key != nil {
+		var err error
+		if a, err = newAEAD(key); err != nil {
+			return err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	aead = a
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("vault: the key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Configured reports whether a master key is set.
+func Configured() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return aead != nil
+}
+
+func current() (cipher.AEAD, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if aead == nil {
+		return nil, ErrNotConfigured
+	}
+	return aead, nil
+}
+
+// Seal encrypts plaintext bound to context.
+func Seal(plaintext, context []byte) ([]byte, error) {
+	a, err := current()
+	if err != nil {
+		return nil, err
+	}
+	return seal(a, plaintext, context)
+}
+
+// Open decrypts a value from Seal given the same context.
+func Open(sealed, context []byte) ([]byte, error) {
+	a, err := current()
+	if err != nil {
+		return nil, err
+	}
+	return open(a, sealed, context)
+}
+
+func seal(a cipher.AEAD, plaintext, context []byte) ([]byte, error) {
+	out := make([]byte, 1+a.NonceSize(), 1+a.NonceSize()+len(plaintext)+a.Overhead())
+	out[0] = version1
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, err
+	}
+	return a.Seal(out, out[1:], plaintext, context), nil
+}
+
+func open(a cipher.AEAD, sealed, context []byte) ([]byte, error) {
+	if len(sealed) < 1+a.NonceSize()+a.Overhead() || sealed[0] != version1 {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := sealed[1:1+a.NonceSize()], sealed[1+a.NonceSize():]
+	plaintext, err := a.Open(nil, nonce, ciphertext, context)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
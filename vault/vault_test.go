@@ -0,0 +1,77 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func useKey(t *testing.T) {
+	t.Helper()
+	key, err := ParseKey(NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetKey(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetKey(nil) })
+}
+
+func TestSealOpen(t *testing.T) {
+	useKey(t)
+	sealed, err := Seal([]byte("s3cret"), []byte("secret:7:API_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("s3cret")) {
+		t.Error("the sealed value contains the plaintext")
+	}
+	again, _ := Seal([]byte("s3cret"), []byte("secret:7:API_KEY"))
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing twice gave the same value")
+	}
+
+	plain, err := Open(sealed, []byte("secret:7:API_KEY"))
+	if err != nil || string(plain) != "s3cret" {
+		t.Errorf("Open() = %q, %v", plain, err)
+	}
+	if _, err := Open(sealed, []byte("secret:8:API_KEY")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open with another context error = %v, want ErrDecrypt", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := Open(sealed, []byte("secret:7:API_KEY")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open of an altered value error = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestOpenWithOtherKey(t *testing.T) {
+	useKey(t)
+	sealed, _ := Seal([]byte("x"), nil)
+	useKey(t)
+	if _, err := Open(sealed, nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Open under another key error = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestNotConfigured(t *testing.T) {
+	SetKey(nil)
+	if Configured() {
+		t.Error("Configured() without a key")
+	}
+	if _, err := Seal([]byte("x"), nil); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Seal() error = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	hexKey := "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	if key, err := ParseKey(hexKey); err != nil || key[31] != 0x1f {
+		t.Errorf("ParseKey(hex) = %x, %v", key, err)
+	}
+	for _, bad := range []string{"", "short", "AAAA", hexKey[:62]} {
+		if _, err := ParseKey(bad); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", bad)
+		}
+	}
+}
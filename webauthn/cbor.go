@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// errCBOR is returned for CBOR this package cannot read.
+var errCBOR = errors.New("webauthn: malformed CBOR")
+
+// maxCBORDepth bounds nesting, so a hostile attestation cannot recurse
+// without limit.
+const maxCBORDepth = 16
+
+// decodeCBOR reads the first CBOR item in data and returns it with the
+// bytes after it. It reads the subset authenticators produce: integers
+// (as int64), byte and text strings of definite length, arrays, maps
+// (with int64 or string keys), booleans and null. Floats, tags and
+// indefinite lengths are refused.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, nil, errCBOR
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22:
+			return nil, data, nil
+		}
+		return nil, nil, errCBOR
+	}
+
+	n, data, err := readArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return int64(n), data, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(n), data, nil
+	case 2, 3:
+		if n > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		b := data[:n]
+		if major == 3 {
+			return string(b), data[n:], nil
+		}
+		return append([]byte(nil), b...), data[n:], nil
+	case 4:
+		if n > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item interface{}
+			if item, data, err = decodeItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		if n > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var key, value interface{}
+			if key, data, err = decodeItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			if value, data, err = decodeItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, data, nil
+	}
+	return nil, nil, errCBOR
+}
+
+// readArgument reads the length or value that follows an initial byte.
+func readArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	return 0, nil, errCBOR
+}
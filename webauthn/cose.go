@@ -0,0 +1,95 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the signatures this package verifies.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms are the algorithms offered to authenticators, most preferred
+// first.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// ErrUnsupportedKey is returned for public keys of a type or algorithm
+// not in Algorithms.
+var ErrUnsupportedKey = errors.New("webauthn: unsupported public key")
+
+// publicKey is a credential public key read from its COSE encoding.
+type publicKey struct {
+	alg int
+	key crypto.PublicKey
+}
+
+// parsePublicKey reads a COSE_Key (RFC 9053) as authenticators encode
+// credential public keys.
+func parsePublicKey(cose []byte) (*publicKey, error) {
+	v, rest, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, errCBOR
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("webauthn: public key is not on P-256")
+		}
+		return &publicKey{alg: AlgES256, key: key}, nil
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return &publicKey{alg: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+		exponent := new(big.Int).SetBytes(e)
+		return &publicKey{alg: AlgRS256, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// verify checks sig over message.
+func (k *publicKey) verify(message, sig []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,418 @@
+// Package webauthn implements the server side of Web Authentication
+// (https://www.w3.org/TR/webauthn-2/) for signing in with passkeys: it
+// issues challenges, builds the options a page passes to
+// navigator.credentials.create and .get, and verifies what the
+// authenticator returns.
+//
+// Passkeys replace passwords, so every ceremony requires user
+// verification (a PIN or biometric on the authenticator) and registration
+// asks for a discoverable credential, which lets sign-in start without a
+// username. Attestation is not requested: "none" and self or unchained
+// "packed" statements are checked, and no authenticator models are
+// trusted or refused.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Timeout is how long a ceremony may take, from issuing its challenge.
+const Timeout = 5 * time.Minute
+
+var (
+	// ErrInvalid is returned, wrapped with the reason, for responses that
+	// fail verification.
+	ErrInvalid = errors.New("webauthn: verification failed")
+	// ErrCloned is returned when a credential's signature counter goes
+	// backwards, which means its private key was copied.
+	ErrCloned = errors.New("webauthn: signature counter went backwards")
+)
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalid}, args...)...)
+}
+
+// Bytes is binary data, in JSON as unpadded base64url as the WebAuthn
+// JSON serialization uses. Padded and standard base64 are read too.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	s = strings.TrimRight(s, "=")
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		if decoded, err = base64.RawStdEncoding.DecodeString(s); err != nil {
+			return fmt.Errorf("webauthn: %q is not base64url", s)
+		}
+	}
+	*b = decoded
+	return nil
+}
+
+// RelyingParty is the site credentials are registered with.
+type RelyingParty struct {
+	// ID is the domain credentials are scoped to: the site's host or a
+	// registrable suffix of it.
+	ID string
+	// Name is shown by authenticators when registering.
+	Name string
+	// Origins are the origins pages may run ceremonies from, such as
+	// https://example.com.
+	Origins []string
+}
+
+// NewChallenge returns a random challenge for one ceremony.
+func NewChallenge() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}
+
+// User is the account a credential is registered for. ID is the user
+// handle, returned by the authenticator when signing in.
+type User struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// Descriptor names a credential, to exclude it from registration or allow
+// it for sign-in.
+type Descriptor struct {
+	Type       string   `json:"type"`
+	ID         Bytes    `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// NewDescriptor describes the credential id.
+func NewDescriptor(id []byte, transports []string) Descriptor {
+	return Descriptor{Type: "public-key", ID: id, Transports: transports}
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type credParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions are the publicKey options for
+// navigator.credentials.create.
+type CreationOptions struct {
+	Challenge              Bytes                  `json:"challenge"`
+	RP                     rpEntity               `json:"rp"`
+	User                   User                   `json:"user"`
+	PubKeyCredParams       []credParam            `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []Descriptor           `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the publicKey options for navigator.credentials.get.
+type RequestOptions struct {
+	Challenge        Bytes        `json:"challenge"`
+	RPID             string       `json:"rpId"`
+	Timeout          int64        `json:"timeout"`
+	AllowCredentials []Descriptor `json:"allowCredentials"`
+	UserVerification string       `json:"userVerification"`
+}
+
+// CreationOptions returns the options to register a passkey for user,
+// other than the credentials in exclude, which it already has.
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, exclude []Descriptor) CreationOptions {
+	params := make([]credParam, len(Algorithms))
+	for i, alg := range Algorithms {
+		params[i] = credParam{Type: "public-key", Alg: alg}
+	}
+	if exclude == nil {
+		exclude = []Descriptor{}
+	}
+	return CreationOptions{
+		Challenge:          challenge,
+		RP:                 rpEntity{ID: rp.ID, Name: rp.Name},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options to sign in with one of allow or, if
+// allow is empty, any passkey the authenticator holds for the site.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []Descriptor) RequestOptions {
+	if allow == nil {
+		allow = []Descriptor{}
+	}
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          Timeout.Milliseconds(),
+		AllowCredentials: allow,
+		UserVerification: "required",
+	}
+}
+
+// AttestationResponse is the response of a credential from
+// navigator.credentials.create.
+type AttestationResponse struct {
+	ClientDataJSON    Bytes    `json:"clientDataJSON"`
+	AttestationObject Bytes    `json:"attestationObject"`
+	Transports        []string `json:"transports,omitempty"`
+}
+
+// AssertionResponse is the response of a credential from
+// navigator.credentials.get.
+type AssertionResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+	UserHandle        Bytes `json:"userHandle,omitempty"`
+}
+
+// Credential is a registered passkey: what is stored to verify sign-ins.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE_Key the authenticator sent.
+	PublicKey []byte
+	// SignCount is the authenticator's signature counter, or 0 for
+	// authenticators without one.
+	SignCount uint32
+}
+
+// VerifyRegistration checks resp, the answer to CreationOptions with
+// challenge, and returns the credential it registers.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp AttestationResponse) (*Credential, error) {
+	if err := rp.verifyClientData(resp.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, rest, err := decodeCBOR(resp.AttestationObject)
+	attestation, ok := v.(map[interface{}]interface{})
+	if err != nil || !ok || len(rest) != 0 {
+		return nil, invalid("malformed attestation object")
+	}
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+
+	data, err := rp.parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if data.credentialID == nil {
+		return nil, invalid("no credential in authenticator data")
+	}
+	key, err := parsePublicKey(data.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.ClientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err := verifyAttestation(format, statement, key, signed); err != nil {
+		return nil, err
+	}
+	return &Credential{ID: data.credentialID, PublicKey: data.publicKey, SignCount: data.signCount}, nil
+}
+
+// VerifyAssertion checks resp, the answer to RequestOptions with
+// challenge, against the registered cred and returns the credential's new
+// signature counter, to store in its place.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, cred Credential, resp AssertionResponse) (uint32, error) {
+	if err := rp.verifyClientData(resp.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	data, err := rp.parseAuthData(resp.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, resp.Signature) {
+		return 0, invalid("bad signature")
+	}
+	if (data.signCount != 0 || cred.SignCount != 0) && data.signCount <= cred.SignCount {
+		return 0, ErrCloned
+	}
+	return data.signCount, nil
+}
+
+// Challenge returns the challenge a response's client data answers, to
+// look up the ceremony it belongs to before verifying it.
+func Challenge(clientDataJSON []byte) ([]byte, error) {
+	var c clientData
+	if err := json.Unmarshal(clientDataJSON, &c); err != nil {
+		return nil, invalid("malformed client data")
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(c.Challenge)
+	if err != nil || len(challenge) == 0 {
+		return nil, invalid("malformed challenge")
+	}
+	return challenge, nil
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var c clientData
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return invalid("malformed client data")
+	}
+	if c.Type != ceremony {
+		return invalid("client data is for %q, want %q", c.Type, ceremony)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(c.Challenge)
+	if err != nil || len(challenge) == 0 || !bytes.Equal(got, challenge) {
+		return invalid("challenge does not match")
+	}
+	if c.CrossOrigin {
+		return invalid("cross-origin ceremony")
+	}
+	for _, origin := range rp.Origins {
+		if c.Origin == origin {
+			return nil
+		}
+	}
+	return invalid("origin %q is not allowed", c.Origin)
+}
+
+// Authenticator data flags.
+const (
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagAttestedCredential = 0x40
+)
+
+type authData struct {
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthData reads authenticator data, checking it is for this relying
+// party and that the user was present and verified.
+func (rp *RelyingParty) parseAuthData(raw []byte) (*authData, error) {
+	if len(raw) < 37 {
+		return nil, invalid("authenticator data too short")
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(raw[:32], rpIDHash[:]) {
+		return nil, invalid("credential is for another relying party")
+	}
+	flags := raw[32]
+	if flags&flagUserPresent == 0 {
+		return nil, invalid("user not present")
+	}
+	if flags&flagUserVerified == 0 {
+		return nil, invalid("user not verified")
+	}
+	data := &authData{signCount: binary.BigEndian.Uint32(raw[33:37])}
+	if flags&flagAttestedCredential == 0 {
+		return data, nil
+	}
+
+	// AAGUID (16 bytes), credential ID length (2) and credential ID, then
+	// the public key, which may be followed by extensions.
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, invalid("attested credential data too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, invalid("bad credential ID")
+	}
+	data.credentialID = append([]byte(nil), rest[:idLength]...)
+	rest = rest[idLength:]
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, invalid("malformed credential public key")
+	}
+	data.publicKey = append([]byte(nil), rest[:len(rest)-len(after)]...)
+	return data, nil
+}
+
+// verifyAttestation checks an attestation statement's signature over
+// signed. Unchained packed statements are checked against their
+// certificate's key without judging the certificate.
+func verifyAttestation(format string, statement map[interface{}]interface{}, key *publicKey, signed []byte) error {
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return invalid("none attestation with a statement")
+		}
+		return nil
+	case "packed":
+		alg, _ := statement["alg"].(int64)
+		sig, _ := statement["sig"].([]byte)
+		chain, hasChain := statement["x5c"].([]interface{})
+		if !hasChain {
+			if int(alg) != key.alg || !key.verify(signed, sig) {
+				return invalid("bad self attestation")
+			}
+			return nil
+		}
+		if len(chain) == 0 {
+			return invalid("empty attestation certificate chain")
+		}
+		der, _ := chain[0].([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return invalid("malformed attestation certificate")
+		}
+		algorithms := map[int64]x509.SignatureAlgorithm{
+			AlgES256: x509.ECDSAWithSHA256,
+			AlgEdDSA: x509.PureEd25519,
+			AlgRS256: x509.SHA256WithRSA,
+		}
+		sigAlg, ok := algorithms[alg]
+		if !ok || cert.CheckSignature(sigAlg, signed, sig) != nil {
+			return invalid("bad packed attestation")
+		}
+		return nil
+	}
+	return invalid("unsupported attestation format %q", format)
+}
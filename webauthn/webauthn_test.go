@@ -0,0 +1,293 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+)
+
+// encodeCBOR writes the values decodeCBOR reads, for building
+// authenticator responses.
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		}
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []interface{}:
+		out := head(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case map[interface{}]interface{}:
+		keys := make([]interface{}, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return string(encodeCBOR(keys[i])) < string(encodeCBOR(keys[j])) })
+		out := head(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(out, encodeCBOR(k)...)
+			out = append(out, encodeCBOR(v[k])...)
+		}
+		return out
+	}
+	panic("unsupported CBOR value")
+}
+
+var testRP = &RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+
+// authenticator is a software passkey with a P-256 key.
+type authenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+	flags     byte
+	origin    string
+	rpID      string
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{
+		key:    key,
+		id:     []byte("credential-1"),
+		flags:  flagUserPresent | flagUserVerified,
+		origin: "https://example.com",
+		rpID:   "example.com",
+	}
+}
+
+func (a *authenticator) coseKey() []byte {
+	return encodeCBOR(map[interface{}]interface{}{
+		1: 2, 3: AlgES256, -1: 1,
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+}
+
+func (a *authenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedCredential
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *authenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	return data
+}
+
+func (a *authenticator) sign(authData, clientData []byte) []byte {
+	hash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), hash[:]...))
+	sig, _ := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	return sig
+}
+
+func (a *authenticator) create(challenge []byte, format string) AttestationResponse {
+	clientData := a.clientData("webauthn.create", challenge)
+	authData := a.authData(true)
+	statement := map[interface{}]interface{}{}
+	if format == "packed" {
+		statement["alg"] = AlgES256
+		statement["sig"] = a.sign(authData, clientData)
+	}
+	return AttestationResponse{
+		ClientDataJSON: clientData,
+		AttestationObject: encodeCBOR(map[interface{}]interface{}{
+			"fmt": format, "attStmt": statement, "authData": authData,
+		}),
+	}
+}
+
+func (a *authenticator) get(challenge []byte) AssertionResponse {
+	a.signCount++
+	clientData := a.clientData("webauthn.get", challenge)
+	authData := a.authData(false)
+	return AssertionResponse{ClientDataJSON: clientData, AuthenticatorData: authData, Signature: a.sign(authData, clientData)}
+}
+
+func TestRegisterAndSignIn(t *testing.T) {
+	for _, format := range []string{"none", "packed"} {
+		a := newAuthenticator(t)
+		challenge := NewChallenge()
+		cred, err := testRP.VerifyRegistration(challenge, a.create(challenge, format))
+		if err != nil {
+			t.Fatalf("%s: VerifyRegistration = %v", format, err)
+		}
+		if string(cred.ID) != "credential-1" {
+			t.Errorf("credential ID = %q", cred.ID)
+		}
+
+		challenge = NewChallenge()
+		count, err := testRP.VerifyAssertion(challenge, *cred, a.get(challenge))
+		if err != nil || count != 1 {
+			t.Fatalf("%s: VerifyAssertion = %d, %v", format, count, err)
+		}
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	challenge := NewChallenge()
+	tests := []struct {
+		name   string
+		modify func(*authenticator)
+		resp   func(*authenticator) AttestationResponse
+	}{
+		{"other challenge", nil, func(a *authenticator) AttestationResponse { return a.create(NewChallenge(), "none") }},
+		{"other origin", func(a *authenticator) { a.origin = "https://evil.example" }, nil},
+		{"other relying party", func(a *authenticator) { a.rpID = "evil.example" }, nil},
+		{"user not verified", func(a *authenticator) { a.flags = flagUserPresent }, nil},
+		{"bad self attestation", nil, func(a *authenticator) AttestationResponse {
+			clientData := a.clientData("webauthn.create", challenge)
+			authData := a.authData(true)
+			other := newAuthenticator(t)
+			return AttestationResponse{ClientDataJSON: clientData, AttestationObject: encodeCBOR(map[interface{}]interface{}{
+				"fmt": "packed", "authData": authData,
+				"attStmt": map[interface{}]interface{}{"alg": AlgES256, "sig": other.sign(authData, clientData)},
+			})}
+		}},
+		{"unknown format", nil, func(a *authenticator) AttestationResponse { return a.create(challenge, "tpm") }},
+		{"sign-in response", nil, func(a *authenticator) AttestationResponse {
+			resp := a.get(challenge)
+			return AttestationResponse{ClientDataJSON: resp.ClientDataJSON, AttestationObject: encodeCBOR(map[interface{}]interface{}{
+				"fmt": "none", "attStmt": map[interface{}]interface{}{}, "authData": []byte(resp.AuthenticatorData),
+			})}
+		}},
+	}
+	for _, tt := range tests {
+		a := newAuthenticator(t)
+		if tt.modify != nil {
+			tt.modify(a)
+		}
+		resp := a.create(challenge, "none")
+		if tt.resp != nil {
+			resp = tt.resp(a)
+		}
+		if _, err := testRP.VerifyRegistration(challenge, resp); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: VerifyRegistration = %v, want ErrInvalid", tt.name, err)
+		}
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	a := newAuthenticator(t)
+	challenge := NewChallenge()
+	cred, err := testRP.VerifyRegistration(challenge, a.create(challenge, "none"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := a.get(challenge)
+	if _, err := testRP.VerifyAssertion(NewChallenge(), *cred, resp); !errors.Is(err, ErrInvalid) {
+		t.Errorf("other challenge: %v", err)
+	}
+	tampered := resp
+	tampered.AuthenticatorData = append(Bytes(nil), resp.AuthenticatorData...)
+	tampered.AuthenticatorData[36]++
+	if _, err := testRP.VerifyAssertion(challenge, *cred, tampered); !errors.Is(err, ErrInvalid) {
+		t.Errorf("tampered authenticator data: %v", err)
+	}
+	created := a.create(challenge, "none")
+	if _, err := testRP.VerifyAssertion(challenge, *cred, AssertionResponse{
+		ClientDataJSON: created.ClientDataJSON, AuthenticatorData: resp.AuthenticatorData, Signature: resp.Signature,
+	}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("registration client data: %v", err)
+	}
+
+	cred.SignCount = 5
+	if _, err := testRP.VerifyAssertion(challenge, *cred, resp); !errors.Is(err, ErrCloned) {
+		t.Errorf("counter went backwards: %v", err)
+	}
+}
+
+func TestEd25519Key(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	key, err := parsePublicKey(encodeCBOR(map[interface{}]interface{}{1: 1, 3: AlgEdDSA, -1: 6, -2: []byte(pub)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.verify([]byte("message"), ed25519.Sign(priv, []byte("message"))) {
+		t.Errorf("signature not verified")
+	}
+	if _, err := parsePublicKey(encodeCBOR(map[interface{}]interface{}{1: 1, 3: -999})); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("unknown algorithm: %v", err)
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	for _, malformed := range [][]byte{
+		{},
+		{0x5f},                         // indefinite byte string
+		{0x43, 0x01},                   // byte string past the end
+		{0xfb, 0, 0, 0, 0, 0, 0, 0, 0}, // float
+		{0xa1, 0x41, 0x00, 0x00},       // map with a byte string key
+		{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // huge array
+	} {
+		if _, _, err := decodeCBOR(malformed); err == nil {
+			t.Errorf("decodeCBOR(%x) accepted", malformed)
+		}
+	}
+	nested := make([]byte, maxCBORDepth+2)
+	for i := range nested {
+		nested[i] = 0x81
+	}
+	if _, _, err := decodeCBOR(nested); err == nil {
+		t.Errorf("deep nesting accepted")
+	}
+}
+
+func TestBytesJSON(t *testing.T) {
+	var b Bytes
+	for _, s := range []string{`"-_8"`, `"+/8="`, `"-_8="`} {
+		if err := json.Unmarshal([]byte(s), &b); err != nil || string(b) != "\xfb\xff" {
+			t.Errorf("Unmarshal(%s) = %x, %v", s, b, err)
+		}
+	}
+	if out, _ := json.Marshal(b); string(out) != `"-_8"` {
+		t.Errorf("Marshal = %s", out)
+	}
+}
@@ -0,0 +1,140 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client is a single WebSocket connection registered with a Hub.
+type Client struct {
+	hub      *Hub
+	conn     *websocket.Conn
+	identity Identity
+	send     chan []byte
+	// topics is guarded by hub.mu.
+	topics map[string]struct{}
+
+	closeOnce sync.Once
+}
+
+func newClient(h *Hub, conn *websocket.Conn, identity Identity) *Client {
+	return &Client{
+		hub:      h,
+		conn:     conn,
+		identity: identity,
+		send:     make(chan []byte, h.opts.SendBuffer),
+		topics:   make(map[string]struct{}),
+	}
+}
+
+// Identity returns the authenticated user behind the connection.
+func (c *Client) Identity() Identity {
+	return c.identity
+}
+
+// Subscribe adds the client to topic, subject to the hub's authorization.
+func (c *Client) Subscribe(topic string) bool {
+	return c.hub.subscribe(c, topic)
+}
+
+// Send queues msg for this client only.
+func (c *Client) Send(msgType, topic string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	c.sendJSON(Message{Type: msgType, Topic: topic, Data: payload})
+}
+
+func (c *Client) sendJSON(msg Message) {
+	frame, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	c.hub.mu.RLock()
+	_, registered := c.hub.clients[c]
+	if registered {
+		select {
+		case c.send <- frame:
+			c.hub.mu.RUnlock()
+			return
+		default:
+		}
+	}
+	c.hub.mu.RUnlock()
+
+	if registered {
+		c.closeWithReason(websocket.ClosePolicyViolation, "send buffer full")
+	}
+}
+
+func (c *Client) sendError(message string) {
+	data, _ := json.Marshal(map[string]string{"message": message})
+	c.sendJSON(Message{Type: "error", Data: data})
+}
+
+// closeWithReason sends a close frame and tears the connection down; the
+// read pump then unregisters the client.
+func (c *Client) closeWithReason(code int, reason string) {
+	c.closeOnce.Do(func() {
+		deadline := time.Now().Add(c.hub.opts.WriteWait)
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+		c.conn.Close()
+	})
+}
+
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(c.hub.opts.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.opts.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.hub.opts.PongWait))
+	})
+
+	for {
+		var msg Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				c.sendError("invalid JSON")
+				continue
+			}
+			return
+		}
+		c.hub.dispatch(c, msg)
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.hub.opts.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case frame, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.opts.WriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.opts.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
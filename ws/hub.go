@@ -0,0 +1,334 @@
+// Package ws provides a shared WebSocket hub: authenticated connections,
+// per-topic publish/subscribe, ping/pong keepalive, and dropping of clients
+// that cannot keep up.
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message is the JSON frame exchanged with clients.
+//
+// Clients send {"type":"subscribe","topic":"..."} and
+// {"type":"unsubscribe","topic":"..."}; the hub delivers
+// {"type":"event","topic":"...","data":...}. Other types are dispatched to
+// handlers registered with Handle.
+type Message struct {
+	Type  string          `json:"type"`
+	Topic string          `json:"topic,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// Identity is the authenticated user behind a connection. AccountID is 0 for
+// anonymous connections.
+type Identity struct {
+	AccountID int
+	Username  string
+}
+
+// ErrUnauthorized is returned by an Authenticate func to reject a handshake.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Options configures a Hub. Zero values get sensible defaults.
+type Options struct {
+	// Authenticate resolves the caller during the HTTP handshake. Returning
+	// an error rejects the upgrade with 401.
+	Authenticate func(r *http.Request) (Identity, error)
+	// AuthorizeTopic decides whether identity may subscribe to topic. When
+	// nil, any topic except other accounts' private topics is allowed.
+	AuthorizeTopic func(identity Identity, topic string) bool
+	// CheckOrigin validates the Origin header; defaults to same-origin.
+	CheckOrigin func(r *http.Request) bool
+	// OnConnect and OnDisconnect are called once per connection, after it
+	// is registered and after it is gone. They must not block.
+	OnConnect    func(identity Identity)
+	OnDisconnect func(identity Identity)
+
+	SendBuffer     int
+	MaxMessageSize int64
+	WriteWait      time.Duration
+	PongWait       time.Duration
+	PingInterval   time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.SendBuffer == 0 {
+		o.SendBuffer = 64
+	}
+	if o.MaxMessageSize == 0 {
+		o.MaxMessageSize = 64 << 10
+	}
+	if o.WriteWait == 0 {
+		o.WriteWait = 10 * time.Second
+	}
+	if o.PongWait == 0 {
+		o.PongWait = 60 * time.Second
+	}
+	if o.PingInterval == 0 {
+		o.PingInterval = o.PongWait * 9 / 10
+	}
+}
+
+// HandlerFunc handles an application message received from a client.
+type HandlerFunc func(c *Client, msg Message)
+
+// Hub tracks connections and topic subscriptions.
+type Hub struct {
+	opts     Options
+	upgrader websocket.Upgrader
+
+	mu       sync.RWMutex
+	clients  map[*Client]struct{}
+	topics   map[string]map[*Client]struct{}
+	handlers map[string]HandlerFunc
+	closed   bool
+}
+
+func NewHub(opts Options) *Hub {
+	opts.setDefaults()
+	return &Hub{
+		opts: opts,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+			CheckOrigin:     opts.CheckOrigin,
+		},
+		clients:  make(map[*Client]struct{}),
+		topics:   make(map[string]map[*Client]struct{}),
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+// AccountTopic is the private topic every authenticated connection joins
+// automatically; publishing to it reaches all of that account's devices.
+func AccountTopic(accountID int) string {
+	return "account:" + itoa(accountID)
+}
+
+// Handle registers fn for client messages of the given type.
+func (h *Hub) Handle(msgType string, fn HandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[msgType] = fn
+}
+
+// ServeHTTP performs the authenticated WebSocket handshake.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	identity := Identity{}
+	if h.opts.Authenticate != nil {
+		var err error
+		identity, err = h.opts.Authenticate(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error.
+		return
+	}
+
+	client := newClient(h, conn, identity)
+	if !h.register(client) {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(h.opts.WriteWait))
+		conn.Close()
+		return
+	}
+	if identity.AccountID != 0 {
+		h.subscribe(client, AccountTopic(identity.AccountID))
+	}
+	if h.opts.OnConnect != nil {
+		h.opts.OnConnect(identity)
+	}
+
+	go client.writePump()
+	client.readPump()
+}
+
+func (h *Hub) register(c *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.clients[c] = struct{}{}
+	return true
+}
+
+func (h *Hub) unregister(c *Client) {
+	h.mu.Lock()
+	if _, ok := h.clients[c]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.clients, c)
+	for topic := range c.topics {
+		h.removeSubscriber(topic, c)
+	}
+	close(c.send)
+	h.mu.Unlock()
+
+	if h.opts.OnDisconnect != nil {
+		h.opts.OnDisconnect(c.identity)
+	}
+}
+
+func (h *Hub) removeSubscriber(topic string, c *Client) {
+	if subscribers, ok := h.topics[topic]; ok {
+		delete(subscribers, c)
+		if len(subscribers) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	delete(c.topics, topic)
+}
+
+func (h *Hub) authorized(identity Identity, topic string) bool {
+	if h.opts.AuthorizeTopic != nil {
+		return h.opts.AuthorizeTopic(identity, topic)
+	}
+	if isAccountTopic(topic) {
+		return identity.AccountID != 0 && topic == AccountTopic(identity.AccountID)
+	}
+	return true
+}
+
+func (h *Hub) subscribe(c *Client, topic string) bool {
+	if topic == "" || !h.authorized(c.identity, topic) {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return false
+	}
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*Client]struct{})
+	}
+	h.topics[topic][c] = struct{}{}
+	c.topics[topic] = struct{}{}
+	return true
+}
+
+func (h *Hub) unsubscribe(c *Client, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeSubscriber(topic, c)
+}
+
+// Publish delivers data to every subscriber of topic and returns how many
+// clients it was queued for. Clients whose send buffer is full are
+// disconnected rather than allowed to stall the publisher.
+func (h *Hub) Publish(topic string, data interface{}) int {
+	return h.publish(topic, data, nil)
+}
+
+// PublishExcept is Publish skipping one client, typically the sender.
+func (h *Hub) PublishExcept(topic string, data interface{}, except *Client) int {
+	return h.publish(topic, data, except)
+}
+
+func (h *Hub) publish(topic string, data interface{}, except *Client) int {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("ws: failed to encode event for %s: %v", topic, err)
+		return 0
+	}
+	frame, _ := json.Marshal(Message{Type: "event", Topic: topic, Data: payload})
+
+	h.mu.RLock()
+	var slow []*Client
+	delivered := 0
+	for c := range h.topics[topic] {
+		if c == except {
+			continue
+		}
+		select {
+		case c.send <- frame:
+			delivered++
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range slow {
+		log.Printf("ws: dropping slow client for account %d", c.identity.AccountID)
+		c.closeWithReason(websocket.ClosePolicyViolation, "send buffer full")
+	}
+	return delivered
+}
+
+// Subscribers returns the number of clients subscribed to topic.
+func (h *Hub) Subscribers(topic string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.topics[topic])
+}
+
+// Clients returns the number of open connections.
+func (h *Hub) Clients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// Close disconnects every client with a going-away close frame and refuses
+// new connections. It is registered as an http.Server shutdown hook because
+// hijacked connections are not drained by Server.Shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	clients := make([]*Client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.Unlock()
+
+	for _, c := range clients {
+		c.closeWithReason(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+func (h *Hub) dispatch(c *Client, msg Message) {
+	switch msg.Type {
+	case "subscribe":
+		if !h.subscribe(c, msg.Topic) {
+			c.sendError("subscription to " + msg.Topic + " denied")
+		}
+	case "unsubscribe":
+		h.unsubscribe(c, msg.Topic)
+	case "ping":
+		c.sendJSON(Message{Type: "pong"})
+	default:
+		h.mu.RLock()
+		handler, ok := h.handlers[msg.Type]
+		h.mu.RUnlock()
+		if !ok {
+			c.sendError("unknown message type " + msg.Type)
+			return
+		}
+		handler(c, msg)
+	}
+}
+
+func isAccountTopic(topic string) bool {
+	return len(topic) > len("account:") && topic[:len("account:")] == "account:"
+}
+
+func itoa(n int) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
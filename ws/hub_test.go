@@ -0,0 +1,199 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func testAuth(r *http.Request) (Identity, error) {
+	cookie, err := r.Cookie("user_id")
+	if err != nil {
+		return Identity{}, ErrUnauthorized
+	}
+	if cookie.Value == "7" {
+		return Identity{AccountID: 7, Username: "alice"}, nil
+	}
+	return Identity{AccountID: 8, Username: "bob"}, nil
+}
+
+func newTestServer(t *testing.T, opts Options) (*Hub, *httptest.Server) {
+	t.Helper()
+	if opts.Authenticate == nil {
+		opts.Authenticate = testAuth
+	}
+	hub := NewHub(opts)
+	srv := httptest.NewServer(hub)
+	t.Cleanup(func() {
+		hub.Close()
+		srv.Close()
+	})
+	return hub, srv
+}
+
+func dial(t *testing.T, srv *httptest.Server, userID string) *websocket.Conn {
+	t.Helper()
+	header := http.Header{}
+	if userID != "" {
+		header.Set("Cookie", "user_id="+userID)
+	}
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return msg
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandshakeRequiresAuthentication(t *testing.T) {
+	_, srv := newTestServer(t, Options{})
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Fatal("expected handshake to fail without a session")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %v", resp)
+	}
+}
+
+func TestSubscribeAndPublish(t *testing.T) {
+	hub, srv := newTestServer(t, Options{})
+	conn := dial(t, srv, "7")
+
+	conn.WriteJSON(Message{Type: "subscribe", Topic: "course:1"})
+	waitFor(t, func() bool { return hub.Subscribers("course:1") == 1 })
+
+	if n := hub.Publish("course:1", map[string]string{"hello": "world"}); n != 1 {
+		t.Fatalf("expected delivery to 1 client, got %d", n)
+	}
+	msg := readMessage(t, conn)
+	if msg.Type != "event" || msg.Topic != "course:1" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	var data map[string]string
+	json.Unmarshal(msg.Data, &data)
+	if data["hello"] != "world" {
+		t.Errorf("unexpected data %s", msg.Data)
+	}
+
+	conn.WriteJSON(Message{Type: "unsubscribe", Topic: "course:1"})
+	waitFor(t, func() bool { return hub.Subscribers("course:1") == 0 })
+}
+
+func TestAccountTopics(t *testing.T) {
+	hub, srv := newTestServer(t, Options{})
+	alice := dial(t, srv, "7")
+	dial(t, srv, "8")
+	waitFor(t, func() bool { return hub.Clients() == 2 })
+
+	if hub.Subscribers(AccountTopic(7)) != 1 {
+		t.Fatal("expected connection to join its account topic")
+	}
+
+	alice.WriteJSON(Message{Type: "subscribe", Topic: AccountTopic(8)})
+	msg := readMessage(t, alice)
+	if msg.Type != "error" {
+		t.Fatalf("expected subscription to another account to be denied, got %+v", msg)
+	}
+	if hub.Subscribers(AccountTopic(8)) != 1 {
+		t.Error("foreign account topic should keep only its owner")
+	}
+}
+
+func TestCustomHandler(t *testing.T) {
+	hub, srv := newTestServer(t, Options{})
+	hub.Handle("echo", func(c *Client, msg Message) {
+		c.Send("echo", msg.Topic, c.Identity().Username)
+	})
+	conn := dial(t, srv, "7")
+
+	conn.WriteJSON(Message{Type: "echo", Topic: "t"})
+	msg := readMessage(t, conn)
+	if msg.Type != "echo" || string(msg.Data) != `"alice"` {
+		t.Fatalf("unexpected reply %+v", msg)
+	}
+
+	conn.WriteJSON(Message{Type: "bogus"})
+	if msg := readMessage(t, conn); msg.Type != "error" {
+		t.Fatalf("expected error for unknown type, got %+v", msg)
+	}
+}
+
+func TestSlowClientIsDropped(t *testing.T) {
+	hub, srv := newTestServer(t, Options{SendBuffer: 1})
+	dial(t, srv, "7")
+	waitFor(t, func() bool { return hub.Subscribers(AccountTopic(7)) == 1 })
+
+	// The client never reads, so the buffer fills and the hub must
+	// disconnect it instead of blocking.
+	big := strings.Repeat("x", 1<<20)
+	for i := 0; i < 50 && hub.Clients() > 0; i++ {
+		hub.Publish(AccountTopic(7), big)
+	}
+	waitFor(t, func() bool { return hub.Clients() == 0 })
+}
+
+func TestCloseDisconnectsClients(t *testing.T) {
+	hub, srv := newTestServer(t, Options{})
+	conn := dial(t, srv, "7")
+	waitFor(t, func() bool { return hub.Clients() == 1 })
+
+	hub.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected going-away close, got %v", err)
+	}
+	waitFor(t, func() bool { return hub.Clients() == 0 })
+}
+
+func TestConnectionHooks(t *testing.T) {
+	events := make(chan string, 2)
+	_, srv := newTestServer(t, Options{
+		OnConnect:    func(id Identity) { events <- "connect " + id.Username },
+		OnDisconnect: func(id Identity) { events <- "disconnect " + id.Username },
+	})
+	conn := dial(t, srv, "7")
+	conn.Close()
+
+	for _, want := range []string{"connect alice", "disconnect alice"} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %q event", want)
+		}
+	}
+}